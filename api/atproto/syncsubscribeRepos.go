@@ -96,6 +96,22 @@ type SyncSubscribeRepos_RepoOp struct {
 	Path string        `json:"path" cborgen:"path"`
 }
 
+// SyncSubscribeRepos_Sync is a "sync" in the com.atproto.sync.subscribeRepos schema.
+//
+// Updates the repo to a new state, without necessarily including that state on the firehose. Used to recover from broken commit streams, data loss incidents, or in situations where upstream host does not know recent state of the repository.
+type SyncSubscribeRepos_Sync struct {
+	// blocks: CAR file containing the commit, as a block. The CAR header must include the commit block CID as the first 'root'.
+	Blocks util.LexBytes `json:"blocks,omitempty" cborgen:"blocks,omitempty"`
+	// did: The account this repo event corresponds to. Must match that in the commit object.
+	Did string `json:"did" cborgen:"did"`
+	// rev: The rev of the commit. This value must match that in the commit object.
+	Rev string `json:"rev" cborgen:"rev"`
+	// seq: The stream sequence number of this message.
+	Seq int64 `json:"seq" cborgen:"seq"`
+	// time: Timestamp of when this message was originally broadcast.
+	Time string `json:"time" cborgen:"time"`
+}
+
 // SyncSubscribeRepos_Tombstone is a "tombstone" in the com.atproto.sync.subscribeRepos schema.
 //
 // DEPRECATED -- Use #account event instead
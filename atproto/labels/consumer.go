@@ -0,0 +1,230 @@
+package labels
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+
+	"github.com/gorilla/websocket"
+)
+
+// CursorStore persists the position of a Consumer in a labeler's stream, so it can resume where it left off after restarting
+type CursorStore interface {
+	// Returns 0 if no cursor has been stored
+	GetCursor(ctx context.Context) (int64, error)
+	SetCursor(ctx context.Context, cursor int64) error
+}
+
+// FileCursorStore is a CursorStore keeping the cursor in a local file
+type FileCursorStore struct {
+	Path string
+}
+
+func (fc *FileCursorStore) GetCursor(ctx context.Context) (int64, error) {
+	b, err := os.ReadFile(fc.Path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+func (fc *FileCursorStore) SetCursor(ctx context.Context, cursor int64) error {
+	// write to a temporary file and rename, so a crash never leaves a partial cursor
+	tmp, err := os.CreateTemp(filepath.Dir(fc.Path), ".cursor-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strconv.FormatInt(cursor, 10)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fc.Path)
+}
+
+// Consumer subscribes to a labeler's com.atproto.label.subscribeLabels stream, reconnecting (with backoff) when the connection fails, and persisting its cursor as it goes. A consumer with no stored cursor starts from the beginning of the labeler's stream.
+type Consumer struct {
+	// Base URL of the labeler service, eg "https://mod.example.com"
+	Host    string
+	Cursors CursorStore
+	// Called with each batch of labels from the stream, in order. If it returns an error, the consumer reconnects and the batch is redelivered
+	HandleLabels func(ctx context.Context, seq int64, labels []*comatproto.LabelDefs_Label) error
+	// If set, labels without a valid signature from their source are dropped
+	Verifier *Verifier
+	// How often the cursor is persisted (default 5s). It is always persisted when Run returns
+	CursorFlushInterval time.Duration
+	UserAgent           string
+	Logger              *slog.Logger
+
+	cursor      int64
+	lastFlushed int64
+	lastFlush   time.Time
+}
+
+// streamURL is the websocket URL of a labeler's label stream
+func streamURL(host string, cursor int64) (string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid labeler host: %w", err)
+	}
+	switch u.Scheme {
+	case "https", "wss":
+		u.Scheme = "wss"
+	case "http", "ws":
+		u.Scheme = "ws"
+	default:
+		return "", fmt.Errorf("invalid labeler host scheme: %q", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/xrpc/com.atproto.label.subscribeLabels"
+	u.RawQuery = fmt.Sprintf("cursor=%d", cursor)
+	return u.String(), nil
+}
+
+// Run consumes the stream until the context is cancelled
+func (c *Consumer) Run(ctx context.Context) error {
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+	if c.CursorFlushInterval == 0 {
+		c.CursorFlushInterval = 5 * time.Second
+	}
+	cursor, err := c.Cursors.GetCursor(ctx)
+	if err != nil {
+		return fmt.Errorf("loading cursor: %w", err)
+	}
+	c.cursor, c.lastFlushed = cursor, cursor
+	defer func() {
+		// the context may be cancelled by now
+		if err := c.flushCursor(context.Background()); err != nil {
+			c.Logger.Error("failed to persist label stream cursor", "host", c.Host, "err", err)
+		}
+	}()
+
+	d := websocket.Dialer{
+		HandshakeTimeout: time.Second * 5,
+	}
+	header := http.Header{}
+	if c.UserAgent != "" {
+		header.Set("User-Agent", c.UserAgent)
+	}
+
+	var backoff int
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		u, err := streamURL(c.Host, c.cursor)
+		if err != nil {
+			return err
+		}
+		con, _, err := d.DialContext(ctx, u, header)
+		if err != nil {
+			c.Logger.Warn("dialing labeler failed", "host", c.Host, "err", err, "backoff", backoff)
+			select {
+			case <-time.After(time.Second * time.Duration(min(1<<backoff, 60))):
+			case <-ctx.Done():
+				return nil
+			}
+			backoff = min(backoff+1, 6)
+			continue
+		}
+
+		c.Logger.Info("connected to labeler", "host", c.Host, "cursor", c.cursor)
+		connected := time.Now()
+
+		sched := sequential.NewScheduler("labels-"+c.Host, c.handleEvent)
+		if err := events.HandleRepoStream(ctx, con, sched); err != nil && ctx.Err() == nil {
+			c.Logger.Warn("labeler connection failed", "host", c.Host, "err", err)
+		}
+		// only reset the backoff for connections which were healthy for a while, so a labeler which drops connections right away isn't hammered
+		if time.Since(connected) > time.Minute {
+			backoff = 0
+		} else {
+			backoff = min(backoff+1, 6)
+		}
+		select {
+		case <-time.After(time.Second * time.Duration(min(1<<backoff, 60))):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (c *Consumer) handleEvent(ctx context.Context, evt *events.XRPCStreamEvent) error {
+	switch {
+	case evt.LabelLabels != nil:
+		return c.handleLabels(ctx, evt.LabelLabels)
+	case evt.RepoInfo != nil:
+		// subscribeLabels info frames have the same shape as subscribeRepos ones
+		c.Logger.Warn("info event from labeler", "host", c.Host, "name", evt.RepoInfo.Name, "message", evt.RepoInfo.Message)
+		return nil
+	case evt.Error != nil:
+		return fmt.Errorf("error from labeler: %s: %s", evt.Error.Error, evt.Error.Message)
+	}
+	return nil
+}
+
+func (c *Consumer) handleLabels(ctx context.Context, evt *comatproto.LabelSubscribeLabels_Labels) error {
+	if evt.Seq <= c.cursor {
+		// redelivered after a reconnect
+		return nil
+	}
+
+	labels := evt.Labels
+	if c.Verifier != nil {
+		labels = make([]*comatproto.LabelDefs_Label, 0, len(evt.Labels))
+		for _, l := range evt.Labels {
+			if err := c.Verifier.Verify(ctx, l); err != nil {
+				c.Logger.Warn("dropping label with invalid signature", "host", c.Host, "seq", evt.Seq, "src", l.Src, "uri", l.Uri, "val", l.Val, "err", err)
+				continue
+			}
+			labels = append(labels, l)
+		}
+	}
+
+	if len(labels) > 0 {
+		if err := c.HandleLabels(ctx, evt.Seq, labels); err != nil {
+			return fmt.Errorf("handling labels (seq %d): %w", evt.Seq, err)
+		}
+	}
+
+	c.cursor = evt.Seq
+	if time.Since(c.lastFlush) > c.CursorFlushInterval {
+		if err := c.flushCursor(ctx); err != nil {
+			c.Logger.Error("failed to persist label stream cursor", "host", c.Host, "err", err)
+		}
+	}
+	return nil
+}
+
+func (c *Consumer) flushCursor(ctx context.Context) error {
+	if c.cursor == c.lastFlushed {
+		return nil
+	}
+	if err := c.Cursors.SetCursor(ctx, c.cursor); err != nil {
+		return err
+	}
+	c.lastFlushed = c.cursor
+	c.lastFlush = time.Now()
+	return nil
+}
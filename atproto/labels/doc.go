@@ -0,0 +1,6 @@
+/*
+Package labels provides helpers for atproto labels (com.atproto.label.defs#label): signing labels with a labeler key, verifying the signatures on labels received from labelers, and consuming a labeler's com.atproto.label.subscribeLabels stream.
+
+Labels are signed over their DAG-CBOR encoding (without the sig field), with the labeler's "#atproto_label" key from its DID document. See https://atproto.com/specs/label.
+*/
+package labels
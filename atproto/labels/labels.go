@@ -0,0 +1,98 @@
+package labels
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// The label format version (the "ver" field) of labels signed by this package
+const Version int64 = 1
+
+// ID (fragment) of labeler signing keys in DID documents
+const KeyID = "atproto_label"
+
+// Returned when verifying a label which has no signature
+var ErrUnsigned = errors.New("label is not signed")
+
+// the bytes signed for a label: its DAG-CBOR encoding, without the signature
+func signingBytes(l *comatproto.LabelDefs_Label) ([]byte, error) {
+	unsigned := *l
+	unsigned.Sig = nil
+	buf := new(bytes.Buffer)
+	if err := unsigned.MarshalCBOR(buf); err != nil {
+		return nil, fmt.Errorf("encoding label: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign sets the version and signature of a label, signing it with a labeler's private key. The label's other fields must be final, as any change invalidates the signature
+func Sign(l *comatproto.LabelDefs_Label, key crypto.PrivateKey) error {
+	ver := Version
+	l.Ver = &ver
+	b, err := signingBytes(l)
+	if err != nil {
+		return err
+	}
+	sig, err := key.HashAndSign(b)
+	if err != nil {
+		return fmt.Errorf("signing label: %w", err)
+	}
+	l.Sig = sig
+	return nil
+}
+
+// Verify checks the signature of a label against a labeler's public key
+func Verify(l *comatproto.LabelDefs_Label, pub crypto.PublicKey) error {
+	if len(l.Sig) == 0 {
+		return ErrUnsigned
+	}
+	b, err := signingBytes(l)
+	if err != nil {
+		return err
+	}
+	if err := pub.HashAndVerify(b, l.Sig); err != nil {
+		return fmt.Errorf("invalid label signature: %w", err)
+	}
+	return nil
+}
+
+// Verifier checks label signatures against the labeler keys declared in the DID documents of their sources
+type Verifier struct {
+	Dir identity.Directory
+}
+
+// Verify checks the signature of a label against the current key of its source labeler. If the signature doesn't match a cached key, the labeler's identity is re-resolved once, in case the key has been rotated
+func (v *Verifier) Verify(ctx context.Context, l *comatproto.LabelDefs_Label) error {
+	did, err := syntax.ParseDID(l.Src)
+	if err != nil {
+		return fmt.Errorf("invalid label src: %w", err)
+	}
+
+	err = v.verifyWithIdentity(ctx, did, l)
+	if err == nil || errors.Is(err, ErrUnsigned) {
+		return err
+	}
+	if err := v.Dir.Purge(ctx, did.AtIdentifier()); err != nil {
+		return err
+	}
+	return v.verifyWithIdentity(ctx, did, l)
+}
+
+func (v *Verifier) verifyWithIdentity(ctx context.Context, did syntax.DID, l *comatproto.LabelDefs_Label) error {
+	ident, err := v.Dir.LookupDID(ctx, did)
+	if err != nil {
+		return fmt.Errorf("resolving labeler identity: %w", err)
+	}
+	pub, err := ident.GetPublicKey(KeyID)
+	if err != nil {
+		return fmt.Errorf("labeler signing key: %w", err)
+	}
+	return Verify(l, pub)
+}
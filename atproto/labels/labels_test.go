@@ -0,0 +1,205 @@
+package labels
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func testLabel(src string) *comatproto.LabelDefs_Label {
+	return &comatproto.LabelDefs_Label{
+		Src: src,
+		Uri: "at://did:plc:abc222/app.bsky.feed.post/3k4duaz5vfs2b",
+		Val: "spam",
+		Cts: "2024-01-01T00:00:00.000Z",
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	priv, err := crypto.GeneratePrivateKeyP256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := testLabel("did:plc:abc111")
+	assert.ErrorIs(Verify(l, pub), ErrUnsigned)
+
+	assert.NoError(Sign(l, priv))
+	assert.Equal(Version, *l.Ver)
+	assert.NoError(Verify(l, pub))
+
+	tampered := *l
+	tampered.Val = "!takedown"
+	assert.Error(Verify(&tampered, pub))
+
+	other, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, err := other.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(Verify(l, otherPub))
+}
+
+func TestVerifier(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{
+		DID:    syntax.DID("did:plc:abc111"),
+		Handle: syntax.Handle("labeler.example.com"),
+		Keys: map[string]identity.Key{
+			KeyID: {Type: "Multikey", PublicKeyMultibase: pub.Multibase()},
+		},
+	})
+	dir.Insert(identity.Identity{
+		DID:    syntax.DID("did:plc:abc333"),
+		Handle: syntax.Handle("nolabeler.example.com"),
+	})
+	v := Verifier{Dir: &dir}
+
+	l := testLabel("did:plc:abc111")
+	assert.NoError(Sign(l, priv))
+	assert.NoError(v.Verify(ctx, l))
+
+	// signed by a key which isn't the source's labeler key
+	wrongSrc := testLabel("did:plc:abc333")
+	assert.NoError(Sign(wrongSrc, priv))
+	assert.Error(v.Verify(ctx, wrongSrc))
+
+	unknown := testLabel("did:plc:abc999")
+	assert.NoError(Sign(unknown, priv))
+	assert.Error(v.Verify(ctx, unknown))
+}
+
+func TestFileCursorStore(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	cs := FileCursorStore{Path: filepath.Join(t.TempDir(), "cursor")}
+	cur, err := cs.GetCursor(ctx)
+	assert.NoError(err)
+	assert.Equal(int64(0), cur)
+
+	assert.NoError(cs.SetCursor(ctx, 1234))
+	cur, err = cs.GetCursor(ctx)
+	assert.NoError(err)
+	assert.Equal(int64(1234), cur)
+}
+
+func TestConsumer(t *testing.T) {
+	assert := assert.New(t)
+
+	priv, err := crypto.GeneratePrivateKeyP256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{
+		DID:    syntax.DID("did:plc:abc111"),
+		Handle: syntax.Handle("labeler.example.com"),
+		Keys: map[string]identity.Key{
+			KeyID: {Type: "Multikey", PublicKeyMultibase: pub.Multibase()},
+		},
+	})
+
+	good := testLabel("did:plc:abc111")
+	assert.NoError(Sign(good, priv))
+	bad := testLabel("did:plc:abc111")
+	bad.Val = "porn"
+	bad.Sig = good.Sig
+
+	cursors := make(chan string, 2)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cursors <- r.URL.Query().Get("cursor")
+		con, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer con.Close()
+		// seq 1 is already consumed
+		for _, evt := range []*comatproto.LabelSubscribeLabels_Labels{
+			{Seq: 1, Labels: []*comatproto.LabelDefs_Label{good}},
+			{Seq: 2, Labels: []*comatproto.LabelDefs_Label{good, bad}},
+		} {
+			buf := new(bytes.Buffer)
+			hdr := events.EventHeader{Op: events.EvtKindMessage, MsgType: "#labels"}
+			if err := hdr.MarshalCBOR(buf); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := evt.MarshalCBOR(buf); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := con.WriteMessage(websocket.BinaryMessage, buf.Bytes()); err != nil {
+				return
+			}
+		}
+		// hold the connection open until the client goes away
+		con.ReadMessage()
+	}))
+	defer srv.Close()
+
+	cs := &FileCursorStore{Path: filepath.Join(t.TempDir(), "cursor")}
+	assert.NoError(cs.SetCursor(context.Background(), 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var received []*comatproto.LabelDefs_Label
+	c := Consumer{
+		Host:     srv.URL,
+		Cursors:  cs,
+		Verifier: &Verifier{Dir: &dir},
+		HandleLabels: func(ctx context.Context, seq int64, labels []*comatproto.LabelDefs_Label) error {
+			assert.Equal(int64(2), seq)
+			received = append(received, labels...)
+			cancel()
+			return nil
+		},
+	}
+	assert.NoError(c.Run(ctx))
+
+	assert.Equal("1", <-cursors)
+	assert.Equal(1, len(received))
+	assert.Equal("spam", received[0].Val)
+	cur, err := cs.GetCursor(context.Background())
+	assert.NoError(err)
+	assert.Equal(int64(2), cur)
+}
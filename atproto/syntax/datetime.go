@@ -64,6 +64,17 @@ func ParseDatetimeLenient(raw string) (Datetime, error) {
 		return valid, nil
 	}
 
+	// lower-case 't' separator or 'z' timezone are allowed by RFC-3339, but not atproto
+	if len(raw) > 10 && raw[10] == 't' {
+		raw = raw[:10] + "T" + raw[11:]
+	}
+	if strings.HasSuffix(raw, "z") {
+		raw = raw[:len(raw)-1] + "Z"
+	}
+	if fixed, err := ParseDatetime(raw); nil == err {
+		return fixed, nil
+	}
+
 	if strings.HasSuffix(raw, "-00:00") {
 		return ParseDatetime(strings.Replace(raw, "-00:00", "+00:00", 1))
 	}
@@ -85,6 +96,25 @@ func ParseDatetimeLenient(raw string) (Datetime, error) {
 	return "", fmt.Errorf("Datetime could not be parsed, even leniently: %v", err)
 }
 
+// Best-effort parsing of a Datetime string, which will accept some common non-conforming syntax seen in the wild (missing timezone, lower-case separator, etc), and return a Datetime in canonical [AtprotoDatetimeLayout] syntax.
+//
+// If the input already passed strict validation ([ParseDatetime]), it is returned as-is, and the returned boolean is false. Otherwise, the returned boolean indicates that normalization occurred and the string differs from the input.
+func ParseDatetimeNormalized(raw string) (Datetime, bool, error) {
+	valid, err := ParseDatetime(raw)
+	if nil == err {
+		return valid, false, nil
+	}
+	lenient, err := ParseDatetimeLenient(raw)
+	if err != nil {
+		return "", false, err
+	}
+	t := lenient.Time()
+	if t.IsZero() {
+		return "", false, fmt.Errorf("Datetime could not be normalized: %s", raw)
+	}
+	return Datetime(t.UTC().Format(AtprotoDatetimeLayout)), true, nil
+}
+
 // Parses the Datetime string in to a golang [time.Time].
 //
 // This method assumes that [ParseDatetime] was used to create the Datetime, which already verified parsing, and thus that [time.Parse] will always succeed. In the event of an error, zero/nil will be returned.
@@ -113,3 +113,26 @@ func TestDatetimeNow(t *testing.T) {
 	_, err := ParseDatetimeTime(dt.String())
 	assert.NoError(err)
 }
+
+func TestParseDatetimeNormalized(t *testing.T) {
+	assert := assert.New(t)
+
+	testVec := [][]string{
+		{"1985-04-12T23:20:50.123Z", "1985-04-12T23:20:50.123Z", "false"},
+		{"1985-04-12T23:20:50.123+01:00", "1985-04-12T23:20:50.123+01:00", "false"},
+		{"1985-04-12T23:20:50.123", "1985-04-12T23:20:50.123Z", "true"},
+		{"1985-04-12t23:20:50.123z", "1985-04-12T23:20:50.123Z", "true"},
+		{"1985-04-12t23:20:50", "1985-04-12T23:20:50Z", "true"},
+		{"1985-04-12T23:20:50.123-0000", "1985-04-12T23:20:50.123Z", "true"},
+		{"2023-11-12T11:20:01+0000", "2023-11-12T11:20:01Z", "true"},
+	}
+	for _, parts := range testVec {
+		dt, changed, err := ParseDatetimeNormalized(parts[0])
+		assert.NoError(err)
+		assert.Equal(parts[1], dt.String())
+		assert.Equal(parts[2] == "true", changed)
+	}
+
+	_, _, err := ParseDatetimeNormalized("1985-04-")
+	assert.Error(err)
+}
@@ -0,0 +1,148 @@
+package syntax
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Coarse classification of how likely a handle is to be a visual lookalike ("confusable") of some other handle.
+//
+// This is a heuristic signal for impersonation detection (eg, in automod rules or relay ingest), not a protocol-level rule: all of these handles are syntactically valid.
+type HandleRisk int
+
+const (
+	// Handle is plain ASCII, or uses a single non-Latin script without resembling ASCII
+	HandleRiskNone HandleRisk = iota
+	// At least one label of the handle mixes characters from multiple scripts (eg, Latin and Cyrillic)
+	HandleRiskMixedScript
+	// At least one label contains non-ASCII characters, all of which look like ASCII characters (eg, Cyrillic "раураl")
+	HandleRiskConfusable
+)
+
+func (r HandleRisk) String() string {
+	switch r {
+	case HandleRiskNone:
+		return "none"
+	case HandleRiskMixedScript:
+		return "mixed-script"
+	case HandleRiskConfusable:
+		return "confusable"
+	default:
+		return fmt.Sprintf("HandleRisk(%d)", int(r))
+	}
+}
+
+// Normalizes a user-supplied handle string, and then parses it as a [Handle].
+//
+// Surrounding whitespace, a leading '@', and a trailing '.' are removed. Unicode (IDN) labels are converted to punycode, and the result is lower-cased.
+func NormalizeHandleInput(raw string) (Handle, error) {
+	s := strings.TrimSpace(raw)
+	s = strings.TrimPrefix(s, "@")
+	s = strings.TrimSuffix(s, ".")
+	ascii, err := idna.Lookup.ToASCII(s)
+	if err != nil {
+		return "", fmt.Errorf("handle could not be converted to punycode: %w", err)
+	}
+	h, err := ParseHandle(ascii)
+	if err != nil {
+		return "", err
+	}
+	return h.Normalize(), nil
+}
+
+// Returns the handle with any punycode ("xn--") labels decoded to Unicode, for display. If decoding fails, the normalized ASCII handle is returned.
+func (h Handle) Unicode() string {
+	u, err := idna.Display.ToUnicode(string(h.Normalize()))
+	if err != nil {
+		return string(h.Normalize())
+	}
+	return u
+}
+
+// Maps a subset of common non-Latin lookalike characters to the ASCII character they resemble. This is intentionally small; see Unicode TR39 for the full confusables table.
+var confusableASCII = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j', 'к': 'k', 'ӏ': 'l',
+	'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'т': 't', 'ԁ': 'd',
+	'с': 'c', 'у': 'y', 'х': 'x', 'ԝ': 'w', 'ѵ': 'v',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+	// Armenian
+	'ո': 'n', 'ս': 'u',
+	// Latin extended
+	'ı': 'i', 'ł': 'l', 'ø': 'o', 'ɡ': 'g',
+}
+
+// Returns a "skeleton" of the handle, with known lookalike characters replaced by their ASCII equivalent, and diacritics (combining marks) stripped.
+//
+// Two handles with the same skeleton are likely to be visually confusable. Callers can compare the skeleton of a new handle against the skeletons of well-known accounts to detect impersonation.
+func (h Handle) Skeleton() string {
+	u := norm.NFD.String(strings.ToLower(h.Unicode()))
+	var sb strings.Builder
+	for _, c := range u {
+		if unicode.Is(unicode.Mn, c) {
+			// combining marks (accents)
+			continue
+		}
+		if r, ok := confusableASCII[c]; ok {
+			sb.WriteRune(r)
+			continue
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+func runeScript(c rune) string {
+	switch {
+	case c < 0x80:
+		if unicode.IsLetter(c) {
+			return "Latin"
+		}
+		return ""
+	case unicode.Is(unicode.Mn, c), !unicode.IsLetter(c):
+		return ""
+	case unicode.Is(unicode.Latin, c):
+		return "Latin"
+	case unicode.Is(unicode.Cyrillic, c):
+		return "Cyrillic"
+	case unicode.Is(unicode.Greek, c):
+		return "Greek"
+	case unicode.Is(unicode.Armenian, c):
+		return "Armenian"
+	}
+	return "Other"
+}
+
+// Classifies the handle by how likely it is to be a lookalike of an ASCII handle. The most severe classification of any individual label is returned.
+func (h Handle) ConfusableRisk() HandleRisk {
+	risk := HandleRiskNone
+	for _, label := range strings.Split(strings.ToLower(h.Unicode()), ".") {
+		scripts := map[string]bool{}
+		allConfusable := true
+		hasNonASCII := false
+		for _, c := range label {
+			if s := runeScript(c); s != "" {
+				scripts[s] = true
+			}
+			if c >= 0x80 {
+				hasNonASCII = true
+				if _, ok := confusableASCII[c]; !ok && !unicode.Is(unicode.Mn, c) {
+					allConfusable = false
+				}
+			}
+		}
+		if len(scripts) > 1 && risk < HandleRiskMixedScript {
+			risk = HandleRiskMixedScript
+		}
+		if hasNonASCII && allConfusable {
+			risk = HandleRiskConfusable
+		}
+	}
+	return risk
+}
@@ -0,0 +1,56 @@
+package syntax
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeHandleInput(t *testing.T) {
+	assert := assert.New(t)
+
+	testVec := [][]string{
+		{"@Alice.Bsky.Social", "alice.bsky.social"},
+		{" alice.example.com. ", "alice.example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+	}
+	for _, parts := range testVec {
+		h, err := NormalizeHandleInput(parts[0])
+		assert.NoError(err)
+		assert.Equal(parts[1], h.String())
+	}
+
+	_, err := NormalizeHandleInput("not a handle")
+	assert.Error(err)
+}
+
+func TestHandleUnicode(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("bücher.example", Handle("xn--bcher-kva.example").Unicode())
+	assert.Equal("alice.bsky.social", Handle("Alice.bsky.social").Unicode())
+}
+
+func TestHandleConfusableRisk(t *testing.T) {
+	assert := assert.New(t)
+
+	mustNorm := func(raw string) Handle {
+		h, err := NormalizeHandleInput(raw)
+		assert.NoError(err)
+		return h
+	}
+
+	assert.Equal(HandleRiskNone, mustNorm("paypal.com").ConfusableRisk())
+	assert.Equal(HandleRiskNone, mustNorm("bücher.example").ConfusableRisk())
+	assert.Equal(HandleRiskNone, mustNorm("пример.example").ConfusableRisk())
+	// Latin 'p' mixed with Cyrillic 'ш'
+	assert.Equal(HandleRiskMixedScript, mustNorm("pшa.example").ConfusableRisk())
+	// Latin with Cyrillic 'а' (U+0430)
+	assert.Equal(HandleRiskConfusable, mustNorm("pаypal.com").ConfusableRisk())
+	// entirely Cyrillic
+	assert.Equal(HandleRiskConfusable, mustNorm("раураl.com").ConfusableRisk())
+
+	assert.Equal("paypal.com", mustNorm("pаypal.com").Skeleton())
+	assert.Equal("paypal.com", mustNorm("paypal.com").Skeleton())
+	assert.Equal("bucher.example", mustNorm("bücher.example").Skeleton())
+}
@@ -20,6 +20,9 @@ func ParseNSID(raw string) (NSID, error) {
 		return "", fmt.Errorf("NSID is too long (317 chars max)")
 	}
 	if !nsidRegex.MatchString(raw) {
+		if err := ValidateNSID(raw); err != nil {
+			return "", err
+		}
 		return "", fmt.Errorf("NSID syntax didn't validate via regex")
 	}
 	return NSID(raw), nil
@@ -41,6 +44,24 @@ func (n NSID) Authority() string {
 	return strings.ToLower(strings.Join(parts, "."))
 }
 
+// Authority section of the NSID in the original reversed-domain order (eg, "app.bsky.feed" for "app.bsky.feed.post"), normalized to lower-case.
+func (n NSID) ReversedAuthority() string {
+	parts := strings.Split(string(n), ".")
+	if len(parts) < 2 {
+		return ""
+	}
+	return strings.ToLower(strings.Join(parts[:len(parts)-1], "."))
+}
+
+// DNS name which would be queried (for a TXT record) when resolving the Lexicon schema for this NSID (eg, "_lexicon.feed.bsky.app" for "app.bsky.feed.post").
+func (n NSID) LexiconDomain() string {
+	auth := n.Authority()
+	if auth == "" {
+		return ""
+	}
+	return "_lexicon." + auth
+}
+
 func (n NSID) Name() string {
 	parts := strings.Split(string(n), ".")
 	return parts[len(parts)-1]
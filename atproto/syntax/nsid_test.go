@@ -74,3 +74,48 @@ func TestNSIDNoPanic(t *testing.T) {
 		_ = bad.Normalize()
 	}
 }
+
+func TestNSIDAuthorityHelpers(t *testing.T) {
+	assert := assert.New(t)
+
+	nsid, err := ParseNSID("App.Bsky.feed.post")
+	assert.NoError(err)
+	assert.Equal("feed.bsky.app", nsid.Authority())
+	assert.Equal("app.bsky.feed", nsid.ReversedAuthority())
+	assert.Equal("_lexicon.feed.bsky.app", nsid.LexiconDomain())
+
+	bad := NSID("")
+	assert.Equal("", bad.LexiconDomain())
+	assert.Equal("", bad.ReversedAuthority())
+}
+
+func TestNSIDValidateDetail(t *testing.T) {
+	assert := assert.New(t)
+
+	testVec := []struct {
+		raw string
+		pos int
+	}{
+		{"com.example.foo", -2},
+		{"com.exa_mple.foo", 7},
+		{"1com.example.foo", 0},
+		{"com.-example.foo", 4},
+		{"com.example-.foo", 11},
+		{"com.example.fo0", 14},
+		{"com..foo", 4},
+		{"com.example", -1},
+	}
+	for _, tc := range testVec {
+		err := ValidateNSID(tc.raw)
+		if tc.pos == -2 {
+			assert.NoError(err)
+			continue
+		}
+		var serr *SyntaxError
+		if assert.ErrorAs(err, &serr) {
+			assert.Equal(tc.pos, serr.Pos, tc.raw)
+		}
+		_, err = ParseNSID(tc.raw)
+		assert.ErrorAs(err, &serr)
+	}
+}
@@ -22,6 +22,9 @@ func ParseRecordKey(raw string) (RecordKey, error) {
 		return "", fmt.Errorf("recordkey can not be empty, '.', or '..'")
 	}
 	if !recordKeyRegex.MatchString(raw) {
+		if err := ValidateRecordKey(raw); err != nil {
+			return "", err
+		}
 		return "", fmt.Errorf("recordkey syntax didn't validate via regex")
 	}
 	return RecordKey(raw), nil
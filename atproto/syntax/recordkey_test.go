@@ -55,3 +55,25 @@ func TestRecordKeyNoPanic(t *testing.T) {
 		_ = bad.String()
 	}
 }
+
+func TestRecordKeyValidateDetail(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(ValidateRecordKey("3jzfcijpj2z2a"))
+	assert.NoError(ValidateRecordKey("self"))
+
+	var serr *SyntaxError
+	err := ValidateRecordKey("abc/def")
+	if assert.ErrorAs(err, &serr) {
+		assert.Equal(3, serr.Pos)
+		assert.Equal('/', serr.Char)
+	}
+	err = ValidateRecordKey("..")
+	if assert.ErrorAs(err, &serr) {
+		assert.Equal(-1, serr.Pos)
+	}
+	_, err = ParseRecordKey("abc def")
+	if assert.ErrorAs(err, &serr) {
+		assert.Equal(3, serr.Pos)
+	}
+}
@@ -0,0 +1,104 @@
+package syntax
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Describes why a string failed syntax validation, including the position of the first offending character when relevant.
+//
+// Errors of this type are returned by [ValidateRecordKey] and [ValidateNSID], and by the corresponding Parse functions when the input fails validation.
+type SyntaxError struct {
+	// Name of the syntax being validated (eg, "recordkey" or "NSID")
+	Syntax string
+	// Byte offset of the offending character in the input, or -1 if the error does not relate to a specific character (eg, overall length)
+	Pos int
+	// The offending character, if Pos is not -1
+	Char rune
+	// Human-readable description of the rule which was violated
+	Reason string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Pos < 0 {
+		return fmt.Sprintf("%s syntax invalid: %s", e.Syntax, e.Reason)
+	}
+	return fmt.Sprintf("%s syntax invalid at position %d (%q): %s", e.Syntax, e.Pos, e.Char, e.Reason)
+}
+
+func isASCIIAlpha(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isASCIIDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Checks a string against record key syntax rules, returning a [*SyntaxError] indicating the first violation found.
+func ValidateRecordKey(raw string) error {
+	if raw == "" || raw == "." || raw == ".." {
+		return &SyntaxError{Syntax: "recordkey", Pos: -1, Reason: "can not be empty, '.', or '..'"}
+	}
+	if len(raw) > 512 {
+		return &SyntaxError{Syntax: "recordkey", Pos: -1, Reason: "too long (512 chars max)"}
+	}
+	for i, c := range raw {
+		if isASCIIAlpha(c) || isASCIIDigit(c) {
+			continue
+		}
+		switch c {
+		case '_', '~', '.', ':', '-':
+			continue
+		}
+		return &SyntaxError{Syntax: "recordkey", Pos: i, Char: c, Reason: "disallowed character"}
+	}
+	return nil
+}
+
+// Checks a string against NSID syntax rules, returning a [*SyntaxError] indicating the first violation found.
+func ValidateNSID(raw string) error {
+	if len(raw) > 317 {
+		return &SyntaxError{Syntax: "NSID", Pos: -1, Reason: "too long (317 chars max)"}
+	}
+	segments := strings.Split(raw, ".")
+	if len(segments) < 3 {
+		return &SyntaxError{Syntax: "NSID", Pos: -1, Reason: "must have at least three segments"}
+	}
+	if authLen := len(raw) - len(segments[len(segments)-1]) - 1; authLen > 253 {
+		return &SyntaxError{Syntax: "NSID", Pos: -1, Reason: "domain authority too long (253 chars max)"}
+	}
+
+	offset := 0
+	for idx, seg := range segments {
+		isName := idx == len(segments)-1
+		if seg == "" {
+			return &SyntaxError{Syntax: "NSID", Pos: offset, Char: '.', Reason: "empty segment"}
+		}
+		if len(seg) > 63 {
+			return &SyntaxError{Syntax: "NSID", Pos: offset, Char: rune(seg[0]), Reason: "segment too long (63 chars max)"}
+		}
+		for i, c := range seg {
+			pos := offset + i
+			if isName {
+				if !isASCIIAlpha(c) {
+					return &SyntaxError{Syntax: "NSID", Pos: pos, Char: c, Reason: "name segment must contain only ASCII letters"}
+				}
+				continue
+			}
+			if idx == 0 && i == 0 && !isASCIIAlpha(c) {
+				return &SyntaxError{Syntax: "NSID", Pos: pos, Char: c, Reason: "first segment must start with a letter"}
+			}
+			if c == '-' {
+				if i == 0 || i == len(seg)-1 {
+					return &SyntaxError{Syntax: "NSID", Pos: pos, Char: c, Reason: "domain segment can not start or end with a hyphen"}
+				}
+				continue
+			}
+			if !isASCIIAlpha(c) && !isASCIIDigit(c) {
+				return &SyntaxError{Syntax: "NSID", Pos: pos, Char: c, Reason: "disallowed character in domain segment"}
+			}
+		}
+		offset += len(seg) + 1
+	}
+	return nil
+}
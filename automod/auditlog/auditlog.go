@@ -0,0 +1,39 @@
+package auditlog
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// A single automod decision: the subject of an event, which rules fired, and the resulting actions.
+type AuditEntry struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+
+	// Type of event processed: "record", "identity", "notif", or "ozone"
+	EventType string `json:"eventType"`
+	// DID of the account, for both account-level and record-level events
+	SubjectDID string `gorm:"column:subject_did;index" json:"subjectDid"`
+	// AT-URI of the record; empty for account-level events
+	SubjectURI string `gorm:"column:subject_uri;index" json:"subjectUri,omitempty"`
+	SubjectCID string `gorm:"column:subject_cid" json:"subjectCid,omitempty"`
+	// Comma-separated names of the rules which requested actions
+	Rules string `json:"rules"`
+	// Comma-separated actions requested by rules (eg, "record-flag:spam,account-report:spam")
+	Actions string `json:"actions"`
+	// JSON-encoded object with supporting evidence (report comments, review evidence, scores, etc)
+	Evidence string `json:"evidence"`
+	// Whether actions were actually persisted, or only computed (eg, engine in dry-run mode)
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+type AuditLog interface {
+	Record(ctx context.Context, entry *AuditEntry) error
+	// Lists entries, newest first. "subject" is an account DID (matching all entries for the account, including records), a record AT-URI, or empty for all entries. "cursor" is the ID of the last entry from a previous page, or zero.
+	List(ctx context.Context, subject string, cursor uint, limit int) ([]AuditEntry, error)
+}
+
+func isRecordSubject(subject string) bool {
+	return strings.HasPrefix(subject, "at://")
+}
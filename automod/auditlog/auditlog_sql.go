@@ -0,0 +1,53 @@
+package auditlog
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// [AuditLog] implementation backed by a SQL database (sqlite or PostgreSQL), via gorm.
+type SQLAuditLog struct {
+	db *gorm.DB
+}
+
+var _ AuditLog = (*SQLAuditLog)(nil)
+
+// Creates a new audit log using the provided database, running any schema migrations.
+func NewSQLAuditLog(db *gorm.DB) (*SQLAuditLog, error) {
+	if err := db.AutoMigrate(&AuditEntry{}); err != nil {
+		return nil, fmt.Errorf("migrating audit log schema: %w", err)
+	}
+	return &SQLAuditLog{db: db}, nil
+}
+
+func (l *SQLAuditLog) Record(ctx context.Context, entry *AuditEntry) error {
+	entry.ID = 0
+	if err := l.db.WithContext(ctx).Create(entry).Error; err != nil {
+		return fmt.Errorf("recording audit entry: %w", err)
+	}
+	return nil
+}
+
+func (l *SQLAuditLog) List(ctx context.Context, subject string, cursor uint, limit int) ([]AuditEntry, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	query := l.db.WithContext(ctx).Order("id DESC").Limit(limit)
+	if cursor > 0 {
+		query = query.Where("id < ?", cursor)
+	}
+	switch {
+	case subject == "":
+	case isRecordSubject(subject):
+		query = query.Where("subject_uri = ?", subject)
+	default:
+		query = query.Where("subject_did = ?", subject)
+	}
+	var entries []AuditEntry
+	if err := query.Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,63 @@
+package auditlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testLog(t *testing.T) *SQLAuditLog {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in-memory sqlite databases are per-connection
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	l, err := NewSQLAuditLog(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestSQLAuditLog(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	l := testLog(t)
+
+	assert.NoError(l.Record(ctx, &AuditEntry{EventType: "identity", SubjectDID: "did:plc:abc111", Rules: "NewAccountRule", Actions: "account-flag:new"}))
+	assert.NoError(l.Record(ctx, &AuditEntry{EventType: "record", SubjectDID: "did:plc:abc111", SubjectURI: "at://did:plc:abc111/app.bsky.feed.post/abc123", Rules: "BadWordPostRule", Actions: "record-flag:bad-word"}))
+	assert.NoError(l.Record(ctx, &AuditEntry{EventType: "record", SubjectDID: "did:plc:abc222", SubjectURI: "at://did:plc:abc222/app.bsky.feed.post/abc123", Rules: "BadWordPostRule", Actions: "record-flag:bad-word"}))
+
+	all, err := l.List(ctx, "", 0, 10)
+	assert.NoError(err)
+	assert.Equal(3, len(all))
+	// newest first
+	assert.Equal("did:plc:abc222", all[0].SubjectDID)
+
+	// account subject includes record-level entries
+	acct, err := l.List(ctx, "did:plc:abc111", 0, 10)
+	assert.NoError(err)
+	assert.Equal(2, len(acct))
+
+	rec, err := l.List(ctx, "at://did:plc:abc111/app.bsky.feed.post/abc123", 0, 10)
+	assert.NoError(err)
+	assert.Equal(1, len(rec))
+	assert.Equal("BadWordPostRule", rec[0].Rules)
+
+	// pagination
+	page, err := l.List(ctx, "", 0, 2)
+	assert.NoError(err)
+	assert.Equal(2, len(page))
+	page, err = l.List(ctx, "", page[1].ID, 2)
+	assert.NoError(err)
+	assert.Equal(1, len(page))
+	assert.Equal("identity", page[0].EventType)
+}
@@ -0,0 +1,2 @@
+// Persistent log of automod rule decisions: for each event where rules requested moderation actions, which rules fired, the actions taken, and supporting evidence.
+package auditlog
@@ -21,9 +21,13 @@ func NewRedisCacheStore(redisURL string, ttl time.Duration) (*RedisCacheStore, e
 	if err != nil {
 		return nil, err
 	}
-	rdb := redis.NewClient(opt)
+	return NewRedisCacheStoreFromClient(ctx, redis.NewClient(opt), ttl)
+}
+
+// Creates a store using an existing redis client, which may be a cluster client.
+func NewRedisCacheStoreFromClient(ctx context.Context, rdb redis.UniversalClient, ttl time.Duration) (*RedisCacheStore, error) {
 	// check redis connection
-	_, err = rdb.Ping(ctx).Result()
+	_, err := rdb.Ping(ctx).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,120 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/engine"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextSketch(t *testing.T) {
+	assert := assert.New(t)
+
+	a := TextSketch("Claim your free crypto airdrop now at the link in my bio, limited time only")
+	b := TextSketch("claim your FREE crypto airdrop now at the link in my bio!! limited time only")
+	c := TextSketch("I went for a long walk in the park this morning and saw some nice birds")
+	assert.Equal(sketchSize, len(a))
+	assert.Equal(a, b)
+	for _, v := range c {
+		assert.NotContains(a, v)
+	}
+	assert.Nil(TextSketch("gm everyone"))
+}
+
+func TestClusterPostRule(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	eng := engine.EngineTestFixture()
+
+	text := "Claim your free crypto airdrop now at the link in my bio, limited time only"
+	post := appbsky.FeedPost{Text: text}
+
+	// simulate prior posts of the same text by other accounts
+	for i := 0; i < 3; i++ {
+		for _, key := range TextSketch(text) {
+			assert.NoError(eng.Counters.IncrementDistinct(ctx, "cluster-text", key, fmt.Sprintf("did:plc:other%d", i)))
+		}
+	}
+
+	am := automod.AccountMeta{
+		Identity: &identity.Identity{
+			DID:    syntax.DID("did:plc:abc111"),
+			Handle: syntax.Handle("handle.example.com"),
+		},
+	}
+	op := engine.RecordOp{
+		Action:     engine.CreateOp,
+		DID:        am.Identity.DID,
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+	}
+	c := engine.NewRecordContext(ctx, &eng, am, op)
+	assert.NoError(ClusterPostRule(&c, &post))
+	n, ok := c.GetScore(ScoreTextAccountsHour)
+	assert.True(ok)
+	assert.Equal(3.0, n)
+	n, ok = c.GetScore(ScoreTextAccountsDay)
+	assert.True(ok)
+	assert.Equal(3.0, n)
+	_, ok = c.GetScore(ScoreLinkAccountsHour)
+	assert.False(ok)
+
+	eff := engine.ExtractEffects(&c.BaseContext)
+	assert.Equal(len(TextSketch(text)), len(eff.CounterDistinctIncrements))
+}
+
+func TestNearDuplicatePostRule(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	eng := engine.EngineTestFixture()
+
+	text := "Claim your free crypto airdrop now at the link in my bio, limited time only"
+	post := appbsky.FeedPost{Text: text}
+
+	// simulate recent posts of near-duplicate text by other accounts, and one outside the window
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		for _, key := range TextSketch("claim your FREE crypto airdrop now at the link in my bio!! limited time only") {
+			assert.NoError(eng.Dupes.Add(ctx, "text/"+key, fmt.Sprintf("did:plc:other%d", i), now.Add(-time.Minute)))
+		}
+	}
+	for _, key := range TextSketch(text) {
+		assert.NoError(eng.Dupes.Add(ctx, "text/"+key, "did:plc:stale", now.Add(-NearDuplicateWindow-time.Minute)))
+	}
+
+	am := automod.AccountMeta{
+		Identity: &identity.Identity{
+			DID:    syntax.DID("did:plc:abc111"),
+			Handle: syntax.Handle("handle.example.com"),
+		},
+	}
+	op := engine.RecordOp{
+		Action:     engine.CreateOp,
+		DID:        am.Identity.DID,
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+	}
+	c := engine.NewRecordContext(ctx, &eng, am, op)
+	assert.NoError(NearDuplicatePostRule(&c, &post))
+	n, ok := c.GetScore(ScoreNearDuplicateAccounts)
+	assert.True(ok)
+	assert.Equal(3.0, n)
+
+	eff := engine.ExtractEffects(&c.BaseContext)
+	assert.Equal(len(TextSketch(text)), len(eff.DupeAdds))
+
+	// below the default threshold
+	assert.NoError(CopypastaPostRule(&c, &post))
+	assert.Empty(eff.RecordFlags)
+	c.SetScore(ScoreNearDuplicateAccounts, 10)
+	assert.NoError(CopypastaPostRule(&c, &post))
+	assert.Equal([]string{"copypasta"}, eff.RecordFlags)
+}
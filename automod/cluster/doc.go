@@ -0,0 +1,4 @@
+// Signals which correlate activity across multiple accounts, to help detect coordinated campaigns (eg, spam rings) rather than single-account behavior.
+//
+// The "provider" rules in this package record activity in the engine's counters, and set cluster sizes as scores on the context (see `c.GetScore()`), for later rules to act on.
+package cluster
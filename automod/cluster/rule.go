@@ -0,0 +1,159 @@
+package cluster
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/rules"
+)
+
+// Names of scores set by the provider rules in this package
+const (
+	// distinct accounts which posted near-duplicate text in the current day
+	ScoreTextAccountsDay = "cluster/text-accounts-day"
+	// distinct accounts which posted near-duplicate text in the current hour; a high value indicates a synchronized burst
+	ScoreTextAccountsHour = "cluster/text-accounts-hour"
+	// distinct accounts which posted the same link in the current hour
+	ScoreLinkAccountsHour = "cluster/link-accounts-hour"
+	// distinct accounts which signed up with an invite from the same inviter in the current day
+	ScoreInviteSiblingsDay = "cluster/invite-siblings-day"
+	// distinct accounts which posted near-duplicate text within the trailing [NearDuplicateWindow] (requires an engine dupe store)
+	ScoreNearDuplicateAccounts = "cluster/near-dupe-accounts"
+)
+
+// Sliding time window over which [NearDuplicatePostRule] counts accounts. Dupe stores should retain records for at least this long.
+var NearDuplicateWindow = 30 * time.Minute
+
+// max over a set of buckets of the distinct-account count for the given period
+func maxDistinct(c *automod.RecordContext, name string, buckets []string, period string) int {
+	best := 0
+	for _, b := range buckets {
+		if n := c.GetCountDistinct(name, b, period); n > best {
+			best = n
+		}
+	}
+	return best
+}
+
+// Tracks which accounts post near-duplicate text or identical links, and sets the [ScoreTextAccountsDay], [ScoreTextAccountsHour], and [ScoreLinkAccountsHour] scores.
+//
+// Counts reflect prior events (counter increments from the current event are persisted after all rules run), and do include the current account if it posted similar content before. This rule should be included ahead of any rules which read these scores.
+func ClusterPostRule(c *automod.RecordContext, post *appbsky.FeedPost) error {
+	did := c.Account.Identity.DID.String()
+
+	sketch := TextSketch(post.Text)
+	if len(sketch) > 0 {
+		c.SetScore(ScoreTextAccountsDay, float64(maxDistinct(c, "cluster-text", sketch, countstore.PeriodDay)))
+		c.SetScore(ScoreTextAccountsHour, float64(maxDistinct(c, "cluster-text", sketch, countstore.PeriodHour)))
+		for _, key := range sketch {
+			c.IncrementDistinct("cluster-text", key, did)
+		}
+	}
+
+	facets, err := rules.ExtractFacets(post)
+	if err != nil {
+		c.Logger.Debug("invalid facets", "err", err)
+		return nil
+	}
+	links := []string{}
+	for _, f := range facets {
+		if f.URL == nil {
+			continue
+		}
+		// normalize a bit, so trivial variations (eg, fragments) are grouped
+		u, err := url.Parse(*f.URL)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		u.Fragment = ""
+		links = append(links, u.String())
+	}
+	if len(links) > 0 {
+		c.SetScore(ScoreLinkAccountsHour, float64(maxDistinct(c, "cluster-link", links, countstore.PeriodHour)))
+		for _, l := range links {
+			c.IncrementDistinct("cluster-link", l, did)
+		}
+	}
+	return nil
+}
+
+var _ automod.PostRuleFunc = ClusterPostRule
+
+// Records post text sketches in the engine's dupe store, and sets the [ScoreNearDuplicateAccounts] score.
+//
+// This is similar to the text signals from [ClusterPostRule], but counts over a trailing window instead of calendar hours, so a copypasta flood is caught in full even if it straddles an hour boundary. Does nothing if the engine has no dupe store configured. As with the other provider rules, counts reflect prior events and should be read by later rules.
+func NearDuplicatePostRule(c *automod.RecordContext, post *appbsky.FeedPost) error {
+	sketch := TextSketch(post.Text)
+	if len(sketch) == 0 {
+		return nil
+	}
+	best := 0
+	for _, key := range sketch {
+		if n := c.GetDupeCount("text/"+key, NearDuplicateWindow); n > best {
+			best = n
+		}
+	}
+	c.SetScore(ScoreNearDuplicateAccounts, float64(best))
+	did := c.Account.Identity.DID.String()
+	for _, key := range sketch {
+		c.AddDupe("text/"+key, did)
+	}
+	return nil
+}
+
+var _ automod.PostRuleFunc = NearDuplicatePostRule
+
+// Example consumer of [ScoreNearDuplicateAccounts]: flags posts (from accounts of any age) which are part of a flood of near-duplicate text, for review.
+//
+// The threshold can be adjusted at runtime with the "near-dupe-flood" rule config threshold.
+func CopypastaPostRule(c *automod.RecordContext, post *appbsky.FeedPost) error {
+	if n, ok := c.GetScore(ScoreNearDuplicateAccounts); ok && int(n) >= c.Threshold("near-dupe-flood", 10) {
+		c.AddRecordFlag("copypasta")
+		c.QueueRecordReview("copypasta", map[string]string{"accounts": fmt.Sprintf("%d", int(n))})
+	}
+	return nil
+}
+
+var _ automod.PostRuleFunc = CopypastaPostRule
+
+// Sets the [ScoreInviteSiblingsDay] score for accounts with known inviter (requires private account metadata), and tracks the invite tree.
+//
+// Runs on every record event; the account is only counted towards the inviter's tree on its first events.
+func InviteTreeRecordRule(c *automod.RecordContext) error {
+	if c.Account.Private == nil || c.Account.Private.InvitedBy == "" {
+		return nil
+	}
+	inviter := c.Account.Private.InvitedBy
+	c.SetScore(ScoreInviteSiblingsDay, float64(c.GetCountDistinct("cluster-invite", inviter, countstore.PeriodDay)))
+	// only count accounts towards recent invite bursts while they are new
+	if rules.AccountIsYoungerThan(&c.AccountContext, 7*24*time.Hour) {
+		c.IncrementDistinct("cluster-invite", inviter, c.Account.Identity.DID.String())
+	}
+	return nil
+}
+
+var _ automod.RecordRuleFunc = InviteTreeRecordRule
+
+// Example consumer of cluster signals: flags and reports new accounts which are part of a synchronized burst of near-duplicate posts or links.
+//
+// Thresholds can be adjusted at runtime with the "cluster-text-burst" and "cluster-link-burst" rule config thresholds.
+func CoordinatedPostRule(c *automod.RecordContext, post *appbsky.FeedPost) error {
+	if !rules.AccountIsYoungerThan(&c.AccountContext, 14*24*time.Hour) {
+		return nil
+	}
+	if n, ok := c.GetScore(ScoreTextAccountsHour); ok && int(n) >= c.Threshold("cluster-text-burst", 20) {
+		c.AddRecordFlag("coordinated-text")
+		c.ReportAccount(automod.ReportReasonSpam, fmt.Sprintf("new account posting text nearly identical to posts from %d accounts in the past hour (possible coordinated campaign)", int(n)))
+	}
+	if n, ok := c.GetScore(ScoreLinkAccountsHour); ok && int(n) >= c.Threshold("cluster-link-burst", 30) {
+		c.AddRecordFlag("coordinated-link")
+		c.ReportAccount(automod.ReportReasonSpam, fmt.Sprintf("new account posting a link also posted by %d accounts in the past hour (possible coordinated campaign)", int(n)))
+	}
+	return nil
+}
+
+var _ automod.PostRuleFunc = CoordinatedPostRule
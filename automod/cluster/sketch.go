@@ -0,0 +1,49 @@
+package cluster
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/bluesky-social/indigo/automod/keyword"
+)
+
+const (
+	// number of words per shingle
+	shingleSize = 3
+	// texts with fewer tokens than this are too generic to cluster on (eg, "gm", "thank you")
+	minSketchTokens = 6
+	// number of min-hash values kept per text
+	sketchSize = 4
+)
+
+// Computes a compact "bottom-k" MinHash sketch of free-form text: the text is tokenized, split in to overlapping word shingles, and the smallest few shingle hashes are kept.
+//
+// Near-duplicate texts (eg, templated spam with a handful of words changed) will share most sketch values, while unrelated texts almost never share any. Returns nil for text which is too short to meaningfully cluster.
+func TextSketch(text string) []string {
+	tokens := keyword.TokenizeText(text)
+	if len(tokens) < minSketchTokens {
+		return nil
+	}
+	seen := map[uint64]bool{}
+	hashes := []uint64{}
+	for i := 0; i+shingleSize <= len(tokens); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(tokens[i:i+shingleSize], " ")))
+		v := h.Sum64()
+		if !seen[v] {
+			seen[v] = true
+			hashes = append(hashes, v)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	if len(hashes) > sketchSize {
+		hashes = hashes[:sketchSize]
+	}
+	out := make([]string, len(hashes))
+	for i, v := range hashes {
+		out[i] = fmt.Sprintf("%016x", v)
+	}
+	return out
+}
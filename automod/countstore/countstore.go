@@ -39,8 +39,8 @@ const (
 // Memory growth and availability of information over time also varies by implementation.
 // The RedisCountStore implementation uses Redis's key expiration primitives;
 // only the all-time counts go without expiration.
-// The MemCountStore grows without bound (it's intended to be used in testing
-// and other non-production operations).
+// The MemCountStore tracks the same expiration periods, and lazily ignores expired
+// counts; call "PurgeExpired" periodically to actually reclaim memory.
 type CountStore interface {
 	GetCount(ctx context.Context, name, val, period string) (int, error)
 	Increment(ctx context.Context, name, val string) error
@@ -50,6 +50,18 @@ type CountStore interface {
 	IncrementDistinct(ctx context.Context, name, bucket, val string) error
 }
 
+// How long period-bucketed counters are retained after their most recent increment. Zero means no expiration.
+func periodTTL(period string) time.Duration {
+	switch period {
+	case PeriodHour:
+		return 2 * time.Hour
+	case PeriodDay:
+		return 48 * time.Hour
+	default:
+		return 0
+	}
+}
+
 func periodBucket(name, val, period string) string {
 	switch period {
 	case PeriodTotal:
@@ -2,6 +2,7 @@ package countstore
 
 import (
 	"context"
+	"time"
 
 	"github.com/puzpuzpuz/xsync/v3"
 )
@@ -14,18 +15,35 @@ type MemCountStore struct {
 	// (Using a values for `name` and `val` with slashes in them is perhaps inadvisable, as it may be ambiguous.)
 	Counts         *xsync.MapOf[string, int]
 	DistinctCounts *xsync.MapOf[string, *xsync.MapOf[string, bool]]
+	// Expiration time for period-bucketed keys in both Counts and DistinctCounts. Keys which are not present never expire.
+	Expirations *xsync.MapOf[string, time.Time]
 }
 
+var _ CountStore = (*MemCountStore)(nil)
+
 func NewMemCountStore() MemCountStore {
 	return MemCountStore{
 		Counts:         xsync.NewMapOf[string, int](),
 		DistinctCounts: xsync.NewMapOf[string, *xsync.MapOf[string, bool]](),
+		Expirations:    xsync.NewMapOf[string, time.Time](),
+	}
+}
+
+func (s MemCountStore) expired(k string) bool {
+	exp, ok := s.Expirations.Load(k)
+	return ok && time.Now().After(exp)
+}
+
+func (s MemCountStore) touch(k, period string) {
+	if ttl := periodTTL(period); ttl > 0 {
+		s.Expirations.Store(k, time.Now().Add(ttl))
 	}
 }
 
 func (s MemCountStore) GetCount(ctx context.Context, name, val, period string) (int, error) {
-	v, ok := s.Counts.Load(periodBucket(name, val, period))
-	if !ok {
+	k := periodBucket(name, val, period)
+	v, ok := s.Counts.Load(k)
+	if !ok || s.expired(k) {
 		return 0, nil
 	}
 	return v, nil
@@ -42,15 +60,21 @@ func (s MemCountStore) Increment(ctx context.Context, name, val string) error {
 
 func (s MemCountStore) IncrementPeriod(ctx context.Context, name, val, period string) error {
 	k := periodBucket(name, val, period)
+	expired := s.expired(k)
 	s.Counts.Compute(k, func(oldVal int, _ bool) (int, bool) {
+		if expired {
+			return 1, false
+		}
 		return oldVal + 1, false
 	})
+	s.touch(k, period)
 	return nil
 }
 
 func (s MemCountStore) GetCountDistinct(ctx context.Context, name, bucket, period string) (int, error) {
-	v, ok := s.DistinctCounts.Load(periodBucket(name, bucket, period))
-	if !ok {
+	k := periodBucket(name, bucket, period)
+	v, ok := s.DistinctCounts.Load(k)
+	if !ok || s.expired(k) {
 		return 0, nil
 	}
 	return v.Size(), nil
@@ -59,13 +83,31 @@ func (s MemCountStore) GetCountDistinct(ctx context.Context, name, bucket, perio
 func (s MemCountStore) IncrementDistinct(ctx context.Context, name, bucket, val string) error {
 	for _, p := range []string{PeriodTotal, PeriodDay, PeriodHour} {
 		k := periodBucket(name, bucket, p)
+		expired := s.expired(k)
 		s.DistinctCounts.Compute(k, func(nested *xsync.MapOf[string, bool], _ bool) (*xsync.MapOf[string, bool], bool) {
-			if nested == nil {
+			if nested == nil || expired {
 				nested = xsync.NewMapOf[string, bool]()
 			}
 			nested.Store(val, true)
 			return nested, false
 		})
+		s.touch(k, p)
 	}
 	return nil
 }
+
+// Removes all expired counters, returning the number of keys purged. Intended to be called periodically by long-running processes.
+func (s MemCountStore) PurgeExpired() int {
+	now := time.Now()
+	purged := 0
+	s.Expirations.Range(func(k string, exp time.Time) bool {
+		if now.After(exp) {
+			s.Counts.Delete(k)
+			s.DistinctCounts.Delete(k)
+			s.Expirations.Delete(k)
+			purged++
+		}
+		return true
+	})
+	return purged
+}
@@ -2,7 +2,6 @@ package countstore
 
 import (
 	"context"
-	"time"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -11,18 +10,27 @@ var redisCountPrefix string = "count/"
 var redisDistinctPrefix string = "distinct/"
 
 type RedisCountStore struct {
-	Client *redis.Client
+	// either a single-node client (*redis.Client) or a cluster client (*redis.ClusterClient)
+	Client redis.UniversalClient
 }
 
+var _ CountStore = (*RedisCountStore)(nil)
+
 func NewRedisCountStore(redisURL string) (*RedisCountStore, error) {
 	ctx := context.Background()
 	opt, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, err
 	}
-	rdb := redis.NewClient(opt)
+	return NewRedisCountStoreFromClient(ctx, redis.NewClient(opt))
+}
+
+// Creates a store using an existing redis client, which may be a cluster client (eg, from [redis.NewClusterClient] or [redis.NewUniversalClient]).
+//
+// Counter keys for different periods may hash to different cluster slots; pipelined increments are split by slot automatically by the cluster client.
+func NewRedisCountStoreFromClient(ctx context.Context, rdb redis.UniversalClient) (*RedisCountStore, error) {
 	// check redis connection
-	_, err = rdb.Ping(ctx).Result()
+	_, err := rdb.Ping(ctx).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -52,11 +60,11 @@ func (s *RedisCountStore) Increment(ctx context.Context, name, val string) error
 
 	key = redisCountPrefix + periodBucket(name, val, PeriodHour)
 	multi.Incr(ctx, key)
-	multi.Expire(ctx, key, 2*time.Hour)
+	multi.Expire(ctx, key, periodTTL(PeriodHour))
 
 	key = redisCountPrefix + periodBucket(name, val, PeriodDay)
 	multi.Incr(ctx, key)
-	multi.Expire(ctx, key, 48*time.Hour)
+	multi.Expire(ctx, key, periodTTL(PeriodDay))
 
 	key = redisCountPrefix + periodBucket(name, val, PeriodTotal)
 	multi.Incr(ctx, key)
@@ -75,11 +83,8 @@ func (s *RedisCountStore) IncrementPeriod(ctx context.Context, name, val, period
 	key := redisCountPrefix + periodBucket(name, val, period)
 	multi.Incr(ctx, key)
 
-	switch period {
-	case PeriodHour:
-		multi.Expire(ctx, key, 2*time.Hour)
-	case PeriodDay:
-		multi.Expire(ctx, key, 48*time.Hour)
+	if ttl := periodTTL(period); ttl > 0 {
+		multi.Expire(ctx, key, ttl)
 	}
 
 	_, err := multi.Exec(ctx)
@@ -106,11 +111,11 @@ func (s *RedisCountStore) IncrementDistinct(ctx context.Context, name, bucket, v
 
 	key = redisDistinctPrefix + periodBucket(name, bucket, PeriodHour)
 	multi.PFAdd(ctx, key, val)
-	multi.Expire(ctx, key, 2*time.Hour)
+	multi.Expire(ctx, key, periodTTL(PeriodHour))
 
 	key = redisDistinctPrefix + periodBucket(name, bucket, PeriodDay)
 	multi.PFAdd(ctx, key, val)
-	multi.Expire(ctx, key, 48*time.Hour)
+	multi.Expire(ctx, key, periodTTL(PeriodDay))
 
 	key = redisDistinctPrefix + periodBucket(name, bucket, PeriodTotal)
 	multi.PFAdd(ctx, key, val)
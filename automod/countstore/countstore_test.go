@@ -104,3 +104,40 @@ func TestMemCountStoreConcurrent(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(1, c)
 }
+
+func TestMemCountStoreExpiration(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	cs := NewMemCountStore()
+	assert.NoError(cs.Increment(ctx, "test1", "val1"))
+	assert.NoError(cs.IncrementDistinct(ctx, "test2", "val2", "one"))
+
+	// force the hour buckets to be expired
+	past := time.Now().Add(-time.Minute)
+	cs.Expirations.Store(periodBucket("test1", "val1", PeriodHour), past)
+	cs.Expirations.Store(periodBucket("test2", "val2", PeriodHour), past)
+
+	c, err := cs.GetCount(ctx, "test1", "val1", PeriodHour)
+	assert.NoError(err)
+	assert.Equal(0, c)
+	c, err = cs.GetCount(ctx, "test1", "val1", PeriodDay)
+	assert.NoError(err)
+	assert.Equal(1, c)
+	c, err = cs.GetCountDistinct(ctx, "test2", "val2", PeriodHour)
+	assert.NoError(err)
+	assert.Equal(0, c)
+
+	// incrementing an expired bucket starts over
+	assert.NoError(cs.IncrementPeriod(ctx, "test1", "val1", PeriodHour))
+	c, err = cs.GetCount(ctx, "test1", "val1", PeriodHour)
+	assert.NoError(err)
+	assert.Equal(1, c)
+
+	assert.Equal(1, cs.PurgeExpired())
+	_, ok := cs.DistinctCounts.Load(periodBucket("test2", "val2", PeriodHour))
+	assert.False(ok)
+	c, err = cs.GetCount(ctx, "test1", "val1", PeriodTotal)
+	assert.NoError(err)
+	assert.Equal(1, c)
+}
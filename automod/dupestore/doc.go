@@ -0,0 +1,2 @@
+// Interface for tracking which accounts have recently posted near-duplicate content, over a sliding time window, and separate implementations using redis and in-process memory.
+package dupestore
@@ -0,0 +1,17 @@
+package dupestore
+
+import (
+	"context"
+	"time"
+)
+
+// DupeStore records which "members" (usually account DIDs) have posted content with a given fingerprint (eg, one value of a MinHash text sketch), and counts them over a sliding time window.
+//
+// Unlike the period buckets of countstore, windows are relative to the query time, so a burst of posts straddling an hour boundary is counted in full. Each store has a retention period; records older than that are discarded, and windows longer than the retention period are effectively truncated to it.
+//
+// A member which posts the same fingerprint repeatedly is counted once, as of its most recent post.
+type DupeStore interface {
+	Add(ctx context.Context, fingerprint, member string, at time.Time) error
+	// Number of distinct members which posted the fingerprint at or after "since"
+	CountSince(ctx context.Context, fingerprint string, since time.Time) (int, error)
+}
@@ -0,0 +1,85 @@
+package dupestore
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// In-process dupe store. Safe for concurrent use.
+//
+// Stale members of a fingerprint are dropped when the fingerprint is next added to; call "PurgeExpired" periodically to also reclaim fingerprints which are no longer being posted.
+type MemDupeStore struct {
+	Retention time.Duration
+
+	mu *sync.Mutex
+	// fingerprint to member to most recent post time
+	data map[string]map[string]time.Time
+}
+
+var _ DupeStore = (*MemDupeStore)(nil)
+
+func NewMemDupeStore(retention time.Duration) MemDupeStore {
+	return MemDupeStore{
+		Retention: retention,
+		mu:        &sync.Mutex{},
+		data:      make(map[string]map[string]time.Time),
+	}
+}
+
+func (s MemDupeStore) Add(ctx context.Context, fingerprint, member string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members, ok := s.data[fingerprint]
+	if !ok {
+		members = make(map[string]time.Time)
+		s.data[fingerprint] = members
+	}
+	cutoff := time.Now().Add(-s.Retention)
+	for m, t := range members {
+		if t.Before(cutoff) {
+			delete(members, m)
+		}
+	}
+	if prev, ok := members[member]; !ok || at.After(prev) {
+		members[member] = at
+	}
+	return nil
+}
+
+func (s MemDupeStore) CountSince(ctx context.Context, fingerprint string, since time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cutoff := time.Now().Add(-s.Retention); since.Before(cutoff) {
+		since = cutoff
+	}
+	n := 0
+	for _, t := range s.data[fingerprint] {
+		if !t.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Removes all fingerprints with no members inside the retention period, returning the number purged.
+func (s MemDupeStore) PurgeExpired() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := time.Now().Add(-s.Retention)
+	purged := 0
+	for fp, members := range s.data {
+		stale := true
+		for _, t := range members {
+			if !t.Before(cutoff) {
+				stale = false
+				break
+			}
+		}
+		if stale {
+			delete(s.data, fp)
+			purged++
+		}
+	}
+	return purged
+}
@@ -0,0 +1,70 @@
+package dupestore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var redisDupePrefix string = "dupe/"
+
+// Dupe store using one redis sorted set per fingerprint, with members scored by their most recent post time (in unix milliseconds).
+type RedisDupeStore struct {
+	// either a single-node client (*redis.Client) or a cluster client (*redis.ClusterClient)
+	Client    redis.UniversalClient
+	Retention time.Duration
+}
+
+var _ DupeStore = (*RedisDupeStore)(nil)
+
+func NewRedisDupeStore(redisURL string, retention time.Duration) (*RedisDupeStore, error) {
+	ctx := context.Background()
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return NewRedisDupeStoreFromClient(ctx, redis.NewClient(opt), retention)
+}
+
+// Creates a store using an existing redis client, which may be a cluster client.
+func NewRedisDupeStoreFromClient(ctx context.Context, rdb redis.UniversalClient, retention time.Duration) (*RedisDupeStore, error) {
+	// check redis connection
+	_, err := rdb.Ping(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	rds := RedisDupeStore{
+		Client:    rdb,
+		Retention: retention,
+	}
+	return &rds, nil
+}
+
+func (s *RedisDupeStore) Add(ctx context.Context, fingerprint, member string, at time.Time) error {
+	rkey := redisDupePrefix + fingerprint
+	cutoff := time.Now().Add(-s.Retention).UnixMilli()
+	// all commands are on the same key, so this works with cluster clients
+	multi := s.Client.TxPipeline()
+	// "GT" only moves a member's score forward, so out-of-order events don't shorten its window
+	multi.ZAddGT(ctx, rkey, redis.Z{Score: float64(at.UnixMilli()), Member: member})
+	multi.ZRemRangeByScore(ctx, rkey, "-inf", "("+strconv.FormatInt(cutoff, 10))
+	multi.Expire(ctx, rkey, s.Retention)
+	_, err := multi.Exec(ctx)
+	return err
+}
+
+func (s *RedisDupeStore) CountSince(ctx context.Context, fingerprint string, since time.Time) (int, error) {
+	rkey := redisDupePrefix + fingerprint
+	if cutoff := time.Now().Add(-s.Retention); since.Before(cutoff) {
+		since = cutoff
+	}
+	n, err := s.Client.ZCount(ctx, rkey, strconv.FormatInt(since.UnixMilli(), 10), "+inf").Result()
+	if err == redis.Nil {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
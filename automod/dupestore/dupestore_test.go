@@ -0,0 +1,68 @@
+package dupestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testDupeStore(t *testing.T, ds DupeStore) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	n, err := ds.CountSince(ctx, "test-fp1", now.Add(-time.Hour))
+	assert.NoError(err)
+	assert.Equal(0, n)
+
+	assert.NoError(ds.Add(ctx, "test-fp1", "did:plc:one", now.Add(-50*time.Minute)))
+	assert.NoError(ds.Add(ctx, "test-fp1", "did:plc:two", now.Add(-10*time.Minute)))
+	assert.NoError(ds.Add(ctx, "test-fp1", "did:plc:three", now.Add(-5*time.Minute)))
+	// repeat posts are counted once, as of the most recent
+	assert.NoError(ds.Add(ctx, "test-fp1", "did:plc:two", now.Add(-2*time.Minute)))
+	assert.NoError(ds.Add(ctx, "test-fp1", "did:plc:two", now.Add(-20*time.Minute)))
+	assert.NoError(ds.Add(ctx, "test-fp2", "did:plc:one", now))
+	// older than the retention period
+	assert.NoError(ds.Add(ctx, "test-fp1", "did:plc:four", now.Add(-90*time.Minute)))
+
+	n, err = ds.CountSince(ctx, "test-fp1", now.Add(-time.Hour))
+	assert.NoError(err)
+	assert.Equal(3, n)
+	n, err = ds.CountSince(ctx, "test-fp1", now.Add(-15*time.Minute))
+	assert.NoError(err)
+	assert.Equal(2, n)
+	n, err = ds.CountSince(ctx, "test-fp1", now.Add(-3*time.Minute))
+	assert.NoError(err)
+	assert.Equal(1, n)
+
+	// windows are truncated to the retention period
+	n, err = ds.CountSince(ctx, "test-fp1", now.Add(-24*time.Hour))
+	assert.NoError(err)
+	assert.Equal(3, n)
+}
+
+func TestMemDupeStore(t *testing.T) {
+	assert := assert.New(t)
+	ds := NewMemDupeStore(time.Hour)
+	testDupeStore(t, ds)
+
+	assert.Equal(0, ds.PurgeExpired())
+	ds.Retention = time.Minute
+	// only test-fp2 has been posted to within the last minute
+	assert.Equal(1, ds.PurgeExpired())
+}
+
+func TestRedisDupeStore(t *testing.T) {
+	t.Skip("live test, need redis running locally")
+	ds, err := NewRedisDupeStore("redis://localhost:6379/0", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, fp := range []string{"test-fp1", "test-fp2"} {
+		ds.Client.Del(ctx, redisDupePrefix+fp)
+	}
+	testDupeStore(t, ds)
+}
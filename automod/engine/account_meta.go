@@ -34,4 +34,6 @@ type AccountPrivate struct {
 	EmailConfirmed bool
 	IndexedAt      *time.Time
 	AccountTags    []string
+	// DID of the account which created the invite code used to sign up, if known
+	InvitedBy string
 }
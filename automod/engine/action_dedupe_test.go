@@ -9,8 +9,11 @@ import (
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/reviewqueue"
 
 	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 func alwaysReportAccountRule(c *RecordContext) error {
@@ -56,3 +59,91 @@ func TestAccountReportDedupe(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(1, reports)
 }
+
+func alwaysEscalateAccountRule(c *RecordContext) error {
+	c.EscalateAccount("test escalation")
+	return nil
+}
+
+func TestAccountEscalateDedupe(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	eng := EngineTestFixture()
+	eng.Rules = RuleSet{
+		RecordRules: []RecordRuleFunc{
+			alwaysEscalateAccountRule,
+		},
+	}
+
+	cid1 := syntax.CID("cid123")
+	p1 := appbsky.FeedPost{Text: "some post blah"}
+	p1buf := new(bytes.Buffer)
+	assert.NoError(p1.MarshalCBOR(p1buf))
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: "app.bsky.feed.post",
+		RecordKey:  "abc123",
+		CID:        &cid1,
+		RecordCBOR: p1buf.Bytes(),
+	}
+	for i := 0; i < 3; i++ {
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+	}
+
+	escalations, err := eng.Counters.GetCount(ctx, "automod-escalate", "did:plc:abc111", countstore.PeriodDay)
+	assert.NoError(err)
+	assert.Equal(1, escalations)
+}
+
+func alwaysReviewRecordRule(c *RecordContext) error {
+	c.AddRecordFlag("needs-review")
+	c.QueueRecordReview("test review", map[string]string{"key": "val"})
+	return nil
+}
+
+func TestRecordReviewQueue(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(err)
+	sqlDB, err := db.DB()
+	assert.NoError(err)
+	sqlDB.SetMaxOpenConns(1)
+	rq, err := reviewqueue.NewSQLReviewQueue(db)
+	assert.NoError(err)
+
+	eng := EngineTestFixture()
+	eng.ReviewQueue = rq
+	eng.Rules = RuleSet{
+		RecordRules: []RecordRuleFunc{
+			alwaysReviewRecordRule,
+		},
+	}
+
+	cid1 := syntax.CID("cid123")
+	p1 := appbsky.FeedPost{Text: "some post blah"}
+	p1buf := new(bytes.Buffer)
+	assert.NoError(p1.MarshalCBOR(p1buf))
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: "app.bsky.feed.post",
+		RecordKey:  "abc123",
+		CID:        &cid1,
+		RecordCBOR: p1buf.Bytes(),
+	}
+	for i := 0; i < 2; i++ {
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+	}
+
+	items, err := rq.List(ctx, reviewqueue.StatusOpen, 0, 10)
+	assert.NoError(err)
+	assert.Equal(1, len(items))
+	assert.Equal("at://did:plc:abc111/app.bsky.feed.post/abc123", items[0].SubjectURI)
+	assert.Equal("cid123", items[0].SubjectCID)
+	assert.Equal("alwaysReviewRecordRule", items[0].Rules)
+	assert.Equal("test review", items[0].Reason)
+	assert.Contains(items[0].Evidence, "needs-review")
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/bluesky-social/indigo/automod/auditlog"
+)
+
+type auditReport struct {
+	Reason  string `json:"reason"`
+	Comment string `json:"comment"`
+}
+
+type auditReview struct {
+	Rule     string            `json:"rule"`
+	Reason   string            `json:"reason"`
+	Evidence map[string]string `json:"evidence,omitempty"`
+}
+
+// JSON structure stored in the "evidence" field of audit log entries
+type auditEvidence struct {
+	Reports       []auditReport      `json:"reports,omitempty"`
+	Escalate      string             `json:"escalate,omitempty"`
+	Reviews       []auditReview      `json:"reviews,omitempty"`
+	BlobTakedowns []string           `json:"blobTakedowns,omitempty"`
+	Notify        []string           `json:"notify,omitempty"`
+	Scores        map[string]float64 `json:"scores,omitempty"`
+}
+
+func newAuditEvidence(eff *Effects, scores map[string]float64) auditEvidence {
+	ev := auditEvidence{
+		BlobTakedowns: eff.BlobTakedowns,
+		Notify:        eff.NotifyServices,
+		Scores:        scores,
+	}
+	for _, r := range append(eff.AccountReports, eff.RecordReports...) {
+		ev.Reports = append(ev.Reports, auditReport{Reason: ReasonShortName(r.ReasonType), Comment: r.Comment})
+	}
+	for _, r := range append(eff.AccountReviews, eff.RecordReviews...) {
+		ev.Reviews = append(ev.Reviews, auditReview{Rule: r.Rule, Reason: r.Reason, Evidence: r.Evidence})
+	}
+	if eff.RecordEscalate != "" {
+		ev.Escalate = eff.RecordEscalate
+	} else {
+		ev.Escalate = eff.AccountEscalate
+	}
+	return ev
+}
+
+// Records per-rule hit metrics for a processed event, and writes an entry to the engine's audit log (if configured) if any rule requested a moderation action.
+//
+// Audit log failures are logged, but do not cause event processing to fail. "op" is nil for account-level events.
+func (eng *Engine) auditEvent(c *AccountContext, eventType string, op *RecordOp) {
+	eff := c.effects
+	for _, name := range eff.RulesFired {
+		ruleHitCount.WithLabelValues(name).Inc()
+	}
+	if eng.AuditLog == nil {
+		return
+	}
+	actions := eff.Actions()
+	if len(actions) == 0 && len(eff.RulesFired) == 0 {
+		return
+	}
+
+	entry := auditlog.AuditEntry{
+		EventType:  eventType,
+		SubjectDID: c.Account.Identity.DID.String(),
+		Rules:      strings.Join(eff.RulesFired, ","),
+		Actions:    strings.Join(actions, ","),
+		DryRun:     eng.DryRun,
+	}
+	if op != nil {
+		entry.SubjectURI = op.ATURI().String()
+		if op.CID != nil {
+			entry.SubjectCID = op.CID.String()
+		}
+	}
+	evidence, err := json.Marshal(newAuditEvidence(eff, c.scores.all()))
+	if err != nil {
+		c.Logger.Error("failed to serialize audit evidence", "err", err)
+	} else {
+		entry.Evidence = string(evidence)
+	}
+	if err := eng.AuditLog.Record(c.Ctx, &entry); err != nil {
+		auditEntryCount.WithLabelValues("error").Inc()
+		c.Logger.Error("failed to write audit log entry", "err", err)
+		return
+	}
+	auditEntryCount.WithLabelValues("success").Inc()
+}
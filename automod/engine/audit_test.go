@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/auditlog"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func quietPostRule(c *RecordContext, post *appbsky.FeedPost) error {
+	c.Increment("quiet", "count")
+	return nil
+}
+
+func flagPostRule(c *RecordContext, post *appbsky.FeedPost) error {
+	c.AddRecordFlag("flagged")
+	c.ReportRecord(ReportReasonSpam, "looks like spam")
+	return nil
+}
+
+func TestAuditLog(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(err)
+	sqlDB, err := db.DB()
+	assert.NoError(err)
+	sqlDB.SetMaxOpenConns(1)
+	al, err := auditlog.NewSQLAuditLog(db)
+	assert.NoError(err)
+
+	eng := EngineTestFixture()
+	eng.AuditLog = al
+	eng.Rules = RuleSet{
+		PostRules: []PostRuleFunc{
+			quietPostRule,
+			flagPostRule,
+		},
+	}
+
+	cid1 := syntax.CID("cid123")
+	p1 := appbsky.FeedPost{Text: "some post blah"}
+	p1buf := new(bytes.Buffer)
+	assert.NoError(p1.MarshalCBOR(p1buf))
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: "app.bsky.feed.post",
+		RecordKey:  "abc123",
+		CID:        &cid1,
+		RecordCBOR: p1buf.Bytes(),
+	}
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+
+	entries, err := al.List(ctx, "did:plc:abc111", 0, 10)
+	assert.NoError(err)
+	assert.Equal(1, len(entries))
+	e := entries[0]
+	assert.Equal("record", e.EventType)
+	assert.Equal("at://did:plc:abc111/app.bsky.feed.post/abc123", e.SubjectURI)
+	assert.Equal("cid123", e.SubjectCID)
+	// rules which only increment counters are not recorded as having fired
+	assert.Equal("flagPostRule", e.Rules)
+	assert.Equal("record-flag:flagged,record-report:spam", e.Actions)
+	assert.Contains(e.Evidence, "looks like spam")
+
+	// no entry when no rules fire
+	eng.Rules = RuleSet{
+		PostRules: []PostRuleFunc{
+			quietPostRule,
+		},
+	}
+	op.RecordKey = "abc456"
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+	entries, err = al.List(ctx, "", 0, 10)
+	assert.NoError(err)
+	assert.Equal(1, len(entries))
+}
+
+func flagHelper(c *RecordContext) {
+	c.AddRecordFlag("helped")
+}
+
+func helperFlagPostRule(c *RecordContext, post *appbsky.FeedPost) error {
+	flagHelper(c)
+	func() {
+		c.QueueRecordReview("from a closure", nil)
+	}()
+	return nil
+}
+
+func TestRuleAttribution(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	eng.Rules = RuleSet{
+		PostRules: []PostRuleFunc{
+			quietPostRule,
+			helperFlagPostRule,
+		},
+	}
+
+	cid1 := syntax.CID("cid123")
+	p1 := appbsky.FeedPost{Text: "some post blah"}
+	p1buf := new(bytes.Buffer)
+	assert.NoError(p1.MarshalCBOR(p1buf))
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: "app.bsky.feed.post",
+		RecordKey:  "abc123",
+		CID:        &cid1,
+		RecordCBOR: p1buf.Bytes(),
+	}
+	am := AccountMeta{Identity: &identity.Identity{DID: op.DID, Handle: syntax.Handle("handle.example.com")}}
+	c := NewRecordContext(ctx, &eng, am, op)
+	assert.NoError(eng.Rules.CallRecordRules(&c))
+
+	// actions requested from helper functions and closures are credited to the rule which was running
+	effects := ExtractEffects(&c.BaseContext)
+	assert.Equal([]string{"helperFlagPostRule"}, effects.RulesFired)
+	assert.Equal([]string{"helped"}, effects.RecordFlags)
+	if assert.Len(effects.RecordReviews, 1) {
+		assert.Equal("helperFlagPostRule", effects.RecordReviews[0].Rule)
+	}
+
+	// and actions requested outside of any rule aren't credited
+	c.AddRecordFlag("engine")
+	assert.Equal([]string{"helperFlagPostRule"}, effects.RulesFired)
+}
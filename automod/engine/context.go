@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"time"
 
 	toolsozone "github.com/bluesky-social/indigo/api/ozone"
 	"github.com/bluesky-social/indigo/atproto/identity"
@@ -21,6 +22,13 @@ type BaseContext struct {
 
 	engine  *Engine // NOTE: pointer, but expected never to be nil
 	effects *Effects
+	scores  *scoreSet
+	// name of the rule function being run, set by the engine for each rule call. Actions requested through the context are attributed to this rule
+	rule string
+}
+
+func (c *BaseContext) base() *BaseContext {
+	return c
 }
 
 // Both a useful context on it's own (eg, for identity events), and extended by other context types.
@@ -158,7 +166,25 @@ func (c *BaseContext) GetCountDistinct(name, bucket, period string) int {
 	return out
 }
 
+// Number of distinct accounts (or other members) which posted content with the given fingerprint in the window before now, from the engine's dupe store. Returns zero if no dupe store is configured. Does not include the event currently being processed.
+func (c *BaseContext) GetDupeCount(fingerprint string, window time.Duration) int {
+	if c.engine.Dupes == nil {
+		return 0
+	}
+	out, err := c.engine.Dupes.CountSince(c.Ctx, fingerprint, time.Now().Add(-window))
+	if err != nil {
+		if nil == c.Err {
+			c.Err = err
+		}
+		return 0
+	}
+	return out
+}
+
 func (c *BaseContext) InSet(name, val string) bool {
+	if out, ok := c.engine.RuleConfig.Current().inSet(name, val); ok {
+		return out
+	}
 	out, err := c.engine.Sets.InSet(c.Ctx, name, val)
 	if err != nil {
 		if nil == c.Err {
@@ -169,6 +195,19 @@ func (c *BaseContext) InSet(name, val string) bool {
 	return out
 }
 
+// Returns the named threshold from the active rule config, or the provided default if not configured.
+func (c *BaseContext) Threshold(name string, def int) int {
+	rc := c.engine.RuleConfig.Current()
+	if rc == nil {
+		return def
+	}
+	v, ok := rc.Thresholds[name]
+	if !ok {
+		return def
+	}
+	return v
+}
+
 func NewAccountContext(ctx context.Context, eng *Engine, meta AccountMeta) AccountContext {
 	return AccountContext{
 		BaseContext: BaseContext{
@@ -177,6 +216,7 @@ func NewAccountContext(ctx context.Context, eng *Engine, meta AccountMeta) Accou
 			Logger:  eng.Logger.With("did", meta.Identity.DID),
 			engine:  eng,
 			effects: &Effects{},
+			scores:  &scoreSet{},
 		},
 		Account: meta,
 	}
@@ -248,50 +288,85 @@ func (c *BaseContext) IncrementDistinct(name, bucket, val string) {
 	c.effects.IncrementDistinct(name, bucket, val)
 }
 
+func (c *BaseContext) AddDupe(fingerprint, member string) {
+	c.effects.AddDupe(fingerprint, member)
+}
+
 func (c *BaseContext) IncrementPeriod(name, val string, period string) {
 	c.effects.IncrementPeriod(name, val, period)
 }
 
 func (c *BaseContext) Notify(srv string) {
+	c.effects.ruleFired(c.rule)
 	c.effects.Notify(srv)
 }
 
 func (c *AccountContext) AddAccountFlag(val string) {
+	c.effects.ruleFired(c.rule)
 	c.effects.AddAccountFlag(val)
 }
 
 func (c *AccountContext) AddAccountLabel(val string) {
+	c.effects.ruleFired(c.rule)
 	c.effects.AddAccountLabel(val)
 }
 
 func (c *AccountContext) ReportAccount(reason, comment string) {
+	c.effects.ruleFired(c.rule)
 	c.effects.ReportAccount(reason, comment)
 }
 
 func (c *AccountContext) TakedownAccount() {
+	c.effects.ruleFired(c.rule)
 	c.effects.TakedownAccount()
 }
 
+func (c *AccountContext) EscalateAccount(comment string) {
+	c.effects.ruleFired(c.rule)
+	c.effects.EscalateAccount(comment)
+}
+
+// Queues the account for human review. The name of the rule requesting review is recorded automatically.
+func (c *AccountContext) QueueAccountReview(reason string, evidence map[string]string) {
+	c.effects.QueueAccountReview(c.rule, reason, evidence)
+}
+
 func (c *RecordContext) AddRecordFlag(val string) {
+	c.effects.ruleFired(c.rule)
 	c.effects.AddRecordFlag(val)
 }
 
 func (c *RecordContext) AddRecordLabel(val string) {
+	c.effects.ruleFired(c.rule)
 	c.effects.AddRecordLabel(val)
 }
 
 func (c *RecordContext) ReportRecord(reason, comment string) {
+	c.effects.ruleFired(c.rule)
 	c.effects.ReportRecord(reason, comment)
 }
 
 func (c *RecordContext) TakedownRecord() {
+	c.effects.ruleFired(c.rule)
 	c.effects.TakedownRecord()
 }
 
+func (c *RecordContext) EscalateRecord(comment string) {
+	c.effects.ruleFired(c.rule)
+	c.effects.EscalateRecord(comment)
+}
+
+// Queues the record for human review. The name of the rule requesting review is recorded automatically.
+func (c *RecordContext) QueueRecordReview(reason string, evidence map[string]string) {
+	c.effects.QueueRecordReview(c.rule, reason, evidence)
+}
+
 func (c *RecordContext) TakedownBlob(cid string) {
+	c.effects.ruleFired(c.rule)
 	c.effects.TakedownBlob(cid)
 }
 
 func (c *NotificationContext) Reject() {
+	c.effects.ruleFired(c.rule)
 	c.effects.Reject()
 }
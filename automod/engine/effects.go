@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"sort"
 	"sync"
 	"time"
 )
@@ -26,6 +27,11 @@ type CounterDistinctRef struct {
 	Val    string
 }
 
+type DupeRef struct {
+	Fingerprint string
+	Member      string
+}
+
 // Mutable container for all the possible side-effects from rule execution.
 //
 // This single type tracks generic effects (eg, counter increments), account-level actions, and record-level actions (even for processing of account-level events which have no possible record-level effects).
@@ -36,6 +42,8 @@ type Effects struct {
 	CounterIncrements []CounterRef
 	// Similar to "CounterIncrements", but for "distinct" style counters
 	CounterDistinctIncrements []CounterDistinctRef // TODO: better variable names
+	// Content fingerprints to record in the engine's dupe store (if configured), persisted along with counters
+	DupeAdds []DupeRef
 	// Label values which should be applied to the overall account, as a result of rule execution.
 	AccountLabels []string
 	// Moderation flags (similar to labels, but private) which should be applied to the overall account, as a result of rule execution.
@@ -44,6 +52,8 @@ type Effects struct {
 	AccountReports []ModReport
 	// If "true", indicates that a rule indicates that the entire account should have a takedown.
 	AccountTakedown bool
+	// If non-empty, indicates that the account should be escalated for (human) moderator review, with this comment.
+	AccountEscalate string
 	// Same as "AccountLabels", but at record-level
 	RecordLabels []string
 	// Same as "AccountFlags", but at record-level
@@ -52,12 +62,35 @@ type Effects struct {
 	RecordReports []ModReport
 	// Same as "AccountTakedown", but at record-level
 	RecordTakedown bool
+	// Same as "AccountEscalate", but at record-level
+	RecordEscalate string
+	// Requests to add the account to the engine's human review queue (if configured)
+	AccountReviews []ReviewRequest
+	// Same as "AccountReviews", but at record-level
+	RecordReviews []ReviewRequest
 	// Set of Blob CIDs to takedown (eg, purge from CDN) when doing a record takedown
 	BlobTakedowns []string
 	// If "true", indicates that a rule indicates that the action causing the event should be blocked or prevented
 	RejectEvent bool
 	// Services, if any, which should blast out a notification about this even (eg, Slack)
 	NotifyServices []string
+	// Names of the rules which requested any of the above actions (not counting counter increments). Used for per-rule metrics and the audit log.
+	RulesFired []string
+}
+
+// Records that the named rule requested an action while processing this event.
+func (e *Effects) ruleFired(name string) {
+	if name == "" {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, v := range e.RulesFired {
+		if v == name {
+			return
+		}
+	}
+	e.RulesFired = append(e.RulesFired, name)
 }
 
 // Enqueues the named counter to be incremented at the end of all rule processing. Will automatically increment for all time periods.
@@ -84,6 +117,13 @@ func (e *Effects) IncrementDistinct(name, bucket, val string) {
 	e.CounterDistinctIncrements = append(e.CounterDistinctIncrements, CounterDistinctRef{Name: name, Bucket: bucket, Val: val})
 }
 
+// Enqueues a record that "member" posted content with the given fingerprint, to be persisted in the engine's dupe store (if configured) at the end of all rule processing.
+func (e *Effects) AddDupe(fingerprint, member string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.DupeAdds = append(e.DupeAdds, DupeRef{Fingerprint: fingerprint, Member: member})
+}
+
 // Enqueues the provided label (string value) to be added to the account at the end of rule processing.
 func (e *Effects) AddAccountLabel(val string) {
 	e.mu.Lock()
@@ -128,6 +168,24 @@ func (e *Effects) TakedownAccount() {
 	e.AccountTakedown = true
 }
 
+// Enqueues the account to be escalated for moderator review at the end of rule processing.
+func (e *Effects) EscalateAccount(comment string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if comment == "" {
+		comment = "(escalating without comment)"
+	}
+	e.AccountEscalate = comment
+}
+
+// Enqueues the account to be added to the human review queue at the end of rule processing. "rule" is the name of the requesting rule, and "evidence" is optional supporting context for reviewers.
+func (e *Effects) QueueAccountReview(rule, reason string, evidence map[string]string) {
+	e.mu.Lock()
+	e.AccountReviews = append(e.AccountReviews, ReviewRequest{Rule: rule, Reason: reason, Evidence: evidence})
+	e.mu.Unlock()
+	e.ruleFired(rule)
+}
+
 // Enqueues the provided label (string value) to be added to the record at the end of rule processing.
 func (e *Effects) AddRecordLabel(val string) {
 	e.mu.Lock()
@@ -172,6 +230,24 @@ func (e *Effects) TakedownRecord() {
 	e.RecordTakedown = true
 }
 
+// Enqueues the record to be escalated for moderator review at the end of rule processing.
+func (e *Effects) EscalateRecord(comment string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if comment == "" {
+		comment = "(escalating without comment)"
+	}
+	e.RecordEscalate = comment
+}
+
+// Enqueues the record to be added to the human review queue at the end of rule processing. See [Effects.QueueAccountReview].
+func (e *Effects) QueueRecordReview(rule, reason string, evidence map[string]string) {
+	e.mu.Lock()
+	e.RecordReviews = append(e.RecordReviews, ReviewRequest{Rule: rule, Reason: reason, Evidence: evidence})
+	e.mu.Unlock()
+	e.ruleFired(rule)
+}
+
 // Enqueues the blob CID to be taken down (aka, CDN purge) as part of any record takedown
 func (e *Effects) TakedownBlob(cid string) {
 	e.mu.Lock()
@@ -199,3 +275,51 @@ func (e *Effects) Notify(srv string) {
 func (e *Effects) Reject() {
 	e.RejectEvent = true
 }
+
+// Returns a sorted summary of all moderation actions, as short strings like "record-flag:spam" or "account-takedown". Counter increments are not included.
+func (e *Effects) Actions() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := []string{}
+	for _, v := range e.AccountLabels {
+		out = append(out, "account-label:"+v)
+	}
+	for _, v := range e.AccountFlags {
+		out = append(out, "account-flag:"+v)
+	}
+	for _, v := range e.AccountReports {
+		out = append(out, "account-report:"+ReasonShortName(v.ReasonType))
+	}
+	if e.AccountTakedown {
+		out = append(out, "account-takedown")
+	}
+	if e.AccountEscalate != "" {
+		out = append(out, "account-escalate")
+	}
+	if len(e.AccountReviews) > 0 {
+		out = append(out, "account-review")
+	}
+	for _, v := range e.RecordLabels {
+		out = append(out, "record-label:"+v)
+	}
+	for _, v := range e.RecordFlags {
+		out = append(out, "record-flag:"+v)
+	}
+	for _, v := range e.RecordReports {
+		out = append(out, "record-report:"+ReasonShortName(v.ReasonType))
+	}
+	if e.RecordTakedown {
+		out = append(out, "record-takedown")
+	}
+	if e.RecordEscalate != "" {
+		out = append(out, "record-escalate")
+	}
+	if len(e.RecordReviews) > 0 {
+		out = append(out, "record-review")
+	}
+	if e.RejectEvent {
+		out = append(out, "reject")
+	}
+	sort.Strings(out)
+	return out
+}
@@ -9,9 +9,13 @@ import (
 
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/auditlog"
 	"github.com/bluesky-social/indigo/automod/cachestore"
 	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/dupestore"
 	"github.com/bluesky-social/indigo/automod/flagstore"
+	"github.com/bluesky-social/indigo/automod/labeler"
+	"github.com/bluesky-social/indigo/automod/reviewqueue"
 	"github.com/bluesky-social/indigo/automod/setstore"
 	"github.com/bluesky-social/indigo/xrpc"
 )
@@ -33,16 +37,38 @@ type Engine struct {
 	Sets      setstore.SetStore
 	Cache     cachestore.CacheStore
 	Flags     flagstore.FlagStore
+	// sliding-window record of accounts posting near-duplicate content; optional (may be nil)
+	Dupes dupestore.DupeStore
 	// unlike the other sub-modules, this field (Notifier) may be nil
 	Notifier Notifier
+	// runtime-reloadable rule enablement, thresholds, and sets; optional (may be nil)
+	RuleConfig *RuleConfigStore
+	// de-duplication, escalation, and rate-limit policy for moderation actions; optional (may be nil). A policy in the RuleConfig takes precedence
+	Policy *ActionPolicy
 	// use to fetch public account metadata from AppView; no auth
 	BskyClient *xrpc.Client
-	// used to persist moderation actions in ozone moderation service; optional, admin auth
+	// used to persist moderation actions in ozone moderation service; optional, admin auth or service auth
 	OzoneClient *xrpc.Client
+	// persists subjects queued for human review; optional (may be nil)
+	ReviewQueue reviewqueue.ReviewQueue
+	// persists a record of every event where rules requested moderation actions; optional (may be nil)
+	AuditLog auditlog.AuditLog
+	// publishes labels directly, as a labeler service, in addition to persisting them in ozone; optional (may be nil)
+	Labeler *labeler.Labeler
+	// if true, new automod flags are also persisted to ozone as subject tags (with "automod:" prefix), so they are visible to human moderators
+	OzoneFlagTags bool
 	// used to fetch private account metadata from PDS or entryway; optional, admin auth
 	AdminClient *xrpc.Client
 	// used to fetch blobs from upstream PDS instances
 	BlobClient *http.Client
+	// PLC directory host (eg, "https://plc.directory"), used to determine account creation time from DID history; optional
+	PLCHost string
+	// HTTP client for PLC directory requests; if nil, http.DefaultClient is used
+	PLCClient *http.Client
+	// if true, rules are run and counters are persisted, but moderation actions (labels, flags, reports, takedowns, etc) are not. For simulation and rule development.
+	DryRun bool
+	// optional callback with the effects of every processed record event, after rule execution. Must not modify the effects.
+	RecordEffectsHook func(op RecordOp, eff *Effects)
 }
 
 // Entrypoint for external code pushing arbitrary identity events in to the engine.
@@ -90,7 +116,11 @@ func (eng *Engine) ProcessIdentityEvent(ctx context.Context, typ string, did syn
 		eventErrorCount.WithLabelValues("identity").Inc()
 		return fmt.Errorf("rule execution failed: %w", err)
 	}
+	if err := eng.applyActionPolicy(&ac, nil); err != nil {
+		ac.Logger.Error("failed to apply action policy", "err", err)
+	}
 	eng.CanonicalLogLineAccount(&ac)
+	eng.auditEvent(&ac, "identity", nil)
 	if err := eng.persistAccountModActions(&ac); err != nil {
 		eventErrorCount.WithLabelValues("identity").Inc()
 		return fmt.Errorf("failed to persist actions for identity event: %w", err)
@@ -158,7 +188,15 @@ func (eng *Engine) ProcessRecordOp(ctx context.Context, op RecordOp) error {
 		eventErrorCount.WithLabelValues("record").Inc()
 		return fmt.Errorf("unexpected op action: %s", op.Action)
 	}
+	if err := eng.applyActionPolicy(&rc.AccountContext, &rc.RecordOp); err != nil {
+		rc.Logger.Error("failed to apply action policy", "err", err)
+	}
+	eng.trackRecordSignals(&rc)
 	eng.CanonicalLogLineRecord(&rc)
+	eng.auditEvent(&rc.AccountContext, "record", &rc.RecordOp)
+	if eng.RecordEffectsHook != nil {
+		eng.RecordEffectsHook(op, rc.effects)
+	}
 	// purge the account meta cache when profile is updated
 	if rc.RecordOp.Collection == "app.bsky.actor.profile" {
 		if err := eng.PurgeAccountCaches(ctx, op.DID); err != nil {
@@ -231,6 +269,7 @@ func (eng *Engine) ProcessNotificationEvent(ctx context.Context, senderDID, reci
 		return false, fmt.Errorf("rule execution failed: %w", err)
 	}
 	eng.CanonicalLogLineNotification(&nc)
+	eng.auditEvent(&nc.AccountContext, "notif", nil)
 	return nc.effects.RejectEvent, nil
 }
 
@@ -251,6 +290,9 @@ func (e *Engine) CanonicalLogLineAccount(c *AccountContext) {
 		"accountFlags", c.effects.AccountFlags,
 		"accountTakedown", c.effects.AccountTakedown,
 		"accountReports", len(c.effects.AccountReports),
+		"accountEscalate", c.effects.AccountEscalate != "",
+		"accountReviews", len(c.effects.AccountReviews),
+		"rules", c.effects.RulesFired,
 	)
 }
 
@@ -264,6 +306,10 @@ func (e *Engine) CanonicalLogLineRecord(c *RecordContext) {
 		"recordFlags", c.effects.RecordFlags,
 		"recordTakedown", c.effects.RecordTakedown,
 		"recordReports", len(c.effects.RecordReports),
+		"recordEscalate", c.effects.RecordEscalate != "",
+		"recordReviews", len(c.effects.RecordReviews),
+		"scores", c.scores.all(),
+		"rules", c.effects.RulesFired,
 	)
 }
 
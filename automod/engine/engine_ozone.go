@@ -137,6 +137,7 @@ func NewOzoneEventContext(ctx context.Context, eng *Engine, eventView *toolsozon
 				Logger:  eng.Logger.With("eventID", evt.EventID, "ozoneEventType", evt.EventType, "creatorDID", evt.CreatedBy, "subjectDID", evt.SubjectDID),
 				engine:  eng,
 				effects: &Effects{},
+				scores:  &scoreSet{},
 			},
 			Account: *accountMeta,
 		},
@@ -185,7 +186,11 @@ func (eng *Engine) ProcessOzoneEvent(ctx context.Context, eventView *toolsozone.
 		return fmt.Errorf("ozone rule execution failed: %w", err)
 	}
 
+	if err := eng.applyActionPolicy(&ec.AccountContext, nil); err != nil {
+		ec.Logger.Error("failed to apply action policy", "err", err)
+	}
 	eng.CanonicalLogLineOzoneEvent(ec)
+	eng.auditEvent(&ec.AccountContext, "ozone", nil)
 
 	// some ozone events should result in account meta cache flushes
 	if (ec.Event.EventType == "takedown" || ec.Event.EventType == "reverseTakedown" || ec.Event.EventType == "label" || ec.Event.EventType == "tag") && ec.SubjectRecord == nil {
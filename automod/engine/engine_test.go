@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/data"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 
@@ -49,3 +50,52 @@ func TestEngineBasics(t *testing.T) {
 	op.RecordCBOR = p2cbor
 	assert.NoError(eng.ProcessRecordOp(ctx, op))
 }
+
+func TestCollectionRules(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	var listNames, thingNames []string
+	eng.Rules.AddCollectionRule(syntax.NSID("app.bsky.graph.list"), func(c *RecordContext, rec *DecodedRecord) error {
+		list, ok := rec.Typed.(*appbsky.GraphList)
+		assert.True(ok)
+		listNames = append(listNames, list.Name)
+		return nil
+	})
+	eng.Rules.AddCollectionRule(syntax.NSID("com.example.thing"), func(c *RecordContext, rec *DecodedRecord) error {
+		assert.Nil(rec.Typed)
+		thingNames = append(thingNames, rec.StringField("name"))
+		return nil
+	})
+
+	cid1 := syntax.CID("cid123")
+	purpose := "app.bsky.graph.defs#curatelist"
+	l1 := appbsky.GraphList{
+		Name:    "some list",
+		Purpose: &purpose,
+	}
+	l1buf := new(bytes.Buffer)
+	assert.NoError(l1.MarshalCBOR(l1buf))
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: syntax.NSID("app.bsky.graph.list"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid1,
+		RecordCBOR: l1buf.Bytes(),
+	}
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+
+	thingCBOR, err := data.MarshalCBOR(map[string]any{
+		"$type": "com.example.thing",
+		"name":  "some thing",
+	})
+	assert.NoError(err)
+	op.Collection = syntax.NSID("com.example.thing")
+	op.RecordCBOR = thingCBOR
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+
+	assert.Equal([]string{"some list"}, listNames)
+	assert.Equal([]string{"some thing"}, thingNames)
+}
@@ -122,6 +122,9 @@ func (e *Engine) GetAccountMeta(ctx context.Context, ident *identity.Identity) (
 			if rd.EmailConfirmedAt != nil && *rd.EmailConfirmedAt != "" {
 				ap.EmailConfirmed = true
 			}
+			if rd.InvitedBy != nil {
+				ap.InvitedBy = rd.InvitedBy.CreatedBy
+			}
 			// TODO: ozone doesn't really return good account "created at", just just leave that field nil
 			ap.IndexedAt = nil
 			if rd.DeactivatedAt != nil {
@@ -149,6 +152,9 @@ func (e *Engine) GetAccountMeta(ctx context.Context, ident *identity.Identity) (
 			if pv.EmailConfirmedAt != nil && *pv.EmailConfirmedAt != "" {
 				ap.EmailConfirmed = true
 			}
+			if pv.InvitedBy != nil {
+				ap.InvitedBy = pv.InvitedBy.CreatedBy
+			}
 			ts, err := syntax.ParseDatetimeTime(pv.IndexedAt)
 			if err != nil {
 				return nil, fmt.Errorf("bad entryway account IndexedAt: %w", err)
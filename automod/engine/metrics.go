@@ -40,6 +40,52 @@ var actionNewTakedownCount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Help: "Number of new flags persisted",
 }, []string{"type"})
 
+var actionNewEscalateCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_new_action_escalations",
+	Help: "Number of new escalations persisted",
+}, []string{"type"})
+
+var actionNewReviewCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_new_action_reviews",
+	Help: "Number of new items added to the human review queue",
+}, []string{"type"})
+
+var ruleEvalCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_rule_evaluations",
+	Help: "Number of times each rule was run, by result",
+}, []string{"rule", "result"})
+
+var ruleEvalDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "automod_rule_duration_sec",
+	Help:    "Duration of individual rule executions",
+	Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+}, []string{"rule"})
+
+var ruleHitCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_rule_hits",
+	Help: "Number of events for which each rule requested a moderation action",
+}, []string{"rule"})
+
+var auditEntryCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_audit_entries",
+	Help: "Number of decision audit log entries written, by result",
+}, []string{"result"})
+
+var policyLadderCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_policy_ladder_steps",
+	Help: "Number of escalation ladder steps applied, by ladder and action",
+}, []string{"ladder", "action"})
+
+var policySuppressedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_policy_suppressed_actions",
+	Help: "Number of moderation actions dropped by the action policy, by reason (dedupe or ratelimit) and action",
+}, []string{"reason", "action"})
+
+var webhookNotifyCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_webhook_notifications",
+	Help: "Number of webhook notifications, by target and result (sent, error, ratelimited)",
+}, []string{"target", "result"})
+
 var accountMetaFetches = promauto.NewCounter(prometheus.CounterOpts{
 	Name: "automod_account_meta_fetches",
 	Help: "Number of account metadata reads (API calls)",
@@ -50,6 +96,11 @@ var accountRelationshipFetches = promauto.NewCounter(prometheus.CounterOpts{
 	Help: "Number of account relationship reads (API calls)",
 })
 
+var plcAuditFetches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_plc_audit_fetches",
+	Help: "Number of PLC directory audit log fetches (for account creation time), by HTTP status code",
+}, []string{"status"})
+
 var blobDownloadCount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "automod_blob_downloads",
 	Help: "Number of blobs downloaded, by HTTP status code",
@@ -59,3 +110,8 @@ var blobDownloadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 	Name: "automod_blob_download_duration_sec",
 	Help: "Duration of blob download attempts",
 })
+
+var ruleConfigReloadCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_rule_config_reloads",
+	Help: "Number of rule config reload attempts, by result",
+}, []string{"result"})
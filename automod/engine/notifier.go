@@ -9,3 +9,18 @@ type Notifier interface {
 	SendAccount(ctx context.Context, service string, c *AccountContext) error
 	SendRecord(ctx context.Context, service string, c *RecordContext) error
 }
+
+// Optional interface for notifiers which should be sent notifications for events where specific rules fired, even if the rule didn't request a notification.
+type RuleSubscriber interface {
+	// Returns the services (if any) which should be notified, given the names of rules which fired
+	SubscribedServices(rules []string) []string
+}
+
+// services to notify for an event: those requested by rules, plus any subscribed to the rules which fired
+func (eng *Engine) notifyServices(eff *Effects) []string {
+	services := eff.NotifyServices
+	if rs, ok := eng.Notifier.(RuleSubscriber); ok && len(eff.RulesFired) > 0 {
+		services = append(append([]string{}, services...), rs.SubscribedServices(eff.RulesFired)...)
+	}
+	return dedupeStrings(services)
+}
@@ -3,9 +3,11 @@ package engine
 import (
 	"context"
 	"fmt"
+	"time"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	toolsozone "github.com/bluesky-social/indigo/api/ozone"
+	"github.com/bluesky-social/indigo/automod/reviewqueue"
 )
 
 func (eng *Engine) persistCounters(ctx context.Context, eff *Effects) error {
@@ -29,6 +31,14 @@ func (eng *Engine) persistCounters(ctx context.Context, eff *Effects) error {
 			return err
 		}
 	}
+	if eng.Dupes != nil {
+		now := time.Now()
+		for _, ref := range eff.DupeAdds {
+			if err := eng.Dupes.Add(ctx, ref.Fingerprint, ref.Member, now); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -39,6 +49,9 @@ func (eng *Engine) persistCounters(ctx context.Context, eff *Effects) error {
 // Note that this method expects to run *before* counts are persisted (it accesses and updates some counts)
 func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 	ctx := c.Ctx
+	if eng.DryRun {
+		return nil
+	}
 
 	// de-dupe actions
 	newLabels := dedupeLabelActions(c.effects.AccountLabels, c.Account.AccountLabels, c.Account.AccountNegatedLabels)
@@ -57,10 +70,20 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 	if err != nil {
 		return fmt.Errorf("circuit-breaking takedowns: %w", err)
 	}
+	newEscalate, err := eng.dedupeEscalation(ctx, c.Account.Identity.DID.String(), c.effects.AccountEscalate)
+	if err != nil {
+		return fmt.Errorf("de-duplicating escalation: %w", err)
+	}
+	newReview, err := eng.enqueueReview(ctx, "account", reviewqueue.ReviewItem{
+		SubjectDID: c.Account.Identity.DID.String(),
+	}, c.effects.AccountReviews, c.effects)
+	if err != nil {
+		c.Logger.Error("failed to queue account for review", "err", err)
+	}
 
-	anyModActions := newTakedown || len(newLabels) > 0 || len(newFlags) > 0 || len(newReports) > 0
+	anyModActions := newTakedown || newEscalate || newReview || len(newLabels) > 0 || len(newFlags) > 0 || len(newReports) > 0
 	if anyModActions && eng.Notifier != nil {
-		for _, srv := range dedupeStrings(c.effects.NotifyServices) {
+		for _, srv := range eng.notifyServices(c.effects) {
 			if err := eng.Notifier.SendAccount(ctx, srv, c); err != nil {
 				c.Logger.Error("failed to deliver notification", "service", srv, "err", err)
 			}
@@ -76,6 +99,12 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 		eng.Flags.Add(ctx, c.Account.Identity.DID.String(), newFlags)
 	}
 
+	if len(newLabels) > 0 && eng.Labeler != nil {
+		if _, err := eng.Labeler.CreateLabels(ctx, c.Account.Identity.DID.String(), nil, newLabels); err != nil {
+			c.Logger.Error("failed to publish account labels", "err", err)
+		}
+	}
+
 	// if we can't actually talk to service, bail out early
 	if eng.OzoneClient == nil {
 		if anyModActions {
@@ -85,6 +114,24 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 	}
 
 	xrpcc := eng.OzoneClient
+	acctSubject := &toolsozone.ModerationEmitEvent_Input_Subject{
+		AdminDefs_RepoRef: &comatproto.AdminDefs_RepoRef{
+			Did: c.Account.Identity.DID.String(),
+		},
+	}
+
+	if len(newFlags) > 0 && eng.OzoneFlagTags {
+		if err := eng.emitFlagTags(ctx, xrpcc, acctSubject, newFlags); err != nil {
+			c.Logger.Error("failed to tag account with flags", "err", err)
+		}
+	}
+
+	if newEscalate {
+		c.Logger.Info("escalating account", "comment", c.effects.AccountEscalate)
+		if err := eng.emitEscalation(ctx, xrpcc, acctSubject, "account", c.effects.AccountEscalate); err != nil {
+			c.Logger.Error("failed to escalate account", "err", err)
+		}
+	}
 
 	if len(newLabels) > 0 {
 		c.Logger.Info("labeling record", "newLabels", newLabels)
@@ -160,6 +207,9 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 // NOTE: this method currently does *not* persist record-level flags to any storage, and does not de-dupe most actions, on the assumption that the record is new (from firehose) and has no existing mod state.
 func (eng *Engine) persistRecordModActions(c *RecordContext) error {
 	ctx := c.Ctx
+	if eng.DryRun {
+		return nil
+	}
 	if err := eng.persistAccountModActions(&c.AccountContext); err != nil {
 		return err
 	}
@@ -210,10 +260,25 @@ func (eng *Engine) persistRecordModActions(c *RecordContext) error {
 	if err != nil {
 		return fmt.Errorf("failed to circuit break takedowns: %w", err)
 	}
+	newEscalate, err := eng.dedupeEscalation(ctx, atURI, c.effects.RecordEscalate)
+	if err != nil {
+		return fmt.Errorf("de-duplicating escalation: %w", err)
+	}
+	reviewItem := reviewqueue.ReviewItem{
+		SubjectDID: c.RecordOp.DID.String(),
+		SubjectURI: atURI,
+	}
+	if c.RecordOp.CID != nil {
+		reviewItem.SubjectCID = c.RecordOp.CID.String()
+	}
+	newReview, err := eng.enqueueReview(ctx, "record", reviewItem, c.effects.RecordReviews, c.effects)
+	if err != nil {
+		c.Logger.Error("failed to queue record for review", "err", err)
+	}
 
-	if newTakedown || len(newLabels) > 0 || len(newFlags) > 0 || len(newReports) > 0 {
+	if newTakedown || newEscalate || newReview || len(newLabels) > 0 || len(newFlags) > 0 || len(newReports) > 0 {
 		if eng.Notifier != nil {
-			for _, srv := range dedupeStrings(c.effects.NotifyServices) {
+			for _, srv := range eng.notifyServices(c.effects) {
 				if err := eng.Notifier.SendRecord(ctx, srv, c); err != nil {
 					c.Logger.Error("failed to deliver notification", "service", srv, "err", err)
 				}
@@ -230,8 +295,19 @@ func (eng *Engine) persistRecordModActions(c *RecordContext) error {
 		eng.Flags.Add(ctx, atURI, newFlags)
 	}
 
+	if len(newLabels) > 0 && eng.Labeler != nil {
+		var cid *string
+		if c.RecordOp.CID != nil {
+			cidStr := c.RecordOp.CID.String()
+			cid = &cidStr
+		}
+		if _, err := eng.Labeler.CreateLabels(ctx, atURI, cid, newLabels); err != nil {
+			c.Logger.Error("failed to publish record labels", "err", err)
+		}
+	}
+
 	// exit early
-	if !newTakedown && len(newLabels) == 0 && len(newReports) == 0 {
+	if !newTakedown && !newEscalate && len(newLabels) == 0 && len(newReports) == 0 && !(eng.OzoneFlagTags && len(newFlags) > 0) {
 		return nil
 	}
 
@@ -251,6 +327,23 @@ func (eng *Engine) persistRecordModActions(c *RecordContext) error {
 	}
 
 	xrpcc := eng.OzoneClient
+	recordSubject := &toolsozone.ModerationEmitEvent_Input_Subject{
+		RepoStrongRef: &strongRef,
+	}
+
+	if len(newFlags) > 0 && eng.OzoneFlagTags {
+		if err := eng.emitFlagTags(ctx, xrpcc, recordSubject, newFlags); err != nil {
+			c.Logger.Error("failed to tag record with flags", "err", err)
+		}
+	}
+
+	if newEscalate {
+		c.Logger.Info("escalating record", "comment", c.effects.RecordEscalate)
+		if err := eng.emitEscalation(ctx, xrpcc, recordSubject, "record", c.effects.RecordEscalate); err != nil {
+			c.Logger.Error("failed to escalate record", "err", err)
+		}
+	}
+
 	if len(newLabels) > 0 {
 		c.Logger.Info("labeling record", "newLabels", newLabels)
 		for _, val := range newLabels {
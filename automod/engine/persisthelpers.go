@@ -2,13 +2,16 @@ package engine
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	toolsozone "github.com/bluesky-social/indigo/api/ozone"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/reviewqueue"
 	"github.com/bluesky-social/indigo/xrpc"
 )
 
@@ -260,3 +263,106 @@ func (eng *Engine) createRecordReportIfFresh(ctx context.Context, xrpcc *xrpc.Cl
 	}
 	return true, nil
 }
+
+// Checks a per-subject counter to avoid escalating the same subject more than once per de-dupe period, and increments it if not.
+//
+// Returns a bool indicating if the escalation should proceed.
+func (eng *Engine) dedupeEscalation(ctx context.Context, subject string, comment string) (bool, error) {
+	if comment == "" {
+		return false, nil
+	}
+	existing, err := eng.Counters.GetCount(ctx, "automod-escalate", subject, countstore.PeriodDay)
+	if err != nil {
+		return false, fmt.Errorf("checking escalation de-dupe counts: %w", err)
+	}
+	if existing > 0 {
+		eng.Logger.Debug("skipping escalation due to counter", "subject", subject)
+		return false, nil
+	}
+	if err := eng.Counters.Increment(ctx, "automod-escalate", subject); err != nil {
+		return false, fmt.Errorf("incrementing escalation de-dupe count: %w", err)
+	}
+	return true, nil
+}
+
+// Emits a tools.ozone.moderation escalation event for the subject.
+func (eng *Engine) emitEscalation(ctx context.Context, xrpcc *xrpc.Client, subject *toolsozone.ModerationEmitEvent_Input_Subject, kind, comment string) error {
+	actionNewEscalateCount.WithLabelValues(kind).Inc()
+	comment = "[automod]: " + comment
+	_, err := toolsozone.ModerationEmitEvent(ctx, xrpcc, &toolsozone.ModerationEmitEvent_Input{
+		CreatedBy: xrpcc.Auth.Did,
+		Event: &toolsozone.ModerationEmitEvent_Input_Event{
+			ModerationDefs_ModEventEscalate: &toolsozone.ModerationDefs_ModEventEscalate{
+				Comment: &comment,
+			},
+		},
+		Subject: subject,
+	})
+	return err
+}
+
+// Emits a tools.ozone.moderation tag event, mirroring new automod flags as "automod:"-prefixed subject tags.
+func (eng *Engine) emitFlagTags(ctx context.Context, xrpcc *xrpc.Client, subject *toolsozone.ModerationEmitEvent_Input_Subject, flags []string) error {
+	tags := make([]string, len(flags))
+	for i, f := range flags {
+		tags[i] = "automod:" + f
+	}
+	comment := "[automod]: flagged"
+	_, err := toolsozone.ModerationEmitEvent(ctx, xrpcc, &toolsozone.ModerationEmitEvent_Input{
+		CreatedBy: xrpcc.Auth.Did,
+		Event: &toolsozone.ModerationEmitEvent_Input_Event{
+			ModerationDefs_ModEventTag: &toolsozone.ModerationDefs_ModEventTag{
+				Add:     tags,
+				Remove:  []string{},
+				Comment: &comment,
+			},
+		},
+		Subject: subject,
+	})
+	return err
+}
+
+// Adds the subject to the human review queue, if any rules requested review and the queue is configured. Evidence from all requesting rules is combined, along with the flags, labels, and reports from this event.
+//
+// Returns a bool indicating if a new queue item was created (the queue itself de-dupes against unresolved items for the same subject).
+func (eng *Engine) enqueueReview(ctx context.Context, kind string, item reviewqueue.ReviewItem, reqs []ReviewRequest, eff *Effects) (bool, error) {
+	if len(reqs) == 0 || eng.ReviewQueue == nil {
+		return false, nil
+	}
+	rules := []string{}
+	reasons := []string{}
+	for _, r := range reqs {
+		rules = append(rules, r.Rule)
+		if r.Reason != "" {
+			reasons = append(reasons, r.Reason)
+		}
+	}
+	evidence := map[string]any{
+		"requests": reqs,
+	}
+	if kind == "account" {
+		evidence["flags"] = eff.AccountFlags
+		evidence["labels"] = eff.AccountLabels
+		evidence["reports"] = eff.AccountReports
+	} else {
+		evidence["flags"] = eff.RecordFlags
+		evidence["labels"] = eff.RecordLabels
+		evidence["reports"] = eff.RecordReports
+	}
+	evidenceJSON, err := json.Marshal(evidence)
+	if err != nil {
+		return false, fmt.Errorf("encoding review evidence: %w", err)
+	}
+	item.Rules = strings.Join(dedupeStrings(rules), ",")
+	item.Reason = strings.Join(dedupeStrings(reasons), "; ")
+	item.Evidence = string(evidenceJSON)
+
+	created, err := eng.ReviewQueue.Enqueue(ctx, &item)
+	if err != nil {
+		return false, err
+	}
+	if created {
+		actionNewReviewCount.WithLabelValues(kind).Inc()
+	}
+	return created, nil
+}
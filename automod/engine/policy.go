@@ -0,0 +1,361 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/automod/countstore"
+)
+
+// Action types, as used in [ActionPolicy] rate limits and [EscalationStep] actions
+const (
+	ActionLabel    = "label"
+	ActionFlag     = "flag"
+	ActionReport   = "report"
+	ActionEscalate = "escalate"
+	ActionTakedown = "takedown"
+)
+
+// Policy applied to the moderation actions requested by rules, after rule execution and before actions are persisted.
+//
+// The policy can be configured statically on the [Engine], or as part of the runtime-reloadable [RuleConfig] (which takes precedence).
+type ActionPolicy struct {
+	// If set ("hour" or "day"), identical actions (same type and value) against the same subject are only applied once per time period, even if rules request them repeatedly
+	DedupePeriod string `json:"dedupePeriod,omitempty"`
+	// Escalation ladders, based on repeat offenses by the same account
+	Ladders []EscalationLadder `json:"ladders,omitempty"`
+	// Max number of actions of each type (eg, "label", "takedown"; see the Action* constants) per hour, across all subjects. Actions beyond the limit are dropped.
+	HourlyLimits map[string]int `json:"hourlyLimits,omitempty"`
+}
+
+// Escalating response to repeat offenses by an account. Each time any rule adds the ladder's flag (to the account, or to a record by the account), that counts as an offense. The action for the highest step reached (by number of offenses within the period) is applied to the account.
+type EscalationLadder struct {
+	// Short name for this ladder, used in counter names, metrics, and comments
+	Name string `json:"name"`
+	// Account or record flag value which counts as an offense
+	Flag string `json:"flag"`
+	// Time period for counting offenses: "hour", "day", or "total". Defaults to "day".
+	Period string           `json:"period,omitempty"`
+	Steps  []EscalationStep `json:"steps"`
+}
+
+type EscalationStep struct {
+	// Minimum number of offenses within the ladder's period for this step to apply
+	MinOffenses int `json:"minOffenses"`
+	// One of "label", "report", "escalate" (request human review, eg for a takedown), or "takedown"
+	Action string `json:"action"`
+	// Label value for "label" steps, or report reason type for "report" steps (eg, "com.atproto.moderation.defs#reasonSpam"; defaults to "reasonOther")
+	Value string `json:"value,omitempty"`
+}
+
+// Validates the policy, returning an error for unknown actions or periods.
+func (p *ActionPolicy) Validate() error {
+	switch p.DedupePeriod {
+	case "", countstore.PeriodHour, countstore.PeriodDay:
+	default:
+		return fmt.Errorf("invalid policy dedupe period: %s", p.DedupePeriod)
+	}
+	for _, l := range p.Ladders {
+		if l.Name == "" || l.Flag == "" {
+			return fmt.Errorf("escalation ladder must have name and flag")
+		}
+		switch l.Period {
+		case "", countstore.PeriodHour, countstore.PeriodDay, countstore.PeriodTotal:
+		default:
+			return fmt.Errorf("invalid escalation ladder period: %s", l.Period)
+		}
+		for _, s := range l.Steps {
+			switch s.Action {
+			case ActionLabel:
+				if s.Value == "" {
+					return fmt.Errorf("escalation ladder %s: label step requires a value", l.Name)
+				}
+			case ActionReport, ActionEscalate, ActionTakedown:
+			default:
+				return fmt.Errorf("escalation ladder %s: unsupported step action: %s", l.Name, s.Action)
+			}
+		}
+	}
+	for action := range p.HourlyLimits {
+		switch action {
+		case ActionLabel, ActionFlag, ActionReport, ActionEscalate, ActionTakedown:
+		default:
+			return fmt.Errorf("unknown action type for hourly limit: %s", action)
+		}
+	}
+	return nil
+}
+
+// returns the active policy: from the rule config if set, otherwise the static engine policy (which may be nil)
+func (eng *Engine) actionPolicy() *ActionPolicy {
+	if rc := eng.RuleConfig.Current(); rc != nil && rc.Policy != nil {
+		return rc.Policy
+	}
+	return eng.Policy
+}
+
+// Applies the engine's action policy (if any) to the effects of an event, in place: first escalation ladders (which may add account-level actions), then de-duplication, then rate limits.
+//
+// This must be called after all rules have run. "op" is nil for account-level events.
+func (eng *Engine) applyActionPolicy(c *AccountContext, op *RecordOp) error {
+	p := eng.actionPolicy()
+	if p == nil {
+		return nil
+	}
+	ctx := c.Ctx
+	eff := c.effects
+	did := c.Account.Identity.DID.String()
+
+	for _, l := range p.Ladders {
+		if err := eng.applyLadder(ctx, c, l); err != nil {
+			return err
+		}
+	}
+
+	if p.DedupePeriod != "" {
+		var err error
+		if eff.AccountLabels, err = eng.filterFresh(ctx, p.DedupePeriod, did, ActionLabel, eff.AccountLabels); err != nil {
+			return err
+		}
+		if eff.AccountTakedown, err = eng.filterFreshBool(ctx, p.DedupePeriod, did, ActionTakedown, eff.AccountTakedown); err != nil {
+			return err
+		}
+		if eff.AccountEscalate != "" {
+			ok, err := eng.filterFreshBool(ctx, p.DedupePeriod, did, ActionEscalate, true)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				eff.AccountEscalate = ""
+			}
+		}
+		if op != nil {
+			uri := op.ATURI().String()
+			if eff.RecordLabels, err = eng.filterFresh(ctx, p.DedupePeriod, uri, ActionLabel, eff.RecordLabels); err != nil {
+				return err
+			}
+			if eff.RecordTakedown, err = eng.filterFreshBool(ctx, p.DedupePeriod, uri, ActionTakedown, eff.RecordTakedown); err != nil {
+				return err
+			}
+			if eff.RecordEscalate != "" {
+				ok, err := eng.filterFreshBool(ctx, p.DedupePeriod, uri, ActionEscalate, true)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					eff.RecordEscalate = ""
+				}
+			}
+		}
+	}
+
+	for action, limit := range p.HourlyLimits {
+		if err := eng.applyRateLimit(ctx, c, action, limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (eng *Engine) applyLadder(ctx context.Context, c *AccountContext, l EscalationLadder) error {
+	eff := c.effects
+	offense := false
+	for _, flags := range [][]string{eff.AccountFlags, eff.RecordFlags} {
+		for _, f := range flags {
+			if f == l.Flag {
+				offense = true
+			}
+		}
+	}
+	if !offense {
+		return nil
+	}
+	period := l.Period
+	if period == "" {
+		period = countstore.PeriodDay
+	}
+	did := c.Account.Identity.DID.String()
+	counterName := "automod-ladder-" + l.Name
+	// increment immediately (instead of at end of event processing), so the current offense is counted
+	if err := eng.Counters.Increment(ctx, counterName, did); err != nil {
+		return fmt.Errorf("incrementing escalation ladder count: %w", err)
+	}
+	offenses, err := eng.Counters.GetCount(ctx, counterName, did, period)
+	if err != nil {
+		return fmt.Errorf("checking escalation ladder count: %w", err)
+	}
+
+	var step *EscalationStep
+	for i, s := range l.Steps {
+		if offenses >= s.MinOffenses && (step == nil || s.MinOffenses > step.MinOffenses) {
+			step = &l.Steps[i]
+		}
+	}
+	if step == nil {
+		return nil
+	}
+
+	comment := fmt.Sprintf("[automod]: escalation ladder %s reached %d offenses (%s)", l.Name, offenses, period)
+	c.Logger.Info("escalation ladder step", "ladder", l.Name, "offenses", offenses, "action", step.Action)
+	policyLadderCount.WithLabelValues(l.Name, step.Action).Inc()
+	switch step.Action {
+	case ActionLabel:
+		eff.AddAccountLabel(step.Value)
+	case ActionReport:
+		reason := step.Value
+		if reason == "" {
+			reason = ReportReasonOther
+		}
+		eff.ReportAccount(reason, comment)
+	case ActionEscalate:
+		eff.EscalateAccount(comment)
+	case ActionTakedown:
+		eff.TakedownAccount()
+	}
+	eff.ruleFired("policy/" + l.Name)
+	return nil
+}
+
+// checks (and marks) whether the given action has already been applied to the subject within the period
+func (eng *Engine) actionFresh(ctx context.Context, period, subject, action string) (bool, error) {
+	key := subject + " " + action
+	existing, err := eng.Counters.GetCount(ctx, "automod-policy-dedupe", key, period)
+	if err != nil {
+		return false, fmt.Errorf("checking action de-dupe count: %w", err)
+	}
+	if existing > 0 {
+		policySuppressedCount.WithLabelValues("dedupe", action).Inc()
+		return false, nil
+	}
+	if err := eng.Counters.IncrementPeriod(ctx, "automod-policy-dedupe", key, period); err != nil {
+		return false, fmt.Errorf("incrementing action de-dupe count: %w", err)
+	}
+	return true, nil
+}
+
+func (eng *Engine) filterFresh(ctx context.Context, period, subject, action string, vals []string) ([]string, error) {
+	if len(vals) == 0 {
+		return vals, nil
+	}
+	out := []string{}
+	for _, v := range vals {
+		ok, err := eng.actionFresh(ctx, period, subject, action+":"+v)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+func (eng *Engine) filterFreshBool(ctx context.Context, period, subject, action string, val bool) (bool, error) {
+	if !val {
+		return false, nil
+	}
+	return eng.actionFresh(ctx, period, subject, action)
+}
+
+// drops actions of the given type beyond the hourly limit
+func (eng *Engine) applyRateLimit(ctx context.Context, c *AccountContext, action string, limit int) error {
+	eff := c.effects
+	requested := 0
+	switch action {
+	case ActionLabel:
+		requested = len(eff.AccountLabels) + len(eff.RecordLabels)
+	case ActionFlag:
+		requested = len(eff.AccountFlags) + len(eff.RecordFlags)
+	case ActionReport:
+		requested = len(eff.AccountReports) + len(eff.RecordReports)
+	case ActionEscalate:
+		if eff.AccountEscalate != "" {
+			requested++
+		}
+		if eff.RecordEscalate != "" {
+			requested++
+		}
+	case ActionTakedown:
+		if eff.AccountTakedown {
+			requested++
+		}
+		if eff.RecordTakedown {
+			requested++
+		}
+	}
+	if requested == 0 {
+		return nil
+	}
+	current, err := eng.Counters.GetCount(ctx, "automod-policy-rate", action, countstore.PeriodHour)
+	if err != nil {
+		return fmt.Errorf("checking action rate limit: %w", err)
+	}
+	allowed := limit - current
+	if allowed < 0 {
+		allowed = 0
+	}
+	if allowed < requested {
+		c.Logger.Warn("action rate limit exceeded, dropping actions", "action", action, "limit", limit, "requested", requested, "allowed", allowed)
+		policySuppressedCount.WithLabelValues("ratelimit", action).Add(float64(requested - allowed))
+		eff.truncateActions(action, allowed)
+		requested = allowed
+	}
+	for i := 0; i < requested; i++ {
+		if err := eng.Counters.IncrementPeriod(ctx, "automod-policy-rate", action, countstore.PeriodHour); err != nil {
+			return fmt.Errorf("incrementing action rate limit: %w", err)
+		}
+	}
+	return nil
+}
+
+// keeps at most "n" actions of the given type, preferring account-level actions
+func (e *Effects) truncateActions(action string, n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch action {
+	case ActionLabel:
+		e.AccountLabels, n = truncateStrings(e.AccountLabels, n)
+		e.RecordLabels, _ = truncateStrings(e.RecordLabels, n)
+	case ActionFlag:
+		e.AccountFlags, n = truncateStrings(e.AccountFlags, n)
+		e.RecordFlags, _ = truncateStrings(e.RecordFlags, n)
+	case ActionReport:
+		if len(e.AccountReports) > n {
+			e.AccountReports = e.AccountReports[:n]
+		}
+		n -= len(e.AccountReports)
+		if len(e.RecordReports) > n {
+			e.RecordReports = e.RecordReports[:n]
+		}
+	case ActionEscalate:
+		if e.AccountEscalate != "" {
+			if n > 0 {
+				n--
+			} else {
+				e.AccountEscalate = ""
+			}
+		}
+		if e.RecordEscalate != "" && n == 0 {
+			e.RecordEscalate = ""
+		}
+	case ActionTakedown:
+		if e.AccountTakedown {
+			if n > 0 {
+				n--
+			} else {
+				e.AccountTakedown = false
+			}
+		}
+		if e.RecordTakedown && n == 0 {
+			e.RecordTakedown = false
+		}
+	}
+}
+
+// returns the first "n" strings, and the remaining count
+func truncateStrings(vals []string, n int) ([]string, int) {
+	if len(vals) > n {
+		return vals[:n], 0
+	}
+	return vals, n - len(vals)
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func spammyFlagRule(c *RecordContext) error {
+	c.AddRecordFlag("spammy")
+	c.AddRecordLabel("spam")
+	return nil
+}
+
+func policyTestOp(t *testing.T, rkey string) RecordOp {
+	cid1 := syntax.CID("cid123")
+	p1 := appbsky.FeedPost{Text: "some post blah"}
+	p1buf := new(bytes.Buffer)
+	if err := p1.MarshalCBOR(p1buf); err != nil {
+		t.Fatal(err)
+	}
+	return RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: "app.bsky.feed.post",
+		RecordKey:  syntax.RecordKey(rkey),
+		CID:        &cid1,
+		RecordCBOR: p1buf.Bytes(),
+	}
+}
+
+func TestEscalationLadder(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	eng.Rules = RuleSet{
+		RecordRules: []RecordRuleFunc{
+			spammyFlagRule,
+		},
+	}
+	eng.Policy = &ActionPolicy{
+		Ladders: []EscalationLadder{
+			{
+				Name: "spam",
+				Flag: "spammy",
+				Steps: []EscalationStep{
+					{MinOffenses: 2, Action: ActionLabel, Value: "spam-account"},
+					{MinOffenses: 3, Action: ActionReport, Value: ReportReasonSpam},
+					{MinOffenses: 4, Action: ActionEscalate},
+				},
+			},
+		},
+	}
+	assert.NoError(eng.Policy.Validate())
+
+	var effects []*Effects
+	eng.RecordEffectsHook = func(op RecordOp, eff *Effects) {
+		effects = append(effects, eff)
+	}
+	for i := 0; i < 4; i++ {
+		assert.NoError(eng.ProcessRecordOp(ctx, policyTestOp(t, fmt.Sprintf("abc%d", i))))
+	}
+
+	assert.Equal(4, len(effects))
+	assert.Empty(effects[0].AccountLabels)
+	assert.Equal([]string{"spam-account"}, effects[1].AccountLabels)
+	assert.Contains(effects[1].RulesFired, "policy/spam")
+	assert.Equal(1, len(effects[2].AccountReports))
+	assert.Equal(ReportReasonSpam, effects[2].AccountReports[0].ReasonType)
+	assert.NotEmpty(effects[3].AccountEscalate)
+}
+
+func TestPolicyDedupeAndRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	eng.Rules = RuleSet{
+		RecordRules: []RecordRuleFunc{
+			spammyFlagRule,
+		},
+	}
+	eng.Policy = &ActionPolicy{
+		DedupePeriod: "day",
+		HourlyLimits: map[string]int{
+			ActionFlag: 2,
+		},
+	}
+	assert.NoError(eng.Policy.Validate())
+
+	var effects []*Effects
+	eng.RecordEffectsHook = func(op RecordOp, eff *Effects) {
+		effects = append(effects, eff)
+	}
+	// same record twice, then a different record
+	assert.NoError(eng.ProcessRecordOp(ctx, policyTestOp(t, "abc1")))
+	assert.NoError(eng.ProcessRecordOp(ctx, policyTestOp(t, "abc1")))
+	assert.NoError(eng.ProcessRecordOp(ctx, policyTestOp(t, "abc2")))
+
+	assert.Equal(3, len(effects))
+	assert.Equal([]string{"spam"}, effects[0].RecordLabels)
+	// identical label on the same record is de-duplicated
+	assert.Empty(effects[1].RecordLabels)
+	assert.Equal([]string{"spam"}, effects[2].RecordLabels)
+
+	// only two flags allowed per hour
+	assert.Equal([]string{"spammy"}, effects[0].RecordFlags)
+	assert.Equal([]string{"spammy"}, effects[1].RecordFlags)
+	assert.Empty(effects[2].RecordFlags)
+
+	assert.Error((&ActionPolicy{HourlyLimits: map[string]int{"bogus": 1}}).Validate())
+	assert.Error((&ActionPolicy{Ladders: []EscalationLadder{{Name: "x", Flag: "y", Steps: []EscalationStep{{Action: ActionLabel}}}}}).Validate())
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runtime-adjustable rule configuration: which rules are enabled, numeric thresholds, and keyword sets.
+//
+// This is loaded from a JSON file or remote HTTP endpoint, and can be swapped out while the engine is running (see [RuleConfigStore]).
+type RuleConfig struct {
+	// Opaque version string, reported back by admin endpoints to identify the active ruleset
+	Version string `json:"version"`
+	// Names of rule functions (eg, "BadWordPostRule") which should be skipped during dispatch
+	DisabledRules []string `json:"disabledRules,omitempty"`
+	// Named numeric thresholds, looked up by rules with [BaseContext.Threshold]
+	Thresholds map[string]int `json:"thresholds,omitempty"`
+	// Named string sets. These take precedence over sets of the same name in the engine's SetStore
+	Sets map[string][]string `json:"sets,omitempty"`
+	// Action de-duplication, escalation, and rate-limit policy. If set, overrides the engine's static policy
+	Policy *ActionPolicy `json:"policy,omitempty"`
+
+	disabled map[string]bool
+	sets     map[string]map[string]bool
+}
+
+// Parses and indexes a JSON-encoded [RuleConfig].
+func ParseRuleConfig(raw []byte) (*RuleConfig, error) {
+	var rc RuleConfig
+	if err := json.Unmarshal(raw, &rc); err != nil {
+		return nil, fmt.Errorf("parsing rule config JSON: %w", err)
+	}
+	if rc.Policy != nil {
+		if err := rc.Policy.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid rule config policy: %w", err)
+		}
+	}
+	rc.disabled = make(map[string]bool, len(rc.DisabledRules))
+	for _, name := range rc.DisabledRules {
+		rc.disabled[name] = true
+	}
+	rc.sets = make(map[string]map[string]bool, len(rc.Sets))
+	for name, vals := range rc.Sets {
+		m := make(map[string]bool, len(vals))
+		for _, v := range vals {
+			m[v] = true
+		}
+		rc.sets[name] = m
+	}
+	return &rc, nil
+}
+
+func (rc *RuleConfig) RuleEnabled(name string) bool {
+	if rc == nil {
+		return true
+	}
+	return !rc.disabled[name]
+}
+
+// Returns the set check result, and whether the set was defined in this config at all.
+func (rc *RuleConfig) inSet(name, val string) (bool, bool) {
+	if rc == nil {
+		return false, false
+	}
+	set, ok := rc.sets[name]
+	if !ok {
+		return false, false
+	}
+	return set[val], true
+}
+
+// Holds the currently active [RuleConfig], and handles (re-)loading it from the configured source. Safe for concurrent use.
+type RuleConfigStore struct {
+	// Local file path to load config from. Takes precedence over URL if both are set.
+	Path string
+	// Remote HTTP(S) endpoint to fetch config from.
+	URL        string
+	HTTPClient *http.Client
+
+	mu       sync.RWMutex
+	current  *RuleConfig
+	loadedAt time.Time
+}
+
+func NewRuleConfigStore(path, url string) *RuleConfigStore {
+	return &RuleConfigStore{
+		Path: path,
+		URL:  url,
+		HTTPClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Returns the active config, which may be nil if nothing has been loaded yet.
+func (s *RuleConfigStore) Current() *RuleConfig {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+func (s *RuleConfigStore) LoadedAt() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loadedAt
+}
+
+// Fetches and parses config from the configured source, and atomically swaps it in. On any error, the previous config remains active.
+func (s *RuleConfigStore) Reload(ctx context.Context) (*RuleConfig, error) {
+	raw, err := s.fetch(ctx)
+	if err != nil {
+		ruleConfigReloadCount.WithLabelValues("error").Inc()
+		return nil, err
+	}
+	rc, err := ParseRuleConfig(raw)
+	if err != nil {
+		ruleConfigReloadCount.WithLabelValues("error").Inc()
+		return nil, err
+	}
+	s.mu.Lock()
+	s.current = rc
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	ruleConfigReloadCount.WithLabelValues("success").Inc()
+	return rc, nil
+}
+
+func (s *RuleConfigStore) fetch(ctx context.Context) ([]byte, error) {
+	if s.Path != "" {
+		raw, err := os.ReadFile(s.Path)
+		if err != nil {
+			return nil, fmt.Errorf("reading rule config file: %w", err)
+		}
+		return raw, nil
+	}
+	if s.URL == "" {
+		return nil, fmt.Errorf("rule config store has neither file path nor URL configured")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching rule config: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching rule config: HTTP status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 16*1024*1024))
+}
+
+// Reloads config on a fixed interval until the context is cancelled. Errors are logged, and the previous config is kept.
+func (s *RuleConfigStore) RunPeriodicReload(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			prev := s.Current()
+			rc, err := s.Reload(ctx)
+			if err != nil {
+				logger.Error("failed to reload rule config", "err", err)
+				continue
+			}
+			if prev == nil || prev.Version != rc.Version {
+				logger.Info("loaded new rule config", "version", rc.Version)
+			}
+		}
+	}
+}
+
+var ruleNameCache sync.Map
+
+// Derives a short name for a rule function from the golang runtime symbol name (eg, "BadWordPostRule", or "HiveLabelBlobRule" for a method value).
+func RuleName(f any) string {
+	ptr := reflect.ValueOf(f).Pointer()
+	if v, ok := ruleNameCache.Load(ptr); ok {
+		return v.(string)
+	}
+	name := ""
+	if fn := runtime.FuncForPC(ptr); fn != nil {
+		name = shortFuncName(fn.Name())
+	}
+	ruleNameCache.Store(ptr, name)
+	return name
+}
+
+func shortFuncName(full string) string {
+	name := strings.TrimSuffix(full, "-fm")
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		name = name[idx+1:]
+	}
+	return name
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleName(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("simpleRule", RuleName(simpleRule))
+	assert.Equal("alwaysReportAccountRule", RuleName(alwaysReportAccountRule))
+}
+
+func TestRuleConfigReload(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	assert.NoError(os.WriteFile(path, []byte(`{"version": "v1", "sets": {"bad-hashtags": ["other"]}}`), 0644))
+
+	eng := EngineTestFixture()
+	eng.RuleConfig = NewRuleConfigStore(path, "")
+	rc, err := eng.RuleConfig.Reload(ctx)
+	assert.NoError(err)
+	assert.Equal("v1", rc.Version)
+
+	cid1 := syntax.CID("cid123")
+	p1 := appbsky.FeedPost{Text: "some post blah", Tags: []string{"other"}}
+	p1buf := new(bytes.Buffer)
+	assert.NoError(p1.MarshalCBOR(p1buf))
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: "app.bsky.feed.post",
+		RecordKey:  "abc123",
+		CID:        &cid1,
+		RecordCBOR: p1buf.Bytes(),
+	}
+
+	// config set overrides the static set
+	am := AccountMeta{Identity: &identity.Identity{DID: op.DID, Handle: syntax.Handle("handle.example.com")}}
+	c := NewRecordContext(ctx, &eng, am, op)
+	assert.NoError(eng.Rules.CallRecordRules(&c))
+	assert.Equal([]string{"bad-hashtag"}, ExtractEffects(&c.BaseContext).RecordLabels)
+
+	// disable the rule and reload
+	assert.NoError(os.WriteFile(path, []byte(`{"version": "v2", "disabledRules": ["simpleRule"], "thresholds": {"max-tags": 3}}`), 0644))
+	rc, err = eng.RuleConfig.Reload(ctx)
+	assert.NoError(err)
+	assert.Equal("v2", rc.Version)
+	c = NewRecordContext(ctx, &eng, am, op)
+	assert.NoError(eng.Rules.CallRecordRules(&c))
+	assert.Empty(ExtractEffects(&c.BaseContext).RecordLabels)
+	assert.Equal(3, c.Threshold("max-tags", 5))
+	assert.Equal(5, c.Threshold("other", 5))
+
+	// bad config keeps previous version active
+	assert.NoError(os.WriteFile(path, []byte(`{not json`), 0644))
+	_, err = eng.RuleConfig.Reload(ctx)
+	assert.Error(err)
+	assert.Equal("v2", eng.RuleConfig.Current().Version)
+}
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"sync"
+	"time"
 
 	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 )
 
@@ -19,13 +22,26 @@ type RuleSet struct {
 	BlobRules         []BlobRuleFunc
 	NotificationRules []NotificationRuleFunc
 	OzoneEventRules   []OzoneEventRuleFunc
+	// Rules for specific record collections, keyed by collection NSID. These run in addition to any PostRules or ProfileRules.
+	CollectionRules map[string][]CollectionRuleFunc
+}
+
+// Registers a rule to be run against every record created or updated in the given collection. This works for any collection NSID, including third-party Lexicons.
+func (r *RuleSet) AddCollectionRule(collection syntax.NSID, f CollectionRuleFunc) {
+	if r.CollectionRules == nil {
+		r.CollectionRules = make(map[string][]CollectionRuleFunc)
+	}
+	r.CollectionRules[collection.String()] = append(r.CollectionRules[collection.String()], f)
 }
 
 // Executes all the various record-related rules. Only dispatches execution, does no other de-dupe or pre/post processing.
 func (r *RuleSet) CallRecordRules(c *RecordContext) error {
 	// first the generic rules
 	for _, f := range r.RecordRules {
-		err := f(c)
+		if !c.ruleEnabled(f) {
+			continue
+		}
+		err := callRule(c, f, func(c *RecordContext) error { return f(c) })
 		if err != nil {
 			c.Logger.Error("record rule execution failed", "err", err)
 		}
@@ -38,7 +54,10 @@ func (r *RuleSet) CallRecordRules(c *RecordContext) error {
 			return fmt.Errorf("failed to parse app.bsky.feed.post record: %v", err)
 		}
 		for _, f := range r.PostRules {
-			err := f(c, &post)
+			if !c.ruleEnabled(f) {
+				continue
+			}
+			err := callRule(c, f, func(c *RecordContext) error { return f(c, &post) })
 			if err != nil {
 				c.Logger.Error("post rule execution failed", "err", err)
 			}
@@ -49,12 +68,19 @@ func (r *RuleSet) CallRecordRules(c *RecordContext) error {
 			return fmt.Errorf("failed to parse app.bsky.actor.profile record: %v", err)
 		}
 		for _, f := range r.ProfileRules {
-			err := f(c, &profile)
+			if !c.ruleEnabled(f) {
+				continue
+			}
+			err := callRule(c, f, func(c *RecordContext) error { return f(c, &profile) })
 			if err != nil {
 				c.Logger.Error("profile rule execution failed", "err", err)
 			}
 		}
 	}
+	// then any rules registered for this specific collection
+	if err := r.callCollectionRules(c); err != nil {
+		return err
+	}
 	// then blob rules, if any
 	if len(r.BlobRules) == 0 {
 		return nil
@@ -67,10 +93,40 @@ func (r *RuleSet) CallRecordRules(c *RecordContext) error {
 	return nil
 }
 
+func (r *RuleSet) callCollectionRules(c *RecordContext) error {
+	collection := c.RecordOp.Collection.String()
+	rules := r.CollectionRules[collection]
+	if len(rules) == 0 {
+		return nil
+	}
+	obj, err := data.UnmarshalCBOR(c.RecordOp.RecordCBOR)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s record: %v", collection, err)
+	}
+	rec := DecodedRecord{Data: obj}
+	// unknown (unregistered) types are fine; rules just get the generic data
+	if typed, err := lexutil.CborDecodeValue(c.RecordOp.RecordCBOR); err == nil {
+		rec.Typed = typed
+	}
+	for _, f := range rules {
+		if !c.ruleEnabled(f) {
+			continue
+		}
+		err := callRule(c, f, func(c *RecordContext) error { return f(c, &rec) })
+		if err != nil {
+			c.Logger.Error("collection rule execution failed", "collection", collection, "err", err)
+		}
+	}
+	return nil
+}
+
 // NOTE: this will probably be removed and merged in to `CallRecordRules`
 func (r *RuleSet) CallRecordDeleteRules(c *RecordContext) error {
 	for _, f := range r.RecordDeleteRules {
-		err := f(c)
+		if !c.ruleEnabled(f) {
+			continue
+		}
+		err := callRule(c, f, func(c *RecordContext) error { return f(c) })
 		if err != nil {
 			c.Logger.Error("record delete rule execution failed", "err", err)
 		}
@@ -81,7 +137,10 @@ func (r *RuleSet) CallRecordDeleteRules(c *RecordContext) error {
 // Executes rules for identity update events.
 func (r *RuleSet) CallIdentityRules(c *AccountContext) error {
 	for _, f := range r.IdentityRules {
-		err := f(c)
+		if !c.ruleEnabled(f) {
+			continue
+		}
+		err := callRule(c, f, func(c *AccountContext) error { return f(c) })
 		if err != nil {
 			c.Logger.Error("identity rule execution failed", "err", err)
 		}
@@ -91,7 +150,10 @@ func (r *RuleSet) CallIdentityRules(c *AccountContext) error {
 
 func (r *RuleSet) CallNotificationRules(c *NotificationContext) error {
 	for _, f := range r.NotificationRules {
-		err := f(c)
+		if !c.ruleEnabled(f) {
+			continue
+		}
+		err := callRule(c, f, func(c *NotificationContext) error { return f(c) })
 		if err != nil {
 			c.Logger.Error("notification rule execution failed", "err", err)
 		}
@@ -101,7 +163,10 @@ func (r *RuleSet) CallNotificationRules(c *NotificationContext) error {
 
 func (r *RuleSet) CallOzoneEventRules(c *OzoneEventContext) error {
 	for _, f := range r.OzoneEventRules {
-		err := f(c)
+		if !c.ruleEnabled(f) {
+			continue
+		}
+		err := callRule(c, f, func(c *OzoneEventContext) error { return f(c) })
 		if err != nil {
 			c.Logger.Error("ozone event rule execution failed", "err", err)
 		}
@@ -155,10 +220,13 @@ func (r *RuleSet) processBlob(c *RecordContext, blob lexutil.LexBlob, data []byt
 	errChan := make(chan error, len(r.BlobRules))
 	var wg sync.WaitGroup
 	for _, f := range r.BlobRules {
+		if !c.ruleEnabled(f) {
+			continue
+		}
 		wg.Add(1)
 		go func(brf BlobRuleFunc) {
 			defer wg.Done()
-			err := brf(c, blob, data)
+			err := callRule(c, brf, func(c *RecordContext) error { return brf(c, blob, data) })
 			if err != nil {
 				errChan <- err
 				return
@@ -177,3 +245,36 @@ func (r *RuleSet) processBlob(c *RecordContext, blob lexutil.LexBlob, data []byt
 	}
 	return nil
 }
+
+type ruleContext[C any] interface {
+	*C
+	base() *BaseContext
+}
+
+// runs a single rule function, recording per-rule evaluation count and latency metrics. The rule gets its own copy of the context, naming the rule, so that the actions it requests are attributed to it (rules for the same event may run concurrently, eg blob rules)
+func callRule[C any, P ruleContext[C]](c P, f any, call func(P) error) error {
+	name := RuleName(f)
+	rc := *c
+	P(&rc).base().rule = name
+	start := time.Now()
+	err := call(&rc)
+	ruleEvalDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ruleEvalCount.WithLabelValues(name, "error").Inc()
+	} else {
+		ruleEvalCount.WithLabelValues(name, "ok").Inc()
+	}
+	if rerr := P(&rc).base().Err; rerr != nil && c.base().Err == nil {
+		c.base().Err = rerr
+	}
+	return err
+}
+
+// checks the engine's runtime rule config (if any) to determine if the given rule function should be run
+func (c *BaseContext) ruleEnabled(f any) bool {
+	rc := c.engine.RuleConfig.Current()
+	if rc == nil {
+		return true
+	}
+	return rc.RuleEnabled(RuleName(f))
+}
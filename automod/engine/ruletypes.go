@@ -12,3 +12,27 @@ type ProfileRuleFunc = func(c *RecordContext, profile *appbsky.ActorProfile) err
 type BlobRuleFunc = func(c *RecordContext, blob lexutil.LexBlob, data []byte) error
 type NotificationRuleFunc = func(c *NotificationContext) error
 type OzoneEventRuleFunc = func(c *OzoneEventContext) error
+
+// Rule for records in a specific collection (registered with [RuleSet.AddCollectionRule]). The record has already been decoded.
+type CollectionRuleFunc = func(c *RecordContext, rec *DecodedRecord) error
+
+// A record which has been decoded for processing by [CollectionRuleFunc] rules.
+type DecodedRecord struct {
+	// Generic atproto data model representation of the record. Always populated.
+	Data map[string]any
+	// Go struct for the record, if the record's `$type` is registered with lexutil (eg, `*appbsky.GraphList`). Nil for unknown Lexicons.
+	Typed any
+}
+
+// Returns a top-level string field from the generic record data, or empty string if not present (or not a string).
+func (d *DecodedRecord) StringField(name string) string {
+	s, _ := d.Data[name].(string)
+	return s
+}
+
+// Request from a rule to add a subject to the human review queue.
+type ReviewRequest struct {
+	Rule     string
+	Reason   string
+	Evidence map[string]string
+}
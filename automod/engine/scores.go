@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"sync"
+)
+
+// Named numeric scores (eg, from external ML classifiers) attached to a single event. Rules which run earlier can set scores which later rules read.
+type scoreSet struct {
+	mu sync.RWMutex
+	m  map[string]float64
+}
+
+func (s *scoreSet) set(name string, val float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[string]float64)
+	}
+	s.m[name] = val
+}
+
+func (s *scoreSet) get(name string) (float64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.m[name]
+	return v, ok
+}
+
+func (s *scoreSet) all() map[string]float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]float64, len(s.m))
+	for k, v := range s.m {
+		out[k] = v
+	}
+	return out
+}
+
+// Records a named score for the current event, making it available to subsequent rules via [BaseContext.GetScore].
+func (c *BaseContext) SetScore(name string, val float64) {
+	c.scores.set(name, val)
+}
+
+// Returns a named score for the current event, and whether it was set at all. Note that rule ordering matters: scores are only available after the rule which sets them has run.
+func (c *BaseContext) GetScore(name string) (float64, bool) {
+	return c.scores.get(name)
+}
+
+// Returns a copy of all scores set on the current event.
+func (c *BaseContext) Scores() map[string]float64 {
+	return c.scores.all()
+}
@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/countstore"
+)
+
+// Counter (keyed by account DID) of new posts, incremented by the engine for every post creation. Used for [AccountContext.PostingVelocity].
+const postVelocityCounter = "automod-post"
+
+// cache value indicating that the PLC directory had no creation time for a DID
+const plcCreatedUnknown = "unknown"
+
+// no accounts exist before this time
+var atprotoAccountEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// returns true if account creation timestamp is plausible: not-nil, not in distant past, not in the future
+func plausibleAccountCreation(when *time.Time) bool {
+	if when == nil {
+		return false
+	}
+	// this is mostly to check for misconfigurations or null values (eg, UNIX epoch zero means "unknown" not actually 1970)
+	if !when.After(atprotoAccountEpoch) {
+		return false
+	}
+	// a timestamp in the future would also indicate some misconfiguration
+	if when.After(time.Now().Add(time.Hour)) {
+		return false
+	}
+	return true
+}
+
+// Best-effort account creation time. In order of preference: the first operation in the PLC directory audit log (for did:plc, if the engine has a PLC host configured), the public profile creation time, or the private account index time. Returns nil if none of these are available or plausible.
+func (c *AccountContext) AccountCreatedAt() *time.Time {
+	if c.engine != nil && c.Account.Identity != nil {
+		ts, err := c.engine.plcCreatedAt(c.Ctx, c.Account.Identity.DID)
+		if err != nil {
+			c.Logger.Warn("failed to fetch account creation time from PLC directory", "err", err)
+		} else if plausibleAccountCreation(ts) {
+			return ts
+		}
+	}
+	if plausibleAccountCreation(c.Account.CreatedAt) {
+		return c.Account.CreatedAt
+	}
+	if c.Account.Private != nil && plausibleAccountCreation(c.Account.Private.IndexedAt) {
+		return c.Account.Private.IndexedAt
+	}
+	return nil
+}
+
+// Time since account creation (see [AccountContext.AccountCreatedAt]). The boolean is false if the creation time is not known.
+func (c *AccountContext) AccountAge() (time.Duration, bool) {
+	ts := c.AccountCreatedAt()
+	if ts == nil {
+		return 0, false
+	}
+	return time.Since(*ts), true
+}
+
+// Ratio of followers to follows, from (cached) AppView account metadata. Accounts which follow nobody are treated as following one account, so the result is always defined.
+func (c *AccountContext) FollowerRatio() float64 {
+	follows := c.Account.FollowsCount
+	if follows < 1 {
+		follows = 1
+	}
+	return float64(c.Account.FollowersCount) / float64(follows)
+}
+
+// Number of new posts by this account in the current time period bucket ([countstore.PeriodHour], [countstore.PeriodDay], or [countstore.PeriodTotal]), as counted by the engine. Does not include the event currently being processed.
+func (c *AccountContext) PostingVelocity(period string) int {
+	if c.Account.Identity == nil {
+		return 0
+	}
+	return c.GetCount(postVelocityCounter, c.Account.Identity.DID.String(), period)
+}
+
+// Estimated posting rate for this account over the current UTC day, in posts per hour. If the count for the current hour bucket is higher, that is returned instead, so that sudden bursts are not averaged away.
+func (c *AccountContext) PostsPerHour() float64 {
+	day := c.PostingVelocity(countstore.PeriodDay)
+	hour := c.PostingVelocity(countstore.PeriodHour)
+	// hours elapsed in the current UTC day, with a floor of one hour to avoid inflating rates just after midnight
+	elapsed := time.Since(time.Now().UTC().Truncate(24 * time.Hour)).Hours()
+	if elapsed < 1 {
+		elapsed = 1
+	}
+	rate := float64(day) / elapsed
+	if float64(hour) > rate {
+		return float64(hour)
+	}
+	return rate
+}
+
+// updates engine-maintained signal counters for a record event
+func (eng *Engine) trackRecordSignals(c *RecordContext) {
+	if c.RecordOp.Action == CreateOp && c.RecordOp.Collection == "app.bsky.feed.post" {
+		c.effects.Increment(postVelocityCounter, c.RecordOp.DID.String())
+	}
+}
+
+type plcAuditEntry struct {
+	CreatedAt string `json:"createdAt"`
+}
+
+// fetches (and caches) the time of the first operation for a DID from the PLC directory. Returns nil without error for non-PLC DIDs, or if no PLC host is configured.
+func (eng *Engine) plcCreatedAt(ctx context.Context, did syntax.DID) (*time.Time, error) {
+	if eng.PLCHost == "" || did.Method() != "plc" || eng.Cache == nil {
+		return nil, nil
+	}
+	existing, err := eng.Cache.Get(ctx, "plc-created", did.String())
+	if err != nil {
+		return nil, fmt.Errorf("checking PLC creation time cache: %w", err)
+	}
+	if existing == plcCreatedUnknown {
+		return nil, nil
+	}
+	if existing != "" {
+		ts, err := syntax.ParseDatetimeTime(existing)
+		if err != nil {
+			return nil, fmt.Errorf("parsing cached PLC creation time: %w", err)
+		}
+		return &ts, nil
+	}
+
+	client := eng.PLCClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/%s/log/audit", eng.PLCHost, did), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		plcAuditFetches.WithLabelValues("error").Inc()
+		return nil, fmt.Errorf("fetching PLC audit log: %w", err)
+	}
+	defer resp.Body.Close()
+	plcAuditFetches.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+
+	var ts *time.Time
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var log []plcAuditEntry
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 4*1024*1024)).Decode(&log); err != nil {
+			return nil, fmt.Errorf("parsing PLC audit log: %w", err)
+		}
+		if len(log) > 0 {
+			t, err := syntax.ParseDatetimeLenient(log[0].CreatedAt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid PLC operation createdAt: %w", err)
+			}
+			tt := t.Time()
+			ts = &tt
+		}
+	case http.StatusNotFound:
+	default:
+		return nil, fmt.Errorf("fetching PLC audit log: HTTP status %d", resp.StatusCode)
+	}
+
+	val := plcCreatedUnknown
+	if ts != nil {
+		val = ts.UTC().Format(syntax.AtprotoDatetimeLayout)
+	}
+	if err := eng.Cache.Set(ctx, "plc-created", did.String(), val); err != nil {
+		eng.Logger.Error("writing to PLC creation time cache failed", "err", err)
+	}
+	return ts, nil
+}
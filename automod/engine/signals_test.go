@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/countstore"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountAgeSignal(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	created := time.Now().Add(-36 * time.Hour).UTC()
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if r.URL.Path != "/did:plc:abc111/log/audit" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `[{"did": "did:plc:abc111", "createdAt": "%s"}]`, created.Format(syntax.AtprotoDatetimeLayout))
+	}))
+	defer srv.Close()
+
+	eng := EngineTestFixture()
+	eng.PLCHost = srv.URL
+	profileCreated := time.Now().Add(-1 * time.Hour)
+	am := AccountMeta{
+		Identity: &identity.Identity{
+			DID:    syntax.DID("did:plc:abc111"),
+			Handle: syntax.Handle("handle.example.com"),
+		},
+		CreatedAt:      &profileCreated,
+		FollowersCount: 30,
+		FollowsCount:   10,
+	}
+	ac := NewAccountContext(ctx, &eng, am)
+
+	// PLC history takes precedence over profile metadata
+	age, ok := ac.AccountAge()
+	assert.True(ok)
+	assert.InDelta((36 * time.Hour).Seconds(), age.Seconds(), 60)
+	// and is cached
+	_, ok = ac.AccountAge()
+	assert.True(ok)
+	assert.Equal(1, fetches)
+
+	// falls back to profile metadata if there is no PLC history
+	am.Identity = &identity.Identity{DID: syntax.DID("did:plc:abc222")}
+	ac = NewAccountContext(ctx, &eng, am)
+	age, ok = ac.AccountAge()
+	assert.True(ok)
+	assert.InDelta(time.Hour.Seconds(), age.Seconds(), 60)
+
+	assert.Equal(3.0, ac.FollowerRatio())
+	ac.Account.FollowsCount = 0
+	assert.Equal(30.0, ac.FollowerRatio())
+}
+
+func TestPostingVelocitySignal(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	eng := EngineTestFixture()
+
+	cid1 := syntax.CID("cid123")
+	p1 := appbsky.FeedPost{Text: "some post blah"}
+	p1buf := new(bytes.Buffer)
+	assert.NoError(p1.MarshalCBOR(p1buf))
+	for i := 0; i < 3; i++ {
+		op := RecordOp{
+			Action:     CreateOp,
+			DID:        syntax.DID("did:plc:abc111"),
+			Collection: "app.bsky.feed.post",
+			RecordKey:  syntax.RecordKey(fmt.Sprintf("abc%d", i)),
+			CID:        &cid1,
+			RecordCBOR: p1buf.Bytes(),
+		}
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+	}
+
+	ident, err := eng.Directory.LookupDID(ctx, syntax.DID("did:plc:abc111"))
+	assert.NoError(err)
+	ac := NewAccountContext(ctx, &eng, AccountMeta{Identity: ident})
+	assert.Equal(3, ac.PostingVelocity(countstore.PeriodHour))
+	assert.Equal(3, ac.PostingVelocity(countstore.PeriodDay))
+	assert.True(ac.PostsPerHour() >= 3.0)
+}
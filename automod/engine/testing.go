@@ -9,6 +9,7 @@ import (
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/automod/cachestore"
 	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/dupestore"
 	"github.com/bluesky-social/indigo/automod/flagstore"
 	"github.com/bluesky-social/indigo/automod/setstore"
 )
@@ -64,6 +65,7 @@ func EngineTestFixture() Engine {
 		Counters:  countstore.NewMemCountStore(),
 		Sets:      sets,
 		Flags:     flags,
+		Dupes:     dupestore.NewMemDupeStore(time.Hour),
 		Cache:     cache,
 		Rules:     rules,
 	}
@@ -0,0 +1,263 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Payload formats supported by [WebhookNotifier]
+const (
+	WebhookFormatSlack   = "slack"
+	WebhookFormatDiscord = "discord"
+	WebhookFormatJSON    = "json"
+)
+
+var defaultWebhookTemplate = `⚠️ Automod {{ .Kind }} action ⚠️
+{{ .DID }} / {{ .Handle }}{{ if .URI }}
+{{ .URI }}{{ end }}{{ if .Rules }}
+Rules: {{ join .Rules ", " }}{{ end }}{{ if .Labels }}
+Labels: {{ join .Labels ", " }}{{ end }}{{ if .Flags }}
+Flags: {{ join .Flags ", " }}{{ end }}{{ range .Reports }}
+Report {{ .ReasonType }}: {{ .Comment }}{{ end }}{{ if .Escalate }}
+Escalated: {{ .Escalate }}{{ end }}{{ if .Takedown }}
+Takedown!{{ end }}
+`
+
+// Configuration for a single webhook notification endpoint.
+type WebhookTarget struct {
+	// Service name: rules request notifications to this target with `c.Notify(<name>)`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Payload format: "slack" (default), "discord", or "json"
+	Format string `json:"format,omitempty"`
+	// Optional golang text/template for the message text, executed with a [WebhookMessage]. A "join" function is available.
+	Template string `json:"template,omitempty"`
+	// If set, this target is also notified (without any rule calling `c.Notify`) for any event where one of these rules fired. Use this for alerts on high-severity rules.
+	Rules []string `json:"rules,omitempty"`
+	// Max number of messages sent per minute; additional messages are dropped. Zero means no limit.
+	MaxPerMinute int `json:"maxPerMinute,omitempty"`
+
+	tmpl    *template.Template
+	limiter *rate.Limiter
+}
+
+// Data passed to webhook message templates, and sent as-is for the "json" format.
+type WebhookMessage struct {
+	// "account" or "record"
+	Kind     string      `json:"kind"`
+	DID      string      `json:"did"`
+	Handle   string      `json:"handle"`
+	URI      string      `json:"uri,omitempty"`
+	Rules    []string    `json:"rules,omitempty"`
+	Labels   []string    `json:"labels,omitempty"`
+	Flags    []string    `json:"flags,omitempty"`
+	Reports  []ModReport `json:"reports,omitempty"`
+	Escalate string      `json:"escalate,omitempty"`
+	Takedown bool        `json:"takedown,omitempty"`
+	Text     string      `json:"text"`
+}
+
+// [Notifier] which posts templated messages to Slack, Discord, or generic JSON webhook endpoints, with per-target rate limits.
+type WebhookNotifier struct {
+	Targets    map[string]*WebhookTarget
+	HTTPClient *http.Client
+}
+
+var _ Notifier = (*WebhookNotifier)(nil)
+var _ RuleSubscriber = (*WebhookNotifier)(nil)
+
+func NewWebhookNotifier(targets []WebhookTarget) (*WebhookNotifier, error) {
+	n := WebhookNotifier{
+		Targets: make(map[string]*WebhookTarget, len(targets)),
+		HTTPClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+	for _, t := range targets {
+		if t.Name == "" || t.URL == "" {
+			return nil, fmt.Errorf("webhook target must have both name and URL")
+		}
+		switch t.Format {
+		case "":
+			t.Format = WebhookFormatSlack
+		case WebhookFormatSlack, WebhookFormatDiscord, WebhookFormatJSON:
+		default:
+			return nil, fmt.Errorf("unsupported webhook format for %s: %s", t.Name, t.Format)
+		}
+		raw := t.Template
+		if raw == "" {
+			raw = defaultWebhookTemplate
+		}
+		tmpl, err := template.New(t.Name).Funcs(template.FuncMap{"join": strings.Join}).Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parsing webhook template for %s: %w", t.Name, err)
+		}
+		t.tmpl = tmpl
+		if t.MaxPerMinute > 0 {
+			t.limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(t.MaxPerMinute)), t.MaxPerMinute)
+		}
+		target := t
+		n.Targets[t.Name] = &target
+	}
+	return &n, nil
+}
+
+type webhookConfigFile struct {
+	Targets []WebhookTarget `json:"targets"`
+}
+
+// Loads webhook targets from a JSON file, like `{"targets": [{"name": "slack-alerts", "url": "https://hooks.slack.com/...", "rules": ["BadWordPostRule"]}]}`.
+func LoadWebhookNotifier(path string) (*WebhookNotifier, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading webhook config file: %w", err)
+	}
+	var cfg webhookConfigFile
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing webhook config JSON: %w", err)
+	}
+	return NewWebhookNotifier(cfg.Targets)
+}
+
+func (n *WebhookNotifier) SubscribedServices(rules []string) []string {
+	var out []string
+	for name, t := range n.Targets {
+	outer:
+		for _, want := range t.Rules {
+			for _, r := range rules {
+				if r == want {
+					out = append(out, name)
+					break outer
+				}
+			}
+		}
+	}
+	return out
+}
+
+func (n *WebhookNotifier) SendAccount(ctx context.Context, service string, c *AccountContext) error {
+	t, ok := n.Targets[service]
+	if !ok {
+		return nil
+	}
+	msg := WebhookMessage{
+		Kind:     "account",
+		DID:      c.Account.Identity.DID.String(),
+		Handle:   c.Account.Identity.Handle.String(),
+		Rules:    c.effects.RulesFired,
+		Labels:   c.effects.AccountLabels,
+		Flags:    c.effects.AccountFlags,
+		Reports:  c.effects.AccountReports,
+		Escalate: c.effects.AccountEscalate,
+		Takedown: c.effects.AccountTakedown,
+	}
+	return n.send(ctx, t, &msg)
+}
+
+func (n *WebhookNotifier) SendRecord(ctx context.Context, service string, c *RecordContext) error {
+	t, ok := n.Targets[service]
+	if !ok {
+		return nil
+	}
+	msg := WebhookMessage{
+		Kind:     "record",
+		DID:      c.Account.Identity.DID.String(),
+		Handle:   c.Account.Identity.Handle.String(),
+		URI:      c.RecordOp.ATURI().String(),
+		Rules:    c.effects.RulesFired,
+		Labels:   c.effects.RecordLabels,
+		Flags:    c.effects.RecordFlags,
+		Reports:  c.effects.RecordReports,
+		Escalate: c.effects.RecordEscalate,
+		Takedown: c.effects.RecordTakedown,
+	}
+	return n.send(ctx, t, &msg)
+}
+
+func (n *WebhookNotifier) send(ctx context.Context, t *WebhookTarget, msg *WebhookMessage) error {
+	if t.limiter != nil && !t.limiter.Allow() {
+		webhookNotifyCount.WithLabelValues(t.Name, "ratelimited").Inc()
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, msg); err != nil {
+		webhookNotifyCount.WithLabelValues(t.Name, "error").Inc()
+		return fmt.Errorf("executing webhook template: %w", err)
+	}
+	msg.Text = buf.String()
+
+	var payload any
+	switch t.Format {
+	case WebhookFormatDiscord:
+		payload = map[string]string{"content": msg.Text}
+	case WebhookFormatJSON:
+		payload = msg
+	default:
+		payload = SlackWebhookBody{Text: msg.Text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.HTTPClient.Do(req)
+	if err != nil {
+		webhookNotifyCount.WithLabelValues(t.Name, "error").Inc()
+		return fmt.Errorf("webhook POST request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		webhookNotifyCount.WithLabelValues(t.Name, "error").Inc()
+		return fmt.Errorf("failed webhook POST request. status=%d", resp.StatusCode)
+	}
+	webhookNotifyCount.WithLabelValues(t.Name, "sent").Inc()
+	return nil
+}
+
+// [Notifier] which dispatches to several other notifiers (eg, a [SlackNotifier] and a [WebhookNotifier]). Errors from individual notifiers are combined.
+type MultiNotifier []Notifier
+
+var _ RuleSubscriber = MultiNotifier(nil)
+
+func (m MultiNotifier) SendAccount(ctx context.Context, service string, c *AccountContext) error {
+	var errs []error
+	for _, n := range m {
+		errs = append(errs, n.SendAccount(ctx, service, c))
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiNotifier) SendRecord(ctx context.Context, service string, c *RecordContext) error {
+	var errs []error
+	for _, n := range m {
+		errs = append(errs, n.SendRecord(ctx, service, c))
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiNotifier) SubscribedServices(rules []string) []string {
+	var out []string
+	for _, n := range m {
+		if rs, ok := n.(RuleSubscriber); ok {
+			out = append(out, rs.SubscribedServices(rules)...)
+		}
+	}
+	return out
+}
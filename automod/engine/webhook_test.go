@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var received []WebhookMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg WebhookMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, msg)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	notifier, err := NewWebhookNotifier([]WebhookTarget{
+		{
+			Name:         "alerts",
+			URL:          srv.URL,
+			Format:       WebhookFormatJSON,
+			Template:     `{{ .Kind }} flagged by {{ join .Rules "," }}`,
+			Rules:        []string{"spammyFlagRule"},
+			MaxPerMinute: 1,
+		},
+	})
+	assert.NoError(err)
+
+	eng := EngineTestFixture()
+	eng.Notifier = notifier
+	eng.Rules = RuleSet{
+		RecordRules: []RecordRuleFunc{
+			spammyFlagRule,
+		},
+	}
+	assert.NoError(eng.ProcessRecordOp(ctx, policyTestOp(t, "abc1")))
+	// second notification is dropped by the rate limit
+	assert.NoError(eng.ProcessRecordOp(ctx, policyTestOp(t, "abc2")))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(1, len(received))
+	assert.Equal("record", received[0].Kind)
+	assert.Equal("at://did:plc:abc111/app.bsky.feed.post/abc1", received[0].URI)
+	assert.Equal([]string{"spammy"}, received[0].Flags)
+	assert.Equal("record flagged by spammyFlagRule", received[0].Text)
+
+	_, err = NewWebhookNotifier([]WebhookTarget{{Name: "bad", URL: srv.URL, Format: "irc"}})
+	assert.Error(err)
+}
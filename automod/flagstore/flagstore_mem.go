@@ -2,29 +2,58 @@ package flagstore
 
 import (
 	"context"
+	"sync"
+	"time"
 )
 
+// In-process flag store. Safe for concurrent use.
 type MemFlagStore struct {
 	Data map[string][]string
+	// If non-zero, flags for a key are discarded this long after the most recent Add to that key
+	TTL time.Duration
+
+	mu      *sync.RWMutex
+	expires map[string]time.Time
 }
 
+var _ FlagStore = (*MemFlagStore)(nil)
+
 func NewMemFlagStore() MemFlagStore {
 	return MemFlagStore{
-		Data: make(map[string][]string),
+		Data:    make(map[string][]string),
+		mu:      &sync.RWMutex{},
+		expires: make(map[string]time.Time),
 	}
 }
 
+// Variant of NewMemFlagStore where flags expire after the given duration.
+func NewMemFlagStoreTTL(ttl time.Duration) MemFlagStore {
+	s := NewMemFlagStore()
+	s.TTL = ttl
+	return s
+}
+
 func (s MemFlagStore) Get(ctx context.Context, key string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	v, ok := s.Data[key]
-	if !ok {
+	if !ok || s.expired(key) {
 		return []string{}, nil
 	}
 	return v, nil
 }
 
+// must be called with lock held
+func (s MemFlagStore) expired(key string) bool {
+	exp, ok := s.expires[key]
+	return ok && time.Now().After(exp)
+}
+
 func (s MemFlagStore) Add(ctx context.Context, key string, flags []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	v, ok := s.Data[key]
-	if !ok {
+	if !ok || s.expired(key) {
 		v = []string{}
 	}
 	for _, f := range flags {
@@ -32,6 +61,9 @@ func (s MemFlagStore) Add(ctx context.Context, key string, flags []string) error
 	}
 	v = dedupeStrings(v)
 	s.Data[key] = v
+	if s.TTL > 0 {
+		s.expires[key] = time.Now().Add(s.TTL)
+	}
 	return nil
 }
 
@@ -40,8 +72,10 @@ func (s MemFlagStore) Remove(ctx context.Context, key string, flags []string) er
 	if len(flags) == 0 {
 		return nil
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	v, ok := s.Data[key]
-	if !ok {
+	if !ok || s.expired(key) {
 		v = []string{}
 	}
 	m := make(map[string]bool, len(v))
@@ -58,3 +92,18 @@ func (s MemFlagStore) Remove(ctx context.Context, key string, flags []string) er
 	s.Data[key] = out
 	return nil
 }
+
+// Removes all expired keys, returning the number purged.
+func (s MemFlagStore) PurgeExpired() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	purged := 0
+	for key := range s.expires {
+		if s.expired(key) {
+			delete(s.Data, key)
+			delete(s.expires, key)
+			purged++
+		}
+	}
+	return purged
+}
@@ -2,6 +2,7 @@ package flagstore
 
 import (
 	"context"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -9,18 +10,27 @@ import (
 var redisFlagsPrefix string = "flags/"
 
 type RedisFlagStore struct {
-	Client *redis.Client
+	// either a single-node client (*redis.Client) or a cluster client (*redis.ClusterClient)
+	Client redis.UniversalClient
+	// If non-zero, flags for a key expire this long after the most recent Add to that key
+	TTL time.Duration
 }
 
+var _ FlagStore = (*RedisFlagStore)(nil)
+
 func NewRedisFlagStore(redisURL string) (*RedisFlagStore, error) {
 	ctx := context.Background()
 	opt, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, err
 	}
-	rdb := redis.NewClient(opt)
+	return NewRedisFlagStoreFromClient(ctx, redis.NewClient(opt))
+}
+
+// Creates a store using an existing redis client, which may be a cluster client.
+func NewRedisFlagStoreFromClient(ctx context.Context, rdb redis.UniversalClient) (*RedisFlagStore, error) {
 	// check redis connection
-	_, err = rdb.Ping(ctx).Result()
+	_, err := rdb.Ping(ctx).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -50,7 +60,14 @@ func (s *RedisFlagStore) Add(ctx context.Context, key string, flags []string) er
 		l = append(l, v)
 	}
 	rkey := redisFlagsPrefix + key
-	return s.Client.SAdd(ctx, rkey, l...).Err()
+	if s.TTL <= 0 {
+		return s.Client.SAdd(ctx, rkey, l...).Err()
+	}
+	multi := s.Client.TxPipeline()
+	multi.SAdd(ctx, rkey, l...)
+	multi.Expire(ctx, rkey, s.TTL)
+	_, err := multi.Exec(ctx)
+	return err
 }
 
 func (s *RedisFlagStore) Remove(ctx context.Context, key string, flags []string) error {
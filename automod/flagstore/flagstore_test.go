@@ -3,6 +3,7 @@ package flagstore
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -28,3 +29,25 @@ func TestFlagStoreBasics(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal([]string{"green"}, l)
 }
+
+func TestFlagStoreTTL(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	fs := NewMemFlagStoreTTL(time.Hour)
+	assert.NoError(fs.Add(ctx, "test1", []string{"red"}))
+	assert.NoError(fs.Add(ctx, "test2", []string{"blue"}))
+
+	// force expiration of one key
+	fs.expires["test1"] = time.Now().Add(-time.Minute)
+	l, err := fs.Get(ctx, "test1")
+	assert.NoError(err)
+	assert.Empty(l)
+	l, err = fs.Get(ctx, "test2")
+	assert.NoError(err)
+	assert.Equal([]string{"blue"}, l)
+
+	assert.Equal(1, fs.PurgeExpired())
+	_, ok := fs.Data["test1"]
+	assert.False(ok)
+}
@@ -0,0 +1,2 @@
+// Labeler service: signs, sequences, and persists labels, and serves them to clients with com.atproto.label.queryLabels and com.atproto.label.subscribeLabels, so automod labels can be published directly as a labeler service.
+package labeler
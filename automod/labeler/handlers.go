@@ -0,0 +1,210 @@
+package labeler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+
+	"github.com/gorilla/websocket"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+type xrpcError struct {
+	Error   string `json:"error"`
+	Message string `json:"message,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Registers the queryLabels and subscribeLabels XRPC endpoints on a mux.
+func (lr *Labeler) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/xrpc/com.atproto.label.queryLabels", lr.HandleQueryLabels)
+	mux.HandleFunc("/xrpc/com.atproto.label.subscribeLabels", lr.HandleSubscribeLabels)
+}
+
+// com.atproto.label.queryLabels: query params "uriPatterns" (repeated, required), "sources" (repeated), "cursor", "limit"
+func (lr *Labeler) HandleQueryLabels(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := LabelQuery{
+		URIPatterns: params["uriPatterns"],
+		Sources:     params["sources"],
+		Limit:       50,
+	}
+	if len(q.URIPatterns) == 0 {
+		writeJSON(w, http.StatusBadRequest, xrpcError{Error: "InvalidRequest", Message: "uriPatterns is required"})
+		return
+	}
+	for _, p := range q.URIPatterns {
+		if p == "*" {
+			writeJSON(w, http.StatusBadRequest, xrpcError{Error: "InvalidRequest", Message: "uriPatterns must not match all subjects"})
+			return
+		}
+	}
+	if l := params.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > 250 {
+			writeJSON(w, http.StatusBadRequest, xrpcError{Error: "InvalidRequest", Message: "limit must be between 1 and 250"})
+			return
+		}
+		q.Limit = limit
+	}
+	if c := params.Get("cursor"); c != "" {
+		cursor, err := strconv.ParseInt(c, 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, xrpcError{Error: "InvalidRequest", Message: "invalid cursor"})
+			return
+		}
+		q.Cursor = cursor
+	}
+
+	page, err := lr.Store.Query(r.Context(), q)
+	if err != nil {
+		lr.Logger.Error("failed to query labels", "err", err)
+		writeJSON(w, http.StatusInternalServerError, xrpcError{Error: "InternalServerError"})
+		return
+	}
+	out := comatproto.LabelQueryLabels_Output{
+		Labels: make([]*comatproto.LabelDefs_Label, len(page)),
+	}
+	for i, sl := range page {
+		out.Labels[i] = sl.Label
+	}
+	if len(page) == q.Limit {
+		cursor := strconv.FormatInt(page[len(page)-1].Seq, 10)
+		out.Cursor = &cursor
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+func writeFrame(con *websocket.Conn, header events.EventHeader, body cbg.CBORMarshaler) error {
+	buf := new(bytes.Buffer)
+	if err := header.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	if err := body.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	con.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return con.WriteMessage(websocket.BinaryMessage, buf.Bytes())
+}
+
+func writeLabelFrame(con *websocket.Conn, sl SeqLabel) error {
+	return writeFrame(con, events.EventHeader{Op: events.EvtKindMessage, MsgType: "#labels"}, &comatproto.LabelSubscribeLabels_Labels{
+		Seq:    sl.Seq,
+		Labels: []*comatproto.LabelDefs_Label{sl.Label},
+	})
+}
+
+func writeErrorFrame(con *websocket.Conn, name, message string) error {
+	return writeFrame(con, events.EventHeader{Op: events.EvtKindErrorFrame}, &events.ErrorFrame{Error: name, Message: message})
+}
+
+// com.atproto.label.subscribeLabels: streams labels as they are created. With the "cursor" query param, first replays all labels after that sequence number.
+func (lr *Labeler) HandleSubscribeLabels(w http.ResponseWriter, r *http.Request) {
+	var cursor *int64
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		v, err := strconv.ParseInt(c, 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, xrpcError{Error: "InvalidRequest", Message: "invalid cursor"})
+			return
+		}
+		cursor = &v
+	}
+
+	con, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		lr.Logger.Warn("failed to upgrade label stream connection", "err", err)
+		return
+	}
+	defer con.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	// clients don't send anything, but reading is needed to process control frames, and notices disconnects
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := con.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// subscribe before replaying, so no labels are missed in between. labels in both are skipped by sequence number
+	sub := lr.subscribe()
+	defer lr.unsubscribe(sub)
+
+	var last int64
+	if cursor != nil {
+		latest, err := lr.Store.LatestSeq(ctx)
+		if err != nil {
+			lr.Logger.Error("failed to read label stream sequence", "err", err)
+			return
+		}
+		if *cursor > latest {
+			writeErrorFrame(con, "FutureCursor", "cursor is ahead of the label stream")
+			return
+		}
+		last = *cursor
+		for {
+			page, err := lr.Store.Query(ctx, LabelQuery{Cursor: last, Limit: 500})
+			if err != nil {
+				lr.Logger.Error("failed to replay label stream", "err", err)
+				return
+			}
+			for _, sl := range page {
+				if err := writeLabelFrame(con, sl); err != nil {
+					return
+				}
+				last = sl.Seq
+			}
+			if len(page) < 500 {
+				break
+			}
+		}
+	}
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.tooSlow:
+			writeErrorFrame(con, "ConsumerTooSlow", "stream consumer fell too far behind")
+			return
+		case <-ping.C:
+			if err := con.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		case batch := <-sub.outgoing:
+			for _, sl := range batch {
+				if sl.Seq <= last {
+					continue
+				}
+				if err := writeLabelFrame(con, sl); err != nil {
+					return
+				}
+				last = sl.Seq
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package labeler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/labels"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// number of label batches buffered for each stream subscriber; subscribers which fall further behind are disconnected
+const subscriberBuffer = 1000
+
+// Labeler creates labels as a labeler service: labels are signed with the labeler's key, sequenced and persisted in the store, and broadcast to stream subscribers.
+//
+// The store must only be written to by a single Labeler, which serializes all writes, so that sequence numbers are assigned (and labels are streamed) in order.
+type Labeler struct {
+	Store LabelStore
+	// DID of the labeler service account, used as the "src" of all labels
+	DID syntax.DID
+	// Labeler signing key; the public key must be declared in the labeler's DID document, with ID "#atproto_label"
+	Key    crypto.PrivateKey
+	Logger *slog.Logger
+
+	// held while writing labels, and while adding subscribers, so subscribers never miss a label
+	lk   sync.Mutex
+	subs map[*subscriber]bool
+}
+
+type subscriber struct {
+	outgoing chan []SeqLabel
+	// closed when the subscriber has fallen too far behind
+	tooSlow chan struct{}
+}
+
+func NewLabeler(store LabelStore, did syntax.DID, key crypto.PrivateKey, logger *slog.Logger) *Labeler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Labeler{
+		Store:  store,
+		DID:    did,
+		Key:    key,
+		Logger: logger,
+		subs:   make(map[*subscriber]bool),
+	}
+}
+
+// Signs, persists, and broadcasts labels. The "src" and "ver" of each label are set by the labeler.
+func (lr *Labeler) Emit(ctx context.Context, lbls []*comatproto.LabelDefs_Label) ([]SeqLabel, error) {
+	for _, l := range lbls {
+		l.Src = lr.DID.String()
+		if err := labels.Sign(l, lr.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	lr.lk.Lock()
+	defer lr.lk.Unlock()
+	seqd, err := lr.Store.Append(ctx, lbls)
+	if err != nil {
+		return nil, err
+	}
+	if len(seqd) == 0 {
+		return seqd, nil
+	}
+	for sub := range lr.subs {
+		select {
+		case sub.outgoing <- seqd:
+		default:
+			lr.Logger.Warn("dropping slow label stream subscriber")
+			close(sub.tooSlow)
+			delete(lr.subs, sub)
+		}
+	}
+	return seqd, nil
+}
+
+// current (non-negated) label values applied by this labeler to a subject
+func (lr *Labeler) activeLabels(ctx context.Context, uri string, cid *string) (map[string]bool, error) {
+	active := make(map[string]bool)
+	q := LabelQuery{
+		URIPatterns: []string{uri},
+		Sources:     []string{lr.DID.String()},
+		Limit:       1000,
+	}
+	for {
+		page, err := lr.Store.Query(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, sl := range page {
+			// labels for another version of a record don't count
+			if sl.Label.Cid != nil && (cid == nil || *sl.Label.Cid != *cid) {
+				continue
+			}
+			active[sl.Label.Val] = sl.Label.Neg == nil || !*sl.Label.Neg
+		}
+		if len(page) < q.Limit {
+			return active, nil
+		}
+		q.Cursor = page[len(page)-1].Seq
+	}
+}
+
+// Applies label values to a subject (an account DID, or record AT-URI and optional CID), skipping any values the subject already has. Returns the labels created.
+func (lr *Labeler) CreateLabels(ctx context.Context, uri string, cid *string, vals []string) ([]SeqLabel, error) {
+	return lr.updateLabels(ctx, uri, cid, vals, false)
+}
+
+// Negates label values on a subject, skipping any values the subject doesn't have. Returns the negation labels created.
+func (lr *Labeler) NegateLabels(ctx context.Context, uri string, cid *string, vals []string) ([]SeqLabel, error) {
+	return lr.updateLabels(ctx, uri, cid, vals, true)
+}
+
+func (lr *Labeler) updateLabels(ctx context.Context, uri string, cid *string, vals []string, neg bool) ([]SeqLabel, error) {
+	active, err := lr.activeLabels(ctx, uri, cid)
+	if err != nil {
+		return nil, fmt.Errorf("fetching existing labels: %w", err)
+	}
+	now := syntax.DatetimeNow().String()
+	var lbls []*comatproto.LabelDefs_Label
+	for _, val := range vals {
+		if active[val] != neg {
+			continue
+		}
+		// don't emit the same value twice in one batch
+		active[val] = !neg
+		l := &comatproto.LabelDefs_Label{
+			Uri: uri,
+			Cid: cid,
+			Val: val,
+			Cts: now,
+		}
+		if neg {
+			t := true
+			l.Neg = &t
+		}
+		lbls = append(lbls, l)
+	}
+	if len(lbls) == 0 {
+		return nil, nil
+	}
+	return lr.Emit(ctx, lbls)
+}
+
+// registers a stream subscriber, which receives all labels emitted after this call
+func (lr *Labeler) subscribe() *subscriber {
+	sub := &subscriber{
+		outgoing: make(chan []SeqLabel, subscriberBuffer),
+		tooSlow:  make(chan struct{}),
+	}
+	lr.lk.Lock()
+	defer lr.lk.Unlock()
+	lr.subs[sub] = true
+	return sub
+}
+
+func (lr *Labeler) unsubscribe(sub *subscriber) {
+	lr.lk.Lock()
+	defer lr.lk.Unlock()
+	delete(lr.subs, sub)
+}
@@ -0,0 +1,193 @@
+package labeler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/labels"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testLabeler(t *testing.T) (*Labeler, crypto.PublicKey) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in-memory sqlite databases are per-connection
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	store, err := NewSQLLabelStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewLabeler(store, syntax.DID("did:plc:labeler111"), key, nil), pub
+}
+
+func TestLabeler(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	lr, pub := testLabeler(t)
+
+	postURI := "at://did:plc:abc111/app.bsky.feed.post/3k4duaz5vfs2b"
+	created, err := lr.CreateLabels(ctx, postURI, nil, []string{"spam", "porn", "spam"})
+	assert.NoError(err)
+	assert.Equal(2, len(created))
+	assert.Equal(int64(1), created[0].Seq)
+	assert.Equal(int64(2), created[1].Seq)
+	assert.NoError(labels.Verify(created[0].Label, pub))
+	assert.Equal("did:plc:labeler111", created[0].Label.Src)
+
+	// existing values are skipped
+	created, err = lr.CreateLabels(ctx, postURI, nil, []string{"spam", "rude"})
+	assert.NoError(err)
+	assert.Equal(1, len(created))
+	assert.Equal("rude", created[0].Label.Val)
+
+	created, err = lr.CreateLabels(ctx, "did:plc:abc111", nil, []string{"spam"})
+	assert.NoError(err)
+	assert.Equal(1, len(created))
+
+	// only active values are negated
+	negated, err := lr.NegateLabels(ctx, postURI, nil, []string{"porn", "gore"})
+	assert.NoError(err)
+	assert.Equal(1, len(negated))
+	assert.True(*negated[0].Label.Neg)
+	assert.NoError(labels.Verify(negated[0].Label, pub))
+
+	// and negated values can be re-applied
+	created, err = lr.CreateLabels(ctx, postURI, nil, []string{"porn"})
+	assert.NoError(err)
+	assert.Equal(1, len(created))
+
+	latest, err := lr.Store.LatestSeq(ctx)
+	assert.NoError(err)
+	assert.Equal(int64(6), latest)
+
+	page, err := lr.Store.Query(ctx, LabelQuery{URIPatterns: []string{"at://did:plc:abc111/*"}})
+	assert.NoError(err)
+	assert.Equal(5, len(page))
+	page, err = lr.Store.Query(ctx, LabelQuery{URIPatterns: []string{"did:plc:abc111"}})
+	assert.NoError(err)
+	assert.Equal(1, len(page))
+	// LIKE wildcards in patterns are literal
+	page, err = lr.Store.Query(ctx, LabelQuery{URIPatterns: []string{"at://did:plc:abc%"}})
+	assert.NoError(err)
+	assert.Equal(0, len(page))
+	page, err = lr.Store.Query(ctx, LabelQuery{URIPatterns: []string{"at://did:plc:abc111/*"}, Sources: []string{"did:plc:other"}})
+	assert.NoError(err)
+	assert.Equal(0, len(page))
+}
+
+func TestQueryLabels(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	lr, _ := testLabeler(t)
+
+	_, err := lr.CreateLabels(ctx, "at://did:plc:abc111/app.bsky.feed.post/3k4duaz5vfs2b", nil, []string{"spam", "porn", "rude"})
+	assert.NoError(err)
+
+	mux := http.NewServeMux()
+	lr.RegisterHandlers(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/xrpc/com.atproto.label.queryLabels?uriPatterns=at://did:plc:abc111/*&limit=2")
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	var out comatproto.LabelQueryLabels_Output
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&out))
+	resp.Body.Close()
+	assert.Equal(2, len(out.Labels))
+	assert.Equal("2", *out.Cursor)
+
+	resp, err = http.Get(srv.URL + "/xrpc/com.atproto.label.queryLabels?uriPatterns=at://did:plc:abc111/*&limit=2&cursor=2")
+	assert.NoError(err)
+	out = comatproto.LabelQueryLabels_Output{}
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&out))
+	resp.Body.Close()
+	assert.Equal(1, len(out.Labels))
+	assert.Equal("rude", out.Labels[0].Val)
+	assert.Nil(out.Cursor)
+
+	resp, err = http.Get(srv.URL + "/xrpc/com.atproto.label.queryLabels")
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestSubscribeLabels(t *testing.T) {
+	assert := assert.New(t)
+	lr, pub := testLabeler(t)
+
+	_, err := lr.CreateLabels(context.Background(), "did:plc:abc111", nil, []string{"spam", "rude"})
+	assert.NoError(err)
+
+	mux := http.NewServeMux()
+	lr.RegisterHandlers(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{
+		DID:    lr.DID,
+		Handle: syntax.Handle("labeler.example.com"),
+		Keys: map[string]identity.Key{
+			labels.KeyID: {Type: "Multikey", PublicKeyMultibase: pub.Multibase()},
+		},
+	})
+
+	// starting after the first label: replays the second, then receives a live label
+	cursors := &labels.FileCursorStore{Path: filepath.Join(t.TempDir(), "cursor")}
+	assert.NoError(cursors.SetCursor(context.Background(), 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	received := make(chan string, 10)
+	c := labels.Consumer{
+		Host:     srv.URL,
+		Cursors:  cursors,
+		Verifier: &labels.Verifier{Dir: &dir},
+		HandleLabels: func(ctx context.Context, seq int64, lbls []*comatproto.LabelDefs_Label) error {
+			for _, l := range lbls {
+				received <- l.Val
+			}
+			if seq == 3 {
+				cancel()
+			}
+			return nil
+		},
+	}
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	assert.Equal("rude", <-received)
+	_, err = lr.CreateLabels(context.Background(), "did:plc:abc111", nil, []string{"porn"})
+	assert.NoError(err)
+	assert.Equal("porn", <-received)
+	assert.NoError(<-done)
+}
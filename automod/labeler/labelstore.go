@@ -0,0 +1,34 @@
+package labeler
+
+import (
+	"context"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+)
+
+// A signed label, along with its position in the labeler's stream
+type SeqLabel struct {
+	Seq   int64
+	Label *comatproto.LabelDefs_Label
+}
+
+// Filters for listing labels. All fields are optional
+type LabelQuery struct {
+	// Subject URIs (AT-URIs or DIDs) to match. A pattern ending in "*" matches any URI with that prefix. Empty matches all subjects
+	URIPatterns []string
+	// Labeler DIDs to match. Empty matches all sources
+	Sources []string
+	// Only labels with a sequence number greater than this are returned
+	Cursor int64
+	Limit  int
+}
+
+// Persistent, ordered log of signed labels
+type LabelStore interface {
+	// Persists labels, assigning each the next sequence number, in order.
+	Append(ctx context.Context, labels []*comatproto.LabelDefs_Label) ([]SeqLabel, error)
+	// Lists labels matching the query, in sequence order.
+	Query(ctx context.Context, q LabelQuery) ([]SeqLabel, error)
+	// Sequence number of the most recent label, or zero if the store is empty.
+	LatestSeq(ctx context.Context) (int64, error)
+}
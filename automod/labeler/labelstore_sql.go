@@ -0,0 +1,166 @@
+package labeler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"gorm.io/gorm"
+)
+
+// Database row for a single signed label. The ID is the label's sequence number
+type LabelRow struct {
+	ID        int64 `gorm:"primarykey"`
+	CreatedAt time.Time
+
+	Src string `gorm:"index"`
+	URI string `gorm:"column:uri;index"`
+	// empty if the label applies to all versions of the subject
+	CID string `gorm:"column:cid"`
+	Val string
+	Neg bool
+	Cts string
+	// empty if the label doesn't expire
+	Exp string
+	Ver int64
+	Sig []byte
+}
+
+func (LabelRow) TableName() string {
+	return "labels"
+}
+
+func rowFromLabel(l *comatproto.LabelDefs_Label) LabelRow {
+	row := LabelRow{
+		Src: l.Src,
+		URI: l.Uri,
+		Val: l.Val,
+		Cts: l.Cts,
+		Sig: l.Sig,
+	}
+	if l.Cid != nil {
+		row.CID = *l.Cid
+	}
+	if l.Neg != nil {
+		row.Neg = *l.Neg
+	}
+	if l.Exp != nil {
+		row.Exp = *l.Exp
+	}
+	if l.Ver != nil {
+		row.Ver = *l.Ver
+	}
+	return row
+}
+
+func (row *LabelRow) SeqLabel() SeqLabel {
+	l := &comatproto.LabelDefs_Label{
+		Src: row.Src,
+		Uri: row.URI,
+		Val: row.Val,
+		Cts: row.Cts,
+		Sig: row.Sig,
+	}
+	if row.CID != "" {
+		cid := row.CID
+		l.Cid = &cid
+	}
+	if row.Neg {
+		neg := true
+		l.Neg = &neg
+	}
+	if row.Exp != "" {
+		exp := row.Exp
+		l.Exp = &exp
+	}
+	if row.Ver != 0 {
+		ver := row.Ver
+		l.Ver = &ver
+	}
+	return SeqLabel{Seq: row.ID, Label: l}
+}
+
+// [LabelStore] implementation backed by a SQL database (sqlite or PostgreSQL), via gorm.
+type SQLLabelStore struct {
+	db *gorm.DB
+}
+
+var _ LabelStore = (*SQLLabelStore)(nil)
+
+// Creates a new store using the provided database, running any schema migrations.
+func NewSQLLabelStore(db *gorm.DB) (*SQLLabelStore, error) {
+	if err := db.AutoMigrate(&LabelRow{}); err != nil {
+		return nil, fmt.Errorf("migrating label store schema: %w", err)
+	}
+	return &SQLLabelStore{db: db}, nil
+}
+
+func (s *SQLLabelStore) Append(ctx context.Context, labels []*comatproto.LabelDefs_Label) ([]SeqLabel, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	rows := make([]LabelRow, len(labels))
+	for i, l := range labels {
+		rows[i] = rowFromLabel(l)
+	}
+	// a single insert creates the rows in order, with ascending IDs
+	if err := s.db.WithContext(ctx).Create(&rows).Error; err != nil {
+		return nil, fmt.Errorf("persisting labels: %w", err)
+	}
+	out := make([]SeqLabel, len(rows))
+	for i := range rows {
+		out[i] = rows[i].SeqLabel()
+	}
+	return out, nil
+}
+
+// escapes LIKE wildcards in a literal prefix
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *SQLLabelStore) Query(ctx context.Context, q LabelQuery) ([]SeqLabel, error) {
+	if q.Limit <= 0 || q.Limit > 1000 {
+		q.Limit = 100
+	}
+	query := s.db.WithContext(ctx).Where("id > ?", q.Cursor).Order("id ASC").Limit(q.Limit)
+	if len(q.URIPatterns) > 0 {
+		var exact []string
+		var clauses []string
+		var args []any
+		for _, p := range q.URIPatterns {
+			if prefix, ok := strings.CutSuffix(p, "*"); ok {
+				clauses = append(clauses, `uri LIKE ? ESCAPE '\'`)
+				args = append(args, likeEscaper.Replace(prefix)+"%")
+			} else {
+				exact = append(exact, p)
+			}
+		}
+		if len(exact) > 0 {
+			clauses = append(clauses, "uri IN ?")
+			args = append(args, exact)
+		}
+		query = query.Where(strings.Join(clauses, " OR "), args...)
+	}
+	if len(q.Sources) > 0 {
+		query = query.Where("src IN ?", q.Sources)
+	}
+	var rows []LabelRow
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make([]SeqLabel, len(rows))
+	for i := range rows {
+		out[i] = rows[i].SeqLabel()
+	}
+	return out, nil
+}
+
+func (s *SQLLabelStore) LatestSeq(ctx context.Context) (int64, error) {
+	var seq int64
+	if err := s.db.WithContext(ctx).Model(&LabelRow{}).Select("COALESCE(MAX(id), 0)").Scan(&seq).Error; err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
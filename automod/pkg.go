@@ -10,9 +10,14 @@ type AccountMeta = engine.AccountMeta
 type ProfileSummary = engine.ProfileSummary
 type AccountPrivate = engine.AccountPrivate
 type RuleSet = engine.RuleSet
+type RuleConfig = engine.RuleConfig
+type RuleConfigStore = engine.RuleConfigStore
 
 type Notifier = engine.Notifier
 type SlackNotifier = engine.SlackNotifier
+type WebhookNotifier = engine.WebhookNotifier
+type WebhookTarget = engine.WebhookTarget
+type MultiNotifier = engine.MultiNotifier
 
 type AccountContext = engine.AccountContext
 type RecordContext = engine.RecordContext
@@ -27,6 +32,8 @@ type ProfileRuleFunc = engine.ProfileRuleFunc
 type BlobRuleFunc = engine.BlobRuleFunc
 type NotificationRuleFunc = engine.NotificationRuleFunc
 type OzoneEventRuleFunc = engine.OzoneEventRuleFunc
+type CollectionRuleFunc = engine.CollectionRuleFunc
+type DecodedRecord = engine.DecodedRecord
 
 var (
 	ReportReasonSpam       = engine.ReportReasonSpam
@@ -43,4 +50,8 @@ var (
 	CreateOp = engine.CreateOp
 	UpdateOp = engine.UpdateOp
 	DeleteOp = engine.DeleteOp
+
+	NewRuleConfigStore  = engine.NewRuleConfigStore
+	NewWebhookNotifier  = engine.NewWebhookNotifier
+	LoadWebhookNotifier = engine.LoadWebhookNotifier
 )
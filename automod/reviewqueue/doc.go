@@ -0,0 +1,2 @@
+// Persistent queue of subjects (accounts or records) flagged by automod rules for human review.
+package reviewqueue
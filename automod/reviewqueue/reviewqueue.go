@@ -0,0 +1,64 @@
+package reviewqueue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	StatusOpen     = "open"
+	StatusClaimed  = "claimed"
+	StatusResolved = "resolved"
+)
+
+var (
+	ErrNotFound      = errors.New("review item not found")
+	ErrInvalidStatus = errors.New("review item is not in a valid state for this action")
+)
+
+// A single subject queued for human review, along with the rules which triggered it and supporting evidence.
+type ReviewItem struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// DID of the account, for both account-level and record-level items. There can only be one unresolved item per subject
+	SubjectDID string `gorm:"column:subject_did;index;uniqueIndex:idx_review_items_unresolved_subject,where:status <> 'resolved'" json:"subjectDid"`
+	// AT-URI of the record; empty for account-level items
+	SubjectURI string `gorm:"column:subject_uri;index;uniqueIndex:idx_review_items_unresolved_subject,where:status <> 'resolved'" json:"subjectUri,omitempty"`
+	SubjectCID string `gorm:"column:subject_cid" json:"subjectCid,omitempty"`
+	// Comma-separated names of the rules which requested review
+	Rules string `json:"rules"`
+	// Human-readable reason(s) for review, from the triggering rules
+	Reason string `json:"reason"`
+	// JSON-encoded object with supporting evidence (flags, labels, rule-supplied values, etc)
+	Evidence string `json:"evidence"`
+
+	Status     string     `gorm:"index" json:"status"`
+	ClaimedBy  string     `json:"claimedBy,omitempty"`
+	ClaimedAt  *time.Time `json:"claimedAt,omitempty"`
+	ResolvedBy string     `json:"resolvedBy,omitempty"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+	Resolution string     `json:"resolution,omitempty"`
+}
+
+// Subject key for de-duplication: record URI if present, otherwise account DID
+func (ri *ReviewItem) Subject() string {
+	if ri.SubjectURI != "" {
+		return ri.SubjectURI
+	}
+	return ri.SubjectDID
+}
+
+type ReviewQueue interface {
+	// Adds an item to the queue, unless there is already an unresolved item for the same subject. Returns true if a new item was created.
+	Enqueue(ctx context.Context, item *ReviewItem) (bool, error)
+	Get(ctx context.Context, id uint) (*ReviewItem, error)
+	// Lists items with the given status (or all items, if status is empty), in order of creation. "cursor" is the ID of the last item from a previous page, or zero.
+	List(ctx context.Context, status string, cursor uint, limit int) ([]ReviewItem, error)
+	// Marks an open item as claimed by the given reviewer. Claimed items can be re-claimed by the same reviewer.
+	Claim(ctx context.Context, id uint, reviewer string) (*ReviewItem, error)
+	// Marks an open or claimed item as resolved, with a free-form resolution (eg, "takedown", "no-action").
+	Resolve(ctx context.Context, id uint, reviewer, resolution string) (*ReviewItem, error)
+}
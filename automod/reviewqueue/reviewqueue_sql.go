@@ -0,0 +1,115 @@
+package reviewqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// [ReviewQueue] implementation backed by a SQL database (sqlite or PostgreSQL), via gorm.
+type SQLReviewQueue struct {
+	db *gorm.DB
+}
+
+var _ ReviewQueue = (*SQLReviewQueue)(nil)
+
+// Creates a new queue using the provided database, running any schema migrations.
+func NewSQLReviewQueue(db *gorm.DB) (*SQLReviewQueue, error) {
+	if err := db.AutoMigrate(&ReviewItem{}); err != nil {
+		return nil, fmt.Errorf("migrating review queue schema: %w", err)
+	}
+	return &SQLReviewQueue{db: db}, nil
+}
+
+func (q *SQLReviewQueue) Enqueue(ctx context.Context, item *ReviewItem) (bool, error) {
+	item.ID = 0
+	item.Status = StatusOpen
+	// an unresolved item for the same subject conflicts on the unique index, so concurrent enqueues can't both create one
+	res := q.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(item)
+	if res.Error != nil {
+		return false, fmt.Errorf("enqueueing review item: %w", res.Error)
+	}
+	return res.RowsAffected > 0, nil
+}
+
+func (q *SQLReviewQueue) Get(ctx context.Context, id uint) (*ReviewItem, error) {
+	var item ReviewItem
+	if err := q.db.WithContext(ctx).First(&item, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (q *SQLReviewQueue) List(ctx context.Context, status string, cursor uint, limit int) ([]ReviewItem, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	query := q.db.WithContext(ctx).Where("id > ?", cursor).Order("id ASC").Limit(limit)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var items []ReviewItem
+	if err := query.Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// runs the update function on a locked row, within a transaction
+func (q *SQLReviewQueue) update(ctx context.Context, id uint, fn func(item *ReviewItem) error) (*ReviewItem, error) {
+	var item ReviewItem
+	err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&item, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotFound
+			}
+			return err
+		}
+		if err := fn(&item); err != nil {
+			return err
+		}
+		return tx.Save(&item).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (q *SQLReviewQueue) Claim(ctx context.Context, id uint, reviewer string) (*ReviewItem, error) {
+	return q.update(ctx, id, func(item *ReviewItem) error {
+		switch {
+		case item.Status == StatusOpen:
+		case item.Status == StatusClaimed && item.ClaimedBy == reviewer:
+			return nil
+		default:
+			return ErrInvalidStatus
+		}
+		now := time.Now()
+		item.Status = StatusClaimed
+		item.ClaimedBy = reviewer
+		item.ClaimedAt = &now
+		return nil
+	})
+}
+
+func (q *SQLReviewQueue) Resolve(ctx context.Context, id uint, reviewer, resolution string) (*ReviewItem, error) {
+	return q.update(ctx, id, func(item *ReviewItem) error {
+		if item.Status == StatusResolved {
+			return ErrInvalidStatus
+		}
+		now := time.Now()
+		item.Status = StatusResolved
+		item.ResolvedBy = reviewer
+		item.ResolvedAt = &now
+		item.Resolution = resolution
+		return nil
+	})
+}
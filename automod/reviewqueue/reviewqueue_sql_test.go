@@ -0,0 +1,115 @@
+package reviewqueue
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testQueue(t *testing.T) *SQLReviewQueue {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in-memory sqlite databases are per-connection
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	q, err := NewSQLReviewQueue(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestSQLReviewQueue(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	q := testQueue(t)
+
+	created, err := q.Enqueue(ctx, &ReviewItem{SubjectDID: "did:plc:abc111", Rules: "SomeRule", Reason: "suspicious"})
+	assert.NoError(err)
+	assert.True(created)
+
+	// same account subject is de-duplicated while unresolved
+	created, err = q.Enqueue(ctx, &ReviewItem{SubjectDID: "did:plc:abc111", Rules: "OtherRule"})
+	assert.NoError(err)
+	assert.False(created)
+
+	// but a record by the same account is distinct
+	created, err = q.Enqueue(ctx, &ReviewItem{SubjectDID: "did:plc:abc111", SubjectURI: "at://did:plc:abc111/app.bsky.feed.post/abc123"})
+	assert.NoError(err)
+	assert.True(created)
+
+	items, err := q.List(ctx, StatusOpen, 0, 10)
+	assert.NoError(err)
+	assert.Equal(2, len(items))
+	first := items[0]
+	assert.Equal("SomeRule", first.Rules)
+
+	// pagination
+	items, err = q.List(ctx, "", first.ID, 10)
+	assert.NoError(err)
+	assert.Equal(1, len(items))
+
+	item, err := q.Claim(ctx, first.ID, "alice")
+	assert.NoError(err)
+	assert.Equal(StatusClaimed, item.Status)
+	assert.NotNil(item.ClaimedAt)
+
+	_, err = q.Claim(ctx, first.ID, "bob")
+	assert.ErrorIs(err, ErrInvalidStatus)
+
+	item, err = q.Resolve(ctx, first.ID, "alice", "no-action")
+	assert.NoError(err)
+	assert.Equal(StatusResolved, item.Status)
+	assert.Equal("no-action", item.Resolution)
+
+	_, err = q.Resolve(ctx, first.ID, "alice", "no-action")
+	assert.ErrorIs(err, ErrInvalidStatus)
+
+	_, err = q.Get(ctx, 9999)
+	assert.ErrorIs(err, ErrNotFound)
+
+	// once resolved, the subject can be queued again
+	created, err = q.Enqueue(ctx, &ReviewItem{SubjectDID: "did:plc:abc111"})
+	assert.NoError(err)
+	assert.True(created)
+}
+
+func TestSQLReviewQueueConcurrentEnqueue(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	q := testQueue(t)
+
+	var wg sync.WaitGroup
+	var created atomic.Int32
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := q.Enqueue(ctx, &ReviewItem{SubjectDID: "did:plc:abc111", SubjectURI: "at://did:plc:abc111/app.bsky.feed.post/abc123"})
+			assert.NoError(err)
+			if ok {
+				created.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(int32(1), created.Load())
+
+	// the database itself refuses a second unresolved item for a subject, however it's inserted
+	assert.Error(q.db.Create(&ReviewItem{SubjectDID: "did:plc:abc111", SubjectURI: "at://did:plc:abc111/app.bsky.feed.post/abc123", Status: StatusClaimed}).Error)
+	assert.NoError(q.db.Create(&ReviewItem{SubjectDID: "did:plc:abc111", SubjectURI: "at://did:plc:abc111/app.bsky.feed.post/abc123", Status: StatusResolved}).Error)
+
+	items, err := q.List(ctx, "", 0, 10)
+	assert.NoError(err)
+	assert.Equal(2, len(items))
+}
@@ -62,6 +62,11 @@ func DefaultRules() automod.RuleSet {
 		OzoneEventRules: []automod.OzoneEventRuleFunc{
 			HarassmentProtectionOzoneEventRule,
 		},
+		CollectionRules: map[string][]automod.CollectionRuleFunc{
+			"app.bsky.graph.starterpack": {
+				BadWordStarterPackRule,
+			},
+		},
 	}
 	return rules
 }
@@ -125,7 +125,7 @@ func HarassmentTrivialPostRule(c *automod.RecordContext, post *appbsky.FeedPost)
 	c.Increment("trivial-harassing", did)
 	count := c.GetCount("trivial-harassing", did, countstore.PeriodDay)
 
-	if count > 5 {
+	if count > c.Threshold("trivial-harassing-posts", 5) {
 		//c.AddRecordFlag("trivial-harassing-post")
 		c.ReportAccount(automod.ReportReasonOther, fmt.Sprintf("possible targetted harassment (also labeled; remove label if this isn't harassment!)"))
 		c.AddAccountLabel("!hide")
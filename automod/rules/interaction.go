@@ -7,6 +7,7 @@ import (
 	"github.com/bluesky-social/indigo/automod/countstore"
 )
 
+// defaults for the "interaction-churn" and "bulk-follows" rule config thresholds
 var interactionDailyThreshold = 800
 var followsDailyThreshold = 3000
 
@@ -16,13 +17,14 @@ var _ automod.RecordRuleFunc = InteractionChurnRule
 func InteractionChurnRule(c *automod.RecordContext) error {
 
 	did := c.Account.Identity.DID.String()
+	churnThreshold := c.Threshold("interaction-churn", interactionDailyThreshold)
 	switch c.RecordOp.Collection {
 	case "app.bsky.feed.like":
 		c.Increment("like", did)
 		created := c.GetCount("like", did, countstore.PeriodDay)
 		deleted := c.GetCount("unlike", did, countstore.PeriodDay)
 		ratio := float64(deleted) / float64(created)
-		if created > interactionDailyThreshold && deleted > interactionDailyThreshold && ratio > 0.5 {
+		if created > churnThreshold && deleted > churnThreshold && ratio > 0.5 {
 			c.Logger.Info("high-like-churn", "created-today", created, "deleted-today", deleted)
 			c.AddAccountFlag("high-like-churn")
 			c.ReportAccount(automod.ReportReasonSpam, fmt.Sprintf("interaction churn: %d likes, %d unlikes today (so far)", created, deleted))
@@ -34,7 +36,7 @@ func InteractionChurnRule(c *automod.RecordContext) error {
 		created := c.GetCount("follow", did, countstore.PeriodDay)
 		deleted := c.GetCount("unfollow", did, countstore.PeriodDay)
 		ratio := float64(deleted) / float64(created)
-		if created > interactionDailyThreshold && deleted > interactionDailyThreshold && ratio > 0.5 {
+		if created > churnThreshold && deleted > churnThreshold && ratio > 0.5 {
 			c.Logger.Info("high-follow-churn", "created-today", created, "deleted-today", deleted)
 			c.AddAccountFlag("high-follow-churn")
 			c.ReportAccount(automod.ReportReasonSpam, fmt.Sprintf("interaction churn: %d follows, %d unfollows today (so far)", created, deleted))
@@ -42,7 +44,7 @@ func InteractionChurnRule(c *automod.RecordContext) error {
 			return nil
 		}
 		// just generic bulk following
-		if created > followsDailyThreshold {
+		if created > c.Threshold("bulk-follows", followsDailyThreshold) {
 			c.Logger.Info("bulk-follower", "created-today", created)
 			c.AddAccountFlag("bulk-follower")
 			c.ReportAccount(automod.ReportReasonSpam, fmt.Sprintf("bulk following: %d follows today (so far)", created))
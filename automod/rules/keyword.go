@@ -158,6 +158,31 @@ func BadWordOtherRecordRule(c *automod.RecordContext) error {
 
 var _ automod.RecordRuleFunc = BadWordOtherRecordRule
 
+// scans starter pack names for explicit slurs or bad word tokens
+func BadWordStarterPackRule(c *automod.RecordContext, rec *automod.DecodedRecord) error {
+	sp, ok := rec.Typed.(*appbsky.GraphStarterpack)
+	if !ok {
+		return nil
+	}
+	word := keyword.SlugContainsExplicitSlur(keyword.Slugify(sp.Name))
+	if word == "" {
+		for _, tok := range keyword.TokenizeText(sp.Name) {
+			if c.InSet("bad-words", tok) {
+				word = tok
+				break
+			}
+		}
+	}
+	if word != "" {
+		c.AddRecordFlag("bad-word-name")
+		c.ReportRecord(automod.ReportReasonRude, fmt.Sprintf("possible bad word in starter pack name: %s", word))
+		c.Notify("slack")
+	}
+	return nil
+}
+
+var _ automod.CollectionRuleFunc = BadWordStarterPackRule
+
 // scans the record-key for all records
 func BadWordRecordKeyRule(c *automod.RecordContext) error {
 	// check record key
@@ -12,6 +12,7 @@ import (
 
 var _ automod.PostRuleFunc = DistinctMentionsRule
 
+// default for the "distinct-mentions" rule config threshold
 var mentionHourlyThreshold = 40
 
 // DistinctMentionsRule looks for accounts which mention an unusually large number of distinct accounts per period.
@@ -35,7 +36,7 @@ func DistinctMentionsRule(c *automod.RecordContext, post *appbsky.FeedPost) erro
 	if !newMentions {
 		return nil
 	}
-	if mentionHourlyThreshold <= c.GetCountDistinct("mentions", did, countstore.PeriodHour) {
+	if c.Threshold("distinct-mentions", mentionHourlyThreshold) <= c.GetCountDistinct("mentions", did, countstore.PeriodHour) {
 		c.AddAccountFlag("high-distinct-mentions")
 		c.Notify("slack")
 	}
@@ -43,6 +44,7 @@ func DistinctMentionsRule(c *automod.RecordContext, post *appbsky.FeedPost) erro
 	return nil
 }
 
+// default for the "young-account-distinct-mentions" rule config threshold
 var youngMentionAccountLimit = 12
 var _ automod.PostRuleFunc = YoungAccountDistinctMentionsRule
 
@@ -84,7 +86,7 @@ func YoungAccountDistinctMentionsRule(c *automod.RecordContext, post *appbsky.Fe
 	}
 
 	count := c.GetCountDistinct("young-mention", did, countstore.PeriodHour) + newMentions
-	if count >= youngMentionAccountLimit {
+	if count >= c.Threshold("young-account-distinct-mentions", youngMentionAccountLimit) {
 		c.AddAccountFlag("new-account-distinct-account-mention")
 		c.ReportAccount(automod.ReportReasonRude, fmt.Sprintf("possible spam (new account, mentioned %d distinct accounts in past hour)", count))
 		c.Notify("slack")
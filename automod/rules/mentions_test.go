@@ -0,0 +1,83 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/engine"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistinctMentionsRuleThreshold(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := engine.EngineTestFixture()
+	am1 := automod.AccountMeta{
+		Identity: &identity.Identity{
+			DID:    syntax.DID("did:plc:abc111"),
+			Handle: syntax.Handle("handle.example.com"),
+		},
+	}
+
+	eng.Rules = automod.RuleSet{
+		PostRules: []automod.PostRuleFunc{
+			DistinctMentionsRule,
+		},
+	}
+
+	post := func(rkey string, mentions int) {
+		p := appbsky.FeedPost{Text: "hello"}
+		for i := range mentions {
+			p.Facets = append(p.Facets, &appbsky.RichtextFacet{
+				Features: []*appbsky.RichtextFacet_Features_Elem{
+					&appbsky.RichtextFacet_Features_Elem{
+						RichtextFacet_Mention: &appbsky.RichtextFacet_Mention{Did: fmt.Sprintf("did:plc:other%d", i)},
+					},
+				},
+				Index: &appbsky.RichtextFacet_ByteSlice{ByteStart: 0, ByteEnd: 5},
+			})
+		}
+		buf := new(bytes.Buffer)
+		assert.NoError(p.MarshalCBOR(buf))
+		cid1 := syntax.CID("cid123")
+		op := engine.RecordOp{
+			Action:     engine.CreateOp,
+			DID:        am1.Identity.DID,
+			Collection: syntax.NSID("app.bsky.feed.post"),
+			RecordKey:  syntax.RecordKey(rkey),
+			CID:        &cid1,
+			RecordCBOR: buf.Bytes(),
+		}
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+	}
+	flags := func() []string {
+		f, err := eng.Flags.Get(ctx, am1.Identity.DID.String())
+		assert.NoError(err)
+		return f
+	}
+
+	// well under the default threshold
+	post("abc111", 5)
+	post("abc222", 5)
+	assert.Empty(flags())
+
+	// the threshold can be lowered by rule config
+	path := filepath.Join(t.TempDir(), "rules.json")
+	assert.NoError(os.WriteFile(path, []byte(`{"version": "v1", "thresholds": {"distinct-mentions": 5}}`), 0644))
+	eng.RuleConfig = engine.NewRuleConfigStore(path, "")
+	_, err := eng.RuleConfig.Reload(ctx)
+	assert.NoError(err)
+
+	post("abc333", 5)
+	assert.Equal([]string{"high-distinct-mentions"}, flags())
+}
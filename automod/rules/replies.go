@@ -35,6 +35,7 @@ func ReplyCountPostRule(c *automod.RecordContext, post *appbsky.FeedPost) error
 	return nil
 }
 
+// defaults for the "identical-replies" and "identical-replies-action" rule config thresholds
 // triggers on the N+1 post
 // var identicalReplyLimit = 6
 // TODO: bumping temporarily
@@ -70,12 +71,12 @@ func IdenticalReplyPostRule(c *automod.RecordContext, post *appbsky.FeedPost) er
 	c.IncrementPeriod("reply-text", bucket, period)
 
 	count := c.GetCount("reply-text", bucket, period)
-	if count >= identicalReplyLimit {
+	if count >= c.Threshold("identical-replies", identicalReplyLimit) {
 		c.AddAccountFlag("multi-identical-reply")
 		c.ReportAccount(automod.ReportReasonSpam, fmt.Sprintf("possible spam (new account, %d identical reply-posts today)", count))
 		c.Notify("slack")
 	}
-	if count >= identicalReplyActionLimit && utf8.RuneCountInString(post.Text) > 100 {
+	if count >= c.Threshold("identical-replies-action", identicalReplyActionLimit) && utf8.RuneCountInString(post.Text) > 100 {
 		c.ReportAccount(automod.ReportReasonRude, fmt.Sprintf("likely spam/harassment (new account, %d identical reply-posts today), actioned (remove label urgently if account is ok)", count))
 		c.AddAccountLabel("!warn")
 		c.Notify("slack")
@@ -85,6 +86,7 @@ func IdenticalReplyPostRule(c *automod.RecordContext, post *appbsky.FeedPost) er
 }
 
 // Similar to above rule but only counts replies to the same post. More aggressively applies a spam label to new accounts that are less than a day old.
+// defaults for the "identical-replies-same-parent" and "identical-replies-same-parent-max-posts" rule config thresholds
 var identicalReplySameParentLimit = 3
 var identicalReplySameParentMaxAge = 24 * time.Hour
 var identicalReplySameParentMaxPosts int64 = 50
@@ -100,7 +102,7 @@ func IdenticalReplyPostSameParentRule(c *automod.RecordContext, post *appbsky.Fe
 	}
 
 	postCount := c.Account.PostsCount
-	if AccountIsOlderThan(&c.AccountContext, identicalReplySameParentMaxAge) || postCount >= identicalReplySameParentMaxPosts {
+	if AccountIsOlderThan(&c.AccountContext, identicalReplySameParentMaxAge) || postCount >= int64(c.Threshold("identical-replies-same-parent-max-posts", int(identicalReplySameParentMaxPosts))) {
 		return nil
 	}
 
@@ -109,7 +111,7 @@ func IdenticalReplyPostSameParentRule(c *automod.RecordContext, post *appbsky.Fe
 	c.IncrementPeriod("reply-text-same-post", bucket, period)
 
 	count := c.GetCount("reply-text-same-post", bucket, period)
-	if count >= identicalReplySameParentLimit {
+	if count >= c.Threshold("identical-replies-same-parent", identicalReplySameParentLimit) {
 		c.AddAccountFlag("multi-identical-reply-same-post")
 		c.ReportAccount(automod.ReportReasonSpam, fmt.Sprintf("possible spam (%d identical reply-posts to same post today)", count))
 		c.AddAccountLabel("spam")
@@ -119,6 +121,7 @@ func IdenticalReplyPostSameParentRule(c *automod.RecordContext, post *appbsky.Fe
 	return nil
 }
 
+// default for the "young-account-distinct-replies" rule config threshold
 // TODO: bumping temporarily
 // var youngReplyAccountLimit = 12
 var youngReplyAccountLimit = 200
@@ -159,7 +162,7 @@ func YoungAccountDistinctRepliesRule(c *automod.RecordContext, post *appbsky.Fee
 	c.IncrementDistinct("young-reply-to", did, parentDID.String())
 	// NOTE: won't include the increment from this event
 	count := c.GetCountDistinct("young-reply-to", did, countstore.PeriodHour)
-	if count >= youngReplyAccountLimit {
+	if count >= c.Threshold("young-account-distinct-replies", youngReplyAccountLimit) {
 		c.AddAccountFlag("new-account-distinct-account-reply")
 		c.ReportAccount(automod.ReportReasonRude, fmt.Sprintf("possible spam (new account, reply-posts to %d distinct accounts in past hour)", count))
 		c.Notify("slack")
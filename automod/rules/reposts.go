@@ -9,6 +9,7 @@ import (
 	"github.com/bluesky-social/indigo/automod/countstore"
 )
 
+// defaults for the "reposts-without-posts", "reposts-with-few-posts", and "few-posts" rule config thresholds
 var dailyRepostThresholdWithoutPost = 30
 var dailyRepostThresholdWithLowPost = 100
 var dailyPostThresholdWithHighRepost = 5
@@ -38,7 +39,7 @@ func TooManyRepostRule(c *automod.RecordContext) error {
 		// +1 to avoid potential divide by 0 issue
 		repostCount := c.GetCount("repost", did, countstore.PeriodDay)
 		postCount := c.GetCount("post", did, countstore.PeriodDay)
-		highRepost := (repostCount >= dailyRepostThresholdWithoutPost && postCount < 1) || (repostCount >= dailyRepostThresholdWithLowPost && postCount < dailyPostThresholdWithHighRepost)
+		highRepost := (repostCount >= c.Threshold("reposts-without-posts", dailyRepostThresholdWithoutPost) && postCount < 1) || (repostCount >= c.Threshold("reposts-with-few-posts", dailyRepostThresholdWithLowPost) && postCount < c.Threshold("few-posts", dailyPostThresholdWithHighRepost))
 		if highRepost {
 			c.Logger.Info("high-repost-count", "reposted-today", repostCount, "posted-today", postCount)
 			c.AddAccountFlag("high-repost-count")
@@ -0,0 +1,238 @@
+package scoring
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Number of consecutive failed calls before the circuit breaker trips
+const failureThresh = 10
+
+// One item to be scored by the external service.
+type ScoreInput struct {
+	// Caller-assigned identifier, echoed back in the result (eg, an AT-URI)
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// Scores returned by the external service for a single input, keyed by classifier label (eg, "toxicity")
+type ScoreResult struct {
+	ID     string             `json:"id"`
+	Scores map[string]float64 `json:"scores"`
+}
+
+type scoreBatchRequest struct {
+	Inputs []ScoreInput `json:"inputs"`
+}
+
+type scoreBatchResponse struct {
+	Results []ScoreResult `json:"results"`
+}
+
+type pendingScore struct {
+	input ScoreInput
+	resp  chan pendingResult
+}
+
+type pendingResult struct {
+	scores map[string]float64
+	err    error
+}
+
+// Client for a generic HTTP scoring service.
+//
+// The service is expected to accept a JSON POST to "/score" with a body like `{"inputs": [{"id": "...", "text": "..."}]}`, and respond with `{"results": [{"id": "...", "scores": {"toxicity": 0.93}}]}`.
+//
+// Concurrent calls to [ScoringClient.Score] are combined into batch requests. A circuit breaker stops calling the service for a period after repeated failures.
+type ScoringClient struct {
+	// Short name for this service, used as a prefix for score names (eg, "perspective" results in "perspective/toxicity")
+	Name  string
+	Host  string
+	Token string
+	// Max number of inputs to send in a single request
+	BatchSize int
+	// Max time to wait for a batch to fill before sending
+	BatchDelay time.Duration
+	// How long the circuit breaker stays open after tripping
+	BreakerCooldown time.Duration
+
+	breakerEOL time.Time
+	breakerLk  sync.Mutex
+	failures   int
+
+	queue   chan pendingScore
+	startLk sync.Once
+	c       *http.Client
+}
+
+func NewScoringClient(name, host, token string) *ScoringClient {
+	return &ScoringClient{
+		Name:            name,
+		Host:            host,
+		Token:           token,
+		BatchSize:       32,
+		BatchDelay:      20 * time.Millisecond,
+		BreakerCooldown: time.Minute,
+		queue:           make(chan pendingScore, 1024),
+		c: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+func (sc *ScoringClient) available() bool {
+	sc.breakerLk.Lock()
+	defer sc.breakerLk.Unlock()
+	if sc.breakerEOL.IsZero() {
+		return true
+	}
+
+	if time.Now().After(sc.breakerEOL) {
+		sc.breakerEOL = time.Time{}
+		return true
+	}
+
+	return false
+}
+
+func (sc *ScoringClient) recordCallResult(success bool) {
+	sc.breakerLk.Lock()
+	defer sc.breakerLk.Unlock()
+	if !sc.breakerEOL.IsZero() {
+		return
+	}
+
+	if success {
+		sc.failures = 0
+	} else {
+		sc.failures++
+		if sc.failures > failureThresh {
+			slog.Warn("scoring service circuit breaker tripped", "service", sc.Name, "cooldown", sc.BreakerCooldown)
+			sc.breakerEOL = time.Now().Add(sc.BreakerCooldown)
+			sc.failures = 0
+		}
+	}
+}
+
+// Scores a single input. The request may be batched together with other concurrent calls. Returns an error immediately if the circuit breaker is open.
+func (sc *ScoringClient) Score(ctx context.Context, input ScoreInput) (map[string]float64, error) {
+	if !sc.available() {
+		scoringAPICount.WithLabelValues(sc.Name, "breaker").Inc()
+		return nil, fmt.Errorf("scoring service temporarily unavailable: %s", sc.Name)
+	}
+	sc.startLk.Do(func() {
+		go sc.runBatcher()
+	})
+
+	p := pendingScore{input: input, resp: make(chan pendingResult, 1)}
+	select {
+	case sc.queue <- p:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case res := <-p.resp:
+		return res.scores, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// collects pending requests in to batches, and dispatches them concurrently
+func (sc *ScoringClient) runBatcher() {
+	for first := range sc.queue {
+		batch := []pendingScore{first}
+		timer := time.NewTimer(sc.BatchDelay)
+	fill:
+		for len(batch) < sc.BatchSize {
+			select {
+			case p := <-sc.queue:
+				batch = append(batch, p)
+			case <-timer.C:
+				break fill
+			}
+		}
+		timer.Stop()
+		go sc.sendBatch(batch)
+	}
+}
+
+func (sc *ScoringClient) sendBatch(batch []pendingScore) {
+	inputs := make([]ScoreInput, len(batch))
+	for i, p := range batch {
+		inputs[i] = p.input
+	}
+	results, err := sc.scoreBatch(context.Background(), inputs)
+	sc.recordCallResult(err == nil)
+	byID := make(map[string]map[string]float64, len(results))
+	for _, r := range results {
+		byID[r.ID] = r.Scores
+	}
+	for _, p := range batch {
+		if err != nil {
+			p.resp <- pendingResult{err: err}
+			continue
+		}
+		scores, ok := byID[p.input.ID]
+		if !ok {
+			p.resp <- pendingResult{err: fmt.Errorf("scoring service returned no result for input: %s", p.input.ID)}
+			continue
+		}
+		p.resp <- pendingResult{scores: scores}
+	}
+}
+
+func (sc *ScoringClient) scoreBatch(ctx context.Context, inputs []ScoreInput) ([]ScoreResult, error) {
+	body, err := json.Marshal(scoreBatchRequest{Inputs: inputs})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", sc.Host+"/score", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sc.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+sc.Token)
+	}
+
+	start := time.Now()
+	resp, err := sc.c.Do(req)
+	duration := time.Since(start)
+	if err != nil {
+		scoringAPICount.WithLabelValues(sc.Name, "error").Inc()
+		return nil, fmt.Errorf("scoring service request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	scoringAPIDuration.WithLabelValues(sc.Name).Observe(duration.Seconds())
+	scoringAPICount.WithLabelValues(sc.Name, strconv.Itoa(resp.StatusCode)).Inc()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scoring service HTTP error: %d", resp.StatusCode)
+	}
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scoring service response: %v", err)
+	}
+	var out scoreBatchResponse
+	if err := json.Unmarshal(respBytes, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse scoring service response JSON: %v", err)
+	}
+	return out.Results, nil
+}
+
+// Full score name as exposed to rules: the client name, a slash, and the label (or just the label if the client has no name)
+func (sc *ScoringClient) ScoreName(label string) string {
+	if sc.Name == "" {
+		return label
+	}
+	return sc.Name + "/" + label
+}
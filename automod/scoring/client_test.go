@@ -0,0 +1,75 @@
+package scoring
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScoringClientBatch(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req scoreBatchRequest
+		assert.NoError(json.NewDecoder(r.Body).Decode(&req))
+		resp := scoreBatchResponse{}
+		for _, in := range req.Inputs {
+			score := 0.1
+			if in.Text == "bad" {
+				score = 0.9
+			}
+			resp.Results = append(resp.Results, ScoreResult{ID: in.ID, Scores: map[string]float64{"toxicity": score}})
+		}
+		assert.NoError(json.NewEncoder(w).Encode(resp))
+	}))
+	defer srv.Close()
+
+	sc := NewScoringClient("test", srv.URL, "")
+	sc.BatchDelay = 50 * time.Millisecond
+
+	var wg sync.WaitGroup
+	for i, text := range []string{"good", "bad", "good", "bad"} {
+		wg.Add(1)
+		go func(id, text string) {
+			defer wg.Done()
+			scores, err := sc.Score(ctx, ScoreInput{ID: id, Text: text})
+			assert.NoError(err)
+			if text == "bad" {
+				assert.Equal(0.9, scores["toxicity"])
+			} else {
+				assert.Equal(0.1, scores["toxicity"])
+			}
+		}(string(rune('a'+i)), text)
+	}
+	wg.Wait()
+	assert.Equal(int64(1), calls.Load())
+	assert.Equal("test/toxicity", sc.ScoreName("toxicity"))
+}
+
+func TestScoringClientBreaker(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	sc := NewScoringClient("test", srv.URL, "")
+	sc.BatchDelay = time.Millisecond
+	for i := 0; i <= failureThresh; i++ {
+		_, err := sc.Score(ctx, ScoreInput{ID: "a", Text: "text"})
+		assert.Error(err)
+	}
+	assert.False(sc.available())
+}
@@ -0,0 +1,2 @@
+// automod helpers for calling external scoring services (eg, toxicity or spam classifiers), and exposing the resulting scores to rules
+package scoring
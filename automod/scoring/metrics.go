@@ -0,0 +1,16 @@
+package scoring
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var scoringAPIDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "automod_scoring_api_duration_sec",
+	Help: "Duration of external scoring service API calls",
+}, []string{"service"})
+
+var scoringAPICount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_scoring_api_count",
+	Help: "Number of external scoring service API calls, by HTTP status code (or 'error' or 'breaker')",
+}, []string{"service", "status"})
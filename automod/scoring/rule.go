@@ -0,0 +1,55 @@
+package scoring
+
+import (
+	"strings"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/automod"
+)
+
+// Scores post text (including image alt text), and sets the results as scores on the context for subsequent rules to read with `c.GetScore()`.
+//
+// This rule should be included in the ruleset ahead of any rules which read the scores. Scoring failures are logged but are not fatal.
+func (sc *ScoringClient) ScorePostRule(c *automod.RecordContext, post *appbsky.FeedPost) error {
+	parts := []string{post.Text}
+	if post.Embed != nil && post.Embed.EmbedImages != nil {
+		for _, img := range post.Embed.EmbedImages.Images {
+			if img.Alt != "" {
+				parts = append(parts, img.Alt)
+			}
+		}
+	}
+	sc.scoreText(c, strings.Join(parts, "\n"))
+	return nil
+}
+
+var _ automod.PostRuleFunc = (&ScoringClient{}).ScorePostRule
+
+// Same as ScorePostRule, but for the display name and description of profile records.
+func (sc *ScoringClient) ScoreProfileRule(c *automod.RecordContext, profile *appbsky.ActorProfile) error {
+	parts := []string{}
+	if profile.DisplayName != nil {
+		parts = append(parts, *profile.DisplayName)
+	}
+	if profile.Description != nil {
+		parts = append(parts, *profile.Description)
+	}
+	sc.scoreText(c, strings.Join(parts, "\n"))
+	return nil
+}
+
+var _ automod.ProfileRuleFunc = (&ScoringClient{}).ScoreProfileRule
+
+func (sc *ScoringClient) scoreText(c *automod.RecordContext, text string) {
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	scores, err := sc.Score(c.Ctx, ScoreInput{ID: c.RecordOp.ATURI().String(), Text: text})
+	if err != nil {
+		c.Logger.Warn("external scoring failed", "service", sc.Name, "err", err)
+		return
+	}
+	for label, val := range scores {
+		c.SetScore(sc.ScoreName(label), val)
+	}
+}
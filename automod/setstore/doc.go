@@ -1,2 +1,2 @@
-// Interface for simple sets of strings, with fast inclusion checks.
+// Interface for simple sets of strings, with fast inclusion checks, and implementations using redis and in-process memory.
 package setstore
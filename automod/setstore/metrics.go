@@ -0,0 +1,11 @@
+package setstore
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var remoteSetRefreshCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_remote_set_refreshes",
+	Help: "Number of remote set refresh attempts, by result",
+}, []string{"result"})
@@ -0,0 +1,280 @@
+package setstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Max size of a remote set response body
+const maxRemoteSetBytes = 32 * 1024 * 1024
+
+// Set store which supports atomically replacing the full contents of a named set. Both [MemSetStore] and [RedisSetStore] implement this.
+type SetReplacer interface {
+	ReplaceSet(ctx context.Context, name string, vals []string) error
+}
+
+// A single remote source of set contents.
+//
+// The source can be an HTTP(S) URL, or an AT-URI pointing to a record (eg, published by a labeler account). HTTP responses can either be JSON (an array of strings, or an object mapping set names to arrays of strings), or plain text with one value per line (blank lines and lines starting with '#' are ignored). Records are expected to have a "values" field (array of strings), or a "sets" field (object mapping set names to arrays of strings).
+type RemoteSetSource struct {
+	// Name of the set to replace. May be empty if the source contains a mapping of set names to values.
+	SetName string
+	URL     string
+
+	// caching state from the previous successful fetch
+	etag         string
+	lastModified string
+	recordCID    string
+}
+
+// Parses a source spec string, which is either a bare URL (or AT-URI), or a set name and URL separated by '=' (eg, "bad-words=https://example.com/bad-words.txt").
+func ParseRemoteSetSource(spec string) (*RemoteSetSource, error) {
+	name := ""
+	u := spec
+	if idx := strings.Index(spec, "="); idx > 0 && !strings.Contains(spec[:idx], "/") {
+		name = spec[:idx]
+		u = spec[idx+1:]
+	}
+	if strings.HasPrefix(u, "at://") {
+		if _, err := syntax.ParseATURI(u); err != nil {
+			return nil, fmt.Errorf("invalid remote set AT-URI: %w", err)
+		}
+	} else if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+		return nil, fmt.Errorf("remote set source must be an HTTP(S) URL or AT-URI: %s", u)
+	}
+	return &RemoteSetSource{SetName: name, URL: u}, nil
+}
+
+// Periodically fetches sets from remote sources, and loads them in to a set store.
+//
+// HTTP sources are re-fetched with conditional requests (ETag and Last-Modified), and record sources are only re-loaded if the record CID has changed, so unchanged sets are cheap to poll.
+type RemoteSetSubscriber struct {
+	Store      SetReplacer
+	Sources    []*RemoteSetSource
+	HTTPClient *http.Client
+	// used to resolve the PDS host for AT-URI sources; may be nil if there are no such sources
+	Directory identity.Directory
+
+	// serializes refreshes, which mutate caching state on sources
+	mu sync.Mutex
+}
+
+func NewRemoteSetSubscriber(store SetReplacer, dir identity.Directory, sources []*RemoteSetSource) *RemoteSetSubscriber {
+	return &RemoteSetSubscriber{
+		Store:     store,
+		Sources:   sources,
+		Directory: dir,
+		HTTPClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Fetches all sources, loading any which have changed. Keeps going if individual sources fail, and returns the first error.
+func (s *RemoteSetSubscriber) RefreshAll(ctx context.Context, logger *slog.Logger) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var firstErr error
+	for _, src := range s.Sources {
+		changed, err := s.refresh(ctx, src)
+		if err != nil {
+			remoteSetRefreshCount.WithLabelValues("error").Inc()
+			logger.Error("failed to refresh remote set", "url", src.URL, "err", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if changed {
+			remoteSetRefreshCount.WithLabelValues("updated").Inc()
+			logger.Info("loaded updated remote set", "url", src.URL, "name", src.SetName)
+		} else {
+			remoteSetRefreshCount.WithLabelValues("unchanged").Inc()
+		}
+	}
+	return firstErr
+}
+
+// Refreshes all sources on a fixed interval until the context is cancelled. Errors are logged, and the previous set contents are kept.
+func (s *RemoteSetSubscriber) Run(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = s.RefreshAll(ctx, logger)
+		}
+	}
+}
+
+func (s *RemoteSetSubscriber) refresh(ctx context.Context, src *RemoteSetSource) (bool, error) {
+	if strings.HasPrefix(src.URL, "at://") {
+		return s.refreshRecord(ctx, src)
+	}
+	return s.refreshHTTP(ctx, src)
+}
+
+func (s *RemoteSetSubscriber) refreshHTTP(ctx context.Context, src *RemoteSetSource) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", src.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	if src.etag != "" {
+		req.Header.Set("If-None-Match", src.etag)
+	}
+	if src.lastModified != "" {
+		req.Header.Set("If-Modified-Since", src.lastModified)
+	}
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("fetching remote set: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("fetching remote set: HTTP status %d", resp.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteSetBytes))
+	if err != nil {
+		return false, fmt.Errorf("reading remote set: %w", err)
+	}
+	sets, err := parseRemoteSets(raw, src.SetName)
+	if err != nil {
+		return false, err
+	}
+	if err := s.replaceAll(ctx, sets); err != nil {
+		return false, err
+	}
+	src.etag = resp.Header.Get("ETag")
+	src.lastModified = resp.Header.Get("Last-Modified")
+	return true, nil
+}
+
+type remoteSetRecord struct {
+	URI   string `json:"uri"`
+	CID   string `json:"cid"`
+	Value struct {
+		Values []string            `json:"values"`
+		Sets   map[string][]string `json:"sets"`
+	} `json:"value"`
+}
+
+func (s *RemoteSetSubscriber) refreshRecord(ctx context.Context, src *RemoteSetSource) (bool, error) {
+	if s.Directory == nil {
+		return false, fmt.Errorf("identity directory required to fetch record-based remote sets")
+	}
+	aturi, err := syntax.ParseATURI(src.URL)
+	if err != nil {
+		return false, err
+	}
+	ident, err := s.Directory.Lookup(ctx, aturi.Authority())
+	if err != nil {
+		return false, fmt.Errorf("resolving remote set record authority: %w", err)
+	}
+	pds := ident.PDSEndpoint()
+	if pds == "" {
+		return false, fmt.Errorf("no PDS endpoint for remote set record authority: %s", ident.DID)
+	}
+	q := url.Values{}
+	q.Set("repo", ident.DID.String())
+	q.Set("collection", aturi.Collection().String())
+	q.Set("rkey", aturi.RecordKey().String())
+	req, err := http.NewRequestWithContext(ctx, "GET", pds+"/xrpc/com.atproto.repo.getRecord?"+q.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("fetching remote set record: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("fetching remote set record: HTTP status %d", resp.StatusCode)
+	}
+	var rec remoteSetRecord
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxRemoteSetBytes)).Decode(&rec); err != nil {
+		return false, fmt.Errorf("parsing remote set record: %w", err)
+	}
+	if rec.CID != "" && rec.CID == src.recordCID {
+		return false, nil
+	}
+	sets := rec.Value.Sets
+	if sets == nil {
+		if src.SetName == "" {
+			return false, fmt.Errorf("remote set record has no 'sets' field, and no set name was configured")
+		}
+		sets = map[string][]string{src.SetName: rec.Value.Values}
+	} else if src.SetName != "" {
+		// only load the configured set from the record
+		sets = map[string][]string{src.SetName: sets[src.SetName]}
+	}
+	if err := s.replaceAll(ctx, sets); err != nil {
+		return false, err
+	}
+	src.recordCID = rec.CID
+	return true, nil
+}
+
+func (s *RemoteSetSubscriber) replaceAll(ctx context.Context, sets map[string][]string) error {
+	for name, vals := range sets {
+		if err := s.Store.ReplaceSet(ctx, name, vals); err != nil {
+			return fmt.Errorf("replacing set %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// parses a remote set response body, which may be JSON or newline-separated text
+func parseRemoteSets(raw []byte, name string) (map[string][]string, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var sets map[string][]string
+		if err := json.Unmarshal(trimmed, &sets); err != nil {
+			return nil, fmt.Errorf("parsing remote set JSON: %w", err)
+		}
+		if name != "" {
+			return map[string][]string{name: sets[name]}, nil
+		}
+		return sets, nil
+	}
+	if name == "" {
+		return nil, fmt.Errorf("remote set name required for list-style set sources")
+	}
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var vals []string
+		if err := json.Unmarshal(trimmed, &vals); err != nil {
+			return nil, fmt.Errorf("parsing remote set JSON: %w", err)
+		}
+		return map[string][]string{name: vals}, nil
+	}
+	vals := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		vals = append(vals, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return map[string][]string{name: vals}, nil
+}
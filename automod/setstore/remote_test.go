@@ -0,0 +1,70 @@
+package setstore
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteSetSubscriber(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		switch r.URL.Path {
+		case "/words.txt":
+			w.Write([]byte("# comment\nfoo\n\nbar\n"))
+		case "/sets.json":
+			w.Write([]byte(`{"domains": ["example.com"], "hashes": ["abc123"]}`))
+		}
+	}))
+	defer srv.Close()
+
+	words, err := ParseRemoteSetSource("bad-words=" + srv.URL + "/words.txt")
+	assert.NoError(err)
+	assert.Equal("bad-words", words.SetName)
+	multi, err := ParseRemoteSetSource(srv.URL + "/sets.json")
+	assert.NoError(err)
+	assert.Equal("", multi.SetName)
+	_, err = ParseRemoteSetSource("ftp://example.com/words.txt")
+	assert.Error(err)
+
+	store := NewMemSetStore()
+	sub := NewRemoteSetSubscriber(store, nil, []*RemoteSetSource{words, multi})
+	assert.NoError(sub.RefreshAll(ctx, slog.Default()))
+	assert.Equal(2, fetches)
+
+	for _, tc := range []struct {
+		set, val string
+		ok       bool
+	}{
+		{"bad-words", "foo", true},
+		{"bad-words", "bar", true},
+		{"bad-words", "# comment", false},
+		{"domains", "example.com", true},
+		{"hashes", "abc123", true},
+	} {
+		ok, err := store.InSet(ctx, tc.set, tc.val)
+		assert.NoError(err)
+		assert.Equal(tc.ok, ok, tc.val)
+	}
+
+	// second refresh uses conditional requests, and keeps existing contents
+	changed, err := sub.refresh(ctx, words)
+	assert.NoError(err)
+	assert.False(changed)
+	ok, err := store.InSet(ctx, "bad-words", "foo")
+	assert.NoError(err)
+	assert.True(ok)
+}
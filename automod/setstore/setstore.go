@@ -5,24 +5,32 @@ import (
 	"encoding/json"
 	"io"
 	"os"
+	"sync"
 )
 
 type SetStore interface {
 	InSet(ctx context.Context, name, val string) (bool, error)
 }
 
-// TODO: this implementation isn't race-safe (yet)!
+// In-process set store. Concurrent reads are safe, as is loading new sets with the exported methods; direct mutation of the Sets field is not.
 type MemSetStore struct {
 	Sets map[string]map[string]bool
+
+	mu *sync.RWMutex
 }
 
+var _ SetStore = (*MemSetStore)(nil)
+
 func NewMemSetStore() MemSetStore {
 	return MemSetStore{
 		Sets: make(map[string]map[string]bool),
+		mu:   &sync.RWMutex{},
 	}
 }
 
 func (s MemSetStore) InSet(ctx context.Context, name, val string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	set, ok := s.Sets[name]
 	if !ok {
 		// NOTE: currently returns false when entire set isn't found
@@ -51,11 +59,19 @@ func (s *MemSetStore) LoadFromFileJSON(p string) error {
 	}
 
 	for name, l := range rules {
-		m := make(map[string]bool, len(l))
-		for _, val := range l {
-			m[val] = true
-		}
-		s.Sets[name] = m
+		_ = s.ReplaceSet(context.Background(), name, l)
+	}
+	return nil
+}
+
+// Replaces the full contents of the named set.
+func (s MemSetStore) ReplaceSet(ctx context.Context, name string, vals []string) error {
+	m := make(map[string]bool, len(vals))
+	for _, val := range vals {
+		m[val] = true
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Sets[name] = m
 	return nil
 }
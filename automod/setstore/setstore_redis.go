@@ -0,0 +1,55 @@
+package setstore
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var redisSetPrefix string = "sets/"
+
+// Set store backed by redis sets, so that set membership can be shared and updated across multiple processes.
+type RedisSetStore struct {
+	// either a single-node client (*redis.Client) or a cluster client (*redis.ClusterClient)
+	Client redis.UniversalClient
+}
+
+var _ SetStore = (*RedisSetStore)(nil)
+
+func NewRedisSetStore(redisURL string) (*RedisSetStore, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return NewRedisSetStoreFromClient(context.Background(), redis.NewClient(opt))
+}
+
+// Creates a store using an existing redis client, which may be a cluster client.
+func NewRedisSetStoreFromClient(ctx context.Context, rdb redis.UniversalClient) (*RedisSetStore, error) {
+	// check redis connection
+	_, err := rdb.Ping(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	return &RedisSetStore{Client: rdb}, nil
+}
+
+func (s *RedisSetStore) InSet(ctx context.Context, name, val string) (bool, error) {
+	return s.Client.SIsMember(ctx, redisSetPrefix+name, val).Result()
+}
+
+// Replaces the full contents of the named set, atomically.
+func (s *RedisSetStore) ReplaceSet(ctx context.Context, name string, vals []string) error {
+	key := redisSetPrefix + name
+	multi := s.Client.TxPipeline()
+	multi.Del(ctx, key)
+	if len(vals) > 0 {
+		l := make([]interface{}, len(vals))
+		for i, v := range vals {
+			l[i] = v
+		}
+		multi.SAdd(ctx, key, l...)
+	}
+	_, err := multi.Exec(ctx)
+	return err
+}
@@ -0,0 +1,4 @@
+// Replays recorded firehose events through a candidate ruleset alongside the production ruleset, in "shadow" (dry-run) mode, and reports differences in the resulting moderation actions.
+//
+// This makes it possible to evaluate rule changes against real historical traffic before deploying them.
+package simulate
@@ -0,0 +1,120 @@
+package simulate
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// Reads a firehose "segment": a sequence of concatenated event frames, each encoded the same as a firehose WebSocket message (a CBOR header, followed by the CBOR event body; see [events.XRPCStreamEvent.Serialize]).
+//
+// The callback is invoked for each event. Unknown message types are skipped, and error frames end processing with an error.
+func ReadSegment(r io.Reader, cb func(xev *events.XRPCStreamEvent) error) error {
+	cr := cbg.NewCborReader(bufio.NewReader(r))
+	for {
+		var header events.EventHeader
+		if err := header.UnmarshalCBOR(cr); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("reading segment frame header: %w", err)
+		}
+		if header.Op == events.EvtKindErrorFrame {
+			var errFrame events.ErrorFrame
+			if err := errFrame.UnmarshalCBOR(cr); err != nil {
+				return fmt.Errorf("reading segment error frame: %w", err)
+			}
+			return fmt.Errorf("error frame in segment: %s: %s", errFrame.Error, errFrame.Message)
+		}
+		if header.Op != events.EvtKindMessage {
+			return fmt.Errorf("unexpected frame op in segment: %d", header.Op)
+		}
+
+		xev := &events.XRPCStreamEvent{}
+		var body cbg.CBORUnmarshaler
+		switch header.MsgType {
+		case "#commit":
+			xev.RepoCommit = &comatproto.SyncSubscribeRepos_Commit{}
+			body = xev.RepoCommit
+		case "#identity":
+			xev.RepoIdentity = &comatproto.SyncSubscribeRepos_Identity{}
+			body = xev.RepoIdentity
+		case "#account":
+			xev.RepoAccount = &comatproto.SyncSubscribeRepos_Account{}
+			body = xev.RepoAccount
+		case "#handle":
+			xev.RepoHandle = &comatproto.SyncSubscribeRepos_Handle{}
+			body = xev.RepoHandle
+		case "#tombstone":
+			xev.RepoTombstone = &comatproto.SyncSubscribeRepos_Tombstone{}
+			body = xev.RepoTombstone
+		case "#info":
+			xev.RepoInfo = &comatproto.SyncSubscribeRepos_Info{}
+			body = xev.RepoInfo
+		default:
+			return fmt.Errorf("unsupported message type in segment: %s", header.MsgType)
+		}
+		if err := body.UnmarshalCBOR(cr); err != nil {
+			return fmt.Errorf("reading segment %s event: %w", header.MsgType, err)
+		}
+		if err := cb(xev); err != nil {
+			return err
+		}
+	}
+}
+
+// Opens a segment for reading from a local file path, or an HTTP(S) URL (eg, a pre-signed object storage URL). Segments with a ".gz" suffix are decompressed.
+func OpenSegment(ctx context.Context, loc string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	if strings.HasPrefix(loc, "https://") || strings.HasPrefix(loc, "http://") {
+		req, err := http.NewRequestWithContext(ctx, "GET", loc, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("fetching segment: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("fetching segment: HTTP status %d", resp.StatusCode)
+		}
+		rc = resp.Body
+	} else {
+		f, err := os.Open(loc)
+		if err != nil {
+			return nil, err
+		}
+		rc = f
+	}
+	if strings.HasSuffix(strings.SplitN(loc, "?", 2)[0], ".gz") {
+		gz, err := gzip.NewReader(rc)
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("opening gzip segment: %w", err)
+		}
+		return &gzipReadCloser{Reader: gz, inner: rc}, nil
+	}
+	return rc, nil
+}
+
+type gzipReadCloser struct {
+	*gzip.Reader
+	inner io.Closer
+}
+
+func (g *gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.inner.Close()
+}
@@ -0,0 +1,219 @@
+package simulate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/engine"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/repomgr"
+)
+
+// Moderation actions for one record event which only one of the two rulesets would have taken.
+type Diff struct {
+	URI            string   `json:"uri"`
+	OnlyProduction []string `json:"onlyProduction,omitempty"`
+	OnlyCandidate  []string `json:"onlyCandidate,omitempty"`
+}
+
+// Summary of a simulation run. Action counts are keyed by strings like "record-label:spam" or "account-takedown".
+type Report struct {
+	// Number of record operations processed
+	Events int `json:"events"`
+	// Number of record operations which failed to process (in either engine)
+	Errors     int            `json:"errors"`
+	Production map[string]int `json:"production"`
+	Candidate  map[string]int `json:"candidate"`
+	// Total number of events with differing actions
+	DiffCount int `json:"diffCount"`
+	// Up to MaxDiffs example differences
+	Diffs []Diff `json:"diffs"`
+}
+
+// Runs events through a production and a candidate engine, both in dry-run mode, and compares the resulting moderation actions.
+//
+// The two engines should have independent counter, flag, and cache stores (typically in-memory), so that state accumulates separately for each ruleset over the course of the replay. Events are processed sequentially.
+type Simulator struct {
+	Production *automod.Engine
+	Candidate  *automod.Engine
+	Logger     *slog.Logger
+	// Max number of individual diffs to retain in the report
+	MaxDiffs int
+
+	mu       sync.Mutex
+	report   Report
+	lastProd []string
+	lastCand []string
+}
+
+// Configures both engines for dry-run, and returns a new simulator.
+func NewSimulator(prod, cand *automod.Engine) *Simulator {
+	s := &Simulator{
+		Production: prod,
+		Candidate:  cand,
+		Logger:     slog.Default(),
+		MaxDiffs:   1000,
+		report: Report{
+			Production: make(map[string]int),
+			Candidate:  make(map[string]int),
+		},
+	}
+	prod.DryRun = true
+	prod.RecordEffectsHook = func(op engine.RecordOp, eff *engine.Effects) {
+		s.lastProd = eff.Actions()
+	}
+	cand.DryRun = true
+	cand.RecordEffectsHook = func(op engine.RecordOp, eff *engine.Effects) {
+		s.lastCand = eff.Actions()
+	}
+	return s
+}
+
+func difference(a, b []string) []string {
+	m := make(map[string]bool, len(b))
+	for _, v := range b {
+		m[v] = true
+	}
+	out := []string{}
+	for _, v := range a {
+		if !m[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Processes a single record operation through both engines, and records the results.
+func (s *Simulator) ProcessRecordOp(ctx context.Context, op automod.RecordOp) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastProd, s.lastCand = nil, nil
+	s.report.Events++
+	prodErr := s.Production.ProcessRecordOp(ctx, op)
+	candErr := s.Candidate.ProcessRecordOp(ctx, op)
+	if prodErr != nil || candErr != nil {
+		s.report.Errors++
+		s.Logger.Warn("failed to process record in simulation", "uri", op.ATURI().String(), "productionErr", prodErr, "candidateErr", candErr)
+		return
+	}
+	for _, a := range s.lastProd {
+		s.report.Production[a]++
+	}
+	for _, a := range s.lastCand {
+		s.report.Candidate[a]++
+	}
+	onlyProd := difference(s.lastProd, s.lastCand)
+	onlyCand := difference(s.lastCand, s.lastProd)
+	if len(onlyProd) == 0 && len(onlyCand) == 0 {
+		return
+	}
+	s.report.DiffCount++
+	if len(s.report.Diffs) < s.MaxDiffs {
+		s.report.Diffs = append(s.report.Diffs, Diff{
+			URI:            op.ATURI().String(),
+			OnlyProduction: onlyProd,
+			OnlyCandidate:  onlyCand,
+		})
+	}
+}
+
+// Processes all the record operations in a commit. Other event types are ignored.
+func (s *Simulator) ProcessEvent(ctx context.Context, xev *events.XRPCStreamEvent) error {
+	if xev.RepoCommit == nil {
+		return nil
+	}
+	ops, err := CommitRecordOps(ctx, xev.RepoCommit)
+	if err != nil {
+		s.Logger.Warn("skipping invalid commit in simulation", "did", xev.RepoCommit.Repo, "seq", xev.RepoCommit.Seq, "err", err)
+		return nil
+	}
+	for _, op := range ops {
+		s.ProcessRecordOp(ctx, op)
+	}
+	return nil
+}
+
+// Returns a copy of the current report.
+func (s *Simulator) Report() Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := s.report
+	out.Production = make(map[string]int, len(s.report.Production))
+	for k, v := range s.report.Production {
+		out.Production[k] = v
+	}
+	out.Candidate = make(map[string]int, len(s.report.Candidate))
+	for k, v := range s.report.Candidate {
+		out.Candidate[k] = v
+	}
+	out.Diffs = append([]Diff{}, s.report.Diffs...)
+	return out
+}
+
+// Extracts record operations (with record CBOR) from a firehose commit event. Ops for records which can't be read or verified from the commit blocks are skipped.
+func CommitRecordOps(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) ([]automod.RecordOp, error) {
+	if evt.TooBig {
+		return nil, fmt.Errorf("tooBig commit")
+	}
+	did, err := syntax.ParseDID(evt.Repo)
+	if err != nil {
+		return nil, err
+	}
+	rr, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(evt.Blocks))
+	if err != nil {
+		return nil, fmt.Errorf("reading commit blocks: %w", err)
+	}
+	out := []automod.RecordOp{}
+	for _, op := range evt.Ops {
+		parts := strings.SplitN(op.Path, "/", 3)
+		if len(parts) != 2 {
+			continue
+		}
+		collection, err := syntax.ParseNSID(parts[0])
+		if err != nil {
+			continue
+		}
+		rkey, err := syntax.ParseRecordKey(parts[1])
+		if err != nil {
+			continue
+		}
+		switch repomgr.EventKind(op.Action) {
+		case repomgr.EvtKindCreateRecord, repomgr.EvtKindUpdateRecord:
+			rc, recCBOR, err := rr.GetRecordBytes(ctx, op.Path)
+			if err != nil || op.Cid == nil || lexutil.LexLink(rc) != *op.Cid {
+				continue
+			}
+			action := automod.CreateOp
+			if repomgr.EventKind(op.Action) == repomgr.EvtKindUpdateRecord {
+				action = automod.UpdateOp
+			}
+			recCID := syntax.CID(op.Cid.String())
+			out = append(out, automod.RecordOp{
+				Action:     action,
+				DID:        did,
+				Collection: collection,
+				RecordKey:  rkey,
+				CID:        &recCID,
+				RecordCBOR: *recCBOR,
+			})
+		case repomgr.EvtKindDeleteRecord:
+			out = append(out, automod.RecordOp{
+				Action:     automod.DeleteOp,
+				DID:        did,
+				Collection: collection,
+				RecordKey:  rkey,
+			})
+		}
+	}
+	return out, nil
+}
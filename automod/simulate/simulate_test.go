@@ -0,0 +1,98 @@
+package simulate
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/engine"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+)
+
+func flagEverythingRule(c *automod.RecordContext, post *appbsky.FeedPost) error {
+	c.AddRecordFlag("everything")
+	return nil
+}
+
+func TestSimulator(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	prod := engine.EngineTestFixture()
+	cand := engine.EngineTestFixture()
+	cand.Rules.PostRules = append(cand.Rules.PostRules, flagEverythingRule)
+	sim := NewSimulator(&prod, &cand)
+
+	cid1 := syntax.CID("cid123")
+	p1 := appbsky.FeedPost{Text: "some post blah"}
+	p1buf := new(bytes.Buffer)
+	assert.NoError(p1.MarshalCBOR(p1buf))
+	op := automod.RecordOp{
+		Action:     automod.CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid1,
+		RecordCBOR: p1buf.Bytes(),
+	}
+	sim.ProcessRecordOp(ctx, op)
+
+	report := sim.Report()
+	assert.Equal(1, report.Events)
+	assert.Equal(0, report.Errors)
+	assert.Equal(1, report.DiffCount)
+	assert.Equal(1, report.Candidate["record-flag:everything"])
+	assert.Equal(0, report.Production["record-flag:everything"])
+	assert.Equal([]string{"record-flag:everything"}, report.Diffs[0].OnlyCandidate)
+
+	// dry-run: flags were not persisted
+	flags, err := cand.Flags.Get(ctx, op.ATURI().String())
+	assert.NoError(err)
+	assert.Empty(flags)
+}
+
+func TestReadSegment(t *testing.T) {
+	assert := assert.New(t)
+
+	commitCID, err := cid.Decode("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")
+	assert.NoError(err)
+	buf := new(bytes.Buffer)
+	for _, seq := range []int64{1, 2} {
+		xev := &events.XRPCStreamEvent{
+			RepoCommit: &comatproto.SyncSubscribeRepos_Commit{
+				Repo:   "did:plc:abc111",
+				Seq:    seq,
+				Commit: lexutil.LexLink(commitCID),
+				Ops:    []*comatproto.SyncSubscribeRepos_RepoOp{},
+				Blobs:  []lexutil.LexLink{},
+				Blocks: []byte{},
+			},
+		}
+		assert.NoError(xev.Serialize(buf))
+	}
+	ident := &events.XRPCStreamEvent{
+		RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:plc:abc111", Seq: 3},
+	}
+	assert.NoError(ident.Serialize(buf))
+
+	seqs := []int64{}
+	err = ReadSegment(buf, func(xev *events.XRPCStreamEvent) error {
+		switch {
+		case xev.RepoCommit != nil:
+			seqs = append(seqs, xev.RepoCommit.Seq)
+		case xev.RepoIdentity != nil:
+			seqs = append(seqs, xev.RepoIdentity.Seq)
+		}
+		return nil
+	})
+	assert.NoError(err)
+	assert.Equal([]int64{1, 2, 3}, seqs)
+}
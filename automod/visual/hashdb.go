@@ -0,0 +1,83 @@
+package visual
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// A matching entry found in a [HashDB].
+type HashMatch struct {
+	// Name of the list the matching hash came from (eg, "known-spam")
+	List     string
+	Hash     PHash
+	Distance int
+}
+
+// Database of perceptual hashes of known-bad images, grouped in to named lists.
+type HashDB interface {
+	// Returns the closest hash within maxDistance of the given hash, or nil if there is no match.
+	Match(ctx context.Context, h PHash, maxDistance int) (*HashMatch, error)
+}
+
+// In-process [HashDB], doing a linear scan over all hashes. Fine for lists up to several tens of thousands of entries.
+//
+// Lists can be loaded with [MemHashDB.ReplaceSet], which means this can be used as the store for a [setstore.RemoteSetSubscriber].
+type MemHashDB struct {
+	mu    sync.RWMutex
+	lists map[string][]PHash
+}
+
+var _ HashDB = (*MemHashDB)(nil)
+
+func NewMemHashDB() *MemHashDB {
+	return &MemHashDB{
+		lists: make(map[string][]PHash),
+	}
+}
+
+// Replaces the contents of the named list. Each value is a hex-encoded hash, optionally followed by whitespace and a comment. Invalid values are skipped; the number skipped is returned.
+func (db *MemHashDB) ReplaceList(name string, vals []string) int {
+	hashes := make([]PHash, 0, len(vals))
+	skipped := 0
+	for _, v := range vals {
+		fields := strings.Fields(v)
+		if len(fields) == 0 {
+			continue
+		}
+		h, err := ParsePHash(fields[0])
+		if err != nil {
+			skipped++
+			continue
+		}
+		hashes = append(hashes, h)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.lists[name] = hashes
+	return skipped
+}
+
+// Same as [MemHashDB.ReplaceList], to implement the setstore.SetReplacer interface.
+func (db *MemHashDB) ReplaceSet(ctx context.Context, name string, vals []string) error {
+	db.ReplaceList(name, vals)
+	return nil
+}
+
+func (db *MemHashDB) Match(ctx context.Context, h PHash, maxDistance int) (*HashMatch, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var best *HashMatch
+	for name, hashes := range db.lists {
+		for _, candidate := range hashes {
+			d := h.Distance(candidate)
+			if d > maxDistance {
+				continue
+			}
+			if best == nil || d < best.Distance {
+				best = &HashMatch{List: name, Hash: candidate, Distance: d}
+			}
+		}
+	}
+	return best, nil
+}
@@ -24,3 +24,13 @@ var abyssAPICount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "automod_abyss_api_count",
 	Help: "Number of abyss image scanning API calls, by HTTP status code",
 }, []string{"status"})
+
+var phashCheckCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_phash_checks",
+	Help: "Number of image blobs checked against perceptual hash lists, by result",
+}, []string{"result"})
+
+var phashMatchCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_phash_matches",
+	Help: "Number of perceptual hash matches, by hash list",
+}, []string{"list"})
@@ -0,0 +1,146 @@
+package visual
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+const (
+	phashSize    = 32
+	phashLowFreq = 8
+)
+
+// 64-bit perceptual hash (pHash) of an image.
+//
+// Visually similar images (eg, re-encoded, resized, or slightly cropped copies) have hashes with a small Hamming distance. The image is reduced to 32x32 grayscale, a 2D DCT is computed, and each bit is set if the corresponding coefficient in the 8x8 lowest-frequency block is greater than the median of that block.
+//
+// This follows the same steps as the "phash" function of the python "imagehash" library, but downsamples with a box filter (imagehash uses LANCZOS), so hashes are not bit-for-bit compatible: lists of known hashes should be computed with this implementation. Other perceptual hashes, such as PDQ, are not implemented.
+type PHash uint64
+
+// Parses a hash from a 16-character hex string.
+func ParsePHash(raw string) (PHash, error) {
+	if len(raw) != 16 {
+		return 0, fmt.Errorf("perceptual hash must be 16 hex characters: %q", raw)
+	}
+	v, err := strconv.ParseUint(raw, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid perceptual hash: %w", err)
+	}
+	return PHash(v), nil
+}
+
+func (h PHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// Hamming distance (number of differing bits) between two hashes.
+func (h PHash) Distance(other PHash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
+
+// Decodes image bytes (JPEG, PNG, or GIF) and computes the perceptual hash.
+func PHashBytes(data []byte) (PHash, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("decoding image for perceptual hash: %w", err)
+	}
+	return PHashImage(img), nil
+}
+
+func PHashImage(img image.Image) PHash {
+	pixels := grayscaleResize(img, phashSize)
+
+	// separable 2D DCT-II: first rows, then columns (only the low-frequency columns are needed)
+	rows := make([][phashSize]float64, phashSize)
+	for y := 0; y < phashSize; y++ {
+		rows[y] = dct1D(pixels[y])
+	}
+	var lowFreq [phashLowFreq * phashLowFreq]float64
+	for u := 0; u < phashLowFreq; u++ {
+		var col [phashSize]float64
+		for y := 0; y < phashSize; y++ {
+			col[y] = rows[y][u]
+		}
+		colDCT := dct1D(col)
+		for v := 0; v < phashLowFreq; v++ {
+			lowFreq[v*phashLowFreq+u] = colDCT[v]
+		}
+	}
+
+	sorted := lowFreq
+	sort.Float64s(sorted[:])
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var h uint64
+	for _, c := range lowFreq {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+	return PHash(h)
+}
+
+var dctTable = func() [phashSize][phashSize]float64 {
+	var t [phashSize][phashSize]float64
+	for k := 0; k < phashSize; k++ {
+		for n := 0; n < phashSize; n++ {
+			t[k][n] = math.Cos(math.Pi / phashSize * (float64(n) + 0.5) * float64(k))
+		}
+	}
+	return t
+}()
+
+func dct1D(in [phashSize]float64) [phashSize]float64 {
+	var out [phashSize]float64
+	for k := 0; k < phashSize; k++ {
+		sum := 0.0
+		for n := 0; n < phashSize; n++ {
+			sum += in[n] * dctTable[k][n]
+		}
+		out[k] = 2 * sum
+	}
+	return out
+}
+
+// converts to luma and downsamples to size x size with box (area-average) filtering
+func grayscaleResize(img image.Image, size int) [][phashSize]float64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := make([][phashSize]float64, size)
+	if w == 0 || h == 0 {
+		return out
+	}
+	for y := 0; y < size; y++ {
+		y0 := b.Min.Y + y*h/size
+		y1 := b.Min.Y + (y+1)*h/size
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < size; x++ {
+			x0 := b.Min.X + x*w/size
+			x1 := b.Min.X + (x+1)*w/size
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			sum := 0.0
+			for py := y0; py < y1; py++ {
+				for px := x0; px < x1; px++ {
+					r, g, bl, _ := img.At(px, py).RGBA()
+					// ITU-R 601-2 luma transform, on 16-bit channels
+					sum += (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)) / 257
+				}
+			}
+			out[y][x] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return out
+}
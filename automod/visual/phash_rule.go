@@ -0,0 +1,57 @@
+package visual
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bluesky-social/indigo/automod"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+)
+
+// Matches image blobs against a database of perceptual hashes of known-bad images.
+type PHashMatcher struct {
+	DB HashDB
+	// Max Hamming distance (out of 64 bits) to count as a match. Values around 8 to 10 are typical.
+	MaxDistance int
+}
+
+func NewPHashMatcher(db HashDB, maxDistance int) *PHashMatcher {
+	return &PHashMatcher{
+		DB:          db,
+		MaxDistance: maxDistance,
+	}
+}
+
+func (pm *PHashMatcher) PHashMatchBlobRule(c *automod.RecordContext, blob lexutil.LexBlob, data []byte) error {
+
+	if !strings.HasPrefix(blob.MimeType, "image/") {
+		return nil
+	}
+
+	h, err := PHashBytes(data)
+	if err != nil {
+		// unsupported format (eg, webp) or corrupt image; not a rule failure
+		phashCheckCount.WithLabelValues("decode-error").Inc()
+		c.Logger.Debug("failed to compute perceptual hash", "cid", blob.Ref.String(), "err", err)
+		return nil
+	}
+
+	match, err := pm.DB.Match(c.Ctx, h, pm.MaxDistance)
+	if err != nil {
+		phashCheckCount.WithLabelValues("error").Inc()
+		return err
+	}
+	if match == nil {
+		phashCheckCount.WithLabelValues("no-match").Inc()
+		return nil
+	}
+
+	phashCheckCount.WithLabelValues("match").Inc()
+	phashMatchCount.WithLabelValues(match.List).Inc()
+	c.Logger.Warn("perceptual hash match", "cid", blob.Ref.String(), "phash", h.String(), "list", match.List, "distance", match.Distance)
+	c.AddRecordFlag("phash-match-" + match.List)
+	c.ReportRecord(automod.ReportReasonViolation, fmt.Sprintf("image matched known-bad perceptual hash list: %s (distance %d, hash %s)", match.List, match.Distance, h.String()))
+	c.Notify("slack")
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package visual
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testImage(w, h int, invert bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8((x*255/w + y*128/h) % 256)
+			if (x*8/w+y*8/h)%2 == 0 {
+				v = 255 - v/2
+			}
+			if invert {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestPHash(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	orig := PHashImage(testImage(400, 300, false))
+	resized := PHashImage(testImage(200, 150, false))
+	inverted := PHashImage(testImage(400, 300, true))
+
+	assert.LessOrEqual(orig.Distance(resized), 4)
+	assert.Greater(orig.Distance(inverted), 20)
+
+	// round-trip through JPEG encoding
+	buf := new(bytes.Buffer)
+	assert.NoError(jpeg.Encode(buf, testImage(400, 300, false), &jpeg.Options{Quality: 70}))
+	encoded, err := PHashBytes(buf.Bytes())
+	assert.NoError(err)
+	assert.LessOrEqual(orig.Distance(encoded), 4)
+
+	parsed, err := ParsePHash(orig.String())
+	assert.NoError(err)
+	assert.Equal(orig, parsed)
+	_, err = ParsePHash("abc")
+	assert.Error(err)
+
+	db := NewMemHashDB()
+	assert.Equal(1, db.ReplaceList("known-bad", []string{orig.String() + " some comment", "not-a-hash"}))
+	match, err := db.Match(ctx, encoded, 8)
+	assert.NoError(err)
+	assert.NotNil(match)
+	assert.Equal("known-bad", match.List)
+	match, err = db.Match(ctx, inverted, 8)
+	assert.NoError(err)
+	assert.Nil(match)
+}
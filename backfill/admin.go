@@ -0,0 +1,248 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Steps of an in-progress backfill job, reported by the admin API
+const (
+	// PhaseFetching means the repo CAR file is being downloaded
+	PhaseFetching = "fetching"
+	// PhaseProcessing means records from the repo are being handled
+	PhaseProcessing = "processing"
+)
+
+// ProgressJob is an optional extension of the Job interface for jobs which persist fine-grained progress
+type ProgressJob interface {
+	SetProgress(ctx context.Context, phase string, recordsProcessed int) error
+}
+
+// AdminStore is an optional extension of the Store interface, required for the admin API
+type AdminStore interface {
+	// ListJobs returns jobs in a stable order, starting after the given cursor (a JobInfo.ID). The state filter may be empty (all jobs), "failed" (any failure reason), or an exact job state.
+	ListJobs(ctx context.Context, state string, cursor uint, limit int) ([]JobInfo, error)
+	// GetJobInfo returns ErrJobNotFound if there is no job for the repo
+	GetJobInfo(ctx context.Context, repo string) (*JobInfo, error)
+	// RetryJob re-enqueues a failed or completed job immediately, resetting its retry count
+	RetryJob(ctx context.Context, repo string) error
+	// RetryFailedJobs re-enqueues all failed jobs, returning the number of jobs
+	RetryFailedJobs(ctx context.Context) (int, error)
+	// Progress returns job counts by state, and the number of jobs completed within the window
+	Progress(ctx context.Context, window time.Duration) (*Progress, error)
+}
+
+// JobInfo is a snapshot of persisted job state
+type JobInfo struct {
+	ID               uint       `json:"id"`
+	Repo             string     `json:"repo"`
+	State            string     `json:"state"`
+	Phase            string     `json:"phase,omitempty"`
+	FailureReason    string     `json:"failureReason,omitempty"`
+	Rev              string     `json:"rev,omitempty"`
+	Priority         int        `json:"priority"`
+	RetryCount       int        `json:"retryCount"`
+	RetryAfter       *time.Time `json:"retryAfter,omitempty"`
+	RecordsProcessed int        `json:"recordsProcessed"`
+	StartedAt        *time.Time `json:"startedAt,omitempty"`
+	CompletedAt      *time.Time `json:"completedAt,omitempty"`
+	UpdatedAt        time.Time  `json:"updatedAt"`
+}
+
+// Progress summarizes all jobs in a store
+type Progress struct {
+	Total             int64 `json:"total"`
+	Enqueued          int64 `json:"enqueued"`
+	InProgress        int64 `json:"inProgress"`
+	Complete          int64 `json:"complete"`
+	Failed            int64 `json:"failed"`
+	RecentlyCompleted int64 `json:"recentlyCompleted"`
+
+	// The following are filled in by the admin API
+	Paused bool `json:"paused"`
+	// Jobs completed per minute, averaged over the recent window
+	CompletedPerMinute float64 `json:"completedPerMinute"`
+	// Estimated time until all enqueued and in-progress jobs are done, at the recent rate. Omitted if nothing has completed recently
+	ETA string `json:"eta,omitempty"`
+}
+
+// window over which recent throughput is measured
+const progressWindow = 15 * time.Minute
+
+// Pause stops the backfiller from starting new jobs. Jobs which are already running are not interrupted.
+func (b *Backfiller) Pause() {
+	b.paused.Store(true)
+}
+
+// Resume undoes Pause
+func (b *Backfiller) Resume() {
+	b.paused.Store(false)
+}
+
+func (b *Backfiller) Paused() bool {
+	return b.paused.Load()
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// AdminHandler returns an HTTP handler for the backfill admin API. The Store must implement AdminStore. Paths are relative to wherever the handler is mounted (eg, using http.StripPrefix):
+//
+//	GET  /progress                          job counts, throughput, and ETA
+//	GET  /jobs?state=<state>&cursor=&limit= list jobs
+//	GET  /jobs/{repo}                       single job
+//	POST /jobs/{repo}/retry                 re-enqueue a single job
+//	POST /retry-failed                      re-enqueue all failed jobs
+//	POST /prioritize                        enqueue or raise the priority of a list of repos; body is {"repos": [...], "priority": N}
+//	POST /pause                             stop starting new jobs
+//	POST /resume
+func (b *Backfiller) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	store, ok := b.Store.(AdminStore)
+	if !ok {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			writeAdminJSON(w, http.StatusNotImplemented, adminError{Error: "backfill store does not support admin API"})
+		})
+		return mux
+	}
+
+	mux.HandleFunc("GET /progress", func(w http.ResponseWriter, r *http.Request) {
+		p, err := store.Progress(r.Context(), progressWindow)
+		if err != nil {
+			writeAdminJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+			return
+		}
+		p.Paused = b.Paused()
+		p.CompletedPerMinute = float64(p.RecentlyCompleted) / progressWindow.Minutes()
+		if remaining := p.Enqueued + p.InProgress; p.CompletedPerMinute > 0 && remaining > 0 {
+			eta := time.Duration(float64(remaining) / p.CompletedPerMinute * float64(time.Minute))
+			p.ETA = eta.Round(time.Second).String()
+		}
+		writeAdminJSON(w, http.StatusOK, p)
+	})
+
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 1000 {
+				writeAdminJSON(w, http.StatusBadRequest, adminError{Error: "limit must be between 1 and 1000"})
+				return
+			}
+			limit = n
+		}
+		var cursor uint
+		if v := r.URL.Query().Get("cursor"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				writeAdminJSON(w, http.StatusBadRequest, adminError{Error: "invalid cursor"})
+				return
+			}
+			cursor = uint(n)
+		}
+		jobs, err := store.ListJobs(r.Context(), r.URL.Query().Get("state"), cursor, limit)
+		if err != nil {
+			writeAdminJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+			return
+		}
+		out := struct {
+			Jobs   []JobInfo `json:"jobs"`
+			Cursor string    `json:"cursor,omitempty"`
+		}{Jobs: jobs}
+		if len(jobs) == limit {
+			out.Cursor = strconv.FormatUint(uint64(jobs[len(jobs)-1].ID), 10)
+		}
+		writeAdminJSON(w, http.StatusOK, out)
+	})
+
+	mux.HandleFunc("GET /jobs/{repo}", func(w http.ResponseWriter, r *http.Request) {
+		info, err := store.GetJobInfo(r.Context(), r.PathValue("repo"))
+		if errors.Is(err, ErrJobNotFound) {
+			writeAdminJSON(w, http.StatusNotFound, adminError{Error: err.Error()})
+			return
+		} else if err != nil {
+			writeAdminJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, info)
+	})
+
+	mux.HandleFunc("POST /jobs/{repo}/retry", func(w http.ResponseWriter, r *http.Request) {
+		err := store.RetryJob(r.Context(), r.PathValue("repo"))
+		if errors.Is(err, ErrJobNotFound) {
+			writeAdminJSON(w, http.StatusNotFound, adminError{Error: err.Error()})
+			return
+		} else if err != nil {
+			writeAdminJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, map[string]bool{"success": true})
+	})
+
+	mux.HandleFunc("POST /retry-failed", func(w http.ResponseWriter, r *http.Request) {
+		n, err := store.RetryFailedJobs(r.Context())
+		if err != nil {
+			writeAdminJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, map[string]int{"retried": n})
+	})
+
+	mux.HandleFunc("POST /prioritize", func(w http.ResponseWriter, r *http.Request) {
+		ps, ok := b.Store.(PriorityStore)
+		if !ok {
+			writeAdminJSON(w, http.StatusNotImplemented, adminError{Error: "backfill store does not support priorities"})
+			return
+		}
+		body := struct {
+			Repos    []string `json:"repos"`
+			Priority *int     `json:"priority"`
+		}{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 16*1024*1024)).Decode(&body); err != nil {
+			writeAdminJSON(w, http.StatusBadRequest, adminError{Error: fmt.Sprintf("invalid request body: %s", err)})
+			return
+		}
+		priority := PriorityOperator
+		if body.Priority != nil {
+			priority = *body.Priority
+		}
+		for i, repo := range body.Repos {
+			if _, err := syntax.ParseDID(repo); err != nil {
+				writeAdminJSON(w, http.StatusBadRequest, adminError{Error: fmt.Sprintf("invalid repo DID %q (%d repos prioritized)", repo, i)})
+				return
+			}
+			if err := ps.EnqueueJobWithPriority(r.Context(), repo, priority); err != nil {
+				writeAdminJSON(w, http.StatusInternalServerError, adminError{Error: fmt.Sprintf("%s (%d repos prioritized)", err, i)})
+				return
+			}
+		}
+		writeAdminJSON(w, http.StatusOK, map[string]int{"prioritized": len(body.Repos)})
+	})
+
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		b.Pause()
+		writeAdminJSON(w, http.StatusOK, map[string]bool{"paused": true})
+	})
+
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		b.Resume()
+		writeAdminJSON(w, http.StatusOK, map[string]bool{"paused": false})
+	})
+
+	return mux
+}
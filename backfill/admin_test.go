@@ -0,0 +1,151 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testGormstore(t *testing.T) *Gormstore {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in-memory sqlite databases are per-connection
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&GormDBJob{}); err != nil {
+		t.Fatal(err)
+	}
+	return NewGormstore(db)
+}
+
+func TestGormstoreResume(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	store := testGormstore(t)
+
+	assert.NoError(store.EnqueueJob(ctx, "did:plc:aaa"))
+	j, err := store.GetJob(ctx, "did:plc:aaa")
+	assert.NoError(err)
+	assert.NoError(j.SetState(ctx, StateInProgress))
+	assert.NoError(j.(ProgressJob).SetProgress(ctx, PhaseFetching, 0))
+	assert.NoError(j.SetRev(ctx, "3kabc"))
+
+	// simulate a restart: new store over the same database
+	store2 := NewGormstore(store.db)
+	assert.NoError(store2.LoadJobs(ctx))
+	info, err := store2.GetJobInfo(ctx, "did:plc:aaa")
+	assert.NoError(err)
+	assert.Equal(StateEnqueued, info.State)
+	assert.Equal("", info.Phase)
+	assert.Equal("3kabc", info.Rev)
+
+	next, err := store2.GetNextEnqueuedJob(ctx)
+	assert.NoError(err)
+	assert.Equal("did:plc:aaa", next.Repo())
+	assert.Equal("3kabc", next.Rev())
+}
+
+func TestBackfillAdminAPI(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	store := testGormstore(t)
+	bf := NewBackfiller("test", store, nil, nil, nil, nil)
+	srv := httptest.NewServer(bf.AdminHandler())
+	defer srv.Close()
+
+	for _, did := range []string{"did:plc:aaa", "did:plc:bbb", "did:plc:ccc"} {
+		assert.NoError(store.EnqueueJob(ctx, did))
+	}
+	j, _ := store.GetJob(ctx, "did:plc:aaa")
+	assert.NoError(j.SetState(ctx, StateInProgress))
+	assert.NoError(j.SetState(ctx, StateComplete))
+	j, _ = store.GetJob(ctx, "did:plc:bbb")
+	assert.NoError(j.SetState(ctx, "failed (repo not found)"))
+
+	call := func(method, path string, out any) int {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			assert.NoError(json.NewDecoder(resp.Body).Decode(out))
+		}
+		return resp.StatusCode
+	}
+
+	var p Progress
+	assert.Equal(200, call("GET", "/progress", &p))
+	assert.Equal(int64(3), p.Total)
+	assert.Equal(int64(1), p.Enqueued)
+	assert.Equal(int64(1), p.Complete)
+	assert.Equal(int64(1), p.Failed)
+	assert.Equal(int64(1), p.RecentlyCompleted)
+	assert.NotEmpty(p.ETA)
+	assert.False(p.Paused)
+
+	var list struct {
+		Jobs   []JobInfo `json:"jobs"`
+		Cursor string    `json:"cursor"`
+	}
+	assert.Equal(200, call("GET", "/jobs?state=failed", &list))
+	assert.Equal(1, len(list.Jobs))
+	assert.Equal("did:plc:bbb", list.Jobs[0].Repo)
+	assert.Equal("failed", list.Jobs[0].State)
+	assert.Equal("repo not found", list.Jobs[0].FailureReason)
+	assert.Equal(1, list.Jobs[0].RetryCount)
+	assert.NotNil(list.Jobs[0].RetryAfter)
+
+	// pagination
+	assert.Equal(200, call("GET", "/jobs?limit=2", &list))
+	assert.Equal(2, len(list.Jobs))
+	assert.NotEmpty(list.Cursor)
+	cursor := list.Cursor
+	list.Cursor = ""
+	assert.Equal(200, call("GET", "/jobs?limit=2&cursor="+cursor, &list))
+	assert.Equal(1, len(list.Jobs))
+	assert.Equal("did:plc:ccc", list.Jobs[0].Repo)
+	assert.Empty(list.Cursor)
+	assert.Equal(400, call("GET", "/jobs?limit=zero", nil))
+
+	var info JobInfo
+	assert.Equal(200, call("GET", "/jobs/did:plc:aaa", &info))
+	assert.Equal(StateComplete, info.State)
+	assert.NotNil(info.CompletedAt)
+	assert.Equal(404, call("GET", "/jobs/did:plc:zzz", nil))
+
+	var retried map[string]int
+	assert.Equal(200, call("POST", "/retry-failed", &retried))
+	assert.Equal(1, retried["retried"])
+	assert.Equal(200, call("GET", "/jobs/did:plc:bbb", &info))
+	assert.Equal(StateEnqueued, info.State)
+	assert.Equal(0, info.RetryCount)
+	assert.Nil(info.RetryAfter)
+
+	assert.Equal(200, call("POST", "/jobs/did:plc:aaa/retry", nil))
+	assert.Equal(200, call("GET", "/jobs/did:plc:aaa", &info))
+	assert.Equal(StateEnqueued, info.State)
+	assert.Equal(404, call("POST", "/jobs/did:plc:zzz/retry", nil))
+
+	assert.Equal(200, call("POST", "/pause", nil))
+	assert.True(bf.Paused())
+	assert.Equal(200, call("GET", "/progress", &p))
+	assert.True(p.Paused)
+	assert.Equal(200, call("POST", "/resume", nil))
+	assert.False(bf.Paused())
+}
@@ -0,0 +1,182 @@
+package backfill
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/util"
+)
+
+// ErrNotInArchive is returned by an ArchiveSource when it has no CAR for the requested repo
+var ErrNotInArchive = errors.New("repo not in archive")
+
+// ArchiveSource is a store of repo CAR snapshots (eg, nightly exports) which may be used to backfill repos without fetching them from the network.
+//
+// Archived repos are keyed by DID, and stored as "<did>.car" under the archive root.
+type ArchiveSource interface {
+	OpenRepo(ctx context.Context, did string) (io.ReadCloser, error)
+}
+
+// ArchiveManifestName is the name of the manifest under the archive root
+const ArchiveManifestName = "manifest.json"
+
+// ArchiveManifest describes a point-in-time snapshot of repos, written alongside the CARs once they have all been uploaded
+type ArchiveManifest struct {
+	CreatedAt time.Time `json:"createdAt"`
+	// Where the repos were read from, eg the relay host
+	Source string                `json:"source,omitempty"`
+	Repos  []ArchiveManifestRepo `json:"repos"`
+}
+
+type ArchiveManifestRepo struct {
+	Did    string `json:"did"`
+	Rev    string `json:"rev"`
+	Commit string `json:"commit"`
+	// Size and SHA-256 (hex) of the CAR file
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// ArchiveSink is the write side of an archive, used to produce snapshots for an ArchiveSource
+type ArchiveSink interface {
+	PutRepo(ctx context.Context, did string, size int64, r io.Reader) error
+	PutManifest(ctx context.Context, m *ArchiveManifest) error
+}
+
+// DirArchive is an ArchiveSource (and ArchiveSink) reading CAR files from a local directory
+type DirArchive struct {
+	Dir string
+}
+
+func (a *DirArchive) OpenRepo(ctx context.Context, did string) (io.ReadCloser, error) {
+	if strings.ContainsAny(did, `/\`) {
+		return nil, fmt.Errorf("invalid repo DID: %q", did)
+	}
+	f, err := os.Open(filepath.Join(a.Dir, did+".car"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotInArchive
+	}
+	return f, err
+}
+
+// writes a file under the archive directory, creating the directory if need be. Files are written to a temporary name and renamed into place, so readers never see a partial file
+func (a *DirArchive) put(name string, r io.Reader) error {
+	if err := os.MkdirAll(a.Dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(a.Dir, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(a.Dir, name))
+}
+
+func (a *DirArchive) PutRepo(ctx context.Context, did string, size int64, r io.Reader) error {
+	if strings.ContainsAny(did, `/\`) {
+		return fmt.Errorf("invalid repo DID: %q", did)
+	}
+	return a.put(did+".car", r)
+}
+
+func (a *DirArchive) PutManifest(ctx context.Context, m *ArchiveManifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return a.put(ArchiveManifestName, bytes.NewReader(b))
+}
+
+// S3Archive is an ArchiveSource (and ArchiveSink) reading CAR files from an S3 (or S3-compatible) bucket, under the client's prefix
+type S3Archive struct {
+	util.S3Client
+}
+
+func (a *S3Archive) OpenRepo(ctx context.Context, did string) (io.ReadCloser, error) {
+	rc, err := a.GetObject(ctx, did+".car")
+	if errors.Is(err, util.ErrS3ObjectNotFound) {
+		return nil, ErrNotInArchive
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching repo from S3 archive: %w", err)
+	}
+	return rc, nil
+}
+
+func (a *S3Archive) put(ctx context.Context, name string, size int64, r io.Reader) error {
+	if err := a.PutObject(ctx, name, size, r, ""); err != nil {
+		return fmt.Errorf("uploading to S3 archive: %w", err)
+	}
+	return nil
+}
+
+func (a *S3Archive) PutRepo(ctx context.Context, did string, size int64, r io.Reader) error {
+	return a.put(ctx, did+".car", size, r)
+}
+
+func (a *S3Archive) PutManifest(ctx context.Context, m *ArchiveManifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return a.put(ctx, ArchiveManifestName, int64(len(b)), bytes.NewReader(b))
+}
+
+// ParseArchiveSource configures an ArchiveSource from a URI: either a local directory path, or "s3://<bucket>/<prefix>". S3 configuration and credentials are read from the standard AWS_REGION, AWS_ENDPOINT_URL, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment variables.
+func ParseArchiveSource(uri string) (ArchiveSource, error) {
+	if !strings.HasPrefix(uri, "s3://") {
+		info, err := os.Stat(uri)
+		if err != nil {
+			return nil, fmt.Errorf("backfill archive directory: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("backfill archive path is not a directory: %s", uri)
+		}
+		return &DirArchive{Dir: uri}, nil
+	}
+	return parseS3Archive(uri)
+}
+
+// ParseArchiveSink configures an ArchiveSink from a URI, as for ParseArchiveSource. A local directory is created if it doesn't exist.
+func ParseArchiveSink(uri string) (ArchiveSink, error) {
+	if !strings.HasPrefix(uri, "s3://") {
+		if info, err := os.Stat(uri); err == nil && !info.IsDir() {
+			return nil, fmt.Errorf("archive path is not a directory: %s", uri)
+		}
+		return &DirArchive{Dir: uri}, nil
+	}
+	return parseS3Archive(uri)
+}
+
+func parseS3Archive(uri string) (*S3Archive, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 archive URI: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("S3 archive URI must include bucket name: %s", uri)
+	}
+	return &S3Archive{
+		S3Client: util.S3ClientFromEnv(u.Host, strings.TrimPrefix(u.Path, "/"), 600*time.Second),
+	}, nil
+}
@@ -0,0 +1,204 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/util"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRepoCAR = "../testing/testdata/greenground.repo.car"
+
+func writeTestArchive(t *testing.T, did string) string {
+	dir := t.TempDir()
+	raw, err := os.ReadFile(testRepoCAR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, did+".car"), raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestDirArchive(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	a := &DirArchive{Dir: writeTestArchive(t, "did:plc:aaa")}
+	rc, err := a.OpenRepo(ctx, "did:plc:aaa")
+	assert.NoError(err)
+	rc.Close()
+
+	_, err = a.OpenRepo(ctx, "did:plc:bbb")
+	assert.ErrorIs(err, ErrNotInArchive)
+	_, err = a.OpenRepo(ctx, "../did:plc:aaa")
+	assert.Error(err)
+
+	src, err := ParseArchiveSource(a.Dir)
+	assert.NoError(err)
+	assert.Equal(a.Dir, src.(*DirArchive).Dir)
+	_, err = ParseArchiveSource(filepath.Join(a.Dir, "did:plc:aaa.car"))
+	assert.Error(err)
+}
+
+func TestS3Archive(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var gotPath, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+		gotAuth = r.Header.Get("Authorization")
+		if strings.HasSuffix(gotPath, "did%3Aplc%3Aaaa.car") {
+			io.WriteString(w, "car bytes")
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	a := &S3Archive{S3Client: util.S3Client{
+		Endpoint:    srv.URL,
+		Region:      "us-east-1",
+		Bucket:      "snapshots",
+		Prefix:      "2024-06-01/",
+		Credentials: util.AWSCredentials{AccessKey: "AKIDEXAMPLE", SecretKey: "secret"},
+	}}
+	rc, err := a.OpenRepo(ctx, "did:plc:aaa")
+	assert.NoError(err)
+	body, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal("car bytes", string(body))
+	assert.Equal("/snapshots/2024-06-01/did%3Aplc%3Aaaa.car", gotPath)
+	assert.True(strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+	assert.Contains(gotAuth, "SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=")
+
+	_, err = a.OpenRepo(ctx, "did:plc:bbb")
+	assert.ErrorIs(err, ErrNotInArchive)
+
+	// no credentials means unsigned requests
+	a.Credentials.AccessKey = ""
+	_, err = a.OpenRepo(ctx, "did:plc:aaa")
+	assert.NoError(err)
+	assert.Empty(gotAuth)
+
+	t.Setenv("AWS_REGION", "eu-west-2")
+	t.Setenv("AWS_ENDPOINT_URL", "")
+	src, err := ParseArchiveSource("s3://snapshots/nightly")
+	assert.NoError(err)
+	s3a := src.(*S3Archive)
+	assert.Equal("https://s3.eu-west-2.amazonaws.com", s3a.Endpoint)
+	assert.Equal("snapshots", s3a.Bucket)
+	assert.Equal("nightly", s3a.Prefix)
+}
+
+func TestArchiveSinks(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	m := &ArchiveManifest{
+		Source: "test",
+		Repos:  []ArchiveManifestRepo{{Did: "did:plc:aaa", Rev: "3kabc", Size: 9}},
+	}
+
+	// directory sink writes what the directory source reads
+	dir := filepath.Join(t.TempDir(), "snapshot")
+	sink, err := ParseArchiveSink(dir)
+	assert.NoError(err)
+	assert.NoError(sink.PutRepo(ctx, "did:plc:aaa", 9, strings.NewReader("car bytes")))
+	assert.Error(sink.PutRepo(ctx, "../did:plc:aaa", 9, strings.NewReader("car bytes")))
+	assert.NoError(sink.PutManifest(ctx, m))
+	rc, err := sink.(*DirArchive).OpenRepo(ctx, "did:plc:aaa")
+	assert.NoError(err)
+	body, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal("car bytes", string(body))
+	raw, err := os.ReadFile(filepath.Join(dir, ArchiveManifestName))
+	assert.NoError(err)
+	var got ArchiveManifest
+	assert.NoError(json.Unmarshal(raw, &got))
+	assert.Equal(m.Repos, got.Repos)
+	entries, _ := os.ReadDir(dir)
+	assert.Len(entries, 2)
+
+	// S3 sink sends sized PUTs under the prefix
+	uploads := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.ContentLength <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		uploads[r.URL.EscapedPath()] = string(b)
+	}))
+	defer srv.Close()
+
+	s3a := &S3Archive{S3Client: util.S3Client{Endpoint: srv.URL, Region: "us-east-1", Bucket: "snapshots", Prefix: "2024-06-01"}}
+	assert.NoError(s3a.PutRepo(ctx, "did:plc:aaa", 9, strings.NewReader("car bytes")))
+	assert.NoError(s3a.PutManifest(ctx, m))
+	assert.Equal("car bytes", uploads["/snapshots/2024-06-01/did%3Aplc%3Aaaa.car"])
+	assert.Contains(uploads["/snapshots/2024-06-01/manifest.json"], `"did": "did:plc:aaa"`)
+}
+
+func TestBackfillFromArchive(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var lk sync.Mutex
+	creates := 0
+	handleCreate := func(ctx context.Context, repo string, rev string, path string, rec *[]byte, cid *cid.Cid) error {
+		lk.Lock()
+		defer lk.Unlock()
+		creates++
+		return nil
+	}
+
+	// network source serves the same CAR, and counts requests
+	networkRequests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		networkRequests++
+		http.ServeFile(w, r, testRepoCAR)
+	}))
+	defer srv.Close()
+
+	store := testGormstore(t)
+	opts := DefaultBackfillOptions()
+	opts.CheckoutPath = srv.URL + "/xrpc/com.atproto.sync.getRepo"
+	opts.Archive = &DirArchive{Dir: writeTestArchive(t, "did:plc:aaa")}
+	bf := NewBackfiller("test-archive", store, handleCreate, nil, nil, opts)
+
+	for _, did := range []string{"did:plc:aaa", "did:plc:bbb"} {
+		assert.NoError(store.EnqueueJob(ctx, did))
+	}
+
+	// archived repo is read from the snapshot
+	j, err := store.GetJob(ctx, "did:plc:aaa")
+	assert.NoError(err)
+	state, err := bf.BackfillRepo(ctx, j)
+	assert.NoError(err)
+	assert.Equal(StateComplete, state)
+	assert.Equal(0, networkRequests)
+	assert.NotEmpty(j.Rev())
+	archivedCreates := creates
+	assert.True(archivedCreates > 0)
+
+	// repo missing from the snapshot falls back to the network
+	j, err = store.GetJob(ctx, "did:plc:bbb")
+	assert.NoError(err)
+	state, err = bf.BackfillRepo(ctx, j)
+	assert.NoError(err)
+	assert.Equal(StateComplete, state)
+	assert.Equal(1, networkRequests)
+	assert.Equal(2*archivedCreates, creates)
+}
@@ -5,20 +5,24 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/repomgr"
+	"github.com/bluesky-social/indigo/util/ratelimit"
 	"github.com/ipfs/go-cid"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
 	"golang.org/x/sync/semaphore"
-	"golang.org/x/time/rate"
 )
 
 // Job is an interface for a backfill job
@@ -67,14 +71,28 @@ type Backfiller struct {
 
 	// Number of backfills to process in parallel
 	ParallelBackfills int
+	// Max number of backfills to process in parallel against any single host. Zero means only the global ParallelBackfills limit applies
+	ParallelBackfillsPerHost int
 	// Number of records to process in parallel for each backfill
 	ParallelRecordCreates int
 	// Prefix match for records to backfill i.e. app.bsky.feed.app/
 	// If empty, all records will be backfilled
 	NSIDFilter   string
 	CheckoutPath string
-
-	syncLimiter *rate.Limiter
+	// Priority for jobs created for repos first seen on the firehose (if the Store supports priorities; see PriorityStore)
+	ActiveRepoPriority int
+	// If set, receives repo lifecycle events and decoded records, in addition to the Handle funcs above
+	Hooks Hooks
+	// If set, backfilled repo CARs are passed whole to this sink (eg, a carstore), and the per-record handlers are only called for buffered firehose ops
+	RepoSink RepoSink
+	// If set, full backfills read repos from this snapshot archive when possible, and only fall back to the network for repos which are not in it
+	Archive ArchiveSource
+	// If set, repos are fetched directly from each account's PDS (as resolved by this directory) instead of from CheckoutPath, which is used as a fallback
+	Directory identity.Directory
+
+	syncLimiter *ratelimit.TokenBucket
+	hosts       *hostPool
+	paused      atomic.Bool
 
 	magicHeaderKey string
 	magicHeaderVal string
@@ -106,20 +124,35 @@ var ErrAlreadyProcessed = fmt.Errorf("event already accounted for")
 var tracer = otel.Tracer("backfiller")
 
 type BackfillOptions struct {
-	ParallelBackfills     int
-	ParallelRecordCreates int
-	NSIDFilter            string
+	ParallelBackfills        int
+	ParallelBackfillsPerHost int
+	ParallelRecordCreates    int
+	NSIDFilter               string
+	// Global limit on sync requests, across all hosts. Zero means no global limit
 	SyncRequestsPerSecond int
-	CheckoutPath          string
+	// Initial (and maximum) sync request rate against any single host. The rate for a host is reduced when it responds with HTTP 429, and recovers gradually after successful requests. Zero means no per-host limit until the host starts rate-limiting
+	HostRequestsPerSecond float64
+	// Floor for the per-host sync request rate after repeated HTTP 429 responses
+	MinHostRequestsPerSecond float64
+	CheckoutPath             string
+	Directory                identity.Directory
+	Archive                  ArchiveSource
+	ActiveRepoPriority       int
+	RepoSink                 RepoSink
+	Hooks                    Hooks
 }
 
 func DefaultBackfillOptions() *BackfillOptions {
 	return &BackfillOptions{
-		ParallelBackfills:     10,
-		ParallelRecordCreates: 100,
-		NSIDFilter:            "",
-		SyncRequestsPerSecond: 2,
-		CheckoutPath:          "https://bsky.social/xrpc/com.atproto.sync.getRepo",
+		ParallelBackfills:        10,
+		ParallelBackfillsPerHost: 0,
+		ParallelRecordCreates:    100,
+		NSIDFilter:               "",
+		SyncRequestsPerSecond:    2,
+		HostRequestsPerSecond:    20,
+		MinHostRequestsPerSecond: 0.5,
+		ActiveRepoPriority:       PriorityActive,
+		CheckoutPath:             "https://bsky.social/xrpc/com.atproto.sync.getRepo",
 	}
 }
 
@@ -135,18 +168,32 @@ func NewBackfiller(
 	if opts == nil {
 		opts = DefaultBackfillOptions()
 	}
+	syncLimit := float64(opts.SyncRequestsPerSecond)
+	if opts.SyncRequestsPerSecond <= 0 {
+		syncLimit = ratelimit.Inf
+	}
+	syncLimiter := ratelimit.NewTokenBucket(syncLimit, 1)
+	ratelimit.Register("backfill."+name+".sync_requests", syncLimiter)
+
 	return &Backfiller{
-		Name:                  name,
-		Store:                 store,
-		HandleCreateRecord:    handleCreate,
-		HandleUpdateRecord:    handleUpdate,
-		HandleDeleteRecord:    handleDelete,
-		ParallelBackfills:     opts.ParallelBackfills,
-		ParallelRecordCreates: opts.ParallelRecordCreates,
-		NSIDFilter:            opts.NSIDFilter,
-		syncLimiter:           rate.NewLimiter(rate.Limit(opts.SyncRequestsPerSecond), 1),
-		CheckoutPath:          opts.CheckoutPath,
-		stop:                  make(chan chan struct{}, 1),
+		Name:                     name,
+		Store:                    store,
+		HandleCreateRecord:       handleCreate,
+		HandleUpdateRecord:       handleUpdate,
+		HandleDeleteRecord:       handleDelete,
+		ParallelBackfills:        opts.ParallelBackfills,
+		ParallelBackfillsPerHost: opts.ParallelBackfillsPerHost,
+		ParallelRecordCreates:    opts.ParallelRecordCreates,
+		NSIDFilter:               opts.NSIDFilter,
+		syncLimiter:              syncLimiter,
+		hosts:                    newHostPool(opts.ParallelBackfillsPerHost, opts.HostRequestsPerSecond, opts.MinHostRequestsPerSecond),
+		CheckoutPath:             opts.CheckoutPath,
+		Directory:                opts.Directory,
+		Archive:                  opts.Archive,
+		ActiveRepoPriority:       opts.ActiveRepoPriority,
+		RepoSink:                 opts.RepoSink,
+		Hooks:                    opts.Hooks,
+		stop:                     make(chan chan struct{}, 1),
 	}
 }
 
@@ -163,12 +210,29 @@ func (b *Backfiller) Start() {
 		select {
 		case stopped := <-b.stop:
 			log.Info("stopping backfill processor")
+			// jobs still waiting on a busy host go back in the queue for next time
+			for _, pj := range b.hosts.drainPending() {
+				if err := pj.job.SetState(ctx, StateEnqueued); err != nil {
+					log.Error("failed to reset state of pending job", "repo", pj.job.Repo(), "error", err)
+				}
+			}
 			sem.Acquire(ctx, int64(b.ParallelBackfills))
 			close(stopped)
 			return
 		default:
 		}
 
+		if b.Paused() {
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		// if many claimed jobs are waiting on busy hosts, let those drain before claiming more
+		if b.hosts.pendingCount() >= maxPendingJobsFactor*b.ParallelBackfills {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
 		// Get the next job
 		job, err := b.Store.GetNextEnqueuedJob(ctx)
 		if err != nil {
@@ -189,28 +253,69 @@ func (b *Backfiller) Start() {
 			continue
 		}
 
+		pj := &pendingJob{job: job, checkoutPath: b.repoCheckoutPath(ctx, job.Repo())}
+		host := checkoutHost(pj.checkoutPath)
+		if !b.hosts.claim(host, pj) {
+			// host is at capacity; the job will be run by one of its current workers
+			continue
+		}
+
 		sem.Acquire(ctx, 1)
-		go func(j Job) {
+		go func() {
 			defer sem.Release(1)
-			newState, err := b.BackfillRepo(ctx, j)
-			if err != nil {
-				log.Error("failed to backfill repo", "error", err)
+			// keep working through jobs queued for this host, then give up the slot
+			for next := pj; next != nil; next = b.hosts.release(host) {
+				b.runJob(ctx, next)
 			}
-			if newState != "" {
-				if sserr := j.SetState(ctx, newState); sserr != nil {
-					log.Error("failed to set job state", "error", sserr)
-				}
+		}()
+	}
+}
 
-				if strings.HasPrefix(newState, "failed") {
-					// Clear buffered ops
-					if err := j.ClearBufferedOps(ctx); err != nil {
-						log.Error("failed to clear buffered ops", "error", err)
-					}
-				}
+// when this many multiples of ParallelBackfills are waiting on per-host limits, stop claiming new jobs
+const maxPendingJobsFactor = 10
+
+func (b *Backfiller) runJob(ctx context.Context, pj *pendingJob) {
+	j := pj.job
+	log := slog.With("source", "backfiller", "name", b.Name, "repo", j.Repo())
+	newState, err := b.backfillRepo(ctx, j, pj.checkoutPath)
+	if err != nil {
+		log.Error("failed to backfill repo", "error", err)
+		b.onError(ctx, j.Repo(), "", err)
+	}
+	if newState != "" {
+		if sserr := j.SetState(ctx, newState); sserr != nil {
+			log.Error("failed to set job state", "error", sserr)
+		}
+
+		if strings.HasPrefix(newState, "failed") {
+			// Clear buffered ops
+			if err := j.ClearBufferedOps(ctx); err != nil {
+				log.Error("failed to clear buffered ops", "error", err)
 			}
-			backfillJobsProcessed.WithLabelValues(b.Name).Inc()
-		}(job)
+		}
+	}
+	backfillJobsProcessed.WithLabelValues(b.Name).Inc()
+}
+
+// Resolves the getRepo endpoint to fetch a repo from: the account's PDS if a Directory is configured, otherwise (or if resolution fails) the static CheckoutPath.
+func (b *Backfiller) repoCheckoutPath(ctx context.Context, repoDid string) string {
+	if b.Directory == nil {
+		return b.CheckoutPath
+	}
+	did, err := syntax.ParseDID(repoDid)
+	if err != nil {
+		return b.CheckoutPath
+	}
+	ident, err := b.Directory.LookupDID(ctx, did)
+	if err != nil {
+		slog.Warn("failed to resolve PDS for backfill, using default checkout path", "source", "backfiller", "name", b.Name, "repo", repoDid, "error", err)
+		return b.CheckoutPath
 	}
+	pds := ident.PDSEndpoint()
+	if pds == "" {
+		return b.CheckoutPath
+	}
+	return strings.TrimSuffix(pds, "/") + "/xrpc/com.atproto.sync.getRepo"
 }
 
 // Stop stops the backfill processor
@@ -241,22 +346,9 @@ func (b *Backfiller) FlushBuffer(ctx context.Context, job Job) int {
 	// Flush buffered operations, clear the buffer, and mark the job as "complete"
 	// Clearing and marking are handled by the job interface
 	err := job.FlushBufferedOps(ctx, func(kind repomgr.EventKind, rev, path string, rec *[]byte, cid *cid.Cid) error {
-		switch kind {
-		case repomgr.EvtKindCreateRecord:
-			err := b.HandleCreateRecord(ctx, repo, rev, path, rec, cid)
-			if err != nil {
-				log.Error("failed to handle create record", "error", err)
-			}
-		case repomgr.EvtKindUpdateRecord:
-			err := b.HandleUpdateRecord(ctx, repo, rev, path, rec, cid)
-			if err != nil {
-				log.Error("failed to handle update record", "error", err)
-			}
-		case repomgr.EvtKindDeleteRecord:
-			err := b.HandleDeleteRecord(ctx, repo, rev, path)
-			if err != nil {
-				log.Error("failed to handle delete record", "error", err)
-			}
+		if err := b.handleOp(ctx, kind, repo, rev, path, rec, cid, false); err != nil {
+			log.Error("failed to handle buffered op", "path", path, "error", err)
+			b.onError(ctx, repo, path, err)
 		}
 		backfillOpsBuffered.WithLabelValues(b.Name).Dec()
 		processed++
@@ -294,6 +386,10 @@ type recordResult struct {
 
 // BackfillRepo backfills a repo
 func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) (string, error) {
+	return b.backfillRepo(ctx, job, b.repoCheckoutPath(ctx, job.Repo()))
+}
+
+func (b *Backfiller) backfillRepo(ctx context.Context, job Job, checkoutPath string) (string, error) {
 	ctx, span := tracer.Start(ctx, "BackfillRepo")
 	defer span.End()
 
@@ -307,61 +403,28 @@ func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) (string, error)
 	}
 	log.Info(fmt.Sprintf("processing backfill for %s", repoDid))
 
-	url := fmt.Sprintf("%s?did=%s", b.CheckoutPath, repoDid)
-
-	if job.Rev() != "" {
-		url = url + fmt.Sprintf("&since=%s", job.Rev())
-	}
-
-	// GET and CAR decode the body
-	client := &http.Client{
-		Transport: otelhttp.NewTransport(http.DefaultTransport),
-		Timeout:   600 * time.Second,
-	}
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		state := fmt.Sprintf("failed (create request: %s)", err.Error())
-		return state, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Accept", "application/vnd.ipld.car")
-	req.Header.Set("User-Agent", fmt.Sprintf("atproto-backfill-%s/0.0.1", b.Name))
-	if b.magicHeaderKey != "" && b.magicHeaderVal != "" {
-		req.Header.Set(b.magicHeaderKey, b.magicHeaderVal)
-	}
-
-	b.syncLimiter.Wait(ctx)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		state := fmt.Sprintf("failed (do request: %s)", err.Error())
-		return state, fmt.Errorf("failed to send request: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		reason := "unknown error"
-		if resp.StatusCode == http.StatusBadRequest {
-			reason = "repo not found"
-		} else {
-			reason = resp.Status
+	if b.Hooks != nil {
+		if err := b.Hooks.OnRepoStart(ctx, repoDid); err != nil {
+			return "failed (OnRepoStart hook)", fmt.Errorf("OnRepoStart hook: %w", err)
 		}
-		state := fmt.Sprintf("failed (%s)", reason)
-		return state, fmt.Errorf("failed to get repo: %s", reason)
 	}
 
-	instrumentedReader := instrumentedReader{
-		source:  resp.Body,
-		counter: backfillBytesProcessed.WithLabelValues(b.Name),
+	setJobProgress(ctx, job, PhaseFetching, 0)
+	if b.RepoSink != nil {
+		return b.importRepo(ctx, job, checkoutPath, log, start)
 	}
 
-	defer instrumentedReader.Close()
-
-	r, err := repo.ReadRepoFromCar(ctx, instrumentedReader)
+	var r *repo.Repo
+	source, state, err := b.readRepoCAR(ctx, job, checkoutPath, log, func(car io.Reader) error {
+		var err error
+		r, err = repo.ReadRepoFromCar(ctx, car)
+		return err
+	})
 	if err != nil {
-		state := "failed (couldn't read repo CAR from response body)"
-		return state, fmt.Errorf("failed to read repo from car: %w", err)
+		return state, err
 	}
 
+	setJobProgress(ctx, job, PhaseProcessing, 0)
 	numRecords := 0
 	numRoutines := b.ParallelRecordCreates
 	recordQueue := make(chan recordQueueItem, numRoutines)
@@ -396,9 +459,9 @@ func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) (string, error)
 
 				raw := blk.RawData()
 
-				err = b.HandleCreateRecord(ctx, repoDid, rev, item.recordPath, &raw, &item.nodeCid)
+				err = b.handleOp(ctx, repomgr.EvtKindCreateRecord, repoDid, rev, item.recordPath, &raw, &item.nodeCid, true)
 				if err != nil {
-					recordResults <- recordResult{recordPath: item.recordPath, err: fmt.Errorf("failed to handle create record: %w", err)}
+					recordResults <- recordResult{recordPath: item.recordPath, err: err}
 					continue
 				}
 
@@ -416,6 +479,7 @@ func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) (string, error)
 		for result := range recordResults {
 			if result.err != nil {
 				log.Error("Error processing record", "record", result.recordPath, "error", result.err)
+				b.onError(ctx, repoDid, result.recordPath, result.err)
 			}
 		}
 	}()
@@ -424,6 +488,7 @@ func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) (string, error)
 	close(recordResults)
 	resultWG.Wait()
 
+	setJobProgress(ctx, job, PhaseProcessing, numRecords)
 	if err := job.SetRev(ctx, r.SignedCommit().Rev); err != nil {
 		log.Error("failed to update rev after backfilling repo", "err", err)
 	}
@@ -432,14 +497,181 @@ func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) (string, error)
 	numProcessed := b.FlushBuffer(ctx, job)
 
 	log.Info("backfill complete",
+		"source", source,
 		"buffered_records_processed", numProcessed,
 		"records_backfilled", numRecords,
 		"duration", time.Since(start),
 	)
+	if b.Hooks != nil {
+		b.Hooks.OnRepoDone(ctx, repoDid, RepoSummary{
+			Rev:         rev,
+			Source:      source,
+			Records:     numRecords,
+			BufferedOps: numProcessed,
+			Duration:    time.Since(start),
+		})
+	}
+
+	return StateComplete, nil
+}
+
+// records progress for jobs which support it (see ProgressJob)
+func setJobProgress(ctx context.Context, job Job, phase string, recordsProcessed int) {
+	pj, ok := job.(ProgressJob)
+	if !ok {
+		return
+	}
+	if err := pj.SetProgress(ctx, phase, recordsProcessed); err != nil {
+		slog.Error("failed to update backfill job progress", "source", "backfiller", "repo", job.Repo(), "error", err)
+	}
+}
+
+// Block-level backfill: hands the whole repo CAR to the RepoSink, bypassing per-record handlers.
+func (b *Backfiller) importRepo(ctx context.Context, job Job, checkoutPath string, log *slog.Logger, start time.Time) (string, error) {
+	var since *string
+	if cur := job.Rev(); cur != "" {
+		since = &cur
+	}
+	var rev string
+	source, state, err := b.readRepoCAR(ctx, job, checkoutPath, log, func(car io.Reader) error {
+		raw, err := io.ReadAll(car)
+		if err != nil {
+			return err
+		}
+		rev, err = b.RepoSink.ImportRepo(ctx, job.Repo(), since, raw)
+		return err
+	})
+	if err != nil {
+		return state, err
+	}
+
+	if err := job.SetRev(ctx, rev); err != nil {
+		log.Error("failed to update rev after importing repo", "err", err)
+	}
+	backfillReposImported.WithLabelValues(b.Name).Inc()
+
+	// Process buffered operations, marking the job as "complete" when done
+	numProcessed := b.FlushBuffer(ctx, job)
+
+	log.Info("backfill import complete",
+		"source", source,
+		"rev", rev,
+		"buffered_records_processed", numProcessed,
+		"duration", time.Since(start),
+	)
+	if b.Hooks != nil {
+		b.Hooks.OnRepoDone(ctx, job.Repo(), RepoSummary{
+			Rev:         rev,
+			Source:      source,
+			BufferedOps: numProcessed,
+			Duration:    time.Since(start),
+		})
+	}
 
 	return StateComplete, nil
 }
 
+// Reads the repo CAR for a job, from the archive if possible and otherwise from the network, passing it to the read callback. If reading an archived CAR fails, it is fetched from the network instead. Returns the source used ("archive" or "network"), or a failure state and error.
+func (b *Backfiller) readRepoCAR(ctx context.Context, job Job, checkoutPath string, log *slog.Logger, read func(car io.Reader) error) (string, string, error) {
+	// archived snapshots only help with full backfills; repos which have synced before are always caught up from the network
+	if b.Archive != nil && job.Rev() == "" {
+		err := b.readArchivedCAR(ctx, job.Repo(), read)
+		switch {
+		case err == nil:
+			backfillArchiveLookups.WithLabelValues(b.Name, "hit").Inc()
+			return "archive", "", nil
+		case errors.Is(err, ErrNotInArchive):
+			backfillArchiveLookups.WithLabelValues(b.Name, "miss").Inc()
+		default:
+			backfillArchiveLookups.WithLabelValues(b.Name, "error").Inc()
+			log.Warn("failed to read repo from archive, falling back to network", "error", err)
+		}
+	}
+	state, err := b.fetchRepoCAR(ctx, job, checkoutPath, read)
+	return "network", state, err
+}
+
+func (b *Backfiller) readArchivedCAR(ctx context.Context, did string, read func(car io.Reader) error) error {
+	rc, err := b.Archive.OpenRepo(ctx, did)
+	if err != nil {
+		return err
+	}
+	ir := instrumentedReader{
+		source:  rc,
+		counter: backfillBytesProcessed.WithLabelValues(b.Name),
+	}
+	defer ir.Close()
+	return read(ir)
+}
+
+// Fetches a repo (or the diff since the job's current rev) from the network, subject to per-host and global rate limits.
+func (b *Backfiller) fetchRepoCAR(ctx context.Context, job Job, checkoutPath string, read func(car io.Reader) error) (string, error) {
+	url := fmt.Sprintf("%s?did=%s", checkoutPath, job.Repo())
+
+	if job.Rev() != "" {
+		url = url + fmt.Sprintf("&since=%s", job.Rev())
+	}
+
+	// GET and CAR decode the body
+	client := &http.Client{
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+		Timeout:   600 * time.Second,
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		state := fmt.Sprintf("failed (create request: %s)", err.Error())
+		return state, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/vnd.ipld.car")
+	req.Header.Set("User-Agent", fmt.Sprintf("atproto-backfill-%s/0.0.1", b.Name))
+	if b.magicHeaderKey != "" && b.magicHeaderVal != "" {
+		req.Header.Set(b.magicHeaderKey, b.magicHeaderVal)
+	}
+
+	host := checkoutHost(checkoutPath)
+	if err := b.hosts.wait(ctx, host); err != nil {
+		state := fmt.Sprintf("failed (waiting for host rate limit: %s)", err.Error())
+		return state, fmt.Errorf("failed waiting for host rate limit: %w", err)
+	}
+	b.syncLimiter.Wait(ctx)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		state := fmt.Sprintf("failed (do request: %s)", err.Error())
+		return state, fmt.Errorf("failed to send request: %w", err)
+	}
+	b.hosts.observe(host, resp)
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusTooManyRequests {
+			backfillSyncRateLimited.WithLabelValues(b.Name).Inc()
+		}
+		resp.Body.Close()
+		reason := "unknown error"
+		if resp.StatusCode == http.StatusBadRequest {
+			reason = "repo not found"
+		} else {
+			reason = resp.Status
+		}
+		state := fmt.Sprintf("failed (%s)", reason)
+		return state, fmt.Errorf("failed to get repo: %s", reason)
+	}
+
+	instrumentedReader := instrumentedReader{
+		source:  resp.Body,
+		counter: backfillBytesProcessed.WithLabelValues(b.Name),
+	}
+
+	defer instrumentedReader.Close()
+
+	if err := read(instrumentedReader); err != nil {
+		state := "failed (couldn't read repo CAR from response body)"
+		return state, fmt.Errorf("failed to read repo from car: %w", err)
+	}
+	return "", nil
+}
+
 const trust = true
 
 func (bf *Backfiller) getRecord(ctx context.Context, r *repo.Repo, op *atproto.SyncSubscribeRepos_RepoOp) (cid.Cid, *[]byte, error) {
@@ -513,19 +745,8 @@ func (bf *Backfiller) HandleEvent(ctx context.Context, evt *atproto.SyncSubscrib
 	}
 
 	for _, op := range ops {
-		switch op.Kind {
-		case repomgr.EvtKindCreateRecord:
-			if err := bf.HandleCreateRecord(ctx, evt.Repo, evt.Rev, op.Path, op.Record, op.Cid); err != nil {
-				return fmt.Errorf("create record failed: %w", err)
-			}
-		case repomgr.EvtKindUpdateRecord:
-			if err := bf.HandleUpdateRecord(ctx, evt.Repo, evt.Rev, op.Path, op.Record, op.Cid); err != nil {
-				return fmt.Errorf("update record failed: %w", err)
-			}
-		case repomgr.EvtKindDeleteRecord:
-			if err := bf.HandleDeleteRecord(ctx, evt.Repo, evt.Rev, op.Path); err != nil {
-				return fmt.Errorf("delete record failed: %w", err)
-			}
+		if err := bf.handleOp(ctx, op.Kind, evt.Repo, evt.Rev, op.Path, op.Record, op.Cid, false); err != nil {
+			return err
 		}
 	}
 
@@ -551,7 +772,14 @@ func (bf *Backfiller) BufferOps(ctx context.Context, repo string, since *string,
 		if !errors.Is(err, ErrJobNotFound) {
 			return false, err
 		}
-		if qerr := bf.Store.EnqueueJob(ctx, repo); qerr != nil {
+		// repos with events on the firehose are active, so backfill them ahead of the long tail
+		var qerr error
+		if ps, ok := bf.Store.(PriorityStore); ok {
+			qerr = ps.EnqueueJobWithPriority(ctx, repo, bf.ActiveRepoPriority)
+		} else {
+			qerr = bf.Store.EnqueueJob(ctx, repo)
+		}
+		if qerr != nil {
 			return false, fmt.Errorf("failed to enqueue job for unknown repo: %w", qerr)
 		}
 
@@ -0,0 +1,83 @@
+package backfill
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/models"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const testRepoDID = "did:plc:kzcqyc3unb33eh5sxzsfs25z"
+
+func testCarstore(t *testing.T) *carstore.CarStore {
+	dir := t.TempDir()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "meta.sqlite")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := carstore.NewCarStore(db, filepath.Join(dir, "shards"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cs
+}
+
+func TestBackfillToCarstore(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	creates := 0
+	handleCreate := func(ctx context.Context, repo string, rev string, path string, rec *[]byte, cid *cid.Cid) error {
+		creates++
+		return nil
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, testRepoCAR)
+	}))
+	defer srv.Close()
+
+	cs := testCarstore(t)
+	store := testGormstore(t)
+	opts := DefaultBackfillOptions()
+	opts.CheckoutPath = srv.URL + "/xrpc/com.atproto.sync.getRepo"
+	opts.RepoSink = &CarstoreSink{
+		CarStore: cs,
+		UidForDID: func(ctx context.Context, did string) (models.Uid, error) {
+			return 1, nil
+		},
+	}
+	bf := NewBackfiller("test-carstore", store, handleCreate, nil, nil, opts)
+
+	assert.NoError(store.EnqueueJob(ctx, testRepoDID))
+	j, err := store.GetJob(ctx, testRepoDID)
+	assert.NoError(err)
+	state, err := bf.BackfillRepo(ctx, j)
+	assert.NoError(err)
+	assert.Equal(StateComplete, state)
+	assert.Equal("3k67up3j7hf2x", j.Rev())
+	// records are not walked
+	assert.Equal(0, creates)
+
+	rev, err := cs.GetUserRepoRev(ctx, 1)
+	assert.NoError(err)
+	assert.Equal("3k67up3j7hf2x", rev)
+	head, err := cs.GetUserRepoHead(ctx, 1)
+	assert.NoError(err)
+	assert.True(head.Defined())
+
+	// a CAR for a different repo is rejected
+	assert.NoError(store.EnqueueJob(ctx, "did:plc:aaa"))
+	j, err = store.GetJob(ctx, "did:plc:aaa")
+	assert.NoError(err)
+	_, err = bf.BackfillRepo(ctx, j)
+	assert.Error(err)
+}
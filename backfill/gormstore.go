@@ -20,6 +20,11 @@ type Gormjob struct {
 
 	lk          sync.Mutex
 	bufferedOps []*opSet
+	// approximate size of bufferedOps, counted against limits
+	bufferedBytes int64
+	// once a job starts spilling, all its buffered ops are on disk until the buffer is flushed or cleared
+	spill  *spillFile
+	limits *bufferLimits
 
 	dbj *GormDBJob
 	db  *gorm.DB
@@ -35,9 +40,15 @@ type GormDBJob struct {
 	gorm.Model
 	Repo       string `gorm:"unique;index"`
 	State      string `gorm:"index:enqueued_job_idx,where:state = 'enqueued';index:retryable_job_idx,where:state like 'failed%'"`
+	Priority   int    `gorm:"index:enqueued_job_idx,sort:desc;not null;default:0"`
 	Rev        string
 	RetryCount int
 	RetryAfter *time.Time `gorm:"index:retryable_job_idx,sort:desc"`
+	// Step of an in-progress backfill (PhaseFetching or PhaseProcessing)
+	Phase            string
+	RecordsProcessed int
+	StartedAt        *time.Time
+	CompletedAt      *time.Time `gorm:"index"`
 }
 
 // Gormstore is a gorm-backed implementation of the Backfill Store interface
@@ -46,21 +57,34 @@ type Gormstore struct {
 	jobs map[string]*Gormjob
 
 	qlk       sync.Mutex
-	taskQueue []string
+	taskQueue jobQueue
 
 	db *gorm.DB
+
+	limits *bufferLimits
 }
 
 func NewGormstore(db *gorm.DB) *Gormstore {
 	return &Gormstore{
-		jobs: make(map[string]*Gormjob),
-		db:   db,
+		jobs:   make(map[string]*Gormjob),
+		db:     db,
+		limits: &bufferLimits{},
 	}
 }
 
+// SetBufferLimits sets a ceiling on the (approximate) total size of firehose ops buffered in memory while repos are backfilled. Beyond that, jobs buffer ops in files in spillDir (or the default temporary directory, if empty). A maxBytes of zero means no limit. This must be called before the store is used.
+func (s *Gormstore) SetBufferLimits(maxBytes int64, spillDir string) {
+	s.limits.maxBytes = maxBytes
+	s.limits.dir = spillDir
+}
+
+// LoadJobs loads enqueued and retryable jobs from the database. It should be called once at startup, and re-enqueues any jobs which were in progress when the previous process stopped.
 func (s *Gormstore) LoadJobs(ctx context.Context) error {
 	s.qlk.Lock()
 	defer s.qlk.Unlock()
+	if err := s.db.Exec("UPDATE gorm_db_jobs SET state = ?, phase = '' WHERE state = ?", StateEnqueued, StateInProgress).Error; err != nil {
+		return fmt.Errorf("resetting interrupted jobs: %w", err)
+	}
 	return s.loadJobs(ctx, 20_000)
 }
 
@@ -74,23 +98,29 @@ func (s *Gormstore) loadJobs(ctx context.Context, limit int) error {
 		retryableIndexClause = "INDEXED BY retryable_job_idx"
 	}
 
-	enqueuedSelect := fmt.Sprintf(`SELECT repo FROM gorm_db_jobs %s WHERE state  = 'enqueued' LIMIT ?`, enqueuedIndexClause)
-	retryableSelect := fmt.Sprintf(`SELECT repo FROM gorm_db_jobs %s WHERE state like 'failed%%' AND (retry_after = NULL OR retry_after < ?) LIMIT ?`, retryableIndexClause)
+	enqueuedSelect := fmt.Sprintf(`SELECT repo, priority FROM gorm_db_jobs %s WHERE state  = 'enqueued' ORDER BY priority DESC LIMIT ?`, enqueuedIndexClause)
+	retryableSelect := fmt.Sprintf(`SELECT repo, priority FROM gorm_db_jobs %s WHERE state like 'failed%%' AND (retry_after = NULL OR retry_after < ?) LIMIT ?`, retryableIndexClause)
 
-	var todo []string
+	type todoRow struct {
+		Repo     string
+		Priority int
+	}
+	var todo []todoRow
 	if err := s.db.Raw(enqueuedSelect, limit).Scan(&todo).Error; err != nil {
 		return err
 	}
 
 	if len(todo) < limit {
-		var moreTodo []string
+		var moreTodo []todoRow
 		if err := s.db.Raw(retryableSelect, time.Now(), limit-len(todo)).Scan(&moreTodo).Error; err != nil {
 			return err
 		}
 		todo = append(todo, moreTodo...)
 	}
 
-	s.taskQueue = append(s.taskQueue, todo...)
+	for _, item := range todo {
+		s.taskQueue.push(item.Repo, item.Priority)
+	}
 
 	return nil
 }
@@ -113,26 +143,47 @@ func (s *Gormstore) GetOrCreateJob(ctx context.Context, repo, state string) (Job
 }
 
 func (s *Gormstore) EnqueueJob(ctx context.Context, repo string) error {
-	_, err := s.GetOrCreateJob(ctx, repo, StateEnqueued)
+	j, err := s.GetOrCreateJob(ctx, repo, StateEnqueued)
 	if err != nil {
 		return err
 	}
 
 	s.qlk.Lock()
-	s.taskQueue = append(s.taskQueue, repo)
+	s.taskQueue.push(repo, j.(*Gormjob).Priority())
 	s.qlk.Unlock()
 
 	return nil
 }
 
 func (s *Gormstore) EnqueueJobWithState(ctx context.Context, repo, state string) error {
-	_, err := s.GetOrCreateJob(ctx, repo, state)
+	j, err := s.GetOrCreateJob(ctx, repo, state)
 	if err != nil {
 		return err
 	}
 
 	s.qlk.Lock()
-	s.taskQueue = append(s.taskQueue, repo)
+	s.taskQueue.push(repo, j.(*Gormjob).Priority())
+	s.qlk.Unlock()
+
+	return nil
+}
+
+func (s *Gormstore) EnqueueJobWithPriority(ctx context.Context, repo string, priority int) error {
+	j, err := s.GetOrCreateJob(ctx, repo, StateEnqueued)
+	if err != nil {
+		return err
+	}
+	gj := j.(*Gormjob)
+	if err := gj.raisePriority(ctx, priority); err != nil {
+		return err
+	}
+	if gj.State() != StateEnqueued {
+		return nil
+	}
+
+	// any earlier, lower-priority queue entry for the repo is skipped once the job has started
+	s.qlk.Lock()
+	s.taskQueue.push(repo, gj.Priority())
 	s.qlk.Unlock()
 
 	return nil
@@ -165,8 +216,9 @@ func (s *Gormstore) createJobForRepo(repo, state string) error {
 		updatedAt: time.Now(),
 		state:     state,
 
-		dbj: dbj,
-		db:  s.db,
+		dbj:    dbj,
+		db:     s.db,
+		limits: s.limits,
 	}
 	s.jobs[repo] = j
 
@@ -199,13 +251,58 @@ func (j *Gormjob) BufferOps(ctx context.Context, since *string, rev string, ops
 		return false, ErrAlreadyProcessed
 	}
 
-	j.bufferOps(&opSet{since: since, rev: rev, ops: ops})
+	if err := j.bufferOps(&opSet{since: since, rev: rev, ops: ops}); err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
-func (j *Gormjob) bufferOps(ops *opSet) {
-	j.bufferedOps = append(j.bufferedOps, ops)
+// must hold lock
+func (j *Gormjob) bufferOps(ops *opSet) error {
 	j.updatedAt = time.Now()
+	size := opSetSize(ops)
+	if j.spill == nil && j.limits.overLimit(size) {
+		if err := j.startSpill(); err != nil {
+			return err
+		}
+	}
+	if j.spill != nil {
+		return j.spill.write(ops)
+	}
+	j.bufferedOps = append(j.bufferedOps, ops)
+	j.bufferedBytes += size
+	j.limits.add(size)
+	return nil
+}
+
+// moves any ops buffered in memory to a new spill file, preserving order. must hold lock
+func (j *Gormjob) startSpill() error {
+	sf, err := newSpillFile(j.limits.dir)
+	if err != nil {
+		return err
+	}
+	for _, ops := range j.bufferedOps {
+		if err := sf.write(ops); err != nil {
+			sf.remove()
+			return err
+		}
+	}
+	j.spill = sf
+	j.bufferedOps = []*opSet{}
+	j.limits.add(-j.bufferedBytes)
+	j.bufferedBytes = 0
+	return nil
+}
+
+// drops all buffered ops, in memory and on disk. must hold lock
+func (j *Gormjob) clearBuffer() {
+	j.bufferedOps = []*opSet{}
+	j.limits.add(-j.bufferedBytes)
+	j.bufferedBytes = 0
+	if j.spill != nil {
+		j.spill.remove()
+		j.spill = nil
+	}
 }
 
 func (s *Gormstore) GetJob(ctx context.Context, repo string) (Job, error) {
@@ -234,11 +331,13 @@ func (s *Gormstore) loadJob(ctx context.Context, repo string) (*Gormjob, error)
 	j := &Gormjob{
 		repo:      dbj.Repo,
 		state:     dbj.State,
+		rev:       dbj.Rev,
 		createdAt: dbj.CreatedAt,
 		updatedAt: dbj.UpdatedAt,
 
-		dbj: &dbj,
-		db:  s.db,
+		dbj:    &dbj,
+		db:     s.db,
+		limits: s.limits,
 
 		retryCount: dbj.RetryCount,
 		retryAfter: dbj.RetryAfter,
@@ -268,19 +367,18 @@ func (s *Gormstore) checkJobCache(ctx context.Context, repo string) *Gormjob {
 func (s *Gormstore) GetNextEnqueuedJob(ctx context.Context) (Job, error) {
 	s.qlk.Lock()
 	defer s.qlk.Unlock()
-	if len(s.taskQueue) == 0 {
+	if s.taskQueue.Len() == 0 {
 		if err := s.loadJobs(ctx, 1000); err != nil {
 			return nil, err
 		}
 
-		if len(s.taskQueue) == 0 {
+		if s.taskQueue.Len() == 0 {
 			return nil, nil
 		}
 	}
 
-	for len(s.taskQueue) > 0 {
-		first := s.taskQueue[0]
-		s.taskQueue = s.taskQueue[1:]
+	for s.taskQueue.Len() > 0 {
+		first := s.taskQueue.pop()
 
 		j, err := s.getJob(ctx, first)
 		if err != nil {
@@ -315,6 +413,7 @@ func (j *Gormjob) SetRev(ctx context.Context, r string) error {
 	j.updatedAt = time.Now()
 
 	// Persist the job to the database
+	j.dbj.Rev = r
 
 	return j.db.Save(j.dbj).Error
 }
@@ -333,6 +432,20 @@ func (j *Gormjob) SetState(ctx context.Context, state string) error {
 	j.state = state
 	j.updatedAt = time.Now()
 
+	switch state {
+	case StateInProgress:
+		now := time.Now()
+		j.dbj.StartedAt = &now
+		j.dbj.CompletedAt = nil
+		j.dbj.RecordsProcessed = 0
+	case StateComplete:
+		now := time.Now()
+		j.dbj.CompletedAt = &now
+	}
+	if state != StateInProgress {
+		j.dbj.Phase = ""
+	}
+
 	if strings.HasPrefix(state, "failed") {
 		if j.retryCount < MaxRetries {
 			next := time.Now().Add(computeExponentialBackoff(j.retryCount))
@@ -345,6 +458,36 @@ func (j *Gormjob) SetState(ctx context.Context, state string) error {
 
 	// Persist the job to the database
 	j.dbj.State = state
+	j.dbj.RetryCount = j.retryCount
+	j.dbj.RetryAfter = j.retryAfter
+	return j.db.Save(j.dbj).Error
+}
+
+// SetProgress records the current phase of an in-progress backfill, and the number of records processed so far.
+func (j *Gormjob) SetProgress(ctx context.Context, phase string, recordsProcessed int) error {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+
+	j.updatedAt = time.Now()
+	j.dbj.Phase = phase
+	j.dbj.RecordsProcessed = recordsProcessed
+	return j.db.Save(j.dbj).Error
+}
+
+// resets a job to be picked up again immediately, clearing any retry backoff
+func (j *Gormjob) resetForRetry(ctx context.Context) error {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+
+	j.state = StateEnqueued
+	j.retryCount = 0
+	j.retryAfter = nil
+	j.updatedAt = time.Now()
+
+	j.dbj.State = StateEnqueued
+	j.dbj.Phase = ""
+	j.dbj.RetryCount = 0
+	j.dbj.RetryAfter = nil
 	return j.db.Save(j.dbj).Error
 }
 
@@ -353,28 +496,28 @@ func (j *Gormjob) FlushBufferedOps(ctx context.Context, fn func(kind repomgr.Eve
 	j.lk.Lock()
 	defer j.lk.Unlock()
 
-	for _, opset := range j.bufferedOps {
+	process := func(opset *opSet) error {
 		if opset.rev <= j.rev {
 			// stale events, skip
-			continue
+			return nil
 		}
 
 		if opset.since == nil {
 			// The first event for a repo may have a nil since
 			// We should process it only if the rev is empty, skip otherwise
 			if j.rev != "" {
-				continue
+				return nil
+			}
+		} else {
+			if j.rev > *opset.since {
+				// we've already accounted for this event
+				return nil
 			}
-		}
-
-		if j.rev > *opset.since {
-			// we've already accounted for this event
-			continue
-		}
 
-		if j.rev != *opset.since {
-			// we've got a discontinuity
-			return fmt.Errorf("event since did not match current rev (%s != %s): %w", *opset.since, j.rev, ErrEventGap)
+			if j.rev != *opset.since {
+				// we've got a discontinuity
+				return fmt.Errorf("event since did not match current rev (%s != %s): %w", *opset.since, j.rev, ErrEventGap)
+			}
 		}
 
 		for _, op := range opset.ops {
@@ -384,9 +527,22 @@ func (j *Gormjob) FlushBufferedOps(ctx context.Context, fn func(kind repomgr.Eve
 		}
 
 		j.rev = opset.rev
+		j.dbj.Rev = opset.rev
+		return nil
 	}
 
-	j.bufferedOps = []*opSet{}
+	if j.spill != nil {
+		if err := j.spill.forEach(process); err != nil {
+			return err
+		}
+	}
+	for _, opset := range j.bufferedOps {
+		if err := process(opset); err != nil {
+			return err
+		}
+	}
+
+	j.clearBuffer()
 	j.state = StateComplete
 
 	return nil
@@ -396,11 +552,28 @@ func (j *Gormjob) ClearBufferedOps(ctx context.Context) error {
 	j.lk.Lock()
 	defer j.lk.Unlock()
 
-	j.bufferedOps = []*opSet{}
+	j.clearBuffer()
 	j.updatedAt = time.Now()
 	return nil
 }
 
+func (j *Gormjob) Priority() int {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+	return j.dbj.Priority
+}
+
+func (j *Gormjob) raisePriority(ctx context.Context, priority int) error {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+
+	if j.dbj.Priority >= priority {
+		return nil
+	}
+	j.dbj.Priority = priority
+	return j.db.Save(j.dbj).Error
+}
+
 func (j *Gormjob) RetryCount() int {
 	j.lk.Lock()
 	defer j.lk.Unlock()
@@ -423,7 +596,143 @@ func (s *Gormstore) PurgeRepo(ctx context.Context, repo string) error {
 
 	s.lk.Lock()
 	defer s.lk.Unlock()
+	if j, ok := s.jobs[repo]; ok {
+		j.lk.Lock()
+		j.clearBuffer()
+		j.lk.Unlock()
+	}
 	delete(s.jobs, repo)
 
 	return nil
 }
+
+func gormJobInfo(dbj *GormDBJob) JobInfo {
+	info := JobInfo{
+		Repo:             dbj.Repo,
+		State:            dbj.State,
+		Phase:            dbj.Phase,
+		Rev:              dbj.Rev,
+		Priority:         dbj.Priority,
+		RetryCount:       dbj.RetryCount,
+		RetryAfter:       dbj.RetryAfter,
+		RecordsProcessed: dbj.RecordsProcessed,
+		StartedAt:        dbj.StartedAt,
+		CompletedAt:      dbj.CompletedAt,
+		UpdatedAt:        dbj.UpdatedAt,
+	}
+	if strings.HasPrefix(dbj.State, "failed") {
+		info.State = "failed"
+		info.FailureReason = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(dbj.State, "failed"), " ("), ")")
+	}
+	return info
+}
+
+func (s *Gormstore) ListJobs(ctx context.Context, state string, cursor uint, limit int) ([]JobInfo, error) {
+	q := s.db.WithContext(ctx).Model(&GormDBJob{}).Where("id > ?", cursor)
+	switch state {
+	case "":
+	case "failed":
+		q = q.Where("state like 'failed%'")
+	default:
+		q = q.Where("state = ?", state)
+	}
+	var dbjs []GormDBJob
+	if err := q.Order("id ASC").Limit(limit).Find(&dbjs).Error; err != nil {
+		return nil, err
+	}
+	out := make([]JobInfo, len(dbjs))
+	for i := range dbjs {
+		out[i] = gormJobInfo(&dbjs[i])
+		out[i].ID = dbjs[i].ID
+	}
+	return out, nil
+}
+
+func (s *Gormstore) GetJobInfo(ctx context.Context, repo string) (*JobInfo, error) {
+	var dbj GormDBJob
+	if err := s.db.WithContext(ctx).Find(&dbj, "repo = ?", repo).Error; err != nil {
+		return nil, err
+	}
+	if dbj.ID == 0 {
+		return nil, ErrJobNotFound
+	}
+	info := gormJobInfo(&dbj)
+	info.ID = dbj.ID
+	return &info, nil
+}
+
+func (s *Gormstore) RetryJob(ctx context.Context, repo string) error {
+	j, err := s.getJob(ctx, repo)
+	if err != nil {
+		return err
+	}
+	if st := j.State(); st == StateInProgress || st == StateEnqueued {
+		return nil
+	}
+	if err := j.resetForRetry(ctx); err != nil {
+		return err
+	}
+	s.qlk.Lock()
+	s.taskQueue.push(repo, j.Priority())
+	s.qlk.Unlock()
+	return nil
+}
+
+func (s *Gormstore) RetryFailedJobs(ctx context.Context) (int, error) {
+	var repos []string
+	if err := s.db.WithContext(ctx).Model(&GormDBJob{}).Where("state like 'failed%'").Pluck("repo", &repos).Error; err != nil {
+		return 0, err
+	}
+	for i, repo := range repos {
+		if err := s.RetryJob(ctx, repo); err != nil {
+			return i, fmt.Errorf("retrying job for %s: %w", repo, err)
+		}
+	}
+	return len(repos), nil
+}
+
+func (s *Gormstore) Progress(ctx context.Context, window time.Duration) (*Progress, error) {
+	var rows []struct {
+		State string
+		Count int64
+	}
+	if err := s.db.WithContext(ctx).Raw(`SELECT CASE WHEN state like 'failed%' THEN 'failed' ELSE state END AS state, COUNT(*) AS count FROM gorm_db_jobs WHERE deleted_at IS NULL GROUP BY 1`).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	var p Progress
+	for _, row := range rows {
+		switch row.State {
+		case StateEnqueued:
+			p.Enqueued = row.Count
+		case StateInProgress:
+			p.InProgress = row.Count
+		case StateComplete:
+			p.Complete = row.Count
+		case "failed":
+			p.Failed = row.Count
+		}
+		p.Total += row.Count
+	}
+	if err := s.db.WithContext(ctx).Model(&GormDBJob{}).Where("completed_at > ?", time.Now().Add(-window)).Count(&p.RecentlyCompleted).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *Gormstore) GetRevs(ctx context.Context, repos []string) (map[string]string, error) {
+	out := make(map[string]string, len(repos))
+	if len(repos) == 0 {
+		return out, nil
+	}
+	var rows []struct {
+		Repo string
+		Rev  string
+	}
+	if err := s.db.WithContext(ctx).Model(&GormDBJob{}).Select("repo, rev").Where("repo IN ?", repos).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		out[row.Repo] = row.Rev
+	}
+	return out, nil
+}
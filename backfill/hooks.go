@@ -0,0 +1,127 @@
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/repomgr"
+
+	// registers app.bsky record types, so they can be decoded for hooks
+	_ "github.com/bluesky-social/indigo/api/bsky"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Hooks receives backfill lifecycle events and decoded records, so indexers can plug their processing into the Backfiller. Hooks are called in addition to the HandleCreateRecord/HandleUpdateRecord/HandleDeleteRecord funcs (any of which may be nil). Embed NopHooks to implement only some of the methods.
+//
+// Methods may be called concurrently, for different repos and for records within a single repo.
+type Hooks interface {
+	// Called before a repo is backfilled. Returning an error fails the job
+	OnRepoStart(ctx context.Context, repo string) error
+	// Called for each record in a backfilled repo, and for each buffered or live firehose op. Errors during backfill are passed to OnError and do not stop the backfill; errors from live ops are returned by HandleEvent
+	OnRecord(ctx context.Context, rec *Record) error
+	// Called after a repo has been backfilled and its buffered ops processed
+	OnRepoDone(ctx context.Context, repo string, summary RepoSummary)
+	// Called when processing a record (path is set) or a whole repo (path is empty) fails during backfill
+	OnError(ctx context.Context, repo string, path string, err error)
+}
+
+// NopHooks implements Hooks with methods which do nothing
+type NopHooks struct{}
+
+func (NopHooks) OnRepoStart(ctx context.Context, repo string) error               { return nil }
+func (NopHooks) OnRecord(ctx context.Context, rec *Record) error                  { return nil }
+func (NopHooks) OnRepoDone(ctx context.Context, repo string, s RepoSummary)       {}
+func (NopHooks) OnError(ctx context.Context, repo string, path string, err error) {}
+
+// Record is a single record op passed to Hooks.OnRecord
+type Record struct {
+	Repo       string
+	Rev        string
+	Collection string
+	Rkey       string
+	// One of repomgr.EvtKindCreateRecord, EvtKindUpdateRecord, or EvtKindDeleteRecord. Records from a repo checkout are always creates
+	Kind repomgr.EventKind
+	// True for records read from a repo checkout, false for firehose ops (whether buffered during the backfill or live)
+	Backfilled bool
+	// CID and raw CBOR of the record; unset for deletes
+	Cid *cid.Cid
+	Raw []byte
+	// Decoded record (eg, *bsky.FeedPost). Nil for deletes, or if the record type is unknown or failed to decode (see DecodeErr)
+	Value     lexutil.CBOR
+	DecodeErr error
+}
+
+// Path returns the record's path within the repo ("<collection>/<rkey>")
+func (r *Record) Path() string {
+	return r.Collection + "/" + r.Rkey
+}
+
+// RepoSummary describes a completed repo backfill
+type RepoSummary struct {
+	Rev string
+	// "archive" or "network"
+	Source string
+	// Records read from the repo checkout. Zero when the repo was imported whole (see RepoSink)
+	Records int
+	// Buffered firehose ops processed after the checkout
+	BufferedOps int
+	Duration    time.Duration
+}
+
+func newRecord(repo, rev, path string, kind repomgr.EventKind, rec *[]byte, cc *cid.Cid, backfilled bool) *Record {
+	r := &Record{
+		Repo:       repo,
+		Rev:        rev,
+		Kind:       kind,
+		Backfilled: backfilled,
+		Cid:        cc,
+	}
+	r.Collection, r.Rkey, _ = strings.Cut(path, "/")
+	if rec != nil {
+		r.Raw = *rec
+		r.Value, r.DecodeErr = lexutil.CborDecodeValue(r.Raw)
+	}
+	return r
+}
+
+// passes a record op to whichever of the handler funcs and hooks are set
+func (b *Backfiller) handleOp(ctx context.Context, kind repomgr.EventKind, repo, rev, path string, rec *[]byte, cc *cid.Cid, backfilled bool) error {
+	var err error
+	switch kind {
+	case repomgr.EvtKindCreateRecord:
+		if b.HandleCreateRecord != nil {
+			err = b.HandleCreateRecord(ctx, repo, rev, path, rec, cc)
+		}
+	case repomgr.EvtKindUpdateRecord:
+		if b.HandleUpdateRecord != nil {
+			err = b.HandleUpdateRecord(ctx, repo, rev, path, rec, cc)
+		}
+	case repomgr.EvtKindDeleteRecord:
+		if b.HandleDeleteRecord != nil {
+			err = b.HandleDeleteRecord(ctx, repo, rev, path)
+		}
+	default:
+		return fmt.Errorf("invalid op kind: %q", kind)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to handle %s record: %w", kind, err)
+	}
+
+	if b.Hooks == nil {
+		return nil
+	}
+	if err := b.Hooks.OnRecord(ctx, newRecord(repo, rev, path, kind, rec, cc, backfilled)); err != nil {
+		return fmt.Errorf("OnRecord hook for %s record: %w", kind, err)
+	}
+	return nil
+}
+
+func (b *Backfiller) onError(ctx context.Context, repo, path string, err error) {
+	if b.Hooks != nil {
+		b.Hooks.OnError(ctx, repo, path, err)
+	}
+}
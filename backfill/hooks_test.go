@@ -0,0 +1,93 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/repomgr"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testHooks struct {
+	NopHooks
+	lk       sync.Mutex
+	started  []string
+	records  []*Record
+	done     map[string]RepoSummary
+	failRepo string
+}
+
+func (h *testHooks) OnRepoStart(ctx context.Context, repo string) error {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	h.started = append(h.started, repo)
+	if repo == h.failRepo {
+		return errors.New("not today")
+	}
+	return nil
+}
+
+func (h *testHooks) OnRecord(ctx context.Context, rec *Record) error {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	h.records = append(h.records, rec)
+	return nil
+}
+
+func (h *testHooks) OnRepoDone(ctx context.Context, repo string, s RepoSummary) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	h.done[repo] = s
+}
+
+func TestBackfillHooks(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	hooks := &testHooks{done: map[string]RepoSummary{}, failRepo: "did:plc:bbb"}
+	store := testGormstore(t)
+	opts := DefaultBackfillOptions()
+	opts.Archive = &DirArchive{Dir: writeTestArchive(t, "did:plc:aaa")}
+	opts.Hooks = hooks
+	// no handler funcs; hooks only
+	bf := NewBackfiller("test-hooks", store, nil, nil, nil, opts)
+
+	assert.NoError(store.EnqueueJob(ctx, "did:plc:aaa"))
+	j, err := store.GetJob(ctx, "did:plc:aaa")
+	assert.NoError(err)
+	state, err := bf.BackfillRepo(ctx, j)
+	assert.NoError(err)
+	assert.Equal(StateComplete, state)
+
+	assert.Equal([]string{"did:plc:aaa"}, hooks.started)
+	assert.NotEmpty(hooks.records)
+	summary := hooks.done["did:plc:aaa"]
+	assert.Equal("archive", summary.Source)
+	assert.Equal(j.Rev(), summary.Rev)
+	assert.Equal(len(hooks.records), summary.Records)
+
+	for _, rec := range hooks.records {
+		assert.Equal(repomgr.EvtKindCreateRecord, rec.Kind)
+		assert.True(rec.Backfilled)
+		assert.Equal("app.bsky.graph.follow", rec.Collection)
+		assert.NotEmpty(rec.Rkey)
+		assert.NoError(rec.DecodeErr)
+		follow, ok := rec.Value.(*bsky.GraphFollow)
+		assert.True(ok)
+		assert.NotEmpty(follow.Subject)
+	}
+
+	// OnRepoStart errors fail the job before anything is fetched
+	assert.NoError(store.EnqueueJob(ctx, "did:plc:bbb"))
+	j, err = store.GetJob(ctx, "did:plc:bbb")
+	assert.NoError(err)
+	state, err = bf.BackfillRepo(ctx, j)
+	assert.Error(err)
+	assert.Equal("failed (OnRepoStart hook)", state)
+	_, ok := hooks.done["did:plc:bbb"]
+	assert.False(ok)
+}
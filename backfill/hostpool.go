@@ -0,0 +1,214 @@
+package backfill
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// a job which has been claimed from the store, along with the resolved repo checkout endpoint
+type pendingJob struct {
+	job          Job
+	checkoutPath string
+}
+
+// concurrency and request rate state for a single PDS (or relay) host
+type hostBudget struct {
+	active  int
+	pending []*pendingJob
+
+	limiter      *rate.Limiter
+	blockedUntil time.Time
+}
+
+// Tracks per-host worker slots and adaptive sync request rate limits.
+//
+// Request rates start at the configured maximum for each host. Every HTTP 429 response halves the rate (down to a floor) and respects any Retry-After or RateLimit-Reset header; every successful request additively increases the rate back towards the maximum.
+type hostPool struct {
+	lk         sync.Mutex
+	hosts      map[string]*hostBudget
+	numPending int
+
+	// max concurrent backfills per host; zero means no per-host limit
+	maxPerHost int
+	maxRate    rate.Limit
+	minRate    rate.Limit
+}
+
+// longest time a host will be paused for because of a Retry-After header
+const maxHostBlock = 10 * time.Minute
+
+func newHostPool(maxPerHost int, maxRate, minRate float64) *hostPool {
+	p := &hostPool{
+		hosts:      make(map[string]*hostBudget),
+		maxPerHost: maxPerHost,
+		maxRate:    rate.Limit(maxRate),
+		minRate:    rate.Limit(minRate),
+	}
+	if maxRate <= 0 {
+		p.maxRate = rate.Inf
+	}
+	if p.minRate <= 0 {
+		p.minRate = 1
+	}
+	if p.minRate > p.maxRate {
+		p.minRate = p.maxRate
+	}
+	return p
+}
+
+// must hold lock
+func (p *hostPool) budget(host string) *hostBudget {
+	hb, ok := p.hosts[host]
+	if !ok {
+		hb = &hostBudget{
+			limiter: rate.NewLimiter(p.maxRate, 1),
+		}
+		p.hosts[host] = hb
+	}
+	return hb
+}
+
+// Claims a worker slot for the host. If the host is already at capacity, the job is queued behind the running ones and false is returned.
+func (p *hostPool) claim(host string, pj *pendingJob) bool {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	hb := p.budget(host)
+	if p.maxPerHost > 0 && hb.active >= p.maxPerHost {
+		hb.pending = append(hb.pending, pj)
+		p.numPending++
+		return false
+	}
+	hb.active++
+	return true
+}
+
+// Releases a worker slot for the host. If another job is queued for the same host, it is returned and takes over the slot.
+func (p *hostPool) release(host string) *pendingJob {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	hb, ok := p.hosts[host]
+	if !ok {
+		return nil
+	}
+	if len(hb.pending) > 0 {
+		next := hb.pending[0]
+		hb.pending = hb.pending[1:]
+		p.numPending--
+		return next
+	}
+	hb.active--
+	// forget idle hosts once they have fully recovered from any throttling
+	if hb.active <= 0 && hb.limiter.Limit() >= p.maxRate && time.Now().After(hb.blockedUntil) {
+		delete(p.hosts, host)
+	}
+	return nil
+}
+
+// Number of jobs waiting for a per-host worker slot.
+func (p *hostPool) pendingCount() int {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return p.numPending
+}
+
+// Removes and returns all queued jobs, for example at shutdown.
+func (p *hostPool) drainPending() []*pendingJob {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	var out []*pendingJob
+	for _, hb := range p.hosts {
+		out = append(out, hb.pending...)
+		hb.pending = nil
+	}
+	p.numPending = 0
+	return out
+}
+
+// Blocks until a sync request may be sent to the host, or the context is cancelled.
+func (p *hostPool) wait(ctx context.Context, host string) error {
+	p.lk.Lock()
+	hb := p.budget(host)
+	limiter := hb.limiter
+	delay := time.Until(hb.blockedUntil)
+	p.lk.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	return limiter.Wait(ctx)
+}
+
+// Adjusts the request rate for the host based on a sync response.
+func (p *hostPool) observe(host string, resp *http.Response) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	hb := p.budget(host)
+	cur := hb.limiter.Limit()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		next := cur / 2
+		if cur == rate.Inf {
+			next = p.minRate
+		}
+		if next < p.minRate {
+			next = p.minRate
+		}
+		hb.limiter.SetLimit(next)
+		now := time.Now()
+		until := retryAfter(resp.Header, now)
+		if until.After(now.Add(maxHostBlock)) {
+			until = now.Add(maxHostBlock)
+		}
+		if until.After(hb.blockedUntil) {
+			hb.blockedUntil = until
+		}
+		return
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 && cur < p.maxRate {
+		next := cur + (p.maxRate-p.minRate)/20
+		if next <= cur || next > p.maxRate {
+			next = p.maxRate
+		}
+		hb.limiter.SetLimit(next)
+	}
+}
+
+// Parses the time when requests may resume from a rate-limited response, using either the standard Retry-After header (seconds or HTTP date), or the RateLimit-Reset header (UNIX timestamp) sent by atproto PDS implementations. Returns the zero time if neither is present.
+func retryAfter(h http.Header, now time.Time) time.Time {
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return now.Add(time.Duration(secs) * time.Second)
+		}
+		if ts, err := http.ParseTime(v); err == nil {
+			return ts
+		}
+	}
+	if v := h.Get("RateLimit-Reset"); v != "" {
+		if epoch, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(epoch, 0)
+		}
+	}
+	return time.Time{}
+}
+
+// host portion of a repo checkout URL, used to group jobs for per-host budgets
+func checkoutHost(checkoutPath string) string {
+	u, err := url.Parse(checkoutPath)
+	if err != nil || u.Host == "" {
+		return checkoutPath
+	}
+	return u.Host
+}
@@ -0,0 +1,112 @@
+package backfill
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestHostPoolSlots(t *testing.T) {
+	assert := assert.New(t)
+
+	p := newHostPool(2, 10, 1)
+	a := &pendingJob{checkoutPath: "https://pds-a.example.com/xrpc/com.atproto.sync.getRepo"}
+	b := &pendingJob{checkoutPath: "https://pds-b.example.com/xrpc/com.atproto.sync.getRepo"}
+	host := checkoutHost(a.checkoutPath)
+	assert.Equal("pds-a.example.com", host)
+
+	assert.True(p.claim(host, a))
+	assert.True(p.claim(host, a))
+	// third job for the same host has to wait, but other hosts are unaffected
+	assert.False(p.claim(host, b))
+	assert.Equal(1, p.pendingCount())
+	assert.True(p.claim(checkoutHost(b.checkoutPath), b))
+
+	// finishing a job hands the slot to the queued job
+	assert.Equal(b, p.release(host))
+	assert.Equal(0, p.pendingCount())
+	assert.Nil(p.release(host))
+	assert.Nil(p.release(host))
+	_, ok := p.hosts[host]
+	assert.False(ok)
+
+	// queued jobs can be drained at shutdown
+	p.claim(host, a)
+	p.claim(host, a)
+	p.claim(host, b)
+	assert.Equal([]*pendingJob{b}, p.drainPending())
+	assert.Equal(0, p.pendingCount())
+}
+
+func TestHostPoolUnlimited(t *testing.T) {
+	assert := assert.New(t)
+
+	p := newHostPool(0, 0, 0)
+	for i := 0; i < 100; i++ {
+		assert.True(p.claim("relay.example.com", &pendingJob{}))
+	}
+	assert.Equal(rate.Inf, p.budget("relay.example.com").limiter.Limit())
+
+	// an unlimited host which starts rate-limiting drops to the floor rate
+	p.observe("relay.example.com", &http.Response{StatusCode: http.StatusTooManyRequests})
+	assert.Equal(rate.Limit(1), p.budget("relay.example.com").limiter.Limit())
+}
+
+func TestHostPoolAdaptiveRate(t *testing.T) {
+	assert := assert.New(t)
+
+	p := newHostPool(0, 16, 1)
+	host := "pds.example.com"
+	limit := func() rate.Limit { return p.budget(host).limiter.Limit() }
+	assert.Equal(rate.Limit(16), limit())
+
+	tooMany := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	p.observe(host, tooMany)
+	assert.Equal(rate.Limit(8), limit())
+	for i := 0; i < 10; i++ {
+		p.observe(host, tooMany)
+	}
+	assert.Equal(rate.Limit(1), limit())
+
+	// successes recover additively, up to the max
+	ok := &http.Response{StatusCode: http.StatusOK}
+	p.observe(host, ok)
+	assert.Equal(rate.Limit(1.75), limit())
+	for i := 0; i < 100; i++ {
+		p.observe(host, ok)
+	}
+	assert.Equal(rate.Limit(16), limit())
+
+	// other errors don't change the rate
+	p.observe(host, &http.Response{StatusCode: http.StatusBadRequest})
+	assert.Equal(rate.Limit(16), limit())
+
+	// Retry-After pauses the host
+	tooMany.Header.Set("Retry-After", "30")
+	p.observe(host, tooMany)
+	assert.WithinDuration(time.Now().Add(30*time.Second), p.budget(host).blockedUntil, 2*time.Second)
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	h := http.Header{}
+	assert.True(retryAfter(h, now).IsZero())
+
+	h.Set("RateLimit-Reset", "1704110460")
+	assert.Equal(now.Add(time.Minute), retryAfter(h, now).UTC())
+
+	h.Set("Retry-After", "120")
+	assert.Equal(now.Add(2*time.Minute), retryAfter(h, now))
+
+	h.Set("Retry-After", "Mon, 01 Jan 2024 12:05:00 GMT")
+	assert.Equal(now.Add(5*time.Minute), retryAfter(h, now).UTC())
+
+	h = http.Header{}
+	h.Set("Retry-After", "junk")
+	assert.True(retryAfter(h, now).IsZero())
+}
@@ -29,3 +29,43 @@ var backfillBytesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "backfill_bytes_processed_total",
 	Help: "The total number of backfill bytes processed",
 }, []string{"backfiller_name"})
+
+var backfillSyncRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "backfill_sync_rate_limited_total",
+	Help: "The total number of repo sync requests rejected by the remote host with HTTP 429",
+}, []string{"backfiller_name"})
+
+var backfillArchiveLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "backfill_archive_lookups_total",
+	Help: "The total number of repo lookups in the backfill snapshot archive, by result",
+}, []string{"backfiller_name", "result"})
+
+var backfillReconciledRepos = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "backfill_reconciled_repos_total",
+	Help: "The total number of repos checked against upstream during reconciliation, by result",
+}, []string{"backfiller_name", "result"})
+
+var backfillBufferedBytes = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "backfill_buffered_bytes",
+	Help: "Approximate size of firehose ops buffered in memory for repos being backfilled",
+})
+
+var backfillSpilledOpSets = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "backfill_spilled_op_sets_total",
+	Help: "The total number of buffered firehose events written to disk because of the buffer memory limit",
+})
+
+var backfillSpilledBytes = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "backfill_spilled_bytes_total",
+	Help: "Approximate total size of buffered firehose ops written to disk because of the buffer memory limit",
+})
+
+var backfillSpillFiles = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "backfill_spill_files",
+	Help: "The number of repos currently buffering firehose ops on disk",
+})
+
+var backfillReposImported = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "backfill_repos_imported_total",
+	Help: "The total number of repo CARs passed whole to a block-level sink (eg, carstore) instead of processed record by record",
+}, []string{"backfiller_name"})
@@ -0,0 +1,72 @@
+package backfill
+
+import (
+	"container/heap"
+	"context"
+	"math"
+)
+
+// Job priorities: higher priority jobs are started first. Any integer may be used; these are suggested levels.
+const (
+	// PriorityDefault is used for repos discovered by enumeration (eg, listRepos), which make up the long tail
+	PriorityDefault = 0
+	// PriorityActive is the default priority for repos first seen on the firehose, which are likely to be active accounts
+	PriorityActive = 5
+	// PriorityOperator is the default priority for repos explicitly prioritized by an operator
+	PriorityOperator = 100
+)
+
+// FollowerPriority maps a follower count to a job priority on a log scale: 1 for accounts with no followers, up to 10 for accounts with billions.
+func FollowerPriority(followers int64) int {
+	if followers < 1 {
+		return 1
+	}
+	return 1 + int(math.Log10(float64(followers)))
+}
+
+// PriorityStore is an optional extension of the Store interface for stores which order jobs by priority
+type PriorityStore interface {
+	// EnqueueJobWithPriority creates an enqueued job if none exists for the repo. If a job is already enqueued, its priority is raised to at least the given priority.
+	EnqueueJobWithPriority(ctx context.Context, repo string, priority int) error
+}
+
+type queueItem struct {
+	repo     string
+	priority int
+	// insertion order, so jobs of equal priority are FIFO
+	seq uint64
+}
+
+// in-memory queue of repos to process, highest priority first (implements container/heap)
+type jobQueue struct {
+	items []queueItem
+	seq   uint64
+}
+
+func (q *jobQueue) Len() int { return len(q.items) }
+
+func (q *jobQueue) Less(i, j int) bool {
+	if q.items[i].priority != q.items[j].priority {
+		return q.items[i].priority > q.items[j].priority
+	}
+	return q.items[i].seq < q.items[j].seq
+}
+
+func (q *jobQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *jobQueue) Push(x any) { q.items = append(q.items, x.(queueItem)) }
+
+func (q *jobQueue) Pop() any {
+	last := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return last
+}
+
+func (q *jobQueue) push(repo string, priority int) {
+	q.seq++
+	heap.Push(q, queueItem{repo: repo, priority: priority, seq: q.seq})
+}
+
+func (q *jobQueue) pop() string {
+	return heap.Pop(q).(queueItem).repo
+}
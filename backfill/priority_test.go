@@ -0,0 +1,78 @@
+package backfill
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobQueueOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	var q jobQueue
+	q.push("did:plc:a", PriorityDefault)
+	q.push("did:plc:b", PriorityOperator)
+	q.push("did:plc:c", PriorityDefault)
+	q.push("did:plc:d", PriorityActive)
+	q.push("did:plc:e", PriorityOperator)
+
+	var out []string
+	for q.Len() > 0 {
+		out = append(out, q.pop())
+	}
+	assert.Equal([]string{"did:plc:b", "did:plc:e", "did:plc:d", "did:plc:a", "did:plc:c"}, out)
+
+	assert.Equal(1, FollowerPriority(0))
+	assert.Equal(1, FollowerPriority(9))
+	assert.Equal(3, FollowerPriority(100))
+	assert.Equal(7, FollowerPriority(1_500_000))
+}
+
+func TestGormstorePriority(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	store := testGormstore(t)
+
+	assert.NoError(store.EnqueueJob(ctx, "did:plc:tail"))
+	assert.NoError(store.EnqueueJobWithPriority(ctx, "did:plc:popular", FollowerPriority(50_000)))
+	assert.NoError(store.EnqueueJobWithPriority(ctx, "did:plc:active", PriorityActive))
+	// priorities are only ever raised
+	assert.NoError(store.EnqueueJobWithPriority(ctx, "did:plc:active", PriorityDefault))
+
+	bf := NewBackfiller("test", store, nil, nil, nil, nil)
+	srv := httptest.NewServer(bf.AdminHandler())
+	defer srv.Close()
+	resp, err := http.Post(srv.URL+"/prioritize", "application/json", bytes.NewBufferString(`{"repos": ["did:plc:tail"]}`))
+	assert.NoError(err)
+	assert.Equal(200, resp.StatusCode)
+	resp, err = http.Post(srv.URL+"/prioritize", "application/json", bytes.NewBufferString(`{"repos": ["not-a-did"]}`))
+	assert.NoError(err)
+	assert.Equal(400, resp.StatusCode)
+
+	info, err := store.GetJobInfo(ctx, "did:plc:tail")
+	assert.NoError(err)
+	assert.Equal(PriorityOperator, info.Priority)
+
+	order := func(s *Gormstore) []string {
+		var out []string
+		for {
+			j, err := s.GetNextEnqueuedJob(ctx)
+			assert.NoError(err)
+			if j == nil {
+				return out
+			}
+			out = append(out, j.Repo())
+			assert.NoError(j.SetState(ctx, StateInProgress))
+		}
+	}
+	assert.Equal([]string{"did:plc:tail", "did:plc:popular", "did:plc:active"}, order(store))
+
+	// priorities are persisted, and respected when jobs are loaded from the database
+	store2 := NewGormstore(store.db)
+	assert.NoError(store2.LoadJobs(ctx))
+	assert.Equal([]string{"did:plc:tail", "did:plc:popular", "did:plc:active"}, order(store2))
+}
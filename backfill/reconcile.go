@@ -0,0 +1,166 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// ReconcileStore is an optional extension of the Store interface, required for reconciliation against an upstream host
+type ReconcileStore interface {
+	// GetRevs returns the current rev of each of the given repos which has a job. Repos without a job are omitted.
+	GetRevs(ctx context.Context, repos []string) (map[string]string, error)
+	// RetryJob re-enqueues an existing job, keeping its current rev
+	RetryJob(ctx context.Context, repo string) error
+}
+
+// ReconcileResult summarizes a reconciliation pass
+type ReconcileResult struct {
+	// Repos listed by the upstream host
+	Checked int `json:"checked"`
+	// Repos with no local job, which were enqueued for a full backfill
+	Missing int `json:"missing"`
+	// Repos whose local rev was behind upstream, which were re-enqueued to catch up
+	Behind int `json:"behind"`
+	// Repos which were up to date, already enqueued, in progress, or failed (and will be retried), or inactive upstream
+	Skipped  int           `json:"skipped"`
+	Duration time.Duration `json:"duration"`
+}
+
+// how many repos to request per listRepos page
+const reconcilePageSize = 1000
+
+// Reconcile compares locally-known repo revs against the upstream host's com.atproto.sync.listRepos output, and enqueues only repos which are missing or behind. This is much cheaper than a full re-backfill, and catches anything missed due to dropped events or downtime.
+//
+// Revs are TIDs, so they are compared lexicographically.
+func (b *Backfiller) Reconcile(ctx context.Context, client *xrpc.Client) (*ReconcileResult, error) {
+	store, ok := b.Store.(ReconcileStore)
+	if !ok {
+		return nil, fmt.Errorf("backfill store does not support reconciliation")
+	}
+	log := slog.With("source", "backfiller_reconcile", "name", b.Name, "host", client.Host)
+	start := time.Now()
+	res := ReconcileResult{}
+
+	cursor := ""
+	for {
+		page, err := comatproto.SyncListRepos(ctx, client, cursor, reconcilePageSize)
+		if err != nil {
+			return &res, fmt.Errorf("listing repos from upstream: %w", err)
+		}
+
+		dids := make([]string, 0, len(page.Repos))
+		for _, r := range page.Repos {
+			dids = append(dids, r.Did)
+		}
+		local, err := store.GetRevs(ctx, dids)
+		if err != nil {
+			return &res, fmt.Errorf("fetching local revs: %w", err)
+		}
+
+		for _, r := range page.Repos {
+			res.Checked++
+			if r.Active != nil && !*r.Active {
+				res.Skipped++
+				continue
+			}
+			result, err := b.reconcileRepo(ctx, store, r.Did, r.Rev, local)
+			if err != nil {
+				return &res, err
+			}
+			switch result {
+			case "missing":
+				res.Missing++
+			case "behind":
+				res.Behind++
+			default:
+				res.Skipped++
+			}
+			backfillReconciledRepos.WithLabelValues(b.Name, result).Inc()
+		}
+
+		if page.Cursor == nil || *page.Cursor == "" || len(page.Repos) == 0 {
+			break
+		}
+		cursor = *page.Cursor
+	}
+
+	res.Duration = time.Since(start)
+	log.Info("reconciliation complete", "checked", res.Checked, "missing", res.Missing, "behind", res.Behind, "duration", res.Duration)
+	return &res, nil
+}
+
+// ReconcileRepo checks a single repo against the upstream host's com.atproto.sync.getLatestCommit, and enqueues it if it is missing or behind. Returns true if the repo was enqueued.
+func (b *Backfiller) ReconcileRepo(ctx context.Context, client *xrpc.Client, did string) (bool, error) {
+	store, ok := b.Store.(ReconcileStore)
+	if !ok {
+		return false, fmt.Errorf("backfill store does not support reconciliation")
+	}
+	latest, err := comatproto.SyncGetLatestCommit(ctx, client, did)
+	if err != nil {
+		return false, fmt.Errorf("fetching latest commit from upstream: %w", err)
+	}
+	local, err := store.GetRevs(ctx, []string{did})
+	if err != nil {
+		return false, fmt.Errorf("fetching local rev: %w", err)
+	}
+	result, err := b.reconcileRepo(ctx, store, did, latest.Rev, local)
+	if err != nil {
+		return false, err
+	}
+	backfillReconciledRepos.WithLabelValues(b.Name, result).Inc()
+	return result == "missing" || result == "behind", nil
+}
+
+// returns "missing", "behind", "current", or "busy"
+func (b *Backfiller) reconcileRepo(ctx context.Context, store ReconcileStore, did, upstreamRev string, local map[string]string) (string, error) {
+	rev, ok := local[did]
+	if !ok {
+		if err := b.Store.EnqueueJob(ctx, did); err != nil {
+			return "", fmt.Errorf("enqueueing missing repo %s: %w", did, err)
+		}
+		return "missing", nil
+	}
+	if rev >= upstreamRev {
+		return "current", nil
+	}
+
+	job, err := b.Store.GetJob(ctx, did)
+	if err != nil {
+		if errors.Is(err, ErrJobNotFound) {
+			// purged since we fetched revs
+			return "current", nil
+		}
+		return "", err
+	}
+	// jobs which are already queued or running will catch up on their own, and failed jobs are retried with backoff
+	if job.State() != StateComplete {
+		return "busy", nil
+	}
+	if err := store.RetryJob(ctx, did); err != nil {
+		return "", fmt.Errorf("re-enqueueing repo %s: %w", did, err)
+	}
+	return "behind", nil
+}
+
+// RunPeriodicReconcile runs Reconcile on a fixed interval until the context is cancelled. Errors are logged.
+func (b *Backfiller) RunPeriodicReconcile(ctx context.Context, client *xrpc.Client, interval time.Duration) {
+	log := slog.With("source", "backfiller_reconcile", "name", b.Name)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := b.Reconcile(ctx, client); err != nil {
+				log.Error("reconciliation failed", "error", err)
+			}
+		}
+	}
+}
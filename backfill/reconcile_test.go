@@ -0,0 +1,95 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcile(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	inactive := false
+	upstream := []*comatproto.SyncListRepos_Repo{
+		{Did: "did:plc:current", Rev: "3kaaa"},
+		{Did: "did:plc:behind", Rev: "3kccc"},
+		{Did: "did:plc:missing", Rev: "3kaaa"},
+		{Did: "did:plc:running", Rev: "3kccc"},
+		{Did: "did:plc:gone", Rev: "3kccc", Active: &inactive},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/xrpc/com.atproto.sync.listRepos":
+			// two pages
+			out := comatproto.SyncListRepos_Output{}
+			if r.URL.Query().Get("cursor") == "" {
+				next := "page2"
+				out.Repos = upstream[:3]
+				out.Cursor = &next
+			} else {
+				out.Repos = upstream[3:]
+			}
+			json.NewEncoder(w).Encode(out)
+		case "/xrpc/com.atproto.sync.getLatestCommit":
+			json.NewEncoder(w).Encode(comatproto.SyncGetLatestCommit_Output{Cid: "bafyfake", Rev: "3kddd"})
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+	client := &xrpc.Client{Host: srv.URL}
+
+	store := testGormstore(t)
+	bf := NewBackfiller("test", store, nil, nil, nil, nil)
+	setup := func(did, rev, state string) {
+		assert.NoError(store.EnqueueJob(ctx, did))
+		j, err := store.GetJob(ctx, did)
+		assert.NoError(err)
+		assert.NoError(j.SetRev(ctx, rev))
+		assert.NoError(j.SetState(ctx, state))
+	}
+	setup("did:plc:current", "3kaaa", StateComplete)
+	setup("did:plc:behind", "3kbbb", StateComplete)
+	setup("did:plc:running", "3kbbb", StateInProgress)
+
+	res, err := bf.Reconcile(ctx, client)
+	assert.NoError(err)
+	assert.Equal(5, res.Checked)
+	assert.Equal(1, res.Missing)
+	assert.Equal(1, res.Behind)
+	assert.Equal(3, res.Skipped)
+
+	state := func(did string) string {
+		info, err := store.GetJobInfo(ctx, did)
+		if err != nil {
+			return err.Error()
+		}
+		return info.State
+	}
+	assert.Equal(StateComplete, state("did:plc:current"))
+	assert.Equal(StateEnqueued, state("did:plc:behind"))
+	assert.Equal(StateEnqueued, state("did:plc:missing"))
+	assert.Equal(StateInProgress, state("did:plc:running"))
+	assert.Equal(ErrJobNotFound.Error(), state("did:plc:gone"))
+
+	// catch-up jobs keep their rev, so only the diff is fetched
+	info, err := store.GetJobInfo(ctx, "did:plc:behind")
+	assert.NoError(err)
+	assert.Equal("3kbbb", info.Rev)
+
+	// single-repo check
+	enqueued, err := bf.ReconcileRepo(ctx, client, "did:plc:current")
+	assert.NoError(err)
+	assert.True(enqueued)
+	assert.Equal(StateEnqueued, state("did:plc:current"))
+	enqueued, err = bf.ReconcileRepo(ctx, client, "did:plc:running")
+	assert.NoError(err)
+	assert.False(enqueued)
+}
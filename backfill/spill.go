@@ -0,0 +1,104 @@
+package backfill
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// Shared accounting for ops buffered in memory by all jobs in a store. When buffering another op set would exceed the memory ceiling, the buffering job moves its buffer to a spill file on disk.
+type bufferLimits struct {
+	// zero means no limit
+	maxBytes int64
+	dir      string
+	used     atomic.Int64
+}
+
+func (l *bufferLimits) overLimit(size int64) bool {
+	return l != nil && l.maxBytes > 0 && l.used.Load()+size > l.maxBytes
+}
+
+func (l *bufferLimits) add(size int64) {
+	if l == nil {
+		return
+	}
+	l.used.Add(size)
+	backfillBufferedBytes.Add(float64(size))
+}
+
+// approximate memory footprint of a buffered op set
+func opSetSize(ops *opSet) int64 {
+	size := int64(64 + len(ops.rev))
+	for _, op := range ops.ops {
+		size += int64(64 + len(op.Path))
+		if op.Record != nil {
+			size += int64(len(*op.Record))
+		}
+	}
+	return size
+}
+
+// on-disk encoding of an opSet
+type spilledOpSet struct {
+	Since *string
+	Rev   string
+	Ops   []*BufferedOp
+}
+
+// append-only file of op sets for a single job
+type spillFile struct {
+	f     *os.File
+	enc   *gob.Encoder
+	bytes int64
+}
+
+func newSpillFile(dir string) (*spillFile, error) {
+	f, err := os.CreateTemp(dir, "backfill-*.spill")
+	if err != nil {
+		return nil, fmt.Errorf("creating backfill spill file: %w", err)
+	}
+	backfillSpillFiles.Inc()
+	return &spillFile{f: f, enc: gob.NewEncoder(f)}, nil
+}
+
+func (sf *spillFile) write(ops *opSet) error {
+	if err := sf.enc.Encode(spilledOpSet{Since: ops.since, Rev: ops.rev, Ops: ops.ops}); err != nil {
+		return fmt.Errorf("writing to backfill spill file: %w", err)
+	}
+	size := opSetSize(ops)
+	sf.bytes += size
+	backfillSpilledOpSets.Inc()
+	backfillSpilledBytes.Add(float64(size))
+	return nil
+}
+
+// calls the function for every op set in the file, in order
+func (sf *spillFile) forEach(fn func(ops *opSet) error) error {
+	rf, err := os.Open(sf.f.Name())
+	if err != nil {
+		return fmt.Errorf("opening backfill spill file: %w", err)
+	}
+	defer rf.Close()
+	dec := gob.NewDecoder(rf)
+	for {
+		var s spilledOpSet
+		if err := dec.Decode(&s); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("reading backfill spill file: %w", err)
+		}
+		if err := fn(&opSet{since: s.Since, rev: s.Rev, ops: s.Ops}); err != nil {
+			return err
+		}
+	}
+}
+
+func (sf *spillFile) remove() {
+	sf.f.Close()
+	os.Remove(sf.f.Name())
+	backfillSpillFiles.Dec()
+}
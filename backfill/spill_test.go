@@ -0,0 +1,82 @@
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/bluesky-social/indigo/repomgr"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferSpill(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	spillDir := t.TempDir()
+	store := testGormstore(t)
+	store.SetBufferLimits(4096, spillDir)
+
+	assert.NoError(store.EnqueueJob(ctx, "did:plc:hot"))
+	j, err := store.GetJob(ctx, "did:plc:hot")
+	assert.NoError(err)
+	assert.NoError(j.SetState(ctx, StateInProgress))
+	gj := j.(*Gormjob)
+
+	rec := make([]byte, 1000)
+	c, err := cid.Decode("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")
+	assert.NoError(err)
+
+	// first event for a new repo has no since
+	revs := []string{"3k000"}
+	assert.NoError(gj.bufferOps(&opSet{rev: "3k000", ops: []*BufferedOp{{Kind: repomgr.EvtKindCreateRecord, Path: "app.bsky.feed.post/000", Record: &rec, Cid: &c}}}))
+	for i := 1; i < 10; i++ {
+		since := revs[len(revs)-1]
+		rev := fmt.Sprintf("3k%03d", i)
+		revs = append(revs, rev)
+		buffered, err := j.BufferOps(ctx, &since, rev, []*BufferedOp{
+			{Kind: repomgr.EvtKindCreateRecord, Path: "app.bsky.feed.post/" + rev, Record: &rec, Cid: &c},
+			{Kind: repomgr.EvtKindDeleteRecord, Path: "app.bsky.feed.like/" + rev},
+		})
+		assert.NoError(err)
+		assert.True(buffered)
+	}
+
+	// buffer moved to disk once the ceiling was reached
+	assert.NotNil(gj.spill)
+	assert.Empty(gj.bufferedOps)
+	assert.Equal(int64(0), store.limits.used.Load())
+	files, _ := os.ReadDir(spillDir)
+	assert.Equal(1, len(files))
+
+	var seen []string
+	err = j.FlushBufferedOps(ctx, func(kind repomgr.EventKind, rev, path string, r *[]byte, cc *cid.Cid) error {
+		seen = append(seen, rev+" "+string(kind))
+		if kind == repomgr.EvtKindCreateRecord {
+			assert.Equal(1000, len(*r))
+			assert.Equal(c, *cc)
+		}
+		return nil
+	})
+	assert.NoError(err)
+	assert.Equal(19, len(seen))
+	assert.Equal("3k000 create", seen[0])
+	assert.Equal("3k009 delete", seen[18])
+	assert.Equal("3k009", j.Rev())
+	assert.Nil(gj.spill)
+	files, _ = os.ReadDir(spillDir)
+	assert.Equal(0, len(files))
+
+	// small buffers stay in memory, and are released on clear
+	assert.NoError(store.EnqueueJob(ctx, "did:plc:cold"))
+	j2, _ := store.GetJob(ctx, "did:plc:cold")
+	since := "3k000"
+	_, err = j2.BufferOps(ctx, &since, "3k001", []*BufferedOp{{Kind: repomgr.EvtKindDeleteRecord, Path: "app.bsky.feed.like/abc"}})
+	assert.NoError(err)
+	assert.Nil(j2.(*Gormjob).spill)
+	assert.True(store.limits.used.Load() > 0)
+	assert.NoError(j2.ClearBufferedOps(ctx))
+	assert.Equal(int64(0), store.limits.used.Load())
+}
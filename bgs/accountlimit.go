@@ -0,0 +1,159 @@
+package bgs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/time/rate"
+)
+
+// What happens to an account's commits over its rate limit
+const (
+	// Commits are delayed until the account is back under its limit
+	AccountLimitThrottle = "throttle"
+	// Commits are processed as usual, and the account only recorded as over its limit, for monitoring limits before enforcing them
+	AccountLimitMark = "mark"
+)
+
+// AccountRateLimitConfig limits how fast a single account may commit, so one pathological account can't flood downstream consumers. Accounts over the limit are reported by RateLimitedAccounts.
+type AccountRateLimitConfig struct {
+	// Sustained commits per minute accepted for a single account. Zero disables the limit
+	CommitsPerMinute float64
+	// Commits an account may make in a burst over the sustained rate
+	Burst int
+	// AccountLimitThrottle or AccountLimitMark
+	Action string
+}
+
+func (c *AccountRateLimitConfig) enabled() bool {
+	return c.CommitsPerMinute > 0
+}
+
+func (c *AccountRateLimitConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	switch c.Action {
+	case AccountLimitThrottle, AccountLimitMark:
+		return nil
+	}
+	return fmt.Errorf("invalid account rate limit action %q", c.Action)
+}
+
+const (
+	accountLimitCacheSize   = 100_000
+	rateLimitedAccountsSize = 10_000
+)
+
+type accountLimit struct {
+	did string
+	lim *rate.Limiter
+
+	exceeded     uint64
+	lastExceeded time.Time
+}
+
+type accountLimiter struct {
+	cfg AccountRateLimitConfig
+
+	lk       sync.Mutex
+	accounts *lru.Cache[models.Uid, *accountLimit]
+	// accounts which have gone over their limit, kept apart so they aren't evicted by ordinary accounts
+	limited *lru.Cache[models.Uid, *accountLimit]
+}
+
+func newAccountLimiter(cfg AccountRateLimitConfig) *accountLimiter {
+	accounts, _ := lru.New[models.Uid, *accountLimit](accountLimitCacheSize)
+	limited, _ := lru.New[models.Uid, *accountLimit](rateLimitedAccountsSize)
+	return &accountLimiter{
+		cfg:      cfg,
+		accounts: accounts,
+		limited:  limited,
+	}
+}
+
+// waitCommit records a commit for an account, blocking until it may be processed if the account is throttled
+func (l *accountLimiter) waitCommit(ctx context.Context, u *User) error {
+	if l == nil {
+		return nil
+	}
+
+	l.lk.Lock()
+	al, ok := l.limited.Get(u.ID)
+	if !ok {
+		al, ok = l.accounts.Get(u.ID)
+	}
+	if !ok {
+		al = &accountLimit{
+			did: u.Did,
+			lim: rate.NewLimiter(rate.Limit(l.cfg.CommitsPerMinute/60), max(1, l.cfg.Burst)),
+		}
+		l.accounts.Add(u.ID, al)
+	}
+
+	if al.lim.Allow() {
+		l.lk.Unlock()
+		return nil
+	}
+
+	al.exceeded++
+	al.lastExceeded = time.Now()
+	if al.exceeded == 1 {
+		log.Warn("account is over its commit rate limit", "did", u.Did, "action", l.cfg.Action)
+	}
+	l.accounts.Remove(u.ID)
+	l.limited.Add(u.ID, al)
+	l.lk.Unlock()
+
+	accountCommitsOverLimit.WithLabelValues(l.cfg.Action).Inc()
+
+	if l.cfg.Action != AccountLimitThrottle {
+		return nil
+	}
+	return al.lim.Wait(ctx)
+}
+
+// RateLimitedAccount is an account which has gone over its commit rate limit since startup
+type RateLimitedAccount struct {
+	Uid models.Uid `json:"uid"`
+	Did string     `json:"did"`
+	// Commits over the limit, which were delayed or only marked depending on the configured action
+	Exceeded     uint64    `json:"exceeded"`
+	LastExceeded time.Time `json:"last_exceeded"`
+}
+
+// RateLimitedAccounts returns the accounts which have recently gone over their commit rate limit, most recent first
+func (bgs *BGS) RateLimitedAccounts() []RateLimitedAccount {
+	l := bgs.accountLimits
+	if l == nil {
+		return []RateLimitedAccount{}
+	}
+
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	out := make([]RateLimitedAccount, 0, l.limited.Len())
+	for _, uid := range l.limited.Keys() {
+		al, ok := l.limited.Peek(uid)
+		if !ok {
+			continue
+		}
+		out = append(out, RateLimitedAccount{
+			Uid:          uid,
+			Did:          al.did,
+			Exceeded:     al.exceeded,
+			LastExceeded: al.lastExceeded,
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].LastExceeded.After(out[j].LastExceeded)
+	})
+	return out
+}
@@ -11,6 +11,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/indexer"
 	"github.com/bluesky-social/indigo/models"
 	"github.com/labstack/echo/v4"
 	dto "github.com/prometheus/client_model/go"
@@ -64,22 +68,91 @@ func (bgs *BGS) handleAdminSetNewPDSPerDayRateLimit(e echo.Context) error {
 	return nil
 }
 
-func (bgs *BGS) handleAdminTakeDownRepo(e echo.Context) error {
-	ctx := e.Request().Context()
+type adminActionBody struct {
+	Did    string `json:"did"`
+	Host   string `json:"host"`
+	Reason string `json:"reason"`
+	// Who is performing the action, for the audit log
+	Actor string `json:"actor"`
+}
 
-	var body map[string]string
+// bindAdminActionBody reads an admin action from the request body, falling back to query params (as used by the older reverseTakedown endpoint)
+func bindAdminActionBody(e echo.Context) (*adminActionBody, error) {
+	var body adminActionBody
 	if err := e.Bind(&body); err != nil {
+		return nil, err
+	}
+	if body.Did == "" {
+		body.Did = e.QueryParam("did")
+	}
+	if body.Host == "" {
+		body.Host = strings.TrimSpace(e.QueryParam("host"))
+	}
+	if body.Reason == "" {
+		body.Reason = e.QueryParam("reason")
+	}
+	if body.Actor == "" {
+		body.Actor = e.QueryParam("actor")
+	}
+	return &body, nil
+}
+
+// Returns a handler which applies an action to an account, and records it in the audit log
+func (bgs *BGS) handleAdminAccountAction(action string, do func(ctx context.Context, did string) error) echo.HandlerFunc {
+	return func(e echo.Context) error {
+		ctx := e.Request().Context()
+
+		body, err := bindAdminActionBody(e)
+		if err != nil {
+			return err
+		}
+		if body.Did == "" {
+			return &echo.HTTPError{
+				Code:    400,
+				Message: "must specify did parameter in body",
+			}
+		}
+
+		if err := do(ctx, body.Did); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &echo.HTTPError{
+					Code:    http.StatusNotFound,
+					Message: "repo not found",
+				}
+			}
+			return &echo.HTTPError{
+				Code:    http.StatusInternalServerError,
+				Message: err.Error(),
+			}
+		}
+
+		return bgs.recordAdminAction(ctx, &AdminAction{
+			Actor:       body.Actor,
+			RemoteAddr:  e.RealIP(),
+			Action:      action,
+			SubjectType: AdminSubjectAccount,
+			Subject:     body.Did,
+			Reason:      body.Reason,
+		})
+	}
+}
+
+// The purge is recorded in the audit log (by DID only) as the record of the deletion request having been carried out
+func (bgs *BGS) handleAdminPurgeAccount(e echo.Context) error {
+	ctx := e.Request().Context()
+
+	body, err := bindAdminActionBody(e)
+	if err != nil {
 		return err
 	}
-	did, ok := body["did"]
-	if !ok {
+	if body.Did == "" {
 		return &echo.HTTPError{
 			Code:    400,
 			Message: "must specify did parameter in body",
 		}
 	}
 
-	err := bgs.TakeDownRepo(ctx, did)
+	report, err := bgs.PurgeAccount(ctx, body.Did)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return &echo.HTTPError{
@@ -87,33 +160,159 @@ func (bgs *BGS) handleAdminTakeDownRepo(e echo.Context) error {
 				Message: "repo not found",
 			}
 		}
-		return &echo.HTTPError{
-			Code:    http.StatusInternalServerError,
-			Message: err.Error(),
+		log.Error("account purge failed", "did", body.Did, "err", err, "report", report)
+		return e.JSON(http.StatusInternalServerError, map[string]any{
+			"error":  err.Error(),
+			"report": report,
+		})
+	}
+
+	if err := bgs.recordAdminAction(ctx, &AdminAction{
+		Actor:       body.Actor,
+		RemoteAddr:  e.RealIP(),
+		Action:      AdminActionPurge,
+		SubjectType: AdminSubjectAccount,
+		Subject:     body.Did,
+		Reason:      body.Reason,
+	}); err != nil {
+		return err
+	}
+
+	return e.JSON(200, report)
+}
+
+// Returns a handler which applies an action to a PDS host, and records it in the audit log
+func (bgs *BGS) handleAdminHostAction(action string, do func(ctx context.Context, host string) (int, error)) echo.HandlerFunc {
+	return func(e echo.Context) error {
+		ctx := e.Request().Context()
+
+		body, err := bindAdminActionBody(e)
+		if err != nil {
+			return err
+		}
+		if body.Host == "" {
+			return &echo.HTTPError{
+				Code:    400,
+				Message: "must pass a valid host",
+			}
+		}
+
+		affected, err := do(ctx, body.Host)
+		// a partially-applied host takedown is still recorded
+		if affected > 0 || err == nil {
+			if aerr := bgs.recordAdminAction(ctx, &AdminAction{
+				Actor:            body.Actor,
+				RemoteAddr:       e.RealIP(),
+				Action:           action,
+				SubjectType:      AdminSubjectHost,
+				Subject:          body.Host,
+				Reason:           body.Reason,
+				AccountsAffected: affected,
+			}); aerr != nil {
+				return aerr
+			}
+		}
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &echo.HTTPError{
+					Code:    http.StatusNotFound,
+					Message: "host not found",
+				}
+			}
+			return &echo.HTTPError{
+				Code:    http.StatusInternalServerError,
+				Message: err.Error(),
+			}
 		}
+
+		return e.JSON(200, map[string]any{
+			"success":          "true",
+			"accountsAffected": affected,
+		})
 	}
-	return nil
 }
 
-func (bgs *BGS) handleAdminReverseTakedown(e echo.Context) error {
-	did := e.QueryParam("did")
-	ctx := e.Request().Context()
-	err := bgs.ReverseTakedown(ctx, did)
+func (bgs *BGS) handleAdminListActions(e echo.Context) error {
+	limit := 100
+	if l := e.QueryParam("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 1000 {
+			return &echo.HTTPError{
+				Code:    400,
+				Message: "limit must be between 1 and 1000",
+			}
+		}
+		limit = n
+	}
+	var cursor uint64
+	if c := e.QueryParam("cursor"); c != "" {
+		n, err := strconv.ParseUint(c, 10, 64)
+		if err != nil {
+			return &echo.HTTPError{
+				Code:    400,
+				Message: "invalid cursor",
+			}
+		}
+		cursor = n
+	}
 
+	actions, err := bgs.ListAdminActions(e.Request().Context(), e.QueryParam("subject"), uint(cursor), limit)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	out := map[string]any{
+		"actions": actions,
+	}
+	if len(actions) == limit {
+		out["cursor"] = strconv.FormatUint(uint64(actions[len(actions)-1].ID), 10)
+	}
+	return e.JSON(200, out)
+}
+
+func (bgs *BGS) handleAdminIdentityHistory(e echo.Context) error {
+	did := e.QueryParam("did")
+	if _, err := syntax.ParseDID(did); err != nil {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: fmt.Sprintf("invalid did: %s", did),
+		}
+	}
+	limit := 100
+	if l := e.QueryParam("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 1000 {
 			return &echo.HTTPError{
-				Code:    http.StatusNotFound,
-				Message: "repo not found",
+				Code:    400,
+				Message: "limit must be between 1 and 1000",
 			}
 		}
-		return &echo.HTTPError{
-			Code:    http.StatusInternalServerError,
-			Message: err.Error(),
+		limit = n
+	}
+	var cursor uint64
+	if c := e.QueryParam("cursor"); c != "" {
+		n, err := strconv.ParseUint(c, 10, 64)
+		if err != nil {
+			return &echo.HTTPError{
+				Code:    400,
+				Message: "invalid cursor",
+			}
 		}
+		cursor = n
 	}
 
-	return nil
+	changes, err := bgs.ListIdentityChanges(e.Request().Context(), did, uint(cursor), limit)
+	if err != nil {
+		return err
+	}
+
+	out := map[string]any{
+		"changes": changes,
+	}
+	if len(changes) == limit {
+		out["cursor"] = strconv.FormatUint(uint64(changes[len(changes)-1].ID), 10)
+	}
+	return e.JSON(200, out)
 }
 
 func (bgs *BGS) handleAdminGetUpstreamConns(e echo.Context) error {
@@ -224,6 +423,10 @@ func (bgs *BGS) handleAdminListConsumers(e echo.Context) error {
 	return e.JSON(200, consumers)
 }
 
+func (bgs *BGS) handleAdminListRateLimitedAccounts(e echo.Context) error {
+	return e.JSON(200, bgs.RateLimitedAccounts())
+}
+
 func (bgs *BGS) handleAdminKillUpstreamConn(e echo.Context) error {
 	host := strings.TrimSpace(e.QueryParam("host"))
 	if host == "" {
@@ -288,6 +491,150 @@ func (bgs *BGS) handleUnblockPDS(e echo.Context) error {
 	})
 }
 
+func (bgs *BGS) handleAdminListHosts(e echo.Context) error {
+	limit := 100
+	if l := e.QueryParam("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 1000 {
+			return &echo.HTTPError{
+				Code:    400,
+				Message: "limit must be between 1 and 1000",
+			}
+		}
+		limit = n
+	}
+	var cursor uint64
+	if c := e.QueryParam("cursor"); c != "" {
+		n, err := strconv.ParseUint(c, 10, 64)
+		if err != nil {
+			return &echo.HTTPError{
+				Code:    400,
+				Message: "invalid cursor",
+			}
+		}
+		cursor = n
+	}
+
+	hosts, err := bgs.ListHosts(e.Request().Context(), uint(cursor), limit)
+	if err != nil {
+		return err
+	}
+
+	out := map[string]any{
+		"hosts": hosts,
+	}
+	if len(hosts) == limit {
+		out["cursor"] = strconv.FormatUint(uint64(hosts[len(hosts)-1].ID), 10)
+	}
+	return e.JSON(200, out)
+}
+
+func (bgs *BGS) handleAdminGetHost(e echo.Context) error {
+	host := strings.TrimSpace(e.QueryParam("host"))
+	if host == "" {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: "must pass a valid host",
+		}
+	}
+
+	hi, err := bgs.GetHost(e.Request().Context(), host)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &echo.HTTPError{
+				Code:    http.StatusNotFound,
+				Message: "host not found",
+			}
+		}
+		return err
+	}
+
+	return e.JSON(200, hi)
+}
+
+func (bgs *BGS) handlePauseHost(e echo.Context) error {
+	host := strings.TrimSpace(e.QueryParam("host"))
+	if host == "" {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: "must pass a valid host",
+		}
+	}
+
+	if err := bgs.PauseHost(e.Request().Context(), host); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &echo.HTTPError{
+				Code:    http.StatusNotFound,
+				Message: "host not found",
+			}
+		}
+		return err
+	}
+
+	return e.JSON(200, map[string]any{
+		"success": "true",
+	})
+}
+
+func (bgs *BGS) handleResumeHost(e echo.Context) error {
+	host := strings.TrimSpace(e.QueryParam("host"))
+	if host == "" {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: "must pass a valid host",
+		}
+	}
+
+	if err := bgs.ResumeHost(e.Request().Context(), host); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &echo.HTTPError{
+				Code:    http.StatusNotFound,
+				Message: "host not found",
+			}
+		}
+		return &echo.HTTPError{
+			Code:    400,
+			Message: err.Error(),
+		}
+	}
+
+	return e.JSON(200, map[string]any{
+		"success": "true",
+	})
+}
+
+func (bgs *BGS) handleAdminSetHostTrust(e echo.Context) error {
+	host := strings.TrimSpace(e.QueryParam("host"))
+	if host == "" {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: "must pass a valid host",
+		}
+	}
+
+	trust := e.QueryParam("trust")
+	if trust != "" && !validHostTrust(trust) {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: fmt.Sprintf("trust must be one of %q, %q, or %q", HostTrustVerified, HostTrustDefault, HostTrustUntrusted),
+		}
+	}
+
+	if err := bgs.SetHostTrust(e.Request().Context(), host, trust); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &echo.HTTPError{
+				Code:    http.StatusNotFound,
+				Message: "host not found",
+			}
+		}
+		return err
+	}
+
+	return e.JSON(200, map[string]any{
+		"success": "true",
+	})
+}
+
 type bannedDomains struct {
 	BannedDomains []string `json:"banned_domains"`
 }
@@ -318,6 +665,15 @@ func (bgs *BGS) handleAdminBanDomain(c echo.Context) error {
 		return err
 	}
 
+	domain, err := normalizeDomainPattern(body.Domain)
+	if err != nil {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: err.Error(),
+		}
+	}
+	body.Domain = domain
+
 	// Check if the domain is already banned
 	var existing models.DomainBan
 	if err := bgs.db.Where("domain = ?", body.Domain).First(&existing).Error; err == nil {
@@ -333,6 +689,20 @@ func (bgs *BGS) handleAdminBanDomain(c echo.Context) error {
 		return err
 	}
 
+	// Drop any existing connections to hosts the ban covers
+	for _, host := range bgs.slurper.GetActiveList() {
+		banned, err := bgs.domainIsBanned(c.Request().Context(), host)
+		if err != nil {
+			return err
+		}
+		if !banned {
+			continue
+		}
+		if err := bgs.slurper.KillUpstreamConnection(host, false); err != nil && !errors.Is(err, ErrNoActiveConnection) {
+			return err
+		}
+	}
+
 	return c.JSON(200, map[string]any{
 		"success": "true",
 	})
@@ -344,7 +714,15 @@ func (bgs *BGS) handleAdminUnbanDomain(c echo.Context) error {
 		return err
 	}
 
-	if err := bgs.db.Where("domain = ?", body.Domain).Delete(&models.DomainBan{}).Error; err != nil {
+	domain, err := normalizeDomainPattern(body.Domain)
+	if err != nil {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: err.Error(),
+		}
+	}
+
+	if err := bgs.db.Where("domain = ?", domain).Delete(&models.DomainBan{}).Error; err != nil {
 		return err
 	}
 
@@ -390,6 +768,9 @@ func (bgs *BGS) handleAdminChangePDSRateLimits(e echo.Context) error {
 	limits.PerSecond.SetLimit(body.PerSecond)
 	limits.PerHour.SetLimit(body.PerHour)
 	limits.PerDay.SetLimit(body.PerDay)
+	if al := bgs.slurper.GetAdaptiveLimiters(pds.ID); al != nil {
+		al.Events.SetLimit(rate.Limit(body.PerSecond))
+	}
 
 	// Set the crawl rate limit
 	bgs.repoFetcher.GetOrCreateLimiter(pds.ID, float64(body.CrawlRate)).SetLimit(rate.Limit(body.CrawlRate))
@@ -399,6 +780,88 @@ func (bgs *BGS) handleAdminChangePDSRateLimits(e echo.Context) error {
 	})
 }
 
+type adaptiveLimitsInfo struct {
+	Host   string                     `json:"host"`
+	Crawl  *indexer.HostLimiterStatus `json:"crawl,omitempty"`
+	Dial   *indexer.HostLimiterStatus `json:"dial,omitempty"`
+	Events *indexer.HostLimiterStatus `json:"events,omitempty"`
+}
+
+// Returns the current state of the adaptive crawl, dial, and event limiters for each host (or only the one passed in the "host" query param)
+func (bgs *BGS) handleAdminGetAdaptiveLimits(e echo.Context) error {
+	q := bgs.db.Model(&models.PDS{})
+	if host := e.QueryParam("host"); host != "" {
+		q = q.Where("host = ?", host)
+	}
+	var pdses []models.PDS
+	if err := q.Find(&pdses).Error; err != nil {
+		return err
+	}
+
+	out := []adaptiveLimitsInfo{}
+	for _, pds := range pdses {
+		info := adaptiveLimitsInfo{Host: pds.Host}
+		if lim := bgs.repoFetcher.GetLimiter(pds.ID); lim != nil {
+			st := lim.Status()
+			info.Crawl = &st
+		}
+		if al := bgs.slurper.GetAdaptiveLimiters(pds.ID); al != nil {
+			dial, events := al.Dial.Status(), al.Events.Status()
+			info.Dial = &dial
+			info.Events = &events
+		}
+		out = append(out, info)
+	}
+
+	return e.JSON(200, out)
+}
+
+type AdaptiveLimitOverrideRequest struct {
+	Host string `json:"host"`
+	// One of "crawl", "dial", or "events"
+	Kind string `json:"kind"`
+	// Rate to pin the limiter at, per second. If unset, any override is cleared and the limiter resumes adapting from its base rate
+	Limit *float64 `json:"limit"`
+}
+
+func (bgs *BGS) handleAdminOverrideAdaptiveLimit(e echo.Context) error {
+	var body AdaptiveLimitOverrideRequest
+	if err := e.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid body: %s", err))
+	}
+
+	var pds models.PDS
+	if err := bgs.db.Where("host = ?", body.Host).First(&pds).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "unknown host")
+		}
+		return err
+	}
+
+	var lim *indexer.HostLimiter
+	switch body.Kind {
+	case "crawl":
+		lim = bgs.repoFetcher.GetOrCreateLimiter(pds.ID, pds.CrawlRateLimit)
+	case "dial":
+		lim = bgs.slurper.GetOrCreateAdaptiveLimiters(pds.ID, int64(pds.RateLimit)).Dial
+	case "events":
+		lim = bgs.slurper.GetOrCreateAdaptiveLimiters(pds.ID, int64(pds.RateLimit)).Events
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "kind must be one of: crawl, dial, events")
+	}
+
+	if body.Limit == nil {
+		lim.ClearOverride()
+	} else {
+		if *body.Limit < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must not be negative")
+		}
+		lim.SetOverride(rate.Limit(*body.Limit))
+	}
+
+	return e.JSON(200, lim.Status())
+}
+
 func (bgs *BGS) handleAdminCompactRepo(e echo.Context) error {
 	ctx, span := otel.Tracer("bgs").Start(context.Background(), "adminCompactRepo")
 	defer span.End()
@@ -418,8 +881,24 @@ func (bgs *BGS) handleAdminCompactRepo(e echo.Context) error {
 		return fmt.Errorf("no such user: %w", err)
 	}
 
-	stats, err := bgs.repoman.CarStore().CompactUserShards(ctx, u.ID, fast)
-	if err != nil {
+	// with a priority, the repo is queued for the compactor's workers instead of compacted during the request
+	if p := e.QueryParam("priority"); p != "" {
+		prio, err := ParseCompactionPriority(p)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		bgs.compactor.EnqueueRepo(ctx, *u, fast, prio)
+		return e.JSON(200, map[string]any{
+			"success":  "true",
+			"queued":   true,
+			"priority": prio.String(),
+		})
+	}
+
+	stats, err := bgs.compactor.CompactRepo(ctx, bgs, u, fast)
+	if errors.Is(err, ErrCompactionInProgress) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	} else if err != nil {
 		return fmt.Errorf("compaction failed: %w", err)
 	}
 
@@ -458,7 +937,17 @@ func (bgs *BGS) handleAdminCompactAllRepos(e echo.Context) error {
 		shardThresh = v
 	}
 
-	err := bgs.compactor.EnqueueAllRepos(ctx, bgs, lim, shardThresh, fast)
+	prio := CompactionNormal
+	if p := e.QueryParam("priority"); p != "" {
+		v, err := ParseCompactionPriority(p)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		prio = v
+	}
+
+	err := bgs.compactor.EnqueueAllRepos(ctx, bgs, lim, shardThresh, fast, prio)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Errorf("failed to enqueue all repos: %w", err))
 	}
@@ -468,6 +957,123 @@ func (bgs *BGS) handleAdminCompactAllRepos(e echo.Context) error {
 	})
 }
 
+func (bgs *BGS) handleAdminGetCompactionQueue(e echo.Context) error {
+	return e.JSON(200, bgs.compactor.QueueStatus())
+}
+
+// CompactionHistory is the admin API's view of recent compactions
+type CompactionHistory struct {
+	Records []CompactionRecord `json:"records"`
+	// Totals for the repo, when filtering by DID
+	User *UserCompactionStats `json:"user,omitempty"`
+}
+
+func (bgs *BGS) handleAdminGetCompactionHistory(e echo.Context) error {
+	ctx := e.Request().Context()
+
+	var since time.Time
+	if s := e.QueryParam("since"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Errorf("invalid since duration: %w", err))
+		}
+		since = time.Now().Add(-d)
+	}
+
+	limit := 100
+	if s := e.QueryParam("limit"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid limit")
+		}
+		limit = v
+	}
+
+	var out CompactionHistory
+	var uid models.Uid
+	if did := e.QueryParam("did"); did != "" {
+		u, err := bgs.lookupUserByDid(ctx, did)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Errorf("no such user: %w", err))
+		}
+		uid = u.ID
+		if us, ok := bgs.compactor.UserStats(uid); ok {
+			out.User = &us
+		}
+	}
+
+	out.Records = bgs.compactor.History(since, uid, limit)
+	return e.JSON(200, out)
+}
+
+// StorageReport is the carstore's storage accounting, for the admin API
+type StorageReport struct {
+	Usage carstore.StorageUsage `json:"usage"`
+	// The requested repo, when filtering by DID
+	Repo *RepoStorage `json:"repo,omitempty"`
+	// The repos using the most storage, or growing the fastest
+	Top []RepoStorage `json:"top"`
+}
+
+// RepoStorage is one repo's storage accounting
+type RepoStorage struct {
+	DID string `json:"did"`
+	carstore.UserStorageUsage
+}
+
+func (bgs *BGS) handleAdminGetStorage(e echo.Context) error {
+	ctx := e.Request().Context()
+	cs := bgs.repoman.CarStore()
+
+	limit := 20
+	if s := e.QueryParam("limit"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 || v > 1000 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid limit")
+		}
+		limit = v
+	}
+
+	var byGrowth bool
+	switch e.QueryParam("sort") {
+	case "", "bytes":
+	case "growth":
+		byGrowth = true
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "sort must be bytes or growth")
+	}
+
+	out := StorageReport{Usage: cs.StorageUsage(), Top: []RepoStorage{}}
+
+	if did := e.QueryParam("did"); did != "" {
+		u, err := bgs.lookupUserByDid(ctx, did)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Errorf("no such user: %w", err))
+		}
+		us, err := cs.UserStorage(ctx, u.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get storage usage: %w", err)
+		}
+		out.Repo = &RepoStorage{DID: u.Did, UserStorageUsage: *us}
+	}
+
+	if limit > 0 {
+		top, err := cs.TopStorageUsers(ctx, limit, byGrowth)
+		if err != nil {
+			return fmt.Errorf("failed to get top storage users: %w", err)
+		}
+		for _, us := range top {
+			rs := RepoStorage{UserStorageUsage: us}
+			if u, err := bgs.lookupUserByUID(ctx, us.Usr); err == nil {
+				rs.DID = u.Did
+			}
+			out.Top = append(out.Top, rs)
+		}
+	}
+
+	return e.JSON(200, out)
+}
+
 func (bgs *BGS) handleAdminPostResyncPDS(e echo.Context) error {
 	host := strings.TrimSpace(e.QueryParam("host"))
 	if host == "" {
@@ -484,7 +1090,7 @@ func (bgs *BGS) handleAdminPostResyncPDS(e echo.Context) error {
 		ctx := context.Background()
 		err := bgs.ResyncPDS(ctx, pds)
 		if err != nil {
-			log.Errorw("failed to resync PDS", "err", err, "pds", pds.Host)
+			log.Error("failed to resync PDS", "err", err, "pds", pds.Host)
 		}
 	}()
 
@@ -544,6 +1150,76 @@ func (bgs *BGS) handleAdminResetRepo(e echo.Context) error {
 	})
 }
 
+func (bgs *BGS) handleAdminResyncRepo(e echo.Context) error {
+	ctx := e.Request().Context()
+
+	did := e.QueryParam("did")
+	if did == "" {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: "must pass a did",
+		}
+	}
+
+	res, err := bgs.ResyncRepo(ctx, did)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &echo.HTTPError{
+				Code:    404,
+				Message: "no such user",
+			}
+		}
+		return err
+	}
+
+	return e.JSON(200, map[string]any{
+		"success":          true,
+		"prev_rev":         res.PrevRev,
+		"rev":              res.Rev,
+		"diverged":         res.Diverged(),
+		"local_unreadable": res.LocalUnreadable,
+		"added":            len(res.Added),
+		"updated":          len(res.Updated),
+		"removed":          len(res.Removed),
+	})
+}
+
+func (bgs *BGS) handleAdminRevalidateHandle(e echo.Context) error {
+	ctx := e.Request().Context()
+
+	did := e.QueryParam("did")
+	if did == "" {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: "must pass a did",
+		}
+	}
+
+	// pick up any change to the DID document itself, too
+	bgs.didr.FlushCacheFor(did)
+
+	if err := bgs.RevalidateHandle(ctx, did); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &echo.HTTPError{
+				Code:    404,
+				Message: "no such user",
+			}
+		}
+		return err
+	}
+
+	u, err := bgs.lookupUserByDid(ctx, did)
+	if err != nil {
+		return err
+	}
+
+	return e.JSON(200, map[string]any{
+		"success":      true,
+		"handle":       u.Handle.String,
+		"valid_handle": u.ValidHandle,
+	})
+}
+
 func (bgs *BGS) handleAdminVerifyRepo(e echo.Context) error {
 	ctx := e.Request().Context()
 
@@ -572,6 +1248,14 @@ func (bgs *BGS) handleAdminAddTrustedDomain(e echo.Context) error {
 		return fmt.Errorf("must specify domain in query parameter")
 	}
 
+	domain, err := normalizeDomainPattern(domain)
+	if err != nil {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: err.Error(),
+		}
+	}
+
 	// Check if the domain is already trusted
 	trustedDomains := bgs.slurper.GetTrustedDomains()
 	if slices.Contains(trustedDomains, domain) {
@@ -590,6 +1274,48 @@ func (bgs *BGS) handleAdminAddTrustedDomain(e echo.Context) error {
 	})
 }
 
+func (bgs *BGS) handleAdminRemoveTrustedDomain(e echo.Context) error {
+	domain, err := normalizeDomainPattern(e.QueryParam("domain"))
+	if err != nil {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: err.Error(),
+		}
+	}
+
+	if err := bgs.slurper.RemoveTrustedDomain(domain); err != nil {
+		return err
+	}
+
+	return e.JSON(200, map[string]any{
+		"success": true,
+	})
+}
+
+func (bgs *BGS) handleAdminListTrustedDomains(e echo.Context) error {
+	trustedDomains := bgs.slurper.GetTrustedDomains()
+	if trustedDomains == nil {
+		trustedDomains = []string{}
+	}
+
+	return e.JSON(200, map[string]any{
+		"trusted_domains":      trustedDomains,
+		"trusted_domains_only": bgs.slurper.GetTrustedDomainsOnly(),
+	})
+}
+
+func (bgs *BGS) handleAdminSetTrustedDomainsOnly(e echo.Context) error {
+	only, err := strconv.ParseBool(e.QueryParam("enabled"))
+	if err != nil {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: err.Error(),
+		}
+	}
+
+	return bgs.slurper.SetTrustedDomainsOnly(only)
+}
+
 type AdminRequestCrawlRequest struct {
 	Hostname string `json:"hostname"`
 }
@@ -647,3 +1373,167 @@ func (bgs *BGS) handleAdminRequestCrawl(e echo.Context) error {
 
 	return bgs.slurper.SubscribeToPds(ctx, host, true, true) // Override Trusted Domain Check
 }
+
+// seqQueryParam parses an optional event sequence number from the query string, defaulting to zero
+func seqQueryParam(e echo.Context, name string) (int64, error) {
+	s := e.QueryParam(name)
+	if s == "" {
+		return 0, nil
+	}
+
+	seq, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, &echo.HTTPError{
+			Code:    400,
+			Message: fmt.Sprintf("invalid %s: %q", name, s),
+		}
+	}
+	return seq, nil
+}
+
+func sequenceToolsError(err error) error {
+	switch {
+	case errors.Is(err, events.ErrSequenceToolsUnsupported), errors.Is(err, events.ErrMaintenanceUnsupported):
+		return &echo.HTTPError{
+			Code:    http.StatusNotImplemented,
+			Message: err.Error(),
+		}
+	case errors.Is(err, events.ErrLiveEventsAfterSequence):
+		return &echo.HTTPError{
+			Code:    http.StatusConflict,
+			Message: err.Error(),
+		}
+	}
+	return err
+}
+
+func (bgs *BGS) handleAdminExportEvents(e echo.Context) error {
+	since, err := seqQueryParam(e, "since")
+	if err != nil {
+		return err
+	}
+	until, err := seqQueryParam(e, "until")
+	if err != nil {
+		return err
+	}
+
+	resp := e.Response()
+	resp.Header().Set(echo.HeaderContentType, echo.MIMEOctetStream)
+	resp.WriteHeader(http.StatusOK)
+
+	n, err := bgs.events.Export(e.Request().Context(), since, until, resp)
+	if err != nil {
+		// too late for an error status, the export is just cut short
+		log.Error("event export failed", "since", since, "until", until, "exported", n, "err", err)
+		return nil
+	}
+
+	log.Info("exported events", "since", since, "until", until, "exported", n)
+	return nil
+}
+
+// handleAdminExportSegment is like handleAdminExportEvents, but writes a self-describing firehose segment (see events.SegmentHeader), which can be analyzed offline or imported in to another relay
+func (bgs *BGS) handleAdminExportSegment(e echo.Context) error {
+	since, err := seqQueryParam(e, "since")
+	if err != nil {
+		return err
+	}
+	until, err := seqQueryParam(e, "until")
+	if err != nil {
+		return err
+	}
+
+	resp := e.Response()
+	resp.Header().Set(echo.HeaderContentType, echo.MIMEOctetStream)
+	resp.WriteHeader(http.StatusOK)
+
+	n, err := bgs.events.ExportSegment(e.Request().Context(), e.Request().Host, since, until, resp)
+	if err != nil {
+		// too late for an error status, the segment is just cut short
+		log.Error("event segment export failed", "since", since, "until", until, "exported", n, "err", err)
+		return nil
+	}
+
+	log.Info("exported event segment", "since", since, "until", until, "exported", n)
+	return nil
+}
+
+// handleAdminImportSegment adds the events in a firehose segment (the request body) to the relay's event stream, renumbered after its current events
+func (bgs *BGS) handleAdminImportSegment(e echo.Context) error {
+	n, err := bgs.events.ImportSegment(e.Request().Context(), e.Request().Body)
+	if err != nil {
+		log.Error("event segment import failed", "imported", n, "err", err)
+		// events before the failure stay imported
+		return &echo.HTTPError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("%s (%d events imported)", err, n),
+		}
+	}
+
+	log.Info("imported event segment", "imported", n)
+	return e.JSON(200, map[string]any{
+		"imported": n,
+	})
+}
+
+func (bgs *BGS) handleAdminTrimEvents(e echo.Context) error {
+	from, err := seqQueryParam(e, "from")
+	if err != nil {
+		return err
+	}
+	to, err := seqQueryParam(e, "to")
+	if err != nil {
+		return err
+	}
+
+	if from == 0 || to < from {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: "must pass a valid sequence range (from <= to)",
+		}
+	}
+
+	n, err := bgs.events.TrimEvents(e.Request().Context(), from, to)
+	if err != nil {
+		return sequenceToolsError(err)
+	}
+
+	return e.JSON(200, map[string]any{
+		"success": "true",
+		"trimmed": n,
+	})
+}
+
+func (bgs *BGS) handleAdminResequenceEvents(e echo.Context) error {
+	next, err := seqQueryParam(e, "next")
+	if err != nil {
+		return err
+	}
+
+	if next == 0 {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: "must pass the next sequence number",
+		}
+	}
+
+	prev, err := bgs.events.Resequence(e.Request().Context(), next)
+	if err != nil {
+		return sequenceToolsError(err)
+	}
+
+	return e.JSON(200, map[string]any{
+		"success":  "true",
+		"previous": prev,
+		"next":     next,
+	})
+}
+
+func (bgs *BGS) handleAdminRunEventsMaintenance(e echo.Context) error {
+	rep, err := bgs.events.RunMaintenance(e.Request().Context())
+	if err != nil {
+		return sequenceToolsError(err)
+	}
+
+	return e.JSON(200, rep)
+}
@@ -0,0 +1,153 @@
+package bgs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+)
+
+const (
+	AdminActionTakedown  = "takedown"
+	AdminActionSuspend   = "suspend"
+	AdminActionReinstate = "reinstate"
+	AdminActionPurge     = "purge"
+
+	AdminSubjectAccount = "account"
+	AdminSubjectHost    = "host"
+)
+
+// AdminAction is an audit log entry, recording an operator action against an account or an entire PDS host
+type AdminAction struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+	// Who performed the action, as given by the operator (eg, a username or email)
+	Actor string `json:"actor"`
+	// Address the admin request came from
+	RemoteAddr string `json:"remoteAddr"`
+	// One of AdminActionTakedown, AdminActionSuspend, AdminActionReinstate, or AdminActionPurge
+	Action string `json:"action"`
+	// AdminSubjectAccount (Subject is a DID) or AdminSubjectHost (Subject is a hostname)
+	SubjectType string `gorm:"index:idx_admin_action_subject" json:"subjectType"`
+	Subject     string `gorm:"index:idx_admin_action_subject" json:"subject"`
+	Reason      string `json:"reason"`
+	// For host actions, how many of the host's accounts were affected
+	AccountsAffected int `json:"accountsAffected,omitempty"`
+}
+
+func (bgs *BGS) recordAdminAction(ctx context.Context, act *AdminAction) error {
+	if err := bgs.db.WithContext(ctx).Create(act).Error; err != nil {
+		return fmt.Errorf("failed to record admin action: %w", err)
+	}
+	log.Info("admin action", "action", act.Action, "subject_type", act.SubjectType, "subject", act.Subject, "actor", act.Actor, "reason", act.Reason)
+	return nil
+}
+
+// ListAdminActions returns audit log entries, newest first. If subject is non-empty, only actions against that DID or hostname are returned. Entries older than the before ID (if non-zero) are returned, for pagination.
+func (bgs *BGS) ListAdminActions(ctx context.Context, subject string, before uint, limit int) ([]AdminAction, error) {
+	q := bgs.db.WithContext(ctx).Model(&AdminAction{})
+	if subject != "" {
+		q = q.Where("subject = ?", subject)
+	}
+	if before != 0 {
+		q = q.Where("id < ?", before)
+	}
+	var out []AdminAction
+	if err := q.Order("id DESC").Limit(limit).Find(&out).Error; err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SuspendRepo stops serving and rebroadcasting an account, without deleting its data (unlike TakeDownRepo). Account events from its PDS are rebroadcast with a suspended status. It can be undone with ReinstateRepo.
+func (bgs *BGS) SuspendRepo(ctx context.Context, did string) error {
+	u, err := bgs.lookupUserByDid(ctx, did)
+	if err != nil {
+		return err
+	}
+
+	if err := bgs.db.Model(User{}).Where("id = ?", u.ID).Update("suspended", true).Error; err != nil {
+		return err
+	}
+	bgs.accountStatusChanged(u.Did)
+
+	return bgs.announceAccountStatus(ctx, u.Did)
+}
+
+// ReinstateRepo reverses a relay takedown or suspension of an account. Data deleted by a takedown is not restored; the account will be re-crawled on its next event.
+func (bgs *BGS) ReinstateRepo(ctx context.Context, did string) error {
+	u, err := bgs.lookupUserByDid(ctx, did)
+	if err != nil {
+		return err
+	}
+
+	if err := bgs.db.Model(User{}).Where("id = ?", u.ID).UpdateColumns(map[string]any{
+		"taken_down": false,
+		"suspended":  false,
+	}).Error; err != nil {
+		return err
+	}
+	bgs.accountStatusChanged(u.Did)
+
+	return bgs.announceAccountStatus(ctx, u.Did)
+}
+
+// TakeDownHost blocks a PDS host, drops its connection, and takes down every account hosted on it. Returns the number of accounts taken down.
+func (bgs *BGS) TakeDownHost(ctx context.Context, host string) (int, error) {
+	pds, err := bgs.blockHost(ctx, host)
+	if err != nil {
+		return 0, err
+	}
+
+	var dids []string
+	if err := bgs.db.Model(&User{}).Where("pds = ? AND NOT taken_down", pds.ID).Pluck("did", &dids).Error; err != nil {
+		return 0, err
+	}
+
+	for i, did := range dids {
+		if err := bgs.TakeDownRepo(ctx, did); err != nil {
+			return i, fmt.Errorf("taking down account %s: %w", did, err)
+		}
+	}
+
+	return len(dids), nil
+}
+
+// SuspendHost blocks a PDS host and drops its connection, so no new events are accepted from it, without touching its accounts
+func (bgs *BGS) SuspendHost(ctx context.Context, host string) error {
+	_, err := bgs.blockHost(ctx, host)
+	return err
+}
+
+// ReinstateHost unblocks a PDS host and resubscribes to it. Accounts taken down along with the host must be reinstated individually.
+func (bgs *BGS) ReinstateHost(ctx context.Context, host string) error {
+	var pds models.PDS
+	if err := bgs.db.Where("host = ?", host).First(&pds).Error; err != nil {
+		return err
+	}
+
+	if err := bgs.db.Model(&models.PDS{}).Where("id = ?", pds.ID).Update("blocked", false).Error; err != nil {
+		return err
+	}
+
+	return bgs.slurper.SubscribeToPds(ctx, host, true, true)
+}
+
+func (bgs *BGS) blockHost(ctx context.Context, host string) (*models.PDS, error) {
+	var pds models.PDS
+	if err := bgs.db.Where("host = ?", host).First(&pds).Error; err != nil {
+		return nil, err
+	}
+
+	if err := bgs.db.Model(&models.PDS{}).Where("id = ?", pds.ID).Update("blocked", true).Error; err != nil {
+		return nil, err
+	}
+
+	if err := bgs.slurper.KillUpstreamConnection(host, false); err != nil && !errors.Is(err, ErrNoActiveConnection) {
+		return nil, err
+	}
+
+	return &pds, nil
+}
@@ -13,38 +13,50 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"contrib.go.opencensus.io/exporter/prometheus"
 	"github.com/bluesky-social/indigo/api"
 	atproto "github.com/bluesky-social/indigo/api/atproto"
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/automod/labeler"
+	"github.com/bluesky-social/indigo/blobmirror"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/did"
 	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/eventstats"
 	"github.com/bluesky-social/indigo/indexer"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/models"
 	"github.com/bluesky-social/indigo/repomgr"
+	"github.com/bluesky-social/indigo/util/dbreplica"
+	"github.com/bluesky-social/indigo/util/health"
+	"github.com/bluesky-social/indigo/util/logging"
+	"github.com/bluesky-social/indigo/util/ratelimit"
 	"github.com/bluesky-social/indigo/xrpc"
 	"golang.org/x/sync/semaphore"
 	"golang.org/x/time/rate"
 
 	"github.com/gorilla/websocket"
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
-	logging "github.com/ipfs/go-log"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	promclient "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"gorm.io/gorm"
 )
 
-var log = logging.Logger("bgs")
+var log = logging.Component("bgs")
+
+// for messages which can be logged for every event from upstream
+var eventLog = logging.Sampled(log, 100)
 var tracer = otel.Tracer("bgs")
 
 // serverListenerBootTimeout is how long to wait for the requested server socket
@@ -54,6 +66,9 @@ var tracer = otel.Tracer("bgs")
 // NewServer.
 const serverListenerBootTimeout = 5 * time.Second
 
+// how many hostnames to track requestCrawl rate limits for
+const requestCrawlLimiterCacheSize = 10_000
+
 type BGS struct {
 	Index       *indexer.Indexer
 	db          *gorm.DB
@@ -70,6 +85,18 @@ type BGS struct {
 
 	crawlOnly bool
 
+	// validate and rebroadcast commits without storing repos in the carstore
+	nonArchival     bool
+	nonArchivalSync string
+
+	// bearer tokens accepted on /internal routes, besides admin tokens
+	internalTokens []string
+
+	// publishes labels for relay takedowns and suspensions, if configured
+	labeler *labeler.Labeler
+
+	shard ShardConfig
+
 	// TODO: at some point we will want to lock specific DIDs, this lock as is
 	// is overly broad, but i dont expect it to be a bottleneck for now
 	extUserLk sync.Mutex
@@ -87,6 +114,43 @@ type BGS struct {
 
 	// Management of Compaction
 	compactor *Compactor
+
+	// readiness checks, for /readyz
+	health *health.Checker
+
+	// requestCrawl rate limits, by hostname
+	requestCrawlLimit    rate.Limit
+	requestCrawlLimiters *lru.Cache[string, *indexer.HostLimiter]
+
+	// nil unless newcomer throttling is configured
+	newcomers *newcomerThrottle
+
+	accountLimits *accountLimiter
+
+	// nil unless blob mirroring is configured
+	blobMirror *blobmirror.Mirror
+
+	// per-collection and per-host counts of upstream events, if enabled
+	eventStats *eventstats.Aggregator
+
+	// read replicas of db, for queries which can tolerate replication lag
+	reads *dbreplica.Set
+
+	// hosting status of recently looked up accounts, for sync.getRepoStatus and bulk status queries
+	hostingStatus *hostingStatusCache
+
+	// closed on shutdown to stop the handle revalidation routine
+	handleRevalidationExit chan struct{}
+
+	// where replication checkpoints are written, and the routine writing them
+	checkpoints     []CheckpointStore
+	checkpointsExit chan struct{}
+
+	// set once shutdown starts, to refuse new consumers
+	draining atomic.Bool
+	// closed on shutdown, once the event persister is flushed, to disconnect consumers
+	consumersExit chan struct{}
+	server        atomic.Pointer[http.Server]
 }
 
 type PDSResync struct {
@@ -112,6 +176,41 @@ type BGSConfig struct {
 	DefaultRepoLimit  int64
 	ConcurrencyPerPDS int64
 	MaxQueuePerPDS    int64
+	// Rate of requestCrawl calls accepted for any single hostname. Reduced adaptively when the host fails its describeServer check
+	RequestCrawlLimit rate.Limit
+	// Throttles for newly discovered PDS hosts and newly seen accounts. The zero value (no probation period) disables them
+	NewcomerThrottle NewcomerThrottleConfig
+	// Per-account commit rate limits
+	AccountRateLimit AccountRateLimitConfig
+	// Periodic re-verification of account handles
+	HandleRevalidation HandleRevalidationConfig
+	// Validate and rebroadcast commits without storing repos. Only the blocks carried in events are retained, in the event persister, for as long as it retains events
+	NonArchival bool
+	// How sync.getRepo, sync.getRecord, and sync.getBlocks are served in non-archival mode: NonArchivalSyncRefuse (the default) or NonArchivalSyncProxy to the account's PDS
+	NonArchivalSync string
+	// Trust tiers for upstream hosts, which control how strictly their commits are validated and how fast they may send events
+	HostTrust HostTrustConfig
+	// Which accounts this relay ingests, when the network is split between several relays
+	Shard ShardConfig
+	// Detection and reconnection of upstream subscriptions which have gone silent
+	StaleHost StaleHostConfig
+	// Mirrors the blobs referenced by accepted commits, and serves them from sync.getBlob. Disabled when nil
+	BlobMirror *blobmirror.Mirror
+	// Aggregates upstream events into per-collection and per-host counts, served at /admin/stats/events. Disabled when nil
+	EventStats *eventstats.Aggregator
+	// Read replicas of the relay database. Read-only queries which can tolerate replication lag (listRepos pages, compaction lookups) are spread across them; everything else goes to the primary
+	ReadReplicas []*gorm.DB
+	// Number of accounts whose hosting status is kept in memory for sync.getRepoStatus and bulk status queries
+	HostingStatusCacheSize int
+	// Stores which a checkpoint of the sequencer position and upstream cursors is written to every CheckpointInterval, and on shutdown, for a standby relay to take over from (see RestoreCheckpoint)
+	Checkpoints        []CheckpointStore
+	CheckpointInterval time.Duration
+	// Compactor worker and queue settings, DefaultCompactorOptions when nil. The requeue interval is CompactInterval
+	Compactor *CompactorOptions
+	// Bearer tokens for trusted internal services (eg, search indexers and labelers), which may use the /internal APIs but not the admin API
+	InternalTokens []string
+	// If set, relay takedowns and suspensions are also published as signed labels on the accounts ("!takedown" and "!suspend"), served from the relay's queryLabels and subscribeLabels endpoints
+	Labeler *labeler.Labeler
 }
 
 func DefaultBGSConfig() *BGSConfig {
@@ -121,6 +220,18 @@ func DefaultBGSConfig() *BGSConfig {
 		DefaultRepoLimit:  100,
 		ConcurrencyPerPDS: 100,
 		MaxQueuePerPDS:    1_000,
+		RequestCrawlLimit: rate.Every(10 * time.Second),
+		HandleRevalidation: HandleRevalidationConfig{
+			ValidInterval:   7 * 24 * time.Hour,
+			InvalidInterval: time.Hour,
+			PerSecond:       10,
+		},
+		NonArchivalSync: NonArchivalSyncRefuse,
+		HostTrust:       DefaultHostTrustConfig(),
+		StaleHost:       DefaultStaleHostConfig(),
+
+		HostingStatusCacheSize: 1_000_000,
+		CheckpointInterval:     10 * time.Second,
 	}
 }
 
@@ -129,14 +240,30 @@ func NewBGS(db *gorm.DB, ix *indexer.Indexer, repoman *repomgr.RepoManager, evtm
 	if config == nil {
 		config = DefaultBGSConfig()
 	}
-	db.AutoMigrate(User{})
-	db.AutoMigrate(AuthToken{})
-	db.AutoMigrate(models.PDS{})
-	db.AutoMigrate(models.DomainBan{})
+	if config.NonArchival && config.NonArchivalSync != NonArchivalSyncRefuse && config.NonArchivalSync != NonArchivalSyncProxy {
+		return nil, fmt.Errorf("invalid non-archival sync mode %q", config.NonArchivalSync)
+	}
+	if !validHostTrust(config.HostTrust.Default) {
+		return nil, fmt.Errorf("invalid default host trust tier %q", config.HostTrust.Default)
+	}
+	if err := config.Shard.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.AccountRateLimit.validate(); err != nil {
+		return nil, err
+	}
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrator.Up(context.TODO(), 0); err != nil {
+		return nil, err
+	}
 
 	bgs := &BGS{
 		Index:       ix,
 		db:          db,
+		reads:       dbreplica.New(db, config.ReadReplicas...),
 		repoFetcher: rf,
 
 		hr:      hr,
@@ -145,11 +272,31 @@ func NewBGS(db *gorm.DB, ix *indexer.Indexer, repoman *repomgr.RepoManager, evtm
 		didr:    didr,
 		ssl:     config.SSL,
 
-		consumersLk: sync.RWMutex{},
-		consumers:   make(map[uint64]*SocketConsumer),
+		nonArchival:     config.NonArchival,
+		nonArchivalSync: config.NonArchivalSync,
+
+		internalTokens: cleanInternalTokens(config.InternalTokens),
+		labeler:        config.Labeler,
+
+		shard: config.Shard,
+
+		blobMirror: config.BlobMirror,
+		eventStats: config.EventStats,
+
+		consumersLk:   sync.RWMutex{},
+		consumers:     make(map[uint64]*SocketConsumer),
+		consumersExit: make(chan struct{}),
 
 		pdsResyncs: make(map[uint]*PDSResync),
+
+		requestCrawlLimit: config.RequestCrawlLimit,
+	}
+	bgs.requestCrawlLimiters, _ = lru.New[string, *indexer.HostLimiter](requestCrawlLimiterCacheSize)
+	hostingStatus, err := newHostingStatusCache(db, config.HostingStatusCacheSize)
+	if err != nil {
+		return nil, err
 	}
+	bgs.hostingStatus = hostingStatus
 
 	ix.CreateExternalUser = bgs.createExternalUser
 	slOpts := DefaultSlurperOptions()
@@ -157,22 +304,52 @@ func NewBGS(db *gorm.DB, ix *indexer.Indexer, repoman *repomgr.RepoManager, evtm
 	slOpts.DefaultRepoLimit = config.DefaultRepoLimit
 	slOpts.ConcurrencyPerPDS = config.ConcurrencyPerPDS
 	slOpts.MaxQueuePerPDS = config.MaxQueuePerPDS
+	slOpts.HostTrust = config.HostTrust
+	slOpts.StaleHost = config.StaleHost
 	s, err := NewSlurper(db, bgs.handleFedEvent, slOpts)
 	if err != nil {
 		return nil, err
 	}
 
 	bgs.slurper = s
+	bgs.health = bgs.newHealthChecker()
+
+	if config.NewcomerThrottle.enabled() {
+		bgs.newcomers = newNewcomerThrottle(config.NewcomerThrottle, s.IsTrustedDomain)
+	}
+
+	if config.AccountRateLimit.enabled() {
+		bgs.accountLimits = newAccountLimiter(config.AccountRateLimit)
+	}
 
 	if err := bgs.slurper.RestartAll(); err != nil {
 		return nil, err
 	}
 
-	compactor := NewCompactor(nil)
+	compactor := NewCompactor(config.Compactor)
 	compactor.requeueInterval = config.CompactInterval
+	if config.NonArchival {
+		// nothing in the carstore to compact
+		compactor.requeueInterval = 0
+	}
 	compactor.Start(bgs)
 	bgs.compactor = compactor
 
+	if bgs.blobMirror != nil {
+		bgs.blobMirror.Start()
+	}
+
+	if config.HandleRevalidation.enabled() {
+		bgs.handleRevalidationExit = make(chan struct{})
+		go bgs.runHandleRevalidation(config.HandleRevalidation, bgs.handleRevalidationExit)
+	}
+
+	if len(config.Checkpoints) > 0 && config.CheckpointInterval > 0 {
+		bgs.checkpoints = config.Checkpoints
+		bgs.checkpointsExit = make(chan struct{})
+		go bgs.runCheckpoints(config.CheckpointInterval, bgs.checkpoints, bgs.checkpointsExit)
+	}
+
 	return bgs, nil
 }
 
@@ -222,13 +399,13 @@ func (bgs *BGS) StartDebug(listen string) error {
 		act, err := bgs.Index.GetUserOrMissing(ctx, did)
 		if err != nil {
 			w.WriteHeader(500)
-			log.Errorf("failed to get user: %s", err)
+			log.Error("failed to get user", "err", err)
 			return
 		}
 
 		if err := bgs.Index.Crawler.Crawl(ctx, act); err != nil {
 			w.WriteHeader(500)
-			log.Errorf("failed to add user to crawler: %s", err)
+			log.Error("failed to add user to crawler", "err", err)
 			return
 		}
 	})
@@ -306,6 +483,10 @@ func (bgs *BGS) StartWithListener(listen net.Listener) error {
 	e.Static("/assets", "public/assets")
 
 	e.Use(MetricsMiddleware)
+	// continue the caller's trace, if it sent one. Subscriptions are skipped; a span for the whole connection isn't useful, and each event carries its own trace context
+	e.Use(otelecho.Middleware("bgs", otelecho.WithSkipper(func(c echo.Context) bool {
+		return strings.HasPrefix(c.Request().URL.Path, "/xrpc/com.atproto.sync.subscribe")
+	})))
 
 	e.HTTPErrorHandler = func(err error, ctx echo.Context) {
 		switch err := err.(type) {
@@ -313,7 +494,7 @@ func (bgs *BGS) StartWithListener(listen net.Listener) error {
 			if err2 := ctx.JSON(err.Code, map[string]any{
 				"error": err.Message,
 			}); err2 != nil {
-				log.Errorf("Failed to write http error: %s", err2)
+				log.Error("Failed to write http error", "err", err2)
 			}
 		default:
 			sendHeader := true
@@ -321,7 +502,7 @@ func (bgs *BGS) StartWithListener(listen net.Listener) error {
 				sendHeader = false
 			}
 
-			log.Warnf("HANDLER ERROR: (%s) %s", ctx.Path(), err)
+			log.Warn("HANDLER ERROR", "path", ctx.Path(), "err", err)
 
 			if strings.HasPrefix(ctx.Path(), "/admin/") {
 				ctx.JSON(500, map[string]any{
@@ -342,13 +523,26 @@ func (bgs *BGS) StartWithListener(listen net.Listener) error {
 	e.GET("/xrpc/com.atproto.sync.getRecord", bgs.HandleComAtprotoSyncGetRecord)
 	e.GET("/xrpc/com.atproto.sync.getRepo", bgs.HandleComAtprotoSyncGetRepo)
 	e.GET("/xrpc/com.atproto.sync.getBlocks", bgs.HandleComAtprotoSyncGetBlocks)
+	if bgs.blobMirror != nil {
+		e.GET("/xrpc/com.atproto.sync.getBlob", bgs.HandleComAtprotoSyncGetBlob)
+	}
 	e.GET("/xrpc/com.atproto.sync.requestCrawl", bgs.HandleComAtprotoSyncRequestCrawl)
 	e.POST("/xrpc/com.atproto.sync.requestCrawl", bgs.HandleComAtprotoSyncRequestCrawl)
 	e.GET("/xrpc/com.atproto.sync.listRepos", bgs.HandleComAtprotoSyncListRepos)
 	e.GET("/xrpc/com.atproto.sync.getLatestCommit", bgs.HandleComAtprotoSyncGetLatestCommit)
+	e.GET("/xrpc/com.atproto.sync.getRepoStatus", bgs.HandleComAtprotoSyncGetRepoStatus)
+	e.POST("/repos/status", bgs.handleRepoStatuses)
+	bgs.registerLabelerRoutes(e)
 	e.GET("/xrpc/com.atproto.sync.notifyOfUpdate", bgs.HandleComAtprotoSyncNotifyOfUpdate)
 	e.GET("/xrpc/_health", bgs.HandleHealthCheck)
 	e.GET("/_health", bgs.HandleHealthCheck)
+	e.GET("/healthz", echo.WrapHandler(bgs.health.Healthz()))
+	e.GET("/readyz", echo.WrapHandler(bgs.health.Readyz()))
+	e.GET("/buildinfo", echo.WrapHandler(bgs.health.BuildInfo()))
+
+	// Batch block fetches for trusted internal services
+	internal := e.Group("/internal", bgs.checkInternalAuth)
+	internal.POST("/blocks", bgs.handleInternalFetchBlocks, bgs.requireArchival, middleware.Gzip())
 
 	admin := e.Group("/admin", bgs.checkAdminAuth)
 
@@ -366,42 +560,137 @@ func (bgs *BGS) StartWithListener(listen net.Listener) error {
 	admin.POST("/subs/unbanDomain", bgs.handleAdminUnbanDomain)
 
 	// Repo-related Admin API
-	admin.POST("/repo/takeDown", bgs.handleAdminTakeDownRepo)
-	admin.POST("/repo/reverseTakedown", bgs.handleAdminReverseTakedown)
-	admin.POST("/repo/compact", bgs.handleAdminCompactRepo)
-	admin.POST("/repo/compactAll", bgs.handleAdminCompactAllRepos)
-	admin.POST("/repo/reset", bgs.handleAdminResetRepo)
-	admin.POST("/repo/verify", bgs.handleAdminVerifyRepo)
+	admin.POST("/repo/takeDown", bgs.handleAdminAccountAction(AdminActionTakedown, bgs.TakeDownRepo))
+	admin.POST("/repo/suspend", bgs.handleAdminAccountAction(AdminActionSuspend, bgs.SuspendRepo))
+	admin.POST("/repo/reinstate", bgs.handleAdminAccountAction(AdminActionReinstate, bgs.ReinstateRepo))
+	admin.POST("/repo/reverseTakedown", bgs.handleAdminAccountAction(AdminActionReinstate, bgs.ReverseTakedown))
+	admin.POST("/repo/purge", bgs.handleAdminPurgeAccount)
+	admin.POST("/repo/compact", bgs.handleAdminCompactRepo, bgs.requireArchival)
+	admin.POST("/repo/compactAll", bgs.handleAdminCompactAllRepos, bgs.requireArchival)
+	admin.GET("/repo/compactionQueue", bgs.handleAdminGetCompactionQueue, bgs.requireArchival)
+	admin.GET("/repo/compactionHistory", bgs.handleAdminGetCompactionHistory, bgs.requireArchival)
+	admin.GET("/storage", bgs.handleAdminGetStorage, bgs.requireArchival)
+	admin.POST("/repo/reset", bgs.handleAdminResetRepo, bgs.requireArchival)
+	admin.POST("/repo/verify", bgs.handleAdminVerifyRepo, bgs.requireArchival)
+	admin.POST("/repo/resync", bgs.handleAdminResyncRepo, bgs.requireArchival)
+	admin.POST("/repo/revalidateHandle", bgs.handleAdminRevalidateHandle)
+	admin.GET("/repo/rateLimited", bgs.handleAdminListRateLimitedAccounts)
 
 	// PDS-related Admin API
 	admin.POST("/pds/requestCrawl", bgs.handleAdminRequestCrawl)
 	admin.GET("/pds/list", bgs.handleListPDSs)
-	admin.POST("/pds/resync", bgs.handleAdminPostResyncPDS)
+	admin.GET("/pds/hosts", bgs.handleAdminListHosts)
+	admin.GET("/pds/host", bgs.handleAdminGetHost)
+	admin.POST("/pds/pause", bgs.handlePauseHost)
+	admin.POST("/pds/resume", bgs.handleResumeHost)
+	admin.POST("/pds/setTrust", bgs.handleAdminSetHostTrust)
+	admin.POST("/pds/resync", bgs.handleAdminPostResyncPDS, bgs.requireArchival)
 	admin.GET("/pds/resync", bgs.handleAdminGetResyncPDS)
 	admin.POST("/pds/changeLimits", bgs.handleAdminChangePDSRateLimits)
+	admin.GET("/pds/adaptiveLimits", bgs.handleAdminGetAdaptiveLimits)
+	admin.POST("/pds/adaptiveLimits/override", bgs.handleAdminOverrideAdaptiveLimit)
 	admin.POST("/pds/block", bgs.handleBlockPDS)
+	admin.POST("/pds/takeDown", bgs.handleAdminHostAction(AdminActionTakedown, bgs.TakeDownHost))
+	admin.POST("/pds/suspend", bgs.handleAdminHostAction(AdminActionSuspend, func(ctx context.Context, host string) (int, error) {
+		return 0, bgs.SuspendHost(ctx, host)
+	}))
+	admin.POST("/pds/reinstate", bgs.handleAdminHostAction(AdminActionReinstate, func(ctx context.Context, host string) (int, error) {
+		return 0, bgs.ReinstateHost(ctx, host)
+	}))
 	admin.POST("/pds/unblock", bgs.handleUnblockPDS)
 	admin.POST("/pds/addTrustedDomain", bgs.handleAdminAddTrustedDomain)
+	admin.POST("/pds/removeTrustedDomain", bgs.handleAdminRemoveTrustedDomain)
+	admin.GET("/pds/listTrustedDomains", bgs.handleAdminListTrustedDomains)
+	admin.POST("/pds/setTrustedDomainsOnly", bgs.handleAdminSetTrustedDomainsOnly)
 
 	// Consumer-related Admin API
 	admin.GET("/consumers/list", bgs.handleAdminListConsumers)
 
+	// Event persister maintenance
+	admin.GET("/events/export", bgs.handleAdminExportEvents)
+	admin.GET("/events/segment", bgs.handleAdminExportSegment)
+	admin.POST("/events/import", bgs.handleAdminImportSegment)
+	admin.POST("/events/trim", bgs.handleAdminTrimEvents)
+	admin.POST("/events/resequence", bgs.handleAdminResequenceEvents)
+	admin.POST("/events/maintenance", bgs.handleAdminRunEventsMaintenance)
+
+	// Shared rate limiters which are adjustable at runtime (see ratelimit.Register)
+	admin.GET("/ratelimits", echo.WrapHandler(ratelimit.Handler()))
+	admin.POST("/ratelimits", echo.WrapHandler(ratelimit.Handler()))
+
+	if bgs.eventStats != nil {
+		admin.GET("/stats/events", echo.WrapHandler(bgs.eventStats.Handler()))
+	}
+
+	// Sequencer position and upstream cursors, for failing over to a standby relay
+	admin.GET("/replication/checkpoint", bgs.handleAdminCheckpoint)
+
+	// Audit log of account and host actions
+	admin.GET("/audit/list", bgs.handleAdminListActions)
+
+	// History of accounts' handles, PDS hosts, and signing keys
+	admin.GET("/repo/identityHistory", bgs.handleAdminIdentityHistory)
+
+	// Runtime log levels
+	admin.GET("/log/levels", echo.WrapHandler(logging.LevelsHandler()))
+	admin.POST("/log/levels", echo.WrapHandler(logging.LevelsHandler()))
+
 	// In order to support booting on random ports in tests, we need to tell the
 	// Echo instance it's already got a port, and then use its StartServer
 	// method to re-use that listener.
 	e.Listener = listen
 	srv := &http.Server{}
+	bgs.server.Store(srv)
 	return e.StartServer(srv)
 }
 
-func (bgs *BGS) Shutdown() []error {
-	errs := bgs.slurper.Shutdown()
+// Shutdown drains and stops the relay, so that a restart neither skips nor reprocesses events. New consumers are refused. Upstream subscriptions are closed once the events already read from them are processed, and their cursors saved. Compactions in progress are finished. The event persister is flushed before existing consumers are disconnected, so they can resume from their cursor elsewhere. If ctx expires first, the remaining upstream events are dropped (see Slurper.Shutdown).
+func (bgs *BGS) Shutdown(ctx context.Context) []error {
+	bgs.draining.Store(true)
+	log.Info("draining relay for shutdown")
+
+	// compactions are finished alongside the upstream drain
+	compactorDone := make(chan struct{})
+	go func() {
+		bgs.compactor.Shutdown()
+		close(compactorDone)
+	}()
+
+	if bgs.handleRevalidationExit != nil {
+		close(bgs.handleRevalidationExit)
+	}
+	if bgs.checkpointsExit != nil {
+		close(bgs.checkpointsExit)
+	}
 
-	if err := bgs.events.Shutdown(context.TODO()); err != nil {
+	errs := bgs.slurper.Shutdown(ctx)
+
+	select {
+	case <-compactorDone:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("timed out waiting for compactions to finish"))
+	}
+
+	if err := bgs.events.Shutdown(ctx); err != nil {
 		errs = append(errs, err)
 	}
 
-	bgs.compactor.Shutdown()
+	// with upstream cursors saved and every event persisted, a standby can take over from this checkpoint without duplicates
+	if len(bgs.checkpoints) > 0 {
+		bgs.writeCheckpoint(ctx, bgs.checkpoints)
+	}
+
+	if bgs.blobMirror != nil {
+		bgs.blobMirror.Shutdown()
+	}
+
+	close(bgs.consumersExit)
+
+	if srv := bgs.server.Load(); srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop http server: %w", err))
+		}
+	}
 
 	return errs
 }
@@ -412,8 +701,11 @@ type HealthStatus struct {
 }
 
 func (bgs *BGS) HandleHealthCheck(c echo.Context) error {
+	if bgs.draining.Load() {
+		return c.JSON(503, HealthStatus{Status: "draining", Message: "relay is shutting down"})
+	}
 	if err := bgs.db.Exec("SELECT 1").Error; err != nil {
-		log.Errorf("healthcheck can't connect to database: %v", err)
+		log.Error("healthcheck can't connect to database", "err", err)
 		return c.JSON(500, HealthStatus{Status: "error", Message: "can't connect to database"})
 	} else {
 		return c.JSON(200, HealthStatus{Status: "ok"})
@@ -482,20 +774,28 @@ func (bgs *BGS) checkAdminAuth(next echo.HandlerFunc) echo.HandlerFunc {
 }
 
 type User struct {
-	ID          models.Uid `gorm:"primarykey;index:idx_user_id_active,where:taken_down = false AND tombstoned = false"`
+	ID          models.Uid `gorm:"primarykey;index:idx_user_id_active,where:taken_down = false AND tombstoned = false;index:idx_user_pds_id,priority:2"`
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 	DeletedAt   gorm.DeletedAt `gorm:"index"`
 	Handle      sql.NullString `gorm:"index"`
 	Did         string         `gorm:"uniqueIndex"`
-	PDS         uint
-	ValidHandle bool `gorm:"default:true"`
+	PDS         uint           `gorm:"index:idx_user_pds_id,priority:1"`
+	ValidHandle bool           `gorm:"default:true"`
+	// HandleCheckedAt is when the handle was last bidirectionally verified
+	// (successfully or not). Null for accounts last checked before this was
+	// tracked.
+	HandleCheckedAt *time.Time `gorm:"index"`
 
 	// TakenDown is set to true if the user in question has been taken down.
 	// A user in this state will have all future events related to it dropped
 	// and no data about this user will be served.
 	TakenDown  bool
 	Tombstoned bool
+	// Suspended is set to true if the user has been suspended by a relay
+	// admin. Like a takedown, events are dropped and data is not served, but
+	// the data is retained so the suspension can be reversed.
+	Suspended bool `gorm:"default:false"`
 
 	// UpstreamStatus is the state of the user as reported by the upstream PDS
 	UpstreamStatus string `gorm:"index"`
@@ -525,10 +825,10 @@ func (bgs *BGS) cleanupConsumer(id uint64) {
 
 	var m = &dto.Metric{}
 	if err := c.EventsSent.Write(m); err != nil {
-		log.Errorf("failed to get sent counter: %s", err)
+		log.Error("failed to get sent counter", "err", err)
 	}
 
-	log.Infow("consumer disconnected",
+	log.Info("consumer disconnected",
 		"consumer_id", id,
 		"remote_addr", c.RemoteAddr,
 		"user_agent", c.UserAgent,
@@ -538,6 +838,19 @@ func (bgs *BGS) cleanupConsumer(id uint64) {
 }
 
 func (bgs *BGS) EventsHandler(c echo.Context) error {
+	if bgs.draining.Load() {
+		return &echo.HTTPError{
+			Code:    http.StatusServiceUnavailable,
+			Message: "relay is shutting down",
+		}
+	}
+	if !bgs.events.Serves() {
+		return &echo.HTTPError{
+			Code:    http.StatusNotImplemented,
+			Message: "this relay publishes events to a bus, subscribe to the fanout instead",
+		}
+	}
+
 	var since *int64
 	if sinceVal := c.QueryParam("cursor"); sinceVal != "" {
 		sval, err := strconv.ParseInt(sinceVal, 10, 64)
@@ -580,7 +893,7 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 				}
 
 				if err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(5*time.Second)); err != nil {
-					log.Warnf("failed to ping client: %s", err)
+					log.Warn("failed to ping client", "err", err)
 					cancel()
 					return
 				}
@@ -605,7 +918,7 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 		for {
 			_, _, err := conn.ReadMessage()
 			if err != nil {
-				log.Warnf("failed to read message from client: %s", err)
+				log.Warn("failed to read message from client", "err", err)
 				cancel()
 				return
 			}
@@ -638,7 +951,7 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 		"user_agent", consumer.UserAgent,
 	)
 
-	logger.Infow("new consumer", "cursor", since)
+	logger.Info("new consumer", "cursor", since)
 
 	for {
 		select {
@@ -650,7 +963,7 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 
 			wc, err := conn.NextWriter(websocket.BinaryMessage)
 			if err != nil {
-				logger.Errorf("failed to get next writer: %s", err)
+				logger.Error("failed to get next writer", "err", err)
 				return err
 			}
 
@@ -664,7 +977,7 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 			}
 
 			if err := wc.Close(); err != nil {
-				logger.Warnf("failed to flush-close our event write: %s", err)
+				logger.Warn("failed to flush-close our event write", "err", err)
 				return nil
 			}
 
@@ -672,6 +985,12 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 			lastWrite = time.Now()
 			lastWriteLk.Unlock()
 			sentCounter.Inc()
+		case <-bgs.consumersExit:
+			logger.Info("disconnecting consumer for shutdown")
+			if err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "relay shutting down"), time.Now().Add(time.Second)); err != nil {
+				logger.Warn("failed to send close message", "err", err)
+			}
+			return nil
 		case <-ctx.Done():
 			return nil
 		}
@@ -685,64 +1004,23 @@ func prometheusHandler() http.Handler {
 	// defensive in case things change under the hood.
 	registry, ok := promclient.DefaultRegisterer.(*promclient.Registry)
 	if !ok {
-		log.Warnf("failed to export default prometheus registry; some metrics will be unavailable; unexpected type: %T", promclient.DefaultRegisterer)
+		log.Warn("failed to export default prometheus registry; some metrics will be unavailable", "type", fmt.Sprintf("%T", promclient.DefaultRegisterer))
 	}
 	exporter, err := prometheus.NewExporter(prometheus.Options{
 		Registry:  registry,
 		Namespace: "bigsky",
 	})
 	if err != nil {
-		log.Errorf("could not create the prometheus stats exporter: %v", err)
+		log.Error("could not create the prometheus stats exporter", "err", err)
 	}
 
 	return exporter
 }
 
-// domainIsBanned checks if the given host is banned, starting with the host
-// itself, then checking every parent domain up to the tld
+// domainIsBanned checks if the given host is banned, either directly, by a
+// ban on any parent domain up to the tld, or by a "*." wildcard ban
 func (s *BGS) domainIsBanned(ctx context.Context, host string) (bool, error) {
-	// ignore ports when checking for ban status
-	hostport := strings.Split(host, ":")
-
-	segments := strings.Split(hostport[0], ".")
-
-	// TODO: use normalize method once that merges
-	var cleaned []string
-	for _, s := range segments {
-		if s == "" {
-			continue
-		}
-		s = strings.ToLower(s)
-
-		cleaned = append(cleaned, s)
-	}
-	segments = cleaned
-
-	for i := 0; i < len(segments)-1; i++ {
-		dchk := strings.Join(segments[i:], ".")
-		found, err := s.findDomainBan(ctx, dchk)
-		if err != nil {
-			return false, err
-		}
-
-		if found {
-			return true, nil
-		}
-	}
-	return false, nil
-}
-
-func (s *BGS) findDomainBan(ctx context.Context, host string) (bool, error) {
-	var db models.DomainBan
-	if err := s.db.Find(&db, "domain = ?", host).Error; err != nil {
-		return false, err
-	}
-
-	if db.ID == 0 {
-		return false, nil
-	}
-
-	return true, nil
+	return hostIsBanned(ctx, s.db, host)
 }
 
 func (bgs *BGS) lookupUserByDid(ctx context.Context, did string) (*User, error) {
@@ -761,12 +1039,24 @@ func (bgs *BGS) lookupUserByDid(ctx context.Context, did string) (*User, error)
 	return &u, nil
 }
 
+// lookupEventUser looks up the user an event is for, returning nil if the account hasn't been seen before
+func (bgs *BGS) lookupEventUser(ctx context.Context, did string) (*User, error) {
+	u, err := bgs.lookupUserByDid(ctx, did)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("looking up event user: %w", err)
+	}
+	return u, nil
+}
+
 func (bgs *BGS) lookupUserByUID(ctx context.Context, uid models.Uid) (*User, error) {
 	ctx, span := tracer.Start(ctx, "lookupUserByUID")
 	defer span.End()
 
 	var u User
-	if err := bgs.db.Find(&u, "id = ?", uid).Error; err != nil {
+	if err := bgs.reads.Read().WithContext(ctx).Find(&u, "id = ?", uid).Error; err != nil {
 		return nil, err
 	}
 
@@ -796,17 +1086,35 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 
 	eventsReceivedCounter.WithLabelValues(host.Host).Add(1)
 
+	if did := EventDid(env); did != "" && !bgs.shard.Owns(did) {
+		// another shard's account
+		eventsOutsideShard.Inc()
+		return nil
+	}
+
+	if bgs.eventStats != nil {
+		bgs.eventStats.HandleEvent(host.Host, env)
+	}
+
+	if err := bgs.newcomers.waitHost(ctx, host); err != nil {
+		return err
+	}
+
 	switch {
 	case env.RepoCommit != nil:
 		repoCommitsReceivedCounter.WithLabelValues(host.Host).Add(1)
 		evt := env.RepoCommit
-		log.Debugw("bgs got repo append event", "seq", evt.Seq, "host", host.Host, "repo", evt.Repo)
+		eventLog.Debug("bgs got repo append event", "seq", evt.Seq, "host", host.Host, "repo", evt.Repo)
 		u, err := bgs.lookupUserByDid(ctx, evt.Repo)
 		if err != nil {
 			if !errors.Is(err, gorm.ErrRecordNotFound) {
 				return fmt.Errorf("looking up event user: %w", err)
 			}
 
+			if bgs.newcomers.newAccountThrottled(host, nil, evt.Repo, "commit") {
+				return nil
+			}
+
 			newUsersDiscovered.Inc()
 			subj, err := bgs.createExternalUser(ctx, evt.Repo)
 			if err != nil {
@@ -816,35 +1124,23 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 			u = new(User)
 			u.ID = subj.Uid
 			u.Did = evt.Repo
+			u.CreatedAt = time.Now()
 		}
 
-		span.SetAttributes(attribute.String("upstream_status", u.UpstreamStatus))
-
-		if u.TakenDown || u.UpstreamStatus == events.AccountStatusTakendown {
-			span.SetAttributes(attribute.Bool("taken_down_by_relay_admin", u.TakenDown))
-			log.Debugw("dropping commit event from taken down user", "did", evt.Repo, "seq", evt.Seq, "host", host.Host)
-			return nil
-		}
-
-		if u.UpstreamStatus == events.AccountStatusSuspended {
-			log.Debugw("dropping commit event from suspended user", "did", evt.Repo, "seq", evt.Seq, "host", host.Host)
-			return nil
-		}
-
-		if u.UpstreamStatus == events.AccountStatusDeactivated {
-			log.Debugw("dropping commit event from deactivated user", "did", evt.Repo, "seq", evt.Seq, "host", host.Host)
-			return nil
+		if err := bgs.newcomers.waitAccount(ctx, host, u); err != nil {
+			return err
 		}
 
-		if evt.Rebase {
-			return fmt.Errorf("rebase was true in event seq:%d,host:%s", evt.Seq, host.Host)
+		if err := bgs.accountLimits.waitCommit(ctx, u); err != nil {
+			return err
 		}
 
 		if host.ID != u.PDS && u.PDS != 0 {
-			log.Warnw("received event for repo from different pds than expected", "repo", evt.Repo, "expPds", u.PDS, "gotPds", host.Host)
+			log.Warn("received event for repo from different pds than expected", "repo", evt.Repo, "expPds", u.PDS, "gotPds", host.Host)
 			// Flush any cached DID documents for this user
 			bgs.didr.FlushCacheFor(env.RepoCommit.Repo)
 
+			// if the account has migrated to this host, this moves it over
 			subj, err := bgs.createExternalUser(ctx, evt.Repo)
 			if err != nil {
 				return err
@@ -853,6 +1149,35 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 			if subj.PDS != host.ID {
 				return fmt.Errorf("event from non-authoritative pds")
 			}
+
+			// the old host's view of the account's status no longer applies
+			u, err = bgs.lookupUserByDid(ctx, evt.Repo)
+			if err != nil {
+				return fmt.Errorf("looking up migrated event user: %w", err)
+			}
+		}
+
+		span.SetAttributes(attribute.String("upstream_status", u.UpstreamStatus))
+
+		if u.TakenDown || u.UpstreamStatus == events.AccountStatusTakendown {
+			span.SetAttributes(attribute.Bool("taken_down_by_relay_admin", u.TakenDown))
+			eventLog.Debug("dropping commit event from taken down user", "did", evt.Repo, "seq", evt.Seq, "host", host.Host)
+			return nil
+		}
+
+		if u.Suspended || u.UpstreamStatus == events.AccountStatusSuspended {
+			span.SetAttributes(attribute.Bool("suspended_by_relay_admin", u.Suspended))
+			eventLog.Debug("dropping commit event from suspended user", "did", evt.Repo, "seq", evt.Seq, "host", host.Host)
+			return nil
+		}
+
+		if u.UpstreamStatus == events.AccountStatusDeactivated {
+			eventLog.Debug("dropping commit event from deactivated user", "did", evt.Repo, "seq", evt.Seq, "host", host.Host)
+			return nil
+		}
+
+		if evt.Rebase {
+			return fmt.Errorf("rebase was true in event seq:%d,host:%s", evt.Seq, host.Host)
 		}
 
 		if u.Tombstoned {
@@ -861,14 +1186,26 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 			if err := bgs.db.Model(&User{}).Where("id = ?", u.ID).UpdateColumn("tombstoned", false).Error; err != nil {
 				return fmt.Errorf("failed to un-tombstone a user: %w", err)
 			}
+			bgs.accountStatusChanged(u.Did)
 
-			ai, err := bgs.Index.LookupUser(ctx, u.ID)
-			if err != nil {
-				return fmt.Errorf("failed to look up user (tombstone recover): %w", err)
+			if !bgs.nonArchival {
+				ai, err := bgs.Index.LookupUser(ctx, u.ID)
+				if err != nil {
+					return fmt.Errorf("failed to look up user (tombstone recover): %w", err)
+				}
+
+				// Now a simple re-crawl should suffice to bring the user back online
+				return bgs.Index.Crawler.AddToCatchupQueue(ctx, host, ai, evt)
 			}
+		}
 
-			// Now a simple re-crawl should suffice to bring the user back online
-			return bgs.Index.Crawler.AddToCatchupQueue(ctx, host, ai, evt)
+		if bgs.nonArchival {
+			if err := bgs.handleNonArchivalCommit(ctx, host, u, evt); err != nil {
+				eventLog.Warn("failed handling event", "err", err, "host", host.Host, "seq", evt.Seq, "repo", u.Did, "commit", evt.Commit.String())
+				return fmt.Errorf("handle user event failed: %w", err)
+			}
+			bgs.mirrorBlobs(ctx, host, evt)
+			return nil
 		}
 
 		// skip the fast path for rebases or if the user is already in the slow path
@@ -890,8 +1227,8 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 			return bgs.Index.Crawler.AddToCatchupQueue(ctx, host, ai, evt)
 		}
 
-		if err := bgs.repoman.HandleExternalUserEvent(ctx, host.ID, u.ID, u.Did, evt.Since, evt.Rev, evt.Blocks, evt.Ops); err != nil {
-			log.Warnw("failed handling event", "err", err, "host", host.Host, "seq", evt.Seq, "repo", u.Did, "prev", stringLink(evt.Prev), "commit", evt.Commit.String())
+		if err := bgs.repoman.HandleExternalUserEventWithValidation(ctx, host.ID, u.ID, u.Did, evt.Since, evt.Rev, evt.Blocks, evt.Ops, validationLevel(bgs.slurper.HostTrust(host.ID))); err != nil {
+			eventLog.Warn("failed handling event", "err", err, "host", host.Host, "seq", evt.Seq, "repo", u.Did, "prev", stringLink(evt.Prev), "commit", evt.Commit.String())
 
 			if errors.Is(err, carstore.ErrRepoBaseMismatch) || ipld.IsNotFound(err) {
 				ai, lerr := bgs.Index.LookupUser(ctx, u.ID)
@@ -907,9 +1244,10 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 			return fmt.Errorf("handle user event failed: %w", err)
 		}
 
+		bgs.mirrorBlobs(ctx, host, evt)
 		return nil
 	case env.RepoHandle != nil:
-		log.Infow("bgs got repo handle event", "did", env.RepoHandle.Did, "handle", env.RepoHandle.Handle)
+		log.Info("bgs got repo handle event", "did", env.RepoHandle.Did, "handle", env.RepoHandle.Handle)
 		// Flush any cached DID documents for this user
 		bgs.didr.FlushCacheFor(env.RepoHandle.Did)
 
@@ -920,7 +1258,7 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 		}
 
 		if act.Handle.String != env.RepoHandle.Handle {
-			log.Warnw("handle update did not update handle to asserted value", "did", env.RepoHandle.Did, "expected", env.RepoHandle.Handle, "actual", act.Handle)
+			log.Warn("handle update did not update handle to asserted value", "did", env.RepoHandle.Did, "expected", env.RepoHandle.Handle, "actual", act.Handle)
 		}
 
 		// TODO: Update the ReposHandle event type to include "verified" or something
@@ -934,18 +1272,28 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 			},
 		})
 		if err != nil {
-			log.Errorw("failed to broadcast RepoHandle event", "error", err, "did", env.RepoHandle.Did, "handle", env.RepoHandle.Handle)
+			log.Error("failed to broadcast RepoHandle event", "error", err, "did", env.RepoHandle.Did, "handle", env.RepoHandle.Handle)
 			return fmt.Errorf("failed to broadcast RepoHandle event: %w", err)
 		}
 
 		return nil
 	case env.RepoIdentity != nil:
-		log.Infow("bgs got identity event", "did", env.RepoIdentity.Did)
+		log.Info("bgs got identity event", "did", env.RepoIdentity.Did)
+		if bgs.newcomers != nil {
+			u, err := bgs.lookupEventUser(ctx, env.RepoIdentity.Did)
+			if err != nil {
+				return err
+			}
+			if bgs.newcomers.newAccountThrottled(host, u, env.RepoIdentity.Did, "identity") {
+				return nil
+			}
+		}
+
 		// Flush any cached DID documents for this user
 		bgs.didr.FlushCacheFor(env.RepoIdentity.Did)
 
 		// Refetch the DID doc and update our cached keys and handle etc.
-		_, err := bgs.createExternalUser(ctx, env.RepoIdentity.Did)
+		act, err := bgs.createExternalUser(ctx, env.RepoIdentity.Did)
 		if err != nil {
 			return err
 		}
@@ -956,11 +1304,11 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 				Did:    env.RepoIdentity.Did,
 				Seq:    env.RepoIdentity.Seq,
 				Time:   env.RepoIdentity.Time,
-				Handle: env.RepoIdentity.Handle,
+				Handle: eventHandle(act.Handle, act.ValidHandle),
 			},
 		})
 		if err != nil {
-			log.Errorw("failed to broadcast Identity event", "error", err, "did", env.RepoIdentity.Did)
+			log.Error("failed to broadcast Identity event", "error", err, "did", env.RepoIdentity.Did)
 			return fmt.Errorf("failed to broadcast Identity event: %w", err)
 		}
 
@@ -976,7 +1324,15 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 			span.SetAttributes(attribute.String("repo_status", *env.RepoAccount.Status))
 		}
 
-		log.Infow("bgs got account event", "did", env.RepoAccount.Did)
+		log.Info("bgs got account event", "did", env.RepoAccount.Did)
+		u, err := bgs.lookupEventUser(ctx, env.RepoAccount.Did)
+		if err != nil {
+			return err
+		}
+		if bgs.newcomers.newAccountThrottled(host, u, env.RepoAccount.Did, "account") {
+			return nil
+		}
+
 		// Flush any cached DID documents for this user
 		bgs.didr.FlushCacheFor(env.RepoAccount.Did)
 
@@ -990,7 +1346,7 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 		// Check if the PDS is still authoritative
 		// if not we don't want to be propagating this account event
 		if ai.PDS != host.ID {
-			log.Errorw("account event from non-authoritative pds",
+			log.Error("account event from non-authoritative pds",
 				"seq", env.RepoAccount.Seq,
 				"did", env.RepoAccount.Did,
 				"event_from", host.Host,
@@ -1014,14 +1370,17 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 
 		shouldBeActive := env.RepoAccount.Active
 		status := env.RepoAccount.Status
-		u, err := bgs.lookupUserByDid(ctx, env.RepoAccount.Did)
-		if err != nil {
-			return fmt.Errorf("failed to look up user by did: %w", err)
+		if u == nil {
+			// just created, so not taken down or suspended by the relay
+			u = &User{}
 		}
 
 		if u.TakenDown {
 			shouldBeActive = false
 			status = &events.AccountStatusTakendown
+		} else if u.Suspended {
+			shouldBeActive = false
+			status = &events.AccountStatusSuspended
 		}
 
 		// Broadcast the account event to all consumers
@@ -1035,7 +1394,7 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 			},
 		})
 		if err != nil {
-			log.Errorw("failed to broadcast Account event", "error", err, "did", env.RepoAccount.Did)
+			log.Error("failed to broadcast Account event", "error", err, "did", env.RepoAccount.Did)
 			return fmt.Errorf("failed to broadcast Account event: %w", err)
 		}
 
@@ -1073,6 +1432,7 @@ func (bgs *BGS) handleRepoTombstone(ctx context.Context, pds *models.PDS, evt *a
 	}).Error; err != nil {
 		return err
 	}
+	bgs.accountStatusChanged(u.Did)
 
 	if err := bgs.db.Model(&models.ActorInfo{}).Where("uid = ?", u.ID).UpdateColumns(map[string]any{
 		"handle": nil,
@@ -1083,7 +1443,7 @@ func (bgs *BGS) handleRepoTombstone(ctx context.Context, pds *models.PDS, evt *a
 	// delete data from carstore
 	if err := bgs.repoman.TakeDownRepo(ctx, u.ID); err != nil {
 		// don't let a failure here prevent us from propagating this event
-		log.Errorf("failed to delete user data from carstore: %s", err)
+		log.Error("failed to delete user data from carstore", "err", err)
 	}
 
 	return bgs.events.AddEvent(ctx, &events.XRPCStreamEvent{
@@ -1098,7 +1458,7 @@ func (s *BGS) createExternalUser(ctx context.Context, did string) (*models.Actor
 
 	externalUserCreationAttempts.Inc()
 
-	log.Debugf("create external user: %s", did)
+	log.Debug("create external user", "did", did)
 	doc, err := s.didr.GetDocument(ctx, did)
 	if err != nil {
 		return nil, fmt.Errorf("could not locate DID document for followed user (%s): %w", did, err)
@@ -1121,7 +1481,7 @@ func (s *BGS) createExternalUser(ctx context.Context, did string) (*models.Actor
 	// TODO: the PDS's DID should also be in the service, we could use that to look up?
 	var peering models.PDS
 	if err := s.db.Find(&peering, "host = ?", durl.Host).Error; err != nil {
-		log.Error("failed to find pds", durl.Host)
+		log.Error("failed to find pds", "host", durl.Host, "err", err)
 		return nil, err
 	}
 
@@ -1194,69 +1554,62 @@ func (s *BGS) createExternalUser(ctx context.Context, did string) (*models.Actor
 	defer func() {
 		if !successfullyCreated {
 			if err := s.db.Model(&models.PDS{}).Where("id = ?", peering.ID).Update("repo_count", gorm.Expr("repo_count - 1")).Error; err != nil {
-				log.Errorf("failed to decrement repo count for pds: %s", err)
+				log.Error("failed to decrement repo count for pds", "err", err)
 			}
 		}
 	}()
 
-	if len(doc.AlsoKnownAs) == 0 {
-		return nil, fmt.Errorf("user has no 'known as' field in their DID document")
-	}
-
-	hurl, err := url.Parse(doc.AlsoKnownAs[0])
+	handle, err := claimedHandle(doc)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Debugw("creating external user", "did", did, "handle", hurl.Host, "pds", peering.ID)
-
-	handle := hurl.Host
-
-	validHandle := true
-
-	resdid, err := s.hr.ResolveHandleToDid(ctx, handle)
-	if err != nil {
-		log.Errorf("failed to resolve users claimed handle (%q) on pds: %s", handle, err)
-		validHandle = false
-	}
+	log.Debug("creating external user", "did", did, "handle", handle, "pds", peering.ID)
 
-	if resdid != did {
-		log.Errorf("claimed handle did not match servers response (%s != %s)", resdid, did)
-		validHandle = false
-	}
+	validHandle := s.verifyHandle(ctx, did, handle)
 
 	s.extUserLk.Lock()
-	defer s.extUserLk.Unlock()
+	locked := true
+	defer func() {
+		if locked {
+			s.extUserLk.Unlock()
+		}
+	}()
 
 	exu, err := s.Index.LookupUserByDid(ctx, did)
 	if err == nil {
-		log.Debugw("lost the race to create a new user", "did", did, "handle", handle, "existing_hand", exu.Handle)
+		log.Debug("lost the race to create a new user", "did", did, "handle", handle, "existing_hand", exu.Handle)
+		migrated := false
 		if exu.PDS != peering.ID {
-			// User is now on a different PDS, update
-			if err := s.db.Model(User{}).Where("id = ?", exu.Uid).Update("pds", peering.ID).Error; err != nil {
-				return nil, fmt.Errorf("failed to update users pds: %w", err)
+			// User is now on a different PDS, move them over
+			if err := s.migrateAccount(ctx, exu, &peering); err != nil {
+				return nil, fmt.Errorf("failed to migrate account to new pds: %w", err)
 			}
 
-			if err := s.db.Model(models.ActorInfo{}).Where("uid = ?", exu.Uid).Update("pds", peering.ID).Error; err != nil {
-				return nil, fmt.Errorf("failed to update users pds on actorInfo: %w", err)
-			}
+			// the account now counts towards the new PDS's repo limit
+			successfullyCreated = true
+			migrated = true
+		}
 
-			exu.PDS = peering.ID
+		// the handle may have changed, or been fixed or broken, since we last verified it
+		if err := s.recordHandleCheck(ctx, exu.Uid, handle, validHandle); err != nil {
+			return nil, err
 		}
+		if err := s.recordIdentity(ctx, did, handle, doc); err != nil {
+			log.Error("failed to record identity history", "did", did, "err", err)
+		}
+		exu.Handle = sql.NullString{String: handle, Valid: validHandle}
+		exu.ValidHandle = validHandle
 
-		if exu.Handle.String != handle {
-			// Users handle has changed, update
-			if err := s.db.Model(User{}).Where("id = ?", exu.Uid).Update("handle", handle).Error; err != nil {
-				return nil, fmt.Errorf("failed to update users handle: %w", err)
-			}
+		if migrated {
+			locked = false
+			s.extUserLk.Unlock()
 
-			// Update ActorInfos
-			if err := s.db.Model(models.ActorInfo{}).Where("uid = ?", exu.Uid).Update("handle", handle).Error; err != nil {
-				return nil, fmt.Errorf("failed to update actorInfos handle: %w", err)
+			if err := s.finishMigration(ctx, exu, &peering, c); err != nil {
+				return nil, fmt.Errorf("failed to finish migrating account to new pds: %w", err)
 			}
-
-			exu.Handle = sql.NullString{String: handle, Valid: true}
 		}
+
 		return exu, nil
 	}
 
@@ -1265,10 +1618,12 @@ func (s *BGS) createExternalUser(ctx context.Context, did string) (*models.Actor
 	}
 
 	// TODO: request this users info from their server to fill out our data...
+	checkedAt := time.Now()
 	u := User{
-		Did:         did,
-		PDS:         peering.ID,
-		ValidHandle: validHandle,
+		Did:             did,
+		PDS:             peering.ID,
+		ValidHandle:     validHandle,
+		HandleCheckedAt: &checkedAt,
 	}
 	if validHandle {
 		u.Handle = sql.NullString{String: handle, Valid: true}
@@ -1323,8 +1678,19 @@ func (s *BGS) createExternalUser(ctx context.Context, did string) (*models.Actor
 		return nil, err
 	}
 
+	if !validHandle {
+		// gorm substitutes the column default (true) for a false value on create, so set it explicitly
+		if err := s.recordHandleCheck(ctx, u.ID, handle, false); err != nil {
+			return nil, err
+		}
+	}
+
 	successfullyCreated = true
 
+	if err := s.recordIdentity(ctx, did, handle, doc); err != nil {
+		log.Error("failed to record identity history", "did", did, "err", err)
+	}
+
 	return subj, nil
 }
 
@@ -1341,6 +1707,7 @@ func (bgs *BGS) UpdateAccountStatus(ctx context.Context, did string, status stri
 	if err != nil {
 		return err
 	}
+	defer bgs.accountStatusChanged(u.Did)
 
 	switch status {
 	case events.AccountStatusActive:
@@ -1384,7 +1751,7 @@ func (bgs *BGS) UpdateAccountStatus(ctx context.Context, did string, status stri
 		// delete data from carstore
 		if err := bgs.repoman.TakeDownRepo(ctx, u.ID); err != nil {
 			// don't let a failure here prevent us from propagating this event
-			log.Errorf("failed to delete user data from carstore: %s", err)
+			log.Error("failed to delete user data from carstore", "err", err)
 		}
 	}
 
@@ -1400,6 +1767,7 @@ func (bgs *BGS) TakeDownRepo(ctx context.Context, did string) error {
 	if err := bgs.db.Model(User{}).Where("id = ?", u.ID).Update("taken_down", true).Error; err != nil {
 		return err
 	}
+	bgs.accountStatusChanged(u.Did)
 
 	if err := bgs.repoman.TakeDownRepo(ctx, u.ID); err != nil {
 		return err
@@ -1409,20 +1777,13 @@ func (bgs *BGS) TakeDownRepo(ctx context.Context, did string) error {
 		return err
 	}
 
-	return nil
+	// after the account's past events are removed, so this one stays
+	return bgs.announceAccountStatus(ctx, u.Did)
 }
 
+// ReverseTakedown is an alias of ReinstateRepo, which also lifts suspensions
 func (bgs *BGS) ReverseTakedown(ctx context.Context, did string) error {
-	u, err := bgs.lookupUserByDid(ctx, did)
-	if err != nil {
-		return err
-	}
-
-	if err := bgs.db.Model(User{}).Where("id = ?", u.ID).Update("taken_down", false).Error; err != nil {
-		return err
-	}
-
-	return nil
+	return bgs.ReinstateRepo(ctx, did)
 }
 
 type revCheckResult struct {
@@ -1489,6 +1850,11 @@ func (bgs *BGS) CompleteResync(resync PDSResync) {
 func (bgs *BGS) ResyncPDS(ctx context.Context, pds models.PDS) error {
 	ctx, span := tracer.Start(ctx, "ResyncPDS")
 	defer span.End()
+
+	if bgs.nonArchival {
+		return errNonArchival
+	}
+
 	log := log.With("pds", pds.Host, "source", "resync_pds")
 	resync, found := bgs.LoadOrStoreResync(pds)
 	if found {
@@ -1506,10 +1872,12 @@ func (bgs *BGS) ResyncPDS(ctx context.Context, pds models.PDS) error {
 	}
 	host += pds.Host
 
-	xrpcc := xrpc.Client{Host: host}
+	xrpcc := xrpc.Client{
+		Host:        host,
+		RateLimiter: ratelimit.NewTokenBucket(50, 1),
+	}
 	bgs.Index.ApplyPDSClientSettings(&xrpcc)
 
-	limiter := rate.NewLimiter(rate.Limit(50), 1)
 	cursor := ""
 	limit := int64(500)
 
@@ -1521,18 +1889,14 @@ func (bgs *BGS) ResyncPDS(ctx context.Context, pds models.PDS) error {
 	for {
 		pages++
 		if pages%10 == 0 {
-			log.Warnw("fetching PDS page during resync", "pages", pages, "total_repos", len(repos))
+			log.Warn("fetching PDS page during resync", "pages", pages, "total_repos", len(repos))
 			resync.NumRepoPages = pages
 			resync.NumRepos = len(repos)
 			bgs.UpdateResync(resync)
 		}
-		if err := limiter.Wait(ctx); err != nil {
-			log.Errorw("failed to wait for rate limiter", "error", err)
-			return fmt.Errorf("failed to wait for rate limiter: %w", err)
-		}
 		repoList, err := comatproto.SyncListRepos(ctx, &xrpcc, cursor, limit)
 		if err != nil {
-			log.Errorw("failed to list repos", "error", err)
+			log.Error("failed to list repos", "error", err)
 			return fmt.Errorf("failed to list repos: %w", err)
 		}
 
@@ -1554,7 +1918,7 @@ func (bgs *BGS) ResyncPDS(ctx context.Context, pds models.PDS) error {
 
 	repolistDone := time.Now()
 
-	log.Warnw("listed all repos, checking roots", "num_repos", len(repos), "took", repolistDone.Sub(start))
+	log.Warn("listed all repos, checking roots", "num_repos", len(repos), "took", repolistDone.Sub(start))
 	resync = bgs.SetResyncStatus(pds.ID, "checking revs")
 
 	// Create a buffered channel for collecting results
@@ -1564,7 +1928,7 @@ func (bgs *BGS) ResyncPDS(ctx context.Context, pds models.PDS) error {
 	// Check repo revs against our local copy and enqueue crawls for any that are out of date
 	for _, r := range repos {
 		if err := sem.Acquire(ctx, 1); err != nil {
-			log.Errorw("failed to acquire semaphore", "error", err)
+			log.Error("failed to acquire semaphore", "error", err)
 			results <- revCheckResult{err: err}
 			continue
 		}
@@ -1574,20 +1938,20 @@ func (bgs *BGS) ResyncPDS(ctx context.Context, pds models.PDS) error {
 			// Fetches the user if we have it, otherwise automatically enqueues it for crawling
 			ai, err := bgs.Index.GetUserOrMissing(ctx, r.Did)
 			if err != nil {
-				log.Errorw("failed to get user while resyncing PDS, we can't recrawl it", "error", err)
+				log.Error("failed to get user while resyncing PDS, we can't recrawl it", "error", err)
 				results <- revCheckResult{err: err}
 				return
 			}
 
 			rev, err := bgs.repoman.GetRepoRev(ctx, ai.Uid)
 			if err != nil {
-				log.Warnw("recrawling because we failed to get the local repo root", "err", err, "uid", ai.Uid)
+				log.Warn("recrawling because we failed to get the local repo root", "err", err, "uid", ai.Uid)
 				results <- revCheckResult{ai: ai}
 				return
 			}
 
 			if rev == "" || rev < r.Rev {
-				log.Warnw("recrawling because the repo rev from the PDS is newer than our local repo rev", "local_rev", rev)
+				log.Warn("recrawling because the repo rev from the PDS is newer than our local repo rev", "local_rev", rev)
 				results <- revCheckResult{ai: ai}
 				return
 			}
@@ -1600,19 +1964,19 @@ func (bgs *BGS) ResyncPDS(ctx context.Context, pds models.PDS) error {
 	for i := 0; i < len(repos); i++ {
 		res := <-results
 		if res.err != nil {
-			log.Errorw("failed to process repo during resync", "error", res.err)
+			log.Error("failed to process repo during resync", "error", res.err)
 
 		}
 		if res.ai != nil {
 			numReposToResync++
 			err := bgs.Index.Crawler.Crawl(ctx, res.ai)
 			if err != nil {
-				log.Errorw("failed to enqueue crawl for repo during resync", "error", err, "uid", res.ai.Uid, "did", res.ai.Did)
+				log.Error("failed to enqueue crawl for repo during resync", "error", err, "uid", res.ai.Uid, "did", res.ai.Did)
 			}
 		}
 		if i%100 == 0 {
 			if i%10_000 == 0 {
-				log.Warnw("checked revs during resync", "num_repos_checked", i, "num_repos_to_crawl", numReposToResync, "took", time.Now().Sub(resync.StatusChangedAt))
+				log.Warn("checked revs during resync", "num_repos_checked", i, "num_repos_to_crawl", numReposToResync, "took", time.Now().Sub(resync.StatusChangedAt))
 			}
 			resync.NumReposChecked = i
 			resync.NumReposToResync = numReposToResync
@@ -1624,7 +1988,7 @@ func (bgs *BGS) ResyncPDS(ctx context.Context, pds models.PDS) error {
 	resync.NumReposToResync = numReposToResync
 	bgs.UpdateResync(resync)
 
-	log.Warnw("enqueued all crawls, exiting resync", "took", time.Now().Sub(start), "num_repos_to_crawl", numReposToResync)
+	log.Warn("enqueued all crawls, exiting resync", "took", time.Now().Sub(start), "num_repos_to_crawl", numReposToResync)
 
 	return nil
 }
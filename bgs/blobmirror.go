@@ -0,0 +1,77 @@
+package bgs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/blobmirror"
+	"github.com/bluesky-social/indigo/models"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
+)
+
+// mirrorBlobs queues the blobs referenced by a commit which has been accepted from a host, if blob mirroring is configured
+func (bgs *BGS) mirrorBlobs(ctx context.Context, host *models.PDS, evt *comatproto.SyncSubscribeRepos_Commit) {
+	if bgs.blobMirror == nil {
+		return
+	}
+	if err := bgs.blobMirror.HandleCommit(ctx, models.ClientForPds(host).Host, evt); err != nil {
+		log.Warn("failed to queue blobs for mirroring", "err", err, "host", host.Host, "repo", evt.Repo, "seq", evt.Seq)
+	}
+}
+
+// HandleComAtprotoSyncGetBlob serves mirrored blobs. It is only routed when blob mirroring is configured
+func (bgs *BGS) HandleComAtprotoSyncGetBlob(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoSyncGetBlob")
+	defer span.End()
+
+	did := c.QueryParam("did")
+	if _, err := syntax.ParseDID(did); err != nil {
+		return c.JSON(http.StatusBadRequest, XRPCError{Message: fmt.Sprintf("invalid did: %s", did)})
+	}
+	bc, err := cid.Decode(c.QueryParam("cid"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, XRPCError{Message: fmt.Sprintf("invalid cid: %s", c.QueryParam("cid"))})
+	}
+
+	u, err := bgs.lookupUserByDid(ctx, did)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "user not found")
+		}
+		log.Error("failed to lookup user", "err", err, "did", did)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to lookup user")
+	}
+	if err := accountUnavailable(u); err != nil {
+		return err
+	}
+
+	rc, b, err := bgs.blobMirror.GetBlob(ctx, did, bc)
+	if err != nil {
+		if errors.Is(err, blobmirror.ErrBlobNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "blob not found")
+		}
+		log.Error("failed to get mirrored blob", "err", err, "did", did, "cid", bc)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get blob")
+	}
+	defer rc.Close()
+
+	// blobs are arbitrary user content, never to be rendered as part of this site
+	h := c.Response().Header()
+	h.Set("Content-Length", strconv.FormatInt(b.Size, 10))
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	mimeType := b.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return c.Stream(http.StatusOK, mimeType, rc)
+}
@@ -0,0 +1,171 @@
+package bgs
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/models"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/ipld/go-car"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// MaxBlockFetch is the most blocks which can be requested in one batch block fetch
+const MaxBlockFetch = 1000
+
+var blockFetchRequested = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_block_fetch_requested",
+	Help: "The total number of blocks requested from the internal block fetch API",
+})
+
+var blockFetchServed = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_block_fetch_served",
+	Help: "The total number of blocks served by the internal block fetch API",
+})
+
+var blockFetchBytes = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_block_fetch_bytes",
+	Help: "The total size of blocks served by the internal block fetch API, before compression",
+})
+
+// BlockFetchRequest is the body of a batch block fetch, to /internal/blocks
+type BlockFetchRequest struct {
+	// CIDs of the blocks, in any accounts' repos, up to MaxBlockFetch
+	Cids []string `json:"cids"`
+}
+
+// checkInternalAuth is middleware for routes used by trusted internal services, which accepts the configured internal tokens as well as admin tokens
+func (bgs *BGS) checkInternalAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	admin := bgs.checkAdminAuth(next)
+	return func(e echo.Context) error {
+		authheader := e.Request().Header.Get("Authorization")
+		pref := "Bearer "
+		if !strings.HasPrefix(authheader, pref) {
+			return echo.ErrForbidden
+		}
+
+		token := []byte(authheader[len(pref):])
+		if len(bytes.TrimSpace(token)) == 0 {
+			return echo.ErrForbidden
+		}
+		for _, tok := range bgs.internalTokens {
+			if subtle.ConstantTimeCompare(token, []byte(tok)) == 1 {
+				return next(e)
+			}
+		}
+
+		return admin(e)
+	}
+}
+
+// cleanInternalTokens trims the configured internal tokens, dropping empty ones (eg, from an empty or trailing-comma RELAY_INTERNAL_TOKENS) so an empty bearer token can't match them
+func cleanInternalTokens(toks []string) []string {
+	var out []string
+	for _, tok := range toks {
+		tok = strings.TrimSpace(tok)
+		if tok != "" {
+			out = append(out, tok)
+		}
+	}
+	return out
+}
+
+// handleInternalFetchBlocks serves up to MaxBlockFetch blocks from any accounts' repos in the carstore, as a CAR file with no roots, so internal services can hydrate records without downloading whole repos. Blocks which aren't stored, or are only stored in the repos of inactive accounts, are left out rather than failing the request; callers compare the blocks they get back against the ones they asked for. The response is gzipped for clients which accept it.
+func (bgs *BGS) handleInternalFetchBlocks(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "handleInternalFetchBlocks")
+	defer span.End()
+
+	var body BlockFetchRequest
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid body")
+	}
+	if len(body.Cids) > MaxBlockFetch {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("too many cids requested (max %d)", MaxBlockFetch))
+	}
+	seen := make(map[cid.Cid]bool, len(body.Cids))
+	cids := make([]cid.Cid, 0, len(body.Cids))
+	for _, s := range body.Cids {
+		k, err := cid.Decode(s)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid cid: %s", s))
+		}
+		if !seen[k] {
+			seen[k] = true
+			cids = append(cids, k)
+		}
+	}
+	blockFetchRequested.Add(float64(len(cids)))
+
+	cs := bgs.repoman.CarStore()
+	locs, err := cs.LocateBlocks(ctx, cids)
+	if err != nil {
+		log.Error("failed to locate blocks", "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to locate blocks")
+	}
+
+	// only blocks held by an active account are served, so content from taken down or deleted accounts isn't hydrated
+	uids := make(map[models.Uid]bool)
+	for _, loc := range locs {
+		uids[loc.Usr] = true
+	}
+	ids := make([]models.Uid, 0, len(uids))
+	for uid := range uids {
+		ids = append(ids, uid)
+	}
+	var users []User
+	if len(ids) > 0 {
+		if err := bgs.db.WithContext(ctx).Model(&User{}).Select("id", "taken_down", "tombstoned", "suspended", "upstream_status").Find(&users, "id IN ?", ids).Error; err != nil {
+			log.Error("failed to look up block owners", "err", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to look up block owners")
+		}
+	}
+	active := make(map[models.Uid]bool, len(users))
+	for i := range users {
+		active[users[i].ID] = accountUnavailable(&users[i]) == nil
+	}
+
+	// one copy of each block is enough
+	picked := make([]carstore.BlockLocation, 0, len(cids))
+	for _, loc := range locs {
+		if active[loc.Usr] && seen[loc.Cid] {
+			picked = append(picked, loc)
+			seen[loc.Cid] = false
+		}
+	}
+
+	hb, err := cbor.DumpObject(&car.CarHeader{
+		Roots:   []cid.Cid{},
+		Version: 1,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/vnd.ipld.car")
+	c.Response().WriteHeader(http.StatusOK)
+	if _, err := carstore.LdWrite(c.Response(), hb); err != nil {
+		return err
+	}
+	if err := cs.ReadBlocks(ctx, picked, func(_ carstore.BlockLocation, blk blockformat.Block) error {
+		if _, err := carstore.LdWrite(c.Response(), blk.Cid().Bytes(), blk.RawData()); err != nil {
+			return err
+		}
+		blockFetchServed.Inc()
+		blockFetchBytes.Add(float64(len(blk.RawData())))
+		return nil
+	}); err != nil {
+		// the response has started, so the client sees a truncated CAR file
+		log.Error("failed to read blocks", "err", err)
+		return nil
+	}
+	return nil
+}
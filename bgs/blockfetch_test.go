@@ -0,0 +1,43 @@
+package bgs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanInternalTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Empty(cleanInternalTokens(nil))
+	assert.Empty(cleanInternalTokens([]string{""}))
+	assert.Empty(cleanInternalTokens([]string{"", "  ", "\t"}))
+	assert.Equal([]string{"abc", "def"}, cleanInternalTokens([]string{"abc", "", " def ", ""}))
+}
+
+func TestInternalAuthRejectsEmptyTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	// as configured from RELAY_INTERNAL_TOKENS="secret," or an empty RELAY_INTERNAL_TOKENS
+	bgs := &BGS{internalTokens: cleanInternalTokens([]string{"secret", "", " "})}
+	handler := bgs.checkInternalAuth(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	e := echo.New()
+	call := func(authheader string) error {
+		req := httptest.NewRequest(http.MethodPost, "/internal/blocks", nil)
+		if authheader != "" {
+			req.Header.Set("Authorization", authheader)
+		}
+		return handler(e.NewContext(req, httptest.NewRecorder()))
+	}
+
+	assert.NoError(call("Bearer secret"))
+	for _, authheader := range []string{"", "Bearer", "Bearer ", "Bearer  ", "Bearer \t"} {
+		assert.ErrorIs(call(authheader), echo.ErrForbidden, "%q", authheader)
+	}
+}
@@ -0,0 +1,138 @@
+package bgs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/models"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// CompactionRecord is one finished compaction attempt, successful or not
+type CompactionRecord struct {
+	UID models.Uid `json:"uid"`
+	DID string     `json:"did"`
+	// Queue tier the repo was compacted from, or "admin" for compactions run by an admin request
+	Priority string `json:"priority"`
+	Fast     bool   `json:"fast"`
+	// Compactor worker which ran the compaction, or -1 for admin requests
+	Worker     int       `json:"worker"`
+	Started    time.Time `json:"started"`
+	DurationMs int64     `json:"duration_ms"`
+	// "done", or the step that failed: "failed_getting_user" or "failed_compacting"
+	Status string                    `json:"status"`
+	Error  string                    `json:"error,omitempty"`
+	Stats  *carstore.CompactionStats `json:"stats,omitempty"`
+}
+
+// UserCompactionStats are the totals of a repo's compactions since startup
+type UserCompactionStats struct {
+	UID           models.Uid `json:"uid"`
+	DID           string     `json:"did"`
+	Compactions   int64      `json:"compactions"`
+	Failures      int64      `json:"failures"`
+	ShardsDeleted int64      `json:"shards_deleted"`
+	NewShards     int64      `json:"new_shards"`
+	SkippedShards int64      `json:"skipped_shards"`
+	Refs          int64      `json:"refs"`
+	Dupes         int64      `json:"dupes"`
+	LastCompacted time.Time  `json:"last_compacted"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// compactionHistory keeps the most recent compaction records in a ring buffer, and per-repo totals for the most recently compacted repos
+type compactionHistory struct {
+	lk      sync.Mutex
+	records []CompactionRecord
+	// next is the ring position the next record is written to; the buffer is full once len(records) reaches its capacity
+	next  int
+	users *lru.Cache[models.Uid, *UserCompactionStats]
+}
+
+func newCompactionHistory(size, users int) *compactionHistory {
+	h := &compactionHistory{
+		records: make([]CompactionRecord, 0, max(size, 0)),
+	}
+	if users > 0 {
+		h.users, _ = lru.New[models.Uid, *UserCompactionStats](users)
+	}
+	return h
+}
+
+func (h *compactionHistory) add(rec CompactionRecord) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	if h.users != nil {
+		us, ok := h.users.Get(rec.UID)
+		if !ok {
+			us = &UserCompactionStats{UID: rec.UID}
+			h.users.Add(rec.UID, us)
+		}
+		if rec.DID != "" && rec.DID != "unknown" {
+			us.DID = rec.DID
+		}
+		us.LastCompacted = rec.Started
+		if rec.Stats == nil {
+			us.Failures++
+			us.LastError = rec.Error
+		} else {
+			us.Compactions++
+			us.ShardsDeleted += int64(rec.Stats.ShardsDeleted)
+			us.NewShards += int64(rec.Stats.NewShards)
+			us.SkippedShards += int64(rec.Stats.SkippedShards)
+			us.Refs += int64(rec.Stats.TotalRefs)
+			us.Dupes += int64(rec.Stats.DupeCount)
+			us.LastError = ""
+		}
+	}
+
+	if cap(h.records) == 0 {
+		return
+	}
+	if len(h.records) < cap(h.records) {
+		h.records = append(h.records, rec)
+	} else {
+		h.records[h.next] = rec
+	}
+	h.next = (h.next + 1) % cap(h.records)
+}
+
+// list returns up to limit records (all of them if limit is 0) started at or after since, newest first. If uid is non-zero, only that repo's compactions are returned
+func (h *compactionHistory) list(since time.Time, uid models.Uid, limit int) []CompactionRecord {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	out := []CompactionRecord{}
+	for i := range len(h.records) {
+		rec := h.records[(h.next-1-i+2*len(h.records))%len(h.records)]
+		if rec.Started.Before(since) {
+			// records are added as compactions finish, so an older one can follow a newer one; keep going rather than stopping here
+			continue
+		}
+		if uid != 0 && rec.UID != uid {
+			continue
+		}
+		out = append(out, rec)
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out
+}
+
+func (h *compactionHistory) user(uid models.Uid) (UserCompactionStats, bool) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	if h.users == nil {
+		return UserCompactionStats{}, false
+	}
+	us, ok := h.users.Peek(uid)
+	if !ok {
+		return UserCompactionStats{}, false
+	}
+	return *us, true
+}
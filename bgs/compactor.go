@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"math/rand/v2"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,44 +15,131 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 )
 
+// CompactionPriority is the tier of the compaction queue a repo waits in. Workers take repos from every non-empty tier, in proportion to the tiers' weights, so operator-requested compactions get ahead of the periodic sweep without stopping it.
+// Higher values are higher priorities, and the zero value is the background tier, so options that don't set a priority don't jump the queue.
+type CompactionPriority int
+
+const (
+	CompactionBackground CompactionPriority = iota
+	CompactionNormal
+	CompactionUrgent
+
+	numCompactionPriorities
+)
+
+var compactionPriorityNames = [numCompactionPriorities]string{"background", "normal", "urgent"}
+
+func (p CompactionPriority) String() string {
+	if p < 0 || p >= numCompactionPriorities {
+		return fmt.Sprintf("CompactionPriority(%d)", int(p))
+	}
+	return compactionPriorityNames[p]
+}
+
+func ParseCompactionPriority(s string) (CompactionPriority, error) {
+	for i, name := range compactionPriorityNames {
+		if s == name {
+			return CompactionPriority(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid compaction priority %q (must be urgent, normal, or background)", s)
+}
+
+// ParseCompactionPriorityWeights parses tier=weight pairs, eg "urgent=16", into weights for CompactorOptions.PriorityWeights. Tiers not given are left out, and so get their default weight
+func ParseCompactionPriorityWeights(kvs []string) (map[CompactionPriority]int, error) {
+	weights := make(map[CompactionPriority]int, len(kvs))
+	for _, kv := range kvs {
+		name, weight, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid compaction priority weight %q (expected tier=weight)", kv)
+		}
+		prio, err := ParseCompactionPriority(name)
+		if err != nil {
+			return nil, err
+		}
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 1 {
+			return nil, fmt.Errorf("invalid compaction priority weight %q (must be a positive integer)", kv)
+		}
+		weights[prio] = w
+	}
+	return weights, nil
+}
+
+// DefaultCompactionPriorityWeights serves 16 urgent repos, and 4 normal ones, for each background repo, when all tiers have repos waiting
+func DefaultCompactionPriorityWeights() map[CompactionPriority]int {
+	return map[CompactionPriority]int{
+		CompactionUrgent:     16,
+		CompactionNormal:     4,
+		CompactionBackground: 1,
+	}
+}
+
 type queueItem struct {
 	uid  models.Uid
 	fast bool
+	// tier the item was popped from
+	prio CompactionPriority
 }
 
-// uniQueue is a queue that only allows one instance of a given uid
+// uniQueue is a queue that only allows one instance of a given uid, split into priority tiers
 type uniQueue struct {
-	q       []queueItem
-	members map[models.Uid]struct{}
+	tiers   [numCompactionPriorities][]queueItem
+	weights [numCompactionPriorities]int
+	// smooth weighted round-robin state: each pop, non-empty tiers gain their weight, and the tier with the most is served and pays back the total
+	credit  [numCompactionPriorities]int
+	members map[models.Uid]CompactionPriority
 	lk      sync.Mutex
 }
 
-// Append appends a uid to the end of the queue if it doesn't already exist
-func (q *uniQueue) Append(uid models.Uid, fast bool) {
-	q.lk.Lock()
-	defer q.lk.Unlock()
-
-	if _, ok := q.members[uid]; ok {
-		return
+func newUniQueue(weights map[CompactionPriority]int) *uniQueue {
+	q := &uniQueue{
+		members: make(map[models.Uid]CompactionPriority),
 	}
-
-	q.q = append(q.q, queueItem{uid: uid, fast: fast})
-	q.members[uid] = struct{}{}
-	compactionQueueDepth.Inc()
+	defaults := DefaultCompactionPriorityWeights()
+	for p := range numCompactionPriorities {
+		w, ok := weights[p]
+		if !ok {
+			w = defaults[p]
+		}
+		q.weights[p] = max(w, 1)
+	}
+	return q
 }
 
-// Prepend prepends a uid to the beginning of the queue if it doesn't already exist
-func (q *uniQueue) Prepend(uid models.Uid, fast bool) {
+func (q *uniQueue) add(uid models.Uid, fast bool, prio CompactionPriority, front bool) {
+	prio = min(max(prio, 0), numCompactionPriorities-1)
+
 	q.lk.Lock()
 	defer q.lk.Unlock()
 
-	if _, ok := q.members[uid]; ok {
-		return
+	if cur, ok := q.members[uid]; ok {
+		if cur >= prio {
+			return
+		}
+		// already queued at a lower priority: move it up
+		q.removeLocked(uid, cur)
 	}
 
-	q.q = append([]queueItem{{uid: uid, fast: fast}}, q.q...)
-	q.members[uid] = struct{}{}
+	item := queueItem{uid: uid, fast: fast}
+	if front {
+		q.tiers[prio] = append([]queueItem{item}, q.tiers[prio]...)
+	} else {
+		q.tiers[prio] = append(q.tiers[prio], item)
+	}
+	q.members[uid] = prio
 	compactionQueueDepth.Inc()
+	compactionQueueDepthByPriority.WithLabelValues(prio.String()).Inc()
+}
+
+// Append appends a uid to the end of its priority tier if it doesn't already exist (or exists at a lower priority)
+func (q *uniQueue) Append(uid models.Uid, fast bool, prio CompactionPriority) {
+	q.add(uid, fast, prio, false)
+}
+
+// Prepend prepends a uid to the beginning of its priority tier if it doesn't already exist (or exists at a lower priority)
+func (q *uniQueue) Prepend(uid models.Uid, fast bool, prio CompactionPriority) {
+	q.add(uid, fast, prio, true)
 }
 
 // Has returns true if the queue contains the given uid
@@ -62,83 +151,153 @@ func (q *uniQueue) Has(uid models.Uid) bool {
 	return ok
 }
 
+// Len returns the number of uids in the queue
+func (q *uniQueue) Len() int {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	return len(q.members)
+}
+
+// LenByPriority returns the number of uids in each priority tier
+func (q *uniQueue) LenByPriority() map[string]int {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	out := make(map[string]int, numCompactionPriorities)
+	for p := range numCompactionPriorities {
+		out[p.String()] = len(q.tiers[p])
+	}
+	return out
+}
+
 // Remove removes the given uid from the queue
 func (q *uniQueue) Remove(uid models.Uid) {
 	q.lk.Lock()
 	defer q.lk.Unlock()
 
-	if _, ok := q.members[uid]; !ok {
-		return
+	if prio, ok := q.members[uid]; ok {
+		q.removeLocked(uid, prio)
 	}
+}
 
-	for i, item := range q.q {
+func (q *uniQueue) removeLocked(uid models.Uid, prio CompactionPriority) {
+	tier := q.tiers[prio]
+	for i, item := range tier {
 		if item.uid == uid {
-			q.q = append(q.q[:i], q.q[i+1:]...)
+			q.tiers[prio] = append(tier[:i], tier[i+1:]...)
 			break
 		}
 	}
 
 	delete(q.members, uid)
 	compactionQueueDepth.Dec()
+	compactionQueueDepthByPriority.WithLabelValues(prio.String()).Dec()
+}
+
+// nextTier picks the tier to serve next, by smooth weighted round-robin over the non-empty tiers
+func (q *uniQueue) nextTier() (CompactionPriority, bool) {
+	best := CompactionPriority(-1)
+	total := 0
+	// highest priority first, so it wins ties
+	for p := numCompactionPriorities - 1; p >= 0; p-- {
+		if len(q.tiers[p]) == 0 {
+			// an idle tier doesn't bank credit for a burst later
+			q.credit[p] = 0
+			continue
+		}
+		q.credit[p] += q.weights[p]
+		total += q.weights[p]
+		if best < 0 || q.credit[p] > q.credit[best] {
+			best = p
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	q.credit[best] -= total
+	return best, true
+}
+
+func (q *uniQueue) popped(item queueItem, prio CompactionPriority) (queueItem, bool) {
+	delete(q.members, item.uid)
+	item.prio = prio
+	compactionQueueDepth.Dec()
+	compactionQueueDepthByPriority.WithLabelValues(prio.String()).Dec()
+	return item, true
 }
 
-// Pop pops the first item off the front of the queue
+// Pop pops the first item off the front of the next tier to be served
 func (q *uniQueue) Pop() (queueItem, bool) {
 	q.lk.Lock()
 	defer q.lk.Unlock()
 
-	if len(q.q) == 0 {
+	prio, ok := q.nextTier()
+	if !ok {
 		return queueItem{}, false
 	}
 
-	item := q.q[0]
-	q.q = q.q[1:]
-	delete(q.members, item.uid)
-
-	compactionQueueDepth.Dec()
-	return item, true
+	item := q.tiers[prio][0]
+	q.tiers[prio] = q.tiers[prio][1:]
+	return q.popped(item, prio)
 }
 
-// PopRandom pops a random item off the of the queue
-// Note: this disrupts the sorted order of the queue and in-order is no longer quite in-order. The randomly popped element is replaced with the last element.
+// PopRandom pops a random item off the next tier to be served
+// Note: this disrupts the sorted order of the tier and in-order is no longer quite in-order. The randomly popped element is replaced with the last element.
 func (q *uniQueue) PopRandom() (queueItem, bool) {
 	q.lk.Lock()
 	defer q.lk.Unlock()
 
-	if len(q.q) == 0 {
+	prio, ok := q.nextTier()
+	if !ok {
 		return queueItem{}, false
 	}
 
+	tier := q.tiers[prio]
 	var item queueItem
-	if len(q.q) == 1 {
-		item = q.q[0]
-		q.q = nil
+	if len(tier) == 1 {
+		item = tier[0]
+		q.tiers[prio] = nil
 	} else {
-		pos := rand.IntN(len(q.q))
-		item = q.q[pos]
-		last := len(q.q) - 1
-		q.q[pos] = q.q[last]
-		q.q = q.q[:last]
+		pos := rand.IntN(len(tier))
+		item = tier[pos]
+		last := len(tier) - 1
+		tier[pos] = tier[last]
+		q.tiers[prio] = tier[:last]
 	}
-	delete(q.members, item.uid)
-
-	compactionQueueDepth.Dec()
-	return item, true
+	return q.popped(item, prio)
 }
 
+// CompactorState is what each of the compactor's workers is doing, and totals across all of them since startup
 type CompactorState struct {
+	Workers       []CompactorWorkerState `json:"workers"`
+	Compacted     int64                  `json:"compacted"`
+	Failed        int64                  `json:"failed"`
+	ShardsDeleted int64                  `json:"shards_deleted"`
+	NewShards     int64                  `json:"new_shards"`
+	SkippedShards int64                  `json:"skipped_shards"`
+	Refs          int64                  `json:"refs"`
+	Dupes         int64                  `json:"dupes"`
+}
+
+// CompactorWorkerState is the repo a compactor worker last picked up, and how far it has got
+type CompactorWorkerState struct {
+	UID    models.Uid `json:"uid"`
+	DID    string     `json:"did"`
+	Status string     `json:"status"`
+	Since  time.Time  `json:"since"`
+}
+
+// compactionResult is the outcome of one attempt to compact a repo
+type compactionResult struct {
 	latestUID models.Uid
 	latestDID string
 	status    string
 	stats     *carstore.CompactionStats
 }
 
-func (cstate *CompactorState) set(uid models.Uid, did, status string, stats *carstore.CompactionStats) {
-	cstate.latestUID = uid
-	cstate.latestDID = did
-	cstate.status = status
-	cstate.stats = stats
-}
+// ErrCompactionInProgress is returned when asked to compact a repo which is already being compacted
+var ErrCompactionInProgress = fmt.Errorf("repo is already being compacted")
 
 // Compactor is a compactor daemon that compacts repos in the background
 type Compactor struct {
@@ -149,9 +308,16 @@ type Compactor struct {
 	requeueLimit      int
 	requeueShardCount int
 	requeueFast       bool
+	requeuePriority   CompactionPriority
 
 	numWorkers int
 	wg         sync.WaitGroup
+
+	// state and active are protected by stateLk. active holds the repos being compacted, by workers or admin requests, so no repo is compacted twice at once
+	state  CompactorState
+	active map[models.Uid]bool
+
+	history *compactionHistory
 }
 
 type CompactorOptions struct {
@@ -159,7 +325,16 @@ type CompactorOptions struct {
 	RequeueLimit      int
 	RequeueShardCount int
 	RequeueFast       bool
-	NumWorkers        int
+	// Tier the periodic sweep queues repos in
+	RequeuePriority CompactionPriority
+	// Number of workers compacting repos from the queue concurrently
+	NumWorkers int
+	// Relative share of compactions for each priority tier, when several have repos waiting. Tiers not set get their default weight
+	PriorityWeights map[CompactionPriority]int
+	// Number of recent compactions kept for the admin API's compaction history; 0 disables the history
+	HistorySize int
+	// Number of repos whose compaction totals are kept, evicting the least recently compacted
+	UserStatsSize int
 }
 
 func DefaultCompactorOptions() *CompactorOptions {
@@ -168,7 +343,11 @@ func DefaultCompactorOptions() *CompactorOptions {
 		RequeueLimit:      0,
 		RequeueShardCount: 50,
 		RequeueFast:       true,
+		RequeuePriority:   CompactionBackground,
 		NumWorkers:        2,
+		PriorityWeights:   DefaultCompactionPriorityWeights(),
+		HistorySize:       1000,
+		UserStatsSize:     100_000,
 	}
 }
 
@@ -176,17 +355,24 @@ func NewCompactor(opts *CompactorOptions) *Compactor {
 	if opts == nil {
 		opts = DefaultCompactorOptions()
 	}
+	numWorkers := max(opts.NumWorkers, 1)
+	workers := make([]CompactorWorkerState, numWorkers)
+	for i := range workers {
+		workers[i].Status = "idle"
+	}
 
 	return &Compactor{
-		q: &uniQueue{
-			members: make(map[models.Uid]struct{}),
-		},
+		q:                 newUniQueue(opts.PriorityWeights),
 		exit:              make(chan struct{}),
 		requeueInterval:   opts.RequeueInterval,
 		requeueLimit:      opts.RequeueLimit,
 		requeueFast:       opts.RequeueFast,
 		requeueShardCount: opts.RequeueShardCount,
-		numWorkers:        opts.NumWorkers,
+		requeuePriority:   opts.RequeuePriority,
+		numWorkers:        numWorkers,
+		state:             CompactorState{Workers: workers},
+		active:            make(map[models.Uid]bool),
+		history:           newCompactionHistory(opts.HistorySize, opts.UserStatsSize),
 	}
 }
 
@@ -206,15 +392,16 @@ func (c *Compactor) Start(bgs *BGS) {
 		if i%2 != 0 {
 			strategy = NextRandom
 		}
-		go c.doWork(bgs, strategy)
+		go c.doWork(bgs, i, strategy)
 	}
 	if c.requeueInterval > 0 {
 		go func() {
-			log.Infow("starting compactor requeue routine",
+			log.Info("starting compactor requeue routine",
 				"interval", c.requeueInterval,
 				"limit", c.requeueLimit,
 				"shardCount", c.requeueShardCount,
 				"fast", c.requeueFast,
+				"priority", c.requeuePriority,
 			)
 
 			t := time.NewTicker(c.requeueInterval)
@@ -225,8 +412,8 @@ func (c *Compactor) Start(bgs *BGS) {
 				case <-t.C:
 					ctx := context.Background()
 					ctx, span := otel.Tracer("compactor").Start(ctx, "RequeueRoutine")
-					if err := c.EnqueueAllRepos(ctx, bgs, c.requeueLimit, c.requeueShardCount, c.requeueFast); err != nil {
-						log.Errorw("failed to enqueue all repos", "err", err)
+					if err := c.EnqueueAllRepos(ctx, bgs, c.requeueLimit, c.requeueShardCount, c.requeueFast, c.requeuePriority); err != nil {
+						log.Error("failed to enqueue all repos", "err", err)
 					}
 					span.End()
 				}
@@ -243,7 +430,7 @@ func (c *Compactor) Shutdown() {
 	log.Info("compactor stopped")
 }
 
-func (c *Compactor) doWork(bgs *BGS, strategy NextStrategy) {
+func (c *Compactor) doWork(bgs *BGS, worker int, strategy NextStrategy) {
 	defer c.wg.Done()
 	for {
 		select {
@@ -255,14 +442,19 @@ func (c *Compactor) doWork(bgs *BGS, strategy NextStrategy) {
 
 		ctx := context.Background()
 		start := time.Now()
-		state, err := c.compactNext(ctx, bgs, strategy)
+		state, err := c.compactNext(ctx, bgs, worker, strategy)
 		if err != nil {
 			if err == errNoReposToCompact {
 				log.Debug("no repos to compact, waiting and retrying")
 				time.Sleep(time.Second * 5)
 				continue
 			}
-			log.Errorw("failed to compact repo",
+			if err == ErrCompactionInProgress {
+				log.Debug("repo is being compacted by another worker, requeued", "uid", state.latestUID)
+				time.Sleep(time.Second)
+				continue
+			}
+			log.Error("failed to compact repo",
 				"err", err,
 				"uid", state.latestUID,
 				"repo", state.latestDID,
@@ -273,7 +465,7 @@ func (c *Compactor) doWork(bgs *BGS, strategy NextStrategy) {
 			// Pause for a bit to avoid spamming failed compactions
 			time.Sleep(time.Millisecond * 100)
 		} else {
-			log.Infow("compacted repo",
+			log.Info("compacted repo",
 				"uid", state.latestUID,
 				"repo", state.latestDID,
 				"status", state.status,
@@ -291,7 +483,7 @@ const (
 	NextRandom
 )
 
-func (c *Compactor) compactNext(ctx context.Context, bgs *BGS, strategy NextStrategy) (CompactorState, error) {
+func (c *Compactor) compactNext(ctx context.Context, bgs *BGS, worker int, strategy NextStrategy) (compactionResult, error) {
 	ctx, span := otel.Tracer("compactor").Start(ctx, "CompactNext")
 	defer span.End()
 
@@ -304,26 +496,50 @@ func (c *Compactor) compactNext(ctx context.Context, bgs *BGS, strategy NextStra
 		item, ok = c.q.Pop()
 	}
 	if !ok {
-		return CompactorState{}, errNoReposToCompact
+		return compactionResult{}, errNoReposToCompact
+	}
+
+	if !c.lockRepo(item.uid) {
+		// compacted again once the current compaction is done, since it may have been queued for writes since that started
+		c.q.Append(item.uid, item.fast, item.prio)
+		return compactionResult{latestUID: item.uid, latestDID: "unknown", status: "busy"}, ErrCompactionInProgress
 	}
+	defer c.unlockRepo(item.uid)
 
-	state := CompactorState{
+	state := compactionResult{
 		latestUID: item.uid,
 		latestDID: "unknown",
 		status:    "getting_user",
 	}
+	c.setWorkerState(worker, state)
+	defer func() {
+		c.setWorkerState(worker, state)
+	}()
+
+	rec := CompactionRecord{
+		UID:      item.uid,
+		DID:      state.latestDID,
+		Priority: item.prio.String(),
+		Fast:     item.fast,
+		Worker:   worker,
+		Started:  time.Now(),
+	}
 
 	user, err := bgs.lookupUserByUID(ctx, item.uid)
 	if err != nil {
 		span.RecordError(err)
 		state.status = "failed_getting_user"
 		err := fmt.Errorf("failed to get user %d: %w", item.uid, err)
+		c.recordResult(rec, state.status, nil, err)
 		return state, err
 	}
 
 	span.SetAttributes(attribute.String("repo", user.Did), attribute.Int("uid", int(item.uid)))
 
 	state.latestDID = user.Did
+	state.status = "compacting"
+	c.setWorkerState(worker, state)
+	rec.DID = user.Did
 
 	start := time.Now()
 	st, err := bgs.repoman.CarStore().CompactUserShards(ctx, item.uid, item.fast)
@@ -331,9 +547,11 @@ func (c *Compactor) compactNext(ctx context.Context, bgs *BGS, strategy NextStra
 		span.RecordError(err)
 		state.status = "failed_compacting"
 		err := fmt.Errorf("failed to compact shards for user %d: %w", item.uid, err)
+		c.recordResult(rec, state.status, nil, err)
 		return state, err
 	}
 	compactionDuration.Observe(time.Since(start).Seconds())
+	c.recordResult(rec, "done", st, nil)
 
 	span.SetAttributes(
 		attribute.Int("shards.deleted", st.ShardsDeleted),
@@ -349,18 +567,150 @@ func (c *Compactor) compactNext(ctx context.Context, bgs *BGS, strategy NextStra
 	return state, nil
 }
 
-func (c *Compactor) EnqueueRepo(ctx context.Context, user User, fast bool) {
+// CompactRepo compacts one repo now, outside the queue. It returns ErrCompactionInProgress if a worker is already compacting the repo
+func (c *Compactor) CompactRepo(ctx context.Context, bgs *BGS, user *User, fast bool) (*carstore.CompactionStats, error) {
+	if !c.lockRepo(user.ID) {
+		return nil, ErrCompactionInProgress
+	}
+	defer c.unlockRepo(user.ID)
+
+	rec := CompactionRecord{
+		UID:      user.ID,
+		DID:      user.Did,
+		Priority: "admin",
+		Fast:     fast,
+		Worker:   -1,
+		Started:  time.Now(),
+	}
+	st, err := bgs.repoman.CarStore().CompactUserShards(ctx, user.ID, fast)
+	if err != nil {
+		c.recordResult(rec, "failed_compacting", nil, err)
+		return nil, err
+	}
+	compactionDuration.Observe(time.Since(rec.Started).Seconds())
+	c.recordResult(rec, "done", st, nil)
+	return st, nil
+}
+
+// lockRepo marks a repo as being compacted, returning false if it already is
+func (c *Compactor) lockRepo(uid models.Uid) bool {
+	c.stateLk.Lock()
+	defer c.stateLk.Unlock()
+
+	if c.active[uid] {
+		return false
+	}
+	c.active[uid] = true
+	compactionsActive.Inc()
+	return true
+}
+
+func (c *Compactor) unlockRepo(uid models.Uid) {
+	c.stateLk.Lock()
+	defer c.stateLk.Unlock()
+
+	delete(c.active, uid)
+	compactionsActive.Dec()
+}
+
+func (c *Compactor) setWorkerState(worker int, res compactionResult) {
+	c.stateLk.Lock()
+	defer c.stateLk.Unlock()
+
+	c.state.Workers[worker] = CompactorWorkerState{
+		UID:    res.latestUID,
+		DID:    res.latestDID,
+		Status: res.status,
+		Since:  time.Now(),
+	}
+}
+
+// recordResult finishes a compaction's record and adds it to the history and the totals; nil stats count as a failure
+func (c *Compactor) recordResult(rec CompactionRecord, status string, st *carstore.CompactionStats, err error) {
+	rec.DurationMs = time.Since(rec.Started).Milliseconds()
+	rec.Status = status
+	rec.Stats = st
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	c.history.add(rec)
+
+	c.stateLk.Lock()
+	defer c.stateLk.Unlock()
+
+	if st == nil {
+		c.state.Failed++
+		return
+	}
+	c.state.Compacted++
+	c.state.ShardsDeleted += int64(st.ShardsDeleted)
+	c.state.NewShards += int64(st.NewShards)
+	c.state.SkippedShards += int64(st.SkippedShards)
+	c.state.Refs += int64(st.TotalRefs)
+	c.state.Dupes += int64(st.DupeCount)
+}
+
+// State returns a snapshot of the workers' progress and the compaction totals
+func (c *Compactor) State() CompactorState {
+	c.stateLk.RLock()
+	defer c.stateLk.RUnlock()
+
+	st := c.state
+	st.Workers = append([]CompactorWorkerState(nil), c.state.Workers...)
+	return st
+}
+
+// History returns the compactor's most recent compactions started at or after since, newest first, up to limit (0 for all those kept). If uid is non-zero, only that repo's compactions are returned
+func (c *Compactor) History(since time.Time, uid models.Uid, limit int) []CompactionRecord {
+	return c.history.list(since, uid, limit)
+}
+
+// UserStats returns a repo's compaction totals since startup, if it has been compacted recently enough for them to be kept
+func (c *Compactor) UserStats(uid models.Uid) (UserCompactionStats, bool) {
+	return c.history.user(uid)
+}
+
+// CompactionQueueStatus describes the compactor's backlog, for the admin API
+type CompactionQueueStatus struct {
+	Queued int `json:"queued"`
+	// Repos queued in each priority tier, and the tiers' weights
+	QueuedByPriority map[string]int `json:"queued_by_priority"`
+	PriorityWeights  map[string]int `json:"priority_weights"`
+	Workers          int            `json:"workers"`
+	RequeueInterval  string         `json:"requeue_interval"`
+	// What the workers are doing, and totals since startup
+	State CompactorState `json:"state"`
+}
+
+func (c *Compactor) QueueStatus() CompactionQueueStatus {
+	weights := make(map[string]int, numCompactionPriorities)
+	for p := range numCompactionPriorities {
+		weights[p.String()] = c.q.weights[p]
+	}
+	return CompactionQueueStatus{
+		Queued:           c.q.Len(),
+		QueuedByPriority: c.q.LenByPriority(),
+		PriorityWeights:  weights,
+		Workers:          c.numWorkers,
+		RequeueInterval:  c.requeueInterval.String(),
+		State:            c.State(),
+	}
+}
+
+// EnqueueRepo queues a repo for compaction in the given priority tier. A repo already queued at a lower priority is moved up
+func (c *Compactor) EnqueueRepo(ctx context.Context, user User, fast bool, prio CompactionPriority) {
 	ctx, span := otel.Tracer("compactor").Start(ctx, "EnqueueRepo")
 	defer span.End()
-	log.Infow("enqueueing compaction for repo", "repo", user.Did, "uid", user.ID, "fast", fast)
-	c.q.Append(user.ID, fast)
+	log.Info("enqueueing compaction for repo", "repo", user.Did, "uid", user.ID, "fast", fast, "priority", prio)
+	c.q.Append(user.ID, fast, prio)
 }
 
 // EnqueueAllRepos enqueues all repos for compaction
 // lim is the maximum number of repos to enqueue
 // shardCount is the number of shards to compact per user (0 = default of 50)
 // fast is whether to use the fast compaction method (skip large shards)
-func (c *Compactor) EnqueueAllRepos(ctx context.Context, bgs *BGS, lim int, shardCount int, fast bool) error {
+// prio is the priority tier to queue them in
+func (c *Compactor) EnqueueAllRepos(ctx context.Context, bgs *BGS, lim int, shardCount int, fast bool, prio CompactionPriority) error {
 	ctx, span := otel.Tracer("compactor").Start(ctx, "EnqueueAllRepos")
 	defer span.End()
 
@@ -368,6 +718,7 @@ func (c *Compactor) EnqueueAllRepos(ctx context.Context, bgs *BGS, lim int, shar
 		attribute.Int("lim", lim),
 		attribute.Int("shardCount", shardCount),
 		attribute.Bool("fast", fast),
+		attribute.String("priority", prio.String()),
 	)
 
 	if shardCount == 0 {
@@ -376,7 +727,7 @@ func (c *Compactor) EnqueueAllRepos(ctx context.Context, bgs *BGS, lim int, shar
 
 	span.SetAttributes(attribute.Int("clampedShardCount", shardCount))
 
-	log := log.With("source", "compactor_enqueue_all_repos", "lim", lim, "shardCount", shardCount, "fast", fast)
+	log := log.With("source", "compactor_enqueue_all_repos", "lim", lim, "shardCount", shardCount, "fast", fast, "priority", prio)
 	log.Info("enqueueing all repos")
 
 	repos, err := bgs.repoman.CarStore().GetCompactionTargets(ctx, shardCount)
@@ -393,10 +744,10 @@ func (c *Compactor) EnqueueAllRepos(ctx context.Context, bgs *BGS, lim int, shar
 	span.SetAttributes(attribute.Int("clampedRepos", len(repos)))
 
 	for _, r := range repos {
-		c.q.Append(r.Usr, fast)
+		c.q.Append(r.Usr, fast, prio)
 	}
 
-	log.Infow("done enqueueing all repos", "repos_enqueued", len(repos))
+	log.Info("done enqueueing all repos", "repos_enqueued", len(repos))
 
 	return nil
 }
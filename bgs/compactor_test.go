@@ -0,0 +1,313 @@
+package bgs
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// fill queues n repos in a tier, with uids starting at base
+func fill(q *uniQueue, prio CompactionPriority, base models.Uid, n int) {
+	for i := range n {
+		q.Append(base+models.Uid(i), false, prio)
+	}
+}
+
+func TestUniQueueWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[CompactionPriority]int
+		queued  map[CompactionPriority]int
+		pops    int
+		served  map[CompactionPriority]int
+	}{
+		{
+			name:    "defaults",
+			weights: DefaultCompactionPriorityWeights(),
+			queued:  map[CompactionPriority]int{CompactionUrgent: 1000, CompactionNormal: 1000, CompactionBackground: 1000},
+			pops:    21 * 10,
+			served:  map[CompactionPriority]int{CompactionUrgent: 160, CompactionNormal: 40, CompactionBackground: 10},
+		},
+		{
+			name:    "equal",
+			weights: map[CompactionPriority]int{CompactionUrgent: 1, CompactionNormal: 1, CompactionBackground: 1},
+			queued:  map[CompactionPriority]int{CompactionUrgent: 100, CompactionNormal: 100, CompactionBackground: 100},
+			pops:    30,
+			served:  map[CompactionPriority]int{CompactionUrgent: 10, CompactionNormal: 10, CompactionBackground: 10},
+		},
+		{
+			name:    "unset weights use defaults",
+			weights: map[CompactionPriority]int{CompactionNormal: 8},
+			queued:  map[CompactionPriority]int{CompactionUrgent: 1000, CompactionNormal: 1000, CompactionBackground: 1000},
+			pops:    25 * 4,
+			served:  map[CompactionPriority]int{CompactionUrgent: 64, CompactionNormal: 32, CompactionBackground: 4},
+		},
+		{
+			name:    "empty tier",
+			weights: DefaultCompactionPriorityWeights(),
+			queued:  map[CompactionPriority]int{CompactionNormal: 100, CompactionBackground: 100},
+			pops:    5 * 10,
+			served:  map[CompactionPriority]int{CompactionNormal: 40, CompactionBackground: 10},
+		},
+		{
+			name:    "tier runs out",
+			weights: DefaultCompactionPriorityWeights(),
+			queued:  map[CompactionPriority]int{CompactionUrgent: 3, CompactionBackground: 100},
+			pops:    10,
+			served:  map[CompactionPriority]int{CompactionUrgent: 3, CompactionBackground: 7},
+		},
+		{
+			name:    "zero weight is treated as one",
+			weights: map[CompactionPriority]int{CompactionUrgent: 2, CompactionNormal: 0, CompactionBackground: 1},
+			queued:  map[CompactionPriority]int{CompactionUrgent: 100, CompactionNormal: 100, CompactionBackground: 100},
+			pops:    4 * 5,
+			served:  map[CompactionPriority]int{CompactionUrgent: 10, CompactionNormal: 5, CompactionBackground: 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			q := newUniQueue(tt.weights)
+			for p, n := range tt.queued {
+				fill(q, p, models.Uid(int(p)*10_000+1), n)
+			}
+
+			served := make(map[CompactionPriority]int)
+			for range tt.pops {
+				item, ok := q.Pop()
+				if !assert.True(ok) {
+					return
+				}
+				assert.Equal(CompactionPriority(int(item.uid-1)/10_000), item.prio)
+				served[item.prio]++
+			}
+			assert.Equal(tt.served, served)
+		})
+	}
+}
+
+func TestUniQueueIdleCredit(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newUniQueue(DefaultCompactionPriorityWeights())
+	fill(q, CompactionUrgent, 1, 100)
+	q.Append(1000, false, CompactionBackground)
+
+	// the background repo banks credit while the urgent tier is served
+	for range 8 {
+		item, ok := q.Pop()
+		assert.True(ok)
+		assert.Equal(CompactionUrgent, item.prio)
+	}
+	assert.Positive(q.credit[CompactionBackground])
+
+	// and loses it once nothing is waiting in its tier
+	q.Remove(1000)
+	_, ok := q.Pop()
+	assert.True(ok)
+	assert.Zero(q.credit[CompactionBackground])
+
+	// so a repo queued in the tier later doesn't jump ahead of the urgent tier straight away
+	q.Append(1000, false, CompactionBackground)
+	item, ok := q.Pop()
+	assert.True(ok)
+	assert.Equal(CompactionUrgent, item.prio)
+}
+
+func TestUniQueuePromotion(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  CompactionPriority
+		then   CompactionPriority
+		expect CompactionPriority
+	}{
+		{"background to urgent", CompactionBackground, CompactionUrgent, CompactionUrgent},
+		{"background to normal", CompactionBackground, CompactionNormal, CompactionNormal},
+		{"normal to urgent", CompactionNormal, CompactionUrgent, CompactionUrgent},
+		{"urgent stays urgent", CompactionUrgent, CompactionBackground, CompactionUrgent},
+		{"normal stays normal", CompactionNormal, CompactionBackground, CompactionNormal},
+		{"same tier", CompactionNormal, CompactionNormal, CompactionNormal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			q := newUniQueue(nil)
+			q.Append(1, false, tt.first)
+			q.Append(1, false, tt.then)
+
+			assert.Equal(1, q.Len())
+			assert.Equal(tt.expect, q.members[1])
+			for p := range numCompactionPriorities {
+				if p == tt.expect {
+					assert.Len(q.tiers[p], 1)
+				} else {
+					assert.Empty(q.tiers[p])
+				}
+			}
+		})
+	}
+
+	// re-queueing at the same priority keeps the repo's place
+	q := newUniQueue(nil)
+	fill(q, CompactionNormal, 1, 3)
+	q.Prepend(3, false, CompactionNormal)
+	for uid := models.Uid(1); uid <= 3; uid++ {
+		item, ok := q.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, uid, item.uid)
+	}
+}
+
+func TestUniQueueDedupe(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newUniQueue(nil)
+	for range 3 {
+		for p := range numCompactionPriorities {
+			q.Append(1, false, p)
+			q.Prepend(1, true, p)
+		}
+	}
+	q.Append(2, false, CompactionBackground)
+
+	assert.Equal(2, q.Len())
+	assert.Equal(map[string]int{"urgent": 1, "normal": 0, "background": 1}, q.LenByPriority())
+
+	var popped []models.Uid
+	for {
+		item, ok := q.PopRandom()
+		if !ok {
+			break
+		}
+		popped = append(popped, item.uid)
+	}
+	assert.Equal([]models.Uid{1, 2}, popped)
+	assert.False(q.Has(1))
+	assert.Zero(q.Len())
+}
+
+func TestCompactorRepoLock(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	c := NewCompactor(&CompactorOptions{NumWorkers: 2})
+	uid := models.Uid(42)
+
+	// workers racing for the same repo: only one gets to compact it
+	var wg sync.WaitGroup
+	var lk sync.Mutex
+	won := 0
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c.lockRepo(uid) {
+				lk.Lock()
+				won++
+				lk.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(1, won)
+
+	// while it's being compacted, another worker that pops it gives it back instead of compacting it too
+	c.q.Append(uid, true, CompactionUrgent)
+	for _, strategy := range []NextStrategy{NextInOrder, NextRandom} {
+		res, err := c.compactNext(ctx, nil, 1, strategy)
+		assert.ErrorIs(err, ErrCompactionInProgress)
+		assert.Equal(uid, res.latestUID)
+		assert.Equal("busy", res.status)
+		assert.True(c.q.Has(uid))
+		assert.Equal(CompactionUrgent, c.q.members[uid])
+		assert.True(c.q.tiers[CompactionUrgent][0].fast)
+	}
+
+	// and admin requests are refused, which the admin API reports as a conflict
+	_, err := c.CompactRepo(ctx, nil, &User{ID: uid}, true)
+	assert.ErrorIs(err, ErrCompactionInProgress)
+
+	// once the compaction finishes, the requeued repo can be picked up again
+	c.unlockRepo(uid)
+	item, ok := c.q.Pop()
+	assert.True(ok)
+	assert.Equal(uid, item.uid)
+	assert.True(c.lockRepo(uid))
+	c.unlockRepo(uid)
+	assert.Empty(c.active)
+}
+
+func TestCompactionPriorityZeroValue(t *testing.T) {
+	assert := assert.New(t)
+
+	var p CompactionPriority
+	assert.Equal(CompactionBackground, p)
+
+	// options built by hand without a priority put the periodic sweep in the background tier
+	c := NewCompactor(&CompactorOptions{})
+	assert.Equal(CompactionBackground, c.requeuePriority)
+}
+
+func TestParseCompactionPriority(t *testing.T) {
+	tests := []struct {
+		in     string
+		expect CompactionPriority
+		err    bool
+	}{
+		{in: "urgent", expect: CompactionUrgent},
+		{in: "normal", expect: CompactionNormal},
+		{in: "background", expect: CompactionBackground},
+		{in: "", err: true},
+		{in: "Urgent", err: true},
+		{in: "high", err: true},
+	}
+
+	for _, tt := range tests {
+		p, err := ParseCompactionPriority(tt.in)
+		if tt.err {
+			assert.Error(t, err, tt.in)
+			continue
+		}
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.expect, p, tt.in)
+		assert.Equal(t, tt.in, p.String())
+	}
+}
+
+func TestParseCompactionPriorityWeights(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     []string
+		expect map[CompactionPriority]int
+		err    string
+	}{
+		{name: "none", in: nil, expect: map[CompactionPriority]int{}},
+		{name: "all", in: []string{"urgent=8", "normal=2", "background=1"}, expect: map[CompactionPriority]int{CompactionUrgent: 8, CompactionNormal: 2, CompactionBackground: 1}},
+		{name: "some", in: []string{"background=3"}, expect: map[CompactionPriority]int{CompactionBackground: 3}},
+		{name: "missing weight", in: []string{"urgent"}, err: "expected tier=weight"},
+		{name: "unknown tier", in: []string{"high=4"}, err: "invalid compaction priority"},
+		{name: "not a number", in: []string{"urgent=lots"}, err: "must be a positive integer"},
+		{name: "zero", in: []string{"normal=0"}, err: "must be a positive integer"},
+		{name: "negative", in: []string{"normal=-2"}, err: "must be a positive integer"},
+		{name: "one bad", in: []string{"urgent=8", "normal=x"}, err: "must be a positive integer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := ParseCompactionPriorityWeights(tt.in)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expect, w)
+		})
+	}
+}
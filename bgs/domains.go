@@ -0,0 +1,87 @@
+package bgs
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/bluesky-social/indigo/models"
+	"gorm.io/gorm"
+)
+
+var ErrDomainBanned = fmt.Errorf("domain is banned")
+
+// normalizeDomainPattern validates and normalizes a domain ban or trusted domain pattern. A pattern is either a plain domain (eg, "example.com") or a wildcard over its subdomains (eg, "*.example.com"); no other wildcards are supported.
+func normalizeDomainPattern(p string) (string, error) {
+	p = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(p)), ".")
+	if p == "" {
+		return "", fmt.Errorf("must specify a domain")
+	}
+
+	rest := strings.TrimPrefix(p, "*.")
+	if rest == "" || strings.ContainsAny(rest, "*:/ ") {
+		return "", fmt.Errorf("invalid domain pattern %q: only a leading '*.' wildcard is supported, without scheme or port", p)
+	}
+
+	for _, seg := range strings.Split(rest, ".") {
+		if seg == "" {
+			return "", fmt.Errorf("invalid domain pattern %q: empty label", p)
+		}
+	}
+
+	return p, nil
+}
+
+// normalizeHostname strips any port from a host and lowercases it
+func normalizeHostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// domainPatternMatches reports whether host (which may include a port) matches an exact or "*." wildcard pattern. A wildcard matches subdomains only, not the domain itself.
+func domainPatternMatches(pattern, host string) bool {
+	host = normalizeHostname(host)
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == pattern
+}
+
+// domainBanCandidates returns every ban pattern which would cover host: the host itself and each parent domain up to (but not including) the tld, which also ban all of their subdomains, plus "*." wildcards over each parent, which ban only subdomains
+func domainBanCandidates(host string) []string {
+	var segments []string
+	for _, s := range strings.Split(normalizeHostname(host), ".") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+
+	var out []string
+	for i := 0; i < len(segments); i++ {
+		d := strings.Join(segments[i:], ".")
+		if i < len(segments)-1 {
+			out = append(out, d)
+		}
+		if i > 0 {
+			out = append(out, "*."+d)
+		}
+	}
+	return out
+}
+
+func hostIsBanned(ctx context.Context, db *gorm.DB, host string) (bool, error) {
+	candidates := domainBanCandidates(host)
+	if len(candidates) == 0 {
+		return false, nil
+	}
+
+	var count int64
+	if err := db.WithContext(ctx).Model(&models.DomainBan{}).Where("domain IN ?", candidates).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,713 @@
+// Package fanout implements the front-end for a relay split into shards by DID (see bgs.ShardConfig). It merges the shards' firehoses (or the events they publish to a bus) into one, with its own sequence numbers, and routes per-account requests to the shard which owns the account.
+package fanout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/bgs"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/util/health"
+	"github.com/bluesky-social/indigo/util/logging"
+
+	"github.com/gorilla/websocket"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+)
+
+var log = logging.Component("fanout")
+
+var eventsFromShards = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "fanout_events_from_shards",
+	Help: "The total number of events merged from each shard",
+}, []string{"shard"})
+
+var eventsFromBus = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "fanout_events_from_bus",
+	Help: "The total number of events merged from the event bus",
+})
+
+var misroutedEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "fanout_misrouted_events",
+	Help: "The total number of events dropped because the shard emitting them doesn't own their account",
+}, []string{"shard"})
+
+// ShardCursor is the sequence number of the last event merged from a shard
+type ShardCursor struct {
+	gorm.Model
+	Host   string `gorm:"uniqueIndex"`
+	Cursor int64
+}
+
+type Config struct {
+	// Shard relay hosts (host:port), in shard index order. Each shard must be configured with this many shards
+	Shards []string
+	// Connect to shards over TLS
+	SSL bool
+	// Merge the events relays publish to this bus (see events.BusConfig), instead of subscribing to the shards' firehoses. Shards are then only used to route requests, and may be empty
+	Bus events.Bus
+}
+
+type Fanout struct {
+	db        *gorm.DB
+	persister events.EventPersistence
+	events    *events.EventManager
+
+	shards  []string
+	ssl     bool
+	bus     events.Bus
+	proxies []*httputil.ReverseProxy
+	client  *http.Client
+
+	// the persister keys events by account uid, so every account needs a row in the actor table
+	actorsLk sync.Mutex
+	actors   *lru.Cache[string, struct{}]
+
+	cursorsLk sync.Mutex
+	cursors   map[string]int64
+
+	ctx    context.Context
+	cancel func()
+	wg     sync.WaitGroup
+
+	// whether each shard's firehose is currently connected, by shard index
+	shardConnected []atomic.Bool
+	health         *health.Checker
+
+	// set once shutdown starts, to refuse new consumers
+	draining atomic.Bool
+	// closed on shutdown, once the event persister is flushed, to disconnect consumers
+	consumersExit chan struct{}
+	server        atomic.Pointer[http.Server]
+}
+
+// NewFanout starts merging the firehoses of the given shards. The persister sequences the merged stream, and must keep its metadata in db.
+func NewFanout(db *gorm.DB, persister events.EventPersistence, config *Config) (*Fanout, error) {
+	if len(config.Shards) == 0 && config.Bus == nil {
+		return nil, fmt.Errorf("must configure at least one shard, or an event bus")
+	}
+
+	if err := db.AutoMigrate(ShardCursor{}, models.ActorInfo{}); err != nil {
+		return nil, err
+	}
+
+	actors, err := lru.New[string, struct{}](100_000)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Fanout{
+		db:            db,
+		persister:     persister,
+		events:        events.NewEventManager(persister),
+		shards:        config.Shards,
+		ssl:           config.SSL,
+		bus:           config.Bus,
+		client:        &http.Client{Timeout: time.Minute},
+		actors:        actors,
+		cursors:       make(map[string]int64),
+		consumersExit: make(chan struct{}),
+
+		shardConnected: make([]atomic.Bool, len(config.Shards)),
+	}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	f.health = f.newHealthChecker()
+
+	var saved []ShardCursor
+	if err := db.Find(&saved).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range saved {
+		f.cursors[c.Host] = c.Cursor
+	}
+
+	for _, host := range f.shards {
+		u, err := url.Parse(f.shardURL("http", host))
+		if err != nil {
+			return nil, fmt.Errorf("invalid shard host %q: %w", host, err)
+		}
+		f.proxies = append(f.proxies, httputil.NewSingleHostReverseProxy(u))
+	}
+
+	if f.bus != nil {
+		if err := f.bus.Subscribe(f.handleBusEvent); err != nil {
+			return nil, fmt.Errorf("subscribing to event bus: %w", err)
+		}
+	} else {
+		for i, host := range f.shards {
+			f.wg.Add(1)
+			go f.subscribeWithRedialer(i, host)
+		}
+	}
+
+	go f.flushCursorsRoutine()
+
+	return f, nil
+}
+
+func (f *Fanout) shardURL(scheme, host string) string {
+	if f.ssl {
+		scheme += "s"
+	}
+	return scheme + "://" + host
+}
+
+func (f *Fanout) cursor(host string) int64 {
+	f.cursorsLk.Lock()
+	defer f.cursorsLk.Unlock()
+	return f.cursors[host]
+}
+
+func (f *Fanout) setCursor(host string, seq int64) {
+	f.cursorsLk.Lock()
+	defer f.cursorsLk.Unlock()
+	f.cursors[host] = seq
+}
+
+func (f *Fanout) subscribeWithRedialer(index int, host string) {
+	defer f.wg.Done()
+
+	d := websocket.Dialer{
+		HandshakeTimeout: time.Second * 5,
+	}
+
+	var backoff int
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		default:
+		}
+
+		u := fmt.Sprintf("%s/xrpc/com.atproto.sync.subscribeRepos?cursor=%d", f.shardURL("ws", host), f.cursor(host))
+		con, _, err := d.DialContext(f.ctx, u, nil)
+		if err != nil {
+			log.Warn("dialing shard failed", "host", host, "err", err, "backoff", backoff)
+			select {
+			case <-time.After(time.Second * time.Duration(min(1<<backoff, 30))):
+			case <-f.ctx.Done():
+				return
+			}
+			backoff = min(backoff+1, 5)
+			continue
+		}
+
+		log.Info("connected to shard", "host", host, "shard", index)
+		backoff = 0
+		f.shardConnected[index].Store(true)
+
+		sched := sequential.NewScheduler("fanout-"+host, func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+			return f.handleShardEvent(ctx, index, host, evt)
+		})
+		if err := events.HandleRepoStream(f.ctx, con, sched); err != nil && f.ctx.Err() == nil {
+			log.Warn("shard connection failed", "host", host, "err", err)
+		}
+		f.shardConnected[index].Store(false)
+	}
+}
+
+func eventSeq(evt *events.XRPCStreamEvent) int64 {
+	switch {
+	case evt.RepoCommit != nil:
+		return evt.RepoCommit.Seq
+	case evt.RepoHandle != nil:
+		return evt.RepoHandle.Seq
+	case evt.RepoIdentity != nil:
+		return evt.RepoIdentity.Seq
+	case evt.RepoAccount != nil:
+		return evt.RepoAccount.Seq
+	case evt.RepoSync != nil:
+		return evt.RepoSync.Seq
+	case evt.RepoMigrate != nil:
+		return evt.RepoMigrate.Seq
+	case evt.RepoTombstone != nil:
+		return evt.RepoTombstone.Seq
+	}
+	return 0
+}
+
+func (f *Fanout) handleShardEvent(ctx context.Context, index int, host string, evt *events.XRPCStreamEvent) error {
+	if evt.RepoInfo != nil {
+		log.Info("info event from shard", "host", host, "name", evt.RepoInfo.Name, "message", evt.RepoInfo.Message)
+		return nil
+	}
+
+	did := bgs.EventDid(evt)
+	if did == "" {
+		return nil
+	}
+
+	// the persister renumbers the event
+	seq := eventSeq(evt)
+
+	if bgs.ShardForDid(did, len(f.shards)) != index {
+		log.Warn("dropping event for account owned by another shard, check the shard configuration", "host", host, "did", did, "seq", seq)
+		misroutedEvents.WithLabelValues(host).Inc()
+		f.setCursor(host, seq)
+		return nil
+	}
+
+	if err := f.ensureActor(ctx, did); err != nil {
+		return fmt.Errorf("failed to assign account uid: %w", err)
+	}
+
+	if err := f.events.AddEvent(ctx, evt); err != nil {
+		return err
+	}
+
+	eventsFromShards.WithLabelValues(host).Inc()
+	f.setCursor(host, seq)
+	return nil
+}
+
+// handleBusEvent merges an event from the bus. The bus tracks how far the fanout has got, so there are no cursors to keep
+func (f *Fanout) handleBusEvent(ctx context.Context, evt *events.XRPCStreamEvent) error {
+	if evt.RepoInfo != nil {
+		log.Info("info event from bus", "name", evt.RepoInfo.Name, "message", evt.RepoInfo.Message)
+		return nil
+	}
+
+	did := bgs.EventDid(evt)
+	if did == "" {
+		return nil
+	}
+
+	if err := f.ensureActor(ctx, did); err != nil {
+		return fmt.Errorf("failed to assign account uid: %w", err)
+	}
+
+	if err := f.events.AddEvent(ctx, evt); err != nil {
+		return err
+	}
+
+	eventsFromBus.Inc()
+	return nil
+}
+
+func (f *Fanout) ensureActor(ctx context.Context, did string) error {
+	if f.actors.Contains(did) {
+		return nil
+	}
+
+	f.actorsLk.Lock()
+	defer f.actorsLk.Unlock()
+
+	var ai models.ActorInfo
+	if err := f.db.WithContext(ctx).Where("did = ?", did).Limit(1).Find(&ai).Error; err != nil {
+		return err
+	}
+
+	if ai.ID == 0 {
+		if err := f.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			ai = models.ActorInfo{Did: did}
+			if err := tx.Create(&ai).Error; err != nil {
+				return err
+			}
+			// there is no user table here, so the row id serves as the uid
+			return tx.Model(&ai).Update("uid", ai.ID).Error
+		}); err != nil {
+			return err
+		}
+	}
+
+	f.actors.Add(did, struct{}{})
+	return nil
+}
+
+func (f *Fanout) flushCursorsRoutine() {
+	t := time.NewTicker(time.Second * 5)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-t.C:
+			if err := f.flushCursors(context.Background()); err != nil {
+				log.Error("failed to flush shard cursors", "err", err)
+			}
+		}
+	}
+}
+
+// flushCursors saves the shard cursors, once the events up to them are persisted
+func (f *Fanout) flushCursors(ctx context.Context) error {
+	f.cursorsLk.Lock()
+	cursors := make(map[string]int64, len(f.cursors))
+	for host, c := range f.cursors {
+		cursors[host] = c
+	}
+	f.cursorsLk.Unlock()
+
+	if err := f.persister.Flush(ctx); err != nil {
+		return fmt.Errorf("failed to flush event persister: %w", err)
+	}
+
+	return f.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for host, c := range cursors {
+			var sc ShardCursor
+			if err := tx.Where("host = ?", host).Limit(1).Find(&sc).Error; err != nil {
+				return err
+			}
+			sc.Host = host
+			sc.Cursor = c
+			if err := tx.Save(&sc).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Shutdown stops merging shard firehoses, saves the shard cursors once the merged events are persisted, then disconnects consumers
+func (f *Fanout) Shutdown(ctx context.Context) []error {
+	f.draining.Store(true)
+	f.cancel()
+
+	var errs []error
+
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("timed out waiting for shard subscriptions to close"))
+	}
+
+	if f.bus != nil {
+		if err := f.bus.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close event bus: %w", err))
+		}
+	}
+
+	if err := f.flushCursors(context.Background()); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := f.events.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	close(f.consumersExit)
+
+	if srv := f.server.Load(); srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop http server: %w", err))
+		}
+	}
+
+	return errs
+}
+
+func (f *Fanout) Start(addr string) error {
+	li, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return f.StartWithListener(li)
+}
+
+func (f *Fanout) StartWithListener(listen net.Listener) error {
+	e := echo.New()
+	e.HideBanner = true
+
+	e.GET("/xrpc/_health", f.handleHealthCheck)
+	e.GET("/healthz", echo.WrapHandler(f.health.Healthz()))
+	e.GET("/readyz", echo.WrapHandler(f.health.Readyz()))
+	e.GET("/buildinfo", echo.WrapHandler(f.health.BuildInfo()))
+	e.GET("/xrpc/com.atproto.sync.subscribeRepos", f.handleSubscribeRepos)
+
+	if len(f.shards) > 0 {
+		e.POST("/xrpc/com.atproto.sync.requestCrawl", f.handleRequestCrawl)
+		e.POST("/repos/status", f.handleRepoStatuses)
+
+		// per-account reads are answered by the account's shard
+		for _, m := range []string{
+			"com.atproto.sync.getBlocks",
+			"com.atproto.sync.getLatestCommit",
+			"com.atproto.sync.getRecord",
+			"com.atproto.sync.getRepo",
+			"com.atproto.sync.getRepoStatus",
+		} {
+			e.GET("/xrpc/"+m, f.handleProxyByDid)
+		}
+	}
+
+	e.Listener = listen
+	srv := &http.Server{}
+	f.server.Store(srv)
+	return e.StartServer(srv)
+}
+
+func (f *Fanout) handleHealthCheck(c echo.Context) error {
+	if f.draining.Load() {
+		return c.JSON(503, bgs.HealthStatus{Status: "draining", Message: "shutting down"})
+	}
+	return c.JSON(200, bgs.HealthStatus{Status: "ok"})
+}
+
+// newHealthChecker sets up the readiness checks served at /readyz. The merged firehose is incomplete while any shard is disconnected, so all of them must be connected.
+func (f *Fanout) newHealthChecker() *health.Checker {
+	hc := health.NewChecker("bigsky-fanout")
+	hc.Add("shutdown", func(ctx context.Context) error {
+		if f.draining.Load() {
+			return errors.New("shutting down")
+		}
+		return nil
+	})
+	hc.Add("database", func(ctx context.Context) error {
+		return f.db.WithContext(ctx).Exec("SELECT 1").Error
+	})
+	if f.bus != nil {
+		// shard connections aren't used
+		return hc
+	}
+	hc.Add("shards", func(ctx context.Context) error {
+		var down []string
+		for i, host := range f.shards {
+			if !f.shardConnected[i].Load() {
+				down = append(down, host)
+			}
+		}
+		if len(down) > 0 {
+			return fmt.Errorf("shards not connected: %s", strings.Join(down, ", "))
+		}
+		return nil
+	})
+	return hc
+}
+
+func (f *Fanout) handleProxyByDid(c echo.Context) error {
+	did := c.QueryParam("did")
+	if did == "" {
+		return &echo.HTTPError{
+			Code:    http.StatusBadRequest,
+			Message: "must pass a did",
+		}
+	}
+
+	f.proxies[bgs.ShardForDid(did, len(f.shards))].ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// handleRequestCrawl passes crawl requests on to every shard, since every shard subscribes to every host. If no shard accepts the request, the first shard's response is returned.
+func (f *Fanout) handleRequestCrawl(c echo.Context) error {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+
+	var first *echo.HTTPError
+	accepted := false
+	for _, host := range f.shards {
+		req, err := http.NewRequestWithContext(c.Request().Context(), http.MethodPost, f.shardURL("http", host)+"/xrpc/com.atproto.sync.requestCrawl", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set(echo.HeaderContentType, c.Request().Header.Get(echo.HeaderContentType))
+
+		resp, err := f.client.Do(req)
+		if err != nil {
+			log.Warn("failed to pass crawl request to shard", "host", host, "err", err)
+			if first == nil {
+				first = &echo.HTTPError{Code: http.StatusBadGateway, Message: "failed to reach shard"}
+			}
+			continue
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		if resp.StatusCode/100 == 2 {
+			accepted = true
+		} else if first == nil {
+			first = &echo.HTTPError{Code: resp.StatusCode, Message: string(msg)}
+		}
+	}
+
+	if !accepted && first != nil {
+		return first
+	}
+
+	return c.JSON(200, map[string]any{})
+}
+
+// handleRepoStatuses splits a bulk account status query between the shards owning the accounts, and merges their responses in the order requested
+func (f *Fanout) handleRepoStatuses(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var body bgs.RepoStatusesRequest
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid body")
+	}
+	if len(body.Dids) > bgs.MaxRepoStatusQuery {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("too many dids requested (max %d)", bgs.MaxRepoStatusQuery))
+	}
+
+	byShard := make(map[int][]string)
+	for _, did := range body.Dids {
+		i := bgs.ShardForDid(did, len(f.shards))
+		byShard[i] = append(byShard[i], did)
+	}
+
+	var lk sync.Mutex
+	statuses := make(map[string]*comatproto.SyncGetRepoStatus_Output, len(body.Dids))
+	eg, ctx := errgroup.WithContext(ctx)
+	for i, dids := range byShard {
+		eg.Go(func() error {
+			resp, err := f.shardRepoStatuses(ctx, f.shards[i], dids)
+			if err != nil {
+				return err
+			}
+			lk.Lock()
+			defer lk.Unlock()
+			for _, r := range resp.Repos {
+				statuses[r.Did] = r
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		log.Warn("failed to query shard for account statuses", "err", err)
+		return err
+	}
+
+	out := bgs.RepoStatusesResponse{Repos: []*comatproto.SyncGetRepoStatus_Output{}}
+	for _, did := range body.Dids {
+		if r, ok := statuses[did]; ok {
+			out.Repos = append(out.Repos, r)
+			delete(statuses, did)
+		}
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+func (f *Fanout) shardRepoStatuses(ctx context.Context, host string, dids []string) (*bgs.RepoStatusesResponse, error) {
+	body, err := json.Marshal(bgs.RepoStatusesRequest{Dids: dids})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.shardURL("http", host)+"/repos/status", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, &echo.HTTPError{Code: http.StatusBadGateway, Message: "failed to reach shard", Internal: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &echo.HTTPError{Code: resp.StatusCode, Message: string(msg)}
+	}
+
+	var out bgs.RepoStatusesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, &echo.HTTPError{Code: http.StatusBadGateway, Message: "invalid response from shard", Internal: err}
+	}
+	return &out, nil
+}
+
+func (f *Fanout) handleSubscribeRepos(c echo.Context) error {
+	if f.draining.Load() {
+		return &echo.HTTPError{
+			Code:    http.StatusServiceUnavailable,
+			Message: "shutting down",
+		}
+	}
+
+	var since *int64
+	if sinceVal := c.QueryParam("cursor"); sinceVal != "" {
+		sval, err := strconv.ParseInt(sinceVal, 10, 64)
+		if err != nil {
+			return err
+		}
+		since = &sval
+	}
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+
+	conn, err := websocket.Upgrade(c.Response(), c.Request(), c.Response().Header(), 10<<10, 10<<10)
+	if err != nil {
+		return fmt.Errorf("upgrading websocket: %w", err)
+	}
+	defer conn.Close()
+
+	// read and discard messages from the client, to notice when it goes away
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	evts, cleanup, err := f.events.Subscribe(ctx, c.RealIP()+"-"+c.Request().UserAgent(), nil, since)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case evt, ok := <-evts:
+			if !ok {
+				return nil
+			}
+
+			wc, err := conn.NextWriter(websocket.BinaryMessage)
+			if err != nil {
+				return err
+			}
+			if evt.Preserialized != nil {
+				_, err = wc.Write(evt.Preserialized)
+			} else {
+				err = evt.Serialize(wc)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to write event: %w", err)
+			}
+			if err := wc.Close(); err != nil {
+				return nil
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(5*time.Second)); err != nil {
+				return nil
+			}
+		case <-f.consumersExit:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down"), time.Now().Add(time.Second))
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
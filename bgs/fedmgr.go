@@ -5,15 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
-	"strings"
+	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/RussellLuo/slidingwindow"
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/events/schedulers/parallel"
+	"github.com/bluesky-social/indigo/indexer"
 	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/util/ratelimit"
 	"go.opentelemetry.io/otel"
 	"golang.org/x/time/rate"
 
@@ -33,8 +36,19 @@ type Slurper struct {
 	lk     sync.Mutex
 	active map[string]*activeSub
 
+	statsLk sync.Mutex
+	stats   map[uint]*hostStats
+
+	trustCfg HostTrustConfig
+	trustLk  sync.RWMutex
+	trust    map[uint]string
+
+	staleCfg StaleHostConfig
+
 	LimitMux              sync.RWMutex
 	Limiters              map[uint]*Limiters
+	AdaptiveLimiters      map[uint]*AdaptiveLimiters
+	DefaultDialLimit      rate.Limit
 	DefaultPerSecondLimit int64
 	DefaultPerHourLimit   int64
 	DefaultPerDayLimit    int64
@@ -44,21 +58,37 @@ type Slurper struct {
 	ConcurrencyPerPDS int64
 	MaxQueuePerPDS    int64
 
-	NewPDSPerDayLimiter *slidingwindow.Limiter
+	NewPDSPerDayLimiter *ratelimit.SlidingWindow
 
 	newSubsDisabled bool
 	trustedDomains  []string
+	// only hosts matching trustedDomains may be subscribed to, unless an admin overrides
+	trustedDomainsOnly bool
 
-	shutdownChan   chan bool
-	shutdownResult chan []error
+	// closed on shutdown to stop the periodic cursor flush
+	shutdownChan chan struct{}
+	// set on shutdown, to refuse new subscriptions
+	shuttingDown bool
+
+	// events read from upstream are processed under this context, which is only cancelled if shutdown times out draining them
+	processCtx    context.Context
+	processCancel func()
 
 	ssl bool
 }
 
 type Limiters struct {
-	PerSecond *slidingwindow.Limiter
-	PerHour   *slidingwindow.Limiter
-	PerDay    *slidingwindow.Limiter
+	PerSecond *ratelimit.SlidingWindow
+	PerHour   *ratelimit.SlidingWindow
+	PerDay    *ratelimit.SlidingWindow
+}
+
+// AdaptiveLimiters are per-host limiters which slow down when a host is struggling or misbehaving, on top of the fixed event limits (see indexer.HostLimiter)
+type AdaptiveLimiters struct {
+	// Connection attempts to the host's firehose. Backs off on failed dials, and honors HTTP 429 responses
+	Dial *indexer.HostLimiter
+	// Events from the host. Backs off when the host's events fail to process
+	Events *indexer.HostLimiter
 }
 
 type SlurperOptions struct {
@@ -67,9 +97,12 @@ type SlurperOptions struct {
 	DefaultPerHourLimit   int64
 	DefaultPerDayLimit    int64
 	DefaultCrawlLimit     rate.Limit
+	DefaultDialLimit      rate.Limit
 	DefaultRepoLimit      int64
 	ConcurrencyPerPDS     int64
 	MaxQueuePerPDS        int64
+	HostTrust             HostTrustConfig
+	StaleHost             StaleHostConfig
 }
 
 func DefaultSlurperOptions() *SlurperOptions {
@@ -79,9 +112,12 @@ func DefaultSlurperOptions() *SlurperOptions {
 		DefaultPerHourLimit:   2500,
 		DefaultPerDayLimit:    20_000,
 		DefaultCrawlLimit:     rate.Limit(5),
+		DefaultDialLimit:      rate.Every(time.Second),
 		DefaultRepoLimit:      100,
 		ConcurrencyPerPDS:     100,
 		MaxQueuePerPDS:        1_000,
+		HostTrust:             DefaultHostTrustConfig(),
+		StaleHost:             DefaultStaleHostConfig(),
 	}
 }
 
@@ -90,18 +126,28 @@ type activeSub struct {
 	lk     sync.RWMutex
 	ctx    context.Context
 	cancel func()
+
+	// closed once the subscription has stopped and finished processing its events
+	done chan struct{}
+	// set if events read from the host were dropped unprocessed during shutdown, so its cursor can't be trusted
+	abandoned bool
 }
 
 func NewSlurper(db *gorm.DB, cb IndexCallback, opts *SlurperOptions) (*Slurper, error) {
 	if opts == nil {
 		opts = DefaultSlurperOptions()
 	}
-	db.AutoMigrate(&SlurpConfig{})
 	s := &Slurper{
 		cb:                    cb,
 		db:                    db,
 		active:                make(map[string]*activeSub),
+		stats:                 make(map[uint]*hostStats),
+		trustCfg:              opts.HostTrust,
+		trust:                 make(map[uint]string),
+		staleCfg:              opts.StaleHost,
 		Limiters:              make(map[uint]*Limiters),
+		AdaptiveLimiters:      make(map[uint]*AdaptiveLimiters),
+		DefaultDialLimit:      opts.DefaultDialLimit,
 		DefaultPerSecondLimit: opts.DefaultPerSecondLimit,
 		DefaultPerHourLimit:   opts.DefaultPerHourLimit,
 		DefaultPerDayLimit:    opts.DefaultPerDayLimit,
@@ -110,9 +156,9 @@ func NewSlurper(db *gorm.DB, cb IndexCallback, opts *SlurperOptions) (*Slurper,
 		ConcurrencyPerPDS:     opts.ConcurrencyPerPDS,
 		MaxQueuePerPDS:        opts.MaxQueuePerPDS,
 		ssl:                   opts.SSL,
-		shutdownChan:          make(chan bool),
-		shutdownResult:        make(chan []error),
+		shutdownChan:          make(chan struct{}),
 	}
+	s.processCtx, s.processCancel = context.WithCancel(context.Background())
 	if err := s.loadConfig(); err != nil {
 		return nil, err
 	}
@@ -122,18 +168,6 @@ func NewSlurper(db *gorm.DB, cb IndexCallback, opts *SlurperOptions) (*Slurper,
 		for {
 			select {
 			case <-s.shutdownChan:
-				log.Info("flushing PDS cursors on shutdown")
-				ctx := context.Background()
-				ctx, span := otel.Tracer("feedmgr").Start(ctx, "CursorFlusherShutdown")
-				defer span.End()
-				var errs []error
-				if errs = s.flushCursors(ctx); len(errs) > 0 {
-					for _, err := range errs {
-						log.Errorf("failed to flush cursors on shutdown: %s", err)
-					}
-				}
-				log.Info("done flushing PDS cursors on shutdown")
-				s.shutdownResult <- errs
 				return
 			case <-time.After(time.Second * 10):
 				log.Debug("flushing PDS cursors")
@@ -142,7 +176,7 @@ func NewSlurper(db *gorm.DB, cb IndexCallback, opts *SlurperOptions) (*Slurper,
 				defer span.End()
 				if errs := s.flushCursors(ctx); len(errs) > 0 {
 					for _, err := range errs {
-						log.Errorf("failed to flush cursors: %s", err)
+						log.Error("failed to flush cursors", "err", err)
 					}
 				}
 				log.Debug("done flushing PDS cursors")
@@ -153,10 +187,6 @@ func NewSlurper(db *gorm.DB, cb IndexCallback, opts *SlurperOptions) (*Slurper,
 	return s, nil
 }
 
-func windowFunc() (slidingwindow.Window, slidingwindow.StopFunc) {
-	return slidingwindow.NewLocalWindow()
-}
-
 func (s *Slurper) GetLimiters(pdsID uint) *Limiters {
 	s.LimitMux.RLock()
 	defer s.LimitMux.RUnlock()
@@ -164,17 +194,16 @@ func (s *Slurper) GetLimiters(pdsID uint) *Limiters {
 }
 
 func (s *Slurper) GetOrCreateLimiters(pdsID uint, perSecLimit int64, perHourLimit int64, perDayLimit int64) *Limiters {
+	perSecLimit, perHourLimit, perDayLimit = s.capLimits(pdsID, perSecLimit, perHourLimit, perDayLimit)
+
 	s.LimitMux.RLock()
 	defer s.LimitMux.RUnlock()
 	lim, ok := s.Limiters[pdsID]
 	if !ok {
-		perSec, _ := slidingwindow.NewLimiter(time.Second, perSecLimit, windowFunc)
-		perHour, _ := slidingwindow.NewLimiter(time.Hour, perHourLimit, windowFunc)
-		perDay, _ := slidingwindow.NewLimiter(time.Hour*24, perDayLimit, windowFunc)
 		lim = &Limiters{
-			PerSecond: perSec,
-			PerHour:   perHour,
-			PerDay:    perDay,
+			PerSecond: ratelimit.NewSlidingWindow(time.Second, perSecLimit),
+			PerHour:   ratelimit.NewSlidingWindow(time.Hour, perHourLimit),
+			PerDay:    ratelimit.NewSlidingWindow(time.Hour*24, perDayLimit),
 		}
 		s.Limiters[pdsID] = lim
 	}
@@ -183,17 +212,16 @@ func (s *Slurper) GetOrCreateLimiters(pdsID uint, perSecLimit int64, perHourLimi
 }
 
 func (s *Slurper) SetLimits(pdsID uint, perSecLimit int64, perHourLimit int64, perDayLimit int64) {
+	perSecLimit, perHourLimit, perDayLimit = s.capLimits(pdsID, perSecLimit, perHourLimit, perDayLimit)
+
 	s.LimitMux.Lock()
 	defer s.LimitMux.Unlock()
 	lim, ok := s.Limiters[pdsID]
 	if !ok {
-		perSec, _ := slidingwindow.NewLimiter(time.Second, perSecLimit, windowFunc)
-		perHour, _ := slidingwindow.NewLimiter(time.Hour, perHourLimit, windowFunc)
-		perDay, _ := slidingwindow.NewLimiter(time.Hour*24, perDayLimit, windowFunc)
 		lim = &Limiters{
-			PerSecond: perSec,
-			PerHour:   perHour,
-			PerDay:    perDay,
+			PerSecond: ratelimit.NewSlidingWindow(time.Second, perSecLimit),
+			PerHour:   ratelimit.NewSlidingWindow(time.Hour, perHourLimit),
+			PerDay:    ratelimit.NewSlidingWindow(time.Hour*24, perDayLimit),
 		}
 		s.Limiters[pdsID] = lim
 	}
@@ -201,17 +229,78 @@ func (s *Slurper) SetLimits(pdsID uint, perSecLimit int64, perHourLimit int64, p
 	lim.PerSecond.SetLimit(perSecLimit)
 	lim.PerHour.SetLimit(perHourLimit)
 	lim.PerDay.SetLimit(perDayLimit)
+
+	if al, ok := s.AdaptiveLimiters[pdsID]; ok {
+		al.Events.SetLimit(rate.Limit(perSecLimit))
+	}
 }
 
-// Shutdown shuts down the slurper
-func (s *Slurper) Shutdown() []error {
-	s.shutdownChan <- true
-	log.Info("waiting for slurper shutdown")
-	errs := <-s.shutdownResult
-	if len(errs) > 0 {
-		for _, err := range errs {
-			log.Errorf("shutdown error: %s", err)
+func (s *Slurper) GetAdaptiveLimiters(pdsID uint) *AdaptiveLimiters {
+	s.LimitMux.RLock()
+	defer s.LimitMux.RUnlock()
+	return s.AdaptiveLimiters[pdsID]
+}
+
+func (s *Slurper) GetOrCreateAdaptiveLimiters(pdsID uint, perSecLimit int64) *AdaptiveLimiters {
+	perSecLimit, _, _ = s.capLimits(pdsID, perSecLimit, 0, 0)
+
+	s.LimitMux.Lock()
+	defer s.LimitMux.Unlock()
+	al, ok := s.AdaptiveLimiters[pdsID]
+	if !ok {
+		al = &AdaptiveLimiters{
+			Dial:   indexer.NewHostLimiter(s.DefaultDialLimit),
+			Events: indexer.NewHostLimiter(rate.Limit(perSecLimit)),
+		}
+		s.AdaptiveLimiters[pdsID] = al
+	}
+
+	return al
+}
+
+var ErrSlurperShutdown = fmt.Errorf("slurper is shutting down")
+
+// Shutdown closes every upstream subscription and saves their cursors. Events already read from each host are processed first, so the saved cursors don't skip any. If ctx expires before that finishes, the remaining events are dropped, and the affected hosts keep their last periodically flushed cursor, so those events are read again after a restart.
+func (s *Slurper) Shutdown(ctx context.Context) []error {
+	s.lk.Lock()
+	s.shuttingDown = true
+	subs := make([]*activeSub, 0, len(s.active))
+	for _, sub := range s.active {
+		sub.cancel()
+		subs = append(subs, sub)
+	}
+	s.lk.Unlock()
+
+	close(s.shutdownChan)
+
+	log.Info("waiting for upstream subscriptions to finish processing", "subscriptions", len(subs))
+	for _, sub := range subs {
+		select {
+		case <-sub.done:
+		case <-ctx.Done():
+			log.Warn("timed out draining upstream subscriptions, dropping queued events")
+			s.processCancel()
+			<-sub.done
+		}
+	}
+
+	drained := make([]*activeSub, 0, len(subs))
+	for _, sub := range subs {
+		sub.lk.RLock()
+		if sub.abandoned {
+			log.Warn("not saving cursor for host with dropped events", "host", sub.pds.Host, "cursor", sub.pds.Cursor)
+		} else {
+			drained = append(drained, sub)
 		}
+		sub.lk.RUnlock()
+	}
+
+	ctx, span := otel.Tracer("feedmgr").Start(context.Background(), "CursorFlusherShutdown")
+	defer span.End()
+
+	errs := s.writeCursors(ctx, drained)
+	for _, err := range errs {
+		log.Error("failed to flush cursors on shutdown", "err", err)
 	}
 	log.Info("slurper shutdown complete")
 	return errs
@@ -231,8 +320,9 @@ func (s *Slurper) loadConfig() error {
 
 	s.newSubsDisabled = sc.NewSubsDisabled
 	s.trustedDomains = sc.TrustedDomains
+	s.trustedDomainsOnly = sc.TrustedDomainsOnly
 
-	s.NewPDSPerDayLimiter, _ = slidingwindow.NewLimiter(time.Hour*24, sc.NewPDSPerDayLimit, windowFunc)
+	s.NewPDSPerDayLimiter = ratelimit.NewSlidingWindow(time.Hour*24, sc.NewPDSPerDayLimit)
 
 	return nil
 }
@@ -240,9 +330,10 @@ func (s *Slurper) loadConfig() error {
 type SlurpConfig struct {
 	gorm.Model
 
-	NewSubsDisabled   bool
-	TrustedDomains    pq.StringArray `gorm:"type:text[]"`
-	NewPDSPerDayLimit int64
+	NewSubsDisabled    bool
+	TrustedDomains     pq.StringArray `gorm:"type:text[]"`
+	TrustedDomainsOnly bool
+	NewPDSPerDayLimit  int64
 }
 
 func (s *Slurper) SetNewSubsDisabled(dis bool) error {
@@ -332,6 +423,25 @@ func (s *Slurper) GetTrustedDomains() []string {
 	return s.trustedDomains
 }
 
+// SetTrustedDomainsOnly toggles allowlist mode, where new subscriptions (other than admin requested ones) are only made to hosts matching a trusted domain
+func (s *Slurper) SetTrustedDomainsOnly(only bool) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if err := s.db.Model(SlurpConfig{}).Where("id = 1").Update("trusted_domains_only", only).Error; err != nil {
+		return err
+	}
+
+	s.trustedDomainsOnly = only
+	return nil
+}
+
+func (s *Slurper) GetTrustedDomainsOnly() bool {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.trustedDomainsOnly
+}
+
 var ErrNewSubsDisabled = fmt.Errorf("new subscriptions temporarily disabled")
 
 // Checks whether a host is allowed to be subscribed to
@@ -342,22 +452,29 @@ func (s *Slurper) canSlurpHost(host string) bool {
 		return false
 	}
 
-	// Check if the host is a trusted domain
+	if s.isTrustedDomain(host) {
+		return true
+	}
+
+	return !s.newSubsDisabled && !s.trustedDomainsOnly
+}
+
+// IsTrustedDomain reports whether host matches one of the trusted domains
+func (s *Slurper) IsTrustedDomain(host string) bool {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.isTrustedDomain(host)
+}
+
+// must be called with the slurper lock held
+func (s *Slurper) isTrustedDomain(host string) bool {
+	// a "*." prefix is a wildcard over subdomains
 	for _, d := range s.trustedDomains {
-		// If the domain starts with a *., it's a wildcard
-		if strings.HasPrefix(d, "*.") {
-			// Cut off the * so we have .domain.com
-			if strings.HasSuffix(host, strings.TrimPrefix(d, "*")) {
-				return true
-			}
-		} else {
-			if host == d {
-				return true
-			}
+		if domainPatternMatches(d, host) {
+			return true
 		}
 	}
-
-	return !s.newSubsDisabled
+	return false
 }
 
 func (s *Slurper) SubscribeToPds(ctx context.Context, host string, reg bool, adminOverride bool) error {
@@ -365,6 +482,10 @@ func (s *Slurper) SubscribeToPds(ctx context.Context, host string, reg bool, adm
 	s.lk.Lock()
 	defer s.lk.Unlock()
 
+	if s.shuttingDown {
+		return ErrSlurperShutdown
+	}
+
 	_, ok := s.active[host]
 	if ok {
 		return nil
@@ -379,6 +500,18 @@ func (s *Slurper) SubscribeToPds(ctx context.Context, host string, reg bool, adm
 		return fmt.Errorf("cannot subscribe to blocked pds")
 	}
 
+	if peering.Paused {
+		return ErrHostPaused
+	}
+
+	banned, err := hostIsBanned(ctx, s.db, host)
+	if err != nil {
+		return fmt.Errorf("failed to check pds ban status: %w", err)
+	}
+	if banned {
+		return ErrDomainBanned
+	}
+
 	if peering.ID == 0 {
 		if !adminOverride && !s.canSlurpHost(host) {
 			return ErrNewSubsDisabled
@@ -408,11 +541,14 @@ func (s *Slurper) SubscribeToPds(ctx context.Context, host string, reg bool, adm
 		}
 	}
 
+	s.cacheHostTrust(&peering)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	sub := activeSub{
 		pds:    &peering,
 		ctx:    ctx,
 		cancel: cancel,
+		done:   make(chan struct{}),
 	}
 	s.active[host] = &sub
 
@@ -427,19 +563,35 @@ func (s *Slurper) RestartAll() error {
 	s.lk.Lock()
 	defer s.lk.Unlock()
 
+	if s.shuttingDown {
+		return ErrSlurperShutdown
+	}
+
 	var all []models.PDS
-	if err := s.db.Find(&all, "registered = true AND blocked = false").Error; err != nil {
+	if err := s.db.Find(&all, "registered = true AND blocked = false AND paused = false").Error; err != nil {
 		return err
 	}
 
 	for _, pds := range all {
 		pds := pds
 
+		banned, err := hostIsBanned(context.Background(), s.db, pds.Host)
+		if err != nil {
+			return err
+		}
+		if banned {
+			log.Warn("not resubscribing to pds with banned domain", "host", pds.Host)
+			continue
+		}
+
+		s.cacheHostTrust(&pds)
+
 		ctx, cancel := context.WithCancel(context.Background())
 		sub := activeSub{
 			pds:    &pds,
 			ctx:    ctx,
 			cancel: cancel,
+			done:   make(chan struct{}),
 		}
 		s.active[pds.Host] = &sub
 
@@ -452,11 +604,15 @@ func (s *Slurper) RestartAll() error {
 }
 
 func (s *Slurper) subscribeWithRedialer(ctx context.Context, host *models.PDS, sub *activeSub) {
+	defer close(sub.done)
 	defer func() {
 		s.lk.Lock()
 		defer s.lk.Unlock()
 
-		delete(s.active, host.Host)
+		// the host may have been resubscribed since this subscription was cancelled
+		if s.active[host.Host] == sub {
+			delete(s.active, host.Host)
+		}
 	}()
 
 	d := websocket.Dialer{
@@ -470,6 +626,9 @@ func (s *Slurper) subscribeWithRedialer(ctx context.Context, host *models.PDS, s
 
 	cursor := host.Cursor
 
+	dialLimiter := s.GetOrCreateAdaptiveLimiters(host.ID, int64(host.RateLimit)).Dial
+	stats := s.hostStatsFor(host.ID)
+
 	var backoff int
 	for {
 		select {
@@ -478,17 +637,27 @@ func (s *Slurper) subscribeWithRedialer(ctx context.Context, host *models.PDS, s
 		default:
 		}
 
+		if err := dialLimiter.Wait(ctx); err != nil {
+			return
+		}
+
 		url := fmt.Sprintf("%s://%s/xrpc/com.atproto.sync.subscribeRepos?cursor=%d", protocol, host.Host, cursor)
 		con, res, err := d.DialContext(ctx, url, nil)
+		if res != nil && res.StatusCode == http.StatusTooManyRequests {
+			dialLimiter.ObserveRateLimited(rateLimitReset(res.Header, time.Now()))
+		} else {
+			dialLimiter.Observe(err)
+		}
 		if err != nil {
-			log.Warnw("dialing failed", "host", host.Host, "err", err, "backoff", backoff)
+			log.Warn("dialing failed", "host", host.Host, "err", err, "backoff", backoff)
+			stats.setConnected(false, err)
 			time.Sleep(sleepForBackoff(backoff))
 			backoff++
 
 			if backoff > 15 {
-				log.Warnw("pds does not appear to be online, disabling for now", "host", host.Host)
+				log.Warn("pds does not appear to be online, disabling for now", "host", host.Host)
 				if err := s.db.Model(&models.PDS{}).Where("id = ?", host.ID).Update("registered", false).Error; err != nil {
-					log.Errorf("failed to unregister failing pds: %w", err)
+					log.Error("failed to unregister failing pds", "err", err)
 				}
 
 				return
@@ -497,15 +666,26 @@ func (s *Slurper) subscribeWithRedialer(ctx context.Context, host *models.PDS, s
 			continue
 		}
 
-		log.Info("event subscription response code: ", res.StatusCode)
+		log.Info("event subscription response", "host", host.Host, "code", res.StatusCode)
+
+		stats.setConnected(true, nil)
 
 		curCursor := cursor
-		if err := s.handleConnection(ctx, host, con, &cursor, sub); err != nil {
+		err = s.handleConnection(ctx, host, con, &cursor, sub)
+		if ctx.Err() != nil {
+			// closed on our end, not a failure of the host
+			err = nil
+		}
+		stats.setConnected(false, err)
+		if err != nil {
 			if errors.Is(err, ErrTimeoutShutdown) {
-				log.Infof("shutting down pds subscription to %s, no activity after %s", host.Host, EventsTimeout)
+				log.Info("shutting down pds subscription, no activity", "host", host.Host, "timeout", EventsTimeout)
 				return
 			}
-			log.Warnf("connection to %q failed: %s", host.Host, err)
+			if errors.Is(err, ErrHostStale) {
+				s.observeStale(host)
+			}
+			log.Warn("connection to pds failed", "host", host.Host, "err", err)
 		}
 
 		if cursor > curCursor {
@@ -514,6 +694,24 @@ func (s *Slurper) subscribeWithRedialer(ctx context.Context, host *models.PDS, s
 	}
 }
 
+// Parses when a rate limited client may retry, from either a Retry-After (seconds or HTTP date) or RateLimit-Reset (unix timestamp) header. Returns the zero time if neither is set.
+func rateLimitReset(h http.Header, now time.Time) time.Time {
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return now.Add(time.Duration(secs) * time.Second)
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return t
+		}
+	}
+	if v := h.Get("RateLimit-Reset"); v != "" {
+		if ts, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(ts, 0)
+		}
+	}
+	return time.Time{}
+}
+
 func sleepForBackoff(b int) time.Duration {
 	if b == 0 {
 		return 0
@@ -534,135 +732,171 @@ func (s *Slurper) handleConnection(ctx context.Context, host *models.PDS, con *w
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	rsc := &events.RepoStreamCallbacks{
-		RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
-			log.Debugw("got remote repo event", "host", host.Host, "repo", evt.Repo, "seq", evt.Seq)
-			if err := s.cb(context.TODO(), host, &events.XRPCStreamEvent{
-				RepoCommit: evt,
-			}); err != nil {
-				log.Errorf("failed handling event from %q (%d): %s", host.Host, evt.Seq, err)
+	// events which fail to process slow down consumption from this host, so one misbehaving PDS can't monopolize the indexer
+	eventLimiter := s.GetOrCreateAdaptiveLimiters(host.ID, int64(host.RateLimit)).Events
+	stats := s.hostStatsFor(host.ID)
+	handle := func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+		// events already read are still processed after the subscription is cancelled, so its cursor doesn't skip past them
+		if err := eventLimiter.Wait(s.processCtx); err != nil {
+			if s.processCtx.Err() != nil {
+				sub.lk.Lock()
+				sub.abandoned = true
+				sub.lk.Unlock()
 			}
-			*lastCursor = evt.Seq
+			return err
+		}
+		err := s.cb(ctx, host, evt)
+		eventLimiter.Observe(err)
+		stats.observeEvent(err)
+		return err
+	}
 
-			if err := s.updateCursor(sub, *lastCursor); err != nil {
-				return fmt.Errorf("updating cursor: %w", err)
-			}
+	// built per event, so handlers get the event's context (and trace)
+	callbacks := func(ctx context.Context) *events.RepoStreamCallbacks {
+		return &events.RepoStreamCallbacks{
+			RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
+				eventLog.Debug("got remote repo event", "host", host.Host, "repo", evt.Repo, "seq", evt.Seq)
+				if err := handle(ctx, &events.XRPCStreamEvent{
+					RepoCommit: evt,
+				}); err != nil {
+					eventLog.Error("failed handling event", "host", host.Host, "seq", evt.Seq, "err", err)
+				}
+				*lastCursor = evt.Seq
 
-			return nil
-		},
-		RepoHandle: func(evt *comatproto.SyncSubscribeRepos_Handle) error {
-			log.Infow("got remote handle update event", "host", host.Host, "did", evt.Did, "handle", evt.Handle)
-			if err := s.cb(context.TODO(), host, &events.XRPCStreamEvent{
-				RepoHandle: evt,
-			}); err != nil {
-				log.Errorf("failed handling event from %q (%d): %s", host.Host, evt.Seq, err)
-			}
-			*lastCursor = evt.Seq
+				if err := s.updateCursor(sub, *lastCursor); err != nil {
+					return fmt.Errorf("updating cursor: %w", err)
+				}
 
-			if err := s.updateCursor(sub, *lastCursor); err != nil {
-				return fmt.Errorf("updating cursor: %w", err)
-			}
+				return nil
+			},
+			RepoHandle: func(evt *comatproto.SyncSubscribeRepos_Handle) error {
+				log.Info("got remote handle update event", "host", host.Host, "did", evt.Did, "handle", evt.Handle)
+				if err := handle(ctx, &events.XRPCStreamEvent{
+					RepoHandle: evt,
+				}); err != nil {
+					eventLog.Error("failed handling event", "host", host.Host, "seq", evt.Seq, "err", err)
+				}
+				*lastCursor = evt.Seq
 
-			return nil
-		},
-		RepoMigrate: func(evt *comatproto.SyncSubscribeRepos_Migrate) error {
-			log.Infow("got remote repo migrate event", "host", host.Host, "did", evt.Did, "migrateTo", evt.MigrateTo)
-			if err := s.cb(context.TODO(), host, &events.XRPCStreamEvent{
-				RepoMigrate: evt,
-			}); err != nil {
-				log.Errorf("failed handling event from %q (%d): %s", host.Host, evt.Seq, err)
-			}
-			*lastCursor = evt.Seq
+				if err := s.updateCursor(sub, *lastCursor); err != nil {
+					return fmt.Errorf("updating cursor: %w", err)
+				}
 
-			if err := s.updateCursor(sub, *lastCursor); err != nil {
-				return fmt.Errorf("updating cursor: %w", err)
-			}
+				return nil
+			},
+			RepoMigrate: func(evt *comatproto.SyncSubscribeRepos_Migrate) error {
+				log.Info("got remote repo migrate event", "host", host.Host, "did", evt.Did, "migrateTo", evt.MigrateTo)
+				if err := handle(ctx, &events.XRPCStreamEvent{
+					RepoMigrate: evt,
+				}); err != nil {
+					eventLog.Error("failed handling event", "host", host.Host, "seq", evt.Seq, "err", err)
+				}
+				*lastCursor = evt.Seq
 
-			return nil
-		},
-		RepoTombstone: func(evt *comatproto.SyncSubscribeRepos_Tombstone) error {
-			log.Infow("got remote repo tombstone event", "host", host.Host, "did", evt.Did)
-			if err := s.cb(context.TODO(), host, &events.XRPCStreamEvent{
-				RepoTombstone: evt,
-			}); err != nil {
-				log.Errorf("failed handling event from %q (%d): %s", host.Host, evt.Seq, err)
-			}
-			*lastCursor = evt.Seq
+				if err := s.updateCursor(sub, *lastCursor); err != nil {
+					return fmt.Errorf("updating cursor: %w", err)
+				}
 
-			if err := s.updateCursor(sub, *lastCursor); err != nil {
-				return fmt.Errorf("updating cursor: %w", err)
-			}
+				return nil
+			},
+			RepoTombstone: func(evt *comatproto.SyncSubscribeRepos_Tombstone) error {
+				log.Info("got remote repo tombstone event", "host", host.Host, "did", evt.Did)
+				if err := handle(ctx, &events.XRPCStreamEvent{
+					RepoTombstone: evt,
+				}); err != nil {
+					eventLog.Error("failed handling event", "host", host.Host, "seq", evt.Seq, "err", err)
+				}
+				*lastCursor = evt.Seq
 
-			return nil
-		},
-		RepoInfo: func(info *comatproto.SyncSubscribeRepos_Info) error {
-			log.Infow("info event", "name", info.Name, "message", info.Message, "host", host.Host)
-			return nil
-		},
-		RepoIdentity: func(ident *comatproto.SyncSubscribeRepos_Identity) error {
-			log.Infow("identity event", "did", ident.Did)
-			if err := s.cb(context.TODO(), host, &events.XRPCStreamEvent{
-				RepoIdentity: ident,
-			}); err != nil {
-				log.Errorf("failed handling event from %q (%d): %s", host.Host, ident.Seq, err)
-			}
-			*lastCursor = ident.Seq
+				if err := s.updateCursor(sub, *lastCursor); err != nil {
+					return fmt.Errorf("updating cursor: %w", err)
+				}
 
-			if err := s.updateCursor(sub, *lastCursor); err != nil {
-				return fmt.Errorf("updating cursor: %w", err)
-			}
+				return nil
+			},
+			RepoInfo: func(info *comatproto.SyncSubscribeRepos_Info) error {
+				log.Info("info event", "name", info.Name, "message", info.Message, "host", host.Host)
+				return nil
+			},
+			RepoIdentity: func(ident *comatproto.SyncSubscribeRepos_Identity) error {
+				log.Info("identity event", "did", ident.Did)
+				if err := handle(ctx, &events.XRPCStreamEvent{
+					RepoIdentity: ident,
+				}); err != nil {
+					eventLog.Error("failed handling event", "host", host.Host, "seq", ident.Seq, "err", err)
+				}
+				*lastCursor = ident.Seq
 
-			return nil
-		},
-		RepoAccount: func(acct *comatproto.SyncSubscribeRepos_Account) error {
-			log.Infow("account event", "did", acct.Did, "status", acct.Status)
-			if err := s.cb(context.TODO(), host, &events.XRPCStreamEvent{
-				RepoAccount: acct,
-			}); err != nil {
-				log.Errorf("failed handling event from %q (%d): %s", host.Host, acct.Seq, err)
-			}
-			*lastCursor = acct.Seq
+				if err := s.updateCursor(sub, *lastCursor); err != nil {
+					return fmt.Errorf("updating cursor: %w", err)
+				}
 
-			if err := s.updateCursor(sub, *lastCursor); err != nil {
-				return fmt.Errorf("updating cursor: %w", err)
-			}
+				return nil
+			},
+			RepoAccount: func(acct *comatproto.SyncSubscribeRepos_Account) error {
+				log.Info("account event", "did", acct.Did, "status", acct.Status)
+				if err := handle(ctx, &events.XRPCStreamEvent{
+					RepoAccount: acct,
+				}); err != nil {
+					eventLog.Error("failed handling event", "host", host.Host, "seq", acct.Seq, "err", err)
+				}
+				*lastCursor = acct.Seq
 
-			return nil
-		},
-		// TODO: all the other event types (handle change, migration, etc)
-		Error: func(errf *events.ErrorFrame) error {
-			switch errf.Error {
-			case "FutureCursor":
-				// if we get a FutureCursor frame, reset our sequence number for this host
-				if err := s.db.Table("pds").Where("id = ?", host.ID).Update("cursor", 0).Error; err != nil {
-					return err
+				if err := s.updateCursor(sub, *lastCursor); err != nil {
+					return fmt.Errorf("updating cursor: %w", err)
 				}
 
-				*lastCursor = 0
-				return fmt.Errorf("got FutureCursor frame, reset cursor tracking for host")
-			default:
-				return fmt.Errorf("error frame: %s: %s", errf.Error, errf.Message)
-			}
-		},
+				return nil
+			},
+			// TODO: all the other event types (handle change, migration, etc)
+			Error: func(errf *events.ErrorFrame) error {
+				switch errf.Error {
+				case "FutureCursor":
+					// if we get a FutureCursor frame, reset our sequence number for this host
+					if err := s.db.Table("pds").Where("id = ?", host.ID).Update("cursor", 0).Error; err != nil {
+						return err
+					}
+
+					*lastCursor = 0
+					return fmt.Errorf("got FutureCursor frame, reset cursor tracking for host")
+				default:
+					return fmt.Errorf("error frame: %s: %s", errf.Error, errf.Message)
+				}
+			},
+		}
+	}
+	dispatch := func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+		return callbacks(ctx).EventHandler(ctx, evt)
 	}
 
 	lims := s.GetOrCreateLimiters(host.ID, int64(host.RateLimit), host.HourlyEventLimit, host.DailyEventLimit)
 
-	limiters := []*slidingwindow.Limiter{
+	limiters := []ratelimit.Limiter{
 		lims.PerSecond,
 		lims.PerHour,
 		lims.PerDay,
 	}
 
-	instrumentedRSC := events.NewInstrumentedRepoStreamCallbacks(limiters, rsc.EventHandler)
+	instrumentedRSC := events.NewInstrumentedRepoStreamCallbacks(limiters, dispatch)
 
+	act := newConnActivity()
 	pool := parallel.NewScheduler(
 		100,
 		1_000,
 		con.RemoteAddr().String(),
-		instrumentedRSC.EventHandler,
+		act.handler(instrumentedRSC.EventHandler),
 	)
-	return events.HandleRepoStream(ctx, con, pool)
+
+	var stale atomic.Bool
+	if s.staleCfg.Timeout > 0 {
+		go s.watchStale(ctx, host, act, cancel, &stale)
+	}
+
+	err := events.HandleRepoStreamWithHeartbeat(ctx, con, &activityScheduler{Scheduler: pool, act: act}, act.touch)
+	if stale.Load() {
+		return ErrHostStale
+	}
+	return err
 }
 
 func (s *Slurper) updateCursor(sub *activeSub, curs int64) error {
@@ -682,11 +916,20 @@ func (s *Slurper) flushCursors(ctx context.Context) []error {
 	ctx, span := otel.Tracer("feedmgr").Start(ctx, "flushCursors")
 	defer span.End()
 
-	var cursors []cursorSnapshot
-
 	s.lk.Lock()
-	// Iterate over active subs and copy the current cursor
+	subs := make([]*activeSub, 0, len(s.active))
 	for _, sub := range s.active {
+		subs = append(subs, sub)
+	}
+	s.lk.Unlock()
+
+	return s.writeCursors(ctx, subs)
+}
+
+// writeCursors saves the current cursors of the given subscriptions to the DB
+func (s *Slurper) writeCursors(ctx context.Context, subs []*activeSub) []error {
+	var cursors []cursorSnapshot
+	for _, sub := range subs {
 		sub.lk.RLock()
 		cursors = append(cursors, cursorSnapshot{
 			id:     sub.pds.ID,
@@ -694,7 +937,6 @@ func (s *Slurper) flushCursors(ctx context.Context) []error {
 		})
 		sub.lk.RUnlock()
 	}
-	s.lk.Unlock()
 
 	errs := []error{}
 
@@ -14,44 +14,42 @@ import (
 	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/indexer"
+	"github.com/bluesky-social/indigo/models"
 	"github.com/bluesky-social/indigo/mst"
 	"gorm.io/gorm"
 
 	"github.com/bluesky-social/indigo/xrpc"
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	cbor "github.com/ipfs/go-ipld-cbor"
 	"github.com/ipld/go-car"
 	"github.com/labstack/echo/v4"
 )
 
+// maximum number of blocks returned by one sync.getBlocks request
+const maxGetBlocks = 1000
+
 func (s *BGS) handleComAtprotoSyncGetRecord(ctx context.Context, collection string, did string, rkey string) (io.Reader, error) {
 	u, err := s.lookupUserByDid(ctx, did)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, echo.NewHTTPError(http.StatusNotFound, "user not found")
 		}
-		log.Errorw("failed to lookup user", "err", err, "did", did)
+		log.Error("failed to lookup user", "err", err, "did", did)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to lookup user")
 	}
 
-	if u.Tombstoned {
-		return nil, fmt.Errorf("account was deleted")
-	}
-
-	if u.TakenDown {
-		return nil, fmt.Errorf("account was taken down by the Relay")
-	}
-
-	if u.UpstreamStatus == events.AccountStatusTakendown {
-		return nil, fmt.Errorf("account was taken down by its PDS")
-	}
-
-	if u.UpstreamStatus == events.AccountStatusDeactivated {
-		return nil, fmt.Errorf("account is temporarily deactivated")
+	if err := accountUnavailable(u); err != nil {
+		return nil, err
 	}
 
-	if u.UpstreamStatus == events.AccountStatusSuspended {
-		return nil, fmt.Errorf("account is suspended by its PDS")
+	if s.nonArchival {
+		return s.proxySyncRequest(ctx, u, func(ctx context.Context, pds *models.PDS) ([]byte, error) {
+			c := models.ClientForPds(pds)
+			s.Index.ApplyPDSClientSettings(c)
+			return atproto.SyncGetRecord(ctx, c, collection, "", did, rkey)
+		})
 	}
 
 	root, blocks, err := s.repoman.GetRecordProof(ctx, u.ID, collection, rkey)
@@ -59,20 +57,28 @@ func (s *BGS) handleComAtprotoSyncGetRecord(ctx context.Context, collection stri
 		if errors.Is(err, mst.ErrNotFound) {
 			return nil, echo.NewHTTPError(http.StatusNotFound, "record not found in repo")
 		}
-		log.Errorw("failed to get record from repo", "err", err, "did", did, "collection", collection, "rkey", rkey)
+		log.Error("failed to get record from repo", "err", err, "did", did, "collection", collection, "rkey", rkey)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get record from repo")
 	}
 
+	return blocksCar([]cid.Cid{root}, blocks)
+}
+
+// blocksCar writes blocks as a CAR file
+func blocksCar(roots []cid.Cid, blks []blocks.Block) (*bytes.Buffer, error) {
 	buf := new(bytes.Buffer)
 	hb, err := cbor.DumpObject(&car.CarHeader{
-		Roots:   []cid.Cid{root},
+		Roots:   roots,
 		Version: 1,
 	})
+	if err != nil {
+		return nil, err
+	}
 	if _, err := carstore.LdWrite(buf, hb); err != nil {
 		return nil, err
 	}
 
-	for _, blk := range blocks {
+	for _, blk := range blks {
 		if _, err := carstore.LdWrite(buf, blk.Cid().Bytes(), blk.RawData()); err != nil {
 			return nil, err
 		}
@@ -87,34 +93,24 @@ func (s *BGS) handleComAtprotoSyncGetRepo(ctx context.Context, did string, since
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, echo.NewHTTPError(http.StatusNotFound, "user not found")
 		}
-		log.Errorw("failed to lookup user", "err", err, "did", did)
+		log.Error("failed to lookup user", "err", err, "did", did)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to lookup user")
 	}
 
-	if u.Tombstoned {
-		return nil, fmt.Errorf("account was deleted")
-	}
-
-	if u.TakenDown {
-		return nil, fmt.Errorf("account was taken down by the Relay")
-	}
-
-	if u.UpstreamStatus == events.AccountStatusTakendown {
-		return nil, fmt.Errorf("account was taken down by its PDS")
-	}
-
-	if u.UpstreamStatus == events.AccountStatusDeactivated {
-		return nil, fmt.Errorf("account is temporarily deactivated")
+	if err := accountUnavailable(u); err != nil {
+		return nil, err
 	}
 
-	if u.UpstreamStatus == events.AccountStatusSuspended {
-		return nil, fmt.Errorf("account is suspended by its PDS")
+	if s.nonArchival {
+		return s.proxySyncRequest(ctx, u, func(ctx context.Context, pds *models.PDS) ([]byte, error) {
+			return s.repoFetcher.FetchRepo(ctx, pds, did, since)
+		})
 	}
 
 	// TODO: stream the response
 	buf := new(bytes.Buffer)
 	if err := s.repoman.ReadRepo(ctx, u.ID, since, buf); err != nil {
-		log.Errorw("failed to read repo into buffer", "err", err, "did", did)
+		log.Error("failed to read repo into buffer", "err", err, "did", did)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to read repo into buffer")
 	}
 
@@ -122,7 +118,53 @@ func (s *BGS) handleComAtprotoSyncGetRepo(ctx context.Context, did string, since
 }
 
 func (s *BGS) handleComAtprotoSyncGetBlocks(ctx context.Context, cids []string, did string) (io.Reader, error) {
-	return nil, fmt.Errorf("NYI")
+	u, err := s.lookupUserByDid(ctx, did)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "user not found")
+		}
+		log.Error("failed to lookup user", "err", err, "did", did)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to lookup user")
+	}
+
+	if err := accountUnavailable(u); err != nil {
+		return nil, err
+	}
+
+	if s.nonArchival {
+		return s.proxySyncRequest(ctx, u, func(ctx context.Context, pds *models.PDS) ([]byte, error) {
+			c := models.ClientForPds(pds)
+			s.Index.ApplyPDSClientSettings(c)
+			return atproto.SyncGetBlocks(ctx, c, cids, did)
+		})
+	}
+
+	if len(cids) > maxGetBlocks {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("too many cids requested (max %d)", maxGetBlocks))
+	}
+	parsed := make([]cid.Cid, 0, len(cids))
+	for _, cs := range cids {
+		c, err := cid.Decode(cs)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid cid: %s", cs))
+		}
+		parsed = append(parsed, c)
+	}
+
+	blks, missing, err := s.repoman.GetBlocks(ctx, u.ID, parsed)
+	if err != nil {
+		log.Error("failed to get blocks from repo", "err", err, "did", did)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get blocks from repo")
+	}
+	if len(missing) > 0 {
+		strs := make([]string, len(missing))
+		for i, c := range missing {
+			strs[i] = c.String()
+		}
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "could not find cids: "+strings.Join(strs, ", "))
+	}
+
+	return blocksCar([]cid.Cid{}, blks)
 }
 
 func (s *BGS) handleComAtprotoSyncRequestCrawl(ctx context.Context, body *comatprototypes.SyncRequestCrawl_Input) error {
@@ -167,7 +209,12 @@ func (s *BGS) handleComAtprotoSyncRequestCrawl(ctx context.Context, body *comatp
 		return echo.NewHTTPError(http.StatusUnauthorized, "domain is banned")
 	}
 
-	log.Warnf("TODO: better host validation for crawl requests")
+	limiter := s.requestCrawlLimiter(host)
+	if !limiter.Allow() {
+		return echo.NewHTTPError(http.StatusTooManyRequests, "too many crawl requests for this host, try again later")
+	}
+
+	log.Warn("TODO: better host validation for crawl requests")
 
 	clientHost := fmt.Sprintf("%s://%s", u.Scheme, host)
 
@@ -177,6 +224,7 @@ func (s *BGS) handleComAtprotoSyncRequestCrawl(ctx context.Context, body *comatp
 	}
 
 	desc, err := atproto.ServerDescribeServer(ctx, c)
+	limiter.Observe(err)
 	if err != nil {
 		errMsg := fmt.Sprintf("requested host (%s) failed to respond to describe request", clientHost)
 		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
@@ -193,50 +241,146 @@ func (s *BGS) handleComAtprotoSyncNotifyOfUpdate(ctx context.Context, body *coma
 	return nil
 }
 
-func (s *BGS) handleComAtprotoSyncListRepos(ctx context.Context, cursor int64, limit int) (*comatprototypes.SyncListRepos_Output, error) {
-	// Filter out tombstoned, taken down, and deactivated accounts
-	q := fmt.Sprintf("id > ? AND NOT tombstoned AND NOT taken_down AND upstream_status != '%s' AND upstream_status != '%s' AND upstream_status != '%s'",
-		events.AccountStatusDeactivated, events.AccountStatusSuspended, events.AccountStatusTakendown)
+// listReposFilter narrows sync.listRepos beyond the lexicon's parameters. The zero value lists active accounts on all hosts
+type listReposFilter struct {
+	// hosting status to list: "" or events.AccountStatusActive for active accounts only, an inactive status (eg, events.AccountStatusTakendown), or listReposStatusAll
+	Status string
+	// only list accounts on this PDS host
+	Host string
+}
+
+const listReposStatusAll = "all"
+
+// accountHostingStatus returns the status to report for an account in sync.listRepos, with relay actions taking precedence over the upstream PDS's, as in accountUnavailable
+func accountHostingStatus(u *User) string {
+	switch {
+	case u.Tombstoned:
+		return events.AccountStatusDeleted
+	case u.TakenDown:
+		return events.AccountStatusTakendown
+	case u.Suspended:
+		return events.AccountStatusSuspended
+	case u.UpstreamStatus == events.AccountStatusTakendown,
+		u.UpstreamStatus == events.AccountStatusDeactivated,
+		u.UpstreamStatus == events.AccountStatusSuspended:
+		return u.UpstreamStatus
+	}
+	return events.AccountStatusActive
+}
+
+// listReposStatusQuery returns the users table condition matching accounts for which accountHostingStatus returns status
+func listReposStatusQuery(status string) (string, error) {
+	switch status {
+	case "", events.AccountStatusActive:
+		return fmt.Sprintf("NOT tombstoned AND NOT taken_down AND NOT suspended AND upstream_status != '%s' AND upstream_status != '%s' AND upstream_status != '%s'",
+			events.AccountStatusDeactivated, events.AccountStatusSuspended, events.AccountStatusTakendown), nil
+	case listReposStatusAll:
+		return "", nil
+	case events.AccountStatusDeleted:
+		return "tombstoned", nil
+	case events.AccountStatusTakendown:
+		return fmt.Sprintf("NOT tombstoned AND (taken_down OR (NOT suspended AND upstream_status = '%s'))", events.AccountStatusTakendown), nil
+	case events.AccountStatusSuspended:
+		return fmt.Sprintf("NOT tombstoned AND NOT taken_down AND (suspended OR upstream_status = '%s')", events.AccountStatusSuspended), nil
+	case events.AccountStatusDeactivated:
+		return fmt.Sprintf("NOT tombstoned AND NOT taken_down AND NOT suspended AND upstream_status = '%s'", events.AccountStatusDeactivated), nil
+	}
+	return "", fmt.Errorf("unknown account status: %s", status)
+}
+
+func (s *BGS) handleComAtprotoSyncListRepos(ctx context.Context, cursor int64, limit int, filter listReposFilter) (*comatprototypes.SyncListRepos_Output, error) {
+	// resp.Repos is an explicit empty array, not just 'nil'
+	resp := &comatprototypes.SyncListRepos_Output{
+		Repos: []*comatprototypes.SyncListRepos_Repo{},
+	}
+
+	cond, err := listReposStatusQuery(filter.Status)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// pages may lag slightly behind the primary
+	db := s.reads.Read()
+	q := db.WithContext(ctx).Model(&User{}).Where("id > ?", cursor)
+	if cond != "" {
+		q = q.Where(cond)
+	}
+
+	if filter.Host != "" {
+		var pds models.PDS
+		if err := db.WithContext(ctx).Find(&pds, "host = ?", strings.ToLower(filter.Host)).Error; err != nil {
+			log.Error("failed to look up pds", "err", err, "host", filter.Host)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to look up host")
+		}
+		if pds.ID == 0 {
+			return resp, nil
+		}
+		q = q.Where("pds = ?", pds.ID)
+	}
 
 	// Load the users
 	users := []*User{}
-	if err := s.db.Model(&User{}).Where(q, cursor).Order("id").Limit(limit).Find(&users).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return &comatprototypes.SyncListRepos_Output{}, nil
-		}
-		log.Errorw("failed to query users", "err", err)
+	if err := q.Order("id").Limit(limit).Find(&users).Error; err != nil {
+		log.Error("failed to query users", "err", err)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to query users")
 	}
 
 	if len(users) == 0 {
-		// resp.Repos is an explicit empty array, not just 'nil'
-		return &comatprototypes.SyncListRepos_Output{
-			Repos: []*comatprototypes.SyncListRepos_Repo{},
-		}, nil
+		return resp, nil
 	}
 
-	resp := &comatprototypes.SyncListRepos_Output{
-		Repos: make([]*comatprototypes.SyncListRepos_Repo, len(users)),
+	uids := make([]models.Uid, len(users))
+	for i, u := range users {
+		uids[i] = u.ID
 	}
 
-	// Fetch the repo roots for each user
-	for i := range users {
-		user := users[i]
-
-		root, err := s.repoman.GetRepoRoot(ctx, user.ID)
+	// Fetch the repo heads for the whole page at once
+	heads := make(map[models.Uid]carstore.UserRepoHead, len(users))
+	if s.nonArchival {
+		var rows []RepoHead
+		if err := db.WithContext(ctx).Find(&rows, "uid IN ?", uids).Error; err != nil {
+			log.Error("failed to get repo heads", "err", err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get repo heads")
+		}
+		for _, h := range rows {
+			c, err := cid.Decode(h.Commit)
+			if err != nil {
+				continue
+			}
+			heads[h.Uid] = carstore.UserRepoHead{Root: c, Rev: h.Rev}
+		}
+	} else {
+		heads, err = s.repoman.GetRepoHeads(ctx, uids)
 		if err != nil {
-			log.Errorw("failed to get repo root", "err", err, "did", user.Did)
-			return nil, echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to get repo root for (%s): %v", user.Did, err.Error()))
+			log.Error("failed to get repo roots", "err", err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get repo roots")
+		}
+	}
+
+	resp.Repos = make([]*comatprototypes.SyncListRepos_Repo, len(users))
+	for i, user := range users {
+		active := true
+		r := &comatprototypes.SyncListRepos_Repo{
+			Did:    user.Did,
+			Active: &active,
 		}
 
-		resp.Repos[i] = &comatprototypes.SyncListRepos_Repo{
-			Did:  user.Did,
-			Head: root.String(),
+		if status := accountHostingStatus(user); status != events.AccountStatusActive {
+			active = false
+			r.Status = &status
 		}
+
+		// accounts with no commits seen yet, or whose data was deleted, have no head
+		if h, ok := heads[user.ID]; ok && h.Root.Defined() {
+			r.Head = h.Root.String()
+			r.Rev = h.Rev
+		}
+
+		resp.Repos[i] = r
 	}
 
 	// If this is not the last page, set the cursor
-	if len(users) >= limit && len(users) > 1 {
+	if len(users) >= limit {
 		nextCursor := fmt.Sprintf("%d", users[len(users)-1].ID)
 		resp.Cursor = &nextCursor
 	}
@@ -253,35 +397,35 @@ func (s *BGS) handleComAtprotoSyncGetLatestCommit(ctx context.Context, did strin
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to lookup user")
 	}
 
-	if u.Tombstoned {
-		return nil, fmt.Errorf("account was deleted")
-	}
-
-	if u.TakenDown {
-		return nil, fmt.Errorf("account was taken down by the Relay")
-	}
-
-	if u.UpstreamStatus == events.AccountStatusTakendown {
-		return nil, fmt.Errorf("account was taken down by its PDS")
+	if err := accountUnavailable(u); err != nil {
+		return nil, err
 	}
 
-	if u.UpstreamStatus == events.AccountStatusDeactivated {
-		return nil, fmt.Errorf("account is temporarily deactivated")
-	}
+	if s.nonArchival {
+		head, err := s.getRepoHead(ctx, u.ID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, echo.NewHTTPError(http.StatusNotFound, "no commits seen for repo")
+			}
+			log.Error("failed to get repo head", "err", err, "did", u.Did)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get repo head")
+		}
 
-	if u.UpstreamStatus == events.AccountStatusSuspended {
-		return nil, fmt.Errorf("account is suspended by its PDS")
+		return &comatprototypes.SyncGetLatestCommit_Output{
+			Cid: head.Commit,
+			Rev: head.Rev,
+		}, nil
 	}
 
 	root, err := s.repoman.GetRepoRoot(ctx, u.ID)
 	if err != nil {
-		log.Errorw("failed to get repo root", "err", err, "did", u.Did)
+		log.Error("failed to get repo root", "err", err, "did", u.Did)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get repo root")
 	}
 
 	rev, err := s.repoman.GetRepoRev(ctx, u.ID)
 	if err != nil {
-		log.Errorw("failed to get repo rev", "err", err, "did", u.Did)
+		log.Error("failed to get repo rev", "err", err, "did", u.Did)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get repo rev")
 	}
 
@@ -290,3 +434,30 @@ func (s *BGS) handleComAtprotoSyncGetLatestCommit(ctx context.Context, did strin
 		Rev: rev,
 	}, nil
 }
+
+func (s *BGS) requestCrawlLimiter(host string) *indexer.HostLimiter {
+	lim := indexer.NewHostLimiter(s.requestCrawlLimit)
+	if prev, ok, _ := s.requestCrawlLimiters.PeekOrAdd(host, lim); ok {
+		return prev
+	}
+	return lim
+}
+
+// Returns an error explaining why an account's data should not be served (eg, it was taken down or deactivated), or nil if it can be
+func accountUnavailable(u *User) error {
+	switch {
+	case u.Tombstoned:
+		return fmt.Errorf("account was deleted")
+	case u.TakenDown:
+		return fmt.Errorf("account was taken down by the Relay")
+	case u.Suspended:
+		return fmt.Errorf("account is suspended by the Relay")
+	case u.UpstreamStatus == events.AccountStatusTakendown:
+		return fmt.Errorf("account was taken down by its PDS")
+	case u.UpstreamStatus == events.AccountStatusDeactivated:
+		return fmt.Errorf("account is temporarily deactivated")
+	case u.UpstreamStatus == events.AccountStatusSuspended:
+		return fmt.Errorf("account is suspended by its PDS")
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package bgs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/util/ratelimit"
+
+	godid "github.com/whyrusleeping/go-did"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+)
+
+// HandleRevalidationConfig controls periodic re-verification of account handles. Handles are bidirectionally verified when an account is first seen and on every #identity event, but DNS records and well-known files can break (or be fixed) at any time without an #identity event, so they are re-checked in the background as well. Zero intervals disable the corresponding re-checks.
+type HandleRevalidationConfig struct {
+	// How long a successful verification is trusted before re-checking
+	ValidInterval time.Duration
+	// How long to wait before re-checking a handle which failed verification
+	InvalidInterval time.Duration
+	// Maximum re-verifications per second
+	PerSecond float64
+}
+
+func (c *HandleRevalidationConfig) enabled() bool {
+	return c.PerSecond > 0 && (c.ValidInterval > 0 || c.InvalidInterval > 0)
+}
+
+const (
+	handleRevalidationPoll  = time.Minute
+	handleRevalidationBatch = 500
+)
+
+// claimedHandle returns the handle an account claims in its DID document. This has not been verified
+func claimedHandle(doc *godid.Document) (string, error) {
+	if len(doc.AlsoKnownAs) == 0 {
+		return "", fmt.Errorf("user has no 'known as' field in their DID document")
+	}
+
+	hurl, err := url.Parse(doc.AlsoKnownAs[0])
+	if err != nil {
+		return "", err
+	}
+
+	return hurl.Host, nil
+}
+
+// verifyHandle checks the second direction of the handle/DID mapping: that a handle claimed in the DID document resolves back to the same DID
+func (s *BGS) verifyHandle(ctx context.Context, did string, handle string) bool {
+	h, err := syntax.ParseHandle(handle)
+	if err != nil || h.IsInvalidHandle() {
+		log.Info("account claims syntactically invalid handle", "did", did, "handle", handle)
+		handleChecks.WithLabelValues("invalid").Inc()
+		return false
+	}
+
+	resdid, err := s.hr.ResolveHandleToDid(ctx, h.Normalize().String())
+	if err != nil {
+		log.Info("failed to resolve users claimed handle", "did", did, "handle", handle, "err", err)
+		handleChecks.WithLabelValues("invalid").Inc()
+		return false
+	}
+
+	if resdid != did {
+		log.Info("claimed handle did not match servers response", "did", did, "handle", handle, "resolved", resdid)
+		handleChecks.WithLabelValues("invalid").Inc()
+		return false
+	}
+
+	handleChecks.WithLabelValues("valid").Inc()
+	return true
+}
+
+// recordHandleCheck stores the outcome of verifying an account's handle. As on account creation, a handle which failed verification is not stored, and a verified handle is taken away from any other account which previously held it.
+//
+// Must be called with extUserLk held.
+func (s *BGS) recordHandleCheck(ctx context.Context, uid models.Uid, handle string, valid bool) error {
+	now := time.Now()
+	h := sql.NullString{String: handle, Valid: valid}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if valid {
+			// since we just verified the handle for this account, we'll assume any other account no longer has control of it
+			if err := tx.Model(User{}).Where("handle = ? AND id != ?", handle, uid).Updates(map[string]any{"handle": nil, "valid_handle": false}).Error; err != nil {
+				return fmt.Errorf("failed to update outdated user's handle: %w", err)
+			}
+			if err := tx.Model(models.ActorInfo{}).Where("handle = ? AND uid != ?", handle, uid).Updates(map[string]any{"handle": nil, "valid_handle": false}).Error; err != nil {
+				return fmt.Errorf("failed to update outdated actorInfo's handle: %w", err)
+			}
+		}
+
+		if err := tx.Model(User{}).Where("id = ?", uid).Updates(map[string]any{
+			"handle":            h,
+			"valid_handle":      valid,
+			"handle_checked_at": now,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update users handle: %w", err)
+		}
+
+		if err := tx.Model(models.ActorInfo{}).Where("uid = ?", uid).Updates(map[string]any{
+			"handle":       h,
+			"valid_handle": valid,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update actorInfos handle: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// eventHandle returns the handle to broadcast in an #identity event: the verified handle, or "handle.invalid" if verification failed, so downstream consumers don't need to re-verify it themselves
+func eventHandle(handle sql.NullString, valid bool) *string {
+	h := syntax.HandleInvalid.String()
+	if valid && handle.Valid {
+		h = handle.String
+	}
+	return &h
+}
+
+// RevalidateHandle re-verifies the handle currently claimed in an account's DID document, and emits an #identity event if the account's verified handle changed as a result
+func (s *BGS) RevalidateHandle(ctx context.Context, did string) error {
+	ctx, span := tracer.Start(ctx, "RevalidateHandle")
+	defer span.End()
+	span.SetAttributes(attribute.String("did", did))
+
+	u, err := s.lookupUserByDid(ctx, did)
+	if err != nil {
+		return err
+	}
+
+	return s.revalidateHandle(ctx, u)
+}
+
+func (s *BGS) revalidateHandle(ctx context.Context, u *User) error {
+	doc, err := s.didr.GetDocument(ctx, u.Did)
+	if err != nil {
+		handleChecks.WithLabelValues("error").Inc()
+		// still record the attempt, so a single unresolvable DID doesn't hold up the rest
+		if err := s.db.Model(User{}).Where("id = ?", u.ID).Update("handle_checked_at", time.Now()).Error; err != nil {
+			log.Error("failed to record handle check", "did", u.Did, "err", err)
+		}
+		return fmt.Errorf("could not locate DID document for user (%s): %w", u.Did, err)
+	}
+
+	handle, err := claimedHandle(doc)
+	if err != nil {
+		return err
+	}
+
+	valid := s.verifyHandle(ctx, u.Did, handle)
+
+	s.extUserLk.Lock()
+	err = s.recordHandleCheck(ctx, u.ID, handle, valid)
+	s.extUserLk.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if u.ValidHandle == valid && (!valid || u.Handle.String == handle) {
+		return nil
+	}
+
+	log.Info("account handle verification changed", "did", u.Did, "handle", handle, "valid", valid, "prev_handle", u.Handle.String, "prev_valid", u.ValidHandle)
+
+	if u.TakenDown || u.Suspended || u.Tombstoned {
+		return nil
+	}
+
+	if err := s.events.AddEvent(ctx, &events.XRPCStreamEvent{
+		RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{
+			Did:    u.Did,
+			Time:   time.Now().Format(util.ISO8601),
+			Handle: eventHandle(sql.NullString{String: handle, Valid: true}, valid),
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to broadcast identity event for handle change: %w", err)
+	}
+
+	return nil
+}
+
+// revalidateDueHandles re-verifies every account whose last handle verification is older than the configured intervals, returning the number checked
+func (s *BGS) revalidateDueHandles(ctx context.Context, cfg HandleRevalidationConfig, lim ratelimit.Limiter) (int, error) {
+	checked := 0
+	for {
+		now := time.Now()
+		q := s.db.WithContext(ctx).Model(User{}).Where("taken_down = false AND tombstoned = false")
+
+		due := s.db.Where("handle_checked_at IS NULL")
+		if cfg.ValidInterval > 0 {
+			due = due.Or("valid_handle = true AND handle_checked_at < ?", now.Add(-cfg.ValidInterval))
+		}
+		if cfg.InvalidInterval > 0 {
+			due = due.Or("valid_handle = false AND handle_checked_at < ?", now.Add(-cfg.InvalidInterval))
+		}
+
+		var users []User
+		if err := q.Where(due).Order("handle_checked_at").Limit(handleRevalidationBatch).Find(&users).Error; err != nil {
+			return checked, err
+		}
+
+		for i := range users {
+			if err := lim.Wait(ctx); err != nil {
+				return checked, err
+			}
+
+			if err := s.revalidateHandle(ctx, &users[i]); err != nil {
+				log.Warn("failed to revalidate handle", "did", users[i].Did, "err", err)
+			}
+			checked++
+		}
+
+		if len(users) < handleRevalidationBatch {
+			return checked, nil
+		}
+	}
+}
+
+func (s *BGS) runHandleRevalidation(cfg HandleRevalidationConfig, exit <-chan struct{}) {
+	log.Info("starting handle revalidation routine", "valid_interval", cfg.ValidInterval, "invalid_interval", cfg.InvalidInterval, "per_second", cfg.PerSecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-exit
+		cancel()
+	}()
+
+	// registered so it can be adjusted at runtime (see ratelimit.Handler)
+	lim := ratelimit.NewTokenBucket(cfg.PerSecond, 1)
+	ratelimit.Register("bgs.handle_revalidation", lim)
+	defer ratelimit.Unregister("bgs.handle_revalidation", lim)
+
+	t := time.NewTicker(handleRevalidationPoll)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n, err := s.revalidateDueHandles(ctx, cfg, lim)
+			if err != nil && ctx.Err() == nil {
+				log.Error("failed to revalidate handles", "err", err)
+			}
+			if n > 0 {
+				log.Info("revalidated handles", "count", n)
+			}
+		}
+	}
+}
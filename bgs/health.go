@@ -0,0 +1,38 @@
+package bgs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/util/health"
+)
+
+// newHealthChecker sets up the readiness checks served at /readyz
+func (bgs *BGS) newHealthChecker() *health.Checker {
+	hc := health.NewChecker("bigsky")
+	hc.Add("shutdown", func(ctx context.Context) error {
+		if bgs.draining.Load() {
+			return errors.New("relay is shutting down")
+		}
+		return nil
+	})
+	hc.Add("database", func(ctx context.Context) error {
+		if err := bgs.db.WithContext(ctx).Exec("SELECT 1").Error; err != nil {
+			return err
+		}
+		return bgs.reads.Ping(ctx)
+	})
+	if cs := bgs.repoman.CarStore(); cs != nil {
+		hc.Add("carstore", cs.Ping)
+	}
+	hc.Add("upstream", func(ctx context.Context) error {
+		// a relay with no hosts yet is still ready, eg while bootstrapping
+		active, connected := bgs.slurper.ConnectedHosts()
+		if active > 0 && connected == 0 {
+			return fmt.Errorf("none of %d subscribed hosts are connected", active)
+		}
+		return nil
+	})
+	return hc
+}
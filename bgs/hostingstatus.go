@@ -0,0 +1,165 @@
+package bgs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
+)
+
+// MaxRepoStatusQuery is the most accounts which can be looked up in one bulk status request
+const MaxRepoStatusQuery = 1000
+
+var hostingStatusLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_hosting_status_lookups",
+	Help: "The total number of account hosting status lookups, by whether they were answered from the cache",
+}, []string{"cached"})
+
+// hostingStatusCache keeps the hosting status (as accountHostingStatus) of recently looked up accounts, so downstream consumers can check the status of many accounts at a time without a database query for each. Anything changing an account's status forgets its entry.
+type hostingStatusCache struct {
+	db    *gorm.DB
+	cache *lru.Cache[string, string]
+
+	// bumped whenever an entry is forgotten, so a lookup racing with a status change doesn't cache the old status
+	epoch atomic.Uint64
+}
+
+func newHostingStatusCache(db *gorm.DB, size int) (*hostingStatusCache, error) {
+	cache, err := lru.New[string, string](max(size, 1))
+	if err != nil {
+		return nil, err
+	}
+	return &hostingStatusCache{db: db, cache: cache}, nil
+}
+
+// lookup returns the hosting status of each of the given accounts known to the relay
+func (hc *hostingStatusCache) lookup(ctx context.Context, dids []string) (map[string]string, error) {
+	out := make(map[string]string, len(dids))
+	var missing []string
+	for _, did := range dids {
+		if status, ok := hc.cache.Get(did); ok {
+			out[did] = status
+		} else {
+			missing = append(missing, did)
+		}
+	}
+	hostingStatusLookups.WithLabelValues("true").Add(float64(len(dids) - len(missing)))
+	hostingStatusLookups.WithLabelValues("false").Add(float64(len(missing)))
+	if len(missing) == 0 {
+		return out, nil
+	}
+
+	epoch := hc.epoch.Load()
+	var users []User
+	if err := hc.db.WithContext(ctx).Select("did", "tombstoned", "taken_down", "suspended", "upstream_status").Where("did IN ?", missing).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	cacheable := hc.epoch.Load() == epoch
+	for i := range users {
+		status := accountHostingStatus(&users[i])
+		out[users[i].Did] = status
+		if cacheable {
+			hc.cache.Add(users[i].Did, status)
+		}
+	}
+	return out, nil
+}
+
+// forget drops an account's cached status, after it has changed
+func (hc *hostingStatusCache) forget(did string) {
+	hc.epoch.Add(1)
+	hc.cache.Remove(did)
+}
+
+func repoStatusOutput(did, status string) *comatprototypes.SyncGetRepoStatus_Output {
+	out := &comatprototypes.SyncGetRepoStatus_Output{
+		Did:    did,
+		Active: status == events.AccountStatusActive,
+	}
+	if !out.Active {
+		out.Status = &status
+	}
+	return out
+}
+
+func (bgs *BGS) HandleComAtprotoSyncGetRepoStatus(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoSyncGetRepoStatus")
+	defer span.End()
+
+	did := c.QueryParam("did")
+	if _, err := syntax.ParseDID(did); err != nil {
+		return c.JSON(http.StatusBadRequest, XRPCError{Message: fmt.Sprintf("invalid did: %s", did)})
+	}
+
+	statuses, err := bgs.hostingStatus.lookup(ctx, []string{did})
+	if err != nil {
+		log.Error("failed to look up account status", "err", err, "did", did)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to look up account status")
+	}
+	status, ok := statuses[did]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	return c.JSON(http.StatusOK, repoStatusOutput(did, status))
+}
+
+// RepoStatusesRequest is the body of a bulk account status query, to /repos/status
+type RepoStatusesRequest struct {
+	Dids []string `json:"dids"`
+}
+
+// RepoStatusesResponse lists the status of each account in a bulk query, in the order requested
+type RepoStatusesResponse struct {
+	// Only accounts known to the relay are listed
+	Repos []*comatprototypes.SyncGetRepoStatus_Output `json:"repos"`
+}
+
+// handleRepoStatuses reports the hosting status of up to MaxRepoStatusQuery accounts at once, so consumers can filter out content from inactive accounts without following their account events
+func (bgs *BGS) handleRepoStatuses(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var body RepoStatusesRequest
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid body")
+	}
+	if len(body.Dids) > MaxRepoStatusQuery {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("too many dids requested (max %d)", MaxRepoStatusQuery))
+	}
+	for _, did := range body.Dids {
+		if _, err := syntax.ParseDID(did); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid did: %s", did))
+		}
+	}
+
+	statuses, err := bgs.hostingStatus.lookup(ctx, body.Dids)
+	if err != nil {
+		log.Error("failed to look up account statuses", "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to look up account statuses")
+	}
+
+	// in request order, without duplicates
+	resp := RepoStatusesResponse{Repos: []*comatprototypes.SyncGetRepoStatus_Output{}}
+	for _, did := range body.Dids {
+		if status, ok := statuses[did]; ok {
+			resp.Repos = append(resp.Repos, repoStatusOutput(did, status))
+			delete(statuses, did)
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// accountStatusChanged is called after anything which may change an account's hosting status
+func (bgs *BGS) accountStatusChanged(did string) {
+	bgs.hostingStatus.forget(did)
+}
@@ -0,0 +1,376 @@
+package bgs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+)
+
+var ErrHostPaused = fmt.Errorf("subscription to host is paused")
+
+// rateWindow estimates an event rate over the trailing minute, from counts in the current and previous minute
+type rateWindow struct {
+	start     time.Time
+	cur, prev float64
+}
+
+func (w *rateWindow) advance(now time.Time) {
+	if w.start.IsZero() {
+		w.start = now.Truncate(time.Minute)
+		return
+	}
+
+	switch d := now.Sub(w.start); {
+	case d >= 2*time.Minute:
+		w.prev, w.cur = 0, 0
+		w.start = now.Truncate(time.Minute)
+	case d >= time.Minute:
+		w.prev, w.cur = w.cur, 0
+		w.start = w.start.Add(time.Minute)
+	}
+}
+
+func (w *rateWindow) add(now time.Time) {
+	w.advance(now)
+	w.cur++
+}
+
+func (w *rateWindow) perSecond(now time.Time) float64 {
+	w.advance(now)
+	frac := float64(now.Sub(w.start)) / float64(time.Minute)
+	return (w.prev*(1-frac) + w.cur) / 60
+}
+
+// hostStats tracks the health of a subscription to a single host, since startup
+type hostStats struct {
+	lk sync.Mutex
+
+	events      rateWindow
+	errors      rateWindow
+	totalEvents uint64
+	totalErrors uint64
+	lastEventAt time.Time
+
+	connected   bool
+	connectedAt time.Time
+	lastError   string
+	lastErrorAt time.Time
+
+	staleReconnects uint64
+	lastStaleAt     time.Time
+	// recent stale reconnects, within the flap window
+	staleAt []time.Time
+}
+
+func (s *Slurper) hostStatsFor(pdsID uint) *hostStats {
+	s.statsLk.Lock()
+	defer s.statsLk.Unlock()
+
+	st, ok := s.stats[pdsID]
+	if !ok {
+		st = &hostStats{}
+		s.stats[pdsID] = st
+	}
+	return st
+}
+
+func (st *hostStats) observeEvent(err error) {
+	now := time.Now()
+
+	st.lk.Lock()
+	defer st.lk.Unlock()
+
+	st.events.add(now)
+	st.totalEvents++
+	st.lastEventAt = now
+	if err != nil {
+		st.errors.add(now)
+		st.totalErrors++
+		st.lastError = err.Error()
+		st.lastErrorAt = now
+	}
+}
+
+func (st *hostStats) setConnected(connected bool, err error) {
+	now := time.Now()
+
+	st.lk.Lock()
+	defer st.lk.Unlock()
+
+	if connected && !st.connected {
+		st.connectedAt = now
+	}
+	st.connected = connected
+	if err != nil {
+		st.lastError = err.Error()
+		st.lastErrorAt = now
+	}
+}
+
+// ConnectedHosts returns the number of hosts with an active subscription, and how many of those are currently connected
+func (s *Slurper) ConnectedHosts() (active int, connected int) {
+	s.lk.Lock()
+	ids := make([]uint, 0, len(s.active))
+	for _, sub := range s.active {
+		ids = append(ids, sub.pds.ID)
+	}
+	s.lk.Unlock()
+
+	for _, id := range ids {
+		st := s.hostStatsFor(id)
+		st.lk.Lock()
+		if st.connected {
+			connected++
+		}
+		st.lk.Unlock()
+	}
+	return len(ids), connected
+}
+
+// PauseHost drops the subscription to a host and keeps it from being resubscribed, including across restarts, until it is resumed. Unlike blocking, this is purely operational: the host's cursor is kept, and resuming picks up where it left off.
+func (s *Slurper) PauseHost(ctx context.Context, host string) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	var pds models.PDS
+	if err := s.db.WithContext(ctx).Where("host = ?", host).First(&pds).Error; err != nil {
+		return err
+	}
+
+	updates := map[string]any{"paused": true}
+	if sub, ok := s.active[host]; ok {
+		sub.cancel()
+		delete(s.active, host)
+
+		// the periodic cursor flush skips subscriptions which are no longer active
+		sub.lk.RLock()
+		updates["cursor"] = sub.pds.Cursor
+		sub.lk.RUnlock()
+	}
+
+	return s.db.WithContext(ctx).Model(&models.PDS{}).Where("id = ?", pds.ID).Updates(updates).Error
+}
+
+// ResumeHost clears the paused state of a host and resubscribes to it
+func (s *Slurper) ResumeHost(ctx context.Context, host string) error {
+	var pds models.PDS
+	if err := s.db.WithContext(ctx).Where("host = ?", host).First(&pds).Error; err != nil {
+		return err
+	}
+
+	if err := s.db.WithContext(ctx).Model(&models.PDS{}).Where("id = ?", pds.ID).Update("paused", false).Error; err != nil {
+		return err
+	}
+
+	return s.SubscribeToPds(ctx, host, true, true)
+}
+
+// PauseHost stops consuming events from a host until it is resumed
+func (bgs *BGS) PauseHost(ctx context.Context, host string) error {
+	return bgs.slurper.PauseHost(ctx, host)
+}
+
+// ResumeHost restarts consumption from a paused host, from where it left off
+func (bgs *BGS) ResumeHost(ctx context.Context, host string) error {
+	return bgs.slurper.ResumeHost(ctx, host)
+}
+
+// Host subscription states, as reported by the admin API
+const (
+	HostStatusConnected    = "connected"
+	HostStatusDisconnected = "disconnected"
+	HostStatusInactive     = "inactive"
+	HostStatusPaused       = "paused"
+	HostStatusBlocked      = "blocked"
+	HostStatusBanned       = "banned"
+)
+
+// HostInfo describes an upstream host and the health of our subscription to it. Rates are over roughly the last minute; totals are since startup.
+type HostInfo struct {
+	ID         uint      `json:"id"`
+	Host       string    `json:"host"`
+	CreatedAt  time.Time `json:"created_at"`
+	Status     string    `json:"status"`
+	SSL        bool      `json:"ssl"`
+	Registered bool      `json:"registered"`
+	Paused     bool      `json:"paused"`
+	Blocked    bool      `json:"blocked"`
+	Banned     bool      `json:"banned"`
+	Trusted    bool      `json:"trusted"`
+	// Trust tier, which controls validation strictness (see HostTrustVerified etc)
+	TrustLevel string `json:"trust_level"`
+
+	Cursor    int64 `json:"cursor"`
+	Accounts  int64 `json:"accounts"`
+	RepoLimit int64 `json:"repo_limit"`
+
+	ConnectedAt     *time.Time `json:"connected_at,omitempty"`
+	LastEventAt     *time.Time `json:"last_event_at,omitempty"`
+	EventsPerSecond float64    `json:"events_per_second"`
+	ErrorsPerSecond float64    `json:"errors_per_second"`
+	ErrorRate       float64    `json:"error_rate"`
+	TotalEvents     uint64     `json:"total_events"`
+	TotalErrors     uint64     `json:"total_errors"`
+	LastError       string     `json:"last_error,omitempty"`
+	LastErrorAt     *time.Time `json:"last_error_at,omitempty"`
+	// Reconnects after the subscription went silent (see StaleHostConfig)
+	StaleReconnects uint64     `json:"stale_reconnects"`
+	LastStaleAt     *time.Time `json:"last_stale_at,omitempty"`
+	Flapping        bool       `json:"flapping"`
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// ListHosts returns every known upstream host with an id greater than cursor, in id order
+func (bgs *BGS) ListHosts(ctx context.Context, cursor uint, limit int) ([]*HostInfo, error) {
+	var hosts []models.PDS
+	if err := bgs.db.WithContext(ctx).Where("id > ?", cursor).Order("id").Limit(limit).Find(&hosts).Error; err != nil {
+		return nil, err
+	}
+
+	return bgs.hostInfos(ctx, hosts)
+}
+
+// GetHost returns a single upstream host by hostname
+func (bgs *BGS) GetHost(ctx context.Context, host string) (*HostInfo, error) {
+	var pds models.PDS
+	if err := bgs.db.WithContext(ctx).Where("host = ?", host).First(&pds).Error; err != nil {
+		return nil, err
+	}
+
+	infos, err := bgs.hostInfos(ctx, []models.PDS{pds})
+	if err != nil {
+		return nil, err
+	}
+	return infos[0], nil
+}
+
+func (bgs *BGS) hostInfos(ctx context.Context, hosts []models.PDS) ([]*HostInfo, error) {
+	out := make([]*HostInfo, 0, len(hosts))
+	if len(hosts) == 0 {
+		return out, nil
+	}
+
+	ids := make([]uint, len(hosts))
+	for i, h := range hosts {
+		ids[i] = h.ID
+	}
+
+	var counts []UserCount
+	if err := bgs.db.WithContext(ctx).Model(&User{}).Select("pds, count(*) as user_count").Where("pds IN ?", ids).Group("pds").Find(&counts).Error; err != nil {
+		return nil, fmt.Errorf("failed to count accounts: %w", err)
+	}
+	accounts := make(map[uint]int64, len(counts))
+	for _, c := range counts {
+		accounts[c.PDSID] = c.UserCount
+	}
+
+	var bans []models.DomainBan
+	if err := bgs.db.WithContext(ctx).Find(&bans).Error; err != nil {
+		return nil, fmt.Errorf("failed to load domain bans: %w", err)
+	}
+	banned := make(map[string]bool, len(bans))
+	for _, b := range bans {
+		banned[strings.ToLower(b.Domain)] = true
+	}
+
+	cursors := bgs.slurper.activeCursors()
+	now := time.Now()
+
+	for _, h := range hosts {
+		hi := &HostInfo{
+			ID:         h.ID,
+			Host:       h.Host,
+			CreatedAt:  h.CreatedAt,
+			SSL:        h.SSL,
+			Registered: h.Registered,
+			Paused:     h.Paused,
+			Blocked:    h.Blocked,
+			Trusted:    bgs.slurper.IsTrustedDomain(h.Host),
+			TrustLevel: h.Trust,
+			Cursor:     h.Cursor,
+			Accounts:   accounts[h.ID],
+			RepoLimit:  h.RepoLimit,
+		}
+
+		if hi.TrustLevel == "" {
+			hi.TrustLevel = bgs.slurper.trustCfg.Default
+		}
+
+		for _, d := range domainBanCandidates(h.Host) {
+			if banned[d] {
+				hi.Banned = true
+				break
+			}
+		}
+
+		active := false
+		if c, ok := cursors[h.Host]; ok {
+			active = true
+			hi.Cursor = c
+		}
+
+		st := bgs.slurper.hostStatsFor(h.ID)
+		st.lk.Lock()
+		connected := active && st.connected
+		if connected {
+			hi.ConnectedAt = timeOrNil(st.connectedAt)
+		}
+		hi.LastEventAt = timeOrNil(st.lastEventAt)
+		hi.EventsPerSecond = st.events.perSecond(now)
+		hi.ErrorsPerSecond = st.errors.perSecond(now)
+		if hi.EventsPerSecond > 0 {
+			hi.ErrorRate = hi.ErrorsPerSecond / hi.EventsPerSecond
+		}
+		hi.TotalEvents = st.totalEvents
+		hi.TotalErrors = st.totalErrors
+		hi.LastError = st.lastError
+		hi.LastErrorAt = timeOrNil(st.lastErrorAt)
+		hi.StaleReconnects = st.staleReconnects
+		hi.LastStaleAt = timeOrNil(st.lastStaleAt)
+		hi.Flapping = st.flappingAt(now, bgs.slurper.staleCfg)
+		st.lk.Unlock()
+
+		switch {
+		case hi.Banned:
+			hi.Status = HostStatusBanned
+		case h.Blocked:
+			hi.Status = HostStatusBlocked
+		case h.Paused:
+			hi.Status = HostStatusPaused
+		case connected:
+			hi.Status = HostStatusConnected
+		case active || h.Registered:
+			hi.Status = HostStatusDisconnected
+		default:
+			hi.Status = HostStatusInactive
+		}
+
+		out = append(out, hi)
+	}
+
+	return out, nil
+}
+
+// activeCursors returns the current cursor of each active subscription, by hostname
+func (s *Slurper) activeCursors() map[string]int64 {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	out := make(map[string]int64, len(s.active))
+	for host, sub := range s.active {
+		sub.lk.RLock()
+		out[host] = sub.pds.Cursor
+		sub.lk.RUnlock()
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+package bgs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	godid "github.com/whyrusleeping/go-did"
+)
+
+const (
+	IdentityFieldHandle     = "handle"
+	IdentityFieldPDS        = "pds"
+	IdentityFieldSigningKey = "signingKey"
+)
+
+var identityChangesRecorded = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_identity_changes_recorded",
+	Help: "The total number of account identity changes recorded in the identity history, by changed field",
+}, []string{"field"})
+
+// IdentityChange is an entry in an account's identity history: the identity the relay resolved from the account's DID document, recorded whenever it differs from the previous entry. The first entry for an account has every field marked as changed.
+type IdentityChange struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Did       string    `gorm:"index" json:"did"`
+	// Handle claimed in the DID document (whether or not it verified)
+	Handle string `json:"handle"`
+	// PDS service endpoint
+	PDS string `json:"pds"`
+	// Multibase-encoded atproto signing key
+	SigningKey string `json:"signingKey"`
+	// Comma-separated IdentityField* names which differ from the previous entry
+	Changed string `json:"changed"`
+}
+
+// signingKey returns the account's atproto signing key from its DID document, or the first key if none is labelled as such
+func signingKey(doc *godid.Document) string {
+	var key string
+	for i := range doc.VerificationMethod {
+		vm := &doc.VerificationMethod[i]
+		if vm.PublicKeyMultibase == nil {
+			continue
+		}
+		if strings.HasSuffix(vm.ID, "#atproto") {
+			return *vm.PublicKeyMultibase
+		}
+		if i == 0 {
+			key = *vm.PublicKeyMultibase
+		}
+	}
+	return key
+}
+
+// recordIdentity adds an entry to the account's identity history if its DID document shows a different handle, PDS, or signing key than last recorded.
+//
+// Must be called with extUserLk held, so concurrent resolutions of the same account don't record the same change twice.
+func (bgs *BGS) recordIdentity(ctx context.Context, did string, handle string, doc *godid.Document) error {
+	cur := IdentityChange{
+		Did:        did,
+		Handle:     handle,
+		SigningKey: signingKey(doc),
+	}
+	if len(doc.Service) > 0 {
+		cur.PDS = doc.Service[0].ServiceEndpoint
+	}
+
+	var prev IdentityChange
+	if err := bgs.db.WithContext(ctx).Where("did = ?", did).Order("id DESC").Limit(1).Find(&prev).Error; err != nil {
+		return fmt.Errorf("failed to look up identity history: %w", err)
+	}
+
+	var changed []string
+	if prev.ID == 0 || prev.Handle != cur.Handle {
+		changed = append(changed, IdentityFieldHandle)
+	}
+	if prev.ID == 0 || prev.PDS != cur.PDS {
+		changed = append(changed, IdentityFieldPDS)
+	}
+	if prev.ID == 0 || prev.SigningKey != cur.SigningKey {
+		changed = append(changed, IdentityFieldSigningKey)
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	cur.Changed = strings.Join(changed, ",")
+
+	if err := bgs.db.WithContext(ctx).Create(&cur).Error; err != nil {
+		return fmt.Errorf("failed to record identity change: %w", err)
+	}
+	for _, f := range changed {
+		identityChangesRecorded.WithLabelValues(f).Inc()
+	}
+	if prev.ID != 0 {
+		log.Info("account identity changed", "did", did, "changed", cur.Changed, "handle", cur.Handle, "pds", cur.PDS)
+	}
+	return nil
+}
+
+// ListIdentityChanges returns an account's identity history, newest first. Entries older than the before ID (if non-zero) are returned, for pagination.
+func (bgs *BGS) ListIdentityChanges(ctx context.Context, did string, before uint, limit int) ([]IdentityChange, error) {
+	q := bgs.db.WithContext(ctx).Where("did = ?", did)
+	if before != 0 {
+		q = q.Where("id < ?", before)
+	}
+	out := []IdentityChange{}
+	if err := q.Order("id DESC").Limit(limit).Find(&out).Error; err != nil {
+		return nil, err
+	}
+	return out, nil
+}
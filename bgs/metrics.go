@@ -42,6 +42,66 @@ var externalUserCreationAttempts = promauto.NewCounter(prometheus.CounterOpts{
 	Help: "The total number of external users created",
 })
 
+var accountMigrations = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_account_migrations",
+	Help: "The total number of accounts moved to a new PDS",
+})
+
+var newcomerThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_newcomer_throttled",
+	Help: "The total number of events delayed or dropped by new host and new account throttles",
+}, []string{"throttle"})
+
+var newcomerEventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_newcomer_events_dropped",
+	Help: "The total number of events for previously unseen accounts dropped because their host may not introduce more accounts yet",
+}, []string{"pds"})
+
+var repoResyncs = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_repo_resyncs",
+	Help: "The total number of admin-triggered repo resyncs, by whether the local copy had diverged",
+}, []string{"diverged"})
+
+var handleChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_handle_checks",
+	Help: "The total number of bidirectional handle verifications, by result",
+}, []string{"result"})
+
+var accountPurges = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_account_purges",
+	Help: "The total number of accounts permanently purged by admin request",
+})
+
+var eventsOutsideShard = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_events_outside_shard",
+	Help: "The total number of upstream events skipped because their account belongs to another shard",
+})
+
+var staleHostReconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_stale_host_reconnects",
+	Help: "The total number of upstream subscriptions reconnected after going silent",
+}, []string{"pds"})
+
+var hostFlapAlerts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_host_flap_alerts",
+	Help: "The total number of times an upstream host started flapping, repeatedly going stale",
+}, []string{"pds"})
+
+var accountCommitsOverLimit = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_account_commits_over_limit",
+	Help: "The total number of commits received over their account's rate limit, by the action taken",
+}, []string{"action"})
+
+var nonArchivalStaleCommits = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_non_archival_stale_commits",
+	Help: "The total number of commit events dropped in non-archival mode for not advancing the repo rev",
+})
+
+var nonArchivalGaps = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_non_archival_gaps",
+	Help: "The total number of commit events forwarded in non-archival mode which did not follow the previous commit seen",
+})
+
 var compactionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 	Name:    "compaction_duration",
 	Help:    "A histogram of compaction latencies",
@@ -53,6 +113,16 @@ var compactionQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
 	Help: "The current depth of the compaction queue",
 })
 
+var compactionsActive = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "compactions_active",
+	Help: "The number of repos being compacted",
+})
+
+var compactionQueueDepthByPriority = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "compaction_queue_depth_by_priority",
+	Help: "The current depth of each priority tier of the compaction queue",
+}, []string{"priority"})
+
 var newUsersDiscovered = promauto.NewCounter(prometheus.CounterOpts{
 	Name: "bgs_new_users_discovered",
 	Help: "The total number of new users discovered directly from the firehose (not from refs)",
@@ -85,7 +155,7 @@ var resSz = promauto.NewHistogramVec(prometheus.HistogramOpts{
 func MetricsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		path := c.Path()
-		if path == "/metrics" || path == "/_health" {
+		if path == "/metrics" || path == "/_health" || path == "/healthz" || path == "/readyz" {
 			return next(c)
 		}
 
@@ -0,0 +1,132 @@
+package bgs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+)
+
+// migrateAccount moves an existing account to the PDS its DID document now declares, by re-associating the uid with the new host. The rest of the move, which talks to the new host, is done by finishMigration.
+//
+// Must be called with extUserLk held.
+func (s *BGS) migrateAccount(ctx context.Context, ai *models.ActorInfo, to *models.PDS) error {
+	ctx, span := tracer.Start(ctx, "migrateAccount")
+	defer span.End()
+
+	from := ai.PDS
+	span.SetAttributes(
+		attribute.String("did", ai.Did),
+		attribute.Int64("from_pds", int64(from)),
+		attribute.String("to_pds", to.Host),
+	)
+
+	if s.ssl && !to.SSL {
+		return fmt.Errorf("refusing to migrate account %s to non-ssl PDS %q", ai.Did, to.Host)
+	}
+
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(User{}).Where("id = ?", ai.Uid).Update("pds", to.ID).Error; err != nil {
+			return fmt.Errorf("failed to update users pds: %w", err)
+		}
+
+		if err := tx.Model(models.ActorInfo{}).Where("uid = ?", ai.Uid).Update("pds", to.ID).Error; err != nil {
+			return fmt.Errorf("failed to update users pds on actorInfo: %w", err)
+		}
+
+		if from != 0 {
+			if err := tx.Model(&models.PDS{}).Where("id = ? AND repo_count > 0", from).Update("repo_count", gorm.Expr("repo_count - 1")).Error; err != nil {
+				return fmt.Errorf("failed to decrement repo count for old pds: %w", err)
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	ai.PDS = to.ID
+	accountMigrations.Inc()
+	log.Info("account migrated to new pds", "did", ai.Did, "from_pds", from, "to_pds", to.Host)
+
+	return nil
+}
+
+// finishMigration completes an account's move to a new PDS after migrateAccount: the account's status is taken from the new host (the old host's view of it is no longer authoritative), the new host is subscribed to, any commits missed during the move are crawled from the new host, and an #account event is emitted downstream.
+//
+// Must be called without extUserLk held, so a slow or unresponsive host doesn't hold up account creation for everyone else.
+func (s *BGS) finishMigration(ctx context.Context, ai *models.ActorInfo, to *models.PDS, c *xrpc.Client) error {
+	ctx, span := tracer.Start(ctx, "finishMigration")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("did", ai.Did),
+		attribute.String("to_pds", to.Host),
+	)
+
+	// Ask the new host how it sees the account. If it can't tell us, assume active; the host's own #account event will correct us.
+	status := events.AccountStatusActive
+	if rs, err := comatproto.SyncGetRepoStatus(ctx, c, ai.Did); err != nil {
+		log.Warn("failed to get repo status from new pds, assuming active", "did", ai.Did, "pds", to.Host, "err", err)
+	} else if !rs.Active {
+		status = events.AccountStatusDeactivated
+		if rs.Status != nil {
+			status = *rs.Status
+		}
+	}
+
+	if err := s.UpdateAccountStatus(ctx, ai.Did, status); err != nil {
+		return fmt.Errorf("failed to update migrated account status: %w", err)
+	}
+
+	if err := s.slurper.SubscribeToPds(ctx, to.Host, false, false); err != nil {
+		// the account is still associated with the new host, and will catch up whenever we do connect to it
+		log.Warn("failed to subscribe to migrated account's new pds", "did", ai.Did, "pds", to.Host, "err", err)
+	}
+
+	u, err := s.lookupUserByDid(ctx, ai.Did)
+	if err != nil {
+		return err
+	}
+
+	active := status == events.AccountStatusActive
+	if active && !u.TakenDown && !u.Suspended && !s.nonArchival {
+		// fetch anything committed on the new host that we missed while the old host was authoritative
+		if err := s.Index.Crawler.Crawl(ctx, ai); err != nil {
+			return fmt.Errorf("failed to enqueue crawl of migrated account: %w", err)
+		}
+	}
+
+	var evtStatus *string
+	switch {
+	case u.TakenDown:
+		active = false
+		evtStatus = &events.AccountStatusTakendown
+	case u.Suspended:
+		active = false
+		evtStatus = &events.AccountStatusSuspended
+	case !active:
+		evtStatus = &status
+	}
+
+	if err := s.events.AddEvent(ctx, &events.XRPCStreamEvent{
+		RepoAccount: &comatproto.SyncSubscribeRepos_Account{
+			Did:    ai.Did,
+			Time:   time.Now().Format(util.ISO8601),
+			Active: active,
+			Status: evtStatus,
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to broadcast account event for migration: %w", err)
+	}
+
+	return nil
+}
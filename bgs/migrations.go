@@ -0,0 +1,32 @@
+package bgs
+
+import (
+	"embed"
+
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/util/migrate"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// NewMigrator returns the schema migrations for the relay's database. The first is the schema from before migrations were versioned, and later ones are the SQL files in migrations/
+func NewMigrator(db *gorm.DB) (*migrate.Migrator, error) {
+	sqlMigrations, err := migrate.LoadSQL(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	baseline := migrate.Models(1, "baseline",
+		&User{},
+		&AuthToken{},
+		&models.PDS{},
+		&models.DomainBan{},
+		&AdminAction{},
+		&IdentityChange{},
+		&RepoHead{},
+		&SlurpConfig{},
+	)
+	return migrate.New(db, "bgs", append(sqlMigrations, baseline)...)
+}
@@ -0,0 +1,118 @@
+package bgs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/models"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Handling of repo data requests (sync.getRepo, sync.getRecord, sync.getBlocks) when the relay does not archive repos
+const (
+	NonArchivalSyncRefuse = "refuse"
+	NonArchivalSyncProxy  = "proxy"
+)
+
+var errNonArchival = fmt.Errorf("relay does not archive repos")
+
+// RepoHead is the latest commit the relay has seen for an account. In non-archival mode it is tracked here, instead of in the carstore.
+type RepoHead struct {
+	Uid       models.Uid `gorm:"primarykey"`
+	Commit    string
+	Rev       string
+	UpdatedAt time.Time
+}
+
+func (bgs *BGS) getRepoHead(ctx context.Context, uid models.Uid) (*RepoHead, error) {
+	var head RepoHead
+	if err := bgs.db.WithContext(ctx).Find(&head, "uid = ?", uid).Error; err != nil {
+		return nil, err
+	}
+	if head.Uid == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &head, nil
+}
+
+// handleNonArchivalCommit validates and rebroadcasts a commit event without storing the repo. Gaps in an account's history can't be repaired without an archive, so they are forwarded as-is for downstream consumers to deal with.
+func (bgs *BGS) handleNonArchivalCommit(ctx context.Context, host *models.PDS, u *User, evt *comatproto.SyncSubscribeRepos_Commit) error {
+	var prev *cid.Cid
+	head, err := bgs.getRepoHead(ctx, u.ID)
+	switch {
+	case err == nil:
+		if evt.Rev <= head.Rev {
+			eventLog.Debug("dropping stale commit event", "did", u.Did, "seq", evt.Seq, "host", host.Host, "rev", evt.Rev, "head_rev", head.Rev)
+			nonArchivalStaleCommits.Inc()
+			return nil
+		}
+
+		if evt.Since != nil && *evt.Since != head.Rev {
+			eventLog.Info("commit event does not follow previous commit", "did", u.Did, "seq", evt.Seq, "host", host.Host, "since", *evt.Since, "head_rev", head.Rev)
+			nonArchivalGaps.Inc()
+		}
+
+		if c, err := cid.Decode(head.Commit); err == nil {
+			prev = &c
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+	default:
+		return fmt.Errorf("failed to load repo head: %w", err)
+	}
+
+	return bgs.repoman.VerifyExternalUserEvent(ctx, host.ID, u.ID, u.Did, prev, evt.Since, evt.Rev, evt.Blocks, evt.Ops, func(ctx context.Context, root cid.Cid) error {
+		if root.String() != evt.Commit.String() {
+			return fmt.Errorf("commit event cid did not match blocks (%s != %s)", evt.Commit, root)
+		}
+
+		return bgs.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&RepoHead{
+			Uid:    u.ID,
+			Commit: root.String(),
+			Rev:    evt.Rev,
+		}).Error
+	})
+}
+
+// requireArchival is middleware for admin routes which operate on stored repos
+func (bgs *BGS) requireArchival(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(e echo.Context) error {
+		if bgs.nonArchival {
+			return &echo.HTTPError{
+				Code:    400,
+				Message: errNonArchival.Error(),
+			}
+		}
+		return next(e)
+	}
+}
+
+// proxySyncRequest fetches repo data for an account from its PDS, in place of serving it from the carstore
+func (bgs *BGS) proxySyncRequest(ctx context.Context, u *User, fetch func(ctx context.Context, pds *models.PDS) ([]byte, error)) (io.Reader, error) {
+	if bgs.nonArchivalSync != NonArchivalSyncProxy {
+		return nil, echo.NewHTTPError(http.StatusNotImplemented, "this relay does not archive repos, fetch from the account's PDS instead")
+	}
+
+	var pds models.PDS
+	if err := bgs.db.First(&pds, "id = ?", u.PDS).Error; err != nil {
+		log.Error("failed to find pds for account", "err", err, "did", u.Did)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to find pds for account")
+	}
+
+	b, err := fetch(ctx, &pds)
+	if err != nil {
+		log.Warn("failed to proxy sync request", "err", err, "did", u.Did, "pds", pds.Host)
+		return nil, echo.NewHTTPError(http.StatusBadGateway, "failed to fetch from account's pds")
+	}
+
+	return bytes.NewReader(b), nil
+}
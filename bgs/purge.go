@@ -0,0 +1,140 @@
+package bgs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+)
+
+// PurgeReport records what was deleted by PurgeAccount
+type PurgeReport struct {
+	Did         string     `json:"did"`
+	Uid         models.Uid `json:"uid"`
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt time.Time  `json:"completed_at"`
+	// Number of carstore shards deleted
+	CarShards int `json:"car_shards"`
+	// Persisted events for the account were blanked out
+	EventsPurged bool `json:"events_purged"`
+	// Mirrored blob references deleted, and blobs deleted from the mirror's store because no other account references them
+	MirroredBlobRefs int64 `json:"mirrored_blob_refs"`
+	MirroredBlobs    int64 `json:"mirrored_blobs"`
+	// Database rows deleted, by table
+	Rows map[string]int64 `json:"rows"`
+	// Cached DID document dropped
+	IdentityFlushed bool `json:"identity_flushed"`
+}
+
+// PurgeAccount permanently deletes everything the relay holds for an account, for legal deletion requests: its repo data, mirrored blobs, persisted events (which are tombstoned in place, so sequence numbers are preserved), database rows, and cached identity data. Unlike a takedown, nothing is kept to block the account, so if its PDS keeps emitting events for it, it will be re-crawled as a new account.
+//
+// Steps run in order, and the account's rows are deleted last, so a purge which fails part way through can be retried. The returned report reflects the steps completed so far, even on error.
+func (bgs *BGS) PurgeAccount(ctx context.Context, did string) (*PurgeReport, error) {
+	ctx, span := tracer.Start(ctx, "PurgeAccount")
+	defer span.End()
+	span.SetAttributes(attribute.String("did", did))
+
+	u, err := bgs.lookupUserByDid(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PurgeReport{
+		Did:       did,
+		Uid:       u.ID,
+		StartedAt: time.Now(),
+		Rows:      make(map[string]int64),
+	}
+
+	// stop serving and rebroadcasting the account while its data is being deleted
+	if err := bgs.db.WithContext(ctx).Model(User{}).Where("id = ?", u.ID).UpdateColumns(map[string]any{
+		"taken_down": true,
+		"handle":     nil,
+	}).Error; err != nil {
+		return report, fmt.Errorf("failed to take down account: %w", err)
+	}
+	bgs.accountStatusChanged(did)
+
+	n, err := bgs.repoman.PurgeRepo(ctx, u.ID)
+	report.CarShards = n
+	if err != nil {
+		return report, fmt.Errorf("failed to delete repo data: %w", err)
+	}
+
+	if bgs.blobMirror != nil {
+		refs, blobs, err := bgs.blobMirror.PurgeAccount(ctx, did)
+		report.MirroredBlobRefs = refs
+		report.MirroredBlobs = blobs
+		if err != nil {
+			return report, fmt.Errorf("failed to delete mirrored blobs: %w", err)
+		}
+	}
+
+	if err := bgs.events.PurgeRepo(ctx, u.ID, did); err != nil {
+		return report, fmt.Errorf("failed to purge persisted events: %w", err)
+	}
+	report.EventsPurged = true
+
+	// downstream services must delete the account's content too
+	if err := bgs.broadcastAccountStatus(ctx, did, events.AccountStatusDeleted); err != nil {
+		return report, err
+	}
+
+	if err := bgs.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		del := func(table string, model any, query string, args ...any) error {
+			// hard deletes, not gorm's soft deletes
+			res := tx.Unscoped().Where(query, args...).Delete(model)
+			if res.Error != nil {
+				return fmt.Errorf("failed to delete from %s: %w", table, res.Error)
+			}
+			if res.RowsAffected > 0 {
+				report.Rows[table] = res.RowsAffected
+			}
+			return nil
+		}
+
+		if bgs.nonArchival {
+			if err := del("repo_heads", &RepoHead{}, "uid = ?", u.ID); err != nil {
+				return err
+			}
+		}
+		if err := del("feed_posts", &models.FeedPost{}, "author = ?", u.ID); err != nil {
+			return err
+		}
+		if err := del("repost_records", &models.RepostRecord{}, "reposter = ? OR author = ?", u.ID, u.ID); err != nil {
+			return err
+		}
+		if err := del("vote_records", &models.VoteRecord{}, "voter = ?", u.ID); err != nil {
+			return err
+		}
+		if err := del("follow_records", &models.FollowRecord{}, "follower = ? OR target = ?", u.ID, u.ID); err != nil {
+			return err
+		}
+		if err := del("actor_infos", &models.ActorInfo{}, "uid = ?", u.ID); err != nil {
+			return err
+		}
+		if err := del("identity_changes", &IdentityChange{}, "did = ?", did); err != nil {
+			return err
+		}
+		return del("users", &User{}, "id = ?", u.ID)
+	}); err != nil {
+		report.Rows = make(map[string]int64)
+		return report, err
+	}
+
+	bgs.accountStatusChanged(did)
+
+	bgs.didr.FlushCacheFor(did)
+	report.IdentityFlushed = true
+
+	report.CompletedAt = time.Now()
+	accountPurges.Inc()
+	log.Info("purged account", "did", did, "uid", u.ID, "car_shards", report.CarShards, "mirrored_blobs", report.MirroredBlobs, "rows", report.Rows)
+
+	return report, nil
+}
@@ -0,0 +1,321 @@
+package bgs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/util"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+)
+
+// CheckpointVersion is the version of the Checkpoint format written by this relay
+const CheckpointVersion = 1
+
+// DefaultRestoreSeqMargin is how far past a checkpoint's last sequence number a restored relay starts numbering events, by default. It must exceed the number of events the failed relay emitted after its last checkpoint
+const DefaultRestoreSeqMargin = 1_000_000
+
+var ErrNoCheckpoint = errors.New("no checkpoint found")
+
+var checkpointsWritten = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_checkpoints_written",
+	Help: "The total number of replication checkpoints written, by whether they succeeded",
+}, []string{"ok"})
+
+var checkpointLastSeq = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "bgs_checkpoint_last_seq",
+	Help: "The firehose sequence number recorded in the last checkpoint written",
+})
+
+var checkpointLastWritten = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "bgs_checkpoint_last_written_timestamp",
+	Help: "When the last checkpoint was written, as a unix timestamp",
+})
+
+// Checkpoint is the state a standby relay needs to take over the firehose from this one: the sequencer position, and how far the relay has read each upstream host's stream. A relay restored from a checkpoint resubscribes to hosts from the checkpoint's cursors and numbers events from past LastSeq, so downstream consumers may see some events twice but don't miss any.
+type Checkpoint struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Sequence number of the last event persisted when the checkpoint was taken
+	LastSeq int64            `json:"lastSeq"`
+	Hosts   []CheckpointHost `json:"hosts"`
+}
+
+// CheckpointHost is an upstream host's cursor and configuration at the time of a checkpoint
+type CheckpointHost struct {
+	Host             string  `json:"host"`
+	SSL              bool    `json:"ssl"`
+	Cursor           int64   `json:"cursor"`
+	Registered       bool    `json:"registered"`
+	Blocked          bool    `json:"blocked,omitempty"`
+	Paused           bool    `json:"paused,omitempty"`
+	Trust            string  `json:"trust,omitempty"`
+	RateLimit        float64 `json:"rateLimit"`
+	CrawlRateLimit   float64 `json:"crawlRateLimit"`
+	RepoLimit        int64   `json:"repoLimit"`
+	HourlyEventLimit int64   `json:"hourlyEventLimit"`
+	DailyEventLimit  int64   `json:"dailyEventLimit"`
+}
+
+// CheckpointStore keeps the latest checkpoint somewhere a standby relay can read it after losing the primary
+type CheckpointStore interface {
+	PutCheckpoint(ctx context.Context, cp *Checkpoint) error
+	// GetCheckpoint returns ErrNoCheckpoint if no checkpoint has been stored
+	GetCheckpoint(ctx context.Context) (*Checkpoint, error)
+}
+
+// FileCheckpointStore keeps the checkpoint in a local file, which is replaced atomically. The file should be on a volume the standby can read (eg, replicated storage, or a network filesystem)
+type FileCheckpointStore struct {
+	Path string
+}
+
+func (s *FileCheckpointStore) PutCheckpoint(ctx context.Context, cp *Checkpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+func (s *FileCheckpointStore) GetCheckpoint(ctx context.Context) (*Checkpoint, error) {
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoCheckpoint
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeCheckpoint(bytes.NewReader(b))
+}
+
+// S3CheckpointStore keeps the checkpoint as an object in an S3 (or S3-compatible) bucket, which may be replicated to the standby's region
+type S3CheckpointStore struct {
+	util.S3Client
+	Key string
+}
+
+func (s *S3CheckpointStore) PutCheckpoint(ctx context.Context, cp *Checkpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if err := s.PutObject(ctx, s.Key, int64(len(b)), bytes.NewReader(b), "application/json"); err != nil {
+		return fmt.Errorf("uploading checkpoint to S3: %w", err)
+	}
+	return nil
+}
+
+func (s *S3CheckpointStore) GetCheckpoint(ctx context.Context) (*Checkpoint, error) {
+	rc, err := s.GetObject(ctx, s.Key)
+	if errors.Is(err, util.ErrS3ObjectNotFound) {
+		return nil, ErrNoCheckpoint
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching checkpoint from S3: %w", err)
+	}
+	defer rc.Close()
+	return decodeCheckpoint(rc)
+}
+
+// ParseCheckpointStore configures a CheckpointStore from a URI: either a local file path, or "s3://<bucket>/<key>". S3 configuration and credentials are read from the standard AWS_REGION, AWS_ENDPOINT_URL, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment variables.
+func ParseCheckpointStore(uri string) (CheckpointStore, error) {
+	if !strings.HasPrefix(uri, "s3://") {
+		if err := os.MkdirAll(filepath.Dir(uri), 0755); err != nil {
+			return nil, fmt.Errorf("checkpoint directory: %w", err)
+		}
+		return &FileCheckpointStore{Path: uri}, nil
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 checkpoint URI: %w", err)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("S3 checkpoint URI must include bucket name and key: %s", uri)
+	}
+	return &S3CheckpointStore{
+		S3Client: util.S3ClientFromEnv(u.Host, "", time.Minute),
+		Key:      key,
+	}, nil
+}
+
+func decodeCheckpoint(r io.Reader) (*Checkpoint, error) {
+	var cp Checkpoint
+	if err := json.NewDecoder(r).Decode(&cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint: %w", err)
+	}
+	if cp.Version != CheckpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d", cp.Version)
+	}
+	return &cp, nil
+}
+
+// Checkpoint captures the relay's current sequencer position and upstream cursors. It fails if no events have been persisted since the relay started, as the sequencer position isn't known
+func (bgs *BGS) Checkpoint(ctx context.Context) (*Checkpoint, error) {
+	// cursors are read first, so a restored relay rereads any events between them and LastSeq rather than skipping them
+	live := bgs.slurper.activeCursors()
+	var hosts []models.PDS
+	if err := bgs.db.WithContext(ctx).Order("id asc").Find(&hosts).Error; err != nil {
+		return nil, err
+	}
+	lastSeq := bgs.events.LastSeq()
+	if lastSeq <= 0 {
+		return nil, fmt.Errorf("no events persisted yet")
+	}
+
+	cp := &Checkpoint{
+		Version:   CheckpointVersion,
+		CreatedAt: time.Now().UTC(),
+		LastSeq:   lastSeq,
+		Hosts:     make([]CheckpointHost, 0, len(hosts)),
+	}
+	for _, h := range hosts {
+		cursor := h.Cursor
+		if c, ok := live[h.Host]; ok {
+			cursor = c
+		}
+		cp.Hosts = append(cp.Hosts, CheckpointHost{
+			Host:             h.Host,
+			SSL:              h.SSL,
+			Cursor:           cursor,
+			Registered:       h.Registered,
+			Blocked:          h.Blocked,
+			Paused:           h.Paused,
+			Trust:            h.Trust,
+			RateLimit:        h.RateLimit,
+			CrawlRateLimit:   h.CrawlRateLimit,
+			RepoLimit:        h.RepoLimit,
+			HourlyEventLimit: h.HourlyEventLimit,
+			DailyEventLimit:  h.DailyEventLimit,
+		})
+	}
+	return cp, nil
+}
+
+// runCheckpoints writes a checkpoint to each store every interval, until the relay shuts down
+func (bgs *BGS) runCheckpoints(interval time.Duration, stores []CheckpointStore, exit <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-exit:
+			return
+		case <-t.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		bgs.writeCheckpoint(ctx, stores)
+		cancel()
+	}
+}
+
+func (bgs *BGS) writeCheckpoint(ctx context.Context, stores []CheckpointStore) {
+	cp, err := bgs.Checkpoint(ctx)
+	if err != nil {
+		log.Warn("failed to take checkpoint", "err", err)
+		checkpointsWritten.WithLabelValues("false").Inc()
+		return
+	}
+	for _, store := range stores {
+		if err := store.PutCheckpoint(ctx, cp); err != nil {
+			log.Error("failed to write checkpoint", "err", err, "seq", cp.LastSeq)
+			checkpointsWritten.WithLabelValues("false").Inc()
+			continue
+		}
+		checkpointsWritten.WithLabelValues("true").Inc()
+	}
+	checkpointLastSeq.Set(float64(cp.LastSeq))
+	checkpointLastWritten.Set(float64(cp.CreatedAt.Unix()))
+}
+
+// RestoreCheckpoint prepares a standby relay to take over from the relay which wrote cp: upstream hosts are set to resume from the checkpoint's cursors (and added, if the standby doesn't know them), and the event sequence continues from LastSeq+margin. It must be done before NewBGS, which starts the upstream subscriptions, and requires an event persister supporting resequencing (the disk persister). Restoring the same checkpoint twice fails, once the restored relay has persisted events
+func RestoreCheckpoint(ctx context.Context, db *gorm.DB, evtman *events.EventManager, cp *Checkpoint, margin int64) error {
+	if margin < 0 {
+		return fmt.Errorf("invalid sequence margin %d", margin)
+	}
+	// a standby's database may be new, so its schema is brought up to date as NewBGS would
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	if _, err := migrator.Up(ctx, 0); err != nil {
+		return err
+	}
+
+	next := cp.LastSeq + margin + 1
+	prev, err := evtman.Resequence(ctx, next)
+	if errors.Is(err, events.ErrLiveEventsAfterSequence) {
+		return fmt.Errorf("relay already has events numbered after the checkpoint (was it restored already?): %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("resequencing events: %w", err)
+	}
+	log.Info("resequenced events from checkpoint", "checkpoint", cp.CreatedAt, "lastSeq", cp.LastSeq, "next", next, "prev", prev)
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, h := range cp.Hosts {
+			var pds models.PDS
+			if err := tx.Where("host = ?", h.Host).Limit(1).Find(&pds).Error; err != nil {
+				return err
+			}
+			pds.Host = h.Host
+			pds.SSL = h.SSL
+			pds.Cursor = h.Cursor
+			pds.Registered = h.Registered
+			pds.Blocked = h.Blocked
+			pds.Paused = h.Paused
+			pds.Trust = h.Trust
+			pds.RateLimit = h.RateLimit
+			pds.CrawlRateLimit = h.CrawlRateLimit
+			pds.RepoLimit = h.RepoLimit
+			pds.HourlyEventLimit = h.HourlyEventLimit
+			pds.DailyEventLimit = h.DailyEventLimit
+			if err := tx.Save(&pds).Error; err != nil {
+				return fmt.Errorf("restoring host %s: %w", h.Host, err)
+			}
+		}
+		log.Info("restored upstream cursors from checkpoint", "hosts", len(cp.Hosts))
+		return nil
+	})
+}
+
+// handleAdminCheckpoint serves a checkpoint of the relay's current state
+func (bgs *BGS) handleAdminCheckpoint(c echo.Context) error {
+	cp, err := bgs.Checkpoint(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, fmt.Sprintf("failed to take checkpoint: %s", err))
+	}
+	return c.JSON(http.StatusOK, cp)
+}
@@ -0,0 +1,57 @@
+package bgs
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3CheckpointStore(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	objects := make(map[string][]byte)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "PUT":
+			if r.Header.Get("Content-Type") != "application/json" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			b, _ := io.ReadAll(r.Body)
+			objects[r.URL.EscapedPath()] = b
+		case "GET":
+			b, ok := objects[r.URL.EscapedPath()]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(b)
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	store, err := ParseCheckpointStore("s3://relay/checkpoints/latest.json")
+	assert.NoError(err)
+
+	_, err = store.GetCheckpoint(ctx)
+	assert.ErrorIs(err, ErrNoCheckpoint)
+
+	cp := &Checkpoint{Version: CheckpointVersion, LastSeq: 123, Hosts: []CheckpointHost{{Host: "pds.example.com", SSL: true, Cursor: 45}}}
+	assert.NoError(store.PutCheckpoint(ctx, cp))
+	assert.Contains(objects, "/relay/checkpoints/latest.json")
+
+	got, err := store.GetCheckpoint(ctx)
+	assert.NoError(err)
+	assert.Equal(cp.LastSeq, got.LastSeq)
+	assert.Equal(cp.Hosts, got.Hosts)
+
+	_, err = ParseCheckpointStore("s3://relay")
+	assert.Error(err)
+}
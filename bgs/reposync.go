@@ -0,0 +1,90 @@
+package bgs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repomgr"
+	"github.com/bluesky-social/indigo/util"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/ipld/go-car"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ResyncRepo re-fetches an account's complete repo from its PDS, replaces our copy with it, and emits a #sync event so downstream consumers can do the same. This repairs a single repo which has diverged from its host, without deleting it.
+func (bgs *BGS) ResyncRepo(ctx context.Context, did string) (*repomgr.ResyncResult, error) {
+	ctx, span := tracer.Start(ctx, "ResyncRepo")
+	defer span.End()
+	span.SetAttributes(attribute.String("did", did))
+
+	if bgs.nonArchival {
+		return nil, errNonArchival
+	}
+
+	u, err := bgs.lookupUserByDid(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := accountUnavailable(u); err != nil {
+		return nil, err
+	}
+
+	var pds models.PDS
+	if err := bgs.db.First(&pds, "id = ?", u.PDS).Error; err != nil {
+		return nil, fmt.Errorf("failed to find pds for account: %w", err)
+	}
+
+	repoCar, err := bgs.repoFetcher.FetchRepo(ctx, &pds, did, "")
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := bgs.repoman.ResyncRepo(ctx, u.ID, did, bytes.NewReader(repoCar))
+	if err != nil {
+		return nil, err
+	}
+
+	repoResyncs.WithLabelValues(strconv.FormatBool(res.Diverged())).Inc()
+	span.SetAttributes(attribute.Bool("diverged", res.Diverged()))
+	log.Info("resynced repo", "did", did, "pds", pds.Host, "prev_rev", res.PrevRev, "rev", res.Rev,
+		"added", len(res.Added), "updated", len(res.Updated), "removed", len(res.Removed), "local_unreadable", res.LocalUnreadable)
+
+	// the #sync event carries just the signed commit, as a CAR
+	buf := new(bytes.Buffer)
+	hb, err := cbor.DumpObject(&car.CarHeader{
+		Roots:   []cid.Cid{res.Root},
+		Version: 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := carstore.LdWrite(buf, hb); err != nil {
+		return nil, err
+	}
+	if _, err := carstore.LdWrite(buf, res.CommitBlock.Cid().Bytes(), res.CommitBlock.RawData()); err != nil {
+		return nil, err
+	}
+
+	if err := bgs.events.AddEvent(ctx, &events.XRPCStreamEvent{
+		RepoSync: &comatproto.SyncSubscribeRepos_Sync{
+			Did:    did,
+			Rev:    res.Rev,
+			Blocks: buf.Bytes(),
+			Time:   time.Now().Format(util.ISO8601),
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to broadcast sync event: %w", err)
+	}
+
+	return res, nil
+}
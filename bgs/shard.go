@@ -0,0 +1,63 @@
+package bgs
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/bluesky-social/indigo/events"
+)
+
+// ShardConfig splits the network between several relays, by DID. Each shard subscribes to every host, but only ingests and stores the accounts it owns. The shards' firehoses are merged by a front-end (see the fanout package).
+type ShardConfig struct {
+	// Index of this relay's shard, from 0 to Count-1
+	Index int
+	// Total number of shards. Zero or one disables sharding
+	Count int
+}
+
+func (c ShardConfig) enabled() bool {
+	return c.Count > 1
+}
+
+func (c ShardConfig) validate() error {
+	if c.Count < 0 || (c.enabled() && (c.Index < 0 || c.Index >= c.Count)) {
+		return fmt.Errorf("invalid shard %d of %d", c.Index, c.Count)
+	}
+	return nil
+}
+
+// Owns reports whether an account belongs to this shard
+func (c ShardConfig) Owns(did string) bool {
+	if !c.enabled() {
+		return true
+	}
+	return ShardForDid(did, c.Count) == c.Index
+}
+
+// ShardForDid returns which of count shards an account belongs to. The assignment only depends on the DID and the number of shards, so every relay and front-end computes the same one.
+func ShardForDid(did string, count int) int {
+	h := fnv.New32a()
+	h.Write([]byte(did))
+	return int(h.Sum32() % uint32(count))
+}
+
+// EventDid returns the account an event is about, or the empty string for events which aren't about a single account
+func EventDid(evt *events.XRPCStreamEvent) string {
+	switch {
+	case evt.RepoCommit != nil:
+		return evt.RepoCommit.Repo
+	case evt.RepoHandle != nil:
+		return evt.RepoHandle.Did
+	case evt.RepoIdentity != nil:
+		return evt.RepoIdentity.Did
+	case evt.RepoAccount != nil:
+		return evt.RepoAccount.Did
+	case evt.RepoSync != nil:
+		return evt.RepoSync.Did
+	case evt.RepoMigrate != nil:
+		return evt.RepoMigrate.Did
+	case evt.RepoTombstone != nil:
+		return evt.RepoTombstone.Did
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+package bgs
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
+)
+
+var ErrHostStale = fmt.Errorf("no events or heartbeats from host")
+
+// StaleHostConfig configures detection of upstream subscriptions which have gone silent. A subscription is stale once nothing (no events, and no answers to our pings) has arrived from the host for Timeout, and is then reconnected from its last cursor.
+type StaleHostConfig struct {
+	// How long a subscription may be silent before it is reconnected. This must exceed the 30s ping interval. Zero disables detection
+	Timeout time.Duration
+	// Hosts going stale this many times within FlapWindow are reported as flapping
+	FlapThreshold int
+	FlapWindow    time.Duration
+}
+
+func DefaultStaleHostConfig() StaleHostConfig {
+	return StaleHostConfig{
+		Timeout:       2 * time.Minute,
+		FlapThreshold: 3,
+		FlapWindow:    time.Hour,
+	}
+}
+
+// connActivity tracks when a single connection to a host last showed signs of life. Events still being processed count as activity, so a throttled or backlogged host isn't mistaken for a silent one.
+type connActivity struct {
+	last     atomic.Int64
+	inflight atomic.Int64
+}
+
+func newConnActivity() *connActivity {
+	a := &connActivity{}
+	a.touch()
+	return a
+}
+
+func (a *connActivity) touch() {
+	a.last.Store(time.Now().UnixNano())
+}
+
+func (a *connActivity) idleFor(now time.Time) time.Duration {
+	if a.inflight.Load() > 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, a.last.Load()))
+}
+
+// handler wraps an event handler, marking events as no longer in flight once handled
+func (a *connActivity) handler(next func(context.Context, *events.XRPCStreamEvent) error) func(context.Context, *events.XRPCStreamEvent) error {
+	return func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+		defer a.touch()
+		defer a.inflight.Add(-1)
+		return next(ctx, evt)
+	}
+}
+
+// activityScheduler marks events as in flight as they are read from a connection
+type activityScheduler struct {
+	events.Scheduler
+	act *connActivity
+}
+
+func (s *activityScheduler) AddWork(ctx context.Context, repo string, val *events.XRPCStreamEvent) error {
+	s.act.touch()
+	s.act.inflight.Add(1)
+	if err := s.Scheduler.AddWork(ctx, repo, val); err != nil {
+		s.act.inflight.Add(-1)
+		return err
+	}
+	return nil
+}
+
+// watchStale cancels a connection once it has been silent for longer than the stale timeout, reporting whether it did
+func (s *Slurper) watchStale(ctx context.Context, host *models.PDS, act *connActivity, cancel func(), stale *atomic.Bool) {
+	t := time.NewTicker(s.staleCfg.Timeout / 4)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			if idle := act.idleFor(now); idle > s.staleCfg.Timeout {
+				log.Warn("upstream subscription is stale, reconnecting", "host", host.Host, "idle", idle)
+				stale.Store(true)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// observeStale records that a host's subscription went stale, alerting if the host keeps doing so
+func (s *Slurper) observeStale(host *models.PDS) {
+	staleHostReconnects.WithLabelValues(host.Host).Inc()
+
+	if s.hostStatsFor(host.ID).observeStale(time.Now(), s.staleCfg) {
+		hostFlapAlerts.WithLabelValues(host.Host).Inc()
+		log.Error("upstream host is flapping, repeatedly going stale", "host", host.Host, "threshold", s.staleCfg.FlapThreshold, "window", s.staleCfg.FlapWindow)
+	}
+}
+
+// observeStale returns true when the host starts flapping
+func (st *hostStats) observeStale(now time.Time, cfg StaleHostConfig) bool {
+	st.lk.Lock()
+	defer st.lk.Unlock()
+
+	st.staleReconnects++
+	st.lastStaleAt = now
+
+	wasFlapping := st.flappingAt(now, cfg)
+	st.staleAt = append(st.staleAt, now)
+	return !wasFlapping && st.flappingAt(now, cfg)
+}
+
+// flappingAt prunes stale reconnects outside the flap window, and reports whether those remaining reach the threshold. Must be called with the lock held
+func (st *hostStats) flappingAt(now time.Time, cfg StaleHostConfig) bool {
+	var expired int
+	for expired < len(st.staleAt) && now.Sub(st.staleAt[expired]) > cfg.FlapWindow {
+		expired++
+	}
+	st.staleAt = st.staleAt[expired:]
+
+	return cfg.FlapThreshold > 0 && len(st.staleAt) >= cfg.FlapThreshold
+}
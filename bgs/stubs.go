@@ -158,7 +158,13 @@ func (s *BGS) HandleComAtprotoSyncListRepos(c echo.Context) error {
 		}
 	}
 
-	out, handleErr := s.handleComAtprotoSyncListRepos(ctx, cursor, limit)
+	// relay extensions to the lexicon, for enumerating the network by status or host
+	filter := listReposFilter{
+		Status: c.QueryParam("status"),
+		Host:   c.QueryParam("host"),
+	}
+
+	out, handleErr := s.handleComAtprotoSyncListRepos(ctx, cursor, limit, filter)
 	if handleErr != nil {
 		return handleErr
 	}
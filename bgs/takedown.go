@@ -0,0 +1,76 @@
+package bgs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/util"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Label values applied to accounts taken down or suspended by the relay, when it has a labeler configured
+const (
+	LabelTakedown = "!takedown"
+	LabelSuspend  = "!suspend"
+)
+
+// announceAccountStatus tells downstream consumers about a change the relay made to an account's status: an #account event goes out on the firehose with the status the relay now reports for the account, and if a labeler is configured, the matching label is applied (or, on reinstatement, negated). Consumers should stop serving an account's content when it becomes inactive; see events.AccountStatusTracker.
+func (bgs *BGS) announceAccountStatus(ctx context.Context, did string) error {
+	u, err := bgs.lookupUserByDid(ctx, did)
+	if err != nil {
+		return err
+	}
+
+	if err := bgs.broadcastAccountStatus(ctx, u.Did, accountHostingStatus(u)); err != nil {
+		return err
+	}
+
+	if bgs.labeler == nil {
+		return nil
+	}
+	var apply, negate []string
+	for val, on := range map[string]bool{LabelTakedown: u.TakenDown, LabelSuspend: u.Suspended} {
+		if on {
+			apply = append(apply, val)
+		} else {
+			negate = append(negate, val)
+		}
+	}
+	if _, err := bgs.labeler.CreateLabels(ctx, u.Did, nil, apply); err != nil {
+		return fmt.Errorf("failed to label account: %w", err)
+	}
+	if _, err := bgs.labeler.NegateLabels(ctx, u.Did, nil, negate); err != nil {
+		return fmt.Errorf("failed to negate account labels: %w", err)
+	}
+	return nil
+}
+
+// broadcastAccountStatus emits an #account event on the firehose, with an account status such as events.AccountStatusTakendown
+func (bgs *BGS) broadcastAccountStatus(ctx context.Context, did string, status string) error {
+	evt := &comatproto.SyncSubscribeRepos_Account{
+		Did:    did,
+		Time:   time.Now().UTC().Format(util.ISO8601),
+		Active: status == events.AccountStatusActive,
+	}
+	if !evt.Active {
+		evt.Status = &status
+	}
+	if err := bgs.events.AddEvent(ctx, &events.XRPCStreamEvent{RepoAccount: evt}); err != nil {
+		return fmt.Errorf("failed to broadcast account event: %w", err)
+	}
+	return nil
+}
+
+// registerLabelerRoutes serves the relay's takedown and suspension labels, with com.atproto.label.queryLabels and com.atproto.label.subscribeLabels
+func (bgs *BGS) registerLabelerRoutes(e *echo.Echo) {
+	if bgs.labeler == nil {
+		return
+	}
+	e.GET("/xrpc/com.atproto.label.queryLabels", echo.WrapHandler(http.HandlerFunc(bgs.labeler.HandleQueryLabels)))
+	e.GET("/xrpc/com.atproto.label.subscribeLabels", echo.WrapHandler(http.HandlerFunc(bgs.labeler.HandleSubscribeLabels)))
+}
@@ -0,0 +1,189 @@
+package bgs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/time/rate"
+)
+
+// NewcomerThrottleConfig limits newly discovered PDS hosts and newly seen accounts, so a spam PDS can't suddenly flood the relay with synthetic accounts. The throttled limits double every RelaxEvery, and are lifted entirely once the host or account is older than Probation. Zero limits disable the corresponding throttle. Hosts matching a trusted domain (and their accounts) are never throttled.
+type NewcomerThrottleConfig struct {
+	Probation  time.Duration
+	RelaxEvery time.Duration
+
+	// Events per second accepted from a new host
+	HostPerSecond float64
+	// Previously unseen accounts a new host may introduce per hour
+	HostNewReposPerHour float64
+	// Events per second accepted for a single new account
+	AccountPerSecond float64
+}
+
+func (c *NewcomerThrottleConfig) enabled() bool {
+	return c.Probation > 0 && (c.HostPerSecond > 0 || c.HostNewReposPerHour > 0 || c.AccountPerSecond > 0)
+}
+
+// factor returns how much to scale the throttled limits by for something created at the given time, or zero if it is past probation
+func (c *NewcomerThrottleConfig) factor(created time.Time, now time.Time) float64 {
+	age := now.Sub(created)
+	if age >= c.Probation {
+		return 0
+	}
+	if age < 0 || c.RelaxEvery <= 0 {
+		return 1
+	}
+	// cap the shift, past this the limit is effectively lifted anyway
+	return float64(uint64(1) << min(uint64(age/c.RelaxEvery), 30))
+}
+
+const newcomerAccountCacheSize = 100_000
+
+// number of accounts remembered as having had events dropped, so only the first drop for each is logged
+const newcomerDroppedCacheSize = 10_000
+
+type hostThrottle struct {
+	events   *rate.Limiter
+	newRepos *rate.Limiter
+}
+
+type newcomerThrottle struct {
+	cfg    NewcomerThrottleConfig
+	exempt func(host string) bool
+
+	lk    sync.Mutex
+	hosts map[uint]*hostThrottle
+
+	accounts *lru.Cache[models.Uid, *rate.Limiter]
+	// DIDs of unseen accounts whose events have been dropped
+	dropped *lru.Cache[string, struct{}]
+}
+
+func newNewcomerThrottle(cfg NewcomerThrottleConfig, exempt func(host string) bool) *newcomerThrottle {
+	accounts, _ := lru.New[models.Uid, *rate.Limiter](newcomerAccountCacheSize)
+	dropped, _ := lru.New[string, struct{}](newcomerDroppedCacheSize)
+	return &newcomerThrottle{
+		cfg:      cfg,
+		exempt:   exempt,
+		hosts:    make(map[uint]*hostThrottle),
+		accounts: accounts,
+		dropped:  dropped,
+	}
+}
+
+// throttled returns a limiter for the throttled rate at the given scale factor, with a burst of burstWindow seconds' worth of events. An existing limiter is adjusted in place
+func throttled(lim *rate.Limiter, base float64, f float64, burstWindow float64) *rate.Limiter {
+	l := rate.Limit(base * f)
+	burst := max(1, int(base*f*burstWindow))
+	if lim == nil {
+		return rate.NewLimiter(l, burst)
+	}
+	if lim.Limit() != l {
+		lim.SetLimit(l)
+		lim.SetBurst(burst)
+	}
+	return lim
+}
+
+// hostLimiter returns the given throttle limiter for a host still in probation, or nil if the host is not throttled
+func (t *newcomerThrottle) hostLimiter(pds *models.PDS, field func(*hostThrottle) **rate.Limiter, base float64, burstWindow float64) *rate.Limiter {
+	f := t.cfg.factor(pds.CreatedAt, time.Now())
+	if f != 0 && t.exempt(pds.Host) {
+		f = 0
+	}
+
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	if f == 0 {
+		delete(t.hosts, pds.ID)
+		return nil
+	}
+
+	ht, ok := t.hosts[pds.ID]
+	if !ok {
+		ht = &hostThrottle{}
+		t.hosts[pds.ID] = ht
+	}
+
+	lim := field(ht)
+	*lim = throttled(*lim, base, f, burstWindow)
+	return *lim
+}
+
+// waitHost blocks until an event from a newly discovered host may be processed
+func (t *newcomerThrottle) waitHost(ctx context.Context, pds *models.PDS) error {
+	if t == nil || t.cfg.HostPerSecond <= 0 {
+		return nil
+	}
+
+	lim := t.hostLimiter(pds, func(ht *hostThrottle) **rate.Limiter { return &ht.events }, t.cfg.HostPerSecond, 1)
+	if lim == nil || lim.Allow() {
+		return nil
+	}
+	newcomerThrottled.WithLabelValues("host_events").Inc()
+	return lim.Wait(ctx)
+}
+
+// allowNewRepo reports whether a newly discovered host may introduce another previously unseen account right now
+func (t *newcomerThrottle) allowNewRepo(pds *models.PDS) bool {
+	if t == nil || t.cfg.HostNewReposPerHour <= 0 {
+		return true
+	}
+
+	lim := t.hostLimiter(pds, func(ht *hostThrottle) **rate.Limiter { return &ht.newRepos }, t.cfg.HostNewReposPerHour/3600, 3600)
+	if lim == nil || lim.Allow() {
+		return true
+	}
+	newcomerThrottled.WithLabelValues("host_new_repos").Inc()
+	return false
+}
+
+// waitAccount blocks until an event for a newly seen account on pds may be processed
+func (t *newcomerThrottle) waitAccount(ctx context.Context, pds *models.PDS, u *User) error {
+	if t == nil || t.cfg.AccountPerSecond <= 0 {
+		return nil
+	}
+
+	f := t.cfg.factor(u.CreatedAt, time.Now())
+	if f != 0 && t.exempt(pds.Host) {
+		f = 0
+	}
+	if f == 0 {
+		t.accounts.Remove(u.ID)
+		return nil
+	}
+
+	t.lk.Lock()
+	prev, _ := t.accounts.Get(u.ID)
+	lim := throttled(prev, t.cfg.AccountPerSecond, f, 1)
+	if prev == nil {
+		t.accounts.Add(u.ID, lim)
+	}
+	t.lk.Unlock()
+
+	if lim.Allow() {
+		return nil
+	}
+	newcomerThrottled.WithLabelValues("account_events").Inc()
+	return lim.Wait(ctx)
+}
+
+// newAccountThrottled reports whether an event from host should be dropped, because it would introduce a previously unseen account (u is nil) while the host is throttled. Drops are counted by host, and the first for each account is logged
+func (t *newcomerThrottle) newAccountThrottled(host *models.PDS, u *User, did string, kind string) bool {
+	if t == nil || u != nil || t.allowNewRepo(host) {
+		return false
+	}
+
+	newcomerEventsDropped.WithLabelValues(host.Host).Inc()
+	if seen, _ := t.dropped.ContainsOrAdd(did, struct{}{}); seen {
+		eventLog.Debug("dropping event for new account from throttled host", "did", did, "host", host.Host, "kind", kind)
+	} else {
+		log.Warn("dropping events for new account from throttled host until it may introduce more accounts", "did", did, "host", host.Host, "kind", kind)
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package bgs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
+)
+
+func TestNewcomerFactor(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		probation  time.Duration
+		relaxEvery time.Duration
+		age        time.Duration
+		expect     float64
+	}{
+		{"brand new", 48 * time.Hour, 12 * time.Hour, 0, 1},
+		{"before first relax", 48 * time.Hour, 12 * time.Hour, 11 * time.Hour, 1},
+		{"first relax", 48 * time.Hour, 12 * time.Hour, 12 * time.Hour, 2},
+		{"third relax", 48 * time.Hour, 12 * time.Hour, 47 * time.Hour, 8},
+		{"end of probation", 48 * time.Hour, 12 * time.Hour, 48 * time.Hour, 0},
+		{"long past probation", 48 * time.Hour, 12 * time.Hour, 1000 * time.Hour, 0},
+		{"created in the future", 48 * time.Hour, 12 * time.Hour, -time.Hour, 1},
+		{"never relaxed", 48 * time.Hour, 0, 47 * time.Hour, 1},
+		{"capped", 10000 * time.Hour, time.Hour, 9999 * time.Hour, 1 << 30},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewcomerThrottleConfig{Probation: tt.probation, RelaxEvery: tt.relaxEvery}
+			assert.Equal(t, tt.expect, cfg.factor(now.Add(-tt.age), now))
+		})
+	}
+}
+
+func TestNewcomerRelaxSchedule(t *testing.T) {
+	assert := assert.New(t)
+
+	nt := newNewcomerThrottle(NewcomerThrottleConfig{
+		Probation:     48 * time.Hour,
+		RelaxEvery:    12 * time.Hour,
+		HostPerSecond: 10,
+	}, func(host string) bool { return host == "trusted.example.com" })
+
+	pds := &models.PDS{Model: gorm.Model{ID: 1}, Host: "new.example.com"}
+	events := func(ht *hostThrottle) **rate.Limiter { return &ht.events }
+
+	// the limit doubles each relax period, on the same limiter
+	var first *rate.Limiter
+	for i, expect := range []float64{10, 20, 40, 80} {
+		pds.CreatedAt = time.Now().Add(-time.Duration(i)*12*time.Hour - time.Minute)
+		lim := nt.hostLimiter(pds, events, nt.cfg.HostPerSecond, 1)
+		if !assert.NotNil(lim) {
+			return
+		}
+		if first == nil {
+			first = lim
+		}
+		assert.Same(first, lim)
+		assert.Equal(rate.Limit(expect), lim.Limit())
+		assert.Equal(int(expect), lim.Burst())
+	}
+
+	// and is lifted at the end of probation
+	pds.CreatedAt = time.Now().Add(-48 * time.Hour)
+	assert.Nil(nt.hostLimiter(pds, events, nt.cfg.HostPerSecond, 1))
+	assert.NotContains(nt.hosts, pds.ID)
+
+	// trusted hosts are never throttled
+	trusted := &models.PDS{Model: gorm.Model{ID: 2, CreatedAt: time.Now()}, Host: "trusted.example.com"}
+	assert.Nil(nt.hostLimiter(trusted, events, nt.cfg.HostPerSecond, 1))
+}
+
+func TestNewcomerAccountThrottle(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	nt := newNewcomerThrottle(NewcomerThrottleConfig{
+		Probation:        48 * time.Hour,
+		RelaxEvery:       12 * time.Hour,
+		AccountPerSecond: 1,
+	}, func(string) bool { return false })
+
+	pds := &models.PDS{Model: gorm.Model{ID: 1, CreatedAt: time.Now()}, Host: "new.example.com"}
+	u := &User{ID: 7, CreatedAt: time.Now()}
+
+	assert.NoError(nt.waitAccount(ctx, pds, u))
+	lim, ok := nt.accounts.Get(u.ID)
+	if !assert.True(ok) {
+		return
+	}
+	assert.Equal(rate.Limit(1), lim.Limit())
+
+	// past probation, the account's limiter is dropped
+	u.CreatedAt = time.Now().Add(-48 * time.Hour)
+	assert.NoError(nt.waitAccount(ctx, pds, u))
+	assert.False(nt.accounts.Contains(u.ID))
+}
+
+func TestNewcomerDropsNewAccounts(t *testing.T) {
+	assert := assert.New(t)
+
+	nt := newNewcomerThrottle(NewcomerThrottleConfig{
+		Probation:           48 * time.Hour,
+		RelaxEvery:          12 * time.Hour,
+		HostNewReposPerHour: 2,
+	}, func(string) bool { return false })
+
+	pds := &models.PDS{Model: gorm.Model{ID: 1, CreatedAt: time.Now()}, Host: "drops.example.com"}
+	dropped := func() float64 { return testutil.ToFloat64(newcomerEventsDropped.WithLabelValues(pds.Host)) }
+
+	// the host's allowance of new accounts
+	assert.False(nt.newAccountThrottled(pds, nil, "did:example:1", "commit"))
+	assert.False(nt.newAccountThrottled(pds, nil, "did:example:2", "commit"))
+	assert.Zero(dropped())
+
+	// after which events introducing more are dropped
+	assert.True(nt.newAccountThrottled(pds, nil, "did:example:3", "commit"))
+	assert.True(nt.newAccountThrottled(pds, nil, "did:example:3", "account"))
+	assert.True(nt.newAccountThrottled(pds, nil, "did:example:4", "identity"))
+	assert.Equal(float64(3), dropped())
+	assert.Equal(2, nt.dropped.Len())
+
+	// while known accounts are unaffected
+	assert.False(nt.newAccountThrottled(pds, &User{ID: 1}, "did:example:1", "commit"))
+
+	// as are hosts past probation, and relays without the throttle
+	pds.CreatedAt = time.Now().Add(-48 * time.Hour)
+	assert.False(nt.newAccountThrottled(pds, nil, "did:example:5", "commit"))
+	var none *newcomerThrottle
+	assert.False(none.newAccountThrottled(pds, nil, "did:example:5", "commit"))
+	assert.Equal(float64(3), dropped())
+}
@@ -0,0 +1,116 @@
+package bgs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repomgr"
+)
+
+// Trust tiers for upstream hosts. Commits from verified hosts are applied without checking signatures; untrusted hosts get full validation (see repomgr.ValidateFull) and capped event rates.
+const (
+	HostTrustVerified  = "verified"
+	HostTrustDefault   = "default"
+	HostTrustUntrusted = "untrusted"
+)
+
+// HostTrustConfig configures host trust tiers. Individual hosts are assigned a tier by an admin; all others get Default.
+type HostTrustConfig struct {
+	// Tier for hosts which haven't been assigned one
+	Default string
+	// Event rate limits for untrusted hosts, capping the host's own limits. Zero leaves the corresponding limit uncapped
+	UntrustedPerSecond int64
+	UntrustedPerHour   int64
+	UntrustedPerDay    int64
+}
+
+func DefaultHostTrustConfig() HostTrustConfig {
+	return HostTrustConfig{
+		Default:            HostTrustDefault,
+		UntrustedPerSecond: 10,
+		UntrustedPerHour:   500,
+		UntrustedPerDay:    4_000,
+	}
+}
+
+func validHostTrust(trust string) bool {
+	switch trust {
+	case HostTrustVerified, HostTrustDefault, HostTrustUntrusted:
+		return true
+	}
+	return false
+}
+
+// validationLevel returns how thoroughly commits from a host at the given tier are checked
+func validationLevel(trust string) repomgr.ValidationLevel {
+	switch trust {
+	case HostTrustVerified:
+		return repomgr.ValidateNone
+	case HostTrustUntrusted:
+		return repomgr.ValidateFull
+	}
+	return repomgr.ValidateSignature
+}
+
+// HostTrust returns the trust tier of a subscribed host
+func (s *Slurper) HostTrust(pdsID uint) string {
+	s.trustLk.RLock()
+	defer s.trustLk.RUnlock()
+
+	if t := s.trust[pdsID]; t != "" {
+		return t
+	}
+	return s.trustCfg.Default
+}
+
+func (s *Slurper) cacheHostTrust(pds *models.PDS) {
+	s.trustLk.Lock()
+	defer s.trustLk.Unlock()
+
+	s.trust[pds.ID] = pds.Trust
+}
+
+// SetHostTrust assigns a host to a trust tier, or back to the default tier if trust is empty. It takes effect immediately for the host's subscription.
+func (s *Slurper) SetHostTrust(ctx context.Context, host string, trust string) error {
+	if trust != "" && !validHostTrust(trust) {
+		return fmt.Errorf("invalid trust tier %q", trust)
+	}
+
+	var pds models.PDS
+	if err := s.db.WithContext(ctx).Where("host = ?", host).First(&pds).Error; err != nil {
+		return err
+	}
+
+	if err := s.db.WithContext(ctx).Model(&models.PDS{}).Where("id = ?", pds.ID).Update("trust", trust).Error; err != nil {
+		return err
+	}
+
+	pds.Trust = trust
+	s.cacheHostTrust(&pds)
+
+	// re-apply the host's own limits, capped for its new tier
+	s.SetLimits(pds.ID, int64(pds.RateLimit), pds.HourlyEventLimit, pds.DailyEventLimit)
+
+	return nil
+}
+
+// SetHostTrust assigns a host to a trust tier (see Slurper.SetHostTrust)
+func (bgs *BGS) SetHostTrust(ctx context.Context, host string, trust string) error {
+	return bgs.slurper.SetHostTrust(ctx, host, trust)
+}
+
+// capLimits applies the untrusted tier's caps to a host's event limits, if it is untrusted
+func (s *Slurper) capLimits(pdsID uint, perSec, perHour, perDay int64) (int64, int64, int64) {
+	if s.HostTrust(pdsID) != HostTrustUntrusted {
+		return perSec, perHour, perDay
+	}
+
+	capped := func(lim, c int64) int64 {
+		if c > 0 && lim > c {
+			return c
+		}
+		return lim
+	}
+	return capped(perSec, s.trustCfg.UntrustedPerSecond), capped(perHour, s.trustCfg.UntrustedPerHour), capped(perDay, s.trustCfg.UntrustedPerDay)
+}
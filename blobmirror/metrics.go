@@ -0,0 +1,32 @@
+package blobmirror
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var blobsMirrored = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "blob_mirror_blobs_mirrored",
+	Help: "Number of blobs fetched and stored",
+})
+
+var blobBytesMirrored = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "blob_mirror_bytes_mirrored",
+	Help: "Total size of blobs fetched and stored",
+})
+
+var blobsSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "blob_mirror_blobs_skipped",
+	Help: "Number of blob references not fetched, by reason (filtered, queue_full, duplicate)",
+}, []string{"reason"})
+
+var blobsFailed = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "blob_mirror_blobs_failed",
+	Help: "Number of blobs which could not be fetched or stored",
+})
+
+var blobFetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "blob_mirror_fetch_duration_seconds",
+	Help:    "Time taken to fetch and store a blob",
+	Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+})
@@ -0,0 +1,391 @@
+// Package blobmirror keeps copies of the blobs (images, video, etc) referenced by records in the firehose, so archival deployments preserve media along with repos.
+//
+// Blob references are taken from commit events (see Mirror.HandleCommit). Blobs passing the size and MIME type allowlists are fetched from the account's PDS in the background, checked against their CID, and written to a Store once per CID. The database records which accounts reference each blob, and blobs are only served for those accounts.
+package blobmirror
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/util/logging"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var log = logging.Component("blobmirror")
+
+// Blob is a mirrored blob
+type Blob struct {
+	Cid       string `gorm:"primarykey"`
+	MimeType  string
+	Size      int64
+	CreatedAt time.Time
+}
+
+func (Blob) TableName() string {
+	return "blob_mirror_blobs"
+}
+
+// BlobRef records that an account's records reference a blob. The blob may not have been mirrored (yet)
+type BlobRef struct {
+	Did       string `gorm:"primarykey"`
+	Cid       string `gorm:"primarykey;index"`
+	CreatedAt time.Time
+}
+
+func (BlobRef) TableName() string {
+	return "blob_mirror_refs"
+}
+
+type Options struct {
+	// Largest blob to mirror, in bytes
+	MaxSize int64
+	// MIME types to mirror, exactly ("video/mp4") or by prefix ("image/*"). Empty mirrors every type
+	MimeTypes []string
+	// Number of blobs fetched at once
+	Workers int
+	// Blobs waiting to be fetched. References arriving while the queue is full are dropped
+	QueueSize int
+	// Client for fetching blobs from PDSs
+	HTTPClient *http.Client
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		MaxSize:    100_000_000,
+		MimeTypes:  []string{"image/*", "video/*"},
+		Workers:    8,
+		QueueSize:  10_000,
+		HTTPClient: util.RobustHTTPClient(),
+	}
+}
+
+type fetchJob struct {
+	did  string
+	pds  string
+	blob data.Blob
+}
+
+type Mirror struct {
+	db    *gorm.DB
+	store Store
+	opts  Options
+
+	jobs chan fetchJob
+
+	lk       sync.Mutex
+	inflight map[cid.Cid]bool
+
+	wg       sync.WaitGroup
+	shutdown chan struct{}
+}
+
+func New(db *gorm.DB, store Store, opts *Options) (*Mirror, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	if err := db.AutoMigrate(&Blob{}, &BlobRef{}); err != nil {
+		return nil, fmt.Errorf("migrating blob mirror tables: %w", err)
+	}
+	m := &Mirror{
+		db:       db,
+		store:    store,
+		opts:     *opts,
+		jobs:     make(chan fetchJob, max(opts.QueueSize, 1)),
+		inflight: make(map[cid.Cid]bool),
+		shutdown: make(chan struct{}),
+	}
+	if m.opts.HTTPClient == nil {
+		m.opts.HTTPClient = http.DefaultClient
+	}
+	return m, nil
+}
+
+// Start starts the fetch workers
+func (m *Mirror) Start() {
+	for i := 0; i < max(m.opts.Workers, 1); i++ {
+		m.wg.Add(1)
+		go m.worker()
+	}
+}
+
+// Shutdown stops the fetch workers, once they finish their current blobs. Queued blobs are dropped
+func (m *Mirror) Shutdown() {
+	close(m.shutdown)
+	m.wg.Wait()
+}
+
+func (m *Mirror) worker() {
+	defer m.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for {
+		select {
+		case <-m.shutdown:
+			return
+		case j := <-m.jobs:
+			m.process(ctx, j)
+		}
+	}
+}
+
+// allowed reports whether a blob's declared type and size are within the mirror's limits
+func (m *Mirror) allowed(b data.Blob) bool {
+	if b.Size > m.opts.MaxSize {
+		return false
+	}
+	if len(m.opts.MimeTypes) == 0 {
+		return true
+	}
+	for _, mt := range m.opts.MimeTypes {
+		if prefix, ok := strings.CutSuffix(mt, "*"); ok {
+			if strings.HasPrefix(b.MimeType, prefix) {
+				return true
+			}
+		} else if b.MimeType == mt {
+			return true
+		}
+	}
+	return false
+}
+
+// Enqueue queues a blob referenced by an account for mirroring from its PDS (a base URL, eg "https://pds.example.com"). It returns false if the blob is outside the allowlists, or the queue is full.
+func (m *Mirror) Enqueue(did, pds string, b data.Blob) bool {
+	if !m.allowed(b) {
+		blobsSkipped.WithLabelValues("filtered").Inc()
+		return false
+	}
+	select {
+	case m.jobs <- fetchJob{did: did, pds: pds, blob: b}:
+		return true
+	default:
+		blobsSkipped.WithLabelValues("queue_full").Inc()
+		return false
+	}
+}
+
+// HandleCommit queues the blobs referenced by records created or updated in a commit event, from the PDS the event came from
+func (m *Mirror) HandleCommit(ctx context.Context, pds string, evt *comatproto.SyncSubscribeRepos_Commit) error {
+	if evt.TooBig || len(evt.Blocks) == 0 {
+		return nil
+	}
+
+	var r *repo.Repo
+	for _, op := range evt.Ops {
+		if op.Action != "create" && op.Action != "update" {
+			continue
+		}
+		if r == nil {
+			var err error
+			r, err = repo.ReadRepoFromCar(ctx, bytes.NewReader(evt.Blocks))
+			if err != nil {
+				return fmt.Errorf("reading commit blocks: %w", err)
+			}
+		}
+		_, rec, err := r.GetRecordBytes(ctx, op.Path)
+		if err != nil {
+			return fmt.Errorf("reading record %s: %w", op.Path, err)
+		}
+		obj, err := data.UnmarshalCBOR(*rec)
+		if err != nil {
+			// not every record is valid atproto data, but there's nothing to mirror from those that aren't
+			continue
+		}
+		for _, b := range data.ExtractBlobs(obj) {
+			m.Enqueue(evt.Repo, pds, b)
+		}
+	}
+	return nil
+}
+
+func (m *Mirror) process(ctx context.Context, j fetchJob) {
+	c := cid.Cid(j.blob.Ref)
+	if err := m.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&BlobRef{Did: j.did, Cid: c.String()}).Error; err != nil {
+		log.Error("failed to record blob reference", "err", err, "did", j.did, "cid", c)
+		return
+	}
+
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&Blob{}).Where("cid = ?", c.String()).Count(&count).Error; err != nil {
+		log.Error("failed to look up blob", "err", err, "cid", c)
+		return
+	}
+	if count > 0 {
+		blobsSkipped.WithLabelValues("duplicate").Inc()
+		return
+	}
+
+	m.lk.Lock()
+	if m.inflight[c] {
+		m.lk.Unlock()
+		blobsSkipped.WithLabelValues("duplicate").Inc()
+		return
+	}
+	m.inflight[c] = true
+	m.lk.Unlock()
+	defer func() {
+		m.lk.Lock()
+		delete(m.inflight, c)
+		m.lk.Unlock()
+	}()
+
+	start := time.Now()
+	size, err := m.fetch(ctx, j.did, j.pds, c)
+	if err != nil {
+		log.Warn("failed to mirror blob", "err", err, "did", j.did, "cid", c, "pds", j.pds)
+		blobsFailed.Inc()
+		return
+	}
+	if err := m.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&Blob{Cid: c.String(), MimeType: j.blob.MimeType, Size: size}).Error; err != nil {
+		log.Error("failed to record mirrored blob", "err", err, "cid", c)
+		return
+	}
+	blobsMirrored.Inc()
+	blobBytesMirrored.Add(float64(size))
+	blobFetchDuration.Observe(time.Since(start).Seconds())
+}
+
+// fetch downloads a blob from the account's PDS to a temporary file, checks it against its CID, and writes it to the store
+func (m *Mirror) fetch(ctx context.Context, did, pds string, c cid.Cid) (int64, error) {
+	if c.Prefix().MhType != multihash.SHA2_256 {
+		return 0, fmt.Errorf("unsupported blob CID hash")
+	}
+	dmh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return 0, err
+	}
+
+	u := strings.TrimSuffix(pds, "/") + "/xrpc/com.atproto.sync.getBlob?" + url.Values{"did": {did}, "cid": {c.String()}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := m.opts.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("fetching blob: %s", resp.Status)
+	}
+	if resp.ContentLength > m.opts.MaxSize {
+		return 0, fmt.Errorf("blob too large (%d bytes)", resp.ContentLength)
+	}
+
+	f, err := os.CreateTemp("", "blobmirror-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(resp.Body, m.opts.MaxSize+1))
+	if err != nil {
+		return 0, fmt.Errorf("fetching blob: %w", err)
+	}
+	if size > m.opts.MaxSize {
+		return 0, fmt.Errorf("blob too large (over %d bytes)", m.opts.MaxSize)
+	}
+	if !bytes.Equal(h.Sum(nil), dmh.Digest) {
+		return 0, fmt.Errorf("blob does not match CID")
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := m.store.PutBlob(ctx, c, size, f); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// GetBlob returns a mirrored blob referenced by an account, or ErrBlobNotFound
+func (m *Mirror) GetBlob(ctx context.Context, did string, c cid.Cid) (io.ReadCloser, *Blob, error) {
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&BlobRef{}).Where("did = ? AND cid = ?", did, c.String()).Count(&count).Error; err != nil {
+		return nil, nil, err
+	}
+	if count == 0 {
+		return nil, nil, ErrBlobNotFound
+	}
+
+	var b Blob
+	if err := m.db.WithContext(ctx).First(&b, "cid = ?", c.String()).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrBlobNotFound
+		}
+		return nil, nil, err
+	}
+
+	rc, err := m.store.GetBlob(ctx, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rc, &b, nil
+}
+
+// PurgeAccount deletes an account's blob references, for legal deletion requests, so its blobs are no longer served for it. Blobs no other account references are deleted from the database and the store. Returns the number of references and blobs deleted
+func (m *Mirror) PurgeAccount(ctx context.Context, did string) (int64, int64, error) {
+	var refs int64
+	var orphaned []string
+	if err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var cids []string
+		if err := tx.Model(&BlobRef{}).Where("did = ?", did).Pluck("cid", &cids).Error; err != nil {
+			return err
+		}
+		res := tx.Where("did = ?", did).Delete(&BlobRef{})
+		if res.Error != nil {
+			return fmt.Errorf("deleting blob references: %w", res.Error)
+		}
+		refs = res.RowsAffected
+
+		for _, c := range cids {
+			var count int64
+			if err := tx.Model(&BlobRef{}).Where("cid = ?", c).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			if err := tx.Where("cid = ?", c).Delete(&Blob{}).Error; err != nil {
+				return fmt.Errorf("deleting blob: %w", err)
+			}
+			orphaned = append(orphaned, c)
+		}
+		return nil
+	}); err != nil {
+		return 0, 0, err
+	}
+
+	// the blobs are no longer served once their rows are gone, so a failure here only leaves unreachable objects behind
+	var blobs int64
+	for _, cs := range orphaned {
+		c, err := cid.Decode(cs)
+		if err != nil {
+			return refs, blobs, err
+		}
+		if err := m.store.DeleteBlob(ctx, c); err != nil {
+			return refs, blobs, fmt.Errorf("deleting blob %s from store: %w", cs, err)
+		}
+		blobs++
+	}
+	return refs, blobs, nil
+}
@@ -0,0 +1,230 @@
+package blobmirror
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	bsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/data"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/repo"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	car "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in-memory sqlite databases are per-connection
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	return db
+}
+
+func blobCid(t *testing.T, b []byte) cid.Cid {
+	c, err := cid.NewPrefixV1(cid.Raw, 0x12).Sum(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// postCommit makes a commit event creating a post with images
+func postCommit(t *testing.T, did string, images ...*lexutil.LexBlob) *comatproto.SyncSubscribeRepos_Commit {
+	ctx := context.TODO()
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	r := repo.NewRepo(ctx, did, bs)
+
+	post := &bsky.FeedPost{Text: "look", CreatedAt: time.Now().Format(time.RFC3339), Embed: &bsky.FeedPost_Embed{EmbedImages: &bsky.EmbedImages{}}}
+	for _, img := range images {
+		post.Embed.EmbedImages.Images = append(post.Embed.EmbedImages.Images, &bsky.EmbedImages_Image{Image: img})
+	}
+	_, tid, err := r.CreateRecord(ctx, "app.bsky.feed.post", post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, rev, err := r.Commit(ctx, func(context.Context, string, []byte) ([]byte, error) { return []byte("sig"), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	hb, err := cbor.DumpObject(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := carutil.LdWrite(buf, hb); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range keys {
+		blk, err := bs.Get(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := carutil.LdWrite(buf, k.Bytes(), blk.RawData()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &comatproto.SyncSubscribeRepos_Commit{
+		Repo:   did,
+		Rev:    rev,
+		Commit: lexutil.LexLink(root),
+		Blocks: buf.Bytes(),
+		Ops:    []*comatproto.SyncSubscribeRepos_RepoOp{{Action: "create", Path: "app.bsky.feed.post/" + tid}},
+	}
+}
+
+func TestMirror(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	png := []byte("\x89PNG\r\n\x1a\nnot much of an image")
+	big := bytes.Repeat([]byte("a"), 2000)
+	liar := []byte("claims to be small")
+	blobs := map[string][]byte{}
+	for _, b := range [][]byte{png, big, liar} {
+		blobs[blobCid(t, b).String()] = b
+	}
+
+	var fetches atomic.Int64
+	pds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if r.URL.Path != "/xrpc/com.atproto.sync.getBlob" || r.URL.Query().Get("did") != "did:plc:aaa" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, ok := blobs[r.URL.Query().Get("cid")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if bytes.Equal(b, liar) {
+			// served without a length, and larger than declared
+			w.(http.Flusher).Flush()
+			b = big
+		}
+		w.Write(b)
+	}))
+	defer pds.Close()
+
+	opts := DefaultOptions()
+	opts.MaxSize = 1000
+	opts.MimeTypes = []string{"image/*"}
+	m, err := New(testDB(t), &DiskStore{Dir: t.TempDir()}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Start()
+	defer m.Shutdown()
+
+	img := &lexutil.LexBlob{Ref: lexutil.LexLink(blobCid(t, png)), MimeType: "image/png", Size: int64(len(png))}
+	evt := postCommit(t, "did:plc:aaa",
+		img,
+		&lexutil.LexBlob{Ref: lexutil.LexLink(blobCid(t, big)), MimeType: "image/png", Size: int64(len(big))},
+		&lexutil.LexBlob{Ref: lexutil.LexLink(blobCid(t, liar)), MimeType: "image/jpeg", Size: 10},
+		&lexutil.LexBlob{Ref: lexutil.LexLink(blobCid(t, []byte("clip"))), MimeType: "video/mp4", Size: 4},
+	)
+	assert.NoError(m.HandleCommit(ctx, pds.URL, evt))
+
+	// the same image again, from another account, is recorded but not re-fetched
+	assert.True(m.Enqueue("did:plc:bbb", pds.URL, data.Blob{Ref: data.CIDLink(blobCid(t, png)), MimeType: "image/png", Size: int64(len(png))}))
+
+	var rc io.ReadCloser
+	var b *Blob
+	assert.Eventually(func() bool {
+		rc, b, err = m.GetBlob(ctx, "did:plc:aaa", blobCid(t, png))
+		return err == nil
+	}, time.Second, 5*time.Millisecond)
+	if rc != nil {
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		assert.Equal(png, got)
+		assert.Equal("image/png", b.MimeType)
+		assert.Equal(int64(len(png)), b.Size)
+	}
+
+	assert.Eventually(func() bool {
+		var count int64
+		m.db.Model(&BlobRef{}).Where("cid = ?", blobCid(t, png).String()).Count(&count)
+		return count == 2
+	}, time.Second, 5*time.Millisecond)
+
+	// only the png and the oversized blob which lied about its size were fetched; the large png and the video were filtered
+	assert.Eventually(func() bool { return fetches.Load() == 2 }, time.Second, 5*time.Millisecond)
+	_, _, err = m.GetBlob(ctx, "did:plc:aaa", blobCid(t, liar))
+	assert.ErrorIs(err, ErrBlobNotFound)
+	_, _, err = m.GetBlob(ctx, "did:plc:aaa", blobCid(t, big))
+	assert.ErrorIs(err, ErrBlobNotFound)
+	_, _, err = m.GetBlob(ctx, "did:plc:ccc", blobCid(t, png))
+	assert.ErrorIs(err, ErrBlobNotFound)
+}
+
+func TestPurgeAccount(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	store := &DiskStore{Dir: t.TempDir()}
+	m, err := New(testDB(t), store, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one blob only the purged account references, and one shared with another account
+	own := []byte("only mine")
+	shared := []byte("ours")
+	for _, b := range [][]byte{own, shared} {
+		c := blobCid(t, b)
+		assert.NoError(store.PutBlob(ctx, c, int64(len(b)), bytes.NewReader(b)))
+		assert.NoError(m.db.Create(&Blob{Cid: c.String(), MimeType: "image/png", Size: int64(len(b))}).Error)
+		assert.NoError(m.db.Create(&BlobRef{Did: "did:plc:aaa", Cid: c.String()}).Error)
+	}
+	assert.NoError(m.db.Create(&BlobRef{Did: "did:plc:bbb", Cid: blobCid(t, shared).String()}).Error)
+
+	refs, blobs, err := m.PurgeAccount(ctx, "did:plc:aaa")
+	assert.NoError(err)
+	assert.EqualValues(2, refs)
+	assert.EqualValues(1, blobs)
+
+	for _, b := range [][]byte{own, shared} {
+		_, _, err := m.GetBlob(ctx, "did:plc:aaa", blobCid(t, b))
+		assert.ErrorIs(err, ErrBlobNotFound)
+	}
+	_, err = store.GetBlob(ctx, blobCid(t, own))
+	assert.ErrorIs(err, ErrBlobNotFound)
+
+	rc, _, err := m.GetBlob(ctx, "did:plc:bbb", blobCid(t, shared))
+	if assert.NoError(err) {
+		rc.Close()
+	}
+
+	// nothing left to purge
+	refs, blobs, err = m.PurgeAccount(ctx, "did:plc:aaa")
+	assert.NoError(err)
+	assert.EqualValues(0, refs)
+	assert.EqualValues(0, blobs)
+}
@@ -0,0 +1,126 @@
+package blobmirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/util"
+	"github.com/ipfs/go-cid"
+)
+
+// ErrBlobNotFound is returned when a blob has not been mirrored
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Store holds mirrored blobs. Blobs are keyed by CID alone, so a blob referenced by many accounts (or many times) is stored once.
+type Store interface {
+	PutBlob(ctx context.Context, c cid.Cid, size int64, r io.Reader) error
+	GetBlob(ctx context.Context, c cid.Cid) (io.ReadCloser, error)
+	// DeleteBlob removes a blob. Deleting a blob which isn't stored is not an error
+	DeleteBlob(ctx context.Context, c cid.Cid) error
+}
+
+// DiskStore is a Store keeping blobs in a local directory
+type DiskStore struct {
+	Dir string
+}
+
+// blobs are spread over subdirectories by the end of their CID (the start is the same for every blob), to keep directories small
+func (s *DiskStore) path(c cid.Cid) string {
+	cs := c.String()
+	return filepath.Join(s.Dir, cs[len(cs)-2:], cs)
+}
+
+func (s *DiskStore) PutBlob(ctx context.Context, c cid.Cid, size int64, r io.Reader) error {
+	path := s.path(c)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary file and rename, so readers never see a partial blob
+	f, err := os.CreateTemp(filepath.Dir(path), ".mirror-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("writing blob: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *DiskStore) GetBlob(ctx context.Context, c cid.Cid) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(c))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+func (s *DiskStore) DeleteBlob(ctx context.Context, c cid.Cid) error {
+	if err := os.Remove(s.path(c)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// S3Store is a Store keeping blobs in an S3 (or S3-compatible) bucket, keyed by CID under the client's prefix
+type S3Store struct {
+	util.S3Client
+}
+
+func (s *S3Store) PutBlob(ctx context.Context, c cid.Cid, size int64, r io.Reader) error {
+	if err := s.PutObject(ctx, c.String(), size, r, ""); err != nil {
+		return fmt.Errorf("uploading blob to S3: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Store) GetBlob(ctx context.Context, c cid.Cid) (io.ReadCloser, error) {
+	rc, err := s.GetObject(ctx, c.String())
+	if errors.Is(err, util.ErrS3ObjectNotFound) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching blob from S3: %w", err)
+	}
+	return rc, nil
+}
+
+func (s *S3Store) DeleteBlob(ctx context.Context, c cid.Cid) error {
+	if err := s.DeleteObject(ctx, c.String()); err != nil {
+		return fmt.Errorf("deleting blob from S3: %w", err)
+	}
+	return nil
+}
+
+// ParseStore configures a Store from a URI: either a local directory path, or "s3://<bucket>/<prefix>". S3 configuration and credentials are read from the standard AWS_REGION, AWS_ENDPOINT_URL, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment variables.
+func ParseStore(uri string) (Store, error) {
+	if !strings.HasPrefix(uri, "s3://") {
+		if err := os.MkdirAll(uri, 0755); err != nil {
+			return nil, fmt.Errorf("blob mirror directory: %w", err)
+		}
+		return &DiskStore{Dir: uri}, nil
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 blob mirror URI: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("S3 blob mirror URI must include bucket name: %s", uri)
+	}
+	return &S3Store{
+		S3Client: util.S3ClientFromEnv(u.Host, strings.TrimPrefix(u.Path, "/"), 600*time.Second),
+	}, nil
+}
@@ -0,0 +1,105 @@
+package carstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/bluesky-social/indigo/models"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// BlockLocation is where a block is stored: a shard, and the account the shard belongs to
+type BlockLocation struct {
+	Cid cid.Cid
+	Usr models.Uid
+
+	path   string
+	offset int64
+}
+
+// LocateBlocks finds the given blocks in any account's shards, without reading them. A block stored in several shards (eg, identical records in different accounts) has a location for each, and blocks which aren't stored have none
+func (cs *CarStore) LocateBlocks(ctx context.Context, cids []cid.Cid) ([]BlockLocation, error) {
+	ctx, span := otel.Tracer("carstore").Start(ctx, "LocateBlocks")
+	defer span.End()
+	span.SetAttributes(attribute.Int("cids", len(cids)))
+
+	if len(cids) == 0 {
+		return nil, nil
+	}
+	keys := make([]models.DbCID, len(cids))
+	for i, c := range cids {
+		keys[i] = models.DbCID{CID: c}
+	}
+
+	var rows []struct {
+		Cid    models.DbCID
+		Offset int64
+		Path   string
+		Usr    models.Uid
+	}
+	if err := cs.meta.WithContext(ctx).
+		Table("block_refs").
+		Select("block_refs.cid, block_refs.offset, car_shards.path, car_shards.usr").
+		Joins("JOIN car_shards ON car_shards.id = block_refs.shard").
+		Where("block_refs.cid IN ?", keys).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	out := make([]BlockLocation, len(rows))
+	for i, r := range rows {
+		out[i] = BlockLocation{Cid: r.Cid.CID, Usr: r.Usr, path: r.Path, offset: r.Offset}
+	}
+	return out, nil
+}
+
+// ReadBlocks reads the blocks at the given locations, calling cb with each. Each shard file is opened once, and read in offset order
+func (cs *CarStore) ReadBlocks(ctx context.Context, locs []BlockLocation, cb func(BlockLocation, blockformat.Block) error) error {
+	ctx, span := otel.Tracer("carstore").Start(ctx, "ReadBlocks")
+	defer span.End()
+	span.SetAttributes(attribute.Int("blocks", len(locs)))
+
+	sorted := append([]BlockLocation(nil), locs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].path != sorted[j].path {
+			return sorted[i].path < sorted[j].path
+		}
+		return sorted[i].offset < sorted[j].offset
+	})
+
+	var fi *os.File
+	defer func() {
+		if fi != nil {
+			fi.Close()
+		}
+	}()
+	for _, loc := range sorted {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if fi == nil || fi.Name() != loc.path {
+			if fi != nil {
+				fi.Close()
+			}
+			var err error
+			fi, err = os.Open(loc.path)
+			if err != nil {
+				return err
+			}
+		}
+		blk, err := doBlockRead(fi, loc.Cid, loc.offset)
+		if err != nil {
+			return fmt.Errorf("reading block %s from %s: %w", loc.Cid, loc.path, err)
+		}
+		if err := cb(loc, blk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
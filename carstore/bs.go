@@ -17,6 +17,8 @@ import (
 	"time"
 
 	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/util/dbreplica"
+	"github.com/bluesky-social/indigo/util/logging"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 
@@ -27,7 +29,6 @@ import (
 	cbor "github.com/ipfs/go-ipld-cbor"
 	ipld "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-libipfs/blocks"
-	logging "github.com/ipfs/go-log"
 	car "github.com/ipld/go-car"
 	carutil "github.com/ipld/go-car/util"
 	cbg "github.com/whyrusleeping/cbor-gen"
@@ -46,7 +47,7 @@ var blockGetTotalCounterUsrskip = blockGetTotalCounter.WithLabelValues("true", "
 var blockGetTotalCounterCached = blockGetTotalCounter.WithLabelValues("false", "hit")
 var blockGetTotalCounterNormal = blockGetTotalCounter.WithLabelValues("false", "miss")
 
-var log = logging.Logger("carstore")
+var log = logging.Component("carstore")
 
 const MaxSliceLength = 2 << 20
 
@@ -55,9 +56,14 @@ const BigShardThreshold = 2 << 20
 type CarStore struct {
 	meta    *gorm.DB
 	rootDir string
+	// read replicas of meta, for bulk scans which can tolerate replication lag
+	reads *dbreplica.Set
 
 	lscLk          sync.Mutex
 	lastShardCache map[models.Uid]*CarShard
+
+	// aggregate storage accounting, see storage.go
+	usage *storageTotals
 }
 
 func NewCarStore(meta *gorm.DB, root string) (*CarStore, error) {
@@ -70,18 +76,43 @@ func NewCarStore(meta *gorm.DB, root string) (*CarStore, error) {
 			return nil, err
 		}
 	}
-	if err := meta.AutoMigrate(&CarShard{}, &blockRef{}); err != nil {
+	migrator, err := NewMigrator(meta)
+	if err != nil {
 		return nil, err
 	}
-	if err := meta.AutoMigrate(&staleRef{}); err != nil {
+	if _, err := migrator.Up(context.TODO(), 0); err != nil {
 		return nil, err
 	}
 
-	return &CarStore{
+	cs := &CarStore{
 		meta:           meta,
 		rootDir:        root,
+		reads:          dbreplica.New(meta),
 		lastShardCache: make(map[models.Uid]*CarShard),
-	}, nil
+	}
+	if err := cs.loadStorageTotals(context.TODO()); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// SetReadReplicas spreads bulk metadata scans (compaction targets and repo head listings) across read replicas of the metadata database
+func (cs *CarStore) SetReadReplicas(replicas ...*gorm.DB) {
+	cs.reads = dbreplica.New(cs.meta, replicas...)
+}
+
+// Ping checks that the shard metadata database and the shard directory are usable
+func (cs *CarStore) Ping(ctx context.Context) error {
+	if err := cs.meta.WithContext(ctx).Exec("SELECT 1").Error; err != nil {
+		return fmt.Errorf("carstore database: %w", err)
+	}
+	if err := cs.reads.Ping(ctx); err != nil {
+		return fmt.Errorf("carstore database: %w", err)
+	}
+	if _, err := os.Stat(cs.rootDir); err != nil {
+		return fmt.Errorf("carstore directory: %w", err)
+	}
+	return nil
 }
 
 type UserInfo struct {
@@ -758,14 +789,14 @@ func (cs *CarStore) writeNewShard(ctx context.Context, root cid.Cid, rev string,
 		Rev:       rev,
 	}
 
-	if err := cs.putShard(ctx, &shard, brefs, rmcids, false); err != nil {
+	if err := cs.putShard(ctx, &shard, int64(buf.Len()), brefs, rmcids, false); err != nil {
 		return nil, err
 	}
 
 	return buf.Bytes(), nil
 }
 
-func (cs *CarStore) putShard(ctx context.Context, shard *CarShard, brefs []map[string]any, rmcids map[cid.Cid]bool, nocache bool) error {
+func (cs *CarStore) putShard(ctx context.Context, shard *CarShard, size int64, brefs []map[string]any, rmcids map[cid.Cid]bool, nocache bool) error {
 	ctx, span := otel.Tracer("carstore").Start(ctx, "putShard")
 	defer span.End()
 
@@ -803,11 +834,18 @@ func (cs *CarStore) putShard(ctx context.Context, shard *CarShard, brefs []map[s
 		}
 	}
 
-	err := tx.WithContext(ctx).Commit().Error
+	usage, err := recordShardWrite(tx, shard.Usr, size)
+	if err != nil {
+		return fmt.Errorf("failed to update storage usage: %w", err)
+	}
+
+	err = tx.WithContext(ctx).Commit().Error
 	if err != nil {
 		return fmt.Errorf("failed to commit shard DB transaction: %w", err)
 	}
 
+	cs.usage.shardWritten(usage, size)
+
 	return nil
 }
 
@@ -1022,6 +1060,42 @@ func (cs *CarStore) GetUserRepoRev(ctx context.Context, user models.Uid) (string
 	return lastShard.Rev, nil
 }
 
+// UserRepoHead is the latest commit stored for a user's repo
+type UserRepoHead struct {
+	Root cid.Cid
+	Rev  string
+}
+
+// GetUserRepoHeads looks up the latest commit of many users' repos in a single query, for bulk listings. Users with no stored repo are omitted from the result
+func (cs *CarStore) GetUserRepoHeads(ctx context.Context, users []models.Uid) (map[models.Uid]UserRepoHead, error) {
+	ctx, span := otel.Tracer("carstore").Start(ctx, "GetUserRepoHeads")
+	defer span.End()
+
+	out := make(map[models.Uid]UserRepoHead, len(users))
+	if len(users) == 0 {
+		return out, nil
+	}
+
+	var shards []CarShard
+	db := cs.reads.Read()
+	latest := db.Model(CarShard{}).Select("usr, MAX(seq) AS seq").Where("usr IN ?", users).Group("usr")
+	if err := db.WithContext(ctx).Model(CarShard{}).
+		Select("car_shards.usr, car_shards.root, car_shards.rev").
+		Joins("JOIN (?) AS latest ON car_shards.usr = latest.usr AND car_shards.seq = latest.seq", latest).
+		Find(&shards).Error; err != nil {
+		return nil, err
+	}
+
+	for _, sh := range shards {
+		out[sh.Usr] = UserRepoHead{
+			Root: sh.Root.CID,
+			Rev:  sh.Rev,
+		}
+	}
+
+	return out, nil
+}
+
 type UserStat struct {
 	Seq     int
 	Root    string
@@ -1047,20 +1121,47 @@ func (cs *CarStore) Stat(ctx context.Context, usr models.Uid) ([]UserStat, error
 }
 
 func (cs *CarStore) WipeUserData(ctx context.Context, user models.Uid) error {
+	_, err := cs.wipeUserData(ctx, user)
+	return err
+}
+
+// PurgeUserData deletes all of a user's data, as WipeUserData, along with the stale block references kept for compaction. Returns the number of shards deleted
+func (cs *CarStore) PurgeUserData(ctx context.Context, user models.Uid) (int, error) {
+	n, err := cs.wipeUserData(ctx, user)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := cs.meta.Delete(&staleRef{}, "usr = ?", user).Error; err != nil {
+		return n, fmt.Errorf("failed to delete stale refs: %w", err)
+	}
+
+	if err := cs.resetUserStorage(ctx, user, true); err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
+func (cs *CarStore) wipeUserData(ctx context.Context, user models.Uid) (int, error) {
 	var shards []*CarShard
 	if err := cs.meta.Find(&shards, "usr = ?", user).Error; err != nil {
-		return err
+		return 0, err
 	}
 
 	if err := cs.deleteShards(ctx, shards); err != nil {
 		if !os.IsNotExist(err) {
-			return err
+			return 0, err
 		}
 	}
 
 	cs.removeLastShardCache(user)
 
-	return nil
+	if err := cs.resetUserStorage(ctx, user, false); err != nil {
+		return 0, err
+	}
+
+	return len(shards), nil
 }
 
 func (cs *CarStore) deleteShards(ctx context.Context, shs []*CarShard) error {
@@ -1069,8 +1170,17 @@ func (cs *CarStore) deleteShards(ctx context.Context, shs []*CarShard) error {
 
 	deleteSlice := func(ctx context.Context, subs []*CarShard) error {
 		var ids []uint
+		// shard counts and sizes being deleted, by user, for storage accounting
+		counts := make(map[models.Uid]int64)
+		sizes := make(map[models.Uid]int64)
 		for _, sh := range subs {
 			ids = append(ids, sh.ID)
+			size, err := shardSize(sh)
+			if err != nil {
+				return err
+			}
+			counts[sh.Usr]++
+			sizes[sh.Usr] += size
 		}
 
 		txn := cs.meta.Begin()
@@ -1083,16 +1193,29 @@ func (cs *CarStore) deleteShards(ctx context.Context, shs []*CarShard) error {
 			return err
 		}
 
+		usage := make(map[models.Uid]*userStorage, len(counts))
+		for usr, n := range counts {
+			us, err := recordShardDeletes(txn, usr, n, sizes[usr])
+			if err != nil {
+				return fmt.Errorf("failed to update storage usage: %w", err)
+			}
+			usage[usr] = us
+		}
+
 		if err := txn.Commit().Error; err != nil {
 			return err
 		}
 
+		for usr, us := range usage {
+			cs.usage.shardsDeleted(us, counts[usr], sizes[usr])
+		}
+
 		for _, sh := range subs {
 			if err := cs.deleteShardFile(ctx, sh); err != nil {
 				if !os.IsNotExist(err) {
 					return err
 				}
-				log.Warnw("shard file we tried to delete did not exist", "shard", sh.ID, "path", sh.Path)
+				log.Warn("shard file we tried to delete did not exist", "shard", sh.ID, "path", sh.Path)
 			}
 		}
 
@@ -1218,7 +1341,7 @@ func (cs *CarStore) GetCompactionTargets(ctx context.Context, shardCount int) ([
 	defer span.End()
 
 	var targets []CompactionTarget
-	if err := cs.meta.Raw(`select usr, count(*) as num_shards from car_shards group by usr having count(*) > ? order by num_shards desc`, shardCount).Scan(&targets).Error; err != nil {
+	if err := cs.reads.Read().WithContext(ctx).Raw(`select usr, count(*) as num_shards from car_shards group by usr having count(*) > ? order by num_shards desc`, shardCount).Scan(&targets).Error; err != nil {
 		return nil, err
 	}
 
@@ -1278,7 +1401,7 @@ func shardSize(sh *CarShard) (int64, error) {
 	st, err := os.Stat(sh.Path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			log.Warnw("missing shard, return size of zero", "path", sh.Path, "shard", sh.ID)
+			log.Warn("missing shard, return size of zero", "path", sh.Path, "shard", sh.ID)
 			return 0, nil
 		}
 		return 0, fmt.Errorf("stat %q: %w", sh.Path, err)
@@ -1403,7 +1526,7 @@ func (cs *CarStore) CompactUserShards(ctx context.Context, user models.Uid, skip
 		// still around but we're doing that anyways since compaction isn't a
 		// perfect process
 
-		log.Debugw("repo has dirty dupes", "count", len(dupes), "uid", user, "staleRefs", len(staleRefs), "blockRefs", len(brefs))
+		log.Debug("repo has dirty dupes", "count", len(dupes), "uid", user, "staleRefs", len(staleRefs), "blockRefs", len(brefs))
 
 		//return nil, fmt.Errorf("WIP: not currently handling this case")
 	}
@@ -1618,7 +1741,7 @@ func (cs *CarStore) compactBucket(ctx context.Context, user models.Uid, b *compB
 		}); err != nil {
 			// If we ever fail to iterate a shard file because its
 			// corrupted, just log an error and skip the shard
-			log.Errorw("iterating blocks in shard", "shard", s.ID, "err", err, "uid", user)
+			log.Error("iterating blocks in shard", "shard", s.ID, "err", err, "uid", user)
 		}
 	}
 
@@ -1631,12 +1754,12 @@ func (cs *CarStore) compactBucket(ctx context.Context, user models.Uid, b *compB
 		Rev:       lastsh.Rev,
 	}
 
-	if err := cs.putShard(ctx, &shard, nbrefs, nil, true); err != nil {
+	if err := cs.putShard(ctx, &shard, offset, nbrefs, nil, true); err != nil {
 		// if writing the shard fails, we should also delete the file
 		_ = fi.Close()
 
 		if err2 := os.Remove(fi.Name()); err2 != nil {
-			log.Errorf("failed to remove shard file (%s) after failed db transaction: %w", fi.Name(), err2)
+			log.Error("failed to remove shard file after failed db transaction", "path", fi.Name(), "err", err2)
 		}
 
 		return err
@@ -0,0 +1,22 @@
+package carstore
+
+import (
+	"embed"
+
+	"github.com/bluesky-social/indigo/util/migrate"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// NewMigrator returns the schema migrations for the carstore's metadata database. The first is the schema from before migrations were versioned, and later ones are the SQL files in migrations/
+func NewMigrator(meta *gorm.DB) (*migrate.Migrator, error) {
+	sqlMigrations, err := migrate.LoadSQL(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	baseline := migrate.Models(1, "baseline", &CarShard{}, &blockRef{}, &staleRef{})
+	return migrate.New(meta, "carstore", append(sqlMigrations, baseline)...)
+}
@@ -0,0 +1,14 @@
+-- per-user storage accounting, updated as shards are written and deleted
+CREATE TABLE user_storage (
+	usr BIGINT PRIMARY KEY,
+	shards BIGINT NOT NULL DEFAULT 0,
+	bytes BIGINT NOT NULL DEFAULT 0,
+	sized BOOLEAN NOT NULL DEFAULT TRUE,
+	window_start TIMESTAMP,
+	window_bytes BIGINT NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP
+);
+CREATE INDEX idx_user_storage_bytes ON user_storage (bytes);
+-- shard sizes aren't in the database, so users with existing shards are measured later, by CarStore.BackfillStorageUsage
+INSERT INTO user_storage (usr, shards, bytes, sized, window_bytes)
+  SELECT usr, COUNT(*), 0, FALSE, 0 FROM car_shards GROUP BY usr;
@@ -8,18 +8,22 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/bluesky-social/indigo/api/bsky"
 	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/models"
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/util"
+	blockformat "github.com/ipfs/go-block-format"
 	sqlbs "github.com/ipfs/go-bs-sqlite3"
 	"github.com/ipfs/go-cid"
 	flatfs "github.com/ipfs/go-ds-flatfs"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multihash"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -154,6 +158,53 @@ func TestBasicOperation(t *testing.T) {
 	checkRepo(t, cs, buf, recs)
 }
 
+func TestGetUserRepoHeads(t *testing.T) {
+	ctx := context.TODO()
+
+	cs, cleanup, err := testCarStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	exp := make(map[models.Uid]UserRepoHead)
+	for usr := models.Uid(1); usr <= 3; usr++ {
+		var since *string
+		for i := 0; i < int(usr); i++ {
+			ds, err := cs.NewDeltaSession(ctx, usr, since)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ncid, rev, err := setupRepo(ctx, ds, i%2 == 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ds.CloseWithRoot(ctx, ncid, rev); err != nil {
+				t.Fatal(err)
+			}
+
+			since = &rev
+			exp[usr] = UserRepoHead{Root: ncid, Rev: rev}
+		}
+	}
+
+	heads, err := cs.GetUserRepoHeads(ctx, []models.Uid{1, 3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(heads) != 2 {
+		t.Fatalf("expected heads for 2 users, got %d", len(heads))
+	}
+	for _, usr := range []models.Uid{1, 3} {
+		if heads[usr] != exp[usr] {
+			t.Fatalf("wrong head for user %d: %v != %v", usr, heads[usr], exp[usr])
+		}
+	}
+}
+
 func TestRepeatedCompactions(t *testing.T) {
 	ctx := context.TODO()
 
@@ -581,3 +632,227 @@ func TestDuplicateBlockAcrossShards(t *testing.T) {
 	}
 	checkRepo(t, cs, buf, recs)
 }
+
+func TestLocateAndReadBlocks(t *testing.T) {
+	ctx := context.TODO()
+
+	cs, cleanup, err := testCarStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	var roots []cid.Cid
+	for _, usr := range []models.Uid{1, 2} {
+		ds, err := cs.NewDeltaSession(ctx, usr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ncid, rev, err := setupRepo(ctx, ds, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ds.CloseWithRoot(ctx, ncid, rev); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, ncid)
+	}
+
+	missing, err := cid.NewPrefixV1(cid.DagCBOR, multihash.SHA2_256).Sum([]byte("not stored"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locs, err := cs.LocateBlocks(ctx, append(roots, missing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locs) != 2 {
+		t.Fatalf("expected two locations, got %d", len(locs))
+	}
+	found := make(map[cid.Cid]models.Uid)
+	for _, loc := range locs {
+		found[loc.Cid] = loc.Usr
+	}
+	if found[roots[0]] != 1 || found[roots[1]] != 2 {
+		t.Fatalf("unexpected block locations: %v", found)
+	}
+
+	var read int
+	if err := cs.ReadBlocks(ctx, locs, func(loc BlockLocation, blk blockformat.Block) error {
+		if !blk.Cid().Equals(loc.Cid) {
+			t.Fatalf("read block %s, expected %s", blk.Cid(), loc.Cid)
+		}
+		read++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if read != 2 {
+		t.Fatalf("expected to read two blocks, read %d", read)
+	}
+}
+
+// shardFileUsage sums the sizes of a user's shard files on disk
+func shardFileUsage(t *testing.T, cs *CarStore, user models.Uid) (int64, int64) {
+	t.Helper()
+	ents, err := os.ReadDir(cs.rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n, size int64
+	for _, ent := range ents {
+		if !strings.HasPrefix(ent.Name(), fmt.Sprintf("sh-%d-", user)) {
+			continue
+		}
+		fi, err := ent.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+		size += fi.Size()
+	}
+	return n, size
+}
+
+func TestStorageAccounting(t *testing.T) {
+	ctx := context.TODO()
+
+	cs, cleanup, err := testCarStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	writeRepo := func(user models.Uid, commits int) {
+		ds, err := cs.NewDeltaSession(ctx, user, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		head, rev, err := setupRepo(ctx, ds, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ds.CloseWithRoot(ctx, head, rev); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < commits; i++ {
+			ds, err := cs.NewDeltaSession(ctx, user, &rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr, err := repo.OpenRepo(ctx, ds, head)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := rr.CreateRecord(ctx, "app.bsky.feed.post", &appbsky.FeedPost{
+				Text: fmt.Sprintf("post %d", i),
+			}); err != nil {
+				t.Fatal(err)
+			}
+			kmgr := &util.FakeKeyManager{}
+			head, rev, err = rr.Commit(ctx, kmgr.SignForUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ds.CalcDiff(ctx, nil); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ds.CloseWithRoot(ctx, head, rev); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	checkUser := func(user models.Uid) {
+		t.Helper()
+		us, err := cs.UserStorage(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, size := shardFileUsage(t, cs, user)
+		if us.Shards != n || us.Bytes != size || !us.Sized {
+			t.Fatalf("user %d: expected %d shards of %d bytes, got %+v", user, n, size, us)
+		}
+		if us.GrowthSince == nil {
+			t.Fatalf("user %d: expected a growth window, got %+v", user, us)
+		}
+	}
+	checkTotal := func(users int64) {
+		t.Helper()
+		n1, size1 := shardFileUsage(t, cs, 1)
+		n2, size2 := shardFileUsage(t, cs, 2)
+		su := cs.StorageUsage()
+		if su.Users != users || su.Shards != n1+n2 || su.Bytes != size1+size2 || su.Unmeasured != 0 {
+			t.Fatalf("expected %d users with %d shards of %d bytes, got %+v", users, n1+n2, size1+size2, su)
+		}
+	}
+
+	writeRepo(1, 10)
+	writeRepo(2, 1)
+	checkUser(1)
+	checkUser(2)
+	checkTotal(2)
+
+	if su := cs.StorageUsage(); len(su.Alerts) != 0 {
+		t.Fatalf("expected no alerts, got %v", su.Alerts)
+	}
+	cs.SetStorageAlertThresholds(StorageAlertThresholds{TotalBytes: 1})
+	if su := cs.StorageUsage(); len(su.Alerts) != 1 || su.Alerts[0] != StorageAlertTotalBytes {
+		t.Fatalf("expected total bytes alert, got %v", su.Alerts)
+	}
+	cs.SetStorageAlertThresholds(StorageAlertThresholds{})
+
+	top, err := cs.TopStorageUsers(ctx, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 1 || top[0].Usr != 1 {
+		t.Fatalf("expected user 1 to use the most storage, got %+v", top)
+	}
+	if top[0].GrowthBytes != top[0].Bytes {
+		t.Fatalf("expected all of a new repo's storage to count as growth, got %+v", top[0])
+	}
+
+	// compaction replaces shards, and the accounting follows
+	if _, err := cs.CompactUserShards(ctx, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	checkUser(1)
+	checkTotal(2)
+
+	// users from before storage accounting are measured by the backfill
+	if err := cs.meta.Exec("UPDATE user_storage SET sized = FALSE, bytes = 0 WHERE usr = 2").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.loadStorageTotals(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if su := cs.StorageUsage(); su.Unmeasured != 1 {
+		t.Fatalf("expected an unmeasured user, got %+v", su)
+	}
+	n, err := cs.BackfillStorageUsage(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected to measure one user, measured %d", n)
+	}
+	checkUser(2)
+	checkTotal(2)
+	if n, err := cs.BackfillStorageUsage(ctx, 10); err != nil || n != 0 {
+		t.Fatalf("expected nothing left to backfill, got %d (%v)", n, err)
+	}
+
+	if _, err := cs.PurgeUserData(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	checkTotal(1)
+	us, err := cs.UserStorage(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if us.Shards != 0 || us.Bytes != 0 {
+		t.Fatalf("expected purged user to have no storage, got %+v", us)
+	}
+}
@@ -0,0 +1,473 @@
+package carstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+)
+
+var storageBytesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "carstore_storage_bytes",
+	Help: "Total size of the carstore's shard files",
+})
+
+var storageShardsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "carstore_storage_shards",
+	Help: "Total number of shards in the carstore",
+})
+
+var storageUsersGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "carstore_storage_users",
+	Help: "Number of users with shards in the carstore",
+})
+
+var storageUnmeasuredGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "carstore_storage_unmeasured_users",
+	Help: "Number of users whose shards predate storage accounting and haven't been measured yet",
+})
+
+var storageGrowthGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "carstore_storage_growth_bytes_per_hour",
+	Help: "Change in total shard size over the last hour",
+})
+
+var storageWrittenCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_storage_written_bytes_total",
+	Help: "Bytes of shard files written, including by compaction",
+})
+
+var storageDeletedCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_storage_deleted_bytes_total",
+	Help: "Bytes of shard files deleted, including by compaction",
+})
+
+var storageAlertsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "carstore_storage_alerts_total",
+	Help: "Number of times a storage alert threshold was crossed",
+}, []string{"threshold"})
+
+var storageAlertActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "carstore_storage_alert_active",
+	Help: "Whether an aggregate storage alert threshold is currently exceeded",
+}, []string{"threshold"})
+
+// Names of the storage alert thresholds, as used in logs, metrics, and StorageUsage.Alerts
+const (
+	StorageAlertTotalBytes = "total_bytes"
+	StorageAlertGrowth     = "growth"
+	StorageAlertUserBytes  = "user_bytes"
+	StorageAlertUserShards = "user_shards"
+)
+
+// StorageAlertThresholds are storage levels which are logged, and counted in carstore_storage_alerts_total, when crossed. Zero disables a threshold
+type StorageAlertThresholds struct {
+	// Total size of all shards
+	TotalBytes int64
+	// Growth of the total size over the last hour
+	GrowthBytesPerHour int64
+	// Size of one user's shards
+	UserBytes int64
+	// Number of shards one user has, which compaction should keep down
+	UserShards int64
+}
+
+// userStorage is a user's row in the storage accounting table, updated in the same transactions as their shards
+type userStorage struct {
+	Usr    models.Uid `gorm:"primarykey"`
+	Shards int64
+	Bytes  int64
+	// false for users whose shards predate storage accounting, until BackfillStorageUsage measures them
+	Sized bool
+	// the growth window, restarted daily: Bytes - WindowBytes have been added since WindowStart
+	WindowStart *time.Time
+	WindowBytes int64
+	UpdatedAt   *time.Time
+}
+
+func (userStorage) TableName() string {
+	return "user_storage"
+}
+
+// StorageUsage is the carstore's aggregate storage accounting
+type StorageUsage struct {
+	Users  int64 `json:"users"`
+	Shards int64 `json:"shards"`
+	Bytes  int64 `json:"bytes"`
+	// Users whose shards predate storage accounting and haven't been measured yet. Until they are, only their shards written since are counted in Bytes
+	Unmeasured int64 `json:"unmeasured"`
+	// Change in Bytes per hour, averaged over the last hour and the last day (or since startup, if that was more recent)
+	GrowthPerHour1h  float64 `json:"growth_per_hour_1h"`
+	GrowthPerHour24h float64 `json:"growth_per_hour_24h"`
+	// Aggregate alert thresholds currently exceeded
+	Alerts []string `json:"alerts"`
+}
+
+// UserStorageUsage is one user's storage accounting
+type UserStorageUsage struct {
+	Usr    models.Uid `json:"uid"`
+	Shards int64      `json:"shards"`
+	Bytes  int64      `json:"bytes"`
+	// False until shards written before storage accounting have been measured
+	Sized bool `json:"sized"`
+	// Bytes added (or, if negative, removed by compaction) since GrowthSince, which restarts daily
+	GrowthBytes int64      `json:"growth_bytes"`
+	GrowthSince *time.Time `json:"growth_since,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+func (us *userStorage) usage() UserStorageUsage {
+	return UserStorageUsage{
+		Usr:         us.Usr,
+		Shards:      us.Shards,
+		Bytes:       us.Bytes,
+		Sized:       us.Sized,
+		GrowthBytes: us.Bytes - us.WindowBytes,
+		GrowthSince: us.WindowStart,
+		UpdatedAt:   us.UpdatedAt,
+	}
+}
+
+type storageSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// storageTotals mirrors the sums of the storage accounting table in memory, so the aggregates can be reported without scanning it
+type storageTotals struct {
+	lk         sync.Mutex
+	users      int64
+	shards     int64
+	bytes      int64
+	unmeasured int64
+
+	// total bytes at most once a minute, over the last day, for growth rates
+	samples []storageSample
+
+	thresholds StorageAlertThresholds
+	active     map[string]bool
+}
+
+const (
+	storageSampleInterval = time.Minute
+	storageSampleWindow   = 24 * time.Hour
+)
+
+func (cs *CarStore) loadStorageTotals(ctx context.Context) error {
+	var row struct {
+		Users      int64
+		Shards     int64
+		Bytes      int64
+		Unmeasured int64
+	}
+	if err := cs.meta.WithContext(ctx).Raw(`SELECT
+		CAST(COALESCE(SUM(CASE WHEN shards > 0 THEN 1 ELSE 0 END), 0) AS BIGINT) AS users,
+		CAST(COALESCE(SUM(shards), 0) AS BIGINT) AS shards,
+		CAST(COALESCE(SUM(bytes), 0) AS BIGINT) AS bytes,
+		CAST(COALESCE(SUM(CASE WHEN sized THEN 0 ELSE 1 END), 0) AS BIGINT) AS unmeasured
+		FROM user_storage`).Scan(&row).Error; err != nil {
+		return fmt.Errorf("loading storage usage: %w", err)
+	}
+
+	cs.usage = &storageTotals{active: make(map[string]bool)}
+	cs.usage.add(row.Users, row.Shards, row.Bytes, row.Unmeasured)
+	return nil
+}
+
+// add applies changes to the totals, and updates the metrics and aggregate alerts
+func (t *storageTotals) add(users, shards, bytes, unmeasured int64) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	t.users += users
+	t.shards += shards
+	t.bytes += bytes
+	t.unmeasured += unmeasured
+
+	now := time.Now()
+	if len(t.samples) == 0 || now.Sub(t.samples[len(t.samples)-1].at) >= storageSampleInterval {
+		t.samples = append(t.samples, storageSample{at: now, bytes: t.bytes})
+		drop := 0
+		for drop < len(t.samples)-1 && now.Sub(t.samples[drop].at) > storageSampleWindow {
+			drop++
+		}
+		t.samples = t.samples[drop:]
+	}
+
+	growth := t.growth(now, time.Hour)
+	storageBytesGauge.Set(float64(t.bytes))
+	storageShardsGauge.Set(float64(t.shards))
+	storageUsersGauge.Set(float64(t.users))
+	storageUnmeasuredGauge.Set(float64(t.unmeasured))
+	storageGrowthGauge.Set(growth)
+
+	t.setAlert(StorageAlertTotalBytes, t.thresholds.TotalBytes > 0 && t.bytes >= t.thresholds.TotalBytes, t.bytes)
+	t.setAlert(StorageAlertGrowth, t.thresholds.GrowthBytesPerHour > 0 && growth >= float64(t.thresholds.GrowthBytesPerHour), int64(growth))
+}
+
+// setAlert records whether an aggregate threshold is exceeded, logging and counting it when it starts to be. Called with lk held
+func (t *storageTotals) setAlert(name string, on bool, value int64) {
+	if on == t.active[name] {
+		return
+	}
+	t.active[name] = on
+	if on {
+		storageAlertActive.WithLabelValues(name).Set(1)
+		storageAlertsCounter.WithLabelValues(name).Inc()
+		log.Warn("carstore storage over alert threshold", "threshold", name, "value", value)
+	} else {
+		storageAlertActive.WithLabelValues(name).Set(0)
+		log.Info("carstore storage back under alert threshold", "threshold", name, "value", value)
+	}
+}
+
+// growth returns the change in total bytes per hour, over the given window or as much of it as has been sampled. Called with lk held
+func (t *storageTotals) growth(now time.Time, window time.Duration) float64 {
+	for _, s := range t.samples {
+		if now.Sub(s.at) > window {
+			continue
+		}
+		elapsed := now.Sub(s.at)
+		if elapsed < storageSampleInterval {
+			return 0
+		}
+		return float64(t.bytes-s.bytes) / elapsed.Hours()
+	}
+	return 0
+}
+
+// checkUserAlerts logs and counts a user crossing a per-user threshold
+func (t *storageTotals) checkUserAlerts(usr models.Uid, prevBytes, prevShards int64, us *userStorage) {
+	t.lk.Lock()
+	th := t.thresholds
+	t.lk.Unlock()
+
+	if th.UserBytes > 0 && us.Sized && prevBytes < th.UserBytes && us.Bytes >= th.UserBytes {
+		storageAlertsCounter.WithLabelValues(StorageAlertUserBytes).Inc()
+		log.Warn("carstore user storage over alert threshold", "threshold", StorageAlertUserBytes, "uid", usr, "bytes", us.Bytes)
+	}
+	if th.UserShards > 0 && prevShards < th.UserShards && us.Shards >= th.UserShards {
+		storageAlertsCounter.WithLabelValues(StorageAlertUserShards).Inc()
+		log.Warn("carstore user storage over alert threshold", "threshold", StorageAlertUserShards, "uid", usr, "shards", us.Shards)
+	}
+}
+
+// SetStorageAlertThresholds sets the storage levels which trigger alerts
+func (cs *CarStore) SetStorageAlertThresholds(th StorageAlertThresholds) {
+	cs.usage.lk.Lock()
+	cs.usage.thresholds = th
+	cs.usage.lk.Unlock()
+
+	// re-evaluate the aggregate thresholds
+	cs.usage.add(0, 0, 0, 0)
+}
+
+// StorageUsage returns the carstore's aggregate storage accounting, which is kept in memory
+func (cs *CarStore) StorageUsage() StorageUsage {
+	t := cs.usage
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	now := time.Now()
+	out := StorageUsage{
+		Users:            t.users,
+		Shards:           t.shards,
+		Bytes:            t.bytes,
+		Unmeasured:       t.unmeasured,
+		GrowthPerHour1h:  t.growth(now, time.Hour),
+		GrowthPerHour24h: t.growth(now, 24*time.Hour),
+		Alerts:           []string{},
+	}
+	for name, on := range t.active {
+		if on {
+			out.Alerts = append(out.Alerts, name)
+		}
+	}
+	sort.Strings(out.Alerts)
+	return out
+}
+
+// UserStorage returns a user's storage accounting, with zero values for users without shards
+func (cs *CarStore) UserStorage(ctx context.Context, usr models.Uid) (*UserStorageUsage, error) {
+	var rows []userStorage
+	if err := cs.meta.WithContext(ctx).Where("usr = ?", usr).Limit(1).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return &UserStorageUsage{Usr: usr, Sized: true}, nil
+	}
+	u := rows[0].usage()
+	return &u, nil
+}
+
+// TopStorageUsers returns the users using the most storage, or if byGrowth is set, those whose storage grew the most in their current growth window
+func (cs *CarStore) TopStorageUsers(ctx context.Context, limit int, byGrowth bool) ([]UserStorageUsage, error) {
+	order := "bytes DESC"
+	if byGrowth {
+		order = "bytes - window_bytes DESC"
+	}
+	var rows []userStorage
+	if err := cs.reads.Read().WithContext(ctx).Order(order).Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make([]UserStorageUsage, 0, len(rows))
+	for i := range rows {
+		out = append(out, rows[i].usage())
+	}
+	return out, nil
+}
+
+// recordShardWrite adds a new shard to its user's storage accounting, in the shard's transaction, returning the user's updated row
+func recordShardWrite(tx *gorm.DB, usr models.Uid, size int64) (*userStorage, error) {
+	now := time.Now().UTC()
+	var us userStorage
+	if err := tx.Raw(`INSERT INTO user_storage (usr, shards, bytes, sized, window_start, window_bytes, updated_at)
+		VALUES (?, 1, ?, TRUE, ?, 0, ?)
+		ON CONFLICT (usr) DO UPDATE SET
+			shards = user_storage.shards + 1,
+			bytes = user_storage.bytes + excluded.bytes,
+			window_bytes = CASE WHEN user_storage.window_start IS NULL OR user_storage.window_start < ? THEN user_storage.bytes ELSE user_storage.window_bytes END,
+			window_start = CASE WHEN user_storage.window_start IS NULL OR user_storage.window_start < ? THEN excluded.window_start ELSE user_storage.window_start END,
+			updated_at = excluded.updated_at
+		RETURNING *`, usr, size, now, now, now.Add(-storageSampleWindow), now.Add(-storageSampleWindow)).Scan(&us).Error; err != nil {
+		return nil, err
+	}
+	return &us, nil
+}
+
+// shardWritten updates the totals for a committed shard write
+func (t *storageTotals) shardWritten(us *userStorage, size int64) {
+	var users int64
+	if us.Shards == 1 {
+		users = 1
+	}
+	storageWrittenCounter.Add(float64(size))
+	t.add(users, 1, size, 0)
+	t.checkUserAlerts(us.Usr, us.Bytes-size, us.Shards-1, us)
+}
+
+// recordShardDeletes removes deleted shards from their user's storage accounting, in the deleting transaction
+func recordShardDeletes(tx *gorm.DB, usr models.Uid, shards int64, size int64) (*userStorage, error) {
+	var us userStorage
+	if err := tx.Raw(`UPDATE user_storage SET shards = shards - ?, bytes = bytes - ?, updated_at = ? WHERE usr = ? RETURNING *`,
+		shards, size, time.Now().UTC(), usr).Scan(&us).Error; err != nil {
+		return nil, err
+	}
+	return &us, nil
+}
+
+// shardsDeleted updates the totals for committed shard deletions
+func (t *storageTotals) shardsDeleted(us *userStorage, shards int64, size int64) {
+	var users int64
+	if us.Shards <= 0 && shards > 0 {
+		users = -1
+	}
+	storageDeletedCounter.Add(float64(size))
+	t.add(users, -shards, -size, 0)
+}
+
+// resetUserStorage zeroes the accounting of a user whose shards have all been deleted, or with remove set, drops it
+func (cs *CarStore) resetUserStorage(ctx context.Context, usr models.Uid, remove bool) error {
+	var prev userStorage
+	err := cs.meta.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var rows []userStorage
+		if err := tx.Where("usr = ?", usr).Limit(1).Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		prev = rows[0]
+		if remove {
+			return tx.Delete(&userStorage{}, "usr = ?", usr).Error
+		}
+		return tx.Model(&userStorage{}).Where("usr = ?", usr).Updates(map[string]any{
+			"shards":       0,
+			"bytes":        0,
+			"sized":        true,
+			"window_bytes": 0,
+			"updated_at":   time.Now().UTC(),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reset storage usage: %w", err)
+	}
+
+	var users, unmeasured int64
+	if prev.Shards > 0 {
+		users = -1
+	}
+	if prev.Usr != 0 && !prev.Sized {
+		unmeasured = -1
+	}
+	cs.usage.add(users, -prev.Shards, -prev.Bytes, unmeasured)
+	return nil
+}
+
+// BackfillStorageUsage measures the shard files of up to limit users whose shards predate storage accounting, returning how many it measured. It is meant to be run in the background, repeatedly, until it returns 0. A user's repo written while it is being measured may be slightly miscounted, until compaction rewrites its shards.
+func (cs *CarStore) BackfillStorageUsage(ctx context.Context, limit int) (int, error) {
+	var pending []userStorage
+	if err := cs.meta.WithContext(ctx).Where("sized = ?", false).Order("usr").Limit(limit).Find(&pending).Error; err != nil {
+		return 0, err
+	}
+
+	for _, us := range pending {
+		var shards []CarShard
+		if err := cs.meta.WithContext(ctx).Find(&shards, "usr = ?", us.Usr).Error; err != nil {
+			return 0, err
+		}
+		var size int64
+		for i := range shards {
+			n, err := shardSize(&shards[i])
+			if err != nil {
+				return 0, err
+			}
+			size += n
+		}
+
+		var prev userStorage
+		var updated bool
+		err := cs.meta.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var rows []userStorage
+			if err := tx.Where("usr = ? AND sized = ?", us.Usr, false).Limit(1).Find(&rows).Error; err != nil {
+				return err
+			}
+			if len(rows) == 0 {
+				// wiped, or already measured
+				return nil
+			}
+			prev, updated = rows[0], true
+			return tx.Model(&userStorage{}).Where("usr = ?", us.Usr).Updates(map[string]any{
+				"shards":       len(shards),
+				"bytes":        size,
+				"sized":        true,
+				"window_start": time.Now().UTC(),
+				"window_bytes": size,
+				"updated_at":   time.Now().UTC(),
+			}).Error
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to record storage usage for user %d: %w", us.Usr, err)
+		}
+		if !updated {
+			continue
+		}
+
+		var users int64
+		switch {
+		case prev.Shards <= 0 && len(shards) > 0:
+			users = 1
+		case prev.Shards > 0 && len(shards) == 0:
+			users = -1
+		}
+		cs.usage.add(users, int64(len(shards))-prev.Shards, size-prev.Bytes, -1)
+	}
+	return len(pending), nil
+}
@@ -0,0 +1,1094 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	libbgs "github.com/bluesky-social/indigo/bgs"
+	"github.com/bluesky-social/indigo/events"
+
+	"github.com/urfave/cli/v2"
+)
+
+var adminCmd = &cli.Command{
+	Name:  "admin",
+	Usage: "administer a running relay through its admin API (authenticated with --admin-key)",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "relay-url",
+			Usage:   "base URL of the relay's API",
+			EnvVars: []string{"RELAY_ADMIN_URL"},
+			Value:   "http://localhost:2470",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print responses as JSON, instead of tables",
+		},
+	},
+	Subcommands: []*cli.Command{
+		adminUpstreamsCmd,
+		adminHostsCmd,
+		adminDomainsCmd,
+		adminRepoCmd,
+		adminCompactionCmd,
+		adminStorageCmd,
+		adminConsumersCmd,
+		adminAuditCmd,
+		adminEventsCmd,
+		adminCheckpointCmd,
+	},
+}
+
+// actor and reason flags, recorded in the relay's audit log
+var adminActionFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "reason",
+		Usage: "reason for the action, for the audit log",
+	},
+	&cli.StringFlag{
+		Name:    "actor",
+		Usage:   "who is performing the action, for the audit log",
+		EnvVars: []string{"USER"},
+	},
+}
+
+type adminClient struct {
+	url    string
+	key    string
+	client *http.Client
+}
+
+func newAdminClient(cctx *cli.Context) (*adminClient, error) {
+	key := cctx.String("admin-key")
+	if key == "" {
+		return nil, fmt.Errorf("--admin-key (or RELAY_ADMIN_KEY) is required")
+	}
+	return &adminClient{
+		url:    strings.TrimSuffix(cctx.String("relay-url"), "/"),
+		key:    key,
+		client: &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// do makes an admin API request with a raw body (if not nil), returning the response if it has an OK status. The caller must close the response body.
+func (c *adminClient) do(ctx context.Context, method, path string, params url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := c.url + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.key)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var e struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		if json.NewDecoder(bytes.NewReader(msg)).Decode(&e) == nil && (e.Message != "" || e.Error != "") {
+			return nil, fmt.Errorf("%s %s: %d: %s", method, path, resp.StatusCode, strings.TrimSpace(e.Error+" "+e.Message))
+		}
+		return nil, fmt.Errorf("%s %s: %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// call makes an admin API request, with body (if not nil) sent as JSON, and decodes the response into out (if not nil)
+func (c *adminClient) call(ctx context.Context, method, path string, params url.Values, body, out any) error {
+	var rb io.Reader
+	var contentType string
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rb = bytes.NewReader(b)
+		contentType = "application/json"
+	}
+
+	resp, err := c.do(ctx, method, path, params, rb, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// printOutput prints v as JSON with --json, and otherwise as a table written by table
+func printOutput(cctx *cli.Context, v any, table func(w io.Writer)) error {
+	if cctx.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// printResult prints the response to an action, which is a bare success flag for most admin routes
+func printResult(cctx *cli.Context, res map[string]any) error {
+	return printOutput(cctx, res, func(w io.Writer) {
+		keys := make([]string, 0, len(res))
+		for k := range res {
+			if k != "success" {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			fmt.Fprintln(w, "ok")
+			return
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s\t%v\n", k, res[k])
+		}
+	})
+}
+
+func requireArg(cctx *cli.Context, name string) (string, error) {
+	arg := cctx.Args().First()
+	if arg == "" {
+		return "", fmt.Errorf("must pass a %s", name)
+	}
+	return arg, nil
+}
+
+// adminAction returns an action posting to an admin API route which takes a single DID or host as its argument
+func adminAction(path, param string, withParams func(cctx *cli.Context, params url.Values)) cli.ActionFunc {
+	return func(cctx *cli.Context) error {
+		arg, err := requireArg(cctx, param)
+		if err != nil {
+			return err
+		}
+		c, err := newAdminClient(cctx)
+		if err != nil {
+			return err
+		}
+		params := url.Values{param: {arg}}
+		if withParams != nil {
+			withParams(cctx, params)
+		}
+		var res map[string]any
+		if err := c.call(cctx.Context, "POST", path, params, nil, &res); err != nil {
+			return err
+		}
+		return printResult(cctx, res)
+	}
+}
+
+// adminAuditedAction returns an action applying an audited account or host action (see bgs.handleAdminAccountAction)
+func adminAuditedAction(path, subject string) cli.ActionFunc {
+	return func(cctx *cli.Context) error {
+		arg, err := requireArg(cctx, subject)
+		if err != nil {
+			return err
+		}
+		c, err := newAdminClient(cctx)
+		if err != nil {
+			return err
+		}
+		body := map[string]string{
+			subject:  arg,
+			"reason": cctx.String("reason"),
+			"actor":  cctx.String("actor"),
+		}
+		var res map[string]any
+		if err := c.call(cctx.Context, "POST", path, nil, body, &res); err != nil {
+			return err
+		}
+		return printResult(cctx, res)
+	}
+}
+
+var adminUpstreamsCmd = &cli.Command{
+	Name:  "upstreams",
+	Usage: "list the hosts the relay is currently subscribed to",
+	Action: func(cctx *cli.Context) error {
+		c, err := newAdminClient(cctx)
+		if err != nil {
+			return err
+		}
+		var hosts []string
+		if err := c.call(cctx.Context, "GET", "/admin/subs/getUpstreamConns", nil, nil, &hosts); err != nil {
+			return err
+		}
+		return printOutput(cctx, hosts, func(w io.Writer) {
+			for _, h := range hosts {
+				fmt.Fprintln(w, h)
+			}
+		})
+	},
+}
+
+var adminHostsCmd = &cli.Command{
+	Name:  "hosts",
+	Usage: "inspect and manage upstream PDS hosts",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "list",
+			Usage: "list known hosts, with their connection state and event rates",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "limit",
+					Usage: "hosts to fetch per request",
+					Value: 500,
+				},
+			},
+			Action: func(cctx *cli.Context) error {
+				c, err := newAdminClient(cctx)
+				if err != nil {
+					return err
+				}
+				var hosts []libbgs.HostInfo
+				params := url.Values{"limit": {strconv.Itoa(cctx.Int("limit"))}}
+				for {
+					var page struct {
+						Hosts  []libbgs.HostInfo `json:"hosts"`
+						Cursor string            `json:"cursor"`
+					}
+					if err := c.call(cctx.Context, "GET", "/admin/pds/hosts", params, nil, &page); err != nil {
+						return err
+					}
+					hosts = append(hosts, page.Hosts...)
+					if page.Cursor == "" {
+						break
+					}
+					params.Set("cursor", page.Cursor)
+				}
+				return printOutput(cctx, hosts, func(w io.Writer) {
+					fmt.Fprintln(w, "ID\tHOST\tSTATUS\tTRUST\tACCOUNTS\tEVENTS/S\tERRORS/S\tCURSOR\tLAST EVENT")
+					for _, h := range hosts {
+						fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%.1f\t%.1f\t%d\t%s\n", h.ID, h.Host, h.Status, h.TrustLevel, h.Accounts, h.EventsPerSecond, h.ErrorsPerSecond, h.Cursor, formatTime(h.LastEventAt))
+					}
+				})
+			},
+		},
+		{
+			Name:      "get",
+			Usage:     "show one host in detail",
+			ArgsUsage: "<host>",
+			Action: func(cctx *cli.Context) error {
+				host, err := requireArg(cctx, "host")
+				if err != nil {
+					return err
+				}
+				c, err := newAdminClient(cctx)
+				if err != nil {
+					return err
+				}
+				var hi libbgs.HostInfo
+				if err := c.call(cctx.Context, "GET", "/admin/pds/host", url.Values{"host": {host}}, nil, &hi); err != nil {
+					return err
+				}
+				return printOutput(cctx, hi, func(w io.Writer) {
+					fmt.Fprintf(w, "host\t%s\n", hi.Host)
+					fmt.Fprintf(w, "status\t%s\n", hi.Status)
+					fmt.Fprintf(w, "trust\t%s\n", hi.TrustLevel)
+					fmt.Fprintf(w, "paused\t%t\n", hi.Paused)
+					fmt.Fprintf(w, "blocked\t%t\n", hi.Blocked)
+					fmt.Fprintf(w, "banned\t%t\n", hi.Banned)
+					fmt.Fprintf(w, "accounts\t%d (limit %d)\n", hi.Accounts, hi.RepoLimit)
+					fmt.Fprintf(w, "cursor\t%d\n", hi.Cursor)
+					fmt.Fprintf(w, "connected\t%s\n", formatTime(hi.ConnectedAt))
+					fmt.Fprintf(w, "last event\t%s\n", formatTime(hi.LastEventAt))
+					fmt.Fprintf(w, "events\t%d (%.1f/s)\n", hi.TotalEvents, hi.EventsPerSecond)
+					fmt.Fprintf(w, "errors\t%d (%.1f/s, rate %.3f)\n", hi.TotalErrors, hi.ErrorsPerSecond, hi.ErrorRate)
+					if hi.LastError != "" {
+						fmt.Fprintf(w, "last error\t%s (%s)\n", hi.LastError, formatTime(hi.LastErrorAt))
+					}
+					fmt.Fprintf(w, "stale reconnects\t%d (flapping: %t)\n", hi.StaleReconnects, hi.Flapping)
+				})
+			},
+		},
+		{
+			Name:      "pause",
+			Usage:     "disconnect from a host and stop reconnecting, keeping its cursor",
+			ArgsUsage: "<host>",
+			Action:    adminAction("/admin/pds/pause", "host", nil),
+		},
+		{
+			Name:      "resume",
+			Usage:     "reconnect to a paused host",
+			ArgsUsage: "<host>",
+			Action:    adminAction("/admin/pds/resume", "host", nil),
+		},
+		{
+			Name:      "kick",
+			Usage:     "drop the relay's subscription to a host",
+			ArgsUsage: "<host>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "block",
+					Usage: "also block the host, so the relay doesn't reconnect",
+				},
+			},
+			Action: adminAction("/admin/subs/killUpstream", "host", func(cctx *cli.Context, params url.Values) {
+				if cctx.Bool("block") {
+					params.Set("block", "true")
+				}
+			}),
+		},
+		{
+			Name:      "block",
+			Usage:     "refuse connections to a host",
+			ArgsUsage: "<host>",
+			Action:    adminAction("/admin/pds/block", "host", nil),
+		},
+		{
+			Name:      "unblock",
+			Usage:     "allow connections to a blocked host again",
+			ArgsUsage: "<host>",
+			Action:    adminAction("/admin/pds/unblock", "host", nil),
+		},
+		{
+			Name:      "takedown",
+			Usage:     "block a host and take down every account on it",
+			ArgsUsage: "<host>",
+			Flags:     adminActionFlags,
+			Action:    adminAuditedAction("/admin/pds/takeDown", "host"),
+		},
+		{
+			Name:      "resync",
+			Usage:     "start a resync of every repo on a host",
+			ArgsUsage: "<host>",
+			Action:    adminAction("/admin/pds/resync", "host", nil),
+		},
+		{
+			Name:      "resync-status",
+			Usage:     "show the progress of a host resync",
+			ArgsUsage: "<host>",
+			Action: func(cctx *cli.Context) error {
+				host, err := requireArg(cctx, "host")
+				if err != nil {
+					return err
+				}
+				c, err := newAdminClient(cctx)
+				if err != nil {
+					return err
+				}
+				var res struct {
+					Resync libbgs.PDSResync `json:"resync"`
+				}
+				if err := c.call(cctx.Context, "GET", "/admin/pds/resync", url.Values{"host": {host}}, nil, &res); err != nil {
+					return err
+				}
+				r := res.Resync
+				return printOutput(cctx, r, func(w io.Writer) {
+					fmt.Fprintf(w, "status\t%s\n", r.Status)
+					fmt.Fprintf(w, "repos\t%d checked, %d resynced, of %d\n", r.NumReposChecked, r.NumReposToResync, r.NumRepos)
+					fmt.Fprintf(w, "status changed\t%s\n", formatTime(&r.StatusChangedAt))
+				})
+			},
+		},
+	},
+}
+
+var adminDomainsCmd = &cli.Command{
+	Name:  "domains",
+	Usage: "manage banned host domains",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "list",
+			Usage: "list banned domains",
+			Action: func(cctx *cli.Context) error {
+				c, err := newAdminClient(cctx)
+				if err != nil {
+					return err
+				}
+				var res struct {
+					BannedDomains []string `json:"banned_domains"`
+				}
+				if err := c.call(cctx.Context, "GET", "/admin/subs/listDomainBans", nil, nil, &res); err != nil {
+					return err
+				}
+				return printOutput(cctx, res.BannedDomains, func(w io.Writer) {
+					for _, d := range res.BannedDomains {
+						fmt.Fprintln(w, d)
+					}
+				})
+			},
+		},
+		{
+			Name:      "ban",
+			Usage:     "ban a domain (or a wildcard like *.example.com), dropping connections to hosts under it",
+			ArgsUsage: "<domain>",
+			Action:    adminDomainAction("/admin/subs/banDomain"),
+		},
+		{
+			Name:      "unban",
+			Usage:     "lift a domain ban",
+			ArgsUsage: "<domain>",
+			Action:    adminDomainAction("/admin/subs/unbanDomain"),
+		},
+	},
+}
+
+func adminDomainAction(path string) cli.ActionFunc {
+	return func(cctx *cli.Context) error {
+		domain, err := requireArg(cctx, "domain")
+		if err != nil {
+			return err
+		}
+		c, err := newAdminClient(cctx)
+		if err != nil {
+			return err
+		}
+		var res map[string]any
+		if err := c.call(cctx.Context, "POST", path, nil, map[string]string{"Domain": domain}, &res); err != nil {
+			return err
+		}
+		return printResult(cctx, res)
+	}
+}
+
+var adminRepoCmd = &cli.Command{
+	Name:  "repo",
+	Usage: "moderate and repair individual accounts",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "takedown",
+			Usage:     "take down an account, deleting its repo data",
+			ArgsUsage: "<did>",
+			Flags:     adminActionFlags,
+			Action:    adminAuditedAction("/admin/repo/takeDown", "did"),
+		},
+		{
+			Name:      "suspend",
+			Usage:     "stop serving an account without deleting its data",
+			ArgsUsage: "<did>",
+			Flags:     adminActionFlags,
+			Action:    adminAuditedAction("/admin/repo/suspend", "did"),
+		},
+		{
+			Name:      "reinstate",
+			Usage:     "reverse a takedown or suspension",
+			ArgsUsage: "<did>",
+			Flags:     adminActionFlags,
+			Action:    adminAuditedAction("/admin/repo/reinstate", "did"),
+		},
+		{
+			Name:      "resync",
+			Usage:     "re-fetch an account's repo from its PDS, and reconcile it with the relay's copy",
+			ArgsUsage: "<did>",
+			Action:    adminAction("/admin/repo/resync", "did", nil),
+		},
+		{
+			Name:      "verify",
+			Usage:     "check the relay's copy of an account's repo",
+			ArgsUsage: "<did>",
+			Action:    adminAction("/admin/repo/verify", "did", nil),
+		},
+		{
+			Name:  "rate-limited",
+			Usage: "list accounts which have gone over their commit rate limit",
+			Action: func(cctx *cli.Context) error {
+				c, err := newAdminClient(cctx)
+				if err != nil {
+					return err
+				}
+				var accounts []libbgs.RateLimitedAccount
+				if err := c.call(cctx.Context, "GET", "/admin/repo/rateLimited", nil, nil, &accounts); err != nil {
+					return err
+				}
+				return printOutput(cctx, accounts, func(w io.Writer) {
+					fmt.Fprintln(w, "DID\tEXCEEDED\tLAST EXCEEDED")
+					for _, a := range accounts {
+						fmt.Fprintf(w, "%s\t%d\t%s\n", a.Did, a.Exceeded, formatTime(&a.LastExceeded))
+					}
+				})
+			},
+		},
+		{
+			Name:      "identity-history",
+			Usage:     "list the handles, PDS hosts, and signing keys an account has been seen with, newest first",
+			ArgsUsage: "<did>",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "limit",
+					Value: 100,
+				},
+			},
+			Action: func(cctx *cli.Context) error {
+				did, err := requireArg(cctx, "did")
+				if err != nil {
+					return err
+				}
+				c, err := newAdminClient(cctx)
+				if err != nil {
+					return err
+				}
+				params := url.Values{"did": {did}, "limit": {strconv.Itoa(cctx.Int("limit"))}}
+				var res struct {
+					Changes []libbgs.IdentityChange `json:"changes"`
+				}
+				if err := c.call(cctx.Context, "GET", "/admin/repo/identityHistory", params, nil, &res); err != nil {
+					return err
+				}
+				return printOutput(cctx, res.Changes, func(w io.Writer) {
+					fmt.Fprintln(w, "TIME\tCHANGED\tHANDLE\tPDS\tSIGNING KEY")
+					for _, ch := range res.Changes {
+						fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", formatTime(&ch.CreatedAt), ch.Changed, ch.Handle, ch.PDS, ch.SigningKey)
+					}
+				})
+			},
+		},
+	},
+}
+
+var adminCompactionCmd = &cli.Command{
+	Name:  "compaction",
+	Usage: "control carstore compaction",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "repo",
+			Usage:     "compact one account's repo now",
+			ArgsUsage: "<did>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "fast",
+					Usage: "skip large shards",
+				},
+				&cli.StringFlag{
+					Name:  "priority",
+					Usage: "queue the repo for the compactor at this priority (urgent, normal, or background), instead of compacting it now",
+				},
+			},
+			Action: adminAction("/admin/repo/compact", "did", func(cctx *cli.Context, params url.Values) {
+				params.Set("fast", strconv.FormatBool(cctx.Bool("fast")))
+				if p := cctx.String("priority"); p != "" {
+					params.Set("priority", p)
+				}
+			}),
+		},
+		{
+			Name:  "all",
+			Usage: "queue the repos with the most shards for compaction",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "fast",
+					Usage: "skip large shards",
+				},
+				&cli.IntFlag{
+					Name:  "limit",
+					Usage: "most repos to queue",
+					Value: 50,
+				},
+				&cli.IntFlag{
+					Name:  "threshold",
+					Usage: "only queue repos with at least this many shards",
+					Value: 20,
+				},
+				&cli.StringFlag{
+					Name:  "priority",
+					Usage: "queue tier: urgent, normal, or background",
+					Value: "normal",
+				},
+			},
+			Action: func(cctx *cli.Context) error {
+				c, err := newAdminClient(cctx)
+				if err != nil {
+					return err
+				}
+				params := url.Values{
+					"fast":      {strconv.FormatBool(cctx.Bool("fast"))},
+					"limit":     {strconv.Itoa(cctx.Int("limit"))},
+					"threshold": {strconv.Itoa(cctx.Int("threshold"))},
+					"priority":  {cctx.String("priority")},
+				}
+				var res map[string]any
+				if err := c.call(cctx.Context, "POST", "/admin/repo/compactAll", params, nil, &res); err != nil {
+					return err
+				}
+				return printResult(cctx, res)
+			},
+		},
+		{
+			Name:  "queue",
+			Usage: "show the compaction backlog",
+			Action: func(cctx *cli.Context) error {
+				c, err := newAdminClient(cctx)
+				if err != nil {
+					return err
+				}
+				var qs libbgs.CompactionQueueStatus
+				if err := c.call(cctx.Context, "GET", "/admin/repo/compactionQueue", nil, nil, &qs); err != nil {
+					return err
+				}
+				return printOutput(cctx, qs, func(w io.Writer) {
+					fmt.Fprintf(w, "queued\t%d\n", qs.Queued)
+					for _, p := range []string{"urgent", "normal", "background"} {
+						fmt.Fprintf(w, "  %s\t%d (weight %d)\n", p, qs.QueuedByPriority[p], qs.PriorityWeights[p])
+					}
+					fmt.Fprintf(w, "workers\t%d\n", qs.Workers)
+					for i, ws := range qs.State.Workers {
+						if ws.UID == 0 {
+							fmt.Fprintf(w, "  %d\t%s\n", i, ws.Status)
+							continue
+						}
+						fmt.Fprintf(w, "  %d\t%s %s (uid %d) since %s\n", i, ws.Status, ws.DID, ws.UID, ws.Since.Format(time.RFC3339))
+					}
+					fmt.Fprintf(w, "requeue interval\t%s\n", qs.RequeueInterval)
+					st := qs.State
+					fmt.Fprintf(w, "compacted\t%d (%d failed)\n", st.Compacted, st.Failed)
+					fmt.Fprintf(w, "shards\t%d deleted, %d new, %d skipped\n", st.ShardsDeleted, st.NewShards, st.SkippedShards)
+					fmt.Fprintf(w, "refs\t%d (%d dupes)\n", st.Refs, st.Dupes)
+				})
+			},
+		},
+		{
+			Name:  "history",
+			Usage: "list recent compactions, newest first",
+			Flags: []cli.Flag{
+				&cli.DurationFlag{
+					Name:  "since",
+					Usage: "only compactions started within this long ago",
+					Value: time.Hour,
+				},
+				&cli.StringFlag{
+					Name:  "did",
+					Usage: "only compactions of this account's repo, with its totals",
+				},
+				&cli.IntFlag{
+					Name:  "limit",
+					Usage: "most compactions to list, 0 for all those kept",
+					Value: 100,
+				},
+			},
+			Action: func(cctx *cli.Context) error {
+				c, err := newAdminClient(cctx)
+				if err != nil {
+					return err
+				}
+				params := url.Values{
+					"since": {cctx.Duration("since").String()},
+					"limit": {strconv.Itoa(cctx.Int("limit"))},
+				}
+				if did := cctx.String("did"); did != "" {
+					params.Set("did", did)
+				}
+				var res libbgs.CompactionHistory
+				if err := c.call(cctx.Context, "GET", "/admin/repo/compactionHistory", params, nil, &res); err != nil {
+					return err
+				}
+				return printOutput(cctx, res, func(w io.Writer) {
+					if us := res.User; us != nil {
+						fmt.Fprintf(w, "%s (uid %d)\t%d compactions (%d failed), last %s\n", us.DID, us.UID, us.Compactions, us.Failures, formatTime(&us.LastCompacted))
+						fmt.Fprintf(w, "  shards\t%d deleted, %d new, %d skipped\n", us.ShardsDeleted, us.NewShards, us.SkippedShards)
+						fmt.Fprintf(w, "  refs\t%d (%d dupes)\n", us.Refs, us.Dupes)
+					}
+					fmt.Fprintln(w, "STARTED\tDID\tPRIORITY\tWORKER\tDURATION\tSTATUS\tSHARDS DELETED\tNEW SHARDS")
+					for _, rec := range res.Records {
+						worker := strconv.Itoa(rec.Worker)
+						if rec.Worker < 0 {
+							worker = "-"
+						}
+						status := rec.Status
+						deleted, added := "-", "-"
+						if rec.Stats != nil {
+							deleted, added = strconv.Itoa(rec.Stats.ShardsDeleted), strconv.Itoa(rec.Stats.NewShards)
+						} else if rec.Error != "" {
+							status += ": " + rec.Error
+						}
+						fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", formatTime(&rec.Started), rec.DID, rec.Priority, worker, time.Duration(rec.DurationMs)*time.Millisecond, status, deleted, added)
+					}
+				})
+			},
+		},
+	},
+}
+
+var adminStorageCmd = &cli.Command{
+	Name:  "storage",
+	Usage: "show carstore storage usage, and the repos using the most",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "did",
+			Usage: "also show this account's repo",
+		},
+		&cli.IntFlag{
+			Name:  "limit",
+			Usage: "number of repos to list",
+			Value: 20,
+		},
+		&cli.StringFlag{
+			Name:  "sort",
+			Usage: "list the repos using the most storage (bytes), or which grew the most today (growth)",
+			Value: "bytes",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		c, err := newAdminClient(cctx)
+		if err != nil {
+			return err
+		}
+		params := url.Values{
+			"limit": {strconv.Itoa(cctx.Int("limit"))},
+			"sort":  {cctx.String("sort")},
+		}
+		if did := cctx.String("did"); did != "" {
+			params.Set("did", did)
+		}
+		var res libbgs.StorageReport
+		if err := c.call(cctx.Context, "GET", "/admin/storage", params, nil, &res); err != nil {
+			return err
+		}
+		return printOutput(cctx, res, func(w io.Writer) {
+			su := res.Usage
+			fmt.Fprintf(w, "bytes\t%d\n", su.Bytes)
+			fmt.Fprintf(w, "shards\t%d\n", su.Shards)
+			fmt.Fprintf(w, "repos\t%d (%d not yet measured)\n", su.Users, su.Unmeasured)
+			fmt.Fprintf(w, "growth\t%.0f bytes/hour over the last hour, %.0f over the last day\n", su.GrowthPerHour1h, su.GrowthPerHour24h)
+			if len(su.Alerts) > 0 {
+				fmt.Fprintf(w, "alerts\t%s\n", strings.Join(su.Alerts, ", "))
+			}
+			if res.Repo != nil {
+				fmt.Fprintf(w, "%s\t%d bytes in %d shards, %d bytes growth since %s\n", res.Repo.DID, res.Repo.Bytes, res.Repo.Shards, res.Repo.GrowthBytes, formatTime(res.Repo.GrowthSince))
+			}
+			fmt.Fprintln(w, "DID\tUID\tBYTES\tSHARDS\tGROWTH\tSINCE")
+			for _, rs := range res.Top {
+				fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", rs.DID, rs.Usr, rs.Bytes, rs.Shards, rs.GrowthBytes, formatTime(rs.GrowthSince))
+			}
+		})
+	},
+}
+
+var adminConsumersCmd = &cli.Command{
+	Name:  "consumers",
+	Usage: "list connected firehose consumers",
+	Action: func(cctx *cli.Context) error {
+		c, err := newAdminClient(cctx)
+		if err != nil {
+			return err
+		}
+		var consumers []struct {
+			ID             uint64    `json:"id"`
+			RemoteAddr     string    `json:"remote_addr"`
+			UserAgent      string    `json:"user_agent"`
+			EventsConsumed uint64    `json:"events_consumed"`
+			ConnectedAt    time.Time `json:"connected_at"`
+		}
+		if err := c.call(cctx.Context, "GET", "/admin/consumers/list", nil, nil, &consumers); err != nil {
+			return err
+		}
+		return printOutput(cctx, consumers, func(w io.Writer) {
+			fmt.Fprintln(w, "ID\tREMOTE ADDR\tUSER AGENT\tEVENTS\tCONNECTED")
+			for _, c := range consumers {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", c.ID, c.RemoteAddr, c.UserAgent, c.EventsConsumed, formatTime(&c.ConnectedAt))
+			}
+		})
+	},
+}
+
+var adminCheckpointCmd = &cli.Command{
+	Name:  "checkpoint",
+	Usage: "take a checkpoint of the relay's event sequence and upstream cursors, for failing over to a standby",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "save",
+			Usage: "write the checkpoint to this file or S3 object ('s3://<bucket>/<key>'), as read by --restore-checkpoint, instead of printing it",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		c, err := newAdminClient(cctx)
+		if err != nil {
+			return err
+		}
+		var cp libbgs.Checkpoint
+		if err := c.call(cctx.Context, "GET", "/admin/replication/checkpoint", nil, nil, &cp); err != nil {
+			return err
+		}
+		if uri := cctx.String("save"); uri != "" {
+			store, err := libbgs.ParseCheckpointStore(uri)
+			if err != nil {
+				return err
+			}
+			if err := store.PutCheckpoint(cctx.Context, &cp); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "saved checkpoint at seq %d (%d hosts) to %s\n", cp.LastSeq, len(cp.Hosts), uri)
+			return nil
+		}
+		return printOutput(cctx, cp, func(w io.Writer) {
+			fmt.Fprintf(w, "LAST SEQ\t%d\nCREATED\t%s\n\n", cp.LastSeq, formatTime(&cp.CreatedAt))
+			fmt.Fprintln(w, "HOST\tCURSOR\tREGISTERED\tBLOCKED")
+			for _, h := range cp.Hosts {
+				fmt.Fprintf(w, "%s\t%d\t%t\t%t\n", h.Host, h.Cursor, h.Registered, h.Blocked)
+			}
+		})
+	},
+}
+
+var adminAuditCmd = &cli.Command{
+	Name:  "audit",
+	Usage: "list recent admin actions, newest first",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "subject",
+			Usage: "only actions on this DID or host",
+		},
+		&cli.IntFlag{
+			Name:  "limit",
+			Value: 100,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		c, err := newAdminClient(cctx)
+		if err != nil {
+			return err
+		}
+		params := url.Values{"limit": {strconv.Itoa(cctx.Int("limit"))}}
+		if s := cctx.String("subject"); s != "" {
+			params.Set("subject", s)
+		}
+		var res struct {
+			Actions []libbgs.AdminAction `json:"actions"`
+		}
+		if err := c.call(cctx.Context, "GET", "/admin/audit/list", params, nil, &res); err != nil {
+			return err
+		}
+		return printOutput(cctx, res.Actions, func(w io.Writer) {
+			fmt.Fprintln(w, "TIME\tACTION\tSUBJECT\tACTOR\tREASON")
+			for _, a := range res.Actions {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", formatTime(&a.CreatedAt), a.Action, a.Subject, a.Actor, a.Reason)
+			}
+		})
+	},
+}
+
+var adminEventsCmd = &cli.Command{
+	Name:  "events",
+	Usage: "export, import, and inspect firehose segments (self-describing files of persisted events)",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "export",
+			Usage: "export a range of persisted events as a firehose segment",
+			Flags: []cli.Flag{
+				&cli.Int64Flag{
+					Name:  "since",
+					Usage: "export events after this sequence number",
+				},
+				&cli.Int64Flag{
+					Name:  "until",
+					Usage: "export events up to and including this sequence number (default: all)",
+				},
+				&cli.StringFlag{
+					Name:     "output",
+					Aliases:  []string{"o"},
+					Usage:    "file to write the segment to",
+					Required: true,
+				},
+			},
+			Action: func(cctx *cli.Context) error {
+				c, err := newAdminClient(cctx)
+				if err != nil {
+					return err
+				}
+				// large ranges can take a while to stream
+				c.client.Timeout = 0
+
+				params := url.Values{"since": {strconv.FormatInt(cctx.Int64("since"), 10)}}
+				if until := cctx.Int64("until"); until > 0 {
+					params.Set("until", strconv.FormatInt(until, 10))
+				}
+				resp, err := c.do(cctx.Context, "GET", "/admin/events/segment", params, nil, "")
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+
+				fi, err := os.Create(cctx.String("output"))
+				if err != nil {
+					return err
+				}
+				defer fi.Close()
+
+				// copy the events one at a time, which counts them and checks the segment wasn't cut short
+				sr, err := events.NewSegmentReader(resp.Body)
+				if err != nil {
+					return err
+				}
+				sw, err := events.NewSegmentWriter(fi, sr.Header)
+				if err != nil {
+					return err
+				}
+				for {
+					frame, err := sr.NextFrame()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					if err != nil {
+						return fmt.Errorf("export cut short after %d events: %w", sw.Count(), err)
+					}
+					if err := sw.WriteFrame(frame); err != nil {
+						return err
+					}
+				}
+				if err := sw.Flush(); err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "exported %d events to %s\n", sw.Count(), cctx.String("output"))
+				return fi.Close()
+			},
+		},
+		{
+			Name:      "import",
+			Usage:     "add the events in a firehose segment to the relay's event stream, renumbered after its current events",
+			ArgsUsage: "<file>",
+			Action: func(cctx *cli.Context) error {
+				path, err := requireArg(cctx, "file")
+				if err != nil {
+					return err
+				}
+				c, err := newAdminClient(cctx)
+				if err != nil {
+					return err
+				}
+				c.client.Timeout = 0
+
+				fi, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				defer fi.Close()
+
+				resp, err := c.do(cctx.Context, "POST", "/admin/events/import", nil, fi, "application/octet-stream")
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+				var res struct {
+					Imported int `json:"imported"`
+				}
+				if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+					return fmt.Errorf("decoding response: %w", err)
+				}
+				return printOutput(cctx, res, func(w io.Writer) {
+					fmt.Fprintf(w, "imported\t%d\n", res.Imported)
+				})
+			},
+		},
+		{
+			Name:      "inspect",
+			Usage:     "print a firehose segment's header and a summary of each event, offline (no relay needed)",
+			ArgsUsage: "<file>",
+			Action: func(cctx *cli.Context) error {
+				path, err := requireArg(cctx, "file")
+				if err != nil {
+					return err
+				}
+				fi, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				defer fi.Close()
+
+				sr, err := events.NewSegmentReader(fi)
+				if err != nil {
+					return err
+				}
+				if cctx.Bool("json") {
+					// one JSON object per line: the header, then each event
+					enc := json.NewEncoder(os.Stdout)
+					if err := enc.Encode(sr.Header); err != nil {
+						return err
+					}
+					for {
+						evt, err := sr.Next()
+						if errors.Is(err, io.EOF) {
+							return nil
+						}
+						if err != nil {
+							return err
+						}
+						if err := enc.Encode(evt); err != nil {
+							return err
+						}
+					}
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintf(w, "host\t%s\n", sr.Header.Host)
+				fmt.Fprintf(w, "range\t%d-%d\n", sr.Header.Since, sr.Header.Until)
+				fmt.Fprintf(w, "created\t%s\n", formatTime(&sr.Header.CreatedAt))
+				fmt.Fprintln(w, "\nSEQ\tTYPE\tDID\tTIME")
+				for {
+					evt, err := sr.Next()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					if err != nil {
+						return err
+					}
+					typ, did, seq, ts := eventSummary(evt)
+					fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", seq, typ, did, ts)
+				}
+				return w.Flush()
+			},
+		},
+	},
+}
+
+// eventSummary returns an event's type, account, sequence number, and timestamp
+func eventSummary(evt *events.XRPCStreamEvent) (string, string, int64, string) {
+	switch {
+	case evt.RepoCommit != nil:
+		return "commit", evt.RepoCommit.Repo, evt.RepoCommit.Seq, evt.RepoCommit.Time
+	case evt.RepoSync != nil:
+		return "sync", evt.RepoSync.Did, evt.RepoSync.Seq, evt.RepoSync.Time
+	case evt.RepoIdentity != nil:
+		return "identity", evt.RepoIdentity.Did, evt.RepoIdentity.Seq, evt.RepoIdentity.Time
+	case evt.RepoAccount != nil:
+		return "account", evt.RepoAccount.Did, evt.RepoAccount.Seq, evt.RepoAccount.Time
+	case evt.RepoHandle != nil:
+		return "handle", evt.RepoHandle.Did, evt.RepoHandle.Seq, evt.RepoHandle.Time
+	case evt.RepoMigrate != nil:
+		return "migrate", evt.RepoMigrate.Did, evt.RepoMigrate.Seq, evt.RepoMigrate.Time
+	case evt.RepoTombstone != nil:
+		return "tombstone", evt.RepoTombstone.Did, evt.RepoTombstone.Seq, evt.RepoTombstone.Time
+	case evt.RepoInfo != nil:
+		return "info", "", 0, ""
+	case evt.Error != nil:
+		return "error", "", 0, ""
+	}
+	return "unknown", "", 0, ""
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
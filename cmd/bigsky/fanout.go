@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bluesky-social/indigo/bgs/fanout"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/util/cliutil"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"github.com/urfave/cli/v2"
+)
+
+var fanoutCmd = &cli.Command{
+	Name:  "fanout",
+	Usage: "run the front-end for a sharded relay, merging the shards' firehoses into one",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "shard-hosts",
+			Usage:   "shard relay hosts (host:port), in shard index order. With --event-bus, only used to route requests, and may be left out",
+			EnvVars: []string{"RELAY_SHARD_HOSTS"},
+		},
+		&cli.StringFlag{
+			Name:    "event-bus-group",
+			Usage:   "redis consumer group to read the event bus as; each group sees every event",
+			EnvVars: []string{"RELAY_EVENT_BUS_GROUP"},
+			Value:   "fanout",
+		},
+	},
+	Action: runFanout,
+}
+
+// setupEventBus connects to a redis event bus. Subscriptions read as the given consumer group, if any
+func setupEventBus(redisURL, stream, group string) (*events.RedisBus, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event bus URL: %w", err)
+	}
+	client := redis.NewClient(opt)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("connecting to event bus: %w", err)
+	}
+
+	opts := events.DefaultRedisBusOptions()
+	opts.Group = group
+	return events.NewRedisBus(client, stream, opts), nil
+}
+
+func runFanout(cctx *cli.Context) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	dpd := cctx.String("disk-persister-dir")
+	if dpd == "" {
+		return fmt.Errorf("the fanout requires the disk persister (--disk-persister-dir)")
+	}
+
+	db, err := cliutil.SetupDatabase(cctx.String("db-url"), cctx.Int("max-metadb-connections"))
+	if err != nil {
+		return err
+	}
+
+	dpOpts := events.DefaultDiskPersistOptions()
+	dpOpts.MaintenanceInterval = cctx.Duration("disk-persister-maintenance-interval")
+	dp, err := events.NewDiskPersistence(dpd, "", db, dpOpts)
+	if err != nil {
+		return fmt.Errorf("setting up disk persister: %w", err)
+	}
+
+	config := &fanout.Config{
+		Shards: cctx.StringSlice("shard-hosts"),
+		SSL:    !cctx.Bool("crawl-insecure-ws"),
+	}
+	if busURL := cctx.String("event-bus"); busURL != "" {
+		config.Bus, err = setupEventBus(busURL, cctx.String("event-bus-stream"), cctx.String("event-bus-group"))
+		if err != nil {
+			return err
+		}
+	} else if len(config.Shards) == 0 {
+		return fmt.Errorf("the fanout requires --shard-hosts or --event-bus")
+	}
+
+	f, err := fanout.NewFanout(db, dp, config)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		http.Handle("/metrics", promhttp.Handler())
+		if err := http.ListenAndServe(cctx.String("metrics-listen"), nil); err != nil {
+			log.Fatalf("failed to start metrics endpoint: %s", err)
+		}
+	}()
+
+	apiErr := make(chan error, 1)
+	go func() {
+		apiErr <- f.Start(cctx.String("api-listen"))
+	}()
+
+	log.Infow("fanout startup complete", "shards", cctx.StringSlice("shard-hosts"))
+	select {
+	case <-signals:
+		log.Info("received shutdown signal")
+	case err := <-apiErr:
+		if err != nil {
+			log.Errorw("error during fanout startup", "err", err)
+		}
+		log.Info("shutting down")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cctx.Duration("shutdown-timeout"))
+	defer cancel()
+	for _, err := range f.Shutdown(ctx) {
+		log.Errorw("error during fanout shutdown", "err", err)
+	}
+
+	log.Info("shutdown complete")
+	return nil
+}
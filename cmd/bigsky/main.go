@@ -13,16 +13,23 @@ import (
 	"time"
 
 	"github.com/bluesky-social/indigo/api"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/labeler"
 	libbgs "github.com/bluesky-social/indigo/bgs"
+	"github.com/bluesky-social/indigo/blobmirror"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/did"
 	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/eventstats"
 	"github.com/bluesky-social/indigo/indexer"
 	"github.com/bluesky-social/indigo/notifs"
 	"github.com/bluesky-social/indigo/plc"
 	"github.com/bluesky-social/indigo/repomgr"
 	"github.com/bluesky-social/indigo/util"
 	"github.com/bluesky-social/indigo/util/cliutil"
+	slogging "github.com/bluesky-social/indigo/util/logging"
+	indigotracing "github.com/bluesky-social/indigo/util/tracing"
 	"github.com/bluesky-social/indigo/xrpc"
 
 	_ "github.com/joho/godotenv/autoload"
@@ -38,6 +45,8 @@ import (
 	"go.opentelemetry.io/otel/sdk/resource"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
 	"gorm.io/plugin/opentelemetry/tracing"
 )
 
@@ -78,9 +87,24 @@ func run(args []string) error {
 			Value:   "sqlite://./data/bigsky/carstore.sqlite",
 			EnvVars: []string{"CARSTORE_DATABASE_URL"},
 		},
+		&cli.StringSliceFlag{
+			Name:    "db-read-replica-urls",
+			Usage:   "database connection strings for read replicas of the BGS database, which take read-only queries that can tolerate replication lag",
+			EnvVars: []string{"DATABASE_READ_REPLICA_URLS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "carstore-db-read-replica-urls",
+			Usage:   "database connection strings for read replicas of the carstore database, which take bulk scans that can tolerate replication lag",
+			EnvVars: []string{"CARSTORE_DATABASE_READ_REPLICA_URLS"},
+		},
 		&cli.BoolFlag{
 			Name: "db-tracing",
 		},
+		&cli.BoolFlag{
+			Name:    "manual-migrations",
+			Usage:   "don't apply database schema migrations on startup; refuse to start until pending migrations are applied with 'bigsky migrate up'",
+			EnvVars: []string{"RELAY_MANUAL_MIGRATIONS"},
+		},
 		&cli.StringFlag{
 			Name:    "data-dir",
 			Usage:   "path of directory for CAR files and other data",
@@ -115,10 +139,42 @@ func run(args []string) error {
 			Name:  "disk-persister-dir",
 			Usage: "set directory for disk persister (implicitly enables disk persister)",
 		},
+		&cli.DurationFlag{
+			Name:    "disk-persister-maintenance-interval",
+			Usage:   "how often the disk persister verifies, compacts, and re-indexes its log files (0 to disable)",
+			Value:   24 * time.Hour,
+			EnvVars: []string{"RELAY_DISK_PERSISTER_MAINTENANCE_INTERVAL"},
+		},
+		&cli.StringFlag{
+			Name:    "event-bus",
+			Usage:   "redis URL of an event bus. The relay publishes its events there instead of persisting and serving them, and 'bigsky fanout' merges them from there instead of from shard firehoses",
+			EnvVars: []string{"RELAY_EVENT_BUS"},
+		},
+		&cli.StringFlag{
+			Name:    "event-bus-stream",
+			Usage:   "name of the redis stream events are published to",
+			EnvVars: []string{"RELAY_EVENT_BUS_STREAM"},
+			Value:   "relay-events",
+		},
 		&cli.StringFlag{
 			Name:    "admin-key",
 			EnvVars: []string{"RELAY_ADMIN_KEY", "BGS_ADMIN_KEY"},
 		},
+		&cli.StringSliceFlag{
+			Name:    "internal-tokens",
+			Usage:   "bearer tokens for trusted internal services, which may fetch blocks in batches from /internal/blocks without an admin token",
+			EnvVars: []string{"RELAY_INTERNAL_TOKENS"},
+		},
+		&cli.StringFlag{
+			Name:    "labeler-did",
+			Usage:   "DID of a labeler service account; if set (with labeler-signing-key), relay takedowns and suspensions are also published as signed labels",
+			EnvVars: []string{"RELAY_LABELER_DID"},
+		},
+		&cli.StringFlag{
+			Name:    "labeler-signing-key",
+			Usage:   "private signing key (multibase) for labeler-did; the public key must be the '#atproto_label' key in the DID document",
+			EnvVars: []string{"RELAY_LABELER_SIGNING_KEY"},
+		},
 		&cli.StringSliceFlag{
 			Name:    "handle-resolver-hosts",
 			EnvVars: []string{"HANDLE_RESOLVER_HOSTS"},
@@ -139,6 +195,44 @@ func run(args []string) error {
 			Value:   4 * time.Hour,
 			Usage:   "interval between compaction runs, set to 0 to disable scheduled compaction",
 		},
+		&cli.IntFlag{
+			Name:    "compaction-workers",
+			EnvVars: []string{"RELAY_COMPACTION_WORKERS"},
+			Value:   2,
+			Usage:   "number of repos to compact concurrently",
+		},
+		&cli.StringSliceFlag{
+			Name:    "compaction-priority-weights",
+			EnvVars: []string{"RELAY_COMPACTION_PRIORITY_WEIGHTS"},
+			Value:   cli.NewStringSlice("urgent=16", "normal=4", "background=1"),
+			Usage:   "relative share of compactions for each queue tier (urgent, normal, and background, which scheduled compaction uses), as tier=weight",
+		},
+		&cli.Int64Flag{
+			Name:    "storage-alert-total-bytes",
+			EnvVars: []string{"RELAY_STORAGE_ALERT_TOTAL_BYTES"},
+			Usage:   "alert when the carstore's shards total this many bytes, 0 to disable",
+		},
+		&cli.Int64Flag{
+			Name:    "storage-alert-growth-bytes-per-hour",
+			EnvVars: []string{"RELAY_STORAGE_ALERT_GROWTH_BYTES_PER_HOUR"},
+			Usage:   "alert when the carstore grows by this many bytes over an hour, 0 to disable",
+		},
+		&cli.Int64Flag{
+			Name:    "storage-alert-repo-bytes",
+			EnvVars: []string{"RELAY_STORAGE_ALERT_REPO_BYTES"},
+			Usage:   "alert when one repo's shards reach this many bytes, 0 to disable",
+		},
+		&cli.Int64Flag{
+			Name:    "storage-alert-repo-shards",
+			EnvVars: []string{"RELAY_STORAGE_ALERT_REPO_SHARDS"},
+			Usage:   "alert when one repo reaches this many shards, 0 to disable",
+		},
+		&cli.IntFlag{
+			Name:    "compaction-history-size",
+			EnvVars: []string{"RELAY_COMPACTION_HISTORY_SIZE"},
+			Value:   1000,
+			Usage:   "number of recent compactions kept for the admin compaction history, set to 0 to disable",
+		},
 		&cli.StringFlag{
 			Name:    "resolve-address",
 			EnvVars: []string{"RESOLVE_ADDRESS"},
@@ -188,12 +282,261 @@ func run(args []string) error {
 			EnvVars: []string{"RELAY_DID_CACHE_SIZE"},
 			Value:   5_000_000,
 		},
+		&cli.DurationFlag{
+			Name:    "request-crawl-interval",
+			Usage:   "minimum interval between accepted requestCrawl calls for any single hostname (lengthened automatically for hosts which fail checks)",
+			EnvVars: []string{"RELAY_REQUEST_CRAWL_INTERVAL"},
+			Value:   10 * time.Second,
+		},
+		&cli.DurationFlag{
+			Name:    "newcomer-probation",
+			Usage:   "how long newly discovered PDS hosts and newly seen accounts are throttled for, eg 48h (0, the default, disables throttling)",
+			EnvVars: []string{"RELAY_NEWCOMER_PROBATION"},
+		},
+		&cli.DurationFlag{
+			Name:    "newcomer-relax-every",
+			Usage:   "newcomer throttle limits double after each interval of this length",
+			EnvVars: []string{"RELAY_NEWCOMER_RELAX_EVERY"},
+			Value:   6 * time.Hour,
+		},
+		&cli.Float64Flag{
+			Name:    "new-host-events-per-second",
+			Usage:   "initial events per second accepted from a newly discovered PDS host (0 for no limit)",
+			EnvVars: []string{"RELAY_NEW_HOST_EVENTS_PER_SECOND"},
+			Value:   10,
+		},
+		&cli.Float64Flag{
+			Name:    "new-host-repos-per-hour",
+			Usage:   "initial number of previously unseen accounts a newly discovered PDS host may introduce per hour (0 for no limit)",
+			EnvVars: []string{"RELAY_NEW_HOST_REPOS_PER_HOUR"},
+			Value:   100,
+		},
+		&cli.Float64Flag{
+			Name:    "new-account-events-per-second",
+			Usage:   "initial events per second accepted for a newly seen account (0 for no limit)",
+			EnvVars: []string{"RELAY_NEW_ACCOUNT_EVENTS_PER_SECOND"},
+			Value:   5,
+		},
+		&cli.Float64Flag{
+			Name:    "account-commits-per-minute",
+			Usage:   "sustained commits per minute accepted for a single account (0 for no limit)",
+			EnvVars: []string{"RELAY_ACCOUNT_COMMITS_PER_MINUTE"},
+			Value:   300,
+		},
+		&cli.IntFlag{
+			Name:    "account-commit-burst",
+			Usage:   "commits a single account may make in a burst over its sustained limit",
+			EnvVars: []string{"RELAY_ACCOUNT_COMMIT_BURST"},
+			Value:   100,
+		},
+		&cli.StringFlag{
+			Name:    "account-rate-limit-action",
+			Usage:   "what to do with commits over an account's limit: 'throttle' to delay them, or 'mark' to only report the account",
+			EnvVars: []string{"RELAY_ACCOUNT_RATE_LIMIT_ACTION"},
+			Value:   libbgs.AccountLimitThrottle,
+		},
+		&cli.DurationFlag{
+			Name:    "handle-revalidate-interval",
+			Usage:   "how often to re-verify account handles which previously verified (0 disables)",
+			EnvVars: []string{"RELAY_HANDLE_REVALIDATE_INTERVAL"},
+			Value:   7 * 24 * time.Hour,
+		},
+		&cli.DurationFlag{
+			Name:    "handle-recheck-invalid-interval",
+			Usage:   "how often to re-check account handles which failed verification (0 disables)",
+			EnvVars: []string{"RELAY_HANDLE_RECHECK_INVALID_INTERVAL"},
+			Value:   time.Hour,
+		},
+		&cli.Float64Flag{
+			Name:    "handle-revalidations-per-second",
+			Usage:   "maximum rate of background handle re-verifications (0 disables)",
+			EnvVars: []string{"RELAY_HANDLE_REVALIDATIONS_PER_SECOND"},
+			Value:   10,
+		},
+		&cli.BoolFlag{
+			Name:    "non-archival",
+			Usage:   "validate and rebroadcast commits without storing repos; replay is limited to the event persister's retention",
+			EnvVars: []string{"RELAY_NON_ARCHIVAL"},
+		},
+		&cli.StringFlag{
+			Name:    "non-archival-sync",
+			Usage:   "in non-archival mode, how to serve sync.getRepo, sync.getRecord, and sync.getBlocks: 'refuse' or 'proxy' to the account's PDS",
+			EnvVars: []string{"RELAY_NON_ARCHIVAL_SYNC"},
+			Value:   libbgs.NonArchivalSyncRefuse,
+		},
+		&cli.StringFlag{
+			Name:    "default-host-trust",
+			Usage:   "trust tier for PDS hosts not assigned one by an admin: 'verified' (skip signature checks), 'default', or 'untrusted' (full commit validation, capped event rates)",
+			EnvVars: []string{"RELAY_DEFAULT_HOST_TRUST"},
+			Value:   libbgs.HostTrustDefault,
+		},
+		&cli.Int64Flag{
+			Name:    "untrusted-host-events-per-second",
+			Usage:   "cap on the per-second event limit of untrusted hosts (0 for no cap)",
+			EnvVars: []string{"RELAY_UNTRUSTED_HOST_EVENTS_PER_SECOND"},
+			Value:   10,
+		},
+		&cli.Int64Flag{
+			Name:    "untrusted-host-events-per-hour",
+			Usage:   "cap on the hourly event limit of untrusted hosts (0 for no cap)",
+			EnvVars: []string{"RELAY_UNTRUSTED_HOST_EVENTS_PER_HOUR"},
+			Value:   500,
+		},
+		&cli.Int64Flag{
+			Name:    "untrusted-host-events-per-day",
+			Usage:   "cap on the daily event limit of untrusted hosts (0 for no cap)",
+			EnvVars: []string{"RELAY_UNTRUSTED_HOST_EVENTS_PER_DAY"},
+			Value:   4_000,
+		},
+		&cli.DurationFlag{
+			Name:    "stale-host-timeout",
+			Usage:   "reconnect to upstream hosts which send no events or heartbeats for this long (0 to disable)",
+			EnvVars: []string{"RELAY_STALE_HOST_TIMEOUT"},
+			Value:   2 * time.Minute,
+		},
+		&cli.IntFlag{
+			Name:    "stale-host-flap-threshold",
+			Usage:   "alert on upstream hosts going stale this many times within an hour",
+			EnvVars: []string{"RELAY_STALE_HOST_FLAP_THRESHOLD"},
+			Value:   3,
+		},
+		&cli.DurationFlag{
+			Name:    "shutdown-timeout",
+			Usage:   "how long to wait on shutdown for in-flight events to be processed before dropping them",
+			EnvVars: []string{"RELAY_SHUTDOWN_TIMEOUT"},
+			Value:   30 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:    "blob-mirror",
+			Usage:   "mirror blobs referenced by commits to this directory, or S3 bucket ('s3://<bucket>/<prefix>', configured from the standard AWS_* environment variables), and serve them from sync.getBlob. Disabled if empty",
+			EnvVars: []string{"RELAY_BLOB_MIRROR"},
+		},
+		&cli.Int64Flag{
+			Name:    "blob-mirror-max-size",
+			Usage:   "largest blob to mirror, in bytes",
+			EnvVars: []string{"RELAY_BLOB_MIRROR_MAX_SIZE"},
+			Value:   100_000_000,
+		},
+		&cli.StringSliceFlag{
+			Name:    "blob-mirror-mime-types",
+			Usage:   "MIME types of blobs to mirror, exactly or by prefix ('image/*'); empty to mirror every type",
+			EnvVars: []string{"RELAY_BLOB_MIRROR_MIME_TYPES"},
+			Value:   cli.NewStringSlice("image/*", "video/*"),
+		},
+		&cli.IntFlag{
+			Name:    "blob-mirror-workers",
+			Usage:   "number of blobs to fetch from PDSs at once",
+			EnvVars: []string{"RELAY_BLOB_MIRROR_WORKERS"},
+			Value:   8,
+		},
+		&cli.StringSliceFlag{
+			Name:    "checkpoint-stores",
+			Usage:   "write checkpoints of the event sequence and upstream cursors to these files or S3 objects ('s3://<bucket>/<key>', configured from the standard AWS_* environment variables), for a standby relay to take over from",
+			EnvVars: []string{"RELAY_CHECKPOINT_STORES"},
+		},
+		&cli.DurationFlag{
+			Name:    "checkpoint-interval",
+			Usage:   "how often checkpoints are written (they are also written on shutdown)",
+			Value:   10 * time.Second,
+			EnvVars: []string{"RELAY_CHECKPOINT_INTERVAL"},
+		},
+		&cli.StringFlag{
+			Name:    "restore-checkpoint",
+			Usage:   "before starting, take over from the relay which wrote the checkpoint in this file or S3 object: resume upstream hosts from its cursors, and number events from past its sequence. Requires the disk persister",
+			EnvVars: []string{"RELAY_RESTORE_CHECKPOINT"},
+		},
+		&cli.Int64Flag{
+			Name:    "restore-seq-margin",
+			Usage:   "how far past the checkpoint's last sequence number to start numbering events, to cover events the old relay sent after the checkpoint",
+			Value:   libbgs.DefaultRestoreSeqMargin,
+			EnvVars: []string{"RELAY_RESTORE_SEQ_MARGIN"},
+		},
+		&cli.BoolFlag{
+			Name:    "event-stats",
+			Usage:   "count upstream events by collection and host, served at /admin/stats/events and as eventstats_* metrics",
+			EnvVars: []string{"RELAY_EVENT_STATS"},
+		},
+		&cli.IntFlag{
+			Name:    "hosting-status-cache-size",
+			Usage:   "number of accounts whose hosting status is kept in memory, for sync.getRepoStatus and bulk status queries",
+			EnvVars: []string{"RELAY_HOSTING_STATUS_CACHE_SIZE"},
+			Value:   1_000_000,
+		},
+		&cli.IntFlag{
+			Name:    "shard-index",
+			Usage:   "index of the shard of accounts (by DID) this relay ingests, from 0",
+			EnvVars: []string{"RELAY_SHARD_INDEX"},
+		},
+		&cli.IntFlag{
+			Name:    "shard-count",
+			Usage:   "number of relays the network is split between (0 or 1 for no sharding)",
+			EnvVars: []string{"RELAY_SHARD_COUNT"},
+		},
+		&cli.StringFlag{
+			Name:    "log-format",
+			Usage:   "log output format for the relay components (bgs, carstore, events): 'text' or 'json'",
+			Value:   "text",
+			EnvVars: []string{"RELAY_LOG_FORMAT"},
+		},
+		&cli.StringFlag{
+			Name:    "log-level",
+			Usage:   "default log level for the relay components",
+			Value:   "info",
+			EnvVars: []string{"RELAY_LOG_LEVEL"},
+		},
+		&cli.StringFlag{
+			Name:    "log-levels",
+			Usage:   "per-component log levels, eg 'bgs=debug,carstore=warn' (can also be changed at runtime, at /admin/log/levels)",
+			EnvVars: []string{"RELAY_LOG_LEVELS"},
+		},
+	}
+
+	app.Commands = []*cli.Command{
+		fanoutCmd,
+		adminCmd,
+		migrateCmd,
 	}
 
 	app.Action = runBigsky
+	cliutil.WithConfigFile(&app, "RELAY_CONFIG", fanoutCmd)
 	return app.Run(os.Args)
 }
 
+// setupReadReplicas connects to the read replicas listed in the given flag
+func setupReadReplicas(cctx *cli.Context, flag string, maxConnections int) ([]*gorm.DB, error) {
+	var replicas []*gorm.DB
+	for i, dburl := range cctx.StringSlice(flag) {
+		log.Infow("setting up read replica", "flag", flag, "index", i)
+		db, err := cliutil.SetupDatabase(dburl, maxConnections)
+		if err != nil {
+			return nil, fmt.Errorf("%s: replica %d: %w", flag, i, err)
+		}
+		if cctx.Bool("db-tracing") {
+			if err := db.Use(tracing.NewPlugin()); err != nil {
+				return nil, err
+			}
+		}
+		replicas = append(replicas, db)
+	}
+	return replicas, nil
+}
+
+func setupLogging(cctx *cli.Context) error {
+	level, err := slogging.ParseLevel(cctx.String("log-level"))
+	if err != nil {
+		return err
+	}
+	levels, err := slogging.ParseLevels(cctx.String("log-levels"))
+	if err != nil {
+		return err
+	}
+	return slogging.Setup(slogging.Config{
+		Format: cctx.String("log-format"),
+		Level:  level,
+		Levels: levels,
+	})
+}
+
 func setupOTEL(cctx *cli.Context) error {
 
 	env := cctx.String("env")
@@ -220,6 +563,7 @@ func setupOTEL(cctx *cli.Context) error {
 		)
 
 		otel.SetTracerProvider(tp)
+		indigotracing.InstallPropagator()
 	}
 
 	// Enable OTLP HTTP exporter
@@ -255,6 +599,7 @@ func setupOTEL(cctx *cli.Context) error {
 			)),
 		)
 		otel.SetTracerProvider(tp)
+		indigotracing.InstallPropagator()
 	}
 
 	return nil
@@ -265,6 +610,10 @@ func runBigsky(cctx *cli.Context) error {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
+	if err := setupLogging(cctx); err != nil {
+		return err
+	}
+
 	// start observability/tracing (OTEL and jaeger)
 	if err := setupOTEL(cctx); err != nil {
 		return err
@@ -291,6 +640,27 @@ func runBigsky(cctx *cli.Context) error {
 		return err
 	}
 
+	if cctx.Bool("manual-migrations") {
+		migrators, err := relayMigrators(db, csdb)
+		if err != nil {
+			return err
+		}
+		for _, mg := range migrators {
+			if err := mg.Check(cctx.Context); err != nil {
+				return fmt.Errorf("%w (apply with 'bigsky migrate up')", err)
+			}
+		}
+	}
+
+	replicas, err := setupReadReplicas(cctx, "db-read-replica-urls", cctx.Int("max-metadb-connections"))
+	if err != nil {
+		return err
+	}
+	csReplicas, err := setupReadReplicas(cctx, "carstore-db-read-replica-urls", cctx.Int("max-carstore-connections"))
+	if err != nil {
+		return err
+	}
+
 	if cctx.Bool("db-tracing") {
 		if err := db.Use(tracing.NewPlugin()); err != nil {
 			return err
@@ -305,6 +675,26 @@ func runBigsky(cctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	cstore.SetReadReplicas(csReplicas...)
+	cstore.SetStorageAlertThresholds(carstore.StorageAlertThresholds{
+		TotalBytes:         cctx.Int64("storage-alert-total-bytes"),
+		GrowthBytesPerHour: cctx.Int64("storage-alert-growth-bytes-per-hour"),
+		UserBytes:          cctx.Int64("storage-alert-repo-bytes"),
+		UserShards:         cctx.Int64("storage-alert-repo-shards"),
+	})
+	// measure the shards written before storage accounting
+	go func() {
+		for {
+			n, err := cstore.BackfillStorageUsage(context.Background(), 1000)
+			if err != nil {
+				log.Errorw("carstore storage usage backfill failed", "err", err)
+				return
+			}
+			if n == 0 {
+				return
+			}
+		}
+	}()
 
 	mr := did.NewMultiResolver()
 
@@ -323,24 +713,52 @@ func runBigsky(cctx *cli.Context) error {
 
 	repoman := repomgr.NewRepoManager(cstore, kmgr)
 
-	var persister events.EventPersistence
-
-	if dpd := cctx.String("disk-persister-dir"); dpd != "" {
+	var evtman *events.EventManager
+	if busURL := cctx.String("event-bus"); busURL != "" {
+		log.Infow("publishing events to event bus", "stream", cctx.String("event-bus-stream"))
+		bus, err := setupEventBus(busURL, cctx.String("event-bus-stream"), "")
+		if err != nil {
+			return err
+		}
+		// events are persisted and served by whatever consumes the bus
+		evtman, err = events.NewEventManagerWithBuses(nil, &events.BusConfig{
+			Ingest: bus,
+			Fanout: events.NewLocalBus(),
+		})
+		if err != nil {
+			return err
+		}
+	} else if dpd := cctx.String("disk-persister-dir"); dpd != "" {
 		log.Infow("setting up disk persister")
-		dp, err := events.NewDiskPersistence(dpd, "", db, events.DefaultDiskPersistOptions())
+		dpOpts := events.DefaultDiskPersistOptions()
+		dpOpts.MaintenanceInterval = cctx.Duration("disk-persister-maintenance-interval")
+		dp, err := events.NewDiskPersistence(dpd, "", db, dpOpts)
 		if err != nil {
 			return fmt.Errorf("setting up disk persister: %w", err)
 		}
-		persister = dp
+		evtman = events.NewEventManager(dp)
 	} else {
 		dbp, err := events.NewDbPersistence(db, cstore, nil)
 		if err != nil {
 			return fmt.Errorf("setting up db event persistence: %w", err)
 		}
-		persister = dbp
+		evtman = events.NewEventManager(dbp)
 	}
 
-	evtman := events.NewEventManager(persister)
+	if uri := cctx.String("restore-checkpoint"); uri != "" {
+		store, err := libbgs.ParseCheckpointStore(uri)
+		if err != nil {
+			return err
+		}
+		cp, err := store.GetCheckpoint(cctx.Context)
+		if err != nil {
+			return fmt.Errorf("reading checkpoint: %w", err)
+		}
+		log.Infow("restoring from checkpoint", "created", cp.CreatedAt, "lastSeq", cp.LastSeq, "hosts", len(cp.Hosts))
+		if err := libbgs.RestoreCheckpoint(cctx.Context, db, evtman, cp, cctx.Int64("restore-seq-margin")); err != nil {
+			return fmt.Errorf("restoring checkpoint: %w", err)
+		}
+	}
 
 	notifman := &notifs.NullNotifs{}
 
@@ -400,9 +818,97 @@ func runBigsky(cctx *cli.Context) error {
 	bgsConfig := libbgs.DefaultBGSConfig()
 	bgsConfig.SSL = !cctx.Bool("crawl-insecure-ws")
 	bgsConfig.CompactInterval = cctx.Duration("compact-interval")
+	bgsConfig.Compactor = libbgs.DefaultCompactorOptions()
+	bgsConfig.Compactor.NumWorkers = cctx.Int("compaction-workers")
+	if bgsConfig.Compactor.NumWorkers < 1 {
+		return fmt.Errorf("--compaction-workers must be at least 1")
+	}
+	bgsConfig.Compactor.HistorySize = cctx.Int("compaction-history-size")
+	weights, err := libbgs.ParseCompactionPriorityWeights(cctx.StringSlice("compaction-priority-weights"))
+	if err != nil {
+		return err
+	}
+	for prio, w := range weights {
+		bgsConfig.Compactor.PriorityWeights[prio] = w
+	}
 	bgsConfig.ConcurrencyPerPDS = cctx.Int64("concurrency-per-pds")
 	bgsConfig.MaxQueuePerPDS = cctx.Int64("max-queue-per-pds")
 	bgsConfig.DefaultRepoLimit = cctx.Int64("default-repo-limit")
+	bgsConfig.RequestCrawlLimit = rate.Every(cctx.Duration("request-crawl-interval"))
+	bgsConfig.NewcomerThrottle = libbgs.NewcomerThrottleConfig{
+		Probation:           cctx.Duration("newcomer-probation"),
+		RelaxEvery:          cctx.Duration("newcomer-relax-every"),
+		HostPerSecond:       cctx.Float64("new-host-events-per-second"),
+		HostNewReposPerHour: cctx.Float64("new-host-repos-per-hour"),
+		AccountPerSecond:    cctx.Float64("new-account-events-per-second"),
+	}
+	bgsConfig.AccountRateLimit = libbgs.AccountRateLimitConfig{
+		CommitsPerMinute: cctx.Float64("account-commits-per-minute"),
+		Burst:            cctx.Int("account-commit-burst"),
+		Action:           cctx.String("account-rate-limit-action"),
+	}
+	bgsConfig.HandleRevalidation = libbgs.HandleRevalidationConfig{
+		ValidInterval:   cctx.Duration("handle-revalidate-interval"),
+		InvalidInterval: cctx.Duration("handle-recheck-invalid-interval"),
+		PerSecond:       cctx.Float64("handle-revalidations-per-second"),
+	}
+	bgsConfig.NonArchival = cctx.Bool("non-archival")
+	bgsConfig.NonArchivalSync = cctx.String("non-archival-sync")
+	bgsConfig.HostTrust = libbgs.HostTrustConfig{
+		Default:            cctx.String("default-host-trust"),
+		UntrustedPerSecond: cctx.Int64("untrusted-host-events-per-second"),
+		UntrustedPerHour:   cctx.Int64("untrusted-host-events-per-hour"),
+		UntrustedPerDay:    cctx.Int64("untrusted-host-events-per-day"),
+	}
+	bgsConfig.StaleHost.Timeout = cctx.Duration("stale-host-timeout")
+	bgsConfig.StaleHost.FlapThreshold = cctx.Int("stale-host-flap-threshold")
+	bgsConfig.HostingStatusCacheSize = cctx.Int("hosting-status-cache-size")
+	bgsConfig.ReadReplicas = replicas
+	if cctx.Bool("event-stats") {
+		bgsConfig.EventStats = eventstats.New(nil)
+	}
+	for _, uri := range cctx.StringSlice("checkpoint-stores") {
+		store, err := libbgs.ParseCheckpointStore(uri)
+		if err != nil {
+			return err
+		}
+		bgsConfig.Checkpoints = append(bgsConfig.Checkpoints, store)
+	}
+	bgsConfig.CheckpointInterval = cctx.Duration("checkpoint-interval")
+	bgsConfig.InternalTokens = cctx.StringSlice("internal-tokens")
+	if didstr := cctx.String("labeler-did"); didstr != "" {
+		did, err := syntax.ParseDID(didstr)
+		if err != nil {
+			return fmt.Errorf("labeler DID supplied was not valid: %w", err)
+		}
+		key, err := crypto.ParsePrivateMultibase(cctx.String("labeler-signing-key"))
+		if err != nil {
+			return fmt.Errorf("parsing labeler signing key: %w", err)
+		}
+		store, err := labeler.NewSQLLabelStore(db)
+		if err != nil {
+			return err
+		}
+		bgsConfig.Labeler = labeler.NewLabeler(store, did, key, nil)
+	}
+	bgsConfig.Shard = libbgs.ShardConfig{
+		Index: cctx.Int("shard-index"),
+		Count: cctx.Int("shard-count"),
+	}
+	if uri := cctx.String("blob-mirror"); uri != "" {
+		store, err := blobmirror.ParseStore(uri)
+		if err != nil {
+			return err
+		}
+		mopts := blobmirror.DefaultOptions()
+		mopts.MaxSize = cctx.Int64("blob-mirror-max-size")
+		mopts.MimeTypes = cctx.StringSlice("blob-mirror-mime-types")
+		mopts.Workers = cctx.Int("blob-mirror-workers")
+		bgsConfig.BlobMirror, err = blobmirror.New(db, store, mopts)
+		if err != nil {
+			return fmt.Errorf("failed to set up blob mirror: %w", err)
+		}
+	}
 	bgs, err := libbgs.NewBGS(db, ix, repoman, evtman, cachedidr, rf, hr, bgsConfig)
 	if err != nil {
 		return err
@@ -432,19 +938,18 @@ func runBigsky(cctx *cli.Context) error {
 	select {
 	case <-signals:
 		log.Info("received shutdown signal")
-		errs := bgs.Shutdown()
-		for err := range errs {
-			log.Errorw("error during BGS shutdown", "err", err)
-		}
 	case err := <-bgsErr:
 		if err != nil {
 			log.Errorw("error during BGS startup", "err", err)
 		}
 		log.Info("shutting down")
-		errs := bgs.Shutdown()
-		for err := range errs {
-			log.Errorw("error during BGS shutdown", "err", err)
-		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cctx.Duration("shutdown-timeout"))
+	defer cancel()
+	errs := bgs.Shutdown(shutdownCtx)
+	for _, err := range errs {
+		log.Errorw("error during BGS shutdown", "err", err)
 	}
 
 	log.Info("shutdown complete")
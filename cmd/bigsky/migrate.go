@@ -0,0 +1,36 @@
+package main
+
+import (
+	libbgs "github.com/bluesky-social/indigo/bgs"
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/util/migrate"
+
+	"github.com/urfave/cli/v2"
+	"gorm.io/gorm"
+)
+
+var migrateCmd = cliutil.MigrateCommand(func(cctx *cli.Context) ([]*migrate.Migrator, error) {
+	db, err := cliutil.SetupDatabase(cctx.String("db-url"), 1)
+	if err != nil {
+		return nil, err
+	}
+	csdb, err := cliutil.SetupDatabase(cctx.String("carstore-db-url"), 1)
+	if err != nil {
+		return nil, err
+	}
+	return relayMigrators(db, csdb)
+})
+
+// relayMigrators returns the migrations for the relay's two databases
+func relayMigrators(db, csdb *gorm.DB) ([]*migrate.Migrator, error) {
+	bgsMigrator, err := libbgs.NewMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	csMigrator, err := carstore.NewMigrator(csdb)
+	if err != nil {
+		return nil, err
+	}
+	return []*migrate.Migrator{bgsMigrator, csMigrator}, nil
+}
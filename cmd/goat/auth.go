@@ -7,9 +7,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"time"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
-	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/xrpc"
 
@@ -18,6 +18,15 @@ import (
 
 var ErrNoAuthSession = errors.New("no auth session found")
 
+// In an interactive shell, the authenticated client is kept between commands, instead of refreshing the session for each one. Access tokens are short-lived, so it's refreshed again after authClientReuse.
+var (
+	keepAuthClient   bool
+	authClient       *xrpc.Client
+	authClientLoaded time.Time
+)
+
+const authClientReuse = 15 * time.Minute
+
 type AuthSession struct {
 	DID          syntax.DID `json:"did"`
 	Password     string     `json:"password"`
@@ -26,6 +35,7 @@ type AuthSession struct {
 }
 
 func persistAuthSession(sess *AuthSession) error {
+	authClient = nil
 
 	fPath, err := xdg.StateFile("goat/auth-session.json")
 	if err != nil {
@@ -50,6 +60,10 @@ func loadAuthClient(ctx context.Context) (*xrpc.Client, error) {
 
 	// TODO: could also load from env var / cctx
 
+	if keepAuthClient && authClient != nil && time.Since(authClientLoaded) < authClientReuse {
+		return authClient, nil
+	}
+
 	fPath, err := xdg.SearchStateFile("goat/auth-session.json")
 	if err != nil {
 		return nil, ErrNoAuthSession
@@ -93,12 +107,15 @@ func loadAuthClient(ctx context.Context) (*xrpc.Client, error) {
 	client.Auth.AccessJwt = resp.AccessJwt
 	client.Auth.RefreshJwt = resp.RefreshJwt
 
+	if keepAuthClient {
+		authClient = &client
+		authClientLoaded = time.Now()
+	}
 	return &client, nil
 }
 
 func refreshAuthSession(ctx context.Context, username syntax.AtIdentifier, password string) (*AuthSession, error) {
-	dir := identity.DefaultDirectory()
-	ident, err := dir.Lookup(ctx, username)
+	ident, err := directory.Lookup(ctx, username)
 	if err != nil {
 		return nil, err
 	}
@@ -135,6 +152,7 @@ func refreshAuthSession(ctx context.Context, username syntax.AtIdentifier, passw
 }
 
 func wipeAuthSession() error {
+	authClient = nil
 
 	fPath, err := xdg.SearchStateFile("goat/auth-session.json")
 	if err != nil {
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+var cmdCompletion = &cli.Command{
+	Name:      "completion",
+	Usage:     "print a shell completion script",
+	ArgsUsage: `<bash|zsh|fish>`,
+	Description: "Prints a script which completes goat commands and flags in the given shell. For example, add this to ~/.bashrc:\n\n" +
+		"   source <(goat completion bash)",
+	Flags:  []cli.Flag{},
+	Action: runCompletion,
+}
+
+// bash and zsh scripts ask goat itself for completions, with the --generate-bash-completion flag (see cli.App.EnableBashCompletion)
+const bashCompletion = `_goat_completion() {
+  local cur opts
+  COMPREPLY=()
+  cur="${COMP_WORDS[COMP_CWORD]}"
+  if [[ "$cur" == "-"* ]]; then
+    opts=$( ${COMP_WORDS[@]:0:$COMP_CWORD} ${cur} --generate-bash-completion )
+  else
+    opts=$( ${COMP_WORDS[@]:0:$COMP_CWORD} --generate-bash-completion )
+  fi
+  COMPREPLY=( $(compgen -W "${opts}" -- ${cur}) )
+  return 0
+}
+
+complete -o bashdefault -o default -o nospace -F _goat_completion goat
+`
+
+const zshCompletion = `#compdef goat
+
+_goat_completion() {
+  local -a opts
+  local cur
+  cur=${words[-1]}
+  if [[ "$cur" == "-"* ]]; then
+    opts=("${(@f)$(${words[@]:0:#words[@]-1} ${cur} --generate-bash-completion)}")
+  else
+    opts=("${(@f)$(${words[@]:0:#words[@]-1} --generate-bash-completion)}")
+  fi
+
+  if [[ "${opts[1]}" != "" ]]; then
+    _describe 'values' opts
+  else
+    _files
+  fi
+}
+
+compdef _goat_completion goat
+`
+
+func runCompletion(cctx *cli.Context) error {
+	switch shell := cctx.Args().First(); shell {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		script, err := cctx.App.ToFishCompletion()
+		if err != nil {
+			return err
+		}
+		fmt.Print(script)
+	case "":
+		return fmt.Errorf("need to provide a shell (bash, zsh, or fish) as an argument")
+	default:
+		return fmt.Errorf("unsupported shell: %s", shell)
+	}
+	return nil
+}
@@ -13,8 +13,10 @@ import (
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/fixture"
 	"github.com/bluesky-social/indigo/events/schedulers/parallel"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/repo"
@@ -53,6 +55,15 @@ var cmdFirehose = &cli.Command{
 			Aliases: []string{"records"},
 			Usage:   "instead of printing entire events, print individual record ops",
 		},
+		&cli.StringFlag{
+			Name:  "record-fixture",
+			Usage: "instead of printing events, record them (and account identities) to a test fixture file at this path",
+		},
+		&cli.IntFlag{
+			Name:  "fixture-count",
+			Usage: "number of events to record with --record-fixture",
+			Value: 1000,
+		},
 	},
 	Action: runFirehose,
 }
@@ -81,6 +92,15 @@ func runFirehose(cctx *cli.Context) error {
 	relayHost := cctx.String("relay-host")
 	cursor := cctx.Int("cursor")
 
+	if path := cctx.String("record-fixture"); path != "" {
+		slog.Info("recording firehose fixture", "relayHost", relayHost, "count", cctx.Int("fixture-count"))
+		f, err := fixture.Record(ctx, relayHost, int64(cursor), cctx.Int("fixture-count"), identity.DefaultDirectory())
+		if err != nil {
+			return err
+		}
+		return f.Save(path)
+	}
+
 	dialer := websocket.DefaultDialer
 	u, err := url.Parse(relayHost)
 	if err != nil {
@@ -23,6 +23,8 @@ func run(args []string) error {
 		Name:    "goat",
 		Usage:   "Go AT protocol CLI tool",
 		Version: versioninfo.Short(),
+		// completion scripts (see 'goat completion') call back into goat with --generate-bash-completion
+		EnableBashCompletion: true,
 	}
 	app.Commands = []*cli.Command{
 		cmdRecordGet,
@@ -37,6 +39,8 @@ func run(args []string) error {
 		cmdRecord,
 		cmdSyntax,
 		cmdCrypto,
+		cmdShell,
+		cmdCompletion,
 	}
 	return app.Run(args)
 }
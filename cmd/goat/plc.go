@@ -11,6 +11,7 @@ import (
 
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/plc"
 
 	"github.com/urfave/cli/v2"
 )
@@ -27,6 +28,7 @@ var cmdPLC = &cli.Command{
 	Subcommands: []*cli.Command{
 		cmdPLCHistory,
 		cmdPLCDump,
+		cmdPLCVerify,
 	},
 }
 
@@ -106,6 +108,52 @@ func runPLCHistory(cctx *cli.Context) error {
 	return nil
 }
 
+var cmdPLCVerify = &cli.Command{
+	Name:      "verify",
+	Usage:     "verify the operation log for individual DID, and print the current state",
+	ArgsUsage: `<at-identifier>`,
+	Flags:     []cli.Flag{},
+	Action:    runPLCVerify,
+}
+
+func runPLCVerify(cctx *cli.Context) error {
+	ctx := context.Background()
+	plcURL := cctx.String("plc-directory")
+	s := cctx.Args().First()
+	if s == "" {
+		return fmt.Errorf("need to provide account identifier as an argument")
+	}
+
+	dir := identity.BaseDirectory{
+		PLCURL: plcURL,
+	}
+
+	id, err := syntax.ParseAtIdentifier(s)
+	if err != nil {
+		return err
+	}
+	ident, err := dir.Lookup(ctx, *id)
+	if err != nil {
+		return err
+	}
+	if ident.DID.Method() != "plc" {
+		return fmt.Errorf("non-PLC DID method: %s", ident.DID.Method())
+	}
+
+	client := plc.Client{Host: plcURL}
+	state, err := client.GetVerifiedState(ctx, ident.DID)
+	if err != nil {
+		return fmt.Errorf("operation log failed verification: %w", err)
+	}
+
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
 var cmdPLCDump = &cli.Command{
 	Name:  "dump",
 	Usage: "output full operation log, as JSON lines",
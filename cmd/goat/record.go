@@ -8,7 +8,6 @@ import (
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/atproto/data"
-	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/xrpc"
@@ -111,8 +110,6 @@ var cmdRecordList = &cli.Command{
 
 func runRecordGet(cctx *cli.Context) error {
 	ctx := context.Background()
-	dir := identity.DefaultDirectory()
-
 	uriArg := cctx.Args().First()
 	if uriArg == "" {
 		return fmt.Errorf("expected a single AT-URI argument")
@@ -122,7 +119,7 @@ func runRecordGet(cctx *cli.Context) error {
 	if err != nil {
 		return fmt.Errorf("not a valid AT-URI: %v", err)
 	}
-	ident, err := dir.Lookup(ctx, aturi.Authority())
+	ident, err := directory.Lookup(ctx, aturi.Authority())
 	if err != nil {
 		return err
 	}
@@ -176,6 +173,7 @@ func runRecordList(cctx *cli.Context) error {
 		collections = []string{filter}
 	}
 
+	resetListedURIs()
 	for _, nsid := range collections {
 		cursor := ""
 		for {
@@ -190,6 +188,7 @@ func runRecordList(cctx *cli.Context) error {
 					return err
 				}
 				fmt.Printf("%s\t%s\t%s\n", aturi.Collection(), aturi.RecordKey(), rec.Cid)
+				rememberURI(aturi.String())
 			}
 			if resp.Cursor != nil && *resp.Cursor != "" {
 				cursor = *resp.Cursor
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+var cmdShell = &cli.Command{
+	Name:   "shell",
+	Usage:  "interactive mode: run goat commands with history, tab completion, and cached identities and login",
+	Flags:  []cli.Flag{},
+	Action: runShell,
+}
+
+// maxListedURIs is how many AT-URIs from the most recent record listings are kept for tab completion
+const maxListedURIs = 10_000
+
+// listedURIs are the AT-URIs of records printed by the most recent 'ls', offered as completions in the shell
+var listedURIs []string
+
+// resetListedURIs forgets the AT-URIs from the previous listing, when a new one starts
+func resetListedURIs() {
+	listedURIs = listedURIs[:0]
+}
+
+// rememberURI adds an AT-URI from the current record listing
+func rememberURI(uri string) {
+	if len(listedURIs) < maxListedURIs {
+		listedURIs = append(listedURIs, uri)
+	}
+}
+
+func runShell(cctx *cli.Context) error {
+	app := cctx.App
+	// errors are printed by the shell, rather than exiting
+	app.ExitErrHandler = func(*cli.Context, error) {}
+	keepAuthClient = true
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		// commands piped in: no prompt or line editing
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if done := shellExec(app, os.Stderr, scanner.Text()); done {
+				return nil
+			}
+		}
+		return scanner.Err()
+	}
+
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "goat> ")
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		return shellComplete(app, t, line, pos)
+	}
+	var history []string
+
+	fmt.Println("goat interactive shell; 'help' lists commands, 'exit' or Ctrl-D quits")
+	for {
+		line, err := shellReadLine(fd, t)
+		if errors.Is(err, io.EOF) {
+			fmt.Println()
+			return nil
+		} else if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		history = append(history, line)
+		if line == "history" {
+			for i, l := range history {
+				fmt.Printf("%5d  %s\n", i+1, l)
+			}
+			continue
+		}
+		if done := shellExec(app, os.Stdout, line); done {
+			return nil
+		}
+	}
+}
+
+// shellReadLine reads one line in raw mode, restoring the terminal afterwards so command output is printed normally
+func shellReadLine(fd int, t *term.Terminal) (string, error) {
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(fd, state)
+	if w, h, err := term.GetSize(fd); err == nil && w > 0 {
+		t.SetSize(w, h)
+	}
+	return t.ReadLine()
+}
+
+// shellExec runs one line of input as a goat command, reporting errors to w. It returns true if the line ends the shell.
+func shellExec(app *cli.App, w io.Writer, line string) bool {
+	args, err := splitShellArgs(line)
+	if err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+		return false
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "#") {
+		return false
+	}
+	switch args[0] {
+	case "exit", "quit":
+		return true
+	case "goat":
+		// allow pasting full command lines
+		args = args[1:]
+	}
+	if len(args) > 0 && args[0] == "shell" {
+		fmt.Fprintln(w, "error: already in the shell")
+		return false
+	}
+	if err := app.Run(append([]string{app.Name}, args...)); err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+	}
+	return false
+}
+
+// splitShellArgs splits a line into arguments at whitespace, with single quotes, double quotes, and backslash escapes like a POSIX shell
+func splitShellArgs(line string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// shellComplete completes the word before the cursor: command and sub-command names, or AT-URIs from the last record listing. If there are several candidates without a longer common prefix, they are printed.
+func shellComplete(app *cli.App, t *term.Terminal, line string, pos int) (string, int, bool) {
+	before := line[:pos]
+	start := strings.LastIndexAny(before, " \t") + 1
+	word := before[start:]
+
+	var candidates []string
+	if !strings.HasPrefix(word, "at:") {
+		// walk the command tree along the preceding words
+		cmds := app.Commands
+		for _, w := range strings.Fields(before[:start]) {
+			if w == "goat" {
+				continue
+			}
+			var next []*cli.Command
+			for _, c := range cmds {
+				if c.HasName(w) {
+					next = c.Subcommands
+					break
+				}
+			}
+			cmds = next
+		}
+		if start == 0 {
+			candidates = append(candidates, "exit", "history")
+		}
+		for _, c := range cmds {
+			if !c.Hidden {
+				candidates = append(candidates, c.Name)
+			}
+		}
+		filtered := candidates[:0]
+		for _, c := range candidates {
+			if strings.HasPrefix(c, word) {
+				filtered = append(filtered, c)
+			}
+		}
+		candidates = filtered
+	}
+	if len(candidates) == 0 && (strings.HasPrefix(word, "at://") || strings.HasPrefix("at://", word)) {
+		seen := make(map[string]bool)
+		for _, uri := range listedURIs {
+			if strings.HasPrefix(uri, word) && !seen[uri] {
+				seen[uri] = true
+				candidates = append(candidates, uri)
+			}
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", 0, false
+	case 1:
+		completion := candidates[0]
+		if !strings.HasPrefix(completion, "at://") {
+			completion += " "
+		}
+		return line[:start] + completion + line[pos:], start + len(completion), true
+	}
+
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(prefix) > len(word) {
+		return line[:start] + prefix + line[pos:], start + len(prefix), true
+	}
+	sort.Strings(candidates)
+	if len(candidates) > 50 {
+		candidates = append(candidates[:50], fmt.Sprintf("(%d more)", len(candidates)-50))
+	}
+	fmt.Fprintln(t, strings.Join(candidates, "  "))
+	return "", 0, false
+}
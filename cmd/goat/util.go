@@ -7,12 +7,14 @@ import (
 	"github.com/bluesky-social/indigo/atproto/syntax"
 )
 
+// directory is shared by all commands, so that in an interactive shell each identity is only resolved once
+var directory = identity.DefaultDirectory()
+
 func resolveIdent(ctx context.Context, arg string) (*identity.Identity, error) {
 	id, err := syntax.ParseAtIdentifier(arg)
 	if err != nil {
 		return nil, err
 	}
 
-	dir := identity.DefaultDirectory()
-	return dir.Lookup(ctx, *id)
+	return directory.Lookup(ctx, *id)
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type adminNameKey struct{}
+
+// parses admin tokens configured as "<name>=<token>", into a map from token to name. empty entries (eg, from a trailing comma in HEPA_ADMIN_TOKENS) are skipped
+func parseAdminTokens(vals []string) (map[string]string, error) {
+	tokens := make(map[string]string, len(vals))
+	for _, v := range vals {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(v, "=")
+		name = strings.TrimSpace(name)
+		token = strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("invalid admin token (expected <name>=<token>)")
+		}
+		if _, dupe := tokens[token]; dupe {
+			return nil, fmt.Errorf("duplicate admin token for %q", name)
+		}
+		tokens[token] = name
+	}
+	return tokens, nil
+}
+
+// wraps an admin endpoint, requiring one of the configured admin tokens as a bearer token. the name the token was configured with is passed on in the request context, see adminName. if no admin tokens are configured, admin endpoints are disabled
+func (s *Server) requireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.adminTokens) == 0 {
+			writeJSON(w, http.StatusForbidden, reviewError{Error: "admin endpoints disabled (no admin tokens configured)"})
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || strings.TrimSpace(token) == "" {
+			writeJSON(w, http.StatusUnauthorized, reviewError{Error: "admin token required"})
+			return
+		}
+		name := ""
+		for tok, n := range s.adminTokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(tok)) == 1 {
+				name = n
+			}
+		}
+		if name == "" {
+			writeJSON(w, http.StatusForbidden, reviewError{Error: "invalid admin token"})
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), adminNameKey{}, name)))
+	}
+}
+
+// returns the name of the admin token a request was authenticated with, by requireAdmin
+func adminName(ctx context.Context) string {
+	name, _ := ctx.Value(adminNameKey{}).(string)
+	return name
+}
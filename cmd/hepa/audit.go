@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bluesky-social/indigo/automod/auditlog"
+)
+
+type auditListResponse struct {
+	Entries []auditlog.AuditEntry `json:"entries"`
+	Cursor  string                `json:"cursor,omitempty"`
+}
+
+// lists rule decision audit log entries, newest first. query params: "subject" (account DID or record AT-URI; optional), "cursor", "limit"
+func (s *Server) HandleAuditList(w http.ResponseWriter, r *http.Request) {
+	if s.engine.AuditLog == nil {
+		writeJSON(w, http.StatusNotFound, reviewError{Error: "audit log not enabled"})
+		return
+	}
+	q := r.URL.Query()
+	var cursor uint64
+	if c := q.Get("cursor"); c != "" {
+		var err error
+		cursor, err = strconv.ParseUint(c, 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, reviewError{Error: "invalid cursor"})
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	entries, err := s.engine.AuditLog.List(r.Context(), q.Get("subject"), uint(cursor), limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, reviewError{Error: err.Error()})
+		return
+	}
+	resp := auditListResponse{Entries: entries}
+	if len(entries) > 0 {
+		resp.Cursor = strconv.FormatUint(uint64(entries[len(entries)-1].ID), 10)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -46,6 +46,8 @@ func (s *Server) RunConsumer(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("subscribing to firehose failed (dialing): %w", err)
 	}
+	s.firehoseConnected.Store(true)
+	defer s.firehoseConnected.Store(false)
 
 	rsc := &events.RepoStreamCallbacks{
 		RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
@@ -17,6 +17,7 @@ import (
 	"github.com/bluesky-social/indigo/atproto/identity/redisdir"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/automod/capture"
+	"github.com/bluesky-social/indigo/util/cliutil"
 
 	"github.com/carlmjohnson/versioninfo"
 	_ "github.com/joho/godotenv/autoload"
@@ -74,6 +75,21 @@ func run(args []string) error {
 			Usage:   "admin authentication password for mod service",
 			EnvVars: []string{"HEPA_OZONE_AUTH_ADMIN_TOKEN", "HEPA_MOD_AUTH_ADMIN_TOKEN"},
 		},
+		&cli.StringFlag{
+			Name:    "ozone-signing-key",
+			Usage:   "private signing key (multibase) for ozone-did, used for service auth to the mod service instead of admin token",
+			EnvVars: []string{"HEPA_OZONE_SIGNING_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "ozone-service-did",
+			Usage:   "service DID of the ozone instance (audience for service auth)",
+			EnvVars: []string{"HEPA_OZONE_SERVICE_DID"},
+		},
+		&cli.BoolFlag{
+			Name:    "ozone-flag-tags",
+			Usage:   "mirror new automod flags to ozone as subject tags",
+			EnvVars: []string{"HEPA_OZONE_FLAG_TAGS"},
+		},
 		&cli.StringFlag{
 			Name:    "atp-pds-host",
 			Usage:   "method, hostname, and port of PDS (or entryway) for admin account info; uses admin auth",
@@ -92,6 +108,11 @@ func run(args []string) error {
 			// redis://localhost:6379/0
 			EnvVars: []string{"HEPA_REDIS_URL"},
 		},
+		&cli.BoolFlag{
+			Name:    "redis-cluster",
+			Usage:   "treat redis-url as a cluster URL (additional nodes as 'addr' query params)",
+			EnvVars: []string{"HEPA_REDIS_CLUSTER"},
+		},
 		&cli.IntFlag{
 			Name:    "plc-rate-limit",
 			Usage:   "max number of requests per second to PLC registry",
@@ -118,11 +139,101 @@ func run(args []string) error {
 			Usage:   "admin auth password for abyss API",
 			EnvVars: []string{"ABYSS_PASSWORD"},
 		},
+		&cli.StringFlag{
+			Name:    "scoring-host",
+			Usage:   "host for external text scoring (classifier) API (scheme, host, port)",
+			EnvVars: []string{"HEPA_SCORING_HOST"},
+		},
+		&cli.StringFlag{
+			Name:    "scoring-token",
+			Usage:   "bearer token for external text scoring API",
+			EnvVars: []string{"HEPA_SCORING_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:    "scoring-name",
+			Usage:   "short name for external text scoring API, used as prefix for score names",
+			Value:   "ext",
+			EnvVars: []string{"HEPA_SCORING_NAME"},
+		},
 		&cli.StringFlag{
 			Name:    "ruleset",
 			Usage:   "which ruleset config to use: default, no-blobs, only-blobs",
 			EnvVars: []string{"HEPA_RULESET"},
 		},
+		&cli.StringFlag{
+			Name:    "rule-config-path",
+			Usage:   "file path of JSON file containing runtime rule config (enabled rules, thresholds, sets)",
+			EnvVars: []string{"HEPA_RULE_CONFIG_PATH"},
+		},
+		&cli.StringFlag{
+			Name:    "rule-config-url",
+			Usage:   "HTTP(S) URL to fetch runtime rule config JSON from (if no file path is configured)",
+			EnvVars: []string{"HEPA_RULE_CONFIG_URL"},
+		},
+		&cli.DurationFlag{
+			Name:    "rule-config-refresh",
+			Usage:   "how often to re-load rule config; zero to only load on startup (or admin request)",
+			Value:   5 * time.Minute,
+			EnvVars: []string{"HEPA_RULE_CONFIG_REFRESH"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "remote-sets",
+			Usage:   "remote sources of keyword/domain/hash sets: HTTP(S) URLs or AT-URIs of records, optionally prefixed with a set name and '=' (eg, 'bad-words=https://example.com/words.txt')",
+			EnvVars: []string{"HEPA_REMOTE_SETS"},
+		},
+		&cli.DurationFlag{
+			Name:    "remote-sets-refresh",
+			Usage:   "how often to re-fetch remote sets",
+			Value:   10 * time.Minute,
+			EnvVars: []string{"HEPA_REMOTE_SETS_REFRESH"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "phash-lists",
+			Usage:   "remote lists of perceptual hashes (hex pHash) of known-bad images, as list name and URL (or AT-URI) separated by '='; uses same source format and refresh interval as remote sets",
+			EnvVars: []string{"HEPA_PHASH_LISTS"},
+		},
+		&cli.IntFlag{
+			Name:    "phash-max-distance",
+			Usage:   "max Hamming distance (out of 64 bits) for a perceptual hash match",
+			Value:   8,
+			EnvVars: []string{"HEPA_PHASH_MAX_DISTANCE"},
+		},
+		&cli.BoolFlag{
+			Name:    "cluster-signals",
+			Usage:   "enable cross-account cluster signals (near-duplicate text, shared links, invite trees) and coordinated-campaign and copypasta rules",
+			EnvVars: []string{"HEPA_CLUSTER_SIGNALS"},
+		},
+		&cli.StringFlag{
+			Name:    "review-db-url",
+			Usage:   "database connection string for the human review queue (sqlite or postgres); review queue is disabled if not set",
+			EnvVars: []string{"HEPA_REVIEW_DB_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "audit-db-url",
+			Usage:   "database connection string for the rule decision audit log (sqlite or postgres); audit log is disabled if not set",
+			EnvVars: []string{"HEPA_AUDIT_DB_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "labeler-db-url",
+			Usage:   "database connection string for publishing labels directly as a labeler service (sqlite or postgres); labeler is disabled if not set",
+			EnvVars: []string{"HEPA_LABELER_DB_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "labeler-did",
+			Usage:   "DID of the labeler service account, used as the source of published labels",
+			EnvVars: []string{"HEPA_LABELER_DID"},
+		},
+		&cli.StringFlag{
+			Name:    "labeler-signing-key",
+			Usage:   "private signing key (multibase) for labeler-did; the public key must be the '#atproto_label' key in the DID document",
+			EnvVars: []string{"HEPA_LABELER_SIGNING_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "labeler-listen",
+			Usage:   "IP or address, and port, to serve the public labeler API (queryLabels and subscribeLabels) on",
+			Value:   ":3990",
+			EnvVars: []string{"HEPA_LABELER_LISTEN"},
+		},
 		&cli.StringFlag{
 			Name:    "log-level",
 			Usage:   "log verbosity level (eg: warn, info, debug)",
@@ -155,7 +266,9 @@ func run(args []string) error {
 		processRecordCmd,
 		processRecentCmd,
 		captureRecentCmd,
+		simulateCmd,
 	}
+	cliutil.WithConfigFile(&app, "HEPA_CONFIG", runCmd)
 
 	return app.Run(args)
 }
@@ -221,6 +334,16 @@ var runCmd = &cli.Command{
 			Usage:   "full URL of slack webhook",
 			EnvVars: []string{"SLACK_WEBHOOK_URL"},
 		},
+		&cli.StringFlag{
+			Name:    "webhook-config-path",
+			Usage:   "JSON file configuring webhook notification targets (Slack, Discord, or generic JSON), with templates, rule subscriptions, and rate limits",
+			EnvVars: []string{"HEPA_WEBHOOK_CONFIG_PATH"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "admin-tokens",
+			Usage:   "bearer tokens for the admin endpoints on the metrics port, each as <name>=<token>; the name is recorded as the reviewer for review queue actions. admin endpoints are disabled if none are set",
+			EnvVars: []string{"HEPA_ADMIN_TOKENS"},
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		ctx := context.Background()
@@ -241,19 +364,40 @@ var runCmd = &cli.Command{
 				OzoneHost:           cctx.String("atp-ozone-host"),
 				OzoneDID:            cctx.String("ozone-did"),
 				OzoneAdminToken:     cctx.String("ozone-admin-token"),
+				OzoneSigningKey:     cctx.String("ozone-signing-key"),
+				OzoneServiceDID:     cctx.String("ozone-service-did"),
+				OzoneFlagTags:       cctx.Bool("ozone-flag-tags"),
 				PDSHost:             cctx.String("atp-pds-host"),
 				PDSAdminToken:       cctx.String("pds-admin-token"),
 				SetsFileJSON:        cctx.String("sets-json-path"),
 				RedisURL:            cctx.String("redis-url"),
+				RedisCluster:        cctx.Bool("redis-cluster"),
 				SlackWebhookURL:     cctx.String("slack-webhook-url"),
+				WebhookConfigPath:   cctx.String("webhook-config-path"),
 				HiveAPIToken:        cctx.String("hiveai-api-token"),
 				AbyssHost:           cctx.String("abyss-host"),
 				AbyssPassword:       cctx.String("abyss-password"),
+				ScoringHost:         cctx.String("scoring-host"),
+				ScoringToken:        cctx.String("scoring-token"),
+				ScoringName:         cctx.String("scoring-name"),
 				RatelimitBypass:     cctx.String("ratelimit-bypass"),
 				RulesetName:         cctx.String("ruleset"),
 				FirehoseParallelism: cctx.Int("firehose-parallelism"),
 				PreScreenHost:       cctx.String("prescreen-host"),
 				PreScreenToken:      cctx.String("prescreen-token"),
+				RuleConfigPath:      cctx.String("rule-config-path"),
+				RuleConfigURL:       cctx.String("rule-config-url"),
+				ReviewDBURL:         cctx.String("review-db-url"),
+				AuditDBURL:          cctx.String("audit-db-url"),
+				LabelerDBURL:        cctx.String("labeler-db-url"),
+				LabelerDID:          cctx.String("labeler-did"),
+				LabelerSigningKey:   cctx.String("labeler-signing-key"),
+				PLCHost:             cctx.String("atp-plc-host"),
+				RemoteSets:          cctx.StringSlice("remote-sets"),
+				PHashLists:          cctx.StringSlice("phash-lists"),
+				PHashMaxDistance:    cctx.Int("phash-max-distance"),
+				ClusterSignals:      cctx.Bool("cluster-signals"),
+				AdminTokens:         cctx.StringSlice("admin-tokens"),
 			},
 		)
 		if err != nil {
@@ -270,12 +414,35 @@ var runCmd = &cli.Command{
 			}
 		}()
 
+		// public labeler API: /xrpc/com.atproto.label.* (if configured)
+		if srv.engine.Labeler != nil {
+			go func() {
+				if err := srv.RunLabeler(cctx.String("labeler-listen")); err != nil {
+					slog.Error("failed to start labeler endpoint", "error", err)
+					panic(fmt.Errorf("failed to start labeler endpoint: %w", err))
+				}
+			}()
+		}
+
 		go func() {
 			if err := srv.RunPersistCursor(ctx); err != nil {
 				slog.Error("cursor routine failed", "err", err)
 			}
 		}()
 
+		// periodic rule config reload (if configured)
+		if srv.engine.RuleConfig != nil && cctx.Duration("rule-config-refresh") > 0 {
+			go srv.engine.RuleConfig.RunPeriodicReload(ctx, logger, cctx.Duration("rule-config-refresh"))
+		}
+
+		// periodic remote set refresh (if configured)
+		if srv.remoteSets != nil && cctx.Duration("remote-sets-refresh") > 0 {
+			go srv.remoteSets.Run(ctx, logger, cctx.Duration("remote-sets-refresh"))
+		}
+		if srv.phashLists != nil && cctx.Duration("remote-sets-refresh") > 0 {
+			go srv.phashLists.Run(ctx, logger, cctx.Duration("remote-sets-refresh"))
+		}
+
 		// ozone event consumer (if configured)
 		if srv.engine.OzoneClient != nil {
 			go func() {
@@ -309,29 +476,49 @@ func configEphemeralServer(cctx *cli.Context) (*Server, error) {
 		return nil, err
 	}
 
-	return NewServer(
-		dir,
-		Config{
-			Logger:              logger,
-			RelayHost:           cctx.String("atp-relay-host"),
-			BskyHost:            cctx.String("atp-bsky-host"),
-			OzoneHost:           cctx.String("atp-ozone-host"),
-			OzoneDID:            cctx.String("ozone-did"),
-			OzoneAdminToken:     cctx.String("ozone-admin-token"),
-			PDSHost:             cctx.String("atp-pds-host"),
-			PDSAdminToken:       cctx.String("pds-admin-token"),
-			SetsFileJSON:        cctx.String("sets-json-path"),
-			RedisURL:            cctx.String("redis-url"),
-			HiveAPIToken:        cctx.String("hiveai-api-token"),
-			AbyssHost:           cctx.String("abyss-host"),
-			AbyssPassword:       cctx.String("abyss-password"),
-			RatelimitBypass:     cctx.String("ratelimit-bypass"),
-			RulesetName:         cctx.String("ruleset"),
-			FirehoseParallelism: cctx.Int("firehose-parallelism"),
-			PreScreenHost:       cctx.String("prescreen-host"),
-			PreScreenToken:      cctx.String("prescreen-token"),
-		},
-	)
+	return NewServer(dir, ephemeralServerConfig(cctx, logger))
+}
+
+func ephemeralServerConfig(cctx *cli.Context, logger *slog.Logger) Config {
+	return Config{
+		Logger:              logger,
+		RelayHost:           cctx.String("atp-relay-host"),
+		BskyHost:            cctx.String("atp-bsky-host"),
+		OzoneHost:           cctx.String("atp-ozone-host"),
+		OzoneDID:            cctx.String("ozone-did"),
+		OzoneAdminToken:     cctx.String("ozone-admin-token"),
+		OzoneSigningKey:     cctx.String("ozone-signing-key"),
+		OzoneServiceDID:     cctx.String("ozone-service-did"),
+		OzoneFlagTags:       cctx.Bool("ozone-flag-tags"),
+		PDSHost:             cctx.String("atp-pds-host"),
+		PDSAdminToken:       cctx.String("pds-admin-token"),
+		SetsFileJSON:        cctx.String("sets-json-path"),
+		RedisURL:            cctx.String("redis-url"),
+		RedisCluster:        cctx.Bool("redis-cluster"),
+		HiveAPIToken:        cctx.String("hiveai-api-token"),
+		AbyssHost:           cctx.String("abyss-host"),
+		AbyssPassword:       cctx.String("abyss-password"),
+		ScoringHost:         cctx.String("scoring-host"),
+		ScoringToken:        cctx.String("scoring-token"),
+		ScoringName:         cctx.String("scoring-name"),
+		RatelimitBypass:     cctx.String("ratelimit-bypass"),
+		RulesetName:         cctx.String("ruleset"),
+		FirehoseParallelism: cctx.Int("firehose-parallelism"),
+		PreScreenHost:       cctx.String("prescreen-host"),
+		PreScreenToken:      cctx.String("prescreen-token"),
+		RuleConfigPath:      cctx.String("rule-config-path"),
+		RuleConfigURL:       cctx.String("rule-config-url"),
+		ReviewDBURL:         cctx.String("review-db-url"),
+		AuditDBURL:          cctx.String("audit-db-url"),
+		LabelerDBURL:        cctx.String("labeler-db-url"),
+		LabelerDID:          cctx.String("labeler-did"),
+		LabelerSigningKey:   cctx.String("labeler-signing-key"),
+		PLCHost:             cctx.String("atp-plc-host"),
+		RemoteSets:          cctx.StringSlice("remote-sets"),
+		PHashLists:          cctx.StringSlice("phash-lists"),
+		PHashMaxDistance:    cctx.Int("phash-max-distance"),
+		ClusterSignals:      cctx.Bool("cluster-signals"),
+	}
 }
 
 var processRecordCmd = &cli.Command{
@@ -7,6 +7,8 @@ import (
 	"os"
 	"time"
 
+	"github.com/bluesky-social/indigo/util/tracing"
+
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
@@ -52,5 +54,6 @@ func configOTEL(serviceName string) {
 			)),
 		)
 		otel.SetTracerProvider(tp)
+		tracing.InstallPropagator()
 	}
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bluesky-social/indigo/automod/reviewqueue"
+)
+
+type reviewListResponse struct {
+	Items  []reviewqueue.ReviewItem `json:"items"`
+	Cursor string                   `json:"cursor,omitempty"`
+}
+
+type reviewActionRequest struct {
+	ID         uint   `json:"id"`
+	Resolution string `json:"resolution,omitempty"`
+	// name of the admin token the request was authenticated with
+	reviewer string
+}
+
+type reviewError struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) reviewQueueEnabled(w http.ResponseWriter) bool {
+	if s.engine.ReviewQueue == nil {
+		writeJSON(w, http.StatusNotFound, reviewError{Error: "review queue not enabled"})
+		return false
+	}
+	return true
+}
+
+func writeReviewErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, reviewqueue.ErrNotFound):
+		writeJSON(w, http.StatusNotFound, reviewError{Error: err.Error()})
+	case errors.Is(err, reviewqueue.ErrInvalidStatus):
+		writeJSON(w, http.StatusConflict, reviewError{Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, reviewError{Error: err.Error()})
+	}
+}
+
+// lists review queue items. query params: "status" (optional), "cursor", "limit"
+func (s *Server) HandleReviewList(w http.ResponseWriter, r *http.Request) {
+	if !s.reviewQueueEnabled(w) {
+		return
+	}
+	q := r.URL.Query()
+	var cursor uint64
+	if c := q.Get("cursor"); c != "" {
+		var err error
+		cursor, err = strconv.ParseUint(c, 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, reviewError{Error: "invalid cursor"})
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	items, err := s.engine.ReviewQueue.List(r.Context(), q.Get("status"), uint(cursor), limit)
+	if err != nil {
+		writeReviewErr(w, err)
+		return
+	}
+	resp := reviewListResponse{Items: items}
+	if len(items) > 0 {
+		resp.Cursor = strconv.FormatUint(uint64(items[len(items)-1].ID), 10)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// fetches a single review queue item. query param: "id"
+func (s *Server) HandleReviewGet(w http.ResponseWriter, r *http.Request) {
+	if !s.reviewQueueEnabled(w) {
+		return
+	}
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, reviewError{Error: "invalid id"})
+		return
+	}
+	item, err := s.engine.ReviewQueue.Get(r.Context(), uint(id))
+	if err != nil {
+		writeReviewErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+func (s *Server) parseReviewAction(w http.ResponseWriter, r *http.Request) (*reviewActionRequest, bool) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, reviewError{Error: "POST required"})
+		return nil, false
+	}
+	if !s.reviewQueueEnabled(w) {
+		return nil, false
+	}
+	var req reviewActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, reviewError{Error: "invalid request body"})
+		return nil, false
+	}
+	if req.ID == 0 {
+		writeJSON(w, http.StatusBadRequest, reviewError{Error: "id is required"})
+		return nil, false
+	}
+	req.reviewer = adminName(r.Context())
+	return &req, true
+}
+
+// claims a review item for the authenticated reviewer; POST only, JSON body with "id"
+func (s *Server) HandleReviewClaim(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.parseReviewAction(w, r)
+	if !ok {
+		return
+	}
+	item, err := s.engine.ReviewQueue.Claim(r.Context(), req.ID, req.reviewer)
+	if err != nil {
+		writeReviewErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+// resolves a review item as the authenticated reviewer; POST only, JSON body with "id" and "resolution"
+func (s *Server) HandleReviewResolve(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.parseReviewAction(w, r)
+	if !ok {
+		return
+	}
+	item, err := s.engine.ReviewQueue.Resolve(r.Context(), req.ID, req.reviewer, req.Resolution)
+	if err != nil {
+		writeReviewErr(w, err)
+		return
+	}
+	s.logger.Info("resolved review item", "id", item.ID, "subject", item.Subject(), "reviewer", req.reviewer, "resolution", req.Resolution)
+	writeJSON(w, http.StatusOK, item)
+}
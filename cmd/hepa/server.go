@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -10,16 +11,25 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/bluesky-social/indigo/atproto/crypto"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/auditlog"
 	"github.com/bluesky-social/indigo/automod/cachestore"
+	"github.com/bluesky-social/indigo/automod/cluster"
 	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/dupestore"
 	"github.com/bluesky-social/indigo/automod/flagstore"
+	"github.com/bluesky-social/indigo/automod/labeler"
+	"github.com/bluesky-social/indigo/automod/reviewqueue"
 	"github.com/bluesky-social/indigo/automod/rules"
+	"github.com/bluesky-social/indigo/automod/scoring"
 	"github.com/bluesky-social/indigo/automod/setstore"
 	"github.com/bluesky-social/indigo/automod/visual"
 	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/util/health"
 	"github.com/bluesky-social/indigo/xrpc"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -31,7 +41,11 @@ type Server struct {
 	firehoseParallelism int
 	logger              *slog.Logger
 	engine              *automod.Engine
-	rdb                 *redis.Client
+	rdb                 redis.UniversalClient
+	remoteSets          *setstore.RemoteSetSubscriber
+	phashLists          *setstore.RemoteSetSubscriber
+	// admin bearer tokens for the metrics port's admin endpoints, mapped to the names they were configured with
+	adminTokens map[string]string
 
 	// lastSeq is the most recent event sequence number we've received and begun to handle.
 	// This number is periodically persisted to redis, if redis is present.
@@ -41,6 +55,9 @@ type Server struct {
 
 	// same as lastSeq, but for Ozone timestamp cursor. the value is a string.
 	lastOzoneCursor atomic.Value
+
+	// whether RunConsumer is connected to the firehose, for readiness checks
+	firehoseConnected atomic.Bool
 }
 
 type Config struct {
@@ -50,19 +67,40 @@ type Config struct {
 	OzoneHost           string
 	OzoneDID            string
 	OzoneAdminToken     string
+	OzoneSigningKey     string
+	OzoneServiceDID     string
+	OzoneFlagTags       bool
 	PDSHost             string
 	PDSAdminToken       string
 	SetsFileJSON        string
 	RedisURL            string
+	RedisCluster        bool
 	SlackWebhookURL     string
+	WebhookConfigPath   string
 	HiveAPIToken        string
 	AbyssHost           string
 	AbyssPassword       string
+	ScoringHost         string
+	ScoringToken        string
+	ScoringName         string
 	RulesetName         string
 	RatelimitBypass     string
 	FirehoseParallelism int
 	PreScreenHost       string
 	PreScreenToken      string
+	RuleConfigPath      string
+	RuleConfigURL       string
+	ReviewDBURL         string
+	PLCHost             string
+	AuditDBURL          string
+	LabelerDBURL        string
+	LabelerDID          string
+	LabelerSigningKey   string
+	RemoteSets          []string
+	PHashLists          []string
+	PHashMaxDistance    int
+	ClusterSignals      bool
+	AdminTokens         []string
 }
 
 func NewServer(dir identity.Directory, config Config) (*Server, error) {
@@ -79,13 +117,35 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 	}
 
 	var ozoneClient *xrpc.Client
-	if config.OzoneAdminToken != "" && config.OzoneDID != "" {
+	if config.OzoneSigningKey != "" && config.OzoneDID != "" {
+		if config.OzoneServiceDID == "" {
+			return nil, fmt.Errorf("ozone service DID is required for service auth")
+		}
+		key, err := crypto.ParsePrivateMultibase(config.OzoneSigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("parsing ozone signing key: %v", err)
+		}
+		httpClient := util.RobustHTTPClient()
+		httpClient.Transport = &xrpc.ServiceAuthTransport{
+			Base:     httpClient.Transport,
+			Key:      key,
+			Issuer:   config.OzoneDID,
+			Audience: config.OzoneServiceDID,
+		}
+		ozoneClient = &xrpc.Client{
+			Client: httpClient,
+			Host:   config.OzoneHost,
+			Auth:   &xrpc.AuthInfo{},
+		}
+	} else if config.OzoneAdminToken != "" && config.OzoneDID != "" {
 		ozoneClient = &xrpc.Client{
 			Client:     util.RobustHTTPClient(),
 			Host:       config.OzoneHost,
 			AdminToken: &config.OzoneAdminToken,
 			Auth:       &xrpc.AuthInfo{},
 		}
+	}
+	if ozoneClient != nil {
 		if config.RatelimitBypass != "" {
 			ozoneClient.Headers = make(map[string]string)
 			ozoneClient.Headers["x-ratelimit-bypass"] = config.RatelimitBypass
@@ -125,37 +185,61 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 			logger.Info("loaded set config from JSON", "path", config.SetsFileJSON)
 		}
 	}
+	var remoteSets *setstore.RemoteSetSubscriber
+	if len(config.RemoteSets) > 0 {
+		sources := make([]*setstore.RemoteSetSource, 0, len(config.RemoteSets))
+		for _, spec := range config.RemoteSets {
+			src, err := setstore.ParseRemoteSetSource(spec)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, src)
+		}
+		remoteSets = setstore.NewRemoteSetSubscriber(sets, dir, sources)
+		// initial load failures are not fatal; sets will be retried on the next refresh
+		if err := remoteSets.RefreshAll(context.TODO(), logger); err != nil {
+			logger.Warn("failed initial load of remote sets", "err", err)
+		}
+	}
 
 	var counters countstore.CountStore
 	var cache cachestore.CacheStore
 	var flags flagstore.FlagStore
-	var rdb *redis.Client
+	var rdb redis.UniversalClient
 	if config.RedisURL != "" {
-		// generic client, for cursor state
-		opt, err := redis.ParseURL(config.RedisURL)
-		if err != nil {
-			return nil, fmt.Errorf("parsing redis URL: %v", err)
+		// generic client, for cursor state; shared with all the redis-backed stores
+		if config.RedisCluster {
+			opt, err := redis.ParseClusterURL(config.RedisURL)
+			if err != nil {
+				return nil, fmt.Errorf("parsing redis cluster URL: %v", err)
+			}
+			rdb = redis.NewClusterClient(opt)
+		} else {
+			opt, err := redis.ParseURL(config.RedisURL)
+			if err != nil {
+				return nil, fmt.Errorf("parsing redis URL: %v", err)
+			}
+			rdb = redis.NewClient(opt)
 		}
-		rdb = redis.NewClient(opt)
 		// check redis connection
-		_, err = rdb.Ping(context.TODO()).Result()
+		_, err := rdb.Ping(context.TODO()).Result()
 		if err != nil {
 			return nil, fmt.Errorf("redis ping failed: %v", err)
 		}
 
-		cnt, err := countstore.NewRedisCountStore(config.RedisURL)
+		cnt, err := countstore.NewRedisCountStoreFromClient(context.TODO(), rdb)
 		if err != nil {
 			return nil, fmt.Errorf("initializing redis countstore: %v", err)
 		}
 		counters = cnt
 
-		csh, err := cachestore.NewRedisCacheStore(config.RedisURL, 6*time.Hour)
+		csh, err := cachestore.NewRedisCacheStoreFromClient(context.TODO(), rdb, 6*time.Hour)
 		if err != nil {
 			return nil, fmt.Errorf("initializing redis cachestore: %v", err)
 		}
 		cache = csh
 
-		flg, err := flagstore.NewRedisFlagStore(config.RedisURL)
+		flg, err := flagstore.NewRedisFlagStoreFromClient(context.TODO(), rdb)
 		if err != nil {
 			return nil, fmt.Errorf("initializing redis flagstore: %v", err)
 		}
@@ -185,25 +269,160 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		extraBlobRules = append(extraBlobRules, ac.AbyssScanBlobRule)
 	}
 
+	var phashLists *setstore.RemoteSetSubscriber
+	if len(config.PHashLists) > 0 {
+		logger.Info("configuring perceptual image hash matching", "lists", len(config.PHashLists))
+		sources := make([]*setstore.RemoteSetSource, 0, len(config.PHashLists))
+		for _, spec := range config.PHashLists {
+			src, err := setstore.ParseRemoteSetSource(spec)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, src)
+		}
+		hashDB := visual.NewMemHashDB()
+		phashLists = setstore.NewRemoteSetSubscriber(hashDB, dir, sources)
+		if err := phashLists.RefreshAll(context.TODO(), logger); err != nil {
+			logger.Warn("failed initial load of perceptual hash lists", "err", err)
+		}
+		pm := visual.NewPHashMatcher(hashDB, config.PHashMaxDistance)
+		extraBlobRules = append(extraBlobRules, pm.PHashMatchBlobRule)
+	}
+
+	// scoring rules set context scores, so they must run before any rules which read them
+	scorePostRules := []automod.PostRuleFunc{}
+	scoreProfileRules := []automod.ProfileRuleFunc{}
+	if config.ScoringHost != "" {
+		logger.Info("configuring external text scoring", "name", config.ScoringName, "host", config.ScoringHost)
+		sc := scoring.NewScoringClient(config.ScoringName, config.ScoringHost, config.ScoringToken)
+		scorePostRules = append(scorePostRules, sc.ScorePostRule)
+		scoreProfileRules = append(scoreProfileRules, sc.ScoreProfileRule)
+	}
+	scoreRecordRules := []automod.RecordRuleFunc{}
+	extraPostRules := []automod.PostRuleFunc{}
+	var dupes dupestore.DupeStore
+	if config.ClusterSignals {
+		logger.Info("configuring cross-account cluster signals")
+		scorePostRules = append(scorePostRules, cluster.ClusterPostRule, cluster.NearDuplicatePostRule)
+		scoreRecordRules = append(scoreRecordRules, cluster.InviteTreeRecordRule)
+		extraPostRules = append(extraPostRules, cluster.CoordinatedPostRule, cluster.CopypastaPostRule)
+
+		if rdb != nil {
+			ds, err := dupestore.NewRedisDupeStoreFromClient(context.TODO(), rdb, cluster.NearDuplicateWindow)
+			if err != nil {
+				return nil, fmt.Errorf("initializing redis dupestore: %v", err)
+			}
+			dupes = ds
+		} else {
+			dupes = dupestore.NewMemDupeStore(cluster.NearDuplicateWindow)
+		}
+	}
+
 	var ruleset automod.RuleSet
 	switch config.RulesetName {
 	case "", "default", "no-hive":
 		ruleset = rules.DefaultRules()
 		ruleset.BlobRules = append(ruleset.BlobRules, extraBlobRules...)
+		ruleset.PostRules = append(append(scorePostRules, ruleset.PostRules...), extraPostRules...)
+		ruleset.ProfileRules = append(scoreProfileRules, ruleset.ProfileRules...)
+		ruleset.RecordRules = append(scoreRecordRules, ruleset.RecordRules...)
 	case "no-blobs":
 		ruleset = rules.DefaultRules()
 		ruleset.BlobRules = []automod.BlobRuleFunc{}
+		ruleset.PostRules = append(append(scorePostRules, ruleset.PostRules...), extraPostRules...)
+		ruleset.ProfileRules = append(scoreProfileRules, ruleset.ProfileRules...)
+		ruleset.RecordRules = append(scoreRecordRules, ruleset.RecordRules...)
 	case "only-blobs":
 		ruleset.BlobRules = extraBlobRules
 	default:
 		return nil, fmt.Errorf("unknown ruleset config: %s", config.RulesetName)
 	}
 
-	var notifier automod.Notifier
+	var ruleConfig *automod.RuleConfigStore
+	if config.RuleConfigPath != "" || config.RuleConfigURL != "" {
+		ruleConfig = automod.NewRuleConfigStore(config.RuleConfigPath, config.RuleConfigURL)
+		rc, err := ruleConfig.Reload(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("loading rule config: %v", err)
+		}
+		logger.Info("loaded rule config", "version", rc.Version)
+	}
+
+	// NOTE: interface type, so that it stays nil if not configured
+	var reviewQueue reviewqueue.ReviewQueue
+	if config.ReviewDBURL != "" {
+		db, err := cliutil.SetupDatabase(config.ReviewDBURL, 10)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to review queue database: %v", err)
+		}
+		rq, err := reviewqueue.NewSQLReviewQueue(db)
+		if err != nil {
+			return nil, err
+		}
+		reviewQueue = rq
+		logger.Info("configured human review queue")
+	}
+
+	var auditLog auditlog.AuditLog
+	if config.AuditDBURL != "" {
+		db, err := cliutil.SetupDatabase(config.AuditDBURL, 10)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to audit log database: %v", err)
+		}
+		al, err := auditlog.NewSQLAuditLog(db)
+		if err != nil {
+			return nil, err
+		}
+		auditLog = al
+		logger.Info("configured rule decision audit log")
+	}
+
+	var lblr *labeler.Labeler
+	if config.LabelerDBURL != "" {
+		if config.LabelerDID == "" || config.LabelerSigningKey == "" {
+			return nil, fmt.Errorf("labeler DID and signing key are required to publish labels")
+		}
+		did, err := syntax.ParseDID(config.LabelerDID)
+		if err != nil {
+			return nil, fmt.Errorf("labeler DID supplied was not valid: %v", err)
+		}
+		key, err := crypto.ParsePrivateMultibase(config.LabelerSigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("parsing labeler signing key: %v", err)
+		}
+		db, err := cliutil.SetupDatabase(config.LabelerDBURL, 10)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to labeler database: %v", err)
+		}
+		store, err := labeler.NewSQLLabelStore(db)
+		if err != nil {
+			return nil, err
+		}
+		lblr = labeler.NewLabeler(store, did, key, logger)
+		logger.Info("configured labeler service", "did", did.String())
+	}
+
+	var notifiers automod.MultiNotifier
 	if config.SlackWebhookURL != "" {
-		notifier = &automod.SlackNotifier{
+		notifiers = append(notifiers, &automod.SlackNotifier{
 			SlackWebhookURL: config.SlackWebhookURL,
+		})
+	}
+	if config.WebhookConfigPath != "" {
+		wn, err := automod.LoadWebhookNotifier(config.WebhookConfigPath)
+		if err != nil {
+			return nil, err
 		}
+		notifiers = append(notifiers, wn)
+		logger.Info("configured webhook notifications", "targets", len(wn.Targets))
+	}
+	var notifier automod.Notifier
+	switch len(notifiers) {
+	case 0:
+	case 1:
+		notifier = notifiers[0]
+	default:
+		notifier = notifiers
 	}
 
 	bskyClient := xrpc.Client{
@@ -216,18 +435,31 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 	}
 	blobClient := util.RobustHTTPClient()
 	engine := automod.Engine{
-		Logger:      logger,
-		Directory:   dir,
-		Counters:    counters,
-		Sets:        sets,
-		Flags:       flags,
-		Cache:       cache,
-		Rules:       ruleset,
-		Notifier:    notifier,
-		BskyClient:  &bskyClient,
-		OzoneClient: ozoneClient,
-		AdminClient: adminClient,
-		BlobClient:  blobClient,
+		Logger:        logger,
+		Directory:     dir,
+		Counters:      counters,
+		Sets:          sets,
+		Flags:         flags,
+		Dupes:         dupes,
+		Cache:         cache,
+		Rules:         ruleset,
+		Notifier:      notifier,
+		RuleConfig:    ruleConfig,
+		BskyClient:    &bskyClient,
+		OzoneClient:   ozoneClient,
+		OzoneFlagTags: config.OzoneFlagTags,
+		ReviewQueue:   reviewQueue,
+		AuditLog:      auditLog,
+		Labeler:       lblr,
+		AdminClient:   adminClient,
+		BlobClient:    blobClient,
+		PLCHost:       config.PLCHost,
+		PLCClient:     util.RobustHTTPClient(),
+	}
+
+	adminTokens, err := parseAdminTokens(config.AdminTokens)
+	if err != nil {
+		return nil, err
 	}
 
 	s := &Server{
@@ -236,6 +468,9 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		logger:              logger,
 		engine:              &engine,
 		rdb:                 rdb,
+		remoteSets:          remoteSets,
+		phashLists:          phashLists,
+		adminTokens:         adminTokens,
 	}
 
 	return s, nil
@@ -243,9 +478,95 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 
 func (s *Server) RunMetrics(listen string) error {
 	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/admin/ruleset", s.requireAdmin(s.HandleRulesetVersion))
+	http.HandleFunc("/admin/ruleset/reload", s.requireAdmin(s.HandleRulesetReload))
+	http.HandleFunc("/admin/review/items", s.requireAdmin(s.HandleReviewList))
+	http.HandleFunc("/admin/review/item", s.requireAdmin(s.HandleReviewGet))
+	http.HandleFunc("/admin/review/claim", s.requireAdmin(s.HandleReviewClaim))
+	http.HandleFunc("/admin/review/resolve", s.requireAdmin(s.HandleReviewResolve))
+	http.HandleFunc("/admin/audit", s.requireAdmin(s.HandleAuditList))
+	s.newHealthChecker().Register(http.DefaultServeMux)
 	return http.ListenAndServe(listen, nil)
 }
 
+// newHealthChecker sets up the readiness checks served at /readyz
+func (s *Server) newHealthChecker() *health.Checker {
+	hc := health.NewChecker("hepa")
+	if s.rdb != nil {
+		hc.Add("redis", func(ctx context.Context) error {
+			return s.rdb.Ping(ctx).Err()
+		})
+	}
+	hc.Add("firehose", func(ctx context.Context) error {
+		if !s.firehoseConnected.Load() {
+			return fmt.Errorf("not connected to firehose")
+		}
+		return nil
+	})
+	return hc
+}
+
+// serves the public labeler API, separately from the admin endpoints on the metrics port
+func (s *Server) RunLabeler(listen string) error {
+	mux := http.NewServeMux()
+	s.engine.Labeler.RegisterHandlers(mux)
+	return http.ListenAndServe(listen, mux)
+}
+
+type rulesetStatus struct {
+	Version  string `json:"version,omitempty"`
+	LoadedAt string `json:"loadedAt,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (s *Server) rulesetStatus() rulesetStatus {
+	rc := s.engine.RuleConfig.Current()
+	if rc == nil {
+		return rulesetStatus{}
+	}
+	return rulesetStatus{
+		Version:  rc.Version,
+		LoadedAt: s.engine.RuleConfig.LoadedAt().UTC().Format(time.RFC3339),
+	}
+}
+
+// reports the version of the currently active rule config
+func (s *Server) HandleRulesetVersion(w http.ResponseWriter, r *http.Request) {
+	if s.engine.RuleConfig == nil {
+		writeJSON(w, http.StatusNotFound, rulesetStatus{Error: "rule config not enabled"})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.rulesetStatus())
+}
+
+// triggers an immediate re-load of rule config; POST only
+func (s *Server) HandleRulesetReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, rulesetStatus{Error: "POST required"})
+		return
+	}
+	if s.engine.RuleConfig == nil {
+		writeJSON(w, http.StatusNotFound, rulesetStatus{Error: "rule config not enabled"})
+		return
+	}
+	rc, err := s.engine.RuleConfig.Reload(r.Context())
+	if err != nil {
+		s.logger.Error("admin rule config reload failed", "err", err)
+		status := s.rulesetStatus()
+		status.Error = err.Error()
+		writeJSON(w, http.StatusInternalServerError, status)
+		return
+	}
+	s.logger.Info("reloaded rule config via admin request", "version", rc.Version)
+	writeJSON(w, http.StatusOK, s.rulesetStatus())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
 var cursorKey = "hepa/seq"
 var ozoneCursorKey = "hepa/ozoneTimestamp"
 
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/bluesky-social/indigo/automod/simulate"
+	"github.com/bluesky-social/indigo/events"
+
+	"github.com/urfave/cli/v2"
+)
+
+var simulateCmd = &cli.Command{
+	Name:      "simulate",
+	Usage:     "replay recorded firehose segments through the production and a candidate ruleset (dry-run), and report differences in actions",
+	ArgsUsage: `<segment-path-or-url>...`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "candidate-ruleset",
+			Usage: "named ruleset for the candidate engine (defaults to same as --ruleset)",
+		},
+		&cli.StringFlag{
+			Name:  "candidate-rule-config-path",
+			Usage: "rule config JSON file for the candidate engine (defaults to same as production)",
+		},
+		&cli.IntFlag{
+			Name:  "max-diffs",
+			Usage: "max number of individual differences to include in report",
+			Value: 1000,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		if cctx.NArg() == 0 {
+			return fmt.Errorf("expected one or more segment file paths or URLs")
+		}
+		logger := configLogger(cctx, os.Stderr)
+		dir, err := configDirectory(cctx)
+		if err != nil {
+			return err
+		}
+
+		// counters and other state are kept in-process, separately for each engine; actions are never persisted
+		prodConfig := ephemeralServerConfig(cctx, logger)
+		prodConfig.RedisURL = ""
+		prodConfig.ReviewDBURL = ""
+		prodConfig.AuditDBURL = ""
+		candConfig := prodConfig
+		if cctx.String("candidate-ruleset") != "" {
+			candConfig.RulesetName = cctx.String("candidate-ruleset")
+		}
+		if cctx.String("candidate-rule-config-path") != "" {
+			candConfig.RuleConfigPath = cctx.String("candidate-rule-config-path")
+		}
+
+		prod, err := NewServer(dir, prodConfig)
+		if err != nil {
+			return fmt.Errorf("configuring production engine: %w", err)
+		}
+		cand, err := NewServer(dir, candConfig)
+		if err != nil {
+			return fmt.Errorf("configuring candidate engine: %w", err)
+		}
+		sim := simulate.NewSimulator(prod.engine, cand.engine)
+		sim.Logger = logger
+		sim.MaxDiffs = cctx.Int("max-diffs")
+
+		for _, loc := range cctx.Args().Slice() {
+			logger.Info("replaying segment", "segment", loc)
+			rc, err := simulate.OpenSegment(ctx, loc)
+			if err != nil {
+				return err
+			}
+			err = simulate.ReadSegment(rc, func(xev *events.XRPCStreamEvent) error {
+				return sim.ProcessEvent(ctx, xev)
+			})
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("replaying segment %s: %w", loc, err)
+			}
+		}
+
+		b, err := json.MarshalIndent(sim.Report(), "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	},
+}
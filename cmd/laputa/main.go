@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -9,6 +10,8 @@ import (
 	"github.com/bluesky-social/indigo/pds"
 	"github.com/bluesky-social/indigo/plc"
 	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/util/migrate"
+	indigotracing "github.com/bluesky-social/indigo/util/tracing"
 
 	_ "github.com/joho/godotenv/autoload"
 	_ "go.uber.org/automaxprocs"
@@ -22,6 +25,7 @@ import (
 	"go.opentelemetry.io/otel/sdk/resource"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"gorm.io/gorm"
 	"gorm.io/plugin/opentelemetry/tracing"
 )
 
@@ -56,6 +60,11 @@ func run(args []string) {
 		&cli.BoolFlag{
 			Name: "db-tracing",
 		},
+		&cli.BoolFlag{
+			Name:    "manual-migrations",
+			Usage:   "don't apply database schema migrations on startup; refuse to start until pending migrations are applied with 'laputa migrate up'",
+			EnvVars: []string{"PDS_MANUAL_MIGRATIONS"},
+		},
 		&cli.StringFlag{
 			Name:  "name",
 			Usage: "hostname of this PDS instance",
@@ -85,6 +94,37 @@ func run(args []string) {
 			Value:   ".test",
 			EnvVars: []string{"ATP_PDS_HANDLE_DOMAINS"},
 		},
+		&cli.StringFlag{
+			Name:    "blobstore",
+			Usage:   "local directory or s3://<bucket>/<prefix> URI to store uploaded blobs in (defaults to 'blobs' in data-dir). S3 access uses the standard AWS_* environment variables",
+			EnvVars: []string{"PDS_BLOBSTORE"},
+		},
+		&cli.Int64Flag{
+			Name:    "blob-quota-mb",
+			Usage:   "max total size in megabytes of blobs uploaded by each account (0 for no limit)",
+			EnvVars: []string{"PDS_BLOB_QUOTA_MB"},
+		},
+		&cli.Float64Flag{
+			Name:    "auth-requests-per-minute",
+			Usage:   "max account creation, login, and password reset requests per minute from a single client IP",
+			Value:   10,
+			EnvVars: []string{"PDS_AUTH_REQUESTS_PER_MINUTE"},
+		},
+		&cli.StringFlag{
+			Name:    "oauth-issuer",
+			Usage:   "public origin of the PDS (eg, https://pds.example.com), to enable the OAuth authorization server",
+			EnvVars: []string{"PDS_OAUTH_ISSUER"},
+		},
+		&cli.StringFlag{
+			Name:    "mailer",
+			Usage:   "how to send email: smtp://<user>:<password>@<host>:<port>, smtps://... (implicit TLS), or ses://<region>",
+			EnvVars: []string{"PDS_MAILER"},
+		},
+		&cli.StringFlag{
+			Name:    "email-from",
+			Usage:   "address emails are sent from, eg \"My PDS <noreply@pds.example.com>\"",
+			EnvVars: []string{"PDS_EMAIL_FROM"},
+		},
 		&cli.IntFlag{
 			Name:    "max-carstore-connections",
 			EnvVars: []string{"MAX_CARSTORE_CONNECTIONS"},
@@ -99,6 +139,7 @@ func run(args []string) {
 
 	app.Commands = []*cli.Command{
 		generateKeyCmd,
+		migrateCmd,
 	}
 
 	app.Action = func(cctx *cli.Context) error {
@@ -122,6 +163,7 @@ func run(args []string) {
 			)
 
 			otel.SetTracerProvider(tp)
+			indigotracing.InstallPropagator()
 		}
 
 		dbtracing := cctx.Bool("db-tracing")
@@ -149,6 +191,18 @@ func run(args []string) {
 			return err
 		}
 
+		if cctx.Bool("manual-migrations") {
+			migrators, err := pdsMigrators(db, csdb)
+			if err != nil {
+				return err
+			}
+			for _, mg := range migrators {
+				if err := mg.Check(cctx.Context); err != nil {
+					return fmt.Errorf("%w (apply with 'laputa migrate up')", err)
+				}
+			}
+		}
+
 		if dbtracing {
 			if err := db.Use(tracing.NewPlugin()); err != nil {
 				return err
@@ -182,6 +236,32 @@ func run(args []string) {
 			return err
 		}
 
+		blobstore := cctx.String("blobstore")
+		if blobstore == "" {
+			blobstore = filepath.Join(datadir, "blobs")
+		}
+		bs, err := pds.ParseBlobStore(blobstore)
+		if err != nil {
+			return err
+		}
+		srv.SetBlobStore(bs)
+		srv.SetBlobQuota(cctx.Int64("blob-quota-mb") * 1024 * 1024)
+		srv.SetAuthRateLimit(cctx.Float64("auth-requests-per-minute")/60, 0)
+
+		if uri := cctx.String("mailer"); uri != "" {
+			m, err := pds.ParseMailer(uri, cctx.String("email-from"))
+			if err != nil {
+				return err
+			}
+			srv.SetMailer(m)
+		}
+
+		if issuer := cctx.String("oauth-issuer"); issuer != "" {
+			if err := srv.EnableOAuth(issuer); err != nil {
+				return err
+			}
+		}
+
 		return srv.RunAPI(":4989")
 	}
 
@@ -202,3 +282,28 @@ var generateKeyCmd = &cli.Command{
 		return cliutil.GenerateKeyToFile(fname)
 	},
 }
+
+var migrateCmd = cliutil.MigrateCommand(func(cctx *cli.Context) ([]*migrate.Migrator, error) {
+	db, err := cliutil.SetupDatabase(cctx.String("db-url"), 1)
+	if err != nil {
+		return nil, err
+	}
+	csdb, err := cliutil.SetupDatabase(cctx.String("carstore-db-url"), 1)
+	if err != nil {
+		return nil, err
+	}
+	return pdsMigrators(db, csdb)
+})
+
+// pdsMigrators returns the migrations for the PDS's two databases
+func pdsMigrators(db, csdb *gorm.DB) ([]*migrate.Migrator, error) {
+	pdsMigrator, err := pds.NewMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	csMigrator, err := carstore.NewMigrator(csdb)
+	if err != nil {
+		return nil, err
+	}
+	return []*migrate.Migrator{pdsMigrator, csMigrator}, nil
+}
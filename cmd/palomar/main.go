@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -24,9 +23,9 @@ import (
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/search"
 	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/util/tracing"
 
 	"github.com/carlmjohnson/versioninfo"
-	es "github.com/opensearch-project/opensearch-go/v2"
 	cli "github.com/urfave/cli/v2"
 )
 
@@ -86,6 +85,12 @@ func run(args []string) error {
 			Value:   "palomar_profile",
 			EnvVars: []string{"ES_PROFILE_INDEX"},
 		},
+		&cli.StringFlag{
+			Name:    "es-record-index",
+			Usage:   "ES index for feed generator, list, and starter pack documents",
+			Value:   "palomar_record",
+			EnvVars: []string{"ES_RECORD_INDEX"},
+		},
 		&cli.StringFlag{
 			Name:    "atp-relay-host",
 			Usage:   "hostname and port of Relay to subscribe to",
@@ -117,6 +122,7 @@ func run(args []string) error {
 		searchPostCmd,
 		searchProfileCmd,
 	}
+	cliutil.WithConfigFile(&app, "PALOMAR_CONFIG", runCmd)
 
 	return app.Run(args)
 }
@@ -146,6 +152,27 @@ var runCmd = &cli.Command{
 			Value:   ":3998",
 			EnvVars: []string{"PALOMAR_METRICS_LISTEN"},
 		},
+		&cli.StringFlag{
+			Name:    "backfill-archive",
+			Usage:   "local directory or s3://<bucket>/<prefix> URI of repo CAR snapshots (named <did>.car) to backfill from before falling back to the network",
+			EnvVars: []string{"PALOMAR_BACKFILL_ARCHIVE"},
+		},
+		&cli.DurationFlag{
+			Name:    "backfill-reconcile-interval",
+			Usage:   "if set, periodically compare backfilled repo revs against the Relay's listRepos, and re-backfill any repos which are missing or behind",
+			EnvVars: []string{"PALOMAR_BACKFILL_RECONCILE_INTERVAL"},
+		},
+		&cli.IntFlag{
+			Name:    "backfill-buffer-max-mb",
+			Usage:   "max megabytes of firehose events to buffer in memory for repos being backfilled, beyond which events are buffered on disk (0 for no limit)",
+			Value:   1024,
+			EnvVars: []string{"PALOMAR_BACKFILL_BUFFER_MAX_MB"},
+		},
+		&cli.StringFlag{
+			Name:    "backfill-spill-dir",
+			Usage:   "directory for on-disk backfill event buffers (defaults to system temporary directory)",
+			EnvVars: []string{"PALOMAR_BACKFILL_SPILL_DIR"},
+		},
 		&cli.IntFlag{
 			Name:    "relay-sync-rate-limit",
 			Usage:   "max repo sync (checkout) requests per second to upstream (Relay)",
@@ -164,6 +191,36 @@ var runCmd = &cli.Command{
 			Value:   50_000,
 			EnvVars: []string{"PALOMAR_INDEXING_RATE_LIMIT"},
 		},
+		&cli.IntFlag{
+			Name:    "bulk-max-docs",
+			Usage:   "max number of documents per bulk indexing batch",
+			Value:   1000,
+			EnvVars: []string{"PALOMAR_BULK_MAX_DOCS"},
+		},
+		&cli.IntFlag{
+			Name:    "bulk-max-mb",
+			Usage:   "max size in megabytes of a bulk indexing request; larger batches are split",
+			Value:   10,
+			EnvVars: []string{"PALOMAR_BULK_MAX_MB"},
+		},
+		&cli.DurationFlag{
+			Name:    "bulk-flush-interval",
+			Usage:   "max time documents wait in a partial bulk indexing batch",
+			Value:   5 * time.Second,
+			EnvVars: []string{"PALOMAR_BULK_FLUSH_INTERVAL"},
+		},
+		&cli.IntFlag{
+			Name:    "bulk-max-retries",
+			Usage:   "max attempts for bulk operations which failed with a retryable status, before they are dropped",
+			Value:   5,
+			EnvVars: []string{"PALOMAR_BULK_MAX_RETRIES"},
+		},
+		&cli.IntFlag{
+			Name:    "index-queue-size",
+			Usage:   "capacity of each indexing queue; when full, firehose processing blocks until there is room",
+			Value:   1000,
+			EnvVars: []string{"PALOMAR_INDEX_QUEUE_SIZE"},
+		},
 		&cli.IntFlag{
 			Name:    "plc-rate-limit",
 			Usage:   "max number of requests per second to PLC registry",
@@ -176,6 +233,32 @@ var runCmd = &cli.Command{
 			EnvVars: []string{"PALOMAR_DISCOVER_REPOS"},
 			Value:   false,
 		},
+		&cli.BoolFlag{
+			Name:    "semantic-search",
+			Usage:   "if true, compute post embeddings at index time, and allow hybrid keyword and semantic (kNN) post queries",
+			EnvVars: []string{"PALOMAR_SEMANTIC_SEARCH"},
+		},
+		&cli.StringFlag{
+			Name:    "embedding-url",
+			Usage:   "URL of an OpenAI-compatible embeddings endpoint, for semantic search",
+			EnvVars: []string{"PALOMAR_EMBEDDING_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "embedding-model",
+			Usage:   "embedding model name, passed to the embeddings endpoint",
+			EnvVars: []string{"PALOMAR_EMBEDDING_MODEL"},
+		},
+		&cli.StringFlag{
+			Name:    "embedding-api-key",
+			Usage:   "optional bearer token for the embeddings endpoint",
+			EnvVars: []string{"PALOMAR_EMBEDDING_API_KEY"},
+		},
+		&cli.IntFlag{
+			Name:    "embedding-dimensions",
+			Usage:   "length of vectors returned by the embedding model",
+			Value:   384,
+			EnvVars: []string{"PALOMAR_EMBEDDING_DIMENSIONS"},
+		},
 		&cli.StringFlag{
 			Name:    "pagerank-file",
 			EnvVars: []string{"PAGERANK_FILE"},
@@ -243,11 +326,12 @@ var runCmd = &cli.Command{
 				)),
 			)
 			otel.SetTracerProvider(tp)
+			tracing.InstallPropagator()
 		}
 
-		escli, err := createEsClient(cctx)
+		backend, err := createBackend(cctx)
 		if err != nil {
-			return fmt.Errorf("failed to get elasticsearch: %w", err)
+			return fmt.Errorf("failed to connect to search backend: %w", err)
 		}
 
 		base := identity.BaseDirectory{
@@ -261,13 +345,29 @@ var runCmd = &cli.Command{
 		}
 		dir := identity.NewCacheDirectory(&base, 1_500_000, time.Hour*24, time.Minute*2, time.Minute*5)
 
+		var embedder search.Embedder
+		if cctx.Bool("semantic-search") {
+			e, err := search.NewHTTPEmbedder(search.HTTPEmbedderConfig{
+				URL:        cctx.String("embedding-url"),
+				Model:      cctx.String("embedding-model"),
+				APIKey:     cctx.String("embedding-api-key"),
+				Dimensions: cctx.Int("embedding-dimensions"),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to configure semantic search: %w", err)
+			}
+			embedder = e
+		}
+
 		apiConfig := search.ServerConfig{
 			Logger:       logger,
 			ProfileIndex: cctx.String("es-profile-index"),
 			PostIndex:    cctx.String("es-post-index"),
+			RecordIndex:  cctx.String("es-record-index"),
+			Embedder:     embedder,
 		}
 
-		srv, err := search.NewServer(escli, &dir, apiConfig)
+		srv, err := search.NewServer(backend, &dir, apiConfig)
 		if err != nil {
 			return err
 		}
@@ -280,17 +380,30 @@ var runCmd = &cli.Command{
 			}
 
 			indexerConfig := search.IndexerConfig{
-				RelayHost:           cctx.String("atp-relay-host"),
-				ProfileIndex:        cctx.String("es-profile-index"),
-				PostIndex:           cctx.String("es-post-index"),
-				Logger:              logger,
-				RelaySyncRateLimit:  cctx.Int("relay-sync-rate-limit"),
-				IndexMaxConcurrency: cctx.Int("index-max-concurrency"),
-				DiscoverRepos:       cctx.Bool("discover-repos"),
-				IndexingRateLimit:   cctx.Int("indexing-rate-limit"),
+				RelayHost:                 cctx.String("atp-relay-host"),
+				ProfileIndex:              cctx.String("es-profile-index"),
+				PostIndex:                 cctx.String("es-post-index"),
+				RecordIndex:               cctx.String("es-record-index"),
+				Logger:                    logger,
+				RelaySyncRateLimit:        cctx.Int("relay-sync-rate-limit"),
+				IndexMaxConcurrency:       cctx.Int("index-max-concurrency"),
+				DiscoverRepos:             cctx.Bool("discover-repos"),
+				IndexingRateLimit:         cctx.Int("indexing-rate-limit"),
+				BackfillArchive:           cctx.String("backfill-archive"),
+				BackfillReconcileInterval: cctx.Duration("backfill-reconcile-interval"),
+				BackfillBufferMaxBytes:    int64(cctx.Int("backfill-buffer-max-mb")) * 1024 * 1024,
+				BackfillSpillDir:          cctx.String("backfill-spill-dir"),
+				Embedder:                  embedder,
+				Bulk: search.BulkConfig{
+					MaxDocs:       cctx.Int("bulk-max-docs"),
+					MaxBytes:      cctx.Int("bulk-max-mb") * 1024 * 1024,
+					FlushInterval: cctx.Duration("bulk-flush-interval"),
+					MaxRetries:    cctx.Int("bulk-max-retries"),
+					QueueSize:     cctx.Int("index-queue-size"),
+				},
 			}
 
-			idx, err := search.NewIndexer(db, escli, &dir, indexerConfig)
+			idx, err := search.NewIndexer(db, backend, &dir, indexerConfig)
 			if err != nil {
 				return fmt.Errorf("failed to set up indexer: %w", err)
 			}
@@ -349,31 +462,21 @@ var elasticCheckCmd = &cli.Command{
 	Name:  "elastic-check",
 	Flags: []cli.Flag{},
 	Action: func(cctx *cli.Context) error {
-		escli, err := createEsClient(cctx)
+		// connecting checks the cluster info
+		backend, err := createBackend(cctx)
 		if err != nil {
 			return err
 		}
+		slog.Info("opensearch client connected")
 
-		// NOTE: this extra info check is redundant; createEsClient() already made this call and logged results
-		inf, err := escli.Info()
-		if err != nil {
-			return fmt.Errorf("failed to get info: %w", err)
-		}
-		defer inf.Body.Close()
-		if inf.IsError() {
-			return fmt.Errorf("failed to get info")
-		}
-		slog.Info("opensearch client connected", "client_info", inf)
-
-		resp, err := escli.Indices.Exists([]string{cctx.String("es-profile-index"), cctx.String("es-post-index")})
-		if err != nil {
-			return fmt.Errorf("failed to check index existence: %w", err)
-		}
-		defer resp.Body.Close()
-		if inf.IsError() {
-			return fmt.Errorf("failed to check index existence")
+		ctx := context.Background()
+		for _, index := range []string{cctx.String("es-profile-index"), cctx.String("es-post-index"), cctx.String("es-record-index")} {
+			exists, err := backend.IndexExists(ctx, index)
+			if err != nil {
+				return fmt.Errorf("failed to check index existence: %w", err)
+			}
+			slog.Info("index existence", "index", index, "exists", exists)
 		}
-		slog.Info("index existence", "resp", resp)
 
 		return nil
 
@@ -393,14 +496,14 @@ var searchPostCmd = &cli.Command{
 	Name:  "search-post",
 	Usage: "run a simple query against posts index",
 	Action: func(cctx *cli.Context) error {
-		escli, err := createEsClient(cctx)
+		backend, err := createBackend(cctx)
 		if err != nil {
 			return err
 		}
 		res, err := search.DoSearchPosts(
 			context.Background(),
 			identity.DefaultDirectory(), // TODO: parse PLC arg
-			escli,
+			backend,
 			cctx.String("es-post-index"),
 			&search.PostSearchParams{
 				Query:  strings.Join(cctx.Args().Slice(), " "),
@@ -425,14 +528,14 @@ var searchProfileCmd = &cli.Command{
 		},
 	},
 	Action: func(cctx *cli.Context) error {
-		escli, err := createEsClient(cctx)
+		backend, err := createBackend(cctx)
 		if err != nil {
 			return err
 		}
 		if cctx.Bool("typeahead") {
 			res, err := search.DoSearchProfilesTypeahead(
 				context.Background(),
-				escli,
+				backend,
 				cctx.String("es-profile-index"),
 				&search.ActorSearchParams{
 					Query: strings.Join(cctx.Args().Slice(), " "),
@@ -447,7 +550,7 @@ var searchProfileCmd = &cli.Command{
 			res, err := search.DoSearchProfiles(
 				context.Background(),
 				identity.DefaultDirectory(), // TODO: parse PLC arg
-				escli,
+				backend,
 				cctx.String("es-profile-index"),
 				&search.ActorSearchParams{
 					Query:  strings.Join(cctx.Args().Slice(), " "),
@@ -464,7 +567,7 @@ var searchProfileCmd = &cli.Command{
 	},
 }
 
-func createEsClient(cctx *cli.Context) (*es.Client, error) {
+func createBackend(cctx *cli.Context) (*search.OpenSearchBackend, error) {
 
 	addrs := []string{}
 	if hosts := cctx.String("elastic-hosts"); hosts != "" {
@@ -482,32 +585,11 @@ func createEsClient(cctx *cli.Context) (*es.Client, error) {
 		cert = b
 	}
 
-	insecure := cctx.Bool("elastic-insecure-ssl")
-
-	cfg := es.Config{
-		Addresses: addrs,
-		Username:  cctx.String("elastic-username"),
-		Password:  cctx.String("elastic-password"),
-		CACert:    cert,
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost: 20,
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: insecure,
-			},
-		},
-	}
-
-	escli, err := es.NewClient(cfg)
-	if err != nil {
-		return nil, fmt.Errorf("failed to set up client: %w", err)
-	}
-
-	info, err := escli.Info()
-	if err != nil {
-		return nil, fmt.Errorf("cannot get escli info: %w", err)
-	}
-	defer info.Body.Close()
-	slog.Debug("opensearch client initialized", "info", info)
-
-	return escli, nil
+	return search.NewOpenSearchBackend(search.OpenSearchConfig{
+		Addresses:          addrs,
+		Username:           cctx.String("elastic-username"),
+		Password:           cctx.String("elastic-password"),
+		CACert:             cert,
+		InsecureSkipVerify: cctx.Bool("elastic-insecure-ssl"),
+	})
 }
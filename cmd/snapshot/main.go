@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	slogging "log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/backfill"
+	"github.com/bluesky-social/indigo/bgs"
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/util/ratelimit"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/carlmjohnson/versioninfo"
+	_ "github.com/joho/godotenv/autoload"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	slog    = slogging.New(slogging.NewJSONHandler(os.Stdout, nil))
+	version = versioninfo.Short()
+)
+
+func main() {
+	if err := run(os.Args); err != nil {
+		slog.Error("fatal", "err", err)
+		os.Exit(-1)
+	}
+}
+
+func run(args []string) error {
+
+	app := cli.App{
+		Name:    "snapshot",
+		Usage:   "point-in-time snapshots of repos, for backfilling from an archive",
+		Version: version,
+	}
+
+	outFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:     "out",
+			Usage:    "archive to write the snapshot to: a local directory, or s3://<bucket>/<prefix> (configured with the standard AWS_* environment variables)",
+			Required: true,
+			EnvVars:  []string{"SNAPSHOT_OUT"},
+		},
+		&cli.IntFlag{
+			Name:    "workers",
+			Usage:   "number of repos to export concurrently",
+			Value:   8,
+			EnvVars: []string{"SNAPSHOT_WORKERS"},
+		},
+	}
+
+	app.Commands = []*cli.Command{
+		&cli.Command{
+			Name:   "carstore",
+			Usage:  "snapshot the repos in a relay's carstore (the relay must be archival)",
+			Action: snapshotCarstore,
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:    "db-url",
+					Usage:   "database connection string for BGS database",
+					Value:   "sqlite://./data/bigsky/bgs.sqlite",
+					EnvVars: []string{"DATABASE_URL"},
+				},
+				&cli.StringFlag{
+					Name:    "carstore-db-url",
+					Usage:   "database connection string for carstore database",
+					Value:   "sqlite://./data/bigsky/carstore.sqlite",
+					EnvVars: []string{"CARSTORE_DATABASE_URL"},
+				},
+				&cli.StringFlag{
+					Name:    "data-dir",
+					Usage:   "path of the relay's data directory",
+					Value:   "data/bigsky",
+					EnvVars: []string{"DATA_DIR"},
+				},
+			}, outFlags...),
+		},
+		&cli.Command{
+			Name:   "relay",
+			Usage:  "snapshot repos over the network, using listRepos and getRepo",
+			Action: snapshotRelay,
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:    "relay-host",
+					Usage:   "method, hostname, and port of the relay (or PDS) to snapshot",
+					Value:   "https://bsky.network",
+					EnvVars: []string{"ATP_RELAY_HOST"},
+				},
+				&cli.Float64Flag{
+					Name:    "requests-per-second",
+					Usage:   "limit on listRepos and getRepo requests per second",
+					Value:   10,
+					EnvVars: []string{"SNAPSHOT_REQUESTS_PER_SECOND"},
+				},
+			}, outFlags...),
+		},
+	}
+
+	return app.Run(args)
+}
+
+func signalContext(cctx *cli.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(cctx.Context, syscall.SIGINT, syscall.SIGTERM)
+}
+
+func snapshotCarstore(cctx *cli.Context) error {
+	ctx, cancel := signalContext(cctx)
+	defer cancel()
+
+	sink, err := backfill.ParseArchiveSink(cctx.String("out"))
+	if err != nil {
+		return err
+	}
+	db, err := cliutil.SetupDatabase(cctx.String("db-url"), 10)
+	if err != nil {
+		return err
+	}
+	csdb, err := cliutil.SetupDatabase(cctx.String("carstore-db-url"), 10)
+	if err != nil {
+		return err
+	}
+	cs, err := carstore.NewCarStore(csdb, filepath.Join(cctx.String("data-dir"), "carstore"))
+	if err != nil {
+		return err
+	}
+
+	// same accounts as an "active" sync.listRepos
+	active := fmt.Sprintf("NOT tombstoned AND NOT taken_down AND NOT suspended AND upstream_status NOT IN ('%s', '%s', '%s')",
+		events.AccountStatusDeactivated, events.AccountStatusSuspended, events.AccountStatusTakendown)
+
+	s := &snapshotter{
+		sink:    sink,
+		source:  "carstore",
+		workers: cctx.Int("workers"),
+		list: func(ctx context.Context, out chan<- repoRef) error {
+			var cursor models.Uid
+			for {
+				var users []bgs.User
+				if err := db.WithContext(ctx).Model(&bgs.User{}).Where("id > ?", cursor).Where(active).Order("id").Limit(1000).Find(&users).Error; err != nil {
+					return fmt.Errorf("listing users: %w", err)
+				}
+				if len(users) == 0 {
+					return nil
+				}
+				for _, u := range users {
+					select {
+					case out <- repoRef{Did: u.Did, Uid: u.ID}:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				cursor = users[len(users)-1].ID
+			}
+		},
+		fetch: func(ctx context.Context, ref repoRef, w io.Writer) error {
+			return cs.ReadUserCar(ctx, ref.Uid, "", false, w)
+		},
+	}
+	return s.run(ctx)
+}
+
+func snapshotRelay(cctx *cli.Context) error {
+	ctx, cancel := signalContext(cctx)
+	defer cancel()
+
+	sink, err := backfill.ParseArchiveSink(cctx.String("out"))
+	if err != nil {
+		return err
+	}
+	xrpcc := &xrpc.Client{
+		Host:        cctx.String("relay-host"),
+		Client:      util.RobustHTTPClient(),
+		RateLimiter: ratelimit.NewTokenBucket(cctx.Float64("requests-per-second"), 1),
+	}
+
+	s := &snapshotter{
+		sink:    sink,
+		source:  xrpcc.Host,
+		workers: cctx.Int("workers"),
+		list: func(ctx context.Context, out chan<- repoRef) error {
+			cursor := ""
+			for {
+				resp, err := comatproto.SyncListRepos(ctx, xrpcc, cursor, 1000)
+				if err != nil {
+					return fmt.Errorf("listing repos: %w", err)
+				}
+				for _, r := range resp.Repos {
+					if r.Active != nil && !*r.Active {
+						continue
+					}
+					select {
+					case out <- repoRef{Did: r.Did}:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				if resp.Cursor == nil || *resp.Cursor == "" || len(resp.Repos) == 0 {
+					return nil
+				}
+				cursor = *resp.Cursor
+			}
+		},
+		fetch: func(ctx context.Context, ref repoRef, w io.Writer) error {
+			car, err := comatproto.SyncGetRepo(ctx, xrpcc, ref.Did, "")
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(car)
+			return err
+		},
+	}
+	return s.run(ctx)
+}
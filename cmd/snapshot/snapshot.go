@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/backfill"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repo"
+
+	car "github.com/ipld/go-car"
+)
+
+type repoRef struct {
+	Did string
+	// carstore user ID, when snapshotting a carstore
+	Uid models.Uid
+}
+
+// snapshotter exports every listed repo to an archive, then writes the manifest. Repos which fail to export are logged and left out of the manifest, so a backfill falls back to the network for them.
+type snapshotter struct {
+	sink    backfill.ArchiveSink
+	source  string
+	workers int
+
+	list  func(ctx context.Context, out chan<- repoRef) error
+	fetch func(ctx context.Context, ref repoRef, w io.Writer) error
+
+	lk     sync.Mutex
+	repos  []backfill.ArchiveManifestRepo
+	failed int
+}
+
+func (s *snapshotter) run(ctx context.Context) error {
+	start := time.Now()
+	refs := make(chan repoRef, s.workers)
+	listErr := make(chan error, 1)
+	go func() {
+		defer close(refs)
+		listErr <- s.list(ctx, refs)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(s.workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ref := range refs {
+				s.exportRepo(ctx, ref)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := <-listErr; err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sort.Slice(s.repos, func(i, j int) bool { return s.repos[i].Did < s.repos[j].Did })
+	m := &backfill.ArchiveManifest{
+		CreatedAt: start.UTC(),
+		Source:    s.source,
+		Repos:     s.repos,
+	}
+	if m.Repos == nil {
+		m.Repos = []backfill.ArchiveManifestRepo{}
+	}
+	if err := s.sink.PutManifest(ctx, m); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	slog.Info("snapshot complete", "repos", len(s.repos), "failed", s.failed, "duration", time.Since(start))
+	return nil
+}
+
+func (s *snapshotter) exportRepo(ctx context.Context, ref repoRef) {
+	entry, err := s.export(ctx, ref)
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("failed to export repo", "did", ref.Did, "err", err)
+		}
+		s.failed++
+		return
+	}
+	s.repos = append(s.repos, *entry)
+	if n := len(s.repos); n%1000 == 0 {
+		slog.Info("snapshot progress", "repos", n, "failed", s.failed)
+	}
+}
+
+// export spools the repo's CAR to a temporary file, so it can be checksummed and sized before upload
+func (s *snapshotter) export(ctx context.Context, ref repoRef) (*backfill.ArchiveManifestRepo, error) {
+	f, err := os.CreateTemp("", "snapshot-*.car")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	if err := s.fetch(ctx, ref, io.MultiWriter(f, h)); err != nil {
+		return nil, fmt.Errorf("fetching repo: %w", err)
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	commitCid, commit, err := readCommit(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading repo commit: %w", err)
+	}
+	if commit.Did != ref.Did {
+		return nil, fmt.Errorf("repo commit is for another account: %s", commit.Did)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := s.sink.PutRepo(ctx, ref.Did, size, f); err != nil {
+		return nil, err
+	}
+	return &backfill.ArchiveManifestRepo{
+		Did:    ref.Did,
+		Rev:    commit.Rev,
+		Commit: commitCid,
+		Size:   size,
+		Sha256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// readCommit finds the root commit of a repo CAR
+func readCommit(r io.Reader) (string, *repo.SignedCommit, error) {
+	cr, err := car.NewCarReader(r)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(cr.Header.Roots) != 1 {
+		return "", nil, fmt.Errorf("expected one root, got %d", len(cr.Header.Roots))
+	}
+	root := cr.Header.Roots[0]
+	for {
+		blk, err := cr.Next()
+		if errors.Is(err, io.EOF) {
+			return "", nil, fmt.Errorf("root block %s missing", root)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if !blk.Cid().Equals(root) {
+			continue
+		}
+		var sc repo.SignedCommit
+		if err := sc.UnmarshalCBOR(bytes.NewReader(blk.RawData())); err != nil {
+			return "", nil, err
+		}
+		return root.String(), &sc, nil
+	}
+}
@@ -14,9 +14,9 @@ import (
 	"time"
 
 	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/eventstats"
 	"github.com/bluesky-social/indigo/events/schedulers/sequential"
 	"github.com/bluesky-social/indigo/sonar"
-	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	_ "go.uber.org/automaxprocs"
 
@@ -97,7 +97,12 @@ func Sonar(cctx *cli.Context) error {
 
 	wg := sync.WaitGroup{}
 
-	pool := sequential.NewScheduler(u.Host, s.HandleStreamEvent)
+	// the relay's firehose doesn't say which PDS each event came from
+	stats := eventstats.New(nil)
+	pool := sequential.NewScheduler(u.Host, func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+		stats.HandleEvent("", evt)
+		return s.HandleStreamEvent(ctx, evt)
+	})
 
 	// Start a goroutine to manage the cursor file, saving the current cursor every 5 seconds.
 	go func() {
@@ -156,6 +161,7 @@ func Sonar(cctx *cli.Context) error {
 
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/stats", stats.Handler())
 
 	metricServer := &http.Server{
 		Addr:    fmt.Sprintf(":%d", cctx.Int("port")),
@@ -176,25 +182,23 @@ func Sonar(cctx *cli.Context) error {
 		logger.Info("metrics server shut down successfully")
 	}()
 
+	var cursor *int64
 	if s.Progress.LastSeq >= 0 {
-		u.RawQuery = fmt.Sprintf("cursor=%d", s.Progress.LastSeq)
+		cursor = &s.Progress.LastSeq
 	}
 
-	logger.Info("connecting to WebSocket", "url", u.String())
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), http.Header{
+	// resubscribes from the last event seen whenever the connection fails or goes stale
+	client := events.NewRepoStreamClient(u.String(), pool, cursor)
+	client.Header = http.Header{
 		"User-Agent": []string{"sonar/1.1"},
-	})
-	if err != nil {
-		logger.Info("failed to connect to websocket", "err", err)
-		return err
 	}
-	defer c.Close()
 
+	logger.Info("connecting to WebSocket", "url", u.String())
 	go func() {
 		wg.Add(1)
 		defer wg.Done()
-		err = events.HandleRepoStream(ctx, c, pool)
-		logger.Info("HandleRepoStream returned unexpectedly", "err", err)
+		err := client.Run(ctx)
+		logger.Info("stream client returned", "err", err)
 		cancel()
 	}()
 
@@ -20,7 +20,6 @@ import (
 
 	"github.com/bluesky-social/indigo/api/atproto"
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
-	"github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/did"
 	"github.com/bluesky-social/indigo/events"
@@ -28,12 +27,10 @@ import (
 	"github.com/bluesky-social/indigo/models"
 	"github.com/bluesky-social/indigo/plc"
 	petname "github.com/dustinkirkland/golang-petname"
-	"github.com/icrowley/fake"
 	"github.com/labstack/echo-contrib/pprof"
 	"github.com/urfave/cli/v2"
 	godid "github.com/whyrusleeping/go-did"
 	"golang.org/x/crypto/acme/autocert"
-	"golang.org/x/time/rate"
 
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/repomgr"
@@ -74,7 +71,7 @@ type Server struct {
 
 	// Event Loop Parameters
 	TotalDesiredEvents int
-	MaxEventsPerSecond int
+	Profile            *LoadProfile
 
 	PlaybackFile string
 }
@@ -120,18 +117,13 @@ func main() {
 	app.Commands = []*cli.Command{
 		{
 			Name:   "reload",
-			Usage:  "reload events from a file and write them to an output file",
+			Usage:  "generate events following a load profile and write them to an output file",
 			Action: Reload,
-			Flags: append([]cli.Flag{
-				&cli.IntFlag{
-					Name:    "num-users",
-					Usage:   "number of fake users to produce events for",
-					Value:   100,
-					EnvVars: []string{"NUM_USERS"},
-				},
+			Flags: append(append([]cli.Flag{
+				profileFileFlag,
 				&cli.IntFlag{
 					Name:    "total-events",
-					Usage:   "total number of events to generate",
+					Usage:   "total number of events (commits) to generate",
 					Value:   1_000_000,
 					EnvVars: []string{"TOTAL_EVENTS"},
 				},
@@ -141,26 +133,27 @@ func main() {
 					Value:   "events_out.cbor",
 					EnvVars: []string{"OUTPUT_FILE"},
 				},
-			}, app.Flags...),
+			}, profileFlags...), app.Flags...),
 		},
 		{
 			Name:   "fire",
-			Usage:  "fire events from a file over a websocket",
+			Usage:  "fire events from a file over a websocket, for a relay to ingest",
 			Action: Fire,
-			Flags: append([]cli.Flag{
-				&cli.IntFlag{
-					Name:    "events-per-second",
-					Usage:   "maximum number of events to generate per second",
-					Value:   300,
-					EnvVars: []string{"EVENTS_PER_SECOND"},
-				},
+			Flags: append(append([]cli.Flag{
+				profileFileFlag,
 				&cli.StringFlag{
 					Name:    "input-file",
 					Usage:   "input file for the generated events (if set, will read events from this file instead of generating them)",
 					Value:   "events_in.cbor",
 					EnvVars: []string{"INPUT_FILE"},
 				},
-			}, app.Flags...),
+			}, pacingFlags...), app.Flags...),
+		},
+		{
+			Name:   "pds",
+			Usage:  "create accounts on a PDS and write records to it following a load profile",
+			Action: RunPDS,
+			Flags:  pdsFlags(),
 		},
 	}
 
@@ -202,6 +195,11 @@ func Reload(cctx *cli.Context) error {
 	logger = logger.With("source", "supercollider_main")
 
 	logger.Info("Starting Supercollider in Reload Mode")
+
+	profile, err := loadProfile(cctx)
+	if err != nil {
+		return err
+	}
 	logger.Info(fmt.Sprintf("Generating %d total events and writing them to %s",
 		cctx.Int("total-events"), cctx.String("output-file")))
 
@@ -247,7 +245,7 @@ func Reload(cctx *cli.Context) error {
 
 	// Initialize fake account DIDs
 	dids := []string{}
-	for i := 0; i < cctx.Int("num-users"); i++ {
+	for i := 0; i < profile.NumUsers; i++ {
 		did := fmt.Sprintf("did:web:%s.%s", petname.Generate(4, "-"), cctx.String("hostname"))
 		dids = append(dids, did)
 	}
@@ -264,6 +262,7 @@ func Reload(cctx *cli.Context) error {
 
 		Events:             em,
 		TotalDesiredEvents: cctx.Int("total-events"),
+		Profile:            profile,
 	}
 
 	repoman.SetEventHandler(s.HandleRepoEvent, false)
@@ -301,11 +300,11 @@ func Reload(cctx *cli.Context) error {
 			log.Fatalf("failed to open output file: %+v\n", err)
 		}
 		defer f.Close()
-		since := int64(0)
 
+		// the yolo persister can't play back events, so subscribe to the live stream (before generation starts)
 		evts, cancel, err := s.Events.Subscribe(ctx, "supercollider_file", func(evt *events.XRPCStreamEvent) bool {
 			return true
-		}, &since)
+		}, nil)
 		if err != nil {
 			log.Fatalf("failed to subscribe to events: %+v\n", err)
 		}
@@ -324,7 +323,11 @@ func Reload(cctx *cli.Context) error {
 				}
 				logger.Info("file writer shutdown complete")
 				return
-			case evt := <-evts:
+			case evt, ok := <-evts:
+				if !ok {
+					logger.Error("event stream closed")
+					return
+				}
 				if evt.Error != nil {
 					logger.Error("error in event stream", "err", evt.Error)
 					continue
@@ -422,6 +425,11 @@ func Fire(cctx *cli.Context) error {
 	logger = logger.With("source", "supercollider_main")
 	logger.Info("Starting Supercollider in Fire Mode")
 
+	profile, err := loadProfile(cctx)
+	if err != nil {
+		return err
+	}
+
 	// Try to read the key from disk
 	keyBytes, err := os.ReadFile(cctx.String("key-file"))
 	if err != nil {
@@ -456,12 +464,12 @@ func Fire(cctx *cli.Context) error {
 
 	// Instantiate Server
 	s := &Server{
-		Logger:             logger,
-		EnableSSL:          cctx.Bool("use-ssl"),
-		Host:               cctx.String("hostname"),
-		MultibaseKey:       *vMethod.PublicKeyMultibase,
-		MaxEventsPerSecond: cctx.Int("events-per-second"),
-		PlaybackFile:       cctx.String("input-file"),
+		Logger:       logger,
+		EnableSSL:    cctx.Bool("use-ssl"),
+		Host:         cctx.String("hostname"),
+		MultibaseKey: *vMethod.PublicKeyMultibase,
+		Profile:      profile,
+		PlaybackFile: cctx.String("input-file"),
 	}
 
 	// HTTP Server setup and Middleware Plumbing
@@ -627,18 +635,15 @@ func (s *Server) EventGenerationLoop(ctx context.Context, cancel context.CancelF
 
 	s.Logger.Info("generating events", "count", s.TotalDesiredEvents)
 
+	gen := newRecordGenerator(s.Profile, s.Dids)
 	for i := 0; i < s.TotalDesiredEvents; i++ {
-		text := fake.SentencesN(3)
-		// Trim to 300 chars
-		if len(text) > 300 {
-			text = text[:300]
-		}
-		_, _, err := s.RepoManager.CreateRecord(ctx, models.Uid(i%len(s.Dids)+1), "app.bsky.feed.post", &bsky.FeedPost{
-			CreatedAt: time.Now().Format(util.ISO8601),
-			Text:      text,
-		})
+		author := i % len(s.Dids)
+		writes, err := gen.NextCommit(author)
 		if err != nil {
-			s.Logger.Error("failed to create record", "err", err)
+			log.Fatalf("failed to generate records: %+v\n", err)
+		}
+		if err := s.RepoManager.BatchWrite(ctx, models.Uid(author+1), writes); err != nil {
+			s.Logger.Error("failed to write records", "err", err)
 		} else {
 			eventsGeneratedCounter.Inc()
 		}
@@ -709,7 +714,7 @@ func (s *Server) HandleSubscribeRepos(c echo.Context) error {
 
 	ctx := c.Request().Context()
 
-	limiter := rate.NewLimiter(rate.Limit(s.MaxEventsPerSecond), 10)
+	limiter := newPacer(s.Profile)
 
 	f, err := os.Open(s.PlaybackFile)
 	if err != nil {
@@ -747,7 +752,9 @@ func (s *Server) HandleSubscribeRepos(c echo.Context) error {
 			return err
 		}
 
-		limiter.Wait(ctx)
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
 
 		if err := header.UnmarshalCBOR(f); err != nil {
 			return fmt.Errorf("failed to read header: %w", err)
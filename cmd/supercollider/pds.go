@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	petname "github.com/dustinkirkland/golang-petname"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/urfave/cli/v2"
+)
+
+var pdsWritesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "supercollider_pds_writes_total",
+	Help: "The total number of applyWrites requests sent to the PDS, by result",
+}, []string{"result"})
+
+func pdsFlags() []cli.Flag {
+	flags := []cli.Flag{
+		profileFileFlag,
+		&cli.StringFlag{
+			Name:     "pds-host",
+			Usage:    "URL of the PDS to write to",
+			Required: true,
+			EnvVars:  []string{"SUPERCOLLIDER_PDS_HOST"},
+		},
+		&cli.StringFlag{
+			Name:     "handle-domain",
+			Usage:    "domain suffix for the handles of created accounts (must be one of the PDS's user domains)",
+			Required: true,
+			EnvVars:  []string{"SUPERCOLLIDER_HANDLE_DOMAIN"},
+		},
+		&cli.StringFlag{
+			Name:    "invite-code",
+			Usage:   "invite code to use when creating accounts, if the PDS requires one",
+			EnvVars: []string{"SUPERCOLLIDER_INVITE_CODE"},
+		},
+		&cli.IntFlag{
+			Name:    "total-events",
+			Usage:   "total number of events (commits) to write",
+			Value:   1_000_000,
+			EnvVars: []string{"TOTAL_EVENTS"},
+		},
+		&cli.IntFlag{
+			Name:  "workers",
+			Usage: "number of concurrent requests to the PDS",
+			Value: 8,
+		},
+		&cli.StringFlag{
+			Name:    "metrics-listen",
+			Usage:   "address for the prometheus metrics server",
+			Value:   ":2471",
+			EnvVars: []string{"SUPERCOLLIDER_METRICS_LISTEN"},
+		},
+	}
+	flags = append(flags, profileFlags...)
+	return append(flags, pacingFlags...)
+}
+
+// RunPDS drives a PDS through its XRPC API: it creates the profile's accounts, then writes commits to them at the profile's pace
+func RunPDS(cctx *cli.Context) error {
+	ctx, cancel := context.WithCancel(cctx.Context)
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	}))
+	logger = logger.With("source", "supercollider_main")
+	logger.Info("Starting Supercollider in PDS Mode", "pds", cctx.String("pds-host"))
+
+	profile, err := loadProfile(cctx)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		if err := http.ListenAndServe(cctx.String("metrics-listen"), mux); err != nil {
+			logger.Error("failed to start metrics server", "err", err)
+		}
+	}()
+
+	logger.Info(fmt.Sprintf("creating %d accounts", profile.NumUsers))
+	domain := "." + strings.TrimPrefix(cctx.String("handle-domain"), ".")
+	var inviteCode *string
+	if code := cctx.String("invite-code"); code != "" {
+		inviteCode = &code
+	}
+	accounts := make([]*pdsAccount, 0, profile.NumUsers)
+	dids := make([]string, 0, profile.NumUsers)
+	for i := 0; i < profile.NumUsers; i++ {
+		handle := petname.Generate(3, "-") + domain
+		email := strings.SplitN(handle, ".", 2)[0] + "@example.com"
+		password := petname.Generate(4, "-")
+		c := &xrpc.Client{
+			Client: util.RobustHTTPClient(),
+			Host:   cctx.String("pds-host"),
+		}
+		out, err := comatproto.ServerCreateAccount(ctx, c, &comatproto.ServerCreateAccount_Input{
+			Handle:     handle,
+			Email:      &email,
+			Password:   &password,
+			InviteCode: inviteCode,
+		})
+		if err != nil {
+			return fmt.Errorf("creating account %s: %w", handle, err)
+		}
+		c.Auth = &xrpc.AuthInfo{
+			AccessJwt:  out.AccessJwt,
+			RefreshJwt: out.RefreshJwt,
+			Handle:     out.Handle,
+			Did:        out.Did,
+		}
+		accounts = append(accounts, &pdsAccount{c: c})
+		dids = append(dids, out.Did)
+	}
+
+	logger.Info("writing events", "count", cctx.Int("total-events"))
+	gen := newRecordGenerator(profile, dids)
+	pace := newPacer(profile)
+
+	authors := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < cctx.Int("workers"); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for author := range authors {
+				writes, err := gen.NextCommit(author)
+				if err != nil {
+					logger.Error("failed to generate records", "err", err)
+					continue
+				}
+				err = accounts[author].applyWrites(ctx, &comatproto.RepoApplyWrites_Input{
+					Repo:   dids[author],
+					Writes: writes,
+				})
+				var xerr *xrpc.Error
+				switch {
+				case err == nil:
+					pdsWritesCounter.WithLabelValues("ok").Inc()
+				case errors.As(err, &xerr) && xerr.StatusCode >= 400 && xerr.StatusCode < 500:
+					// expected for injected invalid records
+					pdsWritesCounter.WithLabelValues("rejected").Inc()
+					logger.Debug("write rejected", "did", dids[author], "err", err)
+				default:
+					pdsWritesCounter.WithLabelValues("error").Inc()
+					logger.Warn("write failed", "did", dids[author], "err", err)
+				}
+			}
+		}()
+	}
+
+	// commits are spread evenly over the accounts
+	for i := 0; i < cctx.Int("total-events"); i++ {
+		if err := pace.Wait(ctx); err != nil {
+			break
+		}
+		authors <- i % len(accounts)
+	}
+	close(authors)
+	wg.Wait()
+
+	logger.Info("event writing complete, shutting down")
+	return nil
+}
+
+// An account created on the target PDS. Writes to an account are sent one at a time, like a real client would
+type pdsAccount struct {
+	lk sync.Mutex
+	c  *xrpc.Client
+}
+
+// applyWrites sends the writes for the account, refreshing its session once if the access token has expired
+func (a *pdsAccount) applyWrites(ctx context.Context, input *comatproto.RepoApplyWrites_Input) error {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	c := a.c
+	err := comatproto.RepoApplyWrites(ctx, c, input)
+	var xerr *xrpc.XRPCError
+	if err == nil || !errors.As(err, &xerr) || xerr.ErrStr != "ExpiredToken" {
+		return err
+	}
+
+	refresh := &xrpc.Client{
+		Client: c.Client,
+		Host:   c.Host,
+		Auth: &xrpc.AuthInfo{
+			AccessJwt: c.Auth.RefreshJwt,
+		},
+	}
+	out, err := comatproto.ServerRefreshSession(ctx, refresh)
+	if err != nil {
+		return fmt.Errorf("refreshing session: %w", err)
+	}
+	c.Auth.AccessJwt = out.AccessJwt
+	c.Auth.RefreshJwt = out.RefreshJwt
+	return comatproto.RepoApplyWrites(ctx, c, input)
+}
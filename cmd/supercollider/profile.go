@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	mrand "math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/util"
+
+	"github.com/icrowley/fake"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/urfave/cli/v2"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/time/rate"
+)
+
+// Duration is a time.Duration which is read from JSON as a string, eg "30s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadProfile describes the shape of the traffic generated by supercollider. Profiles can be loaded from a JSON file (see DefaultLoadProfile for the field names), and overridden by individual CLI flags.
+type LoadProfile struct {
+	// Number of fake accounts to spread writes over
+	NumUsers int `json:"numUsers"`
+
+	// Relative weights of the record types written
+	PostWeight   int `json:"postWeight"`
+	LikeWeight   int `json:"likeWeight"`
+	FollowWeight int `json:"followWeight"`
+
+	// Range of the number of records written in a single commit
+	MinOpsPerCommit int `json:"minOpsPerCommit"`
+	MaxOpsPerCommit int `json:"maxOpsPerCommit"`
+
+	// Fraction of records (0 to 1) which are deliberately invalid against their Lexicon schema
+	InvalidRate float64 `json:"invalidRate"`
+
+	// Base rate of events (commits) per second, when pacing is applied
+	EventsPerSecond int `json:"eventsPerSecond"`
+	// For the last BurstDuration of every BurstInterval, the event rate is multiplied by BurstMultiplier. Bursts are disabled if any of these are zero.
+	BurstInterval   Duration `json:"burstInterval"`
+	BurstDuration   Duration `json:"burstDuration"`
+	BurstMultiplier float64  `json:"burstMultiplier"`
+}
+
+// DefaultLoadProfile is a mix loosely resembling network traffic: mostly likes, single-record commits, and no bursts
+var DefaultLoadProfile = LoadProfile{
+	NumUsers:        100,
+	PostWeight:      2,
+	LikeWeight:      6,
+	FollowWeight:    2,
+	MinOpsPerCommit: 1,
+	MaxOpsPerCommit: 1,
+	EventsPerSecond: 300,
+}
+
+func (p *LoadProfile) Validate() error {
+	if p.NumUsers < 1 {
+		return fmt.Errorf("load profile needs at least one user")
+	}
+	if p.PostWeight < 0 || p.LikeWeight < 0 || p.FollowWeight < 0 {
+		return fmt.Errorf("load profile record weights can not be negative")
+	}
+	if p.PostWeight+p.LikeWeight+p.FollowWeight == 0 {
+		return fmt.Errorf("load profile needs at least one non-zero record weight")
+	}
+	if p.MinOpsPerCommit < 1 || p.MaxOpsPerCommit < p.MinOpsPerCommit {
+		return fmt.Errorf("invalid load profile commit size range: %d-%d", p.MinOpsPerCommit, p.MaxOpsPerCommit)
+	}
+	// applyWrites is limited to 200 writes per request
+	if p.MaxOpsPerCommit > 200 {
+		return fmt.Errorf("load profile commit size can not be more than 200 records")
+	}
+	if p.InvalidRate < 0 || p.InvalidRate > 1 {
+		return fmt.Errorf("load profile invalid record rate must be between 0 and 1")
+	}
+	if p.EventsPerSecond < 1 {
+		return fmt.Errorf("load profile needs a positive event rate")
+	}
+	return nil
+}
+
+var profileFileFlag = &cli.StringFlag{
+	Name:    "profile",
+	Usage:   "path to a JSON load profile; individual flags override values from the file",
+	EnvVars: []string{"SUPERCOLLIDER_PROFILE"},
+}
+
+// CLI flags for the record mix, shared by the commands which generate records
+var profileFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:    "num-users",
+		Usage:   "number of fake users to produce events for",
+		Value:   DefaultLoadProfile.NumUsers,
+		EnvVars: []string{"NUM_USERS"},
+	},
+	&cli.IntFlag{
+		Name:  "post-weight",
+		Usage: "relative weight of post records in the mix",
+		Value: DefaultLoadProfile.PostWeight,
+	},
+	&cli.IntFlag{
+		Name:  "like-weight",
+		Usage: "relative weight of like records in the mix",
+		Value: DefaultLoadProfile.LikeWeight,
+	},
+	&cli.IntFlag{
+		Name:  "follow-weight",
+		Usage: "relative weight of follow records in the mix",
+		Value: DefaultLoadProfile.FollowWeight,
+	},
+	&cli.IntFlag{
+		Name:  "min-ops-per-commit",
+		Usage: "minimum number of records in each commit",
+		Value: DefaultLoadProfile.MinOpsPerCommit,
+	},
+	&cli.IntFlag{
+		Name:  "max-ops-per-commit",
+		Usage: "maximum number of records in each commit",
+		Value: DefaultLoadProfile.MaxOpsPerCommit,
+	},
+	&cli.Float64Flag{
+		Name:  "invalid-rate",
+		Usage: "fraction of records (0 to 1) which are deliberately invalid",
+		Value: DefaultLoadProfile.InvalidRate,
+	},
+}
+
+// CLI flags for event pacing, shared by the commands which send events
+var pacingFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:    "events-per-second",
+		Usage:   "maximum number of events to generate per second (outside of bursts)",
+		Value:   DefaultLoadProfile.EventsPerSecond,
+		EnvVars: []string{"EVENTS_PER_SECOND"},
+	},
+	&cli.DurationFlag{
+		Name:  "burst-interval",
+		Usage: "period of the burst pattern (disabled if zero)",
+	},
+	&cli.DurationFlag{
+		Name:  "burst-duration",
+		Usage: "length of the burst at the end of each burst interval",
+	},
+	&cli.Float64Flag{
+		Name:  "burst-multiplier",
+		Usage: "event rate multiplier during bursts",
+	},
+}
+
+// loadProfile builds the LoadProfile for a command: defaults, then the profile file (if any), then any flags which were explicitly set
+func loadProfile(cctx *cli.Context) (*LoadProfile, error) {
+	p := DefaultLoadProfile
+	if path := cctx.String("profile"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading load profile: %w", err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("parsing load profile: %w", err)
+		}
+	}
+
+	intFlags := map[string]*int{
+		"num-users":          &p.NumUsers,
+		"post-weight":        &p.PostWeight,
+		"like-weight":        &p.LikeWeight,
+		"follow-weight":      &p.FollowWeight,
+		"min-ops-per-commit": &p.MinOpsPerCommit,
+		"max-ops-per-commit": &p.MaxOpsPerCommit,
+		"events-per-second":  &p.EventsPerSecond,
+	}
+	for name, v := range intFlags {
+		if cctx.IsSet(name) {
+			*v = cctx.Int(name)
+		}
+	}
+	if cctx.IsSet("invalid-rate") {
+		p.InvalidRate = cctx.Float64("invalid-rate")
+	}
+	if cctx.IsSet("burst-interval") {
+		p.BurstInterval = Duration(cctx.Duration("burst-interval"))
+	}
+	if cctx.IsSet("burst-duration") {
+		p.BurstDuration = Duration(cctx.Duration("burst-duration"))
+	}
+	if cctx.IsSet("burst-multiplier") {
+		p.BurstMultiplier = cctx.Float64("burst-multiplier")
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// pacer rate-limits events to the profile's base rate, raised during periodic bursts. Safe for concurrent use.
+type pacer struct {
+	lim      *rate.Limiter
+	base     float64
+	mult     float64
+	interval time.Duration
+	duration time.Duration
+	start    time.Time
+}
+
+func newPacer(p *LoadProfile) *pacer {
+	return &pacer{
+		lim:      rate.NewLimiter(rate.Limit(p.EventsPerSecond), 10),
+		base:     float64(p.EventsPerSecond),
+		mult:     p.BurstMultiplier,
+		interval: time.Duration(p.BurstInterval),
+		duration: time.Duration(p.BurstDuration),
+		start:    time.Now(),
+	}
+}
+
+func (p *pacer) Wait(ctx context.Context) error {
+	if p.interval > 0 && p.duration > 0 && p.mult > 0 {
+		r := p.base
+		if time.Since(p.start)%p.interval >= p.interval-p.duration {
+			r *= p.mult
+		}
+		if rate.Limit(r) != p.lim.Limit() {
+			p.lim.SetLimit(rate.Limit(r))
+		}
+	}
+	return p.lim.Wait(ctx)
+}
+
+// maximum number of recent posts remembered as like subjects
+const maxRecentPosts = 10_000
+
+// recordGenerator produces batches of writes following a LoadProfile. Likes and follows reference earlier generated posts and accounts. Safe for concurrent use.
+type recordGenerator struct {
+	profile *LoadProfile
+	dids    []string
+
+	lk    sync.Mutex
+	rng   *mrand.Rand
+	posts []*comatproto.RepoStrongRef
+	// index of the next post to overwrite, once posts is full
+	postIdx int
+}
+
+func newRecordGenerator(profile *LoadProfile, dids []string) *recordGenerator {
+	return &recordGenerator{
+		profile: profile,
+		dids:    dids,
+		rng:     mrand.New(mrand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// NextCommit returns the writes for a single commit by the given account (an index in to the generator's DIDs)
+func (g *recordGenerator) NextCommit(author int) ([]*comatproto.RepoApplyWrites_Input_Writes_Elem, error) {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+
+	p := g.profile
+	n := p.MinOpsPerCommit + g.rng.Intn(p.MaxOpsPerCommit-p.MinOpsPerCommit+1)
+	writes := make([]*comatproto.RepoApplyWrites_Input_Writes_Elem, 0, n)
+	for i := 0; i < n; i++ {
+		collection, rec := g.nextRecord(author)
+		rkey := repo.NextTID()
+		if collection == "app.bsky.feed.post" {
+			if err := g.rememberPost(g.dids[author], rkey, rec); err != nil {
+				return nil, err
+			}
+		}
+		writes = append(writes, &comatproto.RepoApplyWrites_Input_Writes_Elem{
+			RepoApplyWrites_Create: &comatproto.RepoApplyWrites_Create{
+				Collection: collection,
+				Rkey:       &rkey,
+				Value:      &lexutil.LexiconTypeDecoder{Val: rec},
+			},
+		})
+	}
+	return writes, nil
+}
+
+func (g *recordGenerator) nextRecord(author int) (string, cbg.CBORMarshaler) {
+	p := g.profile
+	now := time.Now().Format(util.ISO8601)
+	invalid := p.InvalidRate > 0 && g.rng.Float64() < p.InvalidRate
+
+	pick := g.rng.Intn(p.PostWeight + p.LikeWeight + p.FollowWeight)
+	switch {
+	case pick >= p.PostWeight && pick < p.PostWeight+p.LikeWeight && len(g.posts) > 0:
+		if invalid {
+			// missing subject
+			return "app.bsky.feed.like", &bsky.FeedLike{CreatedAt: now}
+		}
+		return "app.bsky.feed.like", &bsky.FeedLike{
+			CreatedAt: now,
+			Subject:   g.posts[g.rng.Intn(len(g.posts))],
+		}
+	case pick >= p.PostWeight+p.LikeWeight:
+		if invalid {
+			return "app.bsky.graph.follow", &bsky.GraphFollow{CreatedAt: now, Subject: "not-a-did"}
+		}
+		// anyone but the author, if possible
+		subject := g.rng.Intn(len(g.dids))
+		if len(g.dids) > 1 {
+			subject = g.rng.Intn(len(g.dids) - 1)
+			if subject >= author {
+				subject++
+			}
+		}
+		return "app.bsky.graph.follow", &bsky.GraphFollow{CreatedAt: now, Subject: g.dids[subject]}
+	default:
+		// posts, and likes before there is anything to like
+		text := fake.SentencesN(3)
+		if invalid {
+			// over the length limit, and missing createdAt
+			return "app.bsky.feed.post", &bsky.FeedPost{Text: strings.Repeat(text, 3000/len(text)+1)}
+		}
+		// Trim to 300 chars
+		if len(text) > 300 {
+			text = text[:300]
+		}
+		return "app.bsky.feed.post", &bsky.FeedPost{CreatedAt: now, Text: text}
+	}
+}
+
+func (g *recordGenerator) rememberPost(author, rkey string, rec cbg.CBORMarshaler) error {
+	buf := new(bytes.Buffer)
+	if err := rec.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	c, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: multihash.SHA2_256}.Sum(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	ref := &comatproto.RepoStrongRef{
+		Uri: "at://" + author + "/app.bsky.feed.post/" + rkey,
+		Cid: c.String(),
+	}
+	if len(g.posts) < maxRecentPosts {
+		g.posts = append(g.posts, ref)
+	} else {
+		g.posts[g.postIdx] = ref
+		g.postIdx = (g.postIdx + 1) % maxRecentPosts
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package events
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// accountLabels are the account label values which relays and other trusted labelers use for moderation actions, and the statuses they mean, in order of precedence
+var accountLabels = []struct {
+	val    string
+	status string
+}{
+	{"!takedown", AccountStatusTakendown},
+	{"!suspend", AccountStatusSuspended},
+}
+
+// AccountStatusTracker follows the status of accounts, so services consuming a firehose can comply with takedowns, suspensions, and deletions: it tracks #account events, and optionally account labels ("!takedown" and "!suspend") from trusted labelers such as the relay. An account is inactive if either signal says so.
+//
+// Accounts not seen are assumed to be active. Statuses are only kept in memory; consumers which need them across restarts should persist them in OnInactive and OnActive, and restore them with SetStatus.
+type AccountStatusTracker struct {
+	// Called when an account stops being active, or changes from one inactive status to another, with its new status (eg, AccountStatusTakendown). Content from the account should no longer be served, and for AccountStatusDeleted, it should be deleted
+	OnInactive func(ctx context.Context, did string, status string) error
+	// Called when a previously inactive account becomes active again. Its content may be served again, and should be re-fetched from the account's repo if it was deleted
+	OnActive func(ctx context.Context, did string) error
+	// DIDs of labelers whose account labels are acted on. Labels from other sources are ignored, so labels should come from a stream checked with a labels.Verifier
+	TrustedLabelers []string
+
+	// held while handling an event, so hooks are called in order
+	handleLk sync.Mutex
+
+	lk sync.RWMutex
+	// inactive statuses from #account events
+	statuses map[string]string
+	// active moderation label values, by account
+	labels map[string]map[string]bool
+}
+
+// Status returns an account's current status: from its latest #account event if that was inactive, otherwise from any trusted takedown or suspension labels, otherwise AccountStatusActive
+func (t *AccountStatusTracker) Status(did string) string {
+	t.lk.RLock()
+	defer t.lk.RUnlock()
+
+	return statusFrom(t.statuses[did], t.labels[did])
+}
+
+func statusFrom(status string, labels map[string]bool) string {
+	if status != "" {
+		return status
+	}
+	for _, al := range accountLabels {
+		if labels[al.val] {
+			return al.status
+		}
+	}
+	return AccountStatusActive
+}
+
+// IsActive returns true if content from the account may be served
+func (t *AccountStatusTracker) IsActive(did string) bool {
+	return t.Status(did) == AccountStatusActive
+}
+
+// SetStatus records an account's status from an #account event without calling the hooks, to restore statuses persisted before a restart
+func (t *AccountStatusTracker) SetStatus(did string, status string) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	if t.statuses == nil {
+		t.statuses = make(map[string]string)
+	}
+	if status == AccountStatusActive || status == "" {
+		delete(t.statuses, did)
+	} else {
+		t.statuses[did] = status
+	}
+}
+
+// update applies a change to an account's state, calling the hooks if it changes the account's status. If a hook fails, the change is not applied, so it is retried when the event is redelivered
+func (t *AccountStatusTracker) update(ctx context.Context, did string, change func(status string, labels map[string]bool) (string, map[string]bool)) error {
+	t.lk.RLock()
+	prevStatus, prevLabels := t.statuses[did], t.labels[did]
+	before := statusFrom(prevStatus, prevLabels)
+	labels := make(map[string]bool, len(prevLabels))
+	for k, v := range prevLabels {
+		labels[k] = v
+	}
+	t.lk.RUnlock()
+
+	status, labels := change(prevStatus, labels)
+	after := statusFrom(status, labels)
+
+	switch {
+	case before == after:
+	case after == AccountStatusActive:
+		if t.OnActive != nil {
+			if err := t.OnActive(ctx, did); err != nil {
+				return err
+			}
+		}
+	default:
+		if t.OnInactive != nil {
+			if err := t.OnInactive(ctx, did, after); err != nil {
+				return err
+			}
+		}
+	}
+
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	if t.statuses == nil {
+		t.statuses = make(map[string]string)
+	}
+	if t.labels == nil {
+		t.labels = make(map[string]map[string]bool)
+	}
+	if status == "" {
+		delete(t.statuses, did)
+	} else {
+		t.statuses[did] = status
+	}
+	if len(labels) == 0 {
+		delete(t.labels, did)
+	} else {
+		t.labels[did] = labels
+	}
+	return nil
+}
+
+// HandleAccount updates an account's status from an #account event
+func (t *AccountStatusTracker) HandleAccount(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Account) error {
+	t.handleLk.Lock()
+	defer t.handleLk.Unlock()
+
+	return t.update(ctx, evt.Did, func(_ string, labels map[string]bool) (string, map[string]bool) {
+		if evt.Active {
+			return "", labels
+		}
+		if evt.Status == nil || *evt.Status == "" {
+			// inactive without a reason given
+			return AccountStatusDeactivated, labels
+		}
+		return *evt.Status, labels
+	})
+}
+
+// HandleLabels updates account statuses from the takedown and suspension labels (and their negations) of trusted labelers. Labels on records, expired labels, and other label values are ignored. It can be used as the HandleLabels of a labels.Consumer.
+func (t *AccountStatusTracker) HandleLabels(ctx context.Context, seq int64, labels []*comatproto.LabelDefs_Label) error {
+	t.handleLk.Lock()
+	defer t.handleLk.Unlock()
+
+	for _, l := range labels {
+		if !isAccountLabel(l.Val) || !t.trusted(l.Src) || !strings.HasPrefix(l.Uri, "did:") {
+			continue
+		}
+		applied := l.Neg == nil || !*l.Neg
+		if applied && l.Exp != nil {
+			if exp, err := syntax.ParseDatetimeLenient(*l.Exp); err == nil && exp.Time().Before(time.Now()) {
+				applied = false
+			}
+		}
+		if err := t.update(ctx, l.Uri, func(status string, vals map[string]bool) (string, map[string]bool) {
+			if applied {
+				vals[l.Val] = true
+			} else {
+				delete(vals, l.Val)
+			}
+			return status, vals
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isAccountLabel(val string) bool {
+	for _, al := range accountLabels {
+		if al.val == val {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *AccountStatusTracker) trusted(src string) bool {
+	for _, did := range t.TrustedLabelers {
+		if did == src {
+			return true
+		}
+	}
+	return false
+}
+
+// Callbacks wraps a consumer's stream callbacks: #account events update the tracker (before being passed on), and commits and syncs from accounts which aren't active are dropped, in case the upstream still sends them
+func (t *AccountStatusTracker) Callbacks(ctx context.Context, next *RepoStreamCallbacks) *RepoStreamCallbacks {
+	wrapped := *next
+	wrapped.RepoAccount = func(evt *comatproto.SyncSubscribeRepos_Account) error {
+		if err := t.HandleAccount(ctx, evt); err != nil {
+			return err
+		}
+		if next.RepoAccount != nil {
+			return next.RepoAccount(evt)
+		}
+		return nil
+	}
+	if next.RepoCommit != nil {
+		wrapped.RepoCommit = func(evt *comatproto.SyncSubscribeRepos_Commit) error {
+			if !t.IsActive(evt.Repo) {
+				return nil
+			}
+			return next.RepoCommit(evt)
+		}
+	}
+	if next.RepoSync != nil {
+		wrapped.RepoSync = func(evt *comatproto.SyncSubscribeRepos_Sync) error {
+			if !t.IsActive(evt.Did) {
+				return nil
+			}
+			return next.RepoSync(evt)
+		}
+	}
+	return &wrapped
+}
@@ -0,0 +1,174 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+)
+
+func accountEvent(did string, status string) *comatproto.SyncSubscribeRepos_Account {
+	evt := &comatproto.SyncSubscribeRepos_Account{Did: did, Active: status == events.AccountStatusActive}
+	if !evt.Active {
+		evt.Status = &status
+	}
+	return evt
+}
+
+func accountLabel(src, did, val string, neg bool) *comatproto.LabelDefs_Label {
+	l := &comatproto.LabelDefs_Label{Src: src, Uri: did, Val: val, Cts: "2024-01-01T00:00:00.000Z"}
+	if neg {
+		l.Neg = &neg
+	}
+	return l
+}
+
+func TestAccountStatusTracker(t *testing.T) {
+	ctx := context.TODO()
+	var calls []string
+	tr := &events.AccountStatusTracker{
+		OnInactive: func(ctx context.Context, did string, status string) error {
+			calls = append(calls, fmt.Sprintf("inactive %s %s", did, status))
+			return nil
+		},
+		OnActive: func(ctx context.Context, did string) error {
+			calls = append(calls, "active "+did)
+			return nil
+		},
+		TrustedLabelers: []string{"did:plc:relay"},
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		if fmt.Sprint(calls) != fmt.Sprint(want) {
+			t.Fatalf("expected hook calls %v, got %v", want, calls)
+		}
+		calls = nil
+	}
+
+	if !tr.IsActive("did:plc:alice") {
+		t.Fatal("unseen accounts should be active")
+	}
+
+	for _, evt := range []*comatproto.SyncSubscribeRepos_Account{
+		accountEvent("did:plc:alice", events.AccountStatusActive),
+		accountEvent("did:plc:alice", events.AccountStatusTakendown),
+		accountEvent("did:plc:alice", events.AccountStatusTakendown),
+		accountEvent("did:plc:alice", events.AccountStatusDeleted),
+		accountEvent("did:plc:alice", events.AccountStatusActive),
+	} {
+		if err := tr.HandleAccount(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect("inactive did:plc:alice takendown", "inactive did:plc:alice deleted", "active did:plc:alice")
+
+	// labels from untrusted sources, on records, and with other values are ignored
+	if err := tr.HandleLabels(ctx, 1, []*comatproto.LabelDefs_Label{
+		accountLabel("did:plc:mallory", "did:plc:bob", "!takedown", false),
+		accountLabel("did:plc:relay", "at://did:plc:bob/app.bsky.feed.post/abc", "!takedown", false),
+		accountLabel("did:plc:relay", "did:plc:bob", "spam", false),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expect()
+
+	// the account is inactive while either signal says so
+	if err := tr.HandleLabels(ctx, 2, []*comatproto.LabelDefs_Label{
+		accountLabel("did:plc:relay", "did:plc:bob", "!suspend", false),
+		accountLabel("did:plc:relay", "did:plc:bob", "!takedown", false),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.HandleAccount(ctx, accountEvent("did:plc:bob", events.AccountStatusDeactivated)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.HandleAccount(ctx, accountEvent("did:plc:bob", events.AccountStatusActive)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.HandleLabels(ctx, 3, []*comatproto.LabelDefs_Label{
+		accountLabel("did:plc:relay", "did:plc:bob", "!takedown", true),
+		accountLabel("did:plc:relay", "did:plc:bob", "!suspend", true),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expect("inactive did:plc:bob suspended", "inactive did:plc:bob takendown", "inactive did:plc:bob deactivated", "inactive did:plc:bob takendown", "inactive did:plc:bob suspended", "active did:plc:bob")
+
+	// expired labels don't apply
+	expired := accountLabel("did:plc:relay", "did:plc:bob", "!takedown", false)
+	exp := "2000-01-01T00:00:00.000Z"
+	expired.Exp = &exp
+	if err := tr.HandleLabels(ctx, 4, []*comatproto.LabelDefs_Label{expired}); err != nil {
+		t.Fatal(err)
+	}
+	expect()
+
+	// restored statuses don't call the hooks
+	tr.SetStatus("did:plc:carol", events.AccountStatusTakendown)
+	expect()
+	if tr.Status("did:plc:carol") != events.AccountStatusTakendown {
+		t.Fatal("expected restored status")
+	}
+}
+
+func TestAccountStatusTrackerHookFailure(t *testing.T) {
+	ctx := context.TODO()
+	fail := errors.New("fail")
+	failing := true
+	tr := &events.AccountStatusTracker{
+		OnInactive: func(ctx context.Context, did string, status string) error {
+			if failing {
+				return fail
+			}
+			return nil
+		},
+	}
+
+	evt := accountEvent("did:plc:alice", events.AccountStatusTakendown)
+	if err := tr.HandleAccount(ctx, evt); !errors.Is(err, fail) {
+		t.Fatalf("expected hook error, got %v", err)
+	}
+	if !tr.IsActive("did:plc:alice") {
+		t.Fatal("status should not change when the hook fails")
+	}
+	failing = false
+	if err := tr.HandleAccount(ctx, evt); err != nil {
+		t.Fatal(err)
+	}
+	if tr.IsActive("did:plc:alice") {
+		t.Fatal("expected account to be taken down")
+	}
+}
+
+func TestAccountStatusTrackerCallbacks(t *testing.T) {
+	ctx := context.TODO()
+	tr := &events.AccountStatusTracker{}
+
+	var commits, accounts int
+	cbs := tr.Callbacks(ctx, &events.RepoStreamCallbacks{
+		RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
+			commits++
+			return nil
+		},
+		RepoAccount: func(evt *comatproto.SyncSubscribeRepos_Account) error {
+			accounts++
+			return nil
+		},
+	})
+
+	for _, evt := range []*events.XRPCStreamEvent{
+		{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{Repo: "did:plc:alice"}},
+		{RepoAccount: accountEvent("did:plc:alice", events.AccountStatusTakendown)},
+		{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{Repo: "did:plc:alice"}},
+		{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{Repo: "did:plc:bob"}},
+	} {
+		if err := cbs.EventHandler(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if commits != 2 || accounts != 1 {
+		t.Fatalf("expected commits from the taken down account to be dropped, got %d commits and %d account events", commits, accounts)
+	}
+}
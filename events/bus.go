@@ -0,0 +1,241 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/models"
+)
+
+// Bus carries events between the stages of an EventManager: from ingest (AddEvent) to persistence, and from persistence to fanout (Subscribe). LocalBus connects the stages within a process; other implementations (eg RedisBus) let each stage run in its own processes.
+type Bus interface {
+	// Publish sends an event to the bus's subscribers
+	Publish(ctx context.Context, evt *XRPCStreamEvent) error
+	// Subscribe calls handle with each event published to the bus, in order, until the bus is closed. Buses which cross processes may deliver an event again if handle returns an error
+	Subscribe(handle func(context.Context, *XRPCStreamEvent) error) error
+	// Close stops delivering events to subscribers
+	Close() error
+}
+
+var ErrBusClosed = errors.New("event bus closed")
+
+// ErrNotServing is returned by Subscribe on an EventManager which doesn't fan out events in this process
+var ErrNotServing = errors.New("events are not served by this process")
+
+// LocalBus is a Bus within a process. Publish hands the event to each subscriber in turn, returning the first error
+type LocalBus struct {
+	lk     sync.RWMutex
+	subs   []func(context.Context, *XRPCStreamEvent) error
+	closed bool
+}
+
+func NewLocalBus() *LocalBus {
+	return &LocalBus{}
+}
+
+func (b *LocalBus) Publish(ctx context.Context, evt *XRPCStreamEvent) error {
+	b.lk.RLock()
+	defer b.lk.RUnlock()
+	if b.closed {
+		return ErrBusClosed
+	}
+	for _, handle := range b.subs {
+		if err := handle(ctx, evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *LocalBus) Subscribe(handle func(context.Context, *XRPCStreamEvent) error) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	if b.closed {
+		return ErrBusClosed
+	}
+	b.subs = append(b.subs, handle)
+	return nil
+}
+
+func (b *LocalBus) Close() error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	b.closed = true
+	b.subs = nil
+	return nil
+}
+
+// BusConfig sets out which stages of an EventManager run in this process, and the buses between them
+type BusConfig struct {
+	// Carries events from AddEvent to persistence
+	Ingest Bus
+	// Carries persisted (sequenced) events to subscribers
+	Fanout Bus
+	// Persist events from the ingest bus in this process, publishing them to the fanout bus. Requires a persister
+	Persist bool
+	// Serve subscribers in this process, from the fanout bus. Without Persist, the persister (if any) is only used to replay events for subscribers with a cursor, so it must read the storage the persisting process writes to (eg a shared database)
+	Serve bool
+}
+
+// LocalBuses runs every stage of an EventManager in this process, as NewEventManager does
+func LocalBuses() *BusConfig {
+	return &BusConfig{
+		Ingest:  NewLocalBus(),
+		Fanout:  NewLocalBus(),
+		Persist: true,
+		Serve:   true,
+	}
+}
+
+// NewEventManagerWithBuses makes an EventManager running the stages given in the bus configuration. The persister may be nil if events are neither persisted nor replayed in this process.
+func NewEventManagerWithBuses(persister EventPersistence, buses *BusConfig) (*EventManager, error) {
+	if buses.Ingest == nil || buses.Fanout == nil {
+		return nil, fmt.Errorf("event manager needs both an ingest and a fanout bus")
+	}
+	if buses.Persist && persister == nil {
+		return nil, fmt.Errorf("persisting events requires a persister")
+	}
+	if persister == nil {
+		persister = remotePersistence{}
+	}
+
+	em := &EventManager{
+		bufferSize:          16 << 10,
+		crossoverBufferSize: 512,
+		persister:           persister,
+		buses:               *buses,
+	}
+
+	if buses.Persist {
+		persister.SetEventBroadcaster(em.publishPersisted)
+		if err := buses.Ingest.Subscribe(em.persistEvent); err != nil {
+			return nil, fmt.Errorf("subscribing to ingest bus: %w", err)
+		}
+	}
+	if buses.Serve {
+		if err := buses.Fanout.Subscribe(func(ctx context.Context, evt *XRPCStreamEvent) error {
+			em.broadcastEvent(evt)
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("subscribing to fanout bus: %w", err)
+		}
+	}
+
+	return em, nil
+}
+
+// LastSeq returns the highest sequence number persisted by this process since it started, or 0 if it hasn't persisted any events
+func (em *EventManager) LastSeq() int64 {
+	return em.lastSeq.Load()
+}
+
+// Serves reports whether this event manager serves subscribers, or only passes events on to other processes
+func (em *EventManager) Serves() bool {
+	return em.buses.Serve
+}
+
+func (em *EventManager) persistEvent(ctx context.Context, evt *XRPCStreamEvent) error {
+	em.persistAndSendEvent(ctx, evt)
+	return nil
+}
+
+// publishPersisted is the persister's broadcaster, passing sequenced events on to fanout
+func (em *EventManager) publishPersisted(evt *XRPCStreamEvent) {
+	seq := sequenceForEvent(evt)
+	for {
+		last := em.lastSeq.Load()
+		if seq <= last || em.lastSeq.CompareAndSwap(last, seq) {
+			break
+		}
+	}
+	if err := em.buses.Fanout.Publish(context.Background(), evt); err != nil {
+		log.Error("failed to publish persisted event", "err", err, "seq", sequenceForEvent(evt))
+	}
+}
+
+var errRemotePersistence = errors.New("events are persisted by another process")
+
+// remotePersistence stands in for the persister of an EventManager whose events are persisted in another process
+type remotePersistence struct{}
+
+func (remotePersistence) Persist(ctx context.Context, e *XRPCStreamEvent) error {
+	return errRemotePersistence
+}
+
+func (remotePersistence) Playback(ctx context.Context, since int64, cb func(*XRPCStreamEvent) error) error {
+	return errRemotePersistence
+}
+
+// TakeDownRepo can't reach the persisting process (which may not even know the account by the same uid), so the account's events must be taken down there
+func (remotePersistence) TakeDownRepo(ctx context.Context, usr models.Uid) error {
+	log.Warn("not taking down persisted events for account, they are persisted by another process", "uid", usr)
+	return nil
+}
+
+func (remotePersistence) Flush(context.Context) error {
+	return nil
+}
+
+func (remotePersistence) Shutdown(context.Context) error {
+	return nil
+}
+
+func (remotePersistence) SetEventBroadcaster(func(*XRPCStreamEvent)) {}
+
+// DecodeEvent reads an event framed as it is on the firehose (and by XRPCStreamEvent.Serialize): a CBOR header followed by the CBOR event body
+func DecodeEvent(r io.Reader) (*XRPCStreamEvent, error) {
+	var header EventHeader
+	if err := header.UnmarshalCBOR(r); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	evt := &XRPCStreamEvent{PrivSpanContext: header.spanContext()}
+	if header.Op == EvtKindErrorFrame {
+		evt.Error = &ErrorFrame{}
+		if err := evt.Error.UnmarshalCBOR(r); err != nil {
+			return nil, fmt.Errorf("reading error frame: %w", err)
+		}
+		return evt, nil
+	}
+	if header.Op != EvtKindMessage {
+		return nil, fmt.Errorf("unrecognized event stream op %d", header.Op)
+	}
+
+	var err error
+	switch header.MsgType {
+	case "#commit":
+		evt.RepoCommit = &comatproto.SyncSubscribeRepos_Commit{}
+		err = evt.RepoCommit.UnmarshalCBOR(r)
+	case "#handle":
+		evt.RepoHandle = &comatproto.SyncSubscribeRepos_Handle{}
+		err = evt.RepoHandle.UnmarshalCBOR(r)
+	case "#identity":
+		evt.RepoIdentity = &comatproto.SyncSubscribeRepos_Identity{}
+		err = evt.RepoIdentity.UnmarshalCBOR(r)
+	case "#account":
+		evt.RepoAccount = &comatproto.SyncSubscribeRepos_Account{}
+		err = evt.RepoAccount.UnmarshalCBOR(r)
+	case "#sync":
+		evt.RepoSync = &comatproto.SyncSubscribeRepos_Sync{}
+		err = evt.RepoSync.UnmarshalCBOR(r)
+	case "#info":
+		evt.RepoInfo = &comatproto.SyncSubscribeRepos_Info{}
+		err = evt.RepoInfo.UnmarshalCBOR(r)
+	case "#migrate":
+		evt.RepoMigrate = &comatproto.SyncSubscribeRepos_Migrate{}
+		err = evt.RepoMigrate.UnmarshalCBOR(r)
+	case "#tombstone":
+		evt.RepoTombstone = &comatproto.SyncSubscribeRepos_Tombstone{}
+		err = evt.RepoTombstone.UnmarshalCBOR(r)
+	default:
+		return nil, fmt.Errorf("unrecognized event type %q", header.MsgType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s event: %w", header.MsgType, err)
+	}
+	return evt, nil
+}
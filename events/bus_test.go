@@ -0,0 +1,133 @@
+package events_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// wireBus is a LocalBus which passes events through their wire encoding, as a bus between processes does
+type wireBus struct {
+	*events.LocalBus
+}
+
+func (b wireBus) Publish(ctx context.Context, evt *events.XRPCStreamEvent) error {
+	var buf bytes.Buffer
+	if err := evt.Serialize(&buf); err != nil {
+		return err
+	}
+	decoded, err := events.DecodeEvent(&buf)
+	if err != nil {
+		return err
+	}
+	return b.LocalBus.Publish(ctx, decoded)
+}
+
+func TestSplitEventManager(t *testing.T) {
+	ctx := context.TODO()
+	ingest := wireBus{events.NewLocalBus()}
+	fanout := wireBus{events.NewLocalBus()}
+
+	ingester, err := events.NewEventManagerWithBuses(nil, &events.BusConfig{Ingest: ingest, Fanout: fanout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := events.NewEventManagerWithBuses(nil, &events.BusConfig{Ingest: ingest, Fanout: fanout, Persist: true}); err == nil {
+		t.Fatal("expected persisting without a persister to fail")
+	}
+	persister := events.NewMemPersister()
+	if _, err := events.NewEventManagerWithBuses(persister, &events.BusConfig{Ingest: ingest, Fanout: fanout, Persist: true}); err != nil {
+		t.Fatal(err)
+	}
+	server, err := events.NewEventManagerWithBuses(nil, &events.BusConfig{Ingest: ingest, Fanout: fanout, Serve: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := ingester.Subscribe(ctx, "test", nil, nil); !errors.Is(err, events.ErrNotServing) {
+		t.Fatalf("expected ingest-only event manager not to serve, got %v", err)
+	}
+	evts, cleanup, err := server.Subscribe(ctx, "test", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	for _, did := range []string{"did:plc:aaa", "did:plc:bbb"} {
+		if err := ingester.AddEvent(ctx, &events.XRPCStreamEvent{
+			RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: did, Time: "2024-01-01T00:00:00Z"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// events are sequenced by the persisting stage
+	for i, did := range []string{"did:plc:aaa", "did:plc:bbb"} {
+		select {
+		case evt := <-evts:
+			if evt.RepoIdentity == nil || evt.RepoIdentity.Did != did || evt.RepoIdentity.Seq != int64(i+1) {
+				t.Fatalf("unexpected event %d: %+v", i, evt.RepoIdentity)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+	}
+
+	if err := ingester.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ingester.AddEvent(ctx, &events.XRPCStreamEvent{
+		RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:plc:ccc"},
+	}); !errors.Is(err, events.ErrBusClosed) {
+		t.Fatalf("expected events after shutdown to be refused, got %v", err)
+	}
+}
+
+func TestRedisBus(t *testing.T) {
+	t.Skip("live test, need redis running locally")
+	ctx := context.TODO()
+
+	opts, err := redis.ParseURL("redis://localhost:6379/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redis.NewClient(opts)
+	defer client.Close()
+	stream := "test-events-" + time.Now().Format("150405.000")
+	defer client.Del(ctx, stream)
+
+	pub := events.NewRedisBus(client, stream, nil)
+	subOpts := events.DefaultRedisBusOptions()
+	subOpts.Group = "test"
+	sub := events.NewRedisBus(client, stream, subOpts)
+	defer sub.Close()
+
+	got := make(chan *events.XRPCStreamEvent, 1)
+	if err := sub.Subscribe(func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+		got <- evt
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pub.Publish(ctx, &events.XRPCStreamEvent{
+		RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:plc:aaa", Seq: 7},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case evt := <-got:
+		if evt.RepoIdentity == nil || evt.RepoIdentity.Did != "did:plc:aaa" || evt.RepoIdentity.Seq != 7 {
+			t.Fatalf("unexpected event: %+v", evt)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+}
@@ -25,8 +25,13 @@ func (t *EventHeader) MarshalCBOR(w io.Writer) error {
 	}
 
 	cw := cbg.NewCborWriter(w)
+	fieldCount := 3
 
-	if _, err := cw.Write([]byte{162}); err != nil {
+	if t.TraceParent == "" {
+		fieldCount--
+	}
+
+	if _, err := cw.Write(cbg.CborEncodeMajorType(cbg.MajMap, uint64(fieldCount))); err != nil {
 		return err
 	}
 
@@ -75,6 +80,31 @@ func (t *EventHeader) MarshalCBOR(w io.Writer) error {
 		}
 	}
 
+	// t.TraceParent (string) (string)
+	if t.TraceParent != "" {
+
+		if len("traceparent") > 1000000 {
+			return xerrors.Errorf("Value in field \"traceparent\" was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("traceparent"))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string("traceparent")); err != nil {
+			return err
+		}
+
+		if len(t.TraceParent) > 1000000 {
+			return xerrors.Errorf("Value in field t.TraceParent was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.TraceParent))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string(t.TraceParent)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -153,6 +183,17 @@ func (t *EventHeader) UnmarshalCBOR(r io.Reader) (err error) {
 
 				t.Op = int64(extraI)
 			}
+			// t.TraceParent (string) (string)
+		case "traceparent":
+
+			{
+				sval, err := cbg.ReadStringWithMax(cr, 1000000)
+				if err != nil {
+					return err
+				}
+
+				t.TraceParent = string(sval)
+			}
 
 		default:
 			// Field doesn't exist on this type, so ignore it
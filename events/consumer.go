@@ -2,16 +2,18 @@ package events
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"time"
 
-	"github.com/RussellLuo/slidingwindow"
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/util/ratelimit"
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RepoStreamCallbacks struct {
@@ -19,6 +21,7 @@ type RepoStreamCallbacks struct {
 	RepoHandle    func(evt *comatproto.SyncSubscribeRepos_Handle) error
 	RepoIdentity  func(evt *comatproto.SyncSubscribeRepos_Identity) error
 	RepoAccount   func(evt *comatproto.SyncSubscribeRepos_Account) error
+	RepoSync      func(evt *comatproto.SyncSubscribeRepos_Sync) error
 	RepoInfo      func(evt *comatproto.SyncSubscribeRepos_Info) error
 	RepoMigrate   func(evt *comatproto.SyncSubscribeRepos_Migrate) error
 	RepoTombstone func(evt *comatproto.SyncSubscribeRepos_Tombstone) error
@@ -41,6 +44,8 @@ func (rsc *RepoStreamCallbacks) EventHandler(ctx context.Context, xev *XRPCStrea
 		return rsc.RepoIdentity(xev.RepoIdentity)
 	case xev.RepoAccount != nil && rsc.RepoAccount != nil:
 		return rsc.RepoAccount(xev.RepoAccount)
+	case xev.RepoSync != nil && rsc.RepoSync != nil:
+		return rsc.RepoSync(xev.RepoSync)
 	case xev.RepoTombstone != nil && rsc.RepoTombstone != nil:
 		return rsc.RepoTombstone(xev.RepoTombstone)
 	case xev.LabelLabels != nil && rsc.LabelLabels != nil:
@@ -55,41 +60,21 @@ func (rsc *RepoStreamCallbacks) EventHandler(ctx context.Context, xev *XRPCStrea
 }
 
 type InstrumentedRepoStreamCallbacks struct {
-	limiters []*slidingwindow.Limiter
+	limiters []ratelimit.Limiter
 	Next     func(ctx context.Context, xev *XRPCStreamEvent) error
 }
 
-func NewInstrumentedRepoStreamCallbacks(limiters []*slidingwindow.Limiter, next func(ctx context.Context, xev *XRPCStreamEvent) error) *InstrumentedRepoStreamCallbacks {
+func NewInstrumentedRepoStreamCallbacks(limiters []ratelimit.Limiter, next func(ctx context.Context, xev *XRPCStreamEvent) error) *InstrumentedRepoStreamCallbacks {
 	return &InstrumentedRepoStreamCallbacks{
 		limiters: limiters,
 		Next:     next,
 	}
 }
 
-func waitForLimiter(ctx context.Context, lim *slidingwindow.Limiter) error {
-	if lim.Allow() {
-		return nil
-	}
-
-	// wait until the limiter is ready (check every 100ms)
-	t := time.NewTicker(100 * time.Millisecond)
-	defer t.Stop()
-
-	for !lim.Allow() {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-t.C:
-		}
-	}
-
-	return nil
-}
-
 func (rsc *InstrumentedRepoStreamCallbacks) EventHandler(ctx context.Context, xev *XRPCStreamEvent) error {
 	// Wait on all limiters before calling the next handler
 	for _, lim := range rsc.limiters {
-		if err := waitForLimiter(ctx, lim); err != nil {
+		if err := lim.Wait(ctx); err != nil {
 			return err
 		}
 	}
@@ -108,15 +93,64 @@ func (sr *instrumentedReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// ErrStreamStale is returned (wrapped) when nothing has been received from the remote for longer than StreamOptions.ReadTimeout
+var ErrStreamStale = errors.New("event stream went stale")
+
+// StreamOptions control how a stream connection is kept alive, and when it is given up on
+type StreamOptions struct {
+	// How often to ping the remote
+	PingInterval time.Duration
+	// If nothing (no event or pong) is received for this long, the connection is treated as dead and dropped, returning ErrStreamStale. Without this, half-open connections block forever
+	ReadTimeout time.Duration
+	// Called (if not nil) whenever the remote answers one of our pings, so callers can tell a quiet stream from a dead one
+	OnPong func()
+	// Called (if not nil) with the sequence number of each event, once it has been handed to the scheduler
+	OnSeq func(seq int64)
+}
+
+func DefaultStreamOptions() *StreamOptions {
+	return &StreamOptions{
+		PingInterval: 30 * time.Second,
+		ReadTimeout:  time.Minute,
+	}
+}
+
 func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler) error {
+	return HandleRepoStreamWithOptions(ctx, con, sched, nil)
+}
+
+// HandleRepoStreamWithHeartbeat is HandleRepoStream, also calling onPong (if not nil) whenever the remote answers one of our pings, so callers can tell a quiet stream from a dead one
+func HandleRepoStreamWithHeartbeat(ctx context.Context, con *websocket.Conn, sched Scheduler, onPong func()) error {
+	opts := DefaultStreamOptions()
+	opts.OnPong = onPong
+	return HandleRepoStreamWithOptions(ctx, con, sched, opts)
+}
+
+// HandleRepoStreamWithOptions reads events from con and hands them to sched until the connection fails, goes stale, or ctx is cancelled; the scheduler is shut down when it returns. Nil opts means DefaultStreamOptions.
+func HandleRepoStreamWithOptions(ctx context.Context, con *websocket.Conn, sched Scheduler, opts *StreamOptions) error {
+	defer sched.Shutdown()
+	return handleRepoStream(ctx, con, sched, opts)
+}
+
+// handleRepoStream is HandleRepoStreamWithOptions without shutting down the scheduler, so it can be reused for another connection
+func handleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler, opts *StreamOptions) error {
+	if opts == nil {
+		opts = DefaultStreamOptions()
+	}
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	defer sched.Shutdown()
 
 	remoteAddr := con.RemoteAddr().String()
 
+	extendDeadline := func() {
+		if err := con.SetReadDeadline(time.Now().Add(opts.ReadTimeout)); err != nil {
+			log.Error("failed to set read deadline", "err", err)
+		}
+	}
+	extendDeadline()
+
 	go func() {
-		t := time.NewTicker(time.Second * 30)
+		t := time.NewTicker(opts.PingInterval)
 		defer t.Stop()
 
 		for {
@@ -124,7 +158,7 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 			select {
 			case <-t.C:
 				if err := con.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(time.Second*10)); err != nil {
-					log.Warnf("failed to ping: %s", err)
+					log.Warn("failed to ping", "err", err)
 				}
 			case <-ctx.Done():
 				con.Close()
@@ -144,8 +178,11 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 	})
 
 	con.SetPongHandler(func(_ string) error {
-		if err := con.SetReadDeadline(time.Now().Add(time.Minute)); err != nil {
-			log.Errorf("failed to set read deadline: %s", err)
+		extendDeadline()
+		streamPongsCounter.WithLabelValues(remoteAddr).Inc()
+
+		if opts.OnPong != nil {
+			opts.OnPong()
 		}
 
 		return nil
@@ -161,8 +198,15 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 
 		mt, rawReader, err := con.NextReader()
 		if err != nil {
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() && ctx.Err() == nil {
+				streamStaleCounter.WithLabelValues(remoteAddr).Inc()
+				return fmt.Errorf("%w: nothing received from %s in %s", ErrStreamStale, remoteAddr, opts.ReadTimeout)
+			}
 			return err
 		}
+		extendDeadline()
+		prevSeq := lastSeq
 
 		switch mt {
 		default:
@@ -184,6 +228,13 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 
 		eventsFromStreamCounter.WithLabelValues(remoteAddr).Inc()
 
+		// carry the sender's trace (if any) through to whatever handles the event
+		sc := header.spanContext()
+		evtCtx := ctx
+		if sc.IsValid() {
+			evtCtx = trace.ContextWithRemoteSpanContext(ctx, sc)
+		}
+
 		switch header.Op {
 		case EvtKindMessage:
 			switch header.MsgType {
@@ -194,13 +245,14 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 				}
 
 				if evt.Seq < lastSeq {
-					log.Errorf("Got events out of order from stream (seq = %d, prev = %d)", evt.Seq, lastSeq)
+					eventLog.Error("Got events out of order from stream", "seq", evt.Seq, "prev", lastSeq)
 				}
 
 				lastSeq = evt.Seq
 
-				if err := sched.AddWork(ctx, evt.Repo, &XRPCStreamEvent{
-					RepoCommit: &evt,
+				if err := sched.AddWork(evtCtx, evt.Repo, &XRPCStreamEvent{
+					RepoCommit:      &evt,
+					PrivSpanContext: sc,
 				}); err != nil {
 					return err
 				}
@@ -211,12 +263,13 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 				}
 
 				if evt.Seq < lastSeq {
-					log.Errorf("Got events out of order from stream (seq = %d, prev = %d)", evt.Seq, lastSeq)
+					eventLog.Error("Got events out of order from stream", "seq", evt.Seq, "prev", lastSeq)
 				}
 				lastSeq = evt.Seq
 
-				if err := sched.AddWork(ctx, evt.Did, &XRPCStreamEvent{
-					RepoHandle: &evt,
+				if err := sched.AddWork(evtCtx, evt.Did, &XRPCStreamEvent{
+					RepoHandle:      &evt,
+					PrivSpanContext: sc,
 				}); err != nil {
 					return err
 				}
@@ -227,12 +280,13 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 				}
 
 				if evt.Seq < lastSeq {
-					log.Errorf("Got events out of order from stream (seq = %d, prev = %d)", evt.Seq, lastSeq)
+					eventLog.Error("Got events out of order from stream", "seq", evt.Seq, "prev", lastSeq)
 				}
 				lastSeq = evt.Seq
 
-				if err := sched.AddWork(ctx, evt.Did, &XRPCStreamEvent{
-					RepoIdentity: &evt,
+				if err := sched.AddWork(evtCtx, evt.Did, &XRPCStreamEvent{
+					RepoIdentity:    &evt,
+					PrivSpanContext: sc,
 				}); err != nil {
 					return err
 				}
@@ -243,12 +297,30 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 				}
 
 				if evt.Seq < lastSeq {
-					log.Errorf("Got events out of order from stream (seq = %d, prev = %d)", evt.Seq, lastSeq)
+					eventLog.Error("Got events out of order from stream", "seq", evt.Seq, "prev", lastSeq)
+				}
+				lastSeq = evt.Seq
+
+				if err := sched.AddWork(evtCtx, evt.Did, &XRPCStreamEvent{
+					RepoAccount:     &evt,
+					PrivSpanContext: sc,
+				}); err != nil {
+					return err
+				}
+			case "#sync":
+				var evt comatproto.SyncSubscribeRepos_Sync
+				if err := evt.UnmarshalCBOR(r); err != nil {
+					return err
+				}
+
+				if evt.Seq < lastSeq {
+					eventLog.Error("Got events out of order from stream", "seq", evt.Seq, "prev", lastSeq)
 				}
 				lastSeq = evt.Seq
 
-				if err := sched.AddWork(ctx, evt.Did, &XRPCStreamEvent{
-					RepoAccount: &evt,
+				if err := sched.AddWork(evtCtx, evt.Did, &XRPCStreamEvent{
+					RepoSync:        &evt,
+					PrivSpanContext: sc,
 				}); err != nil {
 					return err
 				}
@@ -259,8 +331,9 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 					return err
 				}
 
-				if err := sched.AddWork(ctx, "", &XRPCStreamEvent{
-					RepoInfo: &evt,
+				if err := sched.AddWork(evtCtx, "", &XRPCStreamEvent{
+					RepoInfo:        &evt,
+					PrivSpanContext: sc,
 				}); err != nil {
 					return err
 				}
@@ -271,12 +344,13 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 				}
 
 				if evt.Seq < lastSeq {
-					log.Errorf("Got events out of order from stream (seq = %d, prev = %d)", evt.Seq, lastSeq)
+					eventLog.Error("Got events out of order from stream", "seq", evt.Seq, "prev", lastSeq)
 				}
 				lastSeq = evt.Seq
 
-				if err := sched.AddWork(ctx, evt.Did, &XRPCStreamEvent{
-					RepoMigrate: &evt,
+				if err := sched.AddWork(evtCtx, evt.Did, &XRPCStreamEvent{
+					RepoMigrate:     &evt,
+					PrivSpanContext: sc,
 				}); err != nil {
 					return err
 				}
@@ -287,12 +361,13 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 				}
 
 				if evt.Seq < lastSeq {
-					log.Errorf("Got events out of order from stream (seq = %d, prev = %d)", evt.Seq, lastSeq)
+					eventLog.Error("Got events out of order from stream", "seq", evt.Seq, "prev", lastSeq)
 				}
 				lastSeq = evt.Seq
 
-				if err := sched.AddWork(ctx, evt.Did, &XRPCStreamEvent{
-					RepoTombstone: &evt,
+				if err := sched.AddWork(evtCtx, evt.Did, &XRPCStreamEvent{
+					RepoTombstone:   &evt,
+					PrivSpanContext: sc,
 				}); err != nil {
 					return err
 				}
@@ -303,13 +378,14 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 				}
 
 				if evt.Seq < lastSeq {
-					log.Errorf("Got events out of order from stream (seq = %d, prev = %d)", evt.Seq, lastSeq)
+					eventLog.Error("Got events out of order from stream", "seq", evt.Seq, "prev", lastSeq)
 				}
 
 				lastSeq = evt.Seq
 
-				if err := sched.AddWork(ctx, "", &XRPCStreamEvent{
-					LabelLabels: &evt,
+				if err := sched.AddWork(evtCtx, "", &XRPCStreamEvent{
+					LabelLabels:     &evt,
+					PrivSpanContext: sc,
 				}); err != nil {
 					return err
 				}
@@ -321,8 +397,9 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 				return err
 			}
 
-			if err := sched.AddWork(ctx, "", &XRPCStreamEvent{
-				Error: &errframe,
+			if err := sched.AddWork(evtCtx, "", &XRPCStreamEvent{
+				Error:           &errframe,
+				PrivSpanContext: sc,
 			}); err != nil {
 				return err
 			}
@@ -331,5 +408,8 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 			return fmt.Errorf("unrecognized event stream type: %d", header.Op)
 		}
 
+		if lastSeq != prevSeq && opts.OnSeq != nil {
+			opts.OnSeq(lastSeq)
+		}
 	}
 }
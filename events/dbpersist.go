@@ -83,6 +83,9 @@ type RepoEventRecord struct {
 	Active bool
 	Status *string
 
+	// Blocks is only set on RepoSync events, as they are small and their commit may not be in the carstore
+	Blocks []byte
+
 	Ops []byte
 }
 
@@ -131,7 +134,7 @@ func (p *DbPersistence) batchFlusher() {
 
 		if needsFlush {
 			if err := p.Flush(context.Background()); err != nil {
-				log.Errorf("failed to flush batch: %s", err)
+				log.Error("failed to flush batch", "err", err)
 			}
 		}
 	}
@@ -173,6 +176,8 @@ func (p *DbPersistence) flushBatchLocked(ctx context.Context) error {
 			e.RepoIdentity.Seq = int64(item.Seq)
 		case e.RepoAccount != nil:
 			e.RepoAccount.Seq = int64(item.Seq)
+		case e.RepoSync != nil:
+			e.RepoSync.Seq = int64(item.Seq)
 		case e.RepoTombstone != nil:
 			e.RepoTombstone.Seq = int64(item.Seq)
 		default:
@@ -229,6 +234,11 @@ func (p *DbPersistence) Persist(ctx context.Context, e *XRPCStreamEvent) error {
 		if err != nil {
 			return err
 		}
+	case e.RepoSync != nil:
+		rer, err = p.RecordFromRepoSync(ctx, e.RepoSync)
+		if err != nil {
+			return err
+		}
 	case e.RepoTombstone != nil:
 		rer, err = p.RecordFromTombstone(ctx, e.RepoTombstone)
 		if err != nil {
@@ -302,6 +312,26 @@ func (p *DbPersistence) RecordFromRepoAccount(ctx context.Context, evt *comatpro
 	}, nil
 }
 
+func (p *DbPersistence) RecordFromRepoSync(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Sync) (*RepoEventRecord, error) {
+	t, err := time.Parse(util.ISO8601, evt.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, err := p.uidForDid(ctx, evt.Did)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RepoEventRecord{
+		Repo:   uid,
+		Type:   "repo_sync",
+		Time:   t,
+		Rev:    evt.Rev,
+		Blocks: evt.Blocks,
+	}, nil
+}
+
 func (p *DbPersistence) RecordFromTombstone(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Tombstone) (*RepoEventRecord, error) {
 	t, err := time.Parse(util.ISO8601, evt.Time)
 	if err != nil {
@@ -323,7 +353,7 @@ func (p *DbPersistence) RecordFromTombstone(ctx context.Context, evt *comatproto
 func (p *DbPersistence) RecordFromRepoCommit(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) (*RepoEventRecord, error) {
 	// TODO: hack hack hack
 	if len(evt.Ops) > 8192 {
-		log.Errorf("(VERY BAD) truncating ops field in outgoing event (len = %d)", len(evt.Ops))
+		log.Error("(VERY BAD) truncating ops field in outgoing event", "len", len(evt.Ops))
 		evt.Ops = evt.Ops[:8192]
 	}
 
@@ -456,6 +486,8 @@ func (p *DbPersistence) hydrateBatch(ctx context.Context, batch []*RepoEventReco
 				streamEvent, err = p.hydrateIdentityEvent(ctx, record)
 			case record.Type == "repo_account":
 				streamEvent, err = p.hydrateAccountEvent(ctx, record)
+			case record.Type == "repo_sync":
+				streamEvent, err = p.hydrateSyncEvent(ctx, record)
 			case record.Type == "repo_tombstone":
 				streamEvent, err = p.hydrateTombstone(ctx, record)
 			default:
@@ -569,6 +601,23 @@ func (p *DbPersistence) hydrateAccountEvent(ctx context.Context, rer *RepoEventR
 	}, nil
 }
 
+func (p *DbPersistence) hydrateSyncEvent(ctx context.Context, rer *RepoEventRecord) (*XRPCStreamEvent, error) {
+	did, err := p.didForUid(ctx, rer.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &XRPCStreamEvent{
+		RepoSync: &comatproto.SyncSubscribeRepos_Sync{
+			Seq:    int64(rer.Seq),
+			Did:    did,
+			Time:   rer.Time.Format(util.ISO8601),
+			Rev:    rer.Rev,
+			Blocks: rer.Blocks,
+		},
+	}, nil
+}
+
 func (p *DbPersistence) hydrateTombstone(ctx context.Context, rer *RepoEventRecord) (*XRPCStreamEvent, error) {
 	did, err := p.didForUid(ctx, rer.Repo)
 	if err != nil {
@@ -0,0 +1,502 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+)
+
+var ErrMaintenanceUnsupported = fmt.Errorf("event persister does not support storage maintenance")
+
+// MaintenanceRunner is implemented by persisters which maintain their storage in the background, and can also be asked to do so on demand
+type MaintenanceRunner interface {
+	RunMaintenance(ctx context.Context) (*MaintenanceReport, error)
+}
+
+// MaintenanceReport summarizes a single maintenance pass over the persister's storage
+type MaintenanceReport struct {
+	// Repairs made to the index of log files (refs added for unindexed files, dropped for missing ones, or corrected)
+	IndexRepairs int `json:"indexRepairs"`
+	// Log files read, not counting the one currently being written
+	FilesScanned int `json:"filesScanned"`
+	// Events with a checksum which were verified
+	EventsVerified int `json:"eventsVerified"`
+	// Events which failed verification, and are now skipped in playback
+	CorruptEvents int `json:"corruptEvents"`
+	// Log files ending in a partially written event, which was cut off
+	FilesTruncated int `json:"filesTruncated"`
+	// Log files which couldn't be read to the end (an event header is invalid), and were left as they are
+	FilesUnreadable int `json:"filesUnreadable"`
+	// Log files rewritten without the data of hidden events
+	FilesCompacted int   `json:"filesCompacted"`
+	BytesReclaimed int64 `json:"bytesReclaimed"`
+}
+
+// RunMaintenance runs a maintenance pass now (see MaintenanceRunner)
+func (em *EventManager) RunMaintenance(ctx context.Context) (*MaintenanceReport, error) {
+	mr, ok := em.persister.(MaintenanceRunner)
+	if !ok {
+		return nil, ErrMaintenanceUnsupported
+	}
+	return mr.RunMaintenance(ctx)
+}
+
+var _ (MaintenanceRunner) = (*DiskPersistence)(nil)
+
+var maintenanceRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "disk_persister_maintenance_runs",
+	Help: "Number of maintenance passes executed",
+}, []string{})
+
+var maintenanceErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "disk_persister_maintenance_errors",
+	Help: "Number of errors encountered during maintenance",
+}, []string{})
+
+var maintenanceDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "disk_persister_maintenance_duration_seconds",
+	Help:    "Duration of maintenance passes",
+	Buckets: prometheus.ExponentialBuckets(0.1, 4, 10),
+})
+
+var indexRepairs = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "disk_persister_maintenance_index_repairs",
+	Help: "Number of repairs made to the log file index, by kind",
+}, []string{"kind"})
+
+var eventsVerified = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "disk_persister_maintenance_events_verified",
+	Help: "Number of event checksums verified",
+}, []string{})
+
+var corruptEventsFound = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "disk_persister_maintenance_corrupt_events",
+	Help: "Number of events which failed checksum verification",
+}, []string{})
+
+var damagedFilesFound = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "disk_persister_maintenance_damaged_files",
+	Help: "Number of log files found with unreadable events, by whether they were truncated or left as they are",
+}, []string{"action"})
+
+var filesCompacted = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "disk_persister_maintenance_files_compacted",
+	Help: "Number of log files compacted",
+}, []string{})
+
+var bytesReclaimed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "disk_persister_maintenance_bytes_reclaimed",
+	Help: "Number of bytes freed by compacting log files",
+}, []string{})
+
+func (dp *DiskPersistence) maintenanceRoutine() {
+	t := time.NewTicker(dp.maintenanceInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-dp.shutdown:
+			return
+		case <-t.C:
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case <-dp.shutdown:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			if _, err := dp.RunMaintenance(ctx); err != nil {
+				log.Error("disk persister maintenance failed", "err", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// RunMaintenance checks the index of log files against the files on disk, then reads every log file other than the current one. Event checksums are verified; events which fail are flagged so playback skips them. A partially written event at the end of a file is cut off. Files where enough of the data belongs to events hidden from playback (taken down, trimmed, or corrupt) are rewritten without it, keeping the event headers so sequence numbers are unaffected.
+//
+// Errors with individual files are logged and counted in the report; the returned error is for failures which stop the pass.
+func (dp *DiskPersistence) RunMaintenance(ctx context.Context) (*MaintenanceReport, error) {
+	start := time.Now()
+	maintenanceRuns.WithLabelValues().Inc()
+	defer func() {
+		maintenanceDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	var rep MaintenanceReport
+
+	dp.lk.Lock()
+	n, err := dp.repairIndex(ctx)
+	dp.lk.Unlock()
+	rep.IndexRepairs = n
+	if err != nil {
+		maintenanceErrors.WithLabelValues().Inc()
+		return &rep, fmt.Errorf("failed to repair log file index: %w", err)
+	}
+
+	var refs []LogFileRef
+	if err := dp.meta.WithContext(ctx).Order("seq_start asc").Find(&refs, "archived = ?", false).Error; err != nil {
+		maintenanceErrors.WithLabelValues().Inc()
+		return &rep, err
+	}
+
+	for _, r := range refs {
+		if err := ctx.Err(); err != nil {
+			return &rep, err
+		}
+
+		// the log may have rotated since the refs were listed, so check against the current file as of now. A file
+		// never becomes current again once rotated out, and swapLog flushes it before moving on, so once this check
+		// passes nothing else will write to it
+		dp.lk.Lock()
+		current := dp.logfi.Name()
+		dp.lk.Unlock()
+
+		fn := dp.logPath(r)
+		if fn == current {
+			continue
+		}
+
+		if err := dp.maintainLogFile(fn, &rep); err != nil {
+			maintenanceErrors.WithLabelValues().Inc()
+			log.Error("log file maintenance failed", "path", r.Path, "err", err)
+		}
+	}
+
+	log.Info("disk persister maintenance complete",
+		"duration", time.Since(start),
+		"indexRepairs", rep.IndexRepairs,
+		"filesScanned", rep.FilesScanned,
+		"eventsVerified", rep.EventsVerified,
+		"corruptEvents", rep.CorruptEvents,
+		"filesTruncated", rep.FilesTruncated,
+		"filesUnreadable", rep.FilesUnreadable,
+		"filesCompacted", rep.FilesCompacted,
+		"bytesReclaimed", rep.BytesReclaimed,
+	)
+
+	return &rep, nil
+}
+
+const compactSuffix = ".compact"
+
+// logFileStart parses the starting sequence number out of a log file name, as created by createLogFile
+func logFileStart(name string) (int64, bool) {
+	s, ok := strings.CutPrefix(name, "evts-")
+	if !ok {
+		return 0, false
+	}
+	seq, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return seq, true
+}
+
+// repairIndex makes the (unarchived) log file refs match the log files in the primary directory: refs are created for files without one, and dropped where the file is missing or another ref has the same path. It also removes files left over from interrupted compactions. It returns the number of repairs made.
+// must only be called while holding dp.lk, or before the persister is started
+func (dp *DiskPersistence) repairIndex(ctx context.Context) (int, error) {
+	dp.maintLk.Lock()
+	defer dp.maintLk.Unlock()
+
+	entries, err := os.ReadDir(dp.primaryDir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	type logFile struct {
+		start   int64
+		modTime time.Time
+	}
+
+	files := make(map[string]logFile)
+	for _, ent := range entries {
+		if !ent.Type().IsRegular() {
+			continue
+		}
+		if strings.HasSuffix(ent.Name(), compactSuffix) {
+			if err := os.Remove(filepath.Join(dp.primaryDir, ent.Name())); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		start, ok := logFileStart(ent.Name())
+		if !ok {
+			continue
+		}
+		info, err := ent.Info()
+		if err != nil {
+			return 0, err
+		}
+		files[ent.Name()] = logFile{start: start, modTime: info.ModTime()}
+	}
+
+	var refs []LogFileRef
+	if err := dp.meta.WithContext(ctx).Order("id asc").Find(&refs, "archived = ?", false).Error; err != nil {
+		return 0, err
+	}
+
+	var found int
+	for _, r := range refs {
+		if _, ok := files[r.Path]; ok {
+			found++
+		}
+	}
+	if found == 0 && len(refs) > 0 {
+		// more likely the wrong (or an unmounted) directory than every file going missing
+		return 0, fmt.Errorf("none of the %d indexed log files are in %s", len(refs), dp.primaryDir)
+	}
+
+	var repairs int
+	repaired := func(kind string, r LogFileRef) {
+		log.Warn("repaired log file index", "kind", kind, "path", r.Path, "seqStart", r.SeqStart)
+		indexRepairs.WithLabelValues(kind).Inc()
+		repairs++
+	}
+
+	indexed := make(map[string]bool, len(refs))
+	for _, r := range refs {
+		f, ok := files[r.Path]
+		switch {
+		case !ok || indexed[r.Path]:
+			kind := "missing_file"
+			if ok {
+				kind = "duplicate_ref"
+			}
+			if err := dp.meta.WithContext(ctx).Delete(&r).Error; err != nil {
+				return repairs, err
+			}
+			repaired(kind, r)
+		case r.SeqStart != f.start:
+			if err := dp.meta.WithContext(ctx).Model(&r).Update("seq_start", f.start).Error; err != nil {
+				return repairs, err
+			}
+			repaired("wrong_seq_start", r)
+		}
+		indexed[r.Path] = true
+	}
+
+	for path, f := range files {
+		if indexed[path] {
+			continue
+		}
+		// retention is based on when the ref was created, so date it by the file
+		r := LogFileRef{
+			Model:    gorm.Model{CreatedAt: f.modTime},
+			Path:     path,
+			SeqStart: f.start,
+		}
+		if err := dp.meta.WithContext(ctx).Create(&r).Error; err != nil {
+			return repairs, err
+		}
+		repaired("missing_ref", r)
+	}
+
+	return repairs, nil
+}
+
+// maintainLogFile verifies the events in a log file, truncates a partially written final event, and compacts the file if enough of it is hidden from playback
+func (dp *DiskPersistence) maintainLogFile(fn string, rep *MaintenanceReport) error {
+	dp.maintLk.Lock()
+	defer dp.maintLk.Unlock()
+
+	fi, err := os.OpenFile(fn, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// garbage collected since we listed it
+			return nil
+		}
+		return err
+	}
+	defer fi.Close()
+
+	st, err := fi.Stat()
+	if err != nil {
+		return err
+	}
+	size := st.Size()
+
+	rep.FilesScanned++
+
+	bufr := bufio.NewReader(fi)
+	scratch := make([]byte, headerSize)
+	var data []byte
+	var offset, hidden int64
+	var corrupt int
+	for {
+		h, err := readHeader(bufr, scratch)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				return err
+			}
+			// a torn write, partway through the header
+			h = nil
+		}
+
+		if h == nil || offset+headerSize+h.Len64() > size {
+			// the last event was only partially written (eg, on a crash), so will never be readable
+			if err := fi.Truncate(offset); err != nil {
+				return fmt.Errorf("failed to truncate partial event: %w", err)
+			}
+			log.Warn("truncated partially written event in log file", "path", fn, "offset", offset, "size", size)
+			damagedFilesFound.WithLabelValues("truncated").Inc()
+			rep.FilesTruncated++
+			size = offset
+			break
+		}
+
+		if h.Kind < evtKindCommit || h.Kind > evtKindSync {
+			// the header itself is garbage, so the rest of the file can't be framed. Playback will stop at this point, which is an operator's call to fix.
+			log.Error("invalid event header in log file", "path", fn, "offset", offset, "kind", h.Kind, "seq", h.Seq)
+			damagedFilesFound.WithLabelValues("unreadable").Inc()
+			rep.FilesUnreadable++
+			return nil
+		}
+
+		switch {
+		case postDoNotEmit(h.Flags):
+			hidden += h.Len64()
+			if _, err := bufr.Discard(int(h.Len)); err != nil {
+				return err
+			}
+		case h.Flags&EvtFlagChecksum != 0 && h.Len >= checksumSize:
+			if cap(data) < int(h.Len) {
+				data = make([]byte, h.Len)
+			}
+			data = data[:h.Len]
+			if _, err := io.ReadFull(bufr, data); err != nil {
+				return err
+			}
+
+			rep.EventsVerified++
+			eventsVerified.WithLabelValues().Inc()
+
+			payload := data[:h.payloadLen()]
+			if crc32.Checksum(payload, checksumTable) != binary.LittleEndian.Uint32(data[h.payloadLen():]) {
+				binary.LittleEndian.PutUint32(scratch, h.Flags|EvtFlagCorrupt)
+				if _, err := fi.WriteAt(scratch[:4], offset); err != nil {
+					return fmt.Errorf("failed to flag corrupt event: %w", err)
+				}
+				log.Error("event failed checksum verification, hiding it from playback", "path", fn, "seq", h.Seq)
+				corruptEventsFound.WithLabelValues().Inc()
+				rep.CorruptEvents++
+				corrupt++
+				hidden += h.Len64()
+			}
+		default:
+			// events from before checksums were added can only be skipped
+			if _, err := bufr.Discard(int(h.Len)); err != nil {
+				return err
+			}
+		}
+
+		offset += headerSize + h.Len64()
+	}
+
+	if corrupt > 0 {
+		if err := fi.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if hidden == 0 || float64(hidden) < float64(size)*dp.compactionThreshold {
+		return nil
+	}
+
+	nsize, err := compactLogFile(fi, fn)
+	if err != nil {
+		return fmt.Errorf("failed to compact log file: %w", err)
+	}
+
+	filesCompacted.WithLabelValues().Inc()
+	bytesReclaimed.WithLabelValues().Add(float64(size - nsize))
+	rep.FilesCompacted++
+	rep.BytesReclaimed += size - nsize
+
+	return nil
+}
+
+// compactLogFile rewrites a log file with the data of events hidden from playback dropped, leaving just their headers, and returns the new size
+// must only be called while holding dp.maintLk
+func compactLogFile(fi *os.File, fn string) (int64, error) {
+	if _, err := fi.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	tmpfn := fn + compactSuffix
+	out, err := os.Create(tmpfn)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmpfn)
+	defer out.Close()
+
+	bufr := bufio.NewReader(fi)
+	bufw := bufio.NewWriter(out)
+	scratch := make([]byte, headerSize)
+	var nsize int64
+	for {
+		h, err := readHeader(bufr, scratch)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return 0, err
+		}
+
+		if postDoNotEmit(h.Flags) {
+			if _, err := bufr.Discard(int(h.Len)); err != nil {
+				return 0, err
+			}
+
+			binary.LittleEndian.PutUint32(scratch, h.Flags&^EvtFlagChecksum)
+			binary.LittleEndian.PutUint32(scratch[8:], 0)
+			if _, err := bufw.Write(scratch); err != nil {
+				return 0, err
+			}
+			nsize += headerSize
+			continue
+		}
+
+		if _, err := bufw.Write(scratch); err != nil {
+			return 0, err
+		}
+		if _, err := io.CopyN(bufw, bufr, h.Len64()); err != nil {
+			return 0, err
+		}
+		nsize += headerSize + h.Len64()
+	}
+
+	if err := bufw.Flush(); err != nil {
+		return 0, err
+	}
+	if err := out.Sync(); err != nil {
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+
+	if err := os.Rename(tmpfn, fn); err != nil {
+		return 0, err
+	}
+
+	return nsize, nil
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
@@ -47,9 +48,15 @@ type DiskPersistence struct {
 	outbuf *bytes.Buffer
 	evtbuf []persistJob
 
+	maintenanceInterval time.Duration
+	compactionThreshold float64
+
 	shutdown chan struct{}
 
 	lk sync.Mutex
+
+	// held while rewriting or removing log files other than the current one. Taken after lk, if both are needed.
+	maintLk sync.Mutex
 }
 
 type persistJob struct {
@@ -66,6 +73,11 @@ type jobResult struct {
 const (
 	EvtFlagTakedown = 1 << iota
 	EvtFlagRebased
+	EvtFlagTrimmed
+	// the event data ends with a CRC-32C checksum of the rest of the data (included in the length)
+	EvtFlagChecksum
+	// the event failed checksum verification, and is skipped in playback
+	EvtFlagCorrupt
 )
 
 var _ (EventPersistence) = (*DiskPersistence)(nil)
@@ -76,6 +88,11 @@ type DiskPersistOptions struct {
 	EventsPerFile   int64
 	WriteBufferSize int
 	Retention       time.Duration
+
+	// How often to verify, compact, and re-index log files (see RunMaintenance). Zero disables background maintenance.
+	MaintenanceInterval time.Duration
+	// Log files are compacted when at least this fraction of their size is taken down or otherwise hidden from playback. Defaults to 0.25.
+	CompactionThreshold float64
 }
 
 func DefaultDiskPersistOptions() *DiskPersistOptions {
@@ -85,6 +102,9 @@ func DefaultDiskPersistOptions() *DiskPersistOptions {
 		DIDCacheSize:    100_000,
 		WriteBufferSize: 50,
 		Retention:       time.Hour * 24 * 3, // 3 days
+
+		MaintenanceInterval: time.Hour * 24,
+		CompactionThreshold: 0.25,
 	}
 }
 
@@ -105,6 +125,11 @@ func NewDiskPersistence(primaryDir, archiveDir string, db *gorm.DB, opts *DiskPe
 
 	db.AutoMigrate(&LogFileRef{})
 
+	compactionThreshold := opts.CompactionThreshold
+	if compactionThreshold <= 0 {
+		compactionThreshold = 0.25
+	}
+
 	bufpool := &sync.Pool{
 		New: func() any {
 			return new(bytes.Buffer)
@@ -131,6 +156,14 @@ func NewDiskPersistence(primaryDir, archiveDir string, db *gorm.DB, opts *DiskPe
 		outbuf:          new(bytes.Buffer),
 		writeBufferSize: opts.WriteBufferSize,
 		shutdown:        make(chan struct{}),
+
+		maintenanceInterval: opts.MaintenanceInterval,
+		compactionThreshold: compactionThreshold,
+	}
+
+	// a lost or damaged index would otherwise have us start a fresh log over the top of the existing ones
+	if _, err := dp.repairIndex(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to check log file index: %w", err)
 	}
 
 	if err := dp.resumeLog(); err != nil {
@@ -141,6 +174,10 @@ func NewDiskPersistence(primaryDir, archiveDir string, db *gorm.DB, opts *DiskPe
 
 	go dp.garbageCollectRoutine()
 
+	if dp.maintenanceInterval > 0 {
+		go dp.maintenanceRoutine()
+	}
+
 	return dp, nil
 }
 
@@ -209,6 +246,12 @@ func (dp *DiskPersistence) swapLog(ctx context.Context) error {
 		return fmt.Errorf("failed to close current log file: %w", err)
 	}
 
+	return dp.createLogFile(ctx)
+}
+
+// createLogFile opens a new log file, starting at the current sequence number
+// must only be called while holding dp.lk
+func (dp *DiskPersistence) createLogFile(ctx context.Context) error {
 	fname := fmt.Sprintf("evts-%d", dp.curSeq)
 	nextp := filepath.Join(dp.primaryDir, fname)
 
@@ -278,6 +321,7 @@ const (
 	evtKindTombstone = 3
 	evtKindIdentity  = 4
 	evtKindAccount   = 5
+	evtKindSync      = 6
 )
 
 var emptyHeader = make([]byte, headerSize)
@@ -312,7 +356,7 @@ func (dp *DiskPersistence) flushRoutine() {
 			dp.lk.Lock()
 			if err := dp.flushLog(ctx); err != nil {
 				// TODO: this happening is quite bad. Need a recovery strategy
-				log.Errorf("failed to flush disk log: %s", err)
+				log.Error("failed to flush disk log", "err", err)
 			}
 			dp.lk.Unlock()
 		}
@@ -354,7 +398,7 @@ func (dp *DiskPersistence) garbageCollectRoutine() {
 		case <-t.C:
 			if errs := dp.garbageCollect(ctx); len(errs) > 0 {
 				for _, err := range errs {
-					log.Errorf("garbage collection error: %s", err)
+					log.Error("garbage collection error", "err", err)
 				}
 			}
 		}
@@ -412,8 +456,10 @@ func (dp *DiskPersistence) garbageCollect(ctx context.Context) []error {
 			continue
 		}
 
+		dp.maintLk.Lock()
 		// Delete the ref in the database to prevent playback from finding it
 		if err := dp.meta.WithContext(ctx).Delete(&r).Error; err != nil {
+			dp.maintLk.Unlock()
 			errs = append(errs, err)
 			continue
 		}
@@ -421,16 +467,18 @@ func (dp *DiskPersistence) garbageCollect(ctx context.Context) []error {
 
 		// Delete the file from disk
 		if err := os.Remove(filepath.Join(dp.primaryDir, r.Path)); err != nil {
+			dp.maintLk.Unlock()
 			errs = append(errs, err)
 			continue
 		}
 		filesDeleted++
+		dp.maintLk.Unlock()
 	}
 
 	refsGarbageCollected.WithLabelValues().Add(float64(refsDeleted))
 	filesGarbageCollected.WithLabelValues().Add(float64(filesDeleted))
 
-	log.Infow("garbage collection complete",
+	log.Info("garbage collection complete",
 		"filesDeleted", filesDeleted,
 		"refsDeleted", refsDeleted,
 		"oldRefsFound", oldRefsFound,
@@ -457,6 +505,8 @@ func (dp *DiskPersistence) doPersist(ctx context.Context, j persistJob) error {
 		e.RepoIdentity.Seq = seq
 	case e.RepoAccount != nil:
 		e.RepoAccount.Seq = seq
+	case e.RepoSync != nil:
+		e.RepoSync.Seq = seq
 	case e.RepoTombstone != nil:
 		e.RepoTombstone.Seq = seq
 	default:
@@ -523,6 +573,12 @@ func (dp *DiskPersistence) Persist(ctx context.Context, e *XRPCStreamEvent) erro
 		if err := e.RepoAccount.MarshalCBOR(cw); err != nil {
 			return fmt.Errorf("failed to marshal: %w", err)
 		}
+	case e.RepoSync != nil:
+		evtKind = evtKindSync
+		did = e.RepoSync.Did
+		if err := e.RepoSync.MarshalCBOR(cw); err != nil {
+			return fmt.Errorf("failed to marshal: %w", err)
+		}
 	case e.RepoTombstone != nil:
 		evtKind = evtKindTombstone
 		did = e.RepoTombstone.Did
@@ -534,6 +590,10 @@ func (dp *DiskPersistence) Persist(ctx context.Context, e *XRPCStreamEvent) erro
 		// only those two get peristed right now
 	}
 
+	var sum [checksumSize]byte
+	binary.LittleEndian.PutUint32(sum[:], crc32.Checksum(buffer.Bytes()[headerSize:], checksumTable))
+	buffer.Write(sum[:])
+
 	usr, err := dp.uidForDid(ctx, did)
 	if err != nil {
 		return err
@@ -541,8 +601,8 @@ func (dp *DiskPersistence) Persist(ctx context.Context, e *XRPCStreamEvent) erro
 
 	b := buffer.Bytes()
 
-	// Set flags in header (no flags for now)
-	binary.LittleEndian.PutUint32(b, 0)
+	// Set flags in header
+	binary.LittleEndian.PutUint32(b, EvtFlagChecksum)
 	// Set event kind in header
 	binary.LittleEndian.PutUint32(b[4:], evtKind)
 	// Set event length in header
@@ -569,8 +629,20 @@ func (eh *evtHeader) Len64() int64 {
 	return int64(eh.Len)
 }
 
+// payloadLen is the length of the event data, without any checksum
+func (eh *evtHeader) payloadLen() int64 {
+	if eh.Flags&EvtFlagChecksum != 0 && eh.Len >= checksumSize {
+		return int64(eh.Len) - checksumSize
+	}
+	return int64(eh.Len)
+}
+
 const headerSize = 4 + 4 + 4 + 8 + 8
 
+const checksumSize = 4
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
 func readHeader(r io.Reader, scratch []byte) (*evtHeader, error) {
 	if len(scratch) < headerSize {
 		return nil, fmt.Errorf("must pass scratch buffer of at least %d bytes", headerSize)
@@ -631,6 +703,11 @@ func (dp *DiskPersistence) uidForDid(ctx context.Context, did string) (models.Ui
 	return u.Uid, nil
 }
 
+// ForgetDid drops the cached uid for an account, so a purged account which reappears is assigned its new uid
+func (dp *DiskPersistence) ForgetDid(did string) {
+	dp.didCache.Remove(did)
+}
+
 func (dp *DiskPersistence) Playback(ctx context.Context, since int64, cb func(*XRPCStreamEvent) error) error {
 	base := since - (since % dp.eventsPerFile)
 	var logs []LogFileRef
@@ -677,7 +754,7 @@ func (dp *DiskPersistence) PlaybackLogfiles(ctx context.Context, since int64, cb
 }
 
 func postDoNotEmit(flags uint32) bool {
-	if flags&(EvtFlagRebased|EvtFlagTakedown) != 0 {
+	if flags&(EvtFlagRebased|EvtFlagTakedown|EvtFlagTrimmed|EvtFlagCorrupt) != 0 {
 		return true
 	}
 
@@ -696,7 +773,7 @@ func (dp *DiskPersistence) readEventsFrom(ctx context.Context, since int64, fn s
 			return nil, err
 		}
 		if since > lastSeq {
-			log.Errorw("playback cursor is greater than last seq of file checked",
+			log.Error("playback cursor is greater than last seq of file checked",
 				"since", since,
 				"lastSeq", lastSeq,
 				"filename", fn,
@@ -734,7 +811,7 @@ func (dp *DiskPersistence) readEventsFrom(ctx context.Context, since int64, fn s
 		switch h.Kind {
 		case evtKindCommit:
 			var evt atproto.SyncSubscribeRepos_Commit
-			if err := evt.UnmarshalCBOR(io.LimitReader(bufr, h.Len64())); err != nil {
+			if err := evt.UnmarshalCBOR(io.LimitReader(bufr, h.payloadLen())); err != nil {
 				return nil, err
 			}
 			evt.Seq = h.Seq
@@ -743,7 +820,7 @@ func (dp *DiskPersistence) readEventsFrom(ctx context.Context, since int64, fn s
 			}
 		case evtKindHandle:
 			var evt atproto.SyncSubscribeRepos_Handle
-			if err := evt.UnmarshalCBOR(io.LimitReader(bufr, h.Len64())); err != nil {
+			if err := evt.UnmarshalCBOR(io.LimitReader(bufr, h.payloadLen())); err != nil {
 				return nil, err
 			}
 			evt.Seq = h.Seq
@@ -752,7 +829,7 @@ func (dp *DiskPersistence) readEventsFrom(ctx context.Context, since int64, fn s
 			}
 		case evtKindIdentity:
 			var evt atproto.SyncSubscribeRepos_Identity
-			if err := evt.UnmarshalCBOR(io.LimitReader(bufr, h.Len64())); err != nil {
+			if err := evt.UnmarshalCBOR(io.LimitReader(bufr, h.payloadLen())); err != nil {
 				return nil, err
 			}
 			evt.Seq = h.Seq
@@ -761,16 +838,25 @@ func (dp *DiskPersistence) readEventsFrom(ctx context.Context, since int64, fn s
 			}
 		case evtKindAccount:
 			var evt atproto.SyncSubscribeRepos_Account
-			if err := evt.UnmarshalCBOR(io.LimitReader(bufr, h.Len64())); err != nil {
+			if err := evt.UnmarshalCBOR(io.LimitReader(bufr, h.payloadLen())); err != nil {
 				return nil, err
 			}
 			evt.Seq = h.Seq
 			if err := cb(&XRPCStreamEvent{RepoAccount: &evt}); err != nil {
 				return nil, err
 			}
+		case evtKindSync:
+			var evt atproto.SyncSubscribeRepos_Sync
+			if err := evt.UnmarshalCBOR(io.LimitReader(bufr, h.payloadLen())); err != nil {
+				return nil, err
+			}
+			evt.Seq = h.Seq
+			if err := cb(&XRPCStreamEvent{RepoSync: &evt}); err != nil {
+				return nil, err
+			}
 		case evtKindTombstone:
 			var evt atproto.SyncSubscribeRepos_Tombstone
-			if err := evt.UnmarshalCBOR(io.LimitReader(bufr, h.Len64())); err != nil {
+			if err := evt.UnmarshalCBOR(io.LimitReader(bufr, h.payloadLen())); err != nil {
 				return nil, err
 			}
 			evt.Seq = h.Seq
@@ -778,9 +864,15 @@ func (dp *DiskPersistence) readEventsFrom(ctx context.Context, since int64, fn s
 				return nil, err
 			}
 		default:
-			log.Warnw("unrecognized event kind coming from log file", "seq", h.Seq, "kind", h.Kind)
+			log.Warn("unrecognized event kind coming from log file", "seq", h.Seq, "kind", h.Kind)
 			return nil, fmt.Errorf("halting on unrecognized event kind")
 		}
+
+		if h.Flags&EvtFlagChecksum != 0 {
+			if _, err := bufr.Discard(checksumSize); err != nil {
+				return nil, fmt.Errorf("failed while skipping checksum (seq: %d, fn: %q): %w", h.Seq, fn, err)
+			}
+		}
 	}
 }
 
@@ -859,6 +951,9 @@ func (dp *DiskPersistence) deleteEventsForUser(ctx context.Context, usr models.U
 }
 
 func (dp *DiskPersistence) mutateUserEventsInLog(ctx context.Context, usr models.Uid, fn string, flag uint32, zeroEvts bool) error {
+	dp.maintLk.Lock()
+	defer dp.maintLk.Unlock()
+
 	fi, err := os.OpenFile(fn, os.O_RDWR, 0)
 	if err != nil {
 		return fmt.Errorf("failed to open log file: %w", err)
@@ -1,8 +1,10 @@
 package events_test
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -20,6 +22,7 @@ import (
 	"github.com/bluesky-social/indigo/pds"
 	"github.com/bluesky-social/indigo/repomgr"
 	"github.com/bluesky-social/indigo/util"
+	"github.com/ipfs/go-cid"
 	"gorm.io/gorm"
 )
 
@@ -510,3 +513,404 @@ func runTakedownTest(t *testing.T, cs *carstore.CarStore, db *gorm.DB, p events.
 		t.Fatalf("wrong number of events out: %d != %d", evtsCount, exp)
 	}
 }
+
+func TestDiskPersisterSequenceTools(t *testing.T) {
+	ctx := context.Background()
+
+	db, _, _, tempPath, err := setupDBs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tempPath)
+
+	db.AutoMigrate(&models.ActorInfo{})
+	db.Create(&models.ActorInfo{
+		Uid: 1,
+		Did: "did:example:123",
+	})
+
+	dp, err := events.NewDiskPersistence(filepath.Join(tempPath, "diskPrimary"), filepath.Join(tempPath, "diskArchive"), db, &events.DiskPersistOptions{
+		EventsPerFile: 10,
+		UIDCacheSize:  100000,
+		DIDCacheSize:  100000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	evtman := events.NewEventManager(dp)
+
+	commit := lexutil.LexLink(cid.MustParse("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"))
+
+	addEvents := func(n int) {
+		for i := 0; i < n; i++ {
+			if err := evtman.AddEvent(ctx, &events.XRPCStreamEvent{
+				RepoCommit: &atproto.SyncSubscribeRepos_Commit{
+					Repo:   "did:example:123",
+					Commit: commit,
+					Time:   time.Now().Format(util.ISO8601),
+				},
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := dp.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seqs := func() []int64 {
+		var out []int64
+		if err := dp.Playback(ctx, 0, func(evt *events.XRPCStreamEvent) error {
+			out = append(out, evt.RepoCommit.Seq)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	addEvents(35)
+
+	// export a range, and read it back as firehose frames
+	var buf bytes.Buffer
+	n, err := evtman.Export(ctx, 10, 20, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Fatalf("expected 10 exported events, got %d", n)
+	}
+	for i := int64(11); i <= 20; i++ {
+		var hdr events.EventHeader
+		if err := hdr.UnmarshalCBOR(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.MsgType != "#commit" {
+			t.Fatalf("unexpected message type %q", hdr.MsgType)
+		}
+		var evt atproto.SyncSubscribeRepos_Commit
+		if err := evt.UnmarshalCBOR(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if evt.Seq != i {
+			t.Fatalf("expected exported seq %d, got %d", i, evt.Seq)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected trailing export data")
+	}
+
+	// trim a range spanning a whole log file
+	n, err = evtman.TrimEvents(ctx, 5, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 20 {
+		t.Fatalf("expected 20 trimmed events, got %d", n)
+	}
+	if got := seqs(); len(got) != 15 || got[3] != 4 || got[4] != 25 {
+		t.Fatalf("unexpected events after trim: %v", got)
+	}
+
+	// rolling back over live events is refused
+	if _, err := evtman.Resequence(ctx, 5); !errors.Is(err, events.ErrLiveEventsAfterSequence) {
+		t.Fatalf("expected resequence to be refused, got %v", err)
+	}
+
+	if _, err := evtman.TrimEvents(ctx, 25, 35); err != nil {
+		t.Fatal(err)
+	}
+
+	live, cancel, err := evtman.Subscribe(ctx, "test", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	prev, err := evtman.Resequence(ctx, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev != 36 {
+		t.Fatalf("expected previous next seq of 36, got %d", prev)
+	}
+
+	select {
+	case evt := <-live:
+		if evt.RepoInfo == nil || evt.RepoInfo.Name != events.InfoSequenceRollover {
+			t.Fatalf("expected rollover notice, got %+v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for rollover notice")
+	}
+
+	addEvents(2)
+	if got := seqs(); !reflect.DeepEqual(got, []int64{1, 2, 3, 4, 5, 6}) {
+		t.Fatalf("unexpected events after rollover: %v", got)
+	}
+
+	// rolling forward needs no trimming
+	if _, err := evtman.Resequence(ctx, 1000); err != nil {
+		t.Fatal(err)
+	}
+	addEvents(1)
+	if got := seqs(); got[len(got)-1] != 1000 {
+		t.Fatalf("expected last seq 1000, got %v", got)
+	}
+}
+
+func TestDiskPersisterMaintenance(t *testing.T) {
+	ctx := context.Background()
+
+	db, _, _, tempPath, err := setupDBs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tempPath)
+
+	db.AutoMigrate(&models.ActorInfo{})
+	db.Create(&models.ActorInfo{
+		Uid: 1,
+		Did: "did:example:123",
+	})
+	db.Create(&models.ActorInfo{
+		Uid: 2,
+		Did: "did:example:456",
+	})
+
+	primaryDir := filepath.Join(tempPath, "diskPrimary")
+	opts := &events.DiskPersistOptions{
+		EventsPerFile: 10,
+		UIDCacheSize:  100000,
+		DIDCacheSize:  100000,
+	}
+	dp, err := events.NewDiskPersistence(primaryDir, filepath.Join(tempPath, "diskArchive"), db, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	evtman := events.NewEventManager(dp)
+
+	// odd seqs are from uid 1, even from uid 2
+	commit := lexutil.LexLink(cid.MustParse("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"))
+	for i := 0; i < 35; i++ {
+		repo := "did:example:123"
+		if i%2 == 1 {
+			repo = "did:example:456"
+		}
+		if err := evtman.AddEvent(ctx, &events.XRPCStreamEvent{
+			RepoCommit: &atproto.SyncSubscribeRepos_Commit{
+				Repo:   repo,
+				Commit: commit,
+				Time:   time.Now().Format(util.ISO8601),
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dp.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	seqs := func(dp *events.DiskPersistence) []int64 {
+		var out []int64
+		if err := dp.Playback(ctx, 0, func(evt *events.XRPCStreamEvent) error {
+			out = append(out, evt.RepoCommit.Seq)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	if err := dp.TakeDownRepo(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a bit in the first event of the second file (seq 11)
+	fn := filepath.Join(primaryDir, "evts-11")
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frameSize := len(data) / 10
+	data[frameSize-8] ^= 1
+	if err := os.WriteFile(fn, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// leave a partially written event at the end of the third file, and lose its index entry
+	fi, err := os.OpenFile(filepath.Join(primaryDir, "evts-21"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fi.Write(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	fi.Close()
+	if err := db.Where("path = ?", "evts-21").Delete(&events.LogFileRef{}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := evtman.RunMaintenance(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := events.MaintenanceReport{
+		IndexRepairs:   1,
+		FilesScanned:   3,
+		EventsVerified: 15,
+		CorruptEvents:  1,
+		FilesTruncated: 1,
+		FilesCompacted: 3,
+		BytesReclaimed: rep.BytesReclaimed,
+	}
+	if *rep != expected || rep.BytesReclaimed <= 10 {
+		t.Fatalf("unexpected maintenance report: %+v", rep)
+	}
+
+	var want []int64
+	for seq := int64(1); seq <= 35; seq += 2 {
+		if seq != 11 {
+			want = append(want, seq)
+		}
+	}
+	if got := seqs(dp); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected events after maintenance: %v", got)
+	}
+
+	// nothing left to do on a second pass
+	rep, err = dp.RunMaintenance(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.IndexRepairs != 0 || rep.CorruptEvents != 0 || rep.FilesTruncated != 0 || rep.FilesCompacted != 0 {
+		t.Fatalf("unexpected second maintenance report: %+v", rep)
+	}
+
+	// the index is rebuilt on startup if it's lost entirely
+	if err := dp.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Where("1 = 1").Delete(&events.LogFileRef{}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	dp2, err := events.NewDiskPersistence(primaryDir, filepath.Join(tempPath, "diskArchive"), db, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dp2.Shutdown(ctx)
+	events.NewEventManager(dp2)
+
+	if got := seqs(dp2); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected events after index rebuild: %v", got)
+	}
+}
+
+func TestDiskPersisterMaintenanceDuringRotation(t *testing.T) {
+	ctx := context.Background()
+
+	db, _, _, tempPath, err := setupDBs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tempPath)
+
+	db.AutoMigrate(&models.ActorInfo{})
+	db.Create(&models.ActorInfo{
+		Uid: 1,
+		Did: "did:example:123",
+	})
+	db.Create(&models.ActorInfo{
+		Uid: 2,
+		Did: "did:example:456",
+	})
+
+	opts := &events.DiskPersistOptions{
+		EventsPerFile: 4,
+		UIDCacheSize:  100000,
+		DIDCacheSize:  100000,
+	}
+	dp, err := events.NewDiskPersistence(filepath.Join(tempPath, "diskPrimary"), filepath.Join(tempPath, "diskArchive"), db, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dp.Shutdown(ctx)
+
+	evtman := events.NewEventManager(dp)
+
+	// keep rotating the log while maintenance runs. uid 2 is taken down as it goes, so there is always something for
+	// maintenance to compact; compacting a file that's still being written loses the events written to it after that
+	const total = 400
+	done := make(chan error, 1)
+	go func() {
+		commit := lexutil.LexLink(cid.MustParse("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"))
+		for i := 0; i < total; i++ {
+			repo := "did:example:123"
+			if i%2 == 1 {
+				repo = "did:example:456"
+			}
+			if err := evtman.AddEvent(ctx, &events.XRPCStreamEvent{
+				RepoCommit: &atproto.SyncSubscribeRepos_Commit{
+					Repo:   repo,
+					Commit: commit,
+					Time:   time.Now().Format(util.ISO8601),
+				},
+			}); err != nil {
+				done <- err
+				return
+			}
+			if i%4 == 3 {
+				if err := dp.Flush(ctx); err != nil {
+					done <- err
+					return
+				}
+				if err := dp.TakeDownRepo(ctx, 2); err != nil {
+					done <- err
+					return
+				}
+			}
+		}
+		done <- dp.Flush(ctx)
+	}()
+
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			running = false
+		default:
+		}
+
+		rep, err := dp.RunMaintenance(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.CorruptEvents != 0 || rep.FilesTruncated != 0 || rep.FilesUnreadable != 0 {
+			t.Fatalf("maintenance damaged live log files: %+v", rep)
+		}
+	}
+
+	var got []int64
+	if err := dp.Playback(ctx, 0, func(evt *events.XRPCStreamEvent) error {
+		got = append(got, evt.RepoCommit.Seq)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var want []int64
+	for seq := int64(1); seq <= total; seq += 2 {
+		want = append(want, seq)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected events after maintenance: %v", got)
+	}
+}
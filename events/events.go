@@ -7,19 +7,24 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/util/logging"
 	"github.com/prometheus/client_golang/prometheus"
 
-	logging "github.com/ipfs/go-log"
 	cbg "github.com/whyrusleeping/cbor-gen"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var log = logging.Logger("events")
+var log = logging.Component("events")
+
+// for messages which can be logged for every event in a stream
+var eventLog = logging.Sampled(log, 100)
 
 type Scheduler interface {
 	AddWork(ctx context.Context, repo string, val *XRPCStreamEvent) error
@@ -34,17 +39,20 @@ type EventManager struct {
 	crossoverBufferSize int
 
 	persister EventPersistence
+
+	buses BusConfig
+
+	// highest sequence number persisted by this process
+	lastSeq atomic.Int64
 }
 
+// NewEventManager makes an EventManager which persists and serves events in this process
 func NewEventManager(persister EventPersistence) *EventManager {
-	em := &EventManager{
-		bufferSize:          16 << 10,
-		crossoverBufferSize: 512,
-		persister:           persister,
+	em, err := NewEventManagerWithBuses(persister, LocalBuses())
+	if err != nil {
+		// subscribing to local buses can't fail
+		panic(err)
 	}
-
-	persister.SetEventBroadcaster(em.broadcastEvent)
-
 	return em
 }
 
@@ -60,14 +68,25 @@ type Operation struct {
 	evt *XRPCStreamEvent
 }
 
+// Shutdown stops taking in events, then flushes the persister and stops fanout
 func (em *EventManager) Shutdown(ctx context.Context) error {
-	return em.persister.Shutdown(ctx)
+	var errs []error
+	if err := em.buses.Ingest.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("closing ingest bus: %w", err))
+	}
+	if err := em.persister.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := em.buses.Fanout.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("closing fanout bus: %w", err))
+	}
+	return errors.Join(errs...)
 }
 
 func (em *EventManager) broadcastEvent(evt *XRPCStreamEvent) {
 	// the main thing we do is send it out, so MarshalCBOR once
 	if err := evt.Preserialize(); err != nil {
-		log.Errorf("broadcast serialize failed, %s", err)
+		log.Error("broadcast serialize failed,", "err", err)
 		// serialize isn't going to go better later, this event is cursed
 		return
 	}
@@ -93,7 +112,7 @@ func (em *EventManager) broadcastEvent(evt *XRPCStreamEvent) {
 				// code
 				s.filter = func(*XRPCStreamEvent) bool { return false }
 
-				log.Warnw("dropping slow consumer due to event overflow", "bufferSize", len(s.outgoing), "ident", s.ident)
+				log.Warn("dropping slow consumer due to event overflow", "bufferSize", len(s.outgoing), "ident", s.ident)
 				go func(torem *Subscriber) {
 					torem.lk.Lock()
 					if !torem.cleanedUp {
@@ -104,7 +123,7 @@ func (em *EventManager) broadcastEvent(evt *XRPCStreamEvent) {
 							},
 						}:
 						case <-time.After(time.Second * 5):
-							log.Warnw("failed to send error frame to backed up consumer", "ident", torem.ident)
+							log.Warn("failed to send error frame to backed up consumer", "ident", torem.ident)
 						}
 					}
 					torem.lk.Unlock()
@@ -121,7 +140,7 @@ func (em *EventManager) persistAndSendEvent(ctx context.Context, evt *XRPCStream
 	// accept a uid. The lookup inside the persister is notably expensive (despite
 	// being an lru cache?)
 	if err := em.persister.Persist(ctx, evt); err != nil {
-		log.Errorf("failed to persist outbound event: %s", err)
+		log.Error("failed to persist outbound event", "err", err)
 	}
 }
 
@@ -150,6 +169,8 @@ const (
 type EventHeader struct {
 	Op      int64  `cborgen:"op"`
 	MsgType string `cborgen:"t"`
+	// W3C trace context of the event, if it was traced. Not part of the atproto spec; consumers which don't know it ignore it
+	TraceParent string `cborgen:"traceparent,omitempty"`
 }
 
 var (
@@ -169,6 +190,7 @@ type XRPCStreamEvent struct {
 	RepoMigrate   *comatproto.SyncSubscribeRepos_Migrate
 	RepoTombstone *comatproto.SyncSubscribeRepos_Tombstone
 	RepoAccount   *comatproto.SyncSubscribeRepos_Account
+	RepoSync      *comatproto.SyncSubscribeRepos_Sync
 	LabelLabels   *comatproto.LabelSubscribeLabels_Labels
 	LabelInfo     *comatproto.LabelSubscribeLabels_Info
 
@@ -177,10 +199,14 @@ type XRPCStreamEvent struct {
 	PrivPdsId       uint       `json:"-" cborgen:"-"`
 	PrivRelevantPds []uint     `json:"-" cborgen:"-"`
 	Preserialized   []byte     `json:"-" cborgen:"-"`
+
+	// trace context the event was produced (or received) in, carried in the header on the wire
+	PrivSpanContext trace.SpanContext `json:"-" cborgen:"-"`
 }
 
 func (evt *XRPCStreamEvent) Serialize(wc io.Writer) error {
 	header := EventHeader{Op: EvtKindMessage}
+	header.setSpanContext(evt.PrivSpanContext)
 	var obj lexutil.CBOR
 
 	switch {
@@ -199,6 +225,9 @@ func (evt *XRPCStreamEvent) Serialize(wc io.Writer) error {
 	case evt.RepoAccount != nil:
 		header.MsgType = "#account"
 		obj = evt.RepoAccount
+	case evt.RepoSync != nil:
+		header.MsgType = "#sync"
+		obj = evt.RepoSync
 	case evt.RepoInfo != nil:
 		header.MsgType = "#info"
 		obj = evt.RepoInfo
@@ -241,9 +270,9 @@ type ErrorFrame struct {
 func (em *EventManager) AddEvent(ctx context.Context, ev *XRPCStreamEvent) error {
 	ctx, span := otel.Tracer("events").Start(ctx, "AddEvent")
 	defer span.End()
+	ev.PrivSpanContext = span.SpanContext()
 
-	em.persistAndSendEvent(ctx, ev)
-	return nil
+	return em.buses.Ingest.Publish(ctx, ev)
 }
 
 var (
@@ -252,6 +281,9 @@ var (
 )
 
 func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func(*XRPCStreamEvent) bool, since *int64) (<-chan *XRPCStreamEvent, func(), error) {
+	if !em.buses.Serve {
+		return nil, nil, ErrNotServing
+	}
 	if filter == nil {
 		filter = func(*XRPCStreamEvent) bool { return true }
 	}
@@ -298,9 +330,9 @@ func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func
 			}
 		}); err != nil {
 			if errors.Is(err, ErrPlaybackShutdown) {
-				log.Warnf("events playback: %s", err)
+				log.Warn("events playback", "err", err)
 			} else {
-				log.Errorf("events playback: %s", err)
+				log.Error("events playback", "err", err)
 			}
 
 			// TODO: send an error frame or something?
@@ -328,7 +360,7 @@ func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func
 			}
 		}); err != nil {
 			if !errors.Is(err, ErrCaughtUp) {
-				log.Errorf("events playback: %s", err)
+				log.Error("events playback", "err", err)
 
 				// TODO: send an error frame or something?
 				close(out)
@@ -365,6 +397,8 @@ func sequenceForEvent(evt *XRPCStreamEvent) int64 {
 		return evt.RepoTombstone.Seq
 	case evt.RepoIdentity != nil:
 		return evt.RepoIdentity.Seq
+	case evt.RepoSync != nil:
+		return evt.RepoSync.Seq
 	case evt.RepoInfo != nil:
 		return -1
 	case evt.Error != nil:
@@ -397,3 +431,20 @@ func (em *EventManager) addSubscriber(sub *Subscriber) {
 func (em *EventManager) TakeDownRepo(ctx context.Context, user models.Uid) error {
 	return em.persister.TakeDownRepo(ctx, user)
 }
+
+// PurgeRepo removes all of a repo's events from the persister, as TakeDownRepo, including any still buffered for writing, and drops anything the persister has cached about the account
+func (em *EventManager) PurgeRepo(ctx context.Context, user models.Uid, did string) error {
+	if err := em.persister.Flush(ctx); err != nil {
+		return fmt.Errorf("failed to flush buffered events: %w", err)
+	}
+
+	if err := em.persister.TakeDownRepo(ctx, user); err != nil {
+		return err
+	}
+
+	if f, ok := em.persister.(interface{ ForgetDid(did string) }); ok {
+		f.ForgetDid(did)
+	}
+
+	return nil
+}
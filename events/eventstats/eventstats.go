@@ -0,0 +1,308 @@
+// Package eventstats aggregates firehose events into per-collection and per-host counts and rates, for network-level visibility (posts per second, new accounts per hour, and so on). Totals are exported to Prometheus, and recent rates are served as JSON by [Aggregator.Handler].
+package eventstats
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/events"
+)
+
+// collections and hosts past the tracked limits are counted under this name
+const overflowLabel = "other"
+
+// counts are kept per minute, for the last hour
+const windowMinutes = 60
+
+// rates (per second) are averaged over the last few minutes
+const rateMinutes = 5
+
+type Options struct {
+	// Most collection NSIDs tracked individually. Ops in other collections are counted as "other", which bounds metric cardinality when accounts write arbitrary collections
+	MaxCollections int
+	// Most hosts tracked individually
+	MaxHosts int
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		MaxCollections: 200,
+		MaxHosts:       10_000,
+	}
+}
+
+type opKey struct {
+	collection string
+	action     string
+}
+
+// bucket holds the counts for one minute
+type bucket struct {
+	minute      int64
+	events      map[string]int64
+	ops         map[opKey]int64
+	hosts       map[string]int64
+	newAccounts int64
+}
+
+func newBucket(minute int64) *bucket {
+	return &bucket{
+		minute: minute,
+		events: make(map[string]int64),
+		ops:    make(map[opKey]int64),
+		hosts:  make(map[string]int64),
+	}
+}
+
+// Aggregator counts the events passed to HandleEvent. It is safe for concurrent use
+type Aggregator struct {
+	opts Options
+
+	lk          sync.Mutex
+	collections map[string]bool
+	hosts       map[string]bool
+	window      [windowMinutes]*bucket
+	started     time.Time
+
+	now func() time.Time
+}
+
+func New(opts *Options) *Aggregator {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	a := &Aggregator{
+		opts:        *opts,
+		collections: make(map[string]bool),
+		hosts:       make(map[string]bool),
+		now:         time.Now,
+	}
+	a.started = a.now()
+	return a
+}
+
+func eventType(evt *events.XRPCStreamEvent) string {
+	switch {
+	case evt.RepoCommit != nil:
+		return "commit"
+	case evt.RepoSync != nil:
+		return "sync"
+	case evt.RepoIdentity != nil:
+		return "identity"
+	case evt.RepoAccount != nil:
+		return "account"
+	case evt.RepoHandle != nil:
+		return "handle"
+	case evt.RepoMigrate != nil:
+		return "migrate"
+	case evt.RepoTombstone != nil:
+		return "tombstone"
+	case evt.RepoInfo != nil:
+		return "info"
+	case evt.Error != nil:
+		return "error"
+	}
+	return "unknown"
+}
+
+// label returns name if it is tracked (tracking it if there's room), or the overflow label
+func label(tracked map[string]bool, limit int, name string) string {
+	if tracked[name] {
+		return name
+	}
+	if len(tracked) >= limit {
+		return overflowLabel
+	}
+	tracked[name] = true
+	return name
+}
+
+// HandleEvent counts an event received from host. Host may be empty when the events' origin isn't known (eg, when consuming a relay's firehose)
+func (a *Aggregator) HandleEvent(host string, evt *events.XRPCStreamEvent) {
+	typ := eventType(evt)
+
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	b := a.bucket(a.now())
+	b.events[typ]++
+	eventsCounter.WithLabelValues(typ).Inc()
+
+	if host != "" {
+		host = label(a.hosts, a.opts.MaxHosts, strings.ToLower(host))
+		b.hosts[host]++
+		hostEventsCounter.WithLabelValues(host).Inc()
+	}
+
+	commit := evt.RepoCommit
+	if commit == nil {
+		return
+	}
+	// an account's first commit has no previous revision
+	if commit.Since == nil {
+		b.newAccounts++
+		newAccountsCounter.Inc()
+	}
+	for _, op := range commit.Ops {
+		collection, _, _ := strings.Cut(op.Path, "/")
+		collection = label(a.collections, a.opts.MaxCollections, collection)
+		b.ops[opKey{collection: collection, action: op.Action}]++
+		opsCounter.WithLabelValues(collection, op.Action).Inc()
+	}
+}
+
+// bucket returns the bucket for the minute containing t, replacing the one from an hour earlier
+func (a *Aggregator) bucket(t time.Time) *bucket {
+	minute := t.Unix() / 60
+	i := minute % windowMinutes
+	if b := a.window[i]; b != nil && b.minute == minute {
+		return b
+	}
+	b := newBucket(minute)
+	a.window[i] = b
+	return b
+}
+
+type CollectionStats struct {
+	Collection string `json:"collection"`
+	// Totals over the last hour
+	Creates int64 `json:"creates"`
+	Updates int64 `json:"updates"`
+	Deletes int64 `json:"deletes"`
+	// Recent rates, averaged over the last few minutes
+	CreatesPerSecond float64 `json:"creates_per_second"`
+	OpsPerSecond     float64 `json:"ops_per_second"`
+}
+
+type HostStats struct {
+	Host            string  `json:"host"`
+	Events          int64   `json:"events"`
+	EventsPerSecond float64 `json:"events_per_second"`
+}
+
+// Snapshot summarizes recent events. Totals cover the last hour (or the time since the aggregator started, if less), and rates the last few minutes
+type Snapshot struct {
+	Since time.Time `json:"since"`
+	// Events by type
+	Events             map[string]int64  `json:"events"`
+	EventsPerSecond    float64           `json:"events_per_second"`
+	NewAccounts        int64             `json:"new_accounts"`
+	NewAccountsPerHour float64           `json:"new_accounts_per_hour"`
+	Collections        []CollectionStats `json:"collections"`
+	Hosts              []HostStats       `json:"hosts"`
+}
+
+// Snapshot summarizes the events counted in the last hour
+func (a *Aggregator) Snapshot() *Snapshot {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	now := a.now()
+	minute := now.Unix() / 60
+
+	// rates are over the last rateMinutes buckets, including the current partial one
+	rateStart := time.Unix((minute-rateMinutes+1)*60, 0)
+	if a.started.After(rateStart) {
+		rateStart = a.started
+	}
+	rateSecs := max(now.Sub(rateStart).Seconds(), 1)
+	hourStart := time.Unix((minute-windowMinutes+1)*60, 0)
+	if a.started.After(hourStart) {
+		hourStart = a.started
+	}
+	hourSecs := max(now.Sub(hourStart).Seconds(), 1)
+
+	snap := &Snapshot{
+		Since:       hourStart,
+		Events:      make(map[string]int64),
+		Collections: []CollectionStats{},
+		Hosts:       []HostStats{},
+	}
+	collections := make(map[string]*CollectionStats)
+	hosts := make(map[string]*HostStats)
+	var recentEvents int64
+	for _, b := range a.window {
+		if b == nil || b.minute <= minute-windowMinutes || b.minute > minute {
+			continue
+		}
+		recent := b.minute > minute-rateMinutes
+
+		for typ, n := range b.events {
+			snap.Events[typ] += n
+			if recent {
+				recentEvents += n
+			}
+		}
+		snap.NewAccounts += b.newAccounts
+		for k, n := range b.ops {
+			cs := collections[k.collection]
+			if cs == nil {
+				cs = &CollectionStats{Collection: k.collection}
+				collections[k.collection] = cs
+			}
+			switch k.action {
+			case "create":
+				cs.Creates += n
+			case "update":
+				cs.Updates += n
+			case "delete":
+				cs.Deletes += n
+			}
+			if recent {
+				cs.OpsPerSecond += float64(n)
+				if k.action == "create" {
+					cs.CreatesPerSecond += float64(n)
+				}
+			}
+		}
+		for host, n := range b.hosts {
+			hs := hosts[host]
+			if hs == nil {
+				hs = &HostStats{Host: host}
+				hosts[host] = hs
+			}
+			hs.Events += n
+			if recent {
+				hs.EventsPerSecond += float64(n)
+			}
+		}
+	}
+
+	snap.EventsPerSecond = float64(recentEvents) / rateSecs
+	snap.NewAccountsPerHour = float64(snap.NewAccounts) / hourSecs * 3600
+	for _, cs := range collections {
+		cs.OpsPerSecond /= rateSecs
+		cs.CreatesPerSecond /= rateSecs
+		snap.Collections = append(snap.Collections, *cs)
+	}
+	sort.Slice(snap.Collections, func(i, j int) bool {
+		ci, cj := snap.Collections[i], snap.Collections[j]
+		if ti, tj := ci.Creates+ci.Updates+ci.Deletes, cj.Creates+cj.Updates+cj.Deletes; ti != tj {
+			return ti > tj
+		}
+		return ci.Collection < cj.Collection
+	})
+	for _, hs := range hosts {
+		hs.EventsPerSecond /= rateSecs
+		snap.Hosts = append(snap.Hosts, *hs)
+	}
+	sort.Slice(snap.Hosts, func(i, j int) bool {
+		if snap.Hosts[i].Events != snap.Hosts[j].Events {
+			return snap.Hosts[i].Events > snap.Hosts[j].Events
+		}
+		return snap.Hosts[i].Host < snap.Hosts[j].Host
+	})
+	return snap
+}
+
+// Handler serves the current Snapshot as JSON
+func (a *Aggregator) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.Snapshot())
+	})
+}
@@ -0,0 +1,90 @@
+package eventstats
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+)
+
+func commit(since *string, ops ...string) *events.XRPCStreamEvent {
+	evt := &events.XRPCStreamEvent{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{Since: since}}
+	for i := 0; i+1 < len(ops); i += 2 {
+		evt.RepoCommit.Ops = append(evt.RepoCommit.Ops, &comatproto.SyncSubscribeRepos_RepoOp{Action: ops[i], Path: ops[i+1]})
+	}
+	return evt
+}
+
+func TestAggregator(t *testing.T) {
+	// half way through a minute
+	now := time.Unix(1_700_000_070, 0)
+	opts := DefaultOptions()
+	opts.MaxCollections = 2
+	a := New(opts)
+	a.now = func() time.Time { return now }
+	a.started = now.Add(-2 * time.Hour)
+
+	rev := "3kabc"
+	// over an hour ago, so not counted
+	now = now.Add(-61 * time.Minute)
+	a.HandleEvent("pds.example.com", commit(&rev, "create", "app.bsky.feed.post/1"))
+
+	now = now.Add(time.Hour)
+	a.HandleEvent("pds.example.com", commit(nil, "create", "app.bsky.actor.profile/self"))
+	a.HandleEvent("PDS.example.com", commit(&rev, "create", "app.bsky.feed.post/2", "create", "app.bsky.feed.like/3"))
+	now = now.Add(time.Minute)
+	a.HandleEvent("other.example.com", commit(&rev, "update", "app.bsky.actor.profile/self", "delete", "app.bsky.feed.post/2"))
+	a.HandleEvent("other.example.com", &events.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{}})
+
+	snap := a.Snapshot()
+	if snap.Events["commit"] != 3 || snap.Events["identity"] != 1 {
+		t.Fatalf("unexpected event counts: %v", snap.Events)
+	}
+	if snap.NewAccounts != 1 {
+		t.Fatalf("expected 1 new account, got %d", snap.NewAccounts)
+	}
+	// rates cover the current half minute and the four before it
+	if snap.EventsPerSecond != 4.0/270 {
+		t.Fatalf("unexpected event rate: %f", snap.EventsPerSecond)
+	}
+
+	// only two collections are tracked individually
+	want := []CollectionStats{
+		{Collection: "app.bsky.actor.profile", Creates: 1, Updates: 1},
+		{Collection: "app.bsky.feed.post", Creates: 1, Deletes: 1},
+		{Collection: "other", Creates: 1},
+	}
+	if len(snap.Collections) != len(want) {
+		t.Fatalf("unexpected collections: %+v", snap.Collections)
+	}
+	for i, w := range want {
+		got := snap.Collections[i]
+		if got.Collection != w.Collection || got.Creates != w.Creates || got.Updates != w.Updates || got.Deletes != w.Deletes {
+			t.Fatalf("collection %d: got %+v, want %+v", i, got, w)
+		}
+	}
+
+	if len(snap.Hosts) != 2 || snap.Hosts[0].Host != "other.example.com" || snap.Hosts[0].Events != 2 || snap.Hosts[1].Events != 2 {
+		t.Fatalf("unexpected hosts: %+v", snap.Hosts)
+	}
+
+	// older minutes drop out of the window as it moves on
+	now = now.Add(59 * time.Minute)
+	snap = a.Snapshot()
+	if snap.Events["commit"] != 1 || snap.NewAccounts != 0 || snap.EventsPerSecond != 0 {
+		t.Fatalf("unexpected counts after an hour: %+v", snap)
+	}
+
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	var served Snapshot
+	if err := json.NewDecoder(rec.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	if served.Events["commit"] != 1 {
+		t.Fatalf("unexpected served snapshot: %+v", served)
+	}
+}
@@ -0,0 +1,26 @@
+package eventstats
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var eventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "eventstats_events_total",
+	Help: "The total number of firehose events seen, by type",
+}, []string{"type"})
+
+var hostEventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "eventstats_host_events_total",
+	Help: "The total number of firehose events seen from each host",
+}, []string{"host"})
+
+var opsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "eventstats_ops_total",
+	Help: "The total number of repo operations seen, by collection and action",
+}, []string{"collection", "action"})
+
+var newAccountsCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "eventstats_new_accounts_total",
+	Help: "The total number of accounts seen making their first commit",
+})
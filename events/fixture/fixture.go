@@ -0,0 +1,153 @@
+// Package fixture records slices of firehose traffic, along with the identities of the accounts involved, and replays them deterministically. It is intended for reproducible integration tests of firehose consumers (eg, automod, palomar, backfill).
+//
+// NOTE: recorded fixtures contain real-world content, which may be sensitive.
+package fixture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+)
+
+// Fixture is a recorded slice of firehose traffic
+type Fixture struct {
+	// Host the events were recorded from, if any
+	Host       string          `json:"host,omitempty"`
+	CapturedAt syntax.Datetime `json:"capturedAt"`
+	// Snapshot of the identities of accounts with events in the fixture, taken when recording finished
+	Identities []identity.Identity `json:"identities"`
+	// Stream messages (CBOR header and body, as sent over the websocket), in order
+	Frames [][]byte `json:"frames"`
+}
+
+func LoadFixture(path string) (*Fixture, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f Fixture
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parsing fixture %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// Test helper which loads a fixture, panicking on failure
+func MustLoadFixture(path string) *Fixture {
+	f, err := LoadFixture(path)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func (f *Fixture) Save(path string) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// Directory returns an identity directory containing only the fixture's snapshotted identities, so consumers resolve identities the same way on every replay.
+func (f *Fixture) Directory() *identity.MockDirectory {
+	dir := identity.NewMockDirectory()
+	for _, ident := range f.Identities {
+		dir.Insert(ident)
+	}
+	return &dir
+}
+
+// Events decodes all of the fixture's frames
+func (f *Fixture) Events() ([]*events.XRPCStreamEvent, error) {
+	out := make([]*events.XRPCStreamEvent, 0, len(f.Frames))
+	for i, frame := range f.Frames {
+		evt, err := decodeFrame(frame)
+		if err != nil {
+			return nil, fmt.Errorf("decoding fixture frame %d: %w", i, err)
+		}
+		out = append(out, evt)
+	}
+	return out, nil
+}
+
+func decodeFrame(frame []byte) (*events.XRPCStreamEvent, error) {
+	r := bytes.NewReader(frame)
+	var header events.EventHeader
+	if err := header.UnmarshalCBOR(r); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	var evt events.XRPCStreamEvent
+	switch header.Op {
+	case events.EvtKindMessage:
+		var err error
+		switch header.MsgType {
+		case "#commit":
+			evt.RepoCommit = &comatproto.SyncSubscribeRepos_Commit{}
+			err = evt.RepoCommit.UnmarshalCBOR(r)
+		case "#handle":
+			evt.RepoHandle = &comatproto.SyncSubscribeRepos_Handle{}
+			err = evt.RepoHandle.UnmarshalCBOR(r)
+		case "#identity":
+			evt.RepoIdentity = &comatproto.SyncSubscribeRepos_Identity{}
+			err = evt.RepoIdentity.UnmarshalCBOR(r)
+		case "#account":
+			evt.RepoAccount = &comatproto.SyncSubscribeRepos_Account{}
+			err = evt.RepoAccount.UnmarshalCBOR(r)
+		case "#sync":
+			evt.RepoSync = &comatproto.SyncSubscribeRepos_Sync{}
+			err = evt.RepoSync.UnmarshalCBOR(r)
+		case "#info":
+			evt.RepoInfo = &comatproto.SyncSubscribeRepos_Info{}
+			err = evt.RepoInfo.UnmarshalCBOR(r)
+		case "#migrate":
+			evt.RepoMigrate = &comatproto.SyncSubscribeRepos_Migrate{}
+			err = evt.RepoMigrate.UnmarshalCBOR(r)
+		case "#tombstone":
+			evt.RepoTombstone = &comatproto.SyncSubscribeRepos_Tombstone{}
+			err = evt.RepoTombstone.UnmarshalCBOR(r)
+		default:
+			return nil, fmt.Errorf("unsupported message type: %s", header.MsgType)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s event: %w", header.MsgType, err)
+		}
+	case events.EvtKindErrorFrame:
+		evt.Error = &events.ErrorFrame{}
+		if err := evt.Error.UnmarshalCBOR(r); err != nil {
+			return nil, fmt.Errorf("reading error frame: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unrecognized event stream type: %d", header.Op)
+	}
+	return &evt, nil
+}
+
+// eventDID returns the account an event is about, or empty for events which are not about a specific account
+func eventDID(evt *events.XRPCStreamEvent) string {
+	switch {
+	case evt.RepoCommit != nil:
+		return evt.RepoCommit.Repo
+	case evt.RepoHandle != nil:
+		return evt.RepoHandle.Did
+	case evt.RepoIdentity != nil:
+		return evt.RepoIdentity.Did
+	case evt.RepoAccount != nil:
+		return evt.RepoAccount.Did
+	case evt.RepoSync != nil:
+		return evt.RepoSync.Did
+	case evt.RepoMigrate != nil:
+		return evt.RepoMigrate.Did
+	case evt.RepoTombstone != nil:
+		return evt.RepoTombstone.Did
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,144 @@
+package fixture
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+func testEvents(t *testing.T) []*events.XRPCStreamEvent {
+	c, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: multihash.SHA2_256}.Sum([]byte("commit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := "alice.example.com"
+	return []*events.XRPCStreamEvent{
+		{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{
+			Seq:    1,
+			Repo:   "did:plc:alice",
+			Commit: lexutil.LexLink(c),
+			Blocks: []byte{1, 2, 3},
+			Ops:    []*comatproto.SyncSubscribeRepos_RepoOp{},
+			Blobs:  []lexutil.LexLink{},
+			Rev:    "3kb3xqyqbuc2k",
+			Time:   syntax.DatetimeNow().String(),
+		}},
+		{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{
+			Seq:    2,
+			Did:    "did:plc:alice",
+			Handle: &handle,
+			Time:   syntax.DatetimeNow().String(),
+		}},
+		{RepoAccount: &comatproto.SyncSubscribeRepos_Account{
+			Seq:    3,
+			Did:    "did:plc:deleted",
+			Active: false,
+			Time:   syntax.DatetimeNow().String(),
+		}},
+	}
+}
+
+func collectSeqs(f *Fixture) ([]int64, error) {
+	var seqs []int64
+	sched := sequential.NewScheduler("test", func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+		seqs = append(seqs, eventSeq(evt))
+		return nil
+	})
+	err := f.Replay(context.Background(), sched)
+	return seqs, err
+}
+
+func TestRecordReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{
+		DID:    "did:plc:alice",
+		Handle: "alice.example.com",
+	})
+
+	rec := NewRecorder(&dir, "")
+	for _, evt := range testEvents(t) {
+		if err := rec.HandleEvent(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := rec.Fixture(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the deleted account doesn't resolve
+	if len(f.Identities) != 1 || f.Identities[0].DID != "did:plc:alice" {
+		t.Fatalf("unexpected identities: %v", f.Identities)
+	}
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := f.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := MustLoadFixture(path)
+
+	seqs, err := collectSeqs(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 3 || seqs[0] != 1 || seqs[1] != 2 || seqs[2] != 3 {
+		t.Fatalf("unexpected replayed events: %v", seqs)
+	}
+	evts, err := loaded.Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evts[0].RepoCommit.Repo != "did:plc:alice" || string(evts[0].RepoCommit.Blocks) != "\x01\x02\x03" {
+		t.Fatalf("commit did not round-trip: %v", evts[0].RepoCommit)
+	}
+
+	ident, err := loaded.Directory().LookupHandle(ctx, "alice.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ident.DID != "did:plc:alice" {
+		t.Fatalf("unexpected identity: %v", ident)
+	}
+}
+
+func TestHandlerRecord(t *testing.T) {
+	ctx := context.Background()
+	rec := NewRecorder(nil, "")
+	for _, evt := range testEvents(t) {
+		if err := rec.HandleEvent(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := rec.Fixture(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(f.Handler())
+	defer srv.Close()
+
+	// re-record from the served fixture, starting after the first event
+	again, err := Record(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), 1, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seqs, err := collectSeqs(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 2 || seqs[0] != 2 || seqs[1] != 3 {
+		t.Fatalf("unexpected recorded events: %v", seqs)
+	}
+}
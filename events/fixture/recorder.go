@@ -0,0 +1,137 @@
+package fixture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+
+	"github.com/gorilla/websocket"
+)
+
+// Recorder accumulates firehose events in to a Fixture. HandleEvent can be used directly as a scheduler callback. Safe for concurrent use, though events are recorded in the order HandleEvent is called.
+type Recorder struct {
+	// Used to snapshot the identities of accounts with recorded events. If nil, the fixture will not include identities.
+	Directory identity.Directory
+	Host      string
+	Logger    *slog.Logger
+
+	lk     sync.Mutex
+	frames [][]byte
+	dids   []string
+	seen   map[string]bool
+}
+
+func NewRecorder(dir identity.Directory, host string) *Recorder {
+	return &Recorder{
+		Directory: dir,
+		Host:      host,
+		Logger:    slog.Default(),
+		seen:      make(map[string]bool),
+	}
+}
+
+func (r *Recorder) HandleEvent(ctx context.Context, evt *events.XRPCStreamEvent) error {
+	var buf bytes.Buffer
+	if err := evt.Serialize(&buf); err != nil {
+		// eg, label events, which aren't part of the repo stream
+		r.Logger.Warn("skipping event which can not be recorded", "err", err)
+		return nil
+	}
+
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.frames = append(r.frames, buf.Bytes())
+	if did := eventDID(evt); did != "" && !r.seen[did] {
+		r.seen[did] = true
+		r.dids = append(r.dids, did)
+	}
+	return nil
+}
+
+// Len returns the number of events recorded so far
+func (r *Recorder) Len() int {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return len(r.frames)
+}
+
+// Fixture resolves the identities of all accounts seen so far, and returns a fixture of the recorded events. Accounts which no longer resolve are left out of the identity snapshot.
+func (r *Recorder) Fixture(ctx context.Context) (*Fixture, error) {
+	r.lk.Lock()
+	frames := append([][]byte{}, r.frames...)
+	dids := append([]string{}, r.dids...)
+	r.lk.Unlock()
+
+	f := &Fixture{
+		Host:       r.Host,
+		CapturedAt: syntax.DatetimeNow(),
+		Identities: []identity.Identity{},
+		Frames:     frames,
+	}
+	if r.Directory == nil {
+		return f, nil
+	}
+	for _, raw := range dids {
+		did, err := syntax.ParseDID(raw)
+		if err != nil {
+			r.Logger.Warn("skipping invalid DID in event", "did", raw, "err", err)
+			continue
+		}
+		ident, err := r.Directory.LookupDID(ctx, did)
+		if errors.Is(err, identity.ErrDIDNotFound) {
+			r.Logger.Warn("account identity not found, leaving it out of fixture", "did", did)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("snapshotting identity %s: %w", did, err)
+		}
+		f.Identities = append(f.Identities, *ident)
+	}
+	return f, nil
+}
+
+// Record subscribes to the repo stream of a relay or PDS (eg, "wss://bsky.network"), optionally from a cursor, and records count events in to a fixture, snapshotting identities with the given directory.
+func Record(ctx context.Context, host string, cursor int64, count int, dir identity.Directory) (*Fixture, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host URI: %w", err)
+	}
+	u.Path = "xrpc/com.atproto.sync.subscribeRepos"
+	if cursor != 0 {
+		u.RawQuery = "cursor=" + strconv.FormatInt(cursor, 10)
+	}
+	con, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), http.Header{})
+	if err != nil {
+		return nil, fmt.Errorf("subscribing to firehose failed (dialing): %w", err)
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rec := NewRecorder(dir, host)
+	sched := sequential.NewScheduler("fixture-recorder", func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+		if rec.Len() >= count {
+			return nil
+		}
+		if err := rec.HandleEvent(ctx, evt); err != nil {
+			return err
+		}
+		if rec.Len() >= count {
+			cancel()
+		}
+		return nil
+	})
+	if err := events.HandleRepoStream(streamCtx, con, sched); err != nil && rec.Len() < count {
+		return nil, fmt.Errorf("recording firehose: %w", err)
+	}
+	return rec.Fixture(ctx)
+}
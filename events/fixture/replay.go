@@ -0,0 +1,100 @@
+package fixture
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bluesky-social/indigo/events"
+
+	"github.com/gorilla/websocket"
+)
+
+// Replay feeds the fixture's events, in recorded order, to a scheduler, then shuts the scheduler down (waiting for in-flight work). With the sequential scheduler, events are processed one at a time, so replays are deterministic.
+func (f *Fixture) Replay(ctx context.Context, sched events.Scheduler) error {
+	defer sched.Shutdown()
+
+	evts, err := f.Events()
+	if err != nil {
+		return err
+	}
+	for _, evt := range evts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := sched.AddWork(ctx, eventDID(evt), evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves the fixture as a com.atproto.sync.subscribeRepos websocket stream, for consumers which dial a relay themselves. The "cursor" query parameter is respected. After the last event the connection is held open (like a quiet firehose) until the client disconnects.
+//
+// For example, serve it with net/http/httptest and point the consumer at the test server's URL.
+func (f *Fixture) Handler() http.Handler {
+	upgrader := websocket.Upgrader{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cursor int64
+		if c := r.URL.Query().Get("cursor"); c != "" {
+			v, err := strconv.ParseInt(c, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid cursor: %s", err), http.StatusBadRequest)
+				return
+			}
+			cursor = v
+		}
+
+		evts, err := f.Events()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for i, evt := range evts {
+			if cursor > 0 {
+				if seq := eventSeq(evt); seq > 0 && seq <= cursor {
+					continue
+				}
+			}
+			if err := conn.WriteMessage(websocket.BinaryMessage, f.Frames[i]); err != nil {
+				return
+			}
+		}
+
+		// wait for the client to go away; the default ping handler answers pings along the way
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func eventSeq(evt *events.XRPCStreamEvent) int64 {
+	switch {
+	case evt.RepoCommit != nil:
+		return evt.RepoCommit.Seq
+	case evt.RepoHandle != nil:
+		return evt.RepoHandle.Seq
+	case evt.RepoIdentity != nil:
+		return evt.RepoIdentity.Seq
+	case evt.RepoAccount != nil:
+		return evt.RepoAccount.Seq
+	case evt.RepoSync != nil:
+		return evt.RepoSync.Seq
+	case evt.RepoMigrate != nil:
+		return evt.RepoMigrate.Seq
+	case evt.RepoTombstone != nil:
+		return evt.RepoTombstone.Seq
+	default:
+		return 0
+	}
+}
@@ -24,3 +24,18 @@ var eventsBroadcast = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "indigo_events_broadcast_total",
 	Help: "Total number of events broadcast to subscribers",
 }, []string{"pool"})
+
+var streamPongsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_repo_stream_pongs_total",
+	Help: "Total number of pongs received from the stream, in answer to keepalive pings",
+}, []string{"remote_addr"})
+
+var streamStaleCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_repo_stream_stale_total",
+	Help: "Total number of stream connections dropped after nothing was received within the read timeout",
+}, []string{"remote_addr"})
+
+var streamReconnectsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_repo_stream_reconnects_total",
+	Help: "Total number of times a stream client resubscribed after its connection failed",
+}, []string{"host"})
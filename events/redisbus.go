@@ -0,0 +1,187 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type RedisBusOptions struct {
+	// Approximate number of events kept in the stream
+	MaxLen int64
+	// Consumer group to subscribe as. Each event is delivered to one subscriber per group, and a group carries on from where it left off after a restart. With no group, subscribers only see events published after they subscribe
+	Group string
+	// Name of this subscriber within its group (defaults to the hostname). Events delivered to a consumer but not handled are delivered to it again when it next subscribes
+	Consumer string
+}
+
+func DefaultRedisBusOptions() *RedisBusOptions {
+	return &RedisBusOptions{
+		MaxLen: 1_000_000,
+	}
+}
+
+// RedisBus is a Bus on a Redis stream, so the stages of an EventManager can run in separate processes
+type RedisBus struct {
+	client redis.UniversalClient
+	stream string
+	opts   RedisBusOptions
+
+	ctx    context.Context
+	cancel func()
+	wg     sync.WaitGroup
+}
+
+// NewRedisBus makes a bus on the given stream. The client is not closed with the bus
+func NewRedisBus(client redis.UniversalClient, stream string, opts *RedisBusOptions) *RedisBus {
+	if opts == nil {
+		opts = DefaultRedisBusOptions()
+	}
+	b := &RedisBus{
+		client: client,
+		stream: stream,
+		opts:   *opts,
+	}
+	if b.opts.Group != "" && b.opts.Consumer == "" {
+		b.opts.Consumer, _ = os.Hostname()
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	return b
+}
+
+func (b *RedisBus) Publish(ctx context.Context, evt *XRPCStreamEvent) error {
+	// events are published before they are sequenced, so this can't be kept as evt.Preserialized
+	var buf bytes.Buffer
+	if err := evt.Serialize(&buf); err != nil {
+		return err
+	}
+	return b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.stream,
+		MaxLen: b.opts.MaxLen,
+		Approx: true,
+		Values: map[string]any{"evt": buf.Bytes()},
+	}).Err()
+}
+
+func (b *RedisBus) Subscribe(handle func(context.Context, *XRPCStreamEvent) error) error {
+	if b.ctx.Err() != nil {
+		return ErrBusClosed
+	}
+
+	// with a group, start with any events delivered to this consumer before but never acknowledged
+	next := "0"
+	if b.opts.Group != "" {
+		err := b.client.XGroupCreateMkStream(b.ctx, b.stream, b.opts.Group, "$").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return fmt.Errorf("creating consumer group: %w", err)
+		}
+	} else {
+		// start from the latest event in the stream
+		msgs, err := b.client.XRevRangeN(b.ctx, b.stream, "+", "-", 1).Result()
+		if err != nil {
+			return fmt.Errorf("reading stream: %w", err)
+		}
+		if len(msgs) > 0 {
+			next = msgs[0].ID
+		}
+	}
+
+	b.wg.Add(1)
+	go b.consume(next, handle)
+	return nil
+}
+
+func (b *RedisBus) consume(next string, handle func(context.Context, *XRPCStreamEvent) error) {
+	defer b.wg.Done()
+
+	pending := b.opts.Group != ""
+	for b.ctx.Err() == nil {
+		var streams []redis.XStream
+		var err error
+		if b.opts.Group != "" {
+			id := ">"
+			if pending {
+				id = next
+			}
+			streams, err = b.client.XReadGroup(b.ctx, &redis.XReadGroupArgs{
+				Group:    b.opts.Group,
+				Consumer: b.opts.Consumer,
+				Streams:  []string{b.stream, id},
+				Count:    100,
+				Block:    5 * time.Second,
+			}).Result()
+		} else {
+			streams, err = b.client.XRead(b.ctx, &redis.XReadArgs{
+				Streams: []string{b.stream, next},
+				Count:   100,
+				Block:   5 * time.Second,
+			}).Result()
+		}
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			log.Error("failed to read from event bus", "err", err, "stream", b.stream)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		var msgs []redis.XMessage
+		for _, s := range streams {
+			msgs = append(msgs, s.Messages...)
+		}
+		if pending && len(msgs) == 0 {
+			// caught up on this consumer's pending events, so move on to new ones
+			pending = false
+			continue
+		}
+
+		for _, msg := range msgs {
+			next = msg.ID
+			raw, ok := msg.Values["evt"].(string)
+			if !ok {
+				log.Error("malformed event bus message", "stream", b.stream, "id", msg.ID)
+				b.ack(msg.ID)
+				continue
+			}
+			evt, err := DecodeEvent(strings.NewReader(raw))
+			if err != nil {
+				log.Error("failed to decode event from bus", "err", err, "stream", b.stream, "id", msg.ID)
+				b.ack(msg.ID)
+				continue
+			}
+			if err := handle(context.Background(), evt); err != nil {
+				// left pending, to be delivered again when this consumer next subscribes
+				log.Error("failed to handle event from bus", "err", err, "stream", b.stream, "id", msg.ID)
+				continue
+			}
+			b.ack(msg.ID)
+		}
+	}
+}
+
+func (b *RedisBus) ack(id string) {
+	if b.opts.Group == "" {
+		return
+	}
+	if err := b.client.XAck(context.Background(), b.stream, b.opts.Group, id).Err(); err != nil {
+		log.Error("failed to acknowledge event on bus", "err", err, "stream", b.stream, "id", id)
+	}
+}
+
+// Close stops subscriptions, once they finish handling their current events
+func (b *RedisBus) Close() error {
+	b.cancel()
+	b.wg.Wait()
+	return nil
+}
@@ -16,7 +16,7 @@ func ConsumeRepoStreamLite2(ctx context.Context, con *websocket.Conn, cb LiteStr
 		return HandleRepoStream(ctx, con, &RepoStreamCallbacks{
 			RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
 				if evt.TooBig {
-					log.Errorf("skipping too big events for now: %d", evt.Seq)
+					log.Error("skipping too big events for now", "seq", evt.Seq)
 					return nil
 				}
 				r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(evt.Blocks))
@@ -31,7 +31,7 @@ func ConsumeRepoStreamLite2(ctx context.Context, con *websocket.Conn, cb LiteStr
 						rc, rec, err := r.GetRecord(ctx, op.Path)
 						if err != nil {
 							e := fmt.Errorf("getting record %s (%s) within seq %d for %s: %w", op.Path, *op.Cid, evt.Seq, evt.Repo, err)
-							log.Error(e)
+							log.Error("failed to read record", "err", e)
 							continue
 						}
 
@@ -41,13 +41,13 @@ func ConsumeRepoStreamLite2(ctx context.Context, con *websocket.Conn, cb LiteStr
 						}
 
 						if err := cb(ek, evt.Seq, op.Path, evt.Repo, &rc, rec); err != nil {
-							log.Errorf("event consumer callback (%s): %s", ek, err)
+							log.Error("event consumer callback failed", "kind", ek, "err", err)
 							continue
 						}
 
 					case repomgr.EvtKindDeleteRecord:
 						if err := cb(ek, evt.Seq, op.Path, evt.Repo, nil, nil); err != nil {
-							log.Errorf("event consumer callback (%s): %s", ek, err)
+							log.Error("event consumer callback failed", "kind", ek, "err", err)
 							continue
 						}
 					}
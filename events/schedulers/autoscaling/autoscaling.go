@@ -7,11 +7,11 @@ import (
 
 	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/events/schedulers"
-	logging "github.com/ipfs/go-log"
+	"github.com/bluesky-social/indigo/util/logging"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var log = logging.Logger("autoscaling-scheduler")
+var log = logging.Component("autoscaling-scheduler")
 
 // Scheduler is a scheduler that will scale up and down the number of workers based on the throughput of the workers.
 type Scheduler struct {
@@ -111,7 +111,7 @@ func NewScheduler(autoscaleSettings AutoscaleSettings, ident string, do func(con
 }
 
 func (p *Scheduler) Shutdown() {
-	log.Debugf("shutting down autoscaling scheduler for %s", p.ident)
+	log.Debug("shutting down autoscaling scheduler", "ident", p.ident)
 
 	// stop autoscaling
 	p.autoscalerIn <- struct{}{}
@@ -197,7 +197,7 @@ func (p *Scheduler) AddWork(ctx context.Context, repo string, val *events.XRPCSt
 }
 
 func (p *Scheduler) worker() {
-	log.Debugf("starting autoscaling worker for %s", p.ident)
+	log.Debug("starting autoscaling worker", "ident", p.ident)
 	p.workersActive.Inc()
 	p.workerGroup.Add(1)
 	defer p.workerGroup.Done()
@@ -205,21 +205,21 @@ func (p *Scheduler) worker() {
 		for work != nil {
 			// Check if the work item contains a signal to stop the worker.
 			if work.signal == "stop" {
-				log.Debugf("stopping autoscaling worker for %s", p.ident)
+				log.Debug("stopping autoscaling worker", "ident", p.ident)
 				p.workersActive.Dec()
 				return
 			}
 
 			p.itemsActive.Inc()
-			if err := p.do(context.TODO(), work.val); err != nil {
-				log.Errorf("event handler failed: %s", err)
+			if err := p.do(work.val.TraceContext(context.TODO()), work.val); err != nil {
+				log.Error("event handler failed", "err", err)
 			}
 			p.itemsProcessed.Inc()
 
 			p.lk.Lock()
 			rem, ok := p.active[work.repo]
 			if !ok {
-				log.Errorf("should always have an 'active' entry if a worker is processing a job")
+				log.Error("should always have an 'active' entry if a worker is processing a job")
 			}
 
 			if len(rem) == 0 {
@@ -6,12 +6,12 @@ import (
 
 	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/events/schedulers"
-	logging "github.com/ipfs/go-log"
+	"github.com/bluesky-social/indigo/util/logging"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var log = logging.Logger("parallel-scheduler")
+var log = logging.Component("parallel-scheduler")
 
 // Scheduler is a parallel scheduler that will run work on a fixed number of workers
 type Scheduler struct {
@@ -64,7 +64,7 @@ func NewScheduler(maxC, maxQ int, ident string, do func(context.Context, *events
 }
 
 func (p *Scheduler) Shutdown() {
-	log.Infof("shutting down parallel scheduler for %s", p.ident)
+	log.Info("shutting down parallel scheduler", "ident", p.ident)
 
 	for i := 0; i < p.maxConcurrency; i++ {
 		p.feeder <- &consumerTask{
@@ -122,15 +122,15 @@ func (p *Scheduler) worker() {
 			}
 
 			p.itemsActive.Inc()
-			if err := p.do(context.TODO(), work.val); err != nil {
-				log.Errorf("event handler failed: %s", err)
+			if err := p.do(work.val.TraceContext(context.TODO()), work.val); err != nil {
+				log.Error("event handler failed", "err", err)
 			}
 			p.itemsProcessed.Inc()
 
 			p.lk.Lock()
 			rem, ok := p.active[work.repo]
 			if !ok {
-				log.Errorf("should always have an 'active' entry if a worker is processing a job")
+				log.Error("should always have an 'active' entry if a worker is processing a job")
 			}
 
 			if len(rem) == 0 {
@@ -5,11 +5,11 @@ import (
 
 	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/events/schedulers"
-	logging "github.com/ipfs/go-log"
+	"github.com/bluesky-social/indigo/util/logging"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var log = logging.Logger("sequential-scheduler")
+var log = logging.Component("sequential-scheduler")
 
 // Scheduler is a sequential scheduler that will run work on a single worker
 type Scheduler struct {
@@ -0,0 +1,194 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SegmentFormat identifies firehose segment files, in their header
+const SegmentFormat = "atproto-firehose-segment"
+
+const SegmentVersion = 1
+
+// largest section accepted when reading a segment, as a sanity check against corrupt files
+const maxSegmentSection = 16 << 20
+
+// SegmentHeader describes the events in a firehose segment.
+//
+// A segment is a self-describing file of firehose events, laid out like a CAR file: a sequence of sections, each prefixed with its length as an unsigned varint. The first section is the header, as JSON; every following section is one event, framed as it is on the firehose (a CBOR header followed by the CBOR event body). Segments can be split or concatenated (after the header) without decoding any events.
+type SegmentHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	// Host the events were exported from, if known
+	Host string `json:"host,omitempty"`
+	// Sequence range requested: events after Since, up to and including Until (if non-zero)
+	Since     int64     `json:"since"`
+	Until     int64     `json:"until,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SegmentWriter writes a firehose segment (see SegmentHeader)
+type SegmentWriter struct {
+	w   *bufio.Writer
+	buf bytes.Buffer
+	n   int
+}
+
+// NewSegmentWriter writes the segment header to w; Format, Version, and (if unset) CreatedAt are filled in
+func NewSegmentWriter(w io.Writer, hdr SegmentHeader) (*SegmentWriter, error) {
+	hdr.Format = SegmentFormat
+	hdr.Version = SegmentVersion
+	if hdr.CreatedAt.IsZero() {
+		hdr.CreatedAt = time.Now().UTC()
+	}
+	b, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, err
+	}
+	sw := &SegmentWriter{w: bufio.NewWriter(w)}
+	if err := sw.writeSection(b); err != nil {
+		return nil, fmt.Errorf("failed to write segment header: %w", err)
+	}
+	return sw, nil
+}
+
+func (sw *SegmentWriter) writeSection(b []byte) error {
+	var lbuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lbuf[:], uint64(len(b)))
+	if _, err := sw.w.Write(lbuf[:n]); err != nil {
+		return err
+	}
+	_, err := sw.w.Write(b)
+	return err
+}
+
+func (sw *SegmentWriter) WriteEvent(evt *XRPCStreamEvent) error {
+	sw.buf.Reset()
+	if err := evt.Serialize(&sw.buf); err != nil {
+		return err
+	}
+	return sw.WriteFrame(sw.buf.Bytes())
+}
+
+// WriteFrame writes an event which is already framed as it is on the firehose (eg, from SegmentReader.NextFrame)
+func (sw *SegmentWriter) WriteFrame(frame []byte) error {
+	if err := sw.writeSection(frame); err != nil {
+		return err
+	}
+	sw.n++
+	return nil
+}
+
+// Count returns the number of events written
+func (sw *SegmentWriter) Count() int {
+	return sw.n
+}
+
+// Flush writes any buffered data to the underlying writer
+func (sw *SegmentWriter) Flush() error {
+	return sw.w.Flush()
+}
+
+// SegmentReader reads the events in a firehose segment (see SegmentHeader)
+type SegmentReader struct {
+	Header SegmentHeader
+
+	r *bufio.Reader
+}
+
+// NewSegmentReader reads and checks the segment header from r
+func NewSegmentReader(r io.Reader) (*SegmentReader, error) {
+	sr := &SegmentReader{r: bufio.NewReader(r)}
+	b, err := sr.readSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read segment header: %w", err)
+	}
+	if err := json.Unmarshal(b, &sr.Header); err != nil {
+		return nil, fmt.Errorf("failed to parse segment header: %w", err)
+	}
+	if sr.Header.Format != SegmentFormat {
+		return nil, fmt.Errorf("not a firehose segment (format %q)", sr.Header.Format)
+	}
+	if sr.Header.Version != SegmentVersion {
+		return nil, fmt.Errorf("unsupported firehose segment version %d", sr.Header.Version)
+	}
+	return sr, nil
+}
+
+func (sr *SegmentReader) readSection() ([]byte, error) {
+	l, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		return nil, err
+	}
+	if l > maxSegmentSection {
+		return nil, fmt.Errorf("segment section too large (%d bytes)", l)
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
+
+// NextFrame returns the next event's firehose frame, or io.EOF at the end of the segment
+func (sr *SegmentReader) NextFrame() ([]byte, error) {
+	b, err := sr.readSection()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("failed to read segment event: %w", err)
+	}
+	return b, nil
+}
+
+// Next returns the next event, or io.EOF at the end of the segment
+func (sr *SegmentReader) Next() (*XRPCStreamEvent, error) {
+	b, err := sr.NextFrame()
+	if err != nil {
+		return nil, err
+	}
+	return DecodeEvent(bytes.NewReader(b))
+}
+
+// ExportSegment writes persisted events after since, up to and including until (if non-zero), to w as a firehose segment. It returns the number of events written.
+func (em *EventManager) ExportSegment(ctx context.Context, host string, since, until int64, w io.Writer) (int, error) {
+	sw, err := NewSegmentWriter(w, SegmentHeader{Host: host, Since: since, Until: until})
+	if err != nil {
+		return 0, err
+	}
+	n, err := em.exportRange(ctx, since, until, sw.WriteEvent)
+	if ferr := sw.Flush(); err == nil {
+		err = ferr
+	}
+	return n, err
+}
+
+// ImportSegment adds the events in a firehose segment, in order, as if they had just been received. They are renumbered in this event manager's sequence, and sent to live subscribers. It returns the number of events imported.
+func (em *EventManager) ImportSegment(ctx context.Context, r io.Reader) (int, error) {
+	sr, err := NewSegmentReader(r)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for {
+		evt, err := sr.Next()
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("event %d: %w", n, err)
+		}
+		if err := em.AddEvent(ctx, evt); err != nil {
+			return n, fmt.Errorf("failed to add event %d: %w", n, err)
+		}
+		n++
+	}
+}
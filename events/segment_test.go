@@ -0,0 +1,110 @@
+package events_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestSegmentExportImport(t *testing.T) {
+	ctx := context.Background()
+
+	src := events.NewEventManager(events.NewMemPersister())
+	commit := lexutil.LexLink(cid.MustParse("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"))
+	for i := 0; i < 30; i++ {
+		evt := &events.XRPCStreamEvent{
+			RepoCommit: &atproto.SyncSubscribeRepos_Commit{
+				Repo:   "did:example:123",
+				Commit: commit,
+				Time:   time.Now().Format(time.RFC3339),
+			},
+		}
+		if i%10 == 0 {
+			evt = &events.XRPCStreamEvent{
+				RepoIdentity: &atproto.SyncSubscribeRepos_Identity{Did: "did:example:123"},
+			}
+		}
+		if err := src.AddEvent(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := src.ExportSegment(ctx, "relay.example.com", 5, 25, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 20 {
+		t.Fatalf("expected 20 exported events, got %d", n)
+	}
+	exported := buf.Bytes()
+
+	sr, err := events.NewSegmentReader(bytes.NewReader(exported))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.Header.Host != "relay.example.com" || sr.Header.Since != 5 || sr.Header.Until != 25 || sr.Header.CreatedAt.IsZero() {
+		t.Fatalf("unexpected segment header: %+v", sr.Header)
+	}
+	for seq := int64(6); seq <= 25; seq++ {
+		evt, err := sr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case evt.RepoCommit != nil && evt.RepoCommit.Seq == seq:
+		case evt.RepoIdentity != nil && evt.RepoIdentity.Seq == seq && seq%10 == 1:
+		default:
+			t.Fatalf("unexpected event at seq %d: %+v", seq, evt)
+		}
+	}
+	if _, err := sr.Next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected end of segment, got %v", err)
+	}
+
+	// importing renumbers the events in the destination's sequence
+	dp := events.NewMemPersister()
+	dst := events.NewEventManager(dp)
+	n, err = dst.ImportSegment(ctx, bytes.NewReader(exported))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 20 {
+		t.Fatalf("expected 20 imported events, got %d", n)
+	}
+	var seq int64
+	var identities int
+	if err := dp.Playback(ctx, 0, func(evt *events.XRPCStreamEvent) error {
+		seq++
+		switch {
+		case evt.RepoCommit != nil && evt.RepoCommit.Seq == seq:
+		case evt.RepoIdentity != nil && evt.RepoIdentity.Seq == seq:
+			identities++
+		default:
+			t.Fatalf("unexpected imported event at seq %d: %+v", seq, evt)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if seq != 20 || identities != 2 {
+		t.Fatalf("expected 20 imported events with 2 identity events, got %d and %d", seq, identities)
+	}
+
+	// truncated segments are an error, not a short read
+	if _, err := dst.ImportSegment(ctx, bytes.NewReader(exported[:len(exported)-3])); err == nil {
+		t.Fatal("expected error importing truncated segment")
+	}
+	if _, err := events.NewSegmentReader(bytes.NewReader([]byte("{}"))); err == nil {
+		t.Fatal("expected error reading non-segment")
+	}
+}
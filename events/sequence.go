@@ -0,0 +1,289 @@
+package events
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// InfoSequenceRollover is the name of the #info event sent to live consumers when the event sequence is renumbered (see SequenceManager)
+const InfoSequenceRollover = "SequenceRollover"
+
+var (
+	ErrSequenceToolsUnsupported = fmt.Errorf("event persister does not support sequence maintenance")
+	ErrLiveEventsAfterSequence  = fmt.Errorf("persister holds events at or after the requested sequence number")
+)
+
+// SequenceManager is implemented by persisters which support maintenance of their event sequence, for recovering from corruption
+type SequenceManager interface {
+	// TrimEvents hides the events numbered from..to (inclusive) from playback, dropping log files left with no events. It returns the number of events trimmed.
+	TrimEvents(ctx context.Context, from, to int64) (int, error)
+	// Resequence numbers all subsequent events from next, and sends an InfoSequenceRollover event to live consumers. Moving the sequence backwards requires all events at or after next to have been trimmed first. It returns the sequence number which would otherwise have been assigned next.
+	Resequence(ctx context.Context, next int64) (int64, error)
+}
+
+var errExportDone = errors.New("export complete")
+
+// Export writes persisted events after since, up to and including until (if non-zero), to w. Each event is written as it would be framed over the firehose: a CBOR header followed by the CBOR event body. It returns the number of events written.
+func (em *EventManager) Export(ctx context.Context, since, until int64, w io.Writer) (int, error) {
+	return em.exportRange(ctx, since, until, func(evt *XRPCStreamEvent) error {
+		return evt.Serialize(w)
+	})
+}
+
+// exportRange plays back persisted events after since, up to and including until (if non-zero), returning the number passed to cb
+func (em *EventManager) exportRange(ctx context.Context, since, until int64, cb func(*XRPCStreamEvent) error) (int, error) {
+	if err := em.persister.Flush(ctx); err != nil {
+		return 0, fmt.Errorf("failed to flush buffered events: %w", err)
+	}
+
+	var n int
+	err := em.persister.Playback(ctx, since, func(evt *XRPCStreamEvent) error {
+		if until > 0 && sequenceForEvent(evt) > until {
+			return errExportDone
+		}
+
+		if err := cb(evt); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil && !errors.Is(err, errExportDone) {
+		return n, err
+	}
+
+	return n, nil
+}
+
+// TrimEvents hides a range of events from playback (see SequenceManager)
+func (em *EventManager) TrimEvents(ctx context.Context, from, to int64) (int, error) {
+	sm, ok := em.persister.(SequenceManager)
+	if !ok {
+		return 0, ErrSequenceToolsUnsupported
+	}
+	return sm.TrimEvents(ctx, from, to)
+}
+
+// Resequence renumbers subsequent events from next (see SequenceManager)
+func (em *EventManager) Resequence(ctx context.Context, next int64) (int64, error) {
+	sm, ok := em.persister.(SequenceManager)
+	if !ok {
+		return 0, ErrSequenceToolsUnsupported
+	}
+	return sm.Resequence(ctx, next)
+}
+
+var _ (SequenceManager) = (*DiskPersistence)(nil)
+
+func (dp *DiskPersistence) logPath(ref LogFileRef) string {
+	if ref.Archived {
+		return filepath.Join(dp.archiveDir, ref.Path)
+	}
+	return filepath.Join(dp.primaryDir, ref.Path)
+}
+
+func (dp *DiskPersistence) TrimEvents(ctx context.Context, from, to int64) (int, error) {
+	if from <= 0 || to < from {
+		return 0, fmt.Errorf("invalid sequence range %d-%d", from, to)
+	}
+
+	dp.lk.Lock()
+	defer dp.lk.Unlock()
+	dp.maintLk.Lock()
+	defer dp.maintLk.Unlock()
+
+	if err := dp.flushLog(ctx); err != nil {
+		return 0, fmt.Errorf("failed to flush disk log: %w", err)
+	}
+
+	var refs []LogFileRef
+	if err := dp.meta.WithContext(ctx).Order("seq_start asc").Find(&refs, "seq_start <= ?", to).Error; err != nil {
+		return 0, err
+	}
+
+	current := dp.logfi.Name()
+
+	var trimmed int
+	for i, r := range refs {
+		if i+1 < len(refs) && refs[i+1].SeqStart <= from {
+			// every event in this file comes before the range
+			continue
+		}
+
+		fn := dp.logPath(r)
+		n, live, err := trimEventsInLog(fn, from, to)
+		trimmed += n
+		if err != nil {
+			return trimmed, fmt.Errorf("failed to trim events in %q: %w", r.Path, err)
+		}
+
+		if live == 0 && fn != current {
+			if err := dp.meta.WithContext(ctx).Delete(&r).Error; err != nil {
+				return trimmed, err
+			}
+			if err := os.Remove(fn); err != nil {
+				return trimmed, err
+			}
+		}
+	}
+
+	log.Info("trimmed events", "from", from, "to", to, "trimmed", trimmed)
+
+	return trimmed, nil
+}
+
+// trimEventsInLog flags the events in a log file numbered from..to as trimmed, returning the number newly trimmed and the number still visible to playback
+func trimEventsInLog(fn string, from, to int64) (int, int, error) {
+	fi, err := os.OpenFile(fn, os.O_RDWR, 0)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer fi.Close()
+	defer fi.Sync()
+
+	var trimmed, live int
+	scratch := make([]byte, headerSize)
+	var offset int64
+	for {
+		h, err := readHeader(fi, scratch)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return trimmed, live, nil
+			}
+			return trimmed, live, err
+		}
+
+		flags := h.Flags
+		if h.Seq >= from && h.Seq <= to && flags&EvtFlagTrimmed == 0 {
+			flags |= EvtFlagTrimmed
+
+			binary.LittleEndian.PutUint32(scratch, flags)
+			if _, err := fi.WriteAt(scratch[:4], offset); err != nil {
+				return trimmed, live, fmt.Errorf("failed to write updated flag value: %w", err)
+			}
+			trimmed++
+		}
+
+		if !postDoNotEmit(flags) {
+			live++
+		}
+
+		offset += headerSize + h.Len64()
+		if _, err := fi.Seek(offset, io.SeekStart); err != nil {
+			return trimmed, live, fmt.Errorf("failed to seek: %w", err)
+		}
+	}
+}
+
+// liveEventsFrom counts the events in a log file numbered from or later which are visible to playback
+func liveEventsFrom(fn string, from int64) (int, error) {
+	fi, err := os.OpenFile(fn, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer fi.Close()
+
+	var live int
+	scratch := make([]byte, headerSize)
+	for {
+		h, err := readHeader(fi, scratch)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return live, nil
+			}
+			return live, err
+		}
+
+		if h.Seq >= from && !postDoNotEmit(h.Flags) {
+			live++
+		}
+
+		if _, err := fi.Seek(h.Len64(), io.SeekCurrent); err != nil {
+			return live, fmt.Errorf("failed to seek: %w", err)
+		}
+	}
+}
+
+func (dp *DiskPersistence) Resequence(ctx context.Context, next int64) (int64, error) {
+	if next <= 0 {
+		return 0, fmt.Errorf("invalid sequence number %d", next)
+	}
+
+	dp.lk.Lock()
+	defer dp.lk.Unlock()
+	dp.maintLk.Lock()
+	defer dp.maintLk.Unlock()
+
+	if err := dp.flushLog(ctx); err != nil {
+		return 0, fmt.Errorf("failed to flush disk log: %w", err)
+	}
+
+	prev := dp.curSeq
+	if next == prev {
+		return prev, nil
+	}
+
+	// log files starting at or after next are dropped, and the new log file started after them all, so playback finds it last
+	var stale []LogFileRef
+	if next < prev {
+		if err := dp.meta.WithContext(ctx).Order("seq_start asc").Find(&stale, "seq_start >= ?", next).Error; err != nil {
+			return prev, err
+		}
+
+		check := stale
+		var containing LogFileRef
+		if err := dp.meta.WithContext(ctx).Order("seq_start desc").Limit(1).Find(&containing, "seq_start < ?", next).Error; err != nil {
+			return prev, err
+		}
+		if containing.ID != 0 {
+			check = append([]LogFileRef{containing}, stale...)
+		}
+
+		for _, r := range check {
+			live, err := liveEventsFrom(dp.logPath(r), next)
+			if err != nil {
+				return prev, fmt.Errorf("failed to scan %q: %w", r.Path, err)
+			}
+			if live > 0 {
+				return prev, fmt.Errorf("%w: %d events in %q must be trimmed first", ErrLiveEventsAfterSequence, live, r.Path)
+			}
+		}
+	}
+
+	if err := dp.logfi.Close(); err != nil {
+		return prev, fmt.Errorf("failed to close current log file: %w", err)
+	}
+
+	for _, r := range stale {
+		if err := dp.meta.WithContext(ctx).Delete(&r).Error; err != nil {
+			return prev, err
+		}
+		if err := os.Remove(dp.logPath(r)); err != nil && !os.IsNotExist(err) {
+			return prev, err
+		}
+	}
+
+	dp.curSeq = next
+	if err := dp.createLogFile(ctx); err != nil {
+		return prev, err
+	}
+
+	msg := fmt.Sprintf("event sequence rolled over from %d to %d; consumers should resume from cursor %d", prev-1, next, next-1)
+	dp.broadcast(&XRPCStreamEvent{
+		RepoInfo: &atproto.SyncSubscribeRepos_Info{
+			Name:    InfoSequenceRollover,
+			Message: &msg,
+		},
+	})
+
+	log.Warn("event sequence rolled over", "previous", prev, "next", next)
+
+	return prev, nil
+}
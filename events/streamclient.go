@@ -0,0 +1,112 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// RepoStreamClient consumes an event stream (eg, a relay's com.atproto.sync.subscribeRepos), resubscribing whenever the connection fails or goes stale.
+//
+// Each resubscription resumes from the cursor of the last event handed to the scheduler, so no events are skipped; events which were still queued in the scheduler when the connection dropped are not redelivered, but with a parallel scheduler some events may be seen twice.
+type RepoStreamClient struct {
+	// Websocket URL of the stream endpoint. Any "cursor" query parameter is replaced on each subscription
+	URL       string
+	Scheduler Scheduler
+	// Keepalive settings for each connection; OnSeq, if set, is called after the client's own cursor tracking
+	Options *StreamOptions
+	Dialer  *websocket.Dialer
+	Header  http.Header
+	// Delay before resubscribing, doubled after each consecutive failure (up to MaxBackoff), and reset once a connection delivers an event
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// sequence number of the last event handed to the scheduler, or -1
+	cursor atomic.Int64
+}
+
+// NewRepoStreamClient returns a client which starts from cursor, or from the live stream if cursor is nil
+func NewRepoStreamClient(streamURL string, sched Scheduler, cursor *int64) *RepoStreamClient {
+	c := &RepoStreamClient{
+		URL:        streamURL,
+		Scheduler:  sched,
+		Options:    DefaultStreamOptions(),
+		Dialer:     websocket.DefaultDialer,
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+	}
+	c.cursor.Store(-1)
+	if cursor != nil {
+		c.cursor.Store(*cursor)
+	}
+	return c
+}
+
+// Cursor returns the sequence number of the last event handed to the scheduler (or the starting cursor), and false if there is none yet
+func (c *RepoStreamClient) Cursor() (int64, bool) {
+	seq := c.cursor.Load()
+	return seq, seq >= 0
+}
+
+// Run consumes the stream until ctx is cancelled, then shuts down the scheduler
+func (c *RepoStreamClient) Run(ctx context.Context) error {
+	defer c.Scheduler.Shutdown()
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid stream URL: %w", err)
+	}
+
+	opts := *c.Options
+	opts.OnSeq = func(seq int64) {
+		c.cursor.Store(seq)
+		if c.Options.OnSeq != nil {
+			c.Options.OnSeq(seq)
+		}
+	}
+
+	backoff := c.MinBackoff
+	for {
+		start := c.cursor.Load()
+		err := c.subscribe(ctx, *u, start, &opts)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if c.cursor.Load() != start {
+			backoff = c.MinBackoff
+		}
+
+		streamReconnectsCounter.WithLabelValues(u.Host).Inc()
+		log.Warn("event stream disconnected, resubscribing", "host", u.Host, "err", err, "cursor", c.cursor.Load(), "backoff", backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, c.MaxBackoff)
+	}
+}
+
+func (c *RepoStreamClient) subscribe(ctx context.Context, u url.URL, cursor int64, opts *StreamOptions) error {
+	q := u.Query()
+	q.Del("cursor")
+	if cursor >= 0 {
+		q.Set("cursor", strconv.FormatInt(cursor, 10))
+	}
+	u.RawQuery = q.Encode()
+
+	con, _, err := c.Dialer.DialContext(ctx, u.String(), c.Header)
+	if err != nil {
+		return fmt.Errorf("dialing %s: %w", u.Host, err)
+	}
+	defer con.Close()
+
+	return handleRepoStream(ctx, con, c.Scheduler, opts)
+}
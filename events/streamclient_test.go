@@ -0,0 +1,101 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRepoStreamClientResubscribes(t *testing.T) {
+	done := make(chan struct{})
+
+	var lk sync.Mutex
+	var cursors []string
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lk.Lock()
+		cursors = append(cursors, r.URL.Query().Get("cursor"))
+		first := len(cursors) == 1
+		lk.Unlock()
+
+		con, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer con.Close()
+
+		seqs := []int64{4, 5}
+		if first {
+			seqs = []int64{1, 2, 3}
+		}
+		for _, seq := range seqs {
+			wc, err := con.NextWriter(websocket.BinaryMessage)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			evt := &events.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:example:123", Seq: seq}}
+			if err := evt.Serialize(wc); err != nil {
+				t.Error(err)
+				return
+			}
+			wc.Close()
+		}
+		// then go silent, without reading (so pings aren't answered) or closing: a half-open connection, as far as the client can tell
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var got []int64
+	sched := sequential.NewScheduler("test", func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+		got = append(got, evt.RepoIdentity.Seq)
+		if evt.RepoIdentity.Seq == 5 {
+			cancel()
+		}
+		return nil
+	})
+
+	c := events.NewRepoStreamClient("ws"+strings.TrimPrefix(srv.URL, "http")+"/xrpc/com.atproto.sync.subscribeRepos", sched, nil)
+	c.Options.PingInterval = 20 * time.Millisecond
+	c.Options.ReadTimeout = 200 * time.Millisecond
+	c.MinBackoff = 10 * time.Millisecond
+
+	errc := make(chan error, 1)
+	go func() { errc <- c.Run(ctx) }()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected error from Run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the client to resubscribe")
+	}
+
+	if len(got) != 5 || got[0] != 1 || got[4] != 5 {
+		t.Fatalf("unexpected events: %v", got)
+	}
+	if seq, ok := c.Cursor(); !ok || seq != 5 {
+		t.Fatalf("unexpected cursor: %d", seq)
+	}
+	lk.Lock()
+	defer lk.Unlock()
+	if len(cursors) != 2 || cursors[0] != "" || cursors[1] != "3" {
+		t.Fatalf("unexpected subscription cursors: %q", cursors)
+	}
+}
@@ -0,0 +1,36 @@
+package events
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// stream headers always carry W3C trace context, regardless of the globally configured propagator
+var headerPropagator = propagation.TraceContext{}
+
+func (h *EventHeader) setSpanContext(sc trace.SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	carrier := propagation.MapCarrier{}
+	headerPropagator.Inject(trace.ContextWithSpanContext(context.Background(), sc), carrier)
+	h.TraceParent = carrier.Get("traceparent")
+}
+
+func (h *EventHeader) spanContext() trace.SpanContext {
+	if h.TraceParent == "" {
+		return trace.SpanContext{}
+	}
+	ctx := headerPropagator.Extract(context.Background(), propagation.MapCarrier{"traceparent": h.TraceParent})
+	return trace.SpanContextFromContext(ctx)
+}
+
+// TraceContext returns ctx with the event's trace context (if any) as the parent for new spans. Schedulers which run events on their own workers use this to keep handlers in the trace the event arrived in.
+func (evt *XRPCStreamEvent) TraceContext(ctx context.Context) context.Context {
+	if !evt.PrivSpanContext.IsValid() {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, evt.PrivSpanContext)
+}
@@ -0,0 +1,83 @@
+package events_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestStreamTraceContext(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	traced := &events.XRPCStreamEvent{
+		RepoInfo:        &comatproto.SyncSubscribeRepos_Info{Name: "traced"},
+		PrivSpanContext: sc,
+	}
+	untraced := &events.XRPCStreamEvent{
+		RepoInfo: &comatproto.SyncSubscribeRepos_Info{Name: "untraced"},
+	}
+
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		con, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer con.Close()
+		for _, evt := range []*events.XRPCStreamEvent{traced, untraced} {
+			wc, err := con.NextWriter(websocket.BinaryMessage)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := evt.Serialize(wc); err != nil {
+				t.Error(err)
+				return
+			}
+			wc.Close()
+		}
+		con.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+	defer srv.Close()
+
+	con, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]trace.SpanContext)
+	sched := sequential.NewScheduler("test", func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+		if !trace.SpanContextFromContext(ctx).Equal(evt.PrivSpanContext) {
+			t.Errorf("handler context doesn't match event trace context")
+		}
+		got[evt.RepoInfo.Name] = evt.PrivSpanContext
+		return nil
+	})
+	events.HandleRepoStream(context.Background(), con, sched)
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(got))
+	}
+	if g := got["traced"]; g.TraceID() != traceID || g.SpanID() != spanID || !g.IsSampled() || !g.IsRemote() {
+		t.Fatalf("trace context did not round-trip: %v", g)
+	}
+	if got["untraced"].IsValid() {
+		t.Fatalf("untraced event got a trace context: %v", got["untraced"])
+	}
+}
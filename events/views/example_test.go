@@ -0,0 +1,55 @@
+package views_test
+
+import (
+	"context"
+
+	bsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events/views"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Follow is a row in an index of every follow in the network
+type Follow struct {
+	URI     string `gorm:"primarykey"`
+	Subject string `gorm:"index"`
+	Actor   string `gorm:"index"`
+}
+
+type followIndex struct{}
+
+func (followIndex) Apply(ctx context.Context, tx *gorm.DB, op *views.Op) error {
+	if op.Action == "delete" {
+		return tx.Delete(&Follow{}, "uri = ?", op.URI().String()).Error
+	}
+	rec, ok := op.Record.(*bsky.GraphFollow)
+	if !ok {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&Follow{URI: op.URI().String(), Subject: rec.Subject, Actor: op.Repo.String()}).Error
+}
+
+func (followIndex) DeleteAccount(ctx context.Context, tx *gorm.DB, did syntax.DID) error {
+	return tx.Delete(&Follow{}, "actor = ?", did.String()).Error
+}
+
+func Example() {
+	db, err := gorm.Open(sqlite.Open("follows.sqlite"), &gorm.Config{})
+	if err != nil {
+		panic(err)
+	}
+	m, err := views.New(db, "follows")
+	if err != nil {
+		panic(err)
+	}
+	if err := m.Register("app.bsky.graph.follow", followIndex{}, &Follow{}); err != nil {
+		panic(err)
+	}
+	// runs until cancelled, resuming from the stored cursor after a restart
+	if err := m.Run(context.Background(), "wss://bsky.network/xrpc/com.atproto.sync.subscribeRepos"); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,363 @@
+// Package views maintains materialized views of firehose records (eg, an index of every follow) in a SQL database.
+//
+// A [Materializer] consumes a repo event stream, decodes the record operations in each commit, and hands them to the [Reducer] registered for their collection. Each event's operations are applied in one transaction, together with the stream cursor, so a restarted consumer resumes exactly where it stopped and no event is applied twice. Views can be kept in any database gorm supports, eg SQLite or Postgres.
+package views
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/util/logging"
+
+	"github.com/ipfs/go-cid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var log = logging.Component("views")
+
+var opsApplied = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "views_ops_applied",
+	Help: "The total number of record operations applied to materialized views, by view and collection",
+}, []string{"view", "collection"})
+
+var cursorGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "views_cursor",
+	Help: "Sequence number of the last event applied to each materialized view",
+}, []string{"view"})
+
+// Op is a record operation from a commit
+type Op struct {
+	// Sequence number of the event the operation is from
+	Seq        int64
+	Repo       syntax.DID
+	Rev        string
+	Action     string // "create", "update", or "delete"
+	Collection syntax.NSID
+	RecordKey  syntax.RecordKey
+	// CID and CBOR bytes of the new record, undefined for deletes
+	Cid cid.Cid
+	Raw []byte
+	// The record decoded as a known lexicon type (eg, *bsky.GraphFollow), or nil for deletes and unknown types
+	Record lexutil.CBOR
+}
+
+// URI returns the AT-URI of the record
+func (op *Op) URI() syntax.ATURI {
+	return syntax.ATURI(fmt.Sprintf("at://%s/%s/%s", op.Repo, op.Collection, op.RecordKey))
+}
+
+// Reducer applies record operations to a view, using the transaction it is passed
+type Reducer interface {
+	Apply(ctx context.Context, tx *gorm.DB, op *Op) error
+}
+
+// ReducerFunc adapts a function to a Reducer
+type ReducerFunc func(ctx context.Context, tx *gorm.DB, op *Op) error
+
+func (f ReducerFunc) Apply(ctx context.Context, tx *gorm.DB, op *Op) error {
+	return f(ctx, tx, op)
+}
+
+// AccountReducer is implemented by reducers which drop an account's rows when the account is deleted
+type AccountReducer interface {
+	DeleteAccount(ctx context.Context, tx *gorm.DB, did syntax.DID) error
+}
+
+// Cursor is the stream position of a view, stored in the view's database
+type Cursor struct {
+	Name      string `gorm:"primarykey"`
+	Seq       int64
+	UpdatedAt time.Time
+}
+
+func (Cursor) TableName() string {
+	return "view_cursors"
+}
+
+// Materializer maintains views from a repo event stream. Events must be handled one at a time, in order (as by [Materializer.Run], which uses a sequential scheduler).
+type Materializer struct {
+	db   *gorm.DB
+	name string
+
+	// by collection NSID, or "*" for every collection
+	reducers map[string][]Reducer
+	accounts []AccountReducer
+
+	// Events without operations for any reducer only advance the cursor in memory. It is stored at least this often, so a restart doesn't replay much of the firehose
+	CheckpointInterval time.Duration
+
+	cursor    atomic.Int64
+	saved     int64
+	lastSaved time.Time
+}
+
+// New returns a materializer whose cursor is stored under name (so one database can hold several independently consumed views), resuming from the stored cursor if there is one
+func New(db *gorm.DB, name string) (*Materializer, error) {
+	if err := db.AutoMigrate(&Cursor{}); err != nil {
+		return nil, err
+	}
+	m := &Materializer{
+		db:                 db,
+		name:               name,
+		reducers:           make(map[string][]Reducer),
+		CheckpointInterval: 5 * time.Second,
+		saved:              -1,
+		lastSaved:          time.Now(),
+	}
+	m.cursor.Store(-1)
+
+	var c Cursor
+	if err := db.Limit(1).Find(&c, "name = ?", name).Error; err != nil {
+		return nil, err
+	}
+	if c.Name != "" {
+		m.cursor.Store(c.Seq)
+		m.saved = c.Seq
+	}
+	return m, nil
+}
+
+// Register adds a reducer for records in a collection ("*" for every collection). The models, if any, are auto-migrated first
+func (m *Materializer) Register(collection string, r Reducer, models ...any) error {
+	if collection != "*" {
+		if _, err := syntax.ParseNSID(collection); err != nil {
+			return err
+		}
+	}
+	if len(models) > 0 {
+		if err := m.db.AutoMigrate(models...); err != nil {
+			return err
+		}
+	}
+	m.reducers[collection] = append(m.reducers[collection], r)
+	if ar, ok := r.(AccountReducer); ok {
+		m.accounts = append(m.accounts, ar)
+	}
+	return nil
+}
+
+// Cursor returns the sequence number of the last event handled, and false if there is none yet
+func (m *Materializer) Cursor() (int64, bool) {
+	seq := m.cursor.Load()
+	return seq, seq >= 0
+}
+
+func (m *Materializer) reducersFor(collection string) []Reducer {
+	if len(m.reducers["*"]) == 0 {
+		return m.reducers[collection]
+	}
+	out := make([]Reducer, 0, len(m.reducers[collection])+len(m.reducers["*"]))
+	out = append(out, m.reducers[collection]...)
+	return append(out, m.reducers["*"]...)
+}
+
+// HandleEvent applies a stream event to the views. If it returns an error, the cursor is not advanced past the event, so it is retried when the stream is resubscribed (or the consumer is restarted)
+func (m *Materializer) HandleEvent(ctx context.Context, xev *events.XRPCStreamEvent) error {
+	switch {
+	case xev.RepoCommit != nil:
+		return m.handleCommit(ctx, xev.RepoCommit)
+	case xev.RepoAccount != nil:
+		evt := xev.RepoAccount
+		if !evt.Active && evt.Status != nil && *evt.Status == events.AccountStatusDeleted {
+			return m.deleteAccount(ctx, evt.Seq, evt.Did)
+		}
+		return m.skip(ctx, evt.Seq)
+	case xev.RepoTombstone != nil:
+		return m.deleteAccount(ctx, xev.RepoTombstone.Seq, xev.RepoTombstone.Did)
+	case xev.RepoIdentity != nil:
+		return m.skip(ctx, xev.RepoIdentity.Seq)
+	case xev.RepoHandle != nil:
+		return m.skip(ctx, xev.RepoHandle.Seq)
+	}
+	return nil
+}
+
+func (m *Materializer) handleCommit(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) error {
+	did, err := syntax.ParseDID(evt.Repo)
+	if err != nil {
+		log.Warn("skipping commit with invalid DID", "did", evt.Repo, "seq", evt.Seq)
+		return m.skip(ctx, evt.Seq)
+	}
+
+	var ops []*Op
+	var r *repo.Repo
+	for _, rop := range evt.Ops {
+		nsid, rkey, err := parseRepoPath(rop.Path)
+		if err != nil {
+			log.Warn("skipping op with invalid path", "did", evt.Repo, "seq", evt.Seq, "path", rop.Path)
+			continue
+		}
+		if len(m.reducersFor(nsid.String())) == 0 {
+			continue
+		}
+		op := &Op{
+			Seq:        evt.Seq,
+			Repo:       did,
+			Rev:        evt.Rev,
+			Action:     rop.Action,
+			Collection: nsid,
+			RecordKey:  rkey,
+		}
+		if rop.Action == "create" || rop.Action == "update" {
+			if evt.TooBig {
+				log.Warn("skipping op from too big commit", "did", evt.Repo, "seq", evt.Seq, "path", rop.Path)
+				continue
+			}
+			if r == nil {
+				r, err = repo.ReadRepoFromCar(ctx, bytes.NewReader(evt.Blocks))
+				if err != nil {
+					return fmt.Errorf("reading commit blocks (seq %d): %w", evt.Seq, err)
+				}
+			}
+			rcid, rec, err := r.GetRecordBytes(ctx, rop.Path)
+			if err != nil {
+				return fmt.Errorf("reading record %s (seq %d): %w", rop.Path, evt.Seq, err)
+			}
+			if rop.Cid != nil && lexutil.LexLink(rcid) != *rop.Cid {
+				return fmt.Errorf("record %s (seq %d) doesn't match op CID", rop.Path, evt.Seq)
+			}
+			op.Cid = rcid
+			op.Raw = *rec
+			// records of unknown types are still passed on, for reducers which decode them themselves
+			op.Record, _ = lexutil.CborDecodeValue(op.Raw)
+		}
+		ops = append(ops, op)
+	}
+
+	if len(ops) == 0 {
+		return m.skip(ctx, evt.Seq)
+	}
+	return m.apply(ctx, evt.Seq, func(tx *gorm.DB) error {
+		for _, op := range ops {
+			for _, red := range m.reducersFor(op.Collection.String()) {
+				if err := red.Apply(ctx, tx, op); err != nil {
+					return fmt.Errorf("applying %s %s: %w", op.Action, op.URI(), err)
+				}
+			}
+			opsApplied.WithLabelValues(m.name, op.Collection.String()).Inc()
+		}
+		return nil
+	})
+}
+
+func parseRepoPath(path string) (syntax.NSID, syntax.RecordKey, error) {
+	coll, rkey, ok := strings.Cut(path, "/")
+	if !ok {
+		return "", "", fmt.Errorf("invalid repo path: %s", path)
+	}
+	nsid, err := syntax.ParseNSID(coll)
+	if err != nil {
+		return "", "", err
+	}
+	rk, err := syntax.ParseRecordKey(rkey)
+	if err != nil {
+		return "", "", err
+	}
+	return nsid, rk, nil
+}
+
+func (m *Materializer) deleteAccount(ctx context.Context, seq int64, did string) error {
+	d, err := syntax.ParseDID(did)
+	if err != nil || len(m.accounts) == 0 {
+		return m.skip(ctx, seq)
+	}
+	return m.apply(ctx, seq, func(tx *gorm.DB) error {
+		for _, ar := range m.accounts {
+			if err := ar.DeleteAccount(ctx, tx, d); err != nil {
+				return fmt.Errorf("deleting account %s: %w", did, err)
+			}
+		}
+		return nil
+	})
+}
+
+// apply runs fn and stores the cursor in one transaction
+func (m *Materializer) apply(ctx context.Context, seq int64, fn func(tx *gorm.DB) error) error {
+	if err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return m.saveCursor(tx, seq)
+	}); err != nil {
+		return err
+	}
+	m.advance(seq, true)
+	return nil
+}
+
+// skip advances the cursor past an event with nothing to apply, storing it if it hasn't been for CheckpointInterval
+func (m *Materializer) skip(ctx context.Context, seq int64) error {
+	if time.Since(m.lastSaved) < m.CheckpointInterval {
+		m.advance(seq, false)
+		return nil
+	}
+	if err := m.saveCursor(m.db.WithContext(ctx), seq); err != nil {
+		return err
+	}
+	m.advance(seq, true)
+	return nil
+}
+
+func (m *Materializer) advance(seq int64, saved bool) {
+	m.cursor.Store(seq)
+	cursorGauge.WithLabelValues(m.name).Set(float64(seq))
+	if saved {
+		m.saved = seq
+		m.lastSaved = time.Now()
+	}
+}
+
+func (m *Materializer) saveCursor(tx *gorm.DB, seq int64) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"seq", "updated_at"}),
+	}).Create(&Cursor{Name: m.name, Seq: seq}).Error
+}
+
+// Flush stores the cursor, if it has advanced since it was last stored
+func (m *Materializer) Flush(ctx context.Context) error {
+	seq := m.cursor.Load()
+	if seq < 0 || seq == m.saved {
+		return nil
+	}
+	if err := m.saveCursor(m.db.WithContext(ctx), seq); err != nil {
+		return err
+	}
+	m.advance(seq, true)
+	return nil
+}
+
+// Run consumes the stream at streamURL (eg, "wss://bsky.network/xrpc/com.atproto.sync.subscribeRepos") from the stored cursor, or from the live stream on first run, until ctx is cancelled. The cursor is stored on the way out.
+func (m *Materializer) Run(ctx context.Context, streamURL string) error {
+	var cursor *int64
+	if seq, ok := m.Cursor(); ok {
+		cursor = &seq
+	}
+	sched := sequential.NewScheduler("views-"+m.name, m.HandleEvent)
+	client := events.NewRepoStreamClient(streamURL, sched, cursor)
+	log.Info("starting view consumer", "view", m.name, "url", streamURL, "cursor", m.cursor.Load())
+
+	err := client.Run(ctx)
+	// the scheduler has shut down, so nothing else is handling events
+	if ferr := m.Flush(context.Background()); ferr != nil {
+		log.Error("failed to store cursor", "view", m.name, "err", ferr)
+	}
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,222 @@
+package views_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	bsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/views"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/repo"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	car "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "views.sqlite")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// followCommit makes a commit event creating follows of each subject
+func followCommit(t *testing.T, seq int64, did string, subjects ...string) (*events.XRPCStreamEvent, []string) {
+	ctx := context.TODO()
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	r := repo.NewRepo(ctx, did, bs)
+
+	var ops []*comatproto.SyncSubscribeRepos_RepoOp
+	var paths []string
+	for _, subject := range subjects {
+		rc, tid, err := r.CreateRecord(ctx, "app.bsky.graph.follow", &bsky.GraphFollow{Subject: subject, CreatedAt: time.Now().Format(time.RFC3339)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		link := lexutil.LexLink(rc)
+		ops = append(ops, &comatproto.SyncSubscribeRepos_RepoOp{Action: "create", Path: "app.bsky.graph.follow/" + tid, Cid: &link})
+		paths = append(paths, "app.bsky.graph.follow/"+tid)
+	}
+	root, rev, err := r.Commit(ctx, func(context.Context, string, []byte) ([]byte, error) { return []byte("sig"), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	hb, err := cbor.DumpObject(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := carutil.LdWrite(buf, hb); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range keys {
+		blk, err := bs.Get(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := carutil.LdWrite(buf, k.Bytes(), blk.RawData()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &events.XRPCStreamEvent{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{
+		Seq:    seq,
+		Repo:   did,
+		Rev:    rev,
+		Commit: lexutil.LexLink(root),
+		Blocks: buf.Bytes(),
+		Ops:    ops,
+	}}, paths
+}
+
+func deleteCommit(seq int64, did string, path string) *events.XRPCStreamEvent {
+	return &events.XRPCStreamEvent{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{
+		Seq:  seq,
+		Repo: did,
+		Ops:  []*comatproto.SyncSubscribeRepos_RepoOp{{Action: "delete", Path: path}},
+	}}
+}
+
+func followSubjects(t *testing.T, db *gorm.DB) []string {
+	var subjects []string
+	if err := db.Model(&Follow{}).Order("subject").Pluck("subject", &subjects).Error; err != nil {
+		t.Fatal(err)
+	}
+	return subjects
+}
+
+func TestMaterializeFollows(t *testing.T) {
+	ctx := context.TODO()
+	db := testDB(t)
+	m, err := views.New(db, "follows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Cursor(); ok {
+		t.Fatal("expected no cursor before any events")
+	}
+	if err := m.Register("app.bsky.graph.follow", followIndex{}, &Follow{}); err != nil {
+		t.Fatal(err)
+	}
+
+	alice, _ := followCommit(t, 1, "did:plc:alice", "did:plc:bob", "did:plc:carol")
+	carol, paths := followCommit(t, 2, "did:plc:carol", "did:plc:bob")
+	for _, evt := range []*events.XRPCStreamEvent{
+		alice,
+		carol,
+		{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Seq: 3, Did: "did:plc:dave"}},
+		deleteCommit(4, "did:plc:carol", paths[0]),
+	} {
+		if err := m.HandleEvent(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := followSubjects(t, db); len(got) != 2 || got[0] != "did:plc:bob" || got[1] != "did:plc:carol" {
+		t.Fatalf("unexpected follows: %v", got)
+	}
+
+	// a new consumer resumes after the last event applied
+	m2, err := views.New(db, "follows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq, ok := m2.Cursor(); !ok || seq != 4 {
+		t.Fatalf("expected stored cursor 4, got %d", seq)
+	}
+	// cursors of other views are separate
+	other, err := views.New(db, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := other.Cursor(); ok {
+		t.Fatal("expected no cursor for another view")
+	}
+
+	// events with nothing to apply only move the stored cursor when flushed
+	if err := m.HandleEvent(ctx, &events.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Seq: 5, Did: "did:plc:dave"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m2, err = views.New(db, "follows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq, _ := m2.Cursor(); seq != 5 {
+		t.Fatalf("expected flushed cursor 5, got %d", seq)
+	}
+
+	// deleted accounts are dropped from the view
+	deleted := events.AccountStatusDeleted
+	if err := m.HandleEvent(ctx, &events.XRPCStreamEvent{RepoAccount: &comatproto.SyncSubscribeRepos_Account{Seq: 6, Did: "did:plc:alice", Status: &deleted}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := followSubjects(t, db); len(got) != 0 {
+		t.Fatalf("expected follows to be deleted with the account, got %v", got)
+	}
+}
+
+func TestReducerFailure(t *testing.T) {
+	ctx := context.TODO()
+	db := testDB(t)
+	m, err := views.New(db, "follows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Register("app.bsky.graph.follow", followIndex{}, &Follow{}); err != nil {
+		t.Fatal(err)
+	}
+	fail := errors.New("fail")
+	var seen int
+	if err := m.Register("*", views.ReducerFunc(func(ctx context.Context, tx *gorm.DB, op *views.Op) error {
+		seen++
+		if seen == 2 {
+			return fail
+		}
+		return nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := followCommit(t, 1, "did:plc:alice", "did:plc:bob")
+	if err := m.HandleEvent(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := followCommit(t, 2, "did:plc:alice", "did:plc:carol", "did:plc:dave")
+	if err := m.HandleEvent(ctx, second); !errors.Is(err, fail) {
+		t.Fatalf("expected reducer error, got %v", err)
+	}
+
+	// the failed event was rolled back, and will be retried
+	if got := followSubjects(t, db); len(got) != 1 || got[0] != "did:plc:bob" {
+		t.Fatalf("expected failed event to be rolled back, got %v", got)
+	}
+	if seq, _ := m.Cursor(); seq != 1 {
+		t.Fatalf("expected cursor not to advance past failed event, got %d", seq)
+	}
+	if err := m.HandleEvent(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if got := followSubjects(t, db); len(got) != 3 {
+		t.Fatalf("expected retried event to be applied, got %v", got)
+	}
+}
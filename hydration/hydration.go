@@ -0,0 +1,228 @@
+// Package hydration fetches the records referenced by AT-URIs, in bulk: resolving the identities of the accounts, reading the records from a local carstore, a relay, or an appview, and decoding them into the registered lexicon types. Each URI gets its own result (so one missing record doesn't fail a whole page of posts), and records are cached.
+package hydration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/ipfs/go-cid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrNotFound is returned for records which none of the sources have
+var ErrNotFound = errors.New("record not found")
+
+var recordCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "hydration_record_cache_hits",
+	Help: "Number of cache hits for hydrated records",
+})
+
+var recordCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "hydration_record_cache_misses",
+	Help: "Number of cache misses for hydrated records",
+})
+
+var recordFetchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "hydration_record_fetch_errors",
+	Help: "Number of failed record fetches, by source",
+}, []string{"source"})
+
+// Source is somewhere records can be read from
+type Source interface {
+	// Name identifies the source in metrics and errors
+	Name() string
+	// GetRecord returns the CID and DAG-CBOR bytes of the current version of a record, or an error wrapping ErrNotFound if the source doesn't have it
+	GetRecord(ctx context.Context, ident *identity.Identity, collection syntax.NSID, rkey syntax.RecordKey) (cid.Cid, []byte, error)
+}
+
+// Record is a hydrated record
+type Record struct {
+	// URI of the record, with the account's DID as the authority
+	URI syntax.ATURI
+	CID cid.Cid
+	// Raw is the record's DAG-CBOR encoding
+	Raw []byte
+	// Value is the record decoded into its registered lexicon type (eg, *bsky.FeedPost), or nil if the type isn't registered with lex/util
+	Value lexutil.CBOR
+}
+
+// Data decodes the record generically, for types without a registered Go type
+func (r *Record) Data() (map[string]any, error) {
+	return data.UnmarshalCBOR(r.Raw)
+}
+
+// As returns a record's value as the given lexicon type
+func As[T lexutil.CBOR](r *Record) (T, error) {
+	v, ok := r.Value.(T)
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("record %s is not a %T", r.URI, zero)
+	}
+	return v, nil
+}
+
+// Result is the outcome of hydrating one AT-URI
+type Result struct {
+	// URI as requested
+	URI syntax.ATURI
+	// Identity of the account, if it was resolved
+	Identity *identity.Identity
+	Record   *Record
+	Err      error
+}
+
+// Config of a Hydrator. A capacity of zero means unlimited size, and a ttl of zero means unlimited duration
+type Config struct {
+	// Capacity is how many records (and not found results) are cached
+	Capacity int
+	HitTTL   time.Duration
+	// NotFoundTTL is how long records none of the sources have are remembered as missing
+	NotFoundTTL time.Duration
+	// Concurrency is how many records are fetched at once, by each Hydrate call
+	Concurrency int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Capacity:    100_000,
+		HitTTL:      5 * time.Minute,
+		NotFoundTTL: time.Minute,
+		Concurrency: 20,
+	}
+}
+
+type cacheEntry struct {
+	Updated time.Time
+	Record  *Record
+	Err     error
+}
+
+// Hydrator fetches records from its sources, trying each in order
+type Hydrator struct {
+	Directory   identity.Directory
+	Sources     []Source
+	NotFoundTTL time.Duration
+	Concurrency int
+
+	cache *expirable.LRU[syntax.ATURI, cacheEntry]
+}
+
+func NewHydrator(dir identity.Directory, config Config, sources ...Source) *Hydrator {
+	return &Hydrator{
+		Directory:   dir,
+		Sources:     sources,
+		NotFoundTTL: config.NotFoundTTL,
+		Concurrency: config.Concurrency,
+		cache:       expirable.NewLRU[syntax.ATURI, cacheEntry](config.Capacity, nil, config.HitTTL),
+	}
+}
+
+// Hydrate fetches the records at the given URIs, returning a result for each in the same order. Identities and records are resolved concurrently; duplicate URIs are only fetched once.
+func (h *Hydrator) Hydrate(ctx context.Context, uris []syntax.ATURI) []Result {
+	results := make([]Result, len(uris))
+	first := make(map[syntax.ATURI]int, len(uris))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(h.Concurrency, 1))
+	for i, uri := range uris {
+		results[i].URI = uri
+		if _, ok := first[uri]; ok {
+			continue
+		}
+		first[uri] = i
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(res *Result) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res.Identity, res.Record, res.Err = h.hydrate(ctx, res.URI)
+		}(&results[i])
+	}
+	wg.Wait()
+
+	for i := range results {
+		if j := first[results[i].URI]; j != i {
+			results[i] = results[j]
+		}
+	}
+	return results
+}
+
+// GetRecord fetches the record at a single URI
+func (h *Hydrator) GetRecord(ctx context.Context, uri syntax.ATURI) (*Record, error) {
+	_, rec, err := h.hydrate(ctx, uri)
+	return rec, err
+}
+
+// Purge drops a record from the cache, eg when a firehose consumer sees it change
+func (h *Hydrator) Purge(uri syntax.ATURI) {
+	h.cache.Remove(uri.Normalize())
+}
+
+func (h *Hydrator) hydrate(ctx context.Context, uri syntax.ATURI) (*identity.Identity, *Record, error) {
+	collection := uri.Collection()
+	rkey := uri.RecordKey()
+	if collection == "" || rkey == "" {
+		return nil, nil, fmt.Errorf("not a record URI: %s", uri)
+	}
+
+	atid := uri.Authority()
+	ident, err := h.Directory.Lookup(ctx, atid)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolving %s: %w", atid, err)
+	}
+
+	key := syntax.ATURI(fmt.Sprintf("at://%s/%s/%s", ident.DID, collection, rkey)).Normalize()
+	if entry, ok := h.cache.Get(key); ok {
+		if entry.Err == nil || time.Since(entry.Updated) < h.NotFoundTTL {
+			recordCacheHits.Inc()
+			return ident, entry.Record, entry.Err
+		}
+		h.cache.Remove(key)
+	}
+	recordCacheMisses.Inc()
+
+	rec, err := h.fetch(ctx, ident, key)
+	if err == nil || errors.Is(err, ErrNotFound) {
+		h.cache.Add(key, cacheEntry{Updated: time.Now(), Record: rec, Err: err})
+	}
+	return ident, rec, err
+}
+
+// fetch tries each source in turn, until one has the record. If none do, and any failed other than by not having it, the last such error is returned
+func (h *Hydrator) fetch(ctx context.Context, ident *identity.Identity, uri syntax.ATURI) (*Record, error) {
+	var lastErr error
+	for _, src := range h.Sources {
+		c, raw, err := src.GetRecord(ctx, ident, uri.Collection(), uri.RecordKey())
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			recordFetchErrors.WithLabelValues(src.Name()).Inc()
+			lastErr = fmt.Errorf("fetching %s from %s: %w", uri, src.Name(), err)
+			continue
+		}
+
+		rec := &Record{URI: uri, CID: c, Raw: raw}
+		if val, err := lexutil.CborDecodeValue(raw); err == nil {
+			rec.Value = val
+		} else if !errors.Is(err, lexutil.ErrUnrecognizedType) {
+			return nil, fmt.Errorf("decoding %s: %w", uri, err)
+		}
+		return rec, nil
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, uri)
+}
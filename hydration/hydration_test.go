@@ -0,0 +1,269 @@
+package hydration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	bsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	car "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSource struct {
+	name    string
+	records map[string][]byte
+	err     error
+	calls   int
+}
+
+func (s *fakeSource) Name() string {
+	return s.name
+}
+
+func (s *fakeSource) GetRecord(ctx context.Context, ident *identity.Identity, collection syntax.NSID, rkey syntax.RecordKey) (cid.Cid, []byte, error) {
+	s.calls++
+	if s.err != nil {
+		return cid.Undef, nil, s.err
+	}
+	raw, ok := s.records[fmt.Sprintf("%s/%s/%s", ident.DID, collection, rkey)]
+	if !ok {
+		return cid.Undef, nil, ErrNotFound
+	}
+	c, err := cid.NewPrefixV1(cid.DagCBOR, 0x12).Sum(raw)
+	return c, raw, err
+}
+
+func postBytes(t *testing.T, text string) []byte {
+	buf := new(bytes.Buffer)
+	post := bsky.FeedPost{LexiconTypeID: "app.bsky.feed.post", Text: text, CreatedAt: "2024-01-01T00:00:00.000Z"}
+	if err := post.MarshalCBOR(buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testDirectory() identity.Directory {
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{
+		DID:    syntax.DID("did:plc:alice"),
+		Handle: syntax.Handle("alice.test"),
+	})
+	return &dir
+}
+
+func TestHydrate(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	src := &fakeSource{name: "fake", records: map[string][]byte{
+		"did:plc:alice/app.bsky.feed.post/one": postBytes(t, "hello"),
+		"did:plc:alice/app.bsky.feed.post/two": postBytes(t, "world"),
+	}}
+	h := NewHydrator(testDirectory(), DefaultConfig(), src)
+
+	uris := []syntax.ATURI{
+		"at://did:plc:alice/app.bsky.feed.post/one",
+		"at://alice.test/app.bsky.feed.post/two",
+		"at://did:plc:alice/app.bsky.feed.post/one",
+		"at://did:plc:alice/app.bsky.feed.post/missing",
+		"at://did:plc:bob/app.bsky.feed.post/one",
+		"at://did:plc:alice",
+	}
+	res := h.Hydrate(ctx, uris)
+	assert.Equal(len(uris), len(res))
+	for i := range uris {
+		assert.Equal(uris[i], res[i].URI)
+	}
+
+	post, err := As[*bsky.FeedPost](res[0].Record)
+	assert.NoError(err)
+	assert.Equal("hello", post.Text)
+	assert.Equal(syntax.DID("did:plc:alice"), res[0].Identity.DID)
+
+	// handles are resolved, and records are returned with DID URIs
+	assert.NoError(res[1].Err)
+	assert.Equal(syntax.ATURI("at://did:plc:alice/app.bsky.feed.post/two"), res[1].Record.URI)
+	obj, err := res[1].Record.Data()
+	assert.NoError(err)
+	assert.Equal("world", obj["text"])
+
+	assert.Equal(res[0].Record, res[2].Record)
+	assert.ErrorIs(res[3].Err, ErrNotFound)
+	assert.ErrorIs(res[4].Err, identity.ErrDIDNotFound)
+	assert.Error(res[5].Err)
+	_, err = As[*bsky.FeedLike](res[0].Record)
+	assert.Error(err)
+
+	// duplicates are fetched once, and records or their absence are cached
+	assert.Equal(3, src.calls)
+	res = h.Hydrate(ctx, uris[:4])
+	assert.Equal(3, src.calls)
+	assert.Equal("hello", res[0].Record.Value.(*bsky.FeedPost).Text)
+	assert.ErrorIs(res[3].Err, ErrNotFound)
+
+	h.Purge("at://did:plc:alice/app.bsky.feed.post/one")
+	_, err = h.GetRecord(ctx, "at://alice.test/app.bsky.feed.post/one")
+	assert.NoError(err)
+	assert.Equal(4, src.calls)
+
+	// missing records are forgotten after NotFoundTTL
+	h.NotFoundTTL = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	_, err = h.GetRecord(ctx, "at://did:plc:alice/app.bsky.feed.post/missing")
+	assert.ErrorIs(err, ErrNotFound)
+	assert.Equal(5, src.calls)
+}
+
+func TestHydrateSourceFallback(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	empty := &fakeSource{name: "empty"}
+	broken := &fakeSource{name: "broken", err: errors.New("unavailable")}
+	full := &fakeSource{name: "full", records: map[string][]byte{
+		"did:plc:alice/app.bsky.feed.post/one": postBytes(t, "hello"),
+	}}
+
+	h := NewHydrator(testDirectory(), DefaultConfig(), empty, broken, full)
+	rec, err := h.GetRecord(ctx, "at://did:plc:alice/app.bsky.feed.post/one")
+	assert.NoError(err)
+	assert.Equal("hello", rec.Value.(*bsky.FeedPost).Text)
+
+	// a failing source is reported if no other source has the record, and the failure isn't cached
+	h = NewHydrator(testDirectory(), DefaultConfig(), empty, broken)
+	_, err = h.GetRecord(ctx, "at://did:plc:alice/app.bsky.feed.post/one")
+	assert.ErrorContains(err, "unavailable")
+	assert.NotErrorIs(err, ErrNotFound)
+	_, err = h.GetRecord(ctx, "at://did:plc:alice/app.bsky.feed.post/one")
+	assert.Error(err)
+	assert.Equal(3, broken.calls)
+}
+
+func TestXRPCSources(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	// a repo with one post, signed by the account's key
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	r := repo.NewRepo(ctx, "did:plc:alice", bs)
+	rc, rkey, err := r.CreateRecord(ctx, "app.bsky.feed.post", &bsky.FeedPost{Text: "hello", CreatedAt: "2024-01-01T00:00:00.000Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _, err := r.Commit(ctx, func(_ context.Context, _ string, b []byte) ([]byte, error) { return priv.HashAndSign(b) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	carBuf := new(bytes.Buffer)
+	hb, err := cbor.DumpObject(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := carutil.LdWrite(carBuf, hb); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range keys {
+		blk, err := bs.Get(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := carutil.LdWrite(carBuf, k.Bytes(), blk.RawData()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		if q.Get("rkey") != rkey {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(xrpc.XRPCError{ErrStr: "RecordNotFound", Message: "no such record"})
+			return
+		}
+		switch req.URL.Path {
+		case "/xrpc/com.atproto.sync.getRecord":
+			w.Write(carBuf.Bytes())
+		case "/xrpc/com.atproto.repo.getRecord":
+			json.NewEncoder(w).Encode(map[string]any{
+				"uri":   "at://did:plc:alice/app.bsky.feed.post/" + rkey,
+				"cid":   rc.String(),
+				"value": map[string]any{"$type": "app.bsky.feed.post", "text": "hello", "createdAt": "2024-01-01T00:00:00.000Z"},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{
+		DID:      syntax.DID("did:plc:alice"),
+		Handle:   syntax.Handle("alice.test"),
+		Keys:     map[string]identity.Key{"atproto": {Type: "Multikey", PublicKeyMultibase: pub.Multibase()}},
+		Services: map[string]identity.Service{"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: srv.URL}},
+	})
+	uri := syntax.ATURI("at://did:plc:alice/app.bsky.feed.post/" + rkey)
+
+	for _, src := range []Source{
+		&SyncGetRecordSource{Client: &xrpc.Client{Host: srv.URL}},
+		&RepoGetRecordSource{Client: &xrpc.Client{}},
+	} {
+		h := NewHydrator(&dir, DefaultConfig(), src)
+		rec, err := h.GetRecord(ctx, uri)
+		if !assert.NoError(err, src.Name()) {
+			continue
+		}
+		assert.Equal(rc, rec.CID, src.Name())
+		assert.Equal("hello", rec.Value.(*bsky.FeedPost).Text, src.Name())
+
+		_, err = h.GetRecord(ctx, "at://did:plc:alice/app.bsky.feed.post/missing")
+		assert.ErrorIs(err, ErrNotFound, src.Name())
+	}
+
+	// records from a repo signed with another key are rejected
+	other, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, err := other.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ident, err := dir.LookupDID(ctx, "did:plc:alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ident.Keys = map[string]identity.Key{"atproto": {Type: "Multikey", PublicKeyMultibase: otherPub.Multibase()}}
+	src := &SyncGetRecordSource{Client: &xrpc.Client{Host: srv.URL}}
+	_, _, err = src.GetRecord(ctx, ident, "app.bsky.feed.post", syntax.RecordKey(rkey))
+	assert.ErrorContains(err, "signature")
+}
@@ -0,0 +1,194 @@
+package hydration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/mst"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/ipfs/go-cid"
+)
+
+// CarstoreSource reads records from the repos in a local carstore, such as a relay's
+type CarstoreSource struct {
+	CarStore *carstore.CarStore
+	// LookupUid finds the carstore user for an account, returning an error wrapping ErrNotFound for accounts which aren't stored
+	LookupUid func(ctx context.Context, did syntax.DID) (models.Uid, error)
+}
+
+func (s *CarstoreSource) Name() string {
+	return "carstore"
+}
+
+func (s *CarstoreSource) GetRecord(ctx context.Context, ident *identity.Identity, collection syntax.NSID, rkey syntax.RecordKey) (cid.Cid, []byte, error) {
+	uid, err := s.LookupUid(ctx, ident.DID)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	head, err := s.CarStore.GetUserRepoHead(ctx, uid)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	if !head.Defined() {
+		return cid.Undef, nil, fmt.Errorf("%w: no repo stored for %s", ErrNotFound, ident.DID)
+	}
+	sess, err := s.CarStore.ReadOnlySession(uid)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	r, err := repo.OpenRepo(ctx, sess, head)
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("opening repo: %w", err)
+	}
+	return getRecordBytes(ctx, r, collection, rkey)
+}
+
+// SyncGetRecordSource fetches records with com.atproto.sync.getRecord, from a relay or the account's PDS. The response includes the signed commit and the MST path to the record, which are verified against the account's signing key.
+type SyncGetRecordSource struct {
+	// Client to make requests with. If its Host is empty, each account's PDS is used
+	Client *xrpc.Client
+}
+
+func (s *SyncGetRecordSource) Name() string {
+	if s.Client.Host == "" {
+		return "pds-sync"
+	}
+	return s.Client.Host
+}
+
+func (s *SyncGetRecordSource) GetRecord(ctx context.Context, ident *identity.Identity, collection syntax.NSID, rkey syntax.RecordKey) (cid.Cid, []byte, error) {
+	c := clientFor(s.Client, ident)
+	if c.Host == "" {
+		return cid.Undef, nil, fmt.Errorf("no PDS endpoint for %s", ident.DID)
+	}
+	carBytes, err := comatproto.SyncGetRecord(ctx, c, collection.String(), "", ident.DID.String(), rkey.String())
+	if err != nil {
+		return cid.Undef, nil, wrapNotFound(err)
+	}
+
+	r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(carBytes))
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("reading record proof: %w", err)
+	}
+	sc := r.SignedCommit()
+	if sc.Did != ident.DID.String() {
+		return cid.Undef, nil, fmt.Errorf("record proof is for the wrong account: %s", sc.Did)
+	}
+	pub, err := ident.PublicKey()
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	unsigned, err := sc.Unsigned().BytesForSigning()
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	if err := pub.HashAndVerify(unsigned, sc.Sig); err != nil {
+		return cid.Undef, nil, fmt.Errorf("invalid commit signature: %w", err)
+	}
+	return getRecordBytes(ctx, r, collection, rkey)
+}
+
+// RepoGetRecordSource fetches records with com.atproto.repo.getRecord, from an appview or the account's PDS. The records aren't verified, so this source should only be pointed at trusted services.
+type RepoGetRecordSource struct {
+	// Client to make requests with. If its Host is empty, each account's PDS is used
+	Client *xrpc.Client
+}
+
+func (s *RepoGetRecordSource) Name() string {
+	if s.Client.Host == "" {
+		return "pds"
+	}
+	return s.Client.Host
+}
+
+func (s *RepoGetRecordSource) GetRecord(ctx context.Context, ident *identity.Identity, collection syntax.NSID, rkey syntax.RecordKey) (cid.Cid, []byte, error) {
+	c := clientFor(s.Client, ident)
+	if c.Host == "" {
+		return cid.Undef, nil, fmt.Errorf("no PDS endpoint for %s", ident.DID)
+	}
+
+	// the value is decoded generically, rather than with comatproto.RepoGetRecord, so records of types without registered Go types still hydrate
+	var out struct {
+		Cid   *string         `json:"cid"`
+		Value json.RawMessage `json:"value"`
+	}
+	params := map[string]any{
+		"collection": collection.String(),
+		"repo":       ident.DID.String(),
+		"rkey":       rkey.String(),
+	}
+	if err := c.Do(ctx, xrpc.Query, "", "com.atproto.repo.getRecord", params, nil, &out); err != nil {
+		return cid.Undef, nil, wrapNotFound(err)
+	}
+	if len(out.Value) == 0 {
+		return cid.Undef, nil, fmt.Errorf("empty record in response")
+	}
+
+	obj, err := data.UnmarshalJSON(out.Value)
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("fetched record was invalid data: %w", err)
+	}
+	raw, err := data.MarshalCBOR(obj)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	c2 := cid.Undef
+	if out.Cid != nil {
+		c2, err = cid.Decode(*out.Cid)
+		if err != nil {
+			return cid.Undef, nil, fmt.Errorf("invalid record CID in response: %w", err)
+		}
+	}
+	return c2, raw, nil
+}
+
+// clientFor returns the client to use for an account: the configured one, or a copy of it pointed at the account's PDS
+func clientFor(c *xrpc.Client, ident *identity.Identity) *xrpc.Client {
+	if c.Host != "" {
+		return c
+	}
+	pc := *c
+	pc.Host = ident.PDSEndpoint()
+	return &pc
+}
+
+func getRecordBytes(ctx context.Context, r *repo.Repo, collection syntax.NSID, rkey syntax.RecordKey) (cid.Cid, []byte, error) {
+	rc, raw, err := r.GetRecordBytes(ctx, collection.String()+"/"+rkey.String())
+	if errors.Is(err, mst.ErrNotFound) {
+		return cid.Undef, nil, fmt.Errorf("%w: %s/%s", ErrNotFound, collection, rkey)
+	} else if err != nil {
+		return cid.Undef, nil, err
+	}
+	return rc, *raw, nil
+}
+
+// wrapNotFound marks XRPC errors for missing records or repos as ErrNotFound
+func wrapNotFound(err error) error {
+	var xerr *xrpc.Error
+	if !errors.As(err, &xerr) {
+		return err
+	}
+	if xerr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	var xe *xrpc.XRPCError
+	if errors.As(err, &xe) {
+		switch xe.ErrStr {
+		case "RecordNotFound", "RepoNotFound", "RepoDeactivated", "RepoTakendown", "RepoSuspended":
+			return fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+	}
+	return err
+}
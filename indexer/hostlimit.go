@@ -0,0 +1,205 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/xrpc"
+	"golang.org/x/time/rate"
+)
+
+// HostLimiter is a token bucket for requests to (or from) a single host, which adapts to how the host is coping: the rate is halved on HTTP 429 responses (and requests pause until any advertised reset time), reduced on server errors and failed connections, and recovers gradually towards the configured base rate after successful requests.
+//
+// An operator override pins the rate, turning adaptation off until it is cleared.
+type HostLimiter struct {
+	lk           sync.Mutex
+	lim          *rate.Limiter
+	base         rate.Limit
+	override     bool
+	blockedUntil time.Time
+
+	successes   int64
+	errors      int64
+	rateLimited int64
+}
+
+// HostLimiterStatus is a snapshot of a HostLimiter's state, for admin endpoints
+type HostLimiterStatus struct {
+	Limit        float64    `json:"limit"`
+	BaseLimit    float64    `json:"base_limit"`
+	Override     bool       `json:"override"`
+	BlockedUntil *time.Time `json:"blocked_until,omitempty"`
+	Successes    int64      `json:"successes"`
+	Errors       int64      `json:"errors"`
+	RateLimited  int64      `json:"rate_limited"`
+}
+
+const (
+	// the adaptive rate never drops below this fraction of the base rate
+	hostLimitFloorFactor = 0.05
+	// multiplicative decrease on server errors and failed connections
+	hostLimitErrorFactor = 0.8
+	// number of consecutive-ish successes to recover from the floor to the base rate
+	hostLimitRecoverySteps = 20
+	// longest we will pause requests to a host for an advertised rate limit reset
+	maxHostBlock = 10 * time.Minute
+)
+
+func NewHostLimiter(base rate.Limit) *HostLimiter {
+	return &HostLimiter{
+		lim:  rate.NewLimiter(base, 1),
+		base: base,
+	}
+}
+
+// Wait blocks until the host may be sent another request
+func (h *HostLimiter) Wait(ctx context.Context) error {
+	h.lk.Lock()
+	until := h.blockedUntil
+	h.lk.Unlock()
+
+	if d := time.Until(until); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return h.lim.Wait(ctx)
+}
+
+// Allow reports whether a request may happen now, consuming a token if so
+func (h *HostLimiter) Allow() bool {
+	h.lk.Lock()
+	blocked := time.Now().Before(h.blockedUntil)
+	h.lk.Unlock()
+	return !blocked && h.lim.Allow()
+}
+
+// Observe adjusts the rate based on the outcome of a request. Client errors other than 429 (eg, a repo not being found) say nothing about the host's health and are ignored.
+func (h *HostLimiter) Observe(err error) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	if err == nil {
+		h.successes++
+		if !h.override {
+			step := (h.base - h.floor()) / hostLimitRecoverySteps
+			h.setRate(min(h.lim.Limit()+step, h.base))
+		}
+		return
+	}
+
+	var xerr *xrpc.Error
+	if errors.As(err, &xerr) {
+		switch {
+		case xerr.StatusCode == http.StatusTooManyRequests:
+			h.rateLimited++
+			if xerr.Ratelimit != nil && !xerr.Ratelimit.Reset.IsZero() {
+				h.blockUntil(xerr.Ratelimit.Reset)
+			}
+			if !h.override {
+				h.setRate(h.lim.Limit() / 2)
+			}
+			return
+		case xerr.StatusCode < 500:
+			return
+		}
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	h.errors++
+	if !h.override {
+		h.setRate(h.lim.Limit() * hostLimitErrorFactor)
+	}
+}
+
+// ObserveRateLimited records a rate limit response which did not come from an xrpc call (eg, a websocket dial), pausing requests until the given time (if non-zero)
+func (h *HostLimiter) ObserveRateLimited(until time.Time) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	h.rateLimited++
+	if !until.IsZero() {
+		h.blockUntil(until)
+	}
+	if !h.override {
+		h.setRate(h.lim.Limit() / 2)
+	}
+}
+
+// SetLimit changes the base rate. Unless the rate is overridden, it takes effect immediately
+func (h *HostLimiter) SetLimit(l rate.Limit) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	h.base = l
+	if !h.override {
+		h.lim.SetLimit(l)
+	}
+}
+
+// Limit returns the current (possibly adapted) rate
+func (h *HostLimiter) Limit() rate.Limit {
+	return h.lim.Limit()
+}
+
+// SetOverride pins the rate, disabling adaptation and clearing any pause
+func (h *HostLimiter) SetOverride(l rate.Limit) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	h.override = true
+	h.blockedUntil = time.Time{}
+	h.lim.SetLimit(l)
+}
+
+// ClearOverride returns the limiter to adaptive mode, starting from the base rate
+func (h *HostLimiter) ClearOverride() {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	h.override = false
+	h.lim.SetLimit(h.base)
+}
+
+func (h *HostLimiter) Status() HostLimiterStatus {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	st := HostLimiterStatus{
+		Limit:       float64(h.lim.Limit()),
+		BaseLimit:   float64(h.base),
+		Override:    h.override,
+		Successes:   h.successes,
+		Errors:      h.errors,
+		RateLimited: h.rateLimited,
+	}
+	if time.Now().Before(h.blockedUntil) {
+		until := h.blockedUntil
+		st.BlockedUntil = &until
+	}
+	return st
+}
+
+// must be called with the lock held
+func (h *HostLimiter) floor() rate.Limit {
+	return h.base * hostLimitFloorFactor
+}
+
+// must be called with the lock held
+func (h *HostLimiter) setRate(l rate.Limit) {
+	h.lim.SetLimit(max(l, h.floor()))
+}
+
+// must be called with the lock held
+func (h *HostLimiter) blockUntil(t time.Time) {
+	if limit := time.Now().Add(maxHostBlock); t.After(limit) {
+		t = limit
+	}
+	if t.After(h.blockedUntil) {
+		h.blockedUntil = t
+	}
+}
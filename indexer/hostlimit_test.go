@@ -0,0 +1,81 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestHostLimiterAdapts(t *testing.T) {
+	assert := assert.New(t)
+
+	h := NewHostLimiter(10)
+
+	// 429s halve the rate and pause until the advertised reset
+	reset := time.Now().Add(time.Minute)
+	h.Observe(&xrpc.Error{StatusCode: http.StatusTooManyRequests, Ratelimit: &xrpc.RatelimitInfo{Reset: reset}})
+	assert.Equal(rate.Limit(5), h.Limit())
+	st := h.Status()
+	assert.Equal(int64(1), st.RateLimited)
+	assert.NotNil(st.BlockedUntil)
+	assert.False(h.Allow())
+
+	// server and connection errors reduce the rate, client errors are ignored
+	h.Observe(&xrpc.Error{StatusCode: http.StatusBadGateway})
+	assert.Equal(rate.Limit(4), h.Limit())
+	h.Observe(errors.New("connection refused"))
+	assert.InDelta(3.2, float64(h.Limit()), 0.001)
+	h.Observe(&xrpc.Error{StatusCode: http.StatusNotFound})
+	assert.InDelta(3.2, float64(h.Limit()), 0.001)
+	assert.Equal(int64(2), h.Status().Errors)
+
+	// never below the floor
+	for i := 0; i < 50; i++ {
+		h.Observe(errors.New("connection refused"))
+	}
+	assert.InDelta(0.5, float64(h.Limit()), 0.001)
+
+	// successes recover gradually, up to the base rate
+	h.Observe(nil)
+	assert.InDelta(0.975, float64(h.Limit()), 0.001)
+	for i := 0; i < 50; i++ {
+		h.Observe(nil)
+	}
+	assert.Equal(rate.Limit(10), h.Limit())
+}
+
+func TestHostLimiterOverride(t *testing.T) {
+	assert := assert.New(t)
+
+	h := NewHostLimiter(10)
+	h.ObserveRateLimited(time.Now().Add(time.Hour))
+	st := h.Status()
+	assert.NotNil(st.BlockedUntil)
+	// long pauses are capped
+	assert.True(st.BlockedUntil.Before(time.Now().Add(maxHostBlock + time.Second)))
+
+	// an override pins the rate and clears the pause
+	h.SetOverride(100)
+	assert.True(h.Allow())
+	h.Observe(&xrpc.Error{StatusCode: http.StatusTooManyRequests})
+	h.Observe(errors.New("connection refused"))
+	assert.Equal(rate.Limit(100), h.Limit())
+	assert.True(h.Status().Override)
+
+	// changing the base rate doesn't take effect until the override is cleared
+	h.SetLimit(20)
+	assert.Equal(rate.Limit(100), h.Limit())
+	h.ClearOverride()
+	assert.Equal(rate.Limit(20), h.Limit())
+	assert.False(h.Status().Override)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(h.Wait(ctx))
+}
@@ -34,3 +34,8 @@ var catchupEventsProcessed = promauto.NewCounter(prometheus.CounterOpts{
 	Name: "indexer_catchup_events_processed",
 	Help: "Number of catchup events processed",
 })
+
+var crawlRateLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "indexer_crawl_rate_limit",
+	Help: "Current (adaptive) repo fetch rate limit per PDS, in requests per second",
+}, []string{"pds"})
@@ -24,7 +24,7 @@ func NewRepoFetcher(db *gorm.DB, rm *repomgr.RepoManager, maxConcurrency int) *R
 	return &RepoFetcher{
 		repoman:                rm,
 		db:                     db,
-		Limiters:               make(map[uint]*rate.Limiter),
+		Limiters:               make(map[uint]*HostLimiter),
 		ApplyPDSClientSettings: func(*xrpc.Client) {},
 		MaxConcurrency:         maxConcurrency,
 	}
@@ -34,7 +34,8 @@ type RepoFetcher struct {
 	repoman *repomgr.RepoManager
 	db      *gorm.DB
 
-	Limiters map[uint]*rate.Limiter
+	// adaptive crawl rate limiters, by PDS ID
+	Limiters map[uint]*HostLimiter
 	LimitMux sync.RWMutex
 
 	MaxConcurrency int
@@ -42,27 +43,27 @@ type RepoFetcher struct {
 	ApplyPDSClientSettings func(*xrpc.Client)
 }
 
-func (rf *RepoFetcher) GetLimiter(pdsID uint) *rate.Limiter {
+func (rf *RepoFetcher) GetLimiter(pdsID uint) *HostLimiter {
 	rf.LimitMux.RLock()
 	defer rf.LimitMux.RUnlock()
 
 	return rf.Limiters[pdsID]
 }
 
-func (rf *RepoFetcher) GetOrCreateLimiter(pdsID uint, pdsrate float64) *rate.Limiter {
-	rf.LimitMux.RLock()
-	defer rf.LimitMux.RUnlock()
+func (rf *RepoFetcher) GetOrCreateLimiter(pdsID uint, pdsrate float64) *HostLimiter {
+	rf.LimitMux.Lock()
+	defer rf.LimitMux.Unlock()
 
 	lim, ok := rf.Limiters[pdsID]
 	if !ok {
-		lim = rate.NewLimiter(rate.Limit(pdsrate), 1)
+		lim = NewHostLimiter(rate.Limit(pdsrate))
 		rf.Limiters[pdsID] = lim
 	}
 
 	return lim
 }
 
-func (rf *RepoFetcher) SetLimiter(pdsID uint, lim *rate.Limiter) {
+func (rf *RepoFetcher) SetLimiter(pdsID uint, lim *HostLimiter) {
 	rf.LimitMux.Lock()
 	defer rf.LimitMux.Unlock()
 
@@ -82,11 +83,15 @@ func (rf *RepoFetcher) fetchRepo(ctx context.Context, c *xrpc.Client, pds *model
 	limiter := rf.GetOrCreateLimiter(pds.ID, pds.CrawlRateLimit)
 
 	// Wait to prevent DOSing the PDS when connecting to a new stream with lots of active repos
-	limiter.Wait(ctx)
+	if err := limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
 
 	log.Debugw("SyncGetRepo", "did", did, "since", rev)
 	// TODO: max size on these? A malicious PDS could just send us a petabyte sized repo here and kill us
 	repo, err := atproto.SyncGetRepo(ctx, c, did, rev)
+	limiter.Observe(err)
+	crawlRateLimit.WithLabelValues(pds.Host).Set(float64(limiter.Limit()))
 	if err != nil {
 		reposFetched.WithLabelValues("fail").Inc()
 		return nil, fmt.Errorf("failed to fetch repo (did=%s,rev=%s,host=%s): %w", did, rev, pds.Host, err)
@@ -97,6 +102,13 @@ func (rf *RepoFetcher) fetchRepo(ctx context.Context, c *xrpc.Client, pds *model
 }
 
 // TODO: since this function is the only place we depend on the repomanager, i wonder if this should be wired some other way?
+// FetchRepo fetches a repo CAR from the given PDS, respecting the host's crawl rate limit. An empty rev fetches the complete repo
+func (rf *RepoFetcher) FetchRepo(ctx context.Context, pds *models.PDS, did string, rev string) ([]byte, error) {
+	c := models.ClientForPds(pds)
+	rf.ApplyPDSClientSettings(c)
+	return rf.fetchRepo(ctx, c, pds, did, rev)
+}
+
 func (rf *RepoFetcher) FetchAndIndexRepo(ctx context.Context, job *crawlWork) error {
 	ctx, span := otel.Tracer("indexer").Start(ctx, "FetchAndIndexRepo")
 	defer span.End()
@@ -110,6 +110,10 @@ type PDS struct {
 	Cursor     int64
 	Registered bool
 	Blocked    bool
+	// Paused hosts are not subscribed to, but keep their cursor for when they are resumed
+	Paused bool
+	// Trust tier assigned by an admin (see bgs.HostTrustVerified etc), or empty for the relay's default tier
+	Trust string
 
 	RateLimit      float64
 	CrawlRateLimit float64
@@ -0,0 +1,108 @@
+package mst
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/util"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// === "Range operations" ===
+
+// WalkLeavesRange walks the leaves of the tree with keys in the range [from, to),
+// in order, calling cb on each. An empty to means no upper bound. Subtrees
+// entirely outside the range are not loaded.
+// If cb returns an error, the walk is aborted and the error is returned.
+//
+// Record paths are "<collection>/<rkey>", so, for example, the posts created
+// between two TIDs are the range from "app.bsky.feed.post/<tid1>" to
+// "app.bsky.feed.post/<tid2>".
+func (mst *MerkleSearchTree) WalkLeavesRange(ctx context.Context, from, to string, cb func(key string, val cid.Cid) error) error {
+	_, err := mst.walkRange(ctx, from, to, cb)
+	return err
+}
+
+// walkRange returns true once it has passed the end of the range, so parents can stop too
+func (mst *MerkleSearchTree) walkRange(ctx context.Context, from, to string, cb func(key string, val cid.Cid) error) (bool, error) {
+	entries, err := mst.getEntries(ctx)
+	if err != nil {
+		return false, fmt.Errorf("get entries: %w", err)
+	}
+
+	for i, e := range entries {
+		if e.isLeaf() {
+			if to != "" && e.Key >= to {
+				return true, nil
+			}
+			if e.Key >= from {
+				if err := cb(e.Key, e.Val); err != nil {
+					return true, err
+				}
+			}
+			continue
+		}
+		if !e.isTree() {
+			continue
+		}
+
+		// every key in a subtree sorts before the leaf to its right (subtrees are never neighbors), so the subtree can be skipped if that leaf isn't past the start of the range
+		if i+1 < len(entries) && entries[i+1].isLeaf() && entries[i+1].Key <= from {
+			continue
+		}
+		done, err := e.Tree.walkRange(ctx, from, to, cb)
+		if err != nil {
+			return true, err
+		}
+		if done {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RangeProof returns the MST node blocks under root which must be read to list
+// every key in the range [from, to) (see WalkLeavesRange). With the commit
+// block for root, they prove both the values in the range and that no other
+// keys are in it.
+func RangeProof(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, from, to string) ([]blocks.Block, error) {
+	lbs := util.NewLoggingBstore(bs)
+	t := LoadMST(util.CborStore(lbs), root)
+	if err := t.WalkLeavesRange(ctx, from, to, func(key string, val cid.Cid) error { return nil }); err != nil {
+		return nil, err
+	}
+	return lbs.GetLoggedBlocks(), nil
+}
+
+// VerifyRangeProof walks the range [from, to) of the tree at root, using only
+// the blocks of a proof from RangeProof, calling cb on each key in the range.
+// It fails if any block doesn't match its CID, or if the proof is missing a
+// block needed to be sure the walk is complete.
+func VerifyRangeProof(ctx context.Context, root cid.Cid, from, to string, proof []blocks.Block, cb func(key string, val cid.Cid) error) error {
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	for _, blk := range proof {
+		c, err := blk.Cid().Prefix().Sum(blk.RawData())
+		if err != nil {
+			return err
+		}
+		if !c.Equals(blk.Cid()) {
+			return fmt.Errorf("proof block does not match CID %s", blk.Cid())
+		}
+		if err := bs.Put(ctx, blk); err != nil {
+			return err
+		}
+	}
+
+	t := LoadMST(util.CborStore(bs), root)
+	if err := t.WalkLeavesRange(ctx, from, to, cb); err != nil {
+		if ipld.IsNotFound(err) {
+			return fmt.Errorf("incomplete range proof: %w", err)
+		}
+		return err
+	}
+	return nil
+}
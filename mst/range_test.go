@@ -0,0 +1,108 @@
+package mst
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/bluesky-social/indigo/util"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+func TestWalkLeavesRange(t *testing.T) {
+	ctx := context.TODO()
+	bs := memBs()
+
+	m := map[string]cid.Cid{}
+	for i := 0; i < 1000; i++ {
+		m[fmt.Sprintf("app.bsky.feed.post/%04d", i)] = randCid()
+		if i%10 == 0 {
+			m[fmt.Sprintf("app.bsky.feed.like/%04d", i)] = randCid()
+		}
+	}
+	root := mustCidTree(t, cidMapToMst(t, bs, m))
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	collect := func(walk func(cb func(key string, val cid.Cid) error) error) []string {
+		var out []string
+		if err := walk(func(key string, val cid.Cid) error {
+			if m[key] != val {
+				t.Fatalf("value mismatch on %s", key)
+			}
+			out = append(out, key)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	ranges := [][2]string{
+		{"app.bsky.feed.post/0100", "app.bsky.feed.post/0200"},
+		{"app.bsky.feed.like/", "app.bsky.feed.like0"},
+		{"app.bsky.feed.post/0990", ""},
+		{"", "app.bsky.feed.like/0005"},
+		{"app.bsky.feed.post/0500", "app.bsky.feed.post/0500"},
+		{"zzz", ""},
+	}
+	for _, r := range ranges {
+		var expected []string
+		for _, k := range keys {
+			if k >= r[0] && (r[1] == "" || k < r[1]) {
+				expected = append(expected, k)
+			}
+		}
+
+		got := collect(func(cb func(key string, val cid.Cid) error) error {
+			return LoadMST(util.CborStore(bs), root).WalkLeavesRange(ctx, r[0], r[1], cb)
+		})
+		if fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Fatalf("range %q: expected %d keys, got %d", r, len(expected), len(got))
+		}
+
+		proof, err := RangeProof(ctx, bs, root, r[0], r[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		verified := collect(func(cb func(key string, val cid.Cid) error) error {
+			return VerifyRangeProof(ctx, root, r[0], r[1], proof, cb)
+		})
+		if fmt.Sprint(verified) != fmt.Sprint(expected) {
+			t.Fatalf("range %q: expected %d verified keys, got %d", r, len(expected), len(verified))
+		}
+	}
+
+	// a narrow range only needs part of the tree
+	proof, err := RangeProof(ctx, bs, root, "app.bsky.feed.post/0100", "app.bsky.feed.post/0110")
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := RangeProof(ctx, bs, root, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof) >= len(full) {
+		t.Fatalf("expected narrow proof to be smaller than the whole tree: %d >= %d", len(proof), len(full))
+	}
+
+	noop := func(key string, val cid.Cid) error { return nil }
+	if err := VerifyRangeProof(ctx, root, "app.bsky.feed.post/0100", "app.bsky.feed.post/0300", proof, noop); err == nil {
+		t.Fatal("expected proof not to cover a wider range")
+	}
+	tampered := append([]blocks.Block{}, proof...)
+	raw := append([]byte{}, tampered[0].RawData()...)
+	bad, err := blocks.NewBlockWithCid(append(raw, 0), tampered[0].Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered[0] = bad
+	if err := VerifyRangeProof(ctx, root, "app.bsky.feed.post/0100", "app.bsky.feed.post/0110", tampered, noop); err == nil {
+		t.Fatal("expected tampered proof to fail")
+	}
+}
@@ -0,0 +1,195 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-car"
+	"gorm.io/gorm"
+)
+
+// Max size of a repo CAR file accepted by importRepo
+const maxImportRepoSize = 1024 * 1024 * 1024
+
+// createMigratedAccount creates an account for an existing DID, being migrated from another PDS. The account starts out deactivated and empty: the client then imports the repo and blobs, updates the DID document to point at this PDS, and activates the account. Activation checks the DID document, so only the controller of the DID can complete a migration
+func (s *Server) createMigratedAccount(ctx context.Context, body *comatprototypes.ServerCreateAccount_Input) (*comatprototypes.ServerCreateAccount_Output, error) {
+	did := *body.Did
+	if !strings.HasPrefix(did, "did:") {
+		return nil, fmt.Errorf("invalid DID: %q", did)
+	}
+	_, err := s.lookupUserByDid(ctx, did)
+	switch {
+	case err == nil:
+		return nil, fmt.Errorf("an account already exists for this DID")
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	u := User{
+		Handle:      body.Handle,
+		Password:    *body.Password,
+		Email:       *body.Email,
+		Did:         did,
+		Deactivated: true,
+	}
+	if err := s.db.Create(&u).Error; err != nil {
+		return nil, err
+	}
+
+	ai := &models.ActorInfo{
+		Uid:    u.ID,
+		Did:    did,
+		Handle: sql.NullString{String: body.Handle, Valid: true},
+	}
+	if err := s.db.Create(ai).Error; err != nil {
+		return nil, err
+	}
+
+	tok, err := s.createAuthTokenForUser(ctx, body.Handle, did, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &comatprototypes.ServerCreateAccount_Output{
+		Handle:     body.Handle,
+		Did:        did,
+		AccessJwt:  tok.AccessJwt,
+		RefreshJwt: tok.RefreshJwt,
+	}, nil
+}
+
+// the active and status fields of session responses for an account
+func accountStatus(u *User) (*bool, *string) {
+	active := !u.Deactivated
+	if active {
+		return &active, nil
+	}
+	status := events.AccountStatusDeactivated
+	return &active, &status
+}
+
+// requireActive fails for deactivated accounts, which can't write to their repo, or have it synced, until they are activated
+func requireActive(u *User) error {
+	if u.Deactivated {
+		return fmt.Errorf("account is deactivated")
+	}
+	return nil
+}
+
+// checkDidDoc checks that an account's DID document points at this PDS: that its atproto signing key is the PDS key, and its PDS service endpoint is this PDS (if the PDS has a service URL)
+func (s *Server) checkDidDoc(ctx context.Context, did string) error {
+	// account migration updates the DID document right before activation, so a cached copy will be stale
+	s.plc.FlushCacheFor(did)
+	doc, err := s.plc.GetDocument(ctx, did)
+	if err != nil {
+		return fmt.Errorf("resolving DID: %w", err)
+	}
+
+	pk, err := doc.GetPublicKey("#atproto")
+	if err != nil {
+		return fmt.Errorf("DID document has no atproto signing key: %w", err)
+	}
+	if !pk.Equal(s.signingKey.Public()) {
+		return fmt.Errorf("DID document signing key is not the PDS signing key")
+	}
+
+	if s.serviceUrl == "" {
+		return nil
+	}
+	for _, svc := range doc.Service {
+		ep := strings.TrimSuffix(svc.ServiceEndpoint, "/")
+		if ep == strings.TrimSuffix(s.serviceUrl, "/") {
+			return nil
+		}
+		// the service URL may be configured as just a hostname
+		if u, err := url.Parse(ep); err == nil && u.Host == s.serviceUrl {
+			return nil
+		}
+	}
+	return fmt.Errorf("DID document service endpoint is not this PDS")
+}
+
+type repoStats struct {
+	Blocks  int64
+	Records int64
+	// CIDs of the distinct blobs referenced by records
+	Blobs []string
+}
+
+// computeRepoStats walks the full current repo of an account
+func (s *Server) computeRepoStats(ctx context.Context, u *User) (*repoStats, error) {
+	buf := new(bytes.Buffer)
+	if err := s.repoman.ReadRepo(ctx, u.ID, "", buf); err != nil {
+		return nil, err
+	}
+	carr, err := car.NewCarReader(buf)
+	if err != nil {
+		return nil, err
+	}
+	if len(carr.Header.Roots) != 1 {
+		return nil, fmt.Errorf("invalid repo car, expected a single root")
+	}
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	for {
+		blk, err := carr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := bs.Put(ctx, blk); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := repo.OpenRepo(ctx, bs, carr.Header.Roots[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var stats repoStats
+	if blocks, err := bs.AllKeysChan(ctx); err == nil {
+		for range blocks {
+			stats.Blocks++
+		}
+	}
+	blobs := make(map[string]bool)
+	if err := r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+		stats.Records++
+		blk, err := bs.Get(ctx, v)
+		if err != nil {
+			return err
+		}
+		rec, err := data.UnmarshalCBOR(blk.RawData())
+		if err != nil {
+			// not a valid record, so can't reference blobs
+			return nil
+		}
+		for _, b := range data.ExtractBlobs(rec) {
+			blobs[b.Ref.String()] = true
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for c := range blobs {
+		stats.Blobs = append(stats.Blobs, c)
+	}
+	return &stats, nil
+}
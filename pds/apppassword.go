@@ -0,0 +1,145 @@
+package pds
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Auth token scopes. App password sessions get a distinct scope from full (account password) sessions, and can't manage app passwords
+const (
+	scopeAccess            = "com.atproto.access"
+	scopeAppPass           = "com.atproto.appPass"
+	scopeAppPassPrivileged = "com.atproto.appPassPrivileged"
+	scopeRefresh           = "com.atproto.refresh"
+)
+
+// Token claim holding the name of the app password a session was created with
+const appPasswordClaim = "app_password"
+
+const (
+	maxAppPasswordsPerAccount = 25
+	maxAppPasswordNameLength  = 100
+	appPasswordAlphabet       = "abcdefghijklmnopqrstuvwxyz234567"
+)
+
+// AppPassword is a named, revocable password for an account, for signing in to third-party clients. Only a hash of the password is stored
+type AppPassword struct {
+	ID           uint `gorm:"primarykey"`
+	CreatedAt    time.Time
+	Usr          models.Uid `gorm:"uniqueIndex:idx_app_password_usr_name"`
+	Name         string     `gorm:"uniqueIndex:idx_app_password_usr_name"`
+	PasswordHash []byte
+	Privileged   bool
+}
+
+// scope of access tokens for sessions created with this app password
+func (ap *AppPassword) scope() string {
+	if ap.Privileged {
+		return scopeAppPassPrivileged
+	}
+	return scopeAppPass
+}
+
+// generates a random app password, formatted like "abcd-efgh-ijkl-mnop"
+func generateAppPassword() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	out := make([]byte, 0, 19)
+	for i, b := range raw {
+		if i > 0 && i%4 == 0 {
+			out = append(out, '-')
+		}
+		out = append(out, appPasswordAlphabet[int(b)%len(appPasswordAlphabet)])
+	}
+	return string(out), nil
+}
+
+func (s *Server) createAppPassword(ctx context.Context, u *User, name string, privileged bool) (*AppPassword, string, error) {
+	if name == "" || len(name) > maxAppPasswordNameLength {
+		return nil, "", fmt.Errorf("invalid app password name")
+	}
+
+	var count int64
+	if err := s.db.Model(&AppPassword{}).Where("usr = ?", u.ID).Count(&count).Error; err != nil {
+		return nil, "", err
+	}
+	if count >= maxAppPasswordsPerAccount {
+		return nil, "", fmt.Errorf("too many app passwords (max %d)", maxAppPasswordsPerAccount)
+	}
+	var existing int64
+	if err := s.db.Model(&AppPassword{}).Where("usr = ? AND name = ?", u.ID, name).Count(&existing).Error; err != nil {
+		return nil, "", err
+	}
+	if existing > 0 {
+		return nil, "", fmt.Errorf("app password name already in use")
+	}
+
+	password, err := generateAppPassword()
+	if err != nil {
+		return nil, "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ap := &AppPassword{
+		Usr:          u.ID,
+		Name:         name,
+		PasswordHash: hash,
+		Privileged:   privileged,
+	}
+	if err := s.db.Create(ap).Error; err != nil {
+		return nil, "", err
+	}
+	return ap, password, nil
+}
+
+// finds the app password of an account matching password, if any
+func (s *Server) checkAppPassword(ctx context.Context, u *User, password string) (*AppPassword, error) {
+	var aps []AppPassword
+	if err := s.db.Find(&aps, "usr = ?", u.ID).Error; err != nil {
+		return nil, err
+	}
+	for i := range aps {
+		if bcrypt.CompareHashAndPassword(aps[i].PasswordHash, []byte(password)) == nil {
+			return &aps[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// looks up the app password a session token was issued for, failing if it has since been revoked (including if another app password was created with the same name)
+func (s *Server) lookupSessionAppPassword(ctx context.Context, u *User, name string, issuedAt time.Time) (*AppPassword, error) {
+	var ap AppPassword
+	if err := s.db.Find(&ap, "usr = ? AND name = ?", u.ID, name).Error; err != nil {
+		return nil, err
+	}
+	// token timestamps have second precision
+	if ap.ID == 0 || ap.CreatedAt.Truncate(time.Second).After(issuedAt) {
+		return nil, fmt.Errorf("app password has been revoked")
+	}
+	return &ap, nil
+}
+
+// requireFullAccess fails unless the request was authenticated with a full (account password) session
+func (s *Server) requireFullAccess(ctx context.Context) error {
+	scope, _ := ctx.Value("authScope").(string)
+	if scope != scopeAccess {
+		return fmt.Errorf("this action requires a full session, not an app password")
+	}
+	return nil
+}
+
+// the app password a request was authenticated with, if any
+func sessionAppPassword(ctx context.Context) *AppPassword {
+	ap, _ := ctx.Value("appPassword").(*AppPassword)
+	return ap
+}
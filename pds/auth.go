@@ -22,9 +22,18 @@ func makeToken(subject string, scope string, exp time.Time) jwt.Token {
 	return tok
 }
 
-func (s *Server) createAuthTokenForUser(ctx context.Context, handle, did string) (*xrpc.AuthInfo, error) {
-	accessTok := makeToken(did, "com.atproto.access", time.Now().Add(24*time.Hour))
-	refreshTok := makeToken(did, "com.atproto.refresh", time.Now().Add(7*24*time.Hour))
+// createAuthTokenForUser creates session tokens for an account. If ap is set, the session is scoped to that app password
+func (s *Server) createAuthTokenForUser(ctx context.Context, handle, did string, ap *AppPassword) (*xrpc.AuthInfo, error) {
+	scope := scopeAccess
+	if ap != nil {
+		scope = ap.scope()
+	}
+	accessTok := makeToken(did, scope, time.Now().Add(24*time.Hour))
+	refreshTok := makeToken(did, scopeRefresh, time.Now().Add(7*24*time.Hour))
+	if ap != nil {
+		accessTok.Set(appPasswordClaim, ap.Name)
+		refreshTok.Set(appPasswordClaim, ap.Name)
+	}
 
 	rval := make([]byte, 10)
 	rand.Read(rval)
@@ -0,0 +1,152 @@
+package pds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/util"
+	"github.com/ipfs/go-cid"
+)
+
+// ErrBlobNotFound is returned by a BlobStore when it doesn't have the requested blob
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore holds the blobs uploaded by accounts on the PDS. Blobs are content-addressed, and stored under the account DID and blob CID, so re-uploading the same blob is idempotent. Reads and writes are streamed, not buffered in memory.
+type BlobStore interface {
+	PutBlob(ctx context.Context, did string, c cid.Cid, size int64, r io.Reader) error
+	GetBlob(ctx context.Context, did string, c cid.Cid) (io.ReadCloser, error)
+	DeleteBlob(ctx context.Context, did string, c cid.Cid) error
+}
+
+// key (relative path) of a blob in a BlobStore
+func blobKey(did string, c cid.Cid) (string, error) {
+	if did == "" || strings.ContainsAny(did, `/\`) {
+		return "", fmt.Errorf("invalid account DID: %q", did)
+	}
+	return did + "/" + c.String(), nil
+}
+
+// DiskBlobStore is a BlobStore keeping blobs in a local directory
+type DiskBlobStore struct {
+	Dir string
+}
+
+func (bs *DiskBlobStore) PutBlob(ctx context.Context, did string, c cid.Cid, size int64, r io.Reader) error {
+	key, err := blobKey(did, c)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(bs.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary file and rename, so readers never see a partial blob
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("writing blob: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (bs *DiskBlobStore) GetBlob(ctx context.Context, did string, c cid.Cid) (io.ReadCloser, error) {
+	key, err := blobKey(did, c)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(bs.Dir, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+func (bs *DiskBlobStore) DeleteBlob(ctx context.Context, did string, c cid.Cid) error {
+	key, err := blobKey(did, c)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(bs.Dir, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// S3BlobStore is a BlobStore keeping blobs in an S3 (or S3-compatible, eg minio, or GCS in interoperability mode) bucket
+type S3BlobStore struct {
+	util.S3Client
+}
+
+func (bs *S3BlobStore) PutBlob(ctx context.Context, did string, c cid.Cid, size int64, r io.Reader) error {
+	key, err := blobKey(did, c)
+	if err != nil {
+		return err
+	}
+	if err := bs.PutObject(ctx, key, size, r, ""); err != nil {
+		return fmt.Errorf("uploading blob to S3: %w", err)
+	}
+	return nil
+}
+
+func (bs *S3BlobStore) GetBlob(ctx context.Context, did string, c cid.Cid) (io.ReadCloser, error) {
+	key, err := blobKey(did, c)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := bs.GetObject(ctx, key)
+	if errors.Is(err, util.ErrS3ObjectNotFound) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching blob from S3: %w", err)
+	}
+	return rc, nil
+}
+
+func (bs *S3BlobStore) DeleteBlob(ctx context.Context, did string, c cid.Cid) error {
+	key, err := blobKey(did, c)
+	if err != nil {
+		return err
+	}
+	if err := bs.DeleteObject(ctx, key); err != nil {
+		return fmt.Errorf("deleting blob from S3: %w", err)
+	}
+	return nil
+}
+
+// ParseBlobStore configures a BlobStore from a URI: either a local directory path (created if it doesn't exist), or "s3://<bucket>/<prefix>". S3 configuration and credentials are read from the standard AWS_REGION, AWS_ENDPOINT_URL, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment variables.
+func ParseBlobStore(uri string) (BlobStore, error) {
+	if !strings.HasPrefix(uri, "s3://") {
+		if err := os.MkdirAll(uri, 0755); err != nil {
+			return nil, fmt.Errorf("blob store directory: %w", err)
+		}
+		return &DiskBlobStore{Dir: uri}, nil
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 blob store URI: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("S3 blob store URI must include bucket name: %s", uri)
+	}
+	return &S3BlobStore{
+		S3Client: util.S3ClientFromEnv(u.Host, strings.TrimPrefix(u.Path, "/"), 600*time.Second),
+	}, nil
+}
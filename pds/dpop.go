@@ -0,0 +1,164 @@
+package pds
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// errUseDPoPNonce is returned when a DPoP proof is missing a server-issued nonce, or it has expired. Clients are expected to retry with the nonce in the DPoP-Nonce response header
+var errUseDPoPNonce = errors.New("use_dpop_nonce")
+
+const (
+	// DPoP nonces rotate this often, and are accepted until the next rotation
+	dpopNoncePeriod = 3 * time.Minute
+	// Max clock difference allowed for DPoP proof iat
+	dpopMaxSkew = 5 * time.Minute
+)
+
+// dpopVerifier checks DPoP proofs (RFC 9449): proof-of-possession JWTs, signed by a client key, which OAuth tokens are bound to
+type dpopVerifier struct {
+	nonceSecret []byte
+
+	lk sync.Mutex
+	// proof IDs seen recently, to reject replayed proofs
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func newDPoPVerifier() (*dpopVerifier, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &dpopVerifier{nonceSecret: secret, seen: make(map[string]time.Time)}, nil
+}
+
+func (v *dpopVerifier) nonceAt(t time.Time) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.UnixNano()/int64(dpopNoncePeriod)))
+	mac := hmac.New(sha256.New, v.nonceSecret)
+	mac.Write(counter[:])
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Nonce returns the current nonce, to send in DPoP-Nonce response headers
+func (v *dpopVerifier) Nonce() string {
+	return v.nonceAt(time.Now())
+}
+
+func (v *dpopVerifier) validNonce(nonce string) bool {
+	now := time.Now()
+	return nonce != "" && (nonce == v.nonceAt(now) || nonce == v.nonceAt(now.Add(-dpopNoncePeriod)))
+}
+
+type dpopClaims struct {
+	JTI   string `json:"jti"`
+	HTM   string `json:"htm"`
+	HTU   string `json:"htu"`
+	IAT   int64  `json:"iat"`
+	Nonce string `json:"nonce"`
+	ATH   string `json:"ath"`
+}
+
+// Verify checks a DPoP proof for a request to url (without query or fragment) with the given method. If accessToken is set, the proof must be bound to it. Returns the JWK thumbprint of the proof key, which tokens are bound to
+func (v *dpopVerifier) Verify(proof, method, url, accessToken string) (string, error) {
+	if proof == "" {
+		return "", fmt.Errorf("missing DPoP proof")
+	}
+	msg, err := jws.Parse([]byte(proof))
+	if err != nil {
+		return "", fmt.Errorf("invalid DPoP proof: %w", err)
+	}
+	if len(msg.Signatures()) != 1 {
+		return "", fmt.Errorf("invalid DPoP proof: expected one signature")
+	}
+	hdr := msg.Signatures()[0].ProtectedHeaders()
+	if hdr.Type() != "dpop+jwt" {
+		return "", fmt.Errorf("invalid DPoP proof: wrong typ")
+	}
+	if hdr.Algorithm() != jwa.ES256 {
+		return "", fmt.Errorf("invalid DPoP proof: unsupported alg %s", hdr.Algorithm())
+	}
+	key := hdr.JWK()
+	if key == nil {
+		return "", fmt.Errorf("invalid DPoP proof: missing jwk")
+	}
+	if _, ok := key.(jwk.ECDSAPrivateKey); ok {
+		return "", fmt.Errorf("invalid DPoP proof: jwk must be a public key")
+	}
+	payload, err := jws.Verify([]byte(proof), jws.WithKey(jwa.ES256, key))
+	if err != nil {
+		return "", fmt.Errorf("invalid DPoP proof signature: %w", err)
+	}
+
+	var claims dpopClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("invalid DPoP proof claims: %w", err)
+	}
+	if claims.HTM != method {
+		return "", fmt.Errorf("DPoP proof is for a different method")
+	}
+	htu, _, _ := strings.Cut(claims.HTU, "?")
+	htu, _, _ = strings.Cut(htu, "#")
+	if htu != url {
+		return "", fmt.Errorf("DPoP proof is for a different URL")
+	}
+	iat := time.Unix(claims.IAT, 0)
+	if time.Since(iat) > dpopMaxSkew || time.Until(iat) > dpopMaxSkew {
+		return "", fmt.Errorf("DPoP proof iat out of range")
+	}
+	if accessToken != "" {
+		ath := sha256.Sum256([]byte(accessToken))
+		if claims.ATH != base64.RawURLEncoding.EncodeToString(ath[:]) {
+			return "", fmt.Errorf("DPoP proof is for a different access token")
+		}
+	}
+	if !v.validNonce(claims.Nonce) {
+		return "", errUseDPoPNonce
+	}
+	if claims.JTI == "" || !v.markSeen(claims.JTI) {
+		return "", fmt.Errorf("DPoP proof has been used before")
+	}
+
+	thumb, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(thumb), nil
+}
+
+// records a proof ID, returning false if it has been seen already
+func (v *dpopVerifier) markSeen(jti string) bool {
+	v.lk.Lock()
+	defer v.lk.Unlock()
+
+	now := time.Now()
+	if now.Sub(v.lastPrune) > time.Minute {
+		for id, t := range v.seen {
+			// proofs this old are rejected by the iat check
+			if now.Sub(t) > 2*dpopMaxSkew {
+				delete(v.seen, id)
+			}
+		}
+		v.lastPrune = now
+	}
+	if _, ok := v.seen[jti]; ok {
+		return false
+	}
+	v.seen[jti] = now
+	return true
+}
@@ -0,0 +1,121 @@
+package pds
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+)
+
+// Purposes of email tokens. An account has at most one outstanding token for each
+const (
+	emailTokenConfirmEmail  = "confirm_email"
+	emailTokenResetPassword = "reset_password"
+	emailTokenUpdateEmail   = "update_email"
+)
+
+const emailTokenLifetime = 15 * time.Minute
+
+// EmailToken is a single-use code emailed to an account, proving control of its email address, to confirm the address, reset the account password, or change the address. Only a hash of the code is stored
+type EmailToken struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Usr       models.Uid `gorm:"uniqueIndex:idx_email_token_usr_purpose"`
+	Purpose   string     `gorm:"uniqueIndex:idx_email_token_usr_purpose"`
+	TokenHash string     `gorm:"index"`
+	ExpiresAt time.Time
+}
+
+// SetMailer configures how the PDS sends email. Email confirmation and password resets fail if no mailer is configured
+func (s *Server) SetMailer(m Mailer) {
+	s.mailer = m
+}
+
+func (s *Server) sendMail(ctx context.Context, to, subject, body string) error {
+	if s.mailer == nil {
+		return fmt.Errorf("this PDS is not configured to send email")
+	}
+	if err := s.mailer.SendMail(ctx, to, subject, body); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	return nil
+}
+
+// generates a random email token, formatted like "abcde-fghij"
+func generateEmailToken() (string, error) {
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	out := make([]byte, 0, 11)
+	for i, b := range raw {
+		if i == 5 {
+			out = append(out, '-')
+		}
+		out = append(out, appPasswordAlphabet[int(b)%len(appPasswordAlphabet)])
+	}
+	return string(out), nil
+}
+
+// issues a new email token for an account, replacing any outstanding token for the same purpose
+func (s *Server) issueEmailToken(ctx context.Context, u *User, purpose string) (string, error) {
+	tok, err := generateEmailToken()
+	if err != nil {
+		return "", err
+	}
+	if err := s.db.Where("usr = ? AND purpose = ?", u.ID, purpose).Delete(&EmailToken{}).Error; err != nil {
+		return "", err
+	}
+	if err := s.db.Create(&EmailToken{
+		Usr:       u.ID,
+		Purpose:   purpose,
+		TokenHash: hashToken(tok),
+		ExpiresAt: time.Now().Add(emailTokenLifetime),
+	}).Error; err != nil {
+		return "", err
+	}
+	return tok, nil
+}
+
+// consumes an email token, returning the account it was issued to
+func (s *Server) consumeEmailToken(ctx context.Context, purpose, token string) (models.Uid, error) {
+	var et EmailToken
+	if err := s.db.Find(&et, "purpose = ? AND token_hash = ?", purpose, hashToken(strings.ToLower(strings.TrimSpace(token)))).Error; err != nil {
+		return 0, err
+	}
+	if et.ID == 0 {
+		return 0, fmt.Errorf("invalid or expired token")
+	}
+	if err := s.db.Delete(&et).Error; err != nil {
+		return 0, err
+	}
+	if time.Now().After(et.ExpiresAt) {
+		return 0, fmt.Errorf("invalid or expired token")
+	}
+	return et.Usr, nil
+}
+
+// emails a token to an account
+func (s *Server) sendEmailToken(ctx context.Context, u *User, to, purpose string) error {
+	tok, err := s.issueEmailToken(ctx, u, purpose)
+	if err != nil {
+		return err
+	}
+
+	var subject, action string
+	switch purpose {
+	case emailTokenConfirmEmail:
+		subject, action = "Confirm your email address", "confirm your email address"
+	case emailTokenResetPassword:
+		subject, action = "Reset your password", "reset your password"
+	case emailTokenUpdateEmail:
+		subject, action = "Confirm your email address change", "change your email address"
+	default:
+		return fmt.Errorf("unknown email token purpose: %q", purpose)
+	}
+	body := fmt.Sprintf("Enter this code to %s on @%s:\n\n%s\n\nThe code expires in %d minutes. If you didn't request it, you can ignore this email.\n", action, u.Handle, tok, int(emailTokenLifetime.Minutes()))
+	return s.sendMail(ctx, to, subject, body)
+}
@@ -3,15 +3,21 @@ package pds
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"fmt"
 	"io"
+	"os"
+	"strings"
+	"time"
 
 	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/util"
 	"github.com/ipfs/go-cid"
 	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/multiformats/go-multihash"
 )
 
 func (s *Server) handleComAtprotoServerCreateAccount(ctx context.Context, body *comatprototypes.ServerCreateAccount_Input) (*comatprototypes.ServerCreateAccount_Output, error) {
@@ -41,6 +47,10 @@ func (s *Server) handleComAtprotoServerCreateAccount(ctx context.Context, body *
 		// handle is available, lets go
 	}
 
+	if body.Did != nil {
+		return s.createMigratedAccount(ctx, body)
+	}
+
 	var recoveryKey string
 	if body.RecoveryKey != nil {
 		recoveryKey = *body.RecoveryKey
@@ -83,7 +93,7 @@ func (s *Server) handleComAtprotoServerCreateAccount(ctx context.Context, body *
 		return nil, err
 	}
 
-	tok, err := s.createAuthTokenForUser(ctx, body.Handle, d)
+	tok, err := s.createAuthTokenForUser(ctx, body.Handle, d, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -116,15 +126,110 @@ func (s *Server) handleComAtprotoServerDeleteAccount(ctx context.Context, body *
 }
 
 func (s *Server) handleComAtprotoServerRequestPasswordReset(ctx context.Context, body *comatprototypes.ServerRequestPasswordReset_Input) error {
-	panic("not yet implemented")
+	var u User
+	if err := s.db.Find(&u, "lower(email) = lower(?)", body.Email).Error; err != nil {
+		return err
+	}
+	// don't reveal whether an account exists for the address
+	if u.ID == 0 {
+		return nil
+	}
+
+	return s.sendEmailToken(ctx, &u, u.Email, emailTokenResetPassword)
 }
 
 func (s *Server) handleComAtprotoServerResetPassword(ctx context.Context, body *comatprototypes.ServerResetPassword_Input) error {
-	panic("not yet implemented")
+	if body.Password == "" {
+		return fmt.Errorf("password is required")
+	}
+	uid, err := s.consumeEmailToken(ctx, emailTokenResetPassword, body.Token)
+	if err != nil {
+		return err
+	}
+
+	// receiving the token also confirms the email address
+	updates := map[string]any{"password": body.Password}
+	var u User
+	if err := s.db.First(&u, "id = ?", uid).Error; err != nil {
+		return err
+	}
+	if u.EmailConfirmedAt == nil {
+		updates["email_confirmed_at"] = time.Now()
+	}
+	return s.db.Model(&u).Updates(updates).Error
 }
 
 func (s *Server) handleComAtprotoRepoUploadBlob(ctx context.Context, r io.Reader, contentType string) (*comatprototypes.RepoUploadBlob_Output, error) {
-	panic("not yet implemented")
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.blobs == nil {
+		return nil, fmt.Errorf("blob uploads not supported")
+	}
+
+	// the CID isn't known until the whole blob has been read, so spool it to disk while hashing
+	tmp, err := os.CreateTemp("", "pds-blob-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(r, maxBlobSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading blob: %w", err)
+	}
+	if size > maxBlobSize {
+		return nil, fmt.Errorf("blob too large (max %d bytes)", maxBlobSize)
+	}
+	mh, err := multihash.Encode(hasher.Sum(nil), multihash.SHA2_256)
+	if err != nil {
+		return nil, err
+	}
+	c := cid.NewCidV1(cid.Raw, mh)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	out := &comatprototypes.RepoUploadBlob_Output{
+		Blob: &lexutil.LexBlob{
+			Ref:      lexutil.LexLink(c),
+			MimeType: contentType,
+			Size:     size,
+		},
+	}
+
+	var existing int64
+	if err := s.db.Model(&Blob{}).Where("usr = ? AND cid = ?", u.ID, c.String()).Count(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return out, nil
+	}
+
+	if s.blobQuota > 0 {
+		var used sql.NullInt64
+		if err := s.db.Model(&Blob{}).Where("usr = ?", u.ID).Select("SUM(size)").Scan(&used).Error; err != nil {
+			return nil, err
+		}
+		if used.Int64+size > s.blobQuota {
+			return nil, fmt.Errorf("blob storage quota exceeded (%d of %d bytes used)", used.Int64, s.blobQuota)
+		}
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := s.blobs.PutBlob(ctx, u.Did, c, size, tmp); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(&Blob{Usr: u.ID, Cid: c.String(), MimeType: contentType, Size: size}).Error; err != nil {
+		return nil, err
+	}
+
+	return out, nil
 }
 
 func (s *Server) handleComAtprotoIdentityResolveHandle(ctx context.Context, handle string) (*comatprototypes.IdentityResolveHandle_Output, error) {
@@ -148,6 +253,9 @@ func (s *Server) handleComAtprotoRepoApplyWrites(ctx context.Context, body *coma
 	if u.Did != body.Repo {
 		return fmt.Errorf("writes for non-user actors not supported (DID mismatch)")
 	}
+	if err := requireActive(u); err != nil {
+		return err
+	}
 
 	return s.repoman.BatchWrite(ctx, u.ID, body.Writes)
 }
@@ -157,6 +265,9 @@ func (s *Server) handleComAtprotoRepoCreateRecord(ctx context.Context, input *co
 	if err != nil {
 		return nil, fmt.Errorf("get user: %w", err)
 	}
+	if err := requireActive(u); err != nil {
+		return nil, err
+	}
 
 	rpath, recid, err := s.repoman.CreateRecord(ctx, u.ID, input.Collection, input.Record.Val)
 	if err != nil {
@@ -178,6 +289,9 @@ func (s *Server) handleComAtprotoRepoDeleteRecord(ctx context.Context, input *co
 	if u.Did != input.Repo {
 		return fmt.Errorf("specified DID did not match authed user")
 	}
+	if err := requireActive(u); err != nil {
+		return err
+	}
 
 	return s.repoman.DeleteRecord(ctx, u.ID, input.Collection, input.Rkey)
 }
@@ -237,20 +351,34 @@ func (s *Server) handleComAtprotoServerCreateSession(ctx context.Context, body *
 		return nil, err
 	}
 
+	// either the account password, or one of its app passwords
+	var ap *AppPassword
 	if body.Password != u.Password {
-		return nil, ErrInvalidUsernameOrPassword
+		ap, err = s.checkAppPassword(ctx, u, body.Password)
+		if err != nil {
+			return nil, err
+		}
+		if ap == nil {
+			return nil, ErrInvalidUsernameOrPassword
+		}
 	}
 
-	tok, err := s.createAuthTokenForUser(ctx, body.Identifier, u.Did)
+	tok, err := s.createAuthTokenForUser(ctx, body.Identifier, u.Did, ap)
 	if err != nil {
 		return nil, err
 	}
 
+	active, status := accountStatus(u)
+	confirmed := u.EmailConfirmedAt != nil
 	return &comatprototypes.ServerCreateSession_Output{
-		Handle:     body.Identifier,
-		Did:        u.Did,
-		AccessJwt:  tok.AccessJwt,
-		RefreshJwt: tok.RefreshJwt,
+		Handle:         body.Identifier,
+		Did:            u.Did,
+		AccessJwt:      tok.AccessJwt,
+		RefreshJwt:     tok.RefreshJwt,
+		Active:         active,
+		Status:         status,
+		Email:          &u.Email,
+		EmailConfirmed: &confirmed,
 	}, nil
 }
 
@@ -264,9 +392,15 @@ func (s *Server) handleComAtprotoServerGetSession(ctx context.Context) (*comatpr
 		return nil, err
 	}
 
+	active, status := accountStatus(u)
+	confirmed := u.EmailConfirmedAt != nil
 	return &comatprototypes.ServerGetSession_Output{
-		Handle: u.Handle,
-		Did:    u.Did,
+		Handle:         u.Handle,
+		Did:            u.Did,
+		Active:         active,
+		Status:         status,
+		Email:          &u.Email,
+		EmailConfirmed: &confirmed,
 	}, nil
 }
 
@@ -281,7 +415,7 @@ func (s *Server) handleComAtprotoServerRefreshSession(ctx context.Context) (*com
 		return nil, fmt.Errorf("scope not present in refresh token")
 	}
 
-	if scope != "com.atproto.refresh" {
+	if scope != scopeRefresh {
 		return nil, fmt.Errorf("auth token did not have refresh scope")
 	}
 
@@ -294,7 +428,7 @@ func (s *Server) handleComAtprotoServerRefreshSession(ctx context.Context) (*com
 		return nil, err
 	}
 
-	outTok, err := s.createAuthTokenForUser(ctx, u.Handle, u.Did)
+	outTok, err := s.createAuthTokenForUser(ctx, u.Handle, u.Did, sessionAppPassword(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -321,6 +455,9 @@ func (s *Server) handleComAtprotoSyncGetHead(ctx context.Context, did string) (*
 	if err != nil {
 		return nil, err
 	}
+	if err := requireActive(user); err != nil {
+		return nil, err
+	}
 
 	root, err := s.repoman.GetRepoRoot(ctx, user.ID)
 	if err != nil {
@@ -341,6 +478,9 @@ func (s *Server) handleComAtprotoSyncGetRepo(ctx context.Context, did string, si
 	if err != nil {
 		return nil, err
 	}
+	if err := requireActive(targetUser); err != nil {
+		return nil, err
+	}
 
 	buf := new(bytes.Buffer)
 	if err := s.repoman.ReadRepo(ctx, targetUser.ID, since, buf); err != nil {
@@ -362,12 +502,57 @@ func (s *Server) handleComAtprotoSyncRequestCrawl(ctx context.Context, body *com
 	panic("nyi")
 }
 
-func (s *Server) handleComAtprotoSyncGetBlob(ctx context.Context, cid string, did string) (io.Reader, error) {
-	panic("nyi")
+func (s *Server) handleComAtprotoSyncGetBlob(ctx context.Context, cidStr string, did string) (io.Reader, error) {
+	if s.blobs == nil {
+		return nil, ErrBlobNotFound
+	}
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blob CID: %w", err)
+	}
+	u, err := s.lookupUserByDid(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.Model(&Blob{}).Where("usr = ? AND cid = ?", u.ID, c.String()).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrBlobNotFound
+	}
+
+	return s.blobs.GetBlob(ctx, u.Did, c)
 }
 
 func (s *Server) handleComAtprotoSyncListBlobs(ctx context.Context, cursor string, did string, limit int, since string) (*comatprototypes.SyncListBlobs_Output, error) {
-	panic("nyi")
+	if since != "" {
+		return nil, fmt.Errorf("listing blobs since a revision not supported")
+	}
+	u, err := s.lookupUserByDid(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	q := s.db.Model(&Blob{}).Where("usr = ?", u.ID)
+	if cursor != "" {
+		q = q.Where("cid > ?", cursor)
+	}
+	var blobs []Blob
+	if err := q.Order("cid ASC").Limit(limit).Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+
+	out := &comatprototypes.SyncListBlobs_Output{Cids: []string{}}
+	for _, b := range blobs {
+		out.Cids = append(out.Cids, b.Cid)
+	}
+	if len(blobs) == limit && limit > 0 {
+		last := blobs[len(blobs)-1].Cid
+		out.Cursor = &last
+	}
+	return out, nil
 }
 
 func (s *Server) handleComAtprotoIdentityUpdateHandle(ctx context.Context, body *comatprototypes.IdentityUpdateHandle_Input) error {
@@ -424,15 +609,62 @@ func (s *Server) handleComAtprotoAdminUpdateAccountHandle(ctx context.Context, b
 }
 
 func (s *Server) handleComAtprotoServerCreateAppPassword(ctx context.Context, body *comatprototypes.ServerCreateAppPassword_Input) (*comatprototypes.ServerCreateAppPassword_AppPassword, error) {
-	panic("nyi")
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireFullAccess(ctx); err != nil {
+		return nil, err
+	}
+
+	privileged := body.Privileged != nil && *body.Privileged
+	ap, password, err := s.createAppPassword(ctx, u, body.Name, privileged)
+	if err != nil {
+		return nil, err
+	}
+
+	return &comatprototypes.ServerCreateAppPassword_AppPassword{
+		Name:       ap.Name,
+		Password:   password,
+		CreatedAt:  ap.CreatedAt.UTC().Format(util.ISO8601),
+		Privileged: &privileged,
+	}, nil
 }
 
 func (s *Server) handleComAtprotoServerListAppPasswords(ctx context.Context) (*comatprototypes.ServerListAppPasswords_Output, error) {
-	panic("nyi")
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var aps []AppPassword
+	if err := s.db.Order("created_at ASC").Find(&aps, "usr = ?", u.ID).Error; err != nil {
+		return nil, err
+	}
+
+	out := &comatprototypes.ServerListAppPasswords_Output{Passwords: []*comatprototypes.ServerListAppPasswords_AppPassword{}}
+	for _, ap := range aps {
+		privileged := ap.Privileged
+		out.Passwords = append(out.Passwords, &comatprototypes.ServerListAppPasswords_AppPassword{
+			Name:       ap.Name,
+			CreatedAt:  ap.CreatedAt.UTC().Format(util.ISO8601),
+			Privileged: &privileged,
+		})
+	}
+	return out, nil
 }
 
 func (s *Server) handleComAtprotoServerRevokeAppPassword(ctx context.Context, body *comatprototypes.ServerRevokeAppPassword_Input) error {
-	panic("nyi")
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.requireFullAccess(ctx); err != nil {
+		return err
+	}
+
+	// sessions created with the app password are rejected once it is gone
+	return s.db.Where("usr = ? AND name = ?", u.ID, body.Name).Delete(&AppPassword{}).Error
 }
 
 func (s *Server) handleComAtprotoAdminDisableAccountInvites(ctx context.Context, body *comatprototypes.AdminDisableAccountInvites_Input) error {
@@ -462,20 +694,198 @@ func (s *Server) handleComAtprotoAdminUpdateSubjectStatus(ctx context.Context, b
 	panic("nyi")
 }
 func (s *Server) handleComAtprotoServerConfirmEmail(ctx context.Context, body *comatprototypes.ServerConfirmEmail_Input) error {
-	panic("nyi")
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(body.Email, u.Email) {
+		return fmt.Errorf("email does not match account email")
+	}
+
+	uid, err := s.consumeEmailToken(ctx, emailTokenConfirmEmail, body.Token)
+	if err != nil {
+		return err
+	}
+	if uid != u.ID {
+		return fmt.Errorf("invalid or expired token")
+	}
+	return s.db.Model(u).Update("email_confirmed_at", time.Now()).Error
 }
+
 func (s *Server) handleComAtprotoServerRequestEmailConfirmation(ctx context.Context) error {
-	panic("nyi")
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+	if u.EmailConfirmedAt != nil {
+		return fmt.Errorf("email already confirmed")
+	}
+
+	return s.sendEmailToken(ctx, u, u.Email, emailTokenConfirmEmail)
 }
+
 func (s *Server) handleComAtprotoServerRequestEmailUpdate(ctx context.Context) (*comatprototypes.ServerRequestEmailUpdate_Output, error) {
-	panic("nyi")
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireFullAccess(ctx); err != nil {
+		return nil, err
+	}
+
+	// a confirmed address can only be changed by whoever controls it
+	required := u.EmailConfirmedAt != nil
+	if required {
+		if err := s.sendEmailToken(ctx, u, u.Email, emailTokenUpdateEmail); err != nil {
+			return nil, err
+		}
+	}
+	return &comatprototypes.ServerRequestEmailUpdate_Output{TokenRequired: required}, nil
 }
 func (s *Server) handleComAtprotoServerReserveSigningKey(ctx context.Context, body *comatprototypes.ServerReserveSigningKey_Input) (*comatprototypes.ServerReserveSigningKey_Output, error) {
 	panic("nyi")
 }
 func (s *Server) handleComAtprotoServerUpdateEmail(ctx context.Context, body *comatprototypes.ServerUpdateEmail_Input) error {
-	panic("nyi")
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.requireFullAccess(ctx); err != nil {
+		return err
+	}
+	if err := validateEmail(body.Email); err != nil {
+		return err
+	}
+
+	if u.EmailConfirmedAt != nil {
+		if body.Token == nil {
+			return fmt.Errorf("token is required to change a confirmed email address")
+		}
+		uid, err := s.consumeEmailToken(ctx, emailTokenUpdateEmail, *body.Token)
+		if err != nil {
+			return err
+		}
+		if uid != u.ID {
+			return fmt.Errorf("invalid or expired token")
+		}
+	}
+
+	// the new address is unconfirmed
+	return s.db.Model(u).Updates(map[string]any{"email": body.Email, "email_confirmed_at": nil}).Error
 }
+
 func (s *Server) handleComAtprotoTempFetchLabels(ctx context.Context, limit int, since *int) (*comatprototypes.TempFetchLabels_Output, error) {
 	panic("nyi")
 }
+
+func (s *Server) handleComAtprotoRepoImportRepo(ctx context.Context, r io.Reader) error {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.requireFullAccess(ctx); err != nil {
+		return err
+	}
+	// an import replaces the repo wholesale, which is only safe before the account is live
+	if !u.Deactivated {
+		return fmt.Errorf("repos can only be imported into deactivated accounts")
+	}
+
+	if err := s.repoman.ImportNewRepo(ctx, u.ID, u.Did, io.LimitReader(r, maxImportRepoSize), nil); err != nil {
+		return fmt.Errorf("importing repo: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) handleComAtprotoServerDeactivateAccount(ctx context.Context, body *comatprototypes.ServerDeactivateAccount_Input) error {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.requireFullAccess(ctx); err != nil {
+		return err
+	}
+
+	var deleteAfter *time.Time
+	if body.DeleteAfter != nil {
+		t, err := time.Parse(time.RFC3339, *body.DeleteAfter)
+		if err != nil {
+			return fmt.Errorf("invalid deleteAfter: %w", err)
+		}
+		deleteAfter = &t
+	}
+
+	if err := s.db.Model(u).Updates(map[string]any{"deactivated": true, "delete_after": deleteAfter}).Error; err != nil {
+		return err
+	}
+	return s.DeactivateRepo(ctx, u.Did)
+}
+
+func (s *Server) handleComAtprotoServerActivateAccount(ctx context.Context) error {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.requireFullAccess(ctx); err != nil {
+		return err
+	}
+	if !u.Deactivated {
+		return nil
+	}
+
+	if err := s.checkDidDoc(ctx, u.Did); err != nil {
+		return fmt.Errorf("cannot activate account: %w", err)
+	}
+	root, err := s.repoman.GetRepoRoot(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	if !root.Defined() {
+		return fmt.Errorf("cannot activate account without a repo (import it first)")
+	}
+
+	if err := s.db.Model(u).Updates(map[string]any{"deactivated": false, "delete_after": nil}).Error; err != nil {
+		return err
+	}
+	return s.ReactivateRepo(ctx, u.Did)
+}
+
+func (s *Server) handleComAtprotoServerCheckAccountStatus(ctx context.Context) (*comatprototypes.ServerCheckAccountStatus_Output, error) {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &comatprototypes.ServerCheckAccountStatus_Output{
+		Activated: !u.Deactivated,
+		ValidDid:  s.checkDidDoc(ctx, u.Did) == nil,
+	}
+
+	root, err := s.repoman.GetRepoRoot(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !root.Defined() {
+		return out, nil
+	}
+	rev, err := s.repoman.GetRepoRev(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	out.RepoCommit = root.String()
+	out.RepoRev = rev
+
+	stats, err := s.computeRepoStats(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("reading repo: %w", err)
+	}
+	out.RepoBlocks = stats.Blocks
+	out.IndexedRecords = stats.Records
+	out.ExpectedBlobs = int64(len(stats.Blobs))
+	if len(stats.Blobs) > 0 {
+		if err := s.db.Model(&Blob{}).Where("usr = ? AND cid IN ?", u.ID, stats.Blobs).Count(&out.ImportedBlobs).Error; err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
@@ -5,14 +5,27 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/carstore"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/plc"
+	"github.com/bluesky-social/indigo/util"
 	"github.com/bluesky-social/indigo/util/cliutil"
+	gojwt "github.com/golang-jwt/jwt"
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
 	"github.com/whyrusleeping/go-did"
 	"gorm.io/gorm"
 )
@@ -128,3 +141,512 @@ func TestHandleComAtprotoSessionCreate(t *testing.T) {
 		t.Fatalf("expected error %s, got %s\n", ErrInvalidUsernameOrPassword, err)
 	}
 }
+
+func TestHandleComAtprotoRepoUploadBlob(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(context.Background(), &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(context.Background(), o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "user", u)
+
+	if _, err := s.handleComAtprotoRepoUploadBlob(ctx, strings.NewReader("hello"), "text/plain"); err == nil {
+		t.Fatal("expected upload to fail without a blob store")
+	}
+
+	s.SetBlobStore(&DiskBlobStore{Dir: t.TempDir()})
+	s.SetBlobQuota(8)
+	out, err := s.handleComAtprotoRepoUploadBlob(ctx, strings.NewReader("hello"), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CID of the raw bytes
+	if c := cid.Cid(out.Blob.Ref).String(); c != "bafkreibm6jg3ux5qumhcn2b3flc3tyu6dmlb4xa7u5bf44yegnrjhc4yeq" {
+		t.Fatalf("unexpected blob CID: %s", c)
+	}
+	if out.Blob.Size != 5 || out.Blob.MimeType != "text/plain" {
+		t.Fatalf("unexpected blob metadata: %+v", out.Blob)
+	}
+
+	// re-uploads don't count against the quota
+	if _, err := s.handleComAtprotoRepoUploadBlob(ctx, strings.NewReader("hello"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.handleComAtprotoRepoUploadBlob(ctx, strings.NewReader("world"), "text/plain"); err == nil {
+		t.Fatal("expected upload over quota to fail")
+	}
+
+	r, err := s.handleComAtprotoSyncGetBlob(context.Background(), cid.Cid(out.Blob.Ref).String(), o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(r)
+	r.(io.Closer).Close()
+	if string(body) != "hello" {
+		t.Fatalf("unexpected blob contents: %q", body)
+	}
+
+	list, err := s.handleComAtprotoSyncListBlobs(context.Background(), "", o.Did, 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Cids) != 1 || list.Cursor != nil {
+		t.Fatalf("unexpected blob list: %+v", list)
+	}
+}
+
+func TestS3BlobStore(t *testing.T) {
+	ctx := context.Background()
+
+	objects := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		path := r.URL.EscapedPath()
+		switch r.Method {
+		case "PUT":
+			b, _ := io.ReadAll(r.Body)
+			objects[path] = string(b)
+		case "GET":
+			b, ok := objects[path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			io.WriteString(w, b)
+		case "DELETE":
+			delete(objects, path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	bs := &S3BlobStore{S3Client: util.S3Client{
+		Endpoint:    srv.URL,
+		Region:      "us-east-1",
+		Bucket:      "blobs",
+		Prefix:      "pds/",
+		Credentials: util.AWSCredentials{AccessKey: "AKIDEXAMPLE", SecretKey: "secret"},
+	}}
+	c, err := cid.Decode("bafkreibm6jg3ux5qumhcn2b3flc3tyu6dmlb4xa7u5bf44yegnrjhc4yeq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bs.PutBlob(ctx, "did:plc:aaa", c, 5, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := objects["/blobs/pds/did%3Aplc%3Aaaa/"+c.String()]; !ok {
+		t.Fatalf("blob not stored under expected key: %v", objects)
+	}
+	rc, err := bs.GetBlob(ctx, "did:plc:aaa", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(body) != "hello" {
+		t.Fatalf("unexpected blob contents: %q", body)
+	}
+
+	if err := bs.DeleteBlob(ctx, "did:plc:aaa", c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bs.GetBlob(ctx, "did:plc:aaa", c); err != ErrBlobNotFound {
+		t.Fatalf("expected ErrBlobNotFound, got %v", err)
+	}
+	if err := bs.PutBlob(ctx, "../did:plc:aaa", c, 5, strings.NewReader("hello")); err == nil {
+		t.Fatal("expected invalid DID to be rejected")
+	}
+}
+
+// runs a request authenticated with the given access token through the auth middleware, returning its context
+func testAuthedContext(t *testing.T, s *Server, accessJwt string) (context.Context, error) {
+	t.Helper()
+	tok, err := gojwt.Parse(accessJwt, func(*gojwt.Token) (interface{}, error) { return s.jwtSigningKey, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := echo.New().NewContext(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder())
+	c.Set("user", tok)
+	var ctx context.Context
+	err = s.userCheckMiddleware(func(c echo.Context) error {
+		ctx = c.Request().Context()
+		return nil
+	})(c)
+	return ctx, err
+}
+
+func TestAppPasswords(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	bg := context.Background()
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(bg, &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authed := func(accessJwt string) (context.Context, error) {
+		return testAuthedContext(t, s, accessJwt)
+	}
+
+	full, err := s.handleComAtprotoServerCreateSession(bg, &atproto.ServerCreateSession_Input{Identifier: o.Handle, Password: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fullCtx, err := authed(full.AccessJwt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ap, err := s.handleComAtprotoServerCreateAppPassword(fullCtx, &atproto.ServerCreateAppPassword_Input{Name: "client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ap.Password) != 19 {
+		t.Fatalf("unexpected app password format: %q", ap.Password)
+	}
+	if _, err := s.handleComAtprotoServerCreateAppPassword(fullCtx, &atproto.ServerCreateAppPassword_Input{Name: "client"}); err == nil {
+		t.Fatal("expected duplicate app password name to fail")
+	}
+
+	sess, err := s.handleComAtprotoServerCreateSession(bg, &atproto.ServerCreateSession_Input{Identifier: o.Handle, Password: ap.Password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appCtx, err := authed(sess.AccessJwt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scope := appCtx.Value("authScope"); scope != scopeAppPass {
+		t.Fatalf("unexpected app password session scope: %v", scope)
+	}
+
+	// app password sessions can list, but not manage, app passwords
+	list, err := s.handleComAtprotoServerListAppPasswords(appCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Passwords) != 1 || list.Passwords[0].Name != "client" {
+		t.Fatalf("unexpected app passwords: %+v", list.Passwords)
+	}
+	if _, err := s.handleComAtprotoServerCreateAppPassword(appCtx, &atproto.ServerCreateAppPassword_Input{Name: "other"}); err == nil {
+		t.Fatal("expected app password session to be unable to create app passwords")
+	}
+	if err := s.handleComAtprotoServerRevokeAppPassword(appCtx, &atproto.ServerRevokeAppPassword_Input{Name: "client"}); err == nil {
+		t.Fatal("expected app password session to be unable to revoke app passwords")
+	}
+
+	if err := s.handleComAtprotoServerRevokeAppPassword(fullCtx, &atproto.ServerRevokeAppPassword_Input{Name: "client"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authed(sess.AccessJwt); err == nil {
+		t.Fatal("expected session of revoked app password to be rejected")
+	}
+	if _, err := s.handleComAtprotoServerCreateSession(bg, &atproto.ServerCreateSession_Input{Identifier: o.Handle, Password: ap.Password}); err != ErrInvalidUsernameOrPassword {
+		t.Fatalf("expected error %s, got %s\n", ErrInvalidUsernameOrPassword, err)
+	}
+}
+
+// serves DID documents pointing at the test PDS, for the given DIDs
+type testDidDocs struct {
+	plc.PLCClient
+	docs map[string]*did.Document
+}
+
+func (tr *testDidDocs) GetDocument(ctx context.Context, d string) (*did.Document, error) {
+	if doc, ok := tr.docs[d]; ok {
+		return doc, nil
+	}
+	return tr.PLCClient.GetDocument(ctx, d)
+}
+
+func (tr *testDidDocs) add(t *testing.T, s *Server, d string) {
+	t.Helper()
+	mb := s.signingKey.Public().MultibaseString()
+	tr.docs[d] = &did.Document{
+		VerificationMethod: []did.VerificationMethod{{ID: "#atproto", Type: did.KeyTypeMultikey, PublicKeyMultibase: &mb}},
+		Service:            []did.Service{{Type: "AtprotoPersonalDataServer", ServiceEndpoint: "https://" + s.serviceUrl}},
+	}
+}
+
+func TestAccountDeactivation(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	bg := context.Background()
+	s.serviceUrl = "pds.test"
+	docs := &testDidDocs{PLCClient: s.plc, docs: make(map[string]*did.Document)}
+	s.plc = docs
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(bg, &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: "hello", CreatedAt: time.Now().Format(util.ISO8601)}}
+	authed := func() context.Context {
+		ctx, err := testAuthedContext(t, s, o.AccessJwt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ctx
+	}
+
+	if err := s.handleComAtprotoServerDeactivateAccount(authed(), &atproto.ServerDeactivateAccount_Input{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := authed()
+	if _, err := s.handleComAtprotoRepoCreateRecord(ctx, &atproto.RepoCreateRecord_Input{Repo: o.Did, Collection: "app.bsky.feed.post", Record: post}); err == nil {
+		t.Fatal("expected deactivated account to be unable to write")
+	}
+	if _, err := s.handleComAtprotoSyncGetRepo(bg, o.Did, ""); err == nil {
+		t.Fatal("expected repo of deactivated account to be unavailable")
+	}
+	sess, err := s.handleComAtprotoServerGetSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *sess.Active || sess.Status == nil || *sess.Status != "deactivated" {
+		t.Fatalf("unexpected session status: %v %v", *sess.Active, sess.Status)
+	}
+
+	// the account's DID document must point at this PDS for it to be activated
+	if err := s.handleComAtprotoServerActivateAccount(ctx); err == nil {
+		t.Fatal("expected activation with an invalid DID document to fail")
+	}
+	docs.add(t, s, o.Did)
+	if err := s.handleComAtprotoServerActivateAccount(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ctx = authed()
+	if _, err := s.handleComAtprotoRepoCreateRecord(ctx, &atproto.RepoCreateRecord_Input{Repo: o.Did, Collection: "app.bsky.feed.post", Record: post}); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := s.handleComAtprotoServerCheckAccountStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the new post, and the profile record created with the account
+	if !status.Activated || !status.ValidDid || status.RepoCommit == "" || status.RepoRev == "" || status.IndexedRecords != 2 || status.RepoBlocks == 0 {
+		t.Fatalf("unexpected account status: %+v", status)
+	}
+}
+
+func TestMigratedAccount(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	bg := context.Background()
+	docs := &testDidDocs{PLCClient: s.plc, docs: make(map[string]*did.Document)}
+	s.plc = docs
+
+	d := "did:plc:migrating"
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(bg, &atproto.ServerCreateAccount_Input{
+		Did:      &d,
+		Email:    &e,
+		Password: &p,
+		Handle:   "migrant.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Did != d {
+		t.Fatalf("expected account to keep its DID, got %s", o.Did)
+	}
+	if _, err := s.handleComAtprotoServerCreateAccount(bg, &atproto.ServerCreateAccount_Input{
+		Did:      &d,
+		Email:    &e,
+		Password: &p,
+		Handle:   "other.test",
+	}); err == nil {
+		t.Fatal("expected a second account for the same DID to fail")
+	}
+
+	ctx, err := testAuthedContext(t, s, o.AccessJwt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := s.handleComAtprotoServerCheckAccountStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Activated || status.ValidDid || status.RepoCommit != "" {
+		t.Fatalf("unexpected status of migrating account: %+v", status)
+	}
+
+	// migrated accounts can't be activated until their repo has been imported
+	docs.add(t, s, d)
+	if err := s.handleComAtprotoServerActivateAccount(ctx); err == nil {
+		t.Fatal("expected activation without a repo to fail")
+	}
+}
+
+// captures sent emails
+type testMailer struct {
+	sent []string
+}
+
+func (m *testMailer) SendMail(ctx context.Context, to, subject, body string) error {
+	m.sent = append(m.sent, to+"\n"+body)
+	return nil
+}
+
+var emailTokenRegex = regexp.MustCompile(`[a-z2-7]{5}-[a-z2-7]{5}`)
+
+// the token in the last email sent to an address
+func (m *testMailer) lastToken(t *testing.T, to string) string {
+	t.Helper()
+	if len(m.sent) == 0 || !strings.HasPrefix(m.sent[len(m.sent)-1], to+"\n") {
+		t.Fatalf("expected an email to %s", to)
+	}
+	tok := emailTokenRegex.FindString(m.sent[len(m.sent)-1])
+	if tok == "" {
+		t.Fatal("expected a token in the email")
+	}
+	return tok
+}
+
+func TestEmailFlows(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	bg := context.Background()
+	mailer := &testMailer{}
+	s.SetMailer(mailer)
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(bg, &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authed := func() context.Context {
+		ctx, err := testAuthedContext(t, s, o.AccessJwt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ctx
+	}
+
+	// confirmation
+	if err := s.handleComAtprotoServerRequestEmailConfirmation(authed()); err != nil {
+		t.Fatal(err)
+	}
+	tok := mailer.lastToken(t, e)
+	if err := s.handleComAtprotoServerConfirmEmail(authed(), &atproto.ServerConfirmEmail_Input{Email: e, Token: "aaaaa-aaaaa"}); err == nil {
+		t.Fatal("expected wrong token to fail")
+	}
+	if err := s.handleComAtprotoServerConfirmEmail(authed(), &atproto.ServerConfirmEmail_Input{Email: e, Token: tok}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.handleComAtprotoServerConfirmEmail(authed(), &atproto.ServerConfirmEmail_Input{Email: e, Token: tok}); err == nil {
+		t.Fatal("expected token reuse to fail")
+	}
+	sess, err := s.handleComAtprotoServerGetSession(authed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !*sess.EmailConfirmed {
+		t.Fatal("expected email to be confirmed")
+	}
+
+	// changing a confirmed address needs a token sent to it
+	upd, err := s.handleComAtprotoServerRequestEmailUpdate(authed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !upd.TokenRequired {
+		t.Fatal("expected token to be required to change a confirmed email")
+	}
+	tok = mailer.lastToken(t, e)
+	if err := s.handleComAtprotoServerUpdateEmail(authed(), &atproto.ServerUpdateEmail_Input{Email: "new@foo.com"}); err == nil {
+		t.Fatal("expected email update without token to fail")
+	}
+	if err := s.handleComAtprotoServerUpdateEmail(authed(), &atproto.ServerUpdateEmail_Input{Email: "new@foo.com", Token: &tok}); err != nil {
+		t.Fatal(err)
+	}
+	sess, err = s.handleComAtprotoServerGetSession(authed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *sess.Email != "new@foo.com" || *sess.EmailConfirmed {
+		t.Fatalf("unexpected email state: %s %v", *sess.Email, *sess.EmailConfirmed)
+	}
+
+	// password reset
+	if err := s.handleComAtprotoServerRequestPasswordReset(bg, &atproto.ServerRequestPasswordReset_Input{Email: "nobody@foo.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.handleComAtprotoServerRequestPasswordReset(bg, &atproto.ServerRequestPasswordReset_Input{Email: "NEW@foo.com"}); err != nil {
+		t.Fatal(err)
+	}
+	tok = mailer.lastToken(t, "new@foo.com")
+	if err := s.handleComAtprotoServerResetPassword(bg, &atproto.ServerResetPassword_Input{Password: "newpassword", Token: strings.ToUpper(tok)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.handleComAtprotoServerCreateSession(bg, &atproto.ServerCreateSession_Input{Identifier: o.Handle, Password: p}); err != ErrInvalidUsernameOrPassword {
+		t.Fatalf("expected old password to fail, got %v", err)
+	}
+	if _, err := s.handleComAtprotoServerCreateSession(bg, &atproto.ServerCreateSession_Input{Identifier: o.Handle, Password: "newpassword"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSESMailer(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/email/outbound-emails" || !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/ses/aws4_request") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		io.WriteString(w, `{"MessageId":"1"}`)
+	}))
+	defer srv.Close()
+
+	m, err := ParseMailer("ses://us-east-1", "PDS <noreply@pds.test>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ses := m.(*SESMailer)
+	ses.Endpoint = srv.URL
+	ses.Credentials = util.AWSCredentials{AccessKey: "AKIDEXAMPLE", SecretKey: "secret"}
+
+	if err := ses.SendMail(context.Background(), "test@foo.com", "hello", "body"); err != nil {
+		t.Fatal(err)
+	}
+	if got["FromEmailAddress"] != `"PDS" <noreply@pds.test>` {
+		t.Fatalf("unexpected SES request: %v", got)
+	}
+}
@@ -0,0 +1,215 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/util"
+)
+
+// Mailer sends email to accounts on the PDS, for email confirmation and password resets. Emails are plain text
+type Mailer interface {
+	SendMail(ctx context.Context, to, subject, body string) error
+}
+
+// formats a plain text email message
+func formatMail(from *mail.Address, to *mail.Address, subject, body string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from.String())
+	fmt.Fprintf(&b, "To: %s\r\n", to.String())
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// SMTPMailer is a Mailer sending through an SMTP server. Connections use STARTTLS if the server supports it, or TLS from the start if ImplicitTLS is set (usually on port 465)
+type SMTPMailer struct {
+	// host:port of the SMTP server
+	Addr        string
+	Username    string
+	Password    string
+	From        *mail.Address
+	ImplicitTLS bool
+}
+
+func (m *SMTPMailer) SendMail(ctx context.Context, to, subject, body string) error {
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+	msg := formatMail(m.From, rcpt, subject, body)
+
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	if !m.ImplicitTLS {
+		return smtp.SendMail(m.Addr, auth, m.From.Address, []string{rcpt.Address}, msg)
+	}
+
+	d := tls.Dialer{Config: &tls.Config{ServerName: host}}
+	conn, err := d.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.From.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(rcpt.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// SESMailer is a Mailer sending through the Amazon SES (v2) API
+type SESMailer struct {
+	// Base URL of the SES API, eg "https://email.us-east-1.amazonaws.com"
+	Endpoint    string
+	Region      string
+	From        *mail.Address
+	Credentials util.AWSCredentials
+	HTTPClient  *http.Client
+}
+
+func (m *SESMailer) SendMail(ctx context.Context, to, subject, body string) error {
+	payload, err := json.Marshal(map[string]any{
+		"FromEmailAddress": m.From.String(),
+		"Destination":      map[string]any{"ToAddresses": []string{to}},
+		"Content": map[string]any{
+			"Simple": map[string]any{
+				"Subject": map[string]string{"Data": subject, "Charset": "UTF-8"},
+				"Body":    map[string]any{"Text": map[string]string{"Data": body, "Charset": "UTF-8"}},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	path := "/v2/email/outbound-emails"
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(m.Endpoint, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	hash := sha256.Sum256(payload)
+	util.SignAWSRequest(req, path, "ses", m.Region, m.Credentials, hex.EncodeToString(hash[:]), time.Now().UTC())
+
+	client := m.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending email with SES: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("sending email with SES: %s: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+// ParseMailer configures a Mailer from a URI: "smtp://<user>:<password>@<host>:<port>" (STARTTLS, port 587 by default), "smtps://..." (implicit TLS, port 465 by default), or "ses://<region>". SES credentials are read from the standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment variables. Emails are sent from the from address, eg "My PDS <noreply@pds.example.com>"
+func ParseMailer(uri, from string) (Mailer, error) {
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email from address: %w", err)
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mailer URI: %w", err)
+	}
+
+	switch u.Scheme {
+	case "smtp", "smtps":
+		if u.Hostname() == "" {
+			return nil, fmt.Errorf("mailer URI must include SMTP server host: %s", uri)
+		}
+		port := u.Port()
+		if port == "" {
+			port = "587"
+			if u.Scheme == "smtps" {
+				port = "465"
+			}
+		}
+		pass, _ := u.User.Password()
+		return &SMTPMailer{
+			Addr:        net.JoinHostPort(u.Hostname(), port),
+			Username:    u.User.Username(),
+			Password:    pass,
+			From:        fromAddr,
+			ImplicitTLS: u.Scheme == "smtps",
+		}, nil
+	case "ses":
+		region := u.Host
+		if region == "" {
+			region = os.Getenv("AWS_REGION")
+		}
+		if region == "" {
+			region = "us-east-1"
+		}
+		return &SESMailer{
+			Endpoint: fmt.Sprintf("https://email.%s.amazonaws.com", region),
+			Region:   region,
+			From:     fromAddr,
+			Credentials: util.AWSCredentials{
+				AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+				SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+				SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			},
+			HTTPClient: &http.Client{
+				Timeout: 30 * time.Second,
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported mailer URI scheme: %q", u.Scheme)
+	}
+}
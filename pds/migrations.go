@@ -0,0 +1,30 @@
+package pds
+
+import (
+	"embed"
+
+	"github.com/bluesky-social/indigo/util/migrate"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// NewMigrator returns the schema migrations for the PDS's database. The first is the schema from before migrations were versioned, and later ones are the SQL files in migrations/
+func NewMigrator(db *gorm.DB) (*migrate.Migrator, error) {
+	sqlMigrations, err := migrate.LoadSQL(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	baseline := migrate.Models(1, "baseline",
+		&User{},
+		&Peering{},
+		&Blob{},
+		&AppPassword{},
+		&OAuthRequest{},
+		&OAuthSession{},
+		&EmailToken{},
+	)
+	return migrate.New(db, "pds", append(sqlMigrations, baseline)...)
+}
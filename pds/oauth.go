@@ -0,0 +1,626 @@
+package pds
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+	gojwt "github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"gorm.io/gorm"
+)
+
+// OAuth scopes clients may request. "atproto" is required; "transition:generic" grants the same access as an app password session
+var oauthSupportedScopes = []string{"atproto", "transition:generic"}
+
+const (
+	oauthRequestURIPrefix     = "urn:ietf:params:oauth:request_uri:"
+	oauthRequestLifetime      = 5 * time.Minute
+	oauthCodeLifetime         = time.Minute
+	oauthAccessTokenLifetime  = time.Hour
+	oauthRefreshTokenLifetime = 14 * 24 * time.Hour
+	maxClientMetadataSize     = 64 * 1024
+)
+
+// OAuthRequest is a pending authorization request, from a pushed authorization request (PAR) until its code is exchanged for tokens
+type OAuthRequest struct {
+	ID            uint `gorm:"primarykey"`
+	CreatedAt     time.Time
+	RequestID     string `gorm:"uniqueIndex"`
+	ClientID      string
+	RedirectURI   string
+	Scope         string
+	State         string
+	CodeChallenge string
+	LoginHint     string
+	// Thumbprint of the client's DPoP key, which tokens will be bound to
+	DpopJkt   string
+	ExpiresAt time.Time
+	// Set once the account has approved the request
+	Usr  models.Uid
+	Code *string `gorm:"uniqueIndex"`
+}
+
+// OAuthSession is an OAuth grant to a client, identified by its current refresh token (only a hash of which is stored), and bound to the client's DPoP key
+type OAuthSession struct {
+	ID               uint `gorm:"primarykey"`
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Usr              models.Uid `gorm:"index"`
+	ClientID         string
+	Scope            string
+	DpopJkt          string
+	RefreshTokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt        time.Time
+}
+
+// OAuthClientMetadata is the subset of an OAuth client metadata document (served at the client_id URL) used by the PDS
+type OAuthClientMetadata struct {
+	ClientID                string   `json:"client_id"`
+	ClientName              string   `json:"client_name,omitempty"`
+	ClientURI               string   `json:"client_uri,omitempty"`
+	RedirectURIs            []string `json:"redirect_uris"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	Scope                   string   `json:"scope"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	DPoPBoundAccessTokens   bool     `json:"dpop_bound_access_tokens"`
+}
+
+type oauthServerMetadata struct {
+	Issuer                                     string   `json:"issuer"`
+	AuthorizationEndpoint                      string   `json:"authorization_endpoint"`
+	TokenEndpoint                              string   `json:"token_endpoint"`
+	PushedAuthorizationRequestEndpoint         string   `json:"pushed_authorization_request_endpoint"`
+	RequirePushedAuthorizationRequests         bool     `json:"require_pushed_authorization_requests"`
+	ResponseTypesSupported                     []string `json:"response_types_supported"`
+	ResponseModesSupported                     []string `json:"response_modes_supported"`
+	GrantTypesSupported                        []string `json:"grant_types_supported"`
+	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported"`
+	ScopesSupported                            []string `json:"scopes_supported"`
+	SubjectTypesSupported                      []string `json:"subject_types_supported"`
+	DPoPSigningAlgValuesSupported              []string `json:"dpop_signing_alg_values_supported"`
+	AuthorizationResponseIssParameterSupported bool     `json:"authorization_response_iss_parameter_supported"`
+	ClientIDMetadataDocumentSupported          bool     `json:"client_id_metadata_document_supported"`
+	ProtectedResources                         []string `json:"protected_resources"`
+}
+
+// EnableOAuth turns on the OAuth authorization server, for clients which don't use createSession. The issuer is the public origin of the PDS (eg, "https://pds.example.com"), which DPoP proofs are checked against
+func (s *Server) EnableOAuth(issuer string) error {
+	v, err := newDPoPVerifier()
+	if err != nil {
+		return err
+	}
+	s.oauthIssuer = strings.TrimSuffix(issuer, "/")
+	s.dpop = v
+	s.oauthClient = &http.Client{Timeout: 10 * time.Second}
+	return nil
+}
+
+func isOAuthPath(path string) bool {
+	switch path {
+	case "/.well-known/oauth-protected-resource", "/.well-known/oauth-authorization-server", "/oauth/par", "/oauth/authorize", "/oauth/token":
+		return true
+	}
+	return false
+}
+
+func (s *Server) registerOAuthHandlers(e *echo.Echo) {
+	e.GET("/.well-known/oauth-protected-resource", s.handleOAuthProtectedResource)
+	e.GET("/.well-known/oauth-authorization-server", s.handleOAuthServerMetadata)
+	e.POST("/oauth/par", s.handleOAuthPAR)
+	e.GET("/oauth/authorize", s.handleOAuthAuthorize)
+	e.POST("/oauth/authorize", s.handleOAuthAuthorizeSubmit)
+	e.POST("/oauth/token", s.handleOAuthToken)
+}
+
+func oauthError(c echo.Context, status int, code, desc string) error {
+	return c.JSON(status, map[string]string{"error": code, "error_description": desc})
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(tok string) string {
+	h := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(h[:])
+}
+
+func (s *Server) handleOAuthProtectedResource(c echo.Context) error {
+	return c.JSON(200, map[string]any{
+		"resource":                 s.oauthIssuer,
+		"authorization_servers":    []string{s.oauthIssuer},
+		"scopes_supported":         []string{},
+		"bearer_methods_supported": []string{"header"},
+	})
+}
+
+func (s *Server) handleOAuthServerMetadata(c echo.Context) error {
+	return c.JSON(200, oauthServerMetadata{
+		Issuer:                                     s.oauthIssuer,
+		AuthorizationEndpoint:                      s.oauthIssuer + "/oauth/authorize",
+		TokenEndpoint:                              s.oauthIssuer + "/oauth/token",
+		PushedAuthorizationRequestEndpoint:         s.oauthIssuer + "/oauth/par",
+		RequirePushedAuthorizationRequests:         true,
+		ResponseTypesSupported:                     []string{"code"},
+		ResponseModesSupported:                     []string{"query"},
+		GrantTypesSupported:                        []string{"authorization_code", "refresh_token"},
+		CodeChallengeMethodsSupported:              []string{"S256"},
+		TokenEndpointAuthMethodsSupported:          []string{"none"},
+		ScopesSupported:                            oauthSupportedScopes,
+		SubjectTypesSupported:                      []string{"public"},
+		DPoPSigningAlgValuesSupported:              []string{"ES256"},
+		AuthorizationResponseIssParameterSupported: true,
+		ClientIDMetadataDocumentSupported:          true,
+		ProtectedResources:                         []string{s.oauthIssuer},
+	})
+}
+
+// resolveOAuthClient fetches and validates the metadata of an OAuth client. Clients are identified by the https URL of their metadata document, except for development clients with a client_id of "http://localhost", which may declare redirect URIs and scope in query params
+func (s *Server) resolveOAuthClient(ctx context.Context, clientID string) (*OAuthClientMetadata, error) {
+	u, err := url.Parse(clientID)
+	if err != nil || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid client_id")
+	}
+
+	var md OAuthClientMetadata
+	switch {
+	case u.Scheme == "http" && u.Host == "localhost" && (u.Path == "" || u.Path == "/"):
+		md = OAuthClientMetadata{
+			ClientID:                clientID,
+			ClientName:              "localhost",
+			RedirectURIs:            u.Query()["redirect_uri"],
+			Scope:                   u.Query().Get("scope"),
+			TokenEndpointAuthMethod: "none",
+			DPoPBoundAccessTokens:   true,
+		}
+		if len(md.RedirectURIs) == 0 {
+			md.RedirectURIs = []string{"http://127.0.0.1/", "http://[::1]/"}
+		}
+		if md.Scope == "" {
+			md.Scope = "atproto"
+		}
+	case u.Scheme == "https" && u.Host != "":
+		req, err := http.NewRequestWithContext(ctx, "GET", clientID, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, err := s.oauthClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("fetching client metadata: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching client metadata: %s", resp.Status)
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxClientMetadataSize)).Decode(&md); err != nil {
+			return nil, fmt.Errorf("invalid client metadata: %w", err)
+		}
+		if md.ClientID != clientID {
+			return nil, fmt.Errorf("client metadata client_id does not match")
+		}
+	default:
+		return nil, fmt.Errorf("client_id must be an https URL")
+	}
+
+	if len(md.ResponseTypes) > 0 && !slices.Contains(md.ResponseTypes, "code") {
+		return nil, fmt.Errorf("client must support the code response type")
+	}
+	if len(md.GrantTypes) > 0 && !slices.Contains(md.GrantTypes, "authorization_code") {
+		return nil, fmt.Errorf("client must support the authorization_code grant type")
+	}
+	if !md.DPoPBoundAccessTokens {
+		return nil, fmt.Errorf("client must use DPoP-bound access tokens")
+	}
+	if md.TokenEndpointAuthMethod != "none" {
+		return nil, fmt.Errorf("unsupported token_endpoint_auth_method: %q", md.TokenEndpointAuthMethod)
+	}
+	if !slices.Contains(strings.Fields(md.Scope), "atproto") {
+		return nil, fmt.Errorf("client metadata scope must include atproto")
+	}
+	return &md, nil
+}
+
+// checkOAuthScope validates the scopes requested by a client: "atproto" is required, and all must be supported and declared in the client metadata
+func checkOAuthScope(requested, declared string) (string, error) {
+	if requested == "" {
+		requested = declared
+	}
+	scopes := strings.Fields(requested)
+	if !slices.Contains(scopes, "atproto") {
+		return "", fmt.Errorf("scope must include atproto")
+	}
+	for _, sc := range scopes {
+		if !slices.Contains(oauthSupportedScopes, sc) {
+			return "", fmt.Errorf("unsupported scope: %q", sc)
+		}
+		if !slices.Contains(strings.Fields(declared), sc) {
+			return "", fmt.Errorf("scope not declared in client metadata: %q", sc)
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+// checks the DPoP proof of a request to an authorization server endpoint, returning the client key thumbprint
+func (s *Server) checkOAuthDPoP(c echo.Context) (string, error) {
+	c.Response().Header().Set("DPoP-Nonce", s.dpop.Nonce())
+	return s.dpop.Verify(c.Request().Header.Get("DPoP"), c.Request().Method, s.oauthIssuer+c.Request().URL.Path, "")
+}
+
+func oauthDPoPError(c echo.Context, err error) error {
+	if errors.Is(err, errUseDPoPNonce) {
+		return oauthError(c, 400, "use_dpop_nonce", "authorization server requires a DPoP nonce")
+	}
+	return oauthError(c, 400, "invalid_dpop_proof", err.Error())
+}
+
+func (s *Server) handleOAuthPAR(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	jkt, err := s.checkOAuthDPoP(c)
+	if err != nil {
+		return oauthDPoPError(c, err)
+	}
+
+	client, err := s.resolveOAuthClient(ctx, c.FormValue("client_id"))
+	if err != nil {
+		return oauthError(c, 400, "invalid_client", err.Error())
+	}
+	if c.FormValue("response_type") != "code" {
+		return oauthError(c, 400, "unsupported_response_type", "response_type must be code")
+	}
+	redirectURI := c.FormValue("redirect_uri")
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return oauthError(c, 400, "invalid_request", "redirect_uri not declared in client metadata")
+	}
+	if c.FormValue("code_challenge_method") != "S256" || c.FormValue("code_challenge") == "" {
+		return oauthError(c, 400, "invalid_request", "PKCE with the S256 method is required")
+	}
+	scope, err := checkOAuthScope(c.FormValue("scope"), client.Scope)
+	if err != nil {
+		return oauthError(c, 400, "invalid_scope", err.Error())
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return err
+	}
+	req := OAuthRequest{
+		RequestID:     id,
+		ClientID:      client.ClientID,
+		RedirectURI:   redirectURI,
+		Scope:         scope,
+		State:         c.FormValue("state"),
+		CodeChallenge: c.FormValue("code_challenge"),
+		LoginHint:     c.FormValue("login_hint"),
+		DpopJkt:       jkt,
+		ExpiresAt:     time.Now().Add(oauthRequestLifetime),
+	}
+	if err := s.db.Create(&req).Error; err != nil {
+		return err
+	}
+
+	return c.JSON(201, map[string]any{
+		"request_uri": oauthRequestURIPrefix + id,
+		"expires_in":  int(oauthRequestLifetime.Seconds()),
+	})
+}
+
+// loads a pending authorization request which has not yet been approved
+func (s *Server) loadOAuthRequest(ctx context.Context, clientID, requestURI string) (*OAuthRequest, *OAuthClientMetadata, error) {
+	id, ok := strings.CutPrefix(requestURI, oauthRequestURIPrefix)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid request_uri")
+	}
+	var req OAuthRequest
+	if err := s.db.Find(&req, "request_id = ?", id).Error; err != nil {
+		return nil, nil, err
+	}
+	if req.ID == 0 || req.ClientID != clientID || req.Code != nil || time.Now().After(req.ExpiresAt) {
+		return nil, nil, fmt.Errorf("unknown or expired authorization request")
+	}
+	client, err := s.resolveOAuthClient(ctx, clientID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &req, client, nil
+}
+
+var oauthAuthorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Sign in</title></head>
+<body>
+<h1>Sign in to {{ .ClientName }}</h1>
+<p>{{ .ClientID }} is requesting access to your account ({{ .Scope }}).</p>
+{{ if .Error }}<p><strong>{{ .Error }}</strong></p>{{ end }}
+<form method="post" action="/oauth/authorize">
+<input type="hidden" name="client_id" value="{{ .ClientID }}">
+<input type="hidden" name="request_uri" value="{{ .RequestURI }}">
+<p><label>Handle <input name="identifier" value="{{ .LoginHint }}" autocomplete="username" required></label></p>
+<p><label>Password <input name="password" type="password" autocomplete="current-password"></label></p>
+<p><button type="submit" name="action" value="approve">Sign in and approve</button> <button type="submit" name="action" value="deny" formnovalidate>Deny</button></p>
+</form>
+</body>
+</html>
+`))
+
+func (s *Server) renderOAuthAuthorize(c echo.Context, status int, req *OAuthRequest, client *OAuthClientMetadata, errMsg string) error {
+	name := client.ClientName
+	if name == "" {
+		name = client.ClientID
+	}
+	// the form accepts account passwords, so must not be framed by other sites
+	c.Response().Header().Set("X-Frame-Options", "DENY")
+	c.Response().Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	c.Response().Header().Set("Content-Type", "text/html; charset=utf-8")
+	c.Response().WriteHeader(status)
+	return oauthAuthorizeTemplate.Execute(c.Response(), map[string]string{
+		"ClientName": name,
+		"ClientID":   client.ClientID,
+		"Scope":      req.Scope,
+		"RequestURI": oauthRequestURIPrefix + req.RequestID,
+		"LoginHint":  req.LoginHint,
+		"Error":      errMsg,
+	})
+}
+
+func (s *Server) handleOAuthAuthorize(c echo.Context) error {
+	req, client, err := s.loadOAuthRequest(c.Request().Context(), c.QueryParam("client_id"), c.QueryParam("request_uri"))
+	if err != nil {
+		return c.String(400, err.Error())
+	}
+	return s.renderOAuthAuthorize(c, 200, req, client, "")
+}
+
+// redirects the user agent back to the client with the result of an authorization request
+func (s *Server) oauthRedirect(c echo.Context, req *OAuthRequest, params url.Values) error {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	for k, vs := range params {
+		q[k] = vs
+	}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	q.Set("iss", s.oauthIssuer)
+	u.RawQuery = q.Encode()
+	return c.Redirect(http.StatusSeeOther, u.String())
+}
+
+func (s *Server) handleOAuthAuthorizeSubmit(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req, client, err := s.loadOAuthRequest(ctx, c.FormValue("client_id"), c.FormValue("request_uri"))
+	if err != nil {
+		return c.String(400, err.Error())
+	}
+
+	if c.FormValue("action") != "approve" {
+		if err := s.db.Delete(req).Error; err != nil {
+			return err
+		}
+		return s.oauthRedirect(c, req, url.Values{"error": {"access_denied"}})
+	}
+
+	u, err := s.lookupUser(ctx, c.FormValue("identifier"))
+	if err != nil && !errors.Is(err, ErrNoSuchUser) && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if u == nil || subtle.ConstantTimeCompare([]byte(u.Password), []byte(c.FormValue("password"))) != 1 {
+		return s.renderOAuthAuthorize(c, 401, req, client, "Invalid handle or password")
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		return err
+	}
+	if err := s.db.Model(req).Updates(map[string]any{
+		"usr":        u.ID,
+		"code":       code,
+		"expires_at": time.Now().Add(oauthCodeLifetime),
+	}).Error; err != nil {
+		return err
+	}
+
+	return s.oauthRedirect(c, req, url.Values{"code": {code}})
+}
+
+func (s *Server) handleOAuthToken(c echo.Context) error {
+	ctx := c.Request().Context()
+	c.Response().Header().Set("Cache-Control", "no-store")
+
+	jkt, err := s.checkOAuthDPoP(c)
+	if err != nil {
+		return oauthDPoPError(c, err)
+	}
+	clientID := c.FormValue("client_id")
+	if _, err := s.resolveOAuthClient(ctx, clientID); err != nil {
+		return oauthError(c, 401, "invalid_client", err.Error())
+	}
+
+	var sess *OAuthSession
+	var refreshToken string
+	switch c.FormValue("grant_type") {
+	case "authorization_code":
+		sess, refreshToken, err = s.exchangeOAuthCode(ctx, clientID, jkt, c.FormValue("code"), c.FormValue("redirect_uri"), c.FormValue("code_verifier"))
+	case "refresh_token":
+		sess, refreshToken, err = s.refreshOAuthSession(ctx, clientID, jkt, c.FormValue("refresh_token"))
+	default:
+		return oauthError(c, 400, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+	}
+	if err != nil {
+		return oauthError(c, 400, "invalid_grant", err.Error())
+	}
+
+	var u User
+	if err := s.db.First(&u, "id = ?", sess.Usr).Error; err != nil {
+		return err
+	}
+	accessToken, err := s.createOAuthAccessToken(&u, sess)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(200, map[string]any{
+		"access_token":  accessToken,
+		"token_type":    "DPoP",
+		"expires_in":    int(oauthAccessTokenLifetime.Seconds()),
+		"refresh_token": refreshToken,
+		"scope":         sess.Scope,
+		"sub":           u.Did,
+	})
+}
+
+// exchanges an authorization code for a new session, checking it against the original request
+func (s *Server) exchangeOAuthCode(ctx context.Context, clientID, jkt, code, redirectURI, verifier string) (*OAuthSession, string, error) {
+	if code == "" {
+		return nil, "", fmt.Errorf("missing code")
+	}
+	var req OAuthRequest
+	if err := s.db.Find(&req, "code = ?", code).Error; err != nil {
+		return nil, "", err
+	}
+	if req.ID == 0 {
+		return nil, "", fmt.Errorf("invalid code")
+	}
+	// codes are single use
+	if err := s.db.Delete(&req).Error; err != nil {
+		return nil, "", err
+	}
+	if time.Now().After(req.ExpiresAt) {
+		return nil, "", fmt.Errorf("code expired")
+	}
+	if req.ClientID != clientID || req.RedirectURI != redirectURI {
+		return nil, "", fmt.Errorf("code was issued to a different client or redirect_uri")
+	}
+	if req.DpopJkt != jkt {
+		return nil, "", fmt.Errorf("DPoP key does not match authorization request")
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	if verifier == "" || base64.RawURLEncoding.EncodeToString(challenge[:]) != req.CodeChallenge {
+		return nil, "", fmt.Errorf("invalid code_verifier")
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	sess := OAuthSession{
+		Usr:              req.Usr,
+		ClientID:         clientID,
+		Scope:            req.Scope,
+		DpopJkt:          jkt,
+		RefreshTokenHash: hashToken(refreshToken),
+		ExpiresAt:        time.Now().Add(oauthRefreshTokenLifetime),
+	}
+	if err := s.db.Create(&sess).Error; err != nil {
+		return nil, "", err
+	}
+	return &sess, refreshToken, nil
+}
+
+// rotates the refresh token of a session
+func (s *Server) refreshOAuthSession(ctx context.Context, clientID, jkt, refreshToken string) (*OAuthSession, string, error) {
+	if refreshToken == "" {
+		return nil, "", fmt.Errorf("missing refresh_token")
+	}
+	var sess OAuthSession
+	if err := s.db.Find(&sess, "refresh_token_hash = ?", hashToken(refreshToken)).Error; err != nil {
+		return nil, "", err
+	}
+	if sess.ID == 0 || time.Now().After(sess.ExpiresAt) {
+		return nil, "", fmt.Errorf("invalid refresh_token")
+	}
+	if sess.ClientID != clientID || sess.DpopJkt != jkt {
+		return nil, "", fmt.Errorf("refresh_token was issued to a different client or DPoP key")
+	}
+
+	next, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	sess.RefreshTokenHash = hashToken(next)
+	sess.ExpiresAt = time.Now().Add(oauthRefreshTokenLifetime)
+	if err := s.db.Save(&sess).Error; err != nil {
+		return nil, "", err
+	}
+	return &sess, next, nil
+}
+
+// creates an access token for an OAuth session. Its scope is the OAuth scope (not a session scope like com.atproto.access), and it is bound to the session's DPoP key
+func (s *Server) createOAuthAccessToken(u *User, sess *OAuthSession) (string, error) {
+	tok := makeToken(u.Did, sess.Scope, time.Now().Add(oauthAccessTokenLifetime))
+	tok.Set("client_id", sess.ClientID)
+	tok.Set("cnf", map[string]string{"jkt": sess.DpopJkt})
+
+	sig, err := jwt.Sign(tok, jwt.WithKey(jwa.HS256, s.jwtSigningKey))
+	if err != nil {
+		return "", fmt.Errorf("signing access token: %w", err)
+	}
+	return string(sig), nil
+}
+
+// dpopAuthMiddleware authenticates XRPC requests made with DPoP-bound OAuth access tokens ("Authorization: DPoP <token>"), for userCheckMiddleware. Requests with other credentials are passed through
+func (s *Server) dpopAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		accessToken, ok := strings.CutPrefix(c.Request().Header.Get("Authorization"), "DPoP ")
+		if !ok || s.dpop == nil {
+			return next(c)
+		}
+
+		c.Response().Header().Set("DPoP-Nonce", s.dpop.Nonce())
+		jkt, err := s.dpop.Verify(c.Request().Header.Get("DPoP"), c.Request().Method, s.oauthIssuer+c.Request().URL.Path, accessToken)
+		if errors.Is(err, errUseDPoPNonce) {
+			c.Response().Header().Set("WWW-Authenticate", `DPoP error="use_dpop_nonce"`)
+			return c.JSON(401, map[string]string{"error": "use_dpop_nonce", "message": "resource server requires a DPoP nonce"})
+		}
+		if err != nil {
+			return c.JSON(401, map[string]string{"error": "InvalidToken", "message": err.Error()})
+		}
+
+		tok, err := gojwt.Parse(accessToken, func(t *gojwt.Token) (interface{}, error) {
+			if t.Method != gojwt.SigningMethodHS256 {
+				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+			}
+			return s.jwtSigningKey, nil
+		})
+		if err != nil {
+			return c.JSON(401, map[string]string{"error": "InvalidToken", "message": err.Error()})
+		}
+		claims, _ := tok.Claims.(gojwt.MapClaims)
+		cnf, _ := claims["cnf"].(map[string]interface{})
+		if cnf == nil || cnf["jkt"] != jkt {
+			return c.JSON(401, map[string]string{"error": "InvalidToken", "message": "access token is bound to a different DPoP key"})
+		}
+
+		c.Set("user", tok)
+		c.Set("dpopBound", true)
+		return next(c)
+	}
+}
@@ -0,0 +1,255 @@
+package pds
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	gojwt "github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+func testDPoPKey(t *testing.T) jwk.Key {
+	t.Helper()
+	raw, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testDPoPProof(t *testing.T, key jwk.Key, method, htu, nonce, accessToken string) string {
+	t.Helper()
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdrs := jws.NewHeaders()
+	hdrs.Set(jws.TypeKey, "dpop+jwt")
+	hdrs.Set(jws.JWKKey, pub)
+
+	jti, err := randomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]any{"jti": jti, "htm": method, "htu": htu, "iat": time.Now().Unix(), "nonce": nonce}
+	if accessToken != "" {
+		ath := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(ath[:])
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := jws.Sign(payload, jws.WithKey(jwa.ES256, key, jws.WithProtectedHeaders(hdrs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(sig)
+}
+
+func TestOAuthFlow(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	bg := context.Background()
+
+	e := "test@foo.com"
+	p := "password"
+	if _, err := s.handleComAtprotoServerCreateAccount(bg, &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := httptest.NewTLSServer(nil)
+	defer client.Close()
+	clientID := client.URL + "/client-metadata.json"
+	redirectURI := client.URL + "/callback"
+	client.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OAuthClientMetadata{
+			ClientID:                clientID,
+			ClientName:              "Test Client",
+			RedirectURIs:            []string{redirectURI},
+			GrantTypes:              []string{"authorization_code", "refresh_token"},
+			ResponseTypes:           []string{"code"},
+			Scope:                   "atproto transition:generic",
+			TokenEndpointAuthMethod: "none",
+			DPoPBoundAccessTokens:   true,
+		})
+	})
+
+	issuer := "https://pds.test"
+	if err := s.EnableOAuth(issuer); err != nil {
+		t.Fatal(err)
+	}
+	s.oauthClient = client.Client()
+	router := echo.New()
+	s.registerOAuthHandlers(router)
+
+	key := testDPoPKey(t)
+	nonce := ""
+	post := func(path string, form url.Values, key jwk.Key) (*httptest.ResponseRecorder, map[string]any) {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if key != nil {
+			req.Header.Set("DPoP", testDPoPProof(t, key, "POST", issuer+path, nonce, ""))
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if n := rec.Header().Get("DPoP-Nonce"); n != "" {
+			nonce = n
+		}
+		var out map[string]any
+		json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec, out
+	}
+
+	verifier := "a-code-verifier-which-is-long-enough-to-be-valid"
+	challenge := sha256.Sum256([]byte(verifier))
+	par := url.Values{
+		"client_id":             {clientID},
+		"response_type":         {"code"},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"atproto transition:generic"},
+		"state":                 {"xyz"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	// the first request gets a nonce to retry with
+	rec, out := post("/oauth/par", par, key)
+	if rec.Code != 400 || out["error"] != "use_dpop_nonce" || nonce == "" {
+		t.Fatalf("expected use_dpop_nonce challenge, got %d %v", rec.Code, out)
+	}
+	rec, out = post("/oauth/par", par, key)
+	if rec.Code != 201 {
+		t.Fatalf("PAR failed: %d %v", rec.Code, out)
+	}
+	requestURI, _ := out["request_uri"].(string)
+
+	noPKCE := url.Values{}
+	for k, v := range par {
+		noPKCE[k] = v
+	}
+	noPKCE.Del("code_challenge")
+	if rec, _ := post("/oauth/par", noPKCE, key); rec.Code != 400 {
+		t.Fatalf("expected PAR without PKCE to fail, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/oauth/authorize?"+url.Values{"client_id": {clientID}, "request_uri": {requestURI}}.Encode(), nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != 200 || rec.Header().Get("X-Frame-Options") != "DENY" || !strings.Contains(rec.Body.String(), "Test Client") {
+		t.Fatalf("unexpected authorize page: %d %s", rec.Code, rec.Body.String())
+	}
+
+	login := url.Values{"client_id": {clientID}, "request_uri": {requestURI}, "identifier": {"testman.test"}, "password": {"wrong"}, "action": {"approve"}}
+	if rec, _ := post("/oauth/authorize", login, nil); rec.Code != 401 {
+		t.Fatalf("expected wrong password to fail, got %d", rec.Code)
+	}
+	login.Set("password", p)
+	rec, _ = post("/oauth/authorize", login, nil)
+	if rec.Code != 303 {
+		t.Fatalf("authorize failed: %d %s", rec.Code, rec.Body.String())
+	}
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Query().Get("state") != "xyz" || loc.Query().Get("iss") != issuer || !strings.HasPrefix(loc.String(), redirectURI) {
+		t.Fatalf("unexpected redirect: %s", loc)
+	}
+
+	exchange := url.Values{
+		"client_id":     {clientID},
+		"grant_type":    {"authorization_code"},
+		"code":          {loc.Query().Get("code")},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	rec, out = post("/oauth/token", exchange, key)
+	if rec.Code != 200 || out["token_type"] != "DPoP" {
+		t.Fatalf("token exchange failed: %d %v", rec.Code, out)
+	}
+	accessToken, _ := out["access_token"].(string)
+	refreshToken, _ := out["refresh_token"].(string)
+	if rec, _ := post("/oauth/token", exchange, key); rec.Code != 400 {
+		t.Fatalf("expected code reuse to fail, got %d", rec.Code)
+	}
+
+	refresh := url.Values{"client_id": {clientID}, "grant_type": {"refresh_token"}, "refresh_token": {refreshToken}}
+	if rec, _ := post("/oauth/token", refresh, testDPoPKey(t)); rec.Code != 400 {
+		t.Fatalf("expected refresh with a different DPoP key to fail, got %d", rec.Code)
+	}
+	rec, out = post("/oauth/token", refresh, key)
+	if rec.Code != 200 || out["refresh_token"] == refreshToken {
+		t.Fatalf("refresh failed: %d %v", rec.Code, out)
+	}
+	if rec, _ := post("/oauth/token", refresh, key); rec.Code != 400 {
+		t.Fatalf("expected rotated refresh token to fail, got %d", rec.Code)
+	}
+
+	// runs a request through the resource server auth middleware
+	authed := func(authorization, proof string, bearer bool) (context.Context, int, error) {
+		req := httptest.NewRequest("GET", "/xrpc/com.atproto.server.getSession", nil)
+		req.Header.Set("Authorization", authorization)
+		req.Header.Set("DPoP", proof)
+		rec := httptest.NewRecorder()
+		c := router.NewContext(req, rec)
+		if bearer {
+			tok, err := gojwt.Parse(accessToken, func(*gojwt.Token) (interface{}, error) { return s.jwtSigningKey, nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Set("user", tok)
+		}
+		var ctx context.Context
+		err := s.dpopAuthMiddleware(s.userCheckMiddleware(func(c echo.Context) error {
+			ctx = c.Request().Context()
+			return nil
+		}))(c)
+		return ctx, rec.Code, err
+	}
+
+	htu := issuer + "/xrpc/com.atproto.server.getSession"
+	ctx, _, err := authed("DPoP "+accessToken, testDPoPProof(t, key, "GET", htu, nonce, accessToken), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err := s.getUser(ctx); err != nil || u.Handle != "testman.test" {
+		t.Fatalf("expected authenticated user, got %v %v", u, err)
+	}
+	if scope := ctx.Value("authScope"); scope != "atproto transition:generic" {
+		t.Fatalf("unexpected scope: %v", scope)
+	}
+	if err := s.requireFullAccess(ctx); err == nil {
+		t.Fatal("expected OAuth session to not have full access")
+	}
+
+	if _, code, _ := authed("DPoP "+accessToken, testDPoPProof(t, testDPoPKey(t), "GET", htu, nonce, accessToken), false); code != 401 {
+		t.Fatalf("expected proof from a different key to fail, got %d", code)
+	}
+	if _, _, err := authed("Bearer "+accessToken, "", true); err == nil {
+		t.Fatal("expected DPoP-bound token used as a bearer token to fail")
+	}
+}
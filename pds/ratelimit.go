@@ -0,0 +1,48 @@
+package pds
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bluesky-social/indigo/util/ratelimit"
+	"github.com/labstack/echo/v4"
+)
+
+// Endpoints which can be used without an account to guess passwords, create accounts, or send email, and so are rate limited per client IP
+var authRateLimitedPaths = map[string]bool{
+	"/xrpc/com.atproto.server.createAccount":        true,
+	"/xrpc/com.atproto.server.createSession":        true,
+	"/xrpc/com.atproto.server.requestPasswordReset": true,
+	"/xrpc/com.atproto.server.resetPassword":        true,
+}
+
+const (
+	// default limit on requests to authRateLimitedPaths from a single client IP
+	authRequestsPerMinute = 10
+	authRequestsBurst     = 10
+	authLimiterExpiry     = 10 * time.Minute
+)
+
+func newAuthLimits() *ratelimit.Keyed[string, *ratelimit.TokenBucket] {
+	lims := ratelimit.NewKeyedTokenBuckets[string](authRequestsPerMinute/60.0, authRequestsBurst, authLimiterExpiry)
+	// registered so it can be adjusted at runtime (see ratelimit.Handler)
+	ratelimit.Register("pds.auth_requests", lims)
+	return lims
+}
+
+// SetAuthRateLimit changes the per client IP limit on account creation, login, and password reset requests
+func (s *Server) SetAuthRateLimit(perSecond float64, burst int) {
+	s.authLimits.SetLimits(perSecond, burst)
+}
+
+func (s *Server) rateLimitMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if authRateLimitedPaths[c.Path()] && !s.authLimits.Allow(c.RealIP()) {
+			return c.JSON(http.StatusTooManyRequests, map[string]string{
+				"error":   "RateLimitExceeded",
+				"message": "too many requests, try again later",
+			})
+		}
+		return next(c)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/bluesky-social/indigo/repomgr"
 	"github.com/bluesky-social/indigo/util"
 	bsutil "github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/util/ratelimit"
 	"github.com/bluesky-social/indigo/xrpc"
 	gojwt "github.com/golang-jwt/jwt"
 	"github.com/gorilla/websocket"
@@ -34,6 +35,7 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/whyrusleeping/go-did"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 	"gorm.io/gorm"
 )
 
@@ -56,8 +58,25 @@ type Server struct {
 	serviceUrl   string
 
 	plc plc.PLCClient
+
+	blobs BlobStore
+	// Max total size of blobs uploaded by each account, in bytes (0 for no limit)
+	blobQuota int64
+
+	// OAuth authorization server, enabled if oauthIssuer is set
+	oauthIssuer string
+	dpop        *dpopVerifier
+	oauthClient *http.Client
+
+	mailer Mailer
+
+	// per client IP limits on unauthenticated account endpoints (see authRateLimitedPaths)
+	authLimits *ratelimit.Keyed[string, *ratelimit.TokenBucket]
 }
 
+// Max size of a single uploaded blob
+const maxBlobSize = 100 * 1024 * 1024
+
 // serverListenerBootTimeout is how long to wait for the requested server socket
 // to become available for use. This is an arbitrary timeout that should be safe
 // on any platform, but there's no great way to weave this timeout without
@@ -66,8 +85,13 @@ type Server struct {
 const serverListenerBootTimeout = 5 * time.Second
 
 func NewServer(db *gorm.DB, cs *carstore.CarStore, serkey *did.PrivKey, handleSuffix, serviceUrl string, didr plc.PLCClient, jwtkey []byte) (*Server, error) {
-	db.AutoMigrate(&User{})
-	db.AutoMigrate(&Peering{})
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrator.Up(context.TODO(), 0); err != nil {
+		return nil, err
+	}
 
 	evtman := events.NewEventManager(events.NewMemPersister())
 
@@ -96,6 +120,7 @@ func NewServer(db *gorm.DB, cs *carstore.CarStore, serkey *did.PrivKey, handleSu
 		serviceUrl:     serviceUrl,
 		jwtSigningKey:  jwtkey,
 		enforcePeering: false,
+		authLimits:     newAuthLimits(),
 	}
 
 	repoman.SetEventHandler(func(ctx context.Context, evt *repomgr.RepoEvent) {
@@ -294,9 +319,17 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
 		Format: "method=${method}, uri=${uri}, status=${status} latency=${latency_human}\n",
 	}))
+	// continue the caller's trace, if it sent one. Subscriptions are skipped; a span for the whole connection isn't useful, and each event carries its own trace context
+	e.Use(otelecho.Middleware("pds", otelecho.WithSkipper(func(c echo.Context) bool {
+		return strings.HasPrefix(c.Request().URL.Path, "/xrpc/com.atproto.sync.subscribe")
+	})))
 
 	cfg := middleware.JWTConfig{
 		Skipper: func(c echo.Context) bool {
+			// OAuth endpoints, and requests with DPoP-bound tokens, are authenticated by oauth.go
+			if isOAuthPath(c.Path()) || c.Get("dpopBound") == true {
+				return true
+			}
 			switch c.Path() {
 			case "/xrpc/_health":
 				return true
@@ -411,7 +444,11 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 		return c.String(200, "ok")
 	})
 
-	e.Use(middleware.JWTWithConfig(cfg), s.userCheckMiddleware)
+	if s.oauthIssuer != "" {
+		s.registerOAuthHandlers(e)
+	}
+
+	e.Use(s.rateLimitMiddleware, s.dpopAuthMiddleware, middleware.JWTWithConfig(cfg), s.userCheckMiddleware)
 	s.RegisterHandlersComAtproto(e)
 
 	e.GET("/xrpc/com.atproto.sync.subscribeRepos", s.EventsHandler)
@@ -465,8 +502,24 @@ type User struct {
 	Password    string
 	RecoveryKey string
 	Email       string
-	Did         string `gorm:"uniqueIndex"`
-	PDS         uint
+	// Set once the account has confirmed it controls its email address
+	EmailConfirmedAt *time.Time
+	Did              string `gorm:"uniqueIndex"`
+	PDS              uint
+	// Deactivated accounts include those still being migrated onto this PDS
+	Deactivated bool
+	// When a deactivated account asked to be deleted, if it isn't reactivated
+	DeleteAfter *time.Time
+}
+
+// Blob records a blob uploaded by an account, for quota accounting and listing. The blob itself is in the BlobStore
+type Blob struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Usr       models.Uid `gorm:"uniqueIndex:idx_blob_usr_cid"`
+	Cid       string     `gorm:"uniqueIndex:idx_blob_usr_cid"`
+	MimeType  string
+	Size      int64
 }
 
 type RefreshToken struct {
@@ -591,6 +644,27 @@ func (s *Server) userCheckMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 			return err
 		}
 
+		// app password sessions stop working as soon as the app password is revoked
+		if claims, ok := user.Claims.(gojwt.MapClaims); ok {
+			if name, ok := claims[appPasswordClaim].(string); ok {
+				iat, err := toTime(claims["iat"])
+				if err != nil {
+					return fmt.Errorf("invalid token: %w", err)
+				}
+				ap, err := s.lookupSessionAppPassword(ctx, u, name, iat)
+				if err != nil {
+					return fmt.Errorf("invalid token: %w", err)
+				}
+				ctx = context.WithValue(ctx, "appPassword", ap)
+			} else if scope == scopeAppPass || scope == scopeAppPassPrivileged {
+				return fmt.Errorf("invalid token: app password scope without app password")
+			}
+			// DPoP-bound OAuth tokens can't be used as bearer tokens
+			if _, ok := claims["cnf"]; ok && c.Get("dpopBound") != true {
+				return fmt.Errorf("invalid token: DPoP-bound token used without DPoP proof")
+			}
+		}
+
 		ctx = context.WithValue(ctx, "authScope", scope)
 		ctx = context.WithValue(ctx, "user", u)
 		ctx = context.WithValue(ctx, "did", did)
@@ -849,6 +923,16 @@ func (s *Server) ReactivateRepo(ctx context.Context, did string) error {
 	return nil
 }
 
+// SetBlobStore configures where uploaded blobs are stored. Blob uploads fail if no store is configured
+func (s *Server) SetBlobStore(bs BlobStore) {
+	s.blobs = bs
+}
+
+// SetBlobQuota limits the total size of blobs each account can upload, in bytes (0 for no limit)
+func (s *Server) SetBlobQuota(quota int64) {
+	s.blobQuota = quota
+}
+
 func (s *Server) Repoman() *repomgr.RepoManager {
 	return s.repoman
 }
@@ -28,16 +28,20 @@ func (s *Server) RegisterHandlersComAtproto(e *echo.Echo) error {
 	e.POST("/xrpc/com.atproto.repo.createRecord", s.HandleComAtprotoRepoCreateRecord)
 	e.POST("/xrpc/com.atproto.repo.deleteRecord", s.HandleComAtprotoRepoDeleteRecord)
 	e.GET("/xrpc/com.atproto.repo.describeRepo", s.HandleComAtprotoRepoDescribeRepo)
+	e.POST("/xrpc/com.atproto.repo.importRepo", s.HandleComAtprotoRepoImportRepo)
 	e.GET("/xrpc/com.atproto.repo.getRecord", s.HandleComAtprotoRepoGetRecord)
 	e.GET("/xrpc/com.atproto.repo.listRecords", s.HandleComAtprotoRepoListRecords)
 	e.POST("/xrpc/com.atproto.repo.putRecord", s.HandleComAtprotoRepoPutRecord)
 	e.POST("/xrpc/com.atproto.repo.uploadBlob", s.HandleComAtprotoRepoUploadBlob)
+	e.POST("/xrpc/com.atproto.server.activateAccount", s.HandleComAtprotoServerActivateAccount)
+	e.GET("/xrpc/com.atproto.server.checkAccountStatus", s.HandleComAtprotoServerCheckAccountStatus)
 	e.POST("/xrpc/com.atproto.server.confirmEmail", s.HandleComAtprotoServerConfirmEmail)
 	e.POST("/xrpc/com.atproto.server.createAccount", s.HandleComAtprotoServerCreateAccount)
 	e.POST("/xrpc/com.atproto.server.createAppPassword", s.HandleComAtprotoServerCreateAppPassword)
 	e.POST("/xrpc/com.atproto.server.createInviteCode", s.HandleComAtprotoServerCreateInviteCode)
 	e.POST("/xrpc/com.atproto.server.createInviteCodes", s.HandleComAtprotoServerCreateInviteCodes)
 	e.POST("/xrpc/com.atproto.server.createSession", s.HandleComAtprotoServerCreateSession)
+	e.POST("/xrpc/com.atproto.server.deactivateAccount", s.HandleComAtprotoServerDeactivateAccount)
 	e.POST("/xrpc/com.atproto.server.deleteAccount", s.HandleComAtprotoServerDeleteAccount)
 	e.POST("/xrpc/com.atproto.server.deleteSession", s.HandleComAtprotoServerDeleteSession)
 	e.GET("/xrpc/com.atproto.server.describeServer", s.HandleComAtprotoServerDescribeServer)
@@ -477,6 +481,44 @@ func (s *Server) HandleComAtprotoRepoUploadBlob(c echo.Context) error {
 	return c.JSON(200, out)
 }
 
+func (s *Server) HandleComAtprotoRepoImportRepo(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoRepoImportRepo")
+	defer span.End()
+	body := c.Request().Body
+	var handleErr error
+	// func (s *Server) handleComAtprotoRepoImportRepo(ctx context.Context,r io.Reader) error
+	handleErr = s.handleComAtprotoRepoImportRepo(ctx, body)
+	if handleErr != nil {
+		return handleErr
+	}
+	return nil
+}
+
+func (s *Server) HandleComAtprotoServerActivateAccount(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoServerActivateAccount")
+	defer span.End()
+	var handleErr error
+	// func (s *Server) handleComAtprotoServerActivateAccount(ctx context.Context) error
+	handleErr = s.handleComAtprotoServerActivateAccount(ctx)
+	if handleErr != nil {
+		return handleErr
+	}
+	return nil
+}
+
+func (s *Server) HandleComAtprotoServerCheckAccountStatus(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoServerCheckAccountStatus")
+	defer span.End()
+	var out *comatprototypes.ServerCheckAccountStatus_Output
+	var handleErr error
+	// func (s *Server) handleComAtprotoServerCheckAccountStatus(ctx context.Context) (*comatprototypes.ServerCheckAccountStatus_Output, error)
+	out, handleErr = s.handleComAtprotoServerCheckAccountStatus(ctx)
+	if handleErr != nil {
+		return handleErr
+	}
+	return c.JSON(200, out)
+}
+
 func (s *Server) HandleComAtprotoServerConfirmEmail(c echo.Context) error {
 	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoServerConfirmEmail")
 	defer span.End()
@@ -584,6 +626,23 @@ func (s *Server) HandleComAtprotoServerCreateSession(c echo.Context) error {
 	return c.JSON(200, out)
 }
 
+func (s *Server) HandleComAtprotoServerDeactivateAccount(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoServerDeactivateAccount")
+	defer span.End()
+
+	var body comatprototypes.ServerDeactivateAccount_Input
+	if err := c.Bind(&body); err != nil {
+		return err
+	}
+	var handleErr error
+	// func (s *Server) handleComAtprotoServerDeactivateAccount(ctx context.Context,body *comatprototypes.ServerDeactivateAccount_Input) error
+	handleErr = s.handleComAtprotoServerDeactivateAccount(ctx, &body)
+	if handleErr != nil {
+		return handleErr
+	}
+	return nil
+}
+
 func (s *Server) HandleComAtprotoServerDeleteAccount(c echo.Context) error {
 	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoServerDeleteAccount")
 	defer span.End()
@@ -835,6 +894,9 @@ func (s *Server) HandleComAtprotoSyncGetBlob(c echo.Context) error {
 	if handleErr != nil {
 		return handleErr
 	}
+	if closer, ok := out.(io.Closer); ok {
+		defer closer.Close()
+	}
 	return c.Stream(200, "application/octet-stream", out)
 }
 
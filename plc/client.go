@@ -0,0 +1,171 @@
+package plc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// An entry in the audit log of a DID, as returned by the PLC directory
+type LogEntry struct {
+	DID       string    `json:"did"`
+	Operation Operation `json:"operation"`
+	CID       string    `json:"cid"`
+	Nullified bool      `json:"nullified"`
+	CreatedAt string    `json:"createdAt"`
+}
+
+// Client for the operations API of a PLC directory
+type Client struct {
+	// eg "https://plc.directory"
+	Host string
+	C    *http.Client
+}
+
+func (c *Client) client() *http.Client {
+	if c.C == nil {
+		return http.DefaultClient
+	}
+	return c.C
+}
+
+func (c *Client) get(ctx context.Context, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(c.Host, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("PLC directory request failed (code %d): %s", resp.StatusCode, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// GetAuditLog fetches the full operation log of a DID, including nullified operations.
+func (c *Client) GetAuditLog(ctx context.Context, did syntax.DID) ([]LogEntry, error) {
+	var entries []LogEntry
+	if err := c.get(ctx, "/"+did.String()+"/log/audit", &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// GetLastOp fetches the most recent operation for a DID, which new operations must reference as "prev".
+func (c *Client) GetLastOp(ctx context.Context, did syntax.DID) (*Operation, error) {
+	var op Operation
+	if err := c.get(ctx, "/"+did.String()+"/log/last", &op); err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// GetVerifiedState fetches the audit log of a DID and verifies its active operation chain (see VerifyOpLog), returning the final operation.
+func (c *Client) GetVerifiedState(ctx context.Context, did syntax.DID) (*Operation, error) {
+	entries, err := c.GetAuditLog(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	var ops []*Operation
+	for i := range entries {
+		if entries[i].Nullified {
+			continue
+		}
+		op := &entries[i].Operation
+		opCID, err := op.CID()
+		if err != nil {
+			return nil, err
+		}
+		if opCID.String() != entries[i].CID {
+			return nil, fmt.Errorf("operation CID mismatch in audit log: %s != %s", opCID, entries[i].CID)
+		}
+		ops = append(ops, op)
+	}
+	return VerifyOpLog(did, ops)
+}
+
+// Export fetches a page of operations for all DIDs, in the order they were accepted by the directory, starting after the given createdAt timestamp (or from the beginning, if empty).
+func (c *Client) Export(ctx context.Context, after string, count int) ([]LogEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(c.Host, "/")+"/export", nil)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	q.Set("count", strconv.Itoa(count))
+	if after != "" {
+		q.Set("after", after)
+	}
+	req.URL.RawQuery = q.Encode()
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("PLC directory request failed (code %d): %s", resp.StatusCode, resp.Status)
+	}
+
+	// the response is JSON lines
+	var entries []LogEntry
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var entry LogEntry
+		if err := dec.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("parsing PLC export: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Submit validates a signed operation and submits it to the directory. For genesis operations, the DID is computed from the operation.
+func (c *Client) Submit(ctx context.Context, did syntax.DID, op *Operation) error {
+	if op.Sig == "" {
+		return fmt.Errorf("operation is not signed")
+	}
+	if err := op.Validate(); err != nil {
+		return err
+	}
+	if op.Prev == nil {
+		opDID, err := op.DID()
+		if err != nil {
+			return err
+		}
+		if did != "" && did != opDID {
+			return fmt.Errorf("genesis operation is for a different DID: %s", opDID)
+		}
+		did = opDID
+	}
+
+	body, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(c.Host, "/")+"/"+did.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("PLC directory rejected operation (code %d): %s", resp.StatusCode, msg)
+	}
+	return nil
+}
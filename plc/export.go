@@ -0,0 +1,240 @@
+package plc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Window in which a higher-priority rotation key can nullify operations signed by a lower-priority key
+const RecoveryWindow = 72 * time.Hour
+
+// VerifyNextOp checks a new operation for a DID against the DID's active operation log (oldest first): that its CID is correct, it is signed by a rotation key of the operation it references, and, if it forks the log (references an operation before the most recent one), that it is a valid recovery. Returns the CIDs of the operations it nullifies.
+//
+// A recovery must be signed by a higher-priority rotation key than the first operation being nullified, within RecoveryWindow of it.
+func VerifyNextOp(active []StoredOp, entry *LogEntry) ([]string, error) {
+	op := &entry.Operation
+	c, err := op.CID()
+	if err != nil {
+		return nil, err
+	}
+	if entry.CID != "" && entry.CID != c.String() {
+		return nil, fmt.Errorf("operation CID mismatch: %s != %s", c, entry.CID)
+	}
+	if err := op.Validate(); err != nil {
+		return nil, err
+	}
+
+	if op.Prev == nil {
+		if len(active) > 0 {
+			return nil, fmt.Errorf("genesis operation for existing DID")
+		}
+		did, err := op.DID()
+		if err != nil {
+			return nil, err
+		}
+		if did.String() != entry.DID {
+			return nil, fmt.Errorf("genesis operation is for a different DID: %s", did)
+		}
+		if _, err := op.VerifySignature(op.normalized().RotationKeys); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if op.Type == OpTypeLegacyCreate {
+		return nil, fmt.Errorf("legacy create operation after genesis")
+	}
+
+	idx := -1
+	for i := range active {
+		if active[i].CID == *op.Prev {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("prev operation not found in active log: %s", *op.Prev)
+	}
+	prev := &active[idx].Operation
+	if prev.Type == OpTypeTombstone {
+		return nil, fmt.Errorf("operation follows a tombstone")
+	}
+	keys := prev.normalized().RotationKeys
+	signer, err := op.VerifySignature(keys)
+	if err != nil {
+		return nil, err
+	}
+	if idx == len(active)-1 {
+		return nil, nil
+	}
+
+	// a fork: this operation nullifies everything after prev
+	first := &active[idx+1]
+	firstSigner, err := first.Operation.VerifySignature(keys)
+	if err != nil {
+		return nil, fmt.Errorf("verifying nullified operation: %w", err)
+	}
+	if signer >= firstSigner {
+		return nil, fmt.Errorf("fork must be signed by a higher priority rotation key than the operation it nullifies")
+	}
+	opTime, err := syntax.ParseDatetimeLenient(entry.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid operation createdAt: %w", err)
+	}
+	firstTime, err := syntax.ParseDatetimeLenient(first.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nullified operation createdAt: %w", err)
+	}
+	if opTime.Time().Sub(firstTime.Time()) > RecoveryWindow {
+		return nil, fmt.Errorf("fork is outside the recovery window")
+	}
+	var nullified []string
+	for _, so := range active[idx+1:] {
+		nullified = append(nullified, so.CID)
+	}
+	return nullified, nil
+}
+
+// ExportConsumer mirrors the PLC directory's operation export in to a local OpStore, verifying every operation against the stored log for its DID. The export cursor is persisted in the store, so consumption resumes where it left off.
+//
+// Operations which fail verification are not stored. Because operations are verified against each other, a mirror must consume the export from the beginning.
+type ExportConsumer struct {
+	Client *Client
+	Store  OpStore
+	// Operations per export request (default 1000)
+	PageSize int
+	// If true, keep polling for new operations after catching up, instead of returning
+	Tail bool
+	// How often to poll for new operations, once caught up (default 5s)
+	PollInterval time.Duration
+	Logger       *slog.Logger
+	// Optional callback for each verified operation, after it has been stored, with the CIDs of any operations it nullified. For example, to audit identity changes
+	OnOp func(ctx context.Context, entry *LogEntry, nullified []string) error
+	// Optional callback for operations which failed verification. By default they are logged
+	OnInvalid func(ctx context.Context, entry *LogEntry, err error)
+}
+
+// Run consumes the export until caught up (or, in tail mode, until the context is cancelled). Errors from the store or callbacks are returned; errors fetching the export are retried with backoff.
+func (ec *ExportConsumer) Run(ctx context.Context) error {
+	if ec.Logger == nil {
+		ec.Logger = slog.Default()
+	}
+	if ec.PageSize == 0 {
+		ec.PageSize = 1000
+	}
+	if ec.PollInterval == 0 {
+		ec.PollInterval = 5 * time.Second
+	}
+
+	cursor, err := ec.Store.GetCursor(ctx)
+	if err != nil {
+		return fmt.Errorf("loading export cursor: %w", err)
+	}
+
+	// operations already processed with the same createdAt as the cursor, which may be returned again
+	atCursor := make(map[string]bool)
+	var backoff int
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		entries, err := ec.Client.Export(ctx, cursor, ec.PageSize)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			ec.Logger.Warn("fetching PLC export failed", "cursor", cursor, "err", err, "backoff", backoff)
+			if !sleepCtx(ctx, time.Second*time.Duration(min(1<<backoff, 60))) {
+				return nil
+			}
+			backoff = min(backoff+1, 6)
+			continue
+		}
+		backoff = 0
+
+		for i := range entries {
+			e := &entries[i]
+			if e.CreatedAt == cursor && atCursor[e.CID] {
+				continue
+			}
+			if err := ec.processEntry(ctx, e); err != nil {
+				return err
+			}
+			if e.CreatedAt != cursor {
+				cursor = e.CreatedAt
+				clear(atCursor)
+			}
+			atCursor[e.CID] = true
+		}
+		if len(entries) > 0 {
+			if err := ec.Store.SetCursor(ctx, cursor); err != nil {
+				return fmt.Errorf("persisting export cursor: %w", err)
+			}
+		}
+
+		if len(entries) < ec.PageSize {
+			if !ec.Tail {
+				return nil
+			}
+			if !sleepCtx(ctx, ec.PollInterval) {
+				return nil
+			}
+		}
+	}
+}
+
+func (ec *ExportConsumer) processEntry(ctx context.Context, entry *LogEntry) error {
+	// operations may already have been stored before a restart
+	seen, err := ec.Store.HasOp(ctx, entry.CID)
+	if err != nil {
+		return err
+	}
+	if seen {
+		exportOpsTotal.WithLabelValues("duplicate").Inc()
+		return nil
+	}
+
+	active, err := ec.Store.GetActiveLog(ctx, entry.DID)
+	if err != nil {
+		return err
+	}
+	nullified, err := VerifyNextOp(active, entry)
+	if err != nil {
+		exportOpsTotal.WithLabelValues("invalid").Inc()
+		if ec.OnInvalid != nil {
+			ec.OnInvalid(ctx, entry, err)
+		} else {
+			ec.Logger.Warn("invalid PLC operation", "did", entry.DID, "cid", entry.CID, "createdAt", entry.CreatedAt, "err", err)
+		}
+		return nil
+	}
+
+	if err := ec.Store.PutOp(ctx, &StoredOp{
+		DID:       entry.DID,
+		CID:       entry.CID,
+		Operation: entry.Operation,
+		CreatedAt: entry.CreatedAt,
+	}, nullified); err != nil {
+		return fmt.Errorf("storing PLC operation: %w", err)
+	}
+	exportOpsTotal.WithLabelValues("valid").Inc()
+
+	if ec.OnOp != nil {
+		return ec.OnOp(ctx, entry, nullified)
+	}
+	return nil
+}
+
+// sleeps for the duration, returning false if the context was cancelled first
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,204 @@
+package plc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testEntry(t *testing.T, did string, op *Operation, createdAt time.Time) LogEntry {
+	c, err := op.CID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return LogEntry{
+		DID:       did,
+		Operation: *op,
+		CID:       c.String(),
+		CreatedAt: createdAt.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+func signedUpdate(t *testing.T, prev *Operation, handle string, key crypto.PrivateKey) *Operation {
+	op, err := NewUpdateOp(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op.SetHandle(handle)
+	if err := op.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+func TestVerifyNextOp(t *testing.T) {
+	recovery, recoveryPub := testKey(t)
+	rotation, rotationPub := testKey(t)
+	_, signingPub := testKey(t)
+	now := time.Now()
+
+	genesis := NewCreateOp([]string{recoveryPub, rotationPub}, signingPub, "alice.example.com", "https://pds.example.com")
+	if err := genesis.Sign(rotation); err != nil {
+		t.Fatal(err)
+	}
+	did, err := genesis.DID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e0 := testEntry(t, did.String(), genesis, now.Add(-time.Hour))
+	if _, err := VerifyNextOp(nil, &e0); err != nil {
+		t.Fatal(err)
+	}
+	active := []StoredOp{{DID: e0.DID, CID: e0.CID, Operation: e0.Operation, CreatedAt: e0.CreatedAt}}
+
+	// hijacked by the lower priority key
+	hijack := signedUpdate(t, genesis, "mallory.example.com", rotation)
+	e1 := testEntry(t, did.String(), hijack, now.Add(-time.Minute))
+	if _, err := VerifyNextOp(active, &e1); err != nil {
+		t.Fatal(err)
+	}
+	active = append(active, StoredOp{DID: e1.DID, CID: e1.CID, Operation: e1.Operation, CreatedAt: e1.CreatedAt})
+
+	// a fork with the same priority key is rejected
+	fork := signedUpdate(t, genesis, "bob.example.com", rotation)
+	e2 := testEntry(t, did.String(), fork, now)
+	if _, err := VerifyNextOp(active, &e2); err == nil {
+		t.Fatal("expected fork by same priority key to fail")
+	}
+
+	// recovery with the higher priority key nullifies the hijack
+	recover := signedUpdate(t, genesis, "alice.example.com", recovery)
+	e3 := testEntry(t, did.String(), recover, now)
+	nullified, err := VerifyNextOp(active, &e3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nullified) != 1 || nullified[0] != e1.CID {
+		t.Fatalf("unexpected nullified ops: %v", nullified)
+	}
+
+	// but only within the recovery window
+	late := testEntry(t, did.String(), recover, now.Add(RecoveryWindow))
+	if _, err := VerifyNextOp(active, &late); err == nil {
+		t.Fatal("expected recovery outside window to fail")
+	}
+
+	// CIDs are checked
+	wrongCID := e3
+	wrongCID.CID = e1.CID
+	if _, err := VerifyNextOp(active, &wrongCID); err == nil {
+		t.Fatal("expected CID mismatch to fail")
+	}
+}
+
+func TestExportConsumer(t *testing.T) {
+	ctx := context.Background()
+	rotation, rotationPub := testKey(t)
+	_, signingPub := testKey(t)
+	other, _ := testKey(t)
+	start := time.Now().Add(-time.Hour)
+
+	var entries []LogEntry
+	for i := 0; i < 3; i++ {
+		genesis := NewCreateOp([]string{rotationPub}, signingPub, "user"+strconv.Itoa(i)+".example.com", "https://pds.example.com")
+		if err := genesis.Sign(rotation); err != nil {
+			t.Fatal(err)
+		}
+		did, err := genesis.DID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, testEntry(t, did.String(), genesis, start.Add(time.Duration(len(entries))*time.Second)))
+		update := signedUpdate(t, genesis, "renamed"+strconv.Itoa(i)+".example.com", rotation)
+		entries = append(entries, testEntry(t, did.String(), update, start.Add(time.Duration(len(entries))*time.Second)))
+		bad := signedUpdate(t, update, "mallory.example.com", other)
+		entries = append(entries, testEntry(t, did.String(), bad, start.Add(time.Duration(len(entries))*time.Second)))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		after := r.URL.Query().Get("after")
+		count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+		enc := json.NewEncoder(w)
+		n := 0
+		for _, e := range entries {
+			// like the directory, "after" is inclusive of the timestamp, so pages overlap
+			if after != "" && e.CreatedAt < after {
+				continue
+			}
+			if n >= count {
+				break
+			}
+			enc.Encode(&e)
+			n++
+		}
+	}))
+	defer srv.Close()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	store, err := NewSQLOpStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var valid, invalid int
+	ec := ExportConsumer{
+		Client:   &Client{Host: srv.URL},
+		Store:    store,
+		PageSize: 2,
+		OnOp: func(ctx context.Context, entry *LogEntry, nullified []string) error {
+			valid++
+			return nil
+		},
+		OnInvalid: func(ctx context.Context, entry *LogEntry, err error) {
+			invalid++
+		},
+	}
+	if err := ec.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if valid != 6 || invalid != 3 {
+		t.Fatalf("unexpected results: %d valid, %d invalid", valid, invalid)
+	}
+
+	cursor, err := store.GetCursor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor != entries[len(entries)-1].CreatedAt {
+		t.Fatalf("unexpected cursor: %s", cursor)
+	}
+	log, err := store.GetActiveLog(ctx, entries[0].DID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 2 || log[1].Operation.AlsoKnownAs[0] != "at://renamed0.example.com" {
+		t.Fatalf("unexpected stored log: %v", log)
+	}
+
+	// resuming from the cursor doesn't re-process anything
+	valid, invalid = 0, 0
+	if err := ec.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if valid != 0 {
+		t.Fatalf("unexpected re-processed ops: %d", valid)
+	}
+}
@@ -14,3 +14,8 @@ var cacheMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
 	Name: "plc_cache_misses_total",
 	Help: "Total number of cache misses",
 })
+
+var exportOpsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "plc_export_ops_total",
+	Help: "Total number of operations consumed from the PLC export, by verification result",
+}, []string{"result"})
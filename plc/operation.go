@@ -0,0 +1,439 @@
+package plc
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// Types of did:plc operations
+const (
+	OpTypeOperation = "plc_operation"
+	OpTypeTombstone = "plc_tombstone"
+	// "create" operations are the legacy (v1) genesis operation format; they can still be verified, but not created
+	OpTypeLegacyCreate = "create"
+)
+
+// Limits enforced by the PLC directory
+const (
+	MaxRotationKeys = 5
+	maxOpSize       = 7500
+)
+
+type Service struct {
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint"`
+}
+
+// Operation is a did:plc operation: a signed update to the state of a DID (its keys, handles, and services), or a tombstone deactivating it. Each operation references the CID of the previous one, except for the genesis operation, whose hash determines the DID.
+//
+// Tombstones only have the Type, Prev, and Sig fields. The SigningKey, RecoveryKey, Handle and Service fields are only used by legacy "create" operations.
+type Operation struct {
+	Type                string             `json:"type"`
+	RotationKeys        []string           `json:"rotationKeys,omitempty"`
+	VerificationMethods map[string]string  `json:"verificationMethods,omitempty"`
+	AlsoKnownAs         []string           `json:"alsoKnownAs,omitempty"`
+	Services            map[string]Service `json:"services,omitempty"`
+	// CID of the previous operation, as a string; nil for genesis operations
+	Prev *string `json:"prev"`
+	// base64url (unpadded) signature, by one of the rotation keys of the previous operation
+	Sig string `json:"sig,omitempty"`
+
+	SigningKey  string `json:"signingKey,omitempty"`
+	RecoveryKey string `json:"recoveryKey,omitempty"`
+	Handle      string `json:"handle,omitempty"`
+	Service     string `json:"service,omitempty"`
+}
+
+// NewCreateOp builds an unsigned genesis operation for a new account, with an atproto signing key (as a did:key), handle, and PDS. The rotation keys (did:key, in priority order) can sign later updates.
+func NewCreateOp(rotationKeys []string, signingKey string, handle string, pdsEndpoint string) *Operation {
+	return &Operation{
+		Type:         OpTypeOperation,
+		RotationKeys: rotationKeys,
+		VerificationMethods: map[string]string{
+			"atproto": signingKey,
+		},
+		AlsoKnownAs: []string{"at://" + handle},
+		Services: map[string]Service{
+			"atproto_pds": {
+				Type:     "AtprotoPersonalDataServer",
+				Endpoint: pdsEndpoint,
+			},
+		},
+	}
+}
+
+// NewUpdateOp builds an unsigned operation following prev, starting with the same state as prev, for the caller to modify.
+func NewUpdateOp(prev *Operation) (*Operation, error) {
+	if prev.Type == OpTypeTombstone {
+		return nil, fmt.Errorf("can't update a tombstoned DID")
+	}
+	c, err := prev.CID()
+	if err != nil {
+		return nil, err
+	}
+	prevCID := c.String()
+
+	state := prev.normalized()
+	op := &Operation{
+		Type:                OpTypeOperation,
+		RotationKeys:        append([]string{}, state.RotationKeys...),
+		VerificationMethods: make(map[string]string, len(state.VerificationMethods)),
+		AlsoKnownAs:         append([]string{}, state.AlsoKnownAs...),
+		Services:            make(map[string]Service, len(state.Services)),
+		Prev:                &prevCID,
+	}
+	for k, v := range state.VerificationMethods {
+		op.VerificationMethods[k] = v
+	}
+	for k, v := range state.Services {
+		op.Services[k] = v
+	}
+	return op, nil
+}
+
+// NewTombstoneOp builds an unsigned operation permanently deactivating the DID.
+func NewTombstoneOp(prev *Operation) (*Operation, error) {
+	c, err := prev.CID()
+	if err != nil {
+		return nil, err
+	}
+	prevCID := c.String()
+	return &Operation{
+		Type: OpTypeTombstone,
+		Prev: &prevCID,
+	}, nil
+}
+
+// SetHandle replaces the handle (the first "at://" entry of AlsoKnownAs).
+func (op *Operation) SetHandle(handle string) {
+	for i, aka := range op.AlsoKnownAs {
+		if strings.HasPrefix(aka, "at://") {
+			op.AlsoKnownAs[i] = "at://" + handle
+			return
+		}
+	}
+	op.AlsoKnownAs = append([]string{"at://" + handle}, op.AlsoKnownAs...)
+}
+
+// SetPDS replaces the atproto PDS service endpoint.
+func (op *Operation) SetPDS(endpoint string) {
+	if op.Services == nil {
+		op.Services = make(map[string]Service)
+	}
+	op.Services["atproto_pds"] = Service{
+		Type:     "AtprotoPersonalDataServer",
+		Endpoint: endpoint,
+	}
+}
+
+// SetSigningKey replaces the atproto signing key (a did:key).
+func (op *Operation) SetSigningKey(didKey string) {
+	if op.VerificationMethods == nil {
+		op.VerificationMethods = make(map[string]string)
+	}
+	op.VerificationMethods["atproto"] = didKey
+}
+
+// normalized returns the state of a legacy "create" operation in the current format. Other operations are returned as-is.
+func (op *Operation) normalized() *Operation {
+	if op.Type != OpTypeLegacyCreate {
+		return op
+	}
+	endpoint := op.Service
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "https://" + endpoint
+	}
+	return &Operation{
+		Type:         OpTypeOperation,
+		RotationKeys: []string{op.RecoveryKey, op.SigningKey},
+		VerificationMethods: map[string]string{
+			"atproto": op.SigningKey,
+		},
+		AlsoKnownAs: []string{"at://" + op.Handle},
+		Services: map[string]Service{
+			"atproto_pds": {
+				Type:     "AtprotoPersonalDataServer",
+				Endpoint: endpoint,
+			},
+		},
+		Prev: op.Prev,
+		Sig:  op.Sig,
+	}
+}
+
+// the data model representation of the operation; unlike the JSON struct tags, includes empty fields which are required for the operation type
+func (op *Operation) asMap(withSig bool) map[string]any {
+	var prev any
+	if op.Prev != nil {
+		prev = *op.Prev
+	}
+	var m map[string]any
+	switch op.Type {
+	case OpTypeTombstone:
+		m = map[string]any{
+			"type": op.Type,
+			"prev": prev,
+		}
+	case OpTypeLegacyCreate:
+		m = map[string]any{
+			"type":        op.Type,
+			"signingKey":  op.SigningKey,
+			"recoveryKey": op.RecoveryKey,
+			"handle":      op.Handle,
+			"service":     op.Service,
+			"prev":        prev,
+		}
+	default:
+		rotationKeys := make([]any, len(op.RotationKeys))
+		for i, k := range op.RotationKeys {
+			rotationKeys[i] = k
+		}
+		aka := make([]any, len(op.AlsoKnownAs))
+		for i, a := range op.AlsoKnownAs {
+			aka[i] = a
+		}
+		vms := make(map[string]any, len(op.VerificationMethods))
+		for k, v := range op.VerificationMethods {
+			vms[k] = v
+		}
+		services := make(map[string]any, len(op.Services))
+		for k, v := range op.Services {
+			services[k] = map[string]any{
+				"type":     v.Type,
+				"endpoint": v.Endpoint,
+			}
+		}
+		m = map[string]any{
+			"type":                op.Type,
+			"rotationKeys":        rotationKeys,
+			"verificationMethods": vms,
+			"alsoKnownAs":         aka,
+			"services":            services,
+			"prev":                prev,
+		}
+	}
+	if withSig && op.Sig != "" {
+		m["sig"] = op.Sig
+	}
+	return m
+}
+
+func (op *Operation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(op.asMap(true))
+}
+
+// UnsignedBytes returns the DAG-CBOR encoding of the operation without its signature, which is what gets signed.
+func (op *Operation) UnsignedBytes() ([]byte, error) {
+	return data.MarshalCBOR(op.asMap(false))
+}
+
+// SignedBytes returns the DAG-CBOR encoding of the signed operation.
+func (op *Operation) SignedBytes() ([]byte, error) {
+	if op.Sig == "" {
+		return nil, fmt.Errorf("operation is not signed")
+	}
+	return data.MarshalCBOR(op.asMap(true))
+}
+
+// CID of the signed operation, which the next operation references as "prev".
+func (op *Operation) CID() (cid.Cid, error) {
+	b, err := op.SignedBytes()
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.V1Builder{Codec: cid.DagCBOR, MhType: multihash.SHA2_256}.Sum(b)
+}
+
+// DID is the did:plc identifier determined by a signed genesis operation.
+func (op *Operation) DID() (syntax.DID, error) {
+	if op.Prev != nil {
+		return "", fmt.Errorf("not a genesis operation")
+	}
+	b, err := op.SignedBytes()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	enc := strings.ToLower(base32.StdEncoding.EncodeToString(h[:]))
+	return syntax.DID("did:plc:" + enc[:24]), nil
+}
+
+// Sign signs the operation with a rotation key: for genesis operations one of the operation's own rotation keys, otherwise one of the previous operation's.
+func (op *Operation) Sign(key crypto.PrivateKey) error {
+	if op.Type == OpTypeLegacyCreate {
+		return fmt.Errorf("legacy create operations can't be signed")
+	}
+	b, err := op.UnsignedBytes()
+	if err != nil {
+		return err
+	}
+	sig, err := key.HashAndSign(b)
+	if err != nil {
+		return err
+	}
+	op.Sig = base64.RawURLEncoding.EncodeToString(sig)
+	return nil
+}
+
+// VerifySignature checks the signature against a list of did:key rotation keys, returning the index of the key which signed it.
+//
+// "High-S" signatures are accepted, because older operations in the directory have them; Sign always produces "low-S" signatures.
+func (op *Operation) VerifySignature(keys []string) (int, error) {
+	if op.Sig == "" {
+		return -1, fmt.Errorf("operation is not signed")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(op.Sig)
+	if err != nil {
+		return -1, fmt.Errorf("invalid operation signature encoding: %w", err)
+	}
+	b, err := op.UnsignedBytes()
+	if err != nil {
+		return -1, err
+	}
+	for i, k := range keys {
+		pub, err := crypto.ParsePublicDIDKey(k)
+		if err != nil {
+			continue
+		}
+		if err := pub.HashAndVerifyLenient(b, sig); err == nil {
+			return i, nil
+		}
+	}
+	return -1, crypto.ErrInvalidSignature
+}
+
+// Validate checks that the operation is well-formed, as the PLC directory would, not including its signature or its place in the operation log.
+func (op *Operation) Validate() error {
+	switch op.Type {
+	case OpTypeTombstone:
+		if op.Prev == nil {
+			return fmt.Errorf("tombstone must reference a previous operation")
+		}
+	case OpTypeLegacyCreate:
+		if op.Prev != nil {
+			return fmt.Errorf("legacy create operation must be a genesis operation")
+		}
+		for _, k := range []string{op.SigningKey, op.RecoveryKey} {
+			if _, err := crypto.ParsePublicDIDKey(k); err != nil {
+				return fmt.Errorf("invalid key %q: %w", k, err)
+			}
+		}
+	case OpTypeOperation:
+		if len(op.RotationKeys) == 0 {
+			return fmt.Errorf("operation must have at least one rotation key")
+		}
+		if len(op.RotationKeys) > MaxRotationKeys {
+			return fmt.Errorf("operation has too many rotation keys (max %d)", MaxRotationKeys)
+		}
+		seen := make(map[string]bool)
+		for _, k := range op.RotationKeys {
+			if seen[k] {
+				return fmt.Errorf("duplicate rotation key %q", k)
+			}
+			seen[k] = true
+			if _, err := crypto.ParsePublicDIDKey(k); err != nil {
+				return fmt.Errorf("invalid rotation key %q: %w", k, err)
+			}
+		}
+		for id, k := range op.VerificationMethods {
+			if _, err := crypto.ParsePublicDIDKey(k); err != nil {
+				return fmt.Errorf("invalid verification method %q: %w", id, err)
+			}
+		}
+		for id, svc := range op.Services {
+			if svc.Type == "" || svc.Endpoint == "" {
+				return fmt.Errorf("service %q must have a type and endpoint", id)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown operation type: %q", op.Type)
+	}
+	if op.Prev != nil {
+		if _, err := cid.Decode(*op.Prev); err != nil {
+			return fmt.Errorf("invalid prev CID: %w", err)
+		}
+	}
+	b, err := data.MarshalCBOR(op.asMap(true))
+	if err != nil {
+		return err
+	}
+	if len(b) > maxOpSize {
+		return fmt.Errorf("operation too large (%d bytes, max %d)", len(b), maxOpSize)
+	}
+	return nil
+}
+
+var ErrTombstoned = errors.New("DID has been tombstoned")
+
+// VerifyOpLog validates the active chain of operations for a DID, oldest first: that the genesis operation matches the DID, every operation references the previous one, and every operation is signed by a rotation key of the previous operation (the genesis operation by one of its own). Returns the final operation, which holds the current state of the DID, or ErrTombstoned.
+//
+// Nullified operations (superseded by a higher-priority rotation key, within the recovery window) must be excluded.
+func VerifyOpLog(did syntax.DID, ops []*Operation) (*Operation, error) {
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("empty operation log")
+	}
+	var prev *Operation
+	for i, op := range ops {
+		if err := op.Validate(); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		if prev == nil {
+			if op.Prev != nil {
+				return nil, fmt.Errorf("operation 0: genesis operation has prev")
+			}
+			if op.Type == OpTypeTombstone {
+				return nil, fmt.Errorf("operation 0: genesis operation is a tombstone")
+			}
+			opDID, err := op.DID()
+			if err != nil {
+				return nil, fmt.Errorf("operation 0: %w", err)
+			}
+			if opDID != did {
+				return nil, fmt.Errorf("genesis operation is for a different DID: %s", opDID)
+			}
+			keys := op.RotationKeys
+			if op.Type == OpTypeLegacyCreate {
+				keys = op.normalized().RotationKeys
+			}
+			if _, err := op.VerifySignature(keys); err != nil {
+				return nil, fmt.Errorf("operation 0: %w", err)
+			}
+			prev = op
+			continue
+		}
+
+		if prev.Type == OpTypeTombstone {
+			return nil, fmt.Errorf("operation %d: follows a tombstone", i)
+		}
+		if op.Type == OpTypeLegacyCreate {
+			return nil, fmt.Errorf("operation %d: legacy create operation after genesis", i)
+		}
+		prevCID, err := prev.CID()
+		if err != nil {
+			return nil, err
+		}
+		if op.Prev == nil || *op.Prev != prevCID.String() {
+			return nil, fmt.Errorf("operation %d: prev does not match previous operation (%s)", i, prevCID)
+		}
+		if _, err := op.VerifySignature(prev.normalized().RotationKeys); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		prev = op
+	}
+	if prev.Type == OpTypeTombstone {
+		return nil, ErrTombstoned
+	}
+	return prev.normalized(), nil
+}
@@ -0,0 +1,185 @@
+package plc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+)
+
+func TestLegacyCreateOp(t *testing.T) {
+	// same vector as the api package CreateOp test
+	raw := `{
+    "type": "create",
+    "signingKey": "did:key:zDnaeRSYs7c2NpcNA5NRAUqS8DCkLWDyNLnATi28D6w7no7hX",
+    "recoveryKey": "did:key:zDnaeRSYs7c2NpcNA5NRAUqS8DCkLWDyNLnATi28D6w7no7hX",
+    "handle": "why.bsky.social",
+    "service": "bsky.social",
+    "prev": null,
+    "sig": "e8h6dCx405Z_95cZWWkZtfLgDPvfdXDG9pCZQi1NhduooZgb4d1w-CzahA3J-iNGCCgP3D0O5l997G3vQfxKOA"
+  }`
+	encoded := "pmRwcmV29mR0eXBlZmNyZWF0ZWZoYW5kbGVvd2h5LmJza3kuc29jaWFsZ3NlcnZpY2VrYnNreS5zb2NpYWxqc2lnbmluZ0tleXg5ZGlkOmtleTp6RG5hZVJTWXM3YzJOcGNOQTVOUkFVcVM4RENrTFdEeU5MbkFUaTI4RDZ3N25vN2hYa3JlY292ZXJ5S2V5eDlkaWQ6a2V5OnpEbmFlUlNZczdjMk5wY05BNU5SQVVxUzhEQ2tMV0R5TkxuQVRpMjhENnc3bm83aFg"
+
+	var op Operation
+	if err := json.Unmarshal([]byte(raw), &op); err != nil {
+		t.Fatal(err)
+	}
+	if err := op.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := op.UnsignedBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, exp) {
+		t.Fatalf("encoding mismatch: %x != %x", b, exp)
+	}
+	if _, err := op.VerifySignature([]string{op.SigningKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	state := op.normalized()
+	if state.Services["atproto_pds"].Endpoint != "https://bsky.social" {
+		t.Fatal("legacy service endpoint not normalized")
+	}
+}
+
+func testKey(t *testing.T) (crypto.PrivateKey, string) {
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, pub.DIDKey()
+}
+
+func TestOperationChain(t *testing.T) {
+	rotation, rotationPub := testKey(t)
+	recovery, recoveryPub := testKey(t)
+	_, signingPub := testKey(t)
+	other, _ := testKey(t)
+
+	genesis := NewCreateOp([]string{recoveryPub, rotationPub}, signingPub, "alice.example.com", "https://pds.example.com")
+	if err := genesis.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := genesis.DID(); err == nil {
+		t.Fatal("expected unsigned genesis operation to have no DID")
+	}
+	if err := genesis.Sign(rotation); err != nil {
+		t.Fatal(err)
+	}
+	did, err := genesis.DID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(did.String()) != len("did:plc:")+24 {
+		t.Fatalf("invalid DID: %s", did)
+	}
+
+	// JSON round-trip preserves the signed encoding, and the CID
+	js, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed Operation
+	if err := json.Unmarshal(js, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	c1, err := genesis.CID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := parsed.CID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 {
+		t.Fatal("CID changed in JSON round-trip")
+	}
+
+	update, err := NewUpdateOp(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update.SetHandle("alice2.example.com")
+	update.SetPDS("https://pds2.example.com")
+	if err := update.Sign(recovery); err != nil {
+		t.Fatal(err)
+	}
+	if genesis.AlsoKnownAs[0] != "at://alice.example.com" {
+		t.Fatal("update modified previous operation")
+	}
+
+	state, err := VerifyOpLog(did, []*Operation{genesis, update})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.AlsoKnownAs[0] != "at://alice2.example.com" || state.Services["atproto_pds"].Endpoint != "https://pds2.example.com" {
+		t.Fatal("unexpected final state")
+	}
+
+	// signed by a key which isn't a rotation key
+	bad, err := NewUpdateOp(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad.SetHandle("mallory.example.com")
+	if err := bad.Sign(other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyOpLog(did, []*Operation{genesis, update, bad}); err == nil {
+		t.Fatal("expected invalid signature to fail verification")
+	}
+
+	// prev must chain
+	if _, err := VerifyOpLog(did, []*Operation{genesis, update, update}); err == nil {
+		t.Fatal("expected broken chain to fail verification")
+	}
+	// genesis must match DID
+	if _, err := VerifyOpLog("did:plc:aaaaaaaaaaaaaaaaaaaaaaaa", []*Operation{genesis}); err == nil {
+		t.Fatal("expected wrong DID to fail verification")
+	}
+
+	tomb, err := NewTombstoneOp(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tomb.Sign(rotation); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyOpLog(did, []*Operation{genesis, update, tomb}); err != ErrTombstoned {
+		t.Fatalf("expected tombstoned DID, got: %v", err)
+	}
+	if _, err := NewUpdateOp(tomb); err == nil {
+		t.Fatal("expected update after tombstone to fail")
+	}
+}
+
+func TestValidateOperation(t *testing.T) {
+	_, key := testKey(t)
+
+	op := NewCreateOp([]string{key, key}, key, "alice.example.com", "https://pds.example.com")
+	if err := op.Validate(); err == nil {
+		t.Fatal("expected duplicate rotation keys to fail validation")
+	}
+
+	op = NewCreateOp([]string{"did:key:invalid"}, key, "alice.example.com", "https://pds.example.com")
+	if err := op.Validate(); err == nil {
+		t.Fatal("expected invalid rotation key to fail validation")
+	}
+
+	op = NewCreateOp(nil, key, "alice.example.com", "https://pds.example.com")
+	if err := op.Validate(); err == nil {
+		t.Fatal("expected missing rotation keys to fail validation")
+	}
+}
@@ -0,0 +1,93 @@
+package plc
+
+import (
+	"context"
+	"sync"
+)
+
+// A verified operation in a local copy of the PLC directory
+type StoredOp struct {
+	DID       string
+	CID       string
+	Operation Operation
+	CreatedAt string
+	Nullified bool
+}
+
+// OpStore is a local copy of PLC directory operations, fed by an ExportConsumer. Implementations must be safe for concurrent use.
+type OpStore interface {
+	// Returns the active (non-nullified) operations for a DID, oldest first; empty for unknown DIDs.
+	GetActiveLog(ctx context.Context, did string) ([]StoredOp, error)
+	// Returns whether an operation (by CID) is already stored.
+	HasOp(ctx context.Context, cid string) (bool, error)
+	// Stores a verified operation, marking the given earlier operations (by CID) of the same DID as nullified.
+	PutOp(ctx context.Context, op *StoredOp, nullify []string) error
+
+	// The export cursor (createdAt of the last consumed operation), or empty.
+	GetCursor(ctx context.Context) (string, error)
+	SetCursor(ctx context.Context, cursor string) error
+}
+
+// MemOpStore is an in-memory OpStore, mostly for testing
+type MemOpStore struct {
+	lk     sync.Mutex
+	logs   map[string][]StoredOp
+	cids   map[string]bool
+	cursor string
+}
+
+var _ OpStore = (*MemOpStore)(nil)
+
+func NewMemOpStore() *MemOpStore {
+	return &MemOpStore{
+		logs: make(map[string][]StoredOp),
+		cids: make(map[string]bool),
+	}
+}
+
+func (s *MemOpStore) GetActiveLog(ctx context.Context, did string) ([]StoredOp, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	var out []StoredOp
+	for _, op := range s.logs[did] {
+		if !op.Nullified {
+			out = append(out, op)
+		}
+	}
+	return out, nil
+}
+
+func (s *MemOpStore) HasOp(ctx context.Context, cid string) (bool, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.cids[cid], nil
+}
+
+func (s *MemOpStore) PutOp(ctx context.Context, op *StoredOp, nullify []string) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	log := s.logs[op.DID]
+	for _, c := range nullify {
+		for i := range log {
+			if log[i].CID == c {
+				log[i].Nullified = true
+			}
+		}
+	}
+	s.logs[op.DID] = append(log, *op)
+	s.cids[op.CID] = true
+	return nil
+}
+
+func (s *MemOpStore) GetCursor(ctx context.Context) (string, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.cursor, nil
+}
+
+func (s *MemOpStore) SetCursor(ctx context.Context, cursor string) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.cursor = cursor
+	return nil
+}
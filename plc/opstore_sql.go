@@ -0,0 +1,105 @@
+package plc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Database row for a single PLC operation
+type PLCOpRecord struct {
+	ID  uint   `gorm:"primarykey"`
+	Did string `gorm:"index"`
+	Cid string `gorm:"uniqueIndex"`
+	// JSON-encoded signed operation
+	Operation   string
+	OpCreatedAt string
+	Nullified   bool
+}
+
+type PLCExportCursor struct {
+	ID     uint `gorm:"primarykey"`
+	Cursor string
+}
+
+// [OpStore] implementation backed by a SQL database (sqlite or PostgreSQL), via gorm
+type SQLOpStore struct {
+	db *gorm.DB
+}
+
+var _ OpStore = (*SQLOpStore)(nil)
+
+// Creates a new store using the provided database, running any schema migrations.
+func NewSQLOpStore(db *gorm.DB) (*SQLOpStore, error) {
+	if err := db.AutoMigrate(&PLCOpRecord{}, &PLCExportCursor{}); err != nil {
+		return nil, fmt.Errorf("migrating PLC op store schema: %w", err)
+	}
+	return &SQLOpStore{db: db}, nil
+}
+
+func (s *SQLOpStore) GetActiveLog(ctx context.Context, did string) ([]StoredOp, error) {
+	var rows []PLCOpRecord
+	if err := s.db.WithContext(ctx).Where("did = ? AND nullified = ?", did, false).Order("id ASC").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make([]StoredOp, len(rows))
+	for i, row := range rows {
+		out[i] = StoredOp{
+			DID:       row.Did,
+			CID:       row.Cid,
+			CreatedAt: row.OpCreatedAt,
+			Nullified: row.Nullified,
+		}
+		if err := json.Unmarshal([]byte(row.Operation), &out[i].Operation); err != nil {
+			return nil, fmt.Errorf("parsing stored operation %s: %w", row.Cid, err)
+		}
+	}
+	return out, nil
+}
+
+func (s *SQLOpStore) HasOp(ctx context.Context, cid string) (bool, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&PLCOpRecord{}).Where("cid = ?", cid).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *SQLOpStore) PutOp(ctx context.Context, op *StoredOp, nullify []string) error {
+	b, err := json.Marshal(&op.Operation)
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if len(nullify) > 0 {
+			if err := tx.Model(&PLCOpRecord{}).Where("did = ? AND cid IN ?", op.DID, nullify).Update("nullified", true).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&PLCOpRecord{
+			Did:         op.DID,
+			Cid:         op.CID,
+			Operation:   string(b),
+			OpCreatedAt: op.CreatedAt,
+			Nullified:   op.Nullified,
+		}).Error
+	})
+}
+
+func (s *SQLOpStore) GetCursor(ctx context.Context) (string, error) {
+	var cur PLCExportCursor
+	if err := s.db.WithContext(ctx).Limit(1).Find(&cur, 1).Error; err != nil {
+		return "", err
+	}
+	return cur.Cursor, nil
+}
+
+func (s *SQLOpStore) SetCursor(ctx context.Context, cursor string) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"cursor"}),
+	}).Create(&PLCExportCursor{ID: 1, Cursor: cursor}).Error
+}
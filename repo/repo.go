@@ -354,6 +354,22 @@ func (r *Repo) ForEach(ctx context.Context, prefix string, cb func(k string, v c
 	return nil
 }
 
+// ForEachInRange calls cb on each record path in the range [from, to), in order (see mst.WalkLeavesRange)
+func (r *Repo) ForEachInRange(ctx context.Context, from, to string, cb func(k string, v cid.Cid) error) error {
+	ctx, span := otel.Tracer("repo").Start(ctx, "ForEachInRange")
+	defer span.End()
+
+	t := mst.LoadMST(r.cst, r.sc.Data)
+
+	if err := t.WalkLeavesRange(ctx, from, to, cb); err != nil {
+		if err != ErrDoneIterating {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (r *Repo) GetRecord(ctx context.Context, rpath string) (cid.Cid, cbg.CBORMarshaler, error) {
 	ctx, span := otel.Tracer("repo").Start(ctx, "GetRecord")
 	defer span.End()
@@ -375,6 +391,16 @@ func (r *Repo) GetRecord(ctx context.Context, rpath string) (cid.Cid, cbg.CBORMa
 	return cc, rec, nil
 }
 
+// GetRecordCid returns the CID of the record at rpath, without loading the record itself
+func (r *Repo) GetRecordCid(ctx context.Context, rpath string) (cid.Cid, error) {
+	mst, err := r.getMst(ctx)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("getting repo mst: %w", err)
+	}
+
+	return mst.Get(ctx, rpath)
+}
+
 func (r *Repo) GetRecordBytes(ctx context.Context, rpath string) (cid.Cid, *[]byte, error) {
 	ctx, span := otel.Tracer("repo").Start(ctx, "GetRecordBytes")
 	defer span.End()
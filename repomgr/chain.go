@@ -0,0 +1,150 @@
+package repomgr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	atproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/repo"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Errors from ChainVerifier.Verify, for commits which don't cleanly follow the previous one
+var (
+	// The commit's rev is not after the last verified commit (eg, a replayed or rolled back repo)
+	ErrChainRollback = errors.New("commit rolls back repo")
+	// The commit doesn't build on the last verified commit, or its ops don't describe its changes
+	ErrChainFork = errors.New("commit forks repo")
+	// The commit builds on a later commit than the last verified one, so commits were missed
+	ErrChainGap = errors.New("commits missing from chain")
+	// The commit couldn't be checked against the previous repo state, because blocks were missing (eg, a tooBig event, or a verifier which wasn't seeded with the full repo)
+	ErrChainUnverifiable = errors.New("commit cannot be verified")
+)
+
+// ChainVerifier checks that a sequence of commit events for one account forms a consistent chain: revs only increase, each commit builds on the one before, and each commit's ops account for exactly the changes between the two MSTs.
+//
+// Blocks from each commit are kept in memory, so the previous MST can be compared against. Seeding the verifier with the full repo (see Seed) means every commit can be diffed; otherwise, commits which change parts of the tree not seen in earlier events are ErrChainUnverifiable. Signatures are not checked (see RepoManager.CheckRepoSig).
+//
+// A ChainVerifier is not safe for concurrent use.
+type ChainVerifier struct {
+	did  string
+	bs   blockstore.Blockstore
+	head cid.Cid
+	rev  string
+}
+
+func NewChainVerifier(did string) *ChainVerifier {
+	return &ChainVerifier{
+		did: did,
+		bs:  blockstore.NewBlockstore(datastore.NewMapDatastore()),
+	}
+}
+
+// Head returns the last verified commit and its rev
+func (cv *ChainVerifier) Head() (cid.Cid, string) {
+	return cv.head, cv.rev
+}
+
+// Seed loads a full repo CAR (eg, from sync.getRepo), to verify the commits following it
+func (cv *ChainVerifier) Seed(ctx context.Context, car io.Reader) error {
+	root, err := repo.IngestRepo(ctx, cv.bs, car)
+	if err != nil {
+		return fmt.Errorf("reading repo: %w", err)
+	}
+	r, err := repo.OpenRepo(ctx, cv.bs, root)
+	if err != nil {
+		return err
+	}
+	if repoDid := r.RepoDid(); repoDid != cv.did {
+		return fmt.Errorf("DID in repo did not match (%q != %q)", cv.did, repoDid)
+	}
+	cv.head = root
+	cv.rev = r.SignedCommit().Rev
+	return nil
+}
+
+// Verify checks a commit event against the last verified commit. Rollbacks, and commits which are not for this account or don't match their blocks, are rejected without changing the verifier's state. Otherwise the verifier moves on to the event's commit, even if an error (ErrChainFork, ErrChainGap, or ErrChainUnverifiable) is returned, so the caller can flag the problem (and perhaps resync) and keep verifying. Duplicates of the last verified commit are ignored.
+func (cv *ChainVerifier) Verify(ctx context.Context, evt *atproto.SyncSubscribeRepos_Commit) error {
+	if evt.Repo != cv.did {
+		return fmt.Errorf("commit event is for another account: %s", evt.Repo)
+	}
+	commit := cid.Cid(evt.Commit)
+
+	if cv.rev != "" && evt.Rev <= cv.rev {
+		if evt.Rev == cv.rev && commit == cv.head {
+			return nil
+		}
+		return fmt.Errorf("%w: rev %s is not after %s", ErrChainRollback, evt.Rev, cv.rev)
+	}
+
+	// how the commit follows the last one, checked once the commit itself is known to be valid
+	var chainErr error
+	if cv.rev != "" {
+		switch {
+		case evt.Since == nil:
+			chainErr = fmt.Errorf("%w: commit has no previous rev, expected %s", ErrChainFork, cv.rev)
+		case *evt.Since < cv.rev:
+			chainErr = fmt.Errorf("%w: commit follows rev %s, before %s", ErrChainFork, *evt.Since, cv.rev)
+		case *evt.Since > cv.rev:
+			chainErr = fmt.Errorf("%w: commit follows rev %s, after %s", ErrChainGap, *evt.Since, cv.rev)
+		}
+	}
+
+	if evt.TooBig || len(evt.Blocks) == 0 {
+		cv.head, cv.rev = commit, evt.Rev
+		if chainErr != nil {
+			return chainErr
+		}
+		return fmt.Errorf("%w: event has no blocks", ErrChainUnverifiable)
+	}
+
+	root, err := repo.IngestRepo(ctx, cv.bs, bytes.NewReader(evt.Blocks))
+	if err != nil {
+		return fmt.Errorf("reading commit blocks: %w", err)
+	}
+	if root != commit {
+		return fmt.Errorf("commit event cid did not match blocks (%s != %s)", commit, root)
+	}
+	r, err := repo.OpenRepo(ctx, cv.bs, root)
+	if err != nil {
+		return fmt.Errorf("opening commit: %w", err)
+	}
+	if repoDid := r.RepoDid(); repoDid != cv.did {
+		return fmt.Errorf("DID in repo did not match (%q != %q)", cv.did, repoDid)
+	}
+
+	prev := cv.head
+	cv.head, cv.rev = commit, evt.Rev
+
+	if err := verifyCommitOps(ctx, r, evt.Rev, evt.Ops); err != nil {
+		if ipld.IsNotFound(err) {
+			return fmt.Errorf("%w: %w", ErrChainUnverifiable, err)
+		}
+		return fmt.Errorf("%w: %w", ErrChainFork, err)
+	}
+	if chainErr != nil {
+		return chainErr
+	}
+	if !prev.Defined() {
+		return nil
+	}
+
+	diff, err := r.DiffSince(ctx, prev)
+	if err != nil {
+		if ipld.IsNotFound(err) {
+			return fmt.Errorf("%w: %w", ErrChainUnverifiable, err)
+		}
+		return fmt.Errorf("diffing against previous commit: %w", err)
+	}
+	if err := diffMatchesOps(diff, evt.Ops); err != nil {
+		return fmt.Errorf("%w: %w", ErrChainFork, err)
+	}
+	return nil
+}
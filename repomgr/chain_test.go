@@ -0,0 +1,122 @@
+package repomgr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	atproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/carstore"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/ipfs/go-cid"
+)
+
+func TestChainVerifier(t *testing.T) {
+	dir, err := os.MkdirTemp("", "integtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	did := "did:plc:beepboop"
+	cs := testCarstore(t, dir)
+	ctx := context.TODO()
+
+	var since *string
+	var evts []*atproto.SyncSubscribeRepos_Commit
+	for i := 0; i < 8; i++ {
+		evts = append(evts, chainCommit(t, cs, did, since, i, true))
+		since = &evts[i].Rev
+	}
+
+	cv := NewChainVerifier(did)
+	for _, evt := range evts[:4] {
+		if err := cv.Verify(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if head, rev := cv.Head(); head != cid.Cid(evts[3].Commit) || rev != evts[3].Rev {
+		t.Fatalf("unexpected head %s at rev %s", head, rev)
+	}
+
+	// replays of the last commit are fine, of earlier ones are not
+	if err := cv.Verify(ctx, evts[3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := cv.Verify(ctx, evts[1]); !errors.Is(err, ErrChainRollback) {
+		t.Fatalf("expected rollback, got %v", err)
+	}
+
+	// skipping a commit is a gap, after which verification carries on
+	if err := cv.Verify(ctx, evts[5]); !errors.Is(err, ErrChainGap) {
+		t.Fatalf("expected gap, got %v", err)
+	}
+	if err := cv.Verify(ctx, evts[6]); err != nil {
+		t.Fatal(err)
+	}
+
+	// commits which don't build on the last one, or don't describe their changes, are forks
+	stale := *evts[7]
+	stale.Since = &evts[2].Rev
+	if err := cv.Verify(ctx, &stale); !errors.Is(err, ErrChainFork) {
+		t.Fatalf("expected fork, got %v", err)
+	}
+	undeclared := chainCommit(t, cs, did, &evts[7].Rev, 8, false)
+	if err := cv.Verify(ctx, undeclared); !errors.Is(err, ErrChainFork) {
+		t.Fatalf("expected fork, got %v", err)
+	}
+
+	tooBig := chainCommit(t, cs, did, &undeclared.Rev, 9, true)
+	tooBig.TooBig = true
+	tooBig.Blocks = nil
+	if err := cv.Verify(ctx, tooBig); !errors.Is(err, ErrChainUnverifiable) {
+		t.Fatalf("expected unverifiable, got %v", err)
+	}
+
+	other := *evts[0]
+	other.Repo = "did:plc:someoneelse"
+	if err := cv.Verify(ctx, &other); err == nil {
+		t.Fatal("expected commit for another account to fail")
+	}
+
+	// a verifier seeded with the full repo can check the next commit
+	buf := new(bytes.Buffer)
+	if err := cs.ReadUserCar(ctx, 1, "", false, buf); err != nil {
+		t.Fatal(err)
+	}
+	seeded := NewChainVerifier(did)
+	if err := seeded.Seed(ctx, buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, rev := seeded.Head(); rev != tooBig.Rev {
+		t.Fatalf("expected seeded verifier at rev %s, got %s", tooBig.Rev, rev)
+	}
+	if err := seeded.Verify(ctx, chainCommit(t, cs, did, &tooBig.Rev, 10, true)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// chainCommit makes a commit adding a post, as a commit event (optionally without its op)
+func chainCommit(t *testing.T, cs *carstore.CarStore, did string, since *string, postid int, withOp bool) *atproto.SyncSubscribeRepos_Commit {
+	slice, nrev, tid, rcid := appendPost(t, cs, did, since, postid)
+	head, err := cs.GetUserRepoHead(context.TODO(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	evt := &atproto.SyncSubscribeRepos_Commit{
+		Repo:   did,
+		Rev:    nrev,
+		Since:  since,
+		Blocks: slice,
+		Commit: lexutil.LexLink(head),
+		Ops:    []*atproto.SyncSubscribeRepos_RepoOp{},
+	}
+	if withOp {
+		link := lexutil.LexLink(rcid)
+		evt.Ops = append(evt.Ops, &atproto.SyncSubscribeRepos_RepoOp{Action: "create", Path: "app.bsky.feed.post/" + tid, Cid: &link})
+	}
+	return evt
+}
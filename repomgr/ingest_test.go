@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
 	atproto "github.com/bluesky-social/indigo/api/atproto"
 	bsky "github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/carstore"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/models"
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/util"
@@ -151,6 +153,285 @@ func TestIngestWithGap(t *testing.T) {
 	}
 }
 
+func TestResyncRepo(t *testing.T) {
+	dir, err := os.MkdirTemp("", "integtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	did := "did:plc:beepboop"
+	cs := testCarstore(t, dir)
+	repoman := NewRepoManager(cs, &util.FakeKeyManager{})
+
+	var evts int
+	repoman.SetEventHandler(func(context.Context, *RepoEvent) { evts++ }, false)
+
+	dir2, err := os.MkdirTemp("", "integtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs2 := testCarstore(t, dir2)
+
+	var since *string
+	ctx := context.TODO()
+	for i := 0; i < 3; i++ {
+		slice, nrev, tid, _ := appendPost(t, cs2, did, since, i)
+
+		ops := []*atproto.SyncSubscribeRepos_RepoOp{
+			{
+				Action: "create",
+				Path:   "app.bsky.feed.post/" + tid,
+			},
+		}
+
+		if err := repoman.HandleExternalUserEvent(ctx, 1, 1, did, since, nrev, slice, ops); err != nil {
+			t.Fatal(err)
+		}
+
+		since = &nrev
+	}
+	prevRev := *since
+
+	// the upstream repo moves on without us
+	var missed []string
+	for i := 3; i < 5; i++ {
+		_, nrev, tid, _ := appendPost(t, cs2, did, since, i)
+		missed = append(missed, "app.bsky.feed.post/"+tid)
+		since = &nrev
+	}
+
+	buf := new(bytes.Buffer)
+	if err := cs2.ReadUserCar(ctx, 1, "", true, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	evts = 0
+	res, err := repoman.ResyncRepo(ctx, 1, did, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.PrevRev != prevRev || res.Rev != *since {
+		t.Fatalf("unexpected revs: %s -> %s", res.PrevRev, res.Rev)
+	}
+	if len(res.Added) != 2 || len(res.Updated) != 0 || len(res.Removed) != 0 || !res.Diverged() {
+		t.Fatalf("unexpected diff: %+v", res)
+	}
+	for _, k := range missed {
+		if !slices.Contains(res.Added, k) {
+			t.Fatalf("missed record %s not reported as added", k)
+		}
+	}
+	if res.CommitBlock.Cid() != res.Root {
+		t.Fatal("commit block does not match root")
+	}
+	if evts != 0 {
+		t.Fatal("resync should not emit repo events")
+	}
+
+	rev, err := repoman.GetRepoRev(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != *since {
+		t.Fatalf("local rev not updated: %s", rev)
+	}
+
+	// and the event stream continues from the resynced state
+	slice, nrev, tid, _ := appendPost(t, cs2, did, since, 5)
+	ops := []*atproto.SyncSubscribeRepos_RepoOp{{Action: "create", Path: "app.bsky.feed.post/" + tid}}
+	if err := repoman.HandleExternalUserEvent(ctx, 1, 1, did, since, nrev, slice, ops); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyExternalUserEvent(t *testing.T) {
+	dir, err := os.MkdirTemp("", "integtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	did := "did:plc:beepboop"
+	cs := testCarstore(t, dir)
+	repoman := NewRepoManager(cs, &util.FakeKeyManager{})
+
+	var evts []*RepoEvent
+	repoman.SetEventHandler(func(ctx context.Context, evt *RepoEvent) { evts = append(evts, evt) }, false)
+
+	dir2, err := os.MkdirTemp("", "integtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs2 := testCarstore(t, dir2)
+
+	var heads []cid.Cid
+	accept := func(ctx context.Context, root cid.Cid) error {
+		heads = append(heads, root)
+		return nil
+	}
+
+	var since *string
+	var tids []string
+	ctx := context.TODO()
+	for i := 0; i < 3; i++ {
+		slice, nrev, tid, rcid := appendPost(t, cs2, did, since, i)
+		link := lexutil.LexLink(rcid)
+		ops := []*atproto.SyncSubscribeRepos_RepoOp{{Action: "create", Path: "app.bsky.feed.post/" + tid, Cid: &link}}
+
+		if err := repoman.VerifyExternalUserEvent(ctx, 1, 1, did, nil, since, nrev, slice, ops, accept); err != nil {
+			t.Fatal(err)
+		}
+
+		since = &nrev
+		tids = append(tids, tid)
+	}
+
+	if len(evts) != 3 || len(heads) != 3 {
+		t.Fatalf("expected 3 events and heads, got %d and %d", len(evts), len(heads))
+	}
+	if evts[2].NewRoot != heads[2] || evts[2].Rev != *since {
+		t.Fatal("event does not match accepted commit")
+	}
+
+	// nothing was stored locally
+	if root, err := repoman.GetRepoRoot(ctx, 1); err == nil && root.Defined() {
+		t.Fatal("expected no local repo")
+	}
+
+	slice, nrev, tid, rcid := appendPost(t, cs2, did, since, 3)
+	link := lexutil.LexLink(rcid)
+	other := lexutil.LexLink(heads[0])
+
+	bad := map[string][]*atproto.SyncSubscribeRepos_RepoOp{
+		"wrong cid":      {{Action: "create", Path: "app.bsky.feed.post/" + tid, Cid: &other}},
+		"missing record": {{Action: "create", Path: "app.bsky.feed.post/nope", Cid: &link}},
+		"delete present": {{Action: "delete", Path: "app.bsky.feed.post/" + tids[0]}},
+	}
+	for name, ops := range bad {
+		if err := repoman.VerifyExternalUserEvent(ctx, 1, 1, did, nil, since, nrev, slice, ops, accept); err == nil {
+			t.Fatalf("%s: expected verification to fail", name)
+		}
+	}
+
+	ops := []*atproto.SyncSubscribeRepos_RepoOp{{Action: "create", Path: "app.bsky.feed.post/" + tid, Cid: &link}}
+	if err := repoman.VerifyExternalUserEvent(ctx, 1, 1, "did:plc:someoneelse", nil, since, nrev, slice, ops, accept); err == nil {
+		t.Fatal("expected did mismatch to fail")
+	}
+	if err := repoman.VerifyExternalUserEvent(ctx, 1, 1, did, nil, since, "notthisrev", slice, ops, accept); err == nil {
+		t.Fatal("expected rev mismatch to fail")
+	}
+
+	if len(evts) != 3 || len(heads) != 3 {
+		t.Fatal("failed verifications should not be accepted")
+	}
+}
+
+func TestExternalUserEventValidation(t *testing.T) {
+	dir, err := os.MkdirTemp("", "integtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	did := "did:plc:beepboop"
+	cs := testCarstore(t, dir)
+	repoman := NewRepoManager(cs, &util.FakeKeyManager{})
+
+	dir2, err := os.MkdirTemp("", "integtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs2 := testCarstore(t, dir2)
+
+	var since *string
+	ctx := context.TODO()
+	for i := 0; i < 3; i++ {
+		slice, nrev, tid, rcid := appendPost(t, cs2, did, since, i)
+		link := lexutil.LexLink(rcid)
+		ops := []*atproto.SyncSubscribeRepos_RepoOp{{Action: "create", Path: "app.bsky.feed.post/" + tid, Cid: &link}}
+
+		if err := repoman.HandleExternalUserEventWithValidation(ctx, 1, 1, did, since, nrev, slice, ops, ValidateFull); err != nil {
+			t.Fatal(err)
+		}
+
+		since = &nrev
+	}
+
+	slice, nrev, tid, rcid := appendPost(t, cs2, did, since, 3)
+	link := lexutil.LexLink(rcid)
+	create := &atproto.SyncSubscribeRepos_RepoOp{Action: "create", Path: "app.bsky.feed.post/" + tid, Cid: &link}
+
+	bad := map[string][]*atproto.SyncSubscribeRepos_RepoOp{
+		"undeclared change": {},
+		"extra op":          {create, {Action: "delete", Path: "app.bsky.feed.post/nope"}},
+		"wrong action":      {{Action: "update", Path: create.Path, Cid: &link}},
+	}
+	for name, ops := range bad {
+		if err := repoman.HandleExternalUserEventWithValidation(ctx, 1, 1, did, since, nrev, slice, ops, ValidateFull); err == nil {
+			t.Fatalf("%s: expected full validation to fail", name)
+		}
+	}
+
+	if err := repoman.HandleExternalUserEventWithValidation(ctx, 1, 1, "did:plc:someoneelse", since, nrev, slice, bad["undeclared change"], ValidateNone); err == nil {
+		t.Fatal("expected did mismatch to fail without signature checks")
+	}
+
+	// the default level only checks the signature
+	if err := repoman.HandleExternalUserEventWithValidation(ctx, 1, 1, did, since, nrev, slice, bad["undeclared change"], ValidateSignature); err != nil {
+		t.Fatal(err)
+	}
+
+	rev, err := repoman.GetRepoRev(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != nrev {
+		t.Fatalf("expected repo at rev %s, got %s", nrev, rev)
+	}
+}
+
+// appendPost is like doPost, but adds to the existing repo rather than replacing it
+func appendPost(t *testing.T, cs *carstore.CarStore, did string, prev *string, postid int) ([]byte, string, string, cid.Cid) {
+	ctx := context.TODO()
+	ds, err := cs.NewDeltaSession(ctx, 1, prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r *repo.Repo
+	if prev == nil {
+		r = repo.NewRepo(ctx, did, ds)
+	} else {
+		head, err := cs.GetUserRepoHead(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err = repo.OpenRepo(ctx, ds, head)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rcid, tid, err := r.CreateRecord(ctx, "app.bsky.feed.post", &bsky.FeedPost{
+		Text: fmt.Sprintf("hello friend %d", postid),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, nrev, err := r.Commit(ctx, func(context.Context, string, []byte) ([]byte, error) { return nil, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slice, err := ds.CloseWithRoot(ctx, root, nrev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return slice, nrev, tid, rcid
+}
+
 func doPost(t *testing.T, cs *carstore.CarStore, did string, prev *string, postid int) ([]byte, cid.Cid, string, string) {
 	ctx := context.TODO()
 	ds, err := cs.NewDeltaSession(ctx, 1, prev)
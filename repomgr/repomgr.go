@@ -365,7 +365,7 @@ func (rm *RepoManager) InitNewActor(ctx context.Context, user models.Uid, handle
 		DisplayName: &displayname,
 	}
 
-	_, err = r.PutRecord(ctx, "app.bsky.actor.profile/self", profile)
+	pcid, err := r.PutRecord(ctx, "app.bsky.actor.profile/self", profile)
 	if err != nil {
 		return fmt.Errorf("setting initial actor profile: %w", err)
 	}
@@ -385,6 +385,7 @@ func (rm *RepoManager) InitNewActor(ctx context.Context, user models.Uid, handle
 			Kind:       EvtKindCreateRecord,
 			Collection: "app.bsky.actor.profile",
 			Rkey:       "self",
+			RecCid:     &pcid,
 		}
 
 		if rm.hydrateRecords {
@@ -410,6 +411,11 @@ func (rm *RepoManager) GetRepoRoot(ctx context.Context, user models.Uid) (cid.Ci
 	return rm.cs.GetUserRepoHead(ctx, user)
 }
 
+// GetRepoHeads returns the current root and rev of each of the given repos which has any stored data
+func (rm *RepoManager) GetRepoHeads(ctx context.Context, users []models.Uid) (map[models.Uid]carstore.UserRepoHead, error) {
+	return rm.cs.GetUserRepoHeads(ctx, users)
+}
+
 func (rm *RepoManager) GetRepoRev(ctx context.Context, user models.Uid) (string, error) {
 	unlock := rm.lockUser(ctx, user)
 	defer unlock()
@@ -475,6 +481,33 @@ func (rm *RepoManager) GetRecordProof(ctx context.Context, user models.Uid, coll
 	return head, bs.GetLoggedBlocks(), nil
 }
 
+// GetBlocks reads blocks from a user's repo, returning the CIDs of any which were not found
+func (rm *RepoManager) GetBlocks(ctx context.Context, user models.Uid, cids []cid.Cid) ([]blocks.Block, []cid.Cid, error) {
+	ctx, span := otel.Tracer("repoman").Start(ctx, "GetBlocks")
+	defer span.End()
+
+	bs, err := rm.cs.ReadOnlySession(user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var out []blocks.Block
+	var missing []cid.Cid
+	for _, c := range cids {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			if ipld.IsNotFound(err) {
+				missing = append(missing, c)
+				continue
+			}
+			return nil, nil, err
+		}
+		out = append(out, blk)
+	}
+
+	return out, missing, nil
+}
+
 func (rm *RepoManager) GetProfile(ctx context.Context, uid models.Uid) (*bsky.ActorProfile, error) {
 	bs, err := rm.cs.ReadOnlySession(uid)
 	if err != nil {
@@ -527,11 +560,28 @@ func (rm *RepoManager) CheckRepoSig(ctx context.Context, r *repo.Repo, expdid st
 	return nil
 }
 
+// ValidationLevel controls how thoroughly commits from other hosts are checked before being applied
+type ValidationLevel int
+
+const (
+	// Check the commit signature
+	ValidateSignature ValidationLevel = iota
+	// Trust the host, and apply commits without checking signatures (the commit must still be for the right DID)
+	ValidateNone
+	// Check the signature, that the event's rev matches the signed commit, and that the event's ops account for exactly the changes to the MST
+	ValidateFull
+)
+
 func (rm *RepoManager) HandleExternalUserEvent(ctx context.Context, pdsid uint, uid models.Uid, did string, since *string, nrev string, carslice []byte, ops []*atproto.SyncSubscribeRepos_RepoOp) error {
+	return rm.HandleExternalUserEventWithValidation(ctx, pdsid, uid, did, since, nrev, carslice, ops, ValidateSignature)
+}
+
+// HandleExternalUserEventWithValidation applies a commit event from another host, as HandleExternalUserEvent, checking it at the given level
+func (rm *RepoManager) HandleExternalUserEventWithValidation(ctx context.Context, pdsid uint, uid models.Uid, did string, since *string, nrev string, carslice []byte, ops []*atproto.SyncSubscribeRepos_RepoOp, level ValidationLevel) error {
 	ctx, span := otel.Tracer("repoman").Start(ctx, "HandleExternalUserEvent")
 	defer span.End()
 
-	span.SetAttributes(attribute.Int64("uid", int64(uid)))
+	span.SetAttributes(attribute.Int64("uid", int64(uid)), attribute.Int("validation", int(level)))
 
 	log.Debugw("HandleExternalUserEvent", "pds", pdsid, "uid", uid, "since", since, "nrev", nrev)
 
@@ -548,10 +598,32 @@ func (rm *RepoManager) HandleExternalUserEvent(ctx context.Context, pdsid uint,
 		return fmt.Errorf("opening external user repo (%d, root=%s): %w", uid, root, err)
 	}
 
-	if err := rm.CheckRepoSig(ctx, r, did); err != nil {
+	if level == ValidateNone {
+		// the signature check is the expensive part, and covers this too
+		if repoDid := r.RepoDid(); repoDid != did {
+			return fmt.Errorf("DID in repo did not match (%q != %q)", did, repoDid)
+		}
+	} else if err := rm.CheckRepoSig(ctx, r, did); err != nil {
 		return err
 	}
 
+	if level == ValidateFull {
+		if err := verifyCommitOps(ctx, r, nrev, ops); err != nil {
+			return err
+		}
+
+		// with a previous commit to compare against, the ops must also be the only changes made
+		if ds.BaseCid().Defined() {
+			diff, err := r.DiffSince(ctx, ds.BaseCid())
+			if err != nil {
+				return fmt.Errorf("failed to diff against previous commit: %w", err)
+			}
+			if err := diffMatchesOps(diff, ops); err != nil {
+				return err
+			}
+		}
+	}
+
 	var skipcids map[cid.Cid]bool
 	if ds.BaseCid().Defined() {
 		oldrepo, err := repo.OpenRepo(ctx, ds, ds.BaseCid())
@@ -576,12 +648,40 @@ func (rm *RepoManager) HandleExternalUserEvent(ctx context.Context, pdsid uint,
 
 	}
 
+	evtops, err := rm.externalOps(ctx, r, ops)
+	if err != nil {
+		return err
+	}
+
+	rslice, err := ds.CloseWithRoot(ctx, root, nrev)
+	if err != nil {
+		return fmt.Errorf("close with root: %w", err)
+	}
+
+	if rm.events != nil {
+		rm.events(ctx, &RepoEvent{
+			User: uid,
+			//OldRoot:   prev,
+			NewRoot:   root,
+			Rev:       nrev,
+			Since:     since,
+			Ops:       evtops,
+			RepoSlice: rslice,
+			PDS:       pdsid,
+		})
+	}
+
+	return nil
+}
+
+// externalOps converts the ops of a commit event into RepoOps, with records hydrated from r if configured
+func (rm *RepoManager) externalOps(ctx context.Context, r *repo.Repo, ops []*atproto.SyncSubscribeRepos_RepoOp) ([]RepoOp, error) {
 	evtops := make([]RepoOp, 0, len(ops))
 
 	for _, op := range ops {
 		parts := strings.SplitN(op.Path, "/", 2)
 		if len(parts) != 2 {
-			return fmt.Errorf("invalid rpath in mst diff, must have collection and rkey")
+			return nil, fmt.Errorf("invalid rpath in mst diff, must have collection and rkey")
 		}
 
 		switch EventKind(op.Action) {
@@ -596,7 +696,7 @@ func (rm *RepoManager) HandleExternalUserEvent(ctx context.Context, pdsid uint,
 			if rm.hydrateRecords {
 				_, rec, err := r.GetRecord(ctx, op.Path)
 				if err != nil {
-					return fmt.Errorf("reading changed record from car slice: %w", err)
+					return nil, fmt.Errorf("reading changed record from car slice: %w", err)
 				}
 				rop.Record = rec
 			}
@@ -613,7 +713,7 @@ func (rm *RepoManager) HandleExternalUserEvent(ctx context.Context, pdsid uint,
 			if rm.hydrateRecords {
 				_, rec, err := r.GetRecord(ctx, op.Path)
 				if err != nil {
-					return fmt.Errorf("reading changed record from car slice: %w", err)
+					return nil, fmt.Errorf("reading changed record from car slice: %w", err)
 				}
 
 				rop.Record = rec
@@ -627,24 +727,63 @@ func (rm *RepoManager) HandleExternalUserEvent(ctx context.Context, pdsid uint,
 				Rkey:       parts[1],
 			})
 		default:
-			return fmt.Errorf("unrecognized external user event kind: %q", op.Action)
+			return nil, fmt.Errorf("unrecognized external user event kind: %q", op.Action)
 		}
 	}
 
-	rslice, err := ds.CloseWithRoot(ctx, root, nrev)
+	return evtops, nil
+}
+
+// VerifyExternalUserEvent checks a commit event from another host using only the blocks carried in the event, without reading or writing the carstore: the commit must be signed by the account, and every op must be proven by the included MST nodes (created and updated records present with the given CID, deleted records absent). If it checks out, accept is called with the new commit, and if that succeeds the event is passed to the event handler as if it had been applied.
+//
+// This is for relays which do not archive repos. prev is the last commit seen for the account, if known.
+func (rm *RepoManager) VerifyExternalUserEvent(ctx context.Context, pdsid uint, uid models.Uid, did string, prev *cid.Cid, since *string, nrev string, carslice []byte, ops []*atproto.SyncSubscribeRepos_RepoOp, accept func(ctx context.Context, root cid.Cid) error) error {
+	ctx, span := otel.Tracer("repoman").Start(ctx, "VerifyExternalUserEvent")
+	defer span.End()
+
+	span.SetAttributes(attribute.Int64("uid", int64(uid)))
+
+	if len(carslice) == 0 {
+		return fmt.Errorf("commit event has no blocks to verify")
+	}
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	root, err := repo.IngestRepo(ctx, bs, bytes.NewReader(carslice))
 	if err != nil {
-		return fmt.Errorf("close with root: %w", err)
+		return fmt.Errorf("reading external carslice: %w", err)
+	}
+
+	r, err := repo.OpenRepo(ctx, bs, root)
+	if err != nil {
+		return fmt.Errorf("opening external user commit (%d, root=%s): %w", uid, root, err)
+	}
+
+	if err := rm.CheckRepoSig(ctx, r, did); err != nil {
+		return err
+	}
+
+	if err := verifyCommitOps(ctx, r, nrev, ops); err != nil {
+		return err
+	}
+
+	evtops, err := rm.externalOps(ctx, r, ops)
+	if err != nil {
+		return err
+	}
+
+	if err := accept(ctx, root); err != nil {
+		return err
 	}
 
 	if rm.events != nil {
 		rm.events(ctx, &RepoEvent{
-			User: uid,
-			//OldRoot:   prev,
+			User:      uid,
+			OldRoot:   prev,
 			NewRoot:   root,
 			Rev:       nrev,
 			Since:     since,
 			Ops:       evtops,
-			RepoSlice: rslice,
+			RepoSlice: carslice,
 			PDS:       pdsid,
 		})
 	}
@@ -652,6 +791,66 @@ func (rm *RepoManager) HandleExternalUserEvent(ctx context.Context, pdsid uint,
 	return nil
 }
 
+// verifyCommitOps checks a commit event's rev and ops against the signed commit: created and updated records must be present in the MST with the given CID, and deleted records absent
+func verifyCommitOps(ctx context.Context, r *repo.Repo, nrev string, ops []*atproto.SyncSubscribeRepos_RepoOp) error {
+	if rev := r.SignedCommit().Rev; rev != nrev {
+		return fmt.Errorf("event rev did not match signed commit (%q != %q)", nrev, rev)
+	}
+
+	for _, op := range ops {
+		cc, err := r.GetRecordCid(ctx, op.Path)
+		switch EventKind(op.Action) {
+		case EvtKindCreateRecord, EvtKindUpdateRecord:
+			if err != nil {
+				return fmt.Errorf("proving %s of %q: %w", op.Action, op.Path, err)
+			}
+			if op.Cid == nil || cc != cid.Cid(*op.Cid) {
+				return fmt.Errorf("record cid for %q did not match commit (%s)", op.Path, cc)
+			}
+		case EvtKindDeleteRecord:
+			if err == nil {
+				return fmt.Errorf("deleted record %q still present in commit", op.Path)
+			}
+			if !errors.Is(err, mst.ErrNotFound) {
+				return fmt.Errorf("proving delete of %q: %w", op.Path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// diffMatchesOps checks that a commit event's ops describe every change in the MST diff from the previous commit, and nothing else
+func diffMatchesOps(diff []*mst.DiffOp, ops []*atproto.SyncSubscribeRepos_RepoOp) error {
+	kinds := map[string]EventKind{
+		"add": EvtKindCreateRecord,
+		"mut": EvtKindUpdateRecord,
+		"del": EvtKindDeleteRecord,
+	}
+
+	changed := make(map[string]*mst.DiffOp, len(diff))
+	for _, d := range diff {
+		changed[d.Rpath] = d
+	}
+
+	for _, op := range ops {
+		d, ok := changed[op.Path]
+		if !ok {
+			return fmt.Errorf("op %s of %q is not a change in the commit", op.Action, op.Path)
+		}
+		if kinds[d.Op] != EventKind(op.Action) {
+			return fmt.Errorf("op %s of %q does not match change in the commit (%s)", op.Action, op.Path, d.Op)
+		}
+		delete(changed, op.Path)
+	}
+
+	for rpath, d := range changed {
+		return fmt.Errorf("commit changes %q (%s) without a corresponding op", rpath, d.Op)
+	}
+
+	return nil
+}
+
 func rkeyForCollection(collection string) string {
 	return repo.NextTID()
 }
@@ -865,6 +1064,134 @@ func (rm *RepoManager) ImportNewRepo(ctx context.Context, user models.Uid, repoD
 	return nil
 }
 
+// ResyncResult describes how a resynced repo differed from our local copy
+type ResyncResult struct {
+	Root cid.Cid
+	Rev  string
+	// PrevRev is our local rev before the resync, empty if we had no data
+	PrevRev string
+	// LocalUnreadable is set if our local copy of the repo could not be read (eg, due to missing blocks), in which case every record counts as added
+	LocalUnreadable bool
+
+	Added   []string
+	Updated []string
+	Removed []string
+
+	// The signed commit block of the new repo state
+	CommitBlock blocks.Block
+}
+
+// Diverged reports whether the local copy differed from the fetched repo
+func (rr *ResyncResult) Diverged() bool {
+	return rr.LocalUnreadable || len(rr.Added) > 0 || len(rr.Updated) > 0 || len(rr.Removed) > 0
+}
+
+// ResyncRepo replaces our copy of a repo with a complete CAR fetched from its host, reporting which records differed. Unlike ImportNewRepo, no repo event is emitted for the differences; the caller is expected to announce the new state (eg, with a #sync event).
+func (rm *RepoManager) ResyncRepo(ctx context.Context, user models.Uid, repoDid string, r io.Reader) (*ResyncResult, error) {
+	ctx, span := otel.Tracer("repoman").Start(ctx, "ResyncRepo")
+	defer span.End()
+
+	unlock := rm.lockUser(ctx, user)
+	defer unlock()
+
+	out := &ResyncResult{}
+
+	currev, err := rm.cs.GetUserRepoRev(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	out.PrevRev = currev
+
+	curhead, err := rm.cs.GetUserRepoHead(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	local := make(map[string]cid.Cid)
+	if curhead.Defined() {
+		if err := rm.readRecordCids(ctx, user, curhead, local); err != nil {
+			log.Warnw("local copy of repo unreadable during resync", "did", repoDid, "err", err)
+			out.LocalUnreadable = true
+			local = make(map[string]cid.Cid)
+		}
+	}
+
+	err = rm.processNewRepo(ctx, user, r, nil, func(ctx context.Context, root cid.Cid, finish func(context.Context, string) ([]byte, error), bs blockstore.Blockstore) error {
+		nr, err := repo.OpenRepo(ctx, bs, root)
+		if err != nil {
+			return fmt.Errorf("opening fetched repo: %w", err)
+		}
+
+		scom := nr.SignedCommit()
+		if scom.Did != repoDid {
+			return fmt.Errorf("fetched repo is for the wrong did (%s != %s)", scom.Did, repoDid)
+		}
+
+		usc := scom.Unsigned()
+		sb, err := usc.BytesForSigning()
+		if err != nil {
+			return fmt.Errorf("commit serialization failed: %w", err)
+		}
+		if err := rm.kmgr.VerifyUserSignature(ctx, repoDid, scom.Sig, sb); err != nil {
+			return fmt.Errorf("fetched repo signature check failed: %w", err)
+		}
+
+		if err := nr.ForEach(ctx, "", func(k string, v cid.Cid) error {
+			old, ok := local[k]
+			switch {
+			case !ok:
+				out.Added = append(out.Added, k)
+			case old != v:
+				out.Updated = append(out.Updated, k)
+			}
+			delete(local, k)
+			return nil
+		}); err != nil {
+			return fmt.Errorf("reading fetched repo: %w", err)
+		}
+
+		for k := range local {
+			out.Removed = append(out.Removed, k)
+		}
+
+		out.CommitBlock, err = bs.Get(ctx, root)
+		if err != nil {
+			return err
+		}
+
+		if _, err := finish(ctx, scom.Rev); err != nil {
+			return err
+		}
+
+		out.Root = root
+		out.Rev = scom.Rev
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("resync repo (current rev: %s): %w", currev, err)
+	}
+
+	return out, nil
+}
+
+// readRecordCids collects the record paths and cids of a repo from the carstore
+func (rm *RepoManager) readRecordCids(ctx context.Context, user models.Uid, root cid.Cid, into map[string]cid.Cid) error {
+	ses, err := rm.cs.ReadOnlySession(user)
+	if err != nil {
+		return err
+	}
+
+	r, err := repo.OpenRepo(ctx, ses, root)
+	if err != nil {
+		return err
+	}
+
+	return r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+		into[k] = v
+		return nil
+	})
+}
+
 func processOp(ctx context.Context, bs blockstore.Blockstore, op *mst.DiffOp, hydrateRecords bool) (*RepoOp, error) {
 	parts := strings.SplitN(op.Rpath, "/", 2)
 	if len(parts) != 2 {
@@ -1049,6 +1376,14 @@ func (rm *RepoManager) TakeDownRepo(ctx context.Context, uid models.Uid) error {
 	return rm.cs.WipeUserData(ctx, uid)
 }
 
+// PurgeRepo permanently deletes everything stored for a repo, returning the number of carstore shards removed
+func (rm *RepoManager) PurgeRepo(ctx context.Context, uid models.Uid) (int, error) {
+	unlock := rm.lockUser(ctx, uid)
+	defer unlock()
+
+	return rm.cs.PurgeUserData(ctx, uid)
+}
+
 // technically identical to TakeDownRepo, for now
 func (rm *RepoManager) ResetRepo(ctx context.Context, uid models.Uid) error {
 	unlock := rm.lockUser(ctx, uid)
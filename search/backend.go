@@ -0,0 +1,130 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Backend is the search engine which documents are indexed into and queried from. Documents and queries are JSON, with queries in the OpenSearch (Elasticsearch-compatible) query DSL.
+type Backend interface {
+	// IndexExists reports whether the named index has been created
+	IndexExists(ctx context.Context, index string) (bool, error)
+	// CreateIndex creates an index with the given settings and mappings (see post_schema.json)
+	CreateIndex(ctx context.Context, index string, schemaJSON string) error
+	// Bulk applies a batch of operations to an index. It fails if any operation fails, with a *BulkError if only some did
+	Bulk(ctx context.Context, index string, ops []BulkOp) error
+	// Update applies a partial update or script (body) to a single document
+	Update(ctx context.Context, index string, docID string, body []byte) error
+	// Delete removes a single document, and doesn't fail if it didn't exist
+	Delete(ctx context.Context, index string, docID string) error
+	// Refresh makes recent changes to an index visible to searches
+	Refresh(ctx context.Context, index string) error
+	Search(ctx context.Context, index string, query []byte) (*EsSearchResponse, error)
+	// Count returns the number of documents in an index
+	Count(ctx context.Context, index string) (int64, error)
+	// DeleteIndex drops an index and all its documents, and doesn't fail if it didn't exist
+	DeleteIndex(ctx context.Context, index string) error
+	// AliasIndices returns the indices an alias points to, or nil if there is no such alias
+	AliasIndices(ctx context.Context, alias string) ([]string, error)
+	// SwapAlias atomically points an alias at a single index, removing it from any others. If a concrete index has the alias's name (eg, one created before palomar used aliases), that index is deleted in the same operation
+	SwapAlias(ctx context.Context, alias, index string) error
+	// Health fails if the cluster can't be reached, or can't serve all of its data
+	Health(ctx context.Context) error
+}
+
+// Bulk operation actions
+const (
+	BulkIndex  = "index"
+	BulkUpdate = "update"
+	BulkCreate = "create"
+)
+
+// BulkOp is a single operation in a Backend.Bulk request
+type BulkOp struct {
+	// BulkIndex (create or replace a document), BulkUpdate (update an existing document by script), or BulkCreate (create a document only if it doesn't already exist; existing documents are not an error)
+	Action string
+	DocID  string
+	Body   []byte
+}
+
+// BulkError is returned by Backend.Bulk when some operations in a request failed. The other operations were applied
+type BulkError struct {
+	Total    int
+	Failures []BulkFailure
+}
+
+// BulkFailure is a single failed operation in a bulk request
+type BulkFailure struct {
+	// Position of the operation in the request
+	Op     int
+	DocID  string
+	Status int
+	Reason string
+}
+
+func (e *BulkError) Error() string {
+	if len(e.Failures) == 0 {
+		return fmt.Sprintf("0 of %d bulk operations failed", e.Total)
+	}
+	first := e.Failures[0]
+	return fmt.Sprintf("%d of %d bulk operations failed, first: %s (%d): %s", len(e.Failures), e.Total, first.DocID, first.Status, first.Reason)
+}
+
+// Retryable reports whether the operation may succeed if tried again: the cluster was overloaded (429) or had an internal error, rather than rejecting the document
+func (f BulkFailure) Retryable() bool {
+	return f.Status == http.StatusTooManyRequests || f.Status >= 500
+}
+
+// StatusError is an error response from the search backend to an entire request
+type StatusError struct {
+	Op     string
+	Status int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s error, code=%d", e.Op, e.Status)
+}
+
+// Retryable reports whether the request may succeed if tried again (see BulkFailure.Retryable)
+func (e *StatusError) Retryable() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+type indexSchema struct {
+	Name       string
+	SchemaJSON string
+}
+
+// versionedIndexName is the name of a new concrete index behind an alias, unique to when it was created
+func versionedIndexName(alias string, now time.Time) string {
+	return alias + "_" + now.UTC().Format("20060102150405")
+}
+
+// ensureIndices creates any of the given indices which don't already exist. Each is created as a versioned index behind an alias with the configured name, so that it can later be rebuilt without downtime (see Indexer.StartReindex)
+func ensureIndices(ctx context.Context, backend Backend, logger *slog.Logger, indices []indexSchema) error {
+	for _, index := range indices {
+		exists, err := backend.IndexExists(ctx, index.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		if len(index.SchemaJSON) < 2 {
+			return fmt.Errorf("empty schema file (go:embed failed)")
+		}
+		versioned := versionedIndexName(index.Name, time.Now())
+		logger.Warn("creating search index", "alias", index.Name, "index", versioned)
+		if err := backend.CreateIndex(ctx, versioned, index.SchemaJSON); err != nil {
+			return err
+		}
+		if err := backend.SwapAlias(ctx, index.Name, versioned); err != nil {
+			return err
+		}
+	}
+	return nil
+}
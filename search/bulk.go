@@ -24,6 +24,8 @@ type pagerankJob struct {
 }
 
 // BulkIndexPageranks updates the pageranks for the DIDs in the Search Index from a CSV file.
+//
+// Lines are formatted as `did,pagerank`, optionally followed by `,followers` (an approximate follower count, used to rank typeahead results).
 func (idx *Indexer) BulkIndexPageranks(ctx context.Context, pagerankFile string) error {
 	f, err := os.Open(pagerankFile)
 	if err != nil {
@@ -212,9 +214,9 @@ func (idx *Indexer) BulkIndexProfiles(ctx context.Context, profilesFile string)
 }
 
 func (idx *Indexer) processPagerankCSVLine(line string) error {
-	// Split the line into DID and rank
+	// Split the line into DID, rank, and optional follower count
 	parts := strings.Split(line, ",")
-	if len(parts) != 2 {
+	if len(parts) != 2 && len(parts) != 3 {
 		return fmt.Errorf("invalid pagerank line: %s", line)
 	}
 
@@ -232,6 +234,13 @@ func (idx *Indexer) processPagerankCSVLine(line string) error {
 		did:  did,
 		rank: rank,
 	}
+	if len(parts) == 3 {
+		followers, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || followers < 0 {
+			return fmt.Errorf("invalid follower count: %s", parts[2])
+		}
+		job.followers = &followers
+	}
 
 	// Send the job to the pagerank queue
 	idx.pagerankQueue <- &job
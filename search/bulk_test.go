@@ -0,0 +1,27 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessPagerankCSVLine(t *testing.T) {
+	assert := assert.New(t)
+
+	idx := &Indexer{pagerankQueue: make(chan *PagerankIndexJob, 2)}
+
+	assert.NoError(idx.processPagerankCSVLine("did:plc:abc,0.25"))
+	job := <-idx.pagerankQueue
+	assert.Equal(0.25, job.rank)
+	assert.Nil(job.followers)
+
+	assert.NoError(idx.processPagerankCSVLine("did:plc:abc,0.25,1200"))
+	job = <-idx.pagerankQueue
+	if assert.NotNil(job.followers) {
+		assert.Equal(int64(1200), *job.followers)
+	}
+
+	assert.Error(idx.processPagerankCSVLine("did:plc:abc,0.25,many"))
+	assert.Error(idx.processPagerankCSVLine("did:plc:abc,0.25,1,2"))
+}
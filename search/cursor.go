@@ -0,0 +1,93 @@
+package search
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Max offset (from + size) for offset-based pagination. This is the backend's default `index.max_result_window`
+const maxResultWindow = 10000
+
+// searchCursor is a parsed pagination cursor.
+//
+// Cursors returned by the search endpoints are opaque: the sort values of the last hit on the previous page, for a `search_after` query, encoded as URL-safe base64 of the JSON array. Plain integer cursors (from older clients, or endpoints which still paginate by offset) are treated as an offset.
+type searchCursor struct {
+	Offset      int
+	SearchAfter []json.RawMessage
+}
+
+func parseSearchCursor(raw string) (*searchCursor, error) {
+	if raw == "" {
+		return &searchCursor{}, nil
+	}
+	if offset, err := strconv.Atoi(raw); err == nil {
+		if offset < 0 {
+			offset = 0
+		}
+		if offset > maxResultWindow {
+			return nil, fmt.Errorf("can't paginate so deep by offset")
+		}
+		return &searchCursor{Offset: offset}, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	var after []json.RawMessage
+	if err := json.Unmarshal(b, &after); err != nil || len(after) == 0 {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	return &searchCursor{SearchAfter: after}, nil
+}
+
+func encodeSearchAfterCursor(sort []json.RawMessage) (string, error) {
+	b, err := json.Marshal(sort)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// nextPageCursor returns the cursor for the page following hits, or nil if this was the last page.
+//
+// If the hits carry sort values (ie, the query had a "sort"), a `search_after` cursor is returned; otherwise falls back to an offset cursor, within the backend's result window.
+func nextPageCursor(hits []EsSearchHit, size, offset int) *string {
+	if len(hits) == 0 || len(hits) < size {
+		return nil
+	}
+	if last := hits[len(hits)-1]; len(last.Sort) > 0 {
+		if s, err := encodeSearchAfterCursor(last.Sort); err == nil {
+			return &s
+		}
+	}
+	return nextOffsetCursor(len(hits), size, offset)
+}
+
+// nextOffsetCursor returns an offset cursor for the next page, or nil if this was the last page or the next would be past the backend's result window
+func nextOffsetCursor(count, size, offset int) *string {
+	if count < size || offset+size >= maxResultWindow {
+		return nil
+	}
+	s := strconv.Itoa(offset + size)
+	return &s
+}
+
+// setPagination adds either "search_after" or "from" to a query body, depending on the cursor
+func setPagination(query map[string]interface{}, offset int, searchAfter []json.RawMessage) {
+	if len(searchAfter) > 0 {
+		query["search_after"] = searchAfter
+		return
+	}
+	query["from"] = offset
+}
+
+// sortKeyTiebreaker is the final sort clause for paginated queries, so that the order of hits (and thus `search_after`) is stable. Indices created before the sort_key field was added don't have it mapped, hence the unmapped_type.
+var sortKeyTiebreaker = map[string]any{
+	"sort_key": map[string]any{
+		"order":         "asc",
+		"unmapped_type": "keyword",
+	},
+}
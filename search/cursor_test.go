@@ -0,0 +1,99 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchCursor(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := parseSearchCursor("")
+	assert.NoError(err)
+	assert.Equal(0, c.Offset)
+	assert.Nil(c.SearchAfter)
+
+	// older offset cursors are still accepted
+	c, err = parseSearchCursor("50")
+	assert.NoError(err)
+	assert.Equal(50, c.Offset)
+	assert.Nil(c.SearchAfter)
+
+	_, err = parseSearchCursor("10001")
+	assert.Error(err)
+
+	sort := []json.RawMessage{json.RawMessage(`1704067200000`), json.RawMessage(`"did:plc:abc_3k4duaz5vfs2b"`)}
+	s, err := encodeSearchAfterCursor(sort)
+	assert.NoError(err)
+	c, err = parseSearchCursor(s)
+	assert.NoError(err)
+	assert.Equal(0, c.Offset)
+	assert.Equal(sort, c.SearchAfter)
+
+	for _, bad := range []string{"not a cursor!", "e30", "W10"} {
+		_, err = parseSearchCursor(bad)
+		assert.Error(err, bad)
+	}
+}
+
+func TestNextPageCursor(t *testing.T) {
+	assert := assert.New(t)
+
+	sorted := []EsSearchHit{
+		{ID: "a", Sort: []json.RawMessage{json.RawMessage(`2.5`), json.RawMessage(`"a"`)}},
+		{ID: "b", Sort: []json.RawMessage{json.RawMessage(`1.5`), json.RawMessage(`"b"`)}},
+	}
+	s := nextPageCursor(sorted, 2, 0)
+	if assert.NotNil(s) {
+		c, err := parseSearchCursor(*s)
+		assert.NoError(err)
+		assert.Equal(sorted[1].Sort, c.SearchAfter)
+	}
+
+	// short page means no more results
+	assert.Nil(nextPageCursor(sorted, 3, 0))
+
+	// unsorted hits fall back to offsets, within the result window
+	unsorted := []EsSearchHit{{ID: "a"}, {ID: "b"}}
+	s = nextPageCursor(unsorted, 2, 4)
+	if assert.NotNil(s) {
+		assert.Equal("6", *s)
+	}
+	assert.Nil(nextPageCursor(unsorted, 2, maxResultWindow-2))
+}
+
+func TestSearchAfterQuery(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var body map[string]any
+	backend := testOpenSearchBackend(t, map[string]http.HandlerFunc{
+		"/palomar_record/_search": func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			body = nil
+			json.Unmarshal(b, &body)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"hits":{"total":{"value":1,"relation":"eq"},"hits":[
+				{"_id":"x","_score":1.2,"_source":{},"sort":[1.2,"did:plc:abc_app.bsky.feed.generator_x"]}
+			]}}`)
+		},
+	})
+
+	params := RecordSearchParams{Query: "cats", RecordType: RecordTypeFeed, Offset: 20, Size: 1}
+	resp, err := DoSearchRecords(ctx, backend, "palomar_record", &params)
+	assert.NoError(err)
+	assert.Equal(float64(20), body["from"])
+	assert.NotContains(body, "search_after")
+	assert.Len(resp.Hits.Hits[0].Sort, 2)
+
+	params.SearchAfter = resp.Hits.Hits[0].Sort
+	_, err = DoSearchRecords(ctx, backend, "palomar_record", &params)
+	assert.NoError(err)
+	assert.NotContains(body, "from")
+	assert.Equal([]any{1.2, "did:plc:abc_app.bsky.feed.generator_x"}, body["search_after"])
+}
@@ -0,0 +1,226 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Embedder computes dense vector embeddings of text, for semantic (kNN) search of posts. Implementations must return vectors of Dimensions() length, in the same order as the input texts
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	Dimensions() int
+}
+
+// Max number of texts sent to an Embedder in a single call
+const embedBatchSize = 64
+
+// Number of nearest neighbors retrieved (per shard) by the kNN half of a hybrid post query, if the page being fetched isn't deeper than this
+const semanticMinK = 100
+
+type HTTPEmbedderConfig struct {
+	// URL of an embeddings endpoint with an OpenAI-compatible request/response format (eg, "https://host/v1/embeddings")
+	URL string
+	// Model name passed in requests. Optional for services which only serve one model
+	Model string
+	// Optional bearer token
+	APIKey string
+	// Length of the vectors returned by the model
+	Dimensions int
+	Timeout    time.Duration
+}
+
+// HTTPEmbedder is an Embedder backed by an HTTP embeddings service. Most self-hosted model servers, as well as hosted APIs, implement the OpenAI-compatible `/v1/embeddings` format
+type HTTPEmbedder struct {
+	config HTTPEmbedderConfig
+	client *http.Client
+}
+
+var _ Embedder = (*HTTPEmbedder)(nil)
+
+func NewHTTPEmbedder(config HTTPEmbedderConfig) (*HTTPEmbedder, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("embedding service URL is required")
+	}
+	if config.Dimensions <= 0 {
+		return nil, fmt.Errorf("embedding dimensions must be positive")
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	return &HTTPEmbedder{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}, nil
+}
+
+func (e *HTTPEmbedder) Dimensions() int {
+	return e.config.Dimensions
+}
+
+type embeddingsRequest struct {
+	Model string   `json:"model,omitempty"`
+	Input []string `json:"input"`
+}
+
+type embeddingsResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (e *HTTPEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	ctx, span := tracer.Start(ctx, "Embed")
+	defer span.End()
+
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	body, err := json.Marshal(embeddingsRequest{Model: e.config.Model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", e.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.config.APIKey)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embedding request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("embedding request failed, code=%d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var out embeddingsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decoding embedding response: %w", err)
+	}
+	if len(out.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding response has %d vectors for %d texts", len(out.Data), len(texts))
+	}
+	vecs := make([][]float32, len(texts))
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding response has out of range index: %d", d.Index)
+		}
+		if len(d.Embedding) != e.config.Dimensions {
+			return nil, fmt.Errorf("embedding has %d dimensions, expected %d", len(d.Embedding), e.config.Dimensions)
+		}
+		vecs[d.Index] = d.Embedding
+	}
+	return vecs, nil
+}
+
+// postEmbeddingText is the text of a post which is embedded: the post text along with alt text and any link card
+func postEmbeddingText(doc *PostDoc) string {
+	texts := append([]string{doc.Text}, doc.EmbedImgAltText...)
+	if doc.EmbedLinkTitle != nil {
+		texts = append(texts, *doc.EmbedLinkTitle)
+	}
+	if doc.EmbedLinkDesc != nil {
+		texts = append(texts, *doc.EmbedLinkDesc)
+	}
+	parts := make([]string, 0, len(texts))
+	for _, text := range texts {
+		if t := strings.TrimSpace(text); t != "" {
+			parts = append(parts, t)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// embedPosts sets the Embedding of each post doc which has any text, calling the embedder in batches
+func embedPosts(ctx context.Context, embedder Embedder, docs []*PostDoc) error {
+	var pending []*PostDoc
+	var texts []string
+	flush := func() error {
+		if len(texts) == 0 {
+			return nil
+		}
+		vecs, err := embedder.Embed(ctx, texts)
+		if err != nil {
+			embeddingsFailed.Add(float64(len(texts)))
+			return err
+		}
+		for i, doc := range pending {
+			doc.Embedding = vecs[i]
+		}
+		postsEmbedded.Add(float64(len(texts)))
+		pending, texts = pending[:0], texts[:0]
+		return nil
+	}
+
+	for _, doc := range docs {
+		text := postEmbeddingText(doc)
+		if text == "" {
+			continue
+		}
+		pending = append(pending, doc)
+		texts = append(texts, text)
+		if len(texts) >= embedBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// postSchemaJSON returns the post index schema, with a kNN vector field for embeddings if an embedder is configured
+func postSchemaJSON(embedder Embedder) (string, error) {
+	if embedder == nil {
+		return palomarPostSchemaJSON, nil
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(palomarPostSchemaJSON), &schema); err != nil {
+		return "", fmt.Errorf("parsing post schema: %w", err)
+	}
+	settings, ok := schema["settings"].(map[string]any)
+	if !ok {
+		return "", fmt.Errorf("post schema missing settings")
+	}
+	indexSettings, ok := settings["index"].(map[string]any)
+	if !ok {
+		return "", fmt.Errorf("post schema missing index settings")
+	}
+	mappings, ok := schema["mappings"].(map[string]any)
+	if !ok {
+		return "", fmt.Errorf("post schema missing mappings")
+	}
+	properties, ok := mappings["properties"].(map[string]any)
+	if !ok {
+		return "", fmt.Errorf("post schema missing mapping properties")
+	}
+
+	indexSettings["knn"] = true
+	properties["embedding"] = map[string]any{
+		"type":      "knn_vector",
+		"dimension": embedder.Dimensions(),
+		"method": map[string]any{
+			"name":       "hnsw",
+			"space_type": "cosinesimil",
+			"engine":     "lucene",
+		},
+	}
+
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
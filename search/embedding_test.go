@@ -0,0 +1,156 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeEmbedder struct {
+	calls [][]string
+}
+
+func (f *fakeEmbedder) Dimensions() int { return 2 }
+
+func (f *fakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	f.calls = append(f.calls, append([]string(nil), texts...))
+	vecs := make([][]float32, len(texts))
+	for i, t := range texts {
+		vecs[i] = []float32{float32(len(t)), 1}
+	}
+	return vecs, nil
+}
+
+func TestHTTPEmbedder(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("Bearer secret", r.Header.Get("Authorization"))
+		var req embeddingsRequest
+		assert.NoError(json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal("mini", req.Model)
+		w.Header().Set("Content-Type", "application/json")
+		if len(req.Input) == 1 {
+			// wrong dimensions
+			io.WriteString(w, `{"data":[{"index":0,"embedding":[0.1]}]}`)
+			return
+		}
+		// out of order
+		io.WriteString(w, `{"data":[{"index":1,"embedding":[0.3,0.4]},{"index":0,"embedding":[0.1,0.2]}]}`)
+	}))
+	defer srv.Close()
+
+	_, err := NewHTTPEmbedder(HTTPEmbedderConfig{URL: srv.URL})
+	assert.Error(err)
+
+	e, err := NewHTTPEmbedder(HTTPEmbedderConfig{URL: srv.URL, Model: "mini", APIKey: "secret", Dimensions: 2})
+	if !assert.NoError(err) {
+		return
+	}
+	vecs, err := e.Embed(ctx, []string{"cats", "dogs"})
+	assert.NoError(err)
+	assert.Equal([][]float32{{0.1, 0.2}, {0.3, 0.4}}, vecs)
+
+	_, err = e.Embed(ctx, []string{"birds"})
+	assert.Error(err)
+}
+
+func TestEmbedPosts(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	docs := []*PostDoc{
+		{Text: "hello world"},
+		{Text: "", EmbedImgAltText: []string{"a cat", " "}},
+		{Text: " "},
+	}
+	for i := 0; i < embedBatchSize; i++ {
+		docs = append(docs, &PostDoc{Text: "more"})
+	}
+
+	embedder := &fakeEmbedder{}
+	assert.NoError(embedPosts(ctx, embedder, docs))
+	assert.Len(embedder.calls, 2)
+	assert.Equal([]string{"hello world", "a cat"}, embedder.calls[0][:2])
+	assert.Equal([]float32{11, 1}, docs[0].Embedding)
+	assert.Equal([]float32{5, 1}, docs[1].Embedding)
+	assert.Nil(docs[2].Embedding)
+	assert.Equal([]float32{4, 1}, docs[len(docs)-1].Embedding)
+}
+
+func TestPostSchemaJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := postSchemaJSON(nil)
+	assert.NoError(err)
+	assert.Equal(palomarPostSchemaJSON, s)
+
+	s, err = postSchemaJSON(&fakeEmbedder{})
+	assert.NoError(err)
+	var schema struct {
+		Settings struct {
+			Index struct {
+				KNN bool `json:"knn"`
+			} `json:"index"`
+		} `json:"settings"`
+		Mappings struct {
+			Properties map[string]struct {
+				Type      string `json:"type"`
+				Dimension int    `json:"dimension"`
+			} `json:"properties"`
+		} `json:"mappings"`
+	}
+	assert.NoError(json.Unmarshal([]byte(s), &schema))
+	assert.True(schema.Settings.Index.KNN)
+	assert.Equal("knn_vector", schema.Mappings.Properties["embedding"].Type)
+	assert.Equal(2, schema.Mappings.Properties["embedding"].Dimension)
+	assert.Equal("text", schema.Mappings.Properties["text"].Type)
+}
+
+func TestHybridPostQuery(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	dir := identity.NewMockDirectory()
+
+	var body map[string]any
+	backend := testOpenSearchBackend(t, map[string]http.HandlerFunc{
+		"/palomar_post/_search": func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			body = nil
+			json.Unmarshal(b, &body)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"hits":{"hits":[]}}`)
+		},
+	})
+
+	params := PostSearchParams{Query: "cute cats has:image", Size: 10}
+	_, err := DoSearchPosts(ctx, &dir, backend, "palomar_post", &params)
+	assert.NoError(err)
+	boolQuery := body["query"].(map[string]any)["bool"].(map[string]any)
+	assert.Contains(boolQuery, "must")
+	assert.NotContains(boolQuery, "should")
+
+	params = PostSearchParams{Query: "cute cats has:image", Size: 10, QueryEmbedding: []float32{0.5, 0.5}}
+	_, err = DoSearchPosts(ctx, &dir, backend, "palomar_post", &params)
+	assert.NoError(err)
+	boolQuery = body["query"].(map[string]any)["bool"].(map[string]any)
+	assert.NotContains(boolQuery, "must")
+	should := boolQuery["should"].([]any)
+	assert.Len(should, 2)
+	knn := should[1].(map[string]any)["knn"].(map[string]any)["embedding"].(map[string]any)
+	assert.Equal([]any{0.5, 0.5}, knn["vector"])
+	assert.Equal(float64(semanticMinK), knn["k"])
+	// the kNN search has the same filters as the keyword search, including has:image
+	assert.Equal(boolQuery["filter"], knn["filter"].(map[string]any)["bool"].(map[string]any)["filter"])
+	assert.Len(boolQuery["filter"], 2)
+	sort := body["sort"].([]any)
+	assert.Contains(sort[0], "_score")
+}
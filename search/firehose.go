@@ -42,6 +42,7 @@ func (idx *Indexer) updateLastCursor(curs int64) error {
 }
 
 func (idx *Indexer) RunIndexer(ctx context.Context) error {
+	idx.firehoseRunning.Store(true)
 	cur, err := idx.getLastCursor()
 	if err != nil {
 		return fmt.Errorf("get last cursor: %w", err)
@@ -50,12 +51,20 @@ func (idx *Indexer) RunIndexer(ctx context.Context) error {
 	// Start the indexer batch workers
 	go idx.runPostIndexer(ctx)
 	go idx.runProfileIndexer(ctx)
+	go idx.runRecordIndexer(ctx)
+
+	if err := idx.resumeReindexJobs(); err != nil {
+		return fmt.Errorf("resuming reindex jobs: %w", err)
+	}
 
 	err = idx.bfs.LoadJobs(ctx)
 	if err != nil {
 		return fmt.Errorf("loading backfill jobs: %w", err)
 	}
 	go idx.bf.Start()
+	if idx.reconcileInterval > 0 {
+		go idx.bf.RunPeriodicReconcile(ctx, idx.relayXRPC, idx.reconcileInterval)
+	}
 
 	if idx.enableRepoDiscovery {
 		go idx.discoverRepos()
@@ -76,6 +85,8 @@ func (idx *Indexer) RunIndexer(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("events dial failed: %w", err)
 	}
+	idx.firehoseConnected.Store(true)
+	defer idx.firehoseConnected.Store(false)
 
 	rsc := &events.RepoStreamCallbacks{
 		RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
@@ -184,8 +195,8 @@ func (idx *Indexer) discoverRepos() {
 
 func (idx *Indexer) handleCreateOrUpdate(ctx context.Context, rawDID string, rev string, path string, recB *[]byte, rcid *cid.Cid) error {
 	logger := idx.logger.With("func", "handleCreateOrUpdate", "did", rawDID, "rev", rev, "path", path)
-	// Since this gets called in a backfill job, we need to check if the path is a post or profile
-	if !strings.Contains(path, "app.bsky.feed.post") && !strings.Contains(path, "app.bsky.actor.profile") {
+	// Since this gets called in a backfill job, we need to check if the path is a record type we index
+	if !isIndexedPath(path) {
 		return nil
 	}
 
@@ -227,7 +238,9 @@ func (idx *Indexer) handleCreateOrUpdate(ctx context.Context, rawDID string, rev
 		}
 
 		// Send the job to the bulk indexer
-		idx.postQueue <- &job
+		if err := enqueue(ctx, "post", idx.postQueue, &job); err != nil {
+			return err
+		}
 		postsIndexed.Inc()
 	case *bsky.ActorProfile:
 		if parts[1] != "self" {
@@ -249,16 +262,74 @@ func (idx *Indexer) handleCreateOrUpdate(ctx context.Context, rawDID string, rev
 		}
 
 		// Send the job to the bulk indexer
-		idx.profileQueue <- &job
+		if err := enqueue(ctx, "profile", idx.profileQueue, &job); err != nil {
+			return err
+		}
 		profilesIndexed.Inc()
+	case *bsky.FeedGenerator, *bsky.GraphList, *bsky.GraphStarterpack:
+		rkey, err := syntax.ParseRecordKey(parts[1])
+		if err != nil {
+			logger.Warn("skipping record with invalid rkey")
+			return nil
+		}
+		if err := idx.queueRecord(ctx, did, rkey.String(), *rcid, rec); err != nil {
+			return err
+		}
 	default:
 	}
 	return nil
 }
 
+// isIndexedPath reports whether a repo path ("collection/rkey") is in one of the collections palomar indexes
+func isIndexedPath(path string) bool {
+	collection := strings.SplitN(path, "/", 2)[0]
+	switch collection {
+	case "app.bsky.feed.post", "app.bsky.actor.profile":
+		return true
+	}
+	return isRecordCollection(collection)
+}
+
+// isRecordCollection reports whether a collection is indexed as RecordDoc
+func isRecordCollection(collection string) bool {
+	for _, c := range recordTypeCollections {
+		if c == collection {
+			return true
+		}
+	}
+	return false
+}
+
+// transformRecord transforms a feed generator, list, or starter pack record, returning false for other record types
+func transformRecord(did syntax.DID, rkey string, rcid cid.Cid, rec typegen.CBORMarshaler) (RecordDoc, bool) {
+	switch rec := rec.(type) {
+	case *bsky.FeedGenerator:
+		return TransformFeedGenerator(rec, did, rkey, rcid.String()), true
+	case *bsky.GraphList:
+		return TransformList(rec, did, rkey, rcid.String()), true
+	case *bsky.GraphStarterpack:
+		return TransformStarterPack(rec, did, rkey, rcid.String()), true
+	}
+	return RecordDoc{}, false
+}
+
+// queueRecord transforms a feed generator, list, or starter pack record and sends it to the bulk indexer
+func (idx *Indexer) queueRecord(ctx context.Context, did syntax.DID, rkey string, rcid cid.Cid, rec typegen.CBORMarshaler) error {
+	doc, ok := transformRecord(did, rkey, rcid, rec)
+	if !ok {
+		return nil
+	}
+
+	if err := enqueue(ctx, "record", idx.recordQueue, &RecordIndexJob{doc: doc}); err != nil {
+		return err
+	}
+	recordsIndexed.Inc()
+	return nil
+}
+
 func (idx *Indexer) handleDelete(ctx context.Context, rawDID, rev, path string) error {
-	// Since this gets called in a backfill job, we need to check if the path is a post or profile
-	if !strings.Contains(path, "app.bsky.feed.post") && !strings.Contains(path, "app.bsky.actor.profile") {
+	// Since this gets called in a backfill job, we need to check if the path is a record type we index
+	if !isIndexedPath(path) {
 		return nil
 	}
 
@@ -276,6 +347,11 @@ func (idx *Indexer) handleDelete(ctx context.Context, rawDID, rev, path string)
 		postsDeleted.Inc()
 	case strings.Contains(path, "app.bsky.actor.profile"):
 		// profilesDeleted.Inc()
+	default:
+		if err := idx.deleteRecord(ctx, did, path); err != nil {
+			return err
+		}
+		recordsDeleted.Inc()
 	}
 
 	return nil
@@ -308,7 +384,7 @@ func (idx *Indexer) processTooBigCommit(ctx context.Context, evt *comatproto.Syn
 	}
 
 	return r.ForEach(ctx, "", func(k string, v cid.Cid) error {
-		if strings.HasPrefix(k, "app.bsky.feed.post") || strings.HasPrefix(k, "app.bsky.actor.profile") {
+		if isIndexedPath(k) {
 			rcid, rec, err := r.GetRecord(ctx, k)
 			if err != nil {
 				// TODO: handle this case (instead of return nil)
@@ -338,7 +414,9 @@ func (idx *Indexer) processTooBigCommit(ctx context.Context, evt *comatproto.Syn
 				}
 
 				// Send the job to the bulk indexer
-				idx.postQueue <- &job
+				if err := enqueue(ctx, "post", idx.postQueue, &job); err != nil {
+					return err
+				}
 			case *bsky.ActorProfile:
 				if parts[1] != "self" {
 					return nil
@@ -351,7 +429,18 @@ func (idx *Indexer) processTooBigCommit(ctx context.Context, evt *comatproto.Syn
 				}
 
 				// Send the job to the bulk indexer
-				idx.profileQueue <- &job
+				if err := enqueue(ctx, "profile", idx.profileQueue, &job); err != nil {
+					return err
+				}
+			case *bsky.FeedGenerator, *bsky.GraphList, *bsky.GraphStarterpack:
+				rkey, err := syntax.ParseRecordKey(parts[1])
+				if err != nil {
+					logger.Warn("skipping record with invalid rkey")
+					return nil
+				}
+				if err := idx.queueRecord(ctx, did, rkey.String(), rcid, rec); err != nil {
+					return err
+				}
 			default:
 			}
 
@@ -20,26 +20,12 @@ import (
 
 var tracer = otel.Tracer("search")
 
-func parseCursorLimit(e echo.Context) (int, int, error) {
-	offset := 0
-	if c := strings.TrimSpace(e.QueryParam("cursor")); c != "" {
-		v, err := strconv.Atoi(c)
-		if err != nil {
-			return 0, 0, &echo.HTTPError{
-				Code:    400,
-				Message: fmt.Sprintf("invalid value for 'cursor': %s", err),
-			}
-		}
-		offset = v
-	}
-
-	if offset < 0 {
-		offset = 0
-	}
-	if offset > 10000 {
-		return 0, 0, &echo.HTTPError{
+func parseCursorLimit(e echo.Context) (*searchCursor, int, error) {
+	cursor, err := parseSearchCursor(strings.TrimSpace(e.QueryParam("cursor")))
+	if err != nil {
+		return nil, 0, &echo.HTTPError{
 			Code:    400,
-			Message: fmt.Sprintf("invalid value for 'cursor' (can't paginate so deep)"),
+			Message: fmt.Sprintf("invalid value for 'cursor': %s", err),
 		}
 	}
 
@@ -47,7 +33,7 @@ func parseCursorLimit(e echo.Context) (int, int, error) {
 	if l := strings.TrimSpace(e.QueryParam("limit")); l != "" {
 		v, err := strconv.Atoi(l)
 		if err != nil {
-			return 0, 0, &echo.HTTPError{
+			return nil, 0, &echo.HTTPError{
 				Code:    400,
 				Message: fmt.Sprintf("invalid value for 'count': %s", err),
 			}
@@ -62,7 +48,7 @@ func parseCursorLimit(e echo.Context) (int, int, error) {
 	if limit < 0 {
 		limit = 0
 	}
-	return offset, limit, nil
+	return cursor, limit, nil
 }
 
 func (s *Server) handleSearchPostsSkeleton(e echo.Context) error {
@@ -191,17 +177,31 @@ func (s *Server) handleSearchPostsSkeleton(e echo.Context) error {
 	if len(tags) > 0 {
 		params.Tags = tags
 	}
+	if v := strings.TrimSpace(e.QueryParam("semantic")); v == "true" || v == "1" || v == "y" {
+		if s.embedder == nil {
+			return e.JSON(400, map[string]any{
+				"error":   "BadRequest",
+				"message": "semantic search is not enabled",
+			})
+		}
+		params.Semantic = true
+	}
 
-	offset, limit, err := parseCursorLimit(e)
+	cursor, limit, err := parseCursorLimit(e)
 	if err != nil {
 		span.SetAttributes(attribute.String("error", fmt.Sprintf("invalid cursor/limit: %s", err)))
 		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
 
-	params.Offset = offset
+	params.Offset = cursor.Offset
+	params.SearchAfter = cursor.SearchAfter
 	params.Size = limit
-	span.SetAttributes(attribute.Int("offset", offset), attribute.Int("limit", limit))
+	span.SetAttributes(
+		attribute.Int("offset", cursor.Offset),
+		attribute.Bool("search_after", len(cursor.SearchAfter) > 0),
+		attribute.Int("limit", limit),
+	)
 
 	out, err := s.SearchPosts(ctx, &params)
 	if err != nil {
@@ -229,7 +229,7 @@ func (s *Server) handleSearchActorsSkeleton(e echo.Context) error {
 		})
 	}
 
-	offset, limit, err := parseCursorLimit(e)
+	cursor, limit, err := parseCursorLimit(e)
 	if err != nil {
 		span.SetAttributes(attribute.String("error", fmt.Sprintf("invalid cursor/limit: %s", err)))
 		span.SetStatus(codes.Error, err.Error())
@@ -240,12 +240,19 @@ func (s *Server) handleSearchActorsSkeleton(e echo.Context) error {
 	if q := strings.TrimSpace(e.QueryParam("typeahead")); q == "true" || q == "1" || q == "y" {
 		typeahead = true
 	}
+	if typeahead && len(cursor.SearchAfter) > 0 {
+		return e.JSON(400, map[string]any{
+			"error":   "BadRequest",
+			"message": "typeahead search only supports offset cursors",
+		})
+	}
 
 	params := ActorSearchParams{
-		Query:     q,
-		Typeahead: typeahead,
-		Offset:    offset,
-		Size:      limit,
+		Query:       q,
+		Typeahead:   typeahead,
+		Offset:      cursor.Offset,
+		SearchAfter: cursor.SearchAfter,
+		Size:        limit,
 	}
 
 	viewerStr := e.QueryParam("viewer")
@@ -261,7 +268,8 @@ func (s *Server) handleSearchActorsSkeleton(e echo.Context) error {
 	}
 
 	span.SetAttributes(
-		attribute.Int("offset", offset),
+		attribute.Int("offset", cursor.Offset),
+		attribute.Bool("search_after", len(cursor.SearchAfter) > 0),
 		attribute.Int("limit", limit),
 		attribute.Bool("typeahead", typeahead),
 	)
@@ -278,11 +286,115 @@ func (s *Server) handleSearchActorsSkeleton(e echo.Context) error {
 	return e.JSON(200, out)
 }
 
+// SkeletonSearchRecord is a single feed generator, list, or starter pack search result
+type SkeletonSearchRecord struct {
+	Uri string `json:"uri"`
+}
+
+// SearchRecordsSkeletonOutput is the result of a feed generator, list, or starter pack search. It is served with Records under a key named for the record type (eg, "feeds")
+type SearchRecordsSkeletonOutput struct {
+	Cursor    *string
+	HitsTotal *int64
+	Records   []*SkeletonSearchRecord
+}
+
+// handleSearchRecordsSkeleton serves searches of a single RecordDoc record type, with results under resultKey
+func (s *Server) handleSearchRecordsSkeleton(recordType, resultKey string) echo.HandlerFunc {
+	return func(e echo.Context) error {
+		ctx, span := tracer.Start(e.Request().Context(), "handleSearchRecordsSkeleton")
+		defer span.End()
+
+		span.SetAttributes(
+			attribute.String("query", e.QueryParam("q")),
+			attribute.String("record_type", recordType),
+		)
+
+		q := strings.TrimSpace(e.QueryParam("q"))
+		if q == "" {
+			return e.JSON(400, map[string]any{
+				"error":   "BadRequest",
+				"message": "must pass non-empty search query",
+			})
+		}
+
+		cursor, limit, err := parseCursorLimit(e)
+		if err != nil {
+			span.SetAttributes(attribute.String("error", fmt.Sprintf("invalid cursor/limit: %s", err)))
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+
+		params := RecordSearchParams{
+			Query:       q,
+			RecordType:  recordType,
+			Offset:      cursor.Offset,
+			SearchAfter: cursor.SearchAfter,
+			Size:        limit,
+		}
+
+		authorStr := e.QueryParam("author")
+		if authorStr != "" {
+			d, err := syntax.ParseDID(authorStr)
+			if err != nil {
+				return e.JSON(400, map[string]any{
+					"error":   "BadRequest",
+					"message": fmt.Sprintf("invalid DID for 'author': %s", err),
+				})
+			}
+			params.Author = &d
+		}
+
+		if recordType == RecordTypeList {
+			params.ListPurpose = strings.TrimSpace(e.QueryParam("purpose"))
+		}
+
+		span.SetAttributes(
+			attribute.Int("offset", cursor.Offset),
+			attribute.Bool("search_after", len(cursor.SearchAfter) > 0),
+			attribute.Int("limit", limit),
+		)
+
+		out, err := s.SearchRecords(ctx, &params)
+		if err != nil {
+			span.SetAttributes(attribute.String("error", fmt.Sprintf("failed to SearchRecords: %s", err)))
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+
+		span.SetAttributes(attribute.Int("records.length", len(out.Records)))
+
+		resp := map[string]any{resultKey: out.Records}
+		if out.Cursor != nil {
+			resp["cursor"] = *out.Cursor
+		}
+		if out.HitsTotal != nil {
+			resp["hitsTotal"] = *out.HitsTotal
+		}
+		return e.JSON(200, resp)
+	}
+}
+
 func (s *Server) SearchPosts(ctx context.Context, params *PostSearchParams) (*appbsky.UnspeccedSearchPostsSkeleton_Output, error) {
 	ctx, span := tracer.Start(ctx, "SearchPosts")
 	defer span.End()
 
-	resp, err := DoSearchPosts(ctx, s.dir, s.escli, s.postIndex, params)
+	if params.Semantic && s.embedder != nil && len(params.QueryEmbedding) == 0 {
+		// embed only the free text of the query, not any filter syntax
+		parsed := ParsePostQuery(ctx, s.dir, params.Query, params.Viewer)
+		if text := strings.TrimSpace(parsed.Query); text != "" {
+			vecs, err := s.embedder.Embed(ctx, []string{text})
+			if err != nil {
+				// fall back to a keyword search
+				s.logger.Warn("failed to embed query", "err", err)
+				span.SetAttributes(attribute.String("embed_error", err.Error()))
+			} else {
+				params.QueryEmbedding = vecs[0]
+			}
+		}
+	}
+	span.SetAttributes(attribute.Bool("semantic", len(params.QueryEmbedding) > 0))
+
+	resp, err := DoSearchPosts(ctx, s.dir, s.backend, s.postIndex, params)
 	if err != nil {
 		return nil, err
 	}
@@ -305,10 +417,39 @@ func (s *Server) SearchPosts(ctx context.Context, params *PostSearchParams) (*ap
 	}
 
 	out := appbsky.UnspeccedSearchPostsSkeleton_Output{Posts: posts}
-	if len(posts) == params.Size && (params.Offset+params.Size) < 10000 {
-		s := fmt.Sprintf("%d", params.Offset+params.Size)
-		out.Cursor = &s
+	out.Cursor = nextPageCursor(resp.Hits.Hits, params.Size, params.Offset)
+	if resp.Hits.Total.Relation == "eq" {
+		i := int64(resp.Hits.Total.Value)
+		out.HitsTotal = &i
 	}
+	return &out, nil
+}
+
+func (s *Server) SearchRecords(ctx context.Context, params *RecordSearchParams) (*SearchRecordsSkeletonOutput, error) {
+	ctx, span := tracer.Start(ctx, "SearchRecords")
+	defer span.End()
+
+	resp, err := DoSearchRecords(ctx, s.backend, s.recordIndex, params)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*SkeletonSearchRecord{}
+	for _, r := range resp.Hits.Hits {
+		var doc RecordDoc
+		if err := json.Unmarshal(r.Source, &doc); err != nil {
+			return nil, fmt.Errorf("decoding record doc from search response: %w", err)
+		}
+
+		if _, err := syntax.ParseDID(doc.DID); err != nil {
+			return nil, fmt.Errorf("invalid DID in indexed document: %w", err)
+		}
+
+		records = append(records, &SkeletonSearchRecord{Uri: doc.ATURI()})
+	}
+
+	out := SearchRecordsSkeletonOutput{Records: records}
+	out.Cursor = nextPageCursor(resp.Hits.Hits, params.Size, params.Offset)
 	if resp.Hits.Total.Relation == "eq" {
 		i := int64(resp.Hits.Total.Value)
 		out.HitsTotal = &i
@@ -341,9 +482,9 @@ func (s *Server) SearchProfiles(ctx context.Context, params *ActorSearchParams)
 		myQ.Follows = nil
 
 		if myQ.Typeahead {
-			globalResp, globalErr = DoSearchProfilesTypeahead(ctx, s.escli, s.profileIndex, &myQ)
+			globalResp, globalErr = DoSearchProfilesTypeahead(ctx, s.backend, s.profileIndex, &myQ)
 		} else {
-			globalResp, globalErr = DoSearchProfiles(ctx, s.dir, s.escli, s.profileIndex, &myQ)
+			globalResp, globalErr = DoSearchProfiles(ctx, s.dir, s.backend, s.profileIndex, &myQ)
 		}
 	}(*params)
 
@@ -353,9 +494,9 @@ func (s *Server) SearchProfiles(ctx context.Context, params *ActorSearchParams)
 		go func(myQ ActorSearchParams) {
 			defer wg.Done()
 			if myQ.Typeahead {
-				personalizedResp, personalizedErr = DoSearchProfilesTypeahead(ctx, s.escli, s.profileIndex, &myQ)
+				personalizedResp, personalizedErr = DoSearchProfilesTypeahead(ctx, s.backend, s.profileIndex, &myQ)
 			} else {
-				personalizedResp, personalizedErr = DoSearchProfiles(ctx, s.dir, s.escli, s.profileIndex, &myQ)
+				personalizedResp, personalizedErr = DoSearchProfiles(ctx, s.dir, s.backend, s.profileIndex, &myQ)
 			}
 		}(*params)
 	}
@@ -461,9 +602,11 @@ func (s *Server) SearchProfiles(ctx context.Context, params *ActorSearchParams)
 	}
 
 	out := appbsky.UnspeccedSearchActorsSkeleton_Output{Actors: actors}
-	if len(actors) == params.Size && (params.Offset+params.Size) < 10000 {
-		s := fmt.Sprintf("%d", params.Offset+params.Size)
-		out.Cursor = &s
+	if len(params.Follows) > 0 {
+		// results merged from two searches don't have a single sort order to resume from
+		out.Cursor = nextOffsetCursor(len(actors), params.Size, params.Offset)
+	} else {
+		out.Cursor = nextPageCursor(globalResp.Hits.Hits, params.Size, params.Offset)
 	}
 	if globalResp.Hits.Total.Relation == "eq" {
 		i := int64(globalResp.Hits.Total.Value)
@@ -1,15 +1,15 @@
 package search
 
 import (
-	"bytes"
 	"context"
 	_ "embed"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	appbsky "github.com/bluesky-social/indigo/api/bsky"
@@ -22,15 +22,14 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/time/rate"
 	gorm "gorm.io/gorm"
-
-	es "github.com/opensearch-project/opensearch-go/v2"
-	esapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
 )
 
 type Indexer struct {
-	escli        *es.Client
+	backend      Backend
 	postIndex    string
 	profileIndex string
+	recordIndex  string
+	postSchema   string
 	db           *gorm.DB
 	relayhost    string
 	relayXRPC    *xrpc.Client
@@ -42,22 +41,49 @@ type Indexer struct {
 	bf  *backfill.Backfiller
 
 	enableRepoDiscovery bool
+	reconcileInterval   time.Duration
+	embedder            Embedder
+	bulkConfig          BulkConfig
 
 	indexLimiter  *rate.Limiter
 	profileQueue  chan *ProfileIndexJob
 	postQueue     chan *PostIndexJob
+	recordQueue   chan *RecordIndexJob
 	pagerankQueue chan *PagerankIndexJob
+
+	// index being built by a reindex job, keyed by the alias it will replace, which live writes are copied to (see StartReindex)
+	reindexLk      sync.Mutex
+	reindexTargets map[string]string
+	reindexCancel  map[uint]context.CancelFunc
+	reindexLimiter *rate.Limiter
+
+	// firehose subscription state, for readiness checks
+	firehoseRunning   atomic.Bool
+	firehoseConnected atomic.Bool
 }
 
 type IndexerConfig struct {
-	RelayHost           string
-	ProfileIndex        string
-	PostIndex           string
+	RelayHost    string
+	ProfileIndex string
+	PostIndex    string
+	// Index for feed generator, list, and starter pack docs
+	RecordIndex         string
 	Logger              *slog.Logger
 	RelaySyncRateLimit  int
 	IndexMaxConcurrency int
 	DiscoverRepos       bool
 	IndexingRateLimit   int
+	// Optional local directory or "s3://" URI of repo CAR snapshots to backfill from (see backfill.ParseArchiveSource)
+	BackfillArchive string
+	// If non-zero, periodically reconcile backfill state against the Relay's listRepos, to catch any missed repos or events
+	BackfillReconcileInterval time.Duration
+	// Ceiling on firehose ops buffered in memory for repos being backfilled; beyond this, ops are buffered in files in BackfillSpillDir. Zero means no limit
+	BackfillBufferMaxBytes int64
+	BackfillSpillDir       string
+	// If set, post embeddings are computed at index time, for semantic search (see Embedder)
+	Embedder Embedder
+	// Batching and retries of writes to the search backend. Zero values are replaced with defaults
+	Bulk BulkConfig
 }
 
 type ProfileIndexJob struct {
@@ -73,12 +99,19 @@ type PostIndexJob struct {
 	rkey   string
 }
 
+// RecordIndexJob carries an already-transformed feed generator, list, or starter pack doc
+type RecordIndexJob struct {
+	doc RecordDoc
+}
+
 type PagerankIndexJob struct {
 	did  syntax.DID
 	rank float64
+	// Optional approximate follower count
+	followers *int64
 }
 
-func NewIndexer(db *gorm.DB, escli *es.Client, dir identity.Directory, config IndexerConfig) (*Indexer, error) {
+func NewIndexer(db *gorm.DB, backend Backend, dir identity.Directory, config IndexerConfig) (*Indexer, error) {
 	logger := config.Logger
 	if logger == nil {
 		logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
@@ -89,6 +122,7 @@ func NewIndexer(db *gorm.DB, escli *es.Client, dir identity.Directory, config In
 
 	logger.Info("running database migrations")
 	db.AutoMigrate(&LastSeq{})
+	db.AutoMigrate(&ReindexJob{})
 	db.AutoMigrate(&backfill.GormDBJob{})
 
 	relayWS := config.RelayHost
@@ -101,26 +135,43 @@ func NewIndexer(db *gorm.DB, escli *es.Client, dir identity.Directory, config In
 		Host: relayHTTP,
 	}
 
-	limiter := rate.NewLimiter(rate.Limit(config.IndexingRateLimit), 10_000)
+	postSchema, err := postSchemaJSON(config.Embedder)
+	if err != nil {
+		return nil, err
+	}
+
+	bulkConfig := config.Bulk.withDefaults()
+	// large enough for a full batch
+	limiter := rate.NewLimiter(rate.Limit(config.IndexingRateLimit), max(10_000, bulkConfig.MaxDocs))
 
 	idx := &Indexer{
-		escli:               escli,
+		backend:             backend,
 		profileIndex:        config.ProfileIndex,
 		postIndex:           config.PostIndex,
+		recordIndex:         config.RecordIndex,
+		postSchema:          postSchema,
 		db:                  db,
 		relayhost:           config.RelayHost,
 		relayXRPC:           relayXRPC,
 		dir:                 dir,
 		logger:              logger,
 		enableRepoDiscovery: config.DiscoverRepos,
+		reconcileInterval:   config.BackfillReconcileInterval,
+		embedder:            config.Embedder,
+		bulkConfig:          bulkConfig,
 
 		indexLimiter:  limiter,
-		profileQueue:  make(chan *ProfileIndexJob, 1000),
-		postQueue:     make(chan *PostIndexJob, 1000),
-		pagerankQueue: make(chan *PagerankIndexJob, 1000),
+		profileQueue:  make(chan *ProfileIndexJob, bulkConfig.QueueSize),
+		postQueue:     make(chan *PostIndexJob, bulkConfig.QueueSize),
+		recordQueue:   make(chan *RecordIndexJob, bulkConfig.QueueSize),
+		pagerankQueue: make(chan *PagerankIndexJob, bulkConfig.QueueSize),
+
+		reindexTargets: make(map[string]string),
+		reindexCancel:  make(map[uint]context.CancelFunc),
 	}
 
 	bfstore := backfill.NewGormstore(db)
+	bfstore.SetBufferLimits(config.BackfillBufferMaxBytes, config.BackfillSpillDir)
 	opts := backfill.DefaultBackfillOptions()
 
 	if config.RelaySyncRateLimit > 0 {
@@ -137,6 +188,13 @@ func NewIndexer(db *gorm.DB, escli *es.Client, dir identity.Directory, config In
 		opts.ParallelRecordCreates = 20
 	}
 	opts.NSIDFilter = "app.bsky."
+	if config.BackfillArchive != "" {
+		archive, err := backfill.ParseArchiveSource(config.BackfillArchive)
+		if err != nil {
+			return nil, err
+		}
+		opts.Archive = archive
+	}
 	bf := backfill.NewBackfiller(
 		"search",
 		bfstore,
@@ -148,6 +206,7 @@ func NewIndexer(db *gorm.DB, escli *es.Client, dir identity.Directory, config In
 
 	idx.bfs = bfstore
 	idx.bf = bf
+	idx.reindexLimiter = rate.NewLimiter(rate.Limit(opts.SyncRequestsPerSecond), 1)
 
 	return idx, nil
 }
@@ -158,176 +217,31 @@ var palomarPostSchemaJSON string
 //go:embed profile_schema.json
 var palomarProfileSchemaJSON string
 
+//go:embed record_schema.json
+var palomarRecordSchemaJSON string
+
 func (idx *Indexer) EnsureIndices(ctx context.Context) error {
-	indices := []struct {
-		Name       string
-		SchemaJSON string
-	}{
-		{Name: idx.postIndex, SchemaJSON: palomarPostSchemaJSON},
+	return ensureIndices(ctx, idx.backend, idx.logger, []indexSchema{
+		{Name: idx.postIndex, SchemaJSON: idx.postSchema},
 		{Name: idx.profileIndex, SchemaJSON: palomarProfileSchemaJSON},
-	}
-	for _, index := range indices {
-		resp, err := idx.escli.Indices.Exists([]string{index.Name})
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-		io.ReadAll(resp.Body)
-		if resp.IsError() && resp.StatusCode != 404 {
-			return fmt.Errorf("failed to check index existence")
-		}
-		if resp.StatusCode == 404 {
-			idx.logger.Warn("creating opensearch index", "index", index.Name)
-			if len(index.SchemaJSON) < 2 {
-				return fmt.Errorf("empty schema file (go:embed failed)")
-			}
-			buf := strings.NewReader(index.SchemaJSON)
-			resp, err := idx.escli.Indices.Create(
-				index.Name,
-				idx.escli.Indices.Create.WithBody(buf))
-			if err != nil {
-				return err
-			}
-			defer resp.Body.Close()
-			io.ReadAll(resp.Body)
-			if resp.IsError() {
-				return fmt.Errorf("failed to create index")
-			}
-		}
-	}
-	return nil
+		{Name: idx.recordIndex, SchemaJSON: palomarRecordSchemaJSON},
+	})
 }
 
 func (idx *Indexer) runPostIndexer(ctx context.Context) {
-	ctx, span := tracer.Start(ctx, "runPostIndexer")
-	defer span.End()
-
-	// Batch up to 1000 posts at a time, or every 5 seconds
-	tick := time.NewTicker(5 * time.Second)
-	defer tick.Stop()
-
-	var posts []*PostIndexJob
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-tick.C:
-			if len(posts) > 0 {
-				err := idx.indexLimiter.WaitN(ctx, len(posts))
-				if err != nil {
-					idx.logger.Error("failed to wait for rate limiter", "err", err)
-					continue
-				}
-				err = idx.indexPosts(ctx, posts)
-				if err != nil {
-					idx.logger.Error("failed to index posts", "err", err)
-				}
-				posts = posts[:0]
-			}
-		case job := <-idx.postQueue:
-			posts = append(posts, job)
-			if len(posts) >= 1000 {
-				err := idx.indexLimiter.WaitN(ctx, len(posts))
-				if err != nil {
-					idx.logger.Error("failed to wait for rate limiter", "err", err)
-					continue
-				}
-				err = idx.indexPosts(ctx, posts)
-				if err != nil {
-					idx.logger.Error("failed to index posts", "err", err)
-				}
-				posts = posts[:0]
-			}
-		}
-	}
+	runBatcher(ctx, idx, "post", idx.postQueue, idx.indexPosts)
 }
 
 func (idx *Indexer) runProfileIndexer(ctx context.Context) {
-	ctx, span := tracer.Start(ctx, "runProfileIndexer")
-	defer span.End()
+	runBatcher(ctx, idx, "profile", idx.profileQueue, idx.indexProfiles)
+}
 
-	// Batch up to 1000 profiles at a time, or every 5 seconds
-	tick := time.NewTicker(5 * time.Second)
-	defer tick.Stop()
-
-	var profiles []*ProfileIndexJob
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-tick.C:
-			if len(profiles) > 0 {
-				err := idx.indexLimiter.WaitN(ctx, len(profiles))
-				if err != nil {
-					idx.logger.Error("failed to wait for rate limiter", "err", err)
-					continue
-				}
-				err = idx.indexProfiles(ctx, profiles)
-				if err != nil {
-					idx.logger.Error("failed to index profiles", "err", err)
-				}
-				profiles = profiles[:0]
-			}
-		case job := <-idx.profileQueue:
-			profiles = append(profiles, job)
-			if len(profiles) >= 1000 {
-				err := idx.indexLimiter.WaitN(ctx, len(profiles))
-				if err != nil {
-					idx.logger.Error("failed to wait for rate limiter", "err", err)
-					continue
-				}
-				err = idx.indexProfiles(ctx, profiles)
-				if err != nil {
-					idx.logger.Error("failed to index profiles", "err", err)
-				}
-				profiles = profiles[:0]
-			}
-		}
-	}
+func (idx *Indexer) runRecordIndexer(ctx context.Context) {
+	runBatcher(ctx, idx, "record", idx.recordQueue, idx.indexRecords)
 }
 
 func (idx *Indexer) runPagerankIndexer(ctx context.Context) {
-	ctx, span := tracer.Start(ctx, "runPagerankIndexer")
-	defer span.End()
-
-	// Batch up to 1000 pageranks at a time, or every 5 seconds
-	tick := time.NewTicker(5 * time.Second)
-	defer tick.Stop()
-
-	var pageranks []*PagerankIndexJob
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-tick.C:
-			if len(pageranks) > 0 {
-				err := idx.indexLimiter.WaitN(ctx, len(pageranks))
-				if err != nil {
-					idx.logger.Error("failed to wait for rate limiter", "err", err)
-					continue
-				}
-				err = idx.indexPageranks(ctx, pageranks)
-				if err != nil {
-					idx.logger.Error("failed to index pageranks", "err", err)
-				}
-				pageranks = pageranks[:0]
-			}
-		case job := <-idx.pagerankQueue:
-			pageranks = append(pageranks, job)
-			if len(pageranks) >= 1000 {
-				err := idx.indexLimiter.WaitN(ctx, len(pageranks))
-				if err != nil {
-					idx.logger.Error("failed to wait for rate limiter", "err", err)
-					continue
-				}
-				err = idx.indexPageranks(ctx, pageranks)
-				if err != nil {
-					idx.logger.Error("failed to index pageranks", "err", err)
-				}
-				pageranks = pageranks[:0]
-			}
-		}
-	}
+	runBatcher(ctx, idx, "pagerank", idx.pagerankQueue, idx.indexPageranks)
 }
 
 func (idx *Indexer) deletePost(ctx context.Context, did syntax.DID, recordPath string) error {
@@ -350,29 +264,42 @@ func (idx *Indexer) deletePost(ctx context.Context, did syntax.DID, recordPath s
 
 	docID := fmt.Sprintf("%s_%s", did.String(), rkey)
 	logger.Info("deleting post from index", "docID", docID)
-	req := esapi.DeleteRequest{
-		Index:      idx.postIndex,
-		DocumentID: docID,
-		Refresh:    "true",
-	}
 
 	err = idx.indexLimiter.Wait(ctx)
 	if err != nil {
 		logger.Warn("failed to wait for rate limiter", "err", err)
 		return err
 	}
-	res, err := req.Do(ctx, idx.escli)
-	if err != nil {
+	if err := idx.deleteDoc(ctx, idx.postIndex, docID); err != nil {
 		return fmt.Errorf("failed to delete post: %w", err)
 	}
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
+	return nil
+}
+
+// deleteRecord removes a feed generator, list, or starter pack doc
+func (idx *Indexer) deleteRecord(ctx context.Context, did syntax.DID, recordPath string) error {
+	ctx, span := tracer.Start(ctx, "deleteRecord")
+	defer span.End()
+	span.SetAttributes(attribute.String("repo", did.String()), attribute.String("path", recordPath))
+
+	logger := idx.logger.With("repo", did, "path", recordPath, "op", "deleteRecord")
+
+	parts := strings.SplitN(recordPath, "/", 3)
+	if len(parts) < 2 {
+		logger.Warn("skipping record with malformed path")
+		return nil
+	}
+
+	docID := recordDocId(did.String(), parts[0], parts[1])
+	logger.Info("deleting record from index", "docID", docID)
+
+	err := idx.indexLimiter.Wait(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to read indexing response: %w", err)
+		logger.Warn("failed to wait for rate limiter", "err", err)
+		return err
 	}
-	if res.IsError() {
-		logger.Warn("opensearch indexing error", "status_code", res.StatusCode, "response", res, "body", string(body))
-		return fmt.Errorf("indexing error, code=%d", res.StatusCode)
+	if err := idx.deleteDoc(ctx, idx.recordIndex, docID); err != nil {
+		return fmt.Errorf("failed to delete record: %w", err)
 	}
 	return nil
 }
@@ -385,41 +312,35 @@ func (idx *Indexer) indexPosts(ctx context.Context, jobs []*PostIndexJob) error
 	log := idx.logger.With("op", "indexPosts")
 	start := time.Now()
 
-	var buf bytes.Buffer
-	for i := range jobs {
-		job := jobs[i]
+	docs := make([]*PostDoc, len(jobs))
+	for i, job := range jobs {
 		doc := TransformPost(job.record, job.did, job.rkey, job.rcid.String())
+		docs[i] = &doc
+	}
+	if idx.embedder != nil {
+		// posts are still indexed for keyword search if embedding fails
+		if err := embedPosts(ctx, idx.embedder, docs); err != nil {
+			log.Warn("failed to compute post embeddings", "err", err)
+		}
+	}
+
+	ops := make([]BulkOp, 0, len(jobs))
+	for _, doc := range docs {
 		docBytes, err := json.Marshal(doc)
 		if err != nil {
 			log.Warn("failed to marshal post", "err", err)
 			return err
 		}
 
-		indexScript := []byte(fmt.Sprintf(`{"index":{"_id":"%s"}}%s`, doc.DocId(), "\n"))
-		docBytes = append(docBytes, "\n"...)
-
-		buf.Grow(len(indexScript) + len(docBytes))
-		buf.Write(indexScript)
-		buf.Write(docBytes)
+		ops = append(ops, BulkOp{Action: BulkIndex, DocID: doc.DocId(), Body: docBytes})
 	}
 
 	log.Info("indexing posts", "num_posts", len(jobs))
 
-	res, err := idx.escli.Bulk(bytes.NewReader(buf.Bytes()), idx.escli.Bulk.WithIndex(idx.postIndex))
-	if err != nil {
-		log.Warn("failed to send bulk indexing request", "err", err)
-		return fmt.Errorf("failed to send bulk indexing request: %w", err)
-	}
-	defer res.Body.Close()
-
-	if res.IsError() {
-		body, err := io.ReadAll(res.Body)
-		if err != nil {
-			log.Warn("failed to read bulk indexing response", "err", err)
-			return fmt.Errorf("failed to read bulk indexing response: %w", err)
-		}
-		log.Warn("opensearch bulk indexing error", "status_code", res.StatusCode, "response", res, "body", string(body))
-		return fmt.Errorf("bulk indexing error, code=%d", res.StatusCode)
+	if err := idx.bulk(ctx, idx.postIndex, ops); err != nil {
+		postsFailed.Add(float64(bulkFailedCount(err, len(ops))))
+		log.Warn("bulk indexing error", "err", err)
+		return fmt.Errorf("bulk indexing error: %w", err)
 	}
 
 	log.Info("indexed posts", "num_posts", len(jobs), "duration", time.Since(start))
@@ -435,7 +356,7 @@ func (idx *Indexer) indexProfiles(ctx context.Context, jobs []*ProfileIndexJob)
 	log := idx.logger.With("op", "indexProfiles")
 	start := time.Now()
 
-	var buf bytes.Buffer
+	ops := make([]BulkOp, 0, len(jobs))
 	for i := range jobs {
 		job := jobs[i]
 
@@ -446,39 +367,55 @@ func (idx *Indexer) indexProfiles(ctx context.Context, jobs []*ProfileIndexJob)
 			return err
 		}
 
-		indexScript := []byte(fmt.Sprintf(`{"index":{"_id":"%s"}}%s`, job.ident.DID.String(), "\n"))
-		docBytes = append(docBytes, "\n"...)
-
-		buf.Grow(len(indexScript) + len(docBytes))
-		buf.Write(indexScript)
-		buf.Write(docBytes)
+		ops = append(ops, BulkOp{Action: BulkIndex, DocID: job.ident.DID.String(), Body: docBytes})
 	}
 
 	log.Info("indexing profiles", "num_profiles", len(jobs))
 
-	res, err := idx.escli.Bulk(bytes.NewReader(buf.Bytes()), idx.escli.Bulk.WithIndex(idx.profileIndex))
-	if err != nil {
-		log.Warn("failed to send bulk indexing request", "err", err)
-		return fmt.Errorf("failed to send bulk indexing request: %w", err)
+	if err := idx.bulk(ctx, idx.profileIndex, ops); err != nil {
+		profilesFailed.Add(float64(bulkFailedCount(err, len(ops))))
+		log.Warn("bulk indexing error", "err", err)
+		return fmt.Errorf("bulk indexing error: %w", err)
 	}
-	defer res.Body.Close()
 
-	if res.IsError() {
-		body, err := io.ReadAll(res.Body)
+	log.Info("indexed profiles", "num_profiles", len(jobs), "duration", time.Since(start))
+
+	return nil
+}
+
+func (idx *Indexer) indexRecords(ctx context.Context, jobs []*RecordIndexJob) error {
+	ctx, span := tracer.Start(ctx, "indexRecords")
+	defer span.End()
+	span.SetAttributes(attribute.Int("num_records", len(jobs)))
+
+	log := idx.logger.With("op", "indexRecords")
+	start := time.Now()
+
+	ops := make([]BulkOp, 0, len(jobs))
+	for i := range jobs {
+		doc := jobs[i].doc
+		docBytes, err := json.Marshal(doc)
 		if err != nil {
-			log.Warn("failed to read bulk indexing response", "err", err)
-			return fmt.Errorf("failed to read bulk indexing response: %w", err)
+			log.Warn("failed to marshal record", "err", err)
+			return err
 		}
-		log.Warn("opensearch bulk indexing error", "status_code", res.StatusCode, "response", res, "body", string(body))
-		return fmt.Errorf("bulk indexing error, code=%d", res.StatusCode)
+
+		ops = append(ops, BulkOp{Action: BulkIndex, DocID: doc.DocId(), Body: docBytes})
 	}
 
-	log.Info("indexed profiles", "num_profiles", len(jobs), "duration", time.Since(start))
+	log.Info("indexing records", "num_records", len(jobs))
+
+	if err := idx.bulk(ctx, idx.recordIndex, ops); err != nil {
+		log.Warn("bulk indexing error", "err", err)
+		return fmt.Errorf("bulk indexing error: %w", err)
+	}
+
+	log.Info("indexed records", "num_records", len(jobs), "duration", time.Since(start))
 
 	return nil
 }
 
-// updateProfilePagranks uses the OpenSearch bulk API to update the pageranks for the given DIDs
+// indexPageranks uses the bulk API to update the pageranks (and follower counts, if known) for the given DIDs
 func (idx *Indexer) indexPageranks(ctx context.Context, pageranks []*PagerankIndexJob) error {
 	ctx, span := tracer.Start(ctx, "indexPageranks")
 	defer span.End()
@@ -488,15 +425,21 @@ func (idx *Indexer) indexPageranks(ctx context.Context, pageranks []*PagerankInd
 
 	log.Info("updating profile pageranks")
 
-	var buf bytes.Buffer
+	ops := make([]BulkOp, 0, len(pageranks))
 	for _, pr := range pageranks {
+		source := "ctx._source.pagerank = params.pagerank"
+		params := map[string]any{
+			"pagerank": pr.rank,
+		}
+		if pr.followers != nil {
+			source += "; ctx._source.followersFuzzy = params.followers"
+			params["followers"] = *pr.followers
+		}
 		updateScript := map[string]any{
 			"script": map[string]any{
-				"source": "ctx._source.pagerank = params.pagerank",
+				"source": source,
 				"lang":   "painless",
-				"params": map[string]any{
-					"pagerank": pr.rank,
-				},
+				"params": params,
 			},
 		}
 		updateScriptJSON, err := json.Marshal(updateScript)
@@ -505,29 +448,12 @@ func (idx *Indexer) indexPageranks(ctx context.Context, pageranks []*PagerankInd
 			return err
 		}
 
-		updateMetaJSON := []byte(fmt.Sprintf(`{"update":{"_id":"%s"}}%s`, pr.did.String(), "\n"))
-		updateScriptJSON = append(updateScriptJSON, "\n"...)
-
-		buf.Grow(len(updateMetaJSON) + len(updateScriptJSON))
-		buf.Write(updateMetaJSON)
-		buf.Write(updateScriptJSON)
-	}
-
-	res, err := idx.escli.Bulk(bytes.NewReader(buf.Bytes()), idx.escli.Bulk.WithIndex(idx.profileIndex))
-	if err != nil {
-		log.Warn("failed to send bulk indexing request", "err", err)
-		return fmt.Errorf("failed to send bulk indexing request: %w", err)
+		ops = append(ops, BulkOp{Action: BulkUpdate, DocID: pr.did.String(), Body: updateScriptJSON})
 	}
-	defer res.Body.Close()
 
-	if res.IsError() {
-		body, err := io.ReadAll(res.Body)
-		if err != nil {
-			log.Warn("failed to read bulk indexing response", "err", err)
-			return fmt.Errorf("failed to read bulk indexing response: %w", err)
-		}
-		log.Warn("opensearch bulk indexing error", "status_code", res.StatusCode, "response", res, "body", string(body))
-		return fmt.Errorf("bulk indexing error, code=%d", res.StatusCode)
+	if err := idx.bulk(ctx, idx.profileIndex, ops); err != nil {
+		log.Warn("bulk indexing error", "err", err)
+		return fmt.Errorf("bulk indexing error: %w", err)
 	}
 
 	return nil
@@ -574,31 +500,14 @@ func (idx *Indexer) updateUserHandle(ctx context.Context, did syntax.DID, handle
 		return err
 	}
 
-	req := esapi.UpdateRequest{
-		Index:      idx.profileIndex,
-		DocumentID: did.String(),
-		Body:       bytes.NewReader(b),
-	}
-
 	err = idx.indexLimiter.Wait(ctx)
 	if err != nil {
 		log.Warn("failed to wait for rate limiter", "err", err)
 		return err
 	}
-	res, err := req.Do(ctx, idx.escli)
-	if err != nil {
-		log.Warn("failed to send indexing request", "err", err)
-		return fmt.Errorf("failed to send indexing request: %w", err)
-	}
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		log.Warn("failed to read indexing response", "err", err)
-		return fmt.Errorf("failed to read indexing response: %w", err)
-	}
-	if res.IsError() {
-		log.Warn("opensearch indexing error", "status_code", res.StatusCode, "response", res, "body", string(body))
-		return fmt.Errorf("indexing error, code=%d", res.StatusCode)
+	if err := idx.updateDoc(ctx, idx.profileIndex, did.String(), b); err != nil {
+		log.Warn("indexing error", "err", err)
+		return fmt.Errorf("indexing error: %w", err)
 	}
 	return nil
 }
@@ -0,0 +1,164 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Minimum number of letters in a text before attempting language detection
+const detectMinLetters = 10
+
+// Per-language text fields in the post index, keyed by 2-char language code. Each is analyzed with a language-specific analyzer (stemming, stop words); see post_schema.json
+var langTextFields = map[string]string{
+	"ja": "text_ja",
+	"en": "text_en",
+	"es": "text_es",
+	"pt": "text_pt",
+	"fr": "text_fr",
+	"de": "text_de",
+	"it": "text_it",
+	"nl": "text_nl",
+	"ru": "text_ru",
+}
+
+// Common short words, used to tell apart languages written in the Latin script. Words shared between languages count towards each of them.
+var latinStopWords = map[string][]string{
+	"en": {"the", "and", "is", "are", "was", "of", "to", "this", "that", "with", "for", "have", "you", "it", "not", "but", "what", "which", "be", "my", "just", "so", "at", "as", "in", "on"},
+	"es": {"el", "los", "las", "y", "es", "una", "pero", "por", "para", "que", "con", "como", "muy", "del", "este", "esta", "yo", "lo", "su", "más", "también"},
+	"pt": {"os", "as", "um", "uma", "é", "não", "mas", "com", "para", "que", "do", "da", "dos", "das", "eu", "você", "isso", "muito", "também", "mais", "ao", "na", "no"},
+	"fr": {"le", "la", "les", "et", "est", "un", "une", "des", "du", "pas", "mais", "avec", "pour", "je", "vous", "nous", "il", "elle", "ce", "qui", "sur", "très", "aussi"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "ich", "mit", "auf", "für", "auch", "sich", "aber", "wie", "noch", "war", "sind", "zu", "den", "dem"},
+	"it": {"il", "lo", "gli", "e", "è", "di", "che", "non", "una", "per", "con", "ma", "sono", "questo", "anche", "molto", "della", "del", "io"},
+	"nl": {"de", "het", "een", "en", "is", "niet", "dat", "van", "ik", "met", "op", "voor", "maar", "ook", "zijn", "je", "wat", "nog", "er"},
+}
+
+var latinStopWordSets = func() map[string]map[string]bool {
+	sets := make(map[string]map[string]bool, len(latinStopWords))
+	for lang, words := range latinStopWords {
+		sets[lang] = make(map[string]bool, len(words))
+		for _, w := range words {
+			sets[lang][w] = true
+		}
+	}
+	return sets
+}()
+
+// detectLanguage guesses the language of a text, returning a 2-char language code, or the empty string if the text is too short or ambiguous.
+//
+// Most languages are identified by their script. Languages written in the Latin script are told apart by common words, for the languages in latinStopWords.
+func detectLanguage(text string) string {
+	var letters, kana, hangul, han, thai, cyrillic, ukrainian, arabic, persian, hebrew, greek, devanagari, latin int
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Hangul, r):
+			hangul++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Thai, r):
+			thai++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+			if strings.ContainsRune("ієїґІЄЇҐ", r) {
+				ukrainian++
+			}
+		case unicode.Is(unicode.Arabic, r):
+			arabic++
+			if strings.ContainsRune("پچژگ", r) {
+				persian++
+			}
+		case unicode.Is(unicode.Hebrew, r):
+			hebrew++
+		case unicode.Is(unicode.Greek, r):
+			greek++
+		case unicode.Is(unicode.Devanagari, r):
+			devanagari++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+
+	// any kana at all means Japanese, which also uses Han characters
+	if kana > 0 {
+		return "ja"
+	}
+
+	majority := func(n int) bool { return n*2 > letters }
+	switch {
+	case majority(hangul):
+		return "ko"
+	case majority(han):
+		return "zh"
+	case majority(thai):
+		return "th"
+	case majority(cyrillic):
+		if ukrainian > 0 {
+			return "uk"
+		}
+		return "ru"
+	case majority(arabic):
+		if persian > 0 {
+			return "fa"
+		}
+		return "ar"
+	case majority(hebrew):
+		return "he"
+	case majority(greek):
+		return "el"
+	case majority(devanagari):
+		return "hi"
+	case majority(latin):
+		if letters < detectMinLetters {
+			return ""
+		}
+		return detectLatinLanguage(text)
+	}
+	return ""
+}
+
+// detectLatinLanguage picks the language with the most common-word matches, requiring at least two and a clear lead over the runner-up
+func detectLatinLanguage(text string) string {
+	scores := make(map[string]int, len(latinStopWordSets))
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	for _, w := range words {
+		for lang, set := range latinStopWordSets {
+			if set[w] {
+				scores[lang]++
+			}
+		}
+	}
+
+	best, bestScore, runnerUp := "", 0, 0
+	for lang, score := range scores {
+		switch {
+		case score > bestScore:
+			runnerUp = bestScore
+			best, bestScore = lang, score
+		case score > runnerUp:
+			runnerUp = score
+		}
+	}
+	if bestScore < 2 || bestScore == runnerUp {
+		return ""
+	}
+	return best
+}
+
+// isoLangCode reduces a language tag (eg, "en-US") to its lowercase 2-char language code, or the empty string if it doesn't have one
+func isoLangCode(tag string) string {
+	prefix := strings.SplitN(tag, "-", 2)[0]
+	if len(prefix) != 2 {
+		return ""
+	}
+	return strings.ToLower(prefix)
+}
@@ -0,0 +1,42 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("", detectLanguage(""))
+	assert.Equal("", detectLanguage("🎅 🏡"))
+	assert.Equal("", detectLanguage("lol"))
+	assert.Equal("", detectLanguage("https://example.com"))
+
+	assert.Equal("en", detectLanguage("this is what happens when you leave the oven on"))
+	assert.Equal("es", detectLanguage("el perro de mi vecino es muy simpático, pero ladra por la noche"))
+	assert.Equal("pt", detectLanguage("eu não sei o que você está fazendo, mas isso é muito legal"))
+	assert.Equal("fr", detectLanguage("je ne sais pas pourquoi il pleut toujours le dimanche"))
+	assert.Equal("de", detectLanguage("ich habe heute keine Zeit, aber morgen ist auch noch ein Tag"))
+	assert.Equal("it", detectLanguage("non ho capito perché questo è successo, ma va bene"))
+	assert.Equal("nl", detectLanguage("ik weet niet wat het is, maar het is ook niet belangrijk"))
+
+	assert.Equal("ja", detectLanguage("学校から帰って熱いお風呂に入ったら力一杯がんばる"))
+	assert.Equal("zh", detectLanguage("熱力學是研究熱現象中物態轉變和能量轉換規律的學科"))
+	assert.Equal("ko", detectLanguage("오늘 날씨가 정말 좋네요"))
+	assert.Equal("ru", detectLanguage("сегодня очень хорошая погода"))
+	assert.Equal("uk", detectLanguage("сьогодні дуже гарна погода, їдемо"))
+	assert.Equal("ar", detectLanguage("الطقس جميل اليوم"))
+	assert.Equal("el", detectLanguage("σήμερα ο καιρός είναι ωραίος"))
+}
+
+func TestIsoLangCode(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("en", isoLangCode("en"))
+	assert.Equal("en", isoLangCode("en-US"))
+	assert.Equal("pt", isoLangCode("PT-br"))
+	assert.Equal("", isoLangCode("eng"))
+	assert.Equal("", isoLangCode(""))
+}
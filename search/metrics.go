@@ -32,6 +32,16 @@ var postsDeleted = promauto.NewCounter(prometheus.CounterOpts{
 	Help: "Number of posts deleted",
 })
 
+var postsEmbedded = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "search_posts_embedded",
+	Help: "Number of post embeddings computed",
+})
+
+var embeddingsFailed = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "search_embeddings_failed",
+	Help: "Number of post embeddings which failed to be computed",
+})
+
 var profilesReceived = promauto.NewCounter(prometheus.CounterOpts{
 	Name: "search_profiles_received",
 	Help: "Number of profiles received",
@@ -52,6 +62,36 @@ var profilesDeleted = promauto.NewCounter(prometheus.CounterOpts{
 	Help: "Number of profiles deleted",
 })
 
+var recordsIndexed = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "search_records_indexed",
+	Help: "Number of feed generator, list, and starter pack records indexed",
+})
+
+var recordsDeleted = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "search_records_deleted",
+	Help: "Number of feed generator, list, and starter pack records deleted",
+})
+
+var indexQueueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "search_index_queue_length",
+	Help: "Number of jobs waiting in an indexing queue",
+}, []string{"queue"})
+
+var indexQueueBlocked = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "search_index_queue_blocked_seconds",
+	Help: "Time spent waiting to add jobs to a full indexing queue (backpressure on the firehose consumer)",
+}, []string{"queue"})
+
+var bulkRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "search_bulk_retries",
+	Help: "Number of retries of bulk writes, of either individual failed operations or entire requests",
+}, []string{"kind"})
+
+var bulkOpsDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "search_bulk_ops_dropped",
+	Help: "Number of bulk write operations which failed and were not retried, or ran out of retries",
+})
+
 var currentSeq = promauto.NewGauge(prometheus.GaugeOpts{
 	Name: "search_current_seq",
 	Help: "Current sequence number",
@@ -84,7 +124,7 @@ var resSz = promauto.NewHistogramVec(prometheus.HistogramOpts{
 func MetricsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		path := c.Path()
-		if path == "/metrics" || path == "/_health" {
+		if path == "/metrics" || path == "/_health" || path == "/healthz" || path == "/readyz" {
 			return next(c)
 		}
 
@@ -0,0 +1,383 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	es "github.com/opensearch-project/opensearch-go/v2"
+	esapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+type OpenSearchConfig struct {
+	// Cluster node URLs (scheme, host, and port)
+	Addresses []string
+	// Basic auth credentials, if the cluster has the security plugin enabled
+	Username string
+	Password string
+	// PEM certificate(s) of a private CA for the cluster's TLS certificates
+	CACert []byte
+	// Skip TLS certificate verification, eg for a local cluster with self-signed certificates
+	InsecureSkipVerify bool
+}
+
+// OpenSearchBackend is a Backend for an OpenSearch cluster
+type OpenSearchBackend struct {
+	client *es.Client
+}
+
+var _ Backend = (*OpenSearchBackend)(nil)
+
+// NewOpenSearchBackend connects to an OpenSearch cluster, failing if it can't be reached
+func NewOpenSearchBackend(config OpenSearchConfig) (*OpenSearchBackend, error) {
+	client, err := es.NewClient(es.Config{
+		Addresses: config.Addresses,
+		Username:  config.Username,
+		Password:  config.Password,
+		CACert:    config.CACert,
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: 20,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: config.InsecureSkipVerify,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up client: %w", err)
+	}
+
+	info, err := client.Info()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get opensearch info: %w", err)
+	}
+	defer info.Body.Close()
+	if info.IsError() {
+		return nil, fmt.Errorf("cannot get opensearch info, code=%d", info.StatusCode)
+	}
+	slog.Debug("opensearch client initialized", "info", info)
+
+	return &OpenSearchBackend{client: client}, nil
+}
+
+// Client returns the underlying OpenSearch client, for operations not covered by Backend
+func (b *OpenSearchBackend) Client() *es.Client {
+	return b.client
+}
+
+// responseError drains a response, returning an error including the response body if it failed
+func responseError(res *esapi.Response, what string) error {
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %w", what, err)
+	}
+	if res.IsError() {
+		slog.Warn("opensearch error", "op", what, "status_code", res.StatusCode, "body", string(body))
+		return &StatusError{Op: what, Status: res.StatusCode}
+	}
+	return nil
+}
+
+func (b *OpenSearchBackend) IndexExists(ctx context.Context, index string) (bool, error) {
+	res, err := b.client.Indices.Exists([]string{index}, b.client.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	io.ReadAll(res.Body)
+
+	switch {
+	case res.StatusCode == 404:
+		return false, nil
+	case res.IsError():
+		return false, fmt.Errorf("failed to check index existence, code=%d", res.StatusCode)
+	}
+	return true, nil
+}
+
+func (b *OpenSearchBackend) CreateIndex(ctx context.Context, index string, schemaJSON string) error {
+	res, err := b.client.Indices.Create(
+		index,
+		b.client.Indices.Create.WithContext(ctx),
+		b.client.Indices.Create.WithBody(strings.NewReader(schemaJSON)),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return responseError(res, "create index")
+}
+
+// bulkResponse is the part of a bulk API response needed to find failed operations. A bulk request succeeds (HTTP 200) even if some of its operations fail
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkResponseItem `json:"items"`
+}
+
+type bulkResponseItem struct {
+	ID     string          `json:"_id"`
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+func (b *OpenSearchBackend) Bulk(ctx context.Context, index string, ops []BulkOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, op := range ops {
+		meta, err := json.Marshal(map[string]any{
+			op.Action: map[string]string{"_id": op.DocID},
+		})
+		if err != nil {
+			return err
+		}
+
+		buf.Grow(len(meta) + len(op.Body) + 2)
+		buf.Write(meta)
+		buf.WriteByte('\n')
+		buf.Write(op.Body)
+		buf.WriteByte('\n')
+	}
+
+	res, err := b.client.Bulk(
+		bytes.NewReader(buf.Bytes()),
+		b.client.Bulk.WithContext(ctx),
+		b.client.Bulk.WithIndex(index),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to send bulk request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return responseError(res, "bulk")
+	}
+
+	var out bulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+	if !out.Errors {
+		return nil
+	}
+
+	bulkErr := &BulkError{Total: len(ops)}
+	for i, item := range out.Items {
+		for _, r := range item {
+			if r.Status < 300 {
+				continue
+			}
+			if _, ok := item[BulkCreate]; ok && r.Status == http.StatusConflict {
+				// document already exists
+				continue
+			}
+			bulkErr.Failures = append(bulkErr.Failures, BulkFailure{Op: i, DocID: r.ID, Status: r.Status, Reason: string(r.Error)})
+		}
+	}
+	if len(bulkErr.Failures) == 0 {
+		return nil
+	}
+	return bulkErr
+}
+
+func (b *OpenSearchBackend) Update(ctx context.Context, index string, docID string, body []byte) error {
+	req := esapi.UpdateRequest{
+		Index:      index,
+		DocumentID: docID,
+		Body:       bytes.NewReader(body),
+	}
+	res, err := req.Do(ctx, b.client)
+	if err != nil {
+		return fmt.Errorf("failed to send update request: %w", err)
+	}
+	defer res.Body.Close()
+	return responseError(res, "update")
+}
+
+func (b *OpenSearchBackend) Delete(ctx context.Context, index string, docID string) error {
+	req := esapi.DeleteRequest{
+		Index:      index,
+		DocumentID: docID,
+		Refresh:    "true",
+	}
+	res, err := req.Do(ctx, b.client)
+	if err != nil {
+		return fmt.Errorf("failed to send delete request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		io.ReadAll(res.Body)
+		return nil
+	}
+	return responseError(res, "delete")
+}
+
+func (b *OpenSearchBackend) Refresh(ctx context.Context, index string) error {
+	res, err := b.client.Indices.Refresh(
+		b.client.Indices.Refresh.WithContext(ctx),
+		b.client.Indices.Refresh.WithIndex(index),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return responseError(res, "refresh")
+}
+
+func (b *OpenSearchBackend) Search(ctx context.Context, index string, query []byte) (*EsSearchResponse, error) {
+	res, err := b.client.Search(
+		b.client.Search.WithContext(ctx),
+		b.client.Search.WithIndex(index),
+		b.client.Search.WithBody(bytes.NewReader(query)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("search query error: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, responseError(res, "search query")
+	}
+
+	var out EsSearchResponse
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decoding search response: %w", err)
+	}
+	return &out, nil
+}
+
+func (b *OpenSearchBackend) Count(ctx context.Context, index string) (int64, error) {
+	res, err := b.client.Count(
+		b.client.Count.WithContext(ctx),
+		b.client.Count.WithIndex(index),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("count error: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, responseError(res, "count")
+	}
+
+	var out struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return 0, fmt.Errorf("decoding count response: %w", err)
+	}
+	return out.Count, nil
+}
+
+func (b *OpenSearchBackend) Health(ctx context.Context) error {
+	res, err := b.client.Cluster.Health(b.client.Cluster.Health.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("cluster health error: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return responseError(res, "cluster health")
+	}
+
+	var out struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return fmt.Errorf("decoding cluster health response: %w", err)
+	}
+	// yellow (unassigned replicas) can still serve everything
+	if out.Status == "red" {
+		return fmt.Errorf("cluster status is red")
+	}
+	return nil
+}
+
+func (b *OpenSearchBackend) DeleteIndex(ctx context.Context, index string) error {
+	res, err := b.client.Indices.Delete(
+		[]string{index},
+		b.client.Indices.Delete.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		io.ReadAll(res.Body)
+		return nil
+	}
+	return responseError(res, "delete index")
+}
+
+func (b *OpenSearchBackend) AliasIndices(ctx context.Context, alias string) ([]string, error) {
+	res, err := b.client.Indices.GetAlias(
+		b.client.Indices.GetAlias.WithContext(ctx),
+		b.client.Indices.GetAlias.WithName(alias),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		io.ReadAll(res.Body)
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, responseError(res, "get alias")
+	}
+
+	// keyed by index name
+	var out map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decoding alias response: %w", err)
+	}
+	indices := make([]string, 0, len(out))
+	for index := range out {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+func (b *OpenSearchBackend) SwapAlias(ctx context.Context, alias, index string) error {
+	current, err := b.AliasIndices(ctx, alias)
+	if err != nil {
+		return err
+	}
+
+	var actions []map[string]any
+	if current == nil {
+		exists, err := b.IndexExists(ctx, alias)
+		if err != nil {
+			return err
+		}
+		if exists {
+			actions = append(actions, map[string]any{"remove_index": map[string]string{"index": alias}})
+		}
+	}
+	for _, c := range current {
+		if c != index {
+			actions = append(actions, map[string]any{"remove": map[string]string{"index": c, "alias": alias}})
+		}
+	}
+	actions = append(actions, map[string]any{"add": map[string]string{"index": index, "alias": alias}})
+
+	body, err := json.Marshal(map[string]any{"actions": actions})
+	if err != nil {
+		return err
+	}
+	res, err := b.client.Indices.UpdateAliases(
+		bytes.NewReader(body),
+		b.client.Indices.UpdateAliases.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return responseError(res, "update aliases")
+}
@@ -0,0 +1,185 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testOpenSearchBackend(t *testing.T, handlers map[string]http.HandlerFunc) *OpenSearchBackend {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"version":{"distribution":"opensearch","number":"2.11.0"}}`)
+	})
+	for pattern, h := range handlers {
+		mux.HandleFunc(pattern, h)
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	backend, err := NewOpenSearchBackend(OpenSearchConfig{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func TestOpenSearchBulk(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var body string
+	backend := testOpenSearchBackend(t, map[string]http.HandlerFunc{"/palomar_post/_bulk": func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"errors":false,"items":[{"index":{"_id":"a","status":201}},{"update":{"_id":"b","status":200}}]}`)
+	}})
+
+	assert.NoError(backend.Bulk(ctx, "palomar_post", []BulkOp{
+		{Action: BulkIndex, DocID: "a", Body: []byte(`{"text":"hello"}`)},
+		{Action: BulkUpdate, DocID: "b", Body: []byte(`{"doc":{"text":"world"}}`)},
+	}))
+	assert.Equal(`{"index":{"_id":"a"}}`+"\n"+`{"text":"hello"}`+"\n"+`{"update":{"_id":"b"}}`+"\n"+`{"doc":{"text":"world"}}`+"\n", body)
+}
+
+func TestOpenSearchBulkItemErrors(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	// the bulk API reports failed operations in a successful response
+	backend := testOpenSearchBackend(t, map[string]http.HandlerFunc{"/palomar_post/_bulk": func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"errors":true,"items":[{"index":{"_id":"a","status":201}},{"index":{"_id":"b","status":400,"error":{"type":"mapper_parsing_exception"}}}]}`)
+	}})
+
+	err := backend.Bulk(ctx, "palomar_post", []BulkOp{
+		{Action: BulkIndex, DocID: "a", Body: []byte(`{}`)},
+		{Action: BulkIndex, DocID: "b", Body: []byte(`{}`)},
+	})
+	if assert.Error(err) {
+		assert.True(strings.HasPrefix(err.Error(), "1 of 2 bulk operations failed"))
+		assert.Contains(err.Error(), "mapper_parsing_exception")
+	}
+}
+
+func TestOpenSearchBulkCreateConflicts(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	// creating a document which already exists is not a failure
+	backend := testOpenSearchBackend(t, map[string]http.HandlerFunc{"/palomar_post/_bulk": func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"errors":true,"items":[{"create":{"_id":"a","status":201}},{"create":{"_id":"b","status":409,"error":{"type":"version_conflict_engine_exception"}}}]}`)
+	}})
+
+	assert.NoError(backend.Bulk(ctx, "palomar_post", []BulkOp{
+		{Action: BulkCreate, DocID: "a", Body: []byte(`{}`)},
+		{Action: BulkCreate, DocID: "b", Body: []byte(`{}`)},
+	}))
+}
+
+func TestOpenSearchSwapAlias(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var actions string
+	backend := testOpenSearchBackend(t, map[string]http.HandlerFunc{
+		"/_alias/palomar_post": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"palomar_post_20240101000000":{"aliases":{"palomar_post":{}}}}`)
+		},
+		// an index from before palomar used aliases
+		"/_alias/palomar_profile": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(404)
+			io.WriteString(w, `{"error":"alias [palomar_profile] missing","status":404}`)
+		},
+		"/palomar_profile": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+		},
+		"/_aliases": func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			actions = string(b)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"acknowledged":true}`)
+		},
+	})
+
+	indices, err := backend.AliasIndices(ctx, "palomar_post")
+	assert.NoError(err)
+	assert.Equal([]string{"palomar_post_20240101000000"}, indices)
+
+	assert.NoError(backend.SwapAlias(ctx, "palomar_post", "palomar_post_20240202000000"))
+	assert.JSONEq(`{"actions":[
+		{"remove":{"index":"palomar_post_20240101000000","alias":"palomar_post"}},
+		{"add":{"index":"palomar_post_20240202000000","alias":"palomar_post"}}
+	]}`, actions)
+
+	indices, err = backend.AliasIndices(ctx, "palomar_profile")
+	assert.NoError(err)
+	assert.Nil(indices)
+
+	assert.NoError(backend.SwapAlias(ctx, "palomar_profile", "palomar_profile_20240202000000"))
+	assert.JSONEq(`{"actions":[
+		{"remove_index":{"index":"palomar_profile"}},
+		{"add":{"index":"palomar_profile_20240202000000","alias":"palomar_profile"}}
+	]}`, actions)
+}
+
+func TestSearchProfilesTypeahead(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var body map[string]any
+	backend := testOpenSearchBackend(t, map[string]http.HandlerFunc{
+		"/palomar_profile/_search": func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			body = nil
+			json.Unmarshal(b, &body)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"hits":{"hits":[]}}`)
+		},
+	})
+
+	follows := []syntax.DID{"did:plc:abc"}
+	params := ActorSearchParams{Query: "@alice.bs", Typeahead: true, Follows: follows, Size: 10}
+	_, err := DoSearchProfilesTypeahead(ctx, backend, "palomar_profile", &params)
+	assert.NoError(err)
+
+	functionScore := body["query"].(map[string]any)["function_score"].(map[string]any)
+	boolQuery := functionScore["query"].(map[string]any)["bool"].(map[string]any)
+	should := boolQuery["should"].([]any)
+	// leading "@" is stripped
+	assert.Equal("alice.bs", should[0].(map[string]any)["term"].(map[string]any)["handle"].(map[string]any)["value"])
+	assert.Contains(should[1].(map[string]any)["match"], "handle.prefix")
+	assert.Contains(should[2].(map[string]any)["match"], "display_name.prefix")
+	assert.Equal("AUTO", should[4].(map[string]any)["match"].(map[string]any)["display_name"].(map[string]any)["fuzziness"])
+	assert.Len(boolQuery["filter"], 1)
+	assert.Len(functionScore["functions"], 2)
+	// typeahead only pages with offsets
+	assert.NotContains(body, "sort")
+}
+
+func TestOpenSearchHealth(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	status := "yellow"
+	backend := testOpenSearchBackend(t, map[string]http.HandlerFunc{"/_cluster/health": func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"cluster_name":"test","status":"`+status+`"}`)
+	}})
+
+	assert.NoError(backend.Health(ctx))
+	status = "red"
+	assert.Error(backend.Health(ctx))
+}
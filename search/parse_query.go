@@ -10,7 +10,14 @@ import (
 	"github.com/bluesky-social/indigo/atproto/syntax"
 )
 
-// ParseQuery takes a query string and pulls out some facet patterns ("from:handle.net") as filters
+// Kinds of embed which can be required with has: in a post query string
+var postHasFilters = map[string]bool{
+	"image": true,
+	"link":  true,
+	"quote": true,
+}
+
+// ParseQuery takes a query string and pulls out some facet patterns ("from:handle.net") as filters. Most filters can be negated with a "-" prefix ("-from:handle.net")
 func ParsePostQuery(ctx context.Context, dir identity.Directory, raw string, viewer *syntax.DID) PostSearchParams {
 	quoted := false
 	parts := strings.FieldsFunc(raw, func(r rune) bool {
@@ -24,37 +31,49 @@ func ParsePostQuery(ctx context.Context, dir identity.Directory, raw string, vie
 
 	keep := make([]string, 0, len(parts))
 	for _, p := range parts {
-		// pass-through quoted, either phrase or single token
-		if strings.HasPrefix(p, "\"") {
+		// pass-through quoted, either phrase or single token (including negated phrases)
+		if strings.HasPrefix(p, "\"") || strings.HasPrefix(p, "-\"") {
 			keep = append(keep, p)
 			continue
 		}
 
+		tok := p
+		negated := false
+		if strings.HasPrefix(p, "-") && len(p) > 1 {
+			tok = p[1:]
+			negated = true
+		}
+
 		// tags (array)
-		if strings.HasPrefix(p, "#") && len(p) > 1 {
-			params.Tags = append(params.Tags, p[1:])
+		if strings.HasPrefix(tok, "#") && len(tok) > 1 {
+			if negated {
+				params.NotTags = append(params.NotTags, tok[1:])
+			} else {
+				params.Tags = append(params.Tags, tok[1:])
+			}
 			continue
 		}
 
 		// handle (mention)
-		if strings.HasPrefix(p, "@") && len(p) > 1 {
-			handle, err := syntax.ParseHandle(p[1:])
+		if strings.HasPrefix(tok, "@") && len(tok) > 1 {
+			handle, err := syntax.ParseHandle(tok[1:])
 			if err != nil {
 				keep = append(keep, p)
 				continue
 			}
-			id, err := dir.LookupHandle(ctx, handle)
-			if err != nil {
-				if err != identity.ErrHandleNotFound {
-					slog.Error("failed to resolve handle", "err", err)
-				}
+			did := lookupQueryHandle(ctx, dir, handle)
+			if did == nil {
 				continue
 			}
-			params.Mentions = &id.DID
+			if negated {
+				params.NotMentions = append(params.NotMentions, *did)
+			} else {
+				params.Mentions = did
+			}
 			continue
 		}
 
-		tokParts := strings.SplitN(p, ":", 2)
+		tokParts := strings.SplitN(tok, ":", 2)
 		if len(tokParts) == 1 {
 			keep = append(keep, p)
 			continue
@@ -63,55 +82,91 @@ func ParsePostQuery(ctx context.Context, dir identity.Directory, raw string, vie
 		switch tokParts[0] {
 		case "did":
 			// Used as a hack for `from:me` when suppplied by the client
-			did, err := syntax.ParseDID(p)
+			did, err := syntax.ParseDID(tok)
 			if err != nil {
 				continue
 			}
-			params.Author = &did
+			if negated {
+				params.NotAuthors = append(params.NotAuthors, did)
+			} else {
+				params.Author = &did
+			}
 			continue
 		case "from", "to", "mentions":
 			raw := tokParts[1]
+			var did *syntax.DID
 			if raw == "me" {
-				if viewer != nil && tokParts[0] == "from" {
-					params.Author = viewer
-				} else if viewer != nil {
-					params.Mentions = viewer
+				did = viewer
+			} else {
+				if strings.HasPrefix(raw, "@") && len(raw) > 1 {
+					raw = raw[1:]
 				}
-				continue
-			}
-			if strings.HasPrefix(raw, "@") && len(raw) > 1 {
-				raw = raw[1:]
+				handle, err := syntax.ParseHandle(raw)
+				if err != nil {
+					continue
+				}
+				did = lookupQueryHandle(ctx, dir, handle)
 			}
-			handle, err := syntax.ParseHandle(raw)
-			if err != nil {
+			if did == nil {
 				continue
 			}
-			id, err := dir.LookupHandle(ctx, handle)
-			if err != nil {
-				if err != identity.ErrHandleNotFound {
-					slog.Error("failed to resolve handle", "err", err)
-				}
-				continue
-			}
-			if tokParts[0] == "from" {
-				params.Author = &id.DID
-			} else {
-				params.Mentions = &id.DID
+			switch {
+			case tokParts[0] == "from" && negated:
+				params.NotAuthors = append(params.NotAuthors, *did)
+			case tokParts[0] == "from":
+				params.Author = did
+			case negated:
+				params.NotMentions = append(params.NotMentions, *did)
+			default:
+				params.Mentions = did
 			}
 			continue
 		case "http", "https":
+			if negated {
+				break
+			}
 			params.URL = p
 			continue
 		case "domain":
-			params.Domain = tokParts[1]
+			if negated {
+				params.NotDomains = append(params.NotDomains, tokParts[1])
+			} else {
+				params.Domain = tokParts[1]
+			}
+			continue
+		case "link-domain":
+			if negated {
+				params.NotLinkDomains = append(params.NotLinkDomains, tokParts[1])
+			} else {
+				params.LinkDomain = tokParts[1]
+			}
 			continue
 		case "lang":
 			lang, err := syntax.ParseLanguage(tokParts[1])
-			if nil == err {
+			if err != nil {
+				continue
+			}
+			if negated {
+				params.NotLangs = append(params.NotLangs, lang)
+			} else {
 				params.Lang = &lang
 			}
 			continue
+		case "has":
+			kind := strings.ToLower(strings.TrimSuffix(tokParts[1], "s"))
+			if !postHasFilters[kind] {
+				continue
+			}
+			if negated {
+				params.NotHas = append(params.NotHas, kind)
+			} else {
+				params.Has = append(params.Has, kind)
+			}
+			continue
 		case "since", "until":
+			if negated {
+				break
+			}
 			var dt syntax.Datetime
 			// first try just date
 			date, err := time.Parse(time.DateOnly, tokParts[1])
@@ -149,3 +204,15 @@ func ParsePostQuery(ctx context.Context, dir identity.Directory, raw string, vie
 	params.Query = out
 	return params
 }
+
+// lookupQueryHandle resolves a handle in a query string, returning nil if it can't be resolved
+func lookupQueryHandle(ctx context.Context, dir identity.Directory, handle syntax.Handle) *syntax.DID {
+	id, err := dir.LookupHandle(ctx, handle)
+	if err != nil {
+		if err != identity.ErrHandleNotFound {
+			slog.Error("failed to resolve handle", "err", err)
+		}
+		return nil
+	}
+	return &id.DID
+}
@@ -94,5 +94,48 @@ func TestParseQuery(t *testing.T) {
 		assert.Equal("did:plc:abc222", p.Author.String())
 	}
 
-	// TODO: more parsing tests: bare handles, to:, since:, until:, URL, domain:, lang
+	q10 := `"exact phrase" -"not this" to:known.example.com domain:example.com since:2024-01-02 until:2024-02-03T04:05:06Z lang:pt-BR has:images`
+	p = ParsePostQuery(ctx, &dir, q10, nil)
+	assert.Equal(`"exact phrase" -"not this"`, p.Query)
+	if assert.NotNil(p.Mentions) {
+		assert.Equal("did:plc:abc222", p.Mentions.String())
+	}
+	assert.Equal("example.com", p.Domain)
+	if assert.NotNil(p.Since) {
+		assert.Equal("2024-01-02T00:00:00Z", p.Since.String())
+	}
+	if assert.NotNil(p.Until) {
+		assert.Equal("2024-02-03T04:05:06Z", p.Until.String())
+	}
+	if assert.NotNil(p.Lang) {
+		assert.Equal("pt-BR", p.Lang.String())
+	}
+	assert.Equal([]string{"image"}, p.Has)
+	assert.Equal(6, len(p.Filters()))
+	assert.Empty(p.Exclusions())
+
+	// negated filters
+	q11 := `cats -dogs -from:known.example.com -domain:spam.example.com -lang:en -#nsfw -has:link -@known.example.com -has:nonsense`
+	p = ParsePostQuery(ctx, &dir, q11, nil)
+	assert.Equal("cats -dogs", p.Query)
+	assert.Nil(p.Author)
+	assert.Empty(p.Filters())
+	assert.Equal([]syntax.DID{"did:plc:abc222"}, p.NotAuthors)
+	assert.Equal([]syntax.DID{"did:plc:abc222"}, p.NotMentions)
+	assert.Equal([]string{"spam.example.com"}, p.NotDomains)
+	assert.Equal([]syntax.Language{"en"}, p.NotLangs)
+	assert.Equal([]string{"nsfw"}, p.NotTags)
+	assert.Equal([]string{"link"}, p.NotHas)
+	assert.Equal(6, len(p.Exclusions()))
+
+	// link card host
+	q12 := `news link-domain:example.com -link-domain:spam.example.com`
+	p = ParsePostQuery(ctx, &dir, q12, nil)
+	assert.Equal("news", p.Query)
+	assert.Equal("example.com", p.LinkDomain)
+	assert.Equal([]string{"spam.example.com"}, p.NotLinkDomains)
+	assert.Equal(1, len(p.Filters()))
+	assert.Equal(1, len(p.Exclusions()))
+
+	// TODO: more parsing tests: bare handles, URL
 }
@@ -0,0 +1,229 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// BulkConfig controls how documents are batched in to bulk requests to the search backend, and how failed writes are retried
+type BulkConfig struct {
+	// Max number of documents in a batch (default 1000)
+	MaxDocs int
+	// Max size of a single bulk request body; larger batches are split across requests (default 10 MiB)
+	MaxBytes int
+	// Max time a document waits in a partial batch (default 5s)
+	FlushInterval time.Duration
+	// Max attempts for an operation which failed with a retryable status (429 or 5xx) in an otherwise successful bulk request, after which it is dropped (default 5)
+	MaxRetries int
+	// Delay before the first retry, doubling on each retry up to MaxBackoff (defaults 500ms and 30s)
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+	// Capacity of each indexing queue. When a queue is full, the firehose consumer blocks until there is room (default 1000)
+	QueueSize int
+}
+
+func (c BulkConfig) withDefaults() BulkConfig {
+	if c.MaxDocs <= 0 {
+		c.MaxDocs = 1000
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = 10 * 1024 * 1024
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = 5 * time.Second
+	}
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = 5
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = 500 * time.Millisecond
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 30 * time.Second
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 1000
+	}
+	return c
+}
+
+// enqueue sends a job to an indexing queue. If the queue is full, because the bulk indexer is behind (eg, the search cluster is slow or unavailable), this blocks until there is room, which slows down the firehose consumer
+func enqueue[T any](ctx context.Context, name string, queue chan<- T, job T) error {
+	select {
+	case queue <- job:
+		return nil
+	default:
+	}
+
+	start := time.Now()
+	defer func() {
+		indexQueueBlocked.WithLabelValues(name).Add(time.Since(start).Seconds())
+	}()
+	select {
+	case queue <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runBatcher collects jobs from a queue in to batches, and passes each to flush once it reaches MaxDocs or after FlushInterval. While flush is blocked (eg, retrying writes), jobs back up in the queue, and then in enqueue
+func runBatcher[T any](ctx context.Context, idx *Indexer, name string, queue <-chan T, flush func(context.Context, []T) error) {
+	ctx, span := tracer.Start(ctx, "runBatcher")
+	defer span.End()
+
+	cfg := idx.bulkConfig
+	tick := time.NewTicker(cfg.FlushInterval)
+	defer tick.Stop()
+
+	var batch []T
+	doFlush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := idx.indexLimiter.WaitN(ctx, len(batch)); err != nil {
+			idx.logger.Error("failed to wait for rate limiter", "queue", name, "err", err)
+			return
+		}
+		if err := flush(ctx, batch); err != nil {
+			idx.logger.Error("failed to index batch", "queue", name, "size", len(batch), "err", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			indexQueueLength.WithLabelValues(name).Set(float64(len(queue)))
+			doFlush()
+		case job := <-queue:
+			batch = append(batch, job)
+			if len(batch) >= cfg.MaxDocs {
+				doFlush()
+			}
+		}
+	}
+}
+
+// bulkFailedCount is the number of operations which failed in a bulk write that returned err: either those in a *BulkError, or all of them
+func bulkFailedCount(err error, total int) int {
+	var bulkErr *BulkError
+	if errors.As(err, &bulkErr) {
+		return len(bulkErr.Failures)
+	}
+	return total
+}
+
+// splitBulkOps splits ops in to chunks with bodies of at most maxBytes (but at least one op each)
+func splitBulkOps(ops []BulkOp, maxBytes int) [][]BulkOp {
+	var chunks [][]BulkOp
+	start, size := 0, 0
+	for i, op := range ops {
+		// action and metadata line, plus newlines
+		opSize := len(op.Body) + len(op.DocID) + 32
+		if i > start && size+opSize > maxBytes {
+			chunks = append(chunks, ops[start:i])
+			start, size = i, 0
+		}
+		size += opSize
+	}
+	if start < len(ops) {
+		chunks = append(chunks, ops[start:])
+	}
+	return chunks
+}
+
+// writeBulk applies ops to an index, in requests of at most cfg.MaxBytes, retrying failures:
+//
+//   - operations which failed with a retryable status (see BulkFailure.Retryable) are retried on their own, up to cfg.MaxRetries attempts
+//   - if the whole request failed because the cluster was unreachable or overloaded, it is retried until it succeeds or ctx is done, holding up the caller
+//   - operations (or requests) which were rejected are not retried
+//
+// Operations which still failed are returned as a *BulkError, with Op positions in ops.
+func writeBulk(ctx context.Context, backend Backend, logger *slog.Logger, cfg BulkConfig, index string, ops []BulkOp) error {
+	cfg = cfg.withDefaults()
+	var dropped []BulkFailure
+	offset := 0
+	for _, chunk := range splitBulkOps(ops, cfg.MaxBytes) {
+		failures, err := writeBulkChunk(ctx, backend, logger, cfg, index, chunk)
+		if err != nil {
+			return err
+		}
+		for _, f := range failures {
+			f.Op += offset
+			dropped = append(dropped, f)
+		}
+		offset += len(chunk)
+	}
+	if len(dropped) > 0 {
+		bulkOpsDropped.Add(float64(len(dropped)))
+		return &BulkError{Total: len(ops), Failures: dropped}
+	}
+	return nil
+}
+
+// writeBulkChunk sends a single bulk request with retries (see writeBulk), returning the operations which failed for good
+func writeBulkChunk(ctx context.Context, backend Backend, logger *slog.Logger, cfg BulkConfig, index string, ops []BulkOp) ([]BulkFailure, error) {
+	pending := ops
+	// position in ops of each pending op
+	positions := make([]int, len(ops))
+	for i := range positions {
+		positions[i] = i
+	}
+
+	var dropped []BulkFailure
+	backoff := cfg.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := backend.Bulk(ctx, index, pending)
+		if err == nil {
+			return dropped, nil
+		}
+
+		var bulkErr *BulkError
+		var statusErr *StatusError
+		switch {
+		case errors.As(err, &bulkErr):
+			var retry []BulkOp
+			var retryPositions []int
+			for _, f := range bulkErr.Failures {
+				if f.Op < 0 || f.Op >= len(pending) {
+					continue
+				}
+				pos := positions[f.Op]
+				if f.Retryable() && attempt < cfg.MaxRetries {
+					retry = append(retry, pending[f.Op])
+					retryPositions = append(retryPositions, pos)
+					continue
+				}
+				f.Op = pos
+				dropped = append(dropped, f)
+			}
+			if len(retry) == 0 {
+				return dropped, nil
+			}
+			logger.Warn("retrying failed bulk operations", "index", index, "count", len(retry), "attempt", attempt, "err", err)
+			bulkRetries.WithLabelValues("operation").Add(float64(len(retry)))
+			pending, positions = retry, retryPositions
+		case errors.As(err, &statusErr) && !statusErr.Retryable():
+			// the request itself was rejected, so retrying won't help
+			return nil, err
+		default:
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			logger.Warn("bulk request failed, retrying", "index", index, "count", len(pending), "attempt", attempt, "backoff", backoff, "err", err)
+			bulkRetries.WithLabelValues("request").Inc()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, cfg.MaxBackoff)
+	}
+}
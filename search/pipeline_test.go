@@ -0,0 +1,162 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyBackend is a Backend whose Bulk returns queued errors, recording the doc IDs in each request
+type flakyBackend struct {
+	Backend
+	errs     []func(ops []BulkOp) error
+	requests [][]string
+}
+
+func (b *flakyBackend) Bulk(ctx context.Context, index string, ops []BulkOp) error {
+	ids := make([]string, len(ops))
+	for i, op := range ops {
+		ids[i] = op.DocID
+	}
+	b.requests = append(b.requests, ids)
+	if len(b.errs) == 0 {
+		return nil
+	}
+	f := b.errs[0]
+	b.errs = b.errs[1:]
+	return f(ops)
+}
+
+// failDocs returns a bulk response error for the ops with the given doc IDs
+func failDocs(status int, docIDs ...string) func(ops []BulkOp) error {
+	return func(ops []BulkOp) error {
+		bulkErr := &BulkError{Total: len(ops)}
+		for i, op := range ops {
+			for _, id := range docIDs {
+				if op.DocID == id {
+					bulkErr.Failures = append(bulkErr.Failures, BulkFailure{Op: i, DocID: id, Status: status})
+				}
+			}
+		}
+		return bulkErr
+	}
+}
+
+func testBulkConfig() BulkConfig {
+	return BulkConfig{MaxRetries: 3, RetryBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+}
+
+func testBulkOps(ids ...string) []BulkOp {
+	ops := make([]BulkOp, len(ids))
+	for i, id := range ids {
+		ops[i] = BulkOp{Action: BulkIndex, DocID: id, Body: []byte(`{}`)}
+	}
+	return ops
+}
+
+func TestSplitBulkOps(t *testing.T) {
+	assert := assert.New(t)
+
+	ops := []BulkOp{
+		{DocID: "a", Body: make([]byte, 60)},
+		{DocID: "b", Body: make([]byte, 60)},
+		{DocID: "c", Body: make([]byte, 200)},
+		{DocID: "d", Body: make([]byte, 10)},
+	}
+	chunks := splitBulkOps(ops, 200)
+	assert.Len(chunks, 3)
+	assert.Equal(ops[:2], chunks[0])
+	// an op bigger than the limit goes in a chunk of its own
+	assert.Equal(ops[2:3], chunks[1])
+	assert.Equal(ops[3:], chunks[2])
+
+	assert.Empty(splitBulkOps(nil, 200))
+}
+
+func TestWriteBulkRetries(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	// retryable failures are retried on their own; rejected docs are dropped
+	backend := &flakyBackend{errs: []func([]BulkOp) error{
+		failDocs(429, "b", "c"),
+		failDocs(400, "c"),
+	}}
+	err := writeBulk(ctx, backend, slog.Default(), testBulkConfig(), "palomar_post", testBulkOps("a", "b", "c"))
+	var bulkErr *BulkError
+	if assert.ErrorAs(err, &bulkErr) {
+		assert.Equal(3, bulkErr.Total)
+		assert.Equal([]BulkFailure{{Op: 2, DocID: "c", Status: 400}}, bulkErr.Failures)
+	}
+	assert.Equal([][]string{{"a", "b", "c"}, {"b", "c"}}, backend.requests)
+
+	// retryable failures are dropped after MaxRetries attempts
+	backend = &flakyBackend{errs: []func([]BulkOp) error{
+		failDocs(503, "b"),
+		failDocs(503, "b"),
+		failDocs(503, "b"),
+	}}
+	err = writeBulk(ctx, backend, slog.Default(), testBulkConfig(), "palomar_post", testBulkOps("a", "b"))
+	if assert.ErrorAs(err, &bulkErr) {
+		assert.Equal([]BulkFailure{{Op: 1, DocID: "b", Status: 503}}, bulkErr.Failures)
+	}
+	assert.Len(backend.requests, 3)
+
+	// whole requests are retried while the cluster is unavailable
+	unavailable := func([]BulkOp) error { return &StatusError{Op: "bulk", Status: 503} }
+	unreachable := func([]BulkOp) error { return fmt.Errorf("connection refused") }
+	backend = &flakyBackend{errs: []func([]BulkOp) error{unavailable, unreachable, unavailable, unreachable, unavailable}}
+	assert.NoError(writeBulk(ctx, backend, slog.Default(), testBulkConfig(), "palomar_post", testBulkOps("a", "b")))
+	assert.Len(backend.requests, 6)
+
+	// but not if the request was rejected
+	rejected := func([]BulkOp) error { return &StatusError{Op: "bulk", Status: 400} }
+	backend = &flakyBackend{errs: []func([]BulkOp) error{rejected}}
+	err = writeBulk(ctx, backend, slog.Default(), testBulkConfig(), "palomar_post", testBulkOps("a"))
+	var statusErr *StatusError
+	assert.ErrorAs(err, &statusErr)
+	assert.Len(backend.requests, 1)
+
+	// large batches are split, with failure positions relative to the whole batch
+	cfg := testBulkConfig()
+	cfg.MaxBytes = 50
+	succeed := func([]BulkOp) error { return nil }
+	backend = &flakyBackend{errs: []func([]BulkOp) error{succeed, failDocs(400, "b")}}
+	err = writeBulk(ctx, backend, slog.Default(), cfg, "palomar_post", testBulkOps("a", "b"))
+	if assert.ErrorAs(err, &bulkErr) {
+		assert.Equal([]BulkFailure{{Op: 1, DocID: "b", Status: 400}}, bulkErr.Failures)
+	}
+	assert.Equal([][]string{{"a"}, {"b"}}, backend.requests)
+	assert.Equal(1, bulkFailedCount(err, 2))
+	assert.Equal(2, bulkFailedCount(errors.New("oops"), 2))
+}
+
+func TestEnqueueBackpressure(t *testing.T) {
+	assert := assert.New(t)
+
+	queue := make(chan int, 1)
+	assert.NoError(enqueue(context.Background(), "test", queue, 1))
+
+	// a full queue blocks until there is room
+	done := make(chan error)
+	go func() { done <- enqueue(context.Background(), "test", queue, 2) }()
+	select {
+	case <-done:
+		t.Fatal("enqueue to a full queue should block")
+	case <-time.After(10 * time.Millisecond):
+	}
+	assert.Equal(1, <-queue)
+	assert.NoError(<-done)
+	assert.Equal(2, <-queue)
+
+	// or the context is done
+	queue <- 3
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(enqueue(ctx, "test", queue, 4), context.Canceled)
+}
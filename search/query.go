@@ -1,18 +1,15 @@
 package search
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log/slog"
 	"strings"
 
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 
-	es "github.com/opensearch-project/opensearch-go/v2"
 	"go.opentelemetry.io/otel/attribute"
 )
 
@@ -21,6 +18,8 @@ type EsSearchHit struct {
 	ID     string          `json:"_id"`
 	Score  float64         `json:"_score"`
 	Source json.RawMessage `json:"_source"`
+	// Sort values of the hit, if the query was sorted; used for search_after pagination
+	Sort []json.RawMessage `json:"sort,omitempty"`
 }
 
 type EsSearchHits struct {
@@ -59,11 +58,30 @@ type PostSearchParams struct {
 	Mentions *syntax.DID      `json:"mentions"`
 	Lang     *syntax.Language `json:"lang"`
 	Domain   string           `json:"domain"`
-	URL      string           `json:"url"`
-	Tags     []string         `json:"tag"`
-	Viewer   *syntax.DID      `json:"viewer"`
-	Offset   int              `json:"offset"`
-	Size     int              `json:"size"`
+	// Host of the post's link card embed (unlike Domain, which matches any link in the post)
+	LinkDomain string   `json:"link_domain"`
+	URL        string   `json:"url"`
+	Tags       []string `json:"tag"`
+	// Kinds of embed posts must have: "image", "link", or "quote"
+	Has    []string    `json:"has"`
+	Viewer *syntax.DID `json:"viewer"`
+	Offset int         `json:"offset"`
+	Size   int         `json:"size"`
+	// Sort values of the last hit of the previous page. If set, Offset is ignored
+	SearchAfter []json.RawMessage `json:"search_after"`
+	// Request hybrid keyword and semantic matching, if the server has an Embedder
+	Semantic bool `json:"semantic"`
+	// Embedding of the query text. If set, posts are matched by keyword or by kNN of their embedding, and ordered by relevance instead of recency
+	QueryEmbedding []float32 `json:"query_embedding,omitempty"`
+
+	// Posts matching any of these are excluded (from negated filters in the query string, eg "-from:handle.net")
+	NotAuthors     []syntax.DID      `json:"not_author"`
+	NotMentions    []syntax.DID      `json:"not_mentions"`
+	NotDomains     []string          `json:"not_domain"`
+	NotLinkDomains []string          `json:"not_link_domain"`
+	NotLangs       []syntax.Language `json:"not_lang"`
+	NotTags        []string          `json:"not_tag"`
+	NotHas         []string          `json:"not_has"`
 }
 
 type ActorSearchParams struct {
@@ -73,6 +91,22 @@ type ActorSearchParams struct {
 	Viewer    *syntax.DID  `json:"viewer"`
 	Offset    int          `json:"offset"`
 	Size      int          `json:"size"`
+	// Sort values of the last hit of the previous page. If set, Offset is ignored. Not supported for typeahead searches
+	SearchAfter []json.RawMessage `json:"search_after"`
+}
+
+// Params for searching feed generators, lists, and starter packs (see RecordDoc)
+type RecordSearchParams struct {
+	Query string `json:"q"`
+	// One of the RecordType* constants
+	RecordType string      `json:"record_type"`
+	Author     *syntax.DID `json:"author"`
+	// For lists, optionally filter by purpose, eg "curatelist" or "modlist"
+	ListPurpose string `json:"list_purpose"`
+	Offset      int    `json:"offset"`
+	Size        int    `json:"size"`
+	// Sort values of the last hit of the previous page. If set, Offset is ignored
+	SearchAfter []json.RawMessage `json:"search_after"`
 }
 
 // Merges params from another param object in to this one. Intended to meld parsed query with HTTP query params, so not all functionality is supported, and priority is with the "current" object
@@ -96,12 +130,25 @@ func (p *PostSearchParams) Update(other *PostSearchParams) {
 	if p.Domain == "" {
 		p.Domain = other.Domain
 	}
+	if p.LinkDomain == "" {
+		p.LinkDomain = other.LinkDomain
+	}
 	if p.URL == "" {
 		p.URL = other.URL
 	}
 	if len(p.Tags) == 0 {
 		p.Tags = other.Tags
 	}
+	if len(p.Has) == 0 {
+		p.Has = other.Has
+	}
+	p.NotAuthors = append(p.NotAuthors, other.NotAuthors...)
+	p.NotMentions = append(p.NotMentions, other.NotMentions...)
+	p.NotDomains = append(p.NotDomains, other.NotDomains...)
+	p.NotLinkDomains = append(p.NotLinkDomains, other.NotLinkDomains...)
+	p.NotLangs = append(p.NotLangs, other.NotLangs...)
+	p.NotTags = append(p.NotTags, other.NotTags...)
+	p.NotHas = append(p.NotHas, other.NotHas...)
 }
 
 // Filters turns search params in to actual elasticsearch/opensearch filter DSL
@@ -127,10 +174,13 @@ func (p *PostSearchParams) Filters() []map[string]interface{} {
 	}
 
 	if p.Lang != nil {
-		// TODO: extracting just the 2-char code would be good
+		code := isoLangCode(p.Lang.String())
+		if code == "" {
+			code = p.Lang.String()
+		}
 		filters = append(filters, map[string]interface{}{
 			"term": map[string]interface{}{"lang_code_iso2": map[string]interface{}{
-				"value":            p.Lang.String(),
+				"value":            code,
 				"case_insensitive": true,
 			}},
 		})
@@ -174,6 +224,15 @@ func (p *PostSearchParams) Filters() []map[string]interface{} {
 		})
 	}
 
+	if p.LinkDomain != "" {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]interface{}{"embed_link_domain": map[string]interface{}{
+				"value":            p.LinkDomain,
+				"case_insensitive": true,
+			}},
+		})
+	}
+
 	for _, tag := range p.Tags {
 		filters = append(filters, map[string]interface{}{
 			"term": map[string]interface{}{
@@ -185,9 +244,72 @@ func (p *PostSearchParams) Filters() []map[string]interface{} {
 		})
 	}
 
+	for _, kind := range p.Has {
+		if f := postHasFilter(kind); f != nil {
+			filters = append(filters, f)
+		}
+	}
+
 	return filters
 }
 
+// Exclusions turns negated search params in to elasticsearch/opensearch filter DSL, for a "must_not" clause
+func (p *PostSearchParams) Exclusions() []map[string]interface{} {
+	var filters []map[string]interface{}
+
+	term := func(field, value string) map[string]interface{} {
+		return map[string]interface{}{
+			"term": map[string]interface{}{field: map[string]interface{}{
+				"value":            value,
+				"case_insensitive": true,
+			}},
+		}
+	}
+
+	for _, did := range p.NotAuthors {
+		filters = append(filters, term("did", did.String()))
+	}
+	for _, did := range p.NotMentions {
+		filters = append(filters, term("mention_did", did.String()))
+	}
+	for _, domain := range p.NotDomains {
+		filters = append(filters, term("domain", domain))
+	}
+	for _, domain := range p.NotLinkDomains {
+		filters = append(filters, term("embed_link_domain", domain))
+	}
+	for _, lang := range p.NotLangs {
+		code := isoLangCode(lang.String())
+		if code == "" {
+			code = lang.String()
+		}
+		filters = append(filters, term("lang_code_iso2", code))
+	}
+	for _, tag := range p.NotTags {
+		filters = append(filters, term("tag", tag))
+	}
+	for _, kind := range p.NotHas {
+		if f := postHasFilter(kind); f != nil {
+			filters = append(filters, f)
+		}
+	}
+
+	return filters
+}
+
+// postHasFilter matches posts with a kind of embed (see PostSearchParams.Has)
+func postHasFilter(kind string) map[string]interface{} {
+	switch kind {
+	case "image":
+		return map[string]interface{}{"range": map[string]interface{}{"embed_img_count": map[string]interface{}{"gt": 0}}}
+	case "link":
+		return map[string]interface{}{"exists": map[string]interface{}{"field": "url"}}
+	case "quote":
+		return map[string]interface{}{"exists": map[string]interface{}{"field": "embed_aturi"}}
+	}
+	return nil
+}
+
 // Filters turns search params in to actual elasticsearch/opensearch filter DSL
 func (p *ActorSearchParams) Filters() []map[string]interface{} {
 	var filters []map[string]interface{}
@@ -207,6 +329,33 @@ func (p *ActorSearchParams) Filters() []map[string]interface{} {
 	return filters
 }
 
+// Filters turns search params in to actual elasticsearch/opensearch filter DSL
+func (p *RecordSearchParams) Filters() []map[string]interface{} {
+	filters := []map[string]interface{}{
+		{"term": map[string]interface{}{"record_type": p.RecordType}},
+	}
+
+	if p.Author != nil {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]interface{}{"did": map[string]interface{}{
+				"value":            p.Author.String(),
+				"case_insensitive": true,
+			}},
+		})
+	}
+
+	if p.ListPurpose != "" {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]interface{}{"list_purpose": map[string]interface{}{
+				"value":            p.ListPurpose,
+				"case_insensitive": true,
+			}},
+		})
+	}
+
+	return filters
+}
+
 func checkParams(offset, size int) error {
 	if offset+size > 10000 || size > 250 || offset > 10000 || offset < 0 || size < 0 {
 		return fmt.Errorf("disallowed size/offset parameters")
@@ -214,7 +363,7 @@ func checkParams(offset, size int) error {
 	return nil
 }
 
-func DoSearchPosts(ctx context.Context, dir identity.Directory, escli *es.Client, index string, params *PostSearchParams) (*EsSearchResponse, error) {
+func DoSearchPosts(ctx context.Context, dir identity.Directory, backend Backend, index string, params *PostSearchParams) (*EsSearchResponse, error) {
 	ctx, span := tracer.Start(ctx, "DoSearchPosts")
 	defer span.End()
 
@@ -223,14 +372,23 @@ func DoSearchPosts(ctx context.Context, dir identity.Directory, escli *es.Client
 	}
 	queryStringParams := ParsePostQuery(ctx, dir, params.Query, params.Viewer)
 	params.Update(&queryStringParams)
-	idx := "everything"
+	fields := []string{"everything"}
 	if containsJapanese(params.Query) {
-		idx = "everything_ja"
+		fields = []string{"everything_ja"}
+	}
+	if params.Lang != nil {
+		// when filtering to a language, also match against the language-specific analysis of the text (eg, stemmed words)
+		code := isoLangCode(params.Lang.String())
+		if code == "ja" {
+			fields = []string{"everything_ja"}
+		} else if f, ok := langTextFields[code]; ok {
+			fields = append(fields, f)
+		}
 	}
 	basic := map[string]interface{}{
 		"simple_query_string": map[string]interface{}{
 			"query":            params.Query,
-			"fields":           []string{idx},
+			"fields":           fields,
 			"flags":            "AND|NOT|OR|PHRASE|PRECEDENCE|WHITESPACE",
 			"default_operator": "and",
 			"lenient":          true,
@@ -247,26 +405,56 @@ func DoSearchPosts(ctx context.Context, dir identity.Directory, escli *es.Client
 			},
 		},
 	})
-	query := map[string]interface{}{
-		"query": map[string]interface{}{
-			"bool": map[string]interface{}{
-				"must":   basic,
-				"filter": filters,
-			},
-		},
-		"sort": map[string]any{
+	boolQuery := map[string]interface{}{
+		"must":   basic,
+		"filter": filters,
+	}
+	if exclusions := params.Exclusions(); len(exclusions) > 0 {
+		boolQuery["must_not"] = exclusions
+	}
+	sort := []any{
+		map[string]any{
 			"created_at": map[string]any{
 				"order": "desc",
 			},
 		},
+		sortKeyTiebreaker,
+	}
+	if len(params.QueryEmbedding) > 0 {
+		// hybrid query: either a keyword match or a near neighbor, with scores summed. The filters are applied within the kNN search as well, so that it still returns k matching posts
+		k := max(semanticMinK, params.Offset+params.Size)
+		knn := map[string]interface{}{
+			"knn": map[string]interface{}{
+				"embedding": map[string]interface{}{
+					"vector": params.QueryEmbedding,
+					"k":      k,
+					"filter": map[string]interface{}{
+						"bool": map[string]interface{}{"filter": filters},
+					},
+				},
+			},
+		}
+		delete(boolQuery, "must")
+		boolQuery["should"] = []interface{}{basic, knn}
+		boolQuery["minimum_should_match"] = 1
+		sort = []any{
+			map[string]any{"_score": map[string]any{"order": "desc"}},
+			sortKeyTiebreaker,
+		}
+	}
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": boolQuery,
+		},
+		"sort": sort,
 		"size": params.Size,
-		"from": params.Offset,
 	}
+	setPagination(query, params.Offset, params.SearchAfter)
 
-	return doSearch(ctx, escli, index, query)
+	return doSearch(ctx, backend, index, query)
 }
 
-func DoSearchProfiles(ctx context.Context, dir identity.Directory, escli *es.Client, index string, params *ActorSearchParams) (*EsSearchResponse, error) {
+func DoSearchProfiles(ctx context.Context, dir identity.Directory, backend Backend, index string, params *ActorSearchParams) (*EsSearchResponse, error) {
 	ctx, span := tracer.Start(ctx, "DoSearchProfiles")
 	defer span.End()
 
@@ -326,18 +514,94 @@ func DoSearchProfiles(ctx context.Context, dir identity.Directory, escli *es.Cli
 				"boost":                0.5,
 			},
 		},
+		"sort": []any{
+			map[string]any{"_score": map[string]any{"order": "desc"}},
+			sortKeyTiebreaker,
+		},
 		"size": params.Size,
-		"from": params.Offset,
 	}
+	setPagination(query, params.Offset, params.SearchAfter)
 
 	if len(filters) > 0 {
 		query["query"].(map[string]interface{})["bool"].(map[string]interface{})["filter"] = filters
 	}
 
-	return doSearch(ctx, escli, index, query)
+	return doSearch(ctx, backend, index, query)
 }
 
-func DoSearchProfilesTypeahead(ctx context.Context, escli *es.Client, index string, params *ActorSearchParams) (*EsSearchResponse, error) {
+func DoSearchRecords(ctx context.Context, backend Backend, index string, params *RecordSearchParams) (*EsSearchResponse, error) {
+	ctx, span := tracer.Start(ctx, "DoSearchRecords")
+	defer span.End()
+
+	if err := checkParams(params.Offset, params.Size); err != nil {
+		return nil, err
+	}
+	if _, ok := recordTypeCollections[params.RecordType]; !ok {
+		return nil, fmt.Errorf("unknown record type: %q", params.RecordType)
+	}
+
+	fulltext := map[string]interface{}{
+		"simple_query_string": map[string]interface{}{
+			"query":            params.Query,
+			"fields":           []string{"everything"},
+			"flags":            "AND|NOT|OR|PHRASE|PRECEDENCE|WHITESPACE",
+			"default_operator": "and",
+			"lenient":          true,
+			"analyze_wildcard": false,
+		},
+	}
+	primary := fulltext
+
+	// as with profiles, a single token also matches name prefixes
+	if len(strings.Split(params.Query, " ")) == 1 {
+		typeahead := map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":    params.Query,
+				"type":     "bool_prefix",
+				"operator": "and",
+				"fields": []string{
+					"typeahead",
+					"typeahead._2gram",
+					"typeahead._3gram",
+				},
+			},
+		}
+		primary = map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should": []interface{}{
+					fulltext,
+					typeahead,
+				},
+			},
+		}
+	}
+
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must":   primary,
+				"filter": params.Filters(),
+				"should": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"has_avatar": true}},
+				},
+				"minimum_should_match": 0,
+			},
+		},
+		"sort": []any{
+			map[string]any{"_score": map[string]any{"order": "desc"}},
+			sortKeyTiebreaker,
+		},
+		"size": params.Size,
+	}
+	setPagination(query, params.Offset, params.SearchAfter)
+
+	return doSearch(ctx, backend, index, query)
+}
+
+// Scale of the recency decay in typeahead ranking: profiles created this long ago get half the recency boost of new ones
+const typeaheadRecencyScale = "365d"
+
+func DoSearchProfilesTypeahead(ctx context.Context, backend Backend, index string, params *ActorSearchParams) (*EsSearchResponse, error) {
 	ctx, span := tracer.Start(ctx, "DoSearchProfilesTypeahead")
 	defer span.End()
 
@@ -347,36 +611,82 @@ func DoSearchProfilesTypeahead(ctx context.Context, escli *es.Client, index stri
 
 	filters := params.Filters()
 
+	// people often type the "@" of a handle
+	q := strings.TrimPrefix(strings.TrimSpace(params.Query), "@")
+
+	// any of: an exact handle, a handle prefix, display name word prefixes, the older combined typeahead field, or display name words with typos
+	matches := []interface{}{
+		map[string]interface{}{"term": map[string]interface{}{"handle": map[string]interface{}{"value": q, "boost": 10}}},
+		map[string]interface{}{"match": map[string]interface{}{"handle.prefix": map[string]interface{}{"query": q, "boost": 4}}},
+		map[string]interface{}{"match": map[string]interface{}{"display_name.prefix": map[string]interface{}{"query": q, "operator": "and", "boost": 2}}},
+		map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":    q,
+				"type":     "bool_prefix",
+				"operator": "and",
+				"fields": []string{
+					"typeahead",
+					"typeahead._2gram",
+					"typeahead._3gram",
+				},
+			},
+		},
+		map[string]interface{}{"match": map[string]interface{}{"display_name": map[string]interface{}{
+			"query":         q,
+			"operator":      "and",
+			"fuzziness":     "AUTO",
+			"prefix_length": 1,
+			"boost":         0.5,
+		}}},
+	}
+
+	boolQuery := map[string]interface{}{
+		"should":               matches,
+		"minimum_should_match": 1,
+	}
+	if len(filters) > 0 {
+		boolQuery["filter"] = filters
+	}
+
 	query := map[string]interface{}{
 		"query": map[string]interface{}{
-			"bool": map[string]interface{}{
-				"must": map[string]interface{}{
-					"multi_match": map[string]interface{}{
-						"query":    params.Query,
-						"type":     "bool_prefix",
-						"operator": "and",
-						"fields": []string{
-							"typeahead",
-							"typeahead._2gram",
-							"typeahead._3gram",
+			"function_score": map[string]interface{}{
+				"query": map[string]interface{}{
+					"bool": boolQuery,
+				},
+				// text relevance is scaled by the (log) follower count, plus a boost for recently created profiles
+				"functions": []interface{}{
+					map[string]interface{}{
+						"field_value_factor": map[string]interface{}{
+							"field":    "followersFuzzy",
+							"modifier": "ln2p",
+							"missing":  0,
+						},
+					},
+					map[string]interface{}{
+						"filter": map[string]interface{}{"exists": map[string]interface{}{"field": "created_at"}},
+						"gauss": map[string]interface{}{
+							"created_at": map[string]interface{}{
+								"origin": "now",
+								"scale":  typeaheadRecencyScale,
+								"decay":  0.5,
+							},
 						},
 					},
 				},
+				"score_mode": "sum",
+				"boost_mode": "multiply",
 			},
 		},
 		"size": params.Size,
 		"from": params.Offset,
 	}
 
-	if len(filters) > 0 {
-		query["query"].(map[string]interface{})["bool"].(map[string]interface{})["filter"] = filters
-	}
-
-	return doSearch(ctx, escli, index, query)
+	return doSearch(ctx, backend, index, query)
 }
 
 // helper to do a full-featured Lucene query parser (query_string) search, with all possible facets. Not safe to expose publicly.
-func DoSearchGeneric(ctx context.Context, escli *es.Client, index, q string) (*EsSearchResponse, error) {
+func DoSearchGeneric(ctx context.Context, backend Backend, index, q string) (*EsSearchResponse, error) {
 	ctx, span := tracer.Start(ctx, "DoSearchGeneric")
 	defer span.End()
 
@@ -393,10 +703,10 @@ func DoSearchGeneric(ctx context.Context, escli *es.Client, index, q string) (*E
 		},
 	}
 
-	return doSearch(ctx, escli, index, query)
+	return doSearch(ctx, backend, index, query)
 }
 
-func doSearch(ctx context.Context, escli *es.Client, index string, query interface{}) (*EsSearchResponse, error) {
+func doSearch(ctx context.Context, backend Backend, index string, query interface{}) (*EsSearchResponse, error) {
 	ctx, span := tracer.Start(ctx, "doSearch")
 	defer span.End()
 
@@ -408,28 +718,5 @@ func doSearch(ctx context.Context, escli *es.Client, index string, query interfa
 	}
 	slog.Info("sending query", "index", index, "query", string(b))
 
-	// Perform the search request.
-	res, err := escli.Search(
-		escli.Search.WithContext(ctx),
-		escli.Search.WithIndex(index),
-		escli.Search.WithBody(bytes.NewBuffer(b)),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("search query error: %w", err)
-	}
-	defer res.Body.Close()
-	if res.IsError() {
-		raw, err := ioutil.ReadAll(res.Body)
-		if nil == err {
-			slog.Warn("search query error", "resp", string(raw), "status_code", res.StatusCode)
-		}
-		return nil, fmt.Errorf("search query error, code=%d", res.StatusCode)
-	}
-
-	var out EsSearchResponse
-	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
-		return nil, fmt.Errorf("decoding search response: %w", err)
-	}
-
-	return &out, nil
+	return backend.Search(ctx, index, b)
 }
@@ -0,0 +1,539 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/repo"
+
+	"github.com/ipfs/go-cid"
+	typegen "github.com/whyrusleeping/cbor-gen"
+	"gorm.io/gorm"
+)
+
+// Reindex job states
+const (
+	ReindexRunning   = "running"
+	ReindexComplete  = "complete"
+	ReindexFailed    = "failed"
+	ReindexCancelled = "cancelled"
+)
+
+// Number of repos fetched and indexed concurrently by a reindex job
+const reindexParallelism = 8
+
+var ErrReindexRunning = errors.New("a reindex job is already running for this index")
+
+// ReindexJob is a rebuild of one of palomar's indices. A new versioned index is created alongside the live one, filled by crawling every repo on the Relay, while live firehose writes are copied to it. Once the crawl is done, the alias searches and writes go through is atomically swapped to the new index.
+type ReindexJob struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// "post", "profile", or "record"
+	Kind string `json:"kind"`
+	// Alias being rebuilt, and the new index behind it
+	Alias string `json:"alias" gorm:"index"`
+	Index string `json:"index"`
+	// Indices the alias pointed to when the job started, deleted after the swap unless KeepPrevious
+	Previous     string `json:"previous"`
+	KeepPrevious bool   `json:"keep_previous"`
+	State        string `json:"state" gorm:"index"`
+	// listRepos cursor of the last fully indexed page
+	Cursor      string     `json:"cursor"`
+	ReposDone   int64      `json:"repos_done"`
+	ReposFailed int64      `json:"repos_failed"`
+	Error       string     `json:"error,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	// Current number of documents in Index; not persisted
+	Docs *int64 `json:"docs,omitempty" gorm:"-"`
+}
+
+type reindexKind struct {
+	alias      string
+	schemaJSON string
+	// collections (record path prefixes) crawled for this kind
+	collections []string
+}
+
+func (idx *Indexer) reindexKinds() map[string]reindexKind {
+	return map[string]reindexKind{
+		"post":    {alias: idx.postIndex, schemaJSON: idx.postSchema, collections: []string{"app.bsky.feed.post"}},
+		"profile": {alias: idx.profileIndex, schemaJSON: palomarProfileSchemaJSON, collections: []string{"app.bsky.actor.profile"}},
+		"record": {alias: idx.recordIndex, schemaJSON: palomarRecordSchemaJSON, collections: []string{
+			recordTypeCollections[RecordTypeFeed],
+			recordTypeCollections[RecordTypeList],
+			recordTypeCollections[RecordTypeStarterPack],
+		}},
+	}
+}
+
+// writeIndices returns the indices writes to an alias should go to: the alias itself, and the index being built by any reindex job for it
+func (idx *Indexer) writeIndices(alias string) (string, string) {
+	idx.reindexLk.Lock()
+	defer idx.reindexLk.Unlock()
+	return alias, idx.reindexTargets[alias]
+}
+
+// bulk applies a batch of operations to an alias, with retries (see writeBulk), and copies them to any index being built to replace it. Failures writing to a reindex target are only logged: documents it misses are filled in by the reindex crawl
+func (idx *Indexer) bulk(ctx context.Context, alias string, ops []BulkOp) error {
+	live, target := idx.writeIndices(alias)
+	if err := writeBulk(ctx, idx.backend, idx.logger, idx.bulkConfig, live, ops); err != nil {
+		return err
+	}
+	if target != "" {
+		if err := writeBulk(ctx, idx.backend, idx.logger, idx.bulkConfig, target, ops); err != nil {
+			idx.logger.Warn("failed to copy bulk write to reindex target", "index", target, "err", err)
+		}
+	}
+	return nil
+}
+
+func (idx *Indexer) deleteDoc(ctx context.Context, alias, docID string) error {
+	live, target := idx.writeIndices(alias)
+	if err := idx.backend.Delete(ctx, live, docID); err != nil {
+		return err
+	}
+	if target != "" {
+		if err := idx.backend.Delete(ctx, target, docID); err != nil {
+			idx.logger.Warn("failed to copy delete to reindex target", "index", target, "err", err)
+		}
+	}
+	return nil
+}
+
+func (idx *Indexer) updateDoc(ctx context.Context, alias, docID string, body []byte) error {
+	live, target := idx.writeIndices(alias)
+	if err := idx.backend.Update(ctx, live, docID, body); err != nil {
+		return err
+	}
+	if target != "" {
+		if err := idx.backend.Update(ctx, target, docID, body); err != nil {
+			idx.logger.Warn("failed to copy update to reindex target", "index", target, "err", err)
+		}
+	}
+	return nil
+}
+
+// StartReindex begins rebuilding the index for a kind of document ("post", "profile", or "record"), using the current schema. It returns once the new index has been created, with the crawl running in the background.
+func (idx *Indexer) StartReindex(ctx context.Context, kind string, keepPrevious bool) (*ReindexJob, error) {
+	k, ok := idx.reindexKinds()[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown index kind: %q", kind)
+	}
+
+	var running int64
+	if err := idx.db.Model(&ReindexJob{}).Where("alias = ? AND state = ?", k.alias, ReindexRunning).Count(&running).Error; err != nil {
+		return nil, err
+	}
+	if running > 0 {
+		return nil, ErrReindexRunning
+	}
+
+	previous, err := idx.backend.AliasIndices(ctx, k.alias)
+	if err != nil {
+		return nil, fmt.Errorf("looking up current indices: %w", err)
+	}
+	if previous == nil {
+		// a concrete index created before palomar used aliases
+		previous = []string{k.alias}
+	}
+
+	job := ReindexJob{
+		Kind:         kind,
+		Alias:        k.alias,
+		Index:        versionedIndexName(k.alias, time.Now()),
+		Previous:     strings.Join(previous, ","),
+		KeepPrevious: keepPrevious,
+		State:        ReindexRunning,
+	}
+	if err := idx.backend.CreateIndex(ctx, job.Index, k.schemaJSON); err != nil {
+		return nil, fmt.Errorf("creating index: %w", err)
+	}
+	if err := idx.db.Create(&job).Error; err != nil {
+		return nil, err
+	}
+
+	idx.logger.Info("starting reindex", "job", job.ID, "alias", job.Alias, "index", job.Index)
+	idx.runReindexJob(&job)
+	return &job, nil
+}
+
+// resumeReindexJobs restarts the crawl of any reindex jobs interrupted by a restart, from their last cursor
+func (idx *Indexer) resumeReindexJobs() error {
+	var jobs []ReindexJob
+	if err := idx.db.Where("state = ?", ReindexRunning).Find(&jobs).Error; err != nil {
+		return err
+	}
+	for i := range jobs {
+		idx.logger.Info("resuming reindex", "job", jobs[i].ID, "alias", jobs[i].Alias, "index", jobs[i].Index, "cursor", jobs[i].Cursor)
+		idx.runReindexJob(&jobs[i])
+	}
+	return nil
+}
+
+func (idx *Indexer) runReindexJob(job *ReindexJob) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	idx.reindexLk.Lock()
+	idx.reindexTargets[job.Alias] = job.Index
+	idx.reindexCancel[job.ID] = cancel
+	idx.reindexLk.Unlock()
+
+	go func() {
+		defer cancel()
+		err := idx.crawlReindex(ctx, job)
+		if ctx.Err() != nil {
+			// cancelled; CancelReindex cleans up
+			return
+		}
+
+		idx.reindexLk.Lock()
+		delete(idx.reindexTargets, job.Alias)
+		delete(idx.reindexCancel, job.ID)
+		idx.reindexLk.Unlock()
+
+		now := time.Now()
+		job.FinishedAt = &now
+		if err != nil {
+			idx.logger.Error("reindex failed", "job", job.ID, "index", job.Index, "err", err)
+			job.State = ReindexFailed
+			job.Error = err.Error()
+			if err := idx.backend.DeleteIndex(context.Background(), job.Index); err != nil {
+				idx.logger.Warn("failed to delete index of failed reindex", "index", job.Index, "err", err)
+			}
+		} else {
+			idx.logger.Info("reindex complete, swapped alias", "job", job.ID, "alias", job.Alias, "index", job.Index, "repos", job.ReposDone)
+			job.State = ReindexComplete
+		}
+		if err := idx.db.Save(job).Error; err != nil {
+			idx.logger.Error("failed to save reindex job", "job", job.ID, "err", err)
+		}
+	}()
+}
+
+// crawlReindex indexes every repo on the Relay into the job's index, then swaps the alias over to it
+func (idx *Indexer) crawlReindex(ctx context.Context, job *ReindexJob) error {
+	k := idx.reindexKinds()[job.Kind]
+
+	for {
+		resp, err := comatproto.SyncListRepos(ctx, idx.relayXRPC, job.Cursor, 500)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			idx.logger.Warn("reindex failed to list repos, retrying", "job", job.ID, "err", err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		var wg sync.WaitGroup
+		var done, failed int64
+		var lk sync.Mutex
+		sem := make(chan struct{}, reindexParallelism)
+		for _, r := range resp.Repos {
+			did, err := syntax.ParseDID(r.Did)
+			if err != nil {
+				failed++
+				continue
+			}
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				err := idx.reindexRepo(ctx, job.Index, k, did)
+				lk.Lock()
+				defer lk.Unlock()
+				if err != nil {
+					idx.logger.Warn("reindex failed to index repo", "job", job.ID, "did", did, "err", err)
+					failed++
+					return
+				}
+				done++
+			}()
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		job.ReposDone += done
+		job.ReposFailed += failed
+		if resp.Cursor != nil {
+			job.Cursor = *resp.Cursor
+		}
+		if err := idx.db.Model(job).Select("cursor", "repos_done", "repos_failed").Updates(job).Error; err != nil {
+			return fmt.Errorf("saving reindex progress: %w", err)
+		}
+		if resp.Cursor == nil || *resp.Cursor == "" || len(resp.Repos) == 0 {
+			break
+		}
+	}
+
+	if err := idx.backend.Refresh(ctx, job.Index); err != nil {
+		return err
+	}
+	if err := idx.backend.SwapAlias(ctx, job.Alias, job.Index); err != nil {
+		return fmt.Errorf("swapping alias: %w", err)
+	}
+
+	if !job.KeepPrevious {
+		for _, prev := range strings.Split(job.Previous, ",") {
+			if prev == "" || prev == job.Alias || prev == job.Index {
+				// a concrete index with the alias's name was already removed by the swap
+				continue
+			}
+			if err := idx.backend.DeleteIndex(ctx, prev); err != nil {
+				idx.logger.Warn("failed to delete previous index", "job", job.ID, "index", prev, "err", err)
+			}
+		}
+	}
+	return nil
+}
+
+// reindexRepo fetches a repo from the Relay, and indexes its records of one kind in to index. Documents already written by live firehose events are not overwritten, since they may be newer than the fetched repo
+func (idx *Indexer) reindexRepo(ctx context.Context, index string, k reindexKind, did syntax.DID) error {
+	if err := idx.reindexLimiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	repodata, err := comatproto.SyncGetRepo(ctx, idx.relayXRPC, did.String(), "")
+	if err != nil {
+		return err
+	}
+	r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(repodata))
+	if err != nil {
+		return err
+	}
+
+	var ops []BulkOp
+	// posts are held back to compute embeddings in batches, if enabled
+	var posts []*PostDoc
+	for _, collection := range k.collections {
+		err := r.ForEach(ctx, collection, func(path string, _ cid.Cid) error {
+			if !strings.HasPrefix(path, collection+"/") {
+				return repo.ErrDoneIterating
+			}
+			rcid, rec, err := r.GetRecord(ctx, path)
+			if err != nil {
+				return err
+			}
+			doc, docID, err := idx.transformForReindex(ctx, did, strings.TrimPrefix(path, collection+"/"), rcid, rec)
+			if err != nil || doc == nil {
+				return err
+			}
+			if post, ok := doc.(PostDoc); ok && idx.embedder != nil {
+				posts = append(posts, &post)
+				return nil
+			}
+			b, err := json.Marshal(doc)
+			if err != nil {
+				return err
+			}
+			ops = append(ops, BulkOp{Action: BulkCreate, DocID: docID, Body: b})
+			return nil
+		})
+		if err != nil && !errors.Is(err, repo.ErrDoneIterating) {
+			return err
+		}
+	}
+
+	if len(posts) > 0 {
+		if err := embedPosts(ctx, idx.embedder, posts); err != nil {
+			idx.logger.Warn("failed to compute post embeddings", "did", did, "err", err)
+		}
+		for _, post := range posts {
+			b, err := json.Marshal(post)
+			if err != nil {
+				return err
+			}
+			ops = append(ops, BulkOp{Action: BulkCreate, DocID: post.DocId(), Body: b})
+		}
+	}
+
+	for len(ops) > 0 {
+		batch := ops[:min(len(ops), idx.bulkConfig.MaxDocs)]
+		ops = ops[len(batch):]
+		if err := idx.indexLimiter.WaitN(ctx, len(batch)); err != nil {
+			return err
+		}
+		if err := writeBulk(ctx, idx.backend, idx.logger, idx.bulkConfig, index, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transformForReindex turns a repo record into a search document and its ID, or returns a nil document for records which aren't indexed
+func (idx *Indexer) transformForReindex(ctx context.Context, did syntax.DID, rkey string, rcid cid.Cid, rec typegen.CBORMarshaler) (any, string, error) {
+	switch rec := rec.(type) {
+	case *bsky.FeedPost:
+		tid, err := syntax.ParseTID(rkey)
+		if err != nil {
+			return nil, "", nil
+		}
+		doc := TransformPost(rec, did, tid.String(), rcid.String())
+		return doc, doc.DocId(), nil
+	case *bsky.ActorProfile:
+		if rkey != "self" {
+			return nil, "", nil
+		}
+		ident, err := idx.dir.LookupDID(ctx, did)
+		if err != nil {
+			return nil, "", fmt.Errorf("resolving identity: %w", err)
+		}
+		doc := TransformProfile(rec, ident, rcid.String())
+		return doc, doc.DocId(), nil
+	default:
+		rk, err := syntax.ParseRecordKey(rkey)
+		if err != nil {
+			return nil, "", nil
+		}
+		doc, ok := transformRecord(did, rk.String(), rcid, rec)
+		if !ok {
+			return nil, "", nil
+		}
+		return doc, doc.DocId(), nil
+	}
+}
+
+// CancelReindex stops a running reindex job and drops the index it was building
+func (idx *Indexer) CancelReindex(ctx context.Context, id uint) (*ReindexJob, error) {
+	var job ReindexJob
+	if err := idx.db.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	if job.State != ReindexRunning {
+		return nil, fmt.Errorf("reindex job is %s", job.State)
+	}
+
+	idx.reindexLk.Lock()
+	if cancel, ok := idx.reindexCancel[job.ID]; ok {
+		cancel()
+		delete(idx.reindexCancel, job.ID)
+	}
+	if idx.reindexTargets[job.Alias] == job.Index {
+		delete(idx.reindexTargets, job.Alias)
+	}
+	idx.reindexLk.Unlock()
+
+	if err := idx.backend.DeleteIndex(ctx, job.Index); err != nil {
+		return nil, fmt.Errorf("deleting index: %w", err)
+	}
+
+	now := time.Now()
+	job.State = ReindexCancelled
+	job.FinishedAt = &now
+	if err := idx.db.Model(&job).Select("state", "finished_at").Updates(&job).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ReindexAdminHandler serves the reindex admin API (see the palomar README), meant to be mounted under /admin/reindex
+func (idx *Indexer) ReindexAdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		var jobs []ReindexJob
+		if err := idx.db.Order("id desc").Limit(50).Find(&jobs).Error; err != nil {
+			writeReindexJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		for i := range jobs {
+			idx.fillReindexDocs(r.Context(), &jobs[i])
+		}
+		writeReindexJSON(w, http.StatusOK, map[string]any{"jobs": jobs})
+	})
+
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeReindexJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid job id"})
+			return
+		}
+		var job ReindexJob
+		if err := idx.db.First(&job, uint(id)).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeReindexJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+				return
+			}
+			writeReindexJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		idx.fillReindexDocs(r.Context(), &job)
+		writeReindexJSON(w, http.StatusOK, job)
+	})
+
+	mux.HandleFunc("POST /start", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Kind         string `json:"kind"`
+			KeepPrevious bool   `json:"keep_previous"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeReindexJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+			return
+		}
+		job, err := idx.StartReindex(r.Context(), body.Kind, body.KeepPrevious)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrReindexRunning) {
+				status = http.StatusConflict
+			} else if _, ok := idx.reindexKinds()[body.Kind]; !ok {
+				status = http.StatusBadRequest
+			}
+			writeReindexJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		writeReindexJSON(w, http.StatusOK, job)
+	})
+
+	mux.HandleFunc("POST /jobs/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeReindexJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid job id"})
+			return
+		}
+		job, err := idx.CancelReindex(r.Context(), uint(id))
+		if err != nil {
+			writeReindexJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeReindexJSON(w, http.StatusOK, job)
+	})
+
+	return mux
+}
+
+func (idx *Indexer) fillReindexDocs(ctx context.Context, job *ReindexJob) {
+	if job.State != ReindexRunning {
+		return
+	}
+	n, err := idx.backend.Count(ctx, job.Index)
+	if err != nil {
+		idx.logger.Warn("failed to count reindex docs", "index", job.Index, "err", err)
+		return
+	}
+	job.Docs = &n
+}
+
+func writeReindexJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
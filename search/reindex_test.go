@@ -0,0 +1,60 @@
+package search
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingBackend is a Backend which records which indices were written to
+type recordingBackend struct {
+	Backend
+	writes []string
+}
+
+func (b *recordingBackend) Bulk(ctx context.Context, index string, ops []BulkOp) error {
+	b.writes = append(b.writes, "bulk:"+index)
+	return nil
+}
+
+func (b *recordingBackend) Delete(ctx context.Context, index string, docID string) error {
+	b.writes = append(b.writes, "delete:"+index)
+	return nil
+}
+
+func TestReindexCopiesLiveWrites(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	backend := &recordingBackend{}
+	idx := &Indexer{
+		backend:        backend,
+		postIndex:      "palomar_post",
+		logger:         slog.Default(),
+		reindexTargets: make(map[string]string),
+	}
+
+	assert.NoError(idx.bulk(ctx, "palomar_post", []BulkOp{{Action: BulkIndex, DocID: "a"}}))
+	assert.Equal([]string{"bulk:palomar_post"}, backend.writes)
+
+	backend.writes = nil
+	idx.reindexTargets["palomar_post"] = "palomar_post_20240202000000"
+	assert.NoError(idx.bulk(ctx, "palomar_post", []BulkOp{{Action: BulkIndex, DocID: "a"}}))
+	assert.NoError(idx.deleteDoc(ctx, "palomar_post", "a"))
+	assert.NoError(idx.bulk(ctx, "palomar_profile", []BulkOp{{Action: BulkIndex, DocID: "b"}}))
+	assert.Equal([]string{
+		"bulk:palomar_post", "bulk:palomar_post_20240202000000",
+		"delete:palomar_post", "delete:palomar_post_20240202000000",
+		"bulk:palomar_profile",
+	}, backend.writes)
+}
+
+func TestVersionedIndexName(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := time.Date(2024, 2, 3, 4, 5, 6, 0, time.FixedZone("", 3600))
+	assert.Equal("palomar_post_20240203030506", versionedIndexName("palomar_post", ts))
+}
@@ -3,18 +3,16 @@ package search
 import (
 	"context"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"os"
-	"strings"
 
 	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/util/health"
 
 	"github.com/carlmjohnson/versioninfo"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	es "github.com/opensearch-project/opensearch-go/v2"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	slogecho "github.com/samber/slog-echo"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
@@ -31,13 +29,19 @@ type ServerConfig struct {
 	Logger            *slog.Logger
 	ProfileIndex      string
 	PostIndex         string
+	RecordIndex       string
 	AtlantisAddresses []string
+	// If set, post searches may request hybrid keyword and semantic (kNN) matching. Should be the same as the indexer's
+	Embedder Embedder
 }
 
 type Server struct {
-	escli        *es.Client
+	backend      Backend
 	postIndex    string
 	profileIndex string
+	recordIndex  string
+	postSchema   string
+	embedder     Embedder
 	dir          identity.Directory
 	echo         *echo.Echo
 	logger       *slog.Logger
@@ -45,7 +49,7 @@ type Server struct {
 	Indexer *Indexer
 }
 
-func NewServer(escli *es.Client, dir identity.Directory, config ServerConfig) (*Server, error) {
+func NewServer(backend Backend, dir identity.Directory, config ServerConfig) (*Server, error) {
 	logger := config.Logger
 	if logger == nil {
 		logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
@@ -53,10 +57,18 @@ func NewServer(escli *es.Client, dir identity.Directory, config ServerConfig) (*
 		}))
 	}
 
+	postSchema, err := postSchemaJSON(config.Embedder)
+	if err != nil {
+		return nil, err
+	}
+
 	serv := Server{
-		escli:        escli,
+		backend:      backend,
 		postIndex:    config.PostIndex,
 		profileIndex: config.ProfileIndex,
+		recordIndex:  config.RecordIndex,
+		postSchema:   postSchema,
+		embedder:     config.Embedder,
 		dir:          dir,
 		logger:       logger,
 	}
@@ -65,48 +77,11 @@ func NewServer(escli *es.Client, dir identity.Directory, config ServerConfig) (*
 }
 
 func (s *Server) EnsureIndices(ctx context.Context) error {
-
-	indices := []struct {
-		Name       string
-		SchemaJSON string
-	}{
-		{Name: s.postIndex, SchemaJSON: palomarPostSchemaJSON},
+	return ensureIndices(ctx, s.backend, s.logger, []indexSchema{
+		{Name: s.postIndex, SchemaJSON: s.postSchema},
 		{Name: s.profileIndex, SchemaJSON: palomarProfileSchemaJSON},
-	}
-	for _, idx := range indices {
-		resp, err := s.escli.Indices.Exists([]string{idx.Name})
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-		io.ReadAll(resp.Body)
-		if resp.IsError() && resp.StatusCode != 404 {
-			return fmt.Errorf("failed to check index existence")
-		}
-		if resp.StatusCode == 404 {
-			s.logger.Warn("creating opensearch index", "index", idx.Name)
-			if len(idx.SchemaJSON) < 2 {
-				return fmt.Errorf("empty schema file (go:embed failed)")
-			}
-			buf := strings.NewReader(idx.SchemaJSON)
-			resp, err := s.escli.Indices.Create(
-				idx.Name,
-				s.escli.Indices.Create.WithBody(buf))
-			if err != nil {
-				return err
-			}
-			defer resp.Body.Close()
-			errBytes, err := io.ReadAll(resp.Body)
-			if resp.IsError() {
-				s.logger.Error("failed to create index", "index", idx.Name, "response", string(errBytes))
-				return fmt.Errorf("failed to create index")
-			}
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+		{Name: s.recordIndex, SchemaJSON: palomarRecordSchemaJSON},
+	})
 }
 
 type HealthStatus struct {
@@ -126,6 +101,26 @@ func (a *Server) handleHealthCheck(c echo.Context) error {
 	return c.JSON(200, HealthStatus{Status: "ok", Version: versioninfo.Short()})
 }
 
+// newHealthChecker sets up the readiness checks served at /readyz
+func (s *Server) newHealthChecker() *health.Checker {
+	hc := health.NewChecker("palomar")
+	hc.Add("search", s.backend.Health)
+	if s.Indexer != nil {
+		idx := s.Indexer
+		hc.Add("database", func(ctx context.Context) error {
+			return idx.db.WithContext(ctx).Exec("SELECT 1").Error
+		})
+		hc.Add("firehose", func(ctx context.Context) error {
+			// bulk indexing modes don't consume the firehose
+			if idx.firehoseRunning.Load() && !idx.firehoseConnected.Load() {
+				return fmt.Errorf("not connected to firehose")
+			}
+			return nil
+		})
+	}
+	return hc
+}
+
 func (s *Server) RunAPI(listen string) error {
 
 	s.logger.Info("Configuring HTTP server")
@@ -149,9 +144,16 @@ func (s *Server) RunAPI(listen string) error {
 	e.Use(middleware.CORS())
 	e.GET("/", s.handleHealthCheck)
 	e.GET("/_health", s.handleHealthCheck)
+	hc := s.newHealthChecker()
+	e.GET("/healthz", echo.WrapHandler(hc.Healthz()))
+	e.GET("/readyz", echo.WrapHandler(hc.Readyz()))
+	e.GET("/buildinfo", echo.WrapHandler(hc.BuildInfo()))
 	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
 	e.GET("/xrpc/app.bsky.unspecced.searchPostsSkeleton", s.handleSearchPostsSkeleton)
 	e.GET("/xrpc/app.bsky.unspecced.searchActorsSkeleton", s.handleSearchActorsSkeleton)
+	e.GET("/xrpc/app.bsky.unspecced.searchFeedGeneratorsSkeleton", s.handleSearchRecordsSkeleton(RecordTypeFeed, "feeds"))
+	e.GET("/xrpc/app.bsky.unspecced.searchListsSkeleton", s.handleSearchRecordsSkeleton(RecordTypeList, "lists"))
+	e.GET("/xrpc/app.bsky.unspecced.searchStarterPacksSkeleton", s.handleSearchRecordsSkeleton(RecordTypeStarterPack, "starterPacks"))
 	s.echo = e
 
 	s.logger.Info("starting search API daemon", "bind", listen)
@@ -160,6 +162,11 @@ func (s *Server) RunAPI(listen string) error {
 
 func (s *Server) RunMetrics(listen string) error {
 	http.Handle("/metrics", promhttp.Handler())
+	if s.Indexer != nil {
+		// backfill admin API is only exposed on the (internal) metrics listener
+		http.Handle("/admin/backfill/", http.StripPrefix("/admin/backfill", s.Indexer.bf.AdminHandler()))
+		http.Handle("/admin/reindex/", http.StripPrefix("/admin/reindex", s.Indexer.ReindexAdminHandler()))
+	}
 	return http.ListenAndServe(listen, nil)
 }
 
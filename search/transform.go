@@ -1,6 +1,7 @@
 package search
 
 import (
+	"fmt"
 	"log/slog"
 	"net/url"
 	"strings"
@@ -14,10 +15,14 @@ import (
 )
 
 type ProfileDoc struct {
-	DocIndexTs  string   `json:"doc_index_ts"`
-	DID         string   `json:"did"`
-	RecordCID   string   `json:"record_cid"`
-	Handle      string   `json:"handle"`
+	DocIndexTs string `json:"doc_index_ts"`
+	// Copy of DocId(), used as a sort tiebreaker for search_after pagination
+	SortKey   string `json:"sort_key"`
+	DID       string `json:"did"`
+	RecordCID string `json:"record_cid"`
+	Handle    string `json:"handle"`
+	// Profile record creation time, used as a recency signal in typeahead ranking
+	CreatedAt   *string  `json:"created_at,omitempty"`
 	DisplayName *string  `json:"display_name,omitempty"`
 	Description *string  `json:"description,omitempty"`
 	ImgAltText  []string `json:"img_alt_text,omitempty"`
@@ -31,26 +36,84 @@ type ProfileDoc struct {
 }
 
 type PostDoc struct {
-	DocIndexTs        string   `json:"doc_index_ts"`
-	DID               string   `json:"did"`
-	RecordRkey        string   `json:"record_rkey"`
-	RecordCID         string   `json:"record_cid"`
-	CreatedAt         *string  `json:"created_at,omitempty"`
-	Text              string   `json:"text"`
-	TextJA            *string  `json:"text_ja,omitempty"`
-	LangCode          []string `json:"lang_code,omitempty"`
-	LangCodeIso2      []string `json:"lang_code_iso2,omitempty"`
-	MentionDID        []string `json:"mention_did,omitempty"`
-	EmbedATURI        *string  `json:"embed_aturi,omitempty"`
-	ReplyRootATURI    *string  `json:"reply_root_aturi,omitempty"`
-	EmbedImgCount     int      `json:"embed_img_count"`
+	DocIndexTs string `json:"doc_index_ts"`
+	// Copy of DocId(), used as a sort tiebreaker for search_after pagination
+	SortKey      string   `json:"sort_key"`
+	DID          string   `json:"did"`
+	RecordRkey   string   `json:"record_rkey"`
+	RecordCID    string   `json:"record_cid"`
+	CreatedAt    *string  `json:"created_at,omitempty"`
+	Text         string   `json:"text"`
+	TextJA       *string  `json:"text_ja,omitempty"`
+	TextEN       *string  `json:"text_en,omitempty"`
+	TextES       *string  `json:"text_es,omitempty"`
+	TextPT       *string  `json:"text_pt,omitempty"`
+	TextFR       *string  `json:"text_fr,omitempty"`
+	TextDE       *string  `json:"text_de,omitempty"`
+	TextIT       *string  `json:"text_it,omitempty"`
+	TextNL       *string  `json:"text_nl,omitempty"`
+	TextRU       *string  `json:"text_ru,omitempty"`
+	LangCode     []string `json:"lang_code,omitempty"`
+	LangCodeIso2 []string `json:"lang_code_iso2,omitempty"`
+	// Set if LangCodeIso2 was detected from the text, because the post didn't declare any languages
+	LangDetected   bool     `json:"lang_detected,omitempty"`
+	MentionDID     []string `json:"mention_did,omitempty"`
+	EmbedATURI     *string  `json:"embed_aturi,omitempty"`
+	ReplyRootATURI *string  `json:"reply_root_aturi,omitempty"`
+	EmbedImgCount  int      `json:"embed_img_count"`
+	// Alt text of embedded images, and of an embedded video
 	EmbedImgAltText   []string `json:"embed_img_alt_text,omitempty"`
 	EmbedImgAltTextJA []string `json:"embed_img_alt_text_ja,omitempty"`
-	SelfLabel         []string `json:"self_label,omitempty"`
-	URL               []string `json:"url,omitempty"`
-	Domain            []string `json:"domain,omitempty"`
-	Tag               []string `json:"tag,omitempty"`
-	Emoji             []string `json:"emoji,omitempty"`
+	// Title, description, and host of an external link card embed
+	EmbedLinkTitle  *string  `json:"embed_link_title,omitempty"`
+	EmbedLinkDesc   *string  `json:"embed_link_description,omitempty"`
+	EmbedLinkDomain *string  `json:"embed_link_domain,omitempty"`
+	EmbedLinkTextJA []string `json:"embed_link_text_ja,omitempty"`
+	SelfLabel       []string `json:"self_label,omitempty"`
+	URL             []string `json:"url,omitempty"`
+	Domain          []string `json:"domain,omitempty"`
+	Tag             []string `json:"tag,omitempty"`
+	Emoji           []string `json:"emoji,omitempty"`
+	// Dense vector of the post text, if semantic search is enabled (see Embedder)
+	Embedding []float32 `json:"embedding,omitempty"`
+}
+
+// Kinds of record, other than posts and profiles, which are indexed as RecordDoc
+const (
+	RecordTypeFeed        = "feed"
+	RecordTypeList        = "list"
+	RecordTypeStarterPack = "starterpack"
+)
+
+// Collection NSIDs for each RecordDoc record type
+var recordTypeCollections = map[string]string{
+	RecordTypeFeed:        "app.bsky.feed.generator",
+	RecordTypeList:        "app.bsky.graph.list",
+	RecordTypeStarterPack: "app.bsky.graph.starterpack",
+}
+
+// Document for feed generator, list, and starter pack records, which all share a single index
+type RecordDoc struct {
+	DocIndexTs string `json:"doc_index_ts"`
+	// Copy of DocId(), used as a sort tiebreaker for search_after pagination
+	SortKey        string   `json:"sort_key"`
+	DID            string   `json:"did"`
+	RecordType     string   `json:"record_type"`
+	Collection     string   `json:"collection"`
+	RecordRkey     string   `json:"record_rkey"`
+	RecordCID      string   `json:"record_cid"`
+	CreatedAt      *string  `json:"created_at,omitempty"`
+	Name           string   `json:"name"`
+	Description    *string  `json:"description,omitempty"`
+	SelfLabel      []string `json:"self_label,omitempty"`
+	ListPurpose    *string  `json:"list_purpose,omitempty"`
+	ListATURI      *string  `json:"list_aturi,omitempty"`
+	FeedServiceDID *string  `json:"feed_service_did,omitempty"`
+	URL            []string `json:"url,omitempty"`
+	Domain         []string `json:"domain,omitempty"`
+	Tag            []string `json:"tag,omitempty"`
+	Emoji          []string `json:"emoji,omitempty"`
+	HasAvatar      bool     `json:"has_avatar"`
 }
 
 // Returns the search index document ID (`_id`) for this document.
@@ -67,6 +130,22 @@ func (d *PostDoc) DocId() string {
 	return d.DID + "_" + d.RecordRkey
 }
 
+// Returns the search index document ID (`_id`) for this document.
+//
+// This identifier should be URL safe and not contain a slash ("/").
+func (d *RecordDoc) DocId() string {
+	return recordDocId(d.DID, d.Collection, d.RecordRkey)
+}
+
+func recordDocId(did, collection, rkey string) string {
+	return did + "_" + collection + "_" + rkey
+}
+
+// Returns the AT-URI of the record this document was indexed from.
+func (d *RecordDoc) ATURI() string {
+	return fmt.Sprintf("at://%s/%s/%s", d.DID, d.Collection, d.RecordRkey)
+}
+
 func TransformProfile(profile *appbsky.ActorProfile, ident *identity.Identity, cid string) ProfileDoc {
 	// TODO: placeholder for future alt text on profile blobs
 	var altText []string
@@ -86,11 +165,21 @@ func TransformProfile(profile *appbsky.ActorProfile, ident *identity.Identity, c
 	if !ident.Handle.IsInvalidHandle() {
 		handle = ident.Handle.String()
 	}
+	var createdAt *string
+	if profile.CreatedAt != nil {
+		dt, err := syntax.ParseDatetimeLenient(*profile.CreatedAt)
+		if nil == err && time.Since(dt.Time()) >= -1*5*time.Minute {
+			s := dt.String()
+			createdAt = &s
+		}
+	}
 	return ProfileDoc{
 		DocIndexTs:  syntax.DatetimeNow().String(),
+		SortKey:     ident.DID.String(),
 		DID:         ident.DID.String(),
 		RecordCID:   cid,
 		Handle:      handle,
+		CreatedAt:   createdAt,
 		DisplayName: profile.DisplayName,
 		Description: profile.Description,
 		ImgAltText:  altText,
@@ -103,20 +192,18 @@ func TransformProfile(profile *appbsky.ActorProfile, ident *identity.Identity, c
 }
 
 func TransformPost(post *appbsky.FeedPost, did syntax.DID, rkey, cid string) PostDoc {
-	altText := []string{}
-	if post.Embed != nil && post.Embed.EmbedImages != nil {
-		for _, img := range post.Embed.EmbedImages.Images {
-			if img.Alt != "" {
-				altText = append(altText, img.Alt)
-			}
-		}
-	}
 	var langCodeIso2 []string
 	for _, lang := range post.Langs {
 		// TODO: include an actual language code map to go from 3char to 2char
-		prefix := strings.SplitN(lang, "-", 2)[0]
-		if len(prefix) == 2 {
-			langCodeIso2 = append(langCodeIso2, strings.ToLower(prefix))
+		if code := isoLangCode(lang); code != "" {
+			langCodeIso2 = append(langCodeIso2, code)
+		}
+	}
+	langDetected := false
+	if len(post.Langs) == 0 {
+		if code := detectLanguage(post.Text); code != "" {
+			langCodeIso2 = []string{code}
+			langDetected = true
 		}
 	}
 	var mentionDIDs []string
@@ -135,8 +222,36 @@ func TransformPost(post *appbsky.FeedPost, did syntax.DID, rkey, cid string) Pos
 	if post.Reply != nil {
 		replyRootATURI = &(post.Reply.Root.Uri)
 	}
-	if post.Embed != nil && post.Embed.EmbedExternal != nil {
-		urls = append(urls, post.Embed.EmbedExternal.External.Uri)
+	// media (images, video, or a link card) is either embedded directly, or alongside a quoted record
+	var images *appbsky.EmbedImages
+	var video *appbsky.EmbedVideo
+	var external *appbsky.EmbedExternal
+	if post.Embed != nil {
+		images, video, external = post.Embed.EmbedImages, post.Embed.EmbedVideo, post.Embed.EmbedExternal
+		if rwm := post.Embed.EmbedRecordWithMedia; rwm != nil && rwm.Media != nil {
+			images, video, external = rwm.Media.EmbedImages, rwm.Media.EmbedVideo, rwm.Media.EmbedExternal
+		}
+	}
+	var embedLinkTitle, embedLinkDescription, embedLinkDomain *string
+	var embedLinkTextJA []string
+	if external != nil && external.External != nil {
+		card := external.External
+		urls = append(urls, card.Uri)
+		if u, err := url.Parse(NormalizeLossyURL(card.Uri)); err == nil && u.Hostname() != "" {
+			host := u.Hostname()
+			embedLinkDomain = &host
+		}
+		if title := strings.TrimSpace(card.Title); title != "" {
+			embedLinkTitle = &title
+		}
+		if desc := strings.TrimSpace(card.Description); desc != "" {
+			embedLinkDescription = &desc
+		}
+		for _, text := range []*string{embedLinkTitle, embedLinkDescription} {
+			if text != nil && containsJapanese(*text) {
+				embedLinkTextJA = append(embedLinkTextJA, *text)
+			}
+		}
 	}
 	var embedATURI *string
 	if post.Embed != nil && post.Embed.EmbedRecord != nil {
@@ -146,33 +261,27 @@ func TransformPost(post *appbsky.FeedPost, did syntax.DID, rkey, cid string) Pos
 		embedATURI = &post.Embed.EmbedRecordWithMedia.Record.Record.Uri
 	}
 	var embedImgCount int
-	var embedImgAltText []string
-	var embedImgAltTextJA []string
-	if post.Embed != nil && post.Embed.EmbedImages != nil {
-		embedImgCount = len(post.Embed.EmbedImages.Images)
-		for _, img := range post.Embed.EmbedImages.Images {
-			if img.Alt != "" {
-				embedImgAltText = append(embedImgAltText, img.Alt)
-				if containsJapanese(img.Alt) {
-					embedImgAltTextJA = append(embedImgAltTextJA, img.Alt)
-				}
+	var altTexts []string
+	if images != nil {
+		embedImgCount = len(images.Images)
+		for _, img := range images.Images {
+			if img != nil {
+				altTexts = append(altTexts, img.Alt)
 			}
 		}
 	}
-
-	if post.Embed != nil &&
-		post.Embed.EmbedRecordWithMedia != nil &&
-		post.Embed.EmbedRecordWithMedia.Media != nil &&
-		post.Embed.EmbedRecordWithMedia.Media.EmbedImages != nil &&
-		len(post.Embed.EmbedRecordWithMedia.Media.EmbedImages.Images) > 0 {
-		embedImgCount += len(post.Embed.EmbedRecordWithMedia.Media.EmbedImages.Images)
-		for _, img := range post.Embed.EmbedRecordWithMedia.Media.EmbedImages.Images {
-			if img.Alt != "" {
-				embedImgAltText = append(embedImgAltText, img.Alt)
-				if containsJapanese(img.Alt) {
-					embedImgAltTextJA = append(embedImgAltTextJA, img.Alt)
-				}
-			}
+	if video != nil && video.Alt != nil {
+		altTexts = append(altTexts, *video.Alt)
+	}
+	var embedImgAltText []string
+	var embedImgAltTextJA []string
+	for _, alt := range altTexts {
+		if alt == "" {
+			continue
+		}
+		embedImgAltText = append(embedImgAltText, alt)
+		if containsJapanese(alt) {
+			embedImgAltTextJA = append(embedImgAltTextJA, alt)
 		}
 	}
 
@@ -195,18 +304,24 @@ func TransformPost(post *appbsky.FeedPost, did syntax.DID, rkey, cid string) Pos
 
 	doc := PostDoc{
 		DocIndexTs:        syntax.DatetimeNow().String(),
+		SortKey:           did.String() + "_" + rkey,
 		DID:               did.String(),
 		RecordRkey:        rkey,
 		RecordCID:         cid,
 		Text:              post.Text,
 		LangCode:          post.Langs,
 		LangCodeIso2:      langCodeIso2,
+		LangDetected:      langDetected,
 		MentionDID:        mentionDIDs,
 		EmbedATURI:        embedATURI,
 		ReplyRootATURI:    replyRootATURI,
 		EmbedImgCount:     embedImgCount,
 		EmbedImgAltText:   embedImgAltText,
 		EmbedImgAltTextJA: embedImgAltTextJA,
+		EmbedLinkTitle:    embedLinkTitle,
+		EmbedLinkDesc:     embedLinkDescription,
+		EmbedLinkDomain:   embedLinkDomain,
+		EmbedLinkTextJA:   embedLinkTextJA,
 		SelfLabel:         selfLabels,
 		URL:               urls,
 		Domain:            domains,
@@ -217,6 +332,9 @@ func TransformPost(post *appbsky.FeedPost, did syntax.DID, rkey, cid string) Pos
 	if containsJapanese(post.Text) {
 		doc.TextJA = &post.Text
 	}
+	for _, code := range langCodeIso2 {
+		doc.setLangText(code, &post.Text)
+	}
 
 	if post.CreatedAt != "" {
 		// there are some old bad timestamps out there!
@@ -237,6 +355,119 @@ func TransformPost(post *appbsky.FeedPost, did syntax.DID, rkey, cid string) Pos
 	return doc
 }
 
+func TransformFeedGenerator(feed *appbsky.FeedGenerator, did syntax.DID, rkey, cid string) RecordDoc {
+	doc := newRecordDoc(RecordTypeFeed, did, rkey, cid, feed.CreatedAt, feed.DisplayName, feed.Description, feed.DescriptionFacets)
+	if feed.Did != "" {
+		doc.FeedServiceDID = &feed.Did
+	}
+	if feed.Labels != nil && feed.Labels.LabelDefs_SelfLabels != nil {
+		for _, le := range feed.Labels.LabelDefs_SelfLabels.Values {
+			doc.SelfLabel = append(doc.SelfLabel, le.Val)
+		}
+	}
+	doc.HasAvatar = feed.Avatar != nil
+	return doc
+}
+
+func TransformList(list *appbsky.GraphList, did syntax.DID, rkey, cid string) RecordDoc {
+	doc := newRecordDoc(RecordTypeList, did, rkey, cid, list.CreatedAt, list.Name, list.Description, list.DescriptionFacets)
+	if list.Purpose != nil && *list.Purpose != "" {
+		// strip the lexicon prefix, eg "app.bsky.graph.defs#curatelist" to "curatelist"
+		purpose := *list.Purpose
+		if i := strings.LastIndex(purpose, "#"); i >= 0 {
+			purpose = purpose[i+1:]
+		}
+		doc.ListPurpose = &purpose
+	}
+	if list.Labels != nil && list.Labels.LabelDefs_SelfLabels != nil {
+		for _, le := range list.Labels.LabelDefs_SelfLabels.Values {
+			doc.SelfLabel = append(doc.SelfLabel, le.Val)
+		}
+	}
+	doc.HasAvatar = list.Avatar != nil
+	return doc
+}
+
+func TransformStarterPack(sp *appbsky.GraphStarterpack, did syntax.DID, rkey, cid string) RecordDoc {
+	doc := newRecordDoc(RecordTypeStarterPack, did, rkey, cid, sp.CreatedAt, sp.Name, sp.Description, sp.DescriptionFacets)
+	if sp.List != "" {
+		doc.ListATURI = &sp.List
+	}
+	return doc
+}
+
+// newRecordDoc fills in the fields common to all RecordDoc record types
+func newRecordDoc(recordType string, did syntax.DID, rkey, cid, createdAt, name string, description *string, facets []*appbsky.RichtextFacet) RecordDoc {
+	doc := RecordDoc{
+		DocIndexTs:  syntax.DatetimeNow().String(),
+		SortKey:     recordDocId(did.String(), recordTypeCollections[recordType], rkey),
+		DID:         did.String(),
+		RecordType:  recordType,
+		Collection:  recordTypeCollections[recordType],
+		RecordRkey:  rkey,
+		RecordCID:   cid,
+		Name:        name,
+		Description: description,
+		Emoji:       parseEmojis(name),
+	}
+
+	var tags []string
+	for _, facet := range facets {
+		for _, feat := range facet.Features {
+			if feat.RichtextFacet_Tag != nil {
+				tags = append(tags, feat.RichtextFacet_Tag.Tag)
+			}
+			if feat.RichtextFacet_Link != nil {
+				doc.URL = append(doc.URL, NormalizeLossyURL(feat.RichtextFacet_Link.Uri))
+			}
+		}
+	}
+	doc.Tag = dedupeStrings(tags)
+	for _, raw := range doc.URL {
+		u, err := url.Parse(raw)
+		if nil == err {
+			doc.Domain = append(doc.Domain, u.Hostname())
+		}
+	}
+	if description != nil {
+		doc.Emoji = dedupeStrings(append(doc.Emoji, parseEmojis(*description)...))
+	}
+
+	if createdAt != "" {
+		dt, err := syntax.ParseDatetimeLenient(createdAt)
+		if nil == err && time.Since(dt.Time()) >= -1*5*time.Minute {
+			s := dt.String()
+			doc.CreatedAt = &s
+		}
+	}
+
+	return doc
+}
+
+// setLangText copies post text to the field for a language, if it has its own analyzer (see langTextFields)
+func (d *PostDoc) setLangText(code string, text *string) {
+	switch code {
+	case "ja":
+		d.TextJA = text
+	case "en":
+		d.TextEN = text
+	case "es":
+		d.TextES = text
+	case "pt":
+		d.TextPT = text
+	case "fr":
+		d.TextFR = text
+	case "de":
+		d.TextDE = text
+	case "it":
+		d.TextIT = text
+	case "nl":
+		d.TextNL = text
+	case "ru":
+		d.TextRU = text
+	}
+}
+
 func dedupeStrings(in []string) []string {
 	var out []string
 	seen := make(map[string]bool)
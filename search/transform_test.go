@@ -6,6 +6,7 @@ import (
 	"os"
 	"testing"
 
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	appbsky "github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
@@ -113,3 +114,110 @@ func testPostFixture(t *testing.T, row postFixture) {
 	assert.Equal(row.PostDoc, doc)
 	assert.Equal(row.DocId, doc.DocId())
 }
+
+func TestTransformPostEmbeds(t *testing.T) {
+	assert := assert.New(t)
+
+	did := syntax.DID("did:plc:u5cwb2mwiv2bfq53cjufe6yn")
+	cid := "bafyreibjifzpqj6o6wcq3hejh7y4z4z2vmiklkvykc57tw3pcbx3kxifpm"
+
+	// link card alongside a quoted post
+	doc := TransformPost(&appbsky.FeedPost{
+		CreatedAt: "2024-01-02T03:04:05.000Z",
+		Text:      "worth a read",
+		Embed: &appbsky.FeedPost_Embed{EmbedRecordWithMedia: &appbsky.EmbedRecordWithMedia{
+			Record: &appbsky.EmbedRecord{Record: &comatproto.RepoStrongRef{Uri: "at://did:plc:abc222/app.bsky.feed.post/3k4duaz5vfs2b"}},
+			Media: &appbsky.EmbedRecordWithMedia_Media{EmbedExternal: &appbsky.EmbedExternal{External: &appbsky.EmbedExternal_External{
+				Uri:         "https://News.Example.com/article?utm_source=bsky",
+				Title:       " 東京の天気 ",
+				Description: "Weather in Tokyo",
+			}}},
+		}},
+	}, did, "3k4duaz5vfs2c", cid)
+	assert.Equal("at://did:plc:abc222/app.bsky.feed.post/3k4duaz5vfs2b", *doc.EmbedATURI)
+	assert.Equal("東京の天気", *doc.EmbedLinkTitle)
+	assert.Equal("Weather in Tokyo", *doc.EmbedLinkDesc)
+	assert.Equal("news.example.com", *doc.EmbedLinkDomain)
+	assert.Equal([]string{"東京の天気"}, doc.EmbedLinkTextJA)
+	assert.Equal([]string{"news.example.com"}, doc.Domain)
+	assert.Equal("worth a read\n東京の天気\nWeather in Tokyo", postEmbeddingText(&doc))
+
+	// video alt text
+	alt := "a cat jumping"
+	doc = TransformPost(&appbsky.FeedPost{
+		CreatedAt: "2024-01-02T03:04:05.000Z",
+		Text:      "whoa",
+		Embed:     &appbsky.FeedPost_Embed{EmbedVideo: &appbsky.EmbedVideo{Alt: &alt}},
+	}, did, "3k4duaz5vfs2d", cid)
+	assert.Equal([]string{"a cat jumping"}, doc.EmbedImgAltText)
+	assert.Equal(0, doc.EmbedImgCount)
+	assert.Nil(doc.EmbedLinkDomain)
+}
+
+func TestTransformRecords(t *testing.T) {
+	assert := assert.New(t)
+
+	did := syntax.DID("did:plc:u5cwb2mwiv2bfq53cjufe6yn")
+	desc := "cat pics 🐈 #cats https://example.com/about"
+	facets := []*appbsky.RichtextFacet{
+		{Features: []*appbsky.RichtextFacet_Features_Elem{
+			{RichtextFacet_Tag: &appbsky.RichtextFacet_Tag{Tag: "cats"}},
+		}},
+		{Features: []*appbsky.RichtextFacet_Features_Elem{
+			{RichtextFacet_Link: &appbsky.RichtextFacet_Link{Uri: "https://example.com/about"}},
+		}},
+	}
+
+	feed := TransformFeedGenerator(&appbsky.FeedGenerator{
+		CreatedAt:         "2024-01-02T03:04:05.000Z",
+		Description:       &desc,
+		DescriptionFacets: facets,
+		Did:               "did:web:feeds.example.com",
+		DisplayName:       "Cats",
+	}, did, "cats", "bafyreiaj2dkwcpljdnc6ogl5xwcmxtaws3zbymue3hmzziayhtsmqv2yla")
+	assert.Equal(RecordTypeFeed, feed.RecordType)
+	assert.Equal("app.bsky.feed.generator", feed.Collection)
+	assert.Equal("Cats", feed.Name)
+	assert.Equal("did:web:feeds.example.com", *feed.FeedServiceDID)
+	assert.Equal([]string{"cats"}, feed.Tag)
+	assert.Equal([]string{"example.com"}, feed.Domain)
+	assert.Equal([]string{"🐈"}, feed.Emoji)
+	assert.Equal("2024-01-02T03:04:05.000Z", *feed.CreatedAt)
+	assert.Equal("did:plc:u5cwb2mwiv2bfq53cjufe6yn_app.bsky.feed.generator_cats", feed.DocId())
+	assert.Equal("at://did:plc:u5cwb2mwiv2bfq53cjufe6yn/app.bsky.feed.generator/cats", feed.ATURI())
+	assert.NotContains(feed.DocId(), "/")
+
+	purpose := "app.bsky.graph.defs#modlist"
+	list := TransformList(&appbsky.GraphList{
+		CreatedAt: "2024-01-02T03:04:05.000Z",
+		Name:      "Spammers",
+		Purpose:   &purpose,
+	}, did, "3kpqxqnxwn22a", "bafyreiaj2dkwcpljdnc6ogl5xwcmxtaws3zbymue3hmzziayhtsmqv2yla")
+	assert.Equal(RecordTypeList, list.RecordType)
+	assert.Equal("modlist", *list.ListPurpose)
+	assert.Nil(list.Tag)
+	assert.Nil(list.Description)
+
+	sp := TransformStarterPack(&appbsky.GraphStarterpack{
+		CreatedAt: "2999-01-01T00:00:00.000Z",
+		List:      "at://did:plc:u5cwb2mwiv2bfq53cjufe6yn/app.bsky.graph.list/3kpqxqnxwn22a",
+		Name:      "Cat people",
+	}, did, "3kpqxqnxwn22b", "bafyreiaj2dkwcpljdnc6ogl5xwcmxtaws3zbymue3hmzziayhtsmqv2yla")
+	assert.Equal(RecordTypeStarterPack, sp.RecordType)
+	assert.Equal("app.bsky.graph.starterpack", sp.Collection)
+	assert.Equal("at://did:plc:u5cwb2mwiv2bfq53cjufe6yn/app.bsky.graph.list/3kpqxqnxwn22a", *sp.ListATURI)
+	// far-future timestamps are dropped
+	assert.Nil(sp.CreatedAt)
+}
+
+func TestIsIndexedPath(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isIndexedPath("app.bsky.feed.post/3kpqxqnxwn22a"))
+	assert.True(isIndexedPath("app.bsky.actor.profile/self"))
+	assert.True(isIndexedPath("app.bsky.feed.generator/cats"))
+	assert.True(isIndexedPath("app.bsky.graph.list/3kpqxqnxwn22a"))
+	assert.True(isIndexedPath("app.bsky.graph.starterpack/3kpqxqnxwn22a"))
+	assert.False(isIndexedPath("app.bsky.graph.listitem/3kpqxqnxwn22a"))
+	assert.False(isIndexedPath("app.bsky.feed.like/3kpqxqnxwn22a"))
+}
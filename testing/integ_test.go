@@ -6,18 +6,35 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	atproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/labels"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/labeler"
+	"github.com/bluesky-social/indigo/bgs"
+	"github.com/bluesky-social/indigo/blobmirror"
 	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/gorilla/websocket"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-log/v2"
 	car "github.com/ipld/go-car"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 func init() {
@@ -284,6 +301,30 @@ func TestHandleChange(t *testing.T) {
 	fmt.Println(hcevt.RepoHandle)
 	idevt := evts.Next()
 	fmt.Println(idevt.RepoIdentity)
+
+	// the relay keeps a history of the account's identity
+	req, err := http.NewRequest("GET", "http://"+b1.Host()+"/admin/repo/identityHistory?did="+u.DID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(200, resp.StatusCode)
+	var history struct {
+		Changes []bgs.IdentityChange `json:"changes"`
+	}
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&history))
+	if assert.Len(history.Changes, 2) {
+		assert.Equal("catbear.pdsuno", history.Changes[0].Handle)
+		assert.Equal("handle", history.Changes[0].Changed)
+		assert.Equal(usernames[0]+".pdsuno", history.Changes[1].Handle)
+		assert.Equal("handle,pds,signingKey", history.Changes[1].Changed)
+		assert.NotEmpty(history.Changes[1].SigningKey)
+	}
 }
 
 func TestAccountEvent(t *testing.T) {
@@ -365,24 +406,31 @@ func TestAccountEvent(t *testing.T) {
 	assert.Equal(acevt.RepoAccount.Active, true)
 	assert.Equal(*acevt.RepoAccount.Status, events.AccountStatusActive)
 
-	// Takedown at Relay level, then emit active event and make sure relay overrides it
-	b1.bgs.TakeDownRepo(context.TODO(), u.DID())
+	// Takedown at Relay level, which the relay announces, then emit active event and make sure relay overrides it
+	assert.NoError(b1.bgs.TakeDownRepo(context.TODO(), u.DID()))
 	p1.ReactivateRepo(t, u.DID())
 
 	time.Sleep(time.Millisecond * 20)
 
-	acevt = evts.Next()
-	fmt.Println(acevt.RepoAccount)
-	assert.Equal(acevt.RepoAccount.Did, u.DID())
-	assert.Equal(acevt.RepoAccount.Active, false)
-	assert.Equal(*acevt.RepoAccount.Status, events.AccountStatusTakendown)
+	for range 2 {
+		acevt = evts.Next()
+		fmt.Println(acevt.RepoAccount)
+		assert.Equal(acevt.RepoAccount.Did, u.DID())
+		assert.Equal(acevt.RepoAccount.Active, false)
+		assert.Equal(*acevt.RepoAccount.Status, events.AccountStatusTakendown)
+	}
 
-	// Reactivate at Relay level, then emit an active account event and make sure relay passes it through
-	b1.bgs.ReverseTakedown(context.TODO(), u.DID())
+	// Reactivate at Relay level, which the relay announces, then emit an active account event and make sure relay passes it through
+	assert.NoError(b1.bgs.ReverseTakedown(context.TODO(), u.DID()))
 	p1.ReactivateRepo(t, u.DID())
 
 	time.Sleep(time.Millisecond * 20)
 
+	acevt = evts.Next()
+	assert.Equal(acevt.RepoAccount.Did, u.DID())
+	assert.Equal(acevt.RepoAccount.Active, true)
+	assert.Nil(acevt.RepoAccount.Status)
+
 	acevt = evts.Next()
 	fmt.Println(acevt.RepoAccount)
 	assert.Equal(acevt.RepoAccount.Did, u.DID())
@@ -390,6 +438,76 @@ func TestAccountEvent(t *testing.T) {
 	assert.Equal(*acevt.RepoAccount.Status, events.AccountStatusActive)
 }
 
+func TestRelayAccountMigration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+	didr := TestMovablePLC(t)
+	p1 := MustSetupPDS(t, ".pdsuno", didr)
+	p1.Run(t)
+
+	p2 := MustSetupPDS(t, ".pdsdos", didr)
+	p2.Run(t)
+
+	b1 := MustSetupRelay(t, didr)
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost(), p2.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+	p2.RequestScraping(t, b1)
+	p2.BumpLimits(t, b1)
+	time.Sleep(time.Millisecond * 100)
+
+	evts := b1.Events(t, -1)
+	defer evts.Cancel()
+
+	u := p1.MustNewUser(t, usernames[0]+".pdsuno")
+	u.Post(t, "posted from the old pds")
+	time.Sleep(time.Millisecond * 100)
+
+	moved := p2.MigrateUser(t, u, usernames[0]+".pdsdos", didr)
+	time.Sleep(time.Millisecond * 100)
+	post := moved.Post(t, "posted from the new pds")
+
+	// wait for the post made on the new host, collecting the account events before it
+	var acevts []*events.XRPCStreamEvent
+	for {
+		evt := evts.Next()
+		if evt.RepoAccount != nil && evt.RepoAccount.Did == u.DID() {
+			acevts = append(acevts, evt)
+		}
+		if evt.RepoCommit != nil && evt.RepoCommit.Repo == u.DID() && len(evt.RepoCommit.Ops) > 0 && strings.HasSuffix(post.Uri, evt.RepoCommit.Ops[0].Path) {
+			break
+		}
+	}
+
+	// the relay announces the move itself, as well as passing on the new host's activation
+	var announced bool
+	for _, evt := range acevts {
+		assert.True(evt.RepoAccount.Active)
+		if evt.RepoAccount.Status == nil {
+			announced = true
+		}
+	}
+	assert.True(announced, "expected the relay to emit an account event for the migration")
+
+	var pds2 models.PDS
+	if err := b1.db.First(&pds2, "host = ?", p2.RawHost()).Error; err != nil {
+		t.Fatal(err)
+	}
+	ai, err := b1.bgs.Index.LookupUserByDid(context.TODO(), u.DID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(pds2.ID, ai.PDS)
+
+	_, err = b1.bgs.Index.GetPost(context.TODO(), post.Uri)
+	assert.NoError(err)
+}
+
 func TestRelayTakedown(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping Relay test in 'short' test mode")
@@ -428,14 +546,20 @@ func TestRelayTakedown(t *testing.T) {
 
 	es2 := b1.Events(t, 0)
 	time.Sleep(time.Millisecond * 50) // wait for events to stream in and be collected
-	evts2 := es2.WaitFor(2)
+	evts2 := es2.WaitFor(3)
 
-	assert.Equal(2, len(evts2))
-	for _, e := range evts2 {
+	assert.Equal(3, len(evts2))
+	for _, e := range evts2[:2] {
 		if e.RepoCommit.Repo == bob.did {
 			t.Fatal("events from bob were not removed")
 		}
 	}
+	// the takedown is announced to downstream consumers
+	if acct := evts2[2].RepoAccount; assert.NotNil(acct) {
+		assert.Equal(bob.did, acct.Did)
+		assert.False(acct.Active)
+		assert.Equal(events.AccountStatusTakendown, *acct.Status)
+	}
 
 	bob.Post(t, "im gonna sneak through being banned")
 	time.Sleep(time.Millisecond * 50)
@@ -446,79 +570,345 @@ func TestRelayTakedown(t *testing.T) {
 	assert.Equal(alice.did, last.RepoCommit.Repo)
 }
 
-func jsonPrint(v any) {
-	b, _ := json.Marshal(v)
-	fmt.Println(string(b))
-}
+func TestRelayTakedownLabels(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+	ctx := context.TODO()
 
-func commitFromSlice(t *testing.T, slice []byte, rcid cid.Cid) *repo.SignedCommit {
-	carr, err := car.NewCarReader(bytes.NewReader(slice))
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labeldb, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "labels.sqlite")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := labeler.NewSQLLabelStore(labeldb)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	for {
-		blk, err := carr.Next()
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr, func(config *bgs.BGSConfig) {
+		config.Labeler = labeler.NewLabeler(store, "did:plc:relaylabeler", key, nil)
+	})
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, -1)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	es.WaitFor(1)
+
+	// a consumer following both the firehose and the relay's labels
+	var inactive []string
+	tracker := &events.AccountStatusTracker{
+		OnInactive: func(ctx context.Context, did string, status string) error {
+			inactive = append(inactive, status)
+			return nil
+		},
+		OnActive: func(ctx context.Context, did string) error {
+			inactive = append(inactive, "active")
+			return nil
+		},
+		TrustedLabelers: []string{"did:plc:relaylabeler"},
+	}
+
+	queryLabels := func() []*atproto.LabelDefs_Label {
+		out, err := atproto.LabelQueryLabels(ctx, &xrpc.Client{Host: "http://" + b1.Host()}, "", 50, nil, []string{bob.did})
 		if err != nil {
 			t.Fatal(err)
 		}
+		for _, l := range out.Labels {
+			assert.NoError(labels.Verify(l, pub))
+		}
+		return out.Labels
+	}
 
-		if blk.Cid() == rcid {
+	assert.NoError(b1.bgs.TakeDownRepo(ctx, bob.did))
+	lbls := queryLabels()
+	if assert.Len(lbls, 1) {
+		assert.Equal(bgs.LabelTakedown, lbls[0].Val)
+		assert.Equal(bob.did, lbls[0].Uri)
+		assert.Equal("did:plc:relaylabeler", lbls[0].Src)
+	}
+	assert.NoError(tracker.HandleLabels(ctx, 1, lbls))
+	evt := es.Next()
+	if assert.NotNil(evt.RepoAccount) {
+		assert.NoError(tracker.HandleAccount(ctx, evt.RepoAccount))
+	}
+	assert.False(tracker.IsActive(bob.did))
+
+	// reinstating negates the label, and the account is active again once both signals agree
+	assert.NoError(b1.bgs.ReinstateRepo(ctx, bob.did))
+	lbls = queryLabels()
+	if assert.Len(lbls, 2) {
+		assert.Equal(bgs.LabelTakedown, lbls[1].Val)
+		assert.True(*lbls[1].Neg)
+	}
+	evt = es.Next()
+	if assert.NotNil(evt.RepoAccount) {
+		assert.True(evt.RepoAccount.Active)
+		assert.NoError(tracker.HandleAccount(ctx, evt.RepoAccount))
+	}
+	assert.False(tracker.IsActive(bob.did))
+	assert.NoError(tracker.HandleLabels(ctx, 2, lbls[1:]))
+	assert.True(tracker.IsActive(bob.did))
+	assert.Equal([]string{"takendown", "active"}, inactive)
+}
 
-			var sc repo.SignedCommit
-			if err := sc.UnmarshalCBOR(bytes.NewReader(blk.RawData())); err != nil {
-				t.Fatal(err)
-			}
-			return &sc
+func TestRelayPurgeMirroredBlobs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	mirrordb, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "mirror.sqlite")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &blobmirror.DiskStore{Dir: t.TempDir()}
+	mirror, err := blobmirror.New(mirrordb, store, blobmirror.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1 := MustSetupRelay(t, didr, func(config *bgs.BGSConfig) {
+		config.BlobMirror = mirror
+	})
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, -1)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	bob.Post(t, "look at this")
+	es.WaitFor(2)
+
+	img := []byte("\x89PNG\r\n\x1a\nbob's picture")
+	imgCid, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobHost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(img)
+	}))
+	defer blobHost.Close()
+	assert.True(mirror.Enqueue(bob.DID(), blobHost.URL, data.Blob{Ref: data.CIDLink(imgCid), MimeType: "image/png", Size: int64(len(img))}))
+
+	getBlob := func() int {
+		resp, err := http.Get("http://" + b1.Host() + "/xrpc/com.atproto.sync.getBlob?did=" + bob.DID() + "&cid=" + imgCid.String())
+		if err != nil {
+			t.Fatal(err)
 		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Eventually(func() bool { return getBlob() == http.StatusOK }, 2*time.Second, 10*time.Millisecond)
+
+	report, err := b1.bgs.PurgeAccount(ctx, bob.DID())
+	if err != nil {
+		t.Fatal(err)
 	}
+	assert.EqualValues(1, report.MirroredBlobRefs)
+	assert.EqualValues(1, report.MirroredBlobs)
+	_, err = store.GetBlob(ctx, imgCid)
+	assert.ErrorIs(err, blobmirror.ErrBlobNotFound)
+
+	// the account is re-crawled when its PDS keeps emitting events, but the purged blob isn't served for it again
+	bob.Post(t, "i'm back")
+	assert.Eventually(func() bool {
+		var count int64
+		b1.db.Model(&bgs.User{}).Where("did = ?", bob.DID()).Count(&count)
+		return count == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(http.StatusNotFound, getBlob())
 }
 
-func TestDomainBans(t *testing.T) {
+func TestRelayPurgeAccount(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping Relay test in 'short' test mode")
 	}
+	assert := assert.New(t)
+	ctx := context.TODO()
+
 	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
 
 	b1 := MustSetupRelay(t, didr)
 	b1.Run(t)
 
-	b1.BanDomain(t, "foo.com")
+	b1.tr.TrialHosts = []string{p1.RawHost()}
 
-	c := &xrpc.Client{Host: "http://" + b1.Host()}
-	if err := atproto.SyncRequestCrawl(context.TODO(), c, &atproto.SyncRequestCrawl_Input{Hostname: "foo.com"}); err == nil {
-		t.Fatal("domain should be banned")
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es1 := b1.Events(t, 0)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	alice := p1.MustNewUser(t, "alice.tpds")
+
+	bob.Post(t, "please forget me")
+	alice.Post(t, "i'm staying")
+	es1.WaitFor(4)
+
+	report, err := b1.bgs.PurgeAccount(ctx, bob.did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(bob.did, report.Did)
+	assert.Greater(report.CarShards, 0)
+	assert.True(report.EventsPurged)
+	assert.EqualValues(1, report.Rows["users"])
+	assert.EqualValues(1, report.Rows["actor_infos"])
+	assert.False(report.CompletedAt.IsZero())
+
+	var count int64
+	if err := b1.db.Unscoped().Model(&bgs.User{}).Where("did = ?", bob.did).Count(&count).Error; err != nil {
+		t.Fatal(err)
 	}
+	assert.EqualValues(0, count)
 
-	if err := atproto.SyncRequestCrawl(context.TODO(), c, &atproto.SyncRequestCrawl_Input{Hostname: "pds.foo.com"}); err == nil {
-		t.Fatal("domain should be banned")
+	_, err = b1.bgs.PurgeAccount(ctx, bob.did)
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	// replay from the start no longer includes bob's events, only the announcement that the account was deleted
+	es2 := b1.Events(t, 0)
+	evts := es2.WaitFor(3)
+	for _, e := range evts[:2] {
+		assert.Equal(alice.did, e.RepoCommit.Repo)
+	}
+	if acct := evts[2].RepoAccount; assert.NotNil(acct) {
+		assert.Equal(bob.did, acct.Did)
+		assert.False(acct.Active)
+		assert.Equal(events.AccountStatusDeleted, *acct.Status)
 	}
+	time.Sleep(time.Millisecond * 100)
+	assert.Len(es2.All(), 3)
+}
 
-	err := atproto.SyncRequestCrawl(context.TODO(), c, &atproto.SyncRequestCrawl_Input{Hostname: "app.pds.foo.com"})
-	if err == nil {
-		t.Fatal("domain should be banned")
+func TestRelayListRepos(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
 	}
+	assert := assert.New(t)
+	ctx := context.TODO()
 
-	if !strings.Contains(err.Error(), "XRPC ERROR 401") {
-		t.Fatal("should have failed with a 401")
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr)
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, 0)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	alice := p1.MustNewUser(t, "alice.tpds")
+	carol := p1.MustNewUser(t, "carol.tpds")
+	bob.Post(t, "hello")
+	es.WaitFor(4)
+
+	assert.NoError(b1.bgs.SuspendRepo(ctx, alice.did))
+
+	c := &xrpc.Client{Host: "http://" + b1.Host()}
+	list := func(params map[string]any) *atproto.SyncListRepos_Output {
+		t.Helper()
+		var out atproto.SyncListRepos_Output
+		if err := c.Do(ctx, xrpc.Query, "", "com.atproto.sync.listRepos", params, nil, &out); err != nil {
+			t.Fatal(err)
+		}
+		return &out
 	}
 
-	// should not be banned
-	err = atproto.SyncRequestCrawl(context.TODO(), c, &atproto.SyncRequestCrawl_Input{Hostname: "foo.bar.com"})
-	if err == nil {
-		t.Fatal("should still fail")
+	// active accounts only, by default, paginated
+	var dids []string
+	params := map[string]any{"limit": 1}
+	for i := 0; i < 5; i++ {
+		out := list(params)
+		for _, r := range out.Repos {
+			dids = append(dids, r.Did)
+			assert.True(*r.Active)
+			assert.NotEmpty(r.Head)
+			assert.NotEmpty(r.Rev)
+		}
+		if out.Cursor == nil {
+			break
+		}
+		params["cursor"] = *out.Cursor
 	}
+	// accounts are created in the order their first events are processed, which may vary
+	assert.ElementsMatch([]string{bob.did, carol.did}, dids)
 
-	if !strings.Contains(err.Error(), "XRPC ERROR 400") {
-		t.Fatal("should have failed with a 400")
+	latest, err := atproto.SyncGetLatestCommit(ctx, c, bob.did)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := list(map[string]any{"status": "all"})
+	assert.Len(out.Repos, 3)
+	for _, r := range out.Repos {
+		if r.Did == bob.did {
+			assert.Equal(latest.Rev, r.Rev)
+			assert.Equal(latest.Cid, r.Head)
+		}
 	}
+
+	out = list(map[string]any{"status": "suspended"})
+	assert.Len(out.Repos, 1)
+	assert.Equal(alice.did, out.Repos[0].Did)
+	assert.False(*out.Repos[0].Active)
+	assert.Equal("suspended", *out.Repos[0].Status)
+
+	out = list(map[string]any{"host": p1.RawHost()})
+	assert.Len(out.Repos, 2)
+
+	out = list(map[string]any{"host": "pds.unknown.example"})
+	assert.Len(out.Repos, 0)
+
+	var xerr *xrpc.Error
+	err = c.Do(ctx, xrpc.Query, "", "com.atproto.sync.listRepos", map[string]any{"status": "bogus"}, nil, nil)
+	assert.ErrorAs(err, &xerr)
 }
 
-func TestRelayHandleEmptyEvent(t *testing.T) {
+func TestRelayRepoStatus(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping Relay test in 'short' test mode")
 	}
 	assert := assert.New(t)
+	ctx := context.TODO()
+
 	didr := TestPLC(t)
 	p1 := MustSetupPDS(t, ".tpds", didr)
 	p1.Run(t)
@@ -532,23 +922,1366 @@ func TestRelayHandleEmptyEvent(t *testing.T) {
 	p1.BumpLimits(t, b1)
 
 	time.Sleep(time.Millisecond * 50)
-
-	evts := b1.Events(t, -1)
-	defer evts.Cancel()
+	es := b1.Events(t, 0)
 
 	bob := p1.MustNewUser(t, "bob.tpds")
-	fmt.Println("event 1")
-	e1 := evts.Next()
-	assert.NotNil(e1.RepoCommit)
-	assert.Equal(e1.RepoCommit.Repo, bob.DID())
+	alice := p1.MustNewUser(t, "alice.tpds")
+	es.WaitFor(2)
 
-	ctx := context.TODO()
-	rm := p1.server.Repoman()
-	if err := rm.BatchWrite(ctx, 1, nil); err != nil {
-		t.Fatal(err)
+	statuses := func(dids ...string) []*atproto.SyncGetRepoStatus_Output {
+		t.Helper()
+		body, err := json.Marshal(bgs.RepoStatusesRequest{Dids: dids})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Post("http://"+b1.Host()+"/repos/status", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d", resp.StatusCode)
+		}
+		var out bgs.RepoStatusesResponse
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return out.Repos
+	}
+
+	// unknown accounts and duplicates are left out
+	out := statuses(alice.did, "did:plc:unknown", bob.did, alice.did)
+	if assert.Len(out, 2) {
+		assert.Equal(alice.did, out[0].Did)
+		assert.True(out[0].Active)
+		assert.Equal(bob.did, out[1].Did)
+	}
+
+	// cached statuses follow relay actions
+	assert.NoError(b1.bgs.SuspendRepo(ctx, alice.did))
+	out = statuses(alice.did, bob.did)
+	if assert.Len(out, 2) {
+		assert.False(out[0].Active)
+		assert.Equal("suspended", *out[0].Status)
+		assert.True(out[1].Active)
+	}
+
+	c := &xrpc.Client{Host: "http://" + b1.Host()}
+	rs, err := atproto.SyncGetRepoStatus(ctx, c, alice.did)
+	if assert.NoError(err) {
+		assert.False(rs.Active)
+		assert.Equal("suspended", *rs.Status)
+	}
+	assert.NoError(b1.bgs.ReinstateRepo(ctx, alice.did))
+	rs, err = atproto.SyncGetRepoStatus(ctx, c, alice.did)
+	if assert.NoError(err) {
+		assert.True(rs.Active)
+		assert.Nil(rs.Status)
+	}
+
+	// and account events
+	assert.NoError(b1.bgs.UpdateAccountStatus(ctx, bob.did, events.AccountStatusDeactivated))
+	out = statuses(bob.did)
+	if assert.Len(out, 1) {
+		assert.Equal("deactivated", *out[0].Status)
+	}
+
+	_, err = atproto.SyncGetRepoStatus(ctx, c, "did:plc:unknown")
+	assert.Error(err)
+}
+
+func TestRelaySyncGetRecordAndBlocks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr)
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, 0)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	bob.Post(t, "an older post")
+	post := bob.Post(t, "prove it")
+	evts := es.WaitFor(3)
+	uri, err := syntax.ParseATURI(post.Uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &xrpc.Client{Host: "http://" + b1.Host()}
+
+	// the proof is a partial repo, rooted at the latest commit, with the path to the record
+	proof, err := atproto.SyncGetRecord(ctx, c, "app.bsky.feed.post", "", bob.did, uri.RecordKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(proof))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(evts[2].RepoCommit.Rev, r.SignedCommit().Rev)
+	rcid, rec, err := r.GetRecordBytes(ctx, "app.bsky.feed.post/"+uri.RecordKey().String())
+	if assert.NoError(err) {
+		assert.Equal(post.Cid, rcid.String())
+		assert.NotEmpty(*rec)
+	}
+
+	_, err = atproto.SyncGetRecord(ctx, c, "app.bsky.feed.post", "", bob.did, "3kzzzzzzzzzzz")
+	assert.Error(err)
+
+	blks, err := atproto.SyncGetBlocks(ctx, c, []string{post.Cid, evts[2].RepoCommit.Commit.String()}, bob.did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr, err := carv2.NewBlockReader(bytes.NewReader(blks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		blk, err := cr.Next()
+		if err != nil {
+			break
+		}
+		got = append(got, blk.Cid().String())
+	}
+	assert.ElementsMatch([]string{post.Cid, evts[2].RepoCommit.Commit.String()}, got)
+
+	// any missing block fails the request
+	_, err = atproto.SyncGetBlocks(ctx, c, []string{post.Cid, "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"}, bob.did)
+	assert.Error(err)
+}
+
+func TestRelayInternalBlockFetch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr, func(c *bgs.BGSConfig) {
+		c.InternalTokens = []string{"internal"}
+	})
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, 0)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	alice := p1.MustNewUser(t, "alice.tpds")
+	bp := bob.Post(t, "hydrate me")
+	ap := alice.Post(t, "me too")
+	evts := es.WaitFor(4)
+
+	fetch := func(token string, cids ...string) (int, []string) {
+		body, err := json.Marshal(bgs.BlockFetchRequest{Cids: cids})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", "http://"+b1.Host()+"/internal/blocks", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, nil
+		}
+		// the transport asked for gzip, and decompressed the response
+		assert.True(resp.Uncompressed)
+		cr, err := carv2.NewBlockReader(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for {
+			blk, err := cr.Next()
+			if err != nil {
+				break
+			}
+			got = append(got, blk.Cid().String())
+		}
+		return resp.StatusCode, got
+	}
+
+	// blocks from several accounts in one request, leaving out the ones which aren't stored
+	missing, err := cid.NewPrefixV1(cid.DagCBOR, multihash.SHA2_256).Sum([]byte("not stored"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{bp.Cid, ap.Cid, evts[2].RepoCommit.Commit.String(), evts[3].RepoCommit.Commit.String()}
+	status, got := fetch("internal", append(want, bp.Cid, missing.String())...)
+	assert.Equal(http.StatusOK, status)
+	assert.ElementsMatch(want, got)
+
+	// admin tokens work too, but nothing else does
+	status, _ = fetch("test", bp.Cid)
+	assert.Equal(http.StatusOK, status)
+	status, _ = fetch("wrong", bp.Cid)
+	assert.Equal(http.StatusForbidden, status)
+
+	// blocks only held by inactive accounts aren't served
+	assert.NoError(b1.bgs.TakeDownRepo(ctx, bob.did))
+	status, got = fetch("internal", bp.Cid, ap.Cid)
+	assert.Equal(http.StatusOK, status)
+	assert.ElementsMatch([]string{ap.Cid}, got)
+}
+
+func TestRelayResyncRepo(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr)
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es1 := b1.Events(t, 0)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	bob.Post(t, "cats for cats")
+
+	evts := es1.WaitFor(2)
+	assert.Equal(2, len(evts))
+	head := evts[1].RepoCommit.Rev
+
+	res, err := b1.bgs.ResyncRepo(context.TODO(), bob.did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing has diverged, so this is a no-op apart from the event
+	assert.False(res.Diverged())
+	assert.Equal(head, res.PrevRev)
+	assert.Equal(head, res.Rev)
+
+	sync := es1.Next()
+	if assert.NotNil(sync.RepoSync) {
+		assert.Equal(bob.did, sync.RepoSync.Did)
+		assert.Equal(head, sync.RepoSync.Rev)
+		assert.NotEmpty(sync.RepoSync.Blocks)
+	}
+
+	// the replayed stream includes the sync event too
+	es2 := b1.Events(t, 0)
+	evts2 := es2.WaitFor(3)
+	assert.NotNil(evts2[2].RepoSync)
+}
+
+func TestRelayHandleRevalidation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr)
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es1 := b1.Events(t, 0)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	es1.WaitFor(1)
+
+	userHandle := func() (string, bool) {
+		var u bgs.User
+		if err := b1.db.First(&u, "did = ?", bob.did).Error; err != nil {
+			t.Fatal(err)
+		}
+		return u.Handle.String, u.ValidHandle
+	}
+
+	h, valid := userHandle()
+	assert.True(valid)
+	assert.Equal("bob.tpds", h)
+
+	// verification now fails: the handle no longer resolves
+	b1.tr.TrialHosts = nil
+	assert.NoError(b1.bgs.RevalidateHandle(context.TODO(), bob.did))
+
+	_, valid = userHandle()
+	assert.False(valid)
+
+	evt := es1.Next()
+	if assert.NotNil(evt.RepoIdentity) {
+		assert.Equal(bob.did, evt.RepoIdentity.Did)
+		assert.Equal("handle.invalid", *evt.RepoIdentity.Handle)
+	}
+
+	// and is fixed again
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+	assert.NoError(b1.bgs.RevalidateHandle(context.TODO(), bob.did))
+
+	h, valid = userHandle()
+	assert.True(valid)
+	assert.Equal("bob.tpds", h)
+
+	evt = es1.Next()
+	if assert.NotNil(evt.RepoIdentity) {
+		assert.Equal("bob.tpds", *evt.RepoIdentity.Handle)
+	}
+
+	// nothing changed, so no event
+	assert.NoError(b1.bgs.RevalidateHandle(context.TODO(), bob.did))
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(3, len(es1.All()))
+}
+
+func TestRelayNonArchival(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr, func(cfg *bgs.BGSConfig) {
+		cfg.NonArchival = true
+	})
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es1 := b1.Events(t, 0)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	alice := p1.MustNewUser(t, "alice.tpds")
+	bob.Post(t, "cats for cats")
+	alice.Post(t, "no i like dogs")
+	bp := bob.Post(t, "and more cats")
+	bob.Like(t, bp)
+
+	evts := es1.WaitFor(6)
+	assert.Equal(6, len(evts))
+
+	// events for different repos may be reordered
+	var last *atproto.SyncSubscribeRepos_Commit
+	for _, e := range evts {
+		if e.RepoCommit.Repo == bob.did {
+			last = e.RepoCommit
+		}
+	}
+	assert.NotEmpty(last.Blocks)
+	assert.Equal(1, len(last.Ops))
+
+	c := &xrpc.Client{Host: "http://" + b1.Host()}
+	head, err := atproto.SyncGetLatestCommit(context.TODO(), c, bob.did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(last.Rev, head.Rev)
+	assert.Equal(last.Commit.String(), head.Cid)
+
+	// repo data isn't kept, and the default is to refuse rather than proxy
+	_, err = atproto.SyncGetRepo(context.TODO(), c, bob.did, "")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "501")
+	}
+
+	// replay is served from the event persister
+	es2 := b1.Events(t, 0)
+	assert.Equal(6, len(es2.WaitFor(6)))
+}
+
+func TestRelayHostPause(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr)
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, 0)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	bob.Post(t, "before the pause")
+	es.WaitFor(2)
+
+	hi, err := b1.bgs.GetHost(ctx, p1.RawHost())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(bgs.HostStatusConnected, hi.Status)
+	assert.EqualValues(1, hi.Accounts)
+	assert.EqualValues(2, hi.TotalEvents)
+	assert.Greater(hi.Cursor, int64(0))
+	assert.Greater(hi.EventsPerSecond, 0.0)
+	assert.NotNil(hi.LastEventAt)
+
+	hosts, err := b1.bgs.ListHosts(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(hosts, 1)
+
+	if err := b1.bgs.PauseHost(ctx, p1.RawHost()); err != nil {
+		t.Fatal(err)
+	}
+
+	hi, err = b1.bgs.GetHost(ctx, p1.RawHost())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(bgs.HostStatusPaused, hi.Status)
+	assert.Equal(hosts[0].Cursor, hi.Cursor)
+
+	bob.Post(t, "during the pause")
+	time.Sleep(time.Millisecond * 200)
+	assert.Len(es.All(), 2)
+
+	if err := b1.bgs.ResumeHost(ctx, p1.RawHost()); err != nil {
+		t.Fatal(err)
+	}
+
+	hi, err = b1.bgs.GetHost(ctx, p1.RawHost())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(hi.Paused)
+
+	// redialing is rate limited, so wait for the subscription to come back up
+	for i := 0; i < 50 && hi.Status != bgs.HostStatusConnected; i++ {
+		time.Sleep(time.Millisecond * 100)
+		hi, err = b1.bgs.GetHost(ctx, p1.RawHost())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(bgs.HostStatusConnected, hi.Status)
+
+	// the test PDS doesn't replay from a cursor, so only check that live events flow again
+	bob.Post(t, "after the pause")
+	evts := es.WaitFor(1)
+	assert.Equal(bob.did, evts[0].RepoCommit.Repo)
+}
+
+func TestRelayHostTrust(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr, func(cfg *bgs.BGSConfig) {
+		cfg.HostTrust.Default = bgs.HostTrustUntrusted
+	})
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, 0)
+
+	// commits from untrusted hosts are fully validated
+	bob := p1.MustNewUser(t, "bob.tpds")
+	bp := bob.Post(t, "checked thoroughly")
+	bob.Like(t, bp)
+	es.WaitFor(3)
+
+	hi, err := b1.bgs.GetHost(ctx, p1.RawHost())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(bgs.HostTrustUntrusted, hi.TrustLevel)
+	assert.EqualValues(0, hi.TotalErrors)
+
+	if err := b1.bgs.SetHostTrust(ctx, p1.RawHost(), bgs.HostTrustVerified); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(b1.bgs.SetHostTrust(ctx, p1.RawHost(), "bogus"))
+
+	bob.Post(t, "taken on trust")
+	evt := es.Next()
+	assert.Equal(bob.did, evt.RepoCommit.Repo)
+
+	hi, err = b1.bgs.GetHost(ctx, p1.RawHost())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(bgs.HostTrustVerified, hi.TrustLevel)
+}
+
+func TestRelayParallelCompaction(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr, func(config *bgs.BGSConfig) {
+		config.Compactor = bgs.DefaultCompactorOptions()
+		config.Compactor.NumWorkers = 3
+	})
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, -1)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	for i := 0; i < 10; i++ {
+		bob.Post(t, MakeRandomPost())
+	}
+	es.WaitFor(11)
+
+	compact := func() int {
+		req, err := http.NewRequest("POST", "http://"+b1.Host()+"/admin/repo/compact?did="+bob.DID(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer test")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// concurrent requests to compact the same repo are either run or refused, never run at once
+	var wg sync.WaitGroup
+	codes := make([]int, 4)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = compact()
+		}(i)
+	}
+	wg.Wait()
+	var ok int
+	for _, code := range codes {
+		if code == http.StatusOK {
+			ok++
+		} else {
+			assert.Equal(http.StatusConflict, code)
+		}
+	}
+	assert.GreaterOrEqual(ok, 1)
+
+	req, err := http.NewRequest("GET", "http://"+b1.Host()+"/admin/repo/compactionQueue", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var qs bgs.CompactionQueueStatus
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&qs))
+	assert.Equal(3, qs.Workers)
+	assert.Len(qs.State.Workers, 3)
+	assert.Equal(int64(ok), qs.State.Compacted)
+	assert.Equal(int64(0), qs.State.Failed)
+	assert.Greater(qs.State.ShardsDeleted, int64(0))
+}
+
+func TestRelayCompactionHistory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr, func(config *bgs.BGSConfig) {
+		config.Compactor = bgs.DefaultCompactorOptions()
+		config.Compactor.HistorySize = 2
+	})
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, -1)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	alice := p1.MustNewUser(t, "alice.tpds")
+	for i := 0; i < 5; i++ {
+		bob.Post(t, MakeRandomPost())
+		alice.Post(t, MakeRandomPost())
+	}
+	es.WaitFor(12)
+
+	admin := func(method, path string, out any) {
+		req, err := http.NewRequest(method, "http://"+b1.Host()+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer test")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: status %d", method, path, resp.StatusCode)
+		}
+		if out != nil {
+			assert.NoError(json.NewDecoder(resp.Body).Decode(out))
+		}
+	}
+
+	admin("POST", "/admin/repo/compact?did="+bob.DID(), nil)
+	admin("POST", "/admin/repo/compact?did="+bob.DID(), nil)
+	admin("POST", "/admin/repo/compact?did="+alice.DID(), nil)
+
+	// only the most recent compactions are kept, newest first
+	var hist bgs.CompactionHistory
+	admin("GET", "/admin/repo/compactionHistory?since=1h", &hist)
+	if assert.Len(hist.Records, 2) {
+		assert.Equal(alice.DID(), hist.Records[0].DID)
+		assert.Equal(bob.DID(), hist.Records[1].DID)
+		assert.Equal("admin", hist.Records[0].Priority)
+		assert.Equal(-1, hist.Records[0].Worker)
+		assert.Equal("done", hist.Records[0].Status)
+		assert.NotNil(hist.Records[0].Stats)
+	}
+	assert.Nil(hist.User)
+
+	// per-repo totals outlive the history
+	hist = bgs.CompactionHistory{}
+	admin("GET", "/admin/repo/compactionHistory?did="+bob.DID(), &hist)
+	assert.Len(hist.Records, 1)
+	if assert.NotNil(hist.User) {
+		assert.Equal(bob.DID(), hist.User.DID)
+		assert.Equal(int64(2), hist.User.Compactions)
+		assert.Equal(int64(0), hist.User.Failures)
+		assert.Greater(hist.User.ShardsDeleted, int64(0))
+	}
+
+	hist = bgs.CompactionHistory{}
+	admin("GET", "/admin/repo/compactionHistory?limit=1", &hist)
+	assert.Len(hist.Records, 1)
+
+	time.Sleep(time.Millisecond * 10)
+	hist = bgs.CompactionHistory{}
+	admin("GET", "/admin/repo/compactionHistory?since=5ms", &hist)
+	assert.Empty(hist.Records)
+}
+
+func TestRelayStorageUsage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr)
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, -1)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	alice := p1.MustNewUser(t, "alice.tpds")
+	for i := 0; i < 5; i++ {
+		bob.Post(t, MakeRandomPost())
+	}
+	alice.Post(t, MakeRandomPost())
+	es.WaitFor(8)
+
+	req, err := http.NewRequest("GET", "http://"+b1.Host()+"/admin/storage?did="+alice.DID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	var rep bgs.StorageReport
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&rep))
+	assert.Equal(int64(2), rep.Usage.Users)
+	assert.Equal(int64(0), rep.Usage.Unmeasured)
+	assert.Greater(rep.Usage.Bytes, int64(0))
+	if assert.NotNil(rep.Repo) {
+		assert.Equal(alice.DID(), rep.Repo.DID)
+		assert.Greater(rep.Repo.Bytes, int64(0))
+	}
+	if assert.Len(rep.Top, 2) {
+		assert.Equal(bob.DID(), rep.Top[0].DID)
+		assert.Equal(rep.Usage.Bytes, rep.Top[0].Bytes+rep.Top[1].Bytes)
+		assert.Equal(rep.Usage.Shards, rep.Top[0].Shards+rep.Top[1].Shards)
+	}
+}
+
+func TestRelaySuspendAudit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr)
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, -1)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	alice := p1.MustNewUser(t, "alice.tpds")
+	es.WaitFor(2)
+
+	admin := func(path string, body map[string]string) int {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "http://"+b1.Host()+path, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(200, admin("/admin/repo/suspend", map[string]string{"did": bob.did, "actor": "mod@example.com", "reason": "spam"}))
+	assert.Equal(404, admin("/admin/repo/suspend", map[string]string{"did": "did:plc:nobody"}))
+
+	// suspended accounts are neither served nor rebroadcast
+	resp, err := http.Get("http://" + b1.Host() + "/xrpc/com.atproto.sync.getRepo?did=" + bob.did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.NotEqual(200, resp.StatusCode)
+
+	// the suspension is announced to downstream consumers
+	evt := es.Next()
+	if assert.NotNil(evt.RepoAccount) {
+		assert.Equal(bob.did, evt.RepoAccount.Did)
+		assert.False(evt.RepoAccount.Active)
+		assert.Equal(events.AccountStatusSuspended, *evt.RepoAccount.Status)
+	}
+
+	bob.Post(t, "nobody can hear me")
+	time.Sleep(time.Millisecond * 50)
+	alice.Post(t, "hello")
+	evt = es.Next()
+	assert.Equal(alice.did, evt.RepoCommit.Repo)
+
+	assert.Equal(200, admin("/admin/repo/reinstate", map[string]string{"did": bob.did, "actor": "mod@example.com"}))
+	evt = es.Next()
+	if assert.NotNil(evt.RepoAccount) {
+		assert.Equal(bob.did, evt.RepoAccount.Did)
+		assert.True(evt.RepoAccount.Active)
+	}
+	bob.Post(t, "im back")
+	evt = es.Next()
+	assert.Equal(bob.did, evt.RepoCommit.Repo)
+
+	req, err := http.NewRequest("GET", "http://"+b1.Host()+"/admin/audit/list?subject="+bob.did, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer test")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var audit struct {
+		Actions []bgs.AdminAction `json:"actions"`
+	}
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&audit))
+	assert.Equal(2, len(audit.Actions))
+	assert.Equal(bgs.AdminActionReinstate, audit.Actions[0].Action)
+	assert.Equal(bgs.AdminActionSuspend, audit.Actions[1].Action)
+	assert.Equal("mod@example.com", audit.Actions[1].Actor)
+	assert.Equal("spam", audit.Actions[1].Reason)
+	assert.Equal(bgs.AdminSubjectAccount, audit.Actions[1].SubjectType)
+}
+
+func jsonPrint(v any) {
+	b, _ := json.Marshal(v)
+	fmt.Println(string(b))
+}
+
+func commitFromSlice(t *testing.T, slice []byte, rcid cid.Cid) *repo.SignedCommit {
+	carr, err := car.NewCarReader(bytes.NewReader(slice))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		blk, err := carr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if blk.Cid() == rcid {
+
+			var sc repo.SignedCommit
+			if err := sc.UnmarshalCBOR(bytes.NewReader(blk.RawData())); err != nil {
+				t.Fatal(err)
+			}
+			return &sc
+		}
+	}
+}
+
+func TestDomainBans(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	didr := TestPLC(t)
+
+	b1 := MustSetupRelay(t, didr)
+	b1.Run(t)
+
+	b1.BanDomain(t, "foo.com")
+
+	c := &xrpc.Client{Host: "http://" + b1.Host()}
+	if err := atproto.SyncRequestCrawl(context.TODO(), c, &atproto.SyncRequestCrawl_Input{Hostname: "foo.com"}); err == nil {
+		t.Fatal("domain should be banned")
+	}
+
+	if err := atproto.SyncRequestCrawl(context.TODO(), c, &atproto.SyncRequestCrawl_Input{Hostname: "pds.foo.com"}); err == nil {
+		t.Fatal("domain should be banned")
+	}
+
+	err := atproto.SyncRequestCrawl(context.TODO(), c, &atproto.SyncRequestCrawl_Input{Hostname: "app.pds.foo.com"})
+	if err == nil {
+		t.Fatal("domain should be banned")
+	}
+
+	if !strings.Contains(err.Error(), "XRPC ERROR 401") {
+		t.Fatal("should have failed with a 401")
+	}
+
+	// should not be banned
+	err = atproto.SyncRequestCrawl(context.TODO(), c, &atproto.SyncRequestCrawl_Input{Hostname: "foo.bar.com"})
+	if err == nil {
+		t.Fatal("should still fail")
+	}
+
+	if !strings.Contains(err.Error(), "XRPC ERROR 400") {
+		t.Fatal("should have failed with a 400")
+	}
+}
+
+func TestDomainBanWildcard(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+	didr := TestPLC(t)
+
+	b1 := MustSetupRelay(t, didr)
+	b1.Run(t)
+
+	b1.BanDomain(t, "*.spam.com")
+
+	c := &xrpc.Client{Host: "http://" + b1.Host()}
+	for _, host := range []string{"pds.spam.com", "a.pds.spam.com", "PDS.Spam.com"} {
+		err := atproto.SyncRequestCrawl(context.TODO(), c, &atproto.SyncRequestCrawl_Input{Hostname: host})
+		if assert.Error(err, host) {
+			assert.Contains(err.Error(), "XRPC ERROR 401", host)
+		}
+	}
+
+	// a wildcard only covers subdomains, so this gets as far as failing to contact the host
+	err := atproto.SyncRequestCrawl(context.TODO(), c, &atproto.SyncRequestCrawl_Input{Hostname: "spam.com"})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "XRPC ERROR 400")
+	}
+}
+
+func TestRelayHandleEmptyEvent(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr)
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+
+	evts := b1.Events(t, -1)
+	defer evts.Cancel()
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	fmt.Println("event 1")
+	e1 := evts.Next()
+	assert.NotNil(e1.RepoCommit)
+	assert.Equal(e1.RepoCommit.Repo, bob.DID())
+
+	ctx := context.TODO()
+	rm := p1.server.Repoman()
+	if err := rm.BatchWrite(ctx, 1, nil); err != nil {
+		t.Fatal(err)
 	}
 
 	e2 := evts.Next()
 	assert.Equal(len(e2.RepoCommit.Ops), 0)
 	assert.Equal(e2.RepoCommit.Repo, bob.DID())
 }
+
+func TestRelayShutdown(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr)
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, 0)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	bob.Post(t, "one")
+	bob.Post(t, "two")
+	es.WaitFor(3)
+
+	hi, err := b1.bgs.GetHost(ctx, p1.RawHost())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Greater(hi.Cursor, int64(0))
+
+	sctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	assert.Empty(b1.bgs.Shutdown(sctx))
+
+	// the cursor is saved as of the last processed event
+	var pds models.PDS
+	if err := b1.db.First(&pds, "host = ?", p1.RawHost()).Error; err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(hi.Cursor, pds.Cursor)
+
+	// no more upstream subscriptions or consumers
+	assert.ErrorIs(b1.bgs.ResumeHost(ctx, p1.RawHost()), bgs.ErrSlurperShutdown)
+	_, _, err = websocket.DefaultDialer.Dial("ws://"+b1.Host()+"/xrpc/com.atproto.sync.subscribeRepos", nil)
+	assert.Error(err)
+}
+
+func TestRelayCheckpointFailover(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	store := &bgs.FileCheckpointStore{Path: filepath.Join(t.TempDir(), "checkpoint.json")}
+	b1 := MustSetupRelay(t, didr, func(c *bgs.BGSConfig) {
+		c.Checkpoints = []bgs.CheckpointStore{store}
+		c.CheckpointInterval = 50 * time.Millisecond
+	})
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, 0)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	bob.Post(t, "one")
+	bob.Post(t, "two")
+	evts := es.WaitFor(3)
+	lastSeq := evts[2].RepoCommit.Seq
+
+	// checkpoints are written periodically
+	time.Sleep(time.Millisecond * 200)
+	cp, err := store.GetCheckpoint(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(lastSeq, cp.LastSeq)
+
+	// and on shutdown, once the upstream cursors are saved
+	sctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	assert.Empty(b1.bgs.Shutdown(sctx))
+	cp, err = store.GetCheckpoint(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pds models.PDS
+	if err := b1.db.First(&pds, "host = ?", p1.RawHost()).Error; err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(lastSeq, cp.LastSeq)
+	if assert.Len(cp.Hosts, 1) {
+		assert.Equal(p1.RawHost(), cp.Hosts[0].Host)
+		assert.Equal(pds.Cursor, cp.Hosts[0].Cursor)
+	}
+
+	// a standby takes over from the checkpoint, resuming the upstream stream and numbering events past the old relay's
+	b2 := MustSetupRelayFromCheckpoint(t, didr, cp, 100)
+	b2.Run(t)
+	b2.tr.TrialHosts = []string{p1.RawHost()}
+	es2 := b2.Events(t, 0)
+
+	bob.Post(t, "three")
+	evt := es2.Next()
+	if assert.NotNil(evt.RepoCommit) {
+		assert.Equal(bob.DID(), evt.RepoCommit.Repo)
+		assert.Greater(evt.RepoCommit.Seq, cp.LastSeq+100)
+	}
+}
+
+func TestRelayShardedFanout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	var shards []*TestRelay
+	for i := 0; i < 2; i++ {
+		b := MustSetupRelay(t, didr, func(c *bgs.BGSConfig) {
+			c.Shard = bgs.ShardConfig{Index: i, Count: 2}
+		})
+		b.Run(t)
+		b.tr.TrialHosts = []string{p1.RawHost()}
+		p1.RequestScraping(t, b)
+		p1.BumpLimits(t, b)
+		shards = append(shards, b)
+	}
+
+	f := MustSetupFanout(t, shards...)
+	f.Run(t)
+
+	time.Sleep(time.Millisecond * 50)
+
+	var users []*TestUser
+	for _, h := range []string{"alice", "bob", "carol", "dave", "eve", "frank"} {
+		u := p1.MustNewUser(t, h+".tpds")
+		u.Post(t, "hello from "+h)
+		users = append(users, u)
+	}
+
+	time.Sleep(time.Millisecond * 500)
+
+	// each shard only emits events for the accounts it owns
+	var total int
+	for i, b := range shards {
+		es := b.Events(t, 0)
+		time.Sleep(time.Millisecond * 100)
+		evts := es.All()
+		es.Cancel()
+		for _, evt := range evts {
+			assert.Equal(i, bgs.ShardForDid(bgs.EventDid(evt), 2))
+		}
+		total += len(evts)
+	}
+
+	// the fanout merges both into one contiguous sequence, covering every account
+	es := f.Events(t, 0)
+	evts := es.WaitFor(total)
+	dids := make(map[string]bool)
+	for i, evt := range evts {
+		if i > 0 {
+			assert.Equal(streamSeq(evts[i-1])+1, streamSeq(evt))
+		}
+		dids[bgs.EventDid(evt)] = true
+	}
+	for _, u := range users {
+		assert.True(dids[u.DID()], "no events for %s", u.DID())
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Len(es.All(), total)
+	es.Cancel()
+
+	// per-account requests are routed to the owning shard
+	for _, u := range users {
+		resp, err := http.Get("http://" + f.Host() + "/xrpc/com.atproto.sync.getLatestCommit?did=" + u.DID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		assert.Equal(200, resp.StatusCode)
+	}
+
+	// bulk status queries are split between the shards, and merged in the order requested
+	var req bgs.RepoStatusesRequest
+	for i := len(users) - 1; i >= 0; i-- {
+		req.Dids = append(req.Dids, users[i].DID())
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post("http://"+f.Host()+"/repos/status", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(200, resp.StatusCode)
+	var statuses bgs.RepoStatusesResponse
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&statuses))
+	if assert.Len(statuses.Repos, len(users)) {
+		for i, r := range statuses.Repos {
+			assert.Equal(req.Dids[i], r.Did)
+			assert.True(r.Active)
+		}
+	}
+}
+
+func TestRelayStaleHostRecovery(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr, func(c *bgs.BGSConfig) {
+		c.StaleHost = bgs.StaleHostConfig{
+			Timeout:       time.Millisecond * 500,
+			FlapThreshold: 2,
+			FlapWindow:    time.Hour,
+		}
+	})
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, 0)
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	bob.Post(t, "before")
+	before := es.WaitFor(2)
+
+	// the PDS goes quiet, which (with no pings answered within the timeout) looks like a dead connection
+	time.Sleep(time.Second * 3)
+
+	hi, err := b1.bgs.GetHost(ctx, p1.RawHost())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.GreaterOrEqual(hi.StaleReconnects, uint64(2))
+	assert.NotNil(hi.LastStaleAt)
+	assert.True(hi.Flapping)
+
+	// reconnecting resumes from the cursor, without missing or repeating events
+	bob.Post(t, "after")
+	after := es.Next()
+	assert.Equal(streamSeq(before[len(before)-1])+1, streamSeq(after))
+	assert.Equal(bob.DID(), after.RepoCommit.Repo)
+
+	time.Sleep(time.Millisecond * 200)
+	assert.Len(es.All(), len(before)+1)
+}
+
+func TestRelayAccountRateLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr, func(c *bgs.BGSConfig) {
+		c.AccountRateLimit = bgs.AccountRateLimitConfig{
+			CommitsPerMinute: 240,
+			Burst:            2,
+			Action:           bgs.AccountLimitThrottle,
+		}
+	})
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+
+	time.Sleep(time.Millisecond * 50)
+	es := b1.Events(t, 0)
+
+	alice := p1.MustNewUser(t, "alice.tpds")
+	bob := p1.MustNewUser(t, "bob.tpds")
+	alice.Post(t, "hello")
+	es.WaitFor(3)
+	assert.Empty(b1.bgs.RateLimitedAccounts())
+
+	// account creation used up part of bob's burst, so most of these posts are held to 4 per second
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		bob.Post(t, fmt.Sprintf("spam %d", i))
+	}
+	evts := es.WaitFor(5)
+	assert.GreaterOrEqual(time.Since(start), time.Millisecond*750)
+	for _, evt := range evts {
+		assert.Equal(bob.DID(), evt.RepoCommit.Repo)
+	}
+
+	limited := b1.bgs.RateLimitedAccounts()
+	if assert.Len(limited, 1) {
+		assert.Equal(bob.DID(), limited[0].Did)
+		assert.GreaterOrEqual(limited[0].Exceeded, uint64(3))
+	}
+
+	// other accounts are unaffected
+	start = time.Now()
+	alice.Post(t, "still here")
+	es.Next()
+	assert.Less(time.Since(start), time.Millisecond*200)
+}
+
+func streamSeq(evt *events.XRPCStreamEvent) int64 {
+	switch {
+	case evt.RepoCommit != nil:
+		return evt.RepoCommit.Seq
+	case evt.RepoHandle != nil:
+		return evt.RepoHandle.Seq
+	case evt.RepoIdentity != nil:
+		return evt.RepoIdentity.Seq
+	case evt.RepoAccount != nil:
+		return evt.RepoAccount.Seq
+	case evt.RepoSync != nil:
+		return evt.RepoSync.Seq
+	}
+	return 0
+}
@@ -23,6 +23,7 @@ import (
 	atproto "github.com/bluesky-social/indigo/api/atproto"
 	bsky "github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/bgs"
+	"github.com/bluesky-social/indigo/bgs/fanout"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/events/schedulers/sequential"
@@ -35,6 +36,7 @@ import (
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/repomgr"
 	bsutil "github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/util/ratelimit"
 	"github.com/bluesky-social/indigo/xrpc"
 	"github.com/ipfs/go-cid"
 	"github.com/multiformats/go-multihash"
@@ -48,9 +50,10 @@ import (
 )
 
 type TestPDS struct {
-	dir    string
-	server *pds.Server
-	plc    *api.PLCServer
+	dir        string
+	server     *pds.Server
+	plc        *api.PLCServer
+	signingKey *did.PrivKey
 
 	listener net.Listener
 
@@ -142,11 +145,14 @@ func SetupPDS(ctx context.Context, suffix string, plc plc.PLCClient) (*TestPDS,
 	if err != nil {
 		return nil, err
 	}
+	// every test account signs up and logs in from localhost
+	srv.SetAuthRateLimit(ratelimit.Inf, 0)
 
 	return &TestPDS{
-		dir:      dir,
-		server:   srv,
-		listener: li,
+		dir:        dir,
+		server:     srv,
+		signingKey: serkey,
+		listener:   li,
 	}, nil
 }
 
@@ -298,6 +304,59 @@ func (tp *TestPDS) NewUser(handle string) (*TestUser, error) {
 	}, nil
 }
 
+// MigrateUser moves a user's account from their PDS to this one, under a new handle, the way a client would: an account is created here for their DID, their repo is exported from the old PDS and imported here, the DID document is pointed at this PDS, and the account is activated
+func (tp *TestPDS) MigrateUser(t *testing.T, u *TestUser, handle string, didr *MovablePLC) *TestUser {
+	t.Helper()
+	ctx := context.TODO()
+
+	c := &xrpc.Client{
+		Host: tp.HTTPHost(),
+	}
+
+	email := handle + "@fake.com"
+	pass := "password"
+	out, err := atproto.ServerCreateAccount(ctx, c, &atproto.ServerCreateAccount_Input{
+		Did:      &u.did,
+		Email:    &email,
+		Handle:   handle,
+		Password: &pass,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Auth = &xrpc.AuthInfo{
+		AccessJwt:  out.AccessJwt,
+		RefreshJwt: out.RefreshJwt,
+		Handle:     out.Handle,
+		Did:        out.Did,
+	}
+
+	carb, err := atproto.SyncGetRepo(ctx, u.client, u.did, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := atproto.RepoImportRepo(ctx, c, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := didr.UpdateUserHandle(ctx, u.did, handle); err != nil {
+		t.Fatal(err)
+	}
+	didr.Move(u.did, tp)
+
+	if err := atproto.ServerActivateAccount(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	return &TestUser{
+		pds:    tp,
+		handle: out.Handle,
+		client: c,
+		did:    out.Did,
+	}
+}
+
 func (tp *TestPDS) TakedownRepo(t *testing.T, did string) {
 	req, err := http.NewRequest("GET", tp.HTTPHost()+"/takedownRepo?did="+did, nil)
 	if err != nil {
@@ -504,6 +563,67 @@ func TestPLC(t *testing.T) *plc.FakeDid {
 	return plc.NewFakeDid(db)
 }
 
+// MovablePLC is a fake PLC whose DID documents can be pointed at another PDS, as account migration does
+type MovablePLC struct {
+	*plc.FakeDid
+
+	lk    sync.Mutex
+	moved map[string]*TestPDS
+}
+
+func TestMovablePLC(t *testing.T) *MovablePLC {
+	return &MovablePLC{
+		FakeDid: TestPLC(t),
+		moved:   make(map[string]*TestPDS),
+	}
+}
+
+func (mp *MovablePLC) GetDocument(ctx context.Context, d string) (*did.Document, error) {
+	doc, err := mp.FakeDid.GetDocument(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	mp.lk.Lock()
+	to, ok := mp.moved[d]
+	mp.lk.Unlock()
+	if !ok {
+		// importing a repo checks it against the DID's #atproto key, which the fake PLC calls #signingKey
+		for i := range doc.VerificationMethod {
+			if vm := &doc.VerificationMethod[i]; vm.ID == "#signingKey" {
+				doc.VerificationMethod = append(doc.VerificationMethod, did.VerificationMethod{
+					ID:                 "#atproto",
+					Type:               did.KeyTypeMultikey,
+					PublicKeyMultibase: vm.PublicKeyMultibase,
+					Controller:         vm.Controller,
+				})
+				break
+			}
+		}
+		return doc, nil
+	}
+
+	mb := to.signingKey.Public().MultibaseString()
+	doc.VerificationMethod = []did.VerificationMethod{{
+		ID:                 "#atproto",
+		Type:               did.KeyTypeMultikey,
+		PublicKeyMultibase: &mb,
+		Controller:         d,
+	}}
+	doc.Service = []did.Service{{
+		Type:            "pds",
+		ServiceEndpoint: to.HTTPHost(),
+	}}
+	return doc, nil
+}
+
+// Move points a DID document at a PDS, with that PDS's signing key
+func (mp *MovablePLC) Move(d string, to *TestPDS) {
+	mp.lk.Lock()
+	defer mp.lk.Unlock()
+	mp.moved[d] = to
+}
+
 type TestRelay struct {
 	bgs *bgs.BGS
 	tr  *api.TestHandleResolver
@@ -518,10 +638,10 @@ func (t *TestRelay) Host() string {
 	return t.listener.Addr().String()
 }
 
-func MustSetupRelay(t *testing.T, didr plc.PLCClient) *TestRelay {
+func MustSetupRelay(t *testing.T, didr plc.PLCClient, configure ...func(*bgs.BGSConfig)) *TestRelay {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	tbgs, err := SetupRelay(ctx, didr)
+	tbgs, err := SetupRelay(ctx, didr, configure...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -529,7 +649,26 @@ func MustSetupRelay(t *testing.T, didr plc.PLCClient) *TestRelay {
 	return tbgs
 }
 
-func SetupRelay(ctx context.Context, didr plc.PLCClient) (*TestRelay, error) {
+// SetupRelay creates a relay with the default test config, adjusted by any configure funcs
+func SetupRelay(ctx context.Context, didr plc.PLCClient, configure ...func(*bgs.BGSConfig)) (*TestRelay, error) {
+	return setupRelay(ctx, didr, nil, configure...)
+}
+
+// MustSetupRelayFromCheckpoint sets up a relay taking over from the relay which wrote cp (see bgs.RestoreCheckpoint)
+func MustSetupRelayFromCheckpoint(t *testing.T, didr plc.PLCClient, cp *bgs.Checkpoint, margin int64) *TestRelay {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tbgs, err := setupRelay(ctx, didr, func(db *gorm.DB, evtman *events.EventManager) error {
+		return bgs.RestoreCheckpoint(ctx, db, evtman, cp, margin)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tbgs
+}
+
+// setupRelay sets up a test relay, calling restore (if given) before the relay starts
+func setupRelay(ctx context.Context, didr plc.PLCClient, restore func(*gorm.DB, *events.EventManager) error, configure ...func(*bgs.BGSConfig)) (*TestRelay, error) {
 	dir, err := os.MkdirTemp("", "integtest")
 	if err != nil {
 		return nil, err
@@ -580,10 +719,19 @@ func SetupRelay(ctx context.Context, didr plc.PLCClient) (*TestRelay, error) {
 		}
 	}, true) // TODO: actually want this to be false, but some tests use this to confirm the Relay has seen certain records
 
+	if restore != nil {
+		if err := restore(maindb, evtman); err != nil {
+			return nil, err
+		}
+	}
+
 	tr := &api.TestHandleResolver{}
 
 	bgsConfig := bgs.DefaultBGSConfig()
 	bgsConfig.SSL = false
+	for _, o := range configure {
+		o(bgsConfig)
+	}
 	b, err := bgs.NewBGS(maindb, ix, repoman, evtman, didr, rf, tr, bgsConfig)
 	if err != nil {
 		return nil, err
@@ -622,6 +770,72 @@ func (b *TestRelay) BanDomain(t *testing.T, d string) {
 	}
 }
 
+type TestFanout struct {
+	fanout *fanout.Fanout
+	db     *gorm.DB
+
+	listener net.Listener
+}
+
+func (f *TestFanout) Host() string {
+	return f.listener.Addr().String()
+}
+
+// MustSetupFanout creates a fanout front-end merging the firehoses of the given relays, in shard index order
+func MustSetupFanout(t *testing.T, shards ...*TestRelay) *TestFanout {
+	dir, err := os.MkdirTemp("", "integtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "fanout.sqlite")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := events.DefaultDiskPersistOptions()
+	opts.EventsPerFile = 10
+	dp, err := events.NewDiskPersistence(filepath.Join(dir, "dp-primary"), filepath.Join(dir, "dp-archive"), db, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hosts []string
+	for _, s := range shards {
+		hosts = append(hosts, s.Host())
+	}
+
+	f, err := fanout.NewFanout(db, dp, &fanout.Config{Shards: hosts})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lc net.ListenConfig
+	listener, err := lc.Listen(context.Background(), "tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &TestFanout{
+		fanout:   f,
+		db:       db,
+		listener: listener,
+	}
+}
+
+func (f *TestFanout) Run(t *testing.T) {
+	go func() {
+		if err := f.fanout.StartWithListener(f.listener); err != nil {
+			fmt.Println(err)
+		}
+	}()
+	time.Sleep(time.Millisecond * 10)
+}
+
+func (f *TestFanout) Events(t *testing.T, since int64) *EventStream {
+	return subscribeEvents(t, f.Host(), since)
+}
+
 type EventStream struct {
 	Lk     sync.Mutex
 	Events []*events.XRPCStreamEvent
@@ -631,6 +845,10 @@ type EventStream struct {
 }
 
 func (b *TestRelay) Events(t *testing.T, since int64) *EventStream {
+	return subscribeEvents(t, b.Host(), since)
+}
+
+func subscribeEvents(t *testing.T, host string, since int64) *EventStream {
 	d := websocket.Dialer{}
 	h := http.Header{}
 
@@ -639,7 +857,7 @@ func (b *TestRelay) Events(t *testing.T, since int64) *EventStream {
 		q = fmt.Sprintf("?cursor=%d", since)
 	}
 
-	con, resp, err := d.Dial("ws://"+b.Host()+"/xrpc/com.atproto.sync.subscribeRepos"+q, h)
+	con, resp, err := d.Dial("ws://"+host+"/xrpc/com.atproto.sync.subscribeRepos"+q, h)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -689,6 +907,13 @@ func (b *TestRelay) Events(t *testing.T, since int64) *EventStream {
 				es.Lk.Unlock()
 				return nil
 			},
+			RepoSync: func(evt *atproto.SyncSubscribeRepos_Sync) error {
+				fmt.Println("received sync event: ", evt.Seq, evt.Did)
+				es.Lk.Lock()
+				es.Events = append(es.Events, &events.XRPCStreamEvent{RepoSync: evt})
+				es.Lk.Unlock()
+				return nil
+			},
 		}
 		seqScheduler := sequential.NewScheduler("test", rsc.EventHandler)
 		if err := events.HandleRepoStream(ctx, con, seqScheduler); err != nil {
@@ -0,0 +1,336 @@
+package cliutil
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// WithConfigFile adds config file support to a daemon: a --config flag (also read from envVar), loading of the file before the app and each of cmds run, and a config-dump command which prints the effective configuration.
+//
+// The file is YAML (.yaml, .yml) or TOML (.toml). Top-level keys are the names of app flags; each of cmds gets a section (map or table) named after it, with keys for its own flags:
+//
+//	log-level: debug
+//	run:
+//	  bind: ":3999"
+//
+// Values from the file are only used for flags which weren't given on the command line or in an environment variable. Unknown keys, and values which don't parse for their flag, are errors.
+func WithConfigFile(app *cli.App, envVar string, cmds ...*cli.Command) {
+	app.Flags = append(app.Flags, &cli.StringFlag{
+		Name:    "config",
+		Usage:   "path to a YAML or TOML config file; flags and environment variables take precedence over it",
+		EnvVars: []string{envVar},
+	})
+
+	sections := make(map[string]bool, len(cmds))
+	for _, cmd := range cmds {
+		sections[cmd.Name] = true
+	}
+	appRequired := takeRequired(app.Flags)
+
+	app.Before = chainBefore(app.Before, func(cctx *cli.Context) error {
+		cfg, err := readConfigFile(cctx.String("config"))
+		if err != nil {
+			return err
+		}
+		top := make(map[string]any, len(cfg))
+		for k, v := range cfg {
+			if sections[k] {
+				if _, ok := v.(map[string]any); !ok {
+					return fmt.Errorf("config: %s: expected a section of %s flags", k, k)
+				}
+				continue
+			}
+			top[k] = v
+		}
+		if err := applyConfig(top, "", app.Flags, cctx.IsSet, cctx.Set); err != nil {
+			return err
+		}
+		return checkRequired(cctx, appRequired)
+	})
+
+	for _, cmd := range cmds {
+		cmd := cmd
+		required := takeRequired(cmd.Flags)
+		cmd.Before = chainBefore(cmd.Before, func(cctx *cli.Context) error {
+			cfg, err := readConfigFile(cctx.String("config"))
+			if err != nil {
+				return err
+			}
+			section, _ := cfg[cmd.Name].(map[string]any)
+			if err := applyConfig(section, cmd.Name+".", cmd.Flags, cctx.IsSet, cctx.Set); err != nil {
+				return err
+			}
+			return checkRequired(cctx, required)
+		})
+	}
+
+	app.Commands = append(app.Commands, configDumpCmd(cmds))
+}
+
+// takeRequired clears Required on flags, returning their names. urfave checks required flags before Before runs, so they're checked after loading the config file instead.
+func takeRequired(flags []cli.Flag) []string {
+	var names []string
+	for _, f := range flags {
+		rv := reflect.ValueOf(f)
+		if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		req := rv.Elem().FieldByName("Required")
+		if req.Kind() == reflect.Bool && req.Bool() {
+			req.SetBool(false)
+			names = append(names, f.Names()[0])
+		}
+	}
+	return names
+}
+
+func checkRequired(cctx *cli.Context, names []string) error {
+	var missing []string
+	for _, name := range names {
+		if !cctx.IsSet(name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("required flags %q not set (on the command line, in the environment, or in the config file)", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func chainBefore(first, second cli.BeforeFunc) cli.BeforeFunc {
+	if first == nil {
+		return second
+	}
+	return func(cctx *cli.Context) error {
+		if err := first(cctx); err != nil {
+			return err
+		}
+		return second(cctx)
+	}
+}
+
+// readConfigFile returns nil if no path is given
+func readConfigFile(path string) (map[string]any, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	cfg := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &cfg)
+	case ".toml":
+		err = toml.Unmarshal(b, &cfg)
+	default:
+		return nil, fmt.Errorf("config file must be .yaml, .yml, or .toml: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func isBuiltinFlag(f cli.Flag) bool {
+	return f == cli.HelpFlag || f == cli.VersionFlag
+}
+
+func lookupFlag(flags []cli.Flag, name string) cli.Flag {
+	for _, f := range flags {
+		if isBuiltinFlag(f) {
+			continue
+		}
+		for _, n := range f.Names() {
+			if n == name {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// applyConfig sets flags from cfg which aren't already set
+func applyConfig(cfg map[string]any, prefix string, flags []cli.Flag, isSet func(string) bool, set func(name, value string) error) error {
+	keys := make([]string, 0, len(cfg))
+	for k := range cfg {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		f := lookupFlag(flags, key)
+		if f == nil || key == "config" {
+			return fmt.Errorf("config: unknown key %s%s", prefix, key)
+		}
+		name := f.Names()[0]
+		if isSet(name) {
+			continue
+		}
+
+		values := []any{cfg[key]}
+		if list, ok := cfg[key].([]any); ok {
+			if sf, ok := f.(cli.DocGenerationSliceFlag); !ok || !sf.IsSliceFlag() {
+				return fmt.Errorf("config: %s%s: expected a single value, not a list", prefix, key)
+			}
+			values = list
+		}
+		for _, v := range values {
+			switch v.(type) {
+			case map[string]any, []any:
+				return fmt.Errorf("config: %s%s: expected a value for the flag, not a section", prefix, key)
+			}
+			if err := set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("config: %s%s: %w", prefix, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func configDumpCmd(cmds []*cli.Command) *cli.Command {
+	return &cli.Command{
+		Name:  "config-dump",
+		Usage: "print the effective configuration (from flags, environment, config file, and defaults) as a config file",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "output format: yaml or toml",
+				Value: "yaml",
+			},
+			&cli.BoolFlag{
+				Name:  "show-secrets",
+				Usage: "include tokens, passwords, and keys, instead of redacting them",
+			},
+		},
+		Action: func(cctx *cli.Context) error {
+			show := cctx.Bool("show-secrets")
+			out := make(map[string]any)
+			for _, f := range cctx.App.Flags {
+				name := f.Names()[0]
+				if isBuiltinFlag(f) || name == "config" {
+					continue
+				}
+				out[name] = dumpValue(name, cctx.Value(name), show)
+			}
+
+			// commands other than this one haven't parsed their flags, so resolve them here
+			cfg, err := readConfigFile(cctx.String("config"))
+			if err != nil {
+				return err
+			}
+			for _, cmd := range cmds {
+				if len(cmd.Flags) == 0 {
+					continue
+				}
+				set := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+				for _, f := range cmd.Flags {
+					if err := f.Apply(set); err != nil {
+						return err
+					}
+				}
+				section, _ := cfg[cmd.Name].(map[string]any)
+				isSet := func(name string) bool {
+					f := lookupFlag(cmd.Flags, name)
+					return f != nil && f.IsSet()
+				}
+				if err := applyConfig(section, cmd.Name+".", cmd.Flags, isSet, set.Set); err != nil {
+					return err
+				}
+				values := make(map[string]any, len(cmd.Flags))
+				for _, f := range cmd.Flags {
+					name := f.Names()[0]
+					values[name] = dumpValue(name, set.Lookup(name).Value.(flag.Getter).Get(), show)
+				}
+				out[cmd.Name] = values
+			}
+
+			var buf bytes.Buffer
+			switch cctx.String("format") {
+			case "yaml":
+				enc := yaml.NewEncoder(&buf)
+				enc.SetIndent(2)
+				err = enc.Encode(out)
+			case "toml":
+				err = toml.NewEncoder(&buf).Encode(out)
+			default:
+				return fmt.Errorf("unknown format: %s", cctx.String("format"))
+			}
+			if err != nil {
+				return err
+			}
+			_, err = cctx.App.Writer.Write(buf.Bytes())
+			return err
+		},
+	}
+}
+
+func isSecretFlag(name string) bool {
+	for _, s := range []string{"password", "secret", "token"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return strings.HasSuffix(name, "-key")
+}
+
+// dumpValue converts a flag value to something which round-trips through a config file
+func dumpValue(name string, v any, show bool) any {
+	switch val := v.(type) {
+	case time.Duration:
+		v = val.String()
+	// slice and timestamp flag values are returned by value
+	case cli.StringSlice:
+		v = val.Value()
+	case cli.IntSlice:
+		v = val.Value()
+	case cli.Int64Slice:
+		v = val.Value()
+	case cli.UintSlice:
+		v = val.Value()
+	case cli.Uint64Slice:
+		v = val.Value()
+	case cli.Float64Slice:
+		v = val.Value()
+	case cli.Timestamp:
+		if t := val.Value(); t != nil {
+			v = t.Format(time.RFC3339)
+		} else {
+			v = ""
+		}
+	}
+	if show {
+		return v
+	}
+	s, ok := v.(string)
+	if isSecretFlag(name) {
+		if !ok || s != "" {
+			return "<redacted>"
+		}
+		return v
+	}
+	// hide credentials in database and service URLs
+	if ok && strings.Contains(s, "://") {
+		if u, err := url.Parse(s); err == nil && u.User != nil {
+			if _, has := u.User.Password(); has {
+				u.User = url.UserPassword(u.User.Username(), "redacted")
+				return u.String()
+			}
+		}
+	}
+	return v
+}
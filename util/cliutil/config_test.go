@@ -0,0 +1,146 @@
+package cliutil
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+type testConfigResult struct {
+	level string
+	bind  string
+	hosts []string
+	limit int
+}
+
+func testConfigApp(out *testConfigResult, w *bytes.Buffer) *cli.App {
+	runCmd := &cli.Command{
+		Name: "run",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "bind", Value: ":2470", EnvVars: []string{"CLIUTIL_TEST_BIND"}},
+			&cli.StringSliceFlag{Name: "hosts"},
+			&cli.IntFlag{Name: "limit", Required: true},
+			&cli.StringFlag{Name: "admin-token"},
+		},
+		Action: func(cctx *cli.Context) error {
+			out.level = cctx.String("level")
+			out.bind = cctx.String("bind")
+			out.hosts = cctx.StringSlice("hosts")
+			out.limit = cctx.Int("limit")
+			return nil
+		},
+	}
+	app := &cli.App{
+		Name:   "test",
+		Writer: w,
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "level", Value: "info"},
+		},
+		Commands: []*cli.Command{runCmd},
+	}
+	WithConfigFile(app, "CLIUTIL_TEST_CONFIG", runCmd)
+	return app
+}
+
+func writeTestConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFile(t *testing.T) {
+	yamlPath := writeTestConfig(t, "config.yaml", `
+level: debug
+run:
+  bind: ":3000"
+  hosts: [a, b]
+  limit: 5
+`)
+	tomlPath := writeTestConfig(t, "config.toml", `
+level = "debug"
+
+[run]
+bind = ":3000"
+hosts = ["a", "b"]
+limit = 5
+`)
+
+	for _, path := range []string{yamlPath, tomlPath} {
+		var out testConfigResult
+		if err := testConfigApp(&out, &bytes.Buffer{}).Run([]string{"test", "--config", path, "run"}); err != nil {
+			t.Fatal(err)
+		}
+		if out.level != "debug" || out.bind != ":3000" || strings.Join(out.hosts, ",") != "a,b" || out.limit != 5 {
+			t.Fatalf("unexpected config from %s: %+v", path, out)
+		}
+	}
+
+	// flags and env vars take precedence over the file
+	t.Setenv("CLIUTIL_TEST_BIND", ":4000")
+	var out testConfigResult
+	if err := testConfigApp(&out, &bytes.Buffer{}).Run([]string{"test", "--config", yamlPath, "--level", "warn", "run", "--limit", "7"}); err != nil {
+		t.Fatal(err)
+	}
+	if out.level != "warn" || out.bind != ":4000" || out.limit != 7 {
+		t.Fatalf("unexpected config: %+v", out)
+	}
+}
+
+func TestConfigFileValidation(t *testing.T) {
+	for _, content := range []string{
+		"run:\n  bogus: 1\n",
+		"bogus: 1\n",
+		"run:\n  limit: lots\n",
+		"run:\n  bind: [a, b]\n",
+		"run: 1\n",
+	} {
+		path := writeTestConfig(t, "config.yaml", content)
+		var out testConfigResult
+		if err := testConfigApp(&out, &bytes.Buffer{}).Run([]string{"test", "--config", path, "run"}); err == nil {
+			t.Fatalf("expected error for config: %q", content)
+		}
+	}
+
+	// required flags are still required
+	var out testConfigResult
+	if err := testConfigApp(&out, &bytes.Buffer{}).Run([]string{"test", "run"}); err == nil || !strings.Contains(err.Error(), "limit") {
+		t.Fatalf("expected missing required flag error, got: %v", err)
+	}
+}
+
+func TestConfigDump(t *testing.T) {
+	path := writeTestConfig(t, "config.yaml", `
+run:
+  hosts: [a, b]
+  limit: 5
+  admin-token: hunter2
+`)
+	var w bytes.Buffer
+	var out testConfigResult
+	if err := testConfigApp(&out, &w).Run([]string{"test", "--config", path, "config-dump"}); err != nil {
+		t.Fatal(err)
+	}
+	dump := w.String()
+	if strings.Contains(dump, "hunter2") || !strings.Contains(dump, "admin-token: <redacted>") {
+		t.Fatalf("secret not redacted: %s", dump)
+	}
+
+	// the dump (with secrets) loads back as a config file
+	w.Reset()
+	if err := testConfigApp(&out, &w).Run([]string{"test", "--config", path, "config-dump", "--show-secrets"}); err != nil {
+		t.Fatal(err)
+	}
+	dumped := writeTestConfig(t, "dumped.yaml", w.String())
+	if err := testConfigApp(&out, &bytes.Buffer{}).Run([]string{"test", "--config", dumped, "run"}); err != nil {
+		t.Fatal(err)
+	}
+	if out.level != "info" || out.bind != ":2470" || strings.Join(out.hosts, ",") != "a,b" || out.limit != 5 {
+		t.Fatalf("unexpected config from dump: %+v\n%s", out, w.String())
+	}
+}
@@ -0,0 +1,147 @@
+package cliutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bluesky-social/indigo/util/migrate"
+
+	"github.com/urfave/cli/v2"
+)
+
+// MigrateCommand returns a "migrate" command for a daemon, with status, up, and down subcommands operating on the databases returned by open. Each migrator is selected with --db by its component name
+func MigrateCommand(open func(cctx *cli.Context) ([]*migrate.Migrator, error)) *cli.Command {
+	dbFlag := &cli.StringFlag{
+		Name:  "db",
+		Usage: "only this database (by component name, eg 'carstore'), instead of all of them",
+	}
+	selected := func(cctx *cli.Context) ([]*migrate.Migrator, error) {
+		migrators, err := open(cctx)
+		if err != nil {
+			return nil, err
+		}
+		name := cctx.String("db")
+		if name == "" {
+			return migrators, nil
+		}
+		for _, mg := range migrators {
+			if mg.Component() == name {
+				return []*migrate.Migrator{mg}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown database %q", name)
+	}
+
+	return &cli.Command{
+		Name:  "migrate",
+		Usage: "inspect and apply database schema migrations",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "status",
+				Usage: "list migrations, and whether each has been applied",
+				Flags: []cli.Flag{
+					dbFlag,
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print status as JSON",
+					},
+				},
+				Action: func(cctx *cli.Context) error {
+					migrators, err := selected(cctx)
+					if err != nil {
+						return err
+					}
+					var all []migrate.MigrationStatus
+					for _, mg := range migrators {
+						status, err := mg.Status(cctx.Context)
+						if err != nil {
+							return fmt.Errorf("%s: %w", mg.Component(), err)
+						}
+						all = append(all, status...)
+					}
+					if cctx.Bool("json") {
+						enc := json.NewEncoder(cctx.App.Writer)
+						enc.SetIndent("", "  ")
+						return enc.Encode(all)
+					}
+					tw := tabwriter.NewWriter(cctx.App.Writer, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(tw, "DB\tVERSION\tNAME\tAPPLIED")
+					for _, st := range all {
+						applied := "pending"
+						if st.Applied() {
+							applied = st.AppliedAt.Format(time.RFC3339)
+						}
+						if st.Unknown {
+							applied += " (unknown to this version)"
+						}
+						fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", st.Component, st.Version, st.Name, applied)
+					}
+					return tw.Flush()
+				},
+			},
+			{
+				Name:  "up",
+				Usage: "apply pending migrations",
+				Flags: []cli.Flag{
+					dbFlag,
+					&cli.IntFlag{
+						Name:  "to",
+						Usage: "apply migrations up to this version, instead of all of them (only with --db)",
+					},
+				},
+				Action: func(cctx *cli.Context) error {
+					if cctx.IsSet("to") && !cctx.IsSet("db") {
+						return fmt.Errorf("--to requires --db")
+					}
+					migrators, err := selected(cctx)
+					if err != nil {
+						return err
+					}
+					for _, mg := range migrators {
+						done, err := mg.Up(cctx.Context, cctx.Int("to"))
+						for _, m := range done {
+							fmt.Fprintf(cctx.App.Writer, "%s: applied %s\n", mg.Component(), m)
+						}
+						if err != nil {
+							return err
+						}
+						if len(done) == 0 {
+							fmt.Fprintf(cctx.App.Writer, "%s: up to date\n", mg.Component())
+						}
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "down",
+				Usage: "revert migrations newer than a version",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "db",
+						Usage:    "database to revert migrations in (by component name)",
+						Required: true,
+					},
+					&cli.IntFlag{
+						Name:     "to",
+						Usage:    "revert migrations newer than this version",
+						Required: true,
+					},
+				},
+				Action: func(cctx *cli.Context) error {
+					migrators, err := selected(cctx)
+					if err != nil {
+						return err
+					}
+					mg := migrators[0]
+					done, err := mg.Down(cctx.Context, cctx.Int("to"))
+					for _, m := range done {
+						fmt.Fprintf(cctx.App.Writer, "%s: reverted %s\n", mg.Component(), m)
+					}
+					return err
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,46 @@
+// Package dbreplica spreads read-only queries across read replicas of a database. Writes, and reads which must see them, stay on the primary.
+package dbreplica
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+type Set struct {
+	primary  *gorm.DB
+	replicas []*gorm.DB
+	next     atomic.Uint64
+}
+
+// New makes a set of databases around primary. With no replicas, every query goes to the primary
+func New(primary *gorm.DB, replicas ...*gorm.DB) *Set {
+	return &Set{
+		primary:  primary,
+		replicas: replicas,
+	}
+}
+
+func (s *Set) Primary() *gorm.DB {
+	return s.primary
+}
+
+// Read returns the database for a read-only query which can tolerate replication lag: each replica in turn, or the primary if there are none
+func (s *Set) Read() *gorm.DB {
+	if len(s.replicas) == 0 {
+		return s.primary
+	}
+	return s.replicas[(s.next.Add(1)-1)%uint64(len(s.replicas))]
+}
+
+// Ping checks that every replica is usable, for readiness checks
+func (s *Set) Ping(ctx context.Context) error {
+	for i, db := range s.replicas {
+		if err := db.WithContext(ctx).Exec("SELECT 1").Error; err != nil {
+			return fmt.Errorf("read replica %d: %w", i, err)
+		}
+	}
+	return nil
+}
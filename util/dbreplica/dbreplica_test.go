@@ -0,0 +1,48 @@
+package dbreplica
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestRead(t *testing.T) {
+	primary := testDB(t)
+	s := New(primary)
+	if s.Read() != primary || s.Primary() != primary {
+		t.Fatal("expected reads to go to the primary without replicas")
+	}
+
+	r1, r2 := testDB(t), testDB(t)
+	s = New(primary, r1, r2)
+	for i, want := range []*gorm.DB{r1, r2, r1, r2} {
+		if s.Read() != want {
+			t.Fatalf("read %d went to the wrong database", i)
+		}
+	}
+	if s.Primary() != primary {
+		t.Fatal("expected the primary to be kept")
+	}
+
+	if err := s.Ping(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := r2.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+	if err := s.Ping(context.TODO()); err == nil {
+		t.Fatal("expected ping of a closed replica to fail")
+	}
+}
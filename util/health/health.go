@@ -0,0 +1,155 @@
+// Package health provides the liveness (/healthz), readiness (/readyz), and build info (/buildinfo) endpoints shared by indigo daemons.
+//
+// Liveness only reports that the process is serving requests. Readiness runs the checks registered with [Checker.Add] (database connections, upstream subscriptions, etc), and fails if any of them do, so orchestrators can hold traffic until a daemon is usable.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/carlmjohnson/versioninfo"
+)
+
+// Check reports whether a dependency is usable
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type Checker struct {
+	// service name, reported by all endpoints
+	service string
+	// max time for all readiness checks to run
+	timeout time.Duration
+
+	lk     sync.Mutex
+	checks []namedCheck
+}
+
+// NewChecker returns a Checker with no readiness checks, and a 5 second readiness timeout
+func NewChecker(service string) *Checker {
+	return &Checker{
+		service: service,
+		timeout: 5 * time.Second,
+	}
+}
+
+// Add registers a readiness check
+func (c *Checker) Add(name string, check Check) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+type Status struct {
+	Service string `json:"service"`
+	Status  string `json:"status"`
+	// result of each readiness check: "ok", or the error
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Ready runs all readiness checks concurrently, returning whether they all passed, and each check's result
+func (c *Checker) Ready(ctx context.Context) (bool, map[string]string) {
+	c.lk.Lock()
+	checks := append([]namedCheck(nil), c.checks...)
+	c.lk.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func(i int, nc namedCheck) {
+			defer wg.Done()
+			errs[i] = nc.check(ctx)
+		}(i, nc)
+	}
+	wg.Wait()
+
+	ok := true
+	results := make(map[string]string, len(checks))
+	for i, nc := range checks {
+		if errs[i] != nil {
+			ok = false
+			results[nc.name] = errs[i].Error()
+		} else {
+			results[nc.name] = "ok"
+		}
+	}
+	return ok, results
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Healthz is the liveness endpoint, which always succeeds while the process is serving HTTP
+func (c *Checker) Healthz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Status{Service: c.service, Status: "ok"})
+	})
+}
+
+// Readyz is the readiness endpoint. It responds 503 if any check fails, with the results of every check.
+func (c *Checker) Readyz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, results := c.Ready(r.Context())
+		if !ok {
+			writeJSON(w, http.StatusServiceUnavailable, Status{Service: c.service, Status: "unavailable", Checks: results})
+			return
+		}
+		writeJSON(w, http.StatusOK, Status{Service: c.service, Status: "ok", Checks: results})
+	})
+}
+
+type BuildInfo struct {
+	Service    string     `json:"service"`
+	Version    string     `json:"version"`
+	Commit     string     `json:"commit"`
+	CommitTime *time.Time `json:"commit_time,omitempty"`
+	Dirty      bool       `json:"dirty"`
+	GoVersion  string     `json:"go_version"`
+}
+
+// GetBuildInfo returns version information for the running binary, from the Go toolchain's embedded build info
+func (c *Checker) GetBuildInfo() BuildInfo {
+	bi := BuildInfo{
+		Service:   c.service,
+		Version:   versioninfo.Short(),
+		Commit:    versioninfo.Revision,
+		Dirty:     versioninfo.DirtyBuild,
+		GoVersion: runtime.Version(),
+	}
+	if !versioninfo.LastCommit.IsZero() {
+		t := versioninfo.LastCommit
+		bi.CommitTime = &t
+	}
+	return bi
+}
+
+// BuildInfo is the build info endpoint
+func (c *Checker) BuildInfo() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.GetBuildInfo())
+	})
+}
+
+// Register adds the /healthz, /readyz, and /buildinfo endpoints to a mux
+func (c *Checker) Register(mux interface {
+	Handle(pattern string, handler http.Handler)
+}) {
+	mux.Handle("/healthz", c.Healthz())
+	mux.Handle("/readyz", c.Readyz())
+	mux.Handle("/buildinfo", c.BuildInfo())
+}
@@ -0,0 +1,62 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	hc := NewChecker("test")
+	hc.Add("database", func(ctx context.Context) error { return nil })
+	failing := errors.New("not connected")
+	var upstreamErr error
+	hc.Add("upstream", func(ctx context.Context) error { return upstreamErr })
+
+	mux := http.NewServeMux()
+	hc.Register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(path string) (int, Status) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var st Status
+		if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, st
+	}
+
+	if code, st := get("/readyz"); code != 200 || st.Status != "ok" || st.Checks["upstream"] != "ok" {
+		t.Fatalf("unexpected readiness: %d %+v", code, st)
+	}
+
+	upstreamErr = failing
+	if code, st := get("/readyz"); code != 503 || st.Checks["upstream"] != "not connected" || st.Checks["database"] != "ok" {
+		t.Fatalf("unexpected readiness: %d %+v", code, st)
+	}
+	// liveness doesn't depend on readiness checks
+	if code, st := get("/healthz"); code != 200 || st.Service != "test" {
+		t.Fatalf("unexpected liveness: %d %+v", code, st)
+	}
+
+	resp, err := http.Get(srv.URL + "/buildinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var bi BuildInfo
+	if err := json.NewDecoder(resp.Body).Decode(&bi); err != nil {
+		t.Fatal(err)
+	}
+	if bi.Service != "test" || bi.GoVersion == "" || bi.Version == "" {
+		t.Fatalf("unexpected build info: %+v", bi)
+	}
+}
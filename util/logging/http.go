@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type levelsResponse struct {
+	Default    string            `json:"default"`
+	Components map[string]string `json:"components"`
+}
+
+// LevelsHandler is an admin endpoint for log levels. GET returns the current levels. POST with "level" (and optionally "component") query parameters changes the level of one component, or the default level if no component is given, and returns the updated levels.
+//
+// The handler does no authentication of its own; mount it behind admin auth.
+func LevelsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			level, err := ParseLevel(r.URL.Query().Get("level"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if name := r.URL.Query().Get("component"); name != "" {
+				SetLevel(name, level)
+			} else {
+				SetDefaultLevel(level)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		def, levels := Levels()
+		resp := levelsResponse{
+			Default:    def.String(),
+			Components: make(map[string]string, len(levels)),
+		}
+		for name, level := range levels {
+			resp.Components[name] = level.String()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
@@ -0,0 +1,196 @@
+// Package logging configures log/slog loggers for indigo services.
+//
+// Packages get a logger for a named component with [Component]. All components write to a single output (sink), configured once at startup with [Setup]. Each component has its own level, which defaults to the global level and can be changed at runtime (see [SetLevel] and [LevelsHandler]). High-frequency messages, like per-event logs, can be rate-reduced with [Sampled].
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Config struct {
+	// "text" (default) or "json"
+	Format string
+	// Default level for all components
+	Level slog.Level
+	// Per-component overrides of Level
+	Levels map[string]slog.Level
+	// Defaults to stderr
+	Output io.Writer
+}
+
+type sinkState struct {
+	gen uint64
+	h   slog.Handler
+}
+
+var (
+	sink atomic.Pointer[sinkState]
+
+	lk           sync.Mutex
+	defaultLevel = slog.LevelInfo
+	components   = make(map[string]*slog.LevelVar)
+	// components with a level set explicitly, which don't follow the default level
+	explicit = make(map[string]bool)
+)
+
+func init() {
+	sink.Store(&sinkState{h: newSinkHandler("text", os.Stderr)})
+}
+
+func newSinkHandler(format string, w io.Writer) slog.Handler {
+	// levels are enforced per-component, so the sink lets everything through
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug - 4}
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// Setup configures the output and levels for all components, including loggers which were created before Setup was called.
+func Setup(cfg Config) error {
+	if cfg.Format != "" && cfg.Format != "text" && cfg.Format != "json" {
+		return fmt.Errorf("unknown log format: %s", cfg.Format)
+	}
+	w := cfg.Output
+	if w == nil {
+		w = os.Stderr
+	}
+	prev := sink.Load()
+	sink.Store(&sinkState{gen: prev.gen + 1, h: newSinkHandler(cfg.Format, w)})
+
+	SetDefaultLevel(cfg.Level)
+	for name, level := range cfg.Levels {
+		SetLevel(name, level)
+	}
+	return nil
+}
+
+// ParseLevels parses per-component levels in the form "bgs=debug,events=warn"
+func ParseLevels(s string) (map[string]slog.Level, error) {
+	out := make(map[string]slog.Level)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, lvl, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid component log level (expected name=level): %s", part)
+		}
+		level, err := ParseLevel(lvl)
+		if err != nil {
+			return nil, err
+		}
+		out[strings.TrimSpace(name)] = level
+	}
+	return out, nil
+}
+
+// ParseLevel parses a level name (eg, "debug" or "WARN")
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return level, fmt.Errorf("invalid log level: %s", s)
+	}
+	return level, nil
+}
+
+func componentLevel(name string) *slog.LevelVar {
+	lk.Lock()
+	defer lk.Unlock()
+	lv, ok := components[name]
+	if !ok {
+		lv = new(slog.LevelVar)
+		lv.Set(defaultLevel)
+		components[name] = lv
+	}
+	return lv
+}
+
+// Component returns a logger for the named component, with a "component" attribute.
+func Component(name string) *slog.Logger {
+	h := &componentHandler{level: componentLevel(name)}
+	return slog.New(h).With("component", name)
+}
+
+// SetLevel changes the level of a single component
+func SetLevel(name string, level slog.Level) {
+	lv := componentLevel(name)
+	lk.Lock()
+	defer lk.Unlock()
+	explicit[name] = true
+	lv.Set(level)
+}
+
+// SetDefaultLevel changes the level of all components which haven't had a level set explicitly
+func SetDefaultLevel(level slog.Level) {
+	lk.Lock()
+	defer lk.Unlock()
+	defaultLevel = level
+	for name, lv := range components {
+		if !explicit[name] {
+			lv.Set(level)
+		}
+	}
+}
+
+// Levels returns the default level, and the current level of every known component
+func Levels() (slog.Level, map[string]slog.Level) {
+	lk.Lock()
+	defer lk.Unlock()
+	out := make(map[string]slog.Level, len(components))
+	for name, lv := range components {
+		out[name] = lv.Level()
+	}
+	return defaultLevel, out
+}
+
+// componentHandler filters records by the component level, and writes them to the current sink. Attributes and groups are re-applied if the sink changes.
+type componentHandler struct {
+	level *slog.LevelVar
+	// WithAttrs and WithGroup calls, in order
+	ops   []func(slog.Handler) slog.Handler
+	cache atomic.Pointer[sinkState]
+}
+
+func (h *componentHandler) handler() slog.Handler {
+	s := sink.Load()
+	if c := h.cache.Load(); c != nil && c.gen == s.gen {
+		return c.h
+	}
+	out := s.h
+	for _, op := range h.ops {
+		out = op(out)
+	}
+	h.cache.Store(&sinkState{gen: s.gen, h: out})
+	return out
+}
+
+func (h *componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler().Handle(ctx, r)
+}
+
+func (h *componentHandler) with(op func(slog.Handler) slog.Handler) *componentHandler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &componentHandler{level: h.level, ops: append(ops, op)}
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
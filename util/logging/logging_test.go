@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestComponentLevels(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Setup(Config{Format: "json", Level: slog.LevelInfo, Output: &buf}); err != nil {
+		t.Fatal(err)
+	}
+
+	a := Component("test-a").With("k", "v")
+	b := Component("test-b")
+
+	a.Debug("hidden")
+	a.Info("shown")
+	SetLevel("test-a", slog.LevelDebug)
+	a.Debug("now shown")
+	b.Debug("still hidden")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected log output: %s", buf.String())
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["msg"] != "now shown" || rec["component"] != "test-a" || rec["k"] != "v" {
+		t.Fatalf("unexpected log record: %v", rec)
+	}
+
+	// changing the default doesn't override explicit levels
+	SetDefaultLevel(slog.LevelError)
+	def, levels := Levels()
+	if def != slog.LevelError || levels["test-a"] != slog.LevelDebug || levels["test-b"] != slog.LevelError {
+		t.Fatalf("unexpected levels: %v %v", def, levels)
+	}
+
+	// loggers created before Setup switch to the new sink
+	var buf2 bytes.Buffer
+	if err := Setup(Config{Format: "text", Level: slog.LevelInfo, Output: &buf2}); err != nil {
+		t.Fatal(err)
+	}
+	a.Info("moved")
+	if !strings.Contains(buf2.String(), "msg=moved") || !strings.Contains(buf2.String(), "k=v") {
+		t.Fatalf("unexpected log output: %s", buf2.String())
+	}
+}
+
+func TestSampled(t *testing.T) {
+	var buf bytes.Buffer
+	l := Sampled(slog.New(slog.NewTextHandler(&buf, nil)), 10)
+	for i := 0; i < 25; i++ {
+		l.Info("per-event")
+	}
+	l.Info("other")
+	if n := strings.Count(buf.String(), "per-event"); n != 3 {
+		t.Fatalf("expected 3 sampled records, got %d", n)
+	}
+	if !strings.Contains(buf.String(), "msg=other sampled=10") {
+		t.Fatalf("unexpected log output: %s", buf.String())
+	}
+}
+
+func TestLevelsHandler(t *testing.T) {
+	Component("test-http")
+	srv := httptest.NewServer(LevelsHandler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"?component=test-http&level=warn", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out levelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Components["test-http"] != "WARN" {
+		t.Fatalf("unexpected levels: %v", out)
+	}
+
+	resp2, err := http.Post(srv.URL+"?level=bogus", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp2.Body.Close()
+	if resp2.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected bad request, got %d", resp2.StatusCode)
+	}
+}
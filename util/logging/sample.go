@@ -0,0 +1,51 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// Sampled returns a logger which only writes the first of every n records with the same message, for high-frequency messages (like per-event logs). Written records get a "sampled" attribute with n, so readers can scale counts back up.
+func Sampled(l *slog.Logger, n uint64) *slog.Logger {
+	if n <= 1 {
+		return l
+	}
+	return slog.New(&samplingHandler{
+		next:   l.Handler(),
+		n:      n,
+		counts: &sync.Map{},
+	})
+}
+
+type samplingHandler struct {
+	next slog.Handler
+	n    uint64
+	// message -> *atomic.Uint64; shared by derived handlers
+	counts *sync.Map
+}
+
+func (h *samplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	v, ok := h.counts.Load(r.Message)
+	if !ok {
+		v, _ = h.counts.LoadOrStore(r.Message, new(atomic.Uint64))
+	}
+	if (v.(*atomic.Uint64).Add(1)-1)%h.n != 0 {
+		return nil
+	}
+	r.AddAttrs(slog.Uint64("sampled", h.n))
+	return h.next.Handle(ctx, r)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{next: h.next.WithAttrs(attrs), n: h.n, counts: h.counts}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{next: h.next.WithGroup(name), n: h.n, counts: h.counts}
+}
@@ -0,0 +1,421 @@
+// Package migrate applies explicit, versioned schema migrations to a database, so operators can see and control schema changes during upgrades instead of having them made implicitly at startup.
+//
+// Each component using a database (the relay, the carstore, the PDS) has its own numbered list of migrations, and the versions applied to a database are recorded in its schema_migrations table. Migrations are either Go functions or SQL files, usually embedded in the component's binary. SQL files are named <version>_<name>.up.sql and <version>_<name>.down.sql, and a file for a particular database can be given as <version>_<name>.up.postgres.sql (or .sqlite.sql), which is used instead of the generic file on that database.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/util/logging"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var log = logging.Component("migrate")
+
+// ErrPending is returned (wrapped) by Check when a database has migrations which haven't been applied
+var ErrPending = errors.New("database has pending migrations")
+
+// Migration is one step in a component's schema history
+type Migration struct {
+	Version int
+	Name    string
+	// Up applies the migration. It is run in a transaction with the recording of the new version, unless NoTransaction is set
+	Up func(tx *gorm.DB) error
+	// Down reverts the migration. Nil if it can't be reverted
+	Down func(tx *gorm.DB) error
+	// Run outside of a transaction, for statements which can't be run in one (eg, postgres' CREATE INDEX CONCURRENTLY)
+	NoTransaction bool
+}
+
+func (m *Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// SchemaMigration records a migration applied to the database
+type SchemaMigration struct {
+	Component string `gorm:"primaryKey"`
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrator applies a component's migrations to a database
+type Migrator struct {
+	db         *gorm.DB
+	component  string
+	migrations []*Migration
+}
+
+// New makes a Migrator for the given component's migrations, which needn't be in order but must have distinct, positive versions
+func New(db *gorm.DB, component string, migrations ...*Migration) (*Migrator, error) {
+	sorted := append([]*Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("%s: migration %q has invalid version %d", component, m.Name, m.Version)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("%s: migrations %s and %s have the same version", component, sorted[i-1], m)
+		}
+		if m.Up == nil {
+			return nil, fmt.Errorf("%s: migration %s has no up step", component, m)
+		}
+	}
+	return &Migrator{db: db, component: component, migrations: sorted}, nil
+}
+
+func (mg *Migrator) Component() string {
+	return mg.component
+}
+
+// Latest returns the version of the component's newest migration
+func (mg *Migrator) Latest() int {
+	if len(mg.migrations) == 0 {
+		return 0
+	}
+	return mg.migrations[len(mg.migrations)-1].Version
+}
+
+func (mg *Migrator) ensureTable(ctx context.Context) error {
+	if err := mg.db.WithContext(ctx).AutoMigrate(&SchemaMigration{}); err != nil {
+		return fmt.Errorf("creating migrations table: %w", err)
+	}
+	return nil
+}
+
+func (mg *Migrator) applied(db *gorm.DB) (map[int]*SchemaMigration, error) {
+	var rows []*SchemaMigration
+	if err := db.Where("component = ?", mg.component).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("listing applied migrations: %w", err)
+	}
+	out := make(map[int]*SchemaMigration, len(rows))
+	for _, r := range rows {
+		out[r.Version] = r
+	}
+	return out, nil
+}
+
+// MigrationStatus describes one migration, as known to this binary or recorded in the database
+type MigrationStatus struct {
+	Component string     `json:"component"`
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+	// Unknown is set for migrations recorded in the database which this binary doesn't know about, because they were applied by a newer version
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+func (s *MigrationStatus) Applied() bool {
+	return s.AppliedAt != nil
+}
+
+// Status lists every migration, in version order, with when each was applied
+func (mg *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := mg.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := mg.applied(mg.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var out []MigrationStatus
+	for _, m := range mg.migrations {
+		st := MigrationStatus{Component: mg.component, Version: m.Version, Name: m.Name}
+		if r, ok := applied[m.Version]; ok {
+			st.AppliedAt = &r.AppliedAt
+			delete(applied, m.Version)
+		}
+		out = append(out, st)
+	}
+	for _, r := range applied {
+		out = append(out, MigrationStatus{Component: mg.component, Version: r.Version, Name: r.Name, AppliedAt: &r.AppliedAt, Unknown: true})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Pending returns the migrations not yet applied, in the order they would be
+func (mg *Migrator) Pending(ctx context.Context) ([]*Migration, error) {
+	if err := mg.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := mg.applied(mg.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var out []*Migration
+	for _, m := range mg.migrations {
+		if _, ok := applied[m.Version]; !ok {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+// Check returns an error wrapping ErrPending if any migrations haven't been applied, for daemons which leave applying them to the operator
+func (mg *Migrator) Check(ctx context.Context) error {
+	pending, err := mg.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	names := make([]string, len(pending))
+	for i, m := range pending {
+		names[i] = m.String()
+	}
+	return fmt.Errorf("%s: %w: %s", mg.component, ErrPending, strings.Join(names, ", "))
+}
+
+// Up applies pending migrations up to and including the target version (or all of them, if target is 0), returning those applied
+func (mg *Migrator) Up(ctx context.Context, target int) ([]*Migration, error) {
+	pending, err := mg.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var done []*Migration
+	for _, m := range pending {
+		if target > 0 && m.Version > target {
+			break
+		}
+		log.Info("applying migration", "db", mg.component, "migration", m.String())
+		start := time.Now()
+		ran, err := mg.step(ctx, m, true)
+		if err != nil {
+			return done, fmt.Errorf("%s: applying migration %s: %w", mg.component, m, err)
+		}
+		if ran {
+			log.Info("applied migration", "db", mg.component, "migration", m.String(), "duration", time.Since(start))
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// Down reverts applied migrations newer than the target version, newest first, returning those reverted
+func (mg *Migrator) Down(ctx context.Context, target int) ([]*Migration, error) {
+	status, err := mg.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration, len(mg.migrations))
+	for _, m := range mg.migrations {
+		byVersion[m.Version] = m
+	}
+	var done []*Migration
+	for i := len(status) - 1; i >= 0; i-- {
+		st := status[i]
+		if st.Version <= target {
+			break
+		}
+		if !st.Applied() {
+			continue
+		}
+		m := byVersion[st.Version]
+		if st.Unknown {
+			return done, fmt.Errorf("%s: can't revert migration %d (%s), which is unknown to this version", mg.component, st.Version, st.Name)
+		}
+		if m.Down == nil {
+			return done, fmt.Errorf("%s: migration %s can't be reverted", mg.component, m)
+		}
+		log.Info("reverting migration", "db", mg.component, "migration", m.String())
+		ran, err := mg.step(ctx, m, false)
+		if err != nil {
+			return done, fmt.Errorf("%s: reverting migration %s: %w", mg.component, m, err)
+		}
+		if ran {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// step applies or reverts a single migration, reporting whether it did anything. The recorded state is checked again under a lock first (on postgres), so concurrently starting instances don't both apply a migration
+func (mg *Migrator) step(ctx context.Context, m *Migration, up bool) (bool, error) {
+	ran := false
+	run := func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" && !m.NoTransaction {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "schema_migrations/"+mg.component).Error; err != nil {
+				return fmt.Errorf("locking migrations: %w", err)
+			}
+		}
+		var count int64
+		if err := tx.Model(&SchemaMigration{}).Where("component = ? AND version = ?", mg.component, m.Version).Count(&count).Error; err != nil {
+			return err
+		}
+		if (count > 0) == up {
+			// someone else got there first
+			return nil
+		}
+		ran = true
+
+		fn := m.Up
+		if !up {
+			fn = m.Down
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+
+		if up {
+			return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&SchemaMigration{
+				Component: mg.component,
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		}
+		return tx.Where("component = ? AND version = ?", mg.component, m.Version).Delete(&SchemaMigration{}).Error
+	}
+
+	if m.NoTransaction {
+		// nothing stops concurrent runs of these, so they are best applied with the migrate command rather than at startup
+		return ran, run(mg.db.WithContext(ctx))
+	}
+	return ran, mg.db.WithContext(ctx).Transaction(run)
+}
+
+var sqlFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)(?:\.(postgres|sqlite))?\.sql$`)
+
+// noTransactionMarker, as the first line of an SQL migration, runs it outside a transaction
+const noTransactionMarker = "-- migrate:no-transaction"
+
+type sqlMigration struct {
+	version int
+	name    string
+	// by direction, then by dialect ("" for the generic file)
+	files map[string]map[string]string
+}
+
+// LoadSQL reads migrations from the SQL files in a directory of fsys (usually an embed.FS). Other files in the directory are ignored
+func LoadSQL(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*sqlMigration)
+	for _, ent := range entries {
+		if ent.IsDir() || !strings.HasSuffix(ent.Name(), ".sql") {
+			continue
+		}
+		parts := sqlFileName.FindStringSubmatch(ent.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("invalid migration file name %q (expected <version>_<name>.<up|down>[.<postgres|sqlite>].sql)", ent.Name())
+		}
+		version, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %q: %w", ent.Name(), err)
+		}
+		sm := byVersion[version]
+		if sm == nil {
+			sm = &sqlMigration{version: version, name: parts[2], files: make(map[string]map[string]string)}
+			byVersion[version] = sm
+		}
+		if sm.name != parts[2] {
+			return nil, fmt.Errorf("migration files for version %d have different names (%q and %q)", version, sm.name, parts[2])
+		}
+		body, err := fs.ReadFile(fsys, path.Join(dir, ent.Name()))
+		if err != nil {
+			return nil, err
+		}
+		direction, dialect := parts[3], parts[4]
+		if sm.files[direction] == nil {
+			sm.files[direction] = make(map[string]string)
+		}
+		sm.files[direction][dialect] = string(body)
+	}
+
+	var out []*Migration
+	for _, sm := range byVersion {
+		if sm.files["up"] == nil {
+			return nil, fmt.Errorf("migration %04d_%s has no up file", sm.version, sm.name)
+		}
+		m := &Migration{
+			Version: sm.version,
+			Name:    sm.name,
+			Up:      sqlStep(sm.files["up"]),
+		}
+		if sm.files["down"] != nil {
+			m.Down = sqlStep(sm.files["down"])
+		}
+		for _, body := range sm.files["up"] {
+			if strings.HasPrefix(body, noTransactionMarker) {
+				m.NoTransaction = true
+			}
+		}
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// sqlStep runs the file for the database's dialect, or the generic file if there isn't one
+func sqlStep(files map[string]string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		body, ok := files[tx.Dialector.Name()]
+		if !ok {
+			body, ok = files[""]
+		}
+		if !ok {
+			return fmt.Errorf("no migration file for %s databases", tx.Dialector.Name())
+		}
+		for _, stmt := range SplitStatements(body) {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("%w (in %q)", err, stmt)
+			}
+		}
+		return nil
+	}
+}
+
+// SplitStatements splits an SQL file into statements, which are separated by semicolons at the end of a line. Lines starting with "--" are comments
+func SplitStatements(body string) []string {
+	var out []string
+	var cur strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		cur.WriteString(line)
+		cur.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			out = append(out, strings.TrimSpace(cur.String()))
+			cur.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(cur.String()); rest != "" {
+		out = append(out, rest)
+	}
+	return out
+}
+
+// Models returns a migration which creates the tables for the given models with GORM's auto-migration, and drops them when reverted. It is meant for a component's first migration, creating the schema it had before migrations were versioned: it also brings existing databases from a time it was auto-migrated up to date
+func Models(version int, name string, models ...any) *Migration {
+	return &Migration{
+		Version: version,
+		Name:    name,
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(models...)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(models...)
+		},
+	}
+}
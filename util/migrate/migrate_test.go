@@ -0,0 +1,190 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.sqlite")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+type widget struct {
+	ID   uint `gorm:"primarykey"`
+	Name string
+}
+
+var testFiles = fstest.MapFS{
+	"migrations/0002_widget_color.up.sql":        {Data: []byte("-- colors\nALTER TABLE widgets ADD COLUMN color TEXT;\nUPDATE widgets\n  SET color = 'red';\n")},
+	"migrations/0002_widget_color.down.sql":      {Data: []byte("ALTER TABLE widgets DROP COLUMN color;\n")},
+	"migrations/0003_widget_index.up.sql":        {Data: []byte("CREATE INDEX idx_widgets_name ON widgets (name, id);\n")},
+	"migrations/0003_widget_index.up.sqlite.sql": {Data: []byte("CREATE INDEX idx_widgets_name ON widgets (name);\n")},
+	"migrations/0003_widget_index.down.sql":      {Data: []byte("DROP INDEX idx_widgets_name;\n")},
+	"migrations/README.md":                       {Data: []byte("ignored")},
+}
+
+func testMigrator(t *testing.T, db *gorm.DB) *Migrator {
+	sqlMigrations, err := LoadSQL(testFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mg, err := New(db, "test", append(sqlMigrations, Models(1, "baseline", &widget{}))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mg
+}
+
+func TestUpDown(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	mg := testMigrator(t, db)
+	if mg.Latest() != 3 {
+		t.Fatalf("expected latest version 3, got %d", mg.Latest())
+	}
+
+	if err := mg.Check(ctx); !errors.Is(err, ErrPending) {
+		t.Fatalf("expected pending migrations, got %v", err)
+	}
+
+	done, err := mg.Up(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 1 || done[0].Name != "baseline" {
+		t.Fatalf("unexpected migrations applied: %v", done)
+	}
+	if err := db.Create(&widget{Name: "one"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	done, err = mg.Up(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 2 {
+		t.Fatalf("expected two more migrations, got %v", done)
+	}
+	if err := mg.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var color string
+	if err := db.Raw("SELECT color FROM widgets WHERE name = 'one'").Scan(&color).Error; err != nil {
+		t.Fatal(err)
+	}
+	if color != "red" {
+		t.Fatalf("expected data migration, got color %q", color)
+	}
+	// the sqlite index was used instead of the generic one
+	if !db.Migrator().HasIndex("widgets", "idx_widgets_name") {
+		t.Fatal("expected index to be created")
+	}
+
+	// applying again does nothing
+	done, err = mg.Up(ctx, 0)
+	if err != nil || len(done) != 0 {
+		t.Fatalf("expected nothing to apply, got %v, %v", done, err)
+	}
+
+	done, err = mg.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 2 || done[0].Version != 3 || done[1].Version != 2 {
+		t.Fatalf("unexpected migrations reverted: %v", done)
+	}
+	if db.Migrator().HasColumn("widgets", "color") {
+		t.Fatal("expected column to be dropped")
+	}
+
+	status, err := mg.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 3 || !status[0].Applied() || status[1].Applied() || status[2].Applied() {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	sqlMigrations, err := LoadSQL(testFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	irreversible := &Migration{Version: 4, Name: "irreversible", Up: func(tx *gorm.DB) error { return nil }}
+	mg, err = New(db, "test", append(sqlMigrations, Models(1, "baseline", &widget{}), irreversible)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mg.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mg.Down(ctx, 0); err == nil {
+		t.Fatal("expected irreversible migration to fail to revert")
+	}
+}
+
+func TestUnknownMigrations(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	mg := testMigrator(t, db)
+	if _, err := mg.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// an older binary, which only knows the baseline
+	old, err := New(db, "test", Models(1, "baseline", &widget{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	status, err := old.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 3 || status[0].Unknown || !status[1].Unknown || !status[2].Unknown {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if _, err := old.Down(ctx, 0); err == nil {
+		t.Fatal("expected unknown migrations to fail to revert")
+	}
+
+	// components are tracked separately
+	other, err := New(db, "other", Models(1, "baseline", &widget{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Check(ctx); !errors.Is(err, ErrPending) {
+		t.Fatalf("expected pending migrations, got %v", err)
+	}
+}
+
+func TestInvalidMigrations(t *testing.T) {
+	db := testDB(t)
+	if _, err := New(db, "test", Models(1, "a"), Models(1, "b")); err == nil {
+		t.Fatal("expected duplicate versions to be rejected")
+	}
+	if _, err := LoadSQL(fstest.MapFS{"m/1_Bad-Name.up.sql": {}}, "m"); err == nil {
+		t.Fatal("expected invalid file name to be rejected")
+	}
+	if _, err := LoadSQL(fstest.MapFS{"m/1_only_down.down.sql": {}}, "m"); err == nil {
+		t.Fatal("expected missing up file to be rejected")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	stmts := SplitStatements("-- comment\nCREATE TABLE a (\n  id INT\n);\n\nINSERT INTO a VALUES (1); \nSELECT 1")
+	if len(stmts) != 3 || stmts[0] != "CREATE TABLE a (\n  id INT\n);" || stmts[1] != "INSERT INTO a VALUES (1);" || stmts[2] != "SELECT 1" {
+		t.Fatalf("unexpected statements: %q", stmts)
+	}
+}
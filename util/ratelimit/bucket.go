@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// Inf is a token bucket rate which allows every event
+const Inf = float64(rate.Inf)
+
+// TokenBucket limits events to a sustained rate per second, with a burst
+type TokenBucket struct {
+	lim *rate.Limiter
+}
+
+var _ AdjustableLimiter = (*TokenBucket)(nil)
+
+// NewTokenBucket creates a token bucket which allows perSecond events per second (Inf for no limit), and bursts of up to burst events (at least one)
+func NewTokenBucket(perSecond float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		lim: rate.NewLimiter(rate.Limit(perSecond), max(1, burst)),
+	}
+}
+
+func (tb *TokenBucket) Allow() bool {
+	return tb.lim.Allow()
+}
+
+func (tb *TokenBucket) Wait(ctx context.Context) error {
+	return tb.lim.Wait(ctx)
+}
+
+// Rate returns the current rate, in events per second
+func (tb *TokenBucket) Rate() float64 {
+	return float64(tb.lim.Limit())
+}
+
+func (tb *TokenBucket) SetRate(perSecond float64) {
+	tb.lim.SetLimit(rate.Limit(perSecond))
+}
+
+func (tb *TokenBucket) Burst() int {
+	return tb.lim.Burst()
+}
+
+func (tb *TokenBucket) SetBurst(burst int) {
+	tb.lim.SetBurst(max(1, burst))
+}
+
+func (tb *TokenBucket) Limits() Limits {
+	return Limits{
+		Kind:  KindTokenBucket,
+		Limit: tb.Rate(),
+		Burst: tb.Burst(),
+	}
+}
+
+func (tb *TokenBucket) SetLimits(limit float64, burst int) {
+	tb.SetRate(limit)
+	if burst > 0 {
+		tb.SetBurst(burst)
+	}
+}
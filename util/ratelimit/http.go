@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+type limitsResponse struct {
+	Kind   string  `json:"kind"`
+	Limit  float64 `json:"limit"`
+	Burst  int     `json:"burst,omitempty"`
+	Window string  `json:"window,omitempty"`
+	Keys   *int    `json:"keys,omitempty"`
+}
+
+// Handler is an admin endpoint for registered limiters. GET returns the current limits of every limiter. POST with "name" and "limit" (and optionally "burst") query parameters changes the limits of one limiter, and returns the updated limits. Limits are events per second for token buckets, or per window for sliding windows.
+//
+// The handler does no authentication of its own; mount it behind admin auth.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			q := r.URL.Query()
+			l, ok := Lookup(q.Get("name"))
+			if !ok {
+				http.Error(w, "no such rate limiter: "+q.Get("name"), http.StatusNotFound)
+				return
+			}
+			limit, err := strconv.ParseFloat(q.Get("limit"), 64)
+			if err != nil || limit < 0 {
+				http.Error(w, "invalid limit: "+q.Get("limit"), http.StatusBadRequest)
+				return
+			}
+			var burst int
+			if s := q.Get("burst"); s != "" {
+				burst, err = strconv.Atoi(s)
+				if err != nil || burst < 0 {
+					http.Error(w, "invalid burst: "+s, http.StatusBadRequest)
+					return
+				}
+			}
+			l.SetLimits(limit, burst)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		all := All()
+		resp := make(map[string]limitsResponse, len(all))
+		for name, lims := range all {
+			lr := limitsResponse{
+				Kind:  lims.Kind,
+				Limit: lims.Limit,
+				Burst: lims.Burst,
+			}
+			if lims.Window > 0 {
+				lr.Window = lims.Window.String()
+			}
+			if lims.Keyed {
+				keys := lims.Keys
+				lr.Keys = &keys
+			}
+			resp[name] = lr
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
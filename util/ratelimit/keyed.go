@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Keyed holds a limiter per key, created on first use with the current limits. Limiters which haven't been used for the expiry period are dropped, so the expiry should be at least as long as a limiter takes to recover (eg, the window of a sliding window).
+//
+// Changing the limits of a Keyed changes them for every key.
+type Keyed[K comparable, L AdjustableLimiter] struct {
+	lk      sync.Mutex
+	limit   float64
+	burst   int
+	expiry  time.Duration
+	newFunc func(limit float64, burst int) L
+
+	entries   map[K]*keyedEntry[L]
+	lastSweep time.Time
+	kind      Limits
+}
+
+type keyedEntry[L any] struct {
+	lim      L
+	lastUsed time.Time
+}
+
+var _ Adjustable = (*Keyed[string, *TokenBucket])(nil)
+
+// NewKeyed creates a Keyed which creates limiters with newFunc
+func NewKeyed[K comparable, L AdjustableLimiter](limit float64, burst int, expiry time.Duration, newFunc func(limit float64, burst int) L) *Keyed[K, L] {
+	return &Keyed[K, L]{
+		limit:     limit,
+		burst:     burst,
+		expiry:    expiry,
+		newFunc:   newFunc,
+		entries:   make(map[K]*keyedEntry[L]),
+		lastSweep: time.Now(),
+		kind:      newFunc(limit, burst).Limits(),
+	}
+}
+
+// NewKeyedTokenBuckets creates a Keyed of token buckets (see NewTokenBucket)
+func NewKeyedTokenBuckets[K comparable](perSecond float64, burst int, expiry time.Duration) *Keyed[K, *TokenBucket] {
+	return NewKeyed[K](perSecond, burst, expiry, NewTokenBucket)
+}
+
+// NewKeyedSlidingWindows creates a Keyed of sliding windows (see NewSlidingWindow), which expire after their window
+func NewKeyedSlidingWindows[K comparable](window time.Duration, limit int64) *Keyed[K, *SlidingWindow] {
+	return NewKeyed[K](float64(limit), 0, window, func(limit float64, burst int) *SlidingWindow {
+		return NewSlidingWindow(window, int64(limit))
+	})
+}
+
+// Get returns the limiter for a key, creating it if need be
+func (k *Keyed[K, L]) Get(key K) L {
+	k.lk.Lock()
+	defer k.lk.Unlock()
+
+	now := time.Now()
+	if now.Sub(k.lastSweep) >= k.expiry {
+		for key, ent := range k.entries {
+			if now.Sub(ent.lastUsed) >= k.expiry {
+				delete(k.entries, key)
+			}
+		}
+		k.lastSweep = now
+	}
+
+	ent, ok := k.entries[key]
+	if !ok {
+		ent = &keyedEntry[L]{lim: k.newFunc(k.limit, k.burst)}
+		k.entries[key] = ent
+	}
+	ent.lastUsed = now
+	return ent.lim
+}
+
+// Allow reports whether an event for key may happen now, counting it if so
+func (k *Keyed[K, L]) Allow(key K) bool {
+	return k.Get(key).Allow()
+}
+
+// Wait blocks until an event for key may happen, counting it, or the context is done
+func (k *Keyed[K, L]) Wait(ctx context.Context, key K) error {
+	return k.Get(key).Wait(ctx)
+}
+
+// Len returns the number of keys with a limiter
+func (k *Keyed[K, L]) Len() int {
+	k.lk.Lock()
+	defer k.lk.Unlock()
+	return len(k.entries)
+}
+
+// Limits returns the limits given to each key's limiter
+func (k *Keyed[K, L]) Limits() Limits {
+	k.lk.Lock()
+	defer k.lk.Unlock()
+	lims := k.kind
+	lims.Limit = k.limit
+	if k.burst > 0 {
+		lims.Burst = k.burst
+	}
+	lims.Keyed = true
+	lims.Keys = len(k.entries)
+	return lims
+}
+
+// SetLimits changes the limits for new keys, and every existing key's limiter
+func (k *Keyed[K, L]) SetLimits(limit float64, burst int) {
+	k.lk.Lock()
+	defer k.lk.Unlock()
+	k.limit = limit
+	if burst > 0 {
+		k.burst = burst
+	}
+	for _, ent := range k.entries {
+		ent.lim.SetLimits(limit, burst)
+	}
+}
@@ -0,0 +1,50 @@
+// Package ratelimit has the rate limiters shared by indigo services: token buckets ([TokenBucket]), for limiting a sustained rate with some burst, and sliding windows ([SlidingWindow]), for limiting a count over a longer period (eg, events per day). [Keyed] holds a limiter per key (eg, per host or client IP), dropping limiters which haven't been used in a while.
+//
+// Limiters can be registered by name ([Register]), so their limits can be changed at runtime, eg through an admin endpoint ([Handler]).
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limiter is a rate limiter which is waited on (or checked) before each event
+type Limiter interface {
+	// Allow reports whether an event may happen now, counting it if so
+	Allow() bool
+	// Wait blocks until an event may happen, counting it, or the context is done
+	Wait(ctx context.Context) error
+}
+
+const (
+	KindTokenBucket   = "token_bucket"
+	KindSlidingWindow = "sliding_window"
+)
+
+// Limits describes the current limits of a limiter
+type Limits struct {
+	// KindTokenBucket or KindSlidingWindow
+	Kind string
+	// Events per second for token buckets, or per Window for sliding windows
+	Limit float64
+	// Events allowed in a burst over the rate, for token buckets
+	Burst int
+	// For sliding windows
+	Window time.Duration
+	// Whether this is a Keyed limiter, and its number of keys
+	Keyed bool
+	Keys  int
+}
+
+// Adjustable is a limiter whose limits can be changed while it is in use
+type Adjustable interface {
+	Limits() Limits
+	// SetLimits changes the limit, and the burst if it is non-zero (and the limiter has one)
+	SetLimits(limit float64, burst int)
+}
+
+// AdjustableLimiter is a Limiter which is also Adjustable, as TokenBucket and SlidingWindow are
+type AdjustableLimiter interface {
+	Limiter
+	Adjustable
+}
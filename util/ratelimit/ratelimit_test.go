@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	sw := NewSlidingWindow(time.Minute, 10)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 10; i++ {
+		if d := sw.reserve(start.Add(time.Duration(i) * time.Second)); d != 0 {
+			t.Fatalf("event %d should be allowed, got delay %s", i, d)
+		}
+	}
+	// full until the next window
+	if d := sw.reserve(start.Add(30 * time.Second)); d != 30*time.Second {
+		t.Fatalf("expected to wait for the next window, got %s", d)
+	}
+
+	// a quarter of the way in, three quarters of the previous window's events still count
+	for i := 0; i < 2; i++ {
+		if d := sw.reserve(start.Add(75 * time.Second)); d != 0 {
+			t.Fatalf("event %d should be allowed, got delay %s", i, d)
+		}
+	}
+	if d := sw.reserve(start.Add(75 * time.Second)); d < 2900*time.Millisecond || d > 3100*time.Millisecond {
+		t.Fatalf("expected to wait until more of the previous window slid out, got %s", d)
+	}
+	if d := sw.reserve(start.Add(78 * time.Second)); d != 0 {
+		t.Fatalf("expected event to be allowed, got delay %s", d)
+	}
+
+	// an idle window forgets everything
+	if d := sw.reserve(start.Add(10 * time.Minute)); d != 0 || sw.prev != 0 || sw.cur != 1 {
+		t.Fatalf("expected a fresh window, got delay %s (prev %d, cur %d)", d, sw.prev, sw.cur)
+	}
+
+	sw.SetLimits(1, 0)
+	if sw.Allow() && sw.Allow() {
+		t.Fatal("expected lowered limit to apply")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := sw.Wait(ctx); err == nil {
+		t.Fatal("expected wait to time out")
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	tb := NewTokenBucket(1, 3)
+	for i := 0; i < 3; i++ {
+		if !tb.Allow() {
+			t.Fatalf("event %d of the burst should be allowed", i)
+		}
+	}
+	if tb.Allow() {
+		t.Fatal("expected bucket to be empty")
+	}
+
+	tb.SetLimits(Inf, 0)
+	if !tb.Allow() || tb.Burst() != 3 {
+		t.Fatalf("unexpected limits after update: %+v", tb.Limits())
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	k := NewKeyedTokenBuckets[string](1, 1, 50*time.Millisecond)
+	if !k.Allow("a") || k.Allow("a") || !k.Allow("b") {
+		t.Fatal("expected separate limits per key")
+	}
+
+	k.SetLimits(Inf, 2)
+	if !k.Allow("a") {
+		t.Fatal("expected existing limiter to be updated")
+	}
+	if lims := k.Limits(); lims.Kind != KindTokenBucket || lims.Limit != Inf || lims.Burst != 2 || !lims.Keyed || lims.Keys != 2 {
+		t.Fatalf("unexpected limits: %+v", lims)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	k.Get("c")
+	if n := k.Len(); n != 1 {
+		t.Fatalf("expected idle limiters to expire, have %d", n)
+	}
+	if lims := NewKeyedSlidingWindows[int](time.Hour, 5).Limits(); lims.Kind != KindSlidingWindow || lims.Window != time.Hour || lims.Limit != 5 {
+		t.Fatalf("unexpected limits: %+v", lims)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	tb := NewTokenBucket(5, 1)
+	Register("test.bucket", tb)
+	defer Unregister("test.bucket", tb)
+
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"?name=test.bucket&limit=20&burst=4", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out map[string]limitsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if lr := out["test.bucket"]; lr.Kind != KindTokenBucket || lr.Limit != 20 || lr.Burst != 4 {
+		t.Fatalf("unexpected limits: %+v", out)
+	}
+	if tb.Rate() != 20 || tb.Burst() != 4 {
+		t.Fatalf("limiter not updated: %+v", tb.Limits())
+	}
+
+	for _, q := range []string{"?name=bogus&limit=1", "?name=test.bucket&limit=lots"} {
+		resp, err := http.Post(srv.URL+q, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Fatalf("expected error for %s", q)
+		}
+	}
+}
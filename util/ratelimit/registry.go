@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"sync"
+)
+
+var (
+	registryLk sync.Mutex
+	registry   = make(map[string]Adjustable)
+)
+
+// Register makes a limiter adjustable by name (see Handler), replacing any limiter already registered with the name. Names are dotted, starting with the service or package, eg "bgs.handle_revalidation".
+func Register(name string, l Adjustable) {
+	registryLk.Lock()
+	defer registryLk.Unlock()
+	registry[name] = l
+}
+
+// Unregister removes a registered limiter, if l is still the one registered with the name
+func Unregister(name string, l Adjustable) {
+	registryLk.Lock()
+	defer registryLk.Unlock()
+	if registry[name] == l {
+		delete(registry, name)
+	}
+}
+
+// Lookup returns the limiter registered with a name
+func Lookup(name string) (Adjustable, bool) {
+	registryLk.Lock()
+	defer registryLk.Unlock()
+	l, ok := registry[name]
+	return l, ok
+}
+
+// All returns the current limits of every registered limiter
+func All() map[string]Limits {
+	registryLk.Lock()
+	lims := make(map[string]Adjustable, len(registry))
+	for name, l := range registry {
+		lims[name] = l
+	}
+	registryLk.Unlock()
+
+	out := make(map[string]Limits, len(lims))
+	for name, l := range lims {
+		out[name] = l.Limits()
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SlidingWindow limits the number of events in any window of time (eg, per hour or per day). The count is approximated from the fixed windows it overlaps: all of the events in the current window, and the previous window's weighted by how much of it still overlaps.
+type SlidingWindow struct {
+	lk    sync.Mutex
+	size  time.Duration
+	limit int64
+
+	// start of the current fixed window
+	start time.Time
+	cur   int64
+	prev  int64
+}
+
+var _ AdjustableLimiter = (*SlidingWindow)(nil)
+
+// NewSlidingWindow creates a limiter which allows limit events per window
+func NewSlidingWindow(window time.Duration, limit int64) *SlidingWindow {
+	return &SlidingWindow{
+		size:  window,
+		limit: limit,
+	}
+}
+
+func (sw *SlidingWindow) Window() time.Duration {
+	return sw.size
+}
+
+func (sw *SlidingWindow) Limit() int64 {
+	sw.lk.Lock()
+	defer sw.lk.Unlock()
+	return sw.limit
+}
+
+func (sw *SlidingWindow) SetLimit(limit int64) {
+	sw.lk.Lock()
+	defer sw.lk.Unlock()
+	sw.limit = limit
+}
+
+func (sw *SlidingWindow) Limits() Limits {
+	return Limits{
+		Kind:   KindSlidingWindow,
+		Limit:  float64(sw.Limit()),
+		Window: sw.size,
+	}
+}
+
+// SetLimits sets the limit; sliding windows have no burst
+func (sw *SlidingWindow) SetLimits(limit float64, burst int) {
+	sw.SetLimit(int64(limit))
+}
+
+func (sw *SlidingWindow) Allow() bool {
+	sw.lk.Lock()
+	defer sw.lk.Unlock()
+	return sw.reserve(time.Now()) == 0
+}
+
+func (sw *SlidingWindow) Wait(ctx context.Context) error {
+	for {
+		sw.lk.Lock()
+		d := sw.reserve(time.Now())
+		sw.lk.Unlock()
+		if d == 0 {
+			return nil
+		}
+
+		// other waiters may get there first, so check again after waiting
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// reserve counts an event and returns zero if it is allowed now, or returns how long until it might be
+// must only be called while holding sw.lk
+func (sw *SlidingWindow) reserve(now time.Time) time.Duration {
+	start := now.Truncate(sw.size)
+	switch d := start.Sub(sw.start); {
+	case d == 0:
+	case d == sw.size:
+		sw.prev, sw.cur = sw.cur, 0
+		sw.start = start
+	default:
+		sw.prev, sw.cur = 0, 0
+		sw.start = start
+	}
+
+	elapsed := now.Sub(sw.start)
+	weight := float64(sw.size-elapsed) / float64(sw.size)
+	if float64(sw.prev)*weight+float64(sw.cur)+1 <= float64(sw.limit) {
+		sw.cur++
+		return 0
+	}
+
+	if sw.cur+1 > sw.limit {
+		return sw.size - elapsed
+	}
+
+	// the point at which enough of the previous window has slid out
+	free := float64(sw.limit-sw.cur-1) / float64(sw.prev)
+	need := time.Duration(float64(sw.size)*(1-free)) - elapsed
+	return max(need, time.Millisecond)
+}
@@ -0,0 +1,127 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrS3ObjectNotFound is returned when an S3 object doesn't exist
+var ErrS3ObjectNotFound = errors.New("S3 object not found")
+
+// S3Client reads and writes objects in an S3 (or S3-compatible, eg minio, or GCS in interoperability mode) bucket. If credentials are not set, requests are unsigned, which works for public buckets.
+type S3Client struct {
+	// Base URL of the S3 API, eg "https://s3.us-east-1.amazonaws.com". Requests use path-style addressing
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Optional prefix for all object keys, eg "mirror/2024-06-01"
+	Prefix      string
+	Credentials AWSCredentials
+	HTTPClient  *http.Client
+}
+
+// S3ClientFromEnv configures an S3Client for a bucket from the standard AWS_REGION, AWS_ENDPOINT_URL, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment variables. timeout applies to each whole request, including reading the response body
+func S3ClientFromEnv(bucket, prefix string, timeout time.Duration) S3Client {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	return S3Client{
+		Endpoint: endpoint,
+		Region:   region,
+		Bucket:   bucket,
+		Prefix:   prefix,
+		Credentials: AWSCredentials{
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		},
+		HTTPClient: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// sends a request for an object, by key under the prefix
+func (c *S3Client) do(ctx context.Context, method, key string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	if prefix := strings.Trim(c.Prefix, "/"); prefix != "" {
+		key = prefix + "/" + key
+	}
+	path := "/" + S3URIEncode(c.Bucket, false) + "/" + S3URIEncode(key, true)
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.Endpoint, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Credentials.AccessKey != "" && c.Credentials.SecretKey != "" {
+		SignS3Request(req, path, c.Region, c.Credentials, time.Now().UTC())
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// PutObject uploads an object of the given size. contentType is optional
+func (c *S3Client) PutObject(ctx context.Context, key string, size int64, r io.Reader, contentType string) error {
+	resp, err := c.do(ctx, "PUT", key, r, size, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// GetObject fetches an object's contents, returning ErrS3ObjectNotFound if it doesn't exist. The caller must close the returned reader
+func (c *S3Client) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, "GET", key, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrS3ObjectNotFound
+	default:
+		resp.Body.Close()
+		return nil, errors.New(resp.Status)
+	}
+}
+
+// DeleteObject removes an object. Deleting an object which doesn't exist is not an error
+func (c *S3Client) DeleteObject(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, "DELETE", key, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return errors.New(resp.Status)
+	}
+}
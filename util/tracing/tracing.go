@@ -7,6 +7,7 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
@@ -21,10 +22,16 @@ func InstallExportPipeline(ctx context.Context, serviceName string, sampleRatio
 
 	tracerProvider := newTraceProvider(exporter, serviceName, sampleRatio)
 	otel.SetTracerProvider(tracerProvider)
+	InstallPropagator()
 
 	return tracerProvider.Shutdown, nil
 }
 
+// InstallPropagator sets the global propagator to W3C trace context and baggage, so traces are carried across outbound xrpc calls and picked up from incoming requests.
+func InstallPropagator() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
 func newTraceProvider(exp sdktrace.SpanExporter, serviceName string, sampleRatio float64) *sdktrace.TracerProvider {
 	// Ensure default SDK resources and the required service name are set.
 	r, err := resource.Merge(
@@ -0,0 +1,79 @@
+package xrpc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+)
+
+func TestCreateServiceAuthToken(t *testing.T) {
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := CreateServiceAuthToken(priv, "did:plc:iss", "did:plc:aud", "tools.ozone.moderation.emitEvent", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected three JWT parts, got %d", len(parts))
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.HashAndVerify([]byte(parts[0]+"."+parts[1]), sig); err != nil {
+		t.Fatalf("signature did not verify: %v", err)
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["iss"] != "did:plc:iss" || claims["aud"] != "did:plc:aud" || claims["lxm"] != "tools.ozone.moderation.emitEvent" {
+		t.Fatalf("unexpected claims: %v", claims)
+	}
+}
+
+func TestServiceAuthTransport(t *testing.T) {
+	priv, err := crypto.GeneratePrivateKeyP256()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	c := Client{
+		Client: &http.Client{
+			Transport: &ServiceAuthTransport{Key: priv, Issuer: "did:plc:iss", Audience: "did:plc:aud"},
+		},
+		Host: srv.URL,
+	}
+	if err := c.Do(context.Background(), Query, "", "com.example.test", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(gotAuth, "Bearer ") {
+		t.Fatalf("expected bearer auth header, got: %s", gotAuth)
+	}
+}
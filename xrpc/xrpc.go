@@ -14,7 +14,13 @@ import (
 	"time"
 
 	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/util/ratelimit"
 	"github.com/carlmjohnson/versioninfo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Client struct {
@@ -25,6 +31,8 @@ type Client struct {
 	Host       string
 	UserAgent  *string
 	Headers    map[string]string
+	// RateLimiter, if set, is waited on before each request
+	RateLimiter ratelimit.Limiter
 }
 
 func (c *Client) getClient() *http.Client {
@@ -133,7 +141,22 @@ func makeParams(p map[string]any) string {
 	return params.Encode()
 }
 
-func (c *Client) Do(ctx context.Context, kind XRPCRequestType, inpenc string, method string, params map[string]interface{}, bodyobj interface{}, out interface{}) error {
+func (c *Client) Do(ctx context.Context, kind XRPCRequestType, inpenc string, method string, params map[string]interface{}, bodyobj interface{}, out interface{}) (err error) {
+	ctx, span := otel.Tracer("xrpc").Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("host", c.Host)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	if c.RateLimiter != nil {
+		if err := c.RateLimiter.Wait(ctx); err != nil {
+			return fmt.Errorf("waiting for rate limiter: %w", err)
+		}
+	}
+
 	var body io.Reader
 	if bodyobj != nil {
 		if rr, ok := bodyobj.(io.Reader); ok {
@@ -190,6 +213,9 @@ func (c *Client) Do(ctx context.Context, kind XRPCRequestType, inpenc string, me
 		req.Header.Set("Authorization", "Bearer "+c.Auth.AccessJwt)
 	}
 
+	// propagate the trace to the server, even if the http client isn't instrumented
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
 	resp, err := c.getClient().Do(req.WithContext(ctx))
 	if err != nil {
 		return fmt.Errorf("request failed: %w", err)